@@ -0,0 +1,145 @@
+// Package admission bounds how many requests of each operation class run
+// at once, queueing the excess for a while and shedding the rest, so that
+// bursts wait their turn instead of piling onto the metadata writer and the
+// engine lock.
+package admission
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded is returned when a request finds its class at its limit
+// and the queue full, or waits in the queue past the timeout
+var ErrOverloaded = errors.New("too many concurrent requests")
+
+// Class is a kind of operation limited independently of the others
+type Class string
+
+const (
+	ClassRead  Class = "read"  // GET and HEAD of objects
+	ClassWrite Class = "write" // PUT, POST, PATCH and DELETE
+	ClassList  Class = "list"  // Bucket and upload listings
+)
+
+// Limit bounds the requests of one class
+type Limit struct {
+	MaxConcurrent int           // Requests running at once; 0 leaves the class unlimited
+	MaxQueued     int           // Requests waiting for a slot; beyond this they are refused
+	QueueTimeout  time.Duration // How long a request waits for a slot; 0 waits as long as the client does
+}
+
+// Stats reports the requests of one class
+type Stats struct {
+	MaxConcurrent int   `json:"max_concurrent"`
+	MaxQueued     int   `json:"max_queued"`
+	InFlight      int   `json:"in_flight"`
+	Queued        int64 `json:"queued"`
+	Rejected      int64 `json:"rejected"` // Refused with ErrOverloaded
+}
+
+// semaphore admits up to a fixed number of holders, queueing a bounded
+// number of waiters
+type semaphore struct {
+	limit    Limit
+	slots    chan struct{}
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+func newSemaphore(limit Limit) *semaphore {
+	return &semaphore{
+		limit: limit,
+		slots: make(chan struct{}, limit.MaxConcurrent),
+	}
+}
+
+// acquire takes a slot, waiting in the queue if there is room
+func (s *semaphore) acquire(ctx context.Context) (func(), error) {
+	release := func() { <-s.slots }
+
+	select {
+	case s.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if s.queued.Add(1) > int64(s.limit.MaxQueued) {
+		s.queued.Add(-1)
+		s.rejected.Add(1)
+		return nil, ErrOverloaded
+	}
+	defer s.queued.Add(-1)
+
+	var timeout <-chan time.Time
+	if s.limit.QueueTimeout > 0 {
+		timer := time.NewTimer(s.limit.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		s.rejected.Add(1)
+		return nil, ErrOverloaded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *semaphore) stats() Stats {
+	return Stats{
+		MaxConcurrent: s.limit.MaxConcurrent,
+		MaxQueued:     s.limit.MaxQueued,
+		InFlight:      len(s.slots),
+		Queued:        s.queued.Load(),
+		Rejected:      s.rejected.Load(),
+	}
+}
+
+// Limiter admits requests by class
+type Limiter struct {
+	classes map[Class]*semaphore
+}
+
+// NewLimiter creates a limiter enforcing limits. Classes without a limit,
+// or with a MaxConcurrent of 0, are not limited.
+func NewLimiter(limits map[Class]Limit) *Limiter {
+	l := &Limiter{classes: make(map[Class]*semaphore)}
+	for class, limit := range limits {
+		if limit.MaxConcurrent > 0 {
+			l.classes[class] = newSemaphore(limit)
+		}
+	}
+	return l
+}
+
+// Acquire admits a request of class, waiting for a slot if the class is at
+// its limit. Call the returned func when the request is done. A nil
+// limiter admits everything.
+func (l *Limiter) Acquire(ctx context.Context, class Class) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	s, ok := l.classes[class]
+	if !ok {
+		return func() {}, nil
+	}
+	return s.acquire(ctx)
+}
+
+// Stats reports the limited classes
+func (l *Limiter) Stats() map[Class]Stats {
+	stats := make(map[Class]Stats)
+	if l == nil {
+		return stats
+	}
+	for class, s := range l.classes {
+		stats[class] = s.stats()
+	}
+	return stats
+}
@@ -0,0 +1,93 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Queue(t *testing.T) {
+	l := NewLimiter(map[Class]Limit{
+		ClassWrite: {MaxConcurrent: 1, MaxQueued: 1},
+	})
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx, ClassWrite)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// The second write queues for the slot
+	admitted := make(chan func())
+	go func() {
+		r, err := l.Acquire(ctx, ClassWrite)
+		if err != nil {
+			t.Errorf("queued Acquire() error = %v", err)
+		}
+		admitted <- r
+	}()
+	for deadline := time.Now().Add(time.Second); l.Stats()[ClassWrite].Queued != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("second write never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the queue full, a third is refused
+	if _, err := l.Acquire(ctx, ClassWrite); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Acquire() with a full queue error = %v, want ErrOverloaded", err)
+	}
+
+	// Other classes are not held up
+	if _, err := l.Acquire(ctx, ClassRead); err != nil {
+		t.Errorf("Acquire(read) error = %v", err)
+	}
+
+	release()
+	(<-admitted)()
+
+	stats := l.Stats()[ClassWrite]
+	if stats.InFlight != 0 || stats.Queued != 0 || stats.Rejected != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestLimiter_QueueTimeout(t *testing.T) {
+	l := NewLimiter(map[Class]Limit{
+		ClassList: {MaxConcurrent: 1, MaxQueued: 10, QueueTimeout: 5 * time.Millisecond},
+	})
+
+	release, err := l.Acquire(context.Background(), ClassList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := l.Acquire(context.Background(), ClassList); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Acquire() past the queue timeout error = %v, want ErrOverloaded", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Acquire(ctx, ClassList); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() of a cancelled request error = %v, want context.Canceled", err)
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	var nilLimiter *Limiter
+	if _, err := nilLimiter.Acquire(context.Background(), ClassWrite); err != nil {
+		t.Errorf("nil limiter Acquire() error = %v", err)
+	}
+
+	l := NewLimiter(map[Class]Limit{ClassRead: {MaxConcurrent: 0}})
+	for range 100 {
+		if _, err := l.Acquire(context.Background(), ClassRead); err != nil {
+			t.Fatalf("Acquire() of an unlimited class error = %v", err)
+		}
+	}
+	if len(l.Stats()) != 0 {
+		t.Errorf("Stats() = %v, want no limited classes", l.Stats())
+	}
+}
@@ -0,0 +1,54 @@
+package alerting
+
+import (
+	"fmt"
+	"time"
+)
+
+// Type identifies the condition an alert reports
+type Type string
+
+const (
+	TypeStorageUsage       Type = "storage_usage"
+	TypeReplicationBacklog Type = "replication_backlog"
+	TypeCorruption         Type = "corruption"
+	TypeDiskHealth         Type = "disk_health"
+	TypeFragmentation      Type = "storage_fragmentation"
+)
+
+// Severity of an alert
+type Severity string
+
+const (
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Alert is an administrative alert delivered to webhooks
+type Alert struct {
+	Type      Type                   `json:"type"`
+	Severity  Severity               `json:"severity"`
+	Key       string                 `json:"key,omitempty"` // Distinguishes alerts of one type for deduplication
+	Summary   string                 `json:"summary"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// dedupKey identifies repeats of the same alert
+func (a Alert) dedupKey() string {
+	return string(a.Type) + "/" + a.Key + "/" + string(a.Severity)
+}
+
+// payload is the webhook body. The top-level "text" field makes it
+// directly usable as a Slack incoming webhook message.
+type payload struct {
+	Text  string `json:"text"`
+	Alert Alert  `json:"alert"`
+}
+
+func newPayload(a Alert) payload {
+	return payload{
+		Text:  fmt.Sprintf("[comio] %s: %s", a.Severity, a.Summary),
+		Alert: a,
+	}
+}
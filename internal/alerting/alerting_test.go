@@ -0,0 +1,167 @@
+package alerting
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+// webhookServer records received payloads
+func webhookServer(t *testing.T) (*httptest.Server, chan payload) {
+	received := make(chan payload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		received <- p
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func waitPayload(t *testing.T, received chan payload) payload {
+	select {
+	case p := <-received:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+		return payload{}
+	}
+}
+
+func TestNotifier_DeliveryAndCooldown(t *testing.T) {
+	srv, received := webhookServer(t)
+
+	n := NewNotifier(Config{URLs: []string{srv.URL}, Cooldown: time.Hour})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+	n.Start()
+	defer n.Stop()
+
+	if !n.ReportCorruption("bucket", "key", errors.New("checksum mismatch")) {
+		t.Fatal("first alert should be sent")
+	}
+	p := waitPayload(t, received)
+	if p.Alert.Type != TypeCorruption || p.Text == "" {
+		t.Errorf("payload = %+v", p)
+	}
+
+	// Same alert within the cooldown is suppressed
+	if n.ReportCorruption("bucket", "key", errors.New("checksum mismatch")) {
+		t.Error("repeat within cooldown should be suppressed")
+	}
+	// A different key is a different alert
+	if !n.ReportCorruption("bucket", "other", errors.New("checksum mismatch")) {
+		t.Error("alert for another key should be sent")
+	}
+	waitPayload(t, received)
+
+	now = now.Add(2 * time.Hour)
+	if !n.ReportCorruption("bucket", "key", errors.New("checksum mismatch")) {
+		t.Error("repeat after cooldown should be sent")
+	}
+	waitPayload(t, received)
+}
+
+type statsEngine struct {
+	storage.Engine
+	stats storage.Stats
+}
+
+func (e *statsEngine) Stats() storage.Stats { return e.stats }
+
+func TestMonitor_Check(t *testing.T) {
+	srv, received := webhookServer(t)
+
+	n := NewNotifier(Config{URLs: []string{srv.URL}, Cooldown: time.Hour})
+	n.Start()
+	defer n.Stop()
+
+	engine := &statsEngine{stats: storage.Stats{TotalBytes: 100, UsedBytes: 50}}
+	m := NewMonitor(MonitorConfig{
+		StorageWarningPercent:     80,
+		StorageCriticalPercent:    95,
+		ReplicationQueueThreshold: 10,
+	}, n, engine)
+	depth := 0
+	m.SetReplicationQueue(func() int { return depth })
+
+	m.Check()
+	select {
+	case p := <-received:
+		t.Fatalf("unexpected alert %+v", p.Alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	engine.stats.UsedBytes = 85
+	m.Check()
+	if p := waitPayload(t, received); p.Alert.Type != TypeStorageUsage || p.Alert.Severity != SeverityWarning {
+		t.Errorf("alert = %+v, want storage warning", p.Alert)
+	}
+
+	// Escalation is a new alert despite the cooldown
+	engine.stats.UsedBytes = 97
+	depth = 10
+	m.Check()
+	got := map[Type]Severity{}
+	for i := 0; i < 2; i++ {
+		p := waitPayload(t, received)
+		got[p.Alert.Type] = p.Alert.Severity
+	}
+	if got[TypeStorageUsage] != SeverityCritical {
+		t.Errorf("storage alert severity = %s, want critical", got[TypeStorageUsage])
+	}
+	if _, ok := got[TypeReplicationBacklog]; !ok {
+		t.Error("expected replication backlog alert")
+	}
+}
+
+// fragmentedEngine reports a fixed fragmentation
+type fragmentedEngine struct {
+	statsEngine
+	report storage.FragmentationReport
+}
+
+func (e *fragmentedEngine) Fragmentation() storage.FragmentationReport { return e.report }
+
+func TestMonitor_Fragmentation(t *testing.T) {
+	srv, received := webhookServer(t)
+
+	n := NewNotifier(Config{URLs: []string{srv.URL}, Cooldown: time.Hour})
+	n.Start()
+	defer n.Stop()
+
+	engine := &fragmentedEngine{report: storage.FragmentationReport{AllocatedBytes: 100, ReclaimableBytes: 10, Fragmentation: 10}}
+	m := NewMonitor(MonitorConfig{
+		Fragmentation: storage.FragmentationThresholds{WarningPercent: 30, CriticalPercent: 50},
+	}, n, engine)
+
+	m.Check()
+	select {
+	case p := <-received:
+		t.Fatalf("unexpected alert %+v", p.Alert)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	engine.report.ReclaimableBytes, engine.report.Fragmentation = 60, 60
+	m.Check()
+	p := waitPayload(t, received)
+	if p.Alert.Type != TypeFragmentation || p.Alert.Severity != SeverityCritical {
+		t.Errorf("alert = %+v, want critical fragmentation", p.Alert)
+	}
+	if p.Alert.Details["reclaimable_bytes"] != float64(60) {
+		t.Errorf("details = %v", p.Alert.Details)
+	}
+}
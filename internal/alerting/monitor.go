@@ -0,0 +1,176 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/danielino/comio/internal/storage"
+)
+
+// MonitorConfig holds the thresholds checked by Monitor
+type MonitorConfig struct {
+	Interval                  time.Duration
+	StorageWarningPercent     float64 // 0 disables
+	StorageCriticalPercent    float64 // 0 disables
+	ReplicationQueueThreshold int     // 0 disables
+	Fragmentation             storage.FragmentationThresholds
+}
+
+// QueueDepthFunc reports the number of pending replication events
+type QueueDepthFunc func() int
+
+// Monitor periodically checks storage usage and fragmentation and the
+// replication backlog and raises alerts through a Notifier when thresholds are crossed.
+// Conditions that persist are re-sent once per notifier cooldown.
+type Monitor struct {
+	config     MonitorConfig
+	notifier   *Notifier
+	engine     storage.Engine
+	queueDepth QueueDepthFunc
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewMonitor creates a monitor for the given storage engine
+func NewMonitor(config MonitorConfig, notifier *Notifier, engine storage.Engine) *Monitor {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	return &Monitor{
+		config:   config,
+		notifier: notifier,
+		engine:   engine,
+	}
+}
+
+// SetReplicationQueue enables replication backlog checks
+func (m *Monitor) SetReplicationQueue(queueDepth QueueDepthFunc) {
+	m.queueDepth = queueDepth
+}
+
+// Start starts periodic checks
+func (m *Monitor) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops periodic checks
+func (m *Monitor) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Check evaluates every threshold once
+func (m *Monitor) Check() {
+	m.checkStorage()
+	m.checkFragmentation()
+	m.checkReplication()
+}
+
+func (m *Monitor) checkStorage() {
+	if m.engine == nil {
+		return
+	}
+
+	stats := m.engine.Stats()
+	if stats.TotalBytes <= 0 {
+		return
+	}
+	percent := float64(stats.UsedBytes) * 100 / float64(stats.TotalBytes)
+
+	var severity Severity
+	var threshold float64
+	switch {
+	case m.config.StorageCriticalPercent > 0 && percent >= m.config.StorageCriticalPercent:
+		severity, threshold = SeverityCritical, m.config.StorageCriticalPercent
+	case m.config.StorageWarningPercent > 0 && percent >= m.config.StorageWarningPercent:
+		severity, threshold = SeverityWarning, m.config.StorageWarningPercent
+	default:
+		return
+	}
+
+	m.notifier.Notify(Alert{
+		Type:     TypeStorageUsage,
+		Severity: severity,
+		Summary:  fmt.Sprintf("storage usage %.1f%% exceeds %.0f%%", percent, threshold),
+		Details: map[string]interface{}{
+			"used_bytes":  stats.UsedBytes,
+			"total_bytes": stats.TotalBytes,
+			"percent":     percent,
+		},
+	})
+}
+
+func (m *Monitor) checkFragmentation() {
+	reporter, ok := m.engine.(storage.FragmentationReporter)
+	if !ok {
+		return
+	}
+
+	report := reporter.Fragmentation()
+	var severity Severity
+	var threshold float64
+	switch m.config.Fragmentation.Level(report.Fragmentation) {
+	case storage.FragmentationCritical:
+		severity, threshold = SeverityCritical, m.config.Fragmentation.CriticalPercent
+	case storage.FragmentationWarning:
+		severity, threshold = SeverityWarning, m.config.Fragmentation.WarningPercent
+	default:
+		return
+	}
+
+	m.notifier.Notify(Alert{
+		Type:     TypeFragmentation,
+		Severity: severity,
+		Summary:  fmt.Sprintf("compaction would reclaim %.1f%% of allocated storage, above %.0f%%", report.Fragmentation, threshold),
+		Details: map[string]interface{}{
+			"reclaimable_bytes": report.ReclaimableBytes,
+			"wasted_bytes":      report.WastedBytes,
+			"allocated_bytes":   report.AllocatedBytes,
+			"percent":           report.Fragmentation,
+		},
+	})
+}
+
+func (m *Monitor) checkReplication() {
+	if m.queueDepth == nil || m.config.ReplicationQueueThreshold <= 0 {
+		return
+	}
+
+	depth := m.queueDepth()
+	if depth < m.config.ReplicationQueueThreshold {
+		return
+	}
+
+	m.notifier.Notify(Alert{
+		Type:     TypeReplicationBacklog,
+		Severity: SeverityWarning,
+		Summary:  fmt.Sprintf("replication queue has %d pending events (threshold %d)", depth, m.config.ReplicationQueueThreshold),
+		Details: map[string]interface{}{
+			"queue_depth": depth,
+			"threshold":   m.config.ReplicationQueueThreshold,
+		},
+	})
+}
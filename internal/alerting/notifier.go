@@ -0,0 +1,181 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// Config holds webhook delivery settings
+type Config struct {
+	URLs     []string
+	Cooldown time.Duration // Minimum time between repeats of the same alert
+	Timeout  time.Duration // Per-request timeout
+}
+
+// DefaultConfig returns default notifier settings
+func DefaultConfig() Config {
+	return Config{
+		Cooldown: 15 * time.Minute,
+		Timeout:  10 * time.Second,
+	}
+}
+
+// Notifier delivers alerts to webhook URLs. Repeats of an alert (same
+// type, key and severity) within the cooldown are suppressed, and delivery
+// happens on a background worker so callers never block on webhooks.
+type Notifier struct {
+	config   Config
+	client   *http.Client
+	queue    chan Alert
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	now      func() time.Time
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewNotifier creates a webhook notifier
+func NewNotifier(config Config) *Notifier {
+	defaults := DefaultConfig()
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaults.Cooldown
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+		},
+		queue:    make(chan Alert, 100),
+		lastSent: make(map[string]time.Time),
+		now:      time.Now,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start starts the delivery worker
+func (n *Notifier) Start() {
+	n.wg.Add(1)
+	go n.worker()
+
+	monitoring.Log.Info("Alert notifier started", zap.Int("webhooks", len(n.config.URLs)))
+}
+
+// Stop stops the delivery worker. Alerts still queued are dropped.
+func (n *Notifier) Stop() {
+	n.cancel()
+	n.wg.Wait()
+	monitoring.Log.Info("Alert notifier stopped")
+}
+
+// Notify queues an alert for delivery. It returns false if the alert was
+// suppressed by the cooldown or the queue is full.
+func (n *Notifier) Notify(alert Alert) bool {
+	if alert.Timestamp.IsZero() {
+		alert.Timestamp = n.now()
+	}
+
+	key := alert.dedupKey()
+
+	n.mu.Lock()
+	if last, ok := n.lastSent[key]; ok && alert.Timestamp.Sub(last) < n.config.Cooldown {
+		n.mu.Unlock()
+		return false
+	}
+	n.lastSent[key] = alert.Timestamp
+	n.mu.Unlock()
+
+	select {
+	case n.queue <- alert:
+		return true
+	default:
+		monitoring.Log.Warn("Alert queue full, dropping alert",
+			zap.String("type", string(alert.Type)),
+			zap.String("summary", alert.Summary))
+		return false
+	}
+}
+
+// ReportCorruption raises a critical alert for an object whose data failed
+// verification, e.g. during scrubbing
+func (n *Notifier) ReportCorruption(bucket, key string, err error) bool {
+	return n.Notify(Alert{
+		Type:     TypeCorruption,
+		Severity: SeverityCritical,
+		Key:      bucket + "/" + key,
+		Summary:  fmt.Sprintf("corruption detected in %s/%s", bucket, key),
+		Details: map[string]interface{}{
+			"bucket": bucket,
+			"key":    key,
+			"error":  err.Error(),
+		},
+	})
+}
+
+func (n *Notifier) worker() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case alert := <-n.queue:
+			n.deliver(alert)
+		}
+	}
+}
+
+// deliver posts an alert to every webhook, logging failures
+func (n *Notifier) deliver(alert Alert) {
+	body, err := json.Marshal(newPayload(alert))
+	if err != nil {
+		monitoring.Log.Error("Failed to marshal alert", zap.Error(err))
+		return
+	}
+
+	for _, url := range n.config.URLs {
+		if err := n.post(url, body); err != nil {
+			monitoring.Log.Error("Failed to deliver alert",
+				zap.String("url", url),
+				zap.String("type", string(alert.Type)),
+				zap.Error(err))
+		}
+	}
+}
+
+func (n *Notifier) post(url string, body []byte) error {
+	req, err := http.NewRequestWithContext(n.ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
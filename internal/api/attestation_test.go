@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/attestation"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func TestObjectAttestation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Attestation, err = attestation.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve("PUT", "/audit", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT /audit = %d: %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/audit/records/2024.csv", "id,amount\n1,100\n"); w.Code != http.StatusOK {
+		t.Fatalf("PUT object = %d: %s", w.Code, w.Body)
+	}
+
+	// Auditors pin the server's public key
+	w := serve("GET", "/admin/v1/attestation/key", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET attestation key = %d: %s", w.Code, w.Body)
+	}
+	var key struct {
+		KeyID     string `json:"key_id"`
+		PublicKey string `json:"public_key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &key)
+	public, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve("GET", "/audit/records/2024.csv?attestation", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET ?attestation = %d: %s", w.Code, w.Body)
+	}
+	var a attestation.Attestation
+	if err := json.Unmarshal(w.Body.Bytes(), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.KeyID != key.KeyID {
+		t.Errorf("attestation key ID = %q, want %q", a.KeyID, key.KeyID)
+	}
+	statement, err := attestation.Verify(public, &a)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if statement.Bucket != "audit" || statement.Key != "records/2024.csv" || statement.Size != 16 {
+		t.Errorf("statement = %+v", statement)
+	}
+	if statement.Retention.Mode != attestation.RetentionNone {
+		t.Errorf("retention mode = %q, want %q", statement.Retention.Mode, attestation.RetentionNone)
+	}
+
+	if w := serve("GET", "/audit/missing.csv?attestation", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET ?attestation of a missing object = %d, want 404", w.Code)
+	}
+}
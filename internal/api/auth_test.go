@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+// signRequest signs a request with an access key the way the HMAC
+// authenticator verifies it
+func signRequest(req *http.Request, accessKey, secretKey string) {
+	const payload = "UNSIGNED-PAYLOAD"
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(payload))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+
+		"/20990101/us-east-1/s3/aws4_request, SignedHeaders=x-amz-content-sha256, Signature="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestAuthentication(t *testing.T) {
+	cfg := &config.Config{
+		Auth:    config.AuthConfig{Enabled: true},
+		Cluster: config.ClusterConfig{Token: "cluster-token"},
+	}
+	engine := openTestEngine(t)
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Users = auth.NewMemoryUserStore()
+	container.Authenticator = auth.NewHMACAuthenticator()
+	container.Authenticator.AddUser(auth.NewAdminUser("admin", "admin-secret"))
+	container.Authenticator.AddUser(&auth.User{AccessKeyID: "bob", SecretAccessKey: "bob-secret", Username: "bob", Policies: []string{"readwrite"}})
+	container.Authenticator.SetUserStore(container.Users)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	if err := container.BucketService.CreateBucket(context.Background(), "photos", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, target, body string, sign func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if sign != nil {
+			sign(req)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	as := func(accessKey, secretKey string) func(*http.Request) {
+		return func(req *http.Request) { signRequest(req, accessKey, secretKey) }
+	}
+	admin := as("admin", "admin-secret")
+	bob := as("bob", "bob-secret")
+
+	if w := serve("PUT", "/photos/app1/cat.jpg", "cat", admin); w.Code != http.StatusOK {
+		t.Fatalf("PUT as admin = %d %s", w.Code, w.Body)
+	}
+
+	w := serve("POST", "/admin/v1/service-accounts", `{"bucket":"photos","prefix":"app1/","actions":["read","list"]}`, admin)
+	var account struct {
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &account); w.Code != http.StatusCreated || err != nil {
+		t.Fatalf("POST service account = %d %s", w.Code, w.Body)
+	}
+	scoped := as(account.AccessKeyID, account.SecretAccessKey)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		sign   func(*http.Request)
+		want   int
+	}{
+		{"unsigned object read", "GET", "/photos/app1/cat.jpg", nil, http.StatusUnauthorized},
+		{"bad signature", "GET", "/photos/app1/cat.jpg", as("admin", "wrong"), http.StatusUnauthorized},
+		{"unknown access key", "GET", "/photos/app1/cat.jpg", as("nobody", "admin-secret"), http.StatusUnauthorized},
+		{"unsigned admin API", "GET", "/admin/v1/service-accounts", nil, http.StatusUnauthorized},
+		{"admin reads", "GET", "/photos/app1/cat.jpg", admin, http.StatusOK},
+		{"scoped read inside scope", "GET", "/photos/app1/cat.jpg", scoped, http.StatusOK},
+		{"scoped listing inside scope", "GET", "/photos?prefix=app1/", scoped, http.StatusOK},
+		{"scoped write", "PUT", "/photos/app1/dog.jpg", scoped, http.StatusForbidden},
+		{"scoped read outside scope", "GET", "/photos/app2/cat.jpg", scoped, http.StatusForbidden},
+		{"scoped bucket listing", "GET", "/", scoped, http.StatusForbidden},
+		{"scoped admin API", "GET", "/admin/v1/service-accounts", scoped, http.StatusForbidden},
+		{"user reads", "GET", "/photos/app1/cat.jpg", bob, http.StatusOK},
+		{"user applies a spec", "POST", "/admin/v1/apply", bob, http.StatusForbidden},
+		{"user purges a bucket", "DELETE", "/admin/v1/buckets/photos/objects", bob, http.StatusForbidden},
+		{"user changes the runtime", "PUT", "/admin/v1/runtime", bob, http.StatusForbidden},
+		{"user lists own service accounts", "GET", "/admin/v1/service-accounts", bob, http.StatusOK},
+		{"cluster token", "GET", "/photos/app1/cat.jpg", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer cluster-token")
+		}, http.StatusOK},
+		{"wrong cluster token", "GET", "/photos/app1/cat.jpg", func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer guess")
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(tt.method, tt.target, "", tt.sign); w.Code != tt.want {
+				t.Errorf("%s %s = %d %s, want %d", tt.method, tt.target, w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
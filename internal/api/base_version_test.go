@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/api/handlers"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestPutObjectOnBaseVersion(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	put := func(body []byte, base string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/tables/table.dat", bytes.NewReader(body))
+		if base != "" {
+			req.Header.Set(handlers.HeaderBaseVersion, base)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	server.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/tables", nil))
+	data := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	w := put(data, "")
+	var base object.Object
+	if err := json.Unmarshal(w.Body.Bytes(), &base); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+
+	edited := bytes.Clone(data)
+	copy(edited[100:], "edited")
+	w = put(edited, base.VersionID)
+	var written object.Object
+	if err := json.Unmarshal(w.Body.Bytes(), &written); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PUT on base = %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "extents") {
+		t.Errorf("PUT on base = %s, want the extents kept internal", w.Body)
+	}
+	obj, err := container.ObjectRepo.Head(context.Background(), "tables", "table.dat", &written.VersionID)
+	if err != nil || !obj.Shared || len(obj.Extents) < 2 {
+		t.Errorf("version written on the base = %+v, %v, want it to share the unchanged data", obj, err)
+	}
+
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/tables/table.dat", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), edited) {
+		t.Errorf("GET = %d, want the edited data", w.Code)
+	}
+
+	if w := put(edited, "missing"); w.Code != http.StatusNotFound {
+		t.Errorf("PUT on an unknown base = %d %s, want 404", w.Code, w.Body)
+	}
+}
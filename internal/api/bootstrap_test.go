@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// memoryTarget is a bootstrap target keeping objects in memory
+type memoryTarget struct {
+	buckets map[string]string // name -> settings
+	objects map[string]string
+}
+
+func (t *memoryTarget) ApplyBucket(ctx context.Context, b replication.BootstrapBucket) error {
+	t.buckets[b.Name] = string(b.Settings)
+	return nil
+}
+
+func (t *memoryTarget) ObjectETag(ctx context.Context, bucket, key string) (string, bool) {
+	return "", false
+}
+
+func (t *memoryTarget) PutObject(ctx context.Context, bucket string, entry replication.ManifestEntry, data io.Reader) error {
+	b, err := io.ReadAll(data)
+	t.objects[bucket+"/"+entry.Key] = string(b)
+	return err
+}
+
+func TestBootstrapSnapshots(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+	srv := httptest.NewServer(server.router)
+	defer srv.Close()
+
+	ctx := context.Background()
+	for _, name := range []string{"photos", "scratch"} {
+		if err := container.BucketService.CreateBucket(ctx, name, "owner"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := container.BucketService.SetReplication(ctx, "scratch", false); err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string]string{"a.jpg": "first", "nested/b c.jpg": "second", "z.jpg": "third"}
+	for key, data := range objects {
+		if _, err := container.ObjectService.PutObject(ctx, "photos", key, bytes.NewReader([]byte(data)), int64(len(data)), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := &memoryTarget{buckets: map[string]string{}, objects: map[string]string{}}
+	bootstrapper := replication.NewBootstrapper(replication.BootstrapConfig{
+		SourceURL:      srv.URL,
+		PageSize:       2,
+		CheckpointPath: filepath.Join(t.TempDir(), "bootstrap.json"),
+	}, target)
+	if err := bootstrapper.Run(ctx, nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// Buckets with replication disabled are left out
+	if len(target.buckets) != 1 || !strings.Contains(target.buckets["photos"], `"name":"photos"`) {
+		t.Errorf("buckets = %v, want photos only", target.buckets)
+	}
+	for key, data := range objects {
+		if got := target.objects["photos/"+key]; got != data {
+			t.Errorf("photos/%s = %q, want %q", key, got, data)
+		}
+	}
+
+	// The snapshot was released once copied
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("POST", "/admin/v1/replication/snapshots", nil))
+	var snap replication.BootstrapSnapshot
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &snap) != nil {
+		t.Fatalf("POST snapshots = %d %s", w.Code, w.Body.String())
+	}
+	for _, path := range []string{"/" + snap.ID, "/" + snap.ID + "/buckets/photos/data/a.jpg"} {
+		w = httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/v1/replication/snapshots"+path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/v1/replication/snapshots/"+snap.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("DELETE snapshot = %d, want 204", w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/v1/replication/snapshots/"+snap.ID, nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NoSuchSnapshot") {
+		t.Errorf("GET released snapshot = %d %s, want 404 NoSuchSnapshot", w.Code, w.Body.String())
+	}
+}
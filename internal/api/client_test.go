@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/pkg/client"
+)
+
+// TestClient_AgainstServer checks that the Go client and the object API
+// agree on range, checksum and metadata headers
+func TestClient_AgainstServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 64*1024*1024, 4*1024*1024)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+	srv := httptest.NewServer(server.router)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("PUT", srv.URL+"/photos", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("creating bucket: %v %v", err, resp)
+	}
+	resp.Body.Close()
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	c, err := client.New(client.Config{
+		Endpoint:         srv.URL,
+		DownloadPartSize: 4096,
+		Encryption:       &client.EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": key}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	data := make([]byte, 100000)
+	rand.Read(data)
+	if _, err := c.PutObject(ctx, "photos", "cat.jpg", bytes.NewReader(data), int64(len(data)),
+		&client.PutOptions{Metadata: map[string]string{"camera": "x100"}}); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	body, info, err := c.GetObject(ctx, "photos", "cat.jpg", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading object: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded object does not match")
+	}
+	if info.Metadata["camera"] != "x100" {
+		t.Errorf("Metadata = %v, want camera=x100", info.Metadata)
+	}
+
+	head, err := c.HeadObject(ctx, "photos", "cat.jpg")
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if head.Size != int64(len(data)) || head.VersionID == "" {
+		t.Errorf("HeadObject = %+v", head)
+	}
+
+	plain, _ := client.New(client.Config{Endpoint: srv.URL})
+	if _, err := plain.PutObject(ctx, "photos", "notes.txt", bytes.NewReader([]byte("0123456789")), 10, nil); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	part, err := plain.GetObjectRange(ctx, "photos", "notes.txt", 2, 3)
+	if err != nil || string(part) != "234" {
+		t.Errorf("GetObjectRange = %q, %v; want 234", part, err)
+	}
+	if _, err := c.GetObjectRange(ctx, "photos", "cat.jpg", 0, 10); !errors.Is(err, client.ErrEncryptedRange) {
+		t.Errorf("GetObjectRange of encrypted object error = %v, want ErrEncryptedRange", err)
+	}
+
+	list, err := c.ListObjects(ctx, "photos", client.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListObjects failed: %v", err)
+	}
+	if len(list.Objects) != 2 || list.Objects[0].Key != "cat.jpg" || list.Objects[1].Key != "notes.txt" {
+		t.Fatalf("ListObjects = %+v", list.Objects)
+	}
+	// Listed with its plaintext size and without the envelope metadata
+	if cat := list.Objects[0]; cat.Size != int64(len(data)) || cat.Metadata["camera"] != "x100" || len(cat.Metadata) != 1 {
+		t.Errorf("listed encrypted object = %+v", cat)
+	}
+}
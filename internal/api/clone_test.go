@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestCloneBucket(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	ctx := context.Background()
+	if err := container.BucketService.CreateBucket(ctx, "production", "team"); err != nil {
+		t.Fatal(err)
+	}
+	prod, _ := container.BucketService.GetBucket(ctx, "production")
+	prod.Versioning = bucket.VersioningEnabled
+	container.BucketService.UpdateBucket(ctx, prod)
+	for _, key := range []string{"data/a.csv", "data/b.csv"} {
+		if _, err := container.ObjectService.PutObject(ctx, "production", key, bytes.NewReader([]byte("1,2,3")), 5, "text/csv"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clone := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w
+	}
+
+	w := clone("/admin/v1/buckets/production/clone?target=staging")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("clone = %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Objects int   `json:"objects"`
+		Bytes   int64 `json:"bytes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Objects != 2 || resp.Bytes != 10 {
+		t.Errorf("clone response = %s, want 2 objects of 10 bytes", w.Body.String())
+	}
+	staging, err := container.BucketService.GetBucket(ctx, "staging")
+	if err != nil || staging.Owner != "team" || staging.Versioning != bucket.VersioningEnabled {
+		t.Errorf("clone bucket = %+v, %v; want the owner and versioning of the source", staging, err)
+	}
+	if _, err := container.ObjectService.HeadObject(ctx, "staging", "data/b.csv", nil); err != nil {
+		t.Errorf("cloned object: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/admin/v1/buckets/production/clone?target=staging", http.StatusConflict},
+		{"/admin/v1/buckets/production/clone", http.StatusBadRequest},
+		{"/admin/v1/buckets/missing/clone?target=other", http.StatusNotFound},
+		{"/admin/production/clone?target=Invalid_Name", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := clone(tt.path); w.Code != tt.status {
+			t.Errorf("POST %s = %d %s, want %d", tt.path, w.Code, w.Body.String(), tt.status)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/admission"
+	"github.com/danielino/comio/internal/config"
+)
+
+func TestConcurrencyLimits(t *testing.T) {
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Admission = admission.NewLimiter(map[admission.Class]admission.Limit{
+		admission.ClassWrite: {MaxConcurrent: 1},
+		admission.ClassList:  {MaxConcurrent: 1},
+	})
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader("")))
+		return w
+	}
+	if w := do("PUT", "/photos"); w.Code != http.StatusOK {
+		t.Fatalf("create bucket = %d %s", w.Code, w.Body.String())
+	}
+
+	// Hold the only write slot, as a long running PUT would
+	release, err := container.Admission.Acquire(context.Background(), admission.ClassWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := do("PUT", "/photos/cat.jpg")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "SlowDown") || w.Header().Get("Retry-After") == "" {
+		t.Errorf("PUT at the write limit = %d %s, Retry-After %q", w.Code, w.Body.String(), w.Header().Get("Retry-After"))
+	}
+	// Reads and listings have their own limits
+	if w := do("GET", "/photos"); w.Code != http.StatusOK {
+		t.Errorf("list at the write limit = %d, want 200", w.Code)
+	}
+	if w := do("HEAD", "/photos/cat.jpg"); w.Code == http.StatusServiceUnavailable {
+		t.Errorf("HEAD at the write limit = %d", w.Code)
+	}
+	release()
+
+	if w := do("PUT", "/photos/cat.jpg"); w.Code != http.StatusOK {
+		t.Errorf("PUT after the slot is freed = %d %s", w.Code, w.Body.String())
+	}
+
+	release, err = container.Admission.Acquire(context.Background(), admission.ClassList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	for _, path := range []string{"/", "/photos", "/photos/"} {
+		if w := do("GET", path); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s at the list limit = %d, want 503", path, w.Code)
+		}
+	}
+	if w := do("GET", "/photos/cat.jpg"); w.Code == http.StatusServiceUnavailable {
+		t.Errorf("object GET at the list limit = %d", w.Code)
+	}
+
+	stats := container.Admission.Stats()
+	if stats[admission.ClassWrite].Rejected != 1 || stats[admission.ClassList].Rejected != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -1,13 +1,44 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/danielino/comio/internal/admission"
+	"github.com/danielino/comio/internal/alerting"
+	"github.com/danielino/comio/internal/attestation"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/backup"
+	"github.com/danielino/comio/internal/billing"
 	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/cluster"
 	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/diskhealth"
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/export"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/inventory"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/lifecycle"
+	"github.com/danielino/comio/internal/metafile"
 	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/nfs"
+	"github.com/danielino/comio/internal/notification"
 	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/preview"
+	"github.com/danielino/comio/internal/raft"
+	"github.com/danielino/comio/internal/recovery"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/internal/scheduler"
 	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/internal/usage"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -23,9 +54,85 @@ type ServiceContainer struct {
 	BucketRepo bucket.Repository
 	ObjectRepo object.Repository
 
+	// DB holds the metadata when database.enabled is set, else nil
+	DB *database.DB
+
+	// Corrupt metadata files moved aside by the file repositories, nil
+	// with the database
+	Quarantine *metafile.Quarantine
+
 	// Services
 	BucketService *bucket.Service
 	ObjectService *object.Service
+	Multipart     *multipart.Service
+
+	// Master key versions for server-side encryption, nil unless enabled
+	Keys       *encryption.Keyring
+	KeyManager *encryption.KeyManager // Refreshes Keys from a KMS, nil with keys in the configuration
+
+	// Signs object attestations for auditors, and export manifests
+	Attestation *attestation.Signer
+
+	// Archive exports of buckets with signed manifests
+	Exporter *export.Exporter
+
+	// Object event notifications
+	Notifications *notification.Bus
+
+	// Credentials: configured admin user plus persisted users and service accounts
+	Users         auth.UserStore
+	Authenticator *auth.HMACAuthenticator
+
+	// Replicator is nil unless replication is configured
+	Replicator *replication.Replicator
+
+	// Replica tracks applied writes when serving as a read replica, else nil
+	Replica *replication.ReplicaState
+
+	// Raft replicates metadata mutations when cluster.raft is enabled, else nil
+	Raft *raft.Node
+
+	// Ring of nodes objects are placed on, nil unless cluster nodes are configured
+	Ring           *cluster.Ring
+	Decommissioner *cluster.Decommissioner
+	Rebalancer     *cluster.Rebalancer // nil with raft, where metadata is shared
+
+	// Background jobs
+	Jobs      *jobs.Manager
+	Scheduler *scheduler.Scheduler
+
+	// Administrative alerts
+	Alerts       *alerting.Notifier
+	AlertMonitor *alerting.Monitor
+
+	// SMART checks of the storage devices, nil unless enabled
+	DiskHealth *diskhealth.Checker
+
+	// Periodic samples of the storage stats, nil unless enabled
+	StatsHistory  *storage.StatsHistory
+	StatsRecorder *storage.StatsRecorder
+
+	// Pushes metrics to remote write or statsd, nil unless enabled
+	MetricsPusher *monitoring.Pusher
+
+	// Hourly requests and traffic per access key, nil unless enabled
+	Usage *usage.Tracker
+
+	// Monthly chargeback reports built from Usage, nil unless enabled
+	Billing *billing.Reporter
+
+	// NFS exports of bucket snapshots, nil unless enabled. Serving is
+	// started by the server.
+	NFS *nfs.Server
+
+	// Progress of the startup recovery run in the background, nil if the
+	// engine needs none
+	Recovery     *recovery.Tracker
+	stopRecovery context.CancelFunc
+
+	// Bounds on concurrent S3 requests per operation class, nil leaves
+	// them unlimited
+	Admission *admission.Limiter
 }
 
 // NewServiceContainer creates and wires up all application dependencies
@@ -46,11 +153,142 @@ func NewServiceContainer(cfg *config.Config) (*ServiceContainer, error) {
 	}
 
 	// Initialize services
-	container.initServices()
+	if err := container.initServices(); err != nil {
+		return nil, fmt.Errorf("failed to initialize services: %w", err)
+	}
+
+	// Restore the engine's allocations while the server starts
+	container.startRecovery()
+
+	if err := container.initAttestation(); err != nil {
+		return nil, fmt.Errorf("failed to initialize attestation: %w", err)
+	}
+	container.Exporter = export.NewExporter(container.ObjectService, container.Attestation, cfg.Export.Dir)
+
+	// Initialize object event notifications and their subscribers
+	if err := container.initNotifications(); err != nil {
+		return nil, fmt.Errorf("failed to initialize notifications: %w", err)
+	}
+
+	// Initialize credentials
+	if err := container.initAuth(); err != nil {
+		return nil, fmt.Errorf("failed to initialize auth: %w", err)
+	}
+
+	// Initialize background job manager
+	if err := container.initJobs(); err != nil {
+		return nil, fmt.Errorf("failed to initialize jobs: %w", err)
+	}
+
+	// Initialize usage tracking, which the billing report task reads
+	if err := container.initUsage(); err != nil {
+		return nil, fmt.Errorf("failed to initialize usage tracking: %w", err)
+	}
+
+	// Initialize scheduled tasks
+	if err := container.initScheduler(); err != nil {
+		return nil, fmt.Errorf("failed to initialize scheduler: %w", err)
+	}
+
+	// Initialize webhook alerts
+	container.initAlerting()
+	container.initWatermarks()
+	container.initDiskHealth()
+	if err := container.initStatsHistory(); err != nil {
+		return nil, fmt.Errorf("failed to initialize metrics history: %w", err)
+	}
+	container.initReplicationMetrics()
+	if err := container.initMetricsPush(); err != nil {
+		return nil, fmt.Errorf("failed to initialize metrics push: %w", err)
+	}
+	container.initAdmission()
+
+	if cfg.NFS.Enabled {
+		container.NFS = nfs.NewServer(container.ObjectService)
+	}
 
 	return container, nil
 }
 
+// startRecovery restores the engine's allocations from the metadata in
+// the background. Until it completes, data routes answer 503 and the
+// engine refuses new space, which could land on data stored before the
+// restart. Should it fail, both stay so rather than risk overwriting data.
+func (c *ServiceContainer) startRecovery() {
+	restorer, ok := c.Engine.(storage.Restorer)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tracker := recovery.NewTracker(recovery.PhaseMultipartUploads, recovery.PhaseAllocations)
+	c.Recovery = tracker
+	c.stopRecovery = cancel
+	restorer.BeginRestore()
+	monitoring.Log.Info("Restoring storage allocations")
+
+	go func() {
+		defer cancel()
+		if err := c.restoreAllocations(ctx, tracker, restorer); err != nil {
+			monitoring.Log.Error("Startup recovery failed, data routes stay unavailable", zap.Error(err))
+			return
+		}
+		restorer.EndRestore()
+		tracker.Finish()
+		status := tracker.Status()
+		monitoring.Log.Info("Startup recovery complete",
+			zap.Duration("duration", status.FinishedAt.Sub(status.StartedAt)))
+	}()
+}
+
+// restoreAllocations marks the space of in-progress multipart uploads and
+// of every stored object version as allocated
+func (c *ServiceContainer) restoreAllocations(ctx context.Context, tracker *recovery.Tracker, restorer storage.Restorer) error {
+	// Extents clashing with ones already marked point at corrupt metadata;
+	// they are logged and left to the scrubber
+	mark := func(offset, size int64) error {
+		if err := restorer.MarkAllocated(offset, size); err != nil {
+			monitoring.Log.Warn("Skipping stored extent", zap.Error(err))
+		}
+		tracker.Advance(1)
+		return nil
+	}
+
+	tracker.Begin(recovery.PhaseMultipartUploads, 0)
+	if c.Multipart != nil {
+		if err := c.Multipart.StoredExtents(mark); err != nil {
+			tracker.End(err)
+			return err
+		}
+	}
+	tracker.End(nil)
+
+	buckets, err := c.BucketService.ListBuckets(ctx, "")
+	if err != nil {
+		err = fmt.Errorf("failed to list buckets: %w", err)
+		tracker.Begin(recovery.PhaseAllocations, 0)
+		tracker.End(err)
+		return err
+	}
+	var total int64
+	for _, b := range buckets {
+		if count, _, err := c.ObjectService.CountObjects(ctx, b.Name); err == nil {
+			total += int64(count)
+		}
+	}
+
+	tracker.Begin(recovery.PhaseAllocations, total)
+	for _, b := range buckets {
+		if err := c.ObjectService.StoredExtents(ctx, b.Name, mark); err != nil {
+			err = fmt.Errorf("failed to restore allocations of bucket %s: %w", b.Name, err)
+			tracker.End(err)
+			return err
+		}
+	}
+	tracker.End(nil)
+	return nil
+}
+
 // initStorage initializes the storage engine
 func (c *ServiceContainer) initStorage() error {
 	// Use storage config from config file, or fall back to defaults
@@ -72,6 +310,20 @@ func (c *ServiceContainer) initStorage() error {
 	if err != nil {
 		return fmt.Errorf("failed to create storage engine: %w", err)
 	}
+	engine.SetErrorThreshold(c.Config.Storage.ErrorThreshold)
+	engine.SetSealing(c.Config.Storage.Sealing.Enabled)
+	if wc := c.Config.Storage.WriteCoalescing; wc.Enabled {
+		window, err := time.ParseDuration(wc.Window)
+		if err != nil {
+			monitoring.Log.Warn("Write coalescing disabled: invalid window", zap.String("window", wc.Window))
+		} else {
+			engine.SetWriteCoalescing(storage.Coalescing{
+				Window:   window,
+				MaxBytes: wc.MaxBytes,
+				MaxWrite: wc.MaxWriteSize,
+			})
+		}
+	}
 
 	// Open the storage device
 	if err := engine.Open(storagePath); err != nil {
@@ -89,16 +341,29 @@ func (c *ServiceContainer) initStorage() error {
 }
 
 // initRepositories initializes the bucket and object repositories
-// Using file-based storage like MinIO (no external database)
+// Using file-based storage like MinIO (no external database) unless
+// SQLite is enabled
 func (c *ServiceContainer) initRepositories() error {
+	if c.Config.Database.Enabled {
+		if err := c.initDatabase(); err != nil {
+			return fmt.Errorf("failed to open metadata database: %w", err)
+		}
+		return c.initRaftIfEnabled()
+	}
+
 	// Metadata directory
 	metadataPath := "metadata"
+	durability, err := metafile.ParseDurability(c.Config.Storage.MetadataDurability)
+	if err != nil {
+		return fmt.Errorf("invalid storage.metadata_durability: %w", err)
+	}
 
 	// Initialize file-based bucket repository
 	bucketRepo, err := bucket.NewFileRepository(metadataPath)
 	if err != nil {
 		return fmt.Errorf("failed to create bucket repository: %w", err)
 	}
+	bucketRepo.SetDurability(durability)
 	c.BucketRepo = bucketRepo
 
 	// Initialize file-based object repository
@@ -106,7 +371,13 @@ func (c *ServiceContainer) initRepositories() error {
 	if err != nil {
 		return fmt.Errorf("failed to create object repository: %w", err)
 	}
+	objectRepo.SetDurability(durability)
 	c.ObjectRepo = objectRepo
+	c.Quarantine = metafile.NewQuarantine(metadataPath)
+
+	if err := c.initRaftIfEnabled(); err != nil {
+		return err
+	}
 
 	monitoring.Log.Info("Repositories initialized",
 		zap.String("type", "file-based"),
@@ -116,15 +387,1083 @@ func (c *ServiceContainer) initRepositories() error {
 	return nil
 }
 
+// initDatabase opens the SQLite database holding bucket and object metadata
+func (c *ServiceContainer) initDatabase() error {
+	cfg := c.Config.Database
+	dbCfg := database.Config{
+		Path:             cfg.Path,
+		MaxOpenConns:     cfg.MaxOpenConns,
+		MaxIdleConns:     cfg.MaxIdleConns,
+		MaxPendingWrites: cfg.MaxPendingWrites,
+	}
+	if cfg.ConnMaxLifetime != "" && cfg.ConnMaxLifetime != "0" {
+		d, err := time.ParseDuration(cfg.ConnMaxLifetime)
+		if err != nil {
+			return fmt.Errorf("invalid database.conn_max_lifetime: %w", err)
+		}
+		dbCfg.ConnMaxLifetime = d
+	}
+
+	db, err := database.Open(dbCfg)
+	if err != nil {
+		return err
+	}
+	c.DB = db
+	c.BucketRepo = bucket.NewSQLiteRepository(db)
+	c.ObjectRepo = object.NewSQLiteRepository(db)
+
+	monitoring.Log.Info("Repositories initialized",
+		zap.String("type", "sqlite"),
+		zap.String("path", cfg.Path),
+		zap.Int("max_pending_writes", cfg.MaxPendingWrites))
+	return nil
+}
+
+// initRaftIfEnabled replicates the repositories when cluster.raft is enabled
+func (c *ServiceContainer) initRaftIfEnabled() error {
+	if !c.Config.Cluster.Raft.Enabled {
+		return nil
+	}
+	if err := c.initRaft(); err != nil {
+		return fmt.Errorf("failed to initialize raft: %w", err)
+	}
+	return nil
+}
+
+// initRaft replicates the repositories through an embedded raft group.
+// Mutations are proposed to the leader, reads stay on the local replica.
+func (c *ServiceContainer) initRaft() error {
+	cfg := c.Config.Cluster.Raft
+	if cfg.Token == "" {
+		return errors.New("cluster.raft.token is required")
+	}
+	nodeID, err := c.nodeID()
+	if err != nil {
+		return err
+	}
+
+	raftCfg := raft.Config{
+		ID:                nodeID,
+		Peers:             cfg.Peers,
+		Dir:               cfg.Dir,
+		SnapshotThreshold: uint64(cfg.SnapshotThreshold),
+	}
+	if d, err := time.ParseDuration(cfg.ElectionTimeout); err == nil {
+		raftCfg.ElectionTimeout = d
+	}
+
+	fsm := cluster.NewMetadataFSM(c.BucketRepo, c.ObjectRepo)
+	node, err := raft.NewNode(raftCfg, fsm, raft.NewHTTPTransport(cfg.Token))
+	if err != nil {
+		return err
+	}
+	if err := node.Start(); err != nil {
+		return err
+	}
+
+	c.BucketRepo = cluster.NewBucketRepository(c.BucketRepo, node)
+	c.ObjectRepo = cluster.NewObjectRepository(c.ObjectRepo, node)
+	c.Raft = node
+	return nil
+}
+
+// nodeID identifies this node in the cluster, defaulting to the hostname
+func (c *ServiceContainer) nodeID() (string, error) {
+	if c.Config.Cluster.NodeID != "" {
+		return c.Config.Cluster.NodeID, nil
+	}
+	nodeID, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine node id: %w", err)
+	}
+	return nodeID, nil
+}
+
 // initServices initializes the business logic services
-func (c *ServiceContainer) initServices() {
+func (c *ServiceContainer) initServices() error {
 	c.BucketService = bucket.NewService(c.BucketRepo)
+	// Per-prefix totals, kept up to date on every write through the
+	// repository
+	var prefixes *object.PrefixStatsRepository
+	if c.Config.PrefixStats.Enabled {
+		var store object.PrefixStatsStore
+		if c.DB != nil {
+			store = object.NewSQLitePrefixStatsStore(c.DB)
+		} else {
+			fileStore, err := object.NewFilePrefixStatsStore("metadata")
+			if err != nil {
+				return fmt.Errorf("failed to create prefix statistics store: %w", err)
+			}
+			store = fileStore
+		}
+		prefixes = object.NewPrefixStatsRepository(c.ObjectRepo, store)
+		c.ObjectRepo = prefixes
+	}
+	// Full-text index of keys and user metadata, likewise maintained on
+	// write
+	var search *object.SearchRepository
+	if c.Config.Search.Enabled {
+		var index object.SearchIndex
+		if c.DB != nil {
+			index = object.NewSQLiteSearchIndex(c.DB)
+		} else {
+			index = object.NewMemorySearchIndex()
+		}
+		search = object.NewSearchRepository(c.ObjectRepo, index)
+		c.ObjectRepo = search
+	}
+
 	c.ObjectService = object.NewService(c.ObjectRepo, c.Engine)
+	if prefixes != nil {
+		c.ObjectService.SetPrefixStats(prefixes)
+	}
+	if search != nil {
+		c.ObjectService.SetSearch(search)
+	}
+	c.Multipart = multipart.NewService(c.Engine, c.ObjectService)
+	checksums := integrity.CalculatorOptions{SkipMD5: c.Config.Storage.Checksums.SkipMD5}
+	c.ObjectService.SetChecksums(checksums)
+	c.Multipart.SetChecksums(checksums)
+	chunks := c.Config.Storage.Checksums
+	c.ObjectService.SetSealVerification(c.Config.Storage.Sealing.VerifyData)
+	c.ObjectService.SetChunkChecksums(object.ChunkOptions{
+		Threshold: chunks.ChunkThreshold,
+		ChunkSize: chunks.ChunkSize,
+		Verify:    chunks.VerifyReads,
+	})
+	monitoring.Log.Info("Checksums configured",
+		zap.Bool("md5", !checksums.SkipMD5),
+		zap.Int64("chunk_threshold", chunks.ChunkThreshold),
+		zap.Strings("cpu_features", integrity.Hardware()))
+	c.Multipart.SetLimits(multipart.Limits{
+		MinPartSize: c.Config.Multipart.MinPartSize,
+		MaxParts:    c.Config.Multipart.MaxParts,
+	})
+	if c.DB != nil {
+		// Uploads in progress survive a restart
+		if err := c.Multipart.SetStore(context.Background(), multipart.NewSQLiteStore(c.DB)); err != nil {
+			return err
+		}
+	}
 
 	// Wire up the object counter for bucket emptiness checks
 	c.BucketService.SetObjectCounter(c.ObjectRepo)
+	watermarks := storage.Watermarks{CriticalPercent: c.Config.Storage.Watermarks.CriticalPercent}
+	c.BucketService.SetCapacity(func() int64 { return watermarks.Available(c.Engine.Stats()) })
+	if quotas := c.Config.Tenancy.Quotas; len(quotas) > 0 {
+		tenantQuotas := make(map[string]bucket.Quota, len(quotas))
+		for tenant, q := range quotas {
+			if !bucket.ValidTenant(tenant) {
+				return fmt.Errorf("tenancy.quotas: invalid tenant name %q", tenant)
+			}
+			tenantQuotas[tenant] = bucket.Quota{MaxSize: q.MaxSize, MaxObjects: q.MaxObjects}
+		}
+		c.BucketService.SetTenantQuotas(tenantQuotas)
+	}
+	c.ObjectService.SetVersioning(func(ctx context.Context, name string) bool {
+		b, err := c.BucketService.GetBucket(ctx, name)
+		return err == nil && b.Versioning == bucket.VersioningEnabled
+	})
+	bucketChecksums := func(ctx context.Context, name string) []string {
+		if b, err := c.BucketService.GetBucket(ctx, name); err == nil {
+			return b.ChecksumAlgorithms
+		}
+		return nil
+	}
+	c.ObjectService.SetBucketChecksums(bucketChecksums)
+	c.ObjectService.SetBucketReplication(func(ctx context.Context, name string) bool {
+		b, err := c.BucketService.GetBucket(ctx, name)
+		return err != nil || !b.ReplicationDisabled
+	})
+	c.Multipart.SetBucketChecksums(bucketChecksums)
+
+	// Cluster-wide bucket names, claimed in a directory shared by all nodes
+	if dir := c.Config.Cluster.NamespaceDir; dir != "" {
+		namespace, err := bucket.NewFileNamespace(dir)
+		if err != nil {
+			return fmt.Errorf("failed to open bucket namespace: %w", err)
+		}
+		nodeID, err := c.nodeID()
+		if err != nil {
+			return err
+		}
+		c.BucketService.SetNamespace(namespace, nodeID)
+		monitoring.Log.Info("Bucket namespace shared across nodes",
+			zap.String("dir", dir),
+			zap.String("node_id", nodeID))
+	}
+
+	// Per-object operation history, kept alongside the other metadata
+	if c.Config.History.Enabled {
+		history, err := object.NewFileHistoryStore("metadata", c.Config.History.MaxEvents)
+		if err != nil {
+			return fmt.Errorf("failed to create history store: %w", err)
+		}
+		c.ObjectService.SetHistory(history)
+	}
+
+	if err := c.initEncryption(); err != nil {
+		return fmt.Errorf("failed to initialize encryption: %w", err)
+	}
+
+	if err := c.initRing(); err != nil {
+		return fmt.Errorf("failed to initialize ring: %w", err)
+	}
+
+	if c.Config.ReadReplica.Enabled {
+		c.Replica = replication.NewReplicaState()
+		monitoring.Log.Info("Serving as read replica",
+			zap.String("primary_url", c.Config.ReadReplica.PrimaryURL))
+	}
 
 	monitoring.Log.Info("Services initialized")
+	return nil
+}
+
+// initEncryption loads the master key versions, from the configuration or
+// a key management service, and encrypts new objects with the active one
+func (c *ServiceContainer) initEncryption() error {
+	cfg := c.Config.Encryption
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var keyring *encryption.Keyring
+	switch cfg.KMS.Provider {
+	case "":
+		keys := make(map[int][]byte, len(cfg.Keys))
+		for _, k := range cfg.Keys {
+			if _, ok := keys[k.Version]; ok {
+				return fmt.Errorf("master key version %d is configured twice", k.Version)
+			}
+			key, err := encryption.ParseKey(k.Key)
+			if err != nil {
+				return fmt.Errorf("master key version %d: %w", k.Version, err)
+			}
+			keys[k.Version] = key
+		}
+
+		var err error
+		if keyring, err = encryption.NewKeyring(keys, cfg.ActiveVersion); err != nil {
+			return err
+		}
+
+	case "vault", "aws":
+		source, err := kmsSource(cfg.KMS)
+		if err != nil {
+			return err
+		}
+		interval := 5 * time.Minute
+		if d, err := time.ParseDuration(cfg.KMS.RefreshInterval); err == nil {
+			interval = d
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		manager, err := encryption.NewKeyManager(ctx, source, interval, cfg.ActiveVersion)
+		if err != nil {
+			return fmt.Errorf("failed to fetch master keys from %s: %w", source.Name(), err)
+		}
+		manager.Start()
+		c.KeyManager = manager
+		keyring = manager.Keyring()
+
+	default:
+		return fmt.Errorf("unknown KMS provider %q", cfg.KMS.Provider)
+	}
+
+	c.Keys = keyring
+	c.ObjectService.SetEncryption(keyring, cfg.RewrapOnRead)
+
+	monitoring.Log.Info("Server-side encryption enabled",
+		zap.String("kms", cfg.KMS.Provider),
+		zap.Int("active_version", keyring.ActiveVersion()),
+		zap.Ints("versions", keyring.Versions()))
+	return nil
+}
+
+// initAttestation loads the key object attestations are signed with. Without
+// one configured, a key is generated that lasts until the server restarts.
+func (c *ServiceContainer) initAttestation() error {
+	encoded := c.Config.Attestation.SigningKey
+	if encoded == "" {
+		signer, err := attestation.GenerateSigner()
+		if err != nil {
+			return err
+		}
+		c.Attestation = signer
+		monitoring.Log.Warn("No attestation signing key configured, attestations are signed with a key generated for this run",
+			zap.String("key_id", signer.KeyID()))
+		return nil
+	}
+
+	key, err := attestation.ParseKey(encoded)
+	if err != nil {
+		return err
+	}
+	c.Attestation = attestation.NewSigner(key)
+	return nil
+}
+
+// kmsSource creates the key source of a KMS provider, taking credentials
+// missing from the configuration from the environment
+func kmsSource(cfg config.KMSConfig) (encryption.KeySource, error) {
+	if cfg.Provider == "vault" {
+		v := cfg.Vault
+		if v.Address == "" {
+			return nil, errors.New("encryption.kms.vault.address is required")
+		}
+		token := v.Token
+		if token == "" {
+			token = os.Getenv("VAULT_TOKEN")
+		}
+		return &encryption.VaultSource{
+			Address: v.Address,
+			Token:   token,
+			Mount:   v.Mount,
+			Path:    v.Path,
+			Field:   v.Field,
+		}, nil
+	}
+
+	a := cfg.AWS
+	if a.Region == "" {
+		return nil, errors.New("encryption.kms.aws.region is required")
+	}
+	ciphertexts := make(map[int]string, len(a.Keys))
+	for _, k := range a.Keys {
+		if _, ok := ciphertexts[k.Version]; ok {
+			return nil, fmt.Errorf("master key version %d is configured twice", k.Version)
+		}
+		ciphertexts[k.Version] = k.CiphertextBlob
+	}
+	source := &encryption.AWSKMSSource{
+		Region:          a.Region,
+		Endpoint:        a.Endpoint,
+		AccessKeyID:     a.AccessKeyID,
+		SecretAccessKey: a.SecretAccessKey,
+		SessionToken:    a.SessionToken,
+		Ciphertexts:     ciphertexts,
+	}
+	if source.AccessKeyID == "" {
+		source.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		source.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		source.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return source, nil
+}
+
+// initRing loads the ring of cluster nodes and the states recorded for
+// them. The nodes default to the raft peers.
+func (c *ServiceContainer) initRing() error {
+	cfg := c.Config.Cluster
+	nodes := cfg.Nodes
+	if len(nodes) == 0 {
+		nodes = cfg.Raft.Peers
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	nodeID, err := c.nodeID()
+	if err != nil {
+		return err
+	}
+	dir := cfg.RingDir
+	if dir == "" {
+		dir = filepath.Join("metadata", "cluster")
+	}
+	ring, err := cluster.NewRing(dir, nodeID, nodes)
+	if err != nil {
+		return err
+	}
+	c.Ring = ring
+	c.Decommissioner = cluster.NewDecommissioner(ring, c.BucketService, c.ObjectService, cfg.Token)
+
+	// Moved objects are dropped from the local metadata, which raft would
+	// drop on every node
+	if !cfg.Raft.Enabled {
+		rebalance := cluster.RebalanceConfig{
+			Fraction:       cfg.Rebalance.Fraction,
+			BytesPerSecond: cfg.Rebalance.BytesPerSecond,
+		}
+		if rebalance.Fraction <= 0 || rebalance.Fraction > 1 {
+			rebalance.Fraction = cluster.DefaultRebalanceConfig().Fraction
+		}
+		c.Rebalancer = cluster.NewRebalancer(ring, c.BucketService, c.ObjectService, cfg.Token, rebalance)
+	}
+
+	if ring.ReadOnly() {
+		monitoring.Log.Warn("Node is read-only, it is being or has been decommissioned",
+			zap.String("node_id", nodeID),
+			zap.String("state", string(ring.State())))
+	}
+	return nil
+}
+
+// initNotifications starts the object event bus and registers the
+// preview hook when enabled
+func (c *ServiceContainer) initNotifications() error {
+	bus := notification.NewBus(0, 0)
+	c.ObjectService.SetNotifications(bus)
+
+	cfg := c.Config.Preview
+	if cfg.Enabled {
+		var processors []preview.Processor
+		for _, name := range cfg.Processors {
+			switch name {
+			case "thumbnail":
+				processors = append(processors, preview.NewThumbnailer(cfg.ThumbnailSize))
+			case "text":
+				processors = append(processors, preview.NewTextExtractor(0))
+			default:
+				return fmt.Errorf("unknown preview processor %q", name)
+			}
+		}
+		preview.NewHook(c.ObjectService, cfg.Prefix, processors...).Register(bus)
+	}
+
+	bus.Start()
+	c.Notifications = bus
+	return nil
+}
+
+// initAuth initializes the user store and authenticator
+// Users and service accounts are persisted alongside the other metadata
+func (c *ServiceContainer) initAuth() error {
+	var store interface {
+		auth.UserStore
+		SetKeyring(keys *encryption.Keyring)
+		Reseal() (int, error)
+	}
+	if c.DB != nil {
+		store = auth.NewSQLiteUserStore(c.DB)
+	} else {
+		fileStore, err := auth.NewFileUserStore("metadata")
+		if err != nil {
+			return fmt.Errorf("failed to create user store: %w", err)
+		}
+		store = fileStore
+	}
+	if c.Keys != nil {
+		// Seal secret keys with the master key, including those written
+		// before encryption was enabled or under a rotated key version
+		store.SetKeyring(c.Keys)
+		resealed, err := store.Reseal()
+		if err != nil {
+			monitoring.Log.Warn("Failed to seal stored secret keys", zap.Error(err))
+		} else if resealed > 0 {
+			monitoring.Log.Info("Sealed stored secret keys", zap.Int("users", resealed))
+		}
+	}
+
+	authenticator := auth.NewHMACAuthenticator()
+	if c.Config.Auth.AdminAccessKey != "" {
+		authenticator.AddUser(auth.NewAdminUser(c.Config.Auth.AdminAccessKey, c.Config.Auth.AdminSecretKey))
+	}
+	authenticator.SetUserStore(store)
+
+	c.Users = store
+	c.Authenticator = authenticator
+	return nil
+}
+
+// initJobs initializes the background job manager
+// Job records are persisted alongside the other metadata
+func (c *ServiceContainer) initJobs() error {
+	store, err := jobs.NewFileStore(filepath.Join("metadata", "jobs"))
+	if err != nil {
+		return fmt.Errorf("failed to create job store: %w", err)
+	}
+
+	manager, err := jobs.NewManager(jobs.DefaultConfig(), store)
+	if err != nil {
+		return fmt.Errorf("failed to create job manager: %w", err)
+	}
+
+	manager.Start()
+	c.Jobs = manager
+
+	monitoring.Log.Info("Job manager initialized")
+	return nil
+}
+
+// initScheduler registers the built-in tasks and configured cron schedules
+func (c *ServiceContainer) initScheduler() error {
+	sched := scheduler.New(c.Jobs)
+
+	cfg := c.Config.Scheduler
+	metadataBackup := backup.NewBackup("metadata", cfg.BackupDir, cfg.BackupRetain)
+	sched.RegisterTask(scheduler.TaskMetadataBackup, func(ctx context.Context, h *jobs.Handle) error {
+		path, err := metadataBackup.Run(ctx)
+		if err != nil {
+			return err
+		}
+		h.SetMessage("wrote " + path)
+		return nil
+	})
+
+	sched.RegisterTask(scheduler.TaskScrub, c.scrub)
+	sched.RegisterTask(scheduler.TaskLifecycle, c.expire)
+	sched.RegisterTask(scheduler.TaskCompaction, c.compact)
+	sched.RegisterTask(scheduler.TaskInventory, c.takeInventory)
+
+	if err := c.registerMultipartCleanup(sched); err != nil {
+		return err
+	}
+	if err := c.registerTombstoneGC(sched); err != nil {
+		return err
+	}
+	if err := c.registerBillingReport(sched); err != nil {
+		return err
+	}
+
+	for _, sc := range cfg.Schedules {
+		if sc.Disabled {
+			continue
+		}
+		def := scheduler.Definition{Name: sc.Name, Task: sc.Task, Cron: sc.Cron}
+		if err := sched.Add(def); err != nil {
+			return err
+		}
+	}
+
+	sched.Start()
+	c.Scheduler = sched
+	return nil
+}
+
+// scrub checks the data of every object against its checksums, repairing
+// corrupt chunks from the replica when there is one
+func (c *ServiceContainer) scrub(ctx context.Context, h *jobs.Handle) error {
+	buckets, err := c.BucketService.ListBuckets(ctx, "")
+	if err != nil {
+		return err
+	}
+	var total object.ScrubSummary
+	for _, b := range buckets {
+		summary, err := c.ObjectService.ScrubBucket(ctx, b.Name, true)
+		h.Add(int64(summary.Objects), summary.Bytes)
+		total.Objects += summary.Objects
+		total.Corrupt += summary.Corrupt
+		total.Repaired += summary.Repaired
+		total.Failed += summary.Failed
+		if err != nil {
+			return err
+		}
+	}
+	h.SetMessage(fmt.Sprintf("scrubbed %d objects: %d corrupt, %d repaired, %d unreadable",
+		total.Objects, total.Corrupt, total.Repaired, total.Failed))
+	if total.Corrupt > total.Repaired || total.Failed > 0 {
+		return fmt.Errorf("%d corrupt objects left unrepaired and %d unreadable", total.Corrupt-total.Repaired, total.Failed)
+	}
+	return nil
+}
+
+// expire deletes the objects past the expiration of their bucket's
+// lifecycle rules
+func (c *ServiceContainer) expire(ctx context.Context, h *jobs.Handle) error {
+	executor := lifecycle.NewExecutor(c.BucketService, c.ObjectService, parseDuration(c.Config.Lifecycle.EvaluationInterval))
+	summary, err := executor.Run(ctx, func(s lifecycle.Summary) {
+		h.SetProgress(jobs.Progress{Done: s.Objects, Bytes: s.ExpiredBytes})
+	})
+	h.SetMessage(fmt.Sprintf("expired %d of %d objects in %d buckets, %d failed",
+		summary.Expired, summary.Objects, summary.Buckets, summary.Failed))
+	return err
+}
+
+// compact moves objects out of the slabs wasting the most space, so that
+// they take new data again
+func (c *ServiceContainer) compact(ctx context.Context, h *jobs.Handle) error {
+	reporter, ok := c.Engine.(storage.FragmentationReporter)
+	if !ok {
+		return fmt.Errorf("the storage engine does not report fragmentation")
+	}
+	slabs := reporter.Fragmentation().Compactable(c.Config.Storage.Fragmentation.SlabPercent)
+	if len(slabs) == 0 {
+		h.SetMessage("no slab to compact")
+		return nil
+	}
+
+	buckets, err := c.BucketService.ListBuckets(ctx, "")
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(buckets))
+	for i, b := range buckets {
+		names[i] = b.Name
+	}
+	summary, err := c.ObjectService.Compact(ctx, names, slabs, func(s object.CompactionSummary) {
+		h.SetProgress(jobs.Progress{Done: int64(s.Objects + s.Skipped + s.Failed), Bytes: s.Bytes})
+	})
+	h.SetMessage(fmt.Sprintf("compacted %d slabs: moved %d objects, skipped %d, %d failed",
+		len(slabs), summary.Objects, summary.Skipped, summary.Failed))
+	return err
+}
+
+// takeInventory writes a CSV listing of every bucket to the inventory bucket
+func (c *ServiceContainer) takeInventory(ctx context.Context, h *jobs.Handle) error {
+	cfg := c.Config.Scheduler
+	writer := inventory.NewWriter(c.BucketService, c.ObjectService, cfg.InventoryBucket, cfg.InventoryPrefix)
+	summary, err := writer.Run(ctx, func(s inventory.Summary) {
+		h.SetProgress(jobs.Progress{Done: s.Objects, Bytes: s.Bytes})
+	})
+	h.SetMessage(fmt.Sprintf("listed %d objects in %d buckets", summary.Objects, summary.Buckets))
+	return err
+}
+
+// registerMultipartCleanup registers the task expiring abandoned multipart
+// uploads, scheduling it by default unless a configured schedule runs it
+func (c *ServiceContainer) registerMultipartCleanup(sched *scheduler.Scheduler) error {
+	cfg := c.Config.Multipart
+	if cfg.AbortIncompleteAfter == "" || cfg.AbortIncompleteAfter == "0" {
+		return nil
+	}
+	maxIdle, err := time.ParseDuration(cfg.AbortIncompleteAfter)
+	if err != nil {
+		return fmt.Errorf("invalid multipart.abort_incomplete_after: %w", err)
+	}
+
+	sched.RegisterTask(scheduler.TaskMultipartCleanup, func(ctx context.Context, h *jobs.Handle) error {
+		result, err := c.Multipart.ExpireUploads(ctx, maxIdle)
+		h.Add(int64(result.Uploads+result.Sessions), result.ReclaimedBytes)
+		if err != nil {
+			return err
+		}
+		h.SetMessage(fmt.Sprintf("aborted %d uploads and %d resumable sessions, reclaimed %d bytes",
+			result.Uploads, result.Sessions, result.ReclaimedBytes))
+		if result.Uploads > 0 || result.Sessions > 0 {
+			monitoring.Log.Info("Expired incomplete multipart uploads",
+				zap.Int("uploads", result.Uploads),
+				zap.Int("parts", result.Parts),
+				zap.Int("sessions", result.Sessions),
+				zap.Int64("reclaimed_bytes", result.ReclaimedBytes))
+		}
+		return nil
+	})
+
+	for _, sc := range c.Config.Scheduler.Schedules {
+		if sc.Task == scheduler.TaskMultipartCleanup {
+			return nil
+		}
+	}
+	return sched.Add(scheduler.Definition{
+		Name: "multipart-cleanup",
+		Task: scheduler.TaskMultipartCleanup,
+		Cron: cfg.CleanupCron,
+	})
+}
+
+// registerTombstoneGC registers the task removing aged delete markers from
+// every bucket, scheduling it by default unless a configured schedule runs it
+func (c *ServiceContainer) registerTombstoneGC(sched *scheduler.Scheduler) error {
+	cfg := c.Config.Versioning
+	if cfg.TombstoneMaxAge == "" || cfg.TombstoneMaxAge == "0" {
+		return nil
+	}
+	maxAge, err := time.ParseDuration(cfg.TombstoneMaxAge)
+	if err != nil {
+		return fmt.Errorf("invalid versioning.tombstone_max_age: %w", err)
+	}
+
+	sched.RegisterTask(scheduler.TaskTombstoneGC, func(ctx context.Context, h *jobs.Handle) error {
+		buckets, err := c.BucketService.ListBuckets(ctx, "")
+		if err != nil {
+			return err
+		}
+		var total object.TombstoneResult
+		for _, b := range buckets {
+			result, err := c.ObjectService.CollectTombstones(ctx, b.Name, maxAge)
+			h.Add(int64(result.Markers+result.Versions), result.ReclaimedBytes)
+			total.Markers += result.Markers
+			total.Versions += result.Versions
+			total.ReclaimedBytes += result.ReclaimedBytes
+			total.Pending += result.Pending
+			if err != nil {
+				return err
+			}
+		}
+		h.SetMessage(fmt.Sprintf("removed %d delete markers and %d versions, reclaimed %d bytes, %d awaiting replication",
+			total.Markers, total.Versions, total.ReclaimedBytes, total.Pending))
+		if total.Markers > 0 {
+			monitoring.Log.Info("Collected delete markers",
+				zap.Int("markers", total.Markers),
+				zap.Int("versions", total.Versions),
+				zap.Int64("reclaimed_bytes", total.ReclaimedBytes),
+				zap.Int("pending", total.Pending))
+		}
+		return nil
+	})
+
+	for _, sc := range c.Config.Scheduler.Schedules {
+		if sc.Task == scheduler.TaskTombstoneGC {
+			return nil
+		}
+	}
+	return sched.Add(scheduler.Definition{
+		Name: "tombstone-gc",
+		Task: scheduler.TaskTombstoneGC,
+		Cron: cfg.TombstoneCron,
+	})
+}
+
+// initAlerting starts webhook alerts when webhooks are configured
+// initReplicationMetrics exports the replicator's stats to Prometheus
+func (c *ServiceContainer) initReplicationMetrics() {
+	if c.Replicator == nil {
+		return
+	}
+	if err := prometheus.Register(replication.NewCollector(c.Replicator)); err != nil {
+		monitoring.Log.Warn("Failed to export replication metrics", zap.Error(err))
+	}
+}
+
+func (c *ServiceContainer) initAlerting() {
+	cfg := c.Config.Alerting
+	if len(cfg.Webhooks) == 0 {
+		return
+	}
+
+	notifier := alerting.NewNotifier(alerting.Config{
+		URLs:     cfg.Webhooks,
+		Cooldown: parseDuration(cfg.Cooldown),
+	})
+	notifier.Start()
+
+	monitor := alerting.NewMonitor(alerting.MonitorConfig{
+		Interval:                  parseDuration(cfg.CheckInterval),
+		StorageWarningPercent:     cfg.StorageWarningPercent,
+		StorageCriticalPercent:    cfg.StorageCriticalPercent,
+		ReplicationQueueThreshold: cfg.ReplicationQueueThreshold,
+		Fragmentation:             fragmentationThresholds(c.Config.Storage.Fragmentation),
+	}, notifier, c.Engine)
+	monitor.Start()
+
+	c.Alerts = notifier
+	c.AlertMonitor = monitor
+
+	monitoring.Log.Info("Alerting initialized", zap.Int("webhooks", len(cfg.Webhooks)))
+}
+
+// initAdmission bounds the S3 requests running at once per operation
+// class, queueing the excess for a while
+func (c *ServiceContainer) initAdmission() {
+	cfg := c.Config.Concurrency
+	c.Admission = admission.NewLimiter(map[admission.Class]admission.Limit{
+		admission.ClassRead:  concurrencyLimit(cfg.Reads),
+		admission.ClassWrite: concurrencyLimit(cfg.Writes),
+		admission.ClassList:  concurrencyLimit(cfg.Lists),
+	})
+}
+
+// concurrencyLimit converts the configured limit of a class
+func concurrencyLimit(cfg config.ConcurrencyLimit) admission.Limit {
+	limit := admission.Limit{
+		MaxConcurrent: cfg.MaxConcurrent,
+		MaxQueued:     cfg.MaxQueued,
+	}
+	if cfg.QueueTimeout != "" {
+		limit.QueueTimeout = parseDuration(cfg.QueueTimeout)
+	}
+	return limit
+}
+
+// fragmentationThresholds converts the configured fragmentation thresholds
+func fragmentationThresholds(cfg config.FragmentationConfig) storage.FragmentationThresholds {
+	return storage.FragmentationThresholds{
+		WarningPercent:  cfg.WarningPercent,
+		CriticalPercent: cfg.CriticalPercent,
+	}
+}
+
+// initWatermarks makes the engine refuse new data near a full device, and
+// logs and alerts when usage crosses a watermark
+func (c *ServiceContainer) initWatermarks() {
+	engine, ok := c.Engine.(*storage.SimpleEngine)
+	if !ok {
+		return
+	}
+	cfg := c.Config.Storage.Watermarks
+	watermarks := storage.Watermarks{
+		HighPercent:     cfg.HighPercent,
+		CriticalPercent: cfg.CriticalPercent,
+	}
+
+	engine.SetWatermarks(watermarks, func(prev storage.CapacityLevel, status storage.CapacityStatus) {
+		fields := []zap.Field{
+			zap.String("from", string(prev)),
+			zap.String("to", string(status.Level)),
+			zap.Float64("used_percent", status.UsedPercent),
+		}
+		switch status.Level {
+		case storage.CapacityCritical:
+			monitoring.Log.Error("Storage usage above the critical watermark, refusing writes", fields...)
+		case storage.CapacityHigh:
+			monitoring.Log.Warn("Storage usage above the high watermark", fields...)
+		default:
+			monitoring.Log.Info("Storage usage back below the watermarks", fields...)
+			return
+		}
+
+		if c.Alerts != nil {
+			severity := alerting.SeverityWarning
+			if status.Level == storage.CapacityCritical {
+				severity = alerting.SeverityCritical
+			}
+			c.Alerts.Notify(alerting.Alert{
+				Type:     alerting.TypeStorageUsage,
+				Severity: severity,
+				Key:      "watermark",
+				Summary:  fmt.Sprintf("storage usage %.1f%% is above the %s watermark", status.UsedPercent, status.Level),
+				Details: map[string]interface{}{
+					"used_percent":     status.UsedPercent,
+					"high_percent":     status.HighPercent,
+					"critical_percent": status.CriticalPercent,
+				},
+			})
+		}
+	})
+}
+
+// initDiskHealth starts SMART checks of the disk and partition devices.
+// Warnings go to the alert webhooks when alerting is configured.
+func (c *ServiceContainer) initDiskHealth() {
+	cfg := c.Config.Storage.DiskHealth
+	if !cfg.Enabled {
+		return
+	}
+
+	var devices []string
+	for _, d := range c.Config.Storage.Devices {
+		if d.Type == "disk" || d.Type == "partition" {
+			devices = append(devices, d.Path)
+		}
+	}
+	if len(devices) == 0 {
+		monitoring.Log.Warn("Disk health checks enabled but no disk or partition devices are configured")
+		return
+	}
+
+	interval := time.Hour
+	if d, err := time.ParseDuration(cfg.Interval); err == nil {
+		interval = d
+	}
+	prober := diskhealth.FallbackProber{
+		diskhealth.SmartctlProber{Path: cfg.SmartctlPath},
+		diskhealth.SysfsProber{},
+	}
+	checker := diskhealth.NewChecker(diskhealth.Config{
+		Devices:            devices,
+		Interval:           interval,
+		WearWarningPercent: cfg.WearWarningPercent,
+		ReallocatedSectors: cfg.ReallocatedSectors,
+	}, prober, c.Alerts)
+	checker.Start()
+	c.DiskHealth = checker
+
+	monitoring.Log.Info("Disk health checks started", zap.Strings("devices", devices))
+}
+
+// initStatsHistory starts sampling the storage stats into the metadata
+// directory so growth trends can be seen without external monitoring
+func (c *ServiceContainer) initStatsHistory() error {
+	cfg := c.Config.Metrics.History
+	if !cfg.Enabled {
+		return nil
+	}
+
+	retention := storage.DefaultStatsRetention
+	if cfg.Retention != "" {
+		d, err := time.ParseDuration(cfg.Retention)
+		if err != nil {
+			return fmt.Errorf("invalid metrics.history.retention: %w", err)
+		}
+		retention = d
+	}
+	history, err := storage.NewStatsHistory("metadata", retention)
+	if err != nil {
+		return err
+	}
+
+	recorder := storage.NewStatsRecorder(c.Engine, history, parseDuration(cfg.Interval))
+	recorder.OnError(func(err error) {
+		monitoring.Log.Warn("Failed to record storage stats", zap.Error(err))
+	})
+	recorder.Start()
+
+	c.StatsHistory = history
+	c.StatsRecorder = recorder
+	return nil
+}
+
+// registerBillingReport registers the task writing the chargeback report
+// of the previous month to the report bucket, scheduling it by default
+// unless a configured schedule runs it
+func (c *ServiceContainer) registerBillingReport(sched *scheduler.Scheduler) error {
+	cfg := c.Config.Billing
+	if !cfg.Enabled {
+		return nil
+	}
+	if c.Usage == nil {
+		return fmt.Errorf("billing requires usage tracking")
+	}
+
+	c.Billing = billing.NewReporter(c.Usage, c.ObjectService, c.bucketOwners, cfg.Bucket, cfg.Prefix)
+	if cfg.Bucket == "" {
+		return nil
+	}
+
+	sched.RegisterTask(scheduler.TaskBillingReport, func(ctx context.Context, h *jobs.Handle) error {
+		report, err := c.Billing.Generate(ctx, billing.PreviousMonth(time.Now()))
+		if err != nil {
+			return err
+		}
+		if err := c.Billing.Publish(ctx, report); err != nil {
+			return err
+		}
+		h.SetMessage(fmt.Sprintf("wrote the %s report of %d buckets to %s", report.Month, len(report.Buckets), cfg.Bucket))
+		return nil
+	})
+
+	for _, sc := range c.Config.Scheduler.Schedules {
+		if sc.Task == scheduler.TaskBillingReport {
+			return nil
+		}
+	}
+	return sched.Add(scheduler.Definition{
+		Name: "billing-report",
+		Task: scheduler.TaskBillingReport,
+		Cron: cfg.Cron,
+	})
+}
+
+// bucketOwners returns the current owner of each bucket
+func (c *ServiceContainer) bucketOwners(ctx context.Context) (map[string]string, error) {
+	buckets, err := c.BucketService.ListBuckets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string, len(buckets))
+	for _, b := range buckets {
+		owners[b.Name] = b.Owner
+	}
+	return owners, nil
+}
+
+// storageSamples returns what each bucket holds, for billing storage
+func (c *ServiceContainer) storageSamples(ctx context.Context) ([]usage.StorageSample, error) {
+	buckets, err := c.BucketService.ListBuckets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]usage.StorageSample, 0, len(buckets))
+	for _, b := range buckets {
+		objects, size, err := c.ObjectService.CountObjects(ctx, b.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count objects of %s: %w", b.Name, err)
+		}
+		samples = append(samples, usage.StorageSample{
+			Bucket:  b.Name,
+			Owner:   b.Owner,
+			Objects: int64(objects),
+			Bytes:   size,
+		})
+	}
+	return samples, nil
+}
+
+// initUsage starts counting the requests and traffic of each access key,
+// and sampling what each bucket stores
+func (c *ServiceContainer) initUsage() error {
+	cfg := c.Config.Usage
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var retention time.Duration
+	if cfg.Retention != "" {
+		d, err := time.ParseDuration(cfg.Retention)
+		if err != nil {
+			return fmt.Errorf("invalid usage.retention: %w", err)
+		}
+		retention = d
+	}
+	tracker, err := usage.NewTracker("metadata", retention, parseDuration(cfg.FlushInterval))
+	if err != nil {
+		return err
+	}
+	tracker.OnError(func(err error) {
+		monitoring.Log.Warn("Failed to write usage counters", zap.Error(err))
+	})
+	tracker.SetStorageSource(c.storageSamples)
+	tracker.Start()
+
+	c.Usage = tracker
+	return nil
+}
+
+// initMetricsPush starts pushing the Prometheus metrics to the remote
+// write endpoint and statsd agent that are enabled
+func (c *ServiceContainer) initMetricsPush() error {
+	cfg := c.Config.Metrics.Push
+	if !cfg.RemoteWrite.Enabled && !cfg.Statsd.Enabled {
+		return nil
+	}
+
+	interval := 15 * time.Second
+	if cfg.Interval != "" {
+		d, err := time.ParseDuration(cfg.Interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid metrics.push.interval: %q", cfg.Interval)
+		}
+		interval = d
+	}
+	pusher := monitoring.NewPusher(prometheus.DefaultGatherer, interval)
+
+	if rw := cfg.RemoteWrite; rw.Enabled {
+		if rw.URL == "" {
+			return fmt.Errorf("metrics.push.remote_write.url is required")
+		}
+		var timeout time.Duration
+		if rw.Timeout != "" {
+			d, err := time.ParseDuration(rw.Timeout)
+			if err != nil {
+				return fmt.Errorf("invalid metrics.push.remote_write.timeout: %w", err)
+			}
+			timeout = d
+		}
+		pusher.AddSink("remote_write", monitoring.NewRemoteWriteSink(monitoring.RemoteWriteConfig{
+			URL:               rw.URL,
+			Username:          rw.Username,
+			Password:          rw.Password,
+			Headers:           rw.Headers,
+			Labels:            rw.Labels,
+			Timeout:           timeout,
+			BatchSize:         rw.BatchSize,
+			MaxRetries:        rw.MaxRetries,
+			MaxPendingSamples: rw.MaxPending,
+		}))
+	}
+
+	if sd := cfg.Statsd; sd.Enabled {
+		if sd.Address == "" {
+			return fmt.Errorf("metrics.push.statsd.address is required")
+		}
+		if sd.Format != "" && sd.Format != "statsd" && sd.Format != "datadog" {
+			return fmt.Errorf("invalid metrics.push.statsd.format %q: must be statsd or datadog", sd.Format)
+		}
+		pusher.AddSink("statsd", monitoring.NewStatsdSink(monitoring.StatsdConfig{
+			Address:       sd.Address,
+			Prefix:        sd.Prefix,
+			Datadog:       sd.Format == "datadog",
+			Tags:          sd.Tags,
+			MaxPacketSize: sd.MaxPacketSize,
+		}))
+	}
+
+	pusher.Start()
+	c.MetricsPusher = pusher
+	return nil
 }
 
 // Close gracefully shuts down all resources
@@ -132,6 +1471,61 @@ func (c *ServiceContainer) initServices() {
 func (c *ServiceContainer) Close() error {
 	monitoring.Log.Info("Shutting down service container")
 
+	if c.stopRecovery != nil {
+		c.stopRecovery()
+	}
+
+	// Release the snapshots of NFS exports before the storage they pin
+	if c.NFS != nil {
+		if err := c.NFS.Close(); err != nil {
+			monitoring.Log.Warn("Failed to stop NFS server", zap.Error(err))
+		}
+	}
+	if c.DiskHealth != nil {
+		c.DiskHealth.Stop()
+	}
+	if c.StatsRecorder != nil {
+		c.StatsRecorder.Stop()
+	}
+	if c.MetricsPusher != nil {
+		c.MetricsPusher.Stop()
+	}
+	if c.Usage != nil {
+		if err := c.Usage.Stop(); err != nil {
+			monitoring.Log.Warn("Failed to write usage counters", zap.Error(err))
+		}
+	}
+	if c.KeyManager != nil {
+		c.KeyManager.Stop()
+	}
+	if c.AlertMonitor != nil {
+		c.AlertMonitor.Stop()
+	}
+	if c.Alerts != nil {
+		c.Alerts.Stop()
+	}
+
+	if c.Notifications != nil {
+		c.Notifications.Stop()
+	}
+
+	// Stop background jobs before the storage they operate on
+	if c.Scheduler != nil {
+		c.Scheduler.Stop()
+	}
+	if c.Jobs != nil {
+		c.Jobs.Stop()
+	}
+
+	if c.Raft != nil {
+		c.Raft.Stop()
+	}
+	if c.DB != nil {
+		if err := c.DB.Close(); err != nil {
+			monitoring.Log.Warn("Failed to close metadata database", zap.Error(err))
+		}
+	}
+
 	// Close storage engine if it has a Close method
 	if closer, ok := c.Engine.(interface{ Close() error }); ok {
 		if err := closer.Close(); err != nil {
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/api/handlers"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestDeleteObjects(t *testing.T) {
+	cfg := &config.Config{}
+	engine := openTestEngine(t)
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	serve("PUT", "/photos", "")
+	serve("PUT", "/photos/a.jpg", "a")
+	serve("PUT", "/photos/b.jpg", "b")
+
+	// S3 SDKs send path-style requests without a trailing slash
+	for _, target := range []string{"/photos?delete", "/photos/?delete"} {
+		w := serve("POST", target, `<Delete><Object><Key>a.jpg</Key></Object><Object><Key>b.jpg</Key></Object></Delete>`)
+		var result handlers.DeleteResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil || len(result.Deleted) != 2 {
+			t.Errorf("POST %s = %d %s", target, w.Code, w.Body)
+		}
+	}
+	for _, key := range []string{"a.jpg", "b.jpg"} {
+		if w := serve("GET", "/photos/"+key, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s after the batch delete = %d, want 404", key, w.Code)
+		}
+	}
+}
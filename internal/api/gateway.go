@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/api/handlers"
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// Gateway is an anonymous, read-only listener for public buckets. It only
+// serves GET and HEAD on whitelisted buckets, with long-lived caching
+// headers; the authenticated API stays on the primary listener.
+type Gateway struct {
+	router *gin.Engine
+	srv    *http.Server
+	cfg    config.GatewayConfig
+}
+
+// NewGateway creates the public gateway over the object service
+func NewGateway(cfg config.GatewayConfig, objectService *object.Service) *Gateway {
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.Use(middleware.Recovery())
+	router.Use(middleware.Logging())
+
+	objectHandler := handlers.NewObjectHandler(objectService)
+
+	public := router.Group("/")
+	public.Use(middleware.WildcardKey())
+	public.Use(middleware.PublicBuckets(cfg.Buckets))
+	public.Use(middleware.NormalizeKey())
+	public.Use(middleware.ValidateObjectKey())
+	public.Use(contentOnly())
+	public.Use(middleware.CacheControl(fmt.Sprintf("public, max-age=%d", int(parseDuration(cfg.CacheMaxAge).Seconds()))))
+	{
+		public.GET("/:bucket/*key", objectHandler.GetObject)
+		public.HEAD("/:bucket/*key", objectHandler.HeadObject)
+	}
+
+	// Everything else, including listings and writes, is unavailable
+	router.HandleMethodNotAllowed = true
+	router.RedirectTrailingSlash = false
+
+	return &Gateway{
+		router: router,
+		cfg:    cfg,
+		srv: &http.Server{
+			Addr:    fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+			Handler: router,
+		},
+	}
+}
+
+// contentOnly rejects bucket listings and requests with a query. Queries
+// select sub-resources such as ?history, ?attestation or ?versionId, which
+// expose more than the latest content of the object, or override its
+// response headers; none is served, so sub-resources added later are not
+// exposed either.
+func contentOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("key") == "" {
+			middleware.AbortWithError(c, http.StatusNotFound, s3.NoSuchKey, "listings are not available on the public gateway")
+			return
+		}
+		if c.Request.URL.RawQuery != "" {
+			middleware.AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "query parameters are not available on the public gateway")
+			return
+		}
+		c.Next()
+	}
+}
+
+// Start starts the gateway listener
+func (g *Gateway) Start() error {
+	monitoring.Log.Info("Starting public gateway",
+		zap.String("addr", g.srv.Addr),
+		zap.Strings("buckets", g.cfg.Buckets))
+
+	return g.srv.ListenAndServe()
+}
+
+// Stop stops the gateway listener gracefully
+func (g *Gateway) Stop(ctx context.Context) error {
+	return g.srv.Shutdown(ctx)
+}
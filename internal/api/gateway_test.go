@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// bufferEngine is a storage.Engine backed by a byte slice
+type bufferEngine struct {
+	mockEngine
+	data []byte
+}
+
+func (e *bufferEngine) Allocate(size int64) (int64, error) {
+	offset := int64(len(e.data))
+	e.data = append(e.data, make([]byte, size)...)
+	return offset, nil
+}
+
+func (e *bufferEngine) Write(ctx context.Context, offset int64, data []byte) error {
+	copy(e.data[offset:], data)
+	return nil
+}
+
+func (e *bufferEngine) Read(ctx context.Context, offset, size int64) ([]byte, error) {
+	return e.data[offset : offset+size], nil
+}
+
+var _ storage.Engine = (*bufferEngine)(nil)
+
+func TestGateway(t *testing.T) {
+	service := object.NewService(object.NewMemoryRepository(), &bufferEngine{})
+	var version string
+	for _, bucket := range []string{"public", "private"} {
+		content := "hello"
+		obj, err := service.PutObject(t.Context(), bucket, "file.txt", strings.NewReader(content), int64(len(content)), "text/plain")
+		if err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+		if bucket == "public" {
+			version = obj.VersionID
+		}
+	}
+
+	gw := NewGateway(config.GatewayConfig{
+		Buckets:     []string{"public"},
+		CacheMaxAge: "1h",
+	}, service)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+		cache  string
+	}{
+		{"GET", "/public/file.txt", http.StatusOK, "public, max-age=3600"},
+		{"HEAD", "/public/file.txt", http.StatusOK, "public, max-age=3600"},
+		{"GET", "/public/missing.txt", http.StatusNotFound, "no-store"},
+		{"GET", "/private/file.txt", http.StatusNotFound, ""},
+		{"GET", "/public/file.txt?history", http.StatusForbidden, ""},
+		{"GET", "/public/file.txt?attestation", http.StatusForbidden, ""},
+		{"GET", "/public/file.txt?versionId=" + version, http.StatusForbidden, ""},
+		{"HEAD", "/public/file.txt?versionId=" + version, http.StatusForbidden, ""},
+		{"HEAD", "/public/file.txt?versionId=", http.StatusForbidden, ""},
+		{"GET", "/public/file.txt?response-content-type=text/html", http.StatusForbidden, ""},
+		{"PUT", "/public/file.txt", http.StatusMethodNotAllowed, ""},
+		{"DELETE", "/public/file.txt", http.StatusMethodNotAllowed, ""},
+		{"GET", "/public", http.StatusNotFound, ""},
+		{"GET", "/public/", http.StatusNotFound, ""},
+		{"GET", "/public/photos/2024/cat.jpg", http.StatusNotFound, "no-store"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		gw.router.ServeHTTP(w, req)
+
+		if w.Code != tt.code {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.code)
+		}
+		if tt.cache != "" && w.Header().Get("Cache-Control") != tt.cache {
+			t.Errorf("%s %s Cache-Control = %q, want %q", tt.method, tt.path, w.Header().Get("Cache-Control"), tt.cache)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func TestGraphQL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{GraphQL: config.GraphQLConfig{Enabled: true}}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Jobs, err = jobs.NewManager(jobs.Config{Workers: 1}, jobs.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	container.Jobs.Start()
+	defer container.Jobs.Stop()
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	query := func(q string, variables map[string]any) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"query": q, "variables": variables})
+		return serve("POST", "/admin/v1/graphql", string(body))
+	}
+
+	for _, target := range []string{"/logs", "/photos", "/photos/2024/a.jpg", "/photos/2024/b.jpg", "/photos/cover.png"} {
+		if w := serve("PUT", target, "data"); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d: %s", target, w.Code, w.Body)
+		}
+	}
+	job, err := container.Jobs.Submit(jobs.Spec{Type: jobs.TypeExport, Key: "photos"}, func(ctx context.Context, jh *jobs.Handle) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if j, _ := container.Jobs.Get(job.ID); j.State.Finished() || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// One round trip for what a dashboard shows
+	w := query(`query Dashboard($bucket: String!) {
+		buckets(first: 1) { totalCount nodes { name } pageInfo { hasNextPage endCursor } }
+		bucket(name: $bucket) {
+			usage { objects bytes }
+			objects(prefix: "2024/", first: 1) { nodes { key size } pageInfo { hasNextPage endCursor } }
+			folders: objects(delimiter: "/") { commonPrefixes nodes { key } }
+		}
+		usage { usedBytes buckets { bucket objects } }
+		replication { enabled queueLength }
+		jobs(state: "completed") { totalCount nodes { type key state } }
+	}`, map[string]any{"bucket": "photos"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("query = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			Buckets struct {
+				TotalCount int
+				Nodes      []struct{ Name string }
+				PageInfo   struct {
+					HasNextPage bool
+					EndCursor   string
+				}
+			}
+			Bucket struct {
+				Usage   struct{ Objects, Bytes int64 }
+				Objects struct {
+					Nodes []struct {
+						Key  string
+						Size int64
+					}
+					PageInfo struct {
+						HasNextPage bool
+						EndCursor   string
+					}
+				}
+				Folders struct {
+					CommonPrefixes []string
+					Nodes          []struct{ Key string }
+				}
+			}
+			Usage struct {
+				UsedBytes int64
+				Buckets   []struct {
+					Bucket  string
+					Objects int64
+				}
+			}
+			Replication struct{ Enabled bool }
+			Jobs        struct {
+				TotalCount int
+				Nodes      []struct{ Type, Key, State string }
+			}
+		}
+		Errors []json.RawMessage
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	d := resp.Data
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errors: %s", w.Body)
+	}
+	if d.Buckets.TotalCount != 2 || len(d.Buckets.Nodes) != 1 || d.Buckets.Nodes[0].Name != "logs" || !d.Buckets.PageInfo.HasNextPage {
+		t.Errorf("buckets = %+v", d.Buckets)
+	}
+	if d.Bucket.Usage.Objects != 3 || d.Bucket.Usage.Bytes != 12 {
+		t.Errorf("bucket usage = %+v, want 3 objects of 12 bytes", d.Bucket.Usage)
+	}
+	if len(d.Bucket.Objects.Nodes) != 1 || d.Bucket.Objects.Nodes[0].Key != "2024/a.jpg" || !d.Bucket.Objects.PageInfo.HasNextPage {
+		t.Errorf("objects = %+v", d.Bucket.Objects)
+	}
+	if len(d.Bucket.Folders.CommonPrefixes) != 1 || d.Bucket.Folders.CommonPrefixes[0] != "2024/" || len(d.Bucket.Folders.Nodes) != 1 {
+		t.Errorf("folders = %+v", d.Bucket.Folders)
+	}
+	if d.Usage.UsedBytes == 0 || len(d.Usage.Buckets) != 2 || d.Usage.Buckets[1].Objects != 3 {
+		t.Errorf("usage = %+v", d.Usage)
+	}
+	if d.Replication.Enabled {
+		t.Error("replication is reported enabled without a replicator")
+	}
+	if d.Jobs.TotalCount != 1 || d.Jobs.Nodes[0].Key != "photos" {
+		t.Errorf("jobs = %+v", d.Jobs)
+	}
+
+	// The cursor continues where the page ended
+	w = query(`query ($after: String) { bucket(name: "photos") { objects(prefix: "2024/", first: 1, after: $after) { nodes { key } pageInfo { hasNextPage } } } }`,
+		map[string]any{"after": d.Bucket.Objects.PageInfo.EndCursor})
+	if body := w.Body.String(); !strings.Contains(body, `"nodes":[{"key":"2024/b.jpg"}],"pageInfo":{"hasNextPage":false}`) {
+		t.Errorf("second page = %s", body)
+	}
+
+	// Invalid queries are refused without running
+	if w := query(`{ buckets { nodes { size } } }`, nil); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `Cannot query field \"size\" on type \"Bucket\"`) {
+		t.Errorf("invalid query = %d: %s", w.Code, w.Body)
+	}
+
+	w = serve("GET", "/admin/v1/graphql/schema", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "type Query {") {
+		t.Errorf("schema = %d: %s", w.Code, w.Body)
+	}
+}
@@ -2,15 +2,36 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/danielino/comio/internal/admission"
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/diskhealth"
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/metafile"
+	"github.com/danielino/comio/internal/recovery"
+	"github.com/danielino/comio/internal/replication"
 	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 // AdminHandler handles admin operations
 type AdminHandler struct {
 	engine storage.Engine
+	disks  *diskhealth.Checker
+	kms    *encryption.KeyManager
+	stats  *storage.StatsHistory
+	db     *database.DB
+	meta   *metafile.Quarantine
+	repl   *replication.Replicator
+	frag   storage.FragmentationThresholds
+	rec    *recovery.Tracker
+	adm    *admission.Limiter
 }
 
 // NewAdminHandler creates a new admin handler
@@ -20,15 +41,232 @@ func NewAdminHandler(engine storage.Engine) *AdminHandler {
 	}
 }
 
+// SetDiskHealth adds SMART reports to the metrics
+func (h *AdminHandler) SetDiskHealth(disks *diskhealth.Checker) {
+	h.disks = disks
+}
+
+// SetKeyManager adds the state of the key management service to the
+// health check
+func (h *AdminHandler) SetKeyManager(kms *encryption.KeyManager) {
+	h.kms = kms
+}
+
+// SetStatsHistory enables the stored storage stats samples
+func (h *AdminHandler) SetStatsHistory(stats *storage.StatsHistory) {
+	h.stats = stats
+}
+
+// SetDatabase adds the metadata database pool to the metrics
+func (h *AdminHandler) SetDatabase(db *database.DB) {
+	h.db = db
+}
+
+// SetQuarantine adds the corrupt metadata files found to the metrics
+func (h *AdminHandler) SetQuarantine(quarantine *metafile.Quarantine) {
+	h.meta = quarantine
+}
+
+// SetReplicator adds the replication stats to the metrics
+func (h *AdminHandler) SetReplicator(replicator *replication.Replicator) {
+	h.repl = replicator
+}
+
+// SetFragmentationThresholds sets the fragmentation at which the
+// fragmentation report advises compacting
+func (h *AdminHandler) SetFragmentationThresholds(thresholds storage.FragmentationThresholds) {
+	h.frag = thresholds
+}
+
+// SetRecovery adds the progress of startup recovery to the health check
+func (h *AdminHandler) SetRecovery(tracker *recovery.Tracker) {
+	h.rec = tracker
+}
+
+// SetAdmission adds the concurrency limits and their queues to the metrics
+func (h *AdminHandler) SetAdmission(limiter *admission.Limiter) {
+	h.adm = limiter
+}
+
+// Recovery returns the progress of the recovery run at startup, complete
+// on nodes that needed none
+func (h *AdminHandler) Recovery(c *gin.Context) {
+	if h.rec == nil {
+		c.JSON(http.StatusOK, recovery.Status{Percent: 100, Phases: []recovery.PhaseStatus{}})
+		return
+	}
+	c.JSON(http.StatusOK, h.rec.Status())
+}
+
 // Metrics returns metrics
 func (h *AdminHandler) Metrics(c *gin.Context) {
 	stats := h.engine.Stats()
-	c.JSON(http.StatusOK, gin.H{
+	metrics := gin.H{
 		"storage": stats,
-	})
+	}
+	if reporter, ok := h.engine.(storage.HealthReporter); ok {
+		metrics["devices"] = reporter.DeviceHealth()
+	}
+	if h.disks != nil {
+		metrics["disks"] = h.disks.Reports()
+	}
+	if h.db != nil {
+		metrics["database"] = h.db.PoolStats()
+	}
+	if h.adm != nil {
+		metrics["concurrency"] = h.adm.Stats()
+	}
+	if h.meta != nil {
+		files, err := h.meta.List()
+		if err != nil {
+			respondError(c, "Failed to list quarantined metadata", err)
+			return
+		}
+		if files == nil {
+			files = []metafile.QuarantinedFile{}
+		}
+		metrics["metadata"] = gin.H{"quarantined": files}
+	}
+	if h.repl != nil {
+		stats := h.repl.GetStats()
+		metrics["replication"] = gin.H{
+			"target":            h.repl.Target(),
+			"events_queued":     stats.EventsQueued,
+			"events_replicated": stats.EventsReplicated,
+			"events_failed":     stats.EventsFailed,
+			"queue_length":      h.repl.QueueLength(),
+			"lag_seconds":       stats.Lag.Seconds(),
+			"circuit_breaker":   h.repl.GetCircuitBreakerStats().State,
+		}
+	}
+	c.JSON(http.StatusOK, metrics)
 }
 
-// HealthCheck returns health status
+// HealthCheck returns health status. A failed device, an unreachable key
+// management service or usage above the critical watermark degrades the
+// node: reads may still be served, but new data cannot be stored.
 func (h *AdminHandler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	status := "ok"
+	health := gin.H{}
+
+	if reporter, ok := h.engine.(storage.HealthReporter); ok {
+		devices := reporter.DeviceHealth()
+		for _, d := range devices {
+			if !d.Healthy {
+				status = "degraded"
+			}
+		}
+		health["devices"] = devices
+	}
+	if h.kms != nil {
+		kms := h.kms.Status()
+		if !kms.Reachable {
+			status = "degraded"
+		}
+		health["kms"] = kms
+	}
+	if reporter, ok := h.engine.(storage.CapacityReporter); ok {
+		capacity := reporter.Capacity()
+		if capacity.Level == storage.CapacityCritical {
+			status = "degraded"
+		}
+		health["capacity"] = capacity
+	}
+
+	if h.rec != nil && h.rec.Recovering() {
+		status = "recovering"
+		health["recovery"] = h.rec.Status()
+	}
+
+	health["status"] = status
+	c.JSON(http.StatusOK, health)
+}
+
+// MetricsHistory returns the storage stats samples of the last ?window=,
+// such as 7d or 12h, defaulting to a day
+func (h *AdminHandler) MetricsHistory(c *gin.Context) {
+	if h.stats == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "metrics history is disabled")
+		return
+	}
+
+	window := 24 * time.Hour
+	if w := c.Query("window"); w != "" {
+		d, err := parseWindow(w)
+		if err != nil || d <= 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid window: "+w)
+			return
+		}
+		window = d
+	}
+
+	samples, err := h.stats.Since(time.Now().Add(-window))
+	if err != nil {
+		respondError(c, "Failed to read metrics history", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"window":    window.String(),
+		"retention": h.stats.Retention().String(),
+		"samples":   samples,
+	})
+}
+
+// parseWindow parses a duration, also accepting a whole number of days
+// such as 7d
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// defaultFragmentedSlabs is how many slabs the fragmentation report lists
+// when no ?limit= is given
+const defaultFragmentedSlabs = 20
+
+// Fragmentation reports the space freed objects waste in the slabs and
+// how much compaction would reclaim, listing the ?limit= most fragmented
+// slabs (20 by default, 0 for all of them)
+func (h *AdminHandler) Fragmentation(c *gin.Context) {
+	reporter, ok := h.engine.(storage.FragmentationReporter)
+	if !ok {
+		middleware.Error(c, http.StatusNotImplemented, s3.NotImplemented, "the storage engine does not report fragmentation")
+		return
+	}
+
+	limit := defaultFragmentedSlabs
+	if l := c.Query("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid limit: "+l)
+			return
+		}
+		limit = n
+	}
+
+	report := reporter.Fragmentation()
+	level := h.frag.Level(report.Fragmentation)
+	advice := "no compaction needed"
+	if level != storage.FragmentationOK {
+		advice = "compaction recommended"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"slab_size":             report.SlabSize,
+		"slab_count":            len(report.Slabs),
+		"allocated_bytes":       report.AllocatedBytes,
+		"used_bytes":            report.UsedBytes,
+		"wasted_bytes":          report.WastedBytes,
+		"reclaimable_bytes":     report.ReclaimableBytes,
+		"fragmentation_percent": report.Fragmentation,
+		"level":                 level,
+		"warning_percent":       h.frag.WarningPercent,
+		"critical_percent":      h.frag.CriticalPercent,
+		"advice":                advice,
+		"slabs":                 report.MostFragmented(limit),
+	})
 }
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/apply"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// maxSpecSize bounds the declarative specs accepted by Apply
+const maxSpecSize = 4 << 20
+
+// ApplyHandler reconciles buckets and users with declarative specs
+type ApplyHandler struct {
+	reconciler *apply.Reconciler
+}
+
+// NewApplyHandler creates a new apply handler
+func NewApplyHandler(reconciler *apply.Reconciler) *ApplyHandler {
+	return &ApplyHandler{
+		reconciler: reconciler,
+	}
+}
+
+// Apply reconciles the live state with the YAML or JSON spec in the body
+// and reports the changes. ?dry_run=true only reports them, ?prune=true
+// also deletes buckets and users the spec leaves out.
+func (h *ApplyHandler) Apply(c *gin.Context) {
+	// Specs set users' policies, so only admins apply them
+	caller := middleware.GetUserFromContext(c)
+	if !caller.IsAdmin() {
+		middleware.Error(c, http.StatusForbidden, s3.AccessDenied, "access denied: only admins apply specs")
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid dry_run: "+c.Query("dry_run"))
+		return
+	}
+	prune, err := strconv.ParseBool(c.DefaultQuery("prune", "false"))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid prune: "+c.Query("prune"))
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSpecSize+1))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+	if len(data) > maxSpecSize {
+		middleware.Error(c, http.StatusRequestEntityTooLarge, s3.EntityTooLarge, "spec is too large")
+		return
+	}
+
+	spec, err := apply.Parse(data)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+		return
+	}
+
+	result, err := h.reconciler.Apply(c.Request.Context(), spec, apply.Options{
+		DryRun: dryRun,
+		Prune:  prune,
+		Owner:  caller.Username,
+		Caller: caller,
+	})
+	if err != nil {
+		if errors.Is(err, apply.ErrInvalidSpec) {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+			return
+		}
+		if errors.Is(err, apply.ErrPrivilegeEscalation) {
+			middleware.Error(c, http.StatusForbidden, s3.AccessDenied, err.Error())
+			return
+		}
+		respondError(c, "Failed to apply spec", err)
+		return
+	}
+
+	if !dryRun && len(result.Changes) > 0 {
+		monitoring.Log.Info("Spec applied",
+			zap.Int("changes", len(result.Changes)),
+			zap.Bool("prune", prune))
+	}
+	c.JSON(http.StatusOK, result)
+}
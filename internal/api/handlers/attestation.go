@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/attestation"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// SetAttestation offers signed statements of objects' stored state with
+// ?attestation
+func (h *ObjectHandler) SetAttestation(signer *attestation.Signer) {
+	h.attestation = signer
+}
+
+// getObjectAttestation signs the checksum, size, version and retention of
+// an object, or of the version named by ?versionId, so auditors can check
+// it has not changed without downloading it
+func (h *ObjectHandler) getObjectAttestation(c *gin.Context, bucket, key string) {
+	if h.attestation == nil {
+		middleware.Error(c, http.StatusNotImplemented, s3.NotImplemented, "attestations are not available")
+		return
+	}
+
+	var versionID *string
+	if v := c.Query("versionId"); v != "" {
+		versionID = &v
+	}
+
+	ctx := c.Request.Context()
+	obj, err := h.service.HeadObject(ctx, bucket, key, versionID)
+	if err != nil {
+		setDeleteMarkerHeader(c, err)
+		respondError(c, "Failed to get object", err)
+		return
+	}
+
+	retention := attestation.Retention{Mode: attestation.RetentionNone}
+	if h.buckets != nil {
+		b, err := h.buckets.GetBucket(ctx, bucket)
+		if err != nil {
+			respondError(c, "Failed to get bucket", err)
+			return
+		}
+		retention.Versioning = string(b.Versioning)
+	}
+
+	a, err := h.attestation.Sign(attestation.Statement{
+		Bucket:     obj.BucketName,
+		Key:        obj.Key,
+		VersionID:  obj.VersionID,
+		Size:       obj.Size,
+		ETag:       obj.ETag,
+		Checksum:   obj.Checksum,
+		ModifiedAt: obj.ModifiedAt.UTC(),
+		Retention:  retention,
+	})
+	if err != nil {
+		respondError(c, "Failed to sign attestation", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, a)
+}
+
+// AttestationKey returns the public key attestations are verified with
+func (h *ObjectHandler) AttestationKey(c *gin.Context) {
+	if h.attestation == nil {
+		middleware.Error(c, http.StatusNotImplemented, s3.NotImplemented, "attestations are not available")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm":  attestation.Algorithm,
+		"key_id":     h.attestation.KeyID(),
+		"public_key": base64.StdEncoding.EncodeToString(h.attestation.PublicKey()),
+	})
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+const (
+	// bootstrapSnapshotTTL is how long a snapshot no replica reads from is
+	// kept before its storage is released
+	bootstrapSnapshotTTL = time.Hour
+	maxManifestPage      = 1000
+)
+
+// BootstrapHandler serves snapshots to new replicas bootstrapping from
+// this node, and bootstraps this node from a source
+type BootstrapHandler struct {
+	buckets *bucket.Service
+	objects *object.Service
+	jobs    *jobs.Manager
+
+	replica    *replication.ReplicaState
+	sourceURL  string // Default source, the read replica's primary
+	checkpoint string
+
+	mu        sync.Mutex
+	snapshots map[string]*bootstrapSnapshot
+}
+
+// bootstrapSnapshot holds the object snapshots of every bucket
+type bootstrapSnapshot struct {
+	info     replication.BootstrapSnapshot
+	buckets  map[string]*object.Snapshot
+	lastUsed time.Time
+}
+
+func NewBootstrapHandler(buckets *bucket.Service, objects *object.Service, jobManager *jobs.Manager) *BootstrapHandler {
+	return &BootstrapHandler{
+		buckets:   buckets,
+		objects:   objects,
+		jobs:      jobManager,
+		snapshots: make(map[string]*bootstrapSnapshot),
+	}
+}
+
+// SetReplicaState bootstraps from primaryURL by default, and leaves alone
+// objects replication changed since the snapshot
+func (h *BootstrapHandler) SetReplicaState(replica *replication.ReplicaState, primaryURL string) {
+	h.replica = replica
+	h.sourceURL = primaryURL
+}
+
+// SetCheckpoint sets where the progress of a bootstrap is saved
+func (h *BootstrapHandler) SetCheckpoint(path string) {
+	h.checkpoint = path
+}
+
+// CreateSnapshot snapshots every replicated bucket for a new replica to copy
+func (h *BootstrapHandler) CreateSnapshot(c *gin.Context) {
+	ctx := c.Request.Context()
+	buckets, err := h.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		respondError(c, "Failed to list buckets", err)
+		return
+	}
+
+	snap := &bootstrapSnapshot{
+		info: replication.BootstrapSnapshot{
+			ID:        uuid.New().String(),
+			CreatedAt: time.Now(),
+			Buckets:   []replication.BootstrapBucket{},
+		},
+		buckets:  make(map[string]*object.Snapshot),
+		lastUsed: time.Now(),
+	}
+	for _, b := range buckets {
+		if b.ReplicationDisabled {
+			continue
+		}
+		settings, err := json.Marshal(b)
+		if err != nil {
+			snap.release()
+			respondError(c, "Failed to encode bucket", err)
+			return
+		}
+		objects, err := h.objects.Snapshot(ctx, b.Name)
+		if err != nil {
+			snap.release()
+			respondError(c, "Failed to snapshot bucket", err)
+			return
+		}
+		snap.buckets[b.Name] = objects
+		snap.info.Buckets = append(snap.info.Buckets, replication.BootstrapBucket{
+			Name:     b.Name,
+			Objects:  len(objects.Objects),
+			Size:     objects.Size,
+			Settings: settings,
+		})
+	}
+
+	h.mu.Lock()
+	h.expireLocked()
+	h.snapshots[snap.info.ID] = snap
+	h.mu.Unlock()
+
+	c.JSON(http.StatusCreated, snap.info)
+}
+
+// GetSnapshot describes a snapshot, so a resuming replica can tell whether
+// it is still held
+func (h *BootstrapHandler) GetSnapshot(c *gin.Context) {
+	snap, ok := h.snapshot(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, snap.info)
+}
+
+// ReleaseSnapshot frees the storage a snapshot holds
+func (h *BootstrapHandler) ReleaseSnapshot(c *gin.Context) {
+	h.mu.Lock()
+	snap, ok := h.snapshots[c.Param("id")]
+	delete(h.snapshots, c.Param("id"))
+	h.mu.Unlock()
+
+	if !ok {
+		respondError(c, "Failed to release snapshot", replication.ErrSnapshotNotFound)
+		return
+	}
+	snap.release()
+	c.Status(http.StatusNoContent)
+}
+
+// ListSnapshotObjects returns a page of the objects of a bucket in a
+// snapshot, the keys after ?after= in order
+func (h *BootstrapHandler) ListSnapshotObjects(c *gin.Context) {
+	objects, ok := h.snapshotBucket(c)
+	if !ok {
+		return
+	}
+
+	limit := replication.DefaultBootstrapPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid limit")
+			return
+		}
+		limit = min(n, maxManifestPage)
+	}
+
+	after := c.Query("after")
+	start := sort.Search(len(objects.Objects), func(i int) bool { return objects.Objects[i].Key > after })
+	end := min(start+limit, len(objects.Objects))
+
+	page := replication.ManifestPage{Objects: make([]replication.ManifestEntry, 0, end-start)}
+	for _, obj := range objects.Objects[start:end] {
+		page.Objects = append(page.Objects, replication.ManifestEntry{
+			Key:         obj.Key,
+			Size:        obj.Size,
+			ETag:        obj.ETag,
+			ContentType: obj.ContentType,
+			Metadata:    obj.Metadata,
+			ModifiedAt:  obj.ModifiedAt,
+		})
+	}
+	if end < len(objects.Objects) {
+		page.NextAfter = objects.Objects[end-1].Key
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// GetSnapshotObject streams the data of an object in a snapshot, as it
+// was when the snapshot was taken
+func (h *BootstrapHandler) GetSnapshotObject(c *gin.Context) {
+	objects, ok := h.snapshotBucket(c)
+	if !ok {
+		return
+	}
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	i := sort.Search(len(objects.Objects), func(i int) bool { return objects.Objects[i].Key >= key })
+	if i == len(objects.Objects) || objects.Objects[i].Key != key {
+		respondError(c, "Failed to read snapshot object", object.ErrObjectNotFound)
+		return
+	}
+	obj := objects.Objects[i]
+
+	c.Header("ETag", obj.ETag)
+	c.DataFromReader(http.StatusOK, obj.Size, "application/octet-stream",
+		io.NewSectionReader(snapshotObject{objects, obj}, 0, obj.Size), nil)
+}
+
+// StartBootstrap copies a source's buckets and objects to this node in a
+// background job. A bootstrap interrupted earlier resumes from its
+// checkpoint.
+func (h *BootstrapHandler) StartBootstrap(c *gin.Context) {
+	var req struct {
+		SourceURL string `json:"source_url"`
+		Token     string `json:"token"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.SourceURL == "" {
+		req.SourceURL = h.sourceURL
+	}
+	if req.SourceURL == "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "source_url is required without read_replica.primary_url")
+		return
+	}
+	if h.jobs == nil || h.checkpoint == "" {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "background jobs are not enabled")
+		return
+	}
+
+	bootstrapper := replication.NewBootstrapper(replication.BootstrapConfig{
+		SourceURL:      req.SourceURL,
+		Token:          req.Token,
+		CheckpointPath: h.checkpoint,
+	}, bootstrapTarget{buckets: h.buckets, objects: h.objects})
+	bootstrapper.SetReplicaState(h.replica)
+
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type:   jobs.TypeBootstrap,
+		Key:    "replication",
+		Params: map[string]string{"source_url": req.SourceURL},
+	}, func(ctx context.Context, jh *jobs.Handle) error {
+		return bootstrapper.Run(ctx, func(cp replication.BootstrapCheckpoint) {
+			jh.SetProgress(jobs.Progress{
+				Done:    cp.Objects + cp.Skipped,
+				Bytes:   cp.Bytes,
+				Message: fmt.Sprintf("%d buckets done", len(cp.Done)),
+			})
+		})
+	})
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
+
+// GetBootstrap returns the checkpoint of the last bootstrap of this node
+func (h *BootstrapHandler) GetBootstrap(c *gin.Context) {
+	if h.checkpoint == "" {
+		c.JSON(http.StatusOK, gin.H{"bootstrapped": false})
+		return
+	}
+	cp, err := replication.LoadBootstrapCheckpoint(h.checkpoint)
+	if err != nil {
+		respondError(c, "Failed to read bootstrap checkpoint", err)
+		return
+	}
+	if cp == nil {
+		c.JSON(http.StatusOK, gin.H{"bootstrapped": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bootstrapped": cp.Completed, "checkpoint": cp})
+}
+
+// snapshot finds the snapshot of the request, answering 404 if there is
+// none, and keeps it from expiring
+func (h *BootstrapHandler) snapshot(c *gin.Context) (*bootstrapSnapshot, bool) {
+	h.mu.Lock()
+	h.expireLocked()
+	snap, ok := h.snapshots[c.Param("id")]
+	if ok {
+		snap.lastUsed = time.Now()
+	}
+	h.mu.Unlock()
+
+	if !ok {
+		respondError(c, "Failed to get snapshot", replication.ErrSnapshotNotFound)
+	}
+	return snap, ok
+}
+
+func (h *BootstrapHandler) snapshotBucket(c *gin.Context) (*object.Snapshot, bool) {
+	snap, ok := h.snapshot(c)
+	if !ok {
+		return nil, false
+	}
+	objects, ok := snap.buckets[c.Param("bucket")]
+	if !ok {
+		respondError(c, "Failed to get snapshot", bucket.ErrBucketNotFound)
+	}
+	return objects, ok
+}
+
+// expireLocked releases snapshots no replica has read for the TTL
+func (h *BootstrapHandler) expireLocked() {
+	for id, snap := range h.snapshots {
+		if time.Since(snap.lastUsed) > bootstrapSnapshotTTL {
+			delete(h.snapshots, id)
+			snap.release()
+		}
+	}
+}
+
+func (s *bootstrapSnapshot) release() {
+	for _, objects := range s.buckets {
+		objects.Release()
+	}
+}
+
+// snapshotObject reads an object of a snapshot as an io.ReaderAt
+type snapshotObject struct {
+	snap *object.Snapshot
+	obj  *object.Object
+}
+
+func (r snapshotObject) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.snap.ReadAt(r.obj, p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// bootstrapTarget stores what a bootstrap copies through the services
+type bootstrapTarget struct {
+	buckets *bucket.Service
+	objects *object.Service
+}
+
+func (t bootstrapTarget) ApplyBucket(ctx context.Context, b replication.BootstrapBucket) error {
+	var settings bucket.Bucket
+	if err := json.Unmarshal(b.Settings, &settings); err != nil {
+		return fmt.Errorf("invalid settings of bucket %s: %w", b.Name, err)
+	}
+	settings.Name = b.Name
+
+	err := t.buckets.CreateBucket(ctx, b.Name, settings.Owner)
+	if err != nil && !errors.Is(err, bucket.ErrBucketExists) {
+		return err
+	}
+	return t.buckets.UpdateBucket(ctx, &settings)
+}
+
+func (t bootstrapTarget) ObjectETag(ctx context.Context, bucketName, key string) (string, bool) {
+	obj, err := t.objects.HeadObject(ctx, bucketName, key, nil)
+	if err != nil {
+		return "", false
+	}
+	return obj.ETag, true
+}
+
+func (t bootstrapTarget) PutObject(ctx context.Context, bucketName string, entry replication.ManifestEntry, data io.Reader) error {
+	_, err := t.objects.PutObjectWithMetadata(ctx, bucketName, entry.Key, data, entry.Size, entry.ContentType, entry.Metadata)
+	return err
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"encoding/xml"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -21,15 +23,59 @@ func NewBucketHandler(service *bucket.Service) *BucketHandler {
 	}
 }
 
-// ListBuckets lists all buckets
+// ListAllMyBucketsResult is an S3 ListBuckets response
+type ListAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   BucketOwner   `xml:"Owner"`
+	Buckets []BucketEntry `xml:"Buckets>Bucket"`
+}
+
+// BucketOwner is the user whose buckets are listed
+type BucketOwner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// BucketEntry describes a bucket in a listing
+type BucketEntry struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+// ListBuckets lists the buckets of the request's namespace, as JSON or,
+// for clients asking for XML, as an S3 ListAllMyBucketsResult document.
+// Tenants see their buckets by the names they created them with.
 func (h *BucketHandler) ListBuckets(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
-	buckets, err := h.service.ListBuckets(c.Request.Context(), user.Username)
+	all, err := h.service.ListBuckets(c.Request.Context(), user.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, "Failed to list buckets", err)
 		return
 	}
-	c.JSON(http.StatusOK, buckets)
+	tenant := middleware.GetTenantFromContext(c)
+	buckets := make([]*bucket.Bucket, 0, len(all))
+	for _, b := range all {
+		if t, name := bucket.SplitName(b.Name); t == tenant {
+			listed := *b
+			listed.Name = name
+			buckets = append(buckets, &listed)
+		}
+	}
+	if !middleware.WantsXML(c) {
+		c.JSON(http.StatusOK, buckets)
+		return
+	}
+
+	resp := ListAllMyBucketsResult{
+		Xmlns:   s3Namespace,
+		Owner:   BucketOwner{ID: user.AccessKeyID, DisplayName: user.Username},
+		Buckets: make([]BucketEntry, 0, len(buckets)),
+	}
+	for _, b := range buckets {
+		resp.Buckets = append(resp.Buckets, BucketEntry{Name: b.Name, CreationDate: b.CreatedAt.UTC()})
+	}
+	c.XML(http.StatusOK, resp)
 }
 
 // CreateBucket creates a new bucket
@@ -38,7 +84,7 @@ func (h *BucketHandler) CreateBucket(c *gin.Context) {
 	user := middleware.GetUserFromContext(c)
 
 	if err := h.service.CreateBucket(c.Request.Context(), bucketName, user.Username); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, "Failed to create bucket", err)
 		return
 	}
 
@@ -49,7 +95,7 @@ func (h *BucketHandler) CreateBucket(c *gin.Context) {
 func (h *BucketHandler) DeleteBucket(c *gin.Context) {
 	bucketName := c.Param("bucket")
 	if err := h.service.DeleteBucket(c.Request.Context(), bucketName); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, "Failed to delete bucket", err)
 		return
 	}
 	c.Status(http.StatusNoContent)
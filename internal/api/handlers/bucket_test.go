@@ -11,6 +11,7 @@ import (
 
 	"github.com/danielino/comio/internal/bucket"
 	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 func init() {
@@ -83,12 +84,14 @@ func TestBucketHandler_CreateBucket_Duplicate(t *testing.T) {
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusConflict, w.Code)
 
-	var response map[string]string
+	var response s3.ErrorResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
-	assert.Contains(t, response["error"], "already exists")
+	assert.Equal(t, s3.BucketAlreadyExists, response.Code)
+	assert.Contains(t, response.Message, "already exists")
+	assert.Equal(t, "/test-bucket", response.Resource)
 }
 
 func TestBucketHandler_ListBuckets(t *testing.T) {
@@ -198,6 +201,6 @@ func TestBucketHandler_DeleteBucket_NotFound(t *testing.T) {
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Delete returns 500 if bucket doesn't exist (could be improved to return 404)
-	assert.True(t, w.Code >= 400)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"NoSuchBucket"`)
 }
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// CloneBucket creates the bucket ?target= holding the latest version of
+// every object of a bucket, without copying their data, POST
+// /buckets/:bucket/clone. The clone has the owner, versioning and
+// checksum algorithms of the source; overwrites and deletes in either
+// bucket leave the other as it was.
+func (h *ObjectHandler) CloneBucket(c *gin.Context) {
+	name, target := c.Param("bucket"), c.Query("target")
+	if target == "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "target is required")
+		return
+	}
+	if h.buckets == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "bucket service is not available")
+		return
+	}
+	ctx := c.Request.Context()
+
+	src, err := h.buckets.GetBucket(ctx, name)
+	if err != nil {
+		respondError(c, "Failed to get bucket", err)
+		return
+	}
+	if err := h.buckets.CreateBucket(ctx, target, src.Owner); err != nil {
+		respondError(c, "Failed to create bucket", err)
+		return
+	}
+	result, err := h.cloneInto(c, src, target)
+	if err != nil {
+		// Leave no partial clone behind
+		if _, _, err := h.service.DeleteAllObjects(ctx, target); err != nil {
+			monitoring.Log.Warn("Failed to remove partial bucket clone", zap.String("bucket", target), zap.Error(err))
+		} else if err := h.buckets.DeleteBucket(ctx, target); err != nil {
+			monitoring.Log.Warn("Failed to remove partial bucket clone", zap.String("bucket", target), zap.Error(err))
+		}
+		respondError(c, "Failed to clone bucket", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"bucket":  name,
+		"target":  target,
+		"objects": result.Objects,
+		"bytes":   result.Bytes,
+	})
+}
+
+// cloneInto gives the created target the settings of src and clones the
+// objects of src into it
+func (h *ObjectHandler) cloneInto(c *gin.Context, src *bucket.Bucket, target string) (object.CloneResult, error) {
+	ctx := c.Request.Context()
+	clone, err := h.buckets.GetBucket(ctx, target)
+	if err != nil {
+		return object.CloneResult{}, err
+	}
+	clone.Versioning = src.Versioning
+	clone.ChecksumAlgorithms = src.ChecksumAlgorithms
+	if err := h.buckets.UpdateBucket(ctx, clone); err != nil {
+		return object.CloneResult{}, err
+	}
+	return h.service.CloneBucket(ctx, src.Name, target)
+}
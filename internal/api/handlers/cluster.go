@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/cluster"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ClusterHandler manages the nodes of the ring
+type ClusterHandler struct {
+	ring           *cluster.Ring
+	decommissioner *cluster.Decommissioner
+	rebalancer     *cluster.Rebalancer
+	jobs           *jobs.Manager
+}
+
+func NewClusterHandler(ring *cluster.Ring, decommissioner *cluster.Decommissioner, jobManager *jobs.Manager) *ClusterHandler {
+	return &ClusterHandler{
+		ring:           ring,
+		decommissioner: decommissioner,
+		jobs:           jobManager,
+	}
+}
+
+// ListNodes returns the nodes of the ring and their states
+func (h *ClusterHandler) ListNodes(c *gin.Context) {
+	if h.ring == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": false,
+		})
+		return
+	}
+
+	members, err := h.ring.Members()
+	if err != nil {
+		respondError(c, "Failed to list cluster nodes", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"self":    h.ring.Self(),
+		"nodes":   members,
+	})
+}
+
+// Decommission starts draining the node. A node drains itself, so requests
+// for other nodes are answered with the URL of the node to ask instead.
+func (h *ClusterHandler) Decommission(c *gin.Context) {
+	if h.ring == nil || h.jobs == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "cluster nodes are not configured")
+		return
+	}
+
+	id := c.Param("id")
+	member, err := h.ring.Member(id)
+	if err != nil {
+		respondError(c, "Failed to look up cluster node", err)
+		return
+	}
+	if id != h.ring.Self() {
+		c.JSON(http.StatusMisdirectedRequest, struct {
+			s3.ErrorResponse
+			URL string `json:"url"`
+		}{
+			ErrorResponse: middleware.ErrorBody(c, s3.WrongNode, "decommission must be started on the node being drained"),
+			URL:           member.URL,
+		})
+		return
+	}
+	if member.State == cluster.NodeDecommissioned {
+		middleware.Error(c, http.StatusConflict, s3.InvalidRequest, "node "+id+" is already decommissioned")
+		return
+	}
+
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type:   jobs.TypeDecommission,
+		Key:    id,
+		Params: map[string]string{"node": id},
+	}, h.decommissioner.Run)
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
+
+// SetRebalancer enables rebalancing objects onto added nodes
+func (h *ClusterHandler) SetRebalancer(rebalancer *cluster.Rebalancer) {
+	h.rebalancer = rebalancer
+}
+
+// Rebalance starts moving local objects onto the nodes they are placed on.
+// The fraction and bytes_per_second query parameters override the
+// configured settings for this run.
+func (h *ClusterHandler) Rebalance(c *gin.Context) {
+	if h.rebalancer == nil || h.jobs == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "rebalancing is not available on this node")
+		return
+	}
+
+	config := h.rebalancer.Config()
+	if v := c.Query("fraction"); v != "" {
+		fraction, err := strconv.ParseFloat(v, 64)
+		if err != nil || fraction <= 0 || fraction > 1 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "fraction must be in (0, 1]")
+			return
+		}
+		config.Fraction = fraction
+	}
+	if v := c.Query("bytes_per_second"); v != "" {
+		rate, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || rate < 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "bytes_per_second must be a non-negative integer")
+			return
+		}
+		config.BytesPerSecond = rate
+	}
+
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type: jobs.TypeRebalance,
+		Key:  h.ring.Self(),
+		Params: map[string]string{
+			"fraction":         strconv.FormatFloat(config.Fraction, 'f', -1, 64),
+			"bytes_per_second": strconv.FormatInt(config.BytesPerSecond, 10),
+		},
+	}, func(ctx context.Context, jh *jobs.Handle) error {
+		return h.rebalancer.RunWith(ctx, jh, config)
+	})
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
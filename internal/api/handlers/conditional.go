@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// hasPreconditions reports whether a GET or HEAD carries conditional headers
+func hasPreconditions(r *http.Request) bool {
+	for _, name := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the conditional headers of a GET or HEAD
+// against an object in the order of RFC 7232 section 6, which S3 follows.
+// It returns 0 when the object is to be served, and otherwise the status
+// to answer instead: 412 Precondition Failed or 304 Not Modified. Dates
+// that do not parse are ignored, as the RFC requires.
+func checkPreconditions(r *http.Request, etag string, modifiedAt time.Time) int {
+	// HTTP dates have a resolution of one second
+	modifiedAt = modifiedAt.Truncate(time.Second)
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modifiedAt.After(t) {
+		return http.StatusPreconditionFailed
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, true) {
+			return http.StatusNotModified
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modifiedAt.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagListMatches reports whether an If-Match or If-None-Match header, a
+// list of entity tags or "*", matches an object's ETag. If-None-Match
+// compares weakly, ignoring W/ prefixes; If-Match compares strongly, so
+// weak tags never match.
+func etagListMatches(header, etag string, weak bool) bool {
+	want := strongETag(etag)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// answerPrecondition answers a GET or HEAD whose preconditions stopped it.
+// Not Modified carries the object's validators so caches can refresh the
+// copy they hold.
+func answerPrecondition(c *gin.Context, status int, obj *object.Object) {
+	if status == http.StatusNotModified {
+		c.Header("ETag", strongETag(obj.ETag))
+		c.Header("Last-Modified", obj.ModifiedAt.UTC().Format(http.TimeFormat))
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if c.Request.Method == http.MethodHead {
+		// HEAD responses have no body to carry the error
+		c.Status(status)
+		return
+	}
+	middleware.Error(c, status, s3.PreconditionFailed, "at least one of the preconditions did not hold")
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	same := modified.Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"none", nil, 0},
+		{"if-match", map[string]string{"If-Match": `"abc"`}, 0},
+		{"if-match list", map[string]string{"If-Match": `"x", "abc"`}, 0},
+		{"if-match any", map[string]string{"If-Match": "*"}, 0},
+		{"if-match stale", map[string]string{"If-Match": `"x"`}, http.StatusPreconditionFailed},
+		{"if-match weak", map[string]string{"If-Match": `W/"abc"`}, http.StatusPreconditionFailed},
+		{"if-none-match", map[string]string{"If-None-Match": `"abc"`}, http.StatusNotModified},
+		{"if-none-match weak", map[string]string{"If-None-Match": `W/"abc"`}, http.StatusNotModified},
+		{"if-none-match stale", map[string]string{"If-None-Match": `"x"`}, 0},
+		{"if-modified-since same", map[string]string{"If-Modified-Since": same}, http.StatusNotModified},
+		{"if-modified-since before", map[string]string{"If-Modified-Since": before}, 0},
+		{"if-modified-since invalid", map[string]string{"If-Modified-Since": "yesterday"}, 0},
+		{"if-unmodified-since after", map[string]string{"If-Unmodified-Since": after}, 0},
+		{"if-unmodified-since before", map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed},
+		// If-Match takes precedence over If-Unmodified-Since, and
+		// If-None-Match over If-Modified-Since
+		{"if-match and unmodified", map[string]string{"If-Match": `"abc"`, "If-Unmodified-Since": before}, 0},
+		{"if-none-match and modified", map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": after}, 0},
+		{"if-match then none-match", map[string]string{"If-Match": `"abc"`, "If-None-Match": `"abc"`}, http.StatusNotModified},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/bucket/key", nil)
+		for name, value := range tt.headers {
+			r.Header.Set(name, value)
+		}
+		if got := checkPreconditions(r, "abc", modified); got != tt.want {
+			t.Errorf("%s: checkPreconditions() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// copyObject copies the object an x-amz-copy-source header names to the
+// request's key server-side. Metadata is kept unless the request's
+// x-amz-metadata-directive is REPLACE. With buckets set, the copy must fit
+// in the destination's quota or reservation. Clients asking for XML get an
+// S3 CopyObjectResult, others the new object.
+func copyObject(c *gin.Context, service *object.Service, buckets *bucket.Service, source string) {
+	src, err := parseCopySource(source, "")
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+		return
+	}
+	directive := c.GetHeader("x-amz-metadata-directive")
+	if directive != "" && !strings.EqualFold(directive, "COPY") && !strings.EqualFold(directive, "REPLACE") {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "x-amz-metadata-directive must be COPY or REPLACE")
+		return
+	}
+
+	bucketName, key := c.Param("bucket"), c.Param("key")
+	refund := func() {}
+	if buckets != nil {
+		meta, err := service.HeadObject(c.Request.Context(), src.Bucket, src.Key, src.VersionID)
+		if err != nil {
+			respondError(c, "Failed to read copy source", err)
+			return
+		}
+		var ok bool
+		if refund, ok = checkSpace(c, buckets, bucketName, meta.Size); !ok {
+			return
+		}
+	}
+
+	obj, err := service.CopyObject(actorContext(c), src.Bucket, src.Key, src.VersionID, bucketName, key,
+		strings.EqualFold(directive, "REPLACE"), c.GetHeader("Content-Type"), objectMetadata(c))
+	if err != nil {
+		refund()
+		respondError(c, "Failed to copy object", err)
+		return
+	}
+
+	if obj.VersionID != "" {
+		c.Header("x-amz-version-id", obj.VersionID)
+	}
+	if src.VersionID != nil {
+		c.Header("x-amz-copy-source-version-id", *src.VersionID)
+	}
+	setEncryptionHeader(c, obj)
+	if !middleware.WantsXML(c) {
+		c.JSON(http.StatusOK, newObjectInfo(obj))
+		return
+	}
+	c.XML(http.StatusOK, CopyObjectResult{
+		Xmlns:        s3Namespace,
+		ETag:         strongETag(obj.ETag),
+		LastModified: obj.ModifiedAt.UTC(),
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// maxDeleteObjects is the most keys a DeleteObjects request may name
+const maxDeleteObjects = 1000
+
+// maxDeleteRequestSize bounds the body of a DeleteObjects request: room
+// for maxDeleteObjects keys of the longest length and their markup
+const maxDeleteRequestSize = maxDeleteObjects * 2 * 1024
+
+// deleteRequest is the body of a DeleteObjects request
+type deleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+// DeleteResult is the response to DeleteObjects
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult" json:"-"`
+	Xmlns   string          `xml:"xmlns,attr" json:"-"`
+	Deleted []DeletedObject `xml:"Deleted" json:"deleted"`
+	Errors  []DeleteError   `xml:"Error" json:"errors"`
+}
+
+// DeletedObject is a key removed by DeleteObjects. Keys of buckets keeping
+// delete markers carry the marker that now hides them.
+type DeletedObject struct {
+	Key                   string `xml:"Key" json:"key"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty" json:"delete_marker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty" json:"delete_marker_version_id,omitempty"`
+}
+
+// DeleteError is a key DeleteObjects failed to remove
+type DeleteError struct {
+	Key     string       `xml:"Key" json:"key"`
+	Code    s3.ErrorCode `xml:"Code" json:"code"`
+	Message string       `xml:"Message" json:"message"`
+}
+
+// deleteObjects deletes the keys of an S3 Delete document from the
+// request's bucket, up to maxDeleteObjects of them, each as DeleteObject
+// would. Keys that do not exist
+// count as deleted, as they do for S3, and keys a scoped access key may
+// not delete are reported as errors rather than failing the request.
+// Quiet requests only hear about errors. Clients asking for XML get an S3
+// DeleteResult, others the same as JSON.
+func deleteObjects(c *gin.Context, service *object.Service) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxDeleteRequestSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			middleware.Error(c, http.StatusRequestEntityTooLarge, s3.EntityTooLarge, "delete request body is too large")
+			return
+		}
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+	var req deleteRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.MalformedXML, "malformed delete request: "+err.Error())
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		middleware.Error(c, http.StatusBadRequest, s3.MalformedXML, "a delete request names between 1 and 1000 keys")
+		return
+	}
+
+	bucket := c.Param("bucket")
+	resp := DeleteResult{Xmlns: s3Namespace, Deleted: []DeletedObject{}, Errors: []DeleteError{}}
+	for _, o := range req.Objects {
+		if !middleware.Allowed(c, auth.ActionDelete, bucket, o.Key) {
+			resp.Errors = append(resp.Errors, DeleteError{Key: o.Key, Code: s3.AccessDenied, Message: "access denied: outside the scope of this access key"})
+			continue
+		}
+		marker, err := removeObject(c, service, bucket, o.Key)
+		if err != nil && !errors.Is(err, object.ErrObjectNotFound) {
+			_, code := serviceError(err)
+			resp.Errors = append(resp.Errors, DeleteError{Key: o.Key, Code: code, Message: err.Error()})
+			continue
+		}
+		if req.Quiet {
+			continue
+		}
+		deleted := DeletedObject{Key: o.Key}
+		if marker != nil {
+			deleted.DeleteMarker = true
+			deleted.DeleteMarkerVersionID = marker.VersionID
+		}
+		resp.Deleted = append(resp.Deleted, deleted)
+	}
+
+	if !middleware.WantsXML(c) {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.XML(http.StatusOK, resp)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// encodingTypeURL is the only encoding-type S3 list operations accept.
+// Keys are then returned URL-encoded, as XML cannot carry every character
+// a key may contain, such as control characters.
+const encodingTypeURL = "url"
+
+// listEncoding returns how keys, prefixes and markers in a listing are
+// encoded for the request's encoding-type, and the type to report. It
+// responds with an error and returns false for unknown types.
+func listEncoding(c *gin.Context) (func(string) string, string, bool) {
+	switch c.Query("encoding-type") {
+	case "":
+		return func(s string) string { return s }, "", true
+	case encodingTypeURL:
+		// Encoded as SDKs expect, reversible with query unescaping
+		return url.QueryEscape, encodingTypeURL, true
+	default:
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "Invalid Encoding Method specified in Request")
+		return nil, "", false
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListEncoding_URL(t *testing.T) {
+	router, objectService := setupMultipartTest()
+	registry := NewRegistryHandler(objectService)
+	router.GET("/registry/:bucket", registry.ListObjects)
+
+	// A control character cannot be carried by XML as is
+	key := "dir/café \x01&<.txt"
+	_, err := objectService.PutObject(context.Background(), "test-bucket", key, strings.NewReader("x"), 1, "text/plain")
+	assert.NoError(t, err)
+
+	w := serve(router, "GET", "/registry/test-bucket?encoding-type=url&prefix=dir/", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list ListBucketResult
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, "url", list.EncodingType)
+	assert.Equal(t, "dir%2F", list.Prefix)
+	if assert.Len(t, list.Contents, 1) {
+		decoded, err := url.QueryUnescape(list.Contents[0].Key)
+		assert.NoError(t, err)
+		assert.Equal(t, key, decoded)
+	}
+
+	// Uploads in progress are listed the same way
+	w = serve(router, "POST", "/test-bucket/a%20b?uploads", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "GET", "/test-bucket?uploads&encoding-type=url", "", http.Header{"Accept": {"application/xml"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var uploads ListMultipartUploadsResult
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &uploads))
+	assert.Equal(t, "url", uploads.EncodingType)
+	if assert.Len(t, uploads.Uploads, 1) {
+		assert.Equal(t, "a+b", uploads.Uploads[0].Key)
+	}
+
+	for _, path := range []string{"/registry/test-bucket?encoding-type=base64", "/test-bucket?uploads&encoding-type=URL"} {
+		w = serve(router, "GET", path, "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// EncryptionHandler reports on and rotates server-side encryption keys
+type EncryptionHandler struct {
+	keys    *encryption.Keyring
+	buckets *bucket.Service
+	objects *object.Service
+	jobs    *jobs.Manager
+}
+
+func NewEncryptionHandler(keys *encryption.Keyring, buckets *bucket.Service, objects *object.Service, jobManager *jobs.Manager) *EncryptionHandler {
+	return &EncryptionHandler{
+		keys:    keys,
+		buckets: buckets,
+		objects: objects,
+		jobs:    jobManager,
+	}
+}
+
+// GetStatus returns the master key versions and how many objects use each
+func (h *EncryptionHandler) GetStatus(c *gin.Context) {
+	if h.keys == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": false,
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	names, err := h.bucketNames(ctx)
+	if err != nil {
+		respondError(c, "Failed to list buckets", err)
+		return
+	}
+	usage, err := h.objects.KeyUsage(ctx, names)
+	if err != nil {
+		respondError(c, "Failed to count key usage", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":        true,
+		"active_version": h.keys.ActiveVersion(),
+		"key_versions":   h.keys.Versions(),
+		"objects":        usage,
+	})
+}
+
+// Rewrap starts re-wrapping the data keys of objects on old master key
+// versions with the active one
+func (h *EncryptionHandler) Rewrap(c *gin.Context) {
+	if h.keys == nil || h.jobs == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "server-side encryption is not enabled")
+		return
+	}
+
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type: jobs.TypeRewrap,
+		Key:  "encryption",
+	}, func(ctx context.Context, jh *jobs.Handle) error {
+		names, err := h.bucketNames(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = h.objects.RewrapObjects(ctx, names, func(status object.RewrapStatus) {
+			jh.SetProgress(jobs.Progress{
+				Total: status.Total,
+				Done:  status.Rewrapped + status.Skipped + status.Failed,
+			})
+		})
+		return err
+	})
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
+
+func (h *EncryptionHandler) bucketNames(ctx context.Context) ([]string, error) {
+	buckets, err := h.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(buckets))
+	for _, b := range buckets {
+		names = append(names, b.Name)
+	}
+	return names, nil
+}
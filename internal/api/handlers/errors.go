@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/billing"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/bufpool"
+	"github.com/danielino/comio/internal/cluster"
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/export"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/nfs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/internal/scheduler"
+	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// serviceErrors maps service errors to their status and error code
+var serviceErrors = []struct {
+	err    error
+	status int
+	code   s3.ErrorCode
+}{
+	{bucket.ErrBucketNotFound, http.StatusNotFound, s3.NoSuchBucket},
+	{bucket.ErrBucketExists, http.StatusConflict, s3.BucketAlreadyExists},
+	{bucket.ErrBucketNotEmpty, http.StatusConflict, s3.BucketNotEmpty},
+	{bucket.ErrInvalidBucketName, http.StatusBadRequest, s3.InvalidBucketName},
+	{bucket.ErrQuotaExceeded, http.StatusForbidden, s3.QuotaExceeded},
+	{bucket.ErrNamespaceConflict, http.StatusConflict, s3.BucketAlreadyExists},
+	{bucket.ErrOwnerMismatch, http.StatusForbidden, s3.AccessDenied},
+	{bucket.ErrReservationNotFound, http.StatusNotFound, s3.NoSuchReservation},
+	{bucket.ErrReservationExceeded, http.StatusForbidden, s3.QuotaExceeded},
+	{bucket.ErrInvalidReservation, http.StatusBadRequest, s3.InvalidArgument},
+	{bucket.ErrInsufficientCapacity, http.StatusInsufficientStorage, s3.InsufficientStorage},
+	{bucket.ErrVersioningMismatch, http.StatusConflict, s3.InvalidBucketState},
+	{object.ErrVersionNotFound, http.StatusNotFound, s3.NoSuchVersion},
+	{object.ErrObjectNotFound, http.StatusNotFound, s3.NoSuchKey},
+	{object.ErrObjectChanged, http.StatusConflict, s3.OperationAborted},
+	{object.ErrInvalidRange, http.StatusRequestedRangeNotSatisfiable, s3.InvalidRange},
+	{object.ErrDirectoryMarkerData, http.StatusBadRequest, s3.InvalidArgument},
+	{object.ErrMoveToSelf, http.StatusBadRequest, s3.InvalidArgument},
+	{object.ErrCopyToSelf, http.StatusBadRequest, s3.InvalidRequest},
+	{object.ErrHistoryDisabled, http.StatusNotImplemented, s3.NotImplemented},
+	{object.ErrPrefixStatsDisabled, http.StatusNotImplemented, s3.NotImplemented},
+	{object.ErrSearchDisabled, http.StatusNotImplemented, s3.NotImplemented},
+	{object.ErrNotReplicated, http.StatusConflict, s3.NotConfigured},
+	{object.ErrInvalidSearch, http.StatusBadRequest, s3.InvalidArgument},
+	{object.ErrPreconditionFailed, http.StatusPreconditionFailed, s3.PreconditionFailed},
+	{object.ErrPatchBaseMismatch, http.StatusConflict, s3.PreconditionFailed},
+	{object.ErrPatchChecksum, http.StatusUnprocessableEntity, s3.BadDigest},
+	{replication.ErrInvalidDelta, http.StatusUnprocessableEntity, s3.InvalidRequest},
+	{replication.ErrSnapshotNotFound, http.StatusNotFound, s3.NoSuchSnapshot},
+	{object.ErrSnapshotReleased, http.StatusNotFound, s3.NoSuchSnapshot},
+	{multipart.ErrUploadNotFound, http.StatusNotFound, s3.NoSuchUpload},
+	{multipart.ErrCopySourceNotFound, http.StatusNotFound, s3.NoSuchKey},
+	{multipart.ErrInvalidPart, http.StatusBadRequest, s3.InvalidPart},
+	{multipart.ErrInvalidPartOrder, http.StatusBadRequest, s3.InvalidPartOrder},
+	{multipart.ErrInvalidPartNumber, http.StatusBadRequest, s3.InvalidArgument},
+	{multipart.ErrTooManyParts, http.StatusBadRequest, s3.InvalidArgument},
+	{multipart.ErrEntityTooSmall, http.StatusBadRequest, s3.EntityTooSmall},
+	{multipart.ErrInvalidCopyRange, http.StatusRequestedRangeNotSatisfiable, s3.InvalidRange},
+	{multipart.ErrSessionNotFound, http.StatusNotFound, s3.NoSuchSession},
+	{multipart.ErrInvalidSessionSize, http.StatusBadRequest, s3.InvalidArgument},
+	{multipart.ErrOffsetMismatch, http.StatusConflict, s3.OffsetMismatch},
+	{multipart.ErrChunkOutOfRange, http.StatusBadRequest, s3.InvalidArgument},
+	{multipart.ErrIncompleteChunk, http.StatusBadRequest, s3.IncompleteBody},
+	{multipart.ErrSessionBusy, http.StatusConflict, s3.OperationAborted},
+	{multipart.ErrSessionIncomplete, http.StatusBadRequest, s3.IncompleteBody},
+	{integrity.ErrChecksumMismatch, http.StatusBadRequest, s3.BadDigest},
+	{integrity.ErrUnsupportedAlgorithm, http.StatusBadRequest, s3.InvalidRequest},
+	{storage.ErrDeviceUnhealthy, http.StatusServiceUnavailable, s3.ServiceUnavailable},
+	{storage.ErrInsufficientStorage, http.StatusInsufficientStorage, s3.InsufficientStorage},
+	{storage.ErrRestoring, http.StatusServiceUnavailable, s3.ServiceUnavailable},
+	{encryption.ErrKeysUnavailable, http.StatusServiceUnavailable, s3.ServiceUnavailable},
+	{database.ErrOverloaded, http.StatusServiceUnavailable, s3.SlowDown},
+	{jobs.ErrDuplicate, http.StatusConflict, s3.JobAlreadyRunning},
+	{jobs.ErrQueueFull, http.StatusServiceUnavailable, s3.ServiceUnavailable},
+	{jobs.ErrNotFound, http.StatusNotFound, s3.NoSuchJob},
+	{jobs.ErrFinished, http.StatusConflict, s3.JobAlreadyFinished},
+	{cluster.ErrUnknownNode, http.StatusNotFound, s3.NoSuchNode},
+	{scheduler.ErrScheduleNotFound, http.StatusNotFound, s3.NoSuchSchedule},
+	{scheduler.ErrUnknownTask, http.StatusNotFound, s3.NoSuchTask},
+	{nfs.ErrExportNotFound, http.StatusNotFound, s3.NoSuchExport},
+	{export.ErrNotFound, http.StatusNotFound, s3.NoSuchExport},
+	{export.ErrCompleted, http.StatusConflict, s3.JobAlreadyFinished},
+	{export.ErrObjectChanged, http.StatusConflict, s3.OperationAborted},
+	{auth.ErrScopeEscalation, http.StatusForbidden, s3.AccessDenied},
+	{auth.ErrInvalidPostPolicy, http.StatusBadRequest, s3.InvalidPolicyDocument},
+	{auth.ErrPostPolicyExpired, http.StatusForbidden, s3.AccessDenied},
+	{auth.ErrPostPolicyViolated, http.StatusForbidden, s3.AccessDenied},
+	{auth.ErrPostSignature, http.StatusForbidden, s3.SignatureDoesNotMatch},
+	{bufpool.ErrUnknownPool, http.StatusBadRequest, s3.InvalidArgument},
+	{bufpool.ErrInvalidSize, http.StatusBadRequest, s3.InvalidArgument},
+	{billing.ErrFutureMonth, http.StatusBadRequest, s3.InvalidArgument},
+	{billing.ErrNoBucket, http.StatusConflict, s3.NotConfigured},
+}
+
+// serviceError returns the status and error code of a service error,
+// InternalError for errors not in the table
+func serviceError(err error) (int, s3.ErrorCode) {
+	for _, e := range serviceErrors {
+		if errors.Is(err, e.err) {
+			return e.status, e.code
+		}
+	}
+	return http.StatusInternalServerError, s3.InternalError
+}
+
+// respondError maps service errors onto error responses carrying the
+// code clients branch on. Internal errors are logged with msg.
+func respondError(c *gin.Context, msg string, err error) {
+	status, code := serviceError(err)
+	if status == http.StatusInternalServerError {
+		monitoring.Log.Error(msg,
+			zap.String("bucket", c.Param("bucket")),
+			zap.String("key", c.Param("key")),
+			zap.String("request_id", middleware.GetRequestID(c)),
+			zap.Error(err))
+	}
+	middleware.Error(c, status, code, err.Error())
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+func TestServiceError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   s3.ErrorCode
+	}{
+		{object.ErrObjectNotFound, http.StatusNotFound, s3.NoSuchKey},
+		{fmt.Errorf("%w: %q contains 3 objects", bucket.ErrBucketNotEmpty, "b"), http.StatusConflict, s3.BucketNotEmpty},
+		{fmt.Errorf("purge %q: %w", "b", jobs.ErrDuplicate), http.StatusConflict, s3.JobAlreadyRunning},
+		{jobs.ErrQueueFull, http.StatusServiceUnavailable, s3.ServiceUnavailable},
+		{fmt.Errorf("disk on fire"), http.StatusInternalServerError, s3.InternalError},
+	}
+	for _, tt := range tests {
+		status, code := serviceError(tt.err)
+		assert.Equal(t, tt.status, status, tt.err.Error())
+		assert.Equal(t, tt.code, code, tt.err.Error())
+	}
+}
+
+func TestRespondError_Body(t *testing.T) {
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.GET("/:bucket/:key", func(c *gin.Context) {
+		respondError(c, "Failed to get object", object.ErrObjectNotFound)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/photos/cat.jpg", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var body s3.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, s3.NoSuchKey, body.Code)
+	assert.Equal(t, "object not found", body.Message)
+	assert.Equal(t, "/photos/cat.jpg", body.Resource)
+	assert.NotEmpty(t, body.RequestID)
+	assert.Equal(t, w.Header().Get(middleware.RequestIDHeader), body.RequestID)
+
+	// S3 clients get the XML error document
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/photos/cat.jpg", nil)
+	req.Header.Set("Accept", "application/xml")
+	router.ServeHTTP(w, req)
+	var xmlBody s3.ErrorResponse
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &xmlBody))
+	assert.Equal(t, s3.NoSuchKey, xmlBody.Code)
+	assert.NotEmpty(t, xmlBody.RequestID)
+}
+
+func TestBucketHandler_DeleteBucket_NotEmpty(t *testing.T) {
+	objectRepo := object.NewMemoryRepository()
+	bucketService := bucket.NewService(bucket.NewMemoryRepository())
+	bucketService.SetObjectCounter(objectRepo)
+	objectService := object.NewService(objectRepo, newMockEngine())
+
+	ctx := context.Background()
+	err := bucketService.CreateBucket(ctx, "full-bucket", "owner")
+	assert.NoError(t, err)
+	_, err = objectService.PutObject(ctx, "full-bucket", "key", strings.NewReader("data"), 4, "text/plain")
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.DELETE("/:bucket", NewBucketHandler(bucketService).DeleteBucket)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/full-bucket", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	var body s3.ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, s3.BucketNotEmpty, body.Code)
+}
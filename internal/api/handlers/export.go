@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/export"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ExportHandler manages archive exports of buckets with signed manifests
+type ExportHandler struct {
+	exporter *export.Exporter
+	buckets  *bucket.Service
+	jobs     *jobs.Manager
+	rate     int64 // Default read rate of exports, bytes per second
+}
+
+func NewExportHandler(exporter *export.Exporter, buckets *bucket.Service, jobManager *jobs.Manager, bytesPerSecond int64) *ExportHandler {
+	return &ExportHandler{
+		exporter: exporter,
+		buckets:  buckets,
+		jobs:     jobManager,
+		rate:     bytesPerSecond,
+	}
+}
+
+// exportRequest is the optional body of creating or resuming an export
+type exportRequest struct {
+	Prefix         string `json:"prefix"`
+	BytesPerSecond *int64 `json:"bytes_per_second"` // Overrides export.bytes_per_second; 0 is unlimited
+}
+
+// CreateExport starts exporting the objects of a bucket, or of a key
+// prefix of it, to an archive in a background job
+func (h *ExportHandler) CreateExport(c *gin.Context) {
+	req, ok := h.bindRequest(c)
+	if !ok {
+		return
+	}
+	name := c.Param("bucket")
+	if _, err := h.buckets.GetBucket(c.Request.Context(), name); err != nil {
+		respondError(c, "Failed to get bucket", err)
+		return
+	}
+
+	exp, err := h.exporter.Create(name, req.Prefix)
+	if err != nil {
+		respondError(c, "Failed to create export", err)
+		return
+	}
+	h.submit(c, exp, req)
+}
+
+// ResumeExport continues an interrupted export from its last checkpoint
+func (h *ExportHandler) ResumeExport(c *gin.Context) {
+	req, ok := h.bindRequest(c)
+	if !ok {
+		return
+	}
+	exp, err := h.exporter.Get(c.Param("id"))
+	if err != nil {
+		respondError(c, "Failed to get export", err)
+		return
+	}
+	if exp.Status == export.StatusCompleted {
+		respondError(c, "Failed to resume export", export.ErrCompleted)
+		return
+	}
+	h.submit(c, exp, req)
+}
+
+// ListExports lists the exports, newest first
+func (h *ExportHandler) ListExports(c *gin.Context) {
+	exports, err := h.exporter.List()
+	if err != nil {
+		respondError(c, "Failed to list exports", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// GetExport returns the progress of an export
+func (h *ExportHandler) GetExport(c *gin.Context) {
+	exp, err := h.exporter.Get(c.Param("id"))
+	if err != nil {
+		respondError(c, "Failed to get export", err)
+		return
+	}
+	c.JSON(http.StatusOK, exp)
+}
+
+// DownloadExport streams the archive, manifest or signature of a
+// completed export
+func (h *ExportHandler) DownloadExport(c *gin.Context) {
+	id, name := c.Param("id"), c.Param("file")
+	path, err := h.exporter.File(id, name)
+	if err != nil {
+		respondError(c, "Failed to get export file", err)
+		return
+	}
+	c.FileAttachment(path, fmt.Sprintf("%s-%s", id, name))
+}
+
+func (h *ExportHandler) bindRequest(c *gin.Context) (exportRequest, bool) {
+	var req exportRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+			return req, false
+		}
+	}
+	if req.BytesPerSecond != nil && *req.BytesPerSecond < 0 {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "bytes_per_second must not be negative")
+		return req, false
+	}
+	if h.jobs == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "background jobs are not enabled")
+		return req, false
+	}
+	return req, true
+}
+
+// submit runs an export in a background job, one per export at a time
+func (h *ExportHandler) submit(c *gin.Context, exp *export.Export, req exportRequest) {
+	rate := h.rate
+	if req.BytesPerSecond != nil {
+		rate = *req.BytesPerSecond
+	}
+	// The job updates exp as it runs
+	accepted := *exp
+
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type:   jobs.TypeExport,
+		Key:    exp.ID,
+		Params: map[string]string{"bucket": exp.Bucket, "prefix": exp.Prefix},
+	}, func(ctx context.Context, jh *jobs.Handle) error {
+		return h.exporter.Run(ctx, exp, rate, func(exp *export.Export) {
+			jh.SetProgress(jobs.Progress{
+				Total: int64(exp.Objects),
+				Done:  int64(exp.Done),
+				Bytes: exp.ArchiveSize,
+			})
+		})
+	})
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"export": &accepted,
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/graphql"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+const (
+	// defaultGraphQLPage is the page size of connections without first
+	defaultGraphQLPage = 100
+	// maxGraphQLPage caps the page size of connections
+	maxGraphQLPage = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// GraphQLHandler serves a read-only GraphQL view of buckets, objects,
+// usage, replication and jobs, so dashboards can fetch what they show in
+// one request
+type GraphQLHandler struct {
+	schema     *graphql.Schema
+	buckets    *bucket.Service
+	objects    *object.Service
+	engine     storage.Engine
+	replicator *replication.Replicator
+	jobs       *jobs.Manager
+}
+
+// NewGraphQLHandler creates the handler. The replicator and job manager may
+// be nil when those features are disabled.
+func NewGraphQLHandler(buckets *bucket.Service, objects *object.Service, engine storage.Engine, replicator *replication.Replicator, jobManager *jobs.Manager) *GraphQLHandler {
+	h := &GraphQLHandler{
+		buckets:    buckets,
+		objects:    objects,
+		engine:     engine,
+		replicator: replicator,
+		jobs:       jobManager,
+	}
+	schema, err := graphql.NewSchema(h.queryType())
+	if err != nil {
+		// The schema is fixed; an error is a bug the tests catch
+		panic(fmt.Sprintf("invalid GraphQL schema: %v", err))
+	}
+	h.schema = schema
+	return h
+}
+
+// Query executes a query POSTed as JSON
+func (h *GraphQLHandler) Query(c *gin.Context) {
+	var req graphql.Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+	if req.Query == "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "query is required")
+		return
+	}
+
+	resp := h.schema.Execute(c.Request.Context(), req)
+	status := http.StatusOK
+	if resp.Data == nil {
+		// The query could not be parsed or validated
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, resp)
+}
+
+// Schema returns the schema in the GraphQL schema definition language
+func (h *GraphQLHandler) Schema(c *gin.Context) {
+	c.String(http.StatusOK, h.schema.SDL())
+}
+
+// resolve builds a field read from a source of type T
+func resolve[T any](name string, typ graphql.Type, get func(T) any) *graphql.Field {
+	return &graphql.Field{
+		Name: name,
+		Type: typ,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return get(p.Source.(T)), nil
+		},
+	}
+}
+
+func nonNull(t graphql.Type) graphql.Type {
+	return &graphql.NonNull{Of: t}
+}
+
+func listOf(t graphql.Type) graphql.Type {
+	return &graphql.NonNull{Of: &graphql.List{Of: &graphql.NonNull{Of: t}}}
+}
+
+// pageArgs are the arguments of connections: a page size and the cursor
+// of the page before
+func pageArgs(args ...*graphql.Argument) []*graphql.Argument {
+	return append(args,
+		&graphql.Argument{Name: "first", Type: graphql.Int, Default: defaultGraphQLPage},
+		&graphql.Argument{Name: "after", Type: graphql.String},
+	)
+}
+
+// page reads the page size and the position after which a page starts
+func page(args map[string]any) (int, string, error) {
+	first := args["first"].(int)
+	if first < 1 {
+		return 0, "", errors.New("first must be at least 1")
+	}
+	first = min(first, maxGraphQLPage)
+
+	after, _ := args["after"].(string)
+	if after == "" {
+		return first, "", nil
+	}
+	position, err := base64.RawURLEncoding.DecodeString(after)
+	if err != nil || len(position) == 0 {
+		return 0, "", errInvalidCursor
+	}
+	return first, string(position), nil
+}
+
+// connection is a page of a list
+type connection struct {
+	nodes      any
+	totalCount int
+	endCursor  string // Position of the last node when more follow, else empty
+	prefixes   []string
+}
+
+func cursor(position string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// pageInfoType is shared by every connection type
+var pageInfoType = &graphql.Object{
+	Name: "PageInfo",
+	Fields: []*graphql.Field{
+		resolve("hasNextPage", nonNull(graphql.Boolean), func(c *connection) any { return c.endCursor != "" }),
+		{
+			Name:        "endCursor",
+			Description: "Passed as after for the next page; null on the last page",
+			Type:        graphql.String,
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				if c := p.Source.(*connection); c.endCursor != "" {
+					return cursor(c.endCursor), nil
+				}
+				return nil, nil
+			},
+		},
+	},
+}
+
+func connectionType(name string, node graphql.Type, extra ...*graphql.Field) *graphql.Object {
+	return &graphql.Object{
+		Name: name,
+		Fields: append([]*graphql.Field{
+			resolve("nodes", listOf(node), func(c *connection) any { return c.nodes }),
+			resolve("pageInfo", nonNull(pageInfoType), func(c *connection) any { return c }),
+		}, extra...),
+	}
+}
+
+func totalCountField() *graphql.Field {
+	return resolve("totalCount", nonNull(graphql.Int), func(c *connection) any { return c.totalCount })
+}
+
+type keyValue struct {
+	name, value string
+}
+
+func keyValueType(name string) *graphql.Object {
+	return &graphql.Object{
+		Name: name,
+		Fields: []*graphql.Field{
+			resolve("name", nonNull(graphql.String), func(kv keyValue) any { return kv.name }),
+			resolve("value", nonNull(graphql.String), func(kv keyValue) any { return kv.value }),
+		},
+	}
+}
+
+// keyValues lists a map in key order
+func keyValues(m map[string]string) []keyValue {
+	entries := make([]keyValue, 0, len(m))
+	for name, value := range m {
+		entries = append(entries, keyValue{name, value})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries
+}
+
+// optionalTime is null for unset times
+func optionalTime(t *time.Time) any {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return *t
+}
+
+func (h *GraphQLHandler) queryType() *graphql.Object {
+	objectType := &graphql.Object{
+		Name:        "Object",
+		Description: "The latest version of an object",
+		Fields: []*graphql.Field{
+			resolve("key", nonNull(graphql.String), func(o *object.Object) any { return o.Key }),
+			resolve("versionId", nonNull(graphql.String), func(o *object.Object) any { return o.VersionID }),
+			resolve("size", nonNull(graphql.Long), func(o *object.Object) any { return o.Size }),
+			resolve("etag", nonNull(graphql.String), func(o *object.Object) any { return o.ETag }),
+			resolve("contentType", nonNull(graphql.String), func(o *object.Object) any { return o.ContentType }),
+			resolve("storageClass", nonNull(graphql.String), func(o *object.Object) any { return o.StorageClass }),
+			resolve("lastModified", nonNull(graphql.Time), func(o *object.Object) any { return o.ModifiedAt }),
+			resolve("metadata", listOf(keyValueType("MetadataEntry")), func(o *object.Object) any { return keyValues(o.Metadata) }),
+		},
+	}
+	objectConnection := connectionType("ObjectConnection", objectType,
+		resolve("commonPrefixes", listOf(graphql.String), func(c *connection) any { return c.prefixes }))
+
+	quotaType := &graphql.Object{
+		Name: "Quota",
+		Fields: []*graphql.Field{
+			resolve("maxSize", nonNull(graphql.Long), func(q *bucket.Quota) any { return q.MaxSize }),
+			resolve("maxObjects", nonNull(graphql.Long), func(q *bucket.Quota) any { return q.MaxObjects }),
+		},
+	}
+	bucketUsageType := &graphql.Object{
+		Name: "BucketUsage",
+		Fields: []*graphql.Field{
+			resolve("bucket", nonNull(graphql.String), func(u bucketUsage) any { return u.bucket }),
+			resolve("objects", nonNull(graphql.Long), func(u bucketUsage) any { return u.objects }),
+			resolve("bytes", nonNull(graphql.Long), func(u bucketUsage) any { return u.bytes }),
+		},
+	}
+	bucketType := &graphql.Object{
+		Name: "Bucket",
+		Fields: []*graphql.Field{
+			resolve("name", nonNull(graphql.String), func(b *bucket.Bucket) any { return b.Name }),
+			resolve("owner", nonNull(graphql.String), func(b *bucket.Bucket) any { return b.Owner }),
+			resolve("createdAt", nonNull(graphql.Time), func(b *bucket.Bucket) any { return b.CreatedAt }),
+			resolve("versioning", nonNull(graphql.String), func(b *bucket.Bucket) any { return b.Versioning }),
+			resolve("replicated", nonNull(graphql.Boolean), func(b *bucket.Bucket) any { return !b.ReplicationDisabled }),
+			resolve("quota", quotaType, func(b *bucket.Bucket) any { return b.Quota }),
+			{
+				Name:        "usage",
+				Description: "Count and size of the latest object versions",
+				Type:        nonNull(bucketUsageType),
+				Resolve:     h.bucketUsage,
+			},
+			{
+				Name: "objects",
+				Type: nonNull(objectConnection),
+				Args: pageArgs(
+					&graphql.Argument{Name: "prefix", Type: graphql.String},
+					&graphql.Argument{Name: "delimiter", Type: graphql.String},
+				),
+				Resolve: h.listObjects,
+			},
+		},
+	}
+
+	usageType := &graphql.Object{
+		Name: "Usage",
+		Fields: []*graphql.Field{
+			resolve("totalBytes", nonNull(graphql.Long), func(s storage.Stats) any { return s.TotalBytes }),
+			resolve("usedBytes", nonNull(graphql.Long), func(s storage.Stats) any { return s.UsedBytes }),
+			resolve("freeBytes", nonNull(graphql.Long), func(s storage.Stats) any { return s.FreeBytes }),
+			{
+				Name:        "buckets",
+				Description: "Object counts and sizes of every bucket",
+				Type:        listOf(bucketUsageType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return h.allBucketUsage(p.Context)
+				},
+			},
+		},
+	}
+	replicationType := &graphql.Object{
+		Name: "Replication",
+		Fields: []*graphql.Field{
+			resolve("enabled", nonNull(graphql.Boolean), func(r replicationStatus) any { return r.Replicator != nil }),
+			resolve("target", graphql.String, func(r replicationStatus) any {
+				if r.Replicator == nil {
+					return nil
+				}
+				return r.Target()
+			}),
+			resolve("eventsQueued", nonNull(graphql.Long), func(r replicationStatus) any { return replicationStats(r).EventsQueued }),
+			resolve("eventsReplicated", nonNull(graphql.Long), func(r replicationStatus) any { return replicationStats(r).EventsReplicated }),
+			resolve("eventsFailed", nonNull(graphql.Long), func(r replicationStatus) any { return replicationStats(r).EventsFailed }),
+			resolve("queueLength", nonNull(graphql.Int), func(r replicationStatus) any {
+				if r.Replicator == nil {
+					return 0
+				}
+				return r.QueueLength()
+			}),
+			resolve("lagSeconds", nonNull(graphql.Float), func(r replicationStatus) any { return replicationStats(r).Lag.Seconds() }),
+			resolve("lastReplication", graphql.Time, func(r replicationStatus) any {
+				last := replicationStats(r).LastReplication
+				return optionalTime(&last)
+			}),
+			resolve("circuitBreaker", graphql.String, func(r replicationStatus) any {
+				if r.Replicator == nil {
+					return nil
+				}
+				return r.GetCircuitBreakerStats().State
+			}),
+		},
+	}
+
+	progressType := &graphql.Object{
+		Name: "JobProgress",
+		Fields: []*graphql.Field{
+			resolve("total", nonNull(graphql.Long), func(p jobs.Progress) any { return p.Total }),
+			resolve("done", nonNull(graphql.Long), func(p jobs.Progress) any { return p.Done }),
+			resolve("bytes", nonNull(graphql.Long), func(p jobs.Progress) any { return p.Bytes }),
+			resolve("message", graphql.String, func(p jobs.Progress) any { return optionalString(p.Message) }),
+		},
+	}
+	jobType := &graphql.Object{
+		Name: "Job",
+		Fields: []*graphql.Field{
+			resolve("id", nonNull(graphql.ID), func(j jobs.Job) any { return j.ID }),
+			resolve("type", nonNull(graphql.String), func(j jobs.Job) any { return j.Type }),
+			resolve("key", graphql.String, func(j jobs.Job) any { return optionalString(j.Key) }),
+			resolve("state", nonNull(graphql.String), func(j jobs.Job) any { return j.State }),
+			resolve("params", listOf(keyValueType("JobParam")), func(j jobs.Job) any { return keyValues(j.Params) }),
+			resolve("progress", nonNull(progressType), func(j jobs.Job) any { return j.Progress }),
+			resolve("error", graphql.String, func(j jobs.Job) any { return optionalString(j.Error) }),
+			resolve("createdAt", nonNull(graphql.Time), func(j jobs.Job) any { return j.CreatedAt }),
+			resolve("startedAt", graphql.Time, func(j jobs.Job) any { return optionalTime(j.StartedAt) }),
+			resolve("finishedAt", graphql.Time, func(j jobs.Job) any { return optionalTime(j.FinishedAt) }),
+		},
+	}
+
+	return &graphql.Object{
+		Name: "Query",
+		Fields: []*graphql.Field{
+			{
+				Name:        "buckets",
+				Description: "Buckets in name order",
+				Type:        nonNull(connectionType("BucketConnection", bucketType, totalCountField())),
+				Args:        pageArgs(&graphql.Argument{Name: "prefix", Type: graphql.String}),
+				Resolve:     h.listBuckets,
+			},
+			{
+				Name: "bucket",
+				Type: bucketType,
+				Args: []*graphql.Argument{{Name: "name", Type: nonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					b, err := h.buckets.GetBucket(p.Context, p.Args["name"].(string))
+					if errors.Is(err, bucket.ErrBucketNotFound) {
+						return nil, nil
+					}
+					return b, err
+				},
+			},
+			{
+				Name:        "usage",
+				Description: "Capacity of the storage device and what the buckets hold",
+				Type:        nonNull(usageType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return h.engine.Stats(), nil
+				},
+			},
+			{
+				Name: "replication",
+				Type: nonNull(replicationType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return replicationStatus{h.replicator}, nil
+				},
+			},
+			{
+				Name:        "jobs",
+				Description: "Background jobs, newest first",
+				Type:        nonNull(connectionType("JobConnection", jobType, totalCountField())),
+				Args: pageArgs(
+					&graphql.Argument{Name: "type", Type: graphql.String},
+					&graphql.Argument{Name: "state", Type: graphql.String},
+				),
+				Resolve: h.listJobs,
+			},
+			{
+				Name: "job",
+				Type: jobType,
+				Args: []*graphql.Argument{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					if h.jobs == nil {
+						return nil, nil
+					}
+					if job, ok := h.jobs.Get(p.Args["id"].(string)); ok {
+						return job, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	}
+}
+
+func optionalString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// replicationStatus is the source of the Replication type, whose fields
+// resolve with replication disabled too
+type replicationStatus struct {
+	*replication.Replicator
+}
+
+func replicationStats(r replicationStatus) replication.Stats {
+	if r.Replicator == nil {
+		return replication.Stats{}
+	}
+	return r.GetStats()
+}
+
+func (h *GraphQLHandler) listBuckets(p graphql.ResolveParams) (any, error) {
+	first, after, err := page(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	all, err := h.buckets.ListBuckets(p.Context, "")
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := p.Args["prefix"].(string)
+
+	var matched []*bucket.Bucket
+	for _, b := range all {
+		if strings.HasPrefix(b.Name, prefix) {
+			matched = append(matched, b)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+
+	conn := &connection{totalCount: len(matched)}
+	start := sort.Search(len(matched), func(i int) bool { return matched[i].Name > after })
+	nodes := matched[start:]
+	if len(nodes) > first {
+		nodes = nodes[:first]
+		conn.endCursor = nodes[first-1].Name
+	}
+	conn.nodes = nodes
+	return conn, nil
+}
+
+func (h *GraphQLHandler) listObjects(p graphql.ResolveParams) (any, error) {
+	first, after, err := page(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	b := p.Source.(*bucket.Bucket)
+	prefix, _ := p.Args["prefix"].(string)
+	delimiter, _ := p.Args["delimiter"].(string)
+
+	result, err := h.objects.ListObjects(p.Context, b.Name, prefix, object.ListOptions{
+		Prefix:     prefix,
+		Delimiter:  delimiter,
+		StartAfter: after,
+		MaxKeys:    first,
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := &connection{nodes: result.Objects, prefixes: result.CommonPrefixes}
+	if result.IsTruncated {
+		conn.endCursor = result.NextMarker
+	}
+	return conn, nil
+}
+
+func (h *GraphQLHandler) listJobs(p graphql.ResolveParams) (any, error) {
+	first, after, err := page(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	conn := &connection{nodes: []jobs.Job{}}
+	if h.jobs == nil {
+		return conn, nil
+	}
+	jobType, _ := p.Args["type"].(string)
+	state, _ := p.Args["state"].(string)
+
+	var matched []jobs.Job
+	for _, job := range h.jobs.List(jobType) {
+		if state == "" || string(job.State) == state {
+			matched = append(matched, job)
+		}
+	}
+	conn.totalCount = len(matched)
+
+	start := 0
+	if after != "" {
+		start = -1
+		for i, job := range matched {
+			if job.ID == after {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, errInvalidCursor
+		}
+	}
+	nodes := matched[start:]
+	if len(nodes) > first {
+		nodes = nodes[:first]
+		conn.endCursor = nodes[first-1].ID
+	}
+	conn.nodes = nodes
+	return conn, nil
+}
+
+// bucketUsage is what a bucket holds
+type bucketUsage struct {
+	bucket  string
+	objects int64
+	bytes   int64
+}
+
+func (h *GraphQLHandler) bucketUsage(p graphql.ResolveParams) (any, error) {
+	b := p.Source.(*bucket.Bucket)
+	count, size, err := h.objects.CountObjects(p.Context, b.Name)
+	if err != nil {
+		return nil, err
+	}
+	return bucketUsage{bucket: b.Name, objects: int64(count), bytes: size}, nil
+}
+
+func (h *GraphQLHandler) allBucketUsage(ctx context.Context) ([]bucketUsage, error) {
+	all, err := h.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	usage := make([]bucketUsage, 0, len(all))
+	for _, b := range all {
+		count, size, err := h.objects.CountObjects(ctx, b.Name)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, bucketUsage{bucket: b.Name, objects: int64(count), bytes: size})
+	}
+	return usage, nil
+}
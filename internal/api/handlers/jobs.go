@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/jobs"
+)
+
+// JobHandler exposes background job status
+type JobHandler struct {
+	manager *jobs.Manager
+}
+
+// NewJobHandler creates a new job handler
+func NewJobHandler(manager *jobs.Manager) *JobHandler {
+	return &JobHandler{
+		manager: manager,
+	}
+}
+
+// ListJobs lists known jobs, optionally filtered by ?type=
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": h.manager.List(c.Query("type")),
+	})
+}
+
+// GetJob returns the status of a job
+func (h *JobHandler) GetJob(c *gin.Context) {
+	job, ok := h.manager.Get(c.Param("id"))
+	if !ok {
+		respondError(c, "Failed to get job", jobs.ErrNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob cancels a queued or running job
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	err := h.manager.Cancel(c.Param("id"))
+	if err != nil {
+		respondError(c, "Failed to cancel job", err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// UpdateObjectMetadata changes an object's metadata without rewriting its
+// data, PATCH /:bucket/:key?metadata. If-Match with the object's ETag (or
+// "*") is required; ?versionId additionally requires the object to still
+// be at that version, so concurrent updates cannot overwrite each other.
+// x-amz-meta-* and stored headers in the request are set, or removed when
+// empty, and Content-Type replaces the content type. The new version is
+// returned in x-amz-version-id.
+func (h *ObjectHandler) UpdateObjectMetadata(c *gin.Context) {
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		middleware.Error(c, http.StatusPreconditionRequired, s3.InvalidRequest, "If-Match header is required to update metadata")
+		return
+	}
+	etag, ok := parseIfMatch(header)
+	if !ok {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "If-Match must be a single strong ETag or *")
+		return
+	}
+
+	update := object.MetadataUpdate{
+		IfMatch:     etag,
+		VersionID:   c.Query("versionId"),
+		ContentType: c.GetHeader("Content-Type"),
+		Metadata:    objectMetadata(c),
+	}
+	obj, err := h.service.UpdateObjectMetadata(actorContext(c), bucket, key, update)
+	if err != nil {
+		respondError(c, "Failed to update object metadata", err)
+		return
+	}
+
+	setObjectHeaders(c, obj)
+	c.Header("ETag", strongETag(obj.ETag))
+	c.Header("Last-Modified", obj.ModifiedAt.UTC().Format(http.TimeFormat))
+	c.JSON(http.StatusOK, newObjectInfo(obj))
+}
+
+// parseIfMatch returns the ETag of an If-Match header holding a single
+// strong entity tag, or "*". Weak tags never match, as If-Match uses strong
+// comparison.
+func parseIfMatch(header string) (string, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return header, true
+	}
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return "", false
+	}
+	etag := header[1 : len(header)-1]
+	if etag == "" || strings.Contains(etag, `"`) {
+		return "", false
+	}
+	return etag, true
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestObjectHandler_UpdateObjectMetadata(t *testing.T) {
+	router, _, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	req, _ := http.NewRequest("PUT", "/test-bucket/test-key", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Amz-Meta-Color", "blue")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var put object.Object
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &put))
+
+	patch := func(ifMatch, query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PATCH", "/test-bucket/test-key?metadata"+query, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		req.Header.Set("X-Amz-Meta-Color", "green")
+		req.Header.Set("Content-Type", "text/markdown")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusPreconditionRequired, patch("", "").Code)
+	assert.Equal(t, http.StatusBadRequest, patch(`W/"`+put.ETag+`"`, "").Code)
+	assert.Equal(t, http.StatusPreconditionFailed, patch(`"0123"`, "").Code)
+
+	w = patch(`"`+put.ETag+`"`, "&versionId="+put.VersionID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"`+put.ETag+`"`, w.Header().Get("ETag"))
+	version := w.Header().Get("x-amz-version-id")
+	assert.NotEqual(t, put.VersionID, version)
+
+	// A second update from the same read loses
+	assert.Equal(t, http.StatusPreconditionFailed, patch(`"`+put.ETag+`"`, "&versionId="+put.VersionID).Code)
+
+	req, _ = http.NewRequest("HEAD", "/test-bucket/test-key", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "green", w.Header().Get("x-amz-meta-color"))
+	assert.Equal(t, "text/markdown", w.Header().Get("Content-Type"))
+	assert.Equal(t, version, w.Header().Get("x-amz-version-id"))
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// MoveObject moves an object to another bucket or key without copying its
+// data, POST /:bucket/:key?move-to=<bucket>/<key>. Moves between buckets
+// need the same owner on both, a destination keeping versions when the
+// source does, and room under the destination's quota. The object gets a
+// new version, returned in x-amz-version-id.
+func (h *ObjectHandler) MoveObject(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	key := c.Param("key")
+
+	dstBucket, dstKey, ok := strings.Cut(c.Query("move-to"), "/")
+	if !ok || dstBucket == "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "move-to must be <bucket>/<key>")
+		return
+	}
+	if code, msg, ok := middleware.CheckObjectKey(dstKey); !ok {
+		middleware.Error(c, http.StatusBadRequest, code, msg)
+		return
+	}
+
+	if h.buckets != nil {
+		obj, err := h.service.HeadObject(c.Request.Context(), bucketName, key, nil)
+		if err != nil {
+			respondError(c, "Failed to move object", err)
+			return
+		}
+		if err := h.buckets.CheckMove(c.Request.Context(), bucketName, dstBucket, obj.Size); err != nil {
+			respondError(c, "Failed to move object", err)
+			return
+		}
+	}
+
+	obj, err := h.service.MoveObject(actorContext(c), bucketName, key, dstBucket, dstKey)
+	if err != nil {
+		respondError(c, "Failed to move object", err)
+		return
+	}
+
+	setObjectHeaders(c, obj)
+	c.Header("ETag", strongETag(obj.ETag))
+	c.Header(replication.HeaderConsistencyToken, replication.ConsistencyToken(obj.ModifiedAt))
+	c.JSON(http.StatusOK, newObjectInfo(obj))
+}
@@ -1,41 +1,477 @@
 package handlers
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
 )
 
+// s3Namespace is the XML namespace of S3 API responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
 // MultipartHandler handles multipart upload operations
 type MultipartHandler struct {
+	service *multipart.Service
 }
 
 // NewMultipartHandler creates a new multipart handler
-func NewMultipartHandler() *MultipartHandler {
-	return &MultipartHandler{}
+func NewMultipartHandler(service *multipart.Service) *MultipartHandler {
+	return &MultipartHandler{
+		service: service,
+	}
+}
+
+// The response types below serve both the JSON and the S3 XML format
+
+// InitiateMultipartUploadResult is the response to initiating an upload
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `json:"-" xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `json:"-" xml:"xmlns,attr"`
+	Bucket   string   `json:"bucket" xml:"Bucket"`
+	Key      string   `json:"key" xml:"Key"`
+	UploadID string   `json:"upload_id" xml:"UploadId"`
+}
+
+// CompleteMultipartUploadResult is the response to completing an upload
+type CompleteMultipartUploadResult struct {
+	XMLName xml.Name `json:"-" xml:"CompleteMultipartUploadResult"`
+	Xmlns   string   `json:"-" xml:"xmlns,attr"`
+	Bucket  string   `json:"bucket" xml:"Bucket"`
+	Key     string   `json:"key" xml:"Key"`
+	ETag    string   `json:"etag" xml:"ETag"`
+	Size    int64    `json:"size" xml:"-"`
+}
+
+// UploadEntry describes an in-progress upload in ListMultipartUploads
+type UploadEntry struct {
+	Key       string    `json:"key" xml:"Key"`
+	UploadID  string    `json:"upload_id" xml:"UploadId"`
+	Initiated time.Time `json:"initiated" xml:"Initiated"`
 }
 
-// InitiateMultipartUpload initiates a multipart upload
+// ListMultipartUploadsResult is a page of in-progress uploads
+type ListMultipartUploadsResult struct {
+	XMLName            xml.Name      `json:"-" xml:"ListMultipartUploadsResult"`
+	Xmlns              string        `json:"-" xml:"xmlns,attr"`
+	Bucket             string        `json:"bucket" xml:"Bucket"`
+	Prefix             string        `json:"prefix" xml:"Prefix"`
+	EncodingType       string        `json:"encoding_type,omitempty" xml:"EncodingType,omitempty"`
+	KeyMarker          string        `json:"key_marker" xml:"KeyMarker"`
+	UploadIDMarker     string        `json:"upload_id_marker" xml:"UploadIdMarker"`
+	NextKeyMarker      string        `json:"next_key_marker,omitempty" xml:"NextKeyMarker"`
+	NextUploadIDMarker string        `json:"next_upload_id_marker,omitempty" xml:"NextUploadIdMarker"`
+	MaxUploads         int           `json:"max_uploads" xml:"MaxUploads"`
+	IsTruncated        bool          `json:"is_truncated" xml:"IsTruncated"`
+	Uploads            []UploadEntry `json:"uploads" xml:"Upload"`
+}
+
+// PartEntry describes an uploaded part in ListParts
+type PartEntry struct {
+	PartNumber     int       `json:"part_number" xml:"PartNumber"`
+	LastModified   time.Time `json:"last_modified" xml:"LastModified"`
+	ETag           string    `json:"etag" xml:"ETag"`
+	Size           int64     `json:"size" xml:"Size"`
+	ChecksumCRC32  string    `json:"checksum_crc32,omitempty" xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string    `json:"checksum_crc32c,omitempty" xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA1   string    `json:"checksum_sha1,omitempty" xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256 string    `json:"checksum_sha256,omitempty" xml:"ChecksumSHA256,omitempty"`
+}
+
+// CopyPartResult is the response to UploadPartCopy
+type CopyPartResult struct {
+	XMLName      xml.Name  `json:"-" xml:"CopyPartResult"`
+	Xmlns        string    `json:"-" xml:"xmlns,attr"`
+	ETag         string    `json:"etag" xml:"ETag"`
+	LastModified time.Time `json:"last_modified" xml:"LastModified"`
+}
+
+// newPartEntry converts a part for listing
+func newPartEntry(p multipart.Part) PartEntry {
+	entry := PartEntry{
+		PartNumber:   p.PartNumber,
+		LastModified: p.LastModified.UTC(),
+		ETag:         strongETag(p.ETag),
+		Size:         p.Size,
+	}
+	switch p.ChecksumAlgorithm {
+	case integrity.AlgorithmCRC32:
+		entry.ChecksumCRC32 = p.ChecksumValue
+	case integrity.AlgorithmCRC32C:
+		entry.ChecksumCRC32C = p.ChecksumValue
+	case integrity.AlgorithmSHA1:
+		entry.ChecksumSHA1 = p.ChecksumValue
+	case integrity.AlgorithmSHA256:
+		entry.ChecksumSHA256 = p.ChecksumValue
+	}
+	return entry
+}
+
+// ListPartsResult is a page of an upload's parts
+type ListPartsResult struct {
+	XMLName              xml.Name    `json:"-" xml:"ListPartsResult"`
+	Xmlns                string      `json:"-" xml:"xmlns,attr"`
+	Bucket               string      `json:"bucket" xml:"Bucket"`
+	Key                  string      `json:"key" xml:"Key"`
+	UploadID             string      `json:"upload_id" xml:"UploadId"`
+	PartNumberMarker     int         `json:"part_number_marker" xml:"PartNumberMarker"`
+	NextPartNumberMarker int         `json:"next_part_number_marker,omitempty" xml:"NextPartNumberMarker"`
+	MaxParts             int         `json:"max_parts" xml:"MaxParts"`
+	IsTruncated          bool        `json:"is_truncated" xml:"IsTruncated"`
+	Parts                []PartEntry `json:"parts" xml:"Part"`
+}
+
+// completeRequest is the body of a completion request, as JSON
+// ({"parts": [...]}) or as an S3 CompleteMultipartUpload document
+type completeRequest struct {
+	XMLName xml.Name                  `json:"-" xml:"CompleteMultipartUpload"`
+	Parts   []multipart.CompletedPart `json:"parts" xml:"Part"`
+}
+
+// render writes v in the format the client asked for
+func render(c *gin.Context, status int, v interface{}) {
+	if middleware.WantsXML(c) {
+		c.XML(status, v)
+		return
+	}
+	c.JSON(status, v)
+}
+
+// queryInt parses an optional non-negative integer query parameter
+func queryInt(c *gin.Context, name string) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid "+name)
+		return 0, false
+	}
+	return n, true
+}
+
+// InitiateMultipartUpload initiates a multipart upload (POST /:bucket/:key?uploads)
 func (h *MultipartHandler) InitiateMultipartUpload(c *gin.Context) {
-	c.Status(http.StatusOK)
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+
+	upload, err := h.service.InitiateMultipartUpload(actorContext(c), bucket, key, c.GetHeader("Content-Type"))
+	if err != nil {
+		respondError(c, "Failed to initiate multipart upload", err)
+		return
+	}
+
+	render(c, http.StatusOK, InitiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadID: upload.UploadID,
+	})
+}
+
+// requestChecksum returns the x-amz-checksum-* header of a request, if any
+func requestChecksum(c *gin.Context) (*integrity.Checksum, error) {
+	var checksum *integrity.Checksum
+	for _, algorithm := range integrity.S3Algorithms {
+		value := c.GetHeader(checksumHeader(algorithm))
+		if value == "" {
+			continue
+		}
+		if checksum != nil {
+			return nil, errors.New("only one x-amz-checksum header is allowed")
+		}
+		checksum = &integrity.Checksum{Algorithm: algorithm, Value: value}
+	}
+	return checksum, nil
+}
+
+// checksumHeader returns the header carrying an S3 checksum algorithm
+func checksumHeader(algorithm string) string {
+	return "x-amz-checksum-" + strings.ToLower(algorithm)
 }
 
-// UploadPart uploads a part
+// parseCopySource parses an x-amz-copy-source header ("[/]bucket/key",
+// URL-encoded, with an optional "?versionId=") and optional
+// x-amz-copy-source-range ("bytes=first-last")
+func parseCopySource(source, rangeHeader string) (multipart.CopySource, error) {
+	src := multipart.CopySource{Length: -1}
+
+	source, rawQuery, _ := strings.Cut(source, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return src, fmt.Errorf("invalid copy source: %w", err)
+	}
+	for name := range query {
+		if name != "versionId" {
+			return src, fmt.Errorf("invalid copy source: unknown parameter %s", name)
+		}
+	}
+	if query.Has("versionId") {
+		versionID := query.Get("versionId")
+		if versionID == "" {
+			return src, errors.New("invalid copy source: empty versionId")
+		}
+		src.VersionID = &versionID
+	}
+
+	decoded, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return src, fmt.Errorf("invalid copy source: %w", err)
+	}
+
+	bucket, key, ok := strings.Cut(decoded, "/")
+	if !ok || bucket == "" || key == "" {
+		return src, errors.New("invalid copy source: expected bucket/key")
+	}
+	src.Bucket = bucket
+	src.Key = key
+
+	if rangeHeader == "" {
+		return src, nil
+	}
+
+	// S3 only accepts explicit first-last ranges here
+	first, last, ok := strings.Cut(strings.TrimPrefix(rangeHeader, "bytes="), "-")
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if !strings.HasPrefix(rangeHeader, "bytes=") || !ok || err1 != nil || err2 != nil || start < 0 || end < start {
+		return src, errors.New("invalid copy source range: expected bytes=first-last")
+	}
+	src.Start = start
+	src.Length = end - start + 1
+	return src, nil
+}
+
+// UploadPart uploads a part (PUT /:bucket/:key?partNumber=N&uploadId=ID).
+// With an x-amz-copy-source header the part is copied from an existing
+// object instead (UploadPartCopy).
 func (h *MultipartHandler) UploadPart(c *gin.Context) {
+	partNumber, err := strconv.Atoi(c.Query("partNumber"))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid partNumber")
+		return
+	}
+
+	if source := c.GetHeader("x-amz-copy-source"); source != "" {
+		h.uploadPartCopy(c, partNumber, source)
+		return
+	}
+
+	size := c.Request.ContentLength
+	if size < 0 {
+		middleware.Error(c, http.StatusLengthRequired, s3.MissingContentLength, "Content-Length required")
+		return
+	}
+
+	checksum, err := requestChecksum(c)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+
+	part, err := h.service.UploadPart(actorContext(c), c.Param("bucket"), c.Param("key"),
+		c.Query("uploadId"), partNumber, c.Request.Body, size, checksum)
+	if err != nil {
+		respondError(c, "Failed to upload part", err)
+		return
+	}
+
+	c.Header("ETag", strongETag(part.ETag))
+	if part.ChecksumAlgorithm != "" {
+		c.Header(checksumHeader(part.ChecksumAlgorithm), part.ChecksumValue)
+	}
 	c.Status(http.StatusOK)
 }
 
-// CompleteMultipartUpload completes a multipart upload
+// uploadPartCopy copies a byte range of an existing object as a part
+func (h *MultipartHandler) uploadPartCopy(c *gin.Context, partNumber int, source string) {
+	src, err := parseCopySource(source, c.GetHeader("x-amz-copy-source-range"))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+		return
+	}
+
+	part, err := h.service.UploadPartCopy(actorContext(c), c.Param("bucket"), c.Param("key"),
+		c.Query("uploadId"), partNumber, src)
+	if err != nil {
+		respondError(c, "Failed to copy part", err)
+		return
+	}
+
+	if src.VersionID != nil {
+		c.Header("x-amz-copy-source-version-id", *src.VersionID)
+	}
+	render(c, http.StatusOK, CopyPartResult{
+		Xmlns:        s3Namespace,
+		ETag:         strongETag(part.ETag),
+		LastModified: part.LastModified.UTC(),
+	})
+}
+
+// CompleteMultipartUpload completes a multipart upload (POST /:bucket/:key?uploadId=ID)
 func (h *MultipartHandler) CompleteMultipartUpload(c *gin.Context) {
-	c.Status(http.StatusOK)
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+
+	var req completeRequest
+	trimmed := strings.TrimSpace(string(body))
+	if strings.HasPrefix(trimmed, "<") {
+		err = xml.Unmarshal(body, &req)
+	} else {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.MalformedXML, "malformed part list: "+err.Error())
+		return
+	}
+
+	obj, err := h.service.CompleteMultipartUpload(actorContext(c), bucket, key, c.Query("uploadId"), req.Parts)
+	if err != nil {
+		respondError(c, "Failed to complete multipart upload", err)
+		return
+	}
+
+	c.Header(replication.HeaderConsistencyToken, replication.ConsistencyToken(obj.ModifiedAt))
+	render(c, http.StatusOK, CompleteMultipartUploadResult{
+		Xmlns:  s3Namespace,
+		Bucket: bucket,
+		Key:    key,
+		ETag:   strongETag(obj.ETag),
+		Size:   obj.Size,
+	})
 }
 
-// AbortMultipartUpload aborts a multipart upload
+// AbortMultipartUpload aborts a multipart upload (DELETE /:bucket/:key?uploadId=ID)
 func (h *MultipartHandler) AbortMultipartUpload(c *gin.Context) {
+	err := h.service.AbortMultipartUpload(actorContext(c), c.Param("bucket"), c.Param("key"), c.Query("uploadId"))
+	if err != nil {
+		respondError(c, "Failed to abort multipart upload", err)
+		return
+	}
+
 	c.Status(http.StatusNoContent)
 }
 
-// ListParts lists parts
+// ListParts lists the parts of an upload (GET /:bucket/:key?uploadId=ID).
+// Pages with max-parts and part-number-marker.
 func (h *MultipartHandler) ListParts(c *gin.Context) {
-	c.Status(http.StatusOK)
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+	uploadID := c.Query("uploadId")
+
+	maxParts, ok := queryInt(c, "max-parts")
+	if !ok {
+		return
+	}
+	marker, ok := queryInt(c, "part-number-marker")
+	if !ok {
+		return
+	}
+
+	result, err := h.service.ListParts(c.Request.Context(), bucket, key, uploadID, multipart.ListPartsOptions{
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	})
+	if err != nil {
+		respondError(c, "Failed to list parts", err)
+		return
+	}
+
+	if maxParts == 0 || maxParts > multipart.DefaultMaxParts {
+		maxParts = multipart.DefaultMaxParts
+	}
+
+	resp := ListPartsResult{
+		Xmlns:                s3Namespace,
+		Bucket:               bucket,
+		Key:                  key,
+		UploadID:             uploadID,
+		PartNumberMarker:     marker,
+		NextPartNumberMarker: result.NextPartNumberMarker,
+		MaxParts:             maxParts,
+		IsTruncated:          result.IsTruncated,
+		Parts:                make([]PartEntry, 0, len(result.Parts)),
+	}
+	for _, p := range result.Parts {
+		resp.Parts = append(resp.Parts, newPartEntry(p))
+	}
+
+	render(c, http.StatusOK, resp)
+}
+
+// ListMultipartUploads lists in-progress uploads in a bucket (GET /:bucket?uploads).
+// Pages with max-uploads, key-marker and upload-id-marker.
+func (h *MultipartHandler) ListMultipartUploads(c *gin.Context) {
+	bucket := c.Param("bucket")
+
+	encode, encodingType, ok := listEncoding(c)
+	if !ok {
+		return
+	}
+
+	maxUploads, ok := queryInt(c, "max-uploads")
+	if !ok {
+		return
+	}
+
+	opts := multipart.ListUploadsOptions{
+		Prefix:         c.Query("prefix"),
+		KeyMarker:      c.Query("key-marker"),
+		UploadIDMarker: c.Query("upload-id-marker"),
+		MaxUploads:     maxUploads,
+	}
+
+	result, err := h.service.ListMultipartUploads(c.Request.Context(), bucket, opts)
+	if err != nil {
+		respondError(c, "Failed to list multipart uploads", err)
+		return
+	}
+
+	if maxUploads == 0 || maxUploads > multipart.DefaultMaxUploads {
+		maxUploads = multipart.DefaultMaxUploads
+	}
+
+	resp := ListMultipartUploadsResult{
+		Xmlns:              s3Namespace,
+		Bucket:             bucket,
+		Prefix:             encode(opts.Prefix),
+		EncodingType:       encodingType,
+		KeyMarker:          encode(opts.KeyMarker),
+		UploadIDMarker:     opts.UploadIDMarker,
+		NextKeyMarker:      encode(result.NextKeyMarker),
+		NextUploadIDMarker: result.NextUploadIDMarker,
+		MaxUploads:         maxUploads,
+		IsTruncated:        result.IsTruncated,
+		Uploads:            make([]UploadEntry, 0, len(result.Uploads)),
+	}
+	for _, u := range result.Uploads {
+		resp.Uploads = append(resp.Uploads, UploadEntry{
+			Key:       encode(u.Key),
+			UploadID:  u.UploadID,
+			Initiated: u.CreatedAt.UTC(),
+		})
+	}
+
+	render(c, http.StatusOK, resp)
 }
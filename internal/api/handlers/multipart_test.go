@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+func setupMultipartTest() (*gin.Engine, *object.Service) {
+	router := gin.New()
+
+	bucketService := bucket.NewService(bucket.NewMemoryRepository())
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	engine := newMockEngine()
+	objectService := object.NewService(object.NewMemoryRepository(), engine)
+	service := multipart.NewService(engine, objectService)
+	service.SetLimits(multipart.Limits{MinPartSize: 0})
+	handler := NewMultipartHandler(service)
+
+	router.POST("/:bucket/:key", func(c *gin.Context) {
+		if _, ok := c.GetQuery("uploads"); ok {
+			handler.InitiateMultipartUpload(c)
+			return
+		}
+		handler.CompleteMultipartUpload(c)
+	})
+	router.PUT("/:bucket/:key", handler.UploadPart)
+	router.GET("/:bucket/:key", handler.ListParts)
+	router.DELETE("/:bucket/:key", handler.AbortMultipartUpload)
+	router.GET("/:bucket", handler.ListMultipartUploads)
+
+	return router, objectService
+}
+
+func serve(router *gin.Engine, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMultipartHandler_XMLFlow(t *testing.T) {
+	router, objectService := setupMultipartTest()
+	xmlAccept := http.Header{"Accept": {"application/xml"}}
+
+	w := serve(router, "POST", "/test-bucket/big.bin?uploads", "", xmlAccept)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var initiated InitiateMultipartUploadResult
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &initiated))
+	assert.NotEmpty(t, initiated.UploadID)
+	base := "/test-bucket/big.bin?uploadId=" + initiated.UploadID
+
+	var complete strings.Builder
+	complete.WriteString("<CompleteMultipartUpload>")
+	for n, data := range []string{"one-", "two-", "three"} {
+		w = serve(router, "PUT", fmt.Sprintf("%s&partNumber=%d", base, n+1), data, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		fmt.Fprintf(&complete, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", n+1, w.Header().Get("ETag"))
+	}
+	complete.WriteString("</CompleteMultipartUpload>")
+
+	// Page through the parts two at a time
+	w = serve(router, "GET", base+"&max-parts=2", "", xmlAccept)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var parts ListPartsResult
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &parts))
+	assert.True(t, parts.IsTruncated)
+	assert.Len(t, parts.Parts, 2)
+	assert.Equal(t, 2, parts.NextPartNumberMarker)
+
+	w = serve(router, "GET", base+"&max-parts=2&part-number-marker=2", "", xmlAccept)
+	parts = ListPartsResult{}
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &parts))
+	assert.False(t, parts.IsTruncated)
+	assert.Len(t, parts.Parts, 1)
+	assert.Equal(t, 3, parts.Parts[0].PartNumber)
+
+	w = serve(router, "GET", "/test-bucket?uploads", "", xmlAccept)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<ListMultipartUploadsResult")
+	var uploads ListMultipartUploadsResult
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &uploads))
+	assert.Len(t, uploads.Uploads, 1)
+	assert.Equal(t, initiated.UploadID, uploads.Uploads[0].UploadID)
+
+	w = serve(router, "POST", base, complete.String(), xmlAccept)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	obj, err := objectService.GetObjectMetadata(nil, "test-bucket", "big.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("one-two-three")), obj.Size)
+
+	w = serve(router, "GET", "/test-bucket?uploads", "", nil)
+	var listed ListMultipartUploadsResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Empty(t, listed.Uploads)
+}
+
+func TestMultipartHandler_Errors(t *testing.T) {
+	router, _ := setupMultipartTest()
+
+	w := serve(router, "GET", "/test-bucket/key?uploadId=missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(router, "POST", "/test-bucket/key?uploads", "", nil)
+	var initiated InitiateMultipartUploadResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &initiated))
+	base := "/test-bucket/key?uploadId=" + initiated.UploadID
+
+	w = serve(router, "PUT", base+"&partNumber=0", "x", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "GET", base+"&max-parts=abc", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "POST", base, `{"parts":[{"part_number":1}]}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "PUT", base+"&partNumber=1", "x", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(router, "POST", base, `{"parts":[{"part_number":1},{"part_number":1}]}`, http.Header{"Accept": {"application/xml"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var s3err s3.ErrorResponse
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &s3err))
+	assert.Equal(t, s3.InvalidPartOrder, s3err.Code)
+
+	w = serve(router, "DELETE", base, "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve(router, "DELETE", base, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"NoSuchUpload"`)
+}
+
+func TestMultipartHandler_ChecksumAndCopy(t *testing.T) {
+	router, objectService := setupMultipartTest()
+	objectService.PutObject(nil, "test-bucket", "source", strings.NewReader("0123456789"), 10, "text/plain")
+
+	w := serve(router, "POST", "/test-bucket/dest?uploads", "", nil)
+	var initiated InitiateMultipartUploadResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &initiated))
+	base := "/test-bucket/dest?uploadId=" + initiated.UploadID
+
+	// CRC32 (IEEE) of "abc", base64 encoded
+	w = serve(router, "PUT", base+"&partNumber=1", "abc", http.Header{"X-Amz-Checksum-Crc32": {"NSRBwg=="}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "NSRBwg==", w.Header().Get("x-amz-checksum-crc32"))
+
+	w = serve(router, "PUT", base+"&partNumber=2", "abc", http.Header{"X-Amz-Checksum-Crc32": {"AAAAAA=="}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, "PUT", base+"&partNumber=2", "", http.Header{
+		"X-Amz-Copy-Source":       {"/test-bucket/source"},
+		"X-Amz-Copy-Source-Range": {"bytes=2-5"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var copied CopyPartResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &copied))
+	assert.NotEmpty(t, copied.ETag)
+
+	w = serve(router, "PUT", base+"&partNumber=3", "", http.Header{
+		"X-Amz-Copy-Source":       {"/test-bucket/source"},
+		"X-Amz-Copy-Source-Range": {"bytes=5-20"},
+	})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	w = serve(router, "GET", base, "", nil)
+	var parts ListPartsResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &parts))
+	assert.Len(t, parts.Parts, 2)
+	assert.Equal(t, "NSRBwg==", parts.Parts[0].ChecksumCRC32)
+
+	w = serve(router, "POST", base, `{"parts":[{"part_number":1},{"part_number":2}]}`, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	_, data, err := objectService.GetObject(nil, "test-bucket", "dest", nil)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(data)
+	assert.Equal(t, "abc2345", string(body))
+}
+
+func TestParseCopySource(t *testing.T) {
+	src, err := parseCopySource("photos/a%20b.jpg?versionId=1", "bytes=0-9")
+	assert.NoError(t, err)
+	version := "1"
+	assert.Equal(t, multipart.CopySource{Bucket: "photos", Key: "a b.jpg", VersionID: &version, Start: 0, Length: 10}, src)
+
+	src, err = parseCopySource("/photos/a.jpg", "")
+	assert.NoError(t, err)
+	assert.Nil(t, src.VersionID)
+
+	for _, tt := range []struct{ source, rng string }{
+		{"photos", ""},
+		{"/photos/a.jpg?versionId=", ""},
+		{"/photos/a.jpg?partNumber=1", ""},
+		{"/photos/a.jpg", "bytes=5-"},
+		{"/photos/a.jpg", "bytes=9-3"},
+		{"/photos/a.jpg", "0-9"},
+	} {
+		_, err := parseCopySource(tt.source, tt.rng)
+		assert.Error(t, err, "%s %s", tt.source, tt.rng)
+	}
+}
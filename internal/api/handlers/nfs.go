@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/nfs"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// NFSHandler manages read-only NFS exports of bucket snapshots
+type NFSHandler struct {
+	server  *nfs.Server
+	buckets *bucket.Service
+}
+
+func NewNFSHandler(server *nfs.Server, buckets *bucket.Service) *NFSHandler {
+	return &NFSHandler{
+		server:  server,
+		buckets: buckets,
+	}
+}
+
+// enabled responds with an error when the NFS server is not running
+func (h *NFSHandler) enabled(c *gin.Context) bool {
+	if h.server == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "NFS exports are not enabled")
+		return false
+	}
+	return true
+}
+
+// CreateExport snapshots a bucket and exports the snapshot over NFS
+func (h *NFSHandler) CreateExport(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	name := c.Param("bucket")
+	if _, err := h.buckets.GetBucket(ctx, name); err != nil {
+		respondError(c, "Failed to get bucket", err)
+		return
+	}
+
+	export, err := h.server.Export(ctx, name)
+	if err != nil {
+		respondError(c, "Failed to export bucket snapshot", err)
+		return
+	}
+	c.JSON(http.StatusCreated, export)
+}
+
+// ListExports lists the NFS exports, optionally only those of one bucket
+func (h *NFSHandler) ListExports(c *gin.Context) {
+	if h.server == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": false,
+			"exports": []*nfs.Export{},
+		})
+		return
+	}
+
+	bucketName := c.Query("bucket")
+	exports := make([]*nfs.Export, 0)
+	for _, e := range h.server.Exports() {
+		if bucketName == "" || e.Bucket == bucketName {
+			exports = append(exports, e)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"exports": exports,
+	})
+}
+
+// GetExport returns an NFS export
+func (h *NFSHandler) GetExport(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	export, err := h.server.GetExport(c.Param("id"))
+	if err != nil {
+		respondError(c, "Failed to get NFS export", err)
+		return
+	}
+	c.JSON(http.StatusOK, export)
+}
+
+// DeleteExport removes an NFS export and releases its snapshot
+func (h *NFSHandler) DeleteExport(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	if err := h.server.Unexport(c.Param("id")); err != nil {
+		respondError(c, "Failed to remove NFS export", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -1,19 +1,44 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/attestation"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/jobs"
 	"github.com/danielino/comio/internal/monitoring"
 	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 // ObjectHandler handles object operations
 type ObjectHandler struct {
 	service *object.Service
+	jobs    *jobs.Manager
+	buckets *bucket.Service
+
+	// Browser form uploads
+	authenticator    *auth.HMACAuthenticator
+	postAuthRequired bool
+
+	// Signs ?attestation statements, nil when not offered
+	attestation *attestation.Signer
 }
 
 // NewObjectHandler creates a new object handler
@@ -23,8 +48,35 @@ func NewObjectHandler(service *object.Service) *ObjectHandler {
 	}
 }
 
-// PutObject uploads an object
+// SetJobManager enables asynchronous bucket purges
+func (h *ObjectHandler) SetJobManager(manager *jobs.Manager) {
+	h.jobs = manager
+}
+
+// SetBucketService enforces bucket quotas on uploads
+func (h *ObjectHandler) SetBucketService(buckets *bucket.Service) {
+	h.buckets = buckets
+}
+
+// actorContext attributes the request's object operations to the calling
+// user and client address in the object history
+func actorContext(c *gin.Context) context.Context {
+	user := middleware.GetUserFromContext(c)
+	return object.WithActor(c.Request.Context(), user.AccessKeyID, c.ClientIP())
+}
+
+// HeaderBaseVersion names the version of the key a PUT is written on. The
+// new version shares the data it has in common with it.
+const HeaderBaseVersion = "X-Comio-Base-Version"
+
+// PutObject uploads an object, or copies one server-side when the request
+// names an x-amz-copy-source
 func (h *ObjectHandler) PutObject(c *gin.Context) {
+	if source := c.GetHeader("x-amz-copy-source"); source != "" {
+		copyObject(c, h.service, h.buckets, source)
+		return
+	}
+
 	bucket := c.Param("bucket")
 	key := c.Param("key")
 
@@ -32,43 +84,209 @@ func (h *ObjectHandler) PutObject(c *gin.Context) {
 	size := c.Request.ContentLength
 	contentType := c.GetHeader("Content-Type")
 
-	obj, err := h.service.PutObject(c.Request.Context(), bucket, key, c.Request.Body, size, contentType)
+	refund := func() {}
+	if h.buckets != nil {
+		var ok bool
+		if refund, ok = checkSpace(c, h.buckets, bucket, size); !ok {
+			return
+		}
+	}
+
+	var obj *object.Object
+	var err error
+	if base := c.GetHeader(HeaderBaseVersion); base != "" {
+		obj, err = h.service.PutObjectOnBase(actorContext(c), bucket, key, c.Request.Body, size, contentType, objectMetadata(c), base)
+	} else {
+		obj, err = h.service.PutObjectWithMetadata(actorContext(c), bucket, key, c.Request.Body, size, contentType, objectMetadata(c))
+	}
 	if err != nil {
-		monitoring.Log.Error("Failed to put object",
-			zap.String("bucket", bucket),
-			zap.String("key", key),
-			zap.Int64("size", size),
-			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		refund()
+		respondError(c, "Failed to put object", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, obj)
+	setEncryptionHeader(c, obj)
+	// Lets the client read its own write from an async read replica
+	c.Header(replication.HeaderConsistencyToken, replication.ConsistencyToken(obj.ModifiedAt))
+	c.JSON(http.StatusOK, newObjectInfo(obj))
 }
 
-// GetObject retrieves an object
+// GetObject retrieves an object, honouring conditional requests and single
+// byte-range requests. With ?history it returns the object's operation
+// history instead, and with ?attestation a signed statement of its stored
+// state.
 func (h *ObjectHandler) GetObject(c *gin.Context) {
 	bucket := c.Param("bucket")
 	key := c.Param("key")
 
+	if _, ok := c.GetQuery("history"); ok {
+		h.getObjectHistory(c, bucket, key)
+		return
+	}
+	if _, ok := c.GetQuery("attestation"); ok {
+		h.getObjectAttestation(c, bucket, key)
+		return
+	}
+
+	// Preconditions are checked on the metadata, sparing the read of
+	// objects the client's cache still holds
+	if hasPreconditions(c.Request) {
+		meta, err := h.service.GetObjectMetadata(c.Request.Context(), bucket, key)
+		if err != nil {
+			setDeleteMarkerHeader(c, err)
+			respondError(c, "Failed to get object", err)
+			return
+		}
+		if status := checkPreconditions(c.Request, meta.ETag, meta.ModifiedAt); status != 0 {
+			answerPrecondition(c, status, meta)
+			return
+		}
+	}
+
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader != "" {
+		h.getObjectRange(c, bucket, key, rangeHeader)
+		return
+	}
+
 	obj, data, err := h.service.GetObject(c.Request.Context(), bucket, key, nil)
 	if err != nil {
-		monitoring.Log.Error("Failed to get object",
-			zap.String("bucket", bucket),
-			zap.String("key", key),
-			zap.Error(err))
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		setDeleteMarkerHeader(c, err)
+		respondError(c, "Failed to get object", err)
 		return
 	}
 	defer data.Close()
 
-	c.Header("Content-Type", obj.ContentType)
-	c.Header("ETag", obj.ETag)
-	// Stream data
-	// io.Copy(c.Writer, data)
-	// Gin has DataFromReader
+	setObjectHeaders(c, obj)
+	setChecksumHeader(c, obj)
 	c.DataFromReader(http.StatusOK, obj.Size, obj.ContentType, data, map[string]string{
-		"ETag": obj.ETag,
+		"ETag":          strongETag(obj.ETag),
+		"Accept-Ranges": "bytes",
+		"Last-Modified": obj.ModifiedAt.UTC().Format(http.TimeFormat),
+	})
+}
+
+// userMetadataPrefix marks request headers stored as user metadata
+const userMetadataPrefix = "x-amz-meta-"
+
+// storedHeaders are standard headers kept from a PUT and returned when the
+// object is read
+var storedHeaders = []string{"cache-control", "content-encoding"}
+
+// objectMetadata collects the user metadata and stored headers of a PUT,
+// keyed by lowercase header name
+func objectMetadata(c *gin.Context) map[string]string {
+	var metadata map[string]string
+	for name, values := range c.Request.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, userMetadataPrefix) && !slices.Contains(storedHeaders, name) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[name] = strings.Join(values, ",")
+	}
+	return metadata
+}
+
+// setObjectHeaders sets the response headers describing an object: its
+// version, encryption, user metadata and stored headers
+func setObjectHeaders(c *gin.Context, obj *object.Object) {
+	if obj.VersionID != "" {
+		c.Header("x-amz-version-id", obj.VersionID)
+	}
+	for name, value := range obj.Metadata {
+		if strings.HasPrefix(name, userMetadataPrefix) || slices.Contains(storedHeaders, name) {
+			c.Header(name, value)
+		}
+	}
+	setEncryptionHeader(c, obj)
+}
+
+// setChecksumHeader returns the object's checksum as an S3 additional
+// checksum header, if S3 has one for its algorithm. It covers the whole
+// object, so it is not sent with partial content.
+func setChecksumHeader(c *gin.Context, obj *object.Object) {
+	if !slices.Contains(integrity.S3Algorithms, obj.Checksum.Algorithm) || obj.Checksum.Value == "" {
+		return
+	}
+	digest, err := hex.DecodeString(obj.Checksum.Value)
+	if err != nil {
+		return
+	}
+	c.Header("x-amz-checksum-"+strings.ToLower(obj.Checksum.Algorithm), base64.StdEncoding.EncodeToString(digest))
+}
+
+// setEncryptionHeader reports server-side encryption of an object the way
+// S3 does
+func setEncryptionHeader(c *gin.Context, obj *object.Object) {
+	if obj.Encryption != nil {
+		c.Header("x-amz-server-side-encryption", obj.Encryption.Algorithm)
+	}
+}
+
+// getObjectHistory returns the recorded operations on an object, including
+// ones on keys that have since been deleted
+func (h *ObjectHandler) getObjectHistory(c *gin.Context, bucket, key string) {
+	events, err := h.service.GetObjectHistory(c.Request.Context(), bucket, key)
+	if err != nil {
+		respondError(c, "Failed to get object history", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bucket": bucket,
+		"key":    key,
+		"events": events,
+	})
+}
+
+// getObjectRange serves a partial GET. Resuming clients send open-ended
+// ranges (bytes=N-) optionally guarded by If-Range.
+func (h *ObjectHandler) getObjectRange(c *gin.Context, bucket, key, rangeHeader string) {
+	ctx := c.Request.Context()
+
+	meta, err := h.service.GetObjectMetadata(ctx, bucket, key)
+	if err != nil {
+		setDeleteMarkerHeader(c, err)
+		respondError(c, "Failed to get object", err)
+		return
+	}
+
+	// A stale If-Range validator means the client's partial copy is out of date
+	if !ifRangeMatches(c.GetHeader("If-Range"), meta.ETag, meta.ModifiedAt) {
+		c.Request.Header.Del("Range")
+		h.GetObject(c)
+		return
+	}
+
+	r, err := parseRange(rangeHeader, meta.Size)
+	if errors.Is(err, errInvalidRange) {
+		// A Range that does not parse is ignored, as RFC 9110 asks
+		c.Request.Header.Del("Range")
+		h.GetObject(c)
+		return
+	}
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", meta.Size))
+		middleware.Error(c, http.StatusRequestedRangeNotSatisfiable, s3.InvalidRange, err.Error())
+		return
+	}
+
+	obj, data, err := h.service.GetObjectRange(ctx, bucket, key, nil, r.Start, r.Length())
+	if err != nil {
+		respondError(c, "Failed to get object range", err)
+		return
+	}
+	defer data.Close()
+
+	setObjectHeaders(c, obj)
+	c.DataFromReader(http.StatusPartialContent, r.Length(), obj.ContentType, data, map[string]string{
+		"ETag":          strongETag(obj.ETag),
+		"Accept-Ranges": "bytes",
+		"Content-Range": r.ContentRange(obj.Size),
+		"Last-Modified": obj.ModifiedAt.UTC().Format(http.TimeFormat),
 	})
 }
 
@@ -77,43 +295,107 @@ func (h *ObjectHandler) DeleteObject(c *gin.Context) {
 	bucket := c.Param("bucket")
 	key := c.Param("key")
 
-	err := h.service.DeleteObject(c.Request.Context(), bucket, key)
+	marker, err := removeObject(c, h.service, bucket, key)
 	if err != nil {
-		monitoring.Log.Error("Failed to delete object",
-			zap.String("bucket", bucket),
-			zap.String("key", key),
-			zap.Error(err))
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondError(c, "Failed to delete object", err)
 		return
 	}
+	if marker != nil {
+		c.Header("x-amz-delete-marker", "true")
+		c.Header("x-amz-version-id", marker.VersionID)
+	}
 
 	c.Status(http.StatusNoContent)
 }
 
-// HeadObject checks if object exists and returns metadata
+// removeObject deletes an object, with a delete marker in buckets writing
+// them and for good otherwise, and returns the marker if it wrote one.
+// Replicated deletes of a primary writing delete markers are applied the
+// same way.
+func removeObject(c *gin.Context, service *object.Service, bucket, key string) (*object.Object, error) {
+	if c.GetHeader(replication.HeaderDeleteMarker) == "true" || service.UsesDeleteMarkers(c.Request.Context(), bucket) {
+		return service.PutDeleteMarker(actorContext(c), bucket, key)
+	}
+	return nil, service.DeleteObject(actorContext(c), bucket, key)
+}
+
+// DeleteObjects deletes up to 1000 keys of a bucket in one request (POST
+// /:bucket?delete), reporting the outcome for each key
+func (h *ObjectHandler) DeleteObjects(c *gin.Context) {
+	deleteObjects(c, h.service)
+}
+
+// setDeleteMarkerHeader tells clients a read found a delete marker
+func setDeleteMarkerHeader(c *gin.Context, err error) {
+	if errors.Is(err, object.ErrDeleteMarker) {
+		c.Header("x-amz-delete-marker", "true")
+	}
+}
+
+// HeadObject checks if object exists and returns metadata. With
+// ?versionId it describes that version of the object.
 func (h *ObjectHandler) HeadObject(c *gin.Context) {
 	bucket := c.Param("bucket")
 	key := c.Param("key")
 
-	obj, err := h.service.GetObjectMetadata(c.Request.Context(), bucket, key)
+	var versionID *string
+	if v := c.Query("versionId"); v != "" {
+		versionID = &v
+	}
+
+	obj, err := h.service.HeadObject(c.Request.Context(), bucket, key, versionID)
 	if err != nil {
-		monitoring.Log.Error("Failed to head object",
-			zap.String("bucket", bucket),
-			zap.String("key", key),
-			zap.Error(err))
-		c.Status(http.StatusNotFound)
+		setDeleteMarkerHeader(c, err)
+		// HEAD responses have no body to carry the error
+		status, _ := serviceError(err)
+		if status == http.StatusInternalServerError {
+			monitoring.Log.Error("Failed to head object",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.Error(err))
+		}
+		c.Status(status)
+		return
+	}
+
+	if status := checkPreconditions(c.Request, obj.ETag, obj.ModifiedAt); status != 0 {
+		answerPrecondition(c, status, obj)
 		return
 	}
 
 	// Return metadata as headers
 	c.Header("Content-Type", obj.ContentType)
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("ETag", strongETag(obj.ETag))
+	c.Header("Last-Modified", obj.ModifiedAt.UTC().Format(http.TimeFormat))
+	setObjectHeaders(c, obj)
+
+	// Let resuming clients probe a range before issuing the GET
+	if rangeHeader := c.GetHeader("Range"); rangeHeader != "" &&
+		ifRangeMatches(c.GetHeader("If-Range"), obj.ETag, obj.ModifiedAt) {
+		r, err := parseRange(rangeHeader, obj.Size)
+		switch {
+		case errors.Is(err, errInvalidRange):
+			// Ignored, as GET ignores it
+		case err != nil:
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+			c.Status(http.StatusRequestedRangeNotSatisfiable)
+			return
+		default:
+			c.Header("Content-Range", r.ContentRange(obj.Size))
+			c.Header("Content-Length", strconv.FormatInt(r.Length(), 10))
+			c.Status(http.StatusPartialContent)
+			return
+		}
+	}
+
+	setChecksumHeader(c, obj)
 	c.Header("Content-Length", strconv.FormatInt(obj.Size, 10))
-	c.Header("ETag", obj.ETag)
-	c.Header("Last-Modified", obj.ModifiedAt.Format(http.TimeFormat))
 	c.Status(http.StatusOK)
 }
 
-// ListObjects lists objects in a bucket
+// ListObjects lists objects in a bucket, as JSON or, for clients asking
+// for XML, as an S3 ListBucketResult document
 func (h *ObjectHandler) ListObjects(c *gin.Context) {
 	bucket := c.Param("bucket")
 	prefix := c.Query("prefix")
@@ -121,6 +403,16 @@ func (h *ObjectHandler) ListObjects(c *gin.Context) {
 	startAfter := c.Query("start-after")
 	maxKeys := object.DefaultMaxKeys
 
+	if c.Query("format") == "ndjson" {
+		h.streamObjects(c, bucket, prefix, startAfter)
+		return
+	}
+	// S3 SDKs get the S3 document, with its V1 and V2 paging
+	if middleware.WantsXML(c) {
+		listBucketXML(c, h.service)
+		return
+	}
+
 	if maxKeysParam := c.Query("max-keys"); maxKeysParam != "" {
 		if mk, err := strconv.Atoi(maxKeysParam); err == nil {
 			maxKeys = mk
@@ -139,15 +431,77 @@ func (h *ObjectHandler) ListObjects(c *gin.Context) {
 
 	result, err := h.service.ListObjects(c.Request.Context(), bucket, prefix, opts)
 	if err != nil {
-		monitoring.Log.Error("Failed to list objects",
+		respondError(c, "Failed to list objects", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newObjectListInfo(result))
+}
+
+// PrefixStats returns the object count and size under each first-level
+// prefix of a bucket, so the "folders" using the most space can be found
+// without listing the bucket
+func (h *ObjectHandler) PrefixStats(c *gin.Context) {
+	bucket := c.Param("bucket")
+	if h.buckets != nil {
+		if _, err := h.buckets.GetBucket(c.Request.Context(), bucket); err != nil {
+			respondError(c, "Failed to get prefix statistics", err)
+			return
+		}
+	}
+
+	stats, err := h.service.PrefixStats(c.Request.Context(), bucket)
+	if err != nil {
+		respondError(c, "Failed to get prefix statistics", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bucket":   bucket,
+		"prefixes": stats,
+	})
+}
+
+// ndjsonFlushInterval is how many records are written between flushes
+const ndjsonFlushInterval = 100
+
+// streamObjects writes every matching object as one JSON record per line.
+// Records are produced while the bucket is walked, so memory stays bounded
+// regardless of bucket size; a slow client blocks the writer and a
+// disconnected one cancels the request context, stopping the walk.
+func (h *ObjectHandler) streamObjects(c *gin.Context, bucket, prefix, startAfter string) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	written := 0
+
+	err := h.service.WalkObjects(c.Request.Context(), bucket, prefix, startAfter, func(obj *object.Object) error {
+		if err := enc.Encode(newObjectInfo(obj)); err != nil {
+			return err
+		}
+		written++
+		if written%ndjsonFlushInterval == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		monitoring.Log.Error("Failed to stream objects",
 			zap.String("bucket", bucket),
 			zap.String("prefix", prefix),
+			zap.Int("written", written),
 			zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		// Headers are already sent; report the failure as a trailing record
+		_, code := serviceError(err)
+		_ = enc.Encode(gin.H{"error": s3.ErrorResponse{
+			Code:      code,
+			Message:   err.Error(),
+			Resource:  c.Request.URL.Path,
+			RequestID: middleware.GetRequestID(c),
+		}})
 	}
-
-	c.JSON(http.StatusOK, result)
+	c.Writer.Flush()
 }
 
 // DeleteAllObjects deletes all objects in a bucket
@@ -158,25 +512,41 @@ func (h *ObjectHandler) DeleteAllObjects(c *gin.Context) {
 	confirm := c.Query("confirm")
 
 	if confirm == "true" {
-		// Actually delete
-		count, totalSize, err := h.service.DeleteAllObjects(c.Request.Context(), bucket)
+		if h.jobs == nil {
+			// No job manager: purge synchronously
+			count, totalSize, err := h.service.DeleteAllObjects(actorContext(c), bucket)
+			if err != nil {
+				respondError(c, "Failed to delete objects", err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"deleted_count": count,
+				"freed_size":    totalSize,
+			})
+			return
+		}
+
+		// Purge in the background; large buckets would outlive the request
+		job, err := h.jobs.Submit(jobs.Spec{
+			Type:   jobs.TypePurge,
+			Key:    bucket,
+			Params: map[string]string{"bucket": bucket},
+		}, h.purgeJob(bucket, middleware.GetUserFromContext(c).AccessKeyID, c.ClientIP()))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, "Failed to submit job", err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"deleted_count": count,
-			"freed_size":    totalSize,
+		c.Header("Location", "/admin/v1/jobs/"+job.ID)
+		c.JSON(http.StatusAccepted, gin.H{
+			"job_id": job.ID,
+			"state":  job.State,
 		})
 	} else {
 		// Just get info using efficient count
 		count, totalSize, err := h.service.CountObjects(c.Request.Context(), bucket)
 		if err != nil {
-			monitoring.Log.Error("Failed to count objects",
-				zap.String("bucket", bucket),
-				zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondError(c, "Failed to count objects", err)
 			return
 		}
 
@@ -186,3 +556,52 @@ func (h *ObjectHandler) DeleteAllObjects(c *gin.Context) {
 		})
 	}
 }
+
+// purgeJob returns the job function that purges a bucket on behalf of the
+// requesting user
+func (h *ObjectHandler) purgeJob(bucket, actor, source string) jobs.RunFunc {
+	return func(ctx context.Context, handle *jobs.Handle) error {
+		ctx = object.WithActor(ctx, actor, source)
+		_, err := h.service.PurgeBucket(ctx, bucket, func(status object.PurgeStatus) {
+			handle.SetProgress(jobs.Progress{
+				Total: int64(status.Total),
+				Done:  int64(status.Deleted),
+				Bytes: status.FreedBytes,
+			})
+		})
+		return err
+	}
+}
+
+// PurgeProgress reports the progress of a running bucket purge
+func (h *ObjectHandler) PurgeProgress(c *gin.Context) {
+	bucket := c.Param("bucket")
+
+	status, ok := h.service.PurgeProgress(bucket)
+	if !ok {
+		middleware.Error(c, http.StatusNotFound, s3.NoSuchJob, "no purge in progress for bucket "+bucket)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// ScrubObject checks an object's data against its checksums chunk by chunk
+// and rewrites corrupt chunks with the replica's copy, unless ?repair=false
+func (h *ObjectHandler) ScrubObject(c *gin.Context) {
+	bucket := c.Param("bucket")
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	var versionID *string
+	if v := c.Query("versionId"); v != "" {
+		versionID = &v
+	}
+
+	result, err := h.service.ScrubObject(c.Request.Context(), bucket, key, versionID, c.Query("repair") != "false")
+	if err != nil {
+		respondError(c, "Failed to scrub object", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
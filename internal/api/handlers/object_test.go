@@ -2,30 +2,32 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/encryption"
 	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
 	"github.com/danielino/comio/internal/storage"
 )
 
-// mockEngine for testing
+// mockEngine for testing: a flat in-memory device with bump allocation
 type mockEngine struct {
-	data map[int64][]byte
+	data []byte
 }
 
 func newMockEngine() *mockEngine {
-	return &mockEngine{
-		data: make(map[int64][]byte),
-	}
+	return &mockEngine{}
 }
 
 func (m *mockEngine) Open(devicePath string) error { return nil }
@@ -35,32 +37,21 @@ func (m *mockEngine) Stats() storage.Stats         { return storage.Stats{} }
 func (m *mockEngine) BlockSize() int               { return 4096 }
 
 func (m *mockEngine) Allocate(size int64) (offset int64, err error) {
-	// Simple allocator - just return next offset
 	offset = int64(len(m.data))
+	m.data = append(m.data, make([]byte, size)...)
 	return offset, nil
 }
 
-func (m *mockEngine) Write(offset int64, data []byte) error {
-	m.data[offset] = append([]byte{}, data...)
+func (m *mockEngine) Write(ctx context.Context, offset int64, data []byte) error {
+	copy(m.data[offset:], data)
 	return nil
 }
 
-func (m *mockEngine) Read(offset, size int64) ([]byte, error) {
-	// Reconstruct data from chunks
-	var result []byte
-	for i := offset; i < offset+size; i++ {
-		if chunk, ok := m.data[i]; ok {
-			result = append(result, chunk...)
-		}
-	}
-	return result, nil
+func (m *mockEngine) Read(ctx context.Context, offset, size int64) ([]byte, error) {
+	return append([]byte{}, m.data[offset:offset+size]...), nil
 }
 
 func (m *mockEngine) Free(offset, size int64) error {
-	// Simple free - delete entries
-	for i := offset; i < offset+size; i++ {
-		delete(m.data, i)
-	}
 	return nil
 }
 
@@ -81,7 +72,9 @@ func setupObjectTest() (*gin.Engine, *object.Service, *bucket.Service) {
 	router.GET("/:bucket/:key", objectHandler.GetObject)
 	router.DELETE("/:bucket/:key", objectHandler.DeleteObject)
 	router.HEAD("/:bucket/:key", objectHandler.HeadObject)
+	router.PATCH("/:bucket/:key", objectHandler.UpdateObjectMetadata)
 	router.GET("/:bucket", objectHandler.ListObjects)
+	router.POST("/:bucket", objectHandler.DeleteObjects)
 
 	return router, objectService, bucketService
 }
@@ -136,6 +129,254 @@ func TestObjectHandler_GetObject(t *testing.T) {
 	// In a real implementation with proper mock, we'd verify the content
 }
 
+func TestObjectHandler_GetObject_Range(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "0123456789"
+	objectService.PutObject(nil, "test-bucket", "range-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		wantBody     string
+		contentRange string
+	}{
+		{"closed", "bytes=2-5", "2345", "bytes 2-5/10"},
+		{"open-ended", "bytes=7-", "789", "bytes 7-9/10"},
+		{"suffix", "bytes=-3", "789", "bytes 7-9/10"},
+		{"end past size", "bytes=8-100", "89", "bytes 8-9/10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/test-bucket/range-key", nil)
+			req.Header.Set("Range", tt.rangeHeader)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusPartialContent, w.Code)
+			assert.Equal(t, tt.wantBody, w.Body.String())
+			assert.Equal(t, tt.contentRange, w.Header().Get("Content-Range"))
+			assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		})
+	}
+}
+
+func TestObjectHandler_GetObject_RangeNotSatisfiable(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "0123456789"
+	objectService.PutObject(nil, "test-bucket", "range-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+
+	req, _ := http.NewRequest("GET", "/test-bucket/range-key", nil)
+	req.Header.Set("Range", "bytes=10-")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	assert.Equal(t, "bytes */10", w.Header().Get("Content-Range"))
+}
+
+func TestObjectHandler_GetObject_InvalidRangeIgnored(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "0123456789"
+	objectService.PutObject(nil, "test-bucket", "range-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+
+	for _, header := range []string{"bytes=abc", "items=0-1", "bytes=5-2", "bytes=0-1,3-4"} {
+		for _, method := range []string{"GET", "HEAD"} {
+			req, _ := http.NewRequest(method, "/test-bucket/range-key", nil)
+			req.Header.Set("Range", header)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code, "%s with Range %q", method, header)
+			assert.Empty(t, w.Header().Get("Content-Range"), "%s with Range %q", method, header)
+			if method == "GET" {
+				assert.Equal(t, content, w.Body.String(), "GET with Range %q", header)
+			}
+		}
+	}
+}
+
+func TestObjectHandler_GetObject_IfRange(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "0123456789"
+	obj, _ := objectService.PutObject(nil, "test-bucket", "range-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+
+	// Matching validator resumes the download
+	req, _ := http.NewRequest("GET", "/test-bucket/range-key", nil)
+	req.Header.Set("Range", "bytes=5-")
+	req.Header.Set("If-Range", `"`+obj.ETag+`"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "56789", w.Body.String())
+
+	// Stale validator returns the whole object
+	req, _ = http.NewRequest("GET", "/test-bucket/range-key", nil)
+	req.Header.Set("Range", "bytes=5-")
+	req.Header.Set("If-Range", `"stale"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.String())
+}
+
+func TestObjectHandler_GetObject_Conditional(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "0123456789"
+	obj, _ := objectService.PutObject(nil, "test-bucket", "cached-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+	etag := `"` + obj.ETag + `"`
+
+	serve := func(method, header, value string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/test-bucket/cached-key", nil)
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A cached copy that is still current is not sent again
+	w := serve("GET", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = serve("GET", "If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serve("GET", "If-None-Match", `"stale"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.String())
+
+	// Writers guarding on a version get 412 once it changed
+	w = serve("GET", "If-Match", `"stale"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Contains(t, w.Body.String(), "PreconditionFailed")
+
+	w = serve("HEAD", "If-Match", `"stale"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve("HEAD", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = serve("HEAD", "If-Match", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestObjectHandler_CopyObject(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+	objectService.PutObject(nil, "test-bucket", "src key", strings.NewReader("payload"), 7, "text/plain")
+
+	copyTo := func(key, directive, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/test-bucket/"+key, nil)
+		req.Header.Set("x-amz-copy-source", "/test-bucket/src%20key")
+		if directive != "" {
+			req.Header.Set("x-amz-metadata-directive", directive)
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := copyTo("copy", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var obj object.Object
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &obj))
+	assert.Equal(t, "text/plain", obj.ContentType)
+
+	req, _ := http.NewRequest("GET", "/test-bucket/copy", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "payload", w.Body.String())
+
+	// S3 clients get a CopyObjectResult
+	w = copyTo("replaced", "REPLACE", "application/xml")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<CopyObjectResult")
+	head, _ := objectService.HeadObject(nil, "test-bucket", "replaced", nil)
+	assert.Equal(t, "application/json", head.ContentType)
+
+	w = copyTo("bad", "MERGE", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = copyTo("src key", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestObjectHandler_DeleteObjects(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+	for _, key := range []string{"a", "b", "c"} {
+		objectService.PutObject(nil, "test-bucket", key, strings.NewReader(key), 1, "text/plain")
+	}
+
+	deleteKeys := func(body, accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/test-bucket?delete", strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Missing keys count as deleted
+	w := deleteKeys(`<Delete><Object><Key>a</Key></Object><Object><Key>missing</Key></Object></Delete>`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result DeleteResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, []DeletedObject{{Key: "a"}, {Key: "missing"}}, result.Deleted)
+	assert.Empty(t, result.Errors)
+	_, err := objectService.HeadObject(nil, "test-bucket", "a", nil)
+	assert.ErrorIs(t, err, object.ErrObjectNotFound)
+
+	// Quiet requests only list errors; S3 clients get a DeleteResult
+	w = deleteKeys(`<Delete><Quiet>true</Quiet><Object><Key>b</Key></Object><Object><Key>c</Key></Object></Delete>`, "application/xml")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<DeleteResult")
+	assert.NotContains(t, w.Body.String(), "<Deleted>")
+	_, err = objectService.HeadObject(nil, "test-bucket", "c", nil)
+	assert.ErrorIs(t, err, object.ErrObjectNotFound)
+
+	// Replicated batch deletes of a primary writing delete markers
+	objectService.PutObject(nil, "test-bucket", "d", strings.NewReader("d"), 1, "text/plain")
+	req, _ := http.NewRequest("POST", "/test-bucket?delete", strings.NewReader(`<Delete><Object><Key>d</Key></Object></Delete>`))
+	req.Header.Set(replication.HeaderDeleteMarker, "true")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	result = DeleteResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	if assert.Len(t, result.Deleted, 1) {
+		assert.True(t, result.Deleted[0].DeleteMarker)
+		assert.NotEmpty(t, result.Deleted[0].DeleteMarkerVersionID)
+	}
+	_, err = objectService.HeadObject(nil, "test-bucket", "d", nil)
+	assert.ErrorIs(t, err, object.ErrDeleteMarker)
+
+	w = deleteKeys(`<Delete>`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = deleteKeys("<Delete>"+strings.Repeat(" ", maxDeleteRequestSize)+"</Delete>", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	w = deleteKeys("<Delete>"+strings.Repeat("<Object><Key>k</Key></Object>", maxDeleteObjects+1)+"</Delete>", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestObjectHandler_GetObject_NotFound(t *testing.T) {
 	router, _, _ := setupObjectTest()
 
@@ -168,6 +409,69 @@ func TestObjectHandler_HeadObject(t *testing.T) {
 	assert.Empty(t, w.Body.String())
 }
 
+func TestObjectHandler_HeadObject_ResumptionHeaders(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "0123456789"
+	obj, _ := objectService.PutObject(nil, "test-bucket", "test-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+
+	req, _ := http.NewRequest("HEAD", "/test-bucket/test-key", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, `"`+obj.ETag+`"`, w.Header().Get("ETag"))
+
+	req, _ = http.NewRequest("HEAD", "/test-bucket/test-key", nil)
+	req.Header.Set("Range", "bytes=4-")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 4-9/10", w.Header().Get("Content-Range"))
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+}
+
+func TestObjectHandler_HeadObject_MetadataAndVersion(t *testing.T) {
+	router, _, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "hello"
+	req, _ := http.NewRequest("PUT", "/test-bucket/test-key", strings.NewReader(content))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Amz-Meta-Color", "blue")
+	req.Header.Set("Cache-Control", "max-age=60")
+	req.Header.Set("Content-Encoding", "identity")
+	req.Header.Set("X-Unrelated", "dropped")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var obj object.Object
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &obj))
+
+	req, _ = http.NewRequest("HEAD", "/test-bucket/test-key?versionId="+obj.VersionID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, obj.VersionID, w.Header().Get("x-amz-version-id"))
+	assert.Equal(t, "blue", w.Header().Get("x-amz-meta-color"))
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "identity", w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("X-Unrelated"))
+	// SHA-256 of "hello", base64 encoded as in S3
+	assert.Equal(t, "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=", w.Header().Get("x-amz-checksum-sha256"))
+
+	req, _ = http.NewRequest("HEAD", "/test-bucket/test-key?versionId=missing", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func TestObjectHandler_HeadObject_NotFound(t *testing.T) {
 	router, _, _ := setupObjectTest()
 
@@ -203,6 +507,37 @@ func TestObjectHandler_DeleteObject(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
+func TestObjectHandler_DeleteObject_ReplicatedDeleteMarker(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+	content := "Replicated"
+	objectService.PutObject(nil, "test-bucket", "marked-key",
+		strings.NewReader(content), int64(len(content)), "text/plain")
+
+	// A primary writing delete markers asks for one
+	req, _ := http.NewRequest("DELETE", "/test-bucket/marked-key", nil)
+	req.Header.Set(replication.HeaderDeleteMarker, "true")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "true", w.Header().Get("x-amz-delete-marker"))
+	assert.NotEmpty(t, w.Header().Get("x-amz-version-id"))
+
+	req, _ = http.NewRequest("GET", "/test-bucket/marked-key", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "true", w.Header().Get("x-amz-delete-marker"))
+
+	req, _ = http.NewRequest("HEAD", "/test-bucket/marked-key", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "true", w.Header().Get("x-amz-delete-marker"))
+}
+
 func TestObjectHandler_DeleteObject_NotFound(t *testing.T) {
 	router, _, _ := setupObjectTest()
 
@@ -271,6 +606,84 @@ func TestObjectHandler_ListObjects_WithPrefix(t *testing.T) {
 	}
 }
 
+func TestObjectHandler_ListObjects_EntryFields(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	ctx := object.WithActor(context.Background(), "AKIDUPLOADER", "127.0.0.1")
+	_, err := objectService.PutObject(ctx, "test-bucket", "report.csv",
+		strings.NewReader("a,b"), 3, "text/csv")
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/test-bucket", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Field names are lowercase throughout, including nested checksums
+	var raw struct {
+		Objects []map[string]interface{} `json:"objects"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	assert.Len(t, raw.Objects, 1)
+	entry := raw.Objects[0]
+	assert.Equal(t, "report.csv", entry["key"])
+	assert.Equal(t, "AKIDUPLOADER", entry["owner"])
+	assert.Equal(t, object.StorageClassStandard, entry["storage_class"])
+	checksum, ok := entry["checksum"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "SHA256", checksum["algorithm"])
+	assert.NotEmpty(t, checksum["value"])
+	assert.Contains(t, w.Body.String(), `"is_truncated":false`)
+}
+
+func TestObjectHandler_ListObjects_NDJSON(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	objects := []string{"a.txt", "b/c.txt", "b/d.txt"}
+	for _, key := range objects {
+		content := "content for " + key
+		objectService.PutObject(nil, "test-bucket", key,
+			strings.NewReader(content), int64(len(content)), "text/plain")
+	}
+
+	req, _ := http.NewRequest("GET", "/test-bucket?format=ndjson&prefix=b/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2)
+	for i, line := range lines {
+		var obj object.Object
+		assert.NoError(t, json.Unmarshal([]byte(line), &obj))
+		assert.Equal(t, objects[i+1], obj.Key)
+	}
+}
+
+func TestObjectHandler_EncryptedObjectFields(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+	keys, err := encryption.NewKeyring(map[int][]byte{1: bytes.Repeat([]byte{7}, 32)}, 0)
+	assert.NoError(t, err)
+	objectService.SetEncryption(keys, false)
+
+	put := serve(router, "PUT", "/test-bucket/secret.txt", "attack at dawn", nil)
+	assert.Equal(t, http.StatusOK, put.Code)
+
+	// Responses tell how objects are encrypted, never with which data key
+	// or where they are stored
+	for _, w := range []*httptest.ResponseRecorder{put, serve(router, "GET", "/test-bucket", "", nil), serve(router, "GET", "/test-bucket?format=ndjson", "", nil)} {
+		assert.Contains(t, w.Body.String(), `"key_version":1`)
+		for _, field := range []string{"wrapped_key", `"iv"`, "offset", "extents"} {
+			assert.NotContains(t, w.Body.String(), field)
+		}
+	}
+}
+
 func TestObjectHandler_PutObject_LargeContent(t *testing.T) {
 	router, _, bucketService := setupObjectTest()
 
@@ -350,3 +763,45 @@ func BenchmarkObjectHandler_GetObject(b *testing.B) {
 		io.Copy(io.Discard, w.Body)
 	}
 }
+
+func TestObjectHandler_GetObject_History(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	objectService.SetHistory(object.NewMemoryHistoryStore(0))
+
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+
+	content := "versioned"
+	req, _ := http.NewRequest("PUT", "/test-bucket/history-key", strings.NewReader(content))
+	req.ContentLength = int64(len(content))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("DELETE", "/test-bucket/history-key", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// History stays available after the object is gone
+	req, _ = http.NewRequest("GET", "/test-bucket/history-key?history", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Events []object.HistoryEvent `json:"events"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Events, 2) {
+		assert.Equal(t, object.HistoryPut, resp.Events[0].Op)
+		assert.Equal(t, object.HistoryDelete, resp.Events[1].Op)
+		assert.Equal(t, "anonymous", resp.Events[1].Actor)
+	}
+}
+
+func TestObjectHandler_GetObject_HistoryDisabled(t *testing.T) {
+	router, _, _ := setupObjectTest()
+
+	req, _ := http.NewRequest("GET", "/test-bucket/key?history", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/object"
+)
+
+// ObjectInfo is an object as JSON responses describe it. Where its data
+// lies on the device, the key it is encrypted with and the tenant its
+// bucket is stored under stay on the server.
+type ObjectInfo struct {
+	Key          string             `json:"key"`
+	BucketName   string             `json:"bucket_name"`
+	VersionID    string             `json:"version_id"`
+	Size         int64              `json:"size"`
+	ContentType  string             `json:"content_type"`
+	ETag         string             `json:"etag"`
+	Checksum     integrity.Checksum `json:"checksum"`
+	CreatedAt    time.Time          `json:"created_at"`
+	ModifiedAt   time.Time          `json:"modified_at"`
+	Metadata     map[string]string  `json:"metadata"`
+	StorageClass string             `json:"storage_class"`
+	Owner        string             `json:"owner,omitempty"`
+	DeleteMarker bool               `json:"delete_marker"`
+	ReplicatedAt *time.Time         `json:"replicated_at,omitempty"`
+	Parts        []object.PartInfo  `json:"parts,omitempty"`
+	Encryption   *EncryptionInfo    `json:"encryption,omitempty"`
+}
+
+// EncryptionInfo tells how an object is encrypted, without its data key
+type EncryptionInfo struct {
+	Algorithm  string `json:"algorithm"`
+	KeyVersion int    `json:"key_version"`
+}
+
+// ObjectListInfo is a page of a listing as JSON responses describe it
+type ObjectListInfo struct {
+	Objects        []*ObjectInfo `json:"objects"`
+	CommonPrefixes []string      `json:"common_prefixes"`
+	IsTruncated    bool          `json:"is_truncated"`
+	NextMarker     string        `json:"next_marker"`
+}
+
+// newObjectInfo describes obj for a response. Buckets of a tenant are
+// named as the tenant knows them, without the tenant.
+func newObjectInfo(obj *object.Object) *ObjectInfo {
+	_, bucketName := bucket.SplitName(obj.BucketName)
+	info := &ObjectInfo{
+		Key:          obj.Key,
+		BucketName:   bucketName,
+		VersionID:    obj.VersionID,
+		Size:         obj.Size,
+		ContentType:  obj.ContentType,
+		ETag:         obj.ETag,
+		Checksum:     obj.Checksum,
+		CreatedAt:    obj.CreatedAt,
+		ModifiedAt:   obj.ModifiedAt,
+		Metadata:     obj.Metadata,
+		StorageClass: obj.StorageClass,
+		Owner:        obj.Owner,
+		DeleteMarker: obj.DeleteMarker,
+		ReplicatedAt: obj.ReplicatedAt,
+		Parts:        obj.Parts,
+	}
+	if env := obj.Encryption; env != nil {
+		info.Encryption = &EncryptionInfo{Algorithm: env.Algorithm, KeyVersion: env.KeyVersion}
+	}
+	return info
+}
+
+// newObjectInfos describes the objects of a listing
+func newObjectInfos(objects []*object.Object) []*ObjectInfo {
+	infos := make([]*ObjectInfo, len(objects))
+	for i, obj := range objects {
+		infos[i] = newObjectInfo(obj)
+	}
+	return infos
+}
+
+// newObjectListInfo describes a page of a listing
+func newObjectListInfo(result *object.ListResult) *ObjectListInfo {
+	return &ObjectListInfo{
+		Objects:        newObjectInfos(result.Objects),
+		CommonPrefixes: result.CommonPrefixes,
+		IsTruncated:    result.IsTruncated,
+		NextMarker:     result.NextMarker,
+	}
+}
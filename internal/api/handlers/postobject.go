@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+const (
+	// maxPostObjectSize caps browser form uploads, as for a single PUT
+	maxPostObjectSize = 5 << 30
+	// postFormMemory is how much of a form is held in memory; the rest,
+	// including large files, is spooled to temporary files
+	postFormMemory = 10 << 20
+	// maxPostKeyLength is the longest object key, as for other uploads
+	maxPostKeyLength = 1024
+)
+
+// PostResponse is the response to a form upload with success_action_status 201
+type PostResponse struct {
+	XMLName  xml.Name `json:"-" xml:"PostResponse"`
+	Location string   `json:"location" xml:"Location"`
+	Bucket   string   `json:"bucket" xml:"Bucket"`
+	Key      string   `json:"key" xml:"Key"`
+	ETag     string   `json:"etag" xml:"ETag"`
+}
+
+// SetPostPolicyAuth verifies the signed policies of browser form uploads
+// against the authenticator's users. When required, unsigned forms are
+// refused.
+func (h *ObjectHandler) SetPostPolicyAuth(authenticator *auth.HMACAuthenticator, required bool) {
+	h.authenticator = authenticator
+	h.postAuthRequired = required
+}
+
+// PostObject uploads an object from an HTML form, POST /:bucket with
+// multipart/form-data. The form carries the key, which may contain
+// ${filename}, a base64 policy document with the conditions the upload
+// must meet, its SigV4 signature and the file as the last field.
+func (h *ObjectHandler) PostObject(c *gin.Context) {
+	bucketName := c.Param("bucket")
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPostObjectSize+postFormMemory)
+	if err := c.Request.ParseMultipartForm(postFormMemory); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "POST requires a multipart/form-data body: "+err.Error())
+		return
+	}
+	defer c.Request.MultipartForm.RemoveAll()
+
+	files := c.Request.MultipartForm.File["file"]
+	if len(files) != 1 {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "POST requires exactly one file field")
+		return
+	}
+	file := files[0]
+	// Policies and scopes name buckets as the tenant knows them
+	_, tenantBucket := bucket.SplitName(bucketName)
+	fields, err := postFormFields(c.Request.MultipartForm, tenantBucket)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+		return
+	}
+
+	user, err := h.verifyPostPolicy(fields, file.Size)
+	if err != nil {
+		respondError(c, "Failed to verify POST policy", err)
+		return
+	}
+	// Forms are not authenticated before the tenant is chosen, so signers
+	// are held to their tenant here
+	if tenant := middleware.GetTenantFromContext(c); h.postAuthRequired && user.Tenant != tenant && !user.IsAdmin() {
+		middleware.Error(c, http.StatusForbidden, s3.AccessDenied, "access denied: the access key belongs to another tenant")
+		return
+	}
+
+	key := strings.ReplaceAll(fields["key"], "${filename}", file.Filename)
+	if msg := invalidPostKey(key); msg != "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, msg)
+		return
+	}
+	if user.IsScoped() && !user.Allows(auth.ActionWrite, tenantBucket, key) {
+		middleware.Error(c, http.StatusForbidden, s3.AccessDenied, "access denied: outside the scope of this access key")
+		return
+	}
+
+	refund := func() {}
+	if h.buckets != nil {
+		var ok bool
+		if refund, ok = checkSpace(c, h.buckets, bucketName, file.Size); !ok {
+			return
+		}
+	}
+
+	contentType := fields["content-type"]
+	if contentType == "" {
+		contentType = file.Header.Get("Content-Type")
+	}
+
+	data, err := file.Open()
+	if err != nil {
+		refund()
+		respondError(c, "Failed to read form upload", err)
+		return
+	}
+	defer data.Close()
+
+	ctx := object.WithActor(c.Request.Context(), user.AccessKeyID, c.ClientIP())
+	obj, err := h.service.PutObjectWithMetadata(ctx, bucketName, key, data, file.Size, contentType, postMetadata(fields))
+	if err != nil {
+		refund()
+		respondError(c, "Failed to put object", err)
+		return
+	}
+
+	etag := strongETag(obj.ETag)
+	location := "/" + bucketName + "/" + (&url.URL{Path: key}).EscapedPath()
+	setEncryptionHeader(c, obj)
+	c.Header("ETag", etag)
+	c.Header("Location", location)
+
+	if redirect, err := url.Parse(fields["success_action_redirect"]); err == nil && redirect.IsAbs() {
+		query := redirect.Query()
+		query.Set("bucket", bucketName)
+		query.Set("key", key)
+		query.Set("etag", etag)
+		redirect.RawQuery = query.Encode()
+		c.Redirect(http.StatusSeeOther, redirect.String())
+		return
+	}
+
+	switch fields["success_action_status"] {
+	case "200":
+		c.Status(http.StatusOK)
+	case "201":
+		render(c, http.StatusCreated, PostResponse{Location: location, Bucket: bucketName, Key: key, ETag: etag})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// verifyPostPolicy checks a form's signature and policy and returns the
+// user it uploads as. Forms without a policy upload anonymously unless
+// signatures are required.
+func (h *ObjectHandler) verifyPostPolicy(fields map[string]string, size int64) (*auth.User, error) {
+	anonymous := &auth.User{AccessKeyID: "anonymous", Username: "default"}
+
+	encoded := fields["policy"]
+	if encoded == "" {
+		if h.postAuthRequired {
+			return nil, fmt.Errorf("%w: the form has no policy", auth.ErrPostSignature)
+		}
+		return anonymous, nil
+	}
+
+	user := anonymous
+	if signature := fields["x-amz-signature"]; signature != "" || h.postAuthRequired {
+		if h.authenticator == nil {
+			return nil, fmt.Errorf("%w: no users to verify against", auth.ErrPostSignature)
+		}
+		if fields["x-amz-algorithm"] != auth.PostPolicyAlgorithm {
+			return nil, fmt.Errorf("%w: x-amz-algorithm must be %s", auth.ErrPostSignature, auth.PostPolicyAlgorithm)
+		}
+		signer, err := h.authenticator.VerifyPostPolicy(fields["x-amz-credential"], encoded, signature)
+		if err != nil {
+			return nil, err
+		}
+		user = signer
+	}
+
+	policy, err := auth.ParsePostPolicy(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.Check(fields, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := policy.CheckSize(size); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// postFormFields returns the form's fields keyed by lowercase name, with
+// the bucket from the URL so policies can name it. A field given twice is
+// refused, as the policy could check one value and the upload use another.
+func postFormFields(form *multipart.Form, bucketName string) (map[string]string, error) {
+	fields := map[string]string{"bucket": bucketName}
+	for name, values := range form.Value {
+		lower := strings.ToLower(name)
+		if _, dup := fields[lower]; dup || len(values) > 1 {
+			return nil, fmt.Errorf("form field %s is given more than once", lower)
+		}
+		fields[lower] = values[0]
+	}
+	return fields, nil
+}
+
+// postMetadata collects the user metadata and stored headers of a form
+// upload, as objectMetadata does for a PUT
+func postMetadata(fields map[string]string) map[string]string {
+	var metadata map[string]string
+	for name, value := range fields {
+		if !strings.HasPrefix(name, userMetadataPrefix) && !slices.Contains(storedHeaders, name) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[name] = value
+	}
+	return metadata
+}
+
+// invalidPostKey describes what is wrong with a form's key, which does not
+// pass through the key validation of the object routes
+func invalidPostKey(key string) string {
+	switch {
+	case strings.TrimSpace(key) == "":
+		return "POST requires a key field"
+	case len(key) > maxPostKeyLength:
+		return "object key exceeds maximum length of 1024 characters"
+	case strings.ContainsRune(key, 0):
+		return "object key cannot contain NUL bytes"
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return "object key cannot contain . or .. path segments"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/raft"
+)
+
+// RaftHandler reports the state of the metadata raft group
+type RaftHandler struct {
+	node *raft.Node
+}
+
+func NewRaftHandler(node *raft.Node) *RaftHandler {
+	return &RaftHandler{
+		node: node,
+	}
+}
+
+// GetStatus returns this node's view of the raft group
+func (h *RaftHandler) GetStatus(c *gin.Context) {
+	if h.node == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": true,
+		"status":  h.node.Status(),
+	})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// errInvalidRange is returned when a Range header cannot be parsed, or
+	// asks for several ranges. Such a header is ignored and the whole
+	// object served.
+	errInvalidRange = errors.New("invalid range")
+	// errRangeNotSatisfiable is returned when a well-formed Range lies
+	// outside the object
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// byteRange is a resolved, inclusive byte range within an object
+type byteRange struct {
+	Start int64
+	End   int64
+}
+
+// Length returns the number of bytes covered by the range
+func (r byteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// ContentRange formats the range as a Content-Range header value
+func (r byteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)
+}
+
+// parseRange parses a single-range Range header against an object size.
+// Supported forms are "bytes=N-M", open-ended "bytes=N-" (used by resuming
+// clients such as curl -C -) and suffix "bytes=-N".
+func parseRange(header string, size int64) (*byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errInvalidRange
+	}
+
+	spec := strings.TrimSpace(strings.TrimPrefix(header, prefix))
+	if strings.Contains(spec, ",") {
+		// Multipart/byteranges responses are not supported
+		return nil, errInvalidRange
+	}
+
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, errInvalidRange
+	}
+	startStr = strings.TrimSpace(startStr)
+	endStr = strings.TrimSpace(endStr)
+
+	// Suffix range: last N bytes
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n < 0 {
+			return nil, errInvalidRange
+		}
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return &byteRange{Start: size - n, End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return nil, errInvalidRange
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, errInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return &byteRange{Start: start, End: end}, nil
+}
+
+// strongETag formats a stored ETag as a quoted strong validator
+func strongETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// ifRangeMatches reports whether an If-Range precondition allows serving a
+// partial response. The validator may be a strong ETag or an HTTP date that
+// must exactly match the object's modification time.
+func ifRangeMatches(ifRange, etag string, modifiedAt time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == strongETag(etag)
+	}
+	if strings.HasPrefix(ifRange, "W/") {
+		// Weak validators are never usable for range requests
+		return false
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return modifiedAt.Truncate(time.Second).Equal(t)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header    string
+		size      int64
+		wantStart int64
+		wantEnd   int64
+		wantErr   error
+	}{
+		{"bytes=0-0", 10, 0, 0, nil},
+		{"bytes=0-9", 10, 0, 9, nil},
+		{"bytes=3-", 10, 3, 9, nil},
+		{"bytes=-4", 10, 6, 9, nil},
+		{"bytes=-20", 10, 0, 9, nil},
+		{"bytes=5-50", 10, 5, 9, nil},
+		{"bytes=10-", 10, 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 10, 0, 0, errRangeNotSatisfiable},
+		{"bytes=5-2", 10, 0, 0, errInvalidRange},
+		{"bytes=0-1,3-4", 10, 0, 0, errInvalidRange},
+		{"items=0-1", 10, 0, 0, errInvalidRange},
+		{"bytes=abc", 10, 0, 0, errInvalidRange},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRange(tt.header, tt.size)
+		if err != tt.wantErr {
+			t.Errorf("parseRange(%q) error = %v, want %v", tt.header, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r.Start != tt.wantStart || r.End != tt.wantEnd {
+			t.Errorf("parseRange(%q) = %d-%d, want %d-%d", tt.header, r.Start, r.End, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// RegistryHandler serves the S3 operations a container registry's S3
+// storage driver needs beyond the plain object and multipart handlers:
+// delimited XML listings, server-side copies for moving uploads into
+// place and batch deletes
+type RegistryHandler struct {
+	service *object.Service
+}
+
+// NewRegistryHandler creates a registry compatibility handler
+func NewRegistryHandler(service *object.Service) *RegistryHandler {
+	return &RegistryHandler{
+		service: service,
+	}
+}
+
+// ListBucketResult is an S3 ListObjects (V1 or V2) response
+type ListBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                string         `xml:"Marker,omitempty"`                // V1
+	NextMarker            string         `xml:"NextMarker,omitempty"`            // V1
+	StartAfter            string         `xml:"StartAfter,omitempty"`            // V2
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`     // V2
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"` // V2
+	KeyCount              *int           `xml:"KeyCount,omitempty"`              // V2
+	Contents              []ListEntry    `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// ListEntry describes an object in a listing
+type ListEntry struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+// CommonPrefix is a key prefix rolled up by the listing's delimiter
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// CopyObjectResult is the response to a server-side copy
+type CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	Xmlns        string    `xml:"xmlns,attr"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// ListObjects lists a bucket as S3 ListObjects does, V2 with list-type=2.
+// Keys sharing a prefix up to the delimiter are rolled up into a single
+// common prefix, which is how the driver walks directories.
+func (h *RegistryHandler) ListObjects(c *gin.Context) {
+	listBucketXML(c, h.service)
+}
+
+// listBucketXML answers a listing of the bucket in the request with an S3
+// ListBucketResult document, V2 with list-type=2
+func listBucketXML(c *gin.Context, service *object.Service) {
+	encode, encodingType, ok := listEncoding(c)
+	if !ok {
+		return
+	}
+
+	maxKeys := object.DefaultMaxKeys
+	if v := c.Query("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid max-keys")
+			return
+		}
+		maxKeys = min(n, object.MaxKeysLimit)
+	}
+
+	resp := ListBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      c.Param("bucket"),
+		Prefix:    c.Query("prefix"),
+		Delimiter: c.Query("delimiter"),
+		MaxKeys:   maxKeys,
+	}
+
+	v2 := c.Query("list-type") == "2"
+	marker := c.Query("marker")
+	if v2 {
+		resp.StartAfter = c.Query("start-after")
+		resp.ContinuationToken = c.Query("continuation-token")
+		marker = resp.StartAfter
+		if resp.ContinuationToken != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(resp.ContinuationToken)
+			if err != nil {
+				middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid continuation-token")
+				return
+			}
+			marker = string(decoded)
+		}
+	} else {
+		resp.Marker = marker
+	}
+
+	next, err := listBucket(c, service, &resp, marker)
+	if err != nil {
+		respondError(c, "Failed to list objects", err)
+		return
+	}
+
+	if v2 {
+		count := len(resp.Contents) + len(resp.CommonPrefixes)
+		resp.KeyCount = &count
+		if resp.IsTruncated {
+			resp.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(next))
+		}
+	} else if resp.IsTruncated {
+		resp.NextMarker = next
+	}
+
+	// Continuation tokens are opaque and need no encoding
+	if encodingType != "" {
+		resp.EncodingType = encodingType
+		resp.Prefix, resp.Delimiter = encode(resp.Prefix), encode(resp.Delimiter)
+		resp.Marker, resp.NextMarker, resp.StartAfter = encode(resp.Marker), encode(resp.NextMarker), encode(resp.StartAfter)
+		for i := range resp.Contents {
+			resp.Contents[i].Key = encode(resp.Contents[i].Key)
+		}
+		for i := range resp.CommonPrefixes {
+			resp.CommonPrefixes[i].Prefix = encode(resp.CommonPrefixes[i].Prefix)
+		}
+	}
+
+	c.XML(http.StatusOK, resp)
+}
+
+// listBucket fills a listing with up to MaxKeys entries after marker and
+// returns the marker to continue from
+func listBucket(c *gin.Context, service *object.Service, resp *ListBucketResult, marker string) (string, error) {
+	prefix, delimiter := resp.Prefix, resp.Delimiter
+
+	// skipPast starts after every key beginning with p
+	skipPast := func(p string) string { return p + "\U0010FFFF" }
+
+	startAfter := marker
+	if delimiter != "" && strings.HasPrefix(marker, prefix) && strings.HasSuffix(marker, delimiter) {
+		// The marker is a common prefix returned by the previous page
+		startAfter = skipPast(marker)
+	}
+
+	next := ""
+	for {
+		page, err := service.ListObjects(c.Request.Context(), resp.Name, prefix, object.ListOptions{
+			Prefix:     prefix,
+			StartAfter: startAfter,
+			MaxKeys:    object.DefaultMaxKeys,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		skipped := false
+		for _, obj := range page.Objects {
+			if obj.DeleteMarker {
+				startAfter = obj.Key
+				continue
+			}
+			if len(resp.Contents)+len(resp.CommonPrefixes) == resp.MaxKeys {
+				resp.IsTruncated = true
+				return next, nil
+			}
+
+			if delimiter != "" {
+				if i := strings.Index(obj.Key[len(prefix):], delimiter); i >= 0 {
+					common := obj.Key[:len(prefix)+i+len(delimiter)]
+					resp.CommonPrefixes = append(resp.CommonPrefixes, CommonPrefix{Prefix: common})
+					next = common
+					startAfter = skipPast(common)
+					skipped = true
+					break
+				}
+			}
+
+			resp.Contents = append(resp.Contents, ListEntry{
+				Key:          obj.Key,
+				LastModified: obj.ModifiedAt.UTC(),
+				ETag:         strongETag(obj.ETag),
+				Size:         obj.Size,
+				StorageClass: obj.StorageClass,
+			})
+			next = obj.Key
+			startAfter = obj.Key
+		}
+
+		// Rolling up a prefix restarts the listing past its keys
+		if !skipped && (!page.IsTruncated || len(page.Objects) == 0) {
+			return next, nil
+		}
+	}
+}
+
+// PutObject stores an object, or copies one server-side when the request
+// names an x-amz-copy-source
+func (h *RegistryHandler) PutObject(c *gin.Context) {
+	if source := c.GetHeader("x-amz-copy-source"); source != "" {
+		copyObject(c, h.service, nil, source)
+		return
+	}
+
+	obj, err := h.service.PutObjectWithMetadata(actorContext(c), c.Param("bucket"), c.Param("key"),
+		c.Request.Body, c.Request.ContentLength, c.GetHeader("Content-Type"), objectMetadata(c))
+	if err != nil {
+		respondError(c, "Failed to put object", err)
+		return
+	}
+
+	c.Header("ETag", strongETag(obj.ETag))
+	setEncryptionHeader(c, obj)
+	c.Status(http.StatusOK)
+}
+
+// DeleteObject deletes an object. As in S3, deleting a missing key
+// succeeds.
+func (h *RegistryHandler) DeleteObject(c *gin.Context) {
+	err := h.service.DeleteObject(actorContext(c), c.Param("bucket"), c.Param("key"))
+	if err != nil && !errors.Is(err, object.ErrObjectNotFound) {
+		respondError(c, "Failed to delete object", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteObjects deletes up to maxDeleteObjects keys (POST /:bucket?delete),
+// reporting the outcome for each key
+func (h *RegistryHandler) DeleteObjects(c *gin.Context) {
+	if _, ok := c.GetQuery("delete"); !ok {
+		middleware.Error(c, http.StatusMethodNotAllowed, s3.MethodNotAllowed, "only POST ?delete is supported on a bucket")
+		return
+	}
+	deleteObjects(c, h.service)
+}
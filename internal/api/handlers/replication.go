@@ -1,38 +1,176 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
 	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 type ReplicationHandler struct {
-	replicator *replication.Replicator
+	replicator    *replication.Replicator
+	objectService *object.Service
+	bucketService *bucket.Service
+	replica       *replication.ReplicaState
+	jobs          *jobs.Manager
 }
 
-func NewReplicationHandler(replicator *replication.Replicator) *ReplicationHandler {
+func NewReplicationHandler(replicator *replication.Replicator, objectService *object.Service) *ReplicationHandler {
 	return &ReplicationHandler{
-		replicator: replicator,
+		replicator:    replicator,
+		objectService: objectService,
 	}
 }
 
-func (h *ReplicationHandler) GetStatus(c *gin.Context) {
-	if h.replicator == nil {
-		c.JSON(http.StatusOK, gin.H{
-			"enabled": false,
-		})
+// SetReplicaState records applied patches when serving as a read replica
+func (h *ReplicationHandler) SetReplicaState(replica *replication.ReplicaState) {
+	h.replica = replica
+}
+
+// SetBucketService enables turning replication on and off per bucket
+func (h *ReplicationHandler) SetBucketService(bucketService *bucket.Service) {
+	h.bucketService = bucketService
+}
+
+// SetJobs enables resyncing buckets in background jobs
+func (h *ReplicationHandler) SetJobs(manager *jobs.Manager) {
+	h.jobs = manager
+}
+
+// ResyncBucket queues every object of a bucket for replication again in a
+// background job
+func (h *ReplicationHandler) ResyncBucket(c *gin.Context) {
+	if h.jobs == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "background jobs are not enabled")
+		return
+	}
+	bucketName := c.Param("bucket")
+	if _, err := h.bucketService.GetBucket(c.Request.Context(), bucketName); err != nil {
+		respondError(c, "Failed to get bucket", err)
 		return
 	}
 
-	stats := h.replicator.GetStats()
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type:   jobs.TypeResync,
+		Key:    bucketName,
+		Params: map[string]string{"bucket": bucketName},
+	}, func(ctx context.Context, jh *jobs.Handle) error {
+		summary, err := h.objectService.ResyncBucket(ctx, bucketName, func(s object.ResyncSummary) {
+			jh.SetProgress(jobs.Progress{Done: s.Objects + s.Deleted, Bytes: s.Bytes})
+		})
+		jh.SetMessage(fmt.Sprintf("queued %d objects and %d deletes", summary.Objects, summary.Deleted))
+		return err
+	})
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"enabled":           true,
-		"events_queued":     stats.EventsQueued,
-		"events_replicated": stats.EventsReplicated,
-		"events_failed":     stats.EventsFailed,
-		"last_replication":  stats.LastReplication,
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"state":  job.State,
 	})
 }
+
+// BucketReplication is the body of PUT /admin/v1/buckets/:bucket/replication
+type BucketReplication struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// GetBucketReplication reports whether a bucket's objects are replicated
+func (h *ReplicationHandler) GetBucketReplication(c *gin.Context) {
+	b, err := h.bucketService.GetBucket(c.Request.Context(), c.Param("bucket"))
+	if err != nil {
+		respondError(c, "Failed to get bucket", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bucket": b.Name, "enabled": !b.ReplicationDisabled})
+}
+
+// SetBucketReplication enables or disables replication of a bucket at
+// runtime. Events already queued are still sent; disabling does not remove
+// what was replicated.
+func (h *ReplicationHandler) SetBucketReplication(c *gin.Context) {
+	var req BucketReplication
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+	if req.Enabled == nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "enabled is required")
+		return
+	}
+
+	b, err := h.bucketService.SetReplication(c.Request.Context(), c.Param("bucket"), *req.Enabled)
+	if err != nil {
+		respondError(c, "Failed to update bucket replication", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bucket": b.Name, "enabled": !b.ReplicationDisabled})
+}
+
+func (h *ReplicationHandler) GetStatus(c *gin.Context) {
+	status := gin.H{
+		"enabled": false,
+	}
+
+	if h.replicator != nil {
+		stats := h.replicator.GetStats()
+		status = gin.H{
+			"enabled":           true,
+			"events_queued":     stats.EventsQueued,
+			"events_replicated": stats.EventsReplicated,
+			"events_failed":     stats.EventsFailed,
+			"last_replication":  stats.LastReplication,
+			"delta_bytes_saved": stats.DeltaBytesSaved,
+		}
+	}
+
+	if h.replica != nil {
+		replica := gin.H{}
+		if lag, ok := h.replica.Lag(); ok {
+			replica["lag_seconds"] = lag.Seconds()
+		}
+		status["read_replica"] = replica
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// ApplyPatch applies an overwrite sent by a replication source as a delta
+// against the version this replica holds
+func (h *ReplicationHandler) ApplyPatch(c *gin.Context) {
+	var patch replication.Patch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+	if patch.Bucket == "" || patch.Key == "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "bucket and key are required")
+		return
+	}
+
+	obj, err := h.objectService.ApplyReplicationPatch(c.Request.Context(), &patch)
+	if err != nil {
+		respondError(c, "Failed to apply replication patch", err)
+		return
+	}
+
+	if h.replica != nil {
+		if written, err := replication.ParseConsistencyToken(c.GetHeader(replication.HeaderReplicationTimestamp)); err == nil {
+			h.replica.Record(patch.Bucket, patch.Key, written)
+		}
+	}
+
+	c.Header("ETag", obj.ETag)
+	c.JSON(http.StatusOK, gin.H{"etag": obj.ETag, "size": obj.Size})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/billing"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ReportHandler serves the monthly chargeback reports of the storage and
+// traffic of each bucket and owner
+type ReportHandler struct {
+	reporter *billing.Reporter
+	jobs     *jobs.Manager
+}
+
+// NewReportHandler creates a report handler. reporter may be nil when
+// billing is disabled.
+func NewReportHandler(reporter *billing.Reporter, jobManager *jobs.Manager) *ReportHandler {
+	return &ReportHandler{reporter: reporter, jobs: jobManager}
+}
+
+// ListReports lists the reports written to the report bucket
+func (h *ReportHandler) ListReports(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	stored, err := h.reporter.List(c.Request.Context())
+	if err != nil {
+		respondError(c, "Failed to list reports", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"bucket": h.reporter.Bucket(), "reports": stored})
+}
+
+// GetReport computes the report of a month, YYYY-MM, from the usage
+// counters. The current month is reported up to the current hour. With
+// ?format=csv, the report is returned as CSV.
+func (h *ReportHandler) GetReport(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	month, ok := reportMonth(c)
+	if !ok {
+		return
+	}
+	report, err := h.reporter.Generate(c.Request.Context(), month)
+	if err != nil {
+		respondError(c, "Failed to generate report", err)
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="`+report.Month+`.csv"`)
+		c.Status(http.StatusOK)
+		billing.WriteCSV(c.Writer, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// PublishReport writes the report of a month to the report bucket in a
+// background job, replacing an earlier one
+func (h *ReportHandler) PublishReport(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+	if h.reporter.Bucket() == "" {
+		respondError(c, "Failed to publish report", billing.ErrNoBucket)
+		return
+	}
+	if h.jobs == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "background jobs are not enabled")
+		return
+	}
+	month, ok := reportMonth(c)
+	if !ok {
+		return
+	}
+
+	name := month.Format(billing.MonthLayout)
+	job, err := h.jobs.Submit(jobs.Spec{
+		Type:   jobs.TypeReport,
+		Key:    name,
+		Params: map[string]string{"month": name, "bucket": h.reporter.Bucket()},
+	}, func(ctx context.Context, jh *jobs.Handle) error {
+		report, err := h.reporter.Generate(ctx, month)
+		if err != nil {
+			return err
+		}
+		if err := h.reporter.Publish(ctx, report); err != nil {
+			return err
+		}
+		jh.SetProgress(jobs.Progress{Total: int64(len(report.Buckets)), Done: int64(len(report.Buckets))})
+		return nil
+	})
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"month":  name,
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
+
+func (h *ReportHandler) enabled(c *gin.Context) bool {
+	if h.reporter == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "billing reports are disabled")
+		return false
+	}
+	return true
+}
+
+// reportMonth returns the month of a report request, having answered it
+// with 400 if it is invalid
+func reportMonth(c *gin.Context) (time.Time, bool) {
+	month, err := billing.ParseMonth(c.Param("month"))
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+		return time.Time{}, false
+	}
+	return month, true
+}
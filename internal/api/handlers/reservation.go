@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// HeaderReservation names the reservation a write takes its space from
+const HeaderReservation = "X-Comio-Reservation"
+
+// ReservationHandler manages space reservations of buckets
+type ReservationHandler struct {
+	buckets *bucket.Service
+}
+
+func NewReservationHandler(buckets *bucket.Service) *ReservationHandler {
+	return &ReservationHandler{buckets: buckets}
+}
+
+// createReservationRequest is the body of POST /buckets/:bucket/reservations
+type createReservationRequest struct {
+	Size int64  `json:"size"`
+	TTL  string `json:"ttl"`
+}
+
+// CreateReservation sets space aside in a bucket for a batch of writes
+func (h *ReservationHandler) CreateReservation(c *gin.Context) {
+	var req createReservationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = parseWindow(req.TTL); err != nil || ttl <= 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid ttl: "+req.TTL)
+			return
+		}
+	}
+
+	res, err := h.buckets.Reserve(c.Request.Context(), c.Param("bucket"), req.Size, ttl)
+	if err != nil {
+		respondError(c, "Failed to reserve space", err)
+		return
+	}
+	c.JSON(http.StatusCreated, res)
+}
+
+// ListReservations lists the reservations, optionally only those of one bucket
+func (h *ReservationHandler) ListReservations(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"reservations": h.buckets.ListReservations(c.Query("bucket")),
+	})
+}
+
+// GetReservation returns a reservation and how much of it is left
+func (h *ReservationHandler) GetReservation(c *gin.Context) {
+	res, err := h.buckets.GetReservation(c.Param("token"))
+	if err != nil {
+		respondError(c, "Failed to get reservation", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"reservation": res,
+		"remaining":   res.Remaining(),
+	})
+}
+
+// ReleaseReservation gives back what is left of a reservation
+func (h *ReservationHandler) ReleaseReservation(c *gin.Context) {
+	if err := h.buckets.ReleaseReservation(c.Param("token")); err != nil {
+		respondError(c, "Failed to release reservation", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkSpace checks that a write of size bytes fits in a bucket: in the
+// reservation named by the request if there is one, in the bucket's quota
+// otherwise. It responds with an error and returns false if it does not.
+// The returned func gives the space taken back when the write fails.
+func checkSpace(c *gin.Context, buckets *bucket.Service, name string, size int64) (func(), bool) {
+	token := c.GetHeader(HeaderReservation)
+	if token == "" {
+		if err := buckets.CheckQuota(c.Request.Context(), name, size); err != nil {
+			respondError(c, "Failed to check bucket quota", err)
+			return nil, false
+		}
+		return func() {}, true
+	}
+
+	if err := buckets.UseReservation(token, name, size); err != nil {
+		respondError(c, "Failed to use reservation", err)
+		return nil, false
+	}
+	return func() { buckets.RefundReservation(token, size) }, true
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// Headers of the resumable upload protocol
+const (
+	headerUploadLength = "Upload-Length" // Object size, when creating a session
+	headerUploadOffset = "Upload-Offset" // Where a chunk starts, and the bytes received
+)
+
+// CreateSession starts a resumable upload, POST /:bucket/:key?resumable
+// with the object size in Upload-Length. The session URL is returned in
+// Location.
+func (h *MultipartHandler) CreateSession(c *gin.Context) {
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+
+	size, err := strconv.ParseInt(c.GetHeader(headerUploadLength), 10, 64)
+	if err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "Upload-Length header with the object size is required")
+		return
+	}
+
+	session, err := h.service.CreateSession(actorContext(c), bucket, key, c.GetHeader("Content-Type"), size)
+	if err != nil {
+		respondError(c, "Failed to create resumable upload", err)
+		return
+	}
+
+	c.Header("Location", c.Request.URL.Path+"?session="+url.QueryEscape(session.SessionID))
+	setSessionHeaders(c, session)
+	c.JSON(http.StatusCreated, session)
+}
+
+// UploadChunk appends data to a session, PATCH /:bucket/:key?session=<id>
+// with Upload-Offset set to the bytes received so far. The new offset is
+// returned in Upload-Offset.
+func (h *MultipartHandler) UploadChunk(c *gin.Context) {
+	bucket := c.Param("bucket")
+	key := c.Param("key")
+
+	offset, err := strconv.ParseInt(c.GetHeader(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "Upload-Offset header is required")
+		return
+	}
+	if c.Request.ContentLength < 0 {
+		middleware.Error(c, http.StatusLengthRequired, s3.MissingContentLength, "Content-Length header is required")
+		return
+	}
+
+	session, err := h.service.WriteChunk(c.Request.Context(), bucket, key, c.Query("session"), offset, c.Request.Body, c.Request.ContentLength)
+	if session != nil {
+		setSessionHeaders(c, session)
+	}
+	if err != nil {
+		respondError(c, "Failed to write resumable upload chunk", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetSession reports how much of a session has been received, HEAD
+// /:bucket/:key?session=<id>, for clients resuming after a failure
+func (h *MultipartHandler) GetSession(c *gin.Context) {
+	session, err := h.service.GetSession(c.Request.Context(), c.Param("bucket"), c.Param("key"), c.Query("session"))
+	if err != nil {
+		status, _ := serviceError(err)
+		c.Status(status)
+		return
+	}
+	setSessionHeaders(c, session)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// CompleteSession stores a fully received session as the object, POST
+// /:bucket/:key?session=<id>
+func (h *MultipartHandler) CompleteSession(c *gin.Context) {
+	obj, err := h.service.CompleteSession(actorContext(c), c.Param("bucket"), c.Param("key"), c.Query("session"))
+	if err != nil {
+		respondError(c, "Failed to complete resumable upload", err)
+		return
+	}
+
+	setEncryptionHeader(c, obj)
+	c.Header(replication.HeaderConsistencyToken, replication.ConsistencyToken(obj.ModifiedAt))
+	c.JSON(http.StatusOK, newObjectInfo(obj))
+}
+
+// AbortSession discards a session, DELETE /:bucket/:key?session=<id>
+func (h *MultipartHandler) AbortSession(c *gin.Context) {
+	if err := h.service.AbortSession(c.Request.Context(), c.Param("bucket"), c.Param("key"), c.Query("session")); err != nil {
+		respondError(c, "Failed to abort resumable upload", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// setSessionHeaders reports a session's progress
+func setSessionHeaders(c *gin.Context, session *multipart.Session) {
+	c.Header(headerUploadOffset, strconv.FormatInt(session.Offset, 10))
+	c.Header(headerUploadLength, strconv.FormatInt(session.Size, 10))
+}
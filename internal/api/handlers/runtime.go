@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bufpool"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// RuntimeHandler inspects and tunes the Go runtime and the buffer pools of
+// a live node
+type RuntimeHandler struct{}
+
+func NewRuntimeHandler() *RuntimeHandler {
+	return &RuntimeHandler{}
+}
+
+// runtimeSettings are the settings GET /runtime reports and PUT /runtime
+// changes. Settings left out of a PUT are kept.
+type runtimeSettings struct {
+	GOMAXPROCS  *int           `json:"gomaxprocs,omitempty"`
+	GCPercent   *int           `json:"gc_percent,omitempty"` // -1 turns the collector off
+	BufferSizes map[string]int `json:"buffer_sizes,omitempty"`
+}
+
+// GetRuntime returns the runtime settings along with memory and scheduler
+// figures to judge them by
+func (h *RuntimeHandler) GetRuntime(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	c.JSON(http.StatusOK, gin.H{
+		"go_version":   runtime.Version(),
+		"num_cpu":      runtime.NumCPU(),
+		"gomaxprocs":   runtime.GOMAXPROCS(0),
+		"gc_percent":   gcPercent(),
+		"goroutines":   runtime.NumGoroutine(),
+		"buffer_sizes": bufpool.Sizes(),
+		"memory": gin.H{
+			"heap_alloc_bytes":    mem.HeapAlloc,
+			"heap_inuse_bytes":    mem.HeapInuse,
+			"heap_objects":        mem.HeapObjects,
+			"sys_bytes":           mem.Sys,
+			"next_gc_bytes":       mem.NextGC,
+			"num_gc":              mem.NumGC,
+			"gc_pause_total_ns":   mem.PauseTotalNs,
+			"gc_cpu_fraction":     mem.GCCPUFraction,
+			"total_alloc_bytes":   mem.TotalAlloc,
+			"mallocs":             mem.Mallocs,
+			"frees":               mem.Frees,
+			"stack_inuse_bytes":   mem.StackInuse,
+			"heap_released_bytes": mem.HeapReleased,
+		},
+	})
+}
+
+// SetRuntime changes the runtime settings given. Nothing is changed unless
+// every setting is valid. Changes last until the process restarts.
+func (h *RuntimeHandler) SetRuntime(c *gin.Context) {
+	var req runtimeSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+
+	if req.GOMAXPROCS != nil && *req.GOMAXPROCS < 1 {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "gomaxprocs must be at least 1")
+		return
+	}
+	if req.GCPercent != nil && *req.GCPercent < -1 {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "gc_percent must be -1 or more")
+		return
+	}
+	sizes := bufpool.Sizes()
+	for name, size := range req.BufferSizes {
+		if _, ok := sizes[name]; !ok {
+			respondError(c, "Failed to resize buffer pool", fmt.Errorf("%w: %s", bufpool.ErrUnknownPool, name))
+			return
+		}
+		if size < bufpool.MinSize || size > bufpool.MaxSize {
+			respondError(c, "Failed to resize buffer pool", bufpool.ErrInvalidSize)
+			return
+		}
+	}
+
+	if req.GOMAXPROCS != nil {
+		old := runtime.GOMAXPROCS(*req.GOMAXPROCS)
+		monitoring.Log.Info("GOMAXPROCS changed", zap.Int("from", old), zap.Int("to", *req.GOMAXPROCS))
+	}
+	if req.GCPercent != nil {
+		old := debug.SetGCPercent(*req.GCPercent)
+		monitoring.Log.Info("GC percent changed", zap.Int("from", old), zap.Int("to", *req.GCPercent))
+	}
+	for name, size := range req.BufferSizes {
+		if err := bufpool.Resize(name, size); err != nil {
+			respondError(c, "Failed to resize buffer pool", err)
+			return
+		}
+		monitoring.Log.Info("Buffer pool resized", zap.String("pool", name), zap.Int("from", sizes[name]), zap.Int("to", size))
+	}
+
+	h.GetRuntime(c)
+}
+
+// gcPercent returns the GC percent, which the runtime only reports when it
+// is set
+func gcPercent() int {
+	percent := debug.SetGCPercent(100)
+	debug.SetGCPercent(percent)
+	return percent
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/scheduler"
+)
+
+// ScheduleHandler exposes scheduled task status
+type ScheduleHandler struct {
+	scheduler *scheduler.Scheduler
+}
+
+// NewScheduleHandler creates a new schedule handler
+func NewScheduleHandler(s *scheduler.Scheduler) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduler: s,
+	}
+}
+
+// ListSchedules returns every schedule with its next and last run
+func (h *ScheduleHandler) ListSchedules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": h.scheduler.Status(),
+	})
+}
+
+// RunTask runs a task now as a background job, whether or not a schedule
+// runs it
+func (h *ScheduleHandler) RunTask(c *gin.Context) {
+	job, err := h.scheduler.RunTask(c.Param("task"))
+	if err != nil {
+		respondError(c, "Failed to submit job", err)
+		return
+	}
+
+	c.Header("Location", "/admin/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"job_id": job.ID,
+		"state":  job.State,
+	})
+}
+
+// RunSchedule triggers a schedule immediately
+func (h *ScheduleHandler) RunSchedule(c *gin.Context) {
+	status, err := h.scheduler.RunNow(c.Param("name"))
+	if err != nil {
+		respondError(c, "Failed to run schedule", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, status)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// SearchObjects finds the objects of a bucket whose key or user metadata
+// match the ?search query
+func (h *ObjectHandler) SearchObjects(c *gin.Context) {
+	bucket := c.Param("bucket")
+	if h.buckets != nil {
+		if _, err := h.buckets.GetBucket(c.Request.Context(), bucket); err != nil {
+			respondError(c, "Failed to search objects", err)
+			return
+		}
+	}
+	h.search(c, c.Query("search"), []string{bucket})
+}
+
+// SearchAllObjects finds objects matching the ?q query across every
+// bucket, or the buckets named by ?bucket
+func (h *ObjectHandler) SearchAllObjects(c *gin.Context) {
+	buckets := c.QueryArray("bucket")
+	if len(buckets) == 0 && h.buckets != nil {
+		all, err := h.buckets.ListBuckets(c.Request.Context(), "")
+		if err != nil {
+			respondError(c, "Failed to list buckets", err)
+			return
+		}
+		for _, b := range all {
+			buckets = append(buckets, b.Name)
+		}
+	}
+	h.search(c, c.Query("q"), buckets)
+}
+
+func (h *ObjectHandler) search(c *gin.Context, query string, buckets []string) {
+	opts := object.SearchOptions{
+		Buckets: buckets,
+		Query:   query,
+		MaxKeys: object.DefaultSearchKeys,
+	}
+	if maxKeysParam := c.Query("max-keys"); maxKeysParam != "" {
+		if mk, err := strconv.Atoi(maxKeysParam); err == nil {
+			opts.MaxKeys = min(mk, object.MaxSearchKeys)
+		}
+	}
+	if token := c.Query("continuation-token"); token != "" {
+		after, err := decodeSearchToken(token)
+		if err != nil {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid continuation token")
+			return
+		}
+		opts.StartAfter = after
+	}
+
+	result, err := h.service.SearchObjects(c.Request.Context(), opts)
+	if err != nil {
+		respondError(c, "Failed to search objects", err)
+		return
+	}
+
+	response := gin.H{
+		"query":        query,
+		"objects":      newObjectInfos(result.Objects),
+		"is_truncated": result.IsTruncated,
+	}
+	if result.Next != nil {
+		response["next_continuation_token"] = encodeSearchToken(*result.Next)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Continuation tokens are the last match of a page, opaque to clients
+func encodeSearchToken(after object.SearchMatch) string {
+	data, _ := json.Marshal(after)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchToken(token string) (object.SearchMatch, error) {
+	var after object.SearchMatch
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return after, err
+	}
+	err = json.Unmarshal(data, &after)
+	return after, err
+}
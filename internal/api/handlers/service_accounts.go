@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ServiceAccountHandler manages prefix-scoped access keys derived from users
+type ServiceAccountHandler struct {
+	authenticator *auth.HMACAuthenticator
+	store         auth.UserStore
+}
+
+// NewServiceAccountHandler creates a new service account handler
+func NewServiceAccountHandler(authenticator *auth.HMACAuthenticator, store auth.UserStore) *ServiceAccountHandler {
+	return &ServiceAccountHandler{
+		authenticator: authenticator,
+		store:         store,
+	}
+}
+
+// createServiceAccountRequest is the body of CreateServiceAccount
+type createServiceAccountRequest struct {
+	Parent  string        `json:"parent"` // Defaults to the calling user; others need an admin
+	Bucket  string        `json:"bucket"`
+	Prefix  string        `json:"prefix"`
+	Actions []auth.Action `json:"actions"`
+}
+
+// serviceAccountInfo is a service account without its secret
+type serviceAccountInfo struct {
+	AccessKeyID string      `json:"access_key_id"`
+	Parent      string      `json:"parent"`
+	Scope       *auth.Scope `json:"scope"`
+	CreatedAt   string      `json:"created_at"`
+}
+
+func newServiceAccountInfo(user *auth.User) serviceAccountInfo {
+	return serviceAccountInfo{
+		AccessKeyID: user.AccessKeyID,
+		Parent:      user.ParentAccessKeyID,
+		Scope:       user.Scope,
+		CreatedAt:   user.CreatedAt.UTC().Format(http.TimeFormat),
+	}
+}
+
+// CreateServiceAccount derives a scoped access key. The secret is only
+// returned in this response.
+func (h *ServiceAccountHandler) CreateServiceAccount(c *gin.Context) {
+	var req createServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidRequest, err.Error())
+		return
+	}
+
+	// Only admins derive keys from users other than themselves
+	caller := middleware.GetUserFromContext(c)
+	parentKey := req.Parent
+	if parentKey == "" {
+		parentKey = caller.AccessKeyID
+	}
+	if parentKey != caller.AccessKeyID && !caller.IsAdmin() {
+		middleware.Error(c, http.StatusForbidden, s3.AccessDenied, "access denied: only admins create service accounts for other users")
+		return
+	}
+	parent, ok := h.authenticator.LookupUser(parentKey)
+	if !ok {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "unknown parent access key: "+parentKey)
+		return
+	}
+
+	account, err := auth.NewServiceAccount(parent, auth.Scope{
+		Bucket:  req.Bucket,
+		Prefix:  req.Prefix,
+		Actions: req.Actions,
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrScopeEscalation) {
+			respondError(c, "Failed to create service account", err)
+			return
+		}
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, err.Error())
+		return
+	}
+
+	if err := h.store.Put(account); err != nil {
+		monitoring.Log.Error("Failed to store service account",
+			zap.String("parent", parent.AccessKeyID),
+			zap.Error(err))
+		middleware.Error(c, http.StatusInternalServerError, s3.InternalError, err.Error())
+		return
+	}
+
+	monitoring.Log.Info("Service account created",
+		zap.String("access_key_id", account.AccessKeyID),
+		zap.String("parent", parent.AccessKeyID),
+		zap.String("bucket", account.Scope.Bucket),
+		zap.String("prefix", account.Scope.Prefix))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"access_key_id":     account.AccessKeyID,
+		"secret_access_key": account.SecretAccessKey,
+		"parent":            account.ParentAccessKeyID,
+		"scope":             account.Scope,
+	})
+}
+
+// ListServiceAccounts lists service accounts, optionally filtered by
+// ?parent=. Users other than admins only see their own.
+func (h *ServiceAccountHandler) ListServiceAccounts(c *gin.Context) {
+	users, err := h.store.List()
+	if err != nil {
+		respondError(c, "Failed to list service accounts", err)
+		return
+	}
+
+	parent := c.Query("parent")
+	if caller := middleware.GetUserFromContext(c); !caller.IsAdmin() {
+		parent = caller.AccessKeyID
+	}
+	accounts := make([]serviceAccountInfo, 0, len(users))
+	for _, user := range users {
+		if user.ParentAccessKeyID == "" {
+			continue
+		}
+		if parent != "" && user.ParentAccessKeyID != parent {
+			continue
+		}
+		accounts = append(accounts, newServiceAccountInfo(user))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"service_accounts": accounts})
+}
+
+// DeleteServiceAccount revokes a service account. Users other than admins
+// only revoke their own.
+func (h *ServiceAccountHandler) DeleteServiceAccount(c *gin.Context) {
+	accessKey := c.Param("access_key")
+
+	user, err := h.store.Get(accessKey)
+	caller := middleware.GetUserFromContext(c)
+	if err != nil || user.ParentAccessKeyID == "" || (user.ParentAccessKeyID != caller.AccessKeyID && !caller.IsAdmin()) {
+		middleware.Error(c, http.StatusNotFound, s3.NoSuchServiceAccount, "service account not found")
+		return
+	}
+
+	if err := h.store.Delete(accessKey); err != nil {
+		respondError(c, "Failed to delete service account", err)
+		return
+	}
+
+	monitoring.Log.Info("Service account deleted", zap.String("access_key_id", accessKey))
+	c.Status(http.StatusNoContent)
+}
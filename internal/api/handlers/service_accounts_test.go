@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/object"
+)
+
+// setupServiceAccountTest serves the handler to caller, as authenticated
+// by the Authentication middleware
+func setupServiceAccountTest(caller *auth.User) (*gin.Engine, *auth.HMACAuthenticator, auth.UserStore) {
+	router := gin.New()
+	store := auth.NewMemoryUserStore()
+	authenticator := auth.NewHMACAuthenticator()
+	authenticator.AddUser(auth.NewAdminUser("admin", "secret"))
+	authenticator.AddUser(&auth.User{AccessKeyID: "alice", SecretAccessKey: "alice-secret"})
+	authenticator.SetUserStore(store)
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.ContextKeyUser, caller)
+	})
+
+	handler := NewServiceAccountHandler(authenticator, store)
+
+	router.POST("/admin/service-accounts", handler.CreateServiceAccount)
+	router.GET("/admin/service-accounts", handler.ListServiceAccounts)
+	router.DELETE("/admin/service-accounts/:access_key", handler.DeleteServiceAccount)
+
+	return router, authenticator, store
+}
+
+func TestServiceAccountHandler_Lifecycle(t *testing.T) {
+	router, authenticator, _ := setupServiceAccountTest(auth.NewAdminUser("admin", "secret"))
+
+	body := `{"parent":"admin","bucket":"photos","prefix":"app1/","actions":["read","list"]}`
+	req, _ := http.NewRequest("POST", "/admin/service-accounts", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.SecretAccessKey)
+
+	user, ok := authenticator.LookupUser(created.AccessKeyID)
+	assert.True(t, ok)
+	assert.True(t, user.Allows(auth.ActionRead, "photos", "app1/a.jpg"))
+	assert.False(t, user.Allows(auth.ActionWrite, "photos", "app1/a.jpg"))
+
+	// Listing never exposes secrets
+	req, _ = http.NewRequest("GET", "/admin/service-accounts?parent=admin", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.AccessKeyID)
+	assert.NotContains(t, w.Body.String(), created.SecretAccessKey)
+
+	req, _ = http.NewRequest("DELETE", "/admin/service-accounts/"+created.AccessKeyID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	_, ok = authenticator.LookupUser(created.AccessKeyID)
+	assert.False(t, ok)
+}
+
+func TestServiceAccountHandler_CreateInvalid(t *testing.T) {
+	router, _, _ := setupServiceAccountTest(auth.NewAdminUser("admin", "secret"))
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"unknown parent", `{"parent":"nobody","bucket":"b","actions":["read"]}`, http.StatusBadRequest},
+		{"missing bucket", `{"parent":"admin","actions":["read"]}`, http.StatusBadRequest},
+		{"unknown action", `{"parent":"admin","bucket":"b","actions":["admin"]}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/admin/service-accounts", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestServiceAccountHandler_CreateForOtherUser(t *testing.T) {
+	router, _, _ := setupServiceAccountTest(&auth.User{AccessKeyID: "alice", SecretAccessKey: "alice-secret"})
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"own keys", `{"bucket":"b","actions":["read"]}`, http.StatusCreated},
+		{"own keys by name", `{"parent":"alice","bucket":"b","actions":["read"]}`, http.StatusCreated},
+		{"another user", `{"parent":"admin","bucket":"b","actions":["read"]}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/admin/service-accounts", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestServiceAccountHandler_OtherUsersAccounts(t *testing.T) {
+	router, authenticator, store := setupServiceAccountTest(&auth.User{AccessKeyID: "alice", SecretAccessKey: "alice-secret"})
+	admin, _ := authenticator.LookupUser("admin")
+	account, err := auth.NewServiceAccount(admin, auth.Scope{Bucket: "b", Actions: []auth.Action{auth.ActionRead}})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Put(account))
+
+	req, _ := http.NewRequest("GET", "/admin/service-accounts?parent=admin", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), account.AccessKeyID)
+
+	req, _ = http.NewRequest("DELETE", "/admin/service-accounts/"+account.AccessKeyID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	_, ok := authenticator.LookupUser(account.AccessKeyID)
+	assert.True(t, ok)
+}
+
+func TestAuthorize_ScopedAccessKey(t *testing.T) {
+	scoped, err := auth.NewServiceAccount(auth.NewAdminUser("admin", "secret"), auth.Scope{
+		Bucket:  "photos",
+		Prefix:  "app1",
+		Actions: []auth.Action{auth.ActionRead, auth.ActionWrite, auth.ActionList},
+	})
+	assert.NoError(t, err)
+
+	objectHandler := NewObjectHandler(object.NewService(object.NewMemoryRepository(), newMockEngine()))
+
+	// Simulate an authenticated scoped caller
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.ContextKeyUser, scoped)
+	}, middleware.Authorize())
+	router.PUT("/:bucket/:key", objectHandler.PutObject)
+	router.GET("/:bucket/:key", objectHandler.GetObject)
+	router.DELETE("/:bucket/:key", objectHandler.DeleteObject)
+	router.GET("/:bucket", objectHandler.ListObjects)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"PUT", "/photos/app1-cat.jpg", "data", http.StatusOK},
+		{"GET", "/photos/app1-cat.jpg", "", http.StatusOK},
+		{"PUT", "/photos/app2-cat.jpg", "data", http.StatusForbidden},
+		{"DELETE", "/photos/app1-cat.jpg", "", http.StatusForbidden},
+		{"PUT", "/other/app1-cat.jpg", "data", http.StatusForbidden},
+		{"GET", "/photos?prefix=app1", "", http.StatusOK},
+		{"GET", "/photos", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.ContentLength = int64(len(tt.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tt.code, w.Code, "%s %s", tt.method, tt.path)
+	}
+
+	// Copying into the scope from outside it is a read outside the scope
+	req, _ := http.NewRequest("PUT", "/photos/app1-copy.jpg", strings.NewReader(""))
+	req.Header.Set("x-amz-copy-source", "/photos/app2-cat.jpg")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
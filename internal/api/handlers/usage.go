@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/usage"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// UsageHandler serves the requests and traffic counted per access key
+type UsageHandler struct {
+	tracker *usage.Tracker
+}
+
+// NewUsageHandler creates a usage handler. tracker may be nil when usage
+// tracking is disabled.
+func NewUsageHandler(tracker *usage.Tracker) *UsageHandler {
+	return &UsageHandler{tracker: tracker}
+}
+
+// counterUsage is a set of counters with their error rate
+type counterUsage struct {
+	usage.Counters
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func newCounterUsage(c usage.Counters) counterUsage {
+	return counterUsage{Counters: c, ErrorRate: c.ErrorRate()}
+}
+
+type hourUsage struct {
+	Hour time.Time `json:"hour"`
+	counterUsage
+}
+
+type keyUsage struct {
+	AccessKey string `json:"access_key"`
+	counterUsage
+	Buckets map[string]counterUsage `json:"buckets"` // Totals per bucket; "" for requests outside buckets
+	Hours   []hourUsage             `json:"hours"`
+}
+
+// KeyUsage reports the requests, errors and bytes in and out of each
+// access key over a window, hour by hour. ?window= takes a duration or a
+// number of days (24h by default), or ?from= and ?to= bound it with RFC
+// 3339 times; ?key= selects one access key. With ?format=csv, the hours
+// are returned as CSV, one row per access key and hour.
+func (h *UsageHandler) KeyUsage(c *gin.Context) {
+	if h.tracker == nil {
+		middleware.Error(c, http.StatusConflict, s3.NotConfigured, "usage tracking is disabled")
+		return
+	}
+
+	from, to, ok := usageRange(c)
+	if !ok {
+		return
+	}
+	records, err := h.tracker.Query(from, to)
+	if err != nil {
+		respondError(c, "Failed to read usage", err)
+		return
+	}
+
+	keys := make(map[string]*keyUsage)
+	var order []string
+	for _, r := range records {
+		if k := c.Query("key"); k != "" && r.AccessKey != k {
+			continue
+		}
+		ku, ok := keys[r.AccessKey]
+		if !ok {
+			ku = &keyUsage{AccessKey: r.AccessKey, Buckets: make(map[string]counterUsage), Hours: []hourUsage{}}
+			keys[r.AccessKey] = ku
+			order = append(order, r.AccessKey)
+		}
+		ku.Counters.Add(r.Counters)
+		b := ku.Buckets[r.Bucket]
+		b.Counters.Add(r.Counters)
+		ku.Buckets[r.Bucket] = b
+		// Records come by hour, so an hour is either the last one or new
+		if n := len(ku.Hours); n > 0 && ku.Hours[n-1].Hour.Equal(r.Hour) {
+			ku.Hours[n-1].Counters.Add(r.Counters)
+		} else {
+			ku.Hours = append(ku.Hours, hourUsage{Hour: r.Hour, counterUsage: counterUsage{Counters: r.Counters}})
+		}
+	}
+	sort.Strings(order)
+
+	result := make([]*keyUsage, 0, len(order))
+	for _, k := range order {
+		ku := keys[k]
+		ku.ErrorRate = ku.Counters.ErrorRate()
+		for bucket, b := range ku.Buckets {
+			ku.Buckets[bucket] = newCounterUsage(b.Counters)
+		}
+		for i := range ku.Hours {
+			ku.Hours[i].ErrorRate = ku.Hours[i].Counters.ErrorRate()
+		}
+		result = append(result, ku)
+	}
+
+	if c.Query("format") == "csv" {
+		writeUsageCSV(c, result)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":      from,
+		"to":        to,
+		"retention": h.tracker.Retention().String(),
+		"keys":      result,
+	})
+}
+
+// usageRange returns the hours a usage request covers, having answered
+// it with 400 if they are invalid
+func usageRange(c *gin.Context) (time.Time, time.Time, bool) {
+	to := time.Now().UTC()
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid to: "+s)
+			return time.Time{}, time.Time{}, false
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-24 * time.Hour)
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid from: "+s)
+			return time.Time{}, time.Time{}, false
+		}
+		from = t.UTC()
+	} else if w := c.Query("window"); w != "" {
+		d, err := parseWindow(w)
+		if err != nil || d <= 0 {
+			middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "invalid window: "+w)
+			return time.Time{}, time.Time{}, false
+		}
+		from = to.Add(-d)
+	}
+	if !from.Before(to) {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "from must be before to")
+		return time.Time{}, time.Time{}, false
+	}
+	// Whole hours, including the one in progress
+	return from.Truncate(time.Hour), to, true
+}
+
+func writeUsageCSV(c *gin.Context, keys []*keyUsage) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="usage.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"hour", "access_key", "requests", "client_errors", "server_errors", "error_rate", "bytes_in", "bytes_out"})
+	for _, k := range keys {
+		for _, h := range k.Hours {
+			w.Write([]string{
+				h.Hour.Format(time.RFC3339),
+				k.AccessKey,
+				strconv.FormatInt(h.Requests, 10),
+				strconv.FormatInt(h.ClientErrors, 10),
+				strconv.FormatInt(h.ServerErrors, 10),
+				strconv.FormatFloat(h.ErrorRate, 'f', 4, 64),
+				strconv.FormatInt(h.BytesIn, 10),
+				strconv.FormatInt(h.BytesOut, 10),
+			})
+		}
+	}
+	w.Flush()
+}
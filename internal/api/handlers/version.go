@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// RestoreObjectVersion makes an older version of an object current, POST
+// /:bucket/:key?restoreVersion=<id>. The version is copied as a new
+// version, returned in x-amz-version-id, and the restore is recorded in
+// the object's history.
+func (h *ObjectHandler) RestoreObjectVersion(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	key := c.Param("key")
+
+	versionID := c.Query("restoreVersion")
+	if versionID == "" {
+		middleware.Error(c, http.StatusBadRequest, s3.InvalidArgument, "restoreVersion must name the version to restore")
+		return
+	}
+
+	if h.buckets != nil {
+		b, err := h.buckets.GetBucket(c.Request.Context(), bucketName)
+		if err != nil {
+			respondError(c, "Failed to restore object version", err)
+			return
+		}
+		if b.Versioning != bucket.VersioningEnabled {
+			middleware.Error(c, http.StatusConflict, s3.InvalidBucketState, "versioning is not enabled on the bucket")
+			return
+		}
+
+		version, err := h.service.HeadObject(c.Request.Context(), bucketName, key, &versionID)
+		if err != nil {
+			respondError(c, "Failed to restore object version", err)
+			return
+		}
+		if err := h.buckets.CheckQuota(c.Request.Context(), bucketName, version.Size); err != nil {
+			respondError(c, "Failed to restore object version", err)
+			return
+		}
+	}
+
+	obj, err := h.service.RestoreVersion(actorContext(c), bucketName, key, versionID)
+	if err != nil {
+		respondError(c, "Failed to restore object version", err)
+		return
+	}
+
+	setObjectHeaders(c, obj)
+	c.Header("ETag", strongETag(obj.ETag))
+	c.Header(replication.HeaderConsistencyToken, replication.ConsistencyToken(obj.ModifiedAt))
+	c.JSON(http.StatusOK, newObjectInfo(obj))
+}
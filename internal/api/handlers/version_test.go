@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestObjectHandler_RestoreObjectVersion(t *testing.T) {
+	router, objectService, bucketService := setupObjectTest()
+	handler := NewObjectHandler(objectService)
+	handler.SetBucketService(bucketService)
+	router.POST("/:bucket/:key", handler.RestoreObjectVersion)
+
+	bucketService.CreateBucket(nil, "test-bucket", "default")
+	obj, err := objectService.PutObject(nil, "test-bucket", "test-key", strings.NewReader("hello"), 5, "text/plain")
+	assert.NoError(t, err)
+
+	restore := func(version string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/test-bucket/test-key?restoreVersion="+version, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Versioning must be enabled on the bucket
+	assert.Equal(t, http.StatusConflict, restore(obj.VersionID).Code)
+
+	b, _ := bucketService.GetBucket(nil, "test-bucket")
+	b.Versioning = bucket.VersioningEnabled
+	assert.NoError(t, bucketService.UpdateBucket(nil, b))
+
+	assert.Equal(t, http.StatusNotFound, restore("no-such-version").Code)
+
+	w := restore(obj.VersionID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var restored object.Object
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &restored))
+	assert.NotEqual(t, obj.VersionID, restored.VersionID)
+	assert.Equal(t, restored.VersionID, w.Header().Get("x-amz-version-id"))
+	assert.Equal(t, `"`+obj.ETag+`"`, w.Header().Get("ETag"))
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestHardening_ObjectKeys(t *testing.T) {
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = openTestEngine(t)
+	container.ObjectService = object.NewService(container.ObjectRepo, container.Engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/photos/%2E%2E", http.StatusBadRequest},      // Decoded once by the router
+		{"/photos/%252E%252E", http.StatusBadRequest},  // Decoded twice
+		{"/photos/a%2500b", http.StatusBadRequest},     // NUL once decoded again
+		{"/photos/report%2525", http.StatusBadRequest}, // Still encoded once decoded
+		{"/photos/100%25%20off", http.StatusOK},        // A literal '%'
+		{"/photos/..draft", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest("PUT", tt.path, strings.NewReader("data")))
+		if w.Code != tt.status {
+			t.Errorf("PUT %s = %d, want %d: %s", tt.path, w.Code, tt.status, w.Body)
+		}
+	}
+
+	for _, key := range []string{"report%", "report%25"} {
+		if _, _, err := container.ObjectRepo.Get(context.Background(), "photos", key, nil); err == nil {
+			t.Errorf("still encoded key stored as %q", key)
+		}
+	}
+
+	// A key with a literal '%' reads back under the same key
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/photos/100%25%20off", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("GET /photos/100%%25%%20off = %d %q", w.Code, w.Body)
+	}
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/photos", nil))
+	if !strings.Contains(w.Body.String(), `"key":"100% off"`) {
+		t.Errorf("listing lacks the key 100%% off: %s", w.Body)
+	}
+}
+
+func TestHardening_Headers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.MaxHeaderCount = 10
+	cfg.Server.MaxHeaderBytes = 1024
+	cfg.Console.Enabled = true
+	cfg.Auth.AdminAccessKey = "admin"
+	cfg.Auth.AdminSecretKey = "secret"
+	server := NewServer(cfg, createTestContainer(cfg))
+	server.SetupRoutes()
+
+	req := httptest.NewRequest("GET", "/admin/v1/health", nil)
+	for i := range 11 {
+		req.Header.Set(fmt.Sprintf("X-Header-%d", i), "v")
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("request with 11 headers = %d, want 431", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/v1/health", nil)
+	req.Header.Set("X-Large", strings.Repeat("x", 1024))
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("request with 1 KiB header = %d, want 431", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/console/", nil)
+	req.SetBasicAuth("admin", "secret")
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /console/ = %d, want 200", w.Code)
+	}
+	for _, h := range []string{"X-Content-Type-Options", "X-Frame-Options", "Content-Security-Policy", "Referrer-Policy"} {
+		if w.Header().Get(h) == "" {
+			t.Errorf("console response lacks %s", h)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/v1/health", nil))
+	if w.Header().Get("Content-Security-Policy") != "" {
+		t.Error("security headers set on API responses")
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// TestMaintenanceJobs runs every maintenance task through the admin API and
+// checks that it is listed as a job with its progress, and can be cancelled
+func TestMaintenanceJobs(t *testing.T) {
+	cfg := &config.Config{Scheduler: config.SchedulerConfig{InventoryBucket: "inventory", InventoryPrefix: "inventory/"}}
+	container := createTestContainer(cfg)
+	engine := openTestEngine(t)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	// Resyncs only queue events, so the replicator is not started
+	replicator := replication.NewReplicator(replication.Config{Enabled: true, RemoteURL: "http://replica.invalid"})
+	container.ObjectService.SetReplicator(replicator)
+	container.Replicator = replicator
+
+	var err error
+	container.Jobs, err = jobs.NewManager(jobs.Config{Workers: 1}, jobs.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	container.Jobs.Start()
+	defer container.Jobs.Stop()
+	if err := container.initScheduler(); err != nil {
+		t.Fatal(err)
+	}
+	defer container.Scheduler.Stop()
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader("")))
+		return w
+	}
+
+	ctx := context.Background()
+	for _, name := range []string{"photos", "inventory"} {
+		if err := container.BucketService.CreateBucket(ctx, name, "owner"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	photos, _ := container.BucketRepo.Get(ctx, "photos")
+	photos.Lifecycle = []bucket.LifecycleRule{{ID: "old", Status: "Enabled", ExpirationDays: 30}}
+	if err := container.BucketRepo.Update(ctx, photos); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if _, err := container.ObjectService.PutObject(ctx, "photos", key, strings.NewReader(strings.Repeat("x", 1000)), 1000, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leaves a hole for compaction
+	if err := container.ObjectService.DeleteObject(ctx, "photos", "b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks := []struct {
+		jobType string
+		target  string
+	}{
+		{jobs.TypeScrub, "/admin/v1/tasks/scrub/run"},
+		{jobs.TypeCompaction, "/admin/v1/tasks/compaction/run"},
+		{jobs.TypeLifecycle, "/admin/v1/tasks/lifecycle/run"},
+		{jobs.TypeInventory, "/admin/v1/tasks/inventory/run"},
+		{jobs.TypeResync, "/admin/v1/buckets/photos/resync"},
+		{jobs.TypePurge, "/admin/v1/buckets/photos/objects?confirm=true"},
+	}
+	submit := func(jobType, target string) string {
+		method := "POST"
+		if jobType == jobs.TypePurge {
+			method = "DELETE"
+		}
+		w := serve(method, target)
+		var accepted struct {
+			JobID string `json:"job_id"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &accepted)
+		if w.Code != http.StatusAccepted || accepted.JobID == "" {
+			t.Fatalf("%s %s = %d: %s", method, target, w.Code, w.Body)
+		}
+		return accepted.JobID
+	}
+	listed := func(jobType, id string) jobs.Job {
+		w := serve("GET", "/admin/v1/jobs?type="+jobType)
+		var list struct {
+			Jobs []jobs.Job `json:"jobs"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range list.Jobs {
+			if job.ID == id {
+				return job
+			}
+		}
+		t.Fatalf("%s job %s not listed: %s", jobType, id, w.Body)
+		return jobs.Job{}
+	}
+
+	for _, task := range tasks {
+		id := submit(task.jobType, task.target)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if job, _ := container.Jobs.Get(id); job.State.Finished() {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s job did not finish", task.jobType)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		job := listed(task.jobType, id)
+		if job.State != jobs.StateCompleted || job.Progress.Done == 0 {
+			t.Errorf("%s job = %+v, want completed with progress", task.jobType, job)
+		}
+	}
+
+	// With the only worker busy, the jobs queue and are cancelled there
+	release := make(chan struct{})
+	if _, err := container.Jobs.Submit(jobs.Spec{Type: "test"}, func(ctx context.Context, h *jobs.Handle) error {
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+	for _, task := range tasks {
+		id := submit(task.jobType, task.target)
+		if w := serve("DELETE", "/admin/v1/jobs/"+id); w.Code != http.StatusAccepted {
+			t.Fatalf("cancel %s job = %d: %s", task.jobType, w.Code, w.Body)
+		}
+		if job := listed(task.jobType, id); job.State != jobs.StateCancelled {
+			t.Errorf("%s job = %+v, want cancelled", task.jobType, job)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/replication"
+)
+
+func TestAdminMetrics_Replication(t *testing.T) {
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Replicator = replication.NewReplicator(replication.Config{Enabled: true, RemoteURL: "https://site-b.example.com"})
+	container.Replicator.QueueEvent(replication.Event{Type: replication.EventDeleteObject, Bucket: "b", Key: "k"})
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/v1/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/v1/metrics = %d, want 200", w.Code)
+	}
+	var metrics struct {
+		Replication struct {
+			Target         string `json:"target"`
+			EventsQueued   int64  `json:"events_queued"`
+			QueueLength    int    `json:"queue_length"`
+			CircuitBreaker string `json:"circuit_breaker"`
+		} `json:"replication"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("invalid metrics: %v", err)
+	}
+	repl := metrics.Replication
+	if repl.Target != "https://site-b.example.com" || repl.EventsQueued != 1 || repl.QueueLength != 1 || repl.CircuitBreaker != "closed" {
+		t.Errorf("replication metrics = %+v", repl)
+	}
+
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/v1/metrics/prometheus", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("GET /admin/v1/metrics/prometheus = %d:\n%s", w.Code, w.Body.String())
+	}
+}
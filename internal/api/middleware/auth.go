@@ -1,38 +1,56 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/danielino/comio/internal/auth"
 	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 // ContextKeyUser is the key for user in context
 const ContextKeyUser = "user"
 
-// Authentication returns an authentication middleware
-func Authentication(cfg *config.AuthConfig, authenticator auth.Authenticator) gin.HandlerFunc {
+// Authentication returns an authentication middleware. Requests already
+// authenticated, by a replication signature or the cluster token, pass
+// through, and so do requests any skip function matches, such as form
+// uploads carrying their credentials in the form. With authentication
+// disabled every request acts as an admin.
+func Authentication(cfg *config.AuthConfig, authenticator auth.Authenticator, skip ...func(*gin.Context) bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if _, ok := c.Get(ContextKeyUser); ok {
+			c.Next()
+			return
+		}
+
 		// Skip auth if disabled
 		if !cfg.Enabled {
 			// Set default user for unauthenticated requests
 			c.Set(ContextKeyUser, &auth.User{
 				AccessKeyID: "anonymous",
 				Username:    "default",
+				Policies:    []string{auth.PolicyAdmin},
 			})
 			c.Next()
 			return
 		}
 
+		for _, fn := range skip {
+			if fn(c) {
+				c.Next()
+				return
+			}
+		}
+
 		// Authenticate the request
 		user, err := authenticator.Authenticate(c.Request.Context(), c.Request)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "authentication failed: " + err.Error(),
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusUnauthorized, s3.AccessDenied, "authentication failed: "+err.Error())
 			return
 		}
 
@@ -42,6 +60,38 @@ func Authentication(cfg *config.AuthConfig, authenticator auth.Authenticator) gi
 	}
 }
 
+// ClusterToken authenticates requests from other nodes of the cluster,
+// which send the shared cluster token as a bearer token, as an admin
+func ClusterToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			c.Set(ContextKeyUser, &auth.User{
+				AccessKeyID: "cluster",
+				Username:    "cluster",
+				Policies:    []string{auth.PolicyAdmin},
+			})
+		}
+		c.Next()
+	}
+}
+
+// FormUpload reports whether a request is a browser form upload without an
+// Authorization header: a POST of multipart/form-data to a bucket that is
+// not a batch delete. PostObject verifies the credentials of its form. The
+// query is parsed without gin, which would cache it before Tenant rewrites it.
+func FormUpload(c *gin.Context) bool {
+	r := c.Request
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "" || strings.Trim(c.Param("key"), "/") != "" {
+		return false
+	}
+	if _, ok := r.URL.Query()["delete"]; ok {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
 // GetUserFromContext retrieves the authenticated user from context
 func GetUserFromContext(c *gin.Context) *auth.User {
 	if user, exists := c.Get(ContextKeyUser); exists {
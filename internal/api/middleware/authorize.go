@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// Authorize enforces the scope of service account credentials on bucket
+// and object routes. Unscoped users pass through unchanged. Scopes name
+// the buckets of the user's tenant as the tenant knows them.
+func Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if !user.IsScoped() {
+			c.Next()
+			return
+		}
+
+		bucket := scopedName(user, c.Param("bucket"))
+		key := c.Param("key")
+
+		allowed := false
+		switch {
+		case key != "":
+			if action, ok := objectAction(c.Request.Method); ok {
+				allowed = user.Allows(action, bucket, key)
+			}
+			// Server-side copies also read their source
+			if source := c.GetHeader("x-amz-copy-source"); allowed && source != "" {
+				srcBucket, srcKey := splitCopySource(source)
+				allowed = user.Allows(auth.ActionRead, scopedName(user, srcBucket), srcKey)
+			}
+			// Moves remove the object and write it elsewhere
+			if dest := c.Query("move-to"); allowed && dest != "" {
+				dstBucket, dstKey, _ := strings.Cut(dest, "/")
+				allowed = user.Allows(auth.ActionDelete, bucket, key) && user.Allows(auth.ActionWrite, scopedName(user, dstBucket), dstKey)
+			}
+		case c.Request.Method == http.MethodGet:
+			// Listing is allowed only within the scoped prefix
+			allowed = user.Allows(auth.ActionList, bucket, c.Query("prefix"))
+		case c.Request.Method == http.MethodHead:
+			allowed = bucket == user.Scope.Bucket
+		case c.Request.Method == http.MethodPost && isBatchDelete(c):
+			// Batch deletes check each key they name with Allowed
+			allowed = bucket == user.Scope.Bucket
+		}
+
+		if !allowed {
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "access denied: outside the scope of this access key")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireUnscoped rejects service account credentials, for routes such as
+// bucket management and the admin API
+func RequireUnscoped() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetUserFromContext(c).IsScoped() {
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "access denied: scoped access keys cannot use this endpoint")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin lets only users holding the admin policy through, for the
+// admin API. Admins of a tenant are refused too: the admin API names
+// buckets by their qualified name, which would reach other tenants.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		if !user.IsAdmin() {
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "access denied: admin credentials required")
+			return
+		}
+		if user.Tenant != "" {
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "access denied: the admin API is not open to tenants")
+			return
+		}
+		c.Next()
+	}
+}
+
+// scopedName returns the name a scope knows a stored bucket by: the
+// tenant's name for buckets of the user's tenant, the stored name otherwise
+func scopedName(user *auth.User, name string) string {
+	if tenant, unqualified := bucket.SplitName(name); tenant == user.Tenant {
+		return unqualified
+	}
+	return name
+}
+
+// Allowed reports whether the user of a request may perform action on key
+// in bucket, for handlers acting on keys the path does not name
+func Allowed(c *gin.Context, action auth.Action, bucket, key string) bool {
+	user := GetUserFromContext(c)
+	return user.Allows(action, scopedName(user, bucket), key)
+}
+
+// isBatchDelete reports whether a bucket request is a DeleteObjects
+func isBatchDelete(c *gin.Context) bool {
+	_, ok := c.GetQuery("delete")
+	return ok
+}
+
+// objectAction maps an object request method to its scoped action
+func objectAction(method string) (auth.Action, bool) {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return auth.ActionRead, true
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+		return auth.ActionWrite, true
+	case http.MethodDelete:
+		return auth.ActionDelete, true
+	}
+	return "", false
+}
+
+// splitCopySource returns the bucket and key of an x-amz-copy-source header
+func splitCopySource(source string) (string, string) {
+	source, _, _ = strings.Cut(source, "?")
+	decoded, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		return "", ""
+	}
+	bucket, key, _ := strings.Cut(decoded, "/")
+	return bucket, key
+}
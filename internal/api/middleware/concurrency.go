@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/admission"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ConcurrencyLimit admits requests through limiter by operation class.
+// Requests finding their class and its queue full, or queued past the
+// timeout, are refused with 503 SlowDown so clients back off and retry. A
+// nil limiter lets every request through.
+func ConcurrencyLimit(limiter *admission.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		release, err := limiter.Acquire(c.Request.Context(), operationClass(c))
+		if err != nil {
+			c.Header("Retry-After", "1")
+			AbortWithError(c, http.StatusServiceUnavailable, s3.SlowDown, "please reduce your request rate: "+err.Error())
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// operationClass returns the class a request is limited in. Listings are
+// told apart from object reads, as they cost far more metadata work.
+func operationClass(c *gin.Context) admission.Class {
+	switch c.Request.Method {
+	case http.MethodGet:
+		if c.Param("key") == "" {
+			return admission.ClassList
+		}
+		if _, ok := c.GetQuery("uploads"); ok {
+			return admission.ClassList
+		}
+		if _, ok := c.GetQuery("uploadId"); ok {
+			return admission.ClassList
+		}
+		return admission.ClassRead
+	case http.MethodHead, http.MethodOptions:
+		return admission.ClassRead
+	default:
+		return admission.ClassWrite
+	}
+}
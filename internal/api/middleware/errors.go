@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ContextKeyRequestID is the key for the request ID in context
+const ContextKeyRequestID = "request_id"
+
+// RequestIDHeader carries the request ID in responses, as in S3
+const RequestIDHeader = "x-amz-request-id"
+
+// RequestID assigns every request an ID, returned in x-amz-request-id
+// and in error bodies so failures can be matched with the server logs
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		id := strings.ToUpper(hex.EncodeToString(b))
+		c.Set(ContextKeyRequestID, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the ID assigned to the request, if any
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(ContextKeyRequestID)
+}
+
+// WantsXML reports whether the client asked for XML responses
+func WantsXML(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return format == "xml"
+	}
+	return strings.Contains(c.GetHeader("Accept"), "xml")
+}
+
+// Error writes an error response carrying an error code, as JSON or as an
+// S3 XML error document when the client asked for XML
+func Error(c *gin.Context, status int, code s3.ErrorCode, message string) {
+	body := ErrorBody(c, code, message)
+	if WantsXML(c) {
+		c.XML(status, body)
+		return
+	}
+	c.JSON(status, body)
+}
+
+// ErrorBody returns the error document for the request, for responses
+// extending it with more fields
+func ErrorBody(c *gin.Context, code s3.ErrorCode, message string) s3.ErrorResponse {
+	return s3.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Resource:  c.Request.URL.Path,
+		RequestID: GetRequestID(c),
+	}
+}
+
+// AbortWithError writes an error response and stops the handler chain
+func AbortWithError(c *gin.Context, status int, code s3.ErrorCode, message string) {
+	Error(c, status, code, message)
+	c.Abort()
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// hopByHopHeaders only concern a single connection and must not reach
+// handlers, which could otherwise act on what a proxy meant for itself
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Upgrade",
+}
+
+// SecurityHeaders sets headers keeping browsers from sniffing, framing or
+// leaking the URLs of the pages served, for the console and other static
+// responses. Object downloads are left alone so they can be embedded.
+func SecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'; base-uri 'none'; form-action 'self'")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if c.Request.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		c.Next()
+	}
+}
+
+// StripHopByHop removes hop-by-hop headers from requests, including those
+// the Connection header names, before any handler sees them
+func StripHopByHop() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Request.Header
+		for _, value := range h.Values("Connection") {
+			for _, name := range strings.Split(value, ",") {
+				if name = textproto.TrimString(name); name != "" {
+					h.Del(name)
+				}
+			}
+		}
+		for _, name := range hopByHopHeaders {
+			h.Del(name)
+		}
+		c.Next()
+	}
+}
+
+// LimitHeaders refuses requests with more than maxCount header fields or
+// more than maxBytes of them. A zero limit is not enforced.
+func LimitHeaders(maxCount, maxBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		count, size := 0, 0
+		for name, values := range c.Request.Header {
+			for _, v := range values {
+				count++
+				size += len(name) + len(v) + len(": \r\n")
+			}
+		}
+		if (maxCount > 0 && count > maxCount) || (maxBytes > 0 && size > maxBytes) {
+			AbortWithError(c, http.StatusRequestHeaderFieldsTooLarge, s3.RequestHeaderTooLarge,
+				"request has too many or too large header fields")
+			return
+		}
+		c.Next()
+	}
+}
+
+// NormalizeKey refuses object keys that mean different objects to
+// different path-handling layers: keys still escaping a NUL, '%', '/', '\'
+// or '.' after the router's decoding, which a layer decoding them again
+// would read as another key, keys with "." or ".." segments and keys with
+// NUL bytes. Keys are never rewritten, so a key holding a literal '%'
+// is stored as sent.
+func NormalizeKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, p := range c.Params {
+			if p.Key != "key" {
+				continue
+			}
+
+			key := p.Value
+			if doubleEncoded(key) {
+				AbortWithError(c, http.StatusBadRequest, s3.InvalidArgument, "object key is still percent-encoded after decoding")
+				return
+			}
+			if strings.ContainsRune(key, 0) {
+				AbortWithError(c, http.StatusBadRequest, s3.InvalidArgument, "object key cannot contain NUL bytes")
+				return
+			}
+			for _, segment := range strings.Split(key, "/") {
+				if segment == "." || segment == ".." {
+					AbortWithError(c, http.StatusBadRequest, s3.InvalidArgument, "object key cannot contain . or .. path segments")
+					return
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// doubleEncoded reports whether a decoded key still escapes a character
+// that changes how a path is split
+func doubleEncoded(key string) bool {
+	for i := 0; i+2 < len(key); i++ {
+		if key[i] != '%' {
+			continue
+		}
+		switch strings.ToUpper(key[i+1 : i+3]) {
+		case "00", "25", "2F", "5C", "2E":
+			return true
+		}
+	}
+	return false
+}
@@ -14,7 +14,7 @@ func Logging() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
+		query := monitoring.RedactQuery(c.Request.URL.RawQuery)
 
 		c.Next()
 
@@ -33,6 +33,7 @@ func Logging() gin.HandlerFunc {
 				zap.String("query", query),
 				zap.String("ip", c.ClientIP()),
 				zap.String("user-agent", c.Request.UserAgent()),
+				zap.String("request_id", GetRequestID(c)),
 				zap.Duration("latency", latency),
 			)
 		}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// PublicBuckets restricts requests to an explicit whitelist of buckets.
+// Other buckets answer 404 so their existence is not disclosed.
+func PublicBuckets(buckets []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(buckets))
+	for _, b := range buckets {
+		allowed[b] = true
+	}
+
+	return func(c *gin.Context) {
+		if !allowed[c.Param("bucket")] {
+			AbortWithError(c, http.StatusNotFound, s3.NoSuchBucket, "not found")
+			return
+		}
+		c.Next()
+	}
+}
+
+// CacheControl sets the Cache-Control header on successful responses.
+// Errors are left uncached so a fixed object becomes visible immediately.
+func CacheControl(value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &cacheControlWriter{ResponseWriter: c.Writer, value: value}
+		c.Next()
+	}
+}
+
+// cacheControlWriter adds Cache-Control just before a cacheable status is written
+type cacheControlWriter struct {
+	gin.ResponseWriter
+	value string
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	switch code {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+		w.Header().Set("Cache-Control", w.value)
+	default:
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/cluster"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ReadOnly rejects writes while the local node is draining or
+// decommissioned, so no new data lands on a node that is leaving the ring
+func ReadOnly(ring *cluster.Ring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if ring.ReadOnly() {
+			AbortWithError(c, http.StatusServiceUnavailable, s3.ReadOnly, "node "+ring.Self()+" is "+string(ring.State())+" and read-only")
+			return
+		}
+		c.Next()
+	}
+}
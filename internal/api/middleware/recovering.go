@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/recovery"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// Retry-After bounds while recovering, so clients neither hammer the node
+// nor wait long after it is back
+const (
+	minRecoveryRetry = time.Second
+	maxRecoveryRetry = time.Minute
+)
+
+// StartupRecovery answers 503 with a Retry-After while the node recovers
+// after a restart, so load balancers and clients see it is coming up
+// rather than hung. A nil tracker lets every request through.
+func StartupRecovery(tracker *recovery.Tracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tracker == nil || !tracker.Recovering() {
+			c.Next()
+			return
+		}
+
+		status := tracker.Status()
+		retry := status.RetryAfter(minRecoveryRetry, maxRecoveryRetry)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		msg := fmt.Sprintf("node is recovering after a restart, %.0f%% done", status.Percent)
+		if status.Error != "" {
+			msg = "node failed to recover after a restart: " + status.Error
+		}
+		AbortWithError(c, http.StatusServiceUnavailable, s3.ServiceUnavailable, msg)
+	}
+}
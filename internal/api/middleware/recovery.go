@@ -7,6 +7,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 // Recovery returns a recovery middleware
@@ -14,8 +15,10 @@ func Recovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				monitoring.Log.Error("Panic recovered", zap.Any("error", err))
-				c.AbortWithStatus(http.StatusInternalServerError)
+				monitoring.Log.Error("Panic recovered",
+					zap.Any("error", err),
+					zap.String("request_id", GetRequestID(c)))
+				AbortWithError(c, http.StatusInternalServerError, s3.InternalError, "internal error")
 			}
 		}()
 		c.Next()
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// ReadReplica serves object requests on an async read replica. Reads carry
+// the replica lag, and reads with a consistency token for a write the
+// replica has not applied yet are proxied to the primary. Replicated writes
+// from the primary update the replica state.
+func ReadReplica(state *replication.ReplicaState, primaryURL string) gin.HandlerFunc {
+	var proxy *httputil.ReverseProxy
+	if target, err := url.Parse(primaryURL); err == nil && target.Host != "" {
+		proxy = httputil.NewSingleHostReverseProxy(target)
+	} else if primaryURL != "" {
+		monitoring.Log.Warn("Invalid read replica primary URL, consistency tokens will not be honoured",
+			zap.String("primary_url", primaryURL))
+	}
+
+	return func(c *gin.Context) {
+		bucket, key := c.Param("bucket"), c.Param("key")
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			token := c.GetHeader(replication.HeaderConsistencyToken)
+			if token != "" && proxy != nil && !state.CaughtUp(bucket, key, token) {
+				c.Header(replication.HeaderServedBy, "primary")
+				proxy.ServeHTTP(c.Writer, c.Request)
+				c.Abort()
+				return
+			}
+
+			if lag, ok := state.Lag(); ok {
+				c.Header(replication.HeaderReplicaLag, strconv.FormatFloat(lag.Seconds(), 'f', 3, 64))
+			}
+			c.Header(replication.HeaderServedBy, "replica")
+			c.Next()
+
+		default:
+			c.Next()
+
+			// A replicated delete of an object the replica does not hold yet
+			// is recorded too, so a bootstrap does not copy it back
+			stamp := c.GetHeader(replication.HeaderReplicationTimestamp)
+			status := c.Writer.Status()
+			deleted := c.Request.Method == http.MethodDelete && status == http.StatusNotFound
+			if stamp == "" || (status >= http.StatusMultipleChoices && !deleted) {
+				return
+			}
+			if written, err := replication.ParseConsistencyToken(stamp); err == nil {
+				state.Record(bucket, key, written)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ContextKeyReplicationNode is the key for the replication peer that
+// signed a request in context
+const ContextKeyReplicationNode = "replication_node"
+
+// ReplicationSignature authenticates requests signed by replication peers.
+// Signed requests with a bad, stale or replayed signature are refused, and
+// so are unsigned requests carrying the headers only replication sets,
+// which would let any client rewrite the history a replica records.
+// Signed requests act as the peer, a user holding no policy: it reaches
+// the object routes, and the admin endpoints replication writes through
+// admit it with RequireReplicationNode, but the rest of the admin API
+// refuses it.
+func ReplicationSignature(verifier *replication.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(replication.HeaderSignature) == "" {
+			if c.GetHeader(replication.HeaderReplicationTimestamp) != "" || c.GetHeader(replication.HeaderDeleteMarker) != "" {
+				AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "replication headers require a signed request")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		node, err := verifier.Verify(c.Request)
+		if err != nil {
+			AbortWithError(c, http.StatusForbidden, s3.SignatureDoesNotMatch, err.Error())
+			return
+		}
+		c.Set(ContextKeyReplicationNode, node)
+		c.Set(ContextKeyUser, &auth.User{
+			AccessKeyID: "replication:" + node,
+			Username:    node,
+		})
+		c.Next()
+	}
+}
+
+// RequireReplicationNode lets only requests signed by a replication peer
+// reach h
+func RequireReplicationNode(h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(ContextKeyReplicationNode) == "" {
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "only replication peers may use this endpoint")
+			return
+		}
+		h(c)
+	}
+}
+
+// ReplicatedWrites lets only requests signed by a replication peer write
+// objects, so that everything a replica holds was sent by its sources.
+// Reads pass through.
+func ReplicatedWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if c.GetString(ContextKeyReplicationNode) == "" {
+				AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "objects are written by replication peers only")
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// S3Dialect makes handlers that serve both JSON and S3 XML answer in XML,
+// for routes used by S3 SDKs, which never ask for it
+func S3Dialect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Set("Accept", "application/xml")
+		c.Next()
+	}
+}
+
+// PreferXML makes handlers that serve both JSON and S3 XML answer in XML
+// unless the client asks for JSON, for servers S3 SDKs talk to directly
+func PreferXML() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept"), "json") {
+			c.Request.Header.Set("Accept", "application/xml")
+		}
+		c.Next()
+	}
+}
+
+// WildcardKey strips the leading slash a catch-all /*key route leaves on
+// the key, so keys containing slashes reach handlers as they were stored
+func WildcardKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for i, p := range c.Params {
+			if p.Key == "key" {
+				c.Params[i].Value = strings.TrimPrefix(p.Value, "/")
+			}
+		}
+		c.Next()
+	}
+}
+
+// virtualHosted marks requests whose path VirtualHost already rewrote
+type virtualHosted struct{}
+
+// VirtualHost routes virtual-hosted-style requests, sent to
+// <bucket>.<domain>, as their path-style form: GET /photo.jpg on
+// pets.s3.example.com is served as GET /pets/photo.jpg. With tenants named
+// by hosts under the same domain, buckets are addressed as
+// <bucket>.<tenant>.<domain>. Other hosts are routed unchanged.
+//
+// It must be the first middleware of router, which routes the rewritten
+// request again.
+func VirtualHost(router *gin.Engine, domain string, tenantHosts bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Context().Value(virtualHosted{}) != nil {
+			c.Next()
+			return
+		}
+		name := hostBucket(c.Request.Host, domain, tenantHosts)
+		if name == "" {
+			c.Next()
+			return
+		}
+
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), virtualHosted{}, true))
+		c.Request.URL.Path = bucketPath(name, c.Request.URL.Path)
+		if c.Request.URL.RawPath != "" {
+			c.Request.URL.RawPath = bucketPath(name, c.Request.URL.RawPath)
+		}
+		router.HandleContext(c)
+		c.Abort()
+	}
+}
+
+// hostBucket returns the bucket a Host of <bucket>.<domain> names, or of
+// <bucket>.<tenant>.<domain> with tenantHosts, and "" for other hosts
+func hostBucket(host, domain string, tenantHosts bool) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	prefix, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+	if !ok {
+		return ""
+	}
+	if tenantHosts {
+		i := strings.LastIndex(prefix, ".")
+		if i < 0 {
+			return ""
+		}
+		prefix = prefix[:i]
+	}
+	return prefix
+}
+
+// bucketPath returns the path-style form of a virtual-hosted path, "/" being
+// the bucket itself
+func bucketPath(bucket, path string) string {
+	if path == "/" || path == "" {
+		return "/" + bucket
+	}
+	return "/" + bucket + path
+}
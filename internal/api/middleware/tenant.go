@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// ContextKeyTenant is the key for the tenant of a request in context
+const ContextKeyTenant = "tenant"
+
+// Tenant serves each tenant its own bucket namespace. The tenant is that
+// of the user, or for admins the one named by the tenant header or by a
+// Host of <tenant>.<domain>; other users naming a tenant not their own are
+// refused. Form uploads, authenticated later by PostObject, may name any.
+// Bucket names in the path, in x-amz-copy-source and in move-to are then
+// qualified with the tenant, so handlers and services only ever see the
+// names buckets are stored under.
+//
+// It must run before anything reads the query, which gin parses once.
+func Tenant(cfg config.TenancyConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserFromContext(c)
+		named := c.GetHeader(cfg.Header)
+		if named == "" {
+			named = hostTenant(c.Request.Host, cfg.Domain)
+		}
+		tenant := user.Tenant
+		switch {
+		case tenant != "" && named != "" && named != tenant:
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "access denied: the access key belongs to another tenant")
+			return
+		case tenant == "" && named != "" && !user.IsAdmin() && !FormUpload(c):
+			AbortWithError(c, http.StatusForbidden, s3.AccessDenied, "access denied: the access key belongs to no tenant")
+			return
+		case tenant == "":
+			tenant = named
+		}
+		if tenant == "" {
+			c.Next()
+			return
+		}
+		if !bucket.ValidTenant(tenant) {
+			AbortWithError(c, http.StatusBadRequest, s3.InvalidArgument, "invalid tenant name")
+			return
+		}
+
+		c.Set(ContextKeyTenant, tenant)
+		for i, p := range c.Params {
+			if p.Key == "bucket" {
+				c.Params[i].Value = bucket.QualifiedName(tenant, p.Value)
+			}
+		}
+		if source := c.GetHeader("x-amz-copy-source"); source != "" {
+			if name, rest, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/"); ok {
+				c.Request.Header.Set("x-amz-copy-source", "/"+bucket.QualifiedName(tenant, name)+"/"+rest)
+			}
+		}
+		if query := c.Request.URL.Query(); query.Get("move-to") != "" {
+			query.Set("move-to", bucket.QualifiedName(tenant, query.Get("move-to")))
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+// GetTenantFromContext returns the tenant of a request, empty for the
+// default namespace
+func GetTenantFromContext(c *gin.Context) string {
+	return c.GetString(ContextKeyTenant)
+}
+
+// hostTenant returns the tenant a Host of <tenant>.<domain> names, or of
+// <bucket>.<tenant>.<domain>, and "" for other hosts
+func hostTenant(host, domain string) string {
+	if domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	prefix, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(domain))
+	if !ok {
+		return ""
+	}
+	return prefix[strings.LastIndex(prefix, ".")+1:]
+}
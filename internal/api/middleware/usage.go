@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"io"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/usage"
+)
+
+// TrackUsage counts every request, its status and the bytes of its body
+// and response against the access key that made it and the bucket it
+// addressed
+func TrackUsage(tracker *usage.Tracker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := &countingReader{ReadCloser: c.Request.Body}
+		if c.Request.Body != nil {
+			c.Request.Body = body
+		}
+
+		c.Next()
+
+		counters := usage.Counters{
+			Requests: 1,
+			BytesIn:  body.n,
+			BytesOut: int64(max(c.Writer.Size(), 0)),
+		}
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			counters.ServerErrors = 1
+		case status >= 400:
+			counters.ClientErrors = 1
+		}
+		tracker.Record(GetUserFromContext(c).AccessKeyID, c.Param("bucket"), counters)
+	}
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
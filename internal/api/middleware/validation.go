@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/pkg/s3"
 )
 
 var (
@@ -33,36 +35,24 @@ func ValidateBucketName() gin.HandlerFunc {
 
 		// Check length
 		if len(bucket) < 3 || len(bucket) > 63 {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "bucket name must be between 3 and 63 characters",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusBadRequest, s3.InvalidBucketName, "bucket name must be between 3 and 63 characters")
 			return
 		}
 
 		// Check format
 		if !bucketNameRegex.MatchString(bucket) {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "invalid bucket name format",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusBadRequest, s3.InvalidBucketName, "invalid bucket name format")
 			return
 		}
 
 		// Additional checks
 		if strings.Contains(bucket, "..") {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "bucket name cannot contain consecutive dots",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusBadRequest, s3.InvalidBucketName, "bucket name cannot contain consecutive dots")
 			return
 		}
 
 		if strings.HasPrefix(bucket, "xn--") {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "bucket name cannot start with 'xn--'",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusBadRequest, s3.InvalidBucketName, "bucket name cannot start with 'xn--'")
 			return
 		}
 
@@ -81,19 +71,13 @@ func ValidateObjectKey() gin.HandlerFunc {
 
 		// Check length
 		if len(key) > maxKeyLength {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "object key exceeds maximum length of 1024 characters",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusBadRequest, s3.KeyTooLong, "object key exceeds maximum length of 1024 characters")
 			return
 		}
 
 		// Check for empty key
 		if strings.TrimSpace(key) == "" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "object key cannot be empty or only whitespace",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusBadRequest, s3.InvalidArgument, "object key cannot be empty or only whitespace")
 			return
 		}
 
@@ -101,25 +85,39 @@ func ValidateObjectKey() gin.HandlerFunc {
 	}
 }
 
+// CheckObjectKey applies the rules ValidateObjectKey and NormalizeKey
+// enforce on path keys to a key given elsewhere, such as a move
+// destination, returning the error code and message of a rejected key
+func CheckObjectKey(key string) (s3.ErrorCode, string, bool) {
+	switch {
+	case len(key) > maxKeyLength:
+		return s3.KeyTooLong, "object key exceeds maximum length of 1024 characters", false
+	case strings.TrimSpace(key) == "":
+		return s3.InvalidArgument, "object key cannot be empty or only whitespace", false
+	case strings.ContainsRune(key, 0):
+		return s3.InvalidArgument, "object key cannot contain NUL bytes", false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return s3.InvalidArgument, "object key cannot contain . or .. path segments", false
+		}
+	}
+	return "", "", true
+}
+
 // ValidateContentLength validates that Content-Length header is present for PUT requests
 func ValidateContentLength() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Method == "PUT" {
 			if c.Request.ContentLength < 0 {
-				c.JSON(http.StatusLengthRequired, gin.H{
-					"error": "Content-Length header is required",
-				})
-				c.Abort()
+				AbortWithError(c, http.StatusLengthRequired, s3.MissingContentLength, "Content-Length header is required")
 				return
 			}
 
 			// Optional: check for maximum size
 			maxSize := int64(5 * 1024 * 1024 * 1024) // 5GB max
 			if c.Request.ContentLength > maxSize {
-				c.JSON(http.StatusRequestEntityTooLarge, gin.H{
-					"error": "object size exceeds maximum allowed size",
-				})
-				c.Abort()
+				AbortWithError(c, http.StatusRequestEntityTooLarge, s3.EntityTooLarge, "object size exceeds maximum allowed size")
 				return
 			}
 		}
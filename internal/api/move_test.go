@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestMoveObject(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	ctx := context.Background()
+	for name, owner := range map[string]string{"staging": "team", "production": "team", "other": "someone"} {
+		if err := container.BucketService.CreateBucket(ctx, name, owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"site/a.html", "site/b.html"} {
+		if _, err := container.ObjectService.PutObject(ctx, "staging", key, bytes.NewReader([]byte("<html>")), 6, "text/html"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	move := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w
+	}
+
+	w := move("/staging/site/a.html?move-to=production/www/a.html")
+	if w.Code != http.StatusOK || w.Header().Get("x-amz-version-id") == "" {
+		t.Fatalf("move = %d %s", w.Code, w.Body.String())
+	}
+	if _, err := container.ObjectService.HeadObject(ctx, "production", "www/a.html", nil); err != nil {
+		t.Errorf("destination after move: %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/staging/site/a.html?move-to=production/www/again.html", http.StatusNotFound},
+		{"/staging/site/b.html?move-to=other/b.html", http.StatusForbidden},
+		{"/staging/site/b.html?move-to=missing/b.html", http.StatusNotFound},
+		{"/staging/site/b.html?move-to=production", http.StatusBadRequest},
+		{"/staging/site/b.html?move-to=production/../b.html", http.StatusBadRequest},
+		{"/staging/site/b.html?move-to=staging/site/b.html", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := move(tt.path); w.Code != tt.status {
+			t.Errorf("POST %s = %d %s, want %d", tt.path, w.Code, w.Body.String(), tt.status)
+		}
+	}
+	if _, err := container.ObjectService.HeadObject(ctx, "staging", "site/b.html", nil); err != nil {
+		t.Errorf("refused moves removed the source: %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAPIVersion is the version prefix of the admin and extension API.
+// The S3-compatible surface stays unversioned.
+const AdminAPIVersion = "v1"
+
+// adminRoute is an admin or extension endpoint. It is served under
+// /admin/v1 and described in the OpenAPI document.
+type adminRoute struct {
+	Method  string
+	Path    string // Relative to /admin/v1, with gin :params
+	Legacy  string // Unversioned path relative to /admin kept for existing clients; empty for new endpoints
+	Tag     string
+	Summary string
+	Handler gin.HandlerFunc
+}
+
+// registerAdminRoutes serves routes under /admin/v1 and their legacy
+// paths under /admin. Responses on legacy paths point to their successor.
+func registerAdminRoutes(group *gin.RouterGroup, routes []adminRoute) {
+	versioned := group.Group("/" + AdminAPIVersion)
+	for _, r := range routes {
+		versioned.Handle(r.Method, r.Path, r.Handler)
+		if r.Legacy != "" {
+			group.Handle(r.Method, r.Legacy, deprecated(group.BasePath()+"/"+AdminAPIVersion+r.Path), r.Handler)
+		}
+	}
+}
+
+// deprecated marks responses of a legacy path with the versioned path
+// replacing it
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := successor
+		for _, p := range c.Params {
+			path = strings.Replace(path, ":"+p.Key, p.Value, 1)
+		}
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+path+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// OpenAPIDocument is an OpenAPI 3 description of the admin API
+type OpenAPIDocument struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    OpenAPIInfo                            `json:"info"`
+	Paths   map[string]map[string]OpenAPIOperation `json:"paths"`
+	Tags    []OpenAPITag                           `json:"tags,omitempty"`
+}
+
+// OpenAPIInfo holds the title and version of the API
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPITag groups operations
+type OpenAPITag struct {
+	Name string `json:"name"`
+}
+
+// OpenAPIOperation describes one method on a path
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter describes a path parameter
+type OpenAPIParameter struct {
+	Name     string        `json:"name"`
+	In       string        `json:"in"`
+	Required bool          `json:"required"`
+	Schema   OpenAPISchema `json:"schema"`
+}
+
+// OpenAPISchema is the type of a parameter
+type OpenAPISchema struct {
+	Type string `json:"type"`
+}
+
+// OpenAPIResponse describes a response
+type OpenAPIResponse struct {
+	Description string `json:"description"`
+}
+
+// buildOpenAPI describes the versioned admin routes. Operation IDs are
+// taken from the handler method names, so they stay stable across releases.
+func buildOpenAPI(basePath string, routes []adminRoute) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: "ComIO Admin API", Version: AdminAPIVersion},
+		Paths:   make(map[string]map[string]OpenAPIOperation),
+	}
+
+	// Method names shared by several handlers, like GetStatus, are
+	// qualified with the handler
+	handlers := make(map[string]map[string]bool)
+	for _, r := range routes {
+		receiver, method := handlerName(r.Handler)
+		if handlers[method] == nil {
+			handlers[method] = make(map[string]bool)
+		}
+		handlers[method][receiver] = true
+	}
+
+	tags := make(map[string]bool)
+	for _, r := range routes {
+		path, params := openAPIPath(basePath + r.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]OpenAPIOperation)
+		}
+
+		receiver, method := handlerName(r.Handler)
+		id := lowerFirst(method)
+		if len(handlers[method]) > 1 {
+			id = lowerFirst(strings.TrimSuffix(receiver, "Handler")) + method
+		}
+		op := OpenAPIOperation{
+			OperationID: id,
+			Summary:     r.Summary,
+			Responses: map[string]OpenAPIResponse{
+				"default": {Description: "JSON response; errors return an error document with code, message, resource and requestId"},
+			},
+		}
+		if r.Tag != "" {
+			op.Tags = []string{r.Tag}
+			tags[r.Tag] = true
+		}
+		for _, name := range params {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   OpenAPISchema{Type: "string"},
+			})
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = op
+	}
+
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, OpenAPITag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+	return doc
+}
+
+// openAPIPath converts gin :params to OpenAPI {params}
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			params = append(params, s[1:])
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// handlerName splits a method value like (*AdminHandler).HealthCheck into
+// its receiver and method names
+func handlerName(h gin.HandlerFunc) (receiver, method string) {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name, method = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		receiver = strings.Trim(name[i+1:], "(*)")
+	}
+	return receiver, method
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+// serveOpenAPI returns a handler serving the document
+func serveOpenAPI(doc *OpenAPIDocument) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	}
+}
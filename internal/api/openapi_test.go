@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+)
+
+func TestAdminRoutes_Versioned(t *testing.T) {
+	cfg := &config.Config{}
+	server := NewServer(cfg, createTestContainer(cfg))
+	server.SetupRoutes()
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/v1/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /admin/v1/health = %d, want 200", w.Code)
+	}
+	if w.Header().Get("Deprecation") != "" {
+		t.Error("versioned path marked deprecated")
+	}
+
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/photos/objects/progress", nil))
+	if w.Header().Get("Deprecation") != "true" {
+		t.Error("legacy path not marked deprecated")
+	}
+	if link := w.Header().Get("Link"); link != `</admin/v1/buckets/photos/objects/progress>; rel="successor-version"` {
+		t.Errorf("Link = %q", link)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	cfg := &config.Config{}
+	server := NewServer(cfg, createTestContainer(cfg))
+	server.SetupRoutes()
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/openapi.json = %d, want 200", w.Code)
+	}
+
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Info.Version != AdminAPIVersion {
+		t.Errorf("openapi = %s, version = %s", doc.OpenAPI, doc.Info.Version)
+	}
+
+	op, ok := doc.Paths["/admin/v1/jobs/{id}"]["delete"]
+	if !ok {
+		t.Fatalf("DELETE /admin/v1/jobs/{id} missing from %v", doc.Paths)
+	}
+	if op.OperationID != "cancelJob" {
+		t.Errorf("operationId = %q, want cancelJob", op.OperationID)
+	}
+	if len(op.Parameters) != 1 || op.Parameters[0].Name != "id" || op.Parameters[0].In != "path" {
+		t.Errorf("parameters = %+v", op.Parameters)
+	}
+
+	ids := make(map[string]bool)
+	for path, ops := range doc.Paths {
+		if path == "/{bucket}/{key}" || path == "/{bucket}" {
+			t.Errorf("S3 path %s in the admin document", path)
+		}
+		for _, op := range ops {
+			if ids[op.OperationID] {
+				t.Errorf("duplicate operationId %q", op.OperationID)
+			}
+			ids[op.OperationID] = true
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// postForm builds a multipart form upload with the file as its last field
+func postForm(t *testing.T, fields [][2]string, filename, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, f := range fields {
+		if err := w.WriteField(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	w.Close()
+	return &body, w.FormDataContentType()
+}
+
+func TestPostObject_SignedPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{Auth: config.AuthConfig{Enabled: true}}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Authenticator = auth.NewHMACAuthenticator()
+	container.Authenticator.AddUser(&auth.User{AccessKeyID: "alice", SecretAccessKey: "alice-secret"})
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	if err := container.BucketService.CreateBucket(context.Background(), "uploads", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := base64.StdEncoding.EncodeToString([]byte(`{
+		"expiration": "2099-01-01T00:00:00Z",
+		"conditions": [
+			{"bucket": "uploads"},
+			["starts-with", "$key", "user/alice/"],
+			["starts-with", "$Content-Type", "text/"],
+			["content-length-range", 1, 64],
+			{"success_action_status": "201"},
+			{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+			{"x-amz-credential": "alice/20990101/us-east-1/s3/aws4_request"},
+			["starts-with", "$x-amz-meta-note", ""]
+		]
+	}`))
+	signed := func(key, contentType string) [][2]string {
+		return [][2]string{
+			{"key", key},
+			{"Content-Type", contentType},
+			{"success_action_status", "201"},
+			{"x-amz-meta-note", "from a form"},
+			{"x-amz-algorithm", "AWS4-HMAC-SHA256"},
+			{"x-amz-credential", "alice/20990101/us-east-1/s3/aws4_request"},
+			{"policy", policy},
+			{"x-amz-signature", auth.SignPostPolicy("alice-secret", "20990101", "us-east-1", "s3", policy)},
+		}
+	}
+	post := func(target string, fields [][2]string, filename, content string) *httptest.ResponseRecorder {
+		body, contentType := postForm(t, fields, filename, content)
+		req := httptest.NewRequest("POST", target, body)
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/uploads", signed("user/alice/${filename}", "text/plain"), "notes.txt", "hello")
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/uploads/user/alice/notes.txt" {
+		t.Fatalf("POST signed form = %d %s, location %q", w.Code, w.Body, w.Header().Get("Location"))
+	}
+
+	w = httptest.NewRecorder()
+	get := httptest.NewRequest("GET", "/uploads/user/alice/notes.txt", nil)
+	signRequest(get, "alice", "alice-secret")
+	server.router.ServeHTTP(w, get)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" ||
+		w.Header().Get("Content-Type") != "text/plain" || w.Header().Get("x-amz-meta-note") != "from a form" {
+		t.Errorf("GET uploaded object = %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	// The trailing slash form of the bucket URL is accepted too
+	if w := post("/uploads/", signed("user/alice/second.txt", "text/plain"), "x", "again"); w.Code != http.StatusCreated {
+		t.Errorf("POST /uploads/ = %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name     string
+		fields   [][2]string
+		content  string
+		wantCode int
+	}{
+		{"key outside prefix", signed("user/bob/x.txt", "text/plain"), "hi", http.StatusForbidden},
+		{"content type not allowed", signed("user/alice/x.html", "image/png"), "hi", http.StatusForbidden},
+		{"file too large", signed("user/alice/big.txt", "text/plain"), string(make([]byte, 65)), http.StatusForbidden},
+		{"empty file", signed("user/alice/empty.txt", "text/plain"), "", http.StatusForbidden},
+		{"unsigned", [][2]string{{"key", "user/alice/x.txt"}}, "hi", http.StatusForbidden},
+		{"bad signature", append(signed("user/alice/x.txt", "text/plain")[:7], [2]string{"x-amz-signature", "00"}), "hi", http.StatusForbidden},
+		{"duplicate field", append(signed("user/alice/x.txt", "text/plain"), [2]string{"key", "user/bob/x.txt"}), "hi", http.StatusBadRequest},
+		{"unlisted field", append(signed("user/alice/x.txt", "text/plain"), [2]string{"x-amz-meta-extra", "1"}), "hi", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := post("/uploads", tt.fields, "x", tt.content); w.Code != tt.wantCode {
+				t.Errorf("POST = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/recovery"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func TestStartupRecovery_Routes(t *testing.T) {
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Recovery = recovery.NewTracker(recovery.PhaseAllocations)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	container.Recovery.Begin(recovery.PhaseAllocations, 4)
+	container.Recovery.Advance(1)
+	for _, path := range []string{"/", "/photos", "/photos/cat.jpg"} {
+		w := get(path)
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s while recovering = %d, Retry-After %q", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	w := get("/admin/v1/recovery")
+	var status recovery.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.Recovering || status.Percent != 25 || status.Phase != recovery.PhaseAllocations {
+		t.Errorf("GET /admin/v1/recovery = %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/admin/v1/health"); !bytes.Contains(w.Body.Bytes(), []byte(`"status":"recovering"`)) {
+		t.Errorf("health while recovering = %s", w.Body.String())
+	}
+
+	container.Recovery.End(nil)
+	container.Recovery.Finish()
+	if w := get("/photos"); w.Code == http.StatusServiceUnavailable {
+		t.Errorf("GET /photos after recovery = %d", w.Code)
+	}
+}
+
+func TestStartupRecovery_RestoresAllocations(t *testing.T) {
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = openTestEngine(t)
+	container.ObjectService = object.NewService(container.ObjectRepo, container.Engine)
+
+	ctx := context.Background()
+	if err := container.BucketService.CreateBucket(ctx, "photos", "owner"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if _, err := container.ObjectService.PutObject(ctx, "photos", key, bytes.NewReader([]byte("jpeg data")), 9, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The metadata outlives a restart, the allocator does not
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container.Engine = restarted
+	container.startRecovery()
+	if container.Recovery == nil {
+		t.Fatal("startRecovery() did not start")
+	}
+	defer container.stopRecovery()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for container.Recovery.Recovering() {
+		if status := container.Recovery.Status(); status.Error != "" || time.Now().After(deadline) {
+			t.Fatalf("recovery did not complete: %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if used := restarted.Stats().UsedBytes; used != 27 {
+		t.Errorf("UsedBytes after recovery = %d, want 27", used)
+	}
+	if offset, err := restarted.Allocate(9); err != nil || offset < 27 {
+		t.Errorf("Allocate() after recovery = %d, %v; want past the restored objects", offset, err)
+	}
+}
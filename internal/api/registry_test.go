@@ -0,0 +1,252 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/danielino/comio/internal/api/handlers"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// TestRegistry_ExampleConfig checks the example registry configuration
+// points the s3 driver at the registry endpoint the way comio serves it
+func TestRegistry_ExampleConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigFile(filepath.Join("..", "..", "configs", "registry.yml"))
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatalf("reading example registry config: %v", err)
+	}
+
+	endpoint, err := url.Parse(v.GetString("storage.s3.regionendpoint"))
+	if err != nil || endpoint.Path != "/registry" {
+		t.Errorf("regionendpoint = %q, want a URL ending in /registry", v.GetString("storage.s3.regionendpoint"))
+	}
+	if !v.GetBool("storage.s3.forcepathstyle") {
+		t.Error("forcepathstyle must be set: comio only serves path-style requests")
+	}
+	if !v.GetBool("storage.redirect.disable") {
+		t.Error("redirect.disable must be set: comio does not presign redirects")
+	}
+	if v.GetInt64("storage.s3.chunksize") < multipart.DefaultMinPartSize {
+		t.Errorf("chunksize %d is below the minimum part size %d", v.GetInt64("storage.s3.chunksize"), multipart.DefaultMinPartSize)
+	}
+	if v.GetString("storage.s3.bucket") == "" {
+		t.Error("no bucket configured")
+	}
+}
+
+// registryClient issues the requests the registry's s3 driver makes
+type registryClient struct {
+	t    *testing.T
+	base string
+}
+
+func (r *registryClient) do(method, path string, body []byte, header map[string]string) (*http.Response, []byte) {
+	r.t.Helper()
+	req, err := http.NewRequest(method, r.base+path, bytes.NewReader(body))
+	if err != nil {
+		r.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		r.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		r.t.Fatalf("%s %s: reading body: %v", method, path, err)
+	}
+	return resp, data
+}
+
+// expect issues a request and decodes the XML response into v, if not nil
+func (r *registryClient) expect(status int, method, path string, body []byte, header map[string]string, v any) *http.Response {
+	r.t.Helper()
+	resp, data := r.do(method, path, body, header)
+	if resp.StatusCode != status {
+		r.t.Fatalf("%s %s = %d, want %d: %s", method, path, resp.StatusCode, status, data)
+	}
+	if v != nil {
+		if err := xml.Unmarshal(data, v); err != nil {
+			r.t.Fatalf("%s %s: decoding %s: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+// TestRegistry_StorageDriver replays the request sequence of the registry's
+// s3 driver: small PutContent writes, chunked blob uploads that are resumed
+// by listing in-progress uploads, ranged reads, stat and directory walks,
+// moves and recursive deletes
+func TestRegistry_StorageDriver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 64*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	cfg.Registry.Enabled = true
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Multipart = multipart.NewService(engine, container.ObjectService)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+	srv := httptest.NewServer(server.router)
+	defer srv.Close()
+
+	if err := container.BucketService.CreateBucket(context.Background(), "docker", "owner"); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	r := &registryClient{t: t, base: srv.URL + "/registry/docker"}
+	root := "/docker/registry/v2"
+
+	// Bucket check on startup
+	r.expect(http.StatusOK, "HEAD", "", nil, nil, nil)
+
+	// PutContent and GetContent of a link file
+	link := root + "/repositories/app/_manifests/tags/latest/current/link"
+	digest := []byte("sha256:0123456789abcdef")
+	resp := r.expect(http.StatusOK, "PUT", link, digest, map[string]string{"Content-Type": "application/octet-stream"}, nil)
+	if !strings.HasPrefix(resp.Header.Get("ETag"), `"`) {
+		t.Errorf("PUT ETag = %q, want a quoted ETag", resp.Header.Get("ETag"))
+	}
+	if _, data := r.do("GET", link, nil, map[string]string{"Range": "bytes=0-"}); !bytes.Equal(data, digest) {
+		t.Errorf("GetContent = %q, want %q", data, digest)
+	}
+
+	// Chunked blob upload: the driver starts a multipart upload, writes a
+	// chunk, then resumes by finding the upload and its parts
+	blob := make([]byte, multipart.DefaultMinPartSize+1000)
+	rand.Read(blob)
+	data := root + "/repositories/app/_uploads/u1/data"
+
+	var initiated handlers.InitiateMultipartUploadResult
+	r.expect(http.StatusOK, "POST", data+"?uploads", nil, nil, &initiated)
+	uploadID := initiated.UploadID
+	r.expect(http.StatusOK, "PUT", fmt.Sprintf("%s?partNumber=1&uploadId=%s", data, uploadID), blob[:multipart.DefaultMinPartSize], nil, nil)
+
+	var uploads handlers.ListMultipartUploadsResult
+	r.expect(http.StatusOK, "GET", "?uploads&prefix="+url.QueryEscape(strings.TrimPrefix(data, "/")), nil, nil, &uploads)
+	if len(uploads.Uploads) != 1 || uploads.Uploads[0].UploadID != uploadID {
+		t.Fatalf("in-progress uploads = %+v, want %s", uploads.Uploads, uploadID)
+	}
+	var parts handlers.ListPartsResult
+	r.expect(http.StatusOK, "GET", data+"?uploadId="+uploadID, nil, nil, &parts)
+	if len(parts.Parts) != 1 || parts.Parts[0].Size != multipart.DefaultMinPartSize {
+		t.Fatalf("parts = %+v, want one full part", parts.Parts)
+	}
+
+	r.expect(http.StatusOK, "PUT", fmt.Sprintf("%s?partNumber=2&uploadId=%s", data, uploadID), blob[multipart.DefaultMinPartSize:], nil, nil)
+	parts = handlers.ListPartsResult{}
+	r.expect(http.StatusOK, "GET", data+"?uploadId="+uploadID, nil, nil, &parts)
+	if len(parts.Parts) != 2 {
+		t.Fatalf("parts = %+v, want two", parts.Parts)
+	}
+	complete := fmt.Sprintf(`<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>%s</ETag></Part>`+
+		`<Part><PartNumber>2</PartNumber><ETag>%s</ETag></Part></CompleteMultipartUpload>`,
+		parts.Parts[0].ETag, parts.Parts[1].ETag)
+	r.expect(http.StatusOK, "POST", data+"?uploadId="+uploadID, []byte(complete), nil, nil)
+
+	// Reader at an offset, and past the end
+	if _, got := r.do("GET", data, nil, map[string]string{"Range": "bytes=100-"}); !bytes.Equal(got, blob[100:]) {
+		t.Errorf("ranged read returned %d bytes, want %d", len(got), len(blob)-100)
+	}
+	r.expect(http.StatusRequestedRangeNotSatisfiable, "GET", data, nil, map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(blob))}, nil)
+
+	// Move the finished upload into the content-addressed blob store
+	blobPath := root + "/blobs/sha256/ab/abcdef/data"
+	var copied handlers.CopyObjectResult
+	r.expect(http.StatusOK, "PUT", blobPath, nil, map[string]string{"x-amz-copy-source": "docker" + data}, &copied)
+	if copied.ETag == "" {
+		t.Error("copy returned no ETag")
+	}
+	del := fmt.Sprintf(`<Delete><Object><Key>%s</Key></Object></Delete>`, strings.TrimPrefix(data, "/"))
+	var deleted handlers.DeleteResult
+	r.expect(http.StatusOK, "POST", "?delete", []byte(del), nil, &deleted)
+	if len(deleted.Deleted) != 1 || len(deleted.Errors) != 0 {
+		t.Errorf("DeleteObjects = %+v, want one deleted key", deleted)
+	}
+	if _, got := r.do("GET", blobPath, nil, nil); !bytes.Equal(got, blob) {
+		t.Error("moved blob differs from the uploaded one")
+	}
+
+	// Stat: a file is found by a listing of its exact key, a directory by
+	// a listing of its prefix
+	var list handlers.ListBucketResult
+	r.expect(http.StatusOK, "GET", "?list-type=2&max-keys=1&prefix="+url.QueryEscape(strings.TrimPrefix(blobPath, "/")), nil, nil, &list)
+	if len(list.Contents) != 1 || list.Contents[0].Size != int64(len(blob)) {
+		t.Errorf("stat of blob = %+v", list.Contents)
+	}
+	r.expect(http.StatusOK, "GET", "?list-type=2&max-keys=1&prefix="+url.QueryEscape("docker/registry/v2/blobs/"), nil, nil, &list)
+	if list.KeyCount == nil || *list.KeyCount != 1 {
+		t.Error("stat of blobs directory found nothing")
+	}
+
+	// List of a directory rolls up its subdirectories
+	r.expect(http.StatusOK, "PUT", root+"/repositories/other/_layers/x/link", digest, nil, nil)
+	list = handlers.ListBucketResult{}
+	r.expect(http.StatusOK, "GET", "?list-type=2&delimiter=/&prefix="+url.QueryEscape("docker/registry/v2/repositories/"), nil, nil, &list)
+	var dirs []string
+	for _, p := range list.CommonPrefixes {
+		dirs = append(dirs, p.Prefix)
+	}
+	if strings.Join(dirs, ",") != "docker/registry/v2/repositories/app/,docker/registry/v2/repositories/other/" || len(list.Contents) != 0 {
+		t.Errorf("directory listing = %v, %+v", dirs, list.Contents)
+	}
+
+	// Paging through the rolled-up prefixes continues past each one
+	list = handlers.ListBucketResult{}
+	r.expect(http.StatusOK, "GET", "?list-type=2&max-keys=1&delimiter=/&prefix="+url.QueryEscape("docker/registry/v2/repositories/"), nil, nil, &list)
+	if !list.IsTruncated || len(list.CommonPrefixes) != 1 {
+		t.Fatalf("first page = %+v, want one truncated prefix", list)
+	}
+	token := list.NextContinuationToken
+	list = handlers.ListBucketResult{}
+	r.expect(http.StatusOK, "GET", "?list-type=2&max-keys=1&delimiter=/&continuation-token="+token+"&prefix="+url.QueryEscape("docker/registry/v2/repositories/"), nil, nil, &list)
+	if list.IsTruncated || len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0].Prefix != "docker/registry/v2/repositories/other/" {
+		t.Errorf("second page = %+v, want the other repository", list)
+	}
+
+	// Recursive delete of a repository, then it is gone
+	list = handlers.ListBucketResult{}
+	r.expect(http.StatusOK, "GET", "?list-type=2&prefix="+url.QueryEscape("docker/registry/v2/repositories/app/"), nil, nil, &list)
+	var objects strings.Builder
+	for _, o := range list.Contents {
+		fmt.Fprintf(&objects, "<Object><Key>%s</Key></Object>", o.Key)
+	}
+	deleted = handlers.DeleteResult{}
+	r.expect(http.StatusOK, "POST", "?delete", []byte("<Delete><Quiet>true</Quiet>"+objects.String()+"</Delete>"), nil, &deleted)
+	if len(deleted.Deleted) != 0 || len(deleted.Errors) != 0 {
+		t.Errorf("quiet DeleteObjects = %+v, want an empty result", deleted)
+	}
+	r.expect(http.StatusNotFound, "HEAD", link, nil, nil, nil)
+	r.expect(http.StatusNoContent, "DELETE", link, nil, nil, nil)
+}
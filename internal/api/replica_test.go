@@ -0,0 +1,82 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/replication"
+)
+
+func TestReadReplica(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from primary"))
+	}))
+	defer primary.Close()
+
+	state := replication.NewReplicaState()
+	router := gin.New()
+	router.Use(middleware.ReadReplica(state, primary.URL))
+	router.GET("/:bucket/:key", func(c *gin.Context) { c.String(http.StatusOK, "from replica") })
+	router.PUT("/:bucket/:key", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.DELETE("/:bucket/:key", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	// A real server, as the proxy needs a connection-backed response writer
+	replica := httptest.NewServer(router)
+	defer replica.Close()
+
+	get := func(token string) (string, http.Header) {
+		req, _ := http.NewRequest("GET", replica.URL+"/b/k", nil)
+		if token != "" {
+			req.Header.Set(replication.HeaderConsistencyToken, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header
+	}
+
+	written := time.Now().Add(-time.Second)
+	token := replication.ConsistencyToken(written)
+
+	// The write has not been replicated yet
+	body, header := get(token)
+	if body != "from primary" || header.Get(replication.HeaderServedBy) != "primary" {
+		t.Errorf("unmet token served %q by %q, want the primary", body, header.Get(replication.HeaderServedBy))
+	}
+
+	// The primary replicates the write
+	req := httptest.NewRequest("PUT", "/b/k", strings.NewReader("data"))
+	req.Header.Set(replication.HeaderReplicationTimestamp, token)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	body, header = get(token)
+	if body != "from replica" {
+		t.Errorf("met token served %q, want the replica", body)
+	}
+	if header.Get(replication.HeaderReplicaLag) == "" {
+		t.Error("replica read is missing the lag header")
+	}
+
+	if body, _ = get(""); body != "from replica" {
+		t.Errorf("read without token served %q, want the replica", body)
+	}
+
+	// A replicated delete of an object the replica never held is recorded
+	deleted := time.Now()
+	req = httptest.NewRequest("DELETE", "/b/gone", nil)
+	req.Header.Set(replication.HeaderReplicationTimestamp, replication.ConsistencyToken(deleted))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if !state.AppliedSince("b", "gone", deleted.Add(-time.Second)) {
+		t.Error("replicated delete answered 404 was not recorded")
+	}
+}
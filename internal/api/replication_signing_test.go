@@ -0,0 +1,96 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/replication"
+)
+
+func TestReplicationSignatures(t *testing.T) {
+	cfg := &config.Config{Replication: config.ReplicationConfig{
+		Peers: []config.PeerConfig{{NodeID: "site-a", Key: "secret"}},
+	}}
+	engine := openTestEngine(t)
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	signed := func(signer *replication.Signer, method, target, body string) *http.Request {
+		req, _ := http.NewRequest(method, "http://localhost"+target, strings.NewReader(body))
+		if err := signer.Sign(req); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		req.Body = io.NopCloser(strings.NewReader(body))
+		return req
+	}
+
+	// Buckets are still created by clients, but objects are only written
+	// by peers
+	if w := serve(httptest.NewRequest("PUT", "/photos", nil)); w.Code != http.StatusOK {
+		t.Fatalf("PUT /photos = %d %s", w.Code, w.Body)
+	}
+	if w := serve(httptest.NewRequest("PUT", "/photos/cat.jpg", strings.NewReader("evil"))); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned PUT = %d, want 403", w.Code)
+	}
+	if w := serve(httptest.NewRequest("POST", "/photos?delete", strings.NewReader("<Delete/>"))); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned batch delete = %d, want 403", w.Code)
+	}
+
+	req := httptest.NewRequest("DELETE", "/photos/cat.jpg", nil)
+	req.Header.Set(replication.HeaderDeleteMarker, "true")
+	if w := serve(req); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned replicated delete = %d, want 403", w.Code)
+	}
+	if w := serve(httptest.NewRequest("POST", "/admin/v1/replication/patch", strings.NewReader("{}"))); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned patch = %d, want 403", w.Code)
+	}
+
+	peer := replication.NewSigner("site-a", []byte("secret"))
+	if w := serve(signed(peer, "PUT", "/photos/cat.jpg", "meow")); w.Code != http.StatusOK {
+		t.Errorf("signed PUT = %d %s", w.Code, w.Body)
+	}
+	if w := serve(signed(replication.NewSigner("site-a", []byte("guess")), "PUT", "/photos/cat.jpg", "evil")); w.Code != http.StatusForbidden {
+		t.Errorf("PUT signed with the wrong key = %d, want 403", w.Code)
+	}
+	if w := serve(httptest.NewRequest("GET", "/photos/cat.jpg", nil)); w.Body.String() != "meow" {
+		t.Errorf("GET = %d %s, want the signed write", w.Code, w.Body)
+	}
+	if w := serve(httptest.NewRequest("DELETE", "/photos/cat.jpg", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("unsigned DELETE = %d, want 403", w.Code)
+	}
+	if w := serve(signed(peer, "DELETE", "/photos/cat.jpg", "")); w.Code != http.StatusNoContent {
+		t.Errorf("signed DELETE = %d %s", w.Code, w.Body)
+	}
+
+	// Headers the peer did not sign are refused
+	req = signed(peer, "PUT", "/photos/cat.jpg", "meow")
+	req.Header.Set("Content-Type", "text/html")
+	if w := serve(req); w.Code != http.StatusForbidden {
+		t.Errorf("PUT with an altered Content-Type = %d, want 403", w.Code)
+	}
+
+	// The peer is not an admin: it reaches the endpoints replication writes
+	// through, and nothing else of the admin API
+	if w := serve(signed(peer, "GET", "/admin/v1/jobs", "")); w.Code != http.StatusForbidden {
+		t.Errorf("signed GET /admin/v1/jobs = %d, want 403", w.Code)
+	}
+	if w := serve(signed(peer, "PUT", "/admin/v1/runtime", "{}")); w.Code != http.StatusForbidden {
+		t.Errorf("signed PUT /admin/v1/runtime = %d, want 403", w.Code)
+	}
+	if w := serve(signed(peer, "DELETE", "/admin/photos/objects?confirm=true", "")); w.Code == http.StatusForbidden {
+		t.Errorf("signed purge = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/billing"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/internal/usage"
+)
+
+func TestReports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{Billing: config.BillingConfig{Enabled: true, Bucket: "chargeback", Prefix: "reports/"}}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Usage, err = usage.NewTracker(t.TempDir(), 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container.Usage.SetStorageSource(container.storageSamples)
+	container.Billing = billing.NewReporter(container.Usage, container.ObjectService, container.bucketOwners, "chargeback", "reports/")
+	container.Jobs, err = jobs.NewManager(jobs.Config{Workers: 1}, jobs.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	container.Jobs.Start()
+	defer container.Jobs.Stop()
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+	serve("PUT", "/chargeback", "")
+	serve("PUT", "/logs", "")
+	serve("PUT", "/logs/app.log", "0123456789")
+	serve("GET", "/logs/app.log", "")
+	if err := container.Usage.SampleStorage(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	month := time.Now().UTC().Format(billing.MonthLayout)
+	w := serve("GET", "/admin/v1/reports/"+month, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/v1/reports/%s = %d: %s", month, w.Code, w.Body)
+	}
+	var report billing.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	var logs *billing.Line
+	for i := range report.Buckets {
+		if report.Buckets[i].Bucket == "logs" {
+			logs = &report.Buckets[i]
+		}
+	}
+	// Only the current hour has a sample
+	if logs == nil || logs.Requests != 3 || logs.BytesIn != 10 || logs.ByteHours != 10 || logs.AverageBytes != int64(10/report.Hours) {
+		t.Errorf("logs = %+v, want 3 requests, 10 bytes in and 10 bytes stored for an hour", logs)
+	}
+
+	w = serve("GET", "/admin/v1/reports/"+month+"?format=csv", "")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) < 2 || rows[0][0] != "month" || rows[1][0] != month {
+		t.Errorf("CSV = %q", rows)
+	}
+
+	w = serve("POST", "/admin/v1/reports/"+month, "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /admin/v1/reports/%s = %d: %s", month, w.Code, w.Body)
+	}
+	var accepted struct {
+		JobID string `json:"job_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &accepted)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := container.Jobs.Get(accepted.JobID)
+		if job.State.Finished() {
+			if job.State != jobs.StateCompleted {
+				t.Fatalf("report job %+v", job)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("report job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w = serve("GET", "/admin/v1/reports", "")
+	var list struct {
+		Reports []billing.Stored `json:"reports"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Reports) != 2 || list.Reports[0].Key != "reports/"+month+".json" || list.Reports[1].Format != "csv" {
+		t.Errorf("reports = %+v, want the JSON and CSV of %s", list.Reports, month)
+	}
+
+	if w := serve("GET", "/admin/v1/reports/march", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid month = %d, want 400", w.Code)
+	}
+	if w := serve("GET", "/admin/v1/reports/2999-01", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("future month = %d, want 400", w.Code)
+	}
+}
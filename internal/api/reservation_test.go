@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestReservations(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	ctx := context.Background()
+	if err := container.BucketService.CreateBucket(ctx, "ingest", "owner"); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/admin/v1/buckets/ingest/reservations", `{"size": 10, "ttl": "soon"}`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("reserve with a bad ttl = %d, want 400", w.Code)
+	}
+	w := do("POST", "/admin/v1/buckets/ingest/reservations", `{"size": 10, "ttl": "1h"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("reserve = %d %s", w.Code, w.Body.String())
+	}
+	var res bucket.Reservation
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	token := map[string]string{"X-Comio-Reservation": res.Token}
+
+	if w := do("PUT", "/ingest/a.txt", "123456", token); w.Code != http.StatusOK {
+		t.Fatalf("put with reservation = %d %s", w.Code, w.Body.String())
+	}
+	if w := do("PUT", "/ingest/b.txt", "12345", token); w.Code != http.StatusForbidden {
+		t.Errorf("put past the reservation = %d, want 403", w.Code)
+	}
+	if w := do("PUT", "/ingest/c.txt", "1", map[string]string{"X-Comio-Reservation": "unknown"}); w.Code != http.StatusNotFound {
+		t.Errorf("put with an unknown reservation = %d, want 404", w.Code)
+	}
+
+	w = do("GET", "/admin/v1/reservations/"+res.Token, "", nil)
+	var got struct {
+		Reservation bucket.Reservation `json:"reservation"`
+		Remaining   int64              `json:"remaining"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Remaining != 4 || got.Reservation.Used != 6 {
+		t.Errorf("get reservation = %d %s", w.Code, w.Body.String())
+	}
+
+	if w := do("DELETE", "/admin/v1/reservations/"+res.Token, "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("release = %d", w.Code)
+	}
+	if w := do("GET", "/admin/v1/reservations/"+res.Token, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("get released reservation = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/multipart"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/pkg/client"
+)
+
+// openTestEngine opens a storage engine on a temporary device
+func openTestEngine(t *testing.T) storage.Engine {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	t.Cleanup(func() { engine.Close() })
+	return engine
+}
+
+// cutReader returns the first n bytes of r, then fails as a dropped
+// connection does
+type cutReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *cutReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= n
+	return n, err
+}
+
+func (c *cutReader) Close() error { return nil }
+
+// flakyTransport cuts the body of the first chunk short
+type flakyTransport struct {
+	cut atomic.Bool
+}
+
+func (f *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPatch && f.cut.CompareAndSwap(false, true) {
+		req.Body = &cutReader{r: req.Body, n: int(req.ContentLength / 2)}
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestResumableUpload_SurvivesDroppedChunk(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Multipart = multipart.NewService(engine, container.ObjectService)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+	srv := httptest.NewServer(server.router)
+	defer srv.Close()
+
+	ctx := context.Background()
+	if err := container.BucketService.CreateBucket(ctx, "videos", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &flakyTransport{}
+	c, err := client.New(client.Config{
+		Endpoint:   srv.URL,
+		HTTPClient: &http.Client{Transport: transport},
+		Retry:      &client.RetryPolicy{MaxAttempts: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := bytes.Repeat([]byte("0123456789"), 10000)
+	var session string
+	info, err := c.ResumableUpload(ctx, "videos", "clips/a.bin", bytes.NewReader(payload), int64(len(payload)), &client.ResumableOptions{
+		ChunkSize:   30000,
+		ContentType: "application/octet-stream",
+		OnSession:   func(id string) { session = id },
+	})
+	if err != nil {
+		t.Fatalf("ResumableUpload() error = %v", err)
+	}
+	if !transport.cut.Load() || session == "" || info.Size != int64(len(payload)) {
+		t.Errorf("upload = %+v, session %q, chunk cut %v", info, session, transport.cut.Load())
+	}
+
+	body, _, err := c.GetObject(ctx, "videos", "clips/a.bin", nil)
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	defer body.Close()
+	got, _ := io.ReadAll(body)
+	if !bytes.Equal(got, payload) {
+		t.Errorf("stored %d bytes, want the %d uploaded", len(got), len(payload))
+	}
+
+	// The finished session is gone
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest("HEAD", "/videos/clips/a.bin?session="+session, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("HEAD finished session = %d, want 404", w.Code)
+	}
+}
@@ -1,56 +1,352 @@
 package api
 
 import (
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"github.com/danielino/comio/internal/api/handlers"
 	"github.com/danielino/comio/internal/api/middleware"
+	"github.com/danielino/comio/internal/apply"
+	"github.com/danielino/comio/internal/console"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/profiling"
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 // SetupRoutes configures the routes using injected dependencies from the container
 // All dependencies are now provided via dependency injection, making this method
 // testable and decoupled from implementation details
 func (s *Server) SetupRoutes() {
+	// Virtual-hosted-style requests are routed again in path style, before
+	// any other middleware runs
+	if domain := s.cfg.Server.Domain; domain != "" {
+		tenantHosts := s.cfg.Tenancy.Enabled && strings.EqualFold(s.cfg.Tenancy.Domain, domain)
+		s.router.Use(middleware.VirtualHost(s.router, domain, tenantHosts))
+	}
+
 	// Apply global middleware
+	s.router.Use(middleware.RequestID())
 	s.router.Use(middleware.Recovery())
 	s.router.Use(middleware.Logging())
-	// Auth middleware should be applied to specific routes or globally if appropriate
+	s.router.Use(middleware.StripHopByHop())
+	s.router.Use(middleware.LimitHeaders(s.cfg.Server.MaxHeaderCount, s.cfg.Server.MaxHeaderBytes))
+	if s.container.Usage != nil {
+		s.router.Use(middleware.TrackUsage(s.container.Usage))
+	}
 
 	// Create handlers using injected services from container
 	bucketHandler := handlers.NewBucketHandler(s.container.BucketService)
 	objectHandler := handlers.NewObjectHandler(s.container.ObjectService)
 	adminHandler := handlers.NewAdminHandler(s.container.Engine)
+	adminHandler.SetDiskHealth(s.container.DiskHealth)
+	adminHandler.SetKeyManager(s.container.KeyManager)
+	adminHandler.SetStatsHistory(s.container.StatsHistory)
+	adminHandler.SetDatabase(s.container.DB)
+	adminHandler.SetQuarantine(s.container.Quarantine)
+	adminHandler.SetReplicator(s.container.Replicator)
+	adminHandler.SetRecovery(s.container.Recovery)
+	adminHandler.SetAdmission(s.container.Admission)
+	adminHandler.SetFragmentationThresholds(fragmentationThresholds(s.cfg.Storage.Fragmentation))
+	jobHandler := handlers.NewJobHandler(s.container.Jobs)
+	objectHandler.SetJobManager(s.container.Jobs)
+	objectHandler.SetBucketService(s.container.BucketService)
+	objectHandler.SetPostPolicyAuth(s.container.Authenticator, s.cfg.Auth.Enabled)
+	objectHandler.SetAttestation(s.container.Attestation)
+	scheduleHandler := handlers.NewScheduleHandler(s.container.Scheduler)
+	replicationHandler := handlers.NewReplicationHandler(s.container.Replicator, s.container.ObjectService)
+	replicationHandler.SetReplicaState(s.container.Replica)
+	replicationHandler.SetBucketService(s.container.BucketService)
+	replicationHandler.SetJobs(s.container.Jobs)
+	bootstrapHandler := handlers.NewBootstrapHandler(s.container.BucketService, s.container.ObjectService, s.container.Jobs)
+	bootstrapHandler.SetReplicaState(s.container.Replica, s.cfg.ReadReplica.PrimaryURL)
+	bootstrapHandler.SetCheckpoint(filepath.Join("metadata", "replication", "bootstrap.json"))
+	multipartHandler := handlers.NewMultipartHandler(s.container.Multipart)
+	raftHandler := handlers.NewRaftHandler(s.container.Raft)
+	clusterHandler := handlers.NewClusterHandler(s.container.Ring, s.container.Decommissioner, s.container.Jobs)
+	clusterHandler.SetRebalancer(s.container.Rebalancer)
+	encryptionHandler := handlers.NewEncryptionHandler(s.container.Keys, s.container.BucketService, s.container.ObjectService, s.container.Jobs)
+	serviceAccountHandler := handlers.NewServiceAccountHandler(s.container.Authenticator, s.container.Users)
+	nfsHandler := handlers.NewNFSHandler(s.container.NFS, s.container.BucketService)
+	exportHandler := handlers.NewExportHandler(s.container.Exporter, s.container.BucketService, s.container.Jobs, s.cfg.Export.BytesPerSecond)
+	reservationHandler := handlers.NewReservationHandler(s.container.BucketService)
+	runtimeHandler := handlers.NewRuntimeHandler()
+	registryHandler := handlers.NewRegistryHandler(s.container.ObjectService)
+	usageHandler := handlers.NewUsageHandler(s.container.Usage)
+	reportHandler := handlers.NewReportHandler(s.container.Billing, s.container.Jobs)
+	applyHandler := handlers.NewApplyHandler(apply.NewReconciler(s.container.BucketService, s.container.Users))
+
+	// With peers configured this node is a replica: every write of objects,
+	// replicated or not, must be signed by one of them
+	applyPatch := replicationHandler.ApplyPatch
+	deleteAllObjects := objectHandler.DeleteAllObjects
+	postBucket := byQuery("delete", objectHandler.DeleteObjects, objectHandler.PostObject)
+	replicatedWrites := func(c *gin.Context) { c.Next() }
+	replicationAdmin := middleware.RequireAdmin()
+	if peers := s.cfg.Replication.Peers; len(peers) > 0 {
+		keys := make(map[string][]byte, len(peers))
+		for _, p := range peers {
+			keys[p.NodeID] = []byte(p.Key)
+		}
+		s.router.Use(middleware.ReplicationSignature(replication.NewVerifier(keys)))
+		applyPatch = middleware.RequireReplicationNode(applyPatch)
+		deleteAllObjects = middleware.RequireReplicationNode(deleteAllObjects)
+		postBucket = middleware.RequireReplicationNode(postBucket)
+		replicatedWrites = middleware.ReplicatedWrites()
+		// Peers are not admins: the handlers above admit them alone
+		replicationAdmin = func(c *gin.Context) { c.Next() }
+	}
+	// Other nodes of the cluster send the cluster token
+	if token := s.cfg.Cluster.Token; token != "" {
+		s.router.Use(middleware.ClusterToken(token))
+	}
+	// Anyone else signs with an access key when authentication is enabled
+	authenticate := middleware.Authentication(&s.cfg.Auth, s.container.Authenticator)
+
+	// Web console, only served behind the admin credentials
+	if s.cfg.Console.Enabled {
+		if s.cfg.Auth.AdminAccessKey != "" {
+			console.Register(s.router, s.cfg.Auth.AdminAccessKey, s.cfg.Auth.AdminSecretKey, middleware.SecurityHeaders())
+		} else {
+			monitoring.Log.Warn("Web console disabled: no admin credentials configured")
+		}
+	}
+
+	// Raft RPCs between metadata nodes, authenticated by the shared token
+	if s.container.Raft != nil {
+		s.router.POST("/raft/:rpc", gin.WrapH(s.container.Raft.Handler(s.cfg.Cluster.Raft.Token)))
+	}
+
+	// S3 operations. S3 SDKs never ask for XML, so in compatibility mode
+	// they get it unless JSON is asked for.
+	s3Routes := s.router.Group("/")
+	if s.cfg.Server.S3Compatible {
+		s3Routes.Use(middleware.PreferXML())
+	}
+	// Form uploads are authorized by their signed policy instead
+	s3Routes.Use(middleware.Authentication(&s.cfg.Auth, s.container.Authenticator, middleware.FormUpload))
+
+	// Tenants get their own bucket namespace. Bucket names are qualified
+	// with the tenant once validated, before authorization and anything
+	// reading the query.
+	tenant := func(c *gin.Context) { c.Next() }
+	if s.cfg.Tenancy.Enabled {
+		tenant = middleware.Tenant(s.cfg.Tenancy)
+	}
 
 	// Service operations
-	s.router.GET("/", bucketHandler.ListBuckets)
+	s3Routes.GET("/", middleware.StartupRecovery(s.container.Recovery), middleware.RequireUnscoped(), tenant, middleware.ConcurrencyLimit(s.container.Admission), bucketHandler.ListBuckets)
 
 	// Bucket operations - with validation
-	bucketRoutes := s.router.Group("/")
+	bucketRoutes := s3Routes.Group("/")
+	bucketRoutes.Use(middleware.StartupRecovery(s.container.Recovery))
 	bucketRoutes.Use(middleware.ValidateBucketName())
+	bucketRoutes.Use(tenant)
+	bucketRoutes.Use(middleware.Authorize())
+	bucketRoutes.Use(middleware.ConcurrencyLimit(s.container.Admission))
+	if s.container.Ring != nil {
+		bucketRoutes.Use(middleware.ReadOnly(s.container.Ring))
+	}
 	{
 		bucketRoutes.PUT("/:bucket", bucketHandler.CreateBucket)
 		bucketRoutes.DELETE("/:bucket", bucketHandler.DeleteBucket)
-		bucketRoutes.GET("/:bucket", objectHandler.ListObjects)
+		bucketRoutes.GET("/:bucket", byQuery("uploads", multipartHandler.ListMultipartUploads,
+			byQuery("prefix-stats", objectHandler.PrefixStats, byQuery("search", objectHandler.SearchObjects, objectHandler.ListObjects))))
 		bucketRoutes.HEAD("/:bucket", bucketHandler.HeadBucket)
+		// Batch deletes, and browser form uploads authorized by their
+		// signed policy
+		bucketRoutes.POST("/:bucket", postBucket)
 	}
 
-	// Object operations - with validation
-	objectRoutes := s.router.Group("/")
+	// Object operations - with validation. Keys are matched by a catch-all
+	// so that keys containing slashes reach the handlers whole.
+	objectRoutes := s3Routes.Group("/")
+	objectRoutes.Use(middleware.StartupRecovery(s.container.Recovery))
+	objectRoutes.Use(middleware.WildcardKey())
 	objectRoutes.Use(middleware.ValidateBucketName())
+	objectRoutes.Use(middleware.NormalizeKey())
 	objectRoutes.Use(middleware.ValidateObjectKey())
 	objectRoutes.Use(middleware.ValidateContentLength())
+	objectRoutes.Use(tenant)
+	objectRoutes.Use(middleware.Authorize())
+	objectRoutes.Use(middleware.ConcurrencyLimit(s.container.Admission))
+	if s.container.Ring != nil {
+		objectRoutes.Use(middleware.ReadOnly(s.container.Ring))
+	}
+	if s.container.Replica != nil {
+		objectRoutes.Use(middleware.ReadReplica(s.container.Replica, s.cfg.ReadReplica.PrimaryURL))
+	}
+	objectRoutes.Use(replicatedWrites)
 	{
-		objectRoutes.PUT("/:bucket/:key", objectHandler.PutObject)
-		objectRoutes.GET("/:bucket/:key", objectHandler.GetObject)
-		objectRoutes.DELETE("/:bucket/:key", objectHandler.DeleteObject)
-		objectRoutes.HEAD("/:bucket/:key", objectHandler.HeadObject)
+		objectRoutes.PUT("/:bucket/*key", orBucket(bucketHandler.CreateBucket, byQuery("uploadId", multipartHandler.UploadPart, objectHandler.PutObject)))
+		objectRoutes.GET("/:bucket/*key", orBucket(byQuery("uploads", multipartHandler.ListMultipartUploads, objectHandler.ListObjects), byQuery("uploadId", multipartHandler.ListParts, objectHandler.GetObject)))
+		objectRoutes.DELETE("/:bucket/*key", orBucket(bucketHandler.DeleteBucket, byQuery("uploadId", multipartHandler.AbortMultipartUpload, byQuery("session", multipartHandler.AbortSession, objectHandler.DeleteObject))))
+		objectRoutes.POST("/:bucket/*key", orBucket(byQuery("delete", objectHandler.DeleteObjects, objectHandler.PostObject), byQuery("resumable", multipartHandler.CreateSession,
+			byQuery("session", multipartHandler.CompleteSession, byQuery("restoreVersion", objectHandler.RestoreObjectVersion,
+				byQuery("move-to", objectHandler.MoveObject,
+					byQuery("uploads", multipartHandler.InitiateMultipartUpload, multipartHandler.CompleteMultipartUpload)))))))
+		objectRoutes.PATCH("/:bucket/*key", orBucket(methodNotAllowed, byQuery("session", multipartHandler.UploadChunk, byQuery("metadata", objectHandler.UpdateObjectMetadata, methodNotAllowed))))
+		objectRoutes.HEAD("/:bucket/*key", orBucket(bucketHandler.HeadBucket, byQuery("session", multipartHandler.GetSession, objectHandler.HeadObject)))
 	}
 
-	// Admin object operations
-	s.router.DELETE("/admin/:bucket/objects", objectHandler.DeleteAllObjects)
+	// S3 dialect for a container registry's S3 storage driver, which is
+	// pointed at /registry as its endpoint. Keys may contain slashes and
+	// every response is S3 XML.
+	if s.cfg.Registry.Enabled {
+		registryRoutes := s.router.Group("/registry")
+		registryRoutes.Use(middleware.S3Dialect())
+		registryRoutes.Use(authenticate)
+		registryRoutes.Use(middleware.StartupRecovery(s.container.Recovery))
+		registryRoutes.Use(middleware.WildcardKey())
+		registryRoutes.Use(middleware.NormalizeKey())
+		registryRoutes.Use(middleware.ValidateBucketName())
+		registryRoutes.Use(middleware.ValidateObjectKey())
+		registryRoutes.Use(middleware.ValidateContentLength())
+		registryRoutes.Use(tenant)
+		registryRoutes.Use(middleware.Authorize())
+		registryRoutes.Use(middleware.ConcurrencyLimit(s.container.Admission))
+		if s.container.Ring != nil {
+			registryRoutes.Use(middleware.ReadOnly(s.container.Ring))
+		}
+		registryRoutes.Use(replicatedWrites)
+		{
+			registryRoutes.HEAD("/:bucket", bucketHandler.HeadBucket)
+			registryRoutes.GET("/:bucket", byQuery("uploads", multipartHandler.ListMultipartUploads, registryHandler.ListObjects))
+			registryRoutes.POST("/:bucket", registryHandler.DeleteObjects)
+			registryRoutes.PUT("/:bucket/*key", byQuery("uploadId", multipartHandler.UploadPart, registryHandler.PutObject))
+			registryRoutes.GET("/:bucket/*key", byQuery("uploadId", multipartHandler.ListParts, objectHandler.GetObject))
+			registryRoutes.HEAD("/:bucket/*key", objectHandler.HeadObject)
+			registryRoutes.DELETE("/:bucket/*key", byQuery("uploadId", multipartHandler.AbortMultipartUpload, registryHandler.DeleteObject))
+			registryRoutes.POST("/:bucket/*key", byQuery("uploads", multipartHandler.InitiateMultipartUpload, multipartHandler.CompleteMultipartUpload))
+		}
+	}
+
+	// Admin and extension endpoints, versioned under /admin/v1. The
+	// unversioned paths are kept for existing clients and replication peers.
+	adminRoutes := []adminRoute{
+		{"GET", "/health", "/health", "admin", "Server, device and KMS health", adminHandler.HealthCheck},
+		{"GET", "/recovery", "/recovery", "admin", "Progress of the recovery run at startup", adminHandler.Recovery},
+		{"GET", "/metrics", "/metrics", "admin", "Storage, device and disk metrics", adminHandler.Metrics},
+		{"GET", "/metrics/history", "/metrics/history", "admin", "Storage usage samples over a window", adminHandler.MetricsHistory},
+		{"GET", "/storage/fragmentation", "/storage/fragmentation", "admin", "Slab fragmentation and the space compaction would reclaim", adminHandler.Fragmentation},
+		{"GET", "/runtime", "/runtime", "admin", "Go runtime settings, buffer pool sizes and memory figures", runtimeHandler.GetRuntime},
+		{"PUT", "/runtime", "/runtime", "admin", "Change GOMAXPROCS, the GC percent or buffer pool sizes until restart", runtimeHandler.SetRuntime},
+		{"GET", "/metrics/prometheus", "/metrics/prometheus", "admin", "Request and replication metrics in the Prometheus text format", gin.WrapH(promhttp.Handler())},
+		{"GET", "/usage/keys", "", "admin", "Requests, errors and traffic per access key by hour, as JSON or CSV", usageHandler.KeyUsage},
+		{"GET", "/reports", "", "admin", "List the chargeback reports written to the report bucket", reportHandler.ListReports},
+		{"GET", "/reports/:month", "", "admin", "Storage and traffic per bucket and owner over a month, as JSON or CSV", reportHandler.GetReport},
+		{"POST", "/reports/:month", "", "admin", "Write the chargeback report of a month to the report bucket", reportHandler.PublishReport},
+		{"POST", "/apply", "", "admin", "Reconcile buckets and users with a declarative spec", applyHandler.Apply},
+		{"POST", "/buckets/:bucket/clone", "/:bucket/clone", "buckets", "Clone a bucket into a new one sharing its stored data", objectHandler.CloneBucket},
+		{"GET", "/buckets/:bucket/objects/progress", "/:bucket/objects/progress", "buckets", "Progress of deleting all objects", objectHandler.PurgeProgress},
+		{"GET", "/search", "", "buckets", "Search object keys and user metadata across buckets", objectHandler.SearchAllObjects},
+		{"GET", "/attestation/key", "", "admin", "Public key object attestations are signed with", objectHandler.AttestationKey},
+		{"POST", "/buckets/:bucket/scrub/*key", "", "buckets", "Check an object against its chunk checksums and repair corrupt chunks from the replica", objectHandler.ScrubObject},
+		{"POST", "/buckets/:bucket/exports", "", "buckets", "Export a bucket or prefix to a tar archive with a signed manifest", exportHandler.CreateExport},
+		{"GET", "/exports", "", "buckets", "List archive exports", exportHandler.ListExports},
+		{"GET", "/exports/:id", "", "buckets", "Get the progress of an archive export", exportHandler.GetExport},
+		{"POST", "/exports/:id/resume", "", "buckets", "Resume an interrupted archive export", exportHandler.ResumeExport},
+		{"GET", "/exports/:id/files/:file", "", "buckets", "Download the archive, manifest or signature of an export", exportHandler.DownloadExport},
+		{"POST", "/buckets/:bucket/nfs-exports", "", "buckets", "Export a snapshot of a bucket over NFS", nfsHandler.CreateExport},
+		{"GET", "/nfs-exports", "", "buckets", "List NFS exports", nfsHandler.ListExports},
+		{"GET", "/nfs-exports/:id", "", "buckets", "Get an NFS export", nfsHandler.GetExport},
+		{"DELETE", "/nfs-exports/:id", "", "buckets", "Remove an NFS export and release its snapshot", nfsHandler.DeleteExport},
+		{"POST", "/buckets/:bucket/reservations", "", "buckets", "Reserve space in a bucket for a batch of writes", reservationHandler.CreateReservation},
+		{"GET", "/reservations", "", "buckets", "List space reservations", reservationHandler.ListReservations},
+		{"GET", "/reservations/:token", "", "buckets", "Get a space reservation", reservationHandler.GetReservation},
+		{"DELETE", "/reservations/:token", "", "buckets", "Release what is left of a space reservation", reservationHandler.ReleaseReservation},
+		{"GET", "/replication", "/replication", "replication", "Replication status", replicationHandler.GetStatus},
+		{"GET", "/buckets/:bucket/replication", "/:bucket/replication", "replication", "Whether a bucket is replicated", replicationHandler.GetBucketReplication},
+		{"PUT", "/buckets/:bucket/replication", "/:bucket/replication", "replication", "Enable or disable replication of a bucket", replicationHandler.SetBucketReplication},
+		{"POST", "/buckets/:bucket/resync", "", "replication", "Queue every object of a bucket for replication again", replicationHandler.ResyncBucket},
+		{"POST", "/replication/snapshots", "", "replication", "Snapshot the buckets for a new replica to copy", bootstrapHandler.CreateSnapshot},
+		{"GET", "/replication/snapshots/:id", "", "replication", "Bootstrap snapshot details", bootstrapHandler.GetSnapshot},
+		{"DELETE", "/replication/snapshots/:id", "", "replication", "Release a bootstrap snapshot", bootstrapHandler.ReleaseSnapshot},
+		{"GET", "/replication/snapshots/:id/buckets/:bucket/objects", "", "replication", "List the objects of a bucket in a bootstrap snapshot", bootstrapHandler.ListSnapshotObjects},
+		{"GET", "/replication/snapshots/:id/buckets/:bucket/data/*key", "", "replication", "Read an object of a bootstrap snapshot", bootstrapHandler.GetSnapshotObject},
+		{"POST", "/replication/bootstrap", "", "replication", "Bootstrap this node from a source", bootstrapHandler.StartBootstrap},
+		{"GET", "/replication/bootstrap", "", "replication", "Bootstrap progress", bootstrapHandler.GetBootstrap},
+		{"GET", "/raft", "/raft", "cluster", "Raft group status", raftHandler.GetStatus},
+		{"GET", "/cluster/nodes", "/cluster/nodes", "cluster", "Nodes of the ring", clusterHandler.ListNodes},
+		{"POST", "/cluster/nodes/:id/decommission", "/cluster/nodes/:id/decommission", "cluster", "Drain a node and remove it from the ring", clusterHandler.Decommission},
+		{"POST", "/cluster/rebalance", "/cluster/rebalance", "cluster", "Move objects onto added nodes", clusterHandler.Rebalance},
+		{"GET", "/encryption", "/encryption", "encryption", "Master key versions and their usage", encryptionHandler.GetStatus},
+		{"POST", "/encryption/rewrap", "/encryption/rewrap", "encryption", "Re-wrap data keys with the active master key", encryptionHandler.Rewrap},
+		{"GET", "/jobs", "/jobs", "jobs", "List background jobs", jobHandler.ListJobs},
+		{"GET", "/jobs/:id", "/jobs/:id", "jobs", "Get a background job", jobHandler.GetJob},
+		{"DELETE", "/jobs/:id", "/jobs/:id", "jobs", "Cancel a background job", jobHandler.CancelJob},
+		{"GET", "/schedules", "/schedules", "jobs", "List cron schedules", scheduleHandler.ListSchedules},
+		{"POST", "/schedules/:name/run", "/schedules/:name/run", "jobs", "Run a schedule now", scheduleHandler.RunSchedule},
+		{"POST", "/tasks/:task/run", "", "jobs", "Run a task such as scrub, compaction, lifecycle or inventory now", scheduleHandler.RunTask},
+	}
+	// Replication writes through these, as the admins do until this node
+	// has peers
+	replicationRoutes := []adminRoute{
+		{"DELETE", "/buckets/:bucket/objects", "/:bucket/objects", "buckets", "Delete all objects of a bucket", deleteAllObjects},
+		{"POST", "/replication/patch", "/replication/patch", "replication", "Apply an overwrite sent as a delta", applyPatch},
+	}
+	// Users manage the service accounts derived from their own keys
+	selfServiceRoutes := []adminRoute{
+		{"GET", "/service-accounts", "/service-accounts", "auth", "List service accounts", serviceAccountHandler.ListServiceAccounts},
+		{"POST", "/service-accounts", "/service-accounts", "auth", "Create a service account", serviceAccountHandler.CreateServiceAccount},
+		{"DELETE", "/service-accounts/:access_key", "/service-accounts/:access_key", "auth", "Delete a service account", serviceAccountHandler.DeleteServiceAccount},
+	}
+
+	if s.cfg.GraphQL.Enabled {
+		graphqlHandler := handlers.NewGraphQLHandler(s.container.BucketService, s.container.ObjectService, s.container.Engine, s.container.Replicator, s.container.Jobs)
+		adminRoutes = append(adminRoutes,
+			adminRoute{"POST", "/graphql", "", "admin", "Query buckets, objects, usage, replication and jobs with GraphQL", graphqlHandler.Query},
+			adminRoute{"GET", "/graphql/schema", "", "admin", "The GraphQL schema in SDL", graphqlHandler.Schema},
+		)
+	}
 
-	// Admin endpoints
 	admin := s.router.Group("/admin")
-	{
-		admin.GET("/health", adminHandler.HealthCheck)
-		admin.GET("/metrics", adminHandler.Metrics)
+	registerAdminRoutes(admin.Group("", authenticate, middleware.RequireAdmin()), adminRoutes)
+	registerAdminRoutes(admin.Group("", authenticate, replicationAdmin), replicationRoutes)
+	registerAdminRoutes(admin.Group("", authenticate, middleware.RequireUnscoped()), selfServiceRoutes)
+	// Go runtime profiles, only served behind the admin credentials
+	if s.cfg.Debug.Pprof {
+		if s.cfg.Auth.AdminAccessKey != "" {
+			profiling.Register(admin, s.cfg.Auth.AdminAccessKey, s.cfg.Auth.AdminSecretKey)
+		} else {
+			monitoring.Log.Warn("Profiling disabled: no admin credentials configured")
+		}
+	}
+	admin.GET("/openapi.json", serveOpenAPI(buildOpenAPI("/admin/"+AdminAPIVersion, slices.Concat(adminRoutes, replicationRoutes, selfServiceRoutes))))
+}
+
+// orBucket dispatches requests for the bucket itself, such as GET /photos/,
+// which the catch-all key routes also match, to bucketLevel, and requests
+// naming a key to h
+func orBucket(bucketLevel, h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("key") == "" {
+			bucketLevel(c)
+			return
+		}
+		h(c)
+	}
+}
+
+// methodNotAllowed answers methods a path does not support
+func methodNotAllowed(c *gin.Context) {
+	middleware.Error(c, http.StatusMethodNotAllowed, s3.MethodNotAllowed, "method not supported on this resource")
+}
+
+// byQuery dispatches to h when the request carries the query parameter,
+// and to next otherwise. S3 distinguishes multipart operations from plain
+// object operations on the same path this way.
+func byQuery(param string, h, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.GetQuery(param); ok {
+			h(c)
+			return
+		}
+		next(c)
 	}
 }
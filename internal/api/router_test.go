@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// escapePath escapes each segment of a key, as S3 clients do
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func TestObjectRoutes_NestedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve("PUT", "/logs/", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT /logs/ = %d, want the bucket created: %s", w.Code, w.Body)
+	}
+
+	keys := []string{
+		"2024/01/02/app.log",
+		"a/b/c/d/e/f/g/h/deep.txt",
+		"reports/q1 summary.txt",
+		"fotos/über/日本語.txt",
+		"mixed/100% done+final.txt",
+	}
+	for _, key := range keys {
+		target := "/logs/" + escapePath(key)
+		if w := serve("PUT", target, "content of "+key); w.Code != http.StatusOK {
+			t.Errorf("PUT %s = %d: %s", target, w.Code, w.Body)
+			continue
+		}
+		w := serve("GET", target, "")
+		if w.Code != http.StatusOK || w.Body.String() != "content of "+key {
+			t.Errorf("GET %s = %d %q", target, w.Code, w.Body)
+		}
+		if w := serve("HEAD", target, ""); w.Code != http.StatusOK {
+			t.Errorf("HEAD %s = %d", target, w.Code)
+		}
+	}
+
+	// An escaped slash names the same key as a plain one
+	if w := serve("GET", "/logs/2024%2F01%2F02%2Fapp.log", ""); w.Code != http.StatusOK {
+		t.Errorf("GET with escaped slashes = %d, want 200", w.Code)
+	}
+
+	// The bucket itself is still reachable with a trailing slash
+	w := serve("GET", "/logs/?prefix=2024/", "")
+	var listing object.ListResult
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil || len(listing.Objects) != 1 {
+		t.Errorf("GET /logs/?prefix=2024/ = %d %s", w.Code, w.Body)
+	}
+	// POST on the bucket is a form upload, which needs a form
+	if w := serve("POST", "/logs/", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /logs/ = %d, want 400", w.Code)
+	}
+
+	for _, key := range keys {
+		if w := serve("DELETE", "/logs/"+escapePath(key), ""); w.Code != http.StatusNoContent {
+			t.Errorf("DELETE %s = %d", key, w.Code)
+		}
+	}
+	if w := serve("GET", "/logs/"+escapePath(keys[0]), ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE = %d, want 404", w.Code)
+	}
+}
+
+func TestObjectRoutes_DirectoryMarkers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+	list := func(query string) object.ListResult {
+		t.Helper()
+		var listing object.ListResult
+		w := serve("GET", "/photos?"+query, "")
+		if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+			t.Fatalf("GET /photos?%s = %d %s", query, w.Code, w.Body)
+		}
+		return listing
+	}
+
+	if w := serve("PUT", "/photos", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT /photos = %d: %s", w.Code, w.Body)
+	}
+	for _, key := range []string{"2024/", "2024/summer/", "empty.txt"} {
+		if w := serve("PUT", "/photos/"+key, ""); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d: %s", key, w.Code, w.Body)
+		}
+	}
+	if w := serve("PUT", "/photos/2024/beach.jpg", "jpeg"); w.Code != http.StatusOK {
+		t.Fatalf("PUT 2024/beach.jpg = %d: %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/photos/2025/", "data"); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT marker with data = %d, want 400", w.Code)
+	}
+
+	// Zero-byte objects read back empty
+	for _, key := range []string{"2024/", "empty.txt"} {
+		if w := serve("GET", "/photos/"+key, ""); w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("GET %s = %d %q, want 200 and no data", key, w.Code, w.Body)
+		}
+	}
+
+	// A marker is a common prefix from above and an object from inside
+	root := list("delimiter=/")
+	if len(root.Objects) != 1 || root.Objects[0].Key != "empty.txt" ||
+		len(root.CommonPrefixes) != 1 || root.CommonPrefixes[0] != "2024/" {
+		t.Errorf("root listing = %+v", root)
+	}
+	dir := list("prefix=2024/&delimiter=/")
+	if len(dir.Objects) != 2 || dir.Objects[0].Key != "2024/" || dir.Objects[1].Key != "2024/beach.jpg" ||
+		len(dir.CommonPrefixes) != 1 || dir.CommonPrefixes[0] != "2024/summer/" {
+		t.Errorf("2024/ listing = %+v", dir)
+	}
+
+	if w := serve("DELETE", "/photos/2024/summer/", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE marker = %d", w.Code)
+	}
+	if dir := list("prefix=2024/&delimiter=/"); len(dir.CommonPrefixes) != 0 {
+		t.Errorf("2024/ listing after DELETE = %+v", dir)
+	}
+}
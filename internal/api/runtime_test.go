@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/bufpool"
+	"github.com/danielino/comio/internal/config"
+)
+
+func TestRuntime(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	gc := debug.SetGCPercent(100)
+	copySize := bufpool.Sizes()["object_copy"]
+	t.Cleanup(func() {
+		runtime.GOMAXPROCS(procs)
+		debug.SetGCPercent(gc)
+		bufpool.Resize("object_copy", copySize)
+	})
+
+	cfg := &config.Config{}
+	server := NewServer(cfg, createTestContainer(cfg))
+	server.SetupRoutes()
+
+	do := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, "/admin/v1/runtime", strings.NewReader(body)))
+		return w
+	}
+
+	var got struct {
+		GOMAXPROCS  int            `json:"gomaxprocs"`
+		GCPercent   int            `json:"gc_percent"`
+		BufferSizes map[string]int `json:"buffer_sizes"`
+	}
+	w := do("GET", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.GOMAXPROCS != procs || got.GCPercent != 100 {
+		t.Fatalf("GET /runtime = %d %s", w.Code, w.Body.String())
+	}
+	if _, ok := got.BufferSizes["object_copy"]; !ok {
+		t.Errorf("buffer sizes = %v, want the object copy pool", got.BufferSizes)
+	}
+
+	// Nothing is applied when any setting is invalid
+	for _, body := range []string{
+		`{"gomaxprocs": 0}`,
+		`{"gc_percent": -2}`,
+		`{"gc_percent": 50, "buffer_sizes": {"nosuchpool": 4096}}`,
+		`{"gc_percent": 50, "buffer_sizes": {"object_copy": 1}}`,
+	} {
+		if w := do("PUT", body); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s = %d, want 400", body, w.Code)
+		}
+	}
+	if percent := debug.SetGCPercent(100); percent != 100 {
+		t.Errorf("GC percent = %d after invalid PUTs, want unchanged", percent)
+	}
+
+	w = do("PUT", `{"gomaxprocs": 1, "gc_percent": 200, "buffer_sizes": {"object_copy": 65536}}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PUT /runtime = %d %s", w.Code, w.Body.String())
+	}
+	if got.GOMAXPROCS != 1 || got.GCPercent != 200 || got.BufferSizes["object_copy"] != 65536 {
+		t.Errorf("PUT /runtime = %+v", got)
+	}
+	if runtime.GOMAXPROCS(0) != 1 {
+		t.Errorf("GOMAXPROCS = %d, want 1", runtime.GOMAXPROCS(0))
+	}
+}
+
+func TestProfilingRoutes(t *testing.T) {
+	get := func(cfg *config.Config) int {
+		server := NewServer(cfg, createTestContainer(cfg))
+		server.SetupRoutes()
+		req := httptest.NewRequest("GET", "/admin/debug/pprof/heap", nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(&config.Config{}); code != http.StatusNotFound {
+		t.Errorf("profiles when disabled = %d, want 404", code)
+	}
+
+	cfg := &config.Config{Debug: config.DebugConfig{Pprof: true}}
+	if code := get(cfg); code != http.StatusNotFound {
+		t.Errorf("profiles without admin credentials = %d, want 404", code)
+	}
+
+	cfg.Auth.AdminAccessKey, cfg.Auth.AdminSecretKey = "admin", "secret"
+	if code := get(cfg); code != http.StatusOK {
+		t.Errorf("profiles = %d, want 200", code)
+	}
+}
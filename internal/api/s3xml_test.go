@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/api/handlers"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestS3XMLListings(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{Server: config.ServerConfig{S3Compatible: true}}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	serve("PUT", "/photos", "", "")
+	serve("PUT", "/photos/2024/a.jpg", "", "a")
+	serve("PUT", "/photos/2024/b.jpg", "", "bb")
+	serve("PUT", "/photos/index.html", "", "ccc")
+
+	// SDKs send no Accept header and get S3 documents
+	w := serve("GET", "/", "", "")
+	var buckets handlers.ListAllMyBucketsResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &buckets); err != nil {
+		t.Fatalf("GET / = %s: %v", w.Body, err)
+	}
+	if len(buckets.Buckets) != 1 || buckets.Buckets[0].Name != "photos" || buckets.Buckets[0].CreationDate.IsZero() {
+		t.Errorf("buckets = %+v, want photos", buckets.Buckets)
+	}
+
+	w = serve("GET", "/photos?list-type=2&delimiter=/", "", "")
+	var list handlers.ListBucketResult
+	if err := xml.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("GET /photos = %s: %v", w.Body, err)
+	}
+	if list.Name != "photos" || len(list.Contents) != 1 || list.Contents[0].Key != "index.html" ||
+		len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0].Prefix != "2024/" || list.KeyCount == nil || *list.KeyCount != 2 {
+		t.Errorf("listing = %+v, want index.html and the 2024/ prefix", list)
+	}
+
+	// Errors are S3 documents too
+	w = serve("GET", "/photos/missing.jpg", "", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "<Error>") {
+		t.Errorf("missing object = %d %s, want an S3 error document", w.Code, w.Body)
+	}
+
+	// Clients asking for JSON still get it
+	w = serve("GET", "/photos", "application/json", "")
+	var result object.ListResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Objects) != 3 {
+		t.Errorf("JSON listing = %s, %v", w.Body, err)
+	}
+}
+
+func TestS3XMLListings_Accept(t *testing.T) {
+	cfg := &config.Config{}
+	server := NewServer(cfg, createTestContainer(cfg))
+	server.SetupRoutes()
+
+	serve := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+	if w := serve(""); !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("default Content-Type = %q, want JSON outside compatibility mode", w.Header().Get("Content-Type"))
+	}
+	if w := serve("application/xml"); !strings.Contains(w.Body.String(), "<ListAllMyBucketsResult") {
+		t.Errorf("GET / asking for XML = %s", w.Body)
+	}
+}
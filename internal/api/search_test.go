@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func TestSearchObjects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	search := object.NewSearchRepository(container.ObjectRepo, object.NewMemorySearchIndex())
+	container.ObjectRepo = search
+	container.ObjectService = object.NewService(search, engine)
+	container.ObjectService.SetSearch(search)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target string, header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader("data"))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, bucket := range []string{"/mail", "/scans"} {
+		if w := serve("PUT", bucket, nil); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s = %d: %s", bucket, w.Code, w.Body)
+		}
+	}
+	serve("PUT", "/mail/inbox/1.eml", map[string]string{"x-amz-meta-subject": "Quarterly invoice"})
+	serve("PUT", "/mail/inbox/2.eml", map[string]string{"x-amz-meta-subject": "Lunch"})
+	serve("PUT", "/scans/invoice-0042.pdf", nil)
+
+	type page struct {
+		Objects []struct {
+			BucketName string `json:"bucket_name"`
+			Key        string `json:"key"`
+		} `json:"objects"`
+		IsTruncated bool   `json:"is_truncated"`
+		Next        string `json:"next_continuation_token"`
+	}
+	get := func(target string) page {
+		t.Helper()
+		w := serve("GET", target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", target, w.Code, w.Body)
+		}
+		var p page
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	p := get("/mail?search=invoice")
+	if len(p.Objects) != 1 || p.Objects[0].Key != "inbox/1.eml" {
+		t.Errorf("bucket search = %+v, want inbox/1.eml", p)
+	}
+
+	// The admin search spans every bucket, a page at a time
+	p = get("/admin/v1/search?q=invoice&max-keys=1")
+	if len(p.Objects) != 1 || p.Objects[0].BucketName != "mail" || !p.IsTruncated || p.Next == "" {
+		t.Fatalf("first page = %+v", p)
+	}
+	p = get("/admin/v1/search?q=invoice&max-keys=1&continuation-token=" + p.Next)
+	if len(p.Objects) != 1 || p.Objects[0].Key != "invoice-0042.pdf" || p.IsTruncated {
+		t.Errorf("second page = %+v", p)
+	}
+
+	if w := serve("GET", "/mail?search=--", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("search with no words = %d, want 400", w.Code)
+	}
+}
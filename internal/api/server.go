@@ -11,6 +11,7 @@ import (
 
 	"github.com/danielino/comio/internal/config"
 	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/nfs"
 )
 
 // Server represents the HTTP server
@@ -19,17 +20,25 @@ type Server struct {
 	srv       *http.Server
 	cfg       *config.Config
 	container *ServiceContainer
+	gateway   *Gateway
 }
 
 // NewServer creates a new HTTP server with injected dependencies
 func NewServer(cfg *config.Config, container *ServiceContainer) *Server {
 	router := gin.New()
 
-	return &Server{
+	server := &Server{
 		router:    router,
 		cfg:       cfg,
 		container: container,
 	}
+
+	// Optional anonymous read-only listener for public buckets
+	if cfg.Gateway.Enabled {
+		server.gateway = NewGateway(cfg.Gateway, container.ObjectService)
+	}
+
+	return server
 }
 
 // Start starts the server
@@ -39,6 +48,25 @@ func (s *Server) Start() error {
 		Handler:      s.router,
 		ReadTimeout:  parseDuration(s.cfg.Server.ReadTimeout),
 		WriteTimeout: parseDuration(s.cfg.Server.WriteTimeout),
+		// Oversized headers are refused before the router reads them
+		MaxHeaderBytes: s.cfg.Server.MaxHeaderBytes,
+	}
+
+	if s.gateway != nil {
+		go func() {
+			if err := s.gateway.Start(); err != nil && err != http.ErrServerClosed {
+				monitoring.Log.Error("Public gateway failed", zap.Error(err))
+			}
+		}()
+	}
+
+	if s.container.NFS != nil {
+		addr := fmt.Sprintf("%s:%d", s.cfg.NFS.Host, s.cfg.NFS.Port)
+		go func() {
+			if err := s.container.NFS.ListenAndServe(addr); err != nil && err != nfs.ErrServerClosed {
+				monitoring.Log.Error("NFS server failed", zap.Error(err))
+			}
+		}()
 	}
 
 	monitoring.Log.Info("Starting server", zap.String("addr", s.srv.Addr))
@@ -58,6 +86,12 @@ func (s *Server) Stop(ctx context.Context) error {
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
 
+	if s.gateway != nil {
+		if err := s.gateway.Stop(ctx); err != nil {
+			return fmt.Errorf("gateway shutdown failed: %w", err)
+		}
+	}
+
 	// Then, clean up resources
 	if err := s.container.Close(); err != nil {
 		return fmt.Errorf("container cleanup failed: %w", err)
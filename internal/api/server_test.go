@@ -19,15 +19,15 @@ func init() {
 // mockEngine is a minimal mock implementation of storage.Engine for testing
 type mockEngine struct{}
 
-func (m *mockEngine) Open(devicePath string) error                  { return nil }
-func (m *mockEngine) Close() error                                  { return nil }
-func (m *mockEngine) Read(offset, size int64) ([]byte, error)       { return nil, nil }
-func (m *mockEngine) Write(offset int64, data []byte) error         { return nil }
-func (m *mockEngine) Allocate(size int64) (offset int64, err error) { return 0, nil }
-func (m *mockEngine) Free(offset, size int64) error                 { return nil }
-func (m *mockEngine) Sync() error                                   { return nil }
-func (m *mockEngine) Stats() storage.Stats                          { return storage.Stats{} }
-func (m *mockEngine) BlockSize() int                                { return 4096 }
+func (m *mockEngine) Open(devicePath string) error                                 { return nil }
+func (m *mockEngine) Close() error                                                 { return nil }
+func (m *mockEngine) Read(ctx context.Context, offset, size int64) ([]byte, error) { return nil, nil }
+func (m *mockEngine) Write(ctx context.Context, offset int64, data []byte) error   { return nil }
+func (m *mockEngine) Allocate(size int64) (offset int64, err error)                { return 0, nil }
+func (m *mockEngine) Free(offset, size int64) error                                { return nil }
+func (m *mockEngine) Sync() error                                                  { return nil }
+func (m *mockEngine) Stats() storage.Stats                                         { return storage.Stats{} }
+func (m *mockEngine) BlockSize() int                                               { return 4096 }
 
 // createTestContainer creates a minimal service container for testing
 func createTestContainer(cfg *config.Config) *ServiceContainer {
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestTenantNamespaces(t *testing.T) {
+	cfg := &config.Config{Tenancy: config.TenancyConfig{Enabled: true, Header: "X-Comio-Tenant", Domain: "s3.example.com"}}
+	engine := openTestEngine(t)
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, host, tenant, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if host != "" {
+			req.Host = host
+		}
+		if tenant != "" {
+			req.Header.Set("X-Comio-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Both tenants and the default namespace create a bucket of the same name
+	for _, w := range []*httptest.ResponseRecorder{
+		serve("PUT", "/photos", "", "team-a", ""),
+		serve("PUT", "/photos", "team-b.s3.example.com:8080", "", ""),
+		serve("PUT", "/photos", "", "", ""),
+	} {
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT /photos = %d %s", w.Code, w.Body)
+		}
+	}
+	serve("PUT", "/photos/cat.jpg", "team-a.s3.example.com", "", "meow")
+
+	if w := serve("GET", "/photos/cat.jpg", "", "team-a", ""); w.Code != http.StatusOK || w.Body.String() != "meow" {
+		t.Errorf("GET in team-a = %d %s", w.Code, w.Body)
+	}
+	for _, tenant := range []string{"team-b", ""} {
+		if w := serve("GET", "/photos/cat.jpg", "", tenant, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET in tenant %q = %d, want 404", tenant, w.Code)
+		}
+	}
+
+	// Listings only show the tenant's buckets, by their own names
+	w := serve("GET", "/", "", "team-a", "")
+	var buckets []bucket.Bucket
+	if err := json.Unmarshal(w.Body.Bytes(), &buckets); err != nil || len(buckets) != 1 || buckets[0].Name != "photos" {
+		t.Errorf("GET / in team-a = %s, want only photos", w.Body)
+	}
+
+	// Moves stay within the tenant
+	if w := serve("POST", "/photos/cat.jpg?move-to=photos/kitten.jpg", "", "team-a", ""); w.Code != http.StatusOK {
+		t.Fatalf("move in team-a = %d %s", w.Code, w.Body)
+	}
+	if w := serve("GET", "/photos/kitten.jpg", "", "team-a", ""); w.Code != http.StatusOK {
+		t.Errorf("moved object in team-a = %d, want 200", w.Code)
+	}
+	if w := serve("GET", "/photos/kitten.jpg", "", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("moved object in the default namespace = %d, want 404", w.Code)
+	}
+
+	// Clients cannot name tenant buckets directly
+	if w := serve("GET", "/team-a:photos/kitten.jpg", "", "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("qualified bucket name in the path = %d, want 400", w.Code)
+	}
+	if w := serve("GET", "/photos/kitten.jpg", "", "Team_A", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid tenant = %d, want 400", w.Code)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	cfg := &config.Config{
+		Auth:    config.AuthConfig{Enabled: true},
+		Tenancy: config.TenancyConfig{Enabled: true, Header: "X-Comio-Tenant"},
+	}
+	engine := openTestEngine(t)
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.BucketService.SetObjectCounter(container.ObjectRepo)
+	container.BucketService.SetTenantQuotas(map[string]bucket.Quota{"team-a": {MaxSize: 10}})
+	container.Authenticator = auth.NewHMACAuthenticator()
+	container.Authenticator.AddUser(auth.NewAdminUser("admin", "admin-secret"))
+	container.Authenticator.AddUser(&auth.User{AccessKeyID: "bob", SecretAccessKey: "bob-secret"})
+	container.Authenticator.AddUser(&auth.User{AccessKeyID: "carol", SecretAccessKey: "carol-secret", Tenant: "team-a"})
+	container.Authenticator.AddUser(&auth.User{AccessKeyID: "dave", SecretAccessKey: "dave-secret", Tenant: "team-b", Policies: []string{auth.PolicyAdmin}})
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, accessKey, secretKey, tenant, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		signRequest(req, accessKey, secretKey)
+		if tenant != "" {
+			req.Header.Set("X-Comio-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Admins pick any tenant, users of a tenant get theirs
+	if w := serve("PUT", "/logs", "admin", "admin-secret", "team-a", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT /logs as admin in team-a = %d %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/logs/a.txt", "carol", "carol-secret", "", "12345"); w.Code != http.StatusOK {
+		t.Fatalf("PUT as carol = %d %s", w.Code, w.Body)
+	}
+	if w := serve("GET", "/logs/a.txt", "admin", "admin-secret", "team-a", ""); w.Code != http.StatusOK || w.Body.String() != "12345" {
+		t.Errorf("GET as admin in team-a = %d %s", w.Code, w.Body)
+	}
+
+	// Users of no tenant cannot name one, nor users of another
+	if w := serve("GET", "/logs/a.txt", "bob", "bob-secret", "team-a", ""); w.Code != http.StatusForbidden {
+		t.Errorf("GET as bob naming team-a = %d, want 403", w.Code)
+	}
+	if w := serve("GET", "/logs/a.txt", "carol", "carol-secret", "team-b", ""); w.Code != http.StatusForbidden {
+		t.Errorf("GET as carol naming team-b = %d, want 403", w.Code)
+	}
+
+	// Object records name the bucket as the tenant knows it
+	for _, target := range []string{"/logs", "/logs?format=ndjson"} {
+		w := serve("GET", target, "carol", "carol-secret", "", "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"bucket_name":"logs"`) || strings.Contains(w.Body.String(), "team-a:") {
+			t.Errorf("GET %s as carol = %d %s, want bucket_name logs", target, w.Code, w.Body)
+		}
+	}
+
+	// The admin API names qualified buckets, so even admins of a tenant
+	// cannot use it
+	for _, target := range []string{"/admin/v1/buckets/team-a:logs/objects", "/admin/v1/buckets/team-b:logs/objects"} {
+		if w := serve("DELETE", target, "dave", "dave-secret", "", ""); w.Code != http.StatusForbidden {
+			t.Errorf("DELETE %s as dave = %d, want 403", target, w.Code)
+		}
+	}
+	if w := serve("GET", "/logs/a.txt", "admin", "admin-secret", "team-a", ""); w.Code != http.StatusOK {
+		t.Errorf("GET after refused purges = %d, want 200", w.Code)
+	}
+
+	// The tenant quota covers all its buckets
+	if w := serve("PUT", "/archive", "carol", "carol-secret", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT /archive as carol = %d %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/archive/b.txt", "carol", "carol-secret", "", "123456"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "QuotaExceeded") {
+		t.Errorf("PUT past the tenant quota = %d %s, want 403 QuotaExceeded", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/archive/b.txt", "carol", "carol-secret", "", "12345"); w.Code != http.StatusOK {
+		t.Errorf("PUT within the tenant quota = %d %s", w.Code, w.Body)
+	}
+}
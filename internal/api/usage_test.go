@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+	"github.com/danielino/comio/internal/usage"
+)
+
+func TestKeyUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	defer engine.Close()
+
+	cfg := &config.Config{}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	container.Usage, err = usage.NewTracker(t.TempDir(), 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+	serve("PUT", "/logs", "")
+	serve("PUT", "/logs/app.log", "0123456789")
+	serve("GET", "/logs/app.log", "")
+	serve("GET", "/logs/missing.log", "")
+
+	w := serve("GET", "/admin/v1/usage/keys?window=2h", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/v1/usage/keys = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Keys []struct {
+			AccessKey string  `json:"access_key"`
+			Requests  int64   `json:"requests"`
+			BytesIn   int64   `json:"bytes_in"`
+			BytesOut  int64   `json:"bytes_out"`
+			ErrorRate float64 `json:"error_rate"`
+			Buckets   map[string]struct {
+				Requests     int64 `json:"requests"`
+				ClientErrors int64 `json:"client_errors"`
+			} `json:"buckets"`
+			Hours []struct {
+				Hour     time.Time `json:"hour"`
+				Requests int64     `json:"requests"`
+			} `json:"hours"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// Authentication is disabled, so every request is anonymous
+	if len(resp.Keys) != 1 {
+		t.Fatalf("keys = %+v, want one", resp.Keys)
+	}
+	k := resp.Keys[0]
+	if k.AccessKey != "anonymous" || k.Requests != 4 || k.BytesIn != 10 || k.BytesOut < 10 || k.ErrorRate != 0.25 {
+		t.Errorf("usage = %+v, want 4 requests, 10 bytes in and one error", k)
+	}
+	if b := k.Buckets["logs"]; b.Requests != 4 || b.ClientErrors != 1 {
+		t.Errorf("logs bucket = %+v", b)
+	}
+	if len(k.Hours) != 1 || k.Hours[0].Requests != 4 {
+		t.Errorf("hours = %+v, want the 4 requests in one hour", k.Hours)
+	}
+
+	w = serve("GET", "/admin/v1/usage/keys?format=csv&key=anonymous", "")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header, then the first query's hour, which also counts that query
+	if len(rows) != 2 || rows[0][1] != "access_key" || rows[1][1] != "anonymous" || rows[1][2] != "5" || rows[1][5] != "0.2000" {
+		t.Errorf("CSV = %q", rows)
+	}
+
+	if w := serve("GET", "/admin/v1/usage/keys?window=soon", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid window = %d, want 400", w.Code)
+	}
+}
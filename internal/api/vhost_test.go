@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/object"
+)
+
+func TestVirtualHostedBuckets(t *testing.T) {
+	engine := openTestEngine(t)
+
+	cfg := &config.Config{
+		Server:  config.ServerConfig{Domain: "s3.example.com"},
+		Tenancy: config.TenancyConfig{Enabled: true, Header: "X-Comio-Tenant", Domain: "s3.example.com"},
+	}
+	container := createTestContainer(cfg)
+	container.Engine = engine
+	container.ObjectService = object.NewService(container.ObjectRepo, engine)
+	server := NewServer(cfg, container)
+	server.SetupRoutes()
+
+	serve := func(method, host, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Host = host
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("PUT", "pets.team-a.s3.example.com", "/", ""); w.Code != http.StatusOK {
+		t.Fatalf("PUT bucket = %d %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "pets.team-a.s3.example.com:8080", "/cats/tom.jpg", "meow"); w.Code != http.StatusOK {
+		t.Fatalf("PUT object = %d %s", w.Code, w.Body)
+	}
+	serve("PUT", "pets.team-a.s3.example.com", "/dogs/", "")
+
+	// The same objects in path style, and under the tenant's host
+	for _, host := range []string{"pets.team-a.s3.example.com", "team-a.s3.example.com"} {
+		target := "/cats/tom.jpg"
+		if !strings.HasPrefix(host, "pets.") {
+			target = "/pets" + target
+		}
+		if w := serve("GET", host, target, ""); w.Code != http.StatusOK || w.Body.String() != "meow" {
+			t.Errorf("GET %s%s = %d %s", host, target, w.Code, w.Body)
+		}
+	}
+
+	// The root of a bucket host lists the bucket, keeping directory markers
+	w := serve("GET", "pets.team-a.s3.example.com", "/", "")
+	var result object.ListResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Objects) != 2 || result.Objects[1].Key != "dogs/" {
+		t.Errorf("GET / on the bucket host = %s, %v", w.Body, err)
+	}
+
+	// Other hosts are routed in path style
+	if w := serve("GET", "pets.example.org", "/cats/tom.jpg", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET on another host = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,332 @@
+package apply
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+)
+
+// ErrPrivilegeEscalation is returned for specs granting users more than
+// the caller holds, or reaching outside the caller's tenant
+var ErrPrivilegeEscalation = errors.New("spec exceeds the caller's privileges")
+
+// Action is what applying a change does to a resource
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Kinds of resources a spec manages
+const (
+	KindBucket = "bucket"
+	KindUser   = "user"
+)
+
+// Change is a difference between the spec and the live state
+type Change struct {
+	Action Action   `json:"action"`
+	Kind   string   `json:"kind"`
+	Name   string   `json:"name"`
+	Fields []string `json:"fields,omitempty"` // Settings that differ, secrets elided
+
+	bucket *BucketSpec
+	user   *UserSpec
+}
+
+// Options control how a spec is applied
+type Options struct {
+	// DryRun reports the changes without making them
+	DryRun bool
+	// Prune deletes buckets and users the spec does not name. Buckets
+	// holding objects are never deleted, and service accounts are left
+	// to their parents.
+	Prune bool
+	// Owner owns created buckets whose spec names no owner
+	Owner string
+	// Caller is the user applying the spec. Unless an admin of no tenant,
+	// it may only manage users holding no policy it lacks, and buckets and
+	// users of its own tenant. Nil skips the checks.
+	Caller *auth.User
+}
+
+// Result reports the changes a spec needed
+type Result struct {
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+}
+
+// Reconciler brings the live state in line with specs
+type Reconciler struct {
+	buckets *bucket.Service
+	users   auth.UserStore
+}
+
+// NewReconciler creates a reconciler. Without a user store, specs naming
+// users are rejected.
+func NewReconciler(buckets *bucket.Service, users auth.UserStore) *Reconciler {
+	return &Reconciler{
+		buckets: buckets,
+		users:   users,
+	}
+}
+
+// Apply diffs the spec against the live state and, unless this is a dry
+// run, makes the changes in order: creates and updates first, deletes last.
+// On error the changes before the failing one have been made.
+func (r *Reconciler) Apply(ctx context.Context, spec *Spec, opts Options) (*Result, error) {
+	changes, err := r.Plan(ctx, spec, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{DryRun: opts.DryRun, Changes: changes}
+	if opts.DryRun {
+		return result, nil
+	}
+	for i, change := range changes {
+		if err := r.apply(ctx, change, opts); err != nil {
+			return result, fmt.Errorf("%s %s %q (%d of %d changes made): %w",
+				change.Action, change.Kind, change.Name, i, len(changes), err)
+		}
+	}
+	return result, nil
+}
+
+// Plan returns the changes applying the spec would make
+func (r *Reconciler) Plan(ctx context.Context, spec *Spec, opts Options) ([]Change, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if len(spec.Users) > 0 && r.users == nil {
+		return nil, fmt.Errorf("%w: users cannot be managed without a user store", ErrInvalidSpec)
+	}
+
+	changes := make([]Change, 0)
+	var deletes []Change
+
+	live, err := r.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+	liveBuckets := make(map[string]*bucket.Bucket, len(live))
+	for _, b := range live {
+		liveBuckets[b.Name] = b
+	}
+
+	wanted := make(map[string]bool, len(spec.Buckets))
+	for i := range spec.Buckets {
+		want := &spec.Buckets[i]
+		wanted[want.Name] = true
+
+		if !mayManage(opts.Caller, bucketTenant(want.Name), nil) {
+			return nil, fmt.Errorf("%w: bucket %q", ErrPrivilegeEscalation, want.Name)
+		}
+		have, ok := liveBuckets[want.Name]
+		if !ok {
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindBucket, Name: want.Name, bucket: want})
+			continue
+		}
+		if fields := bucketDiff(have, want); len(fields) > 0 {
+			changes = append(changes, Change{Action: ActionUpdate, Kind: KindBucket, Name: want.Name, Fields: fields, bucket: want})
+		}
+	}
+	if opts.Prune {
+		for _, b := range live {
+			if !wanted[b.Name] && mayManage(opts.Caller, bucketTenant(b.Name), nil) {
+				deletes = append(deletes, Change{Action: ActionDelete, Kind: KindBucket, Name: b.Name})
+			}
+		}
+	}
+
+	if r.users != nil && (len(spec.Users) > 0 || opts.Prune) {
+		users, err := r.users.List()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		liveUsers := make(map[string]*auth.User, len(users))
+		for _, u := range users {
+			liveUsers[u.AccessKeyID] = u
+		}
+
+		wanted := make(map[string]bool, len(spec.Users))
+		for i := range spec.Users {
+			want := &spec.Users[i]
+			wanted[want.AccessKeyID] = true
+
+			if !mayManage(opts.Caller, want.Tenant, want.Policies) {
+				return nil, fmt.Errorf("%w: user %q", ErrPrivilegeEscalation, want.AccessKeyID)
+			}
+			have, ok := liveUsers[want.AccessKeyID]
+			if !ok {
+				changes = append(changes, Change{Action: ActionCreate, Kind: KindUser, Name: want.AccessKeyID, user: want})
+				continue
+			}
+			if have.ParentAccessKeyID != "" {
+				return nil, fmt.Errorf("%w: %q is a service account", ErrInvalidSpec, want.AccessKeyID)
+			}
+			if !mayManage(opts.Caller, have.Tenant, have.Policies) {
+				return nil, fmt.Errorf("%w: user %q", ErrPrivilegeEscalation, want.AccessKeyID)
+			}
+			if fields := userDiff(have, want); len(fields) > 0 {
+				changes = append(changes, Change{Action: ActionUpdate, Kind: KindUser, Name: want.AccessKeyID, Fields: fields, user: want})
+			}
+		}
+		if opts.Prune {
+			for _, u := range users {
+				if !wanted[u.AccessKeyID] && u.ParentAccessKeyID == "" && mayManage(opts.Caller, u.Tenant, u.Policies) {
+					deletes = append(deletes, Change{Action: ActionDelete, Kind: KindUser, Name: u.AccessKeyID})
+				}
+			}
+		}
+	}
+
+	return append(changes, deletes...), nil
+}
+
+// mayManage reports whether caller may manage a resource of tenant, or
+// give a user policies
+func mayManage(caller *auth.User, tenant string, policies []string) bool {
+	if caller == nil || (caller.IsAdmin() && caller.Tenant == "") {
+		return true
+	}
+	if tenant != caller.Tenant || caller.IsScoped() {
+		return false
+	}
+	for _, p := range policies {
+		if !slices.Contains(caller.Policies, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// bucketTenant returns the tenant a bucket name is qualified with
+func bucketTenant(name string) string {
+	tenant, _ := bucket.SplitName(name)
+	return tenant
+}
+
+// apply makes a single change
+func (r *Reconciler) apply(ctx context.Context, change Change, opts Options) error {
+	switch change.Kind {
+	case KindBucket:
+		switch change.Action {
+		case ActionCreate:
+			owner := change.bucket.Owner
+			if owner == "" {
+				owner = opts.Owner
+			}
+			if err := r.buckets.CreateBucket(ctx, change.Name, owner); err != nil {
+				return err
+			}
+			return r.updateBucket(ctx, change.bucket)
+		case ActionUpdate:
+			return r.updateBucket(ctx, change.bucket)
+		case ActionDelete:
+			return r.buckets.DeleteBucket(ctx, change.Name)
+		}
+	case KindUser:
+		switch change.Action {
+		case ActionCreate, ActionUpdate:
+			user := &auth.User{CreatedAt: time.Now()}
+			if have, err := r.users.Get(change.Name); err == nil {
+				user = have
+			}
+			user.AccessKeyID = change.user.AccessKeyID
+			user.SecretAccessKey = change.user.SecretAccessKey
+			user.Username = change.user.Username
+			user.Policies = change.user.Policies
+			user.Tenant = change.user.Tenant
+			return r.users.Put(user)
+		case ActionDelete:
+			return r.users.Delete(change.Name)
+		}
+	}
+	return fmt.Errorf("unknown change %s %s", change.Action, change.Kind)
+}
+
+// updateBucket sets a bucket's managed settings to the spec's
+func (r *Reconciler) updateBucket(ctx context.Context, want *BucketSpec) error {
+	b, err := r.buckets.GetBucket(ctx, want.Name)
+	if err != nil {
+		return err
+	}
+	if want.Owner != "" {
+		b.Owner = want.Owner
+	}
+	if want.Versioning != "" {
+		b.Versioning = want.Versioning
+	}
+	b.Lifecycle = want.Lifecycle
+	b.Policy = want.Policy
+	b.Quota = want.Quota
+	b.ChecksumAlgorithms = want.ChecksumAlgorithms
+	return r.buckets.UpdateBucket(ctx, b)
+}
+
+// bucketDiff lists the settings of a bucket that differ from its spec
+func bucketDiff(have *bucket.Bucket, want *BucketSpec) []string {
+	var fields []string
+	if want.Owner != "" && want.Owner != have.Owner {
+		fields = append(fields, fmt.Sprintf("owner: %q -> %q", have.Owner, want.Owner))
+	}
+	if want.Versioning != "" && want.Versioning != have.Versioning {
+		fields = append(fields, fmt.Sprintf("versioning: %s -> %s", have.Versioning, want.Versioning))
+	}
+	if !sameJSON(have.Lifecycle, want.Lifecycle) {
+		fields = append(fields, "lifecycle")
+	}
+	if !sameJSON(have.Policy, want.Policy) {
+		fields = append(fields, "policy")
+	}
+	if !sameJSON(have.Quota, want.Quota) {
+		fields = append(fields, "quota")
+	}
+	if !slices.Equal(have.ChecksumAlgorithms, want.ChecksumAlgorithms) {
+		fields = append(fields, fmt.Sprintf("checksum_algorithms: %v -> %v", have.ChecksumAlgorithms, want.ChecksumAlgorithms))
+	}
+	return fields
+}
+
+// userDiff lists the settings of a user that differ from its spec
+func userDiff(have *auth.User, want *UserSpec) []string {
+	var fields []string
+	if want.SecretAccessKey != have.SecretAccessKey {
+		fields = append(fields, "secret_access_key")
+	}
+	if want.Username != have.Username {
+		fields = append(fields, fmt.Sprintf("username: %q -> %q", have.Username, want.Username))
+	}
+	if !slices.Equal(want.Policies, have.Policies) {
+		fields = append(fields, "policies")
+	}
+	if want.Tenant != have.Tenant {
+		fields = append(fields, fmt.Sprintf("tenant: %q -> %q", have.Tenant, want.Tenant))
+	}
+	return fields
+}
+
+// sameJSON compares settings by their stored form, so a spec decoded from
+// YAML matches what was stored from JSON and empty lists match nil
+func sameJSON(a, b any) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(normalize(ja), normalize(jb))
+}
+
+func normalize(data []byte) []byte {
+	if string(data) == "[]" {
+		return []byte("null")
+	}
+	return data
+}
@@ -0,0 +1,217 @@
+package apply
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+)
+
+const testSpec = `
+buckets:
+  - name: photos
+    versioning: Enabled
+    lifecycle:
+      - id: expire-tmp
+        status: Enabled
+    policy:
+      Version: "2012-10-17"
+      Statement:
+        - Effect: Allow
+          Action: ["s3:GetObject"]
+          Resource: ["arn:aws:s3:::photos/*"]
+    quota:
+      max_size: 1073741824
+    checksum_algorithms: [BLAKE3]
+  - name: logs
+users:
+  - access_key_id: ci
+    secret_access_key: ci-secret
+    username: ci
+    policies: [readwrite]
+`
+
+// summary renders changes as "action kind name" lines
+func summary(changes []Change) string {
+	lines := make([]string, len(changes))
+	for i, c := range changes {
+		lines[i] = string(c.Action) + " " + c.Kind + " " + c.Name
+	}
+	return strings.Join(lines, ", ")
+}
+
+func newTestReconciler() (*Reconciler, *bucket.Service, auth.UserStore) {
+	buckets := bucket.NewService(bucket.NewMemoryRepository())
+	users := auth.NewMemoryUserStore()
+	return NewReconciler(buckets, users), buckets, users
+}
+
+func TestApply_CreatesThenConverges(t *testing.T) {
+	r, buckets, users := newTestReconciler()
+	ctx := context.Background()
+
+	spec, err := Parse([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	result, err := r.Apply(ctx, spec, Options{DryRun: true, Owner: "admin"})
+	if err != nil {
+		t.Fatalf("dry run error = %v", err)
+	}
+	if got, want := summary(result.Changes), "create bucket photos, create bucket logs, create user ci"; got != want {
+		t.Errorf("dry run changes = %s, want %s", got, want)
+	}
+	if _, err := buckets.GetBucket(ctx, "photos"); err == nil {
+		t.Error("dry run created a bucket")
+	}
+
+	if _, err := r.Apply(ctx, spec, Options{Owner: "admin"}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	photos, err := buckets.GetBucket(ctx, "photos")
+	if err != nil {
+		t.Fatalf("GetBucket() error = %v", err)
+	}
+	if photos.Owner != "admin" || photos.Versioning != bucket.VersioningEnabled || len(photos.Lifecycle) != 1 ||
+		photos.Policy == nil || photos.Quota == nil || photos.Quota.MaxSize != 1<<30 ||
+		len(photos.ChecksumAlgorithms) != 1 || photos.ChecksumAlgorithms[0] != "BLAKE3" {
+		t.Errorf("photos = %+v, want the spec's settings", photos)
+	}
+	user, err := users.Get("ci")
+	if err != nil || user.SecretAccessKey != "ci-secret" || user.Policies[0] != "readwrite" {
+		t.Errorf("user ci = %+v, %v", user, err)
+	}
+
+	// A second apply of the same spec has nothing to do
+	result, err = r.Apply(ctx, spec, Options{Owner: "admin"})
+	if err != nil {
+		t.Fatalf("second Apply() error = %v", err)
+	}
+	if len(result.Changes) != 0 {
+		t.Errorf("second apply changes = %+v, want none", result.Changes)
+	}
+}
+
+func TestApply_UpdateAndPrune(t *testing.T) {
+	r, buckets, users := newTestReconciler()
+	ctx := context.Background()
+
+	buckets.CreateBucket(ctx, "photos", "admin")
+	buckets.CreateBucket(ctx, "stale", "admin")
+	users.Put(&auth.User{AccessKeyID: "ci", SecretAccessKey: "old"})
+	users.Put(&auth.User{AccessKeyID: "gone", SecretAccessKey: "x"})
+	users.Put(&auth.User{AccessKeyID: "sa", SecretAccessKey: "x", ParentAccessKeyID: "gone"})
+
+	spec := &Spec{
+		Buckets: []BucketSpec{{Name: "photos", Versioning: bucket.VersioningEnabled, Quota: &bucket.Quota{MaxObjects: 10}}},
+		Users:   []UserSpec{{AccessKeyID: "ci", SecretAccessKey: "new"}},
+	}
+
+	changes, err := r.Plan(ctx, spec, Options{})
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if got, want := summary(changes), "update bucket photos, update user ci"; got != want {
+		t.Errorf("changes without prune = %s, want %s", got, want)
+	}
+	if fields := strings.Join(changes[0].Fields, "; "); fields != "versioning: Disabled -> Enabled; quota" {
+		t.Errorf("bucket fields = %s", fields)
+	}
+	if fields := strings.Join(changes[1].Fields, "; "); fields != "secret_access_key" {
+		t.Errorf("user fields = %s, want the secret elided", fields)
+	}
+
+	result, err := r.Apply(ctx, spec, Options{Prune: true})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got, want := summary(result.Changes), "update bucket photos, update user ci, delete bucket stale, delete user gone"; got != want {
+		t.Errorf("changes with prune = %s, want %s", got, want)
+	}
+	if _, err := buckets.GetBucket(ctx, "stale"); !errors.Is(err, bucket.ErrBucketNotFound) {
+		t.Errorf("stale bucket not pruned: %v", err)
+	}
+	if _, err := users.Get("sa"); err != nil {
+		t.Errorf("service account was pruned: %v", err)
+	}
+	if user, _ := users.Get("ci"); user.SecretAccessKey != "new" {
+		t.Errorf("secret not rotated: %q", user.SecretAccessKey)
+	}
+}
+
+func TestApply_CallerPrivileges(t *testing.T) {
+	r, buckets, users := newTestReconciler()
+	ctx := context.Background()
+
+	users.Put(auth.NewAdminUser("root", "x"))
+	buckets.CreateBucket(ctx, "team-b:photos", "root")
+	caller := &auth.User{AccessKeyID: "lead", Policies: []string{"readwrite"}, Tenant: "team-a"}
+
+	tests := []struct {
+		name string
+		spec *Spec
+	}{
+		{"grants admin", &Spec{Users: []UserSpec{{AccessKeyID: "ci", SecretAccessKey: "x", Policies: []string{auth.PolicyAdmin}}}}},
+		{"another tenant", &Spec{Users: []UserSpec{{AccessKeyID: "ci", SecretAccessKey: "x", Policies: []string{"readwrite"}, Tenant: "team-b"}}}},
+		{"takes over an admin", &Spec{Users: []UserSpec{{AccessKeyID: "root", SecretAccessKey: "mine", Tenant: "team-a"}}}},
+		{"another tenant's bucket", &Spec{Buckets: []BucketSpec{{Name: "team-b:photos"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := r.Apply(ctx, tt.spec, Options{Caller: caller}); !errors.Is(err, ErrPrivilegeEscalation) {
+				t.Errorf("Apply() error = %v, want ErrPrivilegeEscalation", err)
+			}
+		})
+	}
+	if user, _ := users.Get("root"); user.SecretAccessKey != "x" || user.Tenant != "" {
+		t.Errorf("admin was changed: %+v", user)
+	}
+
+	// What the caller holds it may grant in its tenant, and pruning
+	// leaves what it could not manage
+	spec := &Spec{
+		Buckets: []BucketSpec{{Name: "team-a:logs"}},
+		Users:   []UserSpec{{AccessKeyID: "ci", SecretAccessKey: "x", Policies: []string{"readwrite"}, Tenant: "team-a"}},
+	}
+	result, err := r.Apply(ctx, spec, Options{Caller: caller, Prune: true})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got, want := summary(result.Changes), "create bucket team-a:logs, create user ci"; got != want {
+		t.Errorf("changes = %s, want %s", got, want)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"unknown field", "buckets:\n  - name: photos\n    versioned: true\n"},
+		{"bad bucket name", "buckets:\n  - name: Photos\n"},
+		{"duplicate bucket", "buckets:\n  - name: photos\n  - name: photos\n"},
+		{"bad versioning", "buckets:\n  - name: photos\n    versioning: On\n"},
+		{"missing secret", "users:\n  - access_key_id: ci\n"},
+		{"unknown checksum", "buckets:\n  - name: photos\n    checksum_algorithms: [SHA3]\n"},
+		{"duplicate checksum", "buckets:\n  - name: photos\n    checksum_algorithms: [CRC32C, crc32c]\n"},
+		{"unknown JSON field", `{"bucket": []}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.spec)); !errors.Is(err, ErrInvalidSpec) {
+				t.Errorf("Parse() error = %v, want ErrInvalidSpec", err)
+			}
+		})
+	}
+
+	if spec, err := Parse(nil); err != nil || len(spec.Buckets) != 0 {
+		t.Errorf("Parse(empty) = %+v, %v; want an empty spec", spec, err)
+	}
+	if spec, err := Parse([]byte(`{"buckets": [{"name": "photos"}]}`)); err != nil || spec.Buckets[0].Name != "photos" {
+		t.Errorf("Parse(JSON) = %+v, %v", spec, err)
+	}
+}
@@ -0,0 +1,125 @@
+// Package apply reconciles buckets and users with a declarative spec, so
+// that comio can be managed as code
+package apply
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/danielino/comio/internal/auth"
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/integrity"
+)
+
+// ErrInvalidSpec is returned for specs that cannot be applied
+var ErrInvalidSpec = errors.New("invalid spec")
+
+// Spec is the desired state of the buckets and users it names
+type Spec struct {
+	Buckets []BucketSpec `json:"buckets" yaml:"buckets"`
+	Users   []UserSpec   `json:"users" yaml:"users"`
+}
+
+// BucketSpec is the desired state of a bucket. Settings left out are not
+// managed, except that omitting lifecycle, policy, quota or
+// checksum_algorithms removes them.
+type BucketSpec struct {
+	Name               string                  `json:"name" yaml:"name"`
+	Owner              string                  `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Versioning         bucket.VersioningStatus `json:"versioning,omitempty" yaml:"versioning,omitempty"`
+	Lifecycle          []bucket.LifecycleRule  `json:"lifecycle,omitempty" yaml:"lifecycle,omitempty"`
+	Policy             *auth.Policy            `json:"policy,omitempty" yaml:"policy,omitempty"`
+	Quota              *bucket.Quota           `json:"quota,omitempty" yaml:"quota,omitempty"`
+	ChecksumAlgorithms []string                `json:"checksum_algorithms,omitempty" yaml:"checksum_algorithms,omitempty"`
+}
+
+// UserSpec is the desired state of a user
+type UserSpec struct {
+	AccessKeyID     string   `json:"access_key_id" yaml:"access_key_id"`
+	SecretAccessKey string   `json:"secret_access_key" yaml:"secret_access_key"`
+	Username        string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Policies        []string `json:"policies,omitempty" yaml:"policies,omitempty"`
+	Tenant          string   `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+}
+
+// Parse decodes a YAML or JSON spec, rejecting unknown fields so typos are
+// not silently ignored, and validates it
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&spec); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// An empty document is an empty spec
+		if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+		}
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// Validate checks names are valid and unique and settings are known
+func (s *Spec) Validate() error {
+	var problems []string
+
+	buckets := make(map[string]bool)
+	for _, b := range s.Buckets {
+		if err := bucket.ValidateName(b.Name); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if buckets[b.Name] {
+			problems = append(problems, fmt.Sprintf("bucket %q is listed twice", b.Name))
+		}
+		buckets[b.Name] = true
+
+		switch b.Versioning {
+		case "", bucket.VersioningEnabled, bucket.VersioningSuspended, bucket.VersioningDisabled:
+		default:
+			problems = append(problems, fmt.Sprintf("bucket %q: unknown versioning %q", b.Name, b.Versioning))
+		}
+		if q := b.Quota; q != nil && (q.MaxSize < 0 || q.MaxObjects < 0) {
+			problems = append(problems, fmt.Sprintf("bucket %q: negative quota", b.Name))
+		}
+		if err := integrity.ValidateAlgorithms(b.ChecksumAlgorithms); err != nil {
+			problems = append(problems, fmt.Sprintf("bucket %q: %v", b.Name, err))
+		}
+	}
+
+	users := make(map[string]bool)
+	for _, u := range s.Users {
+		if u.AccessKeyID == "" {
+			problems = append(problems, "user without access_key_id")
+			continue
+		}
+		if users[u.AccessKeyID] {
+			problems = append(problems, fmt.Sprintf("user %q is listed twice", u.AccessKeyID))
+		}
+		users[u.AccessKeyID] = true
+		if u.SecretAccessKey == "" {
+			problems = append(problems, fmt.Sprintf("user %q: secret_access_key is required", u.AccessKeyID))
+		}
+		if u.Tenant != "" && !bucket.ValidTenant(u.Tenant) {
+			problems = append(problems, fmt.Sprintf("user %q: invalid tenant %q", u.AccessKeyID, u.Tenant))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidSpec, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Package attestation signs statements about stored objects with the
+// server's key, so auditors can check an object has not changed since it
+// was written without downloading it.
+package attestation
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danielino/comio/internal/integrity"
+)
+
+// Algorithm is the signature algorithm of attestations
+const Algorithm = "Ed25519"
+
+// RetentionNone is the retention mode of objects under no retention period
+// or legal hold
+const RetentionNone = "NONE"
+
+// ErrInvalidSignature is returned for attestations whose signature does not
+// match their payload
+var ErrInvalidSignature = errors.New("invalid attestation signature")
+
+// Statement is what an attestation vouches for: the stored state of one
+// version of an object at the time it was issued
+type Statement struct {
+	Bucket     string             `json:"bucket"`
+	Key        string             `json:"key"`
+	VersionID  string             `json:"version_id"`
+	Size       int64              `json:"size"`
+	ETag       string             `json:"etag"`
+	Checksum   integrity.Checksum `json:"checksum"`
+	ModifiedAt time.Time          `json:"modified_at"`
+	Retention  Retention          `json:"retention"`
+	IssuedAt   time.Time          `json:"issued_at"`
+	KeyID      string             `json:"key_id"`
+}
+
+// Retention is the protection of an object version against change
+type Retention struct {
+	Mode       string `json:"mode"`       // RetentionNone unless the version is locked
+	Versioning string `json:"versioning"` // Of the bucket; with it enabled, overwrites keep the attested version
+}
+
+// Attestation is a signed statement. Payload holds the exact bytes signed,
+// so the signature can be checked without re-encoding the statement.
+type Attestation struct {
+	Statement Statement `json:"statement"`
+	Payload   string    `json:"payload"`   // Base64 of the statement's JSON
+	Signature string    `json:"signature"` // Base64
+	Algorithm string    `json:"algorithm"`
+	KeyID     string    `json:"key_id"`
+}
+
+// Signer issues attestations with the server's key
+type Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSigner creates a signer with a private key
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}
+}
+
+// GenerateSigner creates a signer with a new random key
+func GenerateSigner() (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(key), nil
+}
+
+// ParseKey decodes a base64-encoded 32-byte Ed25519 seed
+func ParseKey(encoded string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not valid base64: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// KeyID identifies a public key by the first bytes of its SHA-256, in hex
+func KeyID(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(public)
+	return hex.EncodeToString(sum[:8])
+}
+
+// PublicKey returns the key attestations are verified with
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// KeyID returns the identifier of the signer's key
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign issues an attestation of a statement, stamping it with the time and
+// the signer's key
+func (s *Signer) Sign(statement Statement) (*Attestation, error) {
+	statement.IssuedAt = time.Now().UTC()
+	statement.KeyID = s.keyID
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{
+		Statement: statement,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: s.SignBytes(payload),
+		Algorithm: Algorithm,
+		KeyID:     s.keyID,
+	}, nil
+}
+
+// SignBytes signs data other than a statement, such as an export
+// manifest, returning the base64 signature
+func (s *Signer) SignBytes(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data))
+}
+
+// VerifyBytes checks a base64 signature of data made with SignBytes
+func VerifyBytes(public ed25519.PublicKey, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not valid base64: %w", err)
+	}
+	if !ed25519.Verify(public, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Verify checks an attestation's signature with a public key and returns
+// the statement decoded from its signed payload
+func Verify(public ed25519.PublicKey, a *Attestation) (*Statement, error) {
+	if a.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported attestation algorithm %q", a.Algorithm)
+	}
+	payload, err := base64.StdEncoding.DecodeString(a.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("attestation payload is not valid base64: %w", err)
+	}
+	if err := VerifyBytes(public, payload, a.Signature); err != nil {
+		return nil, err
+	}
+	var statement Statement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil, err
+	}
+	return &statement, nil
+}
@@ -0,0 +1,76 @@
+package attestation
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/danielino/comio/internal/integrity"
+)
+
+func TestSign_Verify(t *testing.T) {
+	signer, err := GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := signer.Sign(Statement{
+		Bucket:    "audit",
+		Key:       "ledger.csv",
+		VersionID: "v1",
+		Size:      42,
+		Checksum:  integrity.Checksum{Algorithm: "SHA256", Value: "ab"},
+		Retention: Retention{Mode: RetentionNone, Versioning: "Enabled"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.KeyID != signer.KeyID() || a.Statement.KeyID != signer.KeyID() || a.Statement.IssuedAt.IsZero() {
+		t.Errorf("attestation not stamped: key %q, issued %v", a.Statement.KeyID, a.Statement.IssuedAt)
+	}
+
+	statement, err := Verify(signer.PublicKey(), a)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if statement.Key != "ledger.csv" || statement.Size != 42 || statement.Checksum.Value != "ab" {
+		t.Errorf("Verify() = %+v, want the signed statement", statement)
+	}
+
+	// The signature covers the payload, not the decoded statement shown
+	// beside it
+	tampered := *a
+	payload, _ := base64.StdEncoding.DecodeString(a.Payload)
+	payload[len(payload)-2] ^= 1
+	tampered.Payload = base64.StdEncoding.EncodeToString(payload)
+	if _, err := Verify(signer.PublicKey(), &tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() of a changed payload error = %v, want ErrInvalidSignature", err)
+	}
+
+	other, _ := GenerateSigner()
+	if _, err := Verify(other.PublicKey(), a); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() with another key error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	seed := make([]byte, 32)
+	rand.Read(seed)
+	key, err := ParseKey(base64.StdEncoding.EncodeToString(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same seed gives the same key, and key ID, across restarts
+	again, _ := ParseKey(base64.StdEncoding.EncodeToString(seed))
+	if NewSigner(key).KeyID() != NewSigner(again).KeyID() {
+		t.Error("key ID differs for the same seed")
+	}
+
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("expected error for short key")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Error("expected error for invalid base64")
+	}
+}
@@ -19,6 +19,7 @@ import (
 // https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
 type HMACAuthenticator struct {
 	users map[string]*User // accessKeyID -> User
+	store UserStore        // Optional persisted users and service accounts
 }
 
 // NewHMACAuthenticator creates a new HMAC authenticator
@@ -33,6 +34,33 @@ func (a *HMACAuthenticator) AddUser(user *User) {
 	a.users[user.AccessKeyID] = user
 }
 
+// SetUserStore enables authentication of persisted users and service accounts
+func (a *HMACAuthenticator) SetUserStore(store UserStore) {
+	a.store = store
+}
+
+// LookupUser finds a user by access key in the static users, then the
+// store. Service accounts whose parent no longer exists are not returned.
+func (a *HMACAuthenticator) LookupUser(accessKeyID string) (*User, bool) {
+	if user, ok := a.users[accessKeyID]; ok {
+		return user, true
+	}
+	if a.store == nil {
+		return nil, false
+	}
+
+	user, err := a.store.Get(accessKeyID)
+	if err != nil {
+		return nil, false
+	}
+	if user.ParentAccessKeyID != "" {
+		if _, ok := a.LookupUser(user.ParentAccessKeyID); !ok {
+			return nil, false
+		}
+	}
+	return user, true
+}
+
 // Authenticate authenticates a request and returns the user
 func (a *HMACAuthenticator) Authenticate(ctx context.Context, req *http.Request) (*User, error) {
 	// Get the Authorization header
@@ -66,7 +94,7 @@ func (a *HMACAuthenticator) Authenticate(ctx context.Context, req *http.Request)
 	accessKeyID := parts[0]
 
 	// Look up user by access key ID
-	user, ok := a.users[accessKeyID]
+	user, ok := a.LookupUser(accessKeyID)
 	if !ok {
 		return nil, errors.New("unknown access key")
 	}
@@ -126,7 +154,7 @@ func NewAdminUser(accessKey, secretKey string) *User {
 		AccessKeyID:     accessKey,
 		SecretAccessKey: secretKey,
 		Username:        "admin",
-		Policies:        []string{"admin"},
+		Policies:        []string{PolicyAdmin},
 		CreatedAt:       time.Now(),
 	}
 }
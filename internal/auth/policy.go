@@ -2,14 +2,14 @@ package auth
 
 // Policy represents an authorization policy
 type Policy struct {
-	Version   string
-	Statement []Statement
+	Version   string      `yaml:"Version"`
+	Statement []Statement `yaml:"Statement"`
 }
 
 // Statement represents a policy statement
 type Statement struct {
-	Effect    string
-	Action    []string
-	Resource  []string
-	Condition map[string]interface{}
+	Effect    string                 `yaml:"Effect"`
+	Action    []string               `yaml:"Action"`
+	Resource  []string               `yaml:"Resource"`
+	Condition map[string]interface{} `yaml:"Condition,omitempty"`
 }
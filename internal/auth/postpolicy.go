@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PostPolicyAlgorithm is the only signing algorithm accepted for POST
+// policy uploads
+const PostPolicyAlgorithm = "AWS4-HMAC-SHA256"
+
+var (
+	// ErrInvalidPostPolicy is returned for policy documents that cannot be
+	// decoded or hold unknown conditions
+	ErrInvalidPostPolicy = errors.New("invalid POST policy")
+	// ErrPostPolicyExpired is returned once a policy's expiration has passed
+	ErrPostPolicyExpired = errors.New("POST policy expired")
+	// ErrPostPolicyViolated is returned for forms the policy does not allow
+	ErrPostPolicyViolated = errors.New("POST policy condition failed")
+	// ErrPostSignature is returned when a form's signature does not verify
+	ErrPostSignature = errors.New("POST policy signature does not match")
+)
+
+// Policy condition operators
+const (
+	ConditionEq                 = "eq"
+	ConditionStartsWith         = "starts-with"
+	ConditionContentLengthRange = "content-length-range"
+)
+
+// PostCondition is one condition of a POST policy. Fields are lowercase
+// form field names without the leading "$".
+type PostCondition struct {
+	Op    string
+	Field string
+	Value string
+	Min   int64 // content-length-range only
+	Max   int64
+}
+
+// PostPolicy is a decoded POST policy document: what a browser form may
+// upload, signed by the credentials that allow it
+type PostPolicy struct {
+	Expiration time.Time
+	Conditions []PostCondition
+}
+
+// exemptFields are form fields a policy need not mention
+var exemptFields = []string{"file", "policy", "x-amz-signature"}
+
+// ParsePostPolicy decodes a base64 encoded policy document
+func ParsePostPolicy(encoded string) (*PostPolicy, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPostPolicy, err)
+	}
+
+	var doc struct {
+		Expiration string            `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPostPolicy, err)
+	}
+	expiration, err := time.Parse(time.RFC3339, doc.Expiration)
+	if err != nil {
+		return nil, fmt.Errorf("%w: expiration: %v", ErrInvalidPostPolicy, err)
+	}
+
+	policy := &PostPolicy{Expiration: expiration}
+	for _, raw := range doc.Conditions {
+		cond, err := parseCondition(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPostPolicy, err)
+		}
+		policy.Conditions = append(policy.Conditions, cond)
+	}
+	return policy, nil
+}
+
+// parseCondition decodes {"field": "value"} and [op, "$field", value] or
+// ["content-length-range", min, max] conditions
+func parseCondition(raw json.RawMessage) (PostCondition, error) {
+	var exact map[string]string
+	if err := json.Unmarshal(raw, &exact); err == nil {
+		if len(exact) != 1 {
+			return PostCondition{}, fmt.Errorf("condition %s must name one field", raw)
+		}
+		for field, value := range exact {
+			return PostCondition{Op: ConditionEq, Field: strings.ToLower(field), Value: value}, nil
+		}
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil || len(list) != 3 {
+		return PostCondition{}, fmt.Errorf("malformed condition %s", raw)
+	}
+	var op string
+	if err := json.Unmarshal(list[0], &op); err != nil {
+		return PostCondition{}, fmt.Errorf("malformed condition %s", raw)
+	}
+
+	switch op = strings.ToLower(op); op {
+	case ConditionContentLengthRange:
+		var min, max int64
+		if json.Unmarshal(list[1], &min) != nil || json.Unmarshal(list[2], &max) != nil || min < 0 || max < min {
+			return PostCondition{}, fmt.Errorf("malformed content-length-range %s", raw)
+		}
+		return PostCondition{Op: op, Min: min, Max: max}, nil
+	case ConditionEq, ConditionStartsWith:
+		var field, value string
+		if json.Unmarshal(list[1], &field) != nil || json.Unmarshal(list[2], &value) != nil || !strings.HasPrefix(field, "$") {
+			return PostCondition{}, fmt.Errorf("malformed condition %s", raw)
+		}
+		return PostCondition{Op: op, Field: strings.ToLower(field[1:]), Value: value}, nil
+	}
+	return PostCondition{}, fmt.Errorf("unknown condition %q", op)
+}
+
+// Check verifies a form against the policy at now. Form fields are keyed
+// by lowercase name. Every field but the exempt ones and x-ignore-* must
+// be allowed by a condition, so a signed policy cannot be stretched by
+// adding fields to the form.
+func (p *PostPolicy) Check(fields map[string]string, now time.Time) error {
+	if !now.Before(p.Expiration) {
+		return ErrPostPolicyExpired
+	}
+
+	covered := make(map[string]bool)
+	for _, cond := range p.Conditions {
+		if cond.Op == ConditionContentLengthRange {
+			continue
+		}
+		value := fields[cond.Field]
+		switch cond.Op {
+		case ConditionEq:
+			if value != cond.Value {
+				return fmt.Errorf("%w: %s must equal %q", ErrPostPolicyViolated, cond.Field, cond.Value)
+			}
+		case ConditionStartsWith:
+			if !startsWith(cond.Field, value, cond.Value) {
+				return fmt.Errorf("%w: %s must start with %q", ErrPostPolicyViolated, cond.Field, cond.Value)
+			}
+		}
+		covered[cond.Field] = true
+	}
+
+	for field := range fields {
+		if covered[field] || strings.HasPrefix(field, "x-ignore-") || slices.Contains(exemptFields, field) {
+			continue
+		}
+		return fmt.Errorf("%w: field %s is not allowed by the policy", ErrPostPolicyViolated, field)
+	}
+	return nil
+}
+
+// CheckSize verifies an upload's size against the policy's
+// content-length-range conditions
+func (p *PostPolicy) CheckSize(size int64) error {
+	for _, cond := range p.Conditions {
+		if cond.Op == ConditionContentLengthRange && (size < cond.Min || size > cond.Max) {
+			return fmt.Errorf("%w: content length %d is outside %d-%d", ErrPostPolicyViolated, size, cond.Min, cond.Max)
+		}
+	}
+	return nil
+}
+
+// startsWith matches a starts-with condition. Content-Type may list
+// several types separated by commas, each of which must match.
+func startsWith(field, value, prefix string) bool {
+	if field != "content-type" {
+		return strings.HasPrefix(value, prefix)
+	}
+	for _, v := range strings.Split(value, ",") {
+		if !strings.HasPrefix(strings.TrimSpace(v), prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyPostPolicy checks the SigV4 signature of a POST policy form. The
+// credential is the form's x-amz-credential,
+// AKID/yyyymmdd/region/service/aws4_request, and the signature the hex
+// HMAC of the encoded policy under the derived signing key. It returns the
+// signing user.
+func (a *HMACAuthenticator) VerifyPostPolicy(credential, encodedPolicy, signature string) (*User, error) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" {
+		return nil, fmt.Errorf("%w: malformed credential", ErrPostSignature)
+	}
+
+	user, ok := a.LookupUser(parts[0])
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown access key", ErrPostSignature)
+	}
+
+	expected := SignPostPolicy(user.SecretAccessKey, parts[1], parts[2], parts[3], encodedPolicy)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, ErrPostSignature
+	}
+	return user, nil
+}
+
+// SignPostPolicy returns the SigV4 signature of an encoded policy, as
+// browser upload forms carry it in x-amz-signature
+func SignPostPolicy(secretKey, date, region, service, encodedPolicy string) string {
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request", encodedPolicy} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	return hex.EncodeToString(key)
+}
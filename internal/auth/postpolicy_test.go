@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testPostPolicy = `{
+	"expiration": "2030-01-01T00:00:00Z",
+	"conditions": [
+		{"bucket": "uploads"},
+		["starts-with", "$key", "user/alice/"],
+		["starts-with", "$Content-Type", "image/"],
+		["content-length-range", 1, 1048576],
+		["eq", "$success_action_status", "201"]
+	]
+}`
+
+func TestPostPolicy_Check(t *testing.T) {
+	policy, err := ParsePostPolicy(base64.StdEncoding.EncodeToString([]byte(testPostPolicy)))
+	if err != nil {
+		t.Fatalf("ParsePostPolicy() error = %v", err)
+	}
+	now := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	valid := func() map[string]string {
+		return map[string]string{
+			"bucket":                "uploads",
+			"key":                   "user/alice/${filename}",
+			"content-type":          "image/png",
+			"success_action_status": "201",
+			"x-ignore-tracking":     "1",
+		}
+	}
+	if err := policy.Check(valid(), now); err != nil {
+		t.Errorf("Check(valid form) error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(map[string]string)
+		want   error
+	}{
+		{"other bucket", func(f map[string]string) { f["bucket"] = "other" }, ErrPostPolicyViolated},
+		{"key outside prefix", func(f map[string]string) { f["key"] = "user/bob/x" }, ErrPostPolicyViolated},
+		{"wrong content type", func(f map[string]string) { f["content-type"] = "text/html" }, ErrPostPolicyViolated},
+		{"one of several types wrong", func(f map[string]string) { f["content-type"] = "image/png, text/html" }, ErrPostPolicyViolated},
+		{"field not in policy", func(f map[string]string) { f["x-amz-meta-owner"] = "mallory" }, ErrPostPolicyViolated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := valid()
+			tt.change(fields)
+			if err := policy.Check(fields, now); !errors.Is(err, tt.want) {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if err := policy.Check(valid(), policy.Expiration); !errors.Is(err, ErrPostPolicyExpired) {
+		t.Errorf("Check() at expiration error = %v, want ErrPostPolicyExpired", err)
+	}
+
+	for size, ok := range map[int64]bool{0: false, 1: true, 1 << 20: true, 1<<20 + 1: false} {
+		if err := policy.CheckSize(size); (err == nil) != ok {
+			t.Errorf("CheckSize(%d) error = %v", size, err)
+		}
+	}
+}
+
+func TestParsePostPolicy_Invalid(t *testing.T) {
+	docs := []string{
+		`not json`,
+		`{"conditions": []}`,
+		`{"expiration": "2030-01-01T00:00:00Z", "conditions": [["matches", "$key", ".*"]]}`,
+		`{"expiration": "2030-01-01T00:00:00Z", "conditions": [["eq", "key", "x"]]}`,
+		`{"expiration": "2030-01-01T00:00:00Z", "conditions": [["content-length-range", 10, 1]]}`,
+		`{"expiration": "2030-01-01T00:00:00Z", "conditions": [{"bucket": "a", "key": "b"}]}`,
+	}
+	for _, doc := range docs {
+		if _, err := ParsePostPolicy(base64.StdEncoding.EncodeToString([]byte(doc))); !errors.Is(err, ErrInvalidPostPolicy) {
+			t.Errorf("ParsePostPolicy(%s) error = %v, want ErrInvalidPostPolicy", doc, err)
+		}
+	}
+	if _, err := ParsePostPolicy("%%%"); !errors.Is(err, ErrInvalidPostPolicy) {
+		t.Errorf("ParsePostPolicy(bad base64) error = %v", err)
+	}
+}
+
+func TestVerifyPostPolicy(t *testing.T) {
+	a := NewHMACAuthenticator()
+	a.AddUser(&User{AccessKeyID: "alice", SecretAccessKey: "alice-secret"})
+
+	policy := base64.StdEncoding.EncodeToString([]byte(testPostPolicy))
+	signature := SignPostPolicy("alice-secret", "20290101", "us-east-1", "s3", policy)
+	credential := "alice/20290101/us-east-1/s3/aws4_request"
+
+	user, err := a.VerifyPostPolicy(credential, policy, signature)
+	if err != nil || user.AccessKeyID != "alice" {
+		t.Fatalf("VerifyPostPolicy() = %v, %v", user, err)
+	}
+
+	tests := []struct {
+		name                          string
+		credential, policy, signature string
+	}{
+		{"tampered policy", credential, policy + "e30=", signature},
+		{"wrong region", "alice/20290101/eu-west-1/s3/aws4_request", policy, signature},
+		{"unknown key", "bob/20290101/us-east-1/s3/aws4_request", policy, signature},
+		{"malformed credential", "alice", policy, signature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.VerifyPostPolicy(tt.credential, tt.policy, tt.signature); !errors.Is(err, ErrPostSignature) {
+				t.Errorf("VerifyPostPolicy() error = %v, want ErrPostSignature", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Action is an operation a scoped credential may be allowed to perform
+type Action string
+
+const (
+	ActionRead   Action = "read"   // GET/HEAD objects
+	ActionWrite  Action = "write"  // PUT objects
+	ActionDelete Action = "delete" // DELETE objects
+	ActionList   Action = "list"   // List objects in a bucket
+)
+
+// AllActions lists every scopable action
+var AllActions = []Action{ActionRead, ActionWrite, ActionDelete, ActionList}
+
+// ErrScopeEscalation is returned when a derived credential would be granted
+// more than its parent has
+var ErrScopeEscalation = errors.New("scope exceeds parent credentials")
+
+// Scope restricts a credential to one bucket, an optional key prefix and a
+// subset of actions
+type Scope struct {
+	Bucket  string   `json:"bucket"`
+	Prefix  string   `json:"prefix,omitempty"`
+	Actions []Action `json:"actions"`
+}
+
+// Validate checks that the scope names a bucket and only known actions
+func (s *Scope) Validate() error {
+	if s.Bucket == "" {
+		return errors.New("scope requires a bucket")
+	}
+	if len(s.Actions) == 0 {
+		return errors.New("scope requires at least one action")
+	}
+	for _, action := range s.Actions {
+		if !isKnownAction(action) {
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the scope permits action on bucket/key. For
+// ActionList, key is the listing prefix.
+func (s *Scope) Allows(action Action, bucket, key string) bool {
+	if bucket != s.Bucket || !s.hasAction(action) {
+		return false
+	}
+	return strings.HasPrefix(key, s.Prefix)
+}
+
+// Contains reports whether every request allowed by other is also allowed by s
+func (s *Scope) Contains(other *Scope) bool {
+	if other.Bucket != s.Bucket || !strings.HasPrefix(other.Prefix, s.Prefix) {
+		return false
+	}
+	for _, action := range other.Actions {
+		if !s.hasAction(action) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Scope) hasAction(action Action) bool {
+	for _, a := range s.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func isKnownAction(action Action) bool {
+	for _, a := range AllActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the user may perform action on bucket/key.
+// Unscoped users are not restricted here; scoped users (service accounts)
+// are limited to their scope.
+func (u *User) Allows(action Action, bucket, key string) bool {
+	if u.Scope == nil {
+		return true
+	}
+	return u.Scope.Allows(action, bucket, key)
+}
+
+// IsScoped reports whether the user is restricted to a scope
+func (u *User) IsScoped() bool {
+	return u.Scope != nil
+}
+
+// NewServiceAccount derives a credential from parent restricted to scope.
+// A scoped parent can only derive credentials within its own scope.
+func NewServiceAccount(parent *User, scope Scope) (*User, error) {
+	if err := scope.Validate(); err != nil {
+		return nil, err
+	}
+	if parent.Scope != nil && !parent.Scope.Contains(&scope) {
+		return nil, ErrScopeEscalation
+	}
+
+	accessKey, err := randomKey(10)
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := randomKey(20)
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{
+		AccessKeyID:       "SA" + strings.ToUpper(accessKey),
+		SecretAccessKey:   secretKey,
+		Username:          parent.Username,
+		Tenant:            parent.Tenant,
+		ParentAccessKeyID: parent.AccessKeyID,
+		Scope:             &scope,
+		CreatedAt:         time.Now(),
+	}, nil
+}
+
+func randomKey(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestScope_Allows(t *testing.T) {
+	scope := &Scope{Bucket: "photos", Prefix: "app1/", Actions: []Action{ActionRead, ActionList}}
+
+	tests := []struct {
+		action Action
+		bucket string
+		key    string
+		want   bool
+	}{
+		{ActionRead, "photos", "app1/cat.jpg", true},
+		{ActionList, "photos", "app1/", true},
+		{ActionList, "photos", "app1/2024/", true},
+		{ActionList, "photos", "", false},
+		{ActionRead, "photos", "app2/cat.jpg", false},
+		{ActionRead, "other", "app1/cat.jpg", false},
+		{ActionWrite, "photos", "app1/cat.jpg", false},
+		{ActionDelete, "photos", "app1/cat.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if got := scope.Allows(tt.action, tt.bucket, tt.key); got != tt.want {
+			t.Errorf("Allows(%s, %s, %s) = %v, want %v", tt.action, tt.bucket, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestScope_Validate(t *testing.T) {
+	invalid := []Scope{
+		{Actions: []Action{ActionRead}},
+		{Bucket: "b"},
+		{Bucket: "b", Actions: []Action{"admin"}},
+	}
+	for _, scope := range invalid {
+		if err := scope.Validate(); err == nil {
+			t.Errorf("Validate(%+v) expected error", scope)
+		}
+	}
+}
+
+func TestNewServiceAccount(t *testing.T) {
+	admin := NewAdminUser("admin", "secret")
+
+	sa, err := NewServiceAccount(admin, Scope{Bucket: "logs", Prefix: "app/", Actions: []Action{ActionRead, ActionWrite}})
+	if err != nil {
+		t.Fatalf("NewServiceAccount() error = %v", err)
+	}
+	if sa.AccessKeyID == "" || sa.SecretAccessKey == "" {
+		t.Error("credentials not generated")
+	}
+	if sa.ParentAccessKeyID != "admin" || !sa.IsScoped() {
+		t.Errorf("service account = %+v", sa)
+	}
+	if admin.IsScoped() || !admin.Allows(ActionDelete, "any", "key") {
+		t.Error("unscoped users should not be restricted")
+	}
+
+	// Derived from a scoped parent: narrowing is fine, widening is not
+	if _, err := NewServiceAccount(sa, Scope{Bucket: "logs", Prefix: "app/web/", Actions: []Action{ActionRead}}); err != nil {
+		t.Errorf("narrower scope error = %v", err)
+	}
+	widen := []Scope{
+		{Bucket: "logs", Prefix: "", Actions: []Action{ActionRead}},
+		{Bucket: "logs", Prefix: "app/", Actions: []Action{ActionDelete}},
+		{Bucket: "other", Prefix: "app/", Actions: []Action{ActionRead}},
+	}
+	for _, scope := range widen {
+		if _, err := NewServiceAccount(sa, scope); !errors.Is(err, ErrScopeEscalation) {
+			t.Errorf("NewServiceAccount(%+v) error = %v, want %v", scope, err, ErrScopeEscalation)
+		}
+	}
+}
+
+func TestHMACAuthenticator_LookupUser(t *testing.T) {
+	store, err := NewFileUserStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileUserStore() error = %v", err)
+	}
+
+	a := NewHMACAuthenticator()
+	a.AddUser(NewAdminUser("admin", "secret"))
+	a.SetUserStore(store)
+
+	parent := &User{AccessKeyID: "app-owner", SecretAccessKey: "s"}
+	if err := store.Put(parent); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	sa, _ := NewServiceAccount(parent, Scope{Bucket: "b", Actions: []Action{ActionRead}})
+	if err := store.Put(sa); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, ok := a.LookupUser(sa.AccessKeyID)
+	if !ok || got.Scope == nil || got.Scope.Bucket != "b" {
+		t.Fatalf("LookupUser() = %+v, %v", got, ok)
+	}
+	if _, ok := a.LookupUser("admin"); !ok {
+		t.Error("static admin user not found")
+	}
+
+	// Removing the parent revokes its service accounts
+	if err := store.Delete(parent.AccessKeyID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := a.LookupUser(sa.AccessKeyID); ok {
+		t.Error("service account of deleted parent should not resolve")
+	}
+
+	users, err := store.List()
+	if err != nil || len(users) != 1 {
+		t.Errorf("List() = %d users, %v; want 1", len(users), err)
+	}
+}
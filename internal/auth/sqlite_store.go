@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/encryption"
+)
+
+// SQLiteUserStore implements UserStore in the metadata database. As with
+// FileUserStore, secret keys are sealed with the master key once a keyring
+// is set.
+type SQLiteUserStore struct {
+	db   *database.DB
+	keys *encryption.Keyring
+}
+
+// NewSQLiteUserStore creates a SQLite-based user store
+func NewSQLiteUserStore(db *database.DB) *SQLiteUserStore {
+	return &SQLiteUserStore{db: db}
+}
+
+// SetKeyring seals secret keys with the master key when users are written.
+// Users written before stay readable until they are sealed by Reseal.
+func (s *SQLiteUserStore) SetKeyring(keys *encryption.Keyring) {
+	s.keys = keys
+}
+
+const userColumns = `access_key_id, username, secret_access_key, sealed_secret, policies, parent_access_key_id, scope, tenant, created_at`
+
+func (s *SQLiteUserStore) Put(user *User) error {
+	secret := sql.NullString{String: user.SecretAccessKey, Valid: true}
+	var sealed sql.NullString
+	if s.keys != nil {
+		sealedSecret, err := s.keys.SealSecret(user.SecretAccessKey)
+		if err != nil {
+			return fmt.Errorf("failed to seal secret key: %w", err)
+		}
+		data, err := json.Marshal(sealedSecret)
+		if err != nil {
+			return fmt.Errorf("failed to marshal sealed secret key: %w", err)
+		}
+		secret = sql.NullString{}
+		sealed = sql.NullString{String: string(data), Valid: true}
+	}
+
+	policies, err := json.Marshal(user.Policies)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policies: %w", err)
+	}
+	var scope sql.NullString
+	if user.Scope != nil {
+		data, err := json.Marshal(user.Scope)
+		if err != nil {
+			return fmt.Errorf("failed to marshal scope: %w", err)
+		}
+		scope = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err = s.db.ExecWithRetry(context.Background(), `
+		INSERT INTO users (`+userColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (access_key_id) DO UPDATE SET
+			username = excluded.username,
+			secret_access_key = excluded.secret_access_key,
+			sealed_secret = excluded.sealed_secret,
+			policies = excluded.policies,
+			parent_access_key_id = excluded.parent_access_key_id,
+			scope = excluded.scope,
+			tenant = excluded.tenant,
+			created_at = excluded.created_at
+	`,
+		user.AccessKeyID,
+		user.Username,
+		secret,
+		sealed,
+		string(policies),
+		sql.NullString{String: user.ParentAccessKeyID, Valid: user.ParentAccessKeyID != ""},
+		scope,
+		sql.NullString{String: user.Tenant, Valid: user.Tenant != ""},
+		user.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store user: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteUserStore) Get(accessKeyID string) (*User, error) {
+	row := s.db.QueryRowContext(context.Background(),
+		"SELECT "+userColumns+" FROM users WHERE access_key_id = ?", accessKeyID)
+	user, _, err := s.scan(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// scan reads a user row, opening a sealed secret key. It also returns the
+// sealed secret, nil for one stored in plaintext.
+func (s *SQLiteUserStore) scan(row interface{ Scan(...interface{}) error }) (*User, *encryption.SealedSecret, error) {
+	user := &User{}
+	var secret, sealed, policies, parent, scope, tenant sql.NullString
+	err := row.Scan(&user.AccessKeyID, &user.Username, &secret, &sealed, &policies, &parent, &scope, &tenant, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("failed to read user: %w", err)
+	}
+
+	user.SecretAccessKey = secret.String
+	user.ParentAccessKeyID = parent.String
+	user.Tenant = tenant.String
+	if policies.Valid {
+		if err := json.Unmarshal([]byte(policies.String), &user.Policies); err != nil {
+			return nil, nil, fmt.Errorf("invalid policies of %s: %w", user.AccessKeyID, err)
+		}
+	}
+	if scope.Valid {
+		if err := json.Unmarshal([]byte(scope.String), &user.Scope); err != nil {
+			return nil, nil, fmt.Errorf("invalid scope of %s: %w", user.AccessKeyID, err)
+		}
+	}
+
+	var sealedSecret *encryption.SealedSecret
+	if sealed.Valid {
+		if err := json.Unmarshal([]byte(sealed.String), &sealedSecret); err != nil {
+			return nil, nil, fmt.Errorf("invalid sealed secret key of %s: %w", user.AccessKeyID, err)
+		}
+		user.SecretAccessKey, err = s.keys.OpenSecret(sealedSecret)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open secret key of %s: %w", user.AccessKeyID, err)
+		}
+	}
+	return user, sealedSecret, nil
+}
+
+func (s *SQLiteUserStore) Delete(accessKeyID string) error {
+	result, err := s.db.ExecWithRetry(context.Background(), "DELETE FROM users WHERE access_key_id = ?", accessKeyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteUserStore) List() ([]*User, error) {
+	users, _, err := s.list()
+	return users, err
+}
+
+// list returns every user, ordered by access key, with their sealed
+// secrets
+func (s *SQLiteUserStore) list() ([]*User, []*encryption.SealedSecret, error) {
+	rows, err := s.db.QueryContext(context.Background(),
+		"SELECT "+userColumns+" FROM users ORDER BY access_key_id")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	var sealed []*encryption.SealedSecret
+	for rows.Next() {
+		user, sealedSecret, err := s.scan(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		users = append(users, user)
+		sealed = append(sealed, sealedSecret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating users: %w", err)
+	}
+	return users, sealed, nil
+}
+
+// Reseal seals the secret keys still stored in plaintext, or sealed with a
+// master key version other than the active one, and returns how many users
+// were rewritten
+func (s *SQLiteUserStore) Reseal() (int, error) {
+	if s.keys == nil {
+		return 0, encryption.ErrNotConfigured
+	}
+	users, sealed, err := s.list()
+	if err != nil {
+		return 0, err
+	}
+
+	resealed := 0
+	active := s.keys.ActiveVersion()
+	for i, user := range users {
+		if sealed[i] != nil && sealed[i].KeyVersion == active {
+			continue
+		}
+		if err := s.Put(user); err != nil {
+			return resealed, err
+		}
+		resealed++
+	}
+	return resealed, nil
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/pkg/pathutil"
+)
+
+// ErrUserNotFound is returned when an access key is unknown
+var ErrUserNotFound = errors.New("user not found")
+
+// UserStore persists users and service accounts
+type UserStore interface {
+	Put(user *User) error
+	Get(accessKeyID string) (*User, error)
+	Delete(accessKeyID string) error
+	List() ([]*User, error)
+}
+
+// FileUserStore implements UserStore with one JSON file per user under
+// <metadataDir>/users. Files hold secrets and are created owner-only; with
+// a keyring set, secret keys are also sealed with the master key.
+type FileUserStore struct {
+	dir  string
+	keys *encryption.Keyring
+}
+
+// storedUser is the on-disk form of a user. The plaintext secret is
+// omitted once it is sealed.
+type storedUser struct {
+	*User
+	SecretAccessKey string                   `json:"secret_access_key,omitempty"`
+	SealedSecret    *encryption.SealedSecret `json:"sealed_secret_access_key,omitempty"`
+}
+
+// NewFileUserStore creates a file-based user store
+func NewFileUserStore(metadataDir string) (*FileUserStore, error) {
+	dir := filepath.Join(metadataDir, "users")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create users directory: %w", err)
+	}
+
+	return &FileUserStore{
+		dir: dir,
+	}, nil
+}
+
+// SetKeyring seals secret keys with the master key when users are written.
+// Users written before stay readable until they are sealed by Reseal.
+func (s *FileUserStore) SetKeyring(keys *encryption.Keyring) {
+	s.keys = keys
+}
+
+func (s *FileUserStore) path(accessKeyID string) string {
+	return filepath.Join(s.dir, pathutil.SanitizePath(accessKeyID)+".json")
+}
+
+func (s *FileUserStore) Put(user *User) error {
+	stored := storedUser{User: user, SecretAccessKey: user.SecretAccessKey}
+	if s.keys != nil {
+		sealed, err := s.keys.SealSecret(user.SecretAccessKey)
+		if err != nil {
+			return fmt.Errorf("failed to seal secret key: %w", err)
+		}
+		stored.SecretAccessKey = ""
+		stored.SealedSecret = sealed
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal user: %w", err)
+	}
+
+	// Write atomically (write to temp, then rename)
+	path := s.path(user.AccessKeyID)
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write user file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename user file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileUserStore) Get(accessKeyID string) (*User, error) {
+	data, err := os.ReadFile(s.path(accessKeyID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to read user file: %w", err)
+	}
+
+	stored, err := s.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if stored.AccessKeyID != accessKeyID {
+		return nil, ErrUserNotFound
+	}
+
+	return stored.User, nil
+}
+
+// decode reads a user file, opening a sealed secret key
+func (s *FileUserStore) decode(data []byte) (*storedUser, error) {
+	stored := storedUser{User: &User{}}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %w", err)
+	}
+	stored.User.SecretAccessKey = stored.SecretAccessKey
+	if stored.SealedSecret != nil {
+		secret, err := s.keys.OpenSecret(stored.SealedSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open secret key of %s: %w", stored.AccessKeyID, err)
+		}
+		stored.User.SecretAccessKey = secret
+	}
+	return &stored, nil
+}
+
+func (s *FileUserStore) Delete(accessKeyID string) error {
+	if err := os.Remove(s.path(accessKeyID)); err != nil {
+		if os.IsNotExist(err) {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to delete user file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileUserStore) List() ([]*User, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users directory: %w", err)
+	}
+
+	var users []*User
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			continue // Skip files we can't read
+		}
+
+		stored, err := s.decode(data)
+		if err != nil {
+			continue // Skip invalid user files
+		}
+		users = append(users, stored.User)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].AccessKeyID < users[j].AccessKeyID
+	})
+	return users, nil
+}
+
+// Reseal seals the secret keys still stored in plaintext, or sealed with a
+// master key version other than the active one, and returns how many users
+// were rewritten
+func (s *FileUserStore) Reseal() (int, error) {
+	if s.keys == nil {
+		return 0, encryption.ErrNotConfigured
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read users directory: %w", err)
+	}
+
+	resealed := 0
+	active := s.keys.ActiveVersion()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return resealed, fmt.Errorf("failed to read user file: %w", err)
+		}
+		stored, err := s.decode(data)
+		if err != nil {
+			return resealed, err
+		}
+		if stored.SealedSecret != nil && stored.SealedSecret.KeyVersion == active {
+			continue
+		}
+		if err := s.Put(stored.User); err != nil {
+			return resealed, err
+		}
+		resealed++
+	}
+	return resealed, nil
+}
+
+// MemoryUserStore implements UserStore in memory
+type MemoryUserStore struct {
+	users map[string]*User
+	mu    sync.RWMutex
+}
+
+// NewMemoryUserStore creates a new memory user store
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users: make(map[string]*User),
+	}
+}
+
+func (s *MemoryUserStore) Put(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.AccessKeyID] = user
+	return nil
+}
+
+func (s *MemoryUserStore) Get(accessKeyID string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[accessKeyID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryUserStore) Delete(accessKeyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[accessKeyID]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, accessKeyID)
+	return nil
+}
+
+func (s *MemoryUserStore) List() ([]*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].AccessKeyID < users[j].AccessKeyID
+	})
+	return users, nil
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/encryption"
+)
+
+func testKeyring(t *testing.T, versions ...int) *encryption.Keyring {
+	keys := make(map[int][]byte)
+	for _, v := range versions {
+		key := make([]byte, encryption.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys[v] = key
+	}
+	k, err := encryption.NewKeyring(keys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestFileUserStore_SealsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileUserStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetKeyring(testKeyring(t, 1))
+
+	user := &User{AccessKeyID: "AKID", SecretAccessKey: "very-secret", Username: "alice"}
+	if err := store.Put(user); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "users", "AKID.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "very-secret") {
+		t.Errorf("user file holds the plaintext secret: %s", data)
+	}
+
+	got, err := store.Get("AKID")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.SecretAccessKey != "very-secret" || got.Username != "alice" {
+		t.Errorf("Get = %+v", got)
+	}
+	users, err := store.List()
+	if err != nil || len(users) != 1 || users[0].SecretAccessKey != "very-secret" {
+		t.Errorf("List = %v, %v", users, err)
+	}
+
+	// Without the master key the secret cannot be read back
+	plain, _ := NewFileUserStore(dir)
+	if _, err := plain.Get("AKID"); err == nil {
+		t.Error("expected error reading a sealed secret without a keyring")
+	}
+}
+
+func TestFileUserStore_Reseal(t *testing.T) {
+	dir := t.TempDir()
+	plain, _ := NewFileUserStore(dir)
+	if err := plain.Put(&User{AccessKeyID: "legacy", SecretAccessKey: "old-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ := NewFileUserStore(dir)
+	store.SetKeyring(testKeyring(t, 1))
+	// Plaintext files written before encryption was enabled stay readable
+	if got, err := store.Get("legacy"); err != nil || got.SecretAccessKey != "old-secret" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	resealed, err := store.Reseal()
+	if err != nil || resealed != 1 {
+		t.Fatalf("Reseal = %d, %v, want 1", resealed, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "users", "legacy.json"))
+	if strings.Contains(string(data), "old-secret") {
+		t.Errorf("user file still holds the plaintext secret: %s", data)
+	}
+	if resealed, _ := store.Reseal(); resealed != 0 {
+		t.Errorf("second Reseal = %d, want 0", resealed)
+	}
+}
+
+func TestSQLiteUserStore_SealsAndReseals(t *testing.T) {
+	db, err := database.Open(database.Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	plain := NewSQLiteUserStore(db)
+	user := &User{
+		AccessKeyID:       "svc",
+		SecretAccessKey:   "old-secret",
+		Username:          "alice",
+		Policies:          []string{"readwrite"},
+		ParentAccessKeyID: "AKID",
+		Scope:             &Scope{Bucket: "photos", Actions: []Action{ActionRead}},
+	}
+	if err := plain.Put(user); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	store := NewSQLiteUserStore(db)
+	store.SetKeyring(testKeyring(t, 1))
+	got, err := store.Get("svc")
+	if err != nil || got.SecretAccessKey != "old-secret" || got.Scope == nil || got.Scope.Bucket != "photos" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	if resealed, err := store.Reseal(); err != nil || resealed != 1 {
+		t.Fatalf("Reseal = %d, %v, want 1", resealed, err)
+	}
+	var secret sql.NullString
+	if err := db.QueryRow("SELECT secret_access_key FROM users WHERE access_key_id = 'svc'").Scan(&secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Valid {
+		t.Errorf("plaintext secret still stored: %q", secret.String)
+	}
+	if got, err := store.Get("svc"); err != nil || got.SecretAccessKey != "old-secret" {
+		t.Errorf("Get after Reseal = %+v, %v", got, err)
+	}
+
+	if err := store.Delete("svc"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("svc"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Get after Delete error = %v, want ErrUserNotFound", err)
+	}
+}
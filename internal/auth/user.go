@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"slices"
 	"time"
 )
 
@@ -11,4 +12,20 @@ type User struct {
 	Username        string    `json:"username"`
 	Policies        []string  `json:"policies"`
 	CreatedAt       time.Time `json:"created_at"`
+
+	// Users of a tenant only reach the buckets of its namespace
+	Tenant string `json:"tenant,omitempty"`
+
+	// Service accounts are derived from a parent user and restricted to a scope
+	ParentAccessKeyID string `json:"parent_access_key_id,omitempty"`
+	Scope             *Scope `json:"scope,omitempty"`
+}
+
+// PolicyAdmin grants a user every operation, including managing other users
+const PolicyAdmin = "admin"
+
+// IsAdmin reports whether the user holds the admin policy. Scoped users
+// never do, whatever their policies say.
+func (u *User) IsAdmin() bool {
+	return u.Scope == nil && slices.Contains(u.Policies, PolicyAdmin)
 }
@@ -1,5 +1,158 @@
 package backup
 
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archivePrefix and archiveSuffix frame the names of backup archives
+const (
+	archivePrefix = "metadata-"
+	archiveSuffix = ".tar.gz"
+)
+
 // Backup handles backup operations
+// It snapshots the metadata directory into timestamped tar.gz archives
 type Backup struct {
+	sourceDir string
+	destDir   string
+	retain    int // Number of archives to keep (0 keeps all)
+}
+
+// NewBackup creates a metadata backup from sourceDir into destDir
+func NewBackup(sourceDir, destDir string, retain int) *Backup {
+	return &Backup{
+		sourceDir: sourceDir,
+		destDir:   destDir,
+		retain:    retain,
+	}
+}
+
+// Run writes a new archive and prunes old ones beyond the retention limit.
+// It returns the path of the archive written.
+func (b *Backup) Run(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(b.destDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	name := archivePrefix + time.Now().UTC().Format("20060102T150405Z") + archiveSuffix
+	path := filepath.Join(b.destDir, name)
+	tempPath := path + ".tmp"
+
+	if err := b.writeArchive(ctx, tempPath); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to rename backup archive: %w", err)
+	}
+
+	if err := b.prune(); err != nil {
+		return path, err
+	}
+
+	return path, nil
+}
+
+func (b *Backup) writeArchive(ctx context.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create backup archive: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(b.sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		// Skip in-flight temp files from atomic writes
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.sourceDir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		src, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed since the walk listed it
+			}
+			return err
+		}
+		defer src.Close()
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive metadata: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize tar stream: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finalize gzip stream: %w", err)
+	}
+	return f.Sync()
+}
+
+// prune removes the oldest archives beyond the retention limit
+func (b *Backup) prune() error {
+	if b.retain <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(b.destDir)
+	if err != nil {
+		return fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, archivePrefix) && strings.HasSuffix(name, archiveSuffix) {
+			archives = append(archives, name)
+		}
+	}
+
+	// Timestamped names sort chronologically
+	sort.Strings(archives)
+	for len(archives) > b.retain {
+		if err := os.Remove(filepath.Join(b.destDir, archives[0])); err != nil {
+			return fmt.Errorf("failed to prune backup archive: %w", err)
+		}
+		archives = archives[1:]
+	}
+
+	return nil
 }
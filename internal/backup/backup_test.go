@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackup_RunAndPrune(t *testing.T) {
+	src := t.TempDir()
+	dest := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(src, "objects", "bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "objects", "bucket", "key.meta"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBackup(src, dest, 2)
+
+	path, err := b.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(path), archivePrefix) {
+		t.Errorf("archive name = %s", filepath.Base(path))
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("archive missing or empty: %v", err)
+	}
+
+	// Seed older archives so pruning has something to remove
+	for _, name := range []string{"metadata-20000101T000000Z.tar.gz", "metadata-20000102T000000Z.tar.gz"} {
+		if err := os.WriteFile(filepath.Join(dest, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.prune(); err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+
+	entries, _ := os.ReadDir(dest)
+	if len(entries) != 2 {
+		t.Fatalf("archives after prune = %d, want 2", len(entries))
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("newest archive should be kept")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "metadata-20000101T000000Z.tar.gz")); !os.IsNotExist(err) {
+		t.Error("oldest archive should be pruned")
+	}
+}
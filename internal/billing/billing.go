@@ -0,0 +1,326 @@
+// Package billing turns the usage counters and storage samples into
+// monthly reports of what each bucket and owner stored and transferred,
+// for charging the cost of the cluster back to the teams using it.
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/usage"
+)
+
+// MonthLayout is how reports name their month
+const MonthLayout = "2006-01"
+
+var (
+	// ErrFutureMonth is returned for reports of months that have not begun
+	ErrFutureMonth = errors.New("month has not begun")
+	// ErrNoBucket is returned when publishing without a report bucket
+	ErrNoBucket = errors.New("no report bucket configured")
+)
+
+// Line is what one bucket, or all the buckets of an owner, stored and
+// transferred in a month
+type Line struct {
+	Bucket string `json:"bucket,omitempty"` // Empty on owner totals
+	Owner  string `json:"owner"`
+	// ByteHours adds up the bytes stored in each hour of the month
+	ByteHours    int64 `json:"storage_byte_hours"`
+	AverageBytes int64 `json:"average_bytes"`
+	usage.Counters
+}
+
+func (l *Line) add(other Line) {
+	l.ByteHours += other.ByteHours
+	l.Counters.Add(other.Counters)
+}
+
+// Report is the chargeback report of a month. Requests outside buckets,
+// such as listing them, are not charged to any bucket.
+type Report struct {
+	Month       string    `json:"month"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"` // The end of the month, or the current hour for the current month
+	GeneratedAt time.Time `json:"generated_at"`
+	Hours       int       `json:"hours"`
+	// SampledHours counts the hours with a storage sample. Hours without
+	// one, such as while the server was down, take the previous sample.
+	SampledHours int    `json:"sampled_hours"`
+	Buckets      []Line `json:"buckets"`
+	Owners       []Line `json:"owners"`
+}
+
+// Stored is a report file in the report bucket
+type Stored struct {
+	Month      string    `json:"month"`
+	Key        string    `json:"key"`
+	Format     string    `json:"format"` // json or csv
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// OwnerSource returns the current owner of each bucket, for buckets
+// that were not sampled in a month
+type OwnerSource func(ctx context.Context) (map[string]string, error)
+
+// Reporter generates chargeback reports and publishes them to a bucket
+type Reporter struct {
+	tracker *usage.Tracker
+	objects *object.Service
+	owners  OwnerSource
+	bucket  string
+	prefix  string
+	now     func() time.Time
+}
+
+// NewReporter creates a reporter of the usage counted by tracker,
+// publishing reports under prefix in bucket. bucket may be empty to only
+// generate reports.
+func NewReporter(tracker *usage.Tracker, objects *object.Service, owners OwnerSource, bucket, prefix string) *Reporter {
+	return &Reporter{
+		tracker: tracker,
+		objects: objects,
+		owners:  owners,
+		bucket:  bucket,
+		prefix:  prefix,
+		now:     time.Now,
+	}
+}
+
+// Bucket returns the bucket reports are published to
+func (r *Reporter) Bucket() string {
+	return r.bucket
+}
+
+// ParseMonth parses a month as YYYY-MM
+func ParseMonth(s string) (time.Time, error) {
+	month, err := time.Parse(MonthLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid month %q: expected YYYY-MM", s)
+	}
+	return month, nil
+}
+
+// PreviousMonth returns the month before the one of t
+func PreviousMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+}
+
+// Generate computes the report of the month starting at month
+func (r *Reporter) Generate(ctx context.Context, month time.Time) (*Report, error) {
+	now := r.now().UTC()
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if from.After(now) {
+		return nil, ErrFutureMonth
+	}
+	to := from.AddDate(0, 1, 0)
+	if current := now.Truncate(time.Hour).Add(time.Hour); current.Before(to) {
+		to = current
+	}
+
+	// A day of samples before the month carries the storage of its first
+	// hours should they not be sampled
+	samples, err := r.tracker.QueryStorage(from.Add(-24*time.Hour), to)
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.tracker.Query(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Month:       from.Format(MonthLayout),
+		From:        from,
+		To:          to,
+		GeneratedAt: now,
+		Hours:       int(to.Sub(from) / time.Hour),
+	}
+	lines := make(map[string]*Line)
+	line := func(bucket string) *Line {
+		l, ok := lines[bucket]
+		if !ok {
+			l = &Line{Bucket: bucket}
+			lines[bucket] = l
+		}
+		return l
+	}
+
+	// Samples come by hour: each hour takes the last sampled one
+	var current []usage.StorageSample
+	next := 0
+	for hour := from; hour.Before(to); hour = hour.Add(time.Hour) {
+		for next < len(samples) && !samples[next].Hour.After(hour) {
+			start := next
+			for next < len(samples) && samples[next].Hour.Equal(samples[start].Hour) {
+				next++
+			}
+			current = samples[start:next]
+			if samples[start].Hour.Equal(hour) {
+				report.SampledHours++
+			}
+		}
+		for _, s := range current {
+			l := line(s.Bucket)
+			l.ByteHours += s.Bytes
+			if s.Owner != "" {
+				l.Owner = s.Owner
+			}
+		}
+	}
+
+	for _, rec := range records {
+		if rec.Bucket == "" {
+			continue
+		}
+		line(rec.Bucket).Counters.Add(rec.Counters)
+	}
+
+	var owners map[string]string
+	for _, l := range lines {
+		if l.Owner != "" || r.owners == nil {
+			continue
+		}
+		if owners == nil {
+			if owners, err = r.owners(ctx); err != nil {
+				return nil, fmt.Errorf("failed to get bucket owners: %w", err)
+			}
+			if owners == nil {
+				owners = map[string]string{}
+			}
+		}
+		l.Owner = owners[l.Bucket]
+	}
+
+	totals := make(map[string]*Line)
+	for _, l := range lines {
+		if report.Hours > 0 {
+			l.AverageBytes = l.ByteHours / int64(report.Hours)
+		}
+		report.Buckets = append(report.Buckets, *l)
+
+		t, ok := totals[l.Owner]
+		if !ok {
+			t = &Line{Owner: l.Owner}
+			totals[l.Owner] = t
+		}
+		t.add(*l)
+	}
+	for _, t := range totals {
+		if report.Hours > 0 {
+			t.AverageBytes = t.ByteHours / int64(report.Hours)
+		}
+		report.Owners = append(report.Owners, *t)
+	}
+	sort.Slice(report.Buckets, func(i, j int) bool { return report.Buckets[i].Bucket < report.Buckets[j].Bucket })
+	sort.Slice(report.Owners, func(i, j int) bool { return report.Owners[i].Owner < report.Owners[j].Owner })
+	if report.Buckets == nil {
+		report.Buckets = []Line{}
+		report.Owners = []Line{}
+	}
+	return report, nil
+}
+
+// WriteCSV writes a report as CSV, one row per bucket followed by one row
+// per owner with an empty bucket
+func WriteCSV(w io.Writer, report *Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "owner", "bucket", "storage_byte_hours", "average_bytes",
+		"requests", "client_errors", "server_errors", "bytes_in", "bytes_out"})
+	for _, lines := range [][]Line{report.Buckets, report.Owners} {
+		for _, l := range lines {
+			cw.Write([]string{
+				report.Month,
+				l.Owner,
+				l.Bucket,
+				strconv.FormatInt(l.ByteHours, 10),
+				strconv.FormatInt(l.AverageBytes, 10),
+				strconv.FormatInt(l.Requests, 10),
+				strconv.FormatInt(l.ClientErrors, 10),
+				strconv.FormatInt(l.ServerErrors, 10),
+				strconv.FormatInt(l.BytesIn, 10),
+				strconv.FormatInt(l.BytesOut, 10),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Publish writes a report to the report bucket as <prefix><month>.json
+// and <prefix><month>.csv, replacing those of an earlier run
+func (r *Reporter) Publish(ctx context.Context, report *Report) error {
+	if r.bucket == "" {
+		return ErrNoBucket
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	key := r.prefix + report.Month
+	if _, err := r.objects.PutObject(ctx, r.bucket, key+".json", bytes.NewReader(data), int64(len(data)), "application/json"); err != nil {
+		return fmt.Errorf("failed to write %s.json: %w", key, err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, report); err != nil {
+		return fmt.Errorf("failed to write report CSV: %w", err)
+	}
+	if _, err := r.objects.PutObject(ctx, r.bucket, key+".csv", &buf, int64(buf.Len()), "text/csv"); err != nil {
+		return fmt.Errorf("failed to write %s.csv: %w", key, err)
+	}
+	return nil
+}
+
+// List returns the reports published to the report bucket, by month and
+// format
+func (r *Reporter) List(ctx context.Context) ([]Stored, error) {
+	if r.bucket == "" {
+		return nil, ErrNoBucket
+	}
+
+	stored := []Stored{}
+	err := r.objects.WalkObjects(ctx, r.bucket, r.prefix, "", func(obj *object.Object) error {
+		name := strings.TrimPrefix(obj.Key, r.prefix)
+		ext := path.Ext(name)
+		if ext != ".json" && ext != ".csv" {
+			return nil
+		}
+		month := strings.TrimSuffix(name, ext)
+		if _, err := time.Parse(MonthLayout, month); err != nil {
+			return nil
+		}
+		stored = append(stored, Stored{
+			Month:      month,
+			Key:        obj.Key,
+			Format:     ext[1:],
+			Size:       obj.Size,
+			ModifiedAt: obj.ModifiedAt,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].Month != stored[j].Month {
+			return stored[i].Month < stored[j].Month
+		}
+		return stored[i].Format > stored[j].Format
+	})
+	return stored, nil
+}
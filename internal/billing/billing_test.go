@@ -0,0 +1,109 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/usage"
+)
+
+func TestReporter_Generate(t *testing.T) {
+	dir := t.TempDir()
+	tracker, err := usage.NewTracker(dir, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last sample of February carries into March until the first
+	// sample of March, at 02:00; "old" is deleted at 03:00
+	write := func(name, lines string) {
+		if err := os.WriteFile(filepath.Join(dir, "usage", name), []byte(lines), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("storage/2026-02-28.jsonl", `{"hour":"2026-02-28T23:00:00Z","bucket":"logs","owner":"team-a","bytes":100}
+{"hour":"2026-02-28T23:00:00Z","bucket":"old","owner":"team-b","bytes":50}
+`)
+	write("storage/2026-03-01.jsonl", `{"hour":"2026-03-01T02:00:00Z","bucket":"logs","owner":"team-a","bytes":200}
+{"hour":"2026-03-01T02:00:00Z","bucket":"old","owner":"team-b","bytes":50}
+{"hour":"2026-03-01T03:00:00Z","bucket":"logs","owner":"team-a","bytes":200}
+`)
+	write("2026-03-01.jsonl", `{"hour":"2026-03-01T01:00:00Z","access_key":"k1","bucket":"logs","requests":3,"bytes_in":30,"bytes_out":5}
+{"hour":"2026-03-01T01:00:00Z","access_key":"k2","bucket":"logs","requests":1,"bytes_out":7}
+{"hour":"2026-03-01T02:00:00Z","access_key":"k1","bucket":"media","requests":2,"bytes_in":20}
+{"hour":"2026-03-01T02:00:00Z","access_key":"k1","requests":9}
+`)
+
+	owners := func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{"media": "team-b"}, nil
+	}
+	reporter := NewReporter(tracker, nil, owners, "", "reports/")
+	// Four hours into March
+	reporter.now = func() time.Time { return time.Date(2026, 3, 1, 3, 30, 0, 0, time.UTC) }
+
+	month, err := ParseMonth("2026-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := reporter.Generate(context.Background(), month)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if report.Month != "2026-03" || report.Hours != 4 || report.SampledHours != 2 {
+		t.Errorf("report = %s over %d hours, %d sampled, want 2026-03 over 4 hours, 2 sampled",
+			report.Month, report.Hours, report.SampledHours)
+	}
+
+	want := []Line{
+		{Bucket: "logs", Owner: "team-a", ByteHours: 100 + 100 + 200 + 200, AverageBytes: 150,
+			Counters: usage.Counters{Requests: 4, BytesIn: 30, BytesOut: 12}},
+		{Bucket: "media", Owner: "team-b", Counters: usage.Counters{Requests: 2, BytesIn: 20}},
+		{Bucket: "old", Owner: "team-b", ByteHours: 50 + 50 + 50, AverageBytes: 37},
+	}
+	if len(report.Buckets) != len(want) {
+		t.Fatalf("buckets = %+v, want %+v", report.Buckets, want)
+	}
+	for i := range want {
+		if report.Buckets[i] != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, report.Buckets[i], want[i])
+		}
+	}
+	wantOwners := []Line{
+		{Owner: "team-a", ByteHours: 600, AverageBytes: 150, Counters: usage.Counters{Requests: 4, BytesIn: 30, BytesOut: 12}},
+		{Owner: "team-b", ByteHours: 150, AverageBytes: 37, Counters: usage.Counters{Requests: 2, BytesIn: 20}},
+	}
+	if len(report.Owners) != len(wantOwners) || report.Owners[0] != wantOwners[0] || report.Owners[1] != wantOwners[1] {
+		t.Errorf("owners = %+v, want %+v", report.Owners, wantOwners)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 || rows[0][3] != "storage_byte_hours" || rows[1][2] != "logs" || rows[1][3] != "600" ||
+		rows[4][1] != "team-a" || rows[4][2] != "" {
+		t.Errorf("CSV = %q", rows)
+	}
+
+	if _, err := reporter.Generate(context.Background(), month.AddDate(0, 1, 0)); err != ErrFutureMonth {
+		t.Errorf("Generate() of next month error = %v, want ErrFutureMonth", err)
+	}
+	if err := reporter.Publish(context.Background(), report); err != ErrNoBucket {
+		t.Errorf("Publish() without a bucket error = %v, want ErrNoBucket", err)
+	}
+}
+
+func TestPreviousMonth(t *testing.T) {
+	got := PreviousMonth(time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC))
+	if want := time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("PreviousMonth() = %v, want %v", got, want)
+	}
+}
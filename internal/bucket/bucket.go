@@ -2,6 +2,8 @@ package bucket
 
 import (
 	"time"
+
+	"github.com/danielino/comio/internal/auth"
 )
 
 // VersioningStatus defines the versioning state of a bucket
@@ -20,10 +22,27 @@ type Bucket struct {
 	Owner      string           `json:"owner"`
 	Versioning VersioningStatus `json:"versioning"`
 	Lifecycle  []LifecycleRule  `json:"lifecycle,omitempty"`
+	Policy     *auth.Policy     `json:"policy,omitempty"`
+	Quota      *Quota           `json:"quota,omitempty"`
+	// Checksum algorithms computed for new objects, the first stored with
+	// them; empty uses the server's
+	ChecksumAlgorithms []string `json:"checksum_algorithms,omitempty"`
+	// Objects of the bucket are not replicated while set
+	ReplicationDisabled bool `json:"replication_disabled,omitempty"`
+}
+
+// Quota limits what a bucket may hold. A zero limit is unlimited.
+type Quota struct {
+	MaxSize    int64 `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+	MaxObjects int64 `json:"max_objects,omitempty" yaml:"max_objects,omitempty"`
 }
 
-// LifecycleRule represents a lifecycle policy rule
+// LifecycleRule represents a lifecycle policy rule. Enabled rules expire
+// the objects under Prefix, all of them if empty, ExpirationDays after
+// they were last written; 0 never expires them.
 type LifecycleRule struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
+	ID             string `json:"id" yaml:"id"`
+	Status         string `json:"status" yaml:"status"`
+	Prefix         string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	ExpirationDays int    `json:"expiration_days,omitempty" yaml:"expiration_days,omitempty"`
 }
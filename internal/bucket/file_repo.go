@@ -10,13 +10,25 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/danielino/comio/internal/metafile"
 	"github.com/danielino/comio/pkg/pathutil"
 )
 
+// metaFormat is the format of bucket metadata files. Version 2 added the
+// schema version and version 3 the file checksum.
+var metaFormat = metafile.Format{
+	Upgrades: []metafile.Upgrade{
+		func(fields map[string]json.RawMessage) error { return nil },
+		func(fields map[string]json.RawMessage) error { return nil },
+	},
+}
+
 // FileRepository implements Repository using filesystem metadata files
 type FileRepository struct {
 	metadataDir string
 	mu          sync.RWMutex
+	quarantine  *metafile.Quarantine // Where corrupt metadata files are moved
+	writer      *metafile.Writer
 }
 
 // NewFileRepository creates a new file-based repository
@@ -29,9 +41,29 @@ func NewFileRepository(metadataDir string) (*FileRepository, error) {
 
 	return &FileRepository{
 		metadataDir: metadataDir,
+		quarantine:  metafile.NewQuarantine(metadataDir),
+		writer:      metafile.NewWriter(metafile.DurabilityFull),
 	}, nil
 }
 
+// SetDurability sets how much of each metadata write is synced to disk
+// before it returns. It must be called before the repository is used.
+func (r *FileRepository) SetDurability(durability metafile.Durability) {
+	r.writer.Durability = durability
+}
+
+// decodeMeta decodes the metadata file at path into bucket, quarantining
+// it if it is corrupt
+func (r *FileRepository) decodeMeta(path string, metaData []byte, bucket *Bucket) error {
+	_, err := metaFormat.Unmarshal(metaData, bucket)
+	if errors.Is(err, metafile.ErrCorrupt) {
+		if qErr := r.quarantine.Add(path, metaData, err); qErr != nil {
+			return fmt.Errorf("%w (%v)", err, qErr)
+		}
+	}
+	return err
+}
+
 // getBucketMetaPath returns the path to a bucket's metadata file
 func (r *FileRepository) getBucketMetaPath(name string) string {
 	safeName := pathutil.SanitizePath(name)
@@ -46,27 +78,16 @@ func (r *FileRepository) Create(ctx context.Context, bucket *Bucket) error {
 
 	// Check if bucket already exists
 	if _, err := os.Stat(metaPath); err == nil {
-		return errors.New("bucket already exists")
+		return ErrBucketExists
 	}
 
-	// Marshal bucket metadata to JSON
-	metaData, err := json.MarshalIndent(bucket, "", "  ")
+	metaData, err := metaFormat.Marshal(bucket)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
 	// Write metadata file atomically
-	tempPath := metaPath + ".tmp"
-	if err := os.WriteFile(tempPath, metaData, 0644); err != nil {
-		return fmt.Errorf("failed to write metadata file: %w", err)
-	}
-
-	if err := os.Rename(tempPath, metaPath); err != nil {
-		os.Remove(tempPath)
-		return fmt.Errorf("failed to rename metadata file: %w", err)
-	}
-
-	return nil
+	return r.writer.WriteFile(metaPath, metaData)
 }
 
 func (r *FileRepository) Get(ctx context.Context, name string) (*Bucket, error) {
@@ -79,14 +100,14 @@ func (r *FileRepository) Get(ctx context.Context, name string) (*Bucket, error)
 	metaData, err := os.ReadFile(metaPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, errors.New("bucket not found")
+			return nil, ErrBucketNotFound
 		}
 		return nil, fmt.Errorf("failed to read metadata: %w", err)
 	}
 
 	// Unmarshal metadata
 	var bucket Bucket
-	if err := json.Unmarshal(metaData, &bucket); err != nil {
+	if err := r.decodeMeta(metaPath, metaData, &bucket); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
 	}
 
@@ -121,8 +142,8 @@ func (r *FileRepository) List(ctx context.Context, owner string) ([]*Bucket, err
 		}
 
 		var bucket Bucket
-		if err := json.Unmarshal(metaData, &bucket); err != nil {
-			continue // Skip invalid metadata
+		if err := r.decodeMeta(metaPath, metaData, &bucket); err != nil {
+			continue // Quarantined, or written by a newer version
 		}
 
 		// Filter by owner if specified
@@ -142,9 +163,9 @@ func (r *FileRepository) Delete(ctx context.Context, name string) error {
 
 	metaPath := r.getBucketMetaPath(name)
 
-	if err := os.Remove(metaPath); err != nil {
+	if err := r.writer.Remove(metaPath); err != nil {
 		if os.IsNotExist(err) {
-			return errors.New("bucket not found")
+			return ErrBucketNotFound
 		}
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
@@ -160,25 +181,74 @@ func (r *FileRepository) Update(ctx context.Context, bucket *Bucket) error {
 
 	// Check if bucket exists
 	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
-		return errors.New("bucket not found")
+		return ErrBucketNotFound
 	}
 
-	// Marshal bucket metadata to JSON
-	metaData, err := json.MarshalIndent(bucket, "", "  ")
+	metaData, err := metaFormat.Marshal(bucket)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
 	// Write metadata file atomically
-	tempPath := metaPath + ".tmp"
-	if err := os.WriteFile(tempPath, metaData, 0644); err != nil {
-		return fmt.Errorf("failed to write metadata file: %w", err)
-	}
+	return r.writer.WriteFile(metaPath, metaData)
+}
+
+// UpgradeMetadata rewrites the metadata files written with an older schema
+// version in the current one. With dryRun set, files are only counted.
+func (r *FileRepository) UpgradeMetadata(ctx context.Context, dryRun bool) (metafile.UpgradeResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if err := os.Rename(tempPath, metaPath); err != nil {
-		os.Remove(tempPath)
-		return fmt.Errorf("failed to rename metadata file: %w", err)
+	var result metafile.UpgradeResult
+	bucketsDir := filepath.Join(r.metadataDir, "buckets")
+	entries, err := os.ReadDir(bucketsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, fmt.Errorf("failed to read buckets directory: %w", err)
 	}
 
-	return nil
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		metaPath := filepath.Join(bucketsDir, entry.Name())
+		metaData, err := os.ReadFile(metaPath)
+		if err != nil {
+			return result, fmt.Errorf("failed to read metadata: %w", err)
+		}
+		result.Scanned++
+
+		var bucket Bucket
+		upgraded, err := metaFormat.Unmarshal(metaData, &bucket)
+		if err != nil {
+			result.Invalid = append(result.Invalid, metaPath)
+			if errors.Is(err, metafile.ErrCorrupt) && !dryRun {
+				if err := r.quarantine.Add(metaPath, metaData, err); err != nil {
+					return result, err
+				}
+			}
+			continue
+		}
+		if !upgraded {
+			continue
+		}
+		result.Upgraded++
+		if dryRun {
+			continue
+		}
+
+		if metaData, err = metaFormat.Marshal(&bucket); err != nil {
+			return result, fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+		if err := r.writer.WriteFile(metaPath, metaData); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
 }
@@ -2,7 +2,6 @@ package bucket
 
 import (
 	"context"
-	"errors"
 	"sync"
 )
 
@@ -24,7 +23,7 @@ func (r *MemoryRepository) Create(ctx context.Context, bucket *Bucket) error {
 	defer r.mu.Unlock()
 
 	if _, exists := r.buckets[bucket.Name]; exists {
-		return errors.New("bucket already exists")
+		return ErrBucketExists
 	}
 
 	r.buckets[bucket.Name] = bucket
@@ -37,7 +36,7 @@ func (r *MemoryRepository) Get(ctx context.Context, name string) (*Bucket, error
 
 	bucket, exists := r.buckets[name]
 	if !exists {
-		return nil, errors.New("bucket not found")
+		return nil, ErrBucketNotFound
 	}
 
 	return bucket, nil
@@ -62,7 +61,7 @@ func (r *MemoryRepository) Delete(ctx context.Context, name string) error {
 	defer r.mu.Unlock()
 
 	if _, exists := r.buckets[name]; !exists {
-		return errors.New("bucket not found")
+		return ErrBucketNotFound
 	}
 
 	delete(r.buckets, name)
@@ -74,7 +73,7 @@ func (r *MemoryRepository) Update(ctx context.Context, bucket *Bucket) error {
 	defer r.mu.Unlock()
 
 	if _, exists := r.buckets[bucket.Name]; !exists {
-		return errors.New("bucket not found")
+		return ErrBucketNotFound
 	}
 
 	r.buckets[bucket.Name] = bucket
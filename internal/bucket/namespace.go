@@ -0,0 +1,134 @@
+package bucket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danielino/comio/pkg/pathutil"
+)
+
+// ErrNamespaceConflict is returned when a bucket name is already claimed
+// in the cluster with different settings
+var ErrNamespaceConflict = errors.New("bucket name is claimed in the cluster with different settings")
+
+// Claim records which node created a bucket, and with which settings
+type Claim struct {
+	Name       string           `json:"name"`
+	NodeID     string           `json:"node_id"`
+	Owner      string           `json:"owner"`
+	Versioning VersioningStatus `json:"versioning"`
+	ClaimedAt  time.Time        `json:"claimed_at"`
+}
+
+// sameSettings reports whether two claims describe the same bucket
+func (c *Claim) sameSettings(other *Claim) bool {
+	return c.Owner == other.Owner && c.Versioning == other.Versioning
+}
+
+// Namespace coordinates bucket names across the nodes of a cluster, so two
+// nodes cannot create the same bucket with diverging settings
+type Namespace interface {
+	// Claim reserves a bucket name cluster-wide. Claiming a name already
+	// claimed with the same settings succeeds; otherwise it returns
+	// ErrNamespaceConflict.
+	Claim(ctx context.Context, claim *Claim) error
+	// Release frees a name claimed by nodeID
+	Release(ctx context.Context, name, nodeID string) error
+}
+
+// FileNamespace is a Namespace kept in a directory shared by all nodes,
+// e.g. an NFS mount. Claims are created with a hard link, which fails if
+// the name exists, so concurrent claims are resolved compare-and-swap
+// style without a leader.
+type FileNamespace struct {
+	dir string
+}
+
+// NewFileNamespace creates a namespace in the shared directory dir
+func NewFileNamespace(dir string) (*FileNamespace, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create namespace directory: %w", err)
+	}
+	return &FileNamespace{dir: dir}, nil
+}
+
+func (n *FileNamespace) claimPath(name string) string {
+	return filepath.Join(n.dir, pathutil.SanitizePath(name)+".json")
+}
+
+// Claim implements Namespace
+func (n *FileNamespace) Claim(ctx context.Context, claim *Claim) error {
+	data, err := json.MarshalIndent(claim, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal claim: %w", err)
+	}
+
+	// Write the claim fully before publishing it, so other nodes never
+	// read a partial claim
+	tmp, err := os.CreateTemp(n.dir, ".claim-*")
+	if err != nil {
+		return fmt.Errorf("failed to create claim: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write claim: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync claim: %w", err)
+	}
+	tmp.Close()
+
+	err = os.Link(tmp.Name(), n.claimPath(claim.Name))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to publish claim: %w", err)
+	}
+
+	existing, err := n.get(claim.Name)
+	if err != nil {
+		return err
+	}
+	if !existing.sameSettings(claim) {
+		return fmt.Errorf("%w: %s was created by node %s", ErrNamespaceConflict, claim.Name, existing.NodeID)
+	}
+	return nil
+}
+
+// Release implements Namespace. Claims of other nodes are left in place.
+func (n *FileNamespace) Release(ctx context.Context, name, nodeID string) error {
+	existing, err := n.get(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.NodeID != nodeID {
+		return nil
+	}
+	if err := os.Remove(n.claimPath(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to release claim: %w", err)
+	}
+	return nil
+}
+
+func (n *FileNamespace) get(name string) (*Claim, error) {
+	data, err := os.ReadFile(n.claimPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claim: %w", err)
+	}
+	var claim Claim
+	if err := json.Unmarshal(data, &claim); err != nil {
+		return nil, fmt.Errorf("failed to parse claim: %w", err)
+	}
+	return &claim, nil
+}
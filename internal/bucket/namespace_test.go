@@ -0,0 +1,79 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestFileNamespace_TwoNodes(t *testing.T) {
+	ctx := context.Background()
+	namespace, err := NewFileNamespace(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileNamespace() error = %v", err)
+	}
+
+	nodeA := NewService(NewMemoryRepository())
+	nodeA.SetNamespace(namespace, "node-a")
+	nodeB := NewService(NewMemoryRepository())
+	nodeB.SetNamespace(namespace, "node-b")
+
+	if err := nodeA.CreateBucket(ctx, "shared", "alice"); err != nil {
+		t.Fatalf("CreateBucket() on node A error = %v", err)
+	}
+
+	// Same bucket with different settings on another node
+	if err := nodeB.CreateBucket(ctx, "shared", "bob"); !errors.Is(err, ErrNamespaceConflict) {
+		t.Errorf("CreateBucket() on node B error = %v, want ErrNamespaceConflict", err)
+	}
+	// The same settings converge
+	if err := nodeB.CreateBucket(ctx, "shared", "alice"); err != nil {
+		t.Errorf("CreateBucket() with matching settings error = %v", err)
+	}
+
+	// Only the claiming node releases the name
+	if err := nodeB.DeleteBucket(ctx, "shared"); err != nil {
+		t.Fatalf("DeleteBucket() on node B error = %v", err)
+	}
+	if err := nodeB.CreateBucket(ctx, "shared", "bob"); !errors.Is(err, ErrNamespaceConflict) {
+		t.Errorf("claim released by node B, error = %v", err)
+	}
+
+	if err := nodeA.DeleteBucket(ctx, "shared"); err != nil {
+		t.Fatalf("DeleteBucket() on node A error = %v", err)
+	}
+	if err := nodeB.CreateBucket(ctx, "shared", "bob"); err != nil {
+		t.Errorf("CreateBucket() after release error = %v", err)
+	}
+}
+
+func TestFileNamespace_ConcurrentClaims(t *testing.T) {
+	ctx := context.Background()
+	namespace, _ := NewFileNamespace(t.TempDir())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every node wants the bucket with its own owner
+			errs[i] = namespace.Claim(ctx, &Claim{Name: "race", NodeID: "node", Owner: string(rune('a' + i))})
+		}(i)
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrNamespaceConflict):
+			t.Errorf("Claim() error = %v", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d claims won, want exactly 1", won)
+	}
+}
@@ -0,0 +1,254 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultReservationTTL is how long a reservation holds its space when
+// no TTL is asked for
+const DefaultReservationTTL = 24 * time.Hour
+
+var (
+	// ErrReservationNotFound is returned for unknown or expired reservation tokens
+	ErrReservationNotFound = errors.New("reservation not found")
+	// ErrReservationExceeded is returned when a write needs more than is
+	// left of its reservation
+	ErrReservationExceeded = errors.New("write exceeds the space left in the reservation")
+	// ErrInvalidReservation is returned for reservations of no bytes
+	ErrInvalidReservation = errors.New("reservation size must be positive")
+	// ErrInsufficientCapacity is returned when the storage cannot hold a
+	// reservation, or a write once the space reserved is set aside
+	ErrInsufficientCapacity = errors.New("insufficient storage capacity")
+)
+
+// CapacityFunc returns how many bytes the storage can still take
+type CapacityFunc func() int64
+
+// Reservation sets space aside in a bucket for a batch of writes, so an
+// ingestion job fails before it starts rather than halfway through when
+// the bucket's quota or the storage runs out
+type Reservation struct {
+	Token     string    `json:"token"`
+	Bucket    string    `json:"bucket"`
+	Size      int64     `json:"size"` // Bytes reserved
+	Used      int64     `json:"used"` // Bytes written with the token
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Remaining returns the bytes still held for writes with the token
+func (r *Reservation) Remaining() int64 {
+	return r.Size - r.Used
+}
+
+// reservations tracks the reservations of a node. They are kept in memory:
+// a restart releases them.
+type reservations struct {
+	mu     sync.Mutex
+	tokens map[string]*Reservation
+}
+
+func newReservations() *reservations {
+	return &reservations{tokens: make(map[string]*Reservation)}
+}
+
+// expireLocked drops reservations past their expiry
+func (r *reservations) expireLocked(now time.Time) {
+	for token, res := range r.tokens {
+		if now.After(res.ExpiresAt) {
+			delete(r.tokens, token)
+		}
+	}
+}
+
+// heldLocked returns the bytes still reserved in bucket, or in every
+// bucket when bucket is empty
+func (r *reservations) heldLocked(bucket string) int64 {
+	var held int64
+	for _, res := range r.tokens {
+		if bucket == "" || res.Bucket == bucket {
+			held += res.Remaining()
+		}
+	}
+	return held
+}
+
+// heldByTenantLocked returns the bytes still reserved in the buckets of a
+// tenant
+func (r *reservations) heldByTenantLocked(tenant string) int64 {
+	var held int64
+	for _, res := range r.tokens {
+		if t, _ := SplitName(res.Bucket); t == tenant {
+			held += res.Remaining()
+		}
+	}
+	return held
+}
+
+// heldByTenant returns the bytes still reserved in the buckets of a tenant
+func (r *reservations) heldByTenant(tenant string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(time.Now())
+	return r.heldByTenantLocked(tenant)
+}
+
+// held returns the bytes still reserved in bucket and in every bucket
+func (r *reservations) held(bucket string) (int64, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked(time.Now())
+	return r.heldLocked(bucket), r.heldLocked("")
+}
+
+// SetCapacity makes reservations, and the quota checks of writes, account
+// for the space left on the storage
+func (s *Service) SetCapacity(capacity CapacityFunc) {
+	s.capacity = capacity
+}
+
+// Reserve sets size bytes aside in a bucket for ttl, DefaultReservationTTL
+// if zero. It fails with ErrQuotaExceeded if the bucket's quota, or its
+// tenant's, cannot hold them next to its objects and other reservations,
+// and with ErrInsufficientCapacity if the storage cannot.
+func (s *Service) Reserve(ctx context.Context, name string, size int64, ttl time.Duration) (*Reservation, error) {
+	if size <= 0 {
+		return nil, ErrInvalidReservation
+	}
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+	bucket, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var used int64
+	if bucket.Quota != nil && bucket.Quota.MaxSize > 0 && s.objectCounter != nil {
+		if _, used, err = s.objectCounter.Count(ctx, name); err != nil {
+			return nil, fmt.Errorf("failed to check quota of bucket %q: %w", name, err)
+		}
+	}
+	tenant, _ := SplitName(name)
+	tenantQuota := s.tenantQuotas[tenant]
+	var tenantUsed int64
+	if tenant != "" && tenantQuota.MaxSize > 0 && s.objectCounter != nil {
+		if _, tenantUsed, err = s.tenantUsage(ctx, tenant); err != nil {
+			return nil, err
+		}
+	}
+
+	s.reservations.mu.Lock()
+	defer s.reservations.mu.Unlock()
+
+	now := time.Now()
+	s.reservations.expireLocked(now)
+	if bucket.Quota != nil && bucket.Quota.MaxSize > 0 {
+		held := s.reservations.heldLocked(name)
+		if q := bucket.Quota.MaxSize; used+held+size > q {
+			return nil, fmt.Errorf("%w: %q holds %d and has %d reserved of %d bytes", ErrQuotaExceeded, name, used, held, q)
+		}
+	}
+	if tenant != "" && tenantQuota.MaxSize > 0 {
+		held := s.reservations.heldByTenantLocked(tenant)
+		if q := tenantQuota.MaxSize; tenantUsed+held+size > q {
+			return nil, fmt.Errorf("%w: tenant %q holds %d and has %d reserved of %d bytes", ErrQuotaExceeded, tenant, tenantUsed, held, q)
+		}
+	}
+	if s.capacity != nil {
+		free, held := s.capacity(), s.reservations.heldLocked("")
+		if held+size > free {
+			return nil, fmt.Errorf("%w: %d bytes free, %d of them reserved", ErrInsufficientCapacity, free, held)
+		}
+	}
+
+	res := &Reservation{
+		Token:     uuid.New().String(),
+		Bucket:    name,
+		Size:      size,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	s.reservations.tokens[res.Token] = res
+	c := *res
+	return &c, nil
+}
+
+// GetReservation returns a reservation by its token
+func (s *Service) GetReservation(token string) (*Reservation, error) {
+	s.reservations.mu.Lock()
+	defer s.reservations.mu.Unlock()
+
+	s.reservations.expireLocked(time.Now())
+	res, ok := s.reservations.tokens[token]
+	if !ok {
+		return nil, ErrReservationNotFound
+	}
+	c := *res
+	return &c, nil
+}
+
+// ListReservations returns the reservations of a bucket, or of every
+// bucket when name is empty
+func (s *Service) ListReservations(name string) []*Reservation {
+	s.reservations.mu.Lock()
+	defer s.reservations.mu.Unlock()
+
+	s.reservations.expireLocked(time.Now())
+	list := make([]*Reservation, 0, len(s.reservations.tokens))
+	for _, res := range s.reservations.tokens {
+		if name == "" || res.Bucket == name {
+			c := *res
+			list = append(list, &c)
+		}
+	}
+	return list
+}
+
+// ReleaseReservation gives back what is left of a reservation
+func (s *Service) ReleaseReservation(token string) error {
+	s.reservations.mu.Lock()
+	defer s.reservations.mu.Unlock()
+
+	if _, ok := s.reservations.tokens[token]; !ok {
+		return ErrReservationNotFound
+	}
+	delete(s.reservations.tokens, token)
+	return nil
+}
+
+// UseReservation takes size bytes of a reservation of bucket for a write,
+// in place of CheckQuota. If the write then fails, RefundReservation gives
+// them back.
+func (s *Service) UseReservation(token, bucket string, size int64) error {
+	s.reservations.mu.Lock()
+	defer s.reservations.mu.Unlock()
+
+	s.reservations.expireLocked(time.Now())
+	res, ok := s.reservations.tokens[token]
+	if !ok || res.Bucket != bucket {
+		return ErrReservationNotFound
+	}
+	if size > res.Remaining() {
+		return fmt.Errorf("%w: %d bytes left, %d needed", ErrReservationExceeded, res.Remaining(), size)
+	}
+	res.Used += size
+	return nil
+}
+
+// RefundReservation gives back bytes taken by UseReservation for a write
+// that failed
+func (s *Service) RefundReservation(token string, size int64) {
+	s.reservations.mu.Lock()
+	defer s.reservations.mu.Unlock()
+
+	if res, ok := s.reservations.tokens[token]; ok {
+		res.Used = max(res.Used-size, 0)
+	}
+}
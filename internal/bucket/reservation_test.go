@@ -0,0 +1,144 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucketService_Reserve(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	service.SetObjectCounter(fixedCounter{count: 2, size: 100})
+	ctx := context.Background()
+
+	service.CreateBucket(ctx, "limited", "owner")
+	service.CreateBucket(ctx, "other", "owner")
+	b, _ := service.GetBucket(ctx, "limited")
+	b.Quota = &Quota{MaxSize: 200}
+	service.UpdateBucket(ctx, b)
+
+	if _, err := service.Reserve(ctx, "limited", 0, 0); !errors.Is(err, ErrInvalidReservation) {
+		t.Errorf("Reserve(0) error = %v, want ErrInvalidReservation", err)
+	}
+	if _, err := service.Reserve(ctx, "missing", 1, 0); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("Reserve(missing) error = %v, want ErrBucketNotFound", err)
+	}
+	if _, err := service.Reserve(ctx, "limited", 101, 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Reserve past the quota error = %v, want ErrQuotaExceeded", err)
+	}
+
+	res, err := service.Reserve(ctx, "limited", 60, 0)
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if res.Token == "" || res.Bucket != "limited" || res.ExpiresAt.Sub(res.CreatedAt) != DefaultReservationTTL {
+		t.Errorf("Reserve() = %+v", res)
+	}
+
+	// The reserved space counts as used for writes and other reservations
+	if _, err := service.Reserve(ctx, "limited", 41, 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("second Reserve() error = %v, want ErrQuotaExceeded", err)
+	}
+	if err := service.CheckQuota(ctx, "limited", 41); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota() error = %v, want ErrQuotaExceeded", err)
+	}
+	if err := service.CheckQuota(ctx, "limited", 40); err != nil {
+		t.Errorf("CheckQuota() error = %v", err)
+	}
+
+	if list := service.ListReservations("limited"); len(list) != 1 || list[0].Token != res.Token {
+		t.Errorf("ListReservations(limited) = %v", list)
+	}
+	if list := service.ListReservations("other"); len(list) != 0 {
+		t.Errorf("ListReservations(other) = %v, want none", list)
+	}
+
+	if err := service.ReleaseReservation(res.Token); err != nil {
+		t.Fatalf("ReleaseReservation() error = %v", err)
+	}
+	if _, err := service.GetReservation(res.Token); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("GetReservation() after release error = %v, want ErrReservationNotFound", err)
+	}
+	if err := service.CheckQuota(ctx, "limited", 100); err != nil {
+		t.Errorf("CheckQuota() after release error = %v", err)
+	}
+}
+
+func TestBucketService_UseReservation(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	ctx := context.Background()
+	service.CreateBucket(ctx, "batch", "owner")
+	service.CreateBucket(ctx, "other", "owner")
+
+	res, err := service.Reserve(ctx, "batch", 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := service.UseReservation(res.Token, "other", 1); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("UseReservation() in another bucket error = %v, want ErrReservationNotFound", err)
+	}
+	if err := service.UseReservation(res.Token, "batch", 70); err != nil {
+		t.Fatalf("UseReservation() error = %v", err)
+	}
+	if err := service.UseReservation(res.Token, "batch", 31); !errors.Is(err, ErrReservationExceeded) {
+		t.Errorf("UseReservation() past the reservation error = %v, want ErrReservationExceeded", err)
+	}
+
+	// A failed write gives its space back
+	service.RefundReservation(res.Token, 20)
+	got, err := service.GetReservation(res.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Used != 50 || got.Remaining() != 50 {
+		t.Errorf("reservation = %+v, want 50 bytes used", got)
+	}
+}
+
+func TestBucketService_ReserveCapacity(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	free := int64(1000)
+	service.SetCapacity(func() int64 { return free })
+	ctx := context.Background()
+	service.CreateBucket(ctx, "alpha", "owner")
+	service.CreateBucket(ctx, "beta", "owner")
+
+	if _, err := service.Reserve(ctx, "alpha", 1001, 0); !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("Reserve() past the capacity error = %v, want ErrInsufficientCapacity", err)
+	}
+	if _, err := service.Reserve(ctx, "alpha", 800, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to other buckets cannot take the space reserved
+	if _, err := service.Reserve(ctx, "beta", 201, 0); !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("Reserve() in beta error = %v, want ErrInsufficientCapacity", err)
+	}
+	if err := service.CheckQuota(ctx, "beta", 201); !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("CheckQuota(beta) error = %v, want ErrInsufficientCapacity", err)
+	}
+	if err := service.CheckQuota(ctx, "beta", 200); err != nil {
+		t.Errorf("CheckQuota(beta) error = %v", err)
+	}
+}
+
+func TestBucketService_ReservationExpiry(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	ctx := context.Background()
+	service.CreateBucket(ctx, "batch", "owner")
+
+	res, err := service.Reserve(ctx, "batch", 10, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if err := service.UseReservation(res.Token, "batch", 1); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("UseReservation() after expiry error = %v, want ErrReservationNotFound", err)
+	}
+	if list := service.ListReservations(""); len(list) != 0 {
+		t.Errorf("ListReservations() = %v, want expired reservation dropped", list)
+	}
+}
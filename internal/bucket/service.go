@@ -8,6 +8,25 @@ import (
 	"time"
 )
 
+var (
+	// ErrBucketNotFound is returned for operations on a bucket that does not exist
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists is returned when creating a bucket that already exists
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNotEmpty is returned when deleting a bucket holding objects
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+	// ErrInvalidBucketName is returned for names S3 does not accept
+	ErrInvalidBucketName = errors.New("invalid bucket name")
+	// ErrQuotaExceeded is returned when a write would take a bucket past its quota
+	ErrQuotaExceeded = errors.New("bucket quota exceeded")
+	// ErrOwnerMismatch is returned when moving objects between buckets of
+	// different owners
+	ErrOwnerMismatch = errors.New("buckets have different owners")
+	// ErrVersioningMismatch is returned when moving objects out of a
+	// versioned bucket into one that does not keep versions
+	ErrVersioningMismatch = errors.New("destination bucket does not keep versions")
+)
+
 // ObjectCounter is used to check if a bucket has objects
 type ObjectCounter interface {
 	Count(ctx context.Context, bucket string) (int, int64, error)
@@ -17,12 +36,18 @@ type ObjectCounter interface {
 type Service struct {
 	repo          Repository
 	objectCounter ObjectCounter
+	namespace     Namespace
+	nodeID        string
+	capacity      CapacityFunc
+	reservations  *reservations
+	tenantQuotas  map[string]Quota
 }
 
 // NewService creates a new bucket service
 func NewService(repo Repository) *Service {
 	return &Service{
-		repo: repo,
+		repo:         repo,
+		reservations: newReservations(),
 	}
 }
 
@@ -31,16 +56,23 @@ func (s *Service) SetObjectCounter(counter ObjectCounter) {
 	s.objectCounter = counter
 }
 
+// SetNamespace makes bucket names unique across the cluster, with nodeID
+// identifying this node in claims
+func (s *Service) SetNamespace(namespace Namespace, nodeID string) {
+	s.namespace = namespace
+	s.nodeID = nodeID
+}
+
 // CreateBucket creates a new bucket
 func (s *Service) CreateBucket(ctx context.Context, name, owner string) error {
 	if !isValidBucketName(name) {
-		return errors.New("invalid bucket name")
+		return ErrInvalidBucketName
 	}
 
 	// Check if exists
 	_, err := s.repo.Get(ctx, name)
 	if err == nil {
-		return errors.New("bucket already exists")
+		return ErrBucketExists
 	}
 
 	bucket := &Bucket{
@@ -50,7 +82,25 @@ func (s *Service) CreateBucket(ctx context.Context, name, owner string) error {
 		Versioning: VersioningDisabled,
 	}
 
-	return s.repo.Create(ctx, bucket)
+	if s.namespace == nil {
+		return s.repo.Create(ctx, bucket)
+	}
+
+	claim := &Claim{
+		Name:       name,
+		NodeID:     s.nodeID,
+		Owner:      owner,
+		Versioning: bucket.Versioning,
+		ClaimedAt:  bucket.CreatedAt,
+	}
+	if err := s.namespace.Claim(ctx, claim); err != nil {
+		return err
+	}
+	if err := s.repo.Create(ctx, bucket); err != nil {
+		s.namespace.Release(ctx, name, s.nodeID)
+		return err
+	}
+	return nil
 }
 
 // GetBucket retrieves a bucket
@@ -63,6 +113,89 @@ func (s *Service) ListBuckets(ctx context.Context, owner string) ([]*Bucket, err
 	return s.repo.List(ctx, owner)
 }
 
+// UpdateBucket stores changes to a bucket's settings
+func (s *Service) UpdateBucket(ctx context.Context, bucket *Bucket) error {
+	return s.repo.Update(ctx, bucket)
+}
+
+// SetReplication enables or disables replication of a bucket's objects,
+// returning the updated bucket
+func (s *Service) SetReplication(ctx context.Context, name string, enabled bool) (*Bucket, error) {
+	bucket, err := s.repo.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	bucket.ReplicationDisabled = !enabled
+	if err := s.repo.Update(ctx, bucket); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+// CheckQuota returns ErrQuotaExceeded if adding an object of size bytes
+// would take the bucket past its quota, or its tenant past the tenant's.
+// Overwrites count as new objects, so a bucket at its object limit
+// refuses them too. Space held by reservations counts as used, and with a
+// capacity set it returns ErrInsufficientCapacity if the write would take
+// space reserved on the storage.
+func (s *Service) CheckQuota(ctx context.Context, name string, size int64) error {
+	bucket, err := s.repo.Get(ctx, name)
+	if err != nil {
+		// Missing buckets are reported by the write itself
+		return nil
+	}
+	reserved, allReserved := s.reservations.held(name)
+
+	if s.capacity != nil && allReserved > 0 {
+		if free := s.capacity(); allReserved+size > free {
+			return fmt.Errorf("%w: %d bytes free, %d of them reserved", ErrInsufficientCapacity, free, allReserved)
+		}
+	}
+	if s.objectCounter == nil {
+		return nil
+	}
+
+	if bucket.Quota != nil {
+		count, used, err := s.objectCounter.Count(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to check quota of bucket %q: %w", name, err)
+		}
+		if q := bucket.Quota.MaxObjects; q > 0 && int64(count)+1 > q {
+			return fmt.Errorf("%w: %q holds %d of %d objects", ErrQuotaExceeded, name, count, q)
+		}
+		if q := bucket.Quota.MaxSize; q > 0 && used+reserved+size > q {
+			return fmt.Errorf("%w: %q would hold %d of %d bytes", ErrQuotaExceeded, name, used+reserved+size, q)
+		}
+	}
+	return s.checkTenantQuota(ctx, name, size)
+}
+
+// CheckMove validates moving an object of size bytes from bucket from to
+// bucket to: both must exist and have the same owner, a versioned source
+// needs a versioned destination so the moved version stays recoverable,
+// and the destination must have room for the object under its quota.
+func (s *Service) CheckMove(ctx context.Context, from, to string, size int64) error {
+	src, err := s.repo.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	dst, err := s.repo.Get(ctx, to)
+	if err != nil {
+		return err
+	}
+
+	if src.Owner != dst.Owner {
+		return fmt.Errorf("%w: %q is owned by %q, %q by %q", ErrOwnerMismatch, from, src.Owner, to, dst.Owner)
+	}
+	if src.Versioning == VersioningEnabled && dst.Versioning != VersioningEnabled {
+		return fmt.Errorf("%w: %q has versioning enabled, %q does not", ErrVersioningMismatch, from, to)
+	}
+	return s.CheckQuota(ctx, to, size)
+}
+
 // DeleteBucket deletes a bucket
 func (s *Service) DeleteBucket(ctx context.Context, name string) error {
 	// Check if bucket exists
@@ -77,14 +210,34 @@ func (s *Service) DeleteBucket(ctx context.Context, name string) error {
 			return fmt.Errorf("failed to check if bucket %q is empty: %w", name, err)
 		}
 		if count > 0 {
-			return fmt.Errorf("bucket %q is not empty: contains %d objects", name, count)
+			return fmt.Errorf("%w: %q contains %d objects", ErrBucketNotEmpty, name, count)
 		}
 	}
 
-	return s.repo.Delete(ctx, name)
+	if err := s.repo.Delete(ctx, name); err != nil {
+		return err
+	}
+	if s.namespace != nil {
+		if err := s.namespace.Release(ctx, name, s.nodeID); err != nil {
+			return fmt.Errorf("bucket %q deleted but its cluster claim was kept: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateName returns ErrInvalidBucketName for names S3 does not accept
+func ValidateName(name string) error {
+	if !isValidBucketName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidBucketName, name)
+	}
+	return nil
 }
 
 func isValidBucketName(name string) bool {
+	tenant, name := SplitName(name)
+	if tenant != "" && !ValidTenant(tenant) {
+		return false
+	}
 	if len(name) < 3 || len(name) > 63 {
 		return false
 	}
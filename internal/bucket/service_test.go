@@ -2,8 +2,12 @@ package bucket
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/danielino/comio/internal/database"
 )
 
 func TestMemoryRepository_Create(t *testing.T) {
@@ -280,3 +284,169 @@ func TestBucketService_InvalidNames(t *testing.T) {
 		})
 	}
 }
+
+// fixedCounter reports the same usage for every bucket
+type fixedCounter struct {
+	count int
+	size  int64
+}
+
+func (c fixedCounter) Count(ctx context.Context, bucket string) (int, int64, error) {
+	return c.count, c.size, nil
+}
+
+func TestBucketService_CheckQuota(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	service.SetObjectCounter(fixedCounter{count: 2, size: 100})
+	ctx := context.Background()
+
+	service.CreateBucket(ctx, "limited", "owner")
+	service.CreateBucket(ctx, "unlimited", "owner")
+	b, _ := service.GetBucket(ctx, "limited")
+	b.Quota = &Quota{MaxSize: 150, MaxObjects: 3}
+	if err := service.UpdateBucket(ctx, b); err != nil {
+		t.Fatalf("UpdateBucket() error = %v", err)
+	}
+
+	tests := []struct {
+		bucket string
+		size   int64
+		want   error
+	}{
+		{"limited", 50, nil},
+		{"limited", 51, ErrQuotaExceeded},
+		{"unlimited", 1 << 40, nil},
+		{"missing", 1, nil},
+	}
+	for _, tt := range tests {
+		if err := service.CheckQuota(ctx, tt.bucket, tt.size); !errors.Is(err, tt.want) {
+			t.Errorf("CheckQuota(%s, %d) error = %v, want %v", tt.bucket, tt.size, err, tt.want)
+		}
+	}
+
+	service.SetObjectCounter(fixedCounter{count: 3})
+	if err := service.CheckQuota(ctx, "limited", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota at the object limit error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestBucketService_TenantQuota(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	service.SetObjectCounter(fixedCounter{count: 2, size: 100})
+	service.SetTenantQuotas(map[string]Quota{"team-a": {MaxSize: 250, MaxObjects: 10}})
+	ctx := context.Background()
+
+	for _, name := range []string{"team-a:photos", "team-a:docs", "team-b:photos", "photos"} {
+		if err := service.CreateBucket(ctx, name, "owner"); err != nil {
+			t.Fatalf("CreateBucket(%s) error = %v", name, err)
+		}
+	}
+
+	// The buckets of team-a hold 200 bytes together
+	tests := []struct {
+		bucket string
+		size   int64
+		want   error
+	}{
+		{"team-a:photos", 50, nil},
+		{"team-a:docs", 51, ErrQuotaExceeded},
+		{"team-b:photos", 1 << 40, nil},
+		{"photos", 1 << 40, nil},
+	}
+	for _, tt := range tests {
+		if err := service.CheckQuota(ctx, tt.bucket, tt.size); !errors.Is(err, tt.want) {
+			t.Errorf("CheckQuota(%s, %d) error = %v, want %v", tt.bucket, tt.size, err, tt.want)
+		}
+	}
+
+	// Reservations in one bucket leave less for the others
+	if _, err := service.Reserve(ctx, "team-a:docs", 40, 0); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err := service.CheckQuota(ctx, "team-a:photos", 11); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota past the reserved space error = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := service.Reserve(ctx, "team-a:photos", 11, 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Reserve past the tenant quota error = %v, want ErrQuotaExceeded", err)
+	}
+
+	service.SetTenantQuotas(map[string]Quota{"team-a": {MaxObjects: 4}})
+	if err := service.CheckQuota(ctx, "team-a:photos", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("CheckQuota at the tenant object limit error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestBucketService_CheckMove(t *testing.T) {
+	service := NewService(NewMemoryRepository())
+	service.SetObjectCounter(fixedCounter{count: 2, size: 100})
+	ctx := context.Background()
+
+	for name, owner := range map[string]string{"staging": "team", "production": "team", "versioned": "team", "other": "someone"} {
+		service.CreateBucket(ctx, name, owner)
+	}
+	b, _ := service.GetBucket(ctx, "production")
+	b.Quota = &Quota{MaxSize: 150}
+	service.UpdateBucket(ctx, b)
+	b, _ = service.GetBucket(ctx, "versioned")
+	b.Versioning = VersioningEnabled
+	service.UpdateBucket(ctx, b)
+
+	tests := []struct {
+		from, to string
+		size     int64
+		want     error
+	}{
+		{"staging", "production", 50, nil},
+		{"staging", "production", 51, ErrQuotaExceeded},
+		{"staging", "other", 1, ErrOwnerMismatch},
+		{"staging", "versioned", 1, nil},
+		{"versioned", "staging", 1, ErrVersioningMismatch},
+		{"versioned", "versioned", 1, nil},
+		{"staging", "missing", 1, ErrBucketNotFound},
+		{"missing", "staging", 1, ErrBucketNotFound},
+	}
+	for _, tt := range tests {
+		if err := service.CheckMove(ctx, tt.from, tt.to, tt.size); !errors.Is(err, tt.want) {
+			t.Errorf("CheckMove(%s, %s, %d) error = %v, want %v", tt.from, tt.to, tt.size, err, tt.want)
+		}
+	}
+}
+
+func TestBucketService_SetReplication(t *testing.T) {
+	db, err := database.Open(database.Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repos := map[string]Repository{
+		"memory": NewMemoryRepository(),
+		"sqlite": NewSQLiteRepository(db),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(repo)
+			ctx := context.Background()
+			if err := service.CreateBucket(ctx, "scratch", "owner"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := service.SetReplication(ctx, "scratch", false); err != nil {
+				t.Fatalf("SetReplication() error = %v", err)
+			}
+			if b, _ := service.GetBucket(ctx, "scratch"); !b.ReplicationDisabled {
+				t.Error("replication not disabled")
+			}
+			if _, err := service.SetReplication(ctx, "scratch", true); err != nil {
+				t.Fatalf("SetReplication() error = %v", err)
+			}
+			if b, _ := service.GetBucket(ctx, "scratch"); b.ReplicationDisabled {
+				t.Error("replication not enabled again")
+			}
+
+			if _, err := service.SetReplication(ctx, "missing", false); !errors.Is(err, ErrBucketNotFound) {
+				t.Errorf("SetReplication(missing) error = %v, want ErrBucketNotFound", err)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package bucket
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 
 	"github.com/danielino/comio/internal/database"
@@ -23,21 +24,31 @@ func NewSQLiteRepository(db *database.DB) *SQLiteRepository {
 // Create creates a new bucket
 func (r *SQLiteRepository) Create(ctx context.Context, bucket *Bucket) error {
 	query := `
-		INSERT INTO buckets (name, owner, created_at, versioning_enabled)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO buckets (name, owner, created_at, versioning_enabled, lifecycle, policy, quota, checksum_algorithms, replication_disabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := r.db.ExecWithRetry(ctx, query,
+	lifecycle, policy, quota, checksums, err := marshalConfig(bucket)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecWithRetry(ctx, query,
 		bucket.Name,
 		bucket.Owner,
 		bucket.CreatedAt,
 		bucket.Versioning,
+		lifecycle,
+		policy,
+		quota,
+		checksums,
+		bucket.ReplicationDisabled,
 	)
 
 	if err != nil {
 		// Check for unique constraint violation (bucket already exists)
 		if isSQLiteConstraintError(err) {
-			return fmt.Errorf("bucket '%s' already exists", bucket.Name)
+			return fmt.Errorf("%w: %s", ErrBucketExists, bucket.Name)
 		}
 		return fmt.Errorf("failed to create bucket: %w", err)
 	}
@@ -48,21 +59,14 @@ func (r *SQLiteRepository) Create(ctx context.Context, bucket *Bucket) error {
 // Get retrieves a bucket by name
 func (r *SQLiteRepository) Get(ctx context.Context, name string) (*Bucket, error) {
 	query := `
-		SELECT name, owner, created_at, versioning_enabled
+		SELECT name, owner, created_at, versioning_enabled, lifecycle, policy, quota, checksum_algorithms, replication_disabled
 		FROM buckets
 		WHERE name = ?
 	`
 
-	bucket := &Bucket{}
-	err := r.db.QueryRowContext(ctx, query, name).Scan(
-		&bucket.Name,
-		&bucket.Owner,
-		&bucket.CreatedAt,
-		&bucket.Versioning,
-	)
-
+	bucket, err := scanBucket(r.db.QueryRowContext(ctx, query, name))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("bucket '%s' not found", name)
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotFound, name)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get bucket: %w", err)
@@ -74,7 +78,7 @@ func (r *SQLiteRepository) Get(ctx context.Context, name string) (*Bucket, error
 // List lists all buckets for an owner
 func (r *SQLiteRepository) List(ctx context.Context, owner string) ([]*Bucket, error) {
 	query := `
-		SELECT name, owner, created_at, versioning_enabled
+		SELECT name, owner, created_at, versioning_enabled, lifecycle, policy, quota, checksum_algorithms, replication_disabled
 		FROM buckets
 		WHERE owner = ?
 		ORDER BY name
@@ -88,13 +92,7 @@ func (r *SQLiteRepository) List(ctx context.Context, owner string) ([]*Bucket, e
 
 	var buckets []*Bucket
 	for rows.Next() {
-		bucket := &Bucket{}
-		err := rows.Scan(
-			&bucket.Name,
-			&bucket.Owner,
-			&bucket.CreatedAt,
-			&bucket.Versioning,
-		)
+		bucket, err := scanBucket(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan bucket: %w", err)
 		}
@@ -108,36 +106,38 @@ func (r *SQLiteRepository) List(ctx context.Context, owner string) ([]*Bucket, e
 	return buckets, nil
 }
 
-// Delete deletes a bucket
+// Delete deletes a bucket. Checking for objects and deleting are one
+// transaction, so an object put in between cannot be orphaned.
 func (r *SQLiteRepository) Delete(ctx context.Context, name string) error {
-	// First check if bucket has objects
-	var count int
-	err := r.db.QueryRowContext(ctx,
-		"SELECT COUNT(*) FROM objects WHERE bucket_name = ?", name).Scan(&count)
-	if err != nil {
-		return fmt.Errorf("failed to check bucket objects: %w", err)
-	}
+	return r.db.WithTx(ctx, func(tx *database.Tx) error {
+		var count int
+		err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM objects WHERE bucket_name = ?", name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to check bucket objects: %w", err)
+		}
 
-	if count > 0 {
-		return fmt.Errorf("bucket '%s' is not empty", name)
-	}
+		if count > 0 {
+			return fmt.Errorf("%w: %s", ErrBucketNotEmpty, name)
+		}
 
-	// Delete bucket
-	result, err := r.db.ExecWithRetry(ctx, "DELETE FROM buckets WHERE name = ?", name)
-	if err != nil {
-		return fmt.Errorf("failed to delete bucket: %w", err)
-	}
+		// Delete bucket
+		result, err := tx.ExecContext(ctx, "DELETE FROM buckets WHERE name = ?", name)
+		if err != nil {
+			return fmt.Errorf("failed to delete bucket: %w", err)
+		}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
 
-	if rows == 0 {
-		return fmt.Errorf("bucket '%s' not found", name)
-	}
+		if rows == 0 {
+			return fmt.Errorf("%w: %s", ErrBucketNotFound, name)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // Exists checks if a bucket exists
@@ -155,12 +155,22 @@ func (r *SQLiteRepository) Exists(ctx context.Context, name string) (bool, error
 func (r *SQLiteRepository) Update(ctx context.Context, bucket *Bucket) error {
 	query := `
 		UPDATE buckets
-		SET versioning_enabled = ?
+		SET versioning_enabled = ?, lifecycle = ?, policy = ?, quota = ?, checksum_algorithms = ?, replication_disabled = ?
 		WHERE name = ?
 	`
 
+	lifecycle, policy, quota, checksums, err := marshalConfig(bucket)
+	if err != nil {
+		return err
+	}
+
 	result, err := r.db.ExecWithRetry(ctx, query,
 		bucket.Versioning,
+		lifecycle,
+		policy,
+		quota,
+		checksums,
+		bucket.ReplicationDisabled,
 		bucket.Name,
 	)
 	if err != nil {
@@ -173,12 +183,81 @@ func (r *SQLiteRepository) Update(ctx context.Context, bucket *Bucket) error {
 	}
 
 	if rows == 0 {
-		return fmt.Errorf("bucket '%s' not found", bucket.Name)
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket.Name)
 	}
 
 	return nil
 }
 
+// marshalConfig encodes the bucket configuration columns as JSON, NULL
+// when unset
+func marshalConfig(bucket *Bucket) (lifecycle, policy, quota, checksums sql.NullString, err error) {
+	encode := func(v interface{}, set bool) (sql.NullString, error) {
+		if !set {
+			return sql.NullString{}, nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return sql.NullString{}, fmt.Errorf("failed to marshal bucket configuration: %w", err)
+		}
+		return sql.NullString{String: string(data), Valid: true}, nil
+	}
+
+	if lifecycle, err = encode(bucket.Lifecycle, len(bucket.Lifecycle) > 0); err != nil {
+		return
+	}
+	if policy, err = encode(bucket.Policy, bucket.Policy != nil); err != nil {
+		return
+	}
+	if quota, err = encode(bucket.Quota, bucket.Quota != nil); err != nil {
+		return
+	}
+	checksums, err = encode(bucket.ChecksumAlgorithms, len(bucket.ChecksumAlgorithms) > 0)
+	return
+}
+
+// scanBucket reads a bucket row, decoding its configuration columns
+func scanBucket(row interface{ Scan(...interface{}) error }) (*Bucket, error) {
+	bucket := &Bucket{}
+	var lifecycle, policy, quota, checksums sql.NullString
+	err := row.Scan(
+		&bucket.Name,
+		&bucket.Owner,
+		&bucket.CreatedAt,
+		&bucket.Versioning,
+		&lifecycle,
+		&policy,
+		&quota,
+		&checksums,
+		&bucket.ReplicationDisabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lifecycle.Valid {
+		if err := json.Unmarshal([]byte(lifecycle.String), &bucket.Lifecycle); err != nil {
+			return nil, fmt.Errorf("invalid lifecycle of bucket %s: %w", bucket.Name, err)
+		}
+	}
+	if policy.Valid {
+		if err := json.Unmarshal([]byte(policy.String), &bucket.Policy); err != nil {
+			return nil, fmt.Errorf("invalid policy of bucket %s: %w", bucket.Name, err)
+		}
+	}
+	if quota.Valid {
+		if err := json.Unmarshal([]byte(quota.String), &bucket.Quota); err != nil {
+			return nil, fmt.Errorf("invalid quota of bucket %s: %w", bucket.Name, err)
+		}
+	}
+	if checksums.Valid {
+		if err := json.Unmarshal([]byte(checksums.String), &bucket.ChecksumAlgorithms); err != nil {
+			return nil, fmt.Errorf("invalid checksum algorithms of bucket %s: %w", bucket.Name, err)
+		}
+	}
+	return bucket, nil
+}
+
 // isSQLiteConstraintError checks if error is a constraint violation
 func isSQLiteConstraintError(err error) bool {
 	if err == nil {
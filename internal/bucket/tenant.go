@@ -0,0 +1,91 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TenantSeparator separates the tenant from the bucket name in the names
+// of tenant buckets, as in "team-a:photos". Bucket names of the default
+// namespace never contain it.
+const TenantSeparator = ":"
+
+var tenantNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// ValidTenant reports whether name is a valid tenant name: up to 32
+// lowercase letters, digits and hyphens, starting and ending with a letter
+// or digit
+func ValidTenant(name string) bool {
+	return tenantNameRegex.MatchString(name)
+}
+
+// QualifiedName returns the name a bucket of a tenant is stored under,
+// name itself for the default namespace
+func QualifiedName(tenant, name string) string {
+	if tenant == "" {
+		return name
+	}
+	return tenant + TenantSeparator + name
+}
+
+// SplitName splits a stored bucket name into its tenant, empty for the
+// default namespace, and the name the tenant knows the bucket by
+func SplitName(qualified string) (tenant, name string) {
+	if tenant, name, ok := strings.Cut(qualified, TenantSeparator); ok {
+		return tenant, name
+	}
+	return "", qualified
+}
+
+// SetTenantQuotas limits what all the buckets of each tenant may hold
+// together, on top of the quota of each bucket
+func (s *Service) SetTenantQuotas(quotas map[string]Quota) {
+	s.tenantQuotas = quotas
+}
+
+// checkTenantQuota returns ErrQuotaExceeded if adding an object of size
+// bytes to bucket name would take its tenant past the tenant's quota
+func (s *Service) checkTenantQuota(ctx context.Context, name string, size int64) error {
+	tenant, _ := SplitName(name)
+	quota, ok := s.tenantQuotas[tenant]
+	if tenant == "" || !ok {
+		return nil
+	}
+
+	count, used, err := s.tenantUsage(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if q := quota.MaxObjects; q > 0 && count+1 > q {
+		return fmt.Errorf("%w: tenant %q holds %d of %d objects", ErrQuotaExceeded, tenant, count, q)
+	}
+	reserved := s.reservations.heldByTenant(tenant)
+	if q := quota.MaxSize; q > 0 && used+reserved+size > q {
+		return fmt.Errorf("%w: tenant %q would hold %d of %d bytes", ErrQuotaExceeded, tenant, used+reserved+size, q)
+	}
+	return nil
+}
+
+// tenantUsage returns the objects and bytes held by the buckets of a tenant
+func (s *Service) tenantUsage(ctx context.Context, tenant string) (int64, int64, error) {
+	buckets, err := s.repo.List(ctx, "")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to check quota of tenant %q: %w", tenant, err)
+	}
+
+	var count, used int64
+	for _, b := range buckets {
+		if t, _ := SplitName(b.Name); t != tenant {
+			continue
+		}
+		n, size, err := s.objectCounter.Count(ctx, b.Name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to check quota of tenant %q: %w", tenant, err)
+		}
+		count += int64(n)
+		used += size
+	}
+	return count, used, nil
+}
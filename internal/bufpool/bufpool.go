@@ -0,0 +1,102 @@
+// Package bufpool pools the copy buffers of the data paths. Pools are
+// registered by name so their buffer size can be tuned at runtime.
+package bufpool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Bounds on buffer sizes
+const (
+	MinSize = 512
+	MaxSize = 64 * 1024 * 1024
+)
+
+var (
+	// ErrUnknownPool is returned when resizing a pool that is not registered
+	ErrUnknownPool = errors.New("unknown buffer pool")
+	// ErrInvalidSize is returned for sizes outside [MinSize, MaxSize]
+	ErrInvalidSize = fmt.Errorf("buffer size must be between %d and %d bytes", MinSize, MaxSize)
+)
+
+// Pool hands out buffers of a size that can change while it is in use.
+// Buffers of an old size are dropped when they come back.
+type Pool struct {
+	name string
+	size atomic.Int64
+	pool sync.Pool
+}
+
+var (
+	mu    sync.Mutex
+	pools = make(map[string]*Pool)
+)
+
+// New registers a pool of size-byte buffers under name. Registering a name
+// twice returns the existing pool.
+func New(name string, size int) *Pool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p, ok := pools[name]; ok {
+		return p
+	}
+	p := &Pool{name: name}
+	p.size.Store(int64(size))
+	pools[name] = p
+	return p
+}
+
+// Get returns a buffer of the pool's current size
+func (p *Pool) Get() []byte {
+	size := int(p.size.Load())
+	if b, ok := p.pool.Get().(*[]byte); ok && len(*b) == size {
+		return *b
+	}
+	return make([]byte, size)
+}
+
+// Put returns a buffer to the pool
+func (p *Pool) Put(b []byte) {
+	if len(b) != int(p.size.Load()) {
+		return
+	}
+	p.pool.Put(&b)
+}
+
+// Size returns the size of the buffers handed out
+func (p *Pool) Size() int {
+	return int(p.size.Load())
+}
+
+// Sizes returns the buffer size of every registered pool by name
+func Sizes() map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sizes := make(map[string]int, len(pools))
+	for name, p := range pools {
+		sizes[name] = p.Size()
+	}
+	return sizes
+}
+
+// Resize changes the buffer size of a registered pool. Buffers already
+// handed out keep their size.
+func Resize(name string, size int) error {
+	if size < MinSize || size > MaxSize {
+		return ErrInvalidSize
+	}
+
+	mu.Lock()
+	p, ok := pools[name]
+	mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPool, name)
+	}
+	p.size.Store(int64(size))
+	return nil
+}
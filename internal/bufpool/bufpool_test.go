@@ -0,0 +1,37 @@
+package bufpool
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPool_Resize(t *testing.T) {
+	p := New("test", 1024)
+	if again := New("test", 2048); again != p || again.Size() != 1024 {
+		t.Errorf("registering a name twice = %p size %d, want the existing pool", again, again.Size())
+	}
+
+	buf := p.Get()
+	if len(buf) != 1024 {
+		t.Fatalf("Get() = %d bytes, want 1024", len(buf))
+	}
+
+	if err := Resize("test", 8192); err != nil {
+		t.Fatalf("Resize() error = %v", err)
+	}
+	// A buffer of the old size is dropped rather than handed out again
+	p.Put(buf)
+	if got := p.Get(); len(got) != 8192 {
+		t.Errorf("Get() after resize = %d bytes, want 8192", len(got))
+	}
+	if Sizes()["test"] != 8192 {
+		t.Errorf("Sizes() = %v", Sizes())
+	}
+
+	if err := Resize("test", MinSize-1); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("Resize() below the minimum error = %v, want ErrInvalidSize", err)
+	}
+	if err := Resize("missing", 4096); !errors.Is(err, ErrUnknownPool) {
+		t.Errorf("Resize(missing) error = %v, want ErrUnknownPool", err)
+	}
+}
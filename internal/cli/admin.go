@@ -1,11 +1,15 @@
 package cli
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -21,7 +25,7 @@ var metricsCmd = &cobra.Command{
 	Use:   "metrics",
 	Short: "Show server metrics",
 	Run: func(cmd *cobra.Command, args []string) {
-		url := fmt.Sprintf("%s/admin/metrics", serverAddr)
+		url := fmt.Sprintf("%s/admin/v1/metrics", serverAddr)
 
 		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
@@ -38,8 +42,7 @@ var metricsCmd = &cobra.Command{
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Error getting metrics: %s (Status: %d)\n", string(body), resp.StatusCode)
+			fmt.Printf("Error getting metrics: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
 			os.Exit(1)
 		}
 
@@ -69,7 +72,7 @@ var purgeCmd = &cobra.Command{
 		bucket := args[0]
 
 		// First, get info about what will be deleted
-		url := fmt.Sprintf("%s/admin/%s/objects", serverAddr, bucket)
+		url := fmt.Sprintf("%s/admin/v1/buckets/%s/objects", serverAddr, bucket)
 
 		req, err := http.NewRequest("DELETE", url, nil)
 		if err != nil {
@@ -86,8 +89,7 @@ var purgeCmd = &cobra.Command{
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Error getting bucket info: %s (Status: %d)\n", string(body), resp.StatusCode)
+			fmt.Printf("Error getting bucket info: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
 			os.Exit(1)
 		}
 
@@ -117,73 +119,515 @@ var purgeCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
-		// Perform actual deletion with progress feedback
+		// Start the purge job on the server
 		fmt.Printf("\nDeleting %d objects...\n", count)
-		deleteURL := fmt.Sprintf("%s/admin/%s/objects?confirm=true", serverAddr, bucket)
+		deleteURL := fmt.Sprintf("%s/admin/v1/buckets/%s/objects?confirm=true", serverAddr, bucket)
 		deleteReq, err := http.NewRequest("DELETE", deleteURL, nil)
 		if err != nil {
 			fmt.Printf("Error creating delete request: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Start deletion with timeout
-		client.Timeout = 300 * time.Second
+		deleteResp, err := client.Do(deleteReq)
+		if err != nil {
+			fmt.Printf("Error sending delete request: %v\n", err)
+			os.Exit(1)
+		}
+		defer deleteResp.Body.Close()
 
-		// Show progress animation while deletion is happening
+		if deleteResp.StatusCode != http.StatusAccepted {
+			fmt.Printf("✗ Error starting deletion: %s (Status: %d)\n", responseError(deleteResp), deleteResp.StatusCode)
+			os.Exit(1)
+		}
+
+		var started struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.NewDecoder(deleteResp.Body).Decode(&started); err != nil {
+			fmt.Printf("Error decoding response: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Poll the job and show a progress animation until it finishes
 		ticker := time.NewTicker(200 * time.Millisecond)
-		done := make(chan error)
-		var deletedCount int
-		var freedSize int64
-
-		go func() {
-			deleteResp, err := client.Do(deleteReq)
-			if err != nil {
-				done <- err
-				return
-			}
-			defer deleteResp.Body.Close()
+		defer ticker.Stop()
 
-			if deleteResp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(deleteResp.Body)
-				done <- fmt.Errorf("%s (Status: %d)", string(body), deleteResp.StatusCode)
-				return
+		progressChars := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+		charIndex := 0
+		var job *purgeJob
+		for range ticker.C {
+			// Refresh job status roughly once per second
+			if charIndex%5 == 0 {
+				if j, err := fetchPurgeJob(started.JobID); err == nil {
+					job = j
+				}
+			}
+			if job != nil && job.State != "running" {
+				break
 			}
 
-			var deleteResult map[string]interface{}
-			if err := json.NewDecoder(deleteResp.Body).Decode(&deleteResult); err != nil {
-				done <- err
-				return
+			progressText := ""
+			if job != nil {
+				progressText = fmt.Sprintf("%d/%d", job.Progress.Deleted, job.Progress.Total)
 			}
+			fmt.Printf("\r%s Deleting objects... %s ", progressChars[charIndex%len(progressChars)], progressText)
+			charIndex++
+		}
+		fmt.Printf("\r")
 
-			deletedCount = int(deleteResult["deleted_count"].(float64))
-			freedSize = int64(deleteResult["freed_size"].(float64))
-			done <- nil
-		}()
+		switch job.State {
+		case "completed":
+			fmt.Printf("✓ Deleted %d object(s), freed %s\n", job.Progress.Deleted, formatBytes(float64(job.Progress.FreedBytes)))
+		case "cancelled":
+			fmt.Printf("✗ Deletion cancelled after %d object(s)\n", job.Progress.Deleted)
+			os.Exit(1)
+		default:
+			fmt.Printf("✗ Error during deletion: %s\n", job.Progress.Error)
+			os.Exit(1)
+		}
+	},
+}
 
-		// Show progress animation
-		progressChars := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
-		charIndex := 0
-	progressLoop:
-		for {
-			select {
-			case err := <-done:
-				ticker.Stop()
-				fmt.Printf("\r")
-				if err != nil {
-					fmt.Printf("✗ Error during deletion: %v\n", err)
-					os.Exit(1)
+// purgeJob mirrors the server's purge job status
+type purgeJob struct {
+	ID       string `json:"id"`
+	State    string `json:"state"`
+	Progress struct {
+		Total      int    `json:"total"`
+		Deleted    int    `json:"deleted"`
+		FreedBytes int64  `json:"freed_bytes"`
+		Error      string `json:"error"`
+	} `json:"progress"`
+}
+
+// fetchPurgeJob queries the server for the status of a purge job
+func fetchPurgeJob(id string) (*purgeJob, error) {
+	url := fmt.Sprintf("%s/admin/v1/jobs/%s", serverAddr, id)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var job purgeJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+var decommissionCmd = &cobra.Command{
+	Use:   "decommission <node>",
+	Short: "Drain a cluster node and remove it from the ring",
+	Long: `Marks the node read-only, copies its objects to the remaining nodes and
+removes it from the ring once every object is safe. Running it again after
+a failure resumes the drain.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		nodeID := args[0]
+		client := &http.Client{Timeout: 10 * time.Second}
+
+		// The node drains itself, so find its address first
+		resp, err := client.Get(fmt.Sprintf("%s/admin/v1/cluster/nodes", serverAddr))
+		if err != nil {
+			fmt.Printf("Error sending request: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Error listing cluster nodes: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
+			os.Exit(1)
+		}
+
+		var ring struct {
+			Enabled bool   `json:"enabled"`
+			Self    string `json:"self"`
+			Nodes   []struct {
+				ID    string `json:"id"`
+				URL   string `json:"url"`
+				State string `json:"state"`
+			} `json:"nodes"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&ring); err != nil {
+			fmt.Printf("Error decoding response: %v\n", err)
+			os.Exit(1)
+		}
+		if !ring.Enabled {
+			fmt.Println("Error: cluster nodes are not configured on the server")
+			os.Exit(1)
+		}
+
+		nodeAddr := ""
+		for _, n := range ring.Nodes {
+			if n.ID == nodeID {
+				nodeAddr = n.URL
+				if n.State == "decommissioned" {
+					fmt.Printf("Node '%s' is already decommissioned\n", nodeID)
+					return
 				}
-				break progressLoop
-			case <-ticker.C:
-				fmt.Printf("\r%s Deleting objects... ", progressChars[charIndex%len(progressChars)])
-				charIndex++
 			}
 		}
+		if nodeID == ring.Self {
+			nodeAddr = serverAddr
+		}
+		if nodeAddr == "" {
+			fmt.Printf("Error: node '%s' is not in the ring\n", nodeID)
+			os.Exit(1)
+		}
+
+		fmt.Printf("WARNING: Node '%s' will become read-only and its objects will be moved to the remaining nodes\n", nodeID)
+		fmt.Print("Are you sure you want to proceed? (yes/no): ")
+
+		var confirmation string
+		fmt.Scanln(&confirmation)
+
+		if confirmation != "yes" {
+			fmt.Println("Operation cancelled")
+			os.Exit(0)
+		}
+
+		startResp, err := client.Post(fmt.Sprintf("%s/admin/v1/cluster/nodes/%s/decommission", nodeAddr, nodeID), "application/json", nil)
+		if err != nil {
+			fmt.Printf("Error sending decommission request: %v\n", err)
+			os.Exit(1)
+		}
+		defer startResp.Body.Close()
+
+		if startResp.StatusCode != http.StatusAccepted {
+			fmt.Printf("✗ Error starting decommission: %s (Status: %d)\n", responseError(startResp), startResp.StatusCode)
+			os.Exit(1)
+		}
+
+		var started struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.NewDecoder(startResp.Body).Decode(&started); err != nil {
+			fmt.Printf("Error decoding response: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Poll the job until the node is drained
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var job *backgroundJob
+		for range ticker.C {
+			if j, err := fetchJob(nodeAddr, started.JobID); err == nil {
+				job = j
+			}
+			if job == nil {
+				continue
+			}
+			if job.State != "queued" && job.State != "running" {
+				break
+			}
+			fmt.Printf("\rDraining node '%s'... %d/%d objects, %s moved ", nodeID,
+				job.Progress.Done, job.Progress.Total, formatBytes(float64(job.Progress.Bytes)))
+		}
+		fmt.Printf("\r")
+
+		switch job.State {
+		case "completed":
+			fmt.Printf("✓ Node '%s' decommissioned: %d object(s), %s checked safe on the remaining nodes\n",
+				nodeID, job.Progress.Done, formatBytes(float64(job.Progress.Bytes)))
+		case "cancelled":
+			fmt.Printf("✗ Decommission cancelled after %d/%d object(s); node '%s' stays read-only\n",
+				job.Progress.Done, job.Progress.Total, nodeID)
+			os.Exit(1)
+		default:
+			fmt.Printf("✗ Decommission failed: %s\n", job.Error)
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	rebalanceFraction float64
+	rebalanceRate     int64
+)
+
+var rebalanceCmd = &cobra.Command{
+	Use:   "rebalance",
+	Short: "Move objects onto nodes added to the ring",
+	Long: `Moves the objects of the server that are placed on other nodes, such as
+nodes added since they were written, so utilization evens out. Transfers
+are throttled; --fraction bounds how much of the node's data one run moves.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		query := url.Values{}
+		if cmd.Flags().Changed("fraction") {
+			query.Set("fraction", strconv.FormatFloat(rebalanceFraction, 'f', -1, 64))
+		}
+		if cmd.Flags().Changed("rate") {
+			query.Set("bytes_per_second", strconv.FormatInt(rebalanceRate, 10))
+		}
+		startURL := fmt.Sprintf("%s/admin/v1/cluster/rebalance", serverAddr)
+		if len(query) > 0 {
+			startURL += "?" + query.Encode()
+		}
+
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(startURL, "application/json", nil)
+		if err != nil {
+			fmt.Printf("Error sending rebalance request: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusAccepted {
+			fmt.Printf("✗ Error starting rebalance: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
+			os.Exit(1)
+		}
+
+		var started struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+			fmt.Printf("Error decoding response: %v\n", err)
+			os.Exit(1)
+		}
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var job *backgroundJob
+		for range ticker.C {
+			if j, err := fetchJob(serverAddr, started.JobID); err == nil {
+				job = j
+			}
+			if job == nil {
+				continue
+			}
+			if job.State != "queued" && job.State != "running" {
+				break
+			}
+			fmt.Printf("\rRebalancing... %d/%d objects, %s moved (%s) ",
+				job.Progress.Done, job.Progress.Total, formatBytes(float64(job.Progress.Bytes)), job.Progress.Message)
+		}
+		fmt.Printf("\r")
+
+		switch job.State {
+		case "completed":
+			fmt.Printf("✓ Rebalanced %d object(s), moved %s\n", job.Progress.Done, formatBytes(float64(job.Progress.Bytes)))
+		case "cancelled":
+			fmt.Printf("✗ Rebalance cancelled after %d/%d object(s)\n", job.Progress.Done, job.Progress.Total)
+			os.Exit(1)
+		default:
+			fmt.Printf("✗ Rebalance failed: %s\n", job.Error)
+			os.Exit(1)
+		}
+	},
+}
+
+var rewrapKeysCmd = &cobra.Command{
+	Use:   "rewrap-keys",
+	Short: "Move encrypted objects to the active master key version",
+	Long: `Re-wraps the data keys of objects encrypted under old master key versions
+with the active one, then reports how many objects each version still
+protects. Versions no object uses can be removed from the configuration.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(fmt.Sprintf("%s/admin/v1/encryption/rewrap", serverAddr), "application/json", nil)
+		if err != nil {
+			fmt.Printf("Error sending rewrap request: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusAccepted {
+			fmt.Printf("✗ Error starting rewrap: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
+			os.Exit(1)
+		}
+
+		var started struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&started); err != nil {
+			fmt.Printf("Error decoding response: %v\n", err)
+			os.Exit(1)
+		}
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var job *backgroundJob
+		for range ticker.C {
+			if j, err := fetchJob(serverAddr, started.JobID); err == nil {
+				job = j
+			}
+			if job == nil {
+				continue
+			}
+			if job.State != "queued" && job.State != "running" {
+				break
+			}
+			fmt.Printf("\rRe-wrapping... %d/%d objects ", job.Progress.Done, job.Progress.Total)
+		}
+		fmt.Printf("\r")
+
+		if job.State != "completed" {
+			fmt.Printf("✗ Rewrap %s: %s\n", job.State, job.Error)
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Re-wrapped %d object(s)\n", job.Progress.Done)
+
+		statusResp, err := client.Get(fmt.Sprintf("%s/admin/v1/encryption", serverAddr))
+		if err != nil {
+			fmt.Printf("Error fetching encryption status: %v\n", err)
+			os.Exit(1)
+		}
+		defer statusResp.Body.Close()
+
+		var status struct {
+			ActiveVersion int `json:"active_version"`
+			Objects       struct {
+				Versions    map[string]int64 `json:"versions"`
+				Unencrypted int64            `json:"unencrypted"`
+				Stale       int64            `json:"stale"`
+			} `json:"objects"`
+		}
+		if err := json.NewDecoder(statusResp.Body).Decode(&status); err != nil {
+			fmt.Printf("Error decoding encryption status: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Active key version: %d\n", status.ActiveVersion)
+		versions := make([]string, 0, len(status.Objects.Versions))
+		for version := range status.Objects.Versions {
+			versions = append(versions, version)
+		}
+		sort.Strings(versions)
+		for _, version := range versions {
+			fmt.Printf("  version %s: %d object(s)\n", version, status.Objects.Versions[version])
+		}
+		if status.Objects.Unencrypted > 0 {
+			fmt.Printf("  unencrypted: %d object(s)\n", status.Objects.Unencrypted)
+		}
+		if status.Objects.Stale > 0 {
+			fmt.Printf("%d object(s) remain on old key versions\n", status.Objects.Stale)
+		}
+	},
+}
+
+var (
+	applyFile   string
+	applyDryRun bool
+	applyPrune  bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f <spec>",
+	Short: "Reconcile buckets and users with a declarative spec",
+	Long: `Brings the server's buckets, their versioning, lifecycle rules, policies and
+quotas, and its users in line with a YAML or JSON spec, printing the changes
+made. Buckets and users the spec leaves out are kept unless --prune is set.
+Use --dry-run to only print the changes; "-f -" reads the spec from stdin.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var spec []byte
+		var err error
+		if applyFile == "-" {
+			spec, err = io.ReadAll(os.Stdin)
+		} else {
+			spec, err = os.ReadFile(applyFile)
+		}
+		if err != nil {
+			fmt.Printf("Error reading spec: %v\n", err)
+			os.Exit(1)
+		}
+
+		query := url.Values{}
+		query.Set("dry_run", strconv.FormatBool(applyDryRun))
+		query.Set("prune", strconv.FormatBool(applyPrune))
+		applyURL := fmt.Sprintf("%s/admin/v1/apply?%s", serverAddr, query.Encode())
+
+		client := &http.Client{Timeout: time.Minute}
+		resp, err := client.Post(applyURL, "application/yaml", bytes.NewReader(spec))
+		if err != nil {
+			fmt.Printf("Error sending spec: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
 
-		fmt.Printf("✓ Deleted %d object(s), freed %s\n", deletedCount, formatBytes(float64(freedSize)))
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("✗ Error applying spec: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
+			os.Exit(1)
+		}
+
+		var result struct {
+			Changes []struct {
+				Action string   `json:"action"`
+				Kind   string   `json:"kind"`
+				Name   string   `json:"name"`
+				Fields []string `json:"fields"`
+			} `json:"changes"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Printf("Error decoding response: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(result.Changes) == 0 {
+			fmt.Println("No changes: the server matches the spec")
+			return
+		}
+		symbols := map[string]string{"create": "+", "update": "~", "delete": "-"}
+		for _, change := range result.Changes {
+			fmt.Printf("%s %s %s\n", symbols[change.Action], change.Kind, change.Name)
+			for _, field := range change.Fields {
+				fmt.Printf("    %s\n", field)
+			}
+		}
+		if applyDryRun {
+			fmt.Printf("%d change(s) would be made\n", len(result.Changes))
+		} else {
+			fmt.Printf("✓ %d change(s) made\n", len(result.Changes))
+		}
 	},
 }
 
+// backgroundJob mirrors the server's job status
+type backgroundJob struct {
+	ID       string `json:"id"`
+	State    string `json:"state"`
+	Error    string `json:"error"`
+	Progress struct {
+		Total   int64  `json:"total"`
+		Done    int64  `json:"done"`
+		Bytes   int64  `json:"bytes"`
+		Message string `json:"message"`
+	} `json:"progress"`
+}
+
+// fetchJob queries a server for the status of a background job
+func fetchJob(addr, id string) (*backgroundJob, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("%s/admin/v1/jobs/%s", addr, id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var job backgroundJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
 // formatBytes formats bytes into human-readable format
 func formatBytes(bytes float64) string {
 	const unit = 1024
@@ -203,4 +647,15 @@ func init() {
 	rootCmd.AddCommand(adminCmd)
 	adminCmd.AddCommand(metricsCmd)
 	adminCmd.AddCommand(purgeCmd)
+	adminCmd.AddCommand(decommissionCmd)
+	adminCmd.AddCommand(rebalanceCmd)
+	adminCmd.AddCommand(rewrapKeysCmd)
+	adminCmd.AddCommand(applyCmd)
+
+	rebalanceCmd.Flags().Float64Var(&rebalanceFraction, "fraction", 1, "largest share of the node's objects to move, 0-1")
+	applyCmd.Flags().StringVarP(&applyFile, "file", "f", "", "spec to apply, - for stdin")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "print the changes without making them")
+	applyCmd.Flags().BoolVar(&applyPrune, "prune", false, "delete buckets and users the spec leaves out")
+	applyCmd.MarkFlagRequired("file")
+	rebalanceCmd.Flags().Int64Var(&rebalanceRate, "rate", 0, "transfer limit in bytes per second, 0 for unlimited (default: server setting)")
 }
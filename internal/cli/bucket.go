@@ -3,7 +3,6 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 
@@ -39,8 +38,7 @@ var bucketCreateCmd = &cobra.Command{
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Error creating bucket: %s (Status: %d)\n", string(body), resp.StatusCode)
+			fmt.Printf("Error creating bucket: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
 			os.Exit(1)
 		}
 
@@ -69,8 +67,7 @@ var bucketListCmd = &cobra.Command{
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Error listing buckets: %s (Status: %d)\n", string(body), resp.StatusCode)
+			fmt.Printf("Error listing buckets: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
 			os.Exit(1)
 		}
 
@@ -93,7 +90,7 @@ var bucketCountCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		bucket := args[0]
 
-		url := fmt.Sprintf("%s/admin/%s/objects", serverAddr, bucket)
+		url := fmt.Sprintf("%s/admin/v1/buckets/%s/objects", serverAddr, bucket)
 
 		req, err := http.NewRequest("DELETE", url, nil)
 		if err != nil {
@@ -110,8 +107,7 @@ var bucketCountCmd = &cobra.Command{
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			fmt.Printf("Error getting bucket info: %s (Status: %d)\n", string(body), resp.StatusCode)
+			fmt.Printf("Error getting bucket info: %s (Status: %d)\n", responseError(resp), resp.StatusCode)
 			os.Exit(1)
 		}
 
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielino/comio/internal/config"
+	"github.com/danielino/comio/internal/database"
+)
+
+var (
+	dbForce bool
+	dbSteps int
+)
+
+// dbCmd manages the schema of the SQLite metadata database. Unlike the
+// other admin commands it works on the database file directly, so run it
+// on the server host, ideally with the server stopped.
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the metadata database schema",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openAdminDB()
+		defer db.Close()
+		ctx := context.Background()
+
+		if dbForce {
+			if err := db.ForceClean(ctx); err != nil {
+				fmt.Printf("Error clearing dirty state: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		applied, err := db.Migrate(ctx)
+		for _, version := range applied {
+			fmt.Printf("✓ Applied migration %d\n", version)
+		}
+		if err != nil {
+			exitMigrationError(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	},
+}
+
+var dbRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Revert the most recent schema migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		if dbSteps < 1 {
+			fmt.Println("Error: --steps must be at least 1")
+			os.Exit(1)
+		}
+
+		db := openAdminDB()
+		defer db.Close()
+		ctx := context.Background()
+
+		if dbForce {
+			if err := db.ForceClean(ctx); err != nil {
+				fmt.Printf("Error clearing dirty state: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		reverted, err := db.Rollback(ctx, dbSteps)
+		for _, version := range reverted {
+			fmt.Printf("✓ Rolled back migration %d\n", version)
+		}
+		if err != nil {
+			exitMigrationError(err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migrations to roll back")
+		}
+	},
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which schema migrations are applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openAdminDB()
+		defer db.Close()
+
+		statuses, err := db.MigrationStatus(context.Background())
+		if err != nil {
+			fmt.Printf("Error reading migrations: %v\n", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		dirty := false
+		for _, s := range statuses {
+			state, appliedAt := "pending", "-"
+			switch {
+			case s.Dirty:
+				state = "dirty"
+				dirty = true
+			case s.Applied:
+				state = "applied"
+			}
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+		}
+		w.Flush()
+
+		if dirty {
+			fmt.Println("\nA migration did not complete. Check the schema, then rerun migrate or rollback with --force.")
+			os.Exit(1)
+		}
+	},
+}
+
+// openAdminDB opens the configured database without migrating it
+func openAdminDB() *database.DB {
+	cfg, err := config.LoadConfig(cfgFile)
+	if err != nil {
+		fmt.Println("Error loading config:", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(cfg.Database.Path); err != nil && !cfg.Database.Enabled {
+		fmt.Printf("Error: no database at %s, and database.enabled is false\n", cfg.Database.Path)
+		os.Exit(1)
+	}
+
+	db, err := database.Open(database.Config{Path: cfg.Database.Path, SkipMigrations: true})
+	if err != nil {
+		fmt.Printf("Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	return db
+}
+
+func exitMigrationError(err error) {
+	fmt.Printf("✗ %v\n", err)
+	if errors.Is(err, database.ErrDirty) {
+		fmt.Println("Check the schema with 'comio admin db status', then rerun with --force.")
+	}
+	os.Exit(1)
+}
+
+func init() {
+	adminCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbRollbackCmd)
+	dbCmd.AddCommand(dbStatusCmd)
+
+	dbMigrateCmd.Flags().BoolVar(&dbForce, "force", false, "clear the dirty state left by an interrupted migration first")
+	dbRollbackCmd.Flags().BoolVar(&dbForce, "force", false, "clear the dirty state left by an interrupted migration first")
+	dbRollbackCmd.Flags().IntVar(&dbSteps, "steps", 1, "number of migrations to revert")
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/metafile"
+	"github.com/danielino/comio/internal/object"
+)
+
+var (
+	upgradeMetadataDir    string
+	upgradeMetadataDryRun bool
+)
+
+// upgradeMetadataCmd rewrites the file repositories' metadata in the
+// current schema version. Like the db commands it works on the files
+// directly, so run it on the server host.
+var upgradeMetadataCmd = &cobra.Command{
+	Use:   "upgrade-metadata",
+	Short: "Rewrite metadata files in the current schema version",
+	Long: `Rewrites the bucket and object metadata files written by older versions in
+the current schema version. The server upgrades files as it reads them, so
+this is only needed to convert everything at once. Corrupt files are listed
+and moved to the quarantine directory; with --dry-run they are only listed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stat(upgradeMetadataDir); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		buckets, err := bucket.NewFileRepository(upgradeMetadataDir)
+		if err != nil {
+			fmt.Printf("Error opening bucket metadata: %v\n", err)
+			os.Exit(1)
+		}
+		objects, err := object.NewFileRepository(upgradeMetadataDir)
+		if err != nil {
+			fmt.Printf("Error opening object metadata: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx := context.Background()
+		bucketResult, err := buckets.UpgradeMetadata(ctx, upgradeMetadataDryRun)
+		reportUpgrade("bucket", bucketResult)
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		objectResult, err := objects.UpgradeMetadata(ctx, upgradeMetadataDryRun)
+		reportUpgrade("object", objectResult)
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(bucketResult.Invalid)+len(objectResult.Invalid) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func reportUpgrade(kind string, result metafile.UpgradeResult) {
+	verb := "Upgraded"
+	if upgradeMetadataDryRun {
+		verb = "Would upgrade"
+	}
+	fmt.Printf("✓ %s %d of %d %s metadata files\n", verb, result.Upgraded, result.Scanned, kind)
+	for _, path := range result.Invalid {
+		fmt.Printf("  ✗ invalid: %s\n", path)
+	}
+}
+
+func init() {
+	adminCmd.AddCommand(upgradeMetadataCmd)
+
+	upgradeMetadataCmd.Flags().StringVar(&upgradeMetadataDir, "dir", "metadata", "metadata directory of the server")
+	upgradeMetadataCmd.Flags().BoolVar(&upgradeMetadataDryRun, "dry-run", false, "count the files to upgrade without rewriting them")
+}
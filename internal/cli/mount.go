@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/danielino/comio/internal/mount"
+	"github.com/danielino/comio/pkg/client"
+)
+
+var (
+	mountAccessKey string
+	mountSecretKey string
+	mountEndpoint  string
+)
+
+var mountCmd = &cobra.Command{
+	Use:   "mount <bucket> <dir>",
+	Short: "Mount a bucket as a filesystem",
+	Long: `Mount a bucket at a directory through FUSE. Keys are paths, with slashes
+separating directories. Files are read lazily with range requests; files
+opened for writing are staged locally and uploaded when closed. Runs until
+interrupted, then unmounts.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, dir := args[0], args[1]
+
+		c, err := client.New(client.Config{
+			Endpoint:  mountEndpoint,
+			AccessKey: mountAccessKey,
+			SecretKey: mountSecretKey,
+		})
+		if err != nil {
+			fmt.Printf("Error creating client: %v\n", err)
+			os.Exit(1)
+		}
+
+		fs := mount.NewFS(mount.Config{
+			Client: c,
+			Bucket: bucket,
+			UID:    uint32(os.Getuid()),
+			GID:    uint32(os.Getgid()),
+		})
+		server, err := mount.Mount(dir, fs)
+		if err != nil {
+			fmt.Printf("Error mounting bucket: %v\n", err)
+			os.Exit(1)
+		}
+
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			if err := server.Unmount(); err != nil {
+				fmt.Printf("Error unmounting %s: %v\n", dir, err)
+			}
+		}()
+
+		fmt.Printf("Mounted bucket %s at %s\n", bucket, dir)
+		if err := server.Wait(); err != nil {
+			fmt.Printf("Error serving mount: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Unmounted %s\n", dir)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mountCmd)
+
+	mountCmd.Flags().StringVar(&mountEndpoint, "endpoint", serverAddr, "server URL")
+	mountCmd.Flags().StringVar(&mountAccessKey, "access-key", "", "access key; requests are unsigned when empty")
+	mountCmd.Flags().StringVar(&mountSecretKey, "secret-key", "", "secret key")
+}
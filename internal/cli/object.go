@@ -3,10 +3,14 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/pkg/client"
 )
 
 // objectCmd represents the object command
@@ -38,6 +42,11 @@ var objectPutCmd = &cobra.Command{
 		}
 		fileSize := fileInfo.Size()
 
+		if putResumable || putSession != "" {
+			putObjectResumable(cmd, bucket, key, file, fileSize)
+			return
+		}
+
 		// TODO: Get server address from config
 		url := fmt.Sprintf("%s/%s/%s", serverAddr, bucket, key)
 
@@ -69,6 +78,41 @@ var objectPutCmd = &cobra.Command{
 	},
 }
 
+var (
+	putResumable bool
+	putChunkSize int64
+	putSession   string
+)
+
+// putObjectResumable uploads a file in chunks over the resumable upload
+// protocol, printing the session so an interrupted upload can be resumed
+func putObjectResumable(cmd *cobra.Command, bucket, key string, file *os.File, size int64) {
+	c, err := client.New(client.Config{Endpoint: serverAddr})
+	if err != nil {
+		fmt.Printf("Error creating client: %v\n", err)
+		os.Exit(1)
+	}
+
+	var session string
+	_, err = c.ResumableUpload(cmd.Context(), bucket, key, file, size, &client.ResumableOptions{
+		ChunkSize: putChunkSize,
+		SessionID: putSession,
+		OnSession: func(id string) { session = id },
+		Progress: func(transferred, total int64) {
+			fmt.Printf("\r%d / %d bytes", transferred, total)
+		},
+	})
+	if err != nil {
+		fmt.Printf("\nError uploading object: %v\n", err)
+		if session != "" {
+			fmt.Printf("Resume with: comio object put --session %s %s %s %s\n", session, bucket, key, file.Name())
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("\nSuccessfully uploaded object %s/%s\n", bucket, key)
+}
+
 var objectListCmd = &cobra.Command{
 	Use:   "list <bucket> [prefix]",
 	Short: "List objects in a bucket",
@@ -105,33 +149,73 @@ var objectListCmd = &cobra.Command{
 		}
 
 		// Read and parse response
-		var result map[string]interface{}
+		var result object.ListResult
 		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 			fmt.Printf("Error decoding response: %v\n", err)
 			os.Exit(1)
 		}
 
-		objects, ok := result["Objects"].([]interface{})
-		if !ok || len(objects) == 0 {
+		if len(result.Objects) == 0 {
 			fmt.Printf("No objects found in bucket %s\n", bucket)
 			return
 		}
 
 		fmt.Printf("Objects in bucket %s:\n", bucket)
-		for _, obj := range objects {
-			o := obj.(map[string]interface{})
-			key, keyOk := o["key"].(string)
-			if !keyOk {
-				key, keyOk = o["Key"].(string)
-			}
-			size, sizeOk := o["size"].(float64)
-			if !sizeOk {
-				size, _ = o["Size"].(float64)
-			}
-			if keyOk {
-				fmt.Printf("  %s (%d bytes)\n", key, int64(size))
-			}
+		for _, o := range result.Objects {
+			fmt.Printf("  %s (%d bytes, %s)\n", o.Key, o.Size, o.StorageClass)
+		}
+	},
+}
+
+var (
+	getConcurrency int
+	getPartSize    int64
+)
+
+var objectGetCmd = &cobra.Command{
+	Use:   "get <bucket> <key> <file>",
+	Short: "Download an object",
+	Long: `Download an object to a file. Large objects are downloaded as parallel
+range requests and checked against the checksum returned by the server.`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		bucket, key, filePath := args[0], args[1], args[2]
+
+		c, err := client.New(client.Config{
+			Endpoint:            serverAddr,
+			DownloadConcurrency: getConcurrency,
+			DownloadPartSize:    getPartSize,
+		})
+		if err != nil {
+			fmt.Printf("Error creating client: %v\n", err)
+			os.Exit(1)
 		}
+
+		body, info, err := c.GetObject(cmd.Context(), bucket, key, &client.GetOptions{
+			Progress: func(transferred, total int64) {
+				fmt.Printf("\r%d / %d bytes", transferred, total)
+			},
+		})
+		if err != nil {
+			fmt.Printf("Error downloading object: %v\n", err)
+			os.Exit(1)
+		}
+		defer body.Close()
+
+		file, err := os.Create(filePath)
+		if err != nil {
+			fmt.Printf("Error creating file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(file, body); err != nil {
+			fmt.Printf("\nError downloading object: %v\n", err)
+			os.Remove(filePath)
+			os.Exit(1)
+		}
+
+		fmt.Printf("\nSuccessfully downloaded %s/%s (%d bytes) to %s\n", bucket, key, info.Size, filePath)
 	},
 }
 
@@ -139,4 +223,11 @@ func init() {
 	rootCmd.AddCommand(objectCmd)
 	objectCmd.AddCommand(objectPutCmd)
 	objectCmd.AddCommand(objectListCmd)
+	objectCmd.AddCommand(objectGetCmd)
+
+	objectPutCmd.Flags().BoolVar(&putResumable, "resumable", false, "upload in chunks that survive connection failures")
+	objectPutCmd.Flags().Int64Var(&putChunkSize, "chunk-size", client.DefaultChunkSize, "size in bytes of each chunk of a resumable upload")
+	objectPutCmd.Flags().StringVar(&putSession, "session", "", "resume the resumable upload with this session ID")
+	objectGetCmd.Flags().IntVar(&getConcurrency, "concurrency", client.DefaultDownloadConcurrency, "parallel range requests, 1 to download with a single request")
+	objectGetCmd.Flags().Int64Var(&getPartSize, "part-size", client.DefaultDownloadPartSize, "size in bytes of each range request")
 }
@@ -1,13 +1,17 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 
 	"github.com/spf13/cobra"
 
 	"github.com/danielino/comio/internal/config"
 	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/s3"
 )
 
 const serverAddr = "http://localhost:8080"
@@ -53,4 +57,19 @@ func initConfig() {
 		fmt.Println("Error initializing logger:", err)
 		os.Exit(1)
 	}
+	if err := monitoring.SetModuleLevels(cfg.Logging.Levels); err != nil {
+		fmt.Println("Error initializing logger:", err)
+		os.Exit(1)
+	}
+}
+
+// responseError returns the error carried by a failed response, as
+// "Code: message" when the server sent an error document
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(resp.Body)
+	var e s3.ErrorResponse
+	if err := json.Unmarshal(body, &e); err == nil && e.Code != "" {
+		return e.Error()
+	}
+	return string(body)
 }
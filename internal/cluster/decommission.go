@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+)
+
+// Decommissioner drains the local node: it makes the node read-only,
+// copies every object to the node that owns it among the remaining ones,
+// and takes the node out of the ring once all objects are safe elsewhere
+type Decommissioner struct {
+	ring    *Ring
+	buckets *bucket.Service
+	objects *object.Service
+	token   string // Bearer token for requests to other nodes
+}
+
+// NewDecommissioner creates a decommissioner for the local node of ring
+func NewDecommissioner(ring *Ring, buckets *bucket.Service, objects *object.Service, token string) *Decommissioner {
+	return &Decommissioner{
+		ring:    ring,
+		buckets: buckets,
+		objects: objects,
+		token:   token,
+	}
+}
+
+// Run drains the node as a job. Objects that cannot be migrated fail the
+// job and leave the node draining, so running it again resumes the drain:
+// objects the owner already holds are not copied twice.
+func (d *Decommissioner) Run(ctx context.Context, h *jobs.Handle) error {
+	if d.ring.State() == NodeDecommissioned {
+		return nil
+	}
+	if err := d.ring.SetState(NodeDraining); err != nil {
+		return err
+	}
+
+	buckets, err := d.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list buckets: %w", err)
+	}
+	var total int64
+	for _, b := range buckets {
+		count, _, err := d.objects.CountObjects(ctx, b.Name)
+		if err != nil {
+			return fmt.Errorf("failed to count objects of %s: %w", b.Name, err)
+		}
+		total += int64(count)
+	}
+	h.SetTotal(total)
+
+	var failed int64
+	peers := newPeerClient(d.objects, d.token)
+	for _, b := range buckets {
+		h.SetMessage("migrating " + b.Name)
+		err := d.objects.WalkObjects(ctx, b.Name, "", "", func(obj *object.Object) error {
+			if err := d.migrate(ctx, peers, obj); err != nil {
+				if errors.Is(err, ErrNoTargets) {
+					return err
+				}
+				failed++
+				monitoring.Log.Warn("Failed to migrate object off decommissioned node",
+					zap.String("bucket", obj.BucketName),
+					zap.String("key", obj.Key),
+					zap.Error(err))
+			}
+			h.Add(1, obj.Size)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate bucket %s: %w", b.Name, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d objects were not migrated; node %s stays draining", failed, total, d.ring.Self())
+	}
+
+	if err := d.ring.SetState(NodeDecommissioned); err != nil {
+		return err
+	}
+	h.SetMessage("all objects migrated, node removed from the ring")
+	monitoring.Log.Info("Node decommissioned",
+		zap.String("node_id", d.ring.Self()),
+		zap.Int64("objects", total))
+	return nil
+}
+
+// migrate makes sure the owner of obj holds an identical copy
+func (d *Decommissioner) migrate(ctx context.Context, peers *peerClient, obj *object.Object) error {
+	owner, err := d.ring.Owner(obj.BucketName, obj.Key)
+	if err != nil {
+		return err
+	}
+	return peers.copy(ctx, owner, obj)
+}
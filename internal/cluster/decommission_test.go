@@ -0,0 +1,206 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// fakeNode is a remaining node accepting migrated buckets and objects
+type fakeNode struct {
+	mu      sync.Mutex
+	buckets map[string]bool
+	objects map[string][]byte // "bucket/key" -> data
+	puts    int
+	fail    bool
+}
+
+func newFakeNode(t *testing.T) (*fakeNode, string) {
+	n := &fakeNode{buckets: make(map[string]bool), objects: make(map[string][]byte)}
+	server := httptest.NewServer(n)
+	t.Cleanup(server.Close)
+	return n, server.URL
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	isBucket := !strings.Contains(path, "/")
+	switch {
+	case isBucket && r.Method == http.MethodHead:
+		if !n.buckets[path] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case isBucket && r.Method == http.MethodPut:
+		n.buckets[path] = true
+	case r.Method == http.MethodHead:
+		data, ok := n.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"`+md5Hex(data)+`"`)
+	case r.Method == http.MethodPut:
+		if n.fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		n.objects[path] = data
+		n.puts++
+		w.Header().Set("ETag", `"`+md5Hex(data)+`"`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestServices(t *testing.T) (*bucket.Service, *object.Service) {
+	f, err := os.CreateTemp("", "cluster_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	f.Close()
+
+	engine, err := storage.NewSimpleEngine(f.Name(), 64*1024*1024, 4*1024*1024)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	t.Cleanup(func() { engine.Close() })
+
+	return bucket.NewService(bucket.NewMemoryRepository()), object.NewService(object.NewMemoryRepository(), engine)
+}
+
+func runDecommission(t *testing.T, d *Decommissioner) jobs.Job {
+	return runJob(t, d.Run)
+}
+
+// runJob runs fn as a job and waits for it to finish
+func runJob(t *testing.T, fn jobs.RunFunc) jobs.Job {
+	manager, err := jobs.NewManager(jobs.Config{Workers: 1, PersistInterval: 10 * time.Millisecond}, jobs.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	manager.Start()
+	t.Cleanup(manager.Stop)
+
+	job, err := manager.Submit(jobs.Spec{Type: "test"}, fn)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, _ := manager.Get(job.ID); j.State.Finished() {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job did not finish in time")
+	return jobs.Job{}
+}
+
+func TestDecommissioner_MigratesAndLeavesRing(t *testing.T) {
+	ctx := context.Background()
+	node2, url2 := newFakeNode(t)
+	node3, url3 := newFakeNode(t)
+	ring, err := NewRing(t.TempDir(), "node-1", map[string]string{
+		"node-1": "http://unused",
+		"node-2": url2,
+		"node-3": url3,
+	})
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	buckets, objects := newTestServices(t)
+	if err := buckets.CreateBucket(ctx, "photos", "owner"); err != nil {
+		t.Fatalf("CreateBucket() error = %v", err)
+	}
+	for _, key := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "f.jpg"} {
+		data := []byte("content of " + key)
+		if _, err := objects.PutObject(ctx, "photos", key, bytes.NewReader(data), int64(len(data)), "image/jpeg"); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+	}
+
+	// One object was already re-replicated and must not be sent again
+	owner, _ := ring.Owner("photos", "a.jpg")
+	already := map[string]*fakeNode{"node-2": node2, "node-3": node3}[owner.ID]
+	already.objects["photos/a.jpg"] = []byte("content of a.jpg")
+
+	job := runDecommission(t, NewDecommissioner(ring, buckets, objects, ""))
+	if job.State != jobs.StateCompleted {
+		t.Fatalf("State = %s (%s), want completed", job.State, job.Error)
+	}
+	if job.Progress.Total != 6 || job.Progress.Done != 6 {
+		t.Errorf("Progress = %+v, want 6/6", job.Progress)
+	}
+	if got := len(node2.objects) + len(node3.objects); got != 6 {
+		t.Errorf("remaining nodes hold %d objects, want 6", got)
+	}
+	if node2.puts+node3.puts != 5 {
+		t.Errorf("sent %d objects, want 5", node2.puts+node3.puts)
+	}
+	if ring.State() != NodeDecommissioned {
+		t.Errorf("State() = %s, want %s", ring.State(), NodeDecommissioned)
+	}
+}
+
+func TestDecommissioner_StaysDrainingOnFailure(t *testing.T) {
+	ctx := context.Background()
+	node2, url2 := newFakeNode(t)
+	node2.fail = true
+	ring, err := NewRing(t.TempDir(), "node-1", map[string]string{"node-2": url2})
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	buckets, objects := newTestServices(t)
+	buckets.CreateBucket(ctx, "docs", "owner")
+	objects.PutObject(ctx, "docs", "report.pdf", strings.NewReader("pdf"), 3, "application/pdf")
+
+	d := NewDecommissioner(ring, buckets, objects, "")
+	job := runDecommission(t, d)
+	if job.State != jobs.StateFailed {
+		t.Fatalf("State = %s, want failed", job.State)
+	}
+	if ring.State() != NodeDraining || !ring.ReadOnly() {
+		t.Errorf("State() = %s, want read-only %s", ring.State(), NodeDraining)
+	}
+
+	// Running again once the node recovers finishes the drain
+	node2.mu.Lock()
+	node2.fail = false
+	node2.mu.Unlock()
+	job = runDecommission(t, d)
+	if job.State != jobs.StateCompleted {
+		t.Fatalf("State = %s (%s), want completed", job.State, job.Error)
+	}
+	if ring.State() != NodeDecommissioned {
+		t.Errorf("State() = %s, want %s", ring.State(), NodeDecommissioned)
+	}
+}
@@ -0,0 +1,198 @@
+// Package cluster keeps comio metadata consistent across nodes.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/raft"
+)
+
+// Metadata mutations replicated through the raft log
+const (
+	opCreateBucket     = "create_bucket"
+	opUpdateBucket     = "update_bucket"
+	opDeleteBucket     = "delete_bucket"
+	opPutObject        = "put_object"
+	opDeleteObject     = "delete_object"
+	opDeleteAllObjects = "delete_all_objects"
+)
+
+type command struct {
+	Op        string         `json:"op"`
+	Bucket    *bucket.Bucket `json:"bucket,omitempty"`
+	Object    *object.Object `json:"object,omitempty"`
+	Name      string         `json:"name,omitempty"` // Bucket name
+	Key       string         `json:"key,omitempty"`
+	VersionID *string        `json:"version_id,omitempty"`
+}
+
+// MetadataFSM applies replicated metadata mutations to the local bucket and
+// object repositories
+type MetadataFSM struct {
+	buckets bucket.Repository
+	objects object.Repository
+}
+
+// NewMetadataFSM creates a state machine over local repositories
+func NewMetadataFSM(buckets bucket.Repository, objects object.Repository) *MetadataFSM {
+	return &MetadataFSM{buckets: buckets, objects: objects}
+}
+
+// Apply implements raft.FSM
+func (f *MetadataFSM) Apply(data []byte) error {
+	var cmd command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return fmt.Errorf("invalid metadata command: %w", err)
+	}
+
+	ctx := context.Background()
+	switch cmd.Op {
+	case opCreateBucket:
+		return f.buckets.Create(ctx, cmd.Bucket)
+	case opUpdateBucket:
+		return f.buckets.Update(ctx, cmd.Bucket)
+	case opDeleteBucket:
+		return f.buckets.Delete(ctx, cmd.Name)
+	case opPutObject:
+		return f.objects.Put(ctx, cmd.Object, nil)
+	case opDeleteObject:
+		return f.objects.Delete(ctx, cmd.Name, cmd.Key, cmd.VersionID)
+	case opDeleteAllObjects:
+		_, _, err := f.objects.DeleteAll(ctx, cmd.Name)
+		return err
+	default:
+		return fmt.Errorf("unknown metadata command %q", cmd.Op)
+	}
+}
+
+// metadataSnapshot is the full metadata state
+type metadataSnapshot struct {
+	Buckets []*bucket.Bucket `json:"buckets"`
+	Objects []*object.Object `json:"objects"`
+}
+
+// Snapshot implements raft.FSM
+func (f *MetadataFSM) Snapshot() ([]byte, error) {
+	ctx := context.Background()
+	buckets, err := f.buckets.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	snap := metadataSnapshot{Buckets: buckets}
+	for _, b := range buckets {
+		err := f.objects.Iterate(ctx, b.Name, "", func(obj *object.Object) error {
+			snap.Objects = append(snap.Objects, obj)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects of %s: %w", b.Name, err)
+		}
+	}
+	return json.Marshal(snap)
+}
+
+// Restore implements raft.FSM, replacing all local metadata
+func (f *MetadataFSM) Restore(data []byte) error {
+	var snap metadataSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("invalid metadata snapshot: %w", err)
+	}
+
+	ctx := context.Background()
+	existing, err := f.buckets.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list buckets: %w", err)
+	}
+	for _, b := range existing {
+		if _, _, err := f.objects.DeleteAll(ctx, b.Name); err != nil {
+			return fmt.Errorf("failed to clear objects of %s: %w", b.Name, err)
+		}
+		if err := f.buckets.Delete(ctx, b.Name); err != nil {
+			return fmt.Errorf("failed to clear bucket %s: %w", b.Name, err)
+		}
+	}
+
+	for _, b := range snap.Buckets {
+		if err := f.buckets.Create(ctx, b); err != nil {
+			return fmt.Errorf("failed to restore bucket %s: %w", b.Name, err)
+		}
+	}
+	for _, obj := range snap.Objects {
+		if err := f.objects.Put(ctx, obj, nil); err != nil {
+			return fmt.Errorf("failed to restore object %s/%s: %w", obj.BucketName, obj.Key, err)
+		}
+	}
+	return nil
+}
+
+func propose(ctx context.Context, node *raft.Node, cmd command) error {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return node.Propose(ctx, data)
+}
+
+// BucketRepository is a bucket.Repository whose mutations go through raft.
+// Reads are served from the local replica.
+type BucketRepository struct {
+	bucket.Repository
+	node *raft.Node
+}
+
+// NewBucketRepository wraps the local repository the FSM applies to
+func NewBucketRepository(local bucket.Repository, node *raft.Node) *BucketRepository {
+	return &BucketRepository{Repository: local, node: node}
+}
+
+func (r *BucketRepository) Create(ctx context.Context, b *bucket.Bucket) error {
+	return propose(ctx, r.node, command{Op: opCreateBucket, Bucket: b})
+}
+
+func (r *BucketRepository) Update(ctx context.Context, b *bucket.Bucket) error {
+	return propose(ctx, r.node, command{Op: opUpdateBucket, Bucket: b})
+}
+
+func (r *BucketRepository) Delete(ctx context.Context, name string) error {
+	return propose(ctx, r.node, command{Op: opDeleteBucket, Name: name})
+}
+
+// ObjectRepository is an object.Repository whose mutations go through
+// raft. Reads are served from the local replica.
+type ObjectRepository struct {
+	object.Repository
+	node *raft.Node
+}
+
+// NewObjectRepository wraps the local repository the FSM applies to
+func NewObjectRepository(local object.Repository, node *raft.Node) *ObjectRepository {
+	return &ObjectRepository{Repository: local, node: node}
+}
+
+func (r *ObjectRepository) Put(ctx context.Context, obj *object.Object, data io.Reader) error {
+	return propose(ctx, r.node, command{Op: opPutObject, Object: obj})
+}
+
+func (r *ObjectRepository) Delete(ctx context.Context, bucketName, key string, versionID *string) error {
+	return propose(ctx, r.node, command{Op: opDeleteObject, Name: bucketName, Key: key, VersionID: versionID})
+}
+
+func (r *ObjectRepository) DeleteAll(ctx context.Context, bucketName string) (int, int64, error) {
+	count, size, err := r.Repository.Count(ctx, bucketName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := propose(ctx, r.node, command{Op: opDeleteAllObjects, Name: bucketName}); err != nil {
+		return 0, 0, err
+	}
+	return count, size, nil
+}
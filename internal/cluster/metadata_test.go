@@ -0,0 +1,162 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/raft"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+type testNode struct {
+	node    *raft.Node
+	buckets *BucketRepository
+	objects *ObjectRepository
+	local   object.Repository
+}
+
+// startCluster runs a metadata raft group over the HTTP transport
+func startCluster(t *testing.T, size int) []*testNode {
+	const token = "secret"
+
+	// Servers first, so every node knows all peer addresses
+	handlers := make([]http.Handler, size)
+	peers := make(map[string]string)
+	for i := 0; i < size; i++ {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		peers[fmt.Sprintf("node-%d", i)] = server.URL
+	}
+
+	nodes := make([]*testNode, size)
+	for i := 0; i < size; i++ {
+		localBuckets := bucket.NewMemoryRepository()
+		localObjects := object.NewMemoryRepository()
+		node, err := raft.NewNode(raft.Config{
+			ID:              fmt.Sprintf("node-%d", i),
+			Peers:           peers,
+			Dir:             t.TempDir(),
+			ElectionTimeout: 150 * time.Millisecond,
+		}, NewMetadataFSM(localBuckets, localObjects), raft.NewHTTPTransport(token))
+		if err != nil {
+			t.Fatalf("NewNode() error = %v", err)
+		}
+		handlers[i] = node.Handler(token)
+		nodes[i] = &testNode{
+			node:    node,
+			buckets: NewBucketRepository(localBuckets, node),
+			objects: NewObjectRepository(localObjects, node),
+			local:   localObjects,
+		}
+	}
+	for _, n := range nodes {
+		if err := n.node.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		t.Cleanup(n.node.Stop)
+	}
+	return nodes
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetadataReplication(t *testing.T) {
+	nodes := startCluster(t, 3)
+	ctx := context.Background()
+	waitFor(t, "a leader", func() bool {
+		for _, n := range nodes {
+			if id, _ := n.node.Leader(); id == "" {
+				return false
+			}
+		}
+		return true
+	})
+
+	// Writes on any node go through the leader
+	var follower *testNode
+	for _, n := range nodes {
+		if !n.node.IsLeader() {
+			follower = n
+			break
+		}
+	}
+	service := bucket.NewService(follower.buckets)
+	if err := service.CreateBucket(ctx, "photos", "alice"); err != nil {
+		t.Fatalf("CreateBucket() on a follower error = %v", err)
+	}
+	if err := follower.objects.Put(ctx, &object.Object{BucketName: "photos", Key: "a.jpg", Size: 3, ETag: "abc"}, nil); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	for i, n := range nodes {
+		waitFor(t, fmt.Sprintf("node %d to apply", i), func() bool {
+			_, err := n.buckets.Get(ctx, "photos")
+			obj, headErr := n.objects.Head(ctx, "photos", "a.jpg", nil)
+			return err == nil && headErr == nil && obj.ETag == "abc"
+		})
+	}
+
+	// A conflicting create fails identically wherever it is proposed
+	if err := bucket.NewService(nodes[0].buckets).CreateBucket(ctx, "photos", "bob"); err == nil {
+		t.Error("CreateBucket() of an existing bucket should fail")
+	}
+
+	if err := nodes[2].objects.Delete(ctx, "photos", "a.jpg", nil); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	for i, n := range nodes {
+		waitFor(t, fmt.Sprintf("node %d to delete", i), func() bool {
+			count, _, _ := n.local.Count(ctx, "photos")
+			return count == 0
+		})
+	}
+}
+
+func TestMetadataFSM_SnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	source := NewMetadataFSM(bucket.NewMemoryRepository(), object.NewMemoryRepository())
+	source.buckets.Create(ctx, &bucket.Bucket{Name: "docs", Owner: "alice"})
+	source.objects.Put(ctx, &object.Object{BucketName: "docs", Key: "readme", Size: 10}, nil)
+
+	data, err := source.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	// The target holds stale state that the snapshot replaces
+	target := NewMetadataFSM(bucket.NewMemoryRepository(), object.NewMemoryRepository())
+	target.buckets.Create(ctx, &bucket.Bucket{Name: "stale"})
+	target.objects.Put(ctx, &object.Object{BucketName: "stale", Key: "old"}, nil)
+
+	if err := target.Restore(data); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if _, err := target.buckets.Get(ctx, "stale"); err == nil {
+		t.Error("stale bucket survived the restore")
+	}
+	if obj, err := target.objects.Head(ctx, "docs", "readme", nil); err != nil || obj.Size != 10 {
+		t.Errorf("restored object = %+v, %v", obj, err)
+	}
+}
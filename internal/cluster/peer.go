@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielino/comio/internal/object"
+)
+
+// peerClient copies local objects to other nodes of the ring
+type peerClient struct {
+	objects *object.Service
+	token   string // Bearer token for requests to other nodes
+	client  *http.Client
+	created map[string]bool // "node/bucket" known to exist on the node
+}
+
+func newPeerClient(objects *object.Service, token string) *peerClient {
+	return &peerClient{
+		objects: objects,
+		token:   token,
+		client:  &http.Client{Timeout: 5 * time.Minute},
+		created: make(map[string]bool),
+	}
+}
+
+// copy makes sure owner holds an identical copy of obj. Objects the owner
+// already holds with the same ETag are not sent again.
+func (p *peerClient) copy(ctx context.Context, owner Member, obj *object.Object) error {
+	objectURL := fmt.Sprintf("%s/%s/%s", owner.URL, obj.BucketName, obj.Key)
+
+	// Already re-replicated, e.g. by async replication
+	etag, err := p.remoteETag(ctx, objectURL)
+	if err != nil {
+		return err
+	}
+	if etag == obj.ETag {
+		return nil
+	}
+
+	if bucketKey := owner.ID + "/" + obj.BucketName; !p.created[bucketKey] {
+		if err := p.createBucket(ctx, owner, obj.BucketName); err != nil {
+			return err
+		}
+		p.created[bucketKey] = true
+	}
+
+	_, data, err := p.objects.GetObject(ctx, obj.BucketName, obj.Key, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	defer data.Close()
+
+	req, err := p.request(ctx, http.MethodPut, objectURL, data)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = obj.Size
+	if obj.ContentType != "" {
+		req.Header.Set("Content-Type", obj.ContentType)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("node %s returned %d: %s", owner.ID, resp.StatusCode, string(body))
+	}
+
+	// Only count the object as safe once the owner confirms the content
+	if got := strings.Trim(resp.Header.Get("ETag"), `"`); got != "" && got != obj.ETag {
+		return fmt.Errorf("node %s stored ETag %s, want %s", owner.ID, got, obj.ETag)
+	}
+	return nil
+}
+
+// remoteETag returns the ETag of an object on another node, or "" if the
+// node does not hold it
+func (p *peerClient) remoteETag(ctx context.Context, url string) (string, error) {
+	req, err := p.request(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", fmt.Errorf("HEAD %s returned %d", url, resp.StatusCode)
+	}
+}
+
+// createBucket creates a bucket on another node unless it exists
+func (p *peerClient) createBucket(ctx context.Context, owner Member, name string) error {
+	bucketURL := owner.URL + "/" + name
+	head, err := p.request(ctx, http.MethodHead, bucketURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(head)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	req, err := p.request(ctx, http.MethodPut, bucketURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err = p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("node %s returned %d creating bucket %s: %s", owner.ID, resp.StatusCode, name, string(body))
+	}
+	return nil
+}
+
+func (p *peerClient) request(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	return req, nil
+}
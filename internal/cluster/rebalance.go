@@ -0,0 +1,215 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+)
+
+// RebalanceConfig controls how much data a rebalance moves and how fast
+type RebalanceConfig struct {
+	Fraction       float64 // Largest share of the local objects moved by one run, 0-1
+	BytesPerSecond int64   // Transfer rate limit; 0 disables throttling
+}
+
+// DefaultRebalanceConfig returns default rebalance settings
+func DefaultRebalanceConfig() RebalanceConfig {
+	return RebalanceConfig{
+		Fraction:       1,
+		BytesPerSecond: 50 * 1024 * 1024,
+	}
+}
+
+// Rebalancer moves local objects that belong on other nodes, such as nodes
+// added to the ring since the objects were written, so utilization evens
+// out across the cluster
+type Rebalancer struct {
+	ring    *Ring
+	buckets *bucket.Service
+	objects *object.Service
+	token   string
+	config  RebalanceConfig
+}
+
+// NewRebalancer creates a rebalancer for the local node of ring
+func NewRebalancer(ring *Ring, buckets *bucket.Service, objects *object.Service, token string, config RebalanceConfig) *Rebalancer {
+	return &Rebalancer{
+		ring:    ring,
+		buckets: buckets,
+		objects: objects,
+		token:   token,
+		config:  config,
+	}
+}
+
+// Config returns the rebalance settings
+func (r *Rebalancer) Config() RebalanceConfig {
+	return r.config
+}
+
+// move is an object planned to go to another node
+type move struct {
+	obj   *object.Object
+	owner Member
+}
+
+// Run moves objects as a job using the configured settings
+func (r *Rebalancer) Run(ctx context.Context, h *jobs.Handle) error {
+	return r.RunWith(ctx, h, r.config)
+}
+
+// RunWith moves up to config.Fraction of the local objects to the nodes
+// they are placed on, throttled to config.BytesPerSecond. Progress counts
+// objects and bytes moved; the message carries an ETA.
+func (r *Rebalancer) RunWith(ctx context.Context, h *jobs.Handle, config RebalanceConfig) error {
+	if r.ring.ReadOnly() {
+		return fmt.Errorf("node %s is %s", r.ring.Self(), r.ring.State())
+	}
+
+	h.SetMessage("planning")
+	plan, local, err := r.plan(ctx)
+	if err != nil {
+		return err
+	}
+	if limit := int(math.Ceil(config.Fraction * float64(local))); len(plan) > limit {
+		plan = plan[:limit]
+	}
+
+	var planned int64
+	for _, m := range plan {
+		planned += m.obj.Size
+	}
+	h.SetTotal(int64(len(plan)))
+	h.SetMessage(fmt.Sprintf("moving %d of %d objects, %d bytes", len(plan), local, planned))
+
+	peers := newPeerClient(r.objects, r.token)
+	start := time.Now()
+	pace := &throttle{rate: config.BytesPerSecond, start: start}
+	var done, moved int64
+	var failed int
+	for _, m := range plan {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var bytes int64
+		if err := r.move(ctx, peers, m); err != nil {
+			failed++
+			monitoring.Log.Warn("Failed to move object during rebalance",
+				zap.String("bucket", m.obj.BucketName),
+				zap.String("key", m.obj.Key),
+				zap.String("node_id", m.owner.ID),
+				zap.Error(err))
+		} else {
+			bytes = m.obj.Size
+		}
+
+		moved += bytes
+		done += m.obj.Size
+		h.Add(1, bytes)
+		h.SetMessage(fmt.Sprintf("moved %d of %d bytes, eta %s", moved, planned, eta(start, done, planned)))
+		if err := pace.wait(ctx, m.obj.Size); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d objects were not moved", failed, len(plan))
+	}
+	monitoring.Log.Info("Rebalance finished",
+		zap.Int("objects", len(plan)),
+		zap.Int64("bytes", moved))
+	return nil
+}
+
+// plan lists the local objects placed on other nodes, and how many
+// objects the node holds
+func (r *Rebalancer) plan(ctx context.Context) ([]move, int64, error) {
+	buckets, err := r.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list buckets: %w", err)
+	}
+
+	var plan []move
+	var local int64
+	for _, b := range buckets {
+		err := r.objects.WalkObjects(ctx, b.Name, "", "", func(obj *object.Object) error {
+			local++
+			owner, err := r.ring.Placement(obj.BucketName, obj.Key)
+			if err != nil {
+				return err
+			}
+			if owner.ID != r.ring.Self() {
+				plan = append(plan, move{obj: obj, owner: owner})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to plan bucket %s: %w", b.Name, err)
+		}
+	}
+	return plan, local, nil
+}
+
+// move copies an object to its owner, then drops the local copy
+func (r *Rebalancer) move(ctx context.Context, peers *peerClient, m move) error {
+	if err := peers.copy(ctx, m.owner, m.obj); err != nil {
+		return err
+	}
+	if err := r.objects.EvictObject(ctx, m.obj); err != nil {
+		if errors.Is(err, object.ErrObjectChanged) {
+			// Overwritten while moving: the new version stays here until
+			// the next rebalance
+			return nil
+		}
+		return fmt.Errorf("failed to remove local copy: %w", err)
+	}
+	return nil
+}
+
+// eta estimates the time left from the rate so far
+func eta(start time.Time, done, total int64) time.Duration {
+	if done <= 0 || done >= total {
+		return 0
+	}
+	elapsed := time.Since(start)
+	return (time.Duration(float64(elapsed) * float64(total-done) / float64(done))).Round(time.Second)
+}
+
+// throttle paces transfers to a byte rate
+type throttle struct {
+	rate  int64 // Bytes per second; 0 is unlimited
+	start time.Time
+	sent  int64
+}
+
+// wait accounts for n bytes sent and sleeps until the rate allows more
+func (t *throttle) wait(ctx context.Context, n int64) error {
+	if t.rate <= 0 {
+		return nil
+	}
+	t.sent += n
+	due := t.start.Add(time.Duration(float64(t.sent) / float64(t.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/jobs"
+)
+
+func runRebalance(t *testing.T, r *Rebalancer, config RebalanceConfig) jobs.Job {
+	return runJob(t, func(ctx context.Context, h *jobs.Handle) error {
+		return r.RunWith(ctx, h, config)
+	})
+}
+
+func TestRebalancer_MovesObjectsOntoAddedNode(t *testing.T) {
+	ctx := context.Background()
+	node2, url2 := newFakeNode(t)
+	ring, err := NewRing(t.TempDir(), "node-1", map[string]string{"node-1": "http://unused", "node-2": url2})
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	buckets, objects := newTestServices(t)
+	buckets.CreateBucket(ctx, "logs", "owner")
+	want := 0
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("log-%02d", i)
+		objects.PutObject(ctx, "logs", key, strings.NewReader("entry "+key), int64(len("entry "+key)), "text/plain")
+		if owner, _ := ring.Placement("logs", key); owner.ID == "node-2" {
+			want++
+		}
+	}
+	if want == 0 || want == 20 {
+		t.Fatalf("placement put %d of 20 objects on node-2", want)
+	}
+
+	r := NewRebalancer(ring, buckets, objects, "", DefaultRebalanceConfig())
+	job := runRebalance(t, r, RebalanceConfig{Fraction: 1})
+	if job.State != jobs.StateCompleted {
+		t.Fatalf("State = %s (%s), want completed", job.State, job.Error)
+	}
+	if job.Progress.Total != int64(want) || job.Progress.Done != int64(want) {
+		t.Errorf("Progress = %+v, want %d objects", job.Progress, want)
+	}
+	if len(node2.objects) != want {
+		t.Errorf("node-2 holds %d objects, want %d", len(node2.objects), want)
+	}
+
+	// Moved objects are gone locally, the rest stayed
+	count, _, _ := objects.CountObjects(ctx, "logs")
+	if count != 20-want {
+		t.Errorf("local objects = %d, want %d", count, 20-want)
+	}
+	for key := range node2.objects {
+		if _, err := objects.GetObjectMetadata(ctx, "logs", strings.TrimPrefix(key, "logs/")); err == nil {
+			t.Errorf("%s still stored locally after the move", key)
+		}
+	}
+
+	// Nothing left to move
+	job = runRebalance(t, r, RebalanceConfig{Fraction: 1})
+	if job.Progress.Total != 0 {
+		t.Errorf("second run planned %d objects, want 0", job.Progress.Total)
+	}
+}
+
+func TestRebalancer_FractionAndThrottle(t *testing.T) {
+	ctx := context.Background()
+	node2, url2 := newFakeNode(t)
+	ring, err := NewRing(t.TempDir(), "node-1", map[string]string{"node-2": url2})
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	buckets, objects := newTestServices(t)
+	buckets.CreateBucket(ctx, "data", "owner")
+	data := strings.Repeat("x", 1000)
+	placed := 0
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("obj-%d", i)
+		objects.PutObject(ctx, "data", key, strings.NewReader(data), int64(len(data)), "")
+		if owner, _ := ring.Placement("data", key); owner.ID == "node-2" {
+			placed++
+		}
+	}
+	if placed < 2 {
+		t.Fatalf("placement put %d of 10 objects on node-2", placed)
+	}
+
+	// Only a fifth of the node's objects may move, however many belong
+	// on node-2
+	r := NewRebalancer(ring, buckets, objects, "", DefaultRebalanceConfig())
+	start := time.Now()
+	job := runRebalance(t, r, RebalanceConfig{Fraction: 0.2, BytesPerSecond: 10000})
+	if job.State != jobs.StateCompleted {
+		t.Fatalf("State = %s (%s), want completed", job.State, job.Error)
+	}
+	if job.Progress.Done != 2 || len(node2.objects) != 2 {
+		t.Errorf("moved %d objects, want 2 of 10", job.Progress.Done)
+	}
+	if job.Progress.Bytes != 2000 {
+		t.Errorf("moved %d bytes, want 2000", job.Progress.Bytes)
+	}
+	// 1000 bytes per object at 10000 bytes/s
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("moved 2000 bytes in %s, faster than the rate limit", elapsed)
+	}
+}
+
+func TestRebalancer_RefusesOnDrainingNode(t *testing.T) {
+	_, url2 := newFakeNode(t)
+	ring, _ := NewRing(t.TempDir(), "node-1", map[string]string{"node-2": url2})
+	ring.SetState(NodeDraining)
+
+	buckets, objects := newTestServices(t)
+	job := runRebalance(t, NewRebalancer(ring, buckets, objects, "", DefaultRebalanceConfig()), RebalanceConfig{Fraction: 1})
+	if job.State != jobs.StateFailed {
+		t.Errorf("State = %s, want failed", job.State)
+	}
+}
+
+func TestETA(t *testing.T) {
+	start := time.Now().Add(-10 * time.Second)
+	if got := eta(start, 25, 100); got < 29*time.Second || got > 31*time.Second {
+		t.Errorf("eta() = %s, want ~30s", got)
+	}
+	if got := eta(start, 0, 100); got != 0 {
+		t.Errorf("eta() with nothing done = %s, want 0", got)
+	}
+}
@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielino/comio/pkg/pathutil"
+)
+
+// NodeState is the lifecycle state of a node in the ring
+type NodeState string
+
+const (
+	// NodeActive nodes serve reads and writes and receive migrated data
+	NodeActive NodeState = "active"
+	// NodeDraining nodes are read-only while their objects are migrated
+	NodeDraining NodeState = "draining"
+	// NodeDecommissioned nodes hold no data the cluster needs and are out
+	// of the ring
+	NodeDecommissioned NodeState = "decommissioned"
+)
+
+var (
+	// ErrUnknownNode is returned for node IDs that are not in the ring
+	ErrUnknownNode = errors.New("unknown node")
+	// ErrNoTargets is returned when no active node can take data
+	ErrNoTargets = errors.New("no active nodes to migrate data to")
+)
+
+// Member is a node of the ring
+type Member struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	State     NodeState `json:"state"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// Ring is the set of nodes objects are placed on. Nodes come from the
+// configuration; their states are kept as one file per node in a
+// directory, so each node only ever writes its own file. Pointing the
+// directory at storage shared by all nodes gives them the same view.
+type Ring struct {
+	dir   string
+	self  string
+	nodes map[string]string // Node ID -> base URL
+
+	mu    sync.RWMutex
+	state NodeState // State of this node, cached for the write path
+}
+
+// NewRing creates the ring of nodes (ID -> base URL) as seen by node self
+func NewRing(dir, self string, nodes map[string]string) (*Ring, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ring directory: %w", err)
+	}
+
+	r := &Ring{dir: dir, self: self, nodes: nodes}
+	m, err := r.Member(self)
+	if errors.Is(err, ErrUnknownNode) {
+		m = Member{ID: self, State: NodeActive}
+	} else if err != nil {
+		return nil, err
+	}
+	r.state = m.State
+	return r, nil
+}
+
+// Self returns the ID of the local node
+func (r *Ring) Self() string {
+	return r.self
+}
+
+func (r *Ring) statePath(id string) string {
+	return filepath.Join(r.dir, pathutil.SanitizePath(id)+".json")
+}
+
+// Member returns a node and its current state
+func (r *Ring) Member(id string) (Member, error) {
+	url, ok := r.nodes[id]
+	if !ok && id != r.self {
+		return Member{}, ErrUnknownNode
+	}
+
+	m := Member{ID: id, URL: url, State: NodeActive}
+	data, err := os.ReadFile(r.statePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return Member{}, fmt.Errorf("failed to read state of node %s: %w", id, err)
+	}
+
+	var stored Member
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return Member{}, fmt.Errorf("invalid state of node %s: %w", id, err)
+	}
+	m.State = stored.State
+	m.UpdatedAt = stored.UpdatedAt
+	return m, nil
+}
+
+// Members returns every configured node, including decommissioned ones,
+// sorted by ID
+func (r *Ring) Members() ([]Member, error) {
+	ids := make([]string, 0, len(r.nodes)+1)
+	for id := range r.nodes {
+		ids = append(ids, id)
+	}
+	if _, ok := r.nodes[r.self]; !ok {
+		ids = append(ids, r.self)
+	}
+	sort.Strings(ids)
+
+	members := make([]Member, 0, len(ids))
+	for _, id := range ids {
+		m, err := r.Member(id)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// SetState records the state of the local node
+func (r *Ring) SetState(state NodeState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(Member{ID: r.self, State: state, UpdatedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so other nodes never read a partial state
+	tmp, err := os.CreateTemp(r.dir, ".state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write node state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write node state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write node state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.statePath(r.self)); err != nil {
+		return fmt.Errorf("failed to write node state: %w", err)
+	}
+
+	r.state = state
+	return nil
+}
+
+// State returns the state of the local node
+func (r *Ring) State() NodeState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// ReadOnly reports whether the local node refuses writes
+func (r *Ring) ReadOnly() bool {
+	return r.State() != NodeActive
+}
+
+// Owner returns the active node, other than the local one, that an object
+// is placed on. Rendezvous hashing keeps placements stable as nodes come
+// and go: only the objects of a removed node move.
+func (r *Ring) Owner(bucket, key string) (Member, error) {
+	return r.place(bucket, key, false)
+}
+
+// Placement returns the active node an object belongs on, possibly the
+// local one. When a node joins, it takes over its share of the objects
+// and only those.
+func (r *Ring) Placement(bucket, key string) (Member, error) {
+	return r.place(bucket, key, true)
+}
+
+func (r *Ring) place(bucket, key string, includeSelf bool) (Member, error) {
+	members, err := r.Members()
+	if err != nil {
+		return Member{}, err
+	}
+
+	var owner Member
+	var best uint64
+	found := false
+	for _, m := range members {
+		if m.State != NodeActive {
+			continue
+		}
+		if m.ID == r.self {
+			if !includeSelf {
+				continue
+			}
+		} else if m.URL == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(m.ID + "\x00" + bucket + "/" + key))
+		weight := binary.BigEndian.Uint64(sum[:8])
+		if !found || weight > best {
+			owner, best, found = m, weight, true
+		}
+	}
+	if !found {
+		return Member{}, ErrNoTargets
+	}
+	return owner, nil
+}
@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRing_StatesPersistPerNode(t *testing.T) {
+	dir := t.TempDir()
+	nodes := map[string]string{"node-1": "http://node-1", "node-2": "http://node-2"}
+
+	ring, err := NewRing(dir, "node-1", nodes)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+	if ring.ReadOnly() {
+		t.Error("new node should be writable")
+	}
+	if err := ring.SetState(NodeDraining); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	if !ring.ReadOnly() {
+		t.Error("draining node should be read-only")
+	}
+
+	// Another node sharing the directory sees the state
+	other, err := NewRing(dir, "node-2", nodes)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+	m, err := other.Member("node-1")
+	if err != nil {
+		t.Fatalf("Member() error = %v", err)
+	}
+	if m.State != NodeDraining || m.URL != "http://node-1" {
+		t.Errorf("Member() = %+v, want draining node-1", m)
+	}
+	if other.ReadOnly() {
+		t.Error("node-2 should stay writable")
+	}
+
+	// And the state survives a restart
+	reopened, err := NewRing(dir, "node-1", nodes)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+	if reopened.State() != NodeDraining {
+		t.Errorf("State() after restart = %s, want %s", reopened.State(), NodeDraining)
+	}
+
+	if _, err := ring.Member("node-9"); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("Member(unknown) error = %v, want ErrUnknownNode", err)
+	}
+}
+
+func TestRing_OwnerSkipsSelfAndInactiveNodes(t *testing.T) {
+	dir := t.TempDir()
+	nodes := map[string]string{
+		"node-1": "http://node-1",
+		"node-2": "http://node-2",
+		"node-3": "http://node-3",
+	}
+	ring, err := NewRing(dir, "node-1", nodes)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	placed := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		key := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		owner, err := ring.Owner("bucket", key)
+		if err != nil {
+			t.Fatalf("Owner() error = %v", err)
+		}
+		if owner.ID == "node-1" {
+			t.Fatalf("Owner() returned the local node")
+		}
+		placed[owner.ID]++
+
+		// Placement is stable
+		again, _ := ring.Owner("bucket", key)
+		if again.ID != owner.ID {
+			t.Fatalf("Owner() changed from %s to %s", owner.ID, again.ID)
+		}
+	}
+	if placed["node-2"] == 0 || placed["node-3"] == 0 {
+		t.Errorf("objects not spread over remaining nodes: %v", placed)
+	}
+
+	// node-3 leaves too; everything goes to node-2
+	node3, err := NewRing(dir, "node-3", nodes)
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+	if err := node3.SetState(NodeDecommissioned); err != nil {
+		t.Fatalf("SetState() error = %v", err)
+	}
+	owner, err := ring.Owner("bucket", "key")
+	if err != nil || owner.ID != "node-2" {
+		t.Errorf("Owner() = %s, %v, want node-2", owner.ID, err)
+	}
+
+	node2, _ := NewRing(dir, "node-2", nodes)
+	node2.SetState(NodeDraining)
+	if _, err := ring.Owner("bucket", "key"); !errors.Is(err, ErrNoTargets) {
+		t.Errorf("Owner() error = %v, want ErrNoTargets", err)
+	}
+}
@@ -1,6 +1,12 @@
 package config
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
 
 // Config holds the global configuration
 type Config struct {
@@ -11,6 +17,30 @@ type Config struct {
 	Logging     LoggingConfig     `mapstructure:"logging"`
 	Metrics     MetricsConfig     `mapstructure:"metrics"`
 	Lifecycle   LifecycleConfig   `mapstructure:"lifecycle"`
+	Scheduler   SchedulerConfig   `mapstructure:"scheduler"`
+	History     HistoryConfig     `mapstructure:"history"`
+	PrefixStats PrefixStatsConfig `mapstructure:"prefix_stats"`
+	Search      SearchConfig      `mapstructure:"search"`
+	Usage       UsageConfig       `mapstructure:"usage"`
+	Billing     BillingConfig     `mapstructure:"billing"`
+	Alerting    AlertingConfig    `mapstructure:"alerting"`
+	Console     ConsoleConfig     `mapstructure:"console"`
+	GraphQL     GraphQLConfig     `mapstructure:"graphql"`
+	Gateway     GatewayConfig     `mapstructure:"gateway"`
+	Preview     PreviewConfig     `mapstructure:"preview"`
+	Multipart   MultipartConfig   `mapstructure:"multipart"`
+	Versioning  VersioningConfig  `mapstructure:"versioning"`
+	ReadReplica ReadReplicaConfig `mapstructure:"read_replica"`
+	Cluster     ClusterConfig     `mapstructure:"cluster"`
+	Encryption  EncryptionConfig  `mapstructure:"encryption"`
+	Attestation AttestationConfig `mapstructure:"attestation"`
+	Export      ExportConfig      `mapstructure:"export"`
+	NFS         NFSConfig         `mapstructure:"nfs"`
+	Registry    RegistryConfig    `mapstructure:"registry"`
+	Tenancy     TenancyConfig     `mapstructure:"tenancy"`
+	Database    DatabaseConfig    `mapstructure:"database"`
+	Debug       DebugConfig       `mapstructure:"debug"`
+	Concurrency ConcurrencyConfig `mapstructure:"concurrency"`
 }
 
 // ServerConfig holds server settings
@@ -21,6 +51,10 @@ type ServerConfig struct {
 	WriteTimeout    string    `mapstructure:"write_timeout"`
 	ShutdownTimeoutStr string `mapstructure:"shutdown_timeout"`
 	TLS             TLSConfig `mapstructure:"tls"`
+	MaxHeaderCount  int       `mapstructure:"max_header_count"` // Requests with more header fields are refused
+	MaxHeaderBytes  int       `mapstructure:"max_header_bytes"` // Limit on the total size of request header fields
+	S3Compatible    bool      `mapstructure:"s3_compatible"`    // Listings and errors are S3 XML unless JSON is asked for
+	Domain          string    `mapstructure:"domain"`           // Requests to <bucket>.<domain> address the bucket; empty allows path-style only
 }
 
 // ShutdownTimeout returns the shutdown timeout duration
@@ -44,9 +78,75 @@ type TLSConfig struct {
 
 // StorageConfig holds storage settings
 type StorageConfig struct {
-	Devices           []DeviceConfig `mapstructure:"devices"`
-	BlockSize         int            `mapstructure:"block_size"`
-	ReplicationFactor int            `mapstructure:"replication_factor"`
+	Devices           []DeviceConfig      `mapstructure:"devices"`
+	BlockSize         int                 `mapstructure:"block_size"`
+	ReplicationFactor int                 `mapstructure:"replication_factor"`
+	ErrorThreshold    int                 `mapstructure:"error_threshold"` // Consecutive I/O errors marking a device unhealthy
+	DiskHealth        DiskHealthConfig    `mapstructure:"disk_health"`
+	Watermarks        WatermarkConfig     `mapstructure:"watermarks"`
+	Fragmentation     FragmentationConfig `mapstructure:"fragmentation"`
+	// How much of each metadata file write is synced before it returns:
+	// none, file (the file's content) or full (the file and its directory)
+	MetadataDurability string                `mapstructure:"metadata_durability"`
+	Checksums          ChecksumConfig        `mapstructure:"checksums"`
+	WriteCoalescing    WriteCoalescingConfig `mapstructure:"write_coalescing"`
+	Sealing            SealingConfig         `mapstructure:"sealing"`
+}
+
+// SealingConfig holds the seal records written after object data, by
+// which recovery after a crash tells complete writes from torn ones
+type SealingConfig struct {
+	Enabled    bool `mapstructure:"enabled"`
+	VerifyData bool `mapstructure:"verify_data"` // Check sealed data against its CRC at startup, reading all of it
+}
+
+// WriteCoalescingConfig holds the buffering of small writes, which are
+// flushed to the device together
+type WriteCoalescingConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Window       string `mapstructure:"window"`         // Longest a write stays buffered
+	MaxBytes     int64  `mapstructure:"max_bytes"`      // Buffered bytes of a slab that flush it at once
+	MaxWriteSize int    `mapstructure:"max_write_size"` // Writes larger than this are not buffered
+}
+
+// ChecksumConfig holds the checksums computed for stored data
+type ChecksumConfig struct {
+	// Skips MD5 for deployments whose clients do not check ETags against
+	// it; ETags are then derived from the SHA-256
+	SkipMD5 bool `mapstructure:"skip_md5"`
+	// Objects of at least ChunkThreshold bytes also get a CRC32C of every
+	// ChunkSize bytes, so corruption is localized to a chunk and repaired
+	// from the replica by range; 0 disables them
+	ChunkThreshold int64 `mapstructure:"chunk_threshold"`
+	ChunkSize      int64 `mapstructure:"chunk_size"`
+	VerifyReads    bool  `mapstructure:"verify_reads"` // Check the chunks of every GET
+}
+
+// WatermarkConfig holds the storage usage percentages at which the server
+// warns and at which it refuses new data while still allowing deletes
+type WatermarkConfig struct {
+	HighPercent     float64 `mapstructure:"high_percent"`     // Logs a warning and alerts; 0 disables
+	CriticalPercent float64 `mapstructure:"critical_percent"` // Writes fail with 507 Insufficient Storage; 0 disables
+}
+
+// FragmentationConfig holds the shares of allocated space compaction
+// would give back at which the fragmentation report advises compacting
+// and alerts are raised
+type FragmentationConfig struct {
+	WarningPercent  float64 `mapstructure:"warning_percent"`  // 0 disables
+	CriticalPercent float64 `mapstructure:"critical_percent"` // 0 disables
+	// The compaction task moves the objects out of slabs wasting at least
+	// this share of their space; 0 compacts every slab with a hole
+	SlabPercent float64 `mapstructure:"slab_percent"`
+}
+
+// DiskHealthConfig holds settings for SMART checks of the storage devices
+type DiskHealthConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	Interval           string `mapstructure:"interval"`
+	SmartctlPath       string `mapstructure:"smartctl_path"`        // Falls back to /sys counters when smartctl is missing
+	WearWarningPercent int    `mapstructure:"wear_warning_percent"` // SSD wear raising a warning; 0 disables
+	ReallocatedSectors int64  `mapstructure:"reallocated_sectors"`  // Reallocated sectors raising a warning; 0 disables
 }
 
 // DeviceConfig holds device settings
@@ -61,6 +161,7 @@ type ReplicationConfig struct {
 	WriteQuorum  int          `mapstructure:"write_quorum"`
 	ReadQuorum   int          `mapstructure:"read_quorum"`
 	SyncInterval string       `mapstructure:"sync_interval"`
+	Peers        []PeerConfig `mapstructure:"peers"` // Sources whose signed replication requests are accepted
 }
 
 // NodeConfig holds node settings
@@ -68,11 +169,100 @@ type NodeConfig struct {
 	Address string `mapstructure:"address"`
 }
 
+// PeerConfig holds the key a replication source signs its requests with
+type PeerConfig struct {
+	NodeID string `mapstructure:"node_id"`
+	Key    string `mapstructure:"key"`
+}
+
+// EncryptionConfig holds server-side encryption settings
+type EncryptionConfig struct {
+	Enabled       bool              `mapstructure:"enabled"`
+	Keys          []MasterKeyConfig `mapstructure:"keys"`
+	ActiveVersion int               `mapstructure:"active_version"` // Version new objects use; defaults to the highest
+	RewrapOnRead  bool              `mapstructure:"rewrap_on_read"` // Re-wrap data keys of objects on old versions when read
+	KMS           KMSConfig         `mapstructure:"kms"`
+}
+
+// KMSConfig holds settings for fetching master keys from a key management
+// service instead of the keys list
+type KMSConfig struct {
+	Provider        string         `mapstructure:"provider"`         // "vault" or "aws"; empty uses the keys list
+	RefreshInterval string         `mapstructure:"refresh_interval"` // How often keys are fetched again
+	Vault           VaultKMSConfig `mapstructure:"vault"`
+	AWS             AWSKMSConfig   `mapstructure:"aws"`
+}
+
+// VaultKMSConfig holds HashiCorp Vault settings. Each version of the KV v2
+// secret is a master key version.
+type VaultKMSConfig struct {
+	Address string `mapstructure:"address"`
+	Token   string `mapstructure:"token"` // Defaults to $VAULT_TOKEN
+	Mount   string `mapstructure:"mount"`
+	Path    string `mapstructure:"path"`
+	Field   string `mapstructure:"field"`
+}
+
+// AWSKMSConfig holds AWS KMS settings. Master keys are kept as KMS
+// ciphertexts and decrypted at startup and on every refresh.
+type AWSKMSConfig struct {
+	Region          string            `mapstructure:"region"`
+	Endpoint        string            `mapstructure:"endpoint"`
+	AccessKeyID     string            `mapstructure:"access_key_id"`     // Defaults to $AWS_ACCESS_KEY_ID
+	SecretAccessKey string            `mapstructure:"secret_access_key"` // Defaults to $AWS_SECRET_ACCESS_KEY
+	SessionToken    string            `mapstructure:"session_token"`     // Defaults to $AWS_SESSION_TOKEN
+	Keys            []AWSKMSKeyConfig `mapstructure:"keys"`
+}
+
+// AWSKMSKeyConfig holds one master key version encrypted by AWS KMS
+type AWSKMSKeyConfig struct {
+	Version        int    `mapstructure:"version"`
+	CiphertextBlob string `mapstructure:"ciphertext_blob"` // Base64, from aws kms generate-data-key --key-spec AES_256
+}
+
+// MasterKeyConfig holds one version of the master key
+type MasterKeyConfig struct {
+	Version int    `mapstructure:"version"`
+	Key     string `mapstructure:"key"` // Base64-encoded 32-byte key
+}
+
+// AttestationConfig holds the key object attestations are signed with
+type AttestationConfig struct {
+	SigningKey string `mapstructure:"signing_key"` // Base64-encoded 32-byte Ed25519 seed; empty generates one per start
+}
+
+// ExportConfig holds settings of archive exports of buckets
+type ExportConfig struct {
+	Dir            string `mapstructure:"dir"`              // Where archives and manifests are written
+	BytesPerSecond int64  `mapstructure:"bytes_per_second"` // Read rate limit of an export; 0 disables throttling
+}
+
 // AuthConfig holds authentication settings
 type AuthConfig struct {
-	Enabled        bool   `mapstructure:"enabled"`
-	AdminAccessKey string `mapstructure:"admin_access_key"`
-	AdminSecretKey string `mapstructure:"admin_secret_key"`
+	Enabled            bool   `mapstructure:"enabled"`
+	AdminAccessKey     string `mapstructure:"admin_access_key"`
+	AdminSecretKey     string `mapstructure:"admin_secret_key"`      // Also read from $COMIO_AUTH_ADMIN_SECRET_KEY
+	AdminSecretKeyFile string `mapstructure:"admin_secret_key_file"` // File holding the admin secret key, e.g. a mounted secret
+}
+
+// ResolveAdminSecret reads the admin secret key from AdminSecretKeyFile
+// when one is configured
+func (a *AuthConfig) ResolveAdminSecret() error {
+	if a.AdminSecretKeyFile == "" {
+		return nil
+	}
+	if a.AdminSecretKey != "" {
+		return errors.New("auth.admin_secret_key and auth.admin_secret_key_file are both set")
+	}
+	data, err := os.ReadFile(a.AdminSecretKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read admin secret key file: %w", err)
+	}
+	a.AdminSecretKey = strings.TrimSpace(string(data))
+	if a.AdminSecretKey == "" {
+		return fmt.Errorf("admin secret key file %s is empty", a.AdminSecretKeyFile)
+	}
+	return nil
 }
 
 // LoggingConfig holds logging settings
@@ -80,15 +270,263 @@ type LoggingConfig struct {
 	Level  string `mapstructure:"level"`
 	Format string `mapstructure:"format"`
 	Output string `mapstructure:"output"`
+	// Levels overrides the level of modules, e.g. replication: debug
+	Levels map[string]string `mapstructure:"levels"`
 }
 
 // MetricsConfig holds metrics settings
 type MetricsConfig struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	Endpoint string `mapstructure:"endpoint"`
+	Enabled  bool                 `mapstructure:"enabled"`
+	Endpoint string               `mapstructure:"endpoint"`
+	History  MetricsHistoryConfig `mapstructure:"history"`
+	Push     MetricsPushConfig    `mapstructure:"push"`
+}
+
+// MetricsHistoryConfig holds settings for storage stats samples kept in
+// the metadata directory and served by /admin/metrics/history
+type MetricsHistoryConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Interval  string `mapstructure:"interval"`  // How often a sample is taken
+	Retention string `mapstructure:"retention"` // How long samples are kept
+}
+
+// MetricsPushConfig holds settings for pushing metrics to systems that
+// cannot scrape /admin/metrics/prometheus
+type MetricsPushConfig struct {
+	Interval    string            `mapstructure:"interval"` // How often metrics are pushed
+	RemoteWrite RemoteWriteConfig `mapstructure:"remote_write"`
+	Statsd      StatsdConfig      `mapstructure:"statsd"`
+}
+
+// RemoteWriteConfig holds Prometheus remote write settings
+type RemoteWriteConfig struct {
+	Enabled    bool              `mapstructure:"enabled"`
+	URL        string            `mapstructure:"url"`
+	Username   string            `mapstructure:"username"`
+	Password   string            `mapstructure:"password"`
+	Headers    map[string]string `mapstructure:"headers"`
+	Labels     map[string]string `mapstructure:"labels"` // Added to every series
+	Timeout    string            `mapstructure:"timeout"`
+	BatchSize  int               `mapstructure:"batch_size"`  // Series per request
+	MaxRetries int               `mapstructure:"max_retries"` // Retries of a failed request
+	MaxPending int               `mapstructure:"max_pending"` // Samples kept while the endpoint is down
+}
+
+// StatsdConfig holds statsd and DogStatsD settings
+type StatsdConfig struct {
+	Enabled       bool              `mapstructure:"enabled"`
+	Address       string            `mapstructure:"address"`
+	Prefix        string            `mapstructure:"prefix"`
+	Format        string            `mapstructure:"format"` // "statsd" or "datadog"
+	Tags          map[string]string `mapstructure:"tags"`
+	MaxPacketSize int               `mapstructure:"max_packet_size"`
 }
 
 // LifecycleConfig holds lifecycle settings
 type LifecycleConfig struct {
 	EvaluationInterval string `mapstructure:"evaluation_interval"`
 }
+
+// SchedulerConfig holds scheduled task settings
+type SchedulerConfig struct {
+	Schedules    []ScheduleConfig `mapstructure:"schedules"`
+	BackupDir    string           `mapstructure:"backup_dir"`
+	BackupRetain int              `mapstructure:"backup_retain"`
+	// The inventory task writes a CSV of the objects of each bucket to
+	// <inventory_prefix><bucket>/<YYYY-MM-DD>.csv in this bucket
+	InventoryBucket string `mapstructure:"inventory_bucket"`
+	InventoryPrefix string `mapstructure:"inventory_prefix"`
+}
+
+// ScheduleConfig holds a single cron schedule
+type ScheduleConfig struct {
+	Name     string `mapstructure:"name"`
+	Task     string `mapstructure:"task"` // scrub, inventory, lifecycle, compaction, metadata_backup, multipart_cleanup, tombstone_gc, billing_report
+	Cron     string `mapstructure:"cron"` // five-field cron expression or @daily style shorthand
+	Disabled bool   `mapstructure:"disabled"`
+}
+
+// HistoryConfig holds per-object operation history settings
+type HistoryConfig struct {
+	Enabled   bool `mapstructure:"enabled"`
+	MaxEvents int  `mapstructure:"max_events"` // Events kept per object
+}
+
+// PrefixStatsConfig holds settings for the per-prefix object totals served
+// by GET /:bucket?prefix-stats
+type PrefixStatsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// SearchConfig holds settings for the full-text index of object keys and
+// user metadata served by GET /:bucket?search and /admin/v1/search
+type SearchConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// UsageConfig holds settings for the hourly request and traffic counters
+// of each access key, served by /admin/v1/usage/keys
+type UsageConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	Retention     string `mapstructure:"retention"`      // How long hourly counters are kept
+	FlushInterval string `mapstructure:"flush_interval"` // How often counts are written to metadata/usage
+}
+
+// BillingConfig holds settings for the monthly chargeback reports of the
+// storage and traffic of each bucket and owner, built from the usage
+// counters and served by /admin/v1/reports
+type BillingConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Bucket  string `mapstructure:"bucket"` // Where reports are written; empty only serves them
+	Prefix  string `mapstructure:"prefix"` // Key prefix of the reports in the bucket
+	Cron    string `mapstructure:"cron"`   // When the report of the previous month is written
+}
+
+// AlertingConfig holds administrative alert settings
+type AlertingConfig struct {
+	Webhooks                  []string `mapstructure:"webhooks"` // Slack-compatible webhook URLs; empty disables alerting
+	Cooldown                  string   `mapstructure:"cooldown"`
+	CheckInterval             string   `mapstructure:"check_interval"`
+	StorageWarningPercent     float64  `mapstructure:"storage_warning_percent"`
+	StorageCriticalPercent    float64  `mapstructure:"storage_critical_percent"`
+	ReplicationQueueThreshold int      `mapstructure:"replication_queue_threshold"`
+}
+
+// ConsoleConfig holds web console settings
+type ConsoleConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// GraphQLConfig holds settings for the read-only GraphQL admin endpoint
+type GraphQLConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// ConcurrencyConfig bounds the S3 requests running at once, per operation
+// class
+type ConcurrencyConfig struct {
+	Reads  ConcurrencyLimit `mapstructure:"reads"`  // Object GET and HEAD
+	Writes ConcurrencyLimit `mapstructure:"writes"` // PUT, POST, PATCH and DELETE
+	Lists  ConcurrencyLimit `mapstructure:"lists"`  // Bucket, upload and part listings
+}
+
+// ConcurrencyLimit bounds one class of requests
+type ConcurrencyLimit struct {
+	MaxConcurrent int    `mapstructure:"max_concurrent"` // 0 leaves the class unlimited
+	MaxQueued     int    `mapstructure:"max_queued"`     // Requests waiting beyond this get 503 SlowDown
+	QueueTimeout  string `mapstructure:"queue_timeout"`  // Longest wait for a slot before 503 SlowDown
+}
+
+// DebugConfig holds settings for investigating live nodes
+type DebugConfig struct {
+	// Serve the Go runtime profiles under /admin/debug/pprof, behind the
+	// admin credentials
+	Pprof bool `mapstructure:"pprof"`
+}
+
+// GatewayConfig holds the anonymous read-only public gateway settings
+type GatewayConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	Host        string   `mapstructure:"host"`
+	Port        int      `mapstructure:"port"`
+	Buckets     []string `mapstructure:"buckets"`       // Buckets readable without credentials
+	CacheMaxAge string   `mapstructure:"cache_max_age"` // Cache-Control max-age for served objects
+}
+
+// PreviewConfig holds derived object (thumbnail, text extraction) settings
+type PreviewConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Prefix        string   `mapstructure:"prefix"`     // Key prefix for derived objects
+	Processors    []string `mapstructure:"processors"` // thumbnail, text
+	ThumbnailSize int      `mapstructure:"thumbnail_size"`
+}
+
+// MultipartConfig holds multipart upload limits
+type MultipartConfig struct {
+	MinPartSize int64 `mapstructure:"min_part_size"` // Bytes; applies to all parts but the last
+	MaxParts    int   `mapstructure:"max_parts"`
+	// AbortIncompleteAfter expires uploads idle this long ("0" disables)
+	AbortIncompleteAfter string `mapstructure:"abort_incomplete_after"`
+	CleanupCron          string `mapstructure:"cleanup_cron"`
+}
+
+// VersioningConfig holds settings for versioned buckets
+type VersioningConfig struct {
+	// TombstoneMaxAge removes delete markers, and the versions under them,
+	// once this old and replicated ("0" disables)
+	TombstoneMaxAge string `mapstructure:"tombstone_max_age"`
+	TombstoneCron   string `mapstructure:"tombstone_cron"`
+}
+
+// ReadReplicaConfig holds settings for serving reads as an async replica
+type ReadReplicaConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	PrimaryURL string `mapstructure:"primary_url"` // Reads with an unmet consistency token are proxied here
+}
+
+// ClusterConfig holds multi-node settings
+type ClusterConfig struct {
+	NodeID       string            `mapstructure:"node_id"`       // Defaults to the hostname
+	NamespaceDir string            `mapstructure:"namespace_dir"` // Directory shared by all nodes for bucket name claims; empty disables
+	Nodes        map[string]string `mapstructure:"nodes"`         // Node ID -> base URL of the ring; defaults to the raft peers
+	RingDir      string            `mapstructure:"ring_dir"`      // Where node states are kept; share it so all nodes see them
+	Token        string            `mapstructure:"token"`         // Bearer token for requests to other nodes
+	Raft         RaftConfig        `mapstructure:"raft"`
+	Rebalance    RebalanceConfig   `mapstructure:"rebalance"`
+}
+
+// RebalanceConfig holds settings for moving objects onto added nodes
+type RebalanceConfig struct {
+	Fraction       float64 `mapstructure:"fraction"`         // Largest share of a node's objects moved by one run, 0-1
+	BytesPerSecond int64   `mapstructure:"bytes_per_second"` // Transfer rate limit; 0 disables throttling
+}
+
+// RaftConfig holds settings for replicating metadata with an embedded raft group
+type RaftConfig struct {
+	Enabled           bool              `mapstructure:"enabled"`
+	Peers             map[string]string `mapstructure:"peers"` // Node ID -> base URL, including this node
+	Dir               string            `mapstructure:"dir"`
+	Token             string            `mapstructure:"token"` // Shared secret authenticating raft RPCs
+	ElectionTimeout   string            `mapstructure:"election_timeout"`
+	SnapshotThreshold int               `mapstructure:"snapshot_threshold"` // Applied entries between snapshots
+}
+
+// NFSConfig holds settings for the experimental read-only NFSv3 export of
+// bucket snapshots
+type NFSConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Host    string `mapstructure:"host"`
+	Port    int    `mapstructure:"port"` // Serves both the NFS and MOUNT programs
+}
+
+// RegistryConfig holds settings for the S3-compatible endpoint under
+// /registry that backs a container registry's S3 storage driver
+type RegistryConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// TenancyConfig holds settings for serving separate bucket namespaces to
+// tenants, named by a request header or by the Host of virtual hosts
+type TenancyConfig struct {
+	Enabled bool                   `mapstructure:"enabled"`
+	Header  string                 `mapstructure:"header"` // Request header naming the tenant
+	Domain  string                 `mapstructure:"domain"` // Requests to <tenant>.<domain> are served to the tenant; empty disables
+	Quotas  map[string]TenantQuota `mapstructure:"quotas"` // Tenant -> limits on all its buckets together
+}
+
+// TenantQuota limits what a tenant's buckets may hold. A zero limit is unlimited.
+type TenantQuota struct {
+	MaxSize    int64 `mapstructure:"max_size"` // Bytes
+	MaxObjects int64 `mapstructure:"max_objects"`
+}
+
+// DatabaseConfig holds settings for keeping bucket and object metadata in
+// SQLite instead of JSON files
+type DatabaseConfig struct {
+	Enabled          bool   `mapstructure:"enabled"`
+	Path             string `mapstructure:"path"`
+	MaxOpenConns     int    `mapstructure:"max_open_conns"`
+	MaxIdleConns     int    `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime  string `mapstructure:"conn_max_lifetime"`  // "0" keeps connections open indefinitely
+	MaxPendingWrites int    `mapstructure:"max_pending_writes"` // Writes queued beyond this fail with 503; 0 disables
+}
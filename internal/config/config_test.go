@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -192,3 +194,39 @@ func TestLifecycleConfig(t *testing.T) {
 		t.Errorf("EvaluationInterval = %s, want 24h", cfg.EvaluationInterval)
 	}
 }
+
+func TestAuthConfig_ResolveAdminSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin-secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := AuthConfig{AdminSecretKeyFile: path}
+	if err := cfg.ResolveAdminSecret(); err != nil {
+		t.Fatalf("ResolveAdminSecret failed: %v", err)
+	}
+	if cfg.AdminSecretKey != "from-file" {
+		t.Errorf("AdminSecretKey = %q, want from-file", cfg.AdminSecretKey)
+	}
+
+	both := AuthConfig{AdminSecretKey: "inline", AdminSecretKeyFile: path}
+	if err := both.ResolveAdminSecret(); err == nil {
+		t.Error("expected error when both the key and the key file are set")
+	}
+}
+
+func TestLoadConfig_AdminSecretFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("auth:\n  admin_access_key: admin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("COMIO_AUTH_ADMIN_SECRET_KEY", "from-env")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Auth.AdminSecretKey != "from-env" {
+		t.Errorf("AdminSecretKey = %q, want from-env", cfg.Auth.AdminSecretKey)
+	}
+}
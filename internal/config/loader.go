@@ -42,6 +42,9 @@ func LoadConfig(path string) (*Config, error) {
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := config.Auth.ResolveAdminSecret(); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
@@ -51,16 +54,46 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.port", 8080)
 	v.SetDefault("server.read_timeout", "30s")
 	v.SetDefault("server.write_timeout", "30s")
+	v.SetDefault("server.max_header_count", 200)
+	v.SetDefault("server.max_header_bytes", 64*1024)
+	v.SetDefault("server.s3_compatible", false)
+	v.SetDefault("server.domain", "")
 	v.SetDefault("server.tls.enabled", false)
 
 	v.SetDefault("storage.block_size", 4096)
 	v.SetDefault("storage.replication_factor", 3)
+	v.SetDefault("storage.error_threshold", 3)
+	v.SetDefault("storage.disk_health.enabled", false)
+	v.SetDefault("storage.disk_health.interval", "1h")
+	v.SetDefault("storage.disk_health.smartctl_path", "smartctl")
+	v.SetDefault("storage.disk_health.wear_warning_percent", 80)
+	v.SetDefault("storage.disk_health.reallocated_sectors", 1)
+	v.SetDefault("storage.watermarks.high_percent", 90)
+	v.SetDefault("storage.watermarks.critical_percent", 98)
+	v.SetDefault("storage.fragmentation.warning_percent", 30)
+	v.SetDefault("storage.fragmentation.critical_percent", 50)
+	v.SetDefault("storage.fragmentation.slab_percent", 25)
+	v.SetDefault("storage.metadata_durability", "full")
+	v.SetDefault("storage.checksums.skip_md5", false)
+	v.SetDefault("storage.checksums.chunk_threshold", 16*1024*1024)
+	v.SetDefault("storage.checksums.chunk_size", 4*1024*1024)
+	v.SetDefault("storage.checksums.verify_reads", true)
+	v.SetDefault("storage.write_coalescing.enabled", true)
+	v.SetDefault("storage.write_coalescing.window", "1ms")
+	v.SetDefault("storage.write_coalescing.max_bytes", 1024*1024)
+	v.SetDefault("storage.write_coalescing.max_write_size", 128*1024)
+	v.SetDefault("storage.sealing.enabled", true)
+	v.SetDefault("storage.sealing.verify_data", false)
 
 	v.SetDefault("replication.write_quorum", 2)
 	v.SetDefault("replication.read_quorum", 1)
 	v.SetDefault("replication.sync_interval", "5m")
 
 	v.SetDefault("auth.enabled", true)
+	// Registered so the credentials can be injected through the environment
+	v.SetDefault("auth.admin_access_key", "")
+	v.SetDefault("auth.admin_secret_key", "")
+	v.SetDefault("auth.admin_secret_key_file", "")
 
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.format", "json")
@@ -68,6 +101,117 @@ func setDefaults(v *viper.Viper) {
 
 	v.SetDefault("metrics.enabled", true)
 	v.SetDefault("metrics.endpoint", "/admin/metrics")
+	v.SetDefault("metrics.history.enabled", true)
+	v.SetDefault("metrics.history.interval", "5m")
+	v.SetDefault("metrics.history.retention", "720h")
+	v.SetDefault("metrics.push.interval", "15s")
+	v.SetDefault("metrics.push.remote_write.enabled", false)
+	v.SetDefault("metrics.push.remote_write.timeout", "10s")
+	v.SetDefault("metrics.push.remote_write.batch_size", 500)
+	v.SetDefault("metrics.push.remote_write.max_retries", 3)
+	v.SetDefault("metrics.push.remote_write.max_pending", 100000)
+	v.SetDefault("metrics.push.statsd.enabled", false)
+	v.SetDefault("metrics.push.statsd.address", "127.0.0.1:8125")
+	v.SetDefault("metrics.push.statsd.format", "statsd")
+	v.SetDefault("metrics.push.statsd.max_packet_size", 1432)
 
 	v.SetDefault("lifecycle.evaluation_interval", "24h")
+
+	v.SetDefault("scheduler.backup_dir", "backups")
+	v.SetDefault("scheduler.backup_retain", 7)
+	v.SetDefault("scheduler.inventory_prefix", "inventory/")
+
+	v.SetDefault("history.enabled", true)
+	v.SetDefault("history.max_events", 100)
+
+	v.SetDefault("prefix_stats.enabled", false)
+
+	v.SetDefault("search.enabled", false)
+	v.SetDefault("usage.enabled", true)
+	v.SetDefault("usage.retention", "2160h")
+	v.SetDefault("usage.flush_interval", "1m")
+	v.SetDefault("billing.enabled", false)
+	v.SetDefault("billing.prefix", "reports/")
+	v.SetDefault("billing.cron", "0 1 1 * *")
+
+	v.SetDefault("alerting.cooldown", "15m")
+	v.SetDefault("alerting.check_interval", "1m")
+	v.SetDefault("alerting.storage_warning_percent", 80)
+	v.SetDefault("alerting.storage_critical_percent", 95)
+	v.SetDefault("alerting.replication_queue_threshold", 5000)
+
+	v.SetDefault("console.enabled", true)
+
+	v.SetDefault("graphql.enabled", false)
+
+	v.SetDefault("gateway.enabled", false)
+	v.SetDefault("gateway.host", "0.0.0.0")
+	v.SetDefault("gateway.port", 8081)
+	v.SetDefault("gateway.cache_max_age", "24h")
+
+	v.SetDefault("preview.enabled", false)
+	v.SetDefault("preview.prefix", ".previews/")
+	v.SetDefault("preview.processors", []string{"thumbnail", "text"})
+	v.SetDefault("preview.thumbnail_size", 256)
+
+	v.SetDefault("multipart.min_part_size", 5*1024*1024)
+	v.SetDefault("multipart.max_parts", 10000)
+	v.SetDefault("multipart.abort_incomplete_after", "24h")
+	v.SetDefault("multipart.cleanup_cron", "@hourly")
+	v.SetDefault("versioning.tombstone_max_age", "168h")
+	v.SetDefault("versioning.tombstone_cron", "@daily")
+
+	v.SetDefault("read_replica.enabled", false)
+
+	v.SetDefault("cluster.namespace_dir", "")
+	v.SetDefault("cluster.ring_dir", "metadata/cluster")
+	v.SetDefault("cluster.rebalance.fraction", 1.0)
+	v.SetDefault("cluster.rebalance.bytes_per_second", 50*1024*1024)
+	v.SetDefault("cluster.raft.enabled", false)
+	v.SetDefault("cluster.raft.dir", "metadata/raft")
+	v.SetDefault("cluster.raft.election_timeout", "1s")
+	v.SetDefault("cluster.raft.snapshot_threshold", 1024)
+
+	v.SetDefault("encryption.enabled", false)
+	v.SetDefault("encryption.active_version", 0)
+	v.SetDefault("encryption.rewrap_on_read", true)
+	v.SetDefault("encryption.kms.provider", "")
+	v.SetDefault("encryption.kms.refresh_interval", "5m")
+	v.SetDefault("encryption.kms.vault.mount", "secret")
+	v.SetDefault("encryption.kms.vault.path", "comio/master-key")
+	v.SetDefault("encryption.kms.vault.field", "key")
+
+	v.SetDefault("attestation.signing_key", "")
+
+	v.SetDefault("export.dir", "exports")
+	v.SetDefault("export.bytes_per_second", 20*1024*1024)
+
+	v.SetDefault("nfs.enabled", false)
+	v.SetDefault("nfs.host", "0.0.0.0")
+	v.SetDefault("nfs.port", 2049)
+
+	v.SetDefault("registry.enabled", false)
+
+	v.SetDefault("tenancy.enabled", false)
+	v.SetDefault("tenancy.header", "X-Comio-Tenant")
+	v.SetDefault("tenancy.domain", "")
+
+	v.SetDefault("database.enabled", false)
+	v.SetDefault("database.path", "metadata/comio.db")
+	v.SetDefault("database.max_open_conns", 10)
+	v.SetDefault("database.max_idle_conns", 2)
+	v.SetDefault("database.conn_max_lifetime", "0")
+	v.SetDefault("database.max_pending_writes", 64)
+
+	v.SetDefault("debug.pprof", false)
+
+	v.SetDefault("concurrency.reads.max_concurrent", 256)
+	v.SetDefault("concurrency.reads.max_queued", 1024)
+	v.SetDefault("concurrency.reads.queue_timeout", "10s")
+	v.SetDefault("concurrency.writes.max_concurrent", 64)
+	v.SetDefault("concurrency.writes.max_queued", 256)
+	v.SetDefault("concurrency.writes.queue_timeout", "10s")
+	v.SetDefault("concurrency.lists.max_concurrent", 32)
+	v.SetDefault("concurrency.lists.max_queued", 128)
+	v.SetDefault("concurrency.lists.queue_timeout", "10s")
 }
@@ -0,0 +1,35 @@
+// Package console serves the embedded web admin console
+package console
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path is the URL prefix the console is served under
+const Path = "/console"
+
+//go:embed static
+var staticFiles embed.FS
+
+// Register serves the console under Path on router, behind HTTP basic auth
+// with the admin credentials and any further handlers given
+func Register(router *gin.Engine, accessKey, secretKey string, handlers ...gin.HandlerFunc) {
+	assets, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err) // embedded tree is fixed at build time
+	}
+
+	group := router.Group(Path, handlers...)
+	group.Use(gin.BasicAuthForRealm(gin.Accounts{accessKey: secretKey}, "ComIO Console"))
+	group.StaticFS("/", http.FS(assets))
+
+	// Without this, GET /console would be routed as a bucket listing
+	redirect := func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, Path+"/")
+	}
+	router.GET(Path, append(handlers, redirect)...)
+}
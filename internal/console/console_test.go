@@ -0,0 +1,76 @@
+package console
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupConsole() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// The console must coexist with the bucket and object routes
+	router.GET("/:bucket", func(c *gin.Context) { c.String(http.StatusOK, "bucket") })
+	router.GET("/:bucket/:key", func(c *gin.Context) { c.String(http.StatusOK, "object") })
+
+	Register(router, "admin", "secret")
+	return router
+}
+
+func TestConsole_RequiresCredentials(t *testing.T) {
+	router := setupConsole()
+
+	req := httptest.NewRequest("GET", "/console/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("GET", "/console/", nil)
+	req.SetBasicAuth("admin", "wrong")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status with wrong secret = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestConsole_ServesAssets(t *testing.T) {
+	router := setupConsole()
+
+	for _, path := range []string{"/console/", "/console/console.js", "/console/console.css"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/console/", nil)
+	req.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "ComIO Console") {
+		t.Error("index page not served")
+	}
+}
+
+func TestConsole_Redirect(t *testing.T) {
+	router := setupConsole()
+
+	req := httptest.NewRequest("GET", "/console", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/console/" {
+		t.Errorf("GET /console = %d %s, want redirect to /console/", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,322 @@
+// ComIO web console. Talks to the regular HTTP API on the same origin.
+(function () {
+  "use strict";
+
+  var state = {
+    bucket: null,
+    nextMarker: ""
+  };
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function showMessage(text, isError) {
+    var el = $("message");
+    el.textContent = text || "";
+    el.className = isError ? "error" : "";
+  }
+
+  function api(method, path, body, headers) {
+    return fetch(path, {
+      method: method,
+      body: body,
+      headers: headers || {},
+      credentials: "same-origin"
+    }).then(function (resp) {
+      if (!resp.ok) {
+        return resp.text().then(function (text) {
+          var msg = text;
+          try {
+            var body = JSON.parse(text);
+            if (body.code) {
+              msg = body.code + ": " + body.message;
+            }
+          } catch (e) {
+            // Not JSON
+          }
+          throw new Error(method + " " + path + ": " + resp.status + " " + msg);
+        });
+      }
+      return resp;
+    });
+  }
+
+  function apiJSON(method, path) {
+    return api(method, path).then(function (resp) {
+      return resp.json();
+    });
+  }
+
+  function objectPath(bucket, key) {
+    return "/" + encodeURIComponent(bucket) + "/" + encodeURIComponent(key);
+  }
+
+  function formatBytes(n) {
+    var units = ["B", "KiB", "MiB", "GiB", "TiB"];
+    var i = 0;
+    while (n >= 1024 && i < units.length - 1) {
+      n /= 1024;
+      i++;
+    }
+    return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+  }
+
+  function formatTime(value) {
+    if (!value || value.indexOf("0001-") === 0) {
+      return "-";
+    }
+    return new Date(value).toLocaleString();
+  }
+
+  function cell(row, text) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    row.appendChild(td);
+    return td;
+  }
+
+  function fillDefinitions(el, entries) {
+    el.innerHTML = "";
+    entries.forEach(function (entry) {
+      var dt = document.createElement("dt");
+      dt.textContent = entry[0];
+      var dd = document.createElement("dd");
+      dd.textContent = entry[1];
+      el.appendChild(dt);
+      el.appendChild(dd);
+    });
+  }
+
+  // Buckets
+
+  function loadBuckets() {
+    apiJSON("GET", "/").then(function (buckets) {
+      var list = $("bucket-list");
+      list.innerHTML = "";
+      (buckets || []).forEach(function (b) {
+        var li = document.createElement("li");
+        var link = document.createElement("a");
+        link.textContent = b.name;
+        link.addEventListener("click", function () {
+          openBucket(b.name);
+        });
+
+        var del = document.createElement("button");
+        del.textContent = "Delete";
+        del.className = "danger";
+        del.addEventListener("click", function () {
+          if (!confirm("Delete bucket " + b.name + "? It must be empty.")) {
+            return;
+          }
+          api("DELETE", "/" + encodeURIComponent(b.name)).then(function () {
+            if (state.bucket === b.name) {
+              $("objects-panel").hidden = true;
+              state.bucket = null;
+            }
+            showMessage("Deleted bucket " + b.name);
+            loadBuckets();
+          }).catch(function (err) {
+            showMessage(err.message, true);
+          });
+        });
+
+        li.appendChild(link);
+        li.appendChild(del);
+        list.appendChild(li);
+      });
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+  }
+
+  $("create-bucket").addEventListener("submit", function (e) {
+    e.preventDefault();
+    var name = e.target.bucket.value.trim();
+    api("PUT", "/" + encodeURIComponent(name)).then(function () {
+      e.target.reset();
+      showMessage("Created bucket " + name);
+      loadBuckets();
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+  });
+
+  // Objects
+
+  function openBucket(name) {
+    state.bucket = name;
+    $("current-bucket").textContent = name;
+    $("objects-panel").hidden = false;
+    $("prefix").value = "";
+    loadObjects(false);
+  }
+
+  function loadObjects(append) {
+    var params = new URLSearchParams();
+    var prefix = $("prefix").value;
+    if (prefix) {
+      params.set("prefix", prefix);
+    }
+    if (append && state.nextMarker) {
+      params.set("start-after", state.nextMarker);
+    }
+
+    apiJSON("GET", "/" + encodeURIComponent(state.bucket) + "?" + params.toString()).then(function (result) {
+      var body = $("object-list");
+      if (!append) {
+        body.innerHTML = "";
+      }
+
+      (result.objects || []).forEach(function (obj) {
+        body.appendChild(objectRow(obj));
+      });
+
+      state.nextMarker = result.next_marker || "";
+      $("more-objects").hidden = !result.is_truncated;
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+  }
+
+  function objectRow(obj) {
+    var row = document.createElement("tr");
+    cell(row, obj.key);
+    cell(row, formatBytes(obj.size));
+    cell(row, formatTime(obj.modified_at));
+
+    var actions = cell(row, "");
+    actions.className = "actions";
+
+    var download = document.createElement("a");
+    download.textContent = "Download";
+    download.href = objectPath(state.bucket, obj.key);
+    download.setAttribute("download", obj.key);
+
+    var del = document.createElement("button");
+    del.textContent = "Delete";
+    del.className = "danger";
+    del.addEventListener("click", function () {
+      if (!confirm("Delete " + obj.key + "?")) {
+        return;
+      }
+      api("DELETE", objectPath(state.bucket, obj.key)).then(function () {
+        row.remove();
+        showMessage("Deleted " + obj.key);
+      }).catch(function (err) {
+        showMessage(err.message, true);
+      });
+    });
+
+    actions.appendChild(download);
+    actions.appendChild(document.createTextNode(" "));
+    actions.appendChild(del);
+    return row;
+  }
+
+  $("refresh-objects").addEventListener("click", function () {
+    loadObjects(false);
+  });
+
+  $("more-objects").addEventListener("click", function () {
+    loadObjects(true);
+  });
+
+  $("prefix").addEventListener("keydown", function (e) {
+    if (e.key === "Enter") {
+      loadObjects(false);
+    }
+  });
+
+  $("upload").addEventListener("submit", function (e) {
+    e.preventDefault();
+    var file = e.target.file.files[0];
+    if (!file || !state.bucket) {
+      return;
+    }
+
+    showMessage("Uploading " + file.name + "...");
+    api("PUT", objectPath(state.bucket, file.name), file, {
+      "Content-Type": file.type || "application/octet-stream"
+    }).then(function () {
+      e.target.reset();
+      showMessage("Uploaded " + file.name);
+      loadObjects(false);
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+  });
+
+  // Status
+
+  function loadStatus() {
+    apiJSON("GET", "/admin/v1/metrics").then(function (metrics) {
+      var s = metrics.storage || {};
+      var pct = s.TotalBytes ? (100 * s.UsedBytes / s.TotalBytes).toFixed(1) + "%" : "-";
+      fillDefinitions($("storage-stats"), [
+        ["Total", formatBytes(s.TotalBytes || 0)],
+        ["Used", formatBytes(s.UsedBytes || 0) + " (" + pct + ")"],
+        ["Free", formatBytes(s.FreeBytes || 0)]
+      ]);
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+
+    apiJSON("GET", "/admin/v1/replication").then(function (r) {
+      if (!r.enabled) {
+        fillDefinitions($("replication-stats"), [["Status", "disabled"]]);
+        return;
+      }
+      fillDefinitions($("replication-stats"), [
+        ["Status", "enabled"],
+        ["Queued", r.events_queued],
+        ["Replicated", r.events_replicated],
+        ["Failed", r.events_failed],
+        ["Last replication", formatTime(r.last_replication)]
+      ]);
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+
+    apiJSON("GET", "/admin/v1/jobs").then(function (result) {
+      var body = $("job-list");
+      body.innerHTML = "";
+      (result.jobs || []).slice(0, 20).forEach(function (job) {
+        var row = document.createElement("tr");
+        cell(row, job.type);
+        cell(row, job.key || "-");
+        cell(row, job.state);
+        var p = job.progress || {};
+        cell(row, p.total ? p.done + "/" + p.total : (p.done || "-"));
+        cell(row, formatTime(job.created_at));
+        body.appendChild(row);
+      });
+    }).catch(function (err) {
+      showMessage(err.message, true);
+    });
+  }
+
+  // Navigation
+
+  function showView(name) {
+    ["buckets", "status"].forEach(function (view) {
+      $("view-" + view).hidden = view !== name;
+    });
+    document.querySelectorAll("nav a").forEach(function (a) {
+      a.className = a.getAttribute("data-view") === name ? "active" : "";
+    });
+    showMessage("");
+
+    if (name === "status") {
+      loadStatus();
+    } else {
+      loadBuckets();
+    }
+  }
+
+  window.addEventListener("hashchange", function () {
+    showView(location.hash === "#status" ? "status" : "buckets");
+  });
+
+  showView(location.hash === "#status" ? "status" : "buckets");
+})();
@@ -3,25 +3,73 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	_ "modernc.org/sqlite" // Pure Go SQLite driver
 )
 
+// ErrOverloaded is returned instead of queueing a write behind too many
+// others waiting for SQLite's single writer
+var ErrOverloaded = errors.New("metadata database is overloaded")
+
+// Pool defaults. WAL mode supports concurrent readers, but only 1 writer
+// at a time, so the pool is kept small to avoid too many connections
+// trying to write.
+const (
+	DefaultMaxOpenConns = 10
+	DefaultMaxIdleConns = 2
+)
+
 // DB wraps sql.DB with application-specific methods
 type DB struct {
 	*sql.DB
 	path string
+
+	maxPendingWrites int64
+	pendingWrites    atomic.Int64
+	shedWrites       atomic.Int64
 }
 
 // Config holds database configuration
 type Config struct {
-	Path string // Database file path
+	Path            string        // Database file path
+	MaxOpenConns    int           // Defaults to DefaultMaxOpenConns
+	MaxIdleConns    int           // Defaults to DefaultMaxIdleConns
+	ConnMaxLifetime time.Duration // 0 keeps connections open indefinitely
+	// MaxPendingWrites sheds writes with ErrOverloaded once this many are
+	// already waiting for the writer lock, rather than letting each run
+	// into the busy timeout. 0 disables shedding.
+	MaxPendingWrites int
+	// SkipMigrations opens the database as it is, for tools that inspect
+	// or roll back the schema
+	SkipMigrations bool
 }
 
+// PoolStats reports the connection pool and the writer queue
+type PoolStats struct {
+	MaxOpenConnections int           `json:"max_open_connections"`
+	OpenConnections    int           `json:"open_connections"`
+	InUse              int           `json:"in_use"`
+	Idle               int           `json:"idle"`
+	WaitCount          int64         `json:"wait_count"`    // Connections waited for
+	WaitDuration       time.Duration `json:"wait_duration"` // Total time waited for connections
+	PendingWrites      int64         `json:"pending_writes"`
+	MaxPendingWrites   int64         `json:"max_pending_writes,omitempty"`
+	ShedWrites         int64         `json:"shed_writes"` // Writes refused with ErrOverloaded
+}
+
+// connectionPragmas are applied by the driver to every pooled connection.
+// Set with PRAGMA statements they would only reach whichever connection
+// ran them, leaving the others to fail writes with SQLITE_BUSY at once.
+// The busy timeout makes SQLite wait up to 5s for a lock; synchronous
+// NORMAL is safe with WAL mode and much faster than FULL.
+const connectionPragmas = "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)"
+
 // Open opens a database connection and runs migrations
 func Open(cfg Config) (*DB, error) {
 	// Ensure directory exists
@@ -32,16 +80,22 @@ func Open(cfg Config) (*DB, error) {
 
 	// Open database connection
 	// Use modernc.org/sqlite (pure Go, no CGO)
-	sqlDB, err := sql.Open("sqlite", cfg.Path)
+	sqlDB, err := sql.Open("sqlite", cfg.Path+connectionPragmas)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Configure connection pool for SQLite
-	// WAL mode supports concurrent readers, but only 1 writer at a time
-	// Keep pool small to avoid too many connections trying to write
-	sqlDB.SetMaxOpenConns(10)
-	sqlDB.SetMaxIdleConns(2)
+	maxOpen, maxIdle := cfg.MaxOpenConns, cfg.MaxIdleConns
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenConns
+	}
+	if maxIdle <= 0 {
+		maxIdle = DefaultMaxIdleConns
+	}
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
 	// Test connection
 	if err := sqlDB.Ping(); err != nil {
@@ -49,11 +103,15 @@ func Open(cfg Config) (*DB, error) {
 	}
 
 	db := &DB{
-		DB:   sqlDB,
-		path: cfg.Path,
+		DB:               sqlDB,
+		path:             cfg.Path,
+		maxPendingWrites: int64(cfg.MaxPendingWrites),
 	}
 
 	// Run migrations
+	if cfg.SkipMigrations {
+		return db, nil
+	}
 	if err := db.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("migration failed: %w", err)
@@ -62,30 +120,13 @@ func Open(cfg Config) (*DB, error) {
 	return db, nil
 }
 
-// migrate runs database migrations
+// migrate sets the database-wide PRAGMAs and applies pending migrations
 func (db *DB) migrate() error {
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
-		return err
-	}
-
 	// Enable WAL mode for better concurrency
 	if _, err := db.Exec("PRAGMA journal_mode = WAL"); err != nil {
 		return err
 	}
 
-	// Set busy timeout to 5 seconds (auto-retry on lock)
-	// This makes SQLite wait instead of immediately returning SQLITE_BUSY
-	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
-		return err
-	}
-
-	// Optimize for concurrent writes
-	// NORMAL is safe with WAL mode and much faster than FULL
-	if _, err := db.Exec("PRAGMA synchronous = NORMAL"); err != nil {
-		return err
-	}
-
 	// Increase cache size for better performance (default is ~2MB, set to 20MB)
 	if _, err := db.Exec("PRAGMA cache_size = -20000"); err != nil {
 		return err
@@ -96,101 +137,8 @@ func (db *DB) migrate() error {
 		return err
 	}
 
-	// Create migrations table
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS migrations (
-			version INTEGER PRIMARY KEY,
-			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`); err != nil {
-		return err
-	}
-
-	// Get current version
-	var currentVersion int
-	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM migrations").Scan(&currentVersion)
-	if err != nil {
-		return err
-	}
-
-	// Apply migrations
-	migrations := []struct {
-		version int
-		sql     string
-	}{
-		{
-			version: 1,
-			sql: `
-				-- Buckets table
-				CREATE TABLE buckets (
-					name TEXT PRIMARY KEY,
-					owner TEXT NOT NULL,
-					created_at TIMESTAMP NOT NULL,
-					versioning_enabled BOOLEAN DEFAULT FALSE
-				);
-				CREATE INDEX idx_buckets_owner ON buckets(owner);
-
-				-- Objects table
-				CREATE TABLE objects (
-					bucket_name TEXT NOT NULL,
-					key TEXT NOT NULL,
-					version_id TEXT NOT NULL,
-					size INTEGER NOT NULL,
-					content_type TEXT,
-					etag TEXT,
-					checksum_algorithm TEXT,
-					checksum_value TEXT,
-					storage_offset INTEGER NOT NULL,
-					created_at TIMESTAMP NOT NULL,
-					modified_at TIMESTAMP NOT NULL,
-					metadata TEXT, -- JSON
-					PRIMARY KEY (bucket_name, key, version_id),
-					FOREIGN KEY (bucket_name) REFERENCES buckets(name) ON DELETE CASCADE
-				);
-
-				CREATE INDEX idx_objects_bucket ON objects(bucket_name);
-				CREATE INDEX idx_objects_key ON objects(bucket_name, key);
-				CREATE INDEX idx_objects_created ON objects(created_at);
-			`,
-		},
-		{
-			version: 2,
-			sql: `
-				-- Add index for listing objects with prefix
-				CREATE INDEX idx_objects_prefix ON objects(bucket_name, key);
-			`,
-		},
-	}
-
-	// Apply pending migrations
-	for _, m := range migrations {
-		if m.version <= currentVersion {
-			continue
-		}
-
-		tx, err := db.Begin()
-		if err != nil {
-			return fmt.Errorf("failed to begin transaction for migration %d: %w", m.version, err)
-		}
-
-		// Execute migration SQL
-		if _, err := tx.Exec(m.sql); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("migration %d failed: %w", m.version, err)
-		}
-
-		// Record migration
-		if _, err := tx.Exec("INSERT INTO migrations (version) VALUES (?)", m.version); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
-		}
-
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
-		}
-	}
-
-	return nil
+	_, err := db.Migrate(context.Background())
+	return err
 }
 
 // Close closes the database connection
@@ -208,31 +156,79 @@ func (db *DB) Stats() sql.DBStats {
 	return db.DB.Stats()
 }
 
+// PoolStats returns the state of the connection pool and writer queue
+func (db *DB) PoolStats() PoolStats {
+	s := db.DB.Stats()
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration,
+		PendingWrites:      db.pendingWrites.Load(),
+		MaxPendingWrites:   db.maxPendingWrites,
+		ShedWrites:         db.shedWrites.Load(),
+	}
+}
+
+// acquireWrite counts a write waiting for or holding the writer lock,
+// refusing it when the queue is full. Call the returned func when done.
+func (db *DB) acquireWrite() (func(), error) {
+	pending := db.pendingWrites.Add(1)
+	if db.maxPendingWrites > 0 && pending > db.maxPendingWrites {
+		db.pendingWrites.Add(-1)
+		db.shedWrites.Add(1)
+		return nil, ErrOverloaded
+	}
+	return func() { db.pendingWrites.Add(-1) }, nil
+}
+
 // ExecWithRetry executes a query with automatic retry on SQLITE_BUSY
 func (db *DB) ExecWithRetry(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	release, err := db.acquireWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var result sql.Result
+	err = retryBusy(ctx, func() error {
+		var err error
+		result, err = db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// retryBusy runs fn, running it again with exponential backoff while it
+// fails with SQLITE_BUSY
+func retryBusy(ctx context.Context, fn func() error) error {
 	const maxRetries = 3
 	var lastErr error
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		result, err := db.ExecContext(ctx, query, args...)
+		err := fn()
 		if err == nil {
-			return result, nil
-		}
-
-		// Check if it's a busy error
-		if isSQLiteBusy(err) {
-			lastErr = err
-			// Exponential backoff: 10ms, 20ms, 40ms
-			backoff := time.Duration(10*(1<<uint(attempt))) * time.Millisecond
-			time.Sleep(backoff)
-			continue
+			return nil
 		}
 
 		// Not a busy error, return immediately
-		return nil, err
+		if !isSQLiteBusy(err) {
+			return err
+		}
+		lastErr = err
+
+		// Exponential backoff: 10ms, 20ms, 40ms
+		backoff := time.Duration(10*(1<<uint(attempt))) * time.Millisecond
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
 	}
 
-	return nil, fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
+	return fmt.Errorf("failed after %d retries: %w", maxRetries, lastErr)
 }
 
 // QueryRowWithRetry queries a single row with automatic retry on SQLITE_BUSY
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpen_PoolConfig(t *testing.T) {
+	db, err := Open(Config{Path: filepath.Join(t.TempDir(), "comio.db"), MaxOpenConns: 4, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	stats := db.PoolStats()
+	if stats.MaxOpenConnections != 4 {
+		t.Errorf("MaxOpenConnections = %d, want 4", stats.MaxOpenConnections)
+	}
+	if stats.OpenConnections == 0 {
+		t.Error("OpenConnections = 0 after migrations")
+	}
+}
+
+func TestExecWithRetry_ShedsWhenWritersQueued(t *testing.T) {
+	db, err := Open(Config{Path: filepath.Join(t.TempDir(), "comio.db"), MaxPendingWrites: 1})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// One write already waiting for the writer lock fills the queue
+	release, err := db.acquireWrite()
+	if err != nil {
+		t.Fatalf("acquireWrite() error = %v", err)
+	}
+
+	_, err = db.ExecWithRetry(ctx, "INSERT INTO buckets (name, owner, created_at) VALUES (?, ?, ?)", "b", "o", time.Now())
+	if !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("ExecWithRetry() error = %v, want ErrOverloaded", err)
+	}
+	if stats := db.PoolStats(); stats.ShedWrites != 1 || stats.PendingWrites != 1 {
+		t.Errorf("stats = %+v, want 1 shed and 1 pending", stats)
+	}
+
+	release()
+	if _, err := db.ExecWithRetry(ctx, "INSERT INTO buckets (name, owner, created_at) VALUES (?, ?, ?)", "b", "o", time.Now()); err != nil {
+		t.Fatalf("ExecWithRetry() after the queue drained error = %v", err)
+	}
+	if pending := db.PoolStats().PendingWrites; pending != 0 {
+		t.Errorf("PendingWrites = %d, want 0", pending)
+	}
+}
+
+func TestWithTx_CommitsAndRollsBack(t *testing.T) {
+	db, err := Open(Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if _, err := db.Exec("CREATE TABLE counters (name TEXT PRIMARY KEY, value INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO counters VALUES ('hits', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent read-modify-write transactions do not lose updates
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			errs <- db.WithTx(ctx, func(tx *Tx) error {
+				var n int
+				if err := tx.QueryRowContext(ctx, "SELECT value FROM counters WHERE name = 'hits'").Scan(&n); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "UPDATE counters SET value = ? WHERE name = 'hits'", n+1)
+				return err
+			})
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("WithTx() error = %v", err)
+		}
+	}
+
+	// A failing fn leaves nothing behind
+	failed := errors.New("failed")
+	err = db.WithTx(ctx, func(tx *Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE counters SET value = 0"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("WithTx() error = %v, want the error of fn", err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT value FROM counters WHERE name = 'hits'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != writers {
+		t.Errorf("value = %d, want %d", n, writers)
+	}
+}
+
+func TestMigrate_RollbackAndStatus(t *testing.T) {
+	db, err := Open(Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	reverted, err := db.Rollback(ctx, 2)
+	if err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if len(reverted) != 2 || reverted[0] != latest {
+		t.Fatalf("Rollback() = %v, want the two newest", reverted)
+	}
+
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatus() error = %v", err)
+	}
+	pending := 0
+	for _, s := range statuses {
+		if !s.Applied {
+			pending++
+		}
+	}
+	if pending != 2 {
+		t.Errorf("%d migrations pending, want 2", pending)
+	}
+
+	applied, err := db.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if len(applied) != 2 || applied[1] != latest {
+		t.Errorf("Migrate() = %v, want the two rolled back", applied)
+	}
+}
+
+func TestMigrate_DetectsDirtyState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "comio.db")
+	db, err := Open(Config{Path: path})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	ctx := context.Background()
+
+	// A migration that was marked but never completed
+	if _, err := db.Exec("INSERT INTO migrations (version, applied_at, dirty) VALUES (999, NULL, TRUE)"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if _, err := Open(Config{Path: path}); !errors.Is(err, ErrDirty) {
+		t.Fatalf("Open() error = %v, want ErrDirty", err)
+	}
+
+	db, err = Open(Config{Path: path, SkipMigrations: true})
+	if err != nil {
+		t.Fatalf("Open() without migrations error = %v", err)
+	}
+	defer db.Close()
+
+	if err := db.ForceClean(ctx); err != nil {
+		t.Fatalf("ForceClean() error = %v", err)
+	}
+	if _, err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() after ForceClean error = %v", err)
+	}
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.Version == 999 {
+			t.Errorf("interrupted migration still recorded: %+v", s)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrDirty is returned when a migration was interrupted, leaving the
+// schema in an unknown state. Inspect the database, then clear the state
+// with ForceClean.
+var ErrDirty = errors.New("database schema is dirty")
+
+// Migration is one numbered schema change, read from
+// migrations/<version>_<name>.up.sql and its .down.sql counterpart
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is the state of one known migration
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Dirty     bool       `json:"dirty,omitempty"`
+}
+
+// Migrations returns the embedded migrations in version order
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+// loadMigrations reads the up and down files of every migration in dir
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		num, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", name)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has files named %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ensureMigrationsTable creates the table recording applied migrations,
+// adding the dirty flag to tables created before it existed
+func (db *DB) ensureMigrationsTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS migrations (
+			version INTEGER PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			dirty BOOLEAN NOT NULL DEFAULT FALSE
+		)
+	`); err != nil {
+		return err
+	}
+
+	var hasDirty bool
+	if err := db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM pragma_table_info('migrations') WHERE name = 'dirty')").Scan(&hasDirty); err != nil {
+		return err
+	}
+	if !hasDirty {
+		if _, err := db.ExecContext(ctx, "ALTER TABLE migrations ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applied returns the recorded migrations by version
+func (db *DB) applied(ctx context.Context) (map[int]MigrationStatus, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at, dirty FROM migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var at sql.NullTime
+		if err := rows.Scan(&s.Version, &at, &s.Dirty); err != nil {
+			return nil, err
+		}
+		s.Applied = !s.Dirty
+		if at.Valid {
+			s.AppliedAt = &at.Time
+		}
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// checkClean fails with ErrDirty when a migration was interrupted
+func checkClean(applied map[int]MigrationStatus) error {
+	for _, s := range applied {
+		if s.Dirty {
+			return fmt.Errorf("%w: migration %d did not complete", ErrDirty, s.Version)
+		}
+	}
+	return nil
+}
+
+// Migrate applies every pending migration in order and returns the
+// versions applied
+func (db *DB) Migrate(ctx context.Context) ([]int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := db.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkClean(applied); err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := db.step(ctx, m.Version, m.Up, true); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// Rollback reverts the steps most recently applied migrations, newest
+// first, and returns the versions reverted
+func (db *DB) Rollback(ctx context.Context, steps int) ([]int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := db.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkClean(applied); err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return done, fmt.Errorf("migration %d (%s) cannot be rolled back: it has no down file", m.Version, m.Name)
+		}
+		if err := db.step(ctx, m.Version, m.Down, false); err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// step runs one migration in a transaction. The migration is first
+// marked dirty in its own commit, so a crash midway leaves a record that
+// it did not complete; the mark is cleared in the migration's transaction.
+func (db *DB) step(ctx context.Context, version int, script string, up bool) error {
+	if up {
+		_, err := db.ExecContext(ctx, "INSERT INTO migrations (version, applied_at, dirty) VALUES (?, NULL, TRUE)", version)
+		if err != nil {
+			return fmt.Errorf("failed to record migration: %w", err)
+		}
+	} else if _, err := db.ExecContext(ctx, "UPDATE migrations SET dirty = TRUE WHERE version = ?", version); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "UPDATE migrations SET dirty = FALSE, applied_at = CURRENT_TIMESTAMP WHERE version = ?", version)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM migrations WHERE version = ?", version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// MigrationStatus returns every known migration and whether it is
+// applied, followed by recorded versions no longer known to this build
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := db.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s, ok := applied[m.Version]
+		if !ok {
+			s = MigrationStatus{Version: m.Version}
+		}
+		s.Name = m.Name
+		statuses = append(statuses, s)
+		delete(applied, m.Version)
+	}
+
+	var unknown []MigrationStatus
+	for _, s := range applied {
+		s.Name = "(unknown)"
+		unknown = append(unknown, s)
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...), nil
+}
+
+// ForceClean clears the dirty mark left by an interrupted migration, after
+// the schema has been checked or repaired by hand. A migration interrupted
+// while being applied is forgotten, so Migrate runs it again; one
+// interrupted while being rolled back is considered still applied.
+func (db *DB) ForceClean(ctx context.Context) error {
+	if err := db.ensureMigrationsTable(ctx); err != nil {
+		return err
+	}
+	// Only completed migrations have applied_at set
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM migrations WHERE dirty = TRUE AND applied_at IS NULL;
+		UPDATE migrations SET dirty = FALSE WHERE dirty = TRUE;
+	`)
+	return err
+}
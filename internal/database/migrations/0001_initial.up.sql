@@ -0,0 +1,30 @@
+-- Buckets table
+CREATE TABLE buckets (
+	name TEXT PRIMARY KEY,
+	owner TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	versioning_enabled BOOLEAN DEFAULT FALSE
+);
+CREATE INDEX idx_buckets_owner ON buckets(owner);
+
+-- Objects table
+CREATE TABLE objects (
+	bucket_name TEXT NOT NULL,
+	key TEXT NOT NULL,
+	version_id TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	content_type TEXT,
+	etag TEXT,
+	checksum_algorithm TEXT,
+	checksum_value TEXT,
+	storage_offset INTEGER NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	modified_at TIMESTAMP NOT NULL,
+	metadata TEXT, -- JSON
+	PRIMARY KEY (bucket_name, key, version_id),
+	FOREIGN KEY (bucket_name) REFERENCES buckets(name) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_objects_bucket ON objects(bucket_name);
+CREATE INDEX idx_objects_key ON objects(bucket_name, key);
+CREATE INDEX idx_objects_created ON objects(created_at);
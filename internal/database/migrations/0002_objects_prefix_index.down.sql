@@ -0,0 +1 @@
+DROP INDEX idx_objects_prefix;
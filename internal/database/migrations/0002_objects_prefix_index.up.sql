@@ -0,0 +1,2 @@
+-- Add index for listing objects with prefix
+CREATE INDEX idx_objects_prefix ON objects(bucket_name, key);
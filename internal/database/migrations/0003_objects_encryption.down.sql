@@ -0,0 +1 @@
+ALTER TABLE objects DROP COLUMN encryption;
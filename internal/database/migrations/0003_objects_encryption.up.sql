@@ -0,0 +1,2 @@
+-- Server-side encryption envelope of the object
+ALTER TABLE objects ADD COLUMN encryption TEXT; -- JSON
@@ -0,0 +1,2 @@
+ALTER TABLE objects DROP COLUMN storage_class;
+ALTER TABLE objects DROP COLUMN owner;
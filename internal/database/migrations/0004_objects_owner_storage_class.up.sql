@@ -0,0 +1,3 @@
+-- Uploader and storage class returned in listings
+ALTER TABLE objects ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE objects ADD COLUMN storage_class TEXT NOT NULL DEFAULT 'STANDARD';
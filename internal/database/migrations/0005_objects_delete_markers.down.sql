@@ -0,0 +1,2 @@
+ALTER TABLE objects DROP COLUMN replicated_at;
+ALTER TABLE objects DROP COLUMN delete_marker;
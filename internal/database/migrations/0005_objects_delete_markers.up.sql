@@ -0,0 +1,3 @@
+-- Delete markers, and when they reached the replication target
+ALTER TABLE objects ADD COLUMN delete_marker BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE objects ADD COLUMN replicated_at TIMESTAMP;
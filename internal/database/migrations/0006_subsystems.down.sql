@@ -0,0 +1,6 @@
+DROP TABLE multipart_parts;
+DROP TABLE multipart_uploads;
+DROP TABLE users;
+ALTER TABLE buckets DROP COLUMN quota;
+ALTER TABLE buckets DROP COLUMN policy;
+ALTER TABLE buckets DROP COLUMN lifecycle;
@@ -0,0 +1,43 @@
+-- Bucket configuration, as JSON
+ALTER TABLE buckets ADD COLUMN lifecycle TEXT;
+ALTER TABLE buckets ADD COLUMN policy TEXT;
+ALTER TABLE buckets ADD COLUMN quota TEXT;
+
+-- Users and service accounts. The secret is kept in plaintext only until
+-- a master key seals it.
+CREATE TABLE users (
+	access_key_id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	secret_access_key TEXT,
+	sealed_secret TEXT, -- JSON
+	policies TEXT, -- JSON
+	parent_access_key_id TEXT,
+	scope TEXT, -- JSON
+	created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_users_parent ON users(parent_access_key_id);
+
+-- In-progress multipart uploads and their parts
+CREATE TABLE multipart_uploads (
+	upload_id TEXT PRIMARY KEY,
+	bucket_name TEXT NOT NULL,
+	key TEXT NOT NULL,
+	content_type TEXT,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX idx_multipart_uploads_key ON multipart_uploads(bucket_name, key);
+
+CREATE TABLE multipart_parts (
+	upload_id TEXT NOT NULL,
+	part_number INTEGER NOT NULL,
+	etag TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	checksum TEXT,
+	checksum_algorithm TEXT,
+	checksum_value TEXT,
+	storage_offset INTEGER NOT NULL,
+	last_modified TIMESTAMP NOT NULL,
+	PRIMARY KEY (upload_id, part_number),
+	FOREIGN KEY (upload_id) REFERENCES multipart_uploads(upload_id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE prefix_stats;
+DROP TABLE prefix_stats_buckets;
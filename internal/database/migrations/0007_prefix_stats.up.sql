@@ -0,0 +1,13 @@
+-- Object count and size per first-level prefix, for buckets whose totals
+-- have been computed once and are since kept up to date
+CREATE TABLE prefix_stats_buckets (
+	bucket_name TEXT PRIMARY KEY
+);
+
+CREATE TABLE prefix_stats (
+	bucket_name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	objects INTEGER NOT NULL,
+	bytes INTEGER NOT NULL,
+	PRIMARY KEY (bucket_name, prefix)
+);
@@ -0,0 +1 @@
+ALTER TABLE buckets DROP COLUMN checksum_algorithms;
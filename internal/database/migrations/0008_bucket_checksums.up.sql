@@ -0,0 +1,2 @@
+-- Checksum algorithms chosen for the bucket's objects
+ALTER TABLE buckets ADD COLUMN checksum_algorithms TEXT; -- JSON list
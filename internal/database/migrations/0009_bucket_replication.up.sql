@@ -0,0 +1,2 @@
+-- Buckets whose objects are not replicated
+ALTER TABLE buckets ADD COLUMN replication_disabled BOOLEAN NOT NULL DEFAULT FALSE;
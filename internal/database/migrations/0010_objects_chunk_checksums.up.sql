@@ -0,0 +1,2 @@
+-- Per-chunk checksums of large objects
+ALTER TABLE objects ADD COLUMN chunk_checksums TEXT; -- JSON
@@ -0,0 +1,2 @@
+-- Whether the object's data is followed by a seal record on the device
+ALTER TABLE objects ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+DROP TRIGGER search_documents_update;
+DROP TRIGGER search_documents_delete;
+DROP TRIGGER search_documents_insert;
+DROP TABLE search_index;
+DROP TABLE search_documents;
+DROP TABLE search_buckets;
@@ -0,0 +1,34 @@
+-- Keys and user metadata of the latest object versions, for buckets
+-- indexed once and since kept up to date, with a full-text index over them
+CREATE TABLE search_buckets (
+	bucket_name TEXT PRIMARY KEY
+);
+
+CREATE TABLE search_documents (
+	id INTEGER PRIMARY KEY,
+	bucket_name TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	metadata TEXT NOT NULL,
+	UNIQUE (bucket_name, object_key)
+);
+
+CREATE VIRTUAL TABLE search_index USING fts5(
+	object_key,
+	metadata,
+	content = 'search_documents',
+	content_rowid = 'id',
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER search_documents_insert AFTER INSERT ON search_documents BEGIN
+	INSERT INTO search_index (rowid, object_key, metadata) VALUES (new.id, new.object_key, new.metadata);
+END;
+
+CREATE TRIGGER search_documents_delete AFTER DELETE ON search_documents BEGIN
+	INSERT INTO search_index (search_index, rowid, object_key, metadata) VALUES ('delete', old.id, old.object_key, old.metadata);
+END;
+
+CREATE TRIGGER search_documents_update AFTER UPDATE ON search_documents BEGIN
+	INSERT INTO search_index (search_index, rowid, object_key, metadata) VALUES ('delete', old.id, old.object_key, old.metadata);
+	INSERT INTO search_index (rowid, object_key, metadata) VALUES (new.id, new.object_key, new.metadata);
+END;
@@ -0,0 +1,2 @@
+-- Whether the object's data may be shared with objects of bucket clones
+ALTER TABLE objects ADD COLUMN shared BOOLEAN NOT NULL DEFAULT FALSE;
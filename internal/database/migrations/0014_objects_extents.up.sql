@@ -0,0 +1,2 @@
+-- Runs of data of versions sharing it with the version they were written on
+ALTER TABLE objects ADD COLUMN extents TEXT;
@@ -0,0 +1,2 @@
+-- Tenant whose bucket namespace a user is confined to
+ALTER TABLE users ADD COLUMN tenant TEXT;
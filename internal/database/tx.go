@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Tx is a write transaction started by WithTx. Its statements run on the
+// connection holding the transaction.
+type Tx struct {
+	conn *sql.Conn
+}
+
+// ExecContext executes a statement in the transaction
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.conn.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs a query in the transaction
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.conn.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs a query returning at most one row in the transaction
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.conn.QueryRowContext(ctx, query, args...)
+}
+
+// WithTx runs fn in a transaction started with BEGIN IMMEDIATE, which
+// takes the writer lock up front: a transaction that only upgrades to a
+// writer at its first write fails with SQLITE_BUSY when another writer
+// got in between, without waiting for the busy timeout. fn is run again,
+// with the same backoff as ExecWithRetry, when the transaction fails with
+// SQLITE_BUSY, so it must not have side effects outside the transaction.
+// The transaction commits if fn returns nil and rolls back otherwise.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	release, err := db.acquireWrite()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return retryBusy(ctx, func() error {
+		return db.runTx(ctx, fn)
+	})
+}
+
+// runTx runs fn in a single BEGIN IMMEDIATE transaction
+func (db *DB) runTx(ctx context.Context, fn func(tx *Tx) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(&Tx{conn: conn}); err != nil {
+		// A canceled ctx must not keep the rollback from running
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package diskhealth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/alerting"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// Config holds disk health check settings
+type Config struct {
+	Devices            []string
+	Interval           time.Duration
+	WearWarningPercent int   // Wear at which a warning is raised; 0 disables
+	ReallocatedSectors int64 // Reallocated sectors at which a warning is raised; 0 disables
+}
+
+// Checker periodically probes devices, keeps their latest reports and
+// raises alerts for disks showing signs of failure
+type Checker struct {
+	config   Config
+	prober   Prober
+	notifier *alerting.Notifier
+
+	mu      sync.RWMutex
+	reports map[string]Report
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewChecker creates a checker. notifier may be nil, in which case
+// reports are only exposed through Reports.
+func NewChecker(config Config, prober Prober, notifier *alerting.Notifier) *Checker {
+	if config.Interval <= 0 {
+		config.Interval = time.Hour
+	}
+	return &Checker{
+		config:   config,
+		prober:   prober,
+		notifier: notifier,
+		reports:  make(map[string]Report),
+	}
+}
+
+// Start probes the devices now and then periodically
+func (c *Checker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.Check(ctx)
+
+		ticker := time.NewTicker(c.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.Check(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops periodic checks
+func (c *Checker) Stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	c.wg.Wait()
+}
+
+// Check probes every device once
+func (c *Checker) Check(ctx context.Context) {
+	for _, device := range c.config.Devices {
+		report, err := c.prober.Probe(ctx, device)
+		if err != nil {
+			if !errors.Is(err, ErrUnsupported) {
+				monitoring.Log.Warn("Failed to read disk health",
+					zap.String("device", device),
+					zap.Error(err))
+			}
+			report = newReport(device, "")
+			report.Error = err.Error()
+		}
+		report.CheckedAt = time.Now()
+
+		c.mu.Lock()
+		c.reports[device] = *report
+		c.mu.Unlock()
+
+		if report.Error == "" {
+			c.evaluate(report)
+		}
+	}
+}
+
+// Reports returns the latest report of every device, sorted by device
+func (c *Checker) Reports() []Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	reports := make([]Report, 0, len(c.reports))
+	for _, r := range c.reports {
+		reports = append(reports, r)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Device < reports[j].Device
+	})
+	return reports
+}
+
+// problems lists the signs of failure in a report and how severe they are
+func (c *Checker) problems(r *Report) (alerting.Severity, []string) {
+	var severity alerting.Severity
+	var problems []string
+	raise := func(s alerting.Severity, problem string) {
+		if severity != alerting.SeverityCritical {
+			severity = s
+		}
+		problems = append(problems, problem)
+	}
+
+	if !r.Passed {
+		raise(alerting.SeverityCritical, "SMART self-assessment failed")
+	}
+	if r.PercentageUsed >= 100 {
+		raise(alerting.SeverityCritical, fmt.Sprintf("wear at %d%% of rated endurance", r.PercentageUsed))
+	} else if c.config.WearWarningPercent > 0 && r.PercentageUsed >= c.config.WearWarningPercent {
+		raise(alerting.SeverityWarning, fmt.Sprintf("wear at %d%% of rated endurance", r.PercentageUsed))
+	}
+	if c.config.ReallocatedSectors > 0 && r.ReallocatedSectors >= c.config.ReallocatedSectors {
+		raise(alerting.SeverityWarning, fmt.Sprintf("%d reallocated sectors", r.ReallocatedSectors))
+	}
+	if r.PendingSectors > 0 {
+		raise(alerting.SeverityWarning, fmt.Sprintf("%d sectors pending reallocation", r.PendingSectors))
+	}
+	if r.MediaErrors > 0 {
+		raise(alerting.SeverityWarning, fmt.Sprintf("%d media errors", r.MediaErrors))
+	}
+	return severity, problems
+}
+
+func (c *Checker) evaluate(r *Report) {
+	severity, problems := c.problems(r)
+	if len(problems) == 0 {
+		return
+	}
+
+	monitoring.Log.Warn("Disk shows signs of failure",
+		zap.String("device", r.Device),
+		zap.String("severity", string(severity)),
+		zap.Strings("problems", problems))
+
+	if c.notifier == nil {
+		return
+	}
+	c.notifier.Notify(alerting.Alert{
+		Type:     alerting.TypeDiskHealth,
+		Severity: severity,
+		Key:      r.Device,
+		Summary:  fmt.Sprintf("disk %s: %s", r.Device, strings.Join(problems, ", ")),
+		Details: map[string]interface{}{
+			"device":              r.Device,
+			"source":              r.Source,
+			"passed":              r.Passed,
+			"percentage_used":     r.PercentageUsed,
+			"reallocated_sectors": r.ReallocatedSectors,
+			"pending_sectors":     r.PendingSectors,
+			"media_errors":        r.MediaErrors,
+			"temperature":         r.Temperature,
+		},
+	})
+}
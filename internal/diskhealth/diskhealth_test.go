@@ -0,0 +1,196 @@
+package diskhealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/alerting"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func TestParseSmartctl_ATA(t *testing.T) {
+	out := []byte(`{
+		"smart_status": {"passed": true},
+		"ata_smart_attributes": {"table": [
+			{"id": 5, "raw": {"value": 12}},
+			{"id": 177, "raw": {"value": 85}},
+			{"id": 197, "raw": {"value": 2}},
+			{"id": 198, "raw": {"value": 0}}
+		]},
+		"temperature": {"current": 38}
+	}`)
+
+	r, err := parseSmartctl("/dev/sda", out)
+	if err != nil {
+		t.Fatalf("parseSmartctl failed: %v", err)
+	}
+	if !r.Passed || r.ReallocatedSectors != 12 || r.PendingSectors != 2 || r.MediaErrors != 0 {
+		t.Errorf("report = %+v", r)
+	}
+	if r.PercentageUsed != 15 {
+		t.Errorf("PercentageUsed = %d, want 15", r.PercentageUsed)
+	}
+	if r.Temperature != 38 || r.Source != "smartctl" {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestParseSmartctl_NVMe(t *testing.T) {
+	out := []byte(`{
+		"smart_status": {"passed": false},
+		"nvme_smart_health_information_log": {"percentage_used": 103, "media_errors": 4}
+	}`)
+
+	r, err := parseSmartctl("/dev/nvme0n1", out)
+	if err != nil {
+		t.Fatalf("parseSmartctl failed: %v", err)
+	}
+	if r.Passed || r.PercentageUsed != 103 || r.MediaErrors != 4 {
+		t.Errorf("report = %+v", r)
+	}
+	// Not reported by NVMe devices
+	if r.ReallocatedSectors != -1 || r.Temperature != -1 {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestParseSmartctl_Unsupported(t *testing.T) {
+	out := []byte(`{"smartctl": {"messages": [{"string": "Unable to detect device type"}]}}`)
+
+	if _, err := parseSmartctl("/tmp/file", out); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestSysfsProber(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "class", "block", "sdx", "device")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ioerr_cnt"), []byte("0x1a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := SysfsProber{Root: root}
+	r, err := p.Probe(context.Background(), "/dev/sdx")
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if r.MediaErrors != 26 || r.Source != "sysfs" || r.PercentageUsed != -1 {
+		t.Errorf("report = %+v", r)
+	}
+
+	if _, err := p.Probe(context.Background(), "/dev/sdy"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
+
+type fakeProber map[string]*Report
+
+func (p fakeProber) Probe(ctx context.Context, device string) (*Report, error) {
+	r, ok := p[device]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	copied := *r
+	return &copied, nil
+}
+
+func TestFallbackProber(t *testing.T) {
+	healthy := newReport("/dev/sda", "sysfs")
+	p := FallbackProber{fakeProber{}, fakeProber{"/dev/sda": healthy}}
+
+	r, err := p.Probe(context.Background(), "/dev/sda")
+	if err != nil || r.Source != "sysfs" {
+		t.Errorf("Probe = %+v, %v", r, err)
+	}
+	if _, err := p.Probe(context.Background(), "/dev/sdb"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestChecker_AlertsOnFailingDisk(t *testing.T) {
+	received := make(chan alerting.Alert, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p struct {
+			Alert alerting.Alert `json:"alert"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("invalid webhook body: %v", err)
+		}
+		received <- p.Alert
+	}))
+	defer srv.Close()
+
+	notifier := alerting.NewNotifier(alerting.Config{URLs: []string{srv.URL}, Cooldown: time.Hour})
+	notifier.Start()
+	defer notifier.Stop()
+
+	worn := newReport("/dev/sdb", "smartctl")
+	worn.PercentageUsed = 85
+	worn.ReallocatedSectors = 3
+	healthy := newReport("/dev/sda", "smartctl")
+	healthy.PercentageUsed = 10
+	healthy.ReallocatedSectors = 0
+
+	c := NewChecker(Config{
+		Devices:            []string{"/dev/sdb", "/dev/sda", "/dev/sdc"},
+		WearWarningPercent: 80,
+		ReallocatedSectors: 1,
+	}, fakeProber{"/dev/sda": healthy, "/dev/sdb": worn}, notifier)
+	c.Check(context.Background())
+
+	select {
+	case a := <-received:
+		if a.Type != alerting.TypeDiskHealth || a.Severity != alerting.SeverityWarning || a.Key != "/dev/sdb" {
+			t.Errorf("alert = %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+	select {
+	case a := <-received:
+		t.Errorf("unexpected alert %+v", a)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	reports := c.Reports()
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports, want 3", len(reports))
+	}
+	if reports[0].Device != "/dev/sda" || reports[1].Device != "/dev/sdb" {
+		t.Errorf("reports not sorted: %+v", reports)
+	}
+	if reports[2].Error == "" || reports[2].CheckedAt.IsZero() {
+		t.Errorf("unprobed device report = %+v", reports[2])
+	}
+}
+
+func TestChecker_Problems(t *testing.T) {
+	c := NewChecker(Config{WearWarningPercent: 80, ReallocatedSectors: 10}, fakeProber{}, nil)
+
+	failed := newReport("/dev/sda", "smartctl")
+	failed.Passed = false
+	failed.PendingSectors = 1
+	if severity, problems := c.problems(failed); severity != alerting.SeverityCritical || len(problems) != 2 {
+		t.Errorf("problems = %s %v", severity, problems)
+	}
+
+	fine := newReport("/dev/sda", "smartctl")
+	fine.ReallocatedSectors = 9
+	if _, problems := c.problems(fine); len(problems) != 0 {
+		t.Errorf("problems = %v, want none", problems)
+	}
+}
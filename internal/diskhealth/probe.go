@@ -0,0 +1,210 @@
+// Package diskhealth watches the SMART attributes of storage devices, so
+// failing disks are reported before they lose data.
+package diskhealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned by probes that cannot inspect a device
+var ErrUnsupported = errors.New("device health is not available")
+
+// Report is the health of one device. Counters a probe cannot read are -1.
+type Report struct {
+	Device             string    `json:"device"`
+	Source             string    `json:"source"`          // "smartctl" or "sysfs"
+	Passed             bool      `json:"passed"`          // Overall self-assessment
+	PercentageUsed     int       `json:"percentage_used"` // Wear of SSDs, in percent of rated endurance
+	ReallocatedSectors int64     `json:"reallocated_sectors"`
+	PendingSectors     int64     `json:"pending_sectors"`
+	MediaErrors        int64     `json:"media_errors"`
+	Temperature        int       `json:"temperature"` // Celsius
+	CheckedAt          time.Time `json:"checked_at"`
+	Error              string    `json:"error,omitempty"`
+}
+
+func newReport(device, source string) *Report {
+	return &Report{
+		Device:             device,
+		Source:             source,
+		Passed:             true,
+		PercentageUsed:     -1,
+		ReallocatedSectors: -1,
+		PendingSectors:     -1,
+		MediaErrors:        -1,
+		Temperature:        -1,
+	}
+}
+
+// Prober reads the health of a device
+type Prober interface {
+	Probe(ctx context.Context, device string) (*Report, error)
+}
+
+// SmartctlProber runs smartctl, which reads SMART data from ATA, SCSI
+// and NVMe devices
+type SmartctlProber struct {
+	Path string // smartctl binary; defaults to "smartctl" on PATH
+}
+
+// smartctlOutput is the part of `smartctl --json` output that is used
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATAAttributes struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeLog *struct {
+		PercentageUsed int   `json:"percentage_used"`
+		MediaErrors    int64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+	Temperature *struct {
+		Current int `json:"current"`
+	} `json:"temperature"`
+	Smartctl struct {
+		Messages []struct {
+			String string `json:"string"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+}
+
+// ATA attribute IDs
+const (
+	ataReallocatedSectors = 5
+	ataWearLeveling       = 177
+	ataPendingSectors     = 197
+	ataUncorrectable      = 198
+	ataPercentLifeUsed    = 202
+)
+
+// Probe implements Prober
+func (p SmartctlProber) Probe(ctx context.Context, device string) (*Report, error) {
+	path := p.Path
+	if path == "" {
+		path = "smartctl"
+	}
+
+	// smartctl exits non-zero when it finds problems, so the exit status
+	// alone does not mean the output is unusable
+	out, err := exec.CommandContext(ctx, path, "--json", "-H", "-A", device).Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return parseSmartctl(device, out)
+}
+
+func parseSmartctl(device string, out []byte) (*Report, error) {
+	var parsed smartctlOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid smartctl output: %w", err)
+	}
+	if parsed.SmartStatus == nil {
+		msg := "no SMART status"
+		if len(parsed.Smartctl.Messages) > 0 {
+			msg = parsed.Smartctl.Messages[0].String
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, msg)
+	}
+
+	r := newReport(device, "smartctl")
+	r.Passed = parsed.SmartStatus.Passed
+	for _, attr := range parsed.ATAAttributes.Table {
+		switch attr.ID {
+		case ataReallocatedSectors:
+			r.ReallocatedSectors = attr.Raw.Value
+		case ataPendingSectors:
+			r.PendingSectors = attr.Raw.Value
+		case ataUncorrectable:
+			r.MediaErrors = attr.Raw.Value
+		case ataPercentLifeUsed:
+			r.PercentageUsed = int(attr.Raw.Value)
+		case ataWearLeveling:
+			// Raw value is the remaining life on most drives
+			if r.PercentageUsed < 0 && attr.Raw.Value <= 100 {
+				r.PercentageUsed = 100 - int(attr.Raw.Value)
+			}
+		}
+	}
+	if parsed.NVMeLog != nil {
+		r.PercentageUsed = parsed.NVMeLog.PercentageUsed
+		r.MediaErrors = parsed.NVMeLog.MediaErrors
+	}
+	if parsed.Temperature != nil {
+		r.Temperature = parsed.Temperature.Current
+	}
+	return r, nil
+}
+
+// SysfsProber reads the I/O error counters Linux keeps in /sys, for hosts
+// without smartctl. It cannot see wear or sector reallocation.
+type SysfsProber struct {
+	Root string // Defaults to "/sys"
+}
+
+// Probe implements Prober
+func (p SysfsProber) Probe(ctx context.Context, device string) (*Report, error) {
+	root := p.Root
+	if root == "" {
+		root = "/sys"
+	}
+
+	// Partitions report through their parent disk
+	dir := filepath.Join(root, "class", "block", filepath.Base(device))
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err == nil {
+		dir = filepath.Dir(mustEvalSymlinks(dir))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "device", "ioerr_cnt"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	count, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ioerr_cnt for %s: %w", device, err)
+	}
+
+	r := newReport(device, "sysfs")
+	r.MediaErrors = count
+	return r, nil
+}
+
+func mustEvalSymlinks(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// FallbackProber uses smartctl, and sysfs where smartctl is unavailable
+type FallbackProber []Prober
+
+// Probe implements Prober
+func (p FallbackProber) Probe(ctx context.Context, device string) (*Report, error) {
+	err := ErrUnsupported
+	for _, prober := range p {
+		var r *Report
+		if r, err = prober.Probe(ctx, device); err == nil {
+			return r, nil
+		}
+		if !errors.Is(err, ErrUnsupported) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
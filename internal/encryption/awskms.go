@@ -0,0 +1,174 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSKMSSource decrypts master keys stored in the configuration as AWS KMS
+// ciphertexts (from aws kms generate-data-key), so the plaintext keys
+// only ever exist in memory. Access is governed by the KMS key policy.
+type AWSKMSSource struct {
+	Region          string
+	Endpoint        string // Defaults to https://kms.<region>.amazonaws.com
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Ciphertexts     map[int]string // Master key version -> base64 CiphertextBlob
+	Client          *http.Client
+}
+
+// Name implements KeySource
+func (a *AWSKMSSource) Name() string {
+	return "aws-kms"
+}
+
+// FetchKeys implements KeySource
+func (a *AWSKMSSource) FetchKeys(ctx context.Context) (map[int][]byte, int, error) {
+	if len(a.Ciphertexts) == 0 {
+		return nil, 0, fmt.Errorf("no AWS KMS ciphertexts configured")
+	}
+
+	keys := make(map[int][]byte, len(a.Ciphertexts))
+	for version, blob := range a.Ciphertexts {
+		key, err := a.decrypt(ctx, blob)
+		if err != nil {
+			return nil, 0, fmt.Errorf("master key version %d: %w", version, err)
+		}
+		if len(key) != KeySize {
+			return nil, 0, fmt.Errorf("master key version %d must be %d bytes, got %d", version, KeySize, len(key))
+		}
+		keys[version] = key
+	}
+	return keys, 0, nil
+}
+
+// decrypt calls the KMS Decrypt action
+func (a *AWSKMSSource) decrypt(ctx context.Context, blob string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"CiphertextBlob": blob})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%s.amazonaws.com", a.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+
+	a.sign(req, body, time.Now().UTC())
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("AWS KMS request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("AWS KMS returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var out struct {
+		Plaintext string `json:"Plaintext"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid AWS KMS response: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(out.Plaintext)
+}
+
+// sign adds an AWS Signature Version 4 to a KMS request
+func (a *AWSKMSSource) sign(req *http.Request, body []byte, t time.Time) {
+	signV4(req, body, t, a.AccessKeyID, a.SecretAccessKey, a.SessionToken, a.Region, "kms")
+}
+
+// signV4 signs a request with AWS Signature Version 4. The host, the
+// content type and all X-Amz-* headers are signed.
+func signV4(req *http.Request, body []byte, t time.Time, accessKeyID, secretAccessKey, sessionToken, region, service string) {
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	// url.Values.Encode sorts by key and escapes spaces as '+', which
+	// SigV4 wants as %20
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
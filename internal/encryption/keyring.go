@@ -0,0 +1,263 @@
+// Package encryption implements server-side encryption of object data with
+// envelope keys: every object is encrypted with its own data key, and data
+// keys are stored wrapped with a versioned master key. Rotating the master
+// key only re-wraps data keys; object data is never rewritten.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// AlgorithmAES256 is AES-256 in CTR mode, reported to clients as
+// x-amz-server-side-encryption: AES256
+const AlgorithmAES256 = "AES256"
+
+// KeySize is the size of master and data keys in bytes
+const KeySize = 32
+
+var (
+	// ErrNotConfigured is returned when reading encrypted data without
+	// master keys
+	ErrNotConfigured = errors.New("server-side encryption is not configured")
+	// ErrUnknownKeyVersion is returned for envelopes wrapped with a master
+	// key version that is not in the keyring
+	ErrUnknownKeyVersion = errors.New("unknown master key version")
+	// ErrKeysUnavailable is returned when wrapping new data keys while the
+	// master keys cannot be refreshed from their source. Existing objects
+	// stay readable with the cached keys.
+	ErrKeysUnavailable = errors.New("master keys are unavailable")
+)
+
+// Envelope is the encryption metadata stored with an object
+type Envelope struct {
+	Algorithm  string `json:"algorithm"`
+	KeyVersion int    `json:"key_version"` // Master key version the data key is wrapped with
+	WrappedKey []byte `json:"wrapped_key"`
+	IV         []byte `json:"iv"`
+}
+
+// Keyring holds the versions of the master key. New data keys are wrapped
+// with the active version; older versions are kept to unwrap existing ones.
+type Keyring struct {
+	mu          sync.RWMutex
+	keys        map[int][]byte
+	active      int
+	unavailable error // Set while the key source cannot be reached
+}
+
+// NewKeyring creates a keyring from master keys by version. An active
+// version of 0 selects the highest one.
+func NewKeyring(keys map[int][]byte, active int) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Update(keys, active); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Update replaces the master keys, such as after fetching them again from
+// a key management service, and clears any unavailability
+func (k *Keyring) Update(keys map[int][]byte, active int) error {
+	if len(keys) == 0 {
+		return errors.New("no master keys configured")
+	}
+	for version, key := range keys {
+		if version <= 0 {
+			return fmt.Errorf("invalid master key version %d", version)
+		}
+		if len(key) != KeySize {
+			return fmt.Errorf("master key version %d must be %d bytes, got %d", version, KeySize, len(key))
+		}
+	}
+	if active == 0 {
+		for version := range keys {
+			if version > active {
+				active = version
+			}
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return fmt.Errorf("%w: active version %d", ErrUnknownKeyVersion, active)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	k.active = active
+	k.unavailable = nil
+	return nil
+}
+
+// SetUnavailable marks the keys as unavailable because of err, refusing
+// new data keys until the next Update. A nil err clears the state.
+func (k *Keyring) SetUnavailable(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.unavailable = err
+}
+
+// Available returns an error wrapping ErrKeysUnavailable while new data
+// keys are refused
+func (k *Keyring) Available() error {
+	if k == nil {
+		return ErrNotConfigured
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.unavailable != nil {
+		return fmt.Errorf("%w: %v", ErrKeysUnavailable, k.unavailable)
+	}
+	return nil
+}
+
+// ParseKey decodes a base64-encoded master key
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// ActiveVersion returns the master key version new data keys are wrapped with
+func (k *Keyring) ActiveVersion() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Versions returns the master key versions in the keyring, in ascending order
+func (k *Keyring) Versions() []int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	versions := make([]int, 0, len(k.keys))
+	for version := range k.keys {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// NewDataKey generates a data key for an object and its envelope
+func (k *Keyring) NewDataKey() ([]byte, *Envelope, error) {
+	if err := k.Available(); err != nil {
+		return nil, nil, err
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate IV: %w", err)
+	}
+
+	active := k.ActiveVersion()
+	wrapped, err := k.wrap(active, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, &Envelope{
+		Algorithm:  AlgorithmAES256,
+		KeyVersion: active,
+		WrappedKey: wrapped,
+		IV:         iv,
+	}, nil
+}
+
+// DataKey unwraps the data key of an envelope
+func (k *Keyring) DataKey(env *Envelope) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNotConfigured
+	}
+	if env.Algorithm != AlgorithmAES256 {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", env.Algorithm)
+	}
+
+	gcm, err := k.aead(env.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.WrappedKey) < gcm.NonceSize() {
+		return nil, errors.New("wrapped data key is truncated")
+	}
+	nonce, sealed := env.WrappedKey[:gcm.NonceSize()], env.WrappedKey[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, sealed, versionLabel(env.KeyVersion))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key version %d: %w", env.KeyVersion, err)
+	}
+	return dataKey, nil
+}
+
+// Stale reports whether an envelope is wrapped with a master key version
+// other than the active one
+func (k *Keyring) Stale(env *Envelope) bool {
+	return env != nil && env.KeyVersion != k.ActiveVersion()
+}
+
+// Rewrap returns a copy of env with its data key wrapped with the active
+// master key version
+func (k *Keyring) Rewrap(env *Envelope) (*Envelope, error) {
+	if err := k.Available(); err != nil {
+		return nil, err
+	}
+	dataKey, err := k.DataKey(env)
+	if err != nil {
+		return nil, err
+	}
+	active := k.ActiveVersion()
+	wrapped, err := k.wrap(active, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrapped := *env
+	rewrapped.KeyVersion = active
+	rewrapped.WrappedKey = wrapped
+	return &rewrapped, nil
+}
+
+// wrap seals a data key with a master key version, prefixed by the nonce
+func (k *Keyring) wrap(version int, dataKey []byte) ([]byte, error) {
+	gcm, err := k.aead(version)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, dataKey, versionLabel(version)), nil
+}
+
+func (k *Keyring) aead(version int) (cipher.AEAD, error) {
+	k.mu.RLock()
+	key, ok := k.keys[version]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyVersion, version)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// versionLabel binds a wrapped key to its master key version
+func versionLabel(version int) []byte {
+	return []byte("comio-master-key-v" + strconv.Itoa(version))
+}
@@ -0,0 +1,164 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestNewKeyring_DefaultsToHighestVersion(t *testing.T) {
+	k, err := NewKeyring(map[int][]byte{1: testKey(t), 3: testKey(t), 2: testKey(t)}, 0)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	if k.ActiveVersion() != 3 {
+		t.Errorf("ActiveVersion = %d, want 3", k.ActiveVersion())
+	}
+
+	if _, err := NewKeyring(map[int][]byte{1: testKey(t)}, 2); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("err = %v, want ErrUnknownKeyVersion", err)
+	}
+	if _, err := NewKeyring(map[int][]byte{1: []byte("short")}, 0); err == nil {
+		t.Error("expected error for short key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := testKey(t)
+	parsed, err := ParseKey(base64.StdEncoding.EncodeToString(key))
+	if err != nil || !bytes.Equal(parsed, key) {
+		t.Errorf("ParseKey = %x, %v", parsed, err)
+	}
+	if _, err := ParseKey(base64.StdEncoding.EncodeToString(key[:16])); err == nil {
+		t.Error("expected error for 16-byte key")
+	}
+}
+
+func TestKeyring_Rewrap(t *testing.T) {
+	v1, v2 := testKey(t), testKey(t)
+	old, _ := NewKeyring(map[int][]byte{1: v1}, 0)
+	dataKey, env, err := old.NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+
+	rotated, _ := NewKeyring(map[int][]byte{1: v1, 2: v2}, 0)
+	if !rotated.Stale(env) {
+		t.Fatal("envelope on version 1 should be stale")
+	}
+	rewrapped, err := rotated.Rewrap(env)
+	if err != nil {
+		t.Fatalf("Rewrap failed: %v", err)
+	}
+	if rewrapped.KeyVersion != 2 || rotated.Stale(rewrapped) || env.KeyVersion != 1 {
+		t.Errorf("rewrapped = %+v, original = %+v", rewrapped, env)
+	}
+
+	// The data key is unchanged, and readable without the old version
+	retired, _ := NewKeyring(map[int][]byte{2: v2}, 0)
+	got, err := retired.DataKey(rewrapped)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Errorf("DataKey = %x, %v", got, err)
+	}
+	if _, err := retired.DataKey(env); !errors.Is(err, ErrUnknownKeyVersion) {
+		t.Errorf("err = %v, want ErrUnknownKeyVersion", err)
+	}
+
+	// A wrapped key cannot be passed off as another version
+	forged := *rewrapped
+	forged.KeyVersion = 1
+	if _, err := rotated.DataKey(&forged); err == nil {
+		t.Error("expected error unwrapping with the wrong version")
+	}
+}
+
+func TestKeyring_DecryptAtOffset(t *testing.T) {
+	k, _ := NewKeyring(map[int][]byte{1: testKey(t)}, 0)
+	dataKey, env, err := k.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+	sealed := append([]byte(nil), plain...)
+	stream, err := NewStream(dataKey, env.IV, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.XORKeyStream(sealed, sealed)
+
+	for _, r := range []struct{ start, end int }{{0, 1000}, {5, 21}, {16, 32}, {333, 1000}, {999, 1000}} {
+		part := append([]byte(nil), sealed[r.start:r.end]...)
+		if err := k.Decrypt(env, part, int64(r.start)); err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if !bytes.Equal(part, plain[r.start:r.end]) {
+			t.Errorf("range %d-%d decrypted incorrectly", r.start, r.end)
+		}
+	}
+}
+
+func TestNewStream_CounterCarry(t *testing.T) {
+	key := testKey(t)
+	iv := bytes.Repeat([]byte{0xff}, 16)
+
+	// Encrypting from 0 and jumping to block 2 must agree across the
+	// counter wrapping around
+	full := make([]byte, 48)
+	stream, _ := NewStream(key, iv, 0)
+	stream.XORKeyStream(full, full)
+
+	tail := make([]byte, 16)
+	stream, _ = NewStream(key, iv, 32)
+	stream.XORKeyStream(tail, tail)
+	if !bytes.Equal(tail, full[32:]) {
+		t.Error("keystream at offset 32 does not match")
+	}
+}
+
+func TestNilKeyring(t *testing.T) {
+	var k *Keyring
+	if _, _, err := k.NewDataKey(); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("err = %v, want ErrNotConfigured", err)
+	}
+	if err := k.Decrypt(&Envelope{Algorithm: AlgorithmAES256}, nil, 0); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("err = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestKeyring_SealSecret(t *testing.T) {
+	k, _ := NewKeyring(map[int][]byte{1: testKey(t)}, 0)
+	sealed, err := k.SealSecret("wJalrXUtnFEMI/K7MDENG")
+	if err != nil {
+		t.Fatalf("SealSecret failed: %v", err)
+	}
+	if bytes.Contains(sealed.Ciphertext, []byte("wJalrXUtnFEMI")) {
+		t.Error("sealed secret contains the plaintext")
+	}
+	secret, err := k.OpenSecret(sealed)
+	if err != nil || secret != "wJalrXUtnFEMI/K7MDENG" {
+		t.Errorf("OpenSecret = %q, %v", secret, err)
+	}
+
+	// A sealed secret is not accepted as a wrapped data key
+	env := &Envelope{Algorithm: AlgorithmAES256, KeyVersion: 1, WrappedKey: sealed.Ciphertext}
+	if _, err := k.DataKey(env); err == nil {
+		t.Error("expected error unwrapping a sealed secret as a data key")
+	}
+
+	k.SetUnavailable(errors.New("vault sealed"))
+	if _, err := k.SealSecret("s"); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("err = %v, want ErrKeysUnavailable", err)
+	}
+}
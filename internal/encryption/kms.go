@@ -0,0 +1,168 @@
+package encryption
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// KeySource fetches the master key versions from a key management service
+type KeySource interface {
+	// Name identifies the source in logs and status
+	Name() string
+	// FetchKeys returns the master keys by version, and the version to
+	// make active (0 selects the highest)
+	FetchKeys(ctx context.Context) (map[int][]byte, int, error)
+}
+
+// KMSStatus is the state of the connection to a key source
+type KMSStatus struct {
+	Source        string     `json:"source"`
+	Reachable     bool       `json:"reachable"`
+	ActiveVersion int        `json:"active_version"`
+	LastRefresh   *time.Time `json:"last_refresh,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// KeyManager keeps a keyring filled from a key source. Keys are cached in
+// memory and refreshed periodically, which picks up new versions and
+// renews credentials with the source. While the source is unreachable,
+// cached keys keep existing objects readable but new objects are refused.
+type KeyManager struct {
+	source   KeySource
+	interval time.Duration
+	active   int // Overrides the active version chosen by the source when non-zero
+	timeout  time.Duration
+
+	keyring *Keyring
+
+	mu     sync.RWMutex
+	status KMSStatus
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewKeyManager fetches the keys from source, failing if it is
+// unreachable, and returns a manager refreshing them every interval.
+// A non-zero active overrides the active version chosen by the source.
+func NewKeyManager(ctx context.Context, source KeySource, interval time.Duration, active int) (*KeyManager, error) {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	m := &KeyManager{
+		source:   source,
+		interval: interval,
+		active:   active,
+		timeout:  30 * time.Second,
+		status:   KMSStatus{Source: source.Name()},
+	}
+
+	keys, sourceActive, err := source.FetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.active != 0 {
+		sourceActive = m.active
+	}
+	keyring, err := NewKeyring(keys, sourceActive)
+	if err != nil {
+		return nil, err
+	}
+	m.keyring = keyring
+
+	now := time.Now()
+	m.status.Reachable = true
+	m.status.ActiveVersion = keyring.ActiveVersion()
+	m.status.LastRefresh = &now
+	return m, nil
+}
+
+// Keyring returns the keyring kept up to date by the manager
+func (m *KeyManager) Keyring() *Keyring {
+	return m.keyring
+}
+
+// Status returns the state of the connection to the key source
+func (m *KeyManager) Status() KMSStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Start refreshes the keys periodically
+func (m *KeyManager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Refresh(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the keys
+func (m *KeyManager) Stop() {
+	if m.cancel == nil {
+		return
+	}
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Refresh fetches the keys from the source once. On failure the cached
+// keys are kept and new data keys are refused until a refresh succeeds.
+func (m *KeyManager) Refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	keys, active, err := m.source.FetchKeys(ctx)
+	if err == nil {
+		if m.active != 0 {
+			active = m.active
+		}
+		err = m.keyring.Update(keys, active)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		if m.status.Reachable {
+			monitoring.Log.Error("Key management service unreachable, refusing new objects",
+				zap.String("source", m.source.Name()),
+				zap.Error(err))
+		}
+		m.keyring.SetUnavailable(err)
+		m.status.Reachable = false
+		m.status.LastError = err.Error()
+		return
+	}
+
+	if !m.status.Reachable {
+		monitoring.Log.Info("Key management service reachable again",
+			zap.String("source", m.source.Name()))
+	}
+	now := time.Now()
+	m.status.Reachable = true
+	m.status.ActiveVersion = m.keyring.ActiveVersion()
+	m.status.LastRefresh = &now
+	m.status.LastError = ""
+}
@@ -0,0 +1,217 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func TestSignV4_Vanilla(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signV4(req, nil, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC),
+		"AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "us-east-1", "service")
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %s\nwant %s", got, want)
+	}
+}
+
+func TestAWSKMSSource_FetchKeys(t *testing.T) {
+	v1, v2 := testKey(t), testKey(t)
+	plaintexts := map[string][]byte{"blob-1": v1, "blob-2": v2}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" {
+			t.Errorf("X-Amz-Target = %s", r.Header.Get("X-Amz-Target"))
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/kms/aws4_request") {
+			t.Errorf("Authorization = %s", auth)
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("missing session token")
+		}
+
+		var in struct{ CiphertextBlob string }
+		json.NewDecoder(r.Body).Decode(&in)
+		key, ok := plaintexts[in.CiphertextBlob]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"InvalidCiphertextException"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Plaintext": base64.StdEncoding.EncodeToString(key)})
+	}))
+	defer srv.Close()
+
+	source := &AWSKMSSource{
+		Region:          "eu-west-1",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		Ciphertexts:     map[int]string{1: "blob-1", 2: "blob-2"},
+	}
+	keys, active, err := source.FetchKeys(context.Background())
+	if err != nil {
+		t.Fatalf("FetchKeys failed: %v", err)
+	}
+	if len(keys) != 2 || string(keys[1]) != string(v1) || string(keys[2]) != string(v2) || active != 0 {
+		t.Errorf("keys = %v, active = %d", keys, active)
+	}
+
+	source.Ciphertexts[3] = "unknown"
+	if _, _, err := source.FetchKeys(context.Background()); err == nil || !strings.Contains(err.Error(), "InvalidCiphertextException") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// fakeVault serves a KV v2 secret with the given versions
+type fakeVault struct {
+	mu       sync.Mutex
+	versions map[int][]byte
+	current  int
+	renewed  int
+	down     bool
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/token/lookup-self":
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"renewable": true}})
+	case "/v1/auth/token/renew-self":
+		v.renewed++
+	case "/v1/kv/metadata/comio/master":
+		versions := map[string]interface{}{}
+		for version := range v.versions {
+			versions[strconv.Itoa(version)] = map[string]interface{}{"deletion_time": "", "destroyed": false}
+		}
+		versions["99"] = map[string]interface{}{"deletion_time": "", "destroyed": true}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"current_version": v.current,
+			"versions":        versions,
+		}})
+	case "/v1/kv/data/comio/master":
+		for version, key := range v.versions {
+			if r.URL.Query().Get("version") == strconv.Itoa(version) {
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+					"data": map[string]string{"key": base64.StdEncoding.EncodeToString(key)},
+				}})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultSource_FetchKeys(t *testing.T) {
+	vault := &fakeVault{versions: map[int][]byte{1: testKey(t), 2: testKey(t)}, current: 2}
+	srv := httptest.NewServer(vault)
+	defer srv.Close()
+
+	source := &VaultSource{Address: srv.URL, Token: "token", Mount: "kv", Path: "comio/master"}
+	keys, active, err := source.FetchKeys(context.Background())
+	if err != nil {
+		t.Fatalf("FetchKeys failed: %v", err)
+	}
+	if len(keys) != 2 || active != 2 {
+		t.Errorf("got %d keys, active %d", len(keys), active)
+	}
+	if vault.renewed != 1 {
+		t.Errorf("token renewed %d times, want 1", vault.renewed)
+	}
+
+	source.Token = "wrong"
+	if _, _, err := source.FetchKeys(context.Background()); err == nil {
+		t.Error("expected error with a bad token")
+	}
+}
+
+func TestKeyManager_DegradesWhenUnreachable(t *testing.T) {
+	vault := &fakeVault{versions: map[int][]byte{1: testKey(t)}, current: 1}
+	srv := httptest.NewServer(vault)
+	defer srv.Close()
+
+	source := &VaultSource{Address: srv.URL, Token: "token", Mount: "kv", Path: "comio/master"}
+	m, err := NewKeyManager(context.Background(), source, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("NewKeyManager failed: %v", err)
+	}
+	keyring := m.Keyring()
+	dataKey, env, err := keyring.NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+
+	vault.mu.Lock()
+	vault.down = true
+	vault.mu.Unlock()
+	m.Refresh(context.Background())
+
+	if status := m.Status(); status.Reachable || status.LastError == "" {
+		t.Errorf("status = %+v", status)
+	}
+	if _, _, err := keyring.NewDataKey(); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("err = %v, want ErrKeysUnavailable", err)
+	}
+	// Cached keys still read existing objects
+	if got, err := keyring.DataKey(env); err != nil || string(got) != string(dataKey) {
+		t.Errorf("DataKey = %x, %v", got, err)
+	}
+
+	// Recovery picks up the new version written meanwhile
+	vault.mu.Lock()
+	vault.down = false
+	vault.versions[2] = testKey(t)
+	vault.current = 2
+	vault.mu.Unlock()
+	m.Refresh(context.Background())
+
+	if status := m.Status(); !status.Reachable || status.ActiveVersion != 2 {
+		t.Errorf("status = %+v", status)
+	}
+	if _, env, err := keyring.NewDataKey(); err != nil || env.KeyVersion != 2 {
+		t.Errorf("NewDataKey = %+v, %v", env, err)
+	}
+}
+
+func TestNewKeyManager_FailsWhenUnreachable(t *testing.T) {
+	srv := httptest.NewServer(&fakeVault{down: true})
+	defer srv.Close()
+
+	source := &VaultSource{Address: srv.URL, Token: "token", Mount: "kv", Path: "comio/master"}
+	if _, err := NewKeyManager(context.Background(), source, time.Hour, 0); err == nil {
+		t.Error("expected error when the source is unreachable at startup")
+	}
+}
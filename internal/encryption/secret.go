@@ -0,0 +1,64 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// SealedSecret is a credential encrypted with a master key version, kept
+// in metadata instead of the plaintext
+type SealedSecret struct {
+	KeyVersion int    `json:"key_version"`
+	Ciphertext []byte `json:"ciphertext"` // Nonce followed by the AES-GCM sealed secret
+}
+
+// SealSecret encrypts a secret, such as an access key's secret key, with
+// the active master key version. Secrets cannot be hashed because request
+// signatures are verified with the plaintext.
+func (k *Keyring) SealSecret(secret string) (*SealedSecret, error) {
+	if err := k.Available(); err != nil {
+		return nil, err
+	}
+	active := k.ActiveVersion()
+	gcm, err := k.aead(active)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return &SealedSecret{
+		KeyVersion: active,
+		Ciphertext: gcm.Seal(nonce, nonce, []byte(secret), secretLabel(active)),
+	}, nil
+}
+
+// OpenSecret decrypts a sealed secret
+func (k *Keyring) OpenSecret(sealed *SealedSecret) (string, error) {
+	if k == nil {
+		return "", ErrNotConfigured
+	}
+	gcm, err := k.aead(sealed.KeyVersion)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed.Ciphertext) < gcm.NonceSize() {
+		return "", errors.New("sealed secret is truncated")
+	}
+	nonce, ciphertext := sealed.Ciphertext[:gcm.NonceSize()], sealed.Ciphertext[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, ciphertext, secretLabel(sealed.KeyVersion))
+	if err != nil {
+		return "", fmt.Errorf("failed to open secret with master key version %d: %w", sealed.KeyVersion, err)
+	}
+	return string(secret), nil
+}
+
+// secretLabel keeps sealed secrets and wrapped data keys from being
+// swapped for one another
+func secretLabel(version int) []byte {
+	return []byte("comio-secret-v" + strconv.Itoa(version))
+}
@@ -0,0 +1,49 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// NewStream returns the AES-CTR keystream of an object positioned at
+// offset, so any byte range can be encrypted or decrypted on its own
+func NewStream(dataKey, iv []byte, offset int64) (cipher.Stream, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("IV must be %d bytes, got %d", aes.BlockSize, len(iv))
+	}
+
+	// Advance the 128-bit big-endian counter by the number of whole blocks
+	counter := make([]byte, aes.BlockSize)
+	hi := binary.BigEndian.Uint64(iv[:8])
+	lo, carry := bits.Add64(binary.BigEndian.Uint64(iv[8:]), uint64(offset/aes.BlockSize), 0)
+	binary.BigEndian.PutUint64(counter[:8], hi+carry)
+	binary.BigEndian.PutUint64(counter[8:], lo)
+
+	stream := cipher.NewCTR(block, counter)
+	if skip := offset % aes.BlockSize; skip > 0 {
+		discard := make([]byte, skip)
+		stream.XORKeyStream(discard, discard)
+	}
+	return stream, nil
+}
+
+// Decrypt decrypts data read at offset of an object in place
+func (k *Keyring) Decrypt(env *Envelope, data []byte, offset int64) error {
+	dataKey, err := k.DataKey(env)
+	if err != nil {
+		return err
+	}
+	stream, err := NewStream(dataKey, env.IV, offset)
+	if err != nil {
+		return err
+	}
+	stream.XORKeyStream(data, data)
+	return nil
+}
@@ -0,0 +1,141 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VaultSource reads master keys from a HashiCorp Vault KV version 2
+// secret. Every version of the secret is a master key version, so
+// rotating is writing a new version (vault kv put); the current version
+// becomes active. Renewable tokens are renewed on every fetch.
+type VaultSource struct {
+	Address string // e.g. https://vault.example.com:8200
+	Token   string
+	Mount   string // KV v2 mount, defaults to "secret"
+	Path    string // Secret path within the mount
+	Field   string // Secret field holding the base64 key, defaults to "key"
+	Client  *http.Client
+}
+
+// Name implements KeySource
+func (v *VaultSource) Name() string {
+	return "vault"
+}
+
+// FetchKeys implements KeySource
+func (v *VaultSource) FetchKeys(ctx context.Context) (map[int][]byte, int, error) {
+	v.renewToken(ctx)
+
+	var meta struct {
+		Data struct {
+			CurrentVersion int `json:"current_version"`
+			Versions       map[string]struct {
+				DeletionTime string `json:"deletion_time"`
+				Destroyed    bool   `json:"destroyed"`
+			} `json:"versions"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, v.url("metadata", ""), &meta); err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(map[int][]byte)
+	for name, info := range meta.Data.Versions {
+		if info.Destroyed || info.DeletionTime != "" {
+			continue
+		}
+		version, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid vault secret version %q", name)
+		}
+
+		var secret struct {
+			Data struct {
+				Data map[string]string `json:"data"`
+			} `json:"data"`
+		}
+		if err := v.do(ctx, http.MethodGet, v.url("data", "?version="+name), &secret); err != nil {
+			return nil, 0, err
+		}
+		key, err := ParseKey(secret.Data.Data[v.field()])
+		if err != nil {
+			return nil, 0, fmt.Errorf("vault secret version %d: %w", version, err)
+		}
+		keys[version] = key
+	}
+	if len(keys) == 0 {
+		return nil, 0, fmt.Errorf("vault secret %s/%s has no live versions", v.mount(), v.Path)
+	}
+	return keys, meta.Data.CurrentVersion, nil
+}
+
+// renewToken extends the token's lease if it is renewable. Failures are
+// left to surface through the reads that follow.
+func (v *VaultSource) renewToken(ctx context.Context) {
+	var lookup struct {
+		Data struct {
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, strings.TrimSuffix(v.Address, "/")+"/v1/auth/token/lookup-self", &lookup); err != nil {
+		return
+	}
+	if lookup.Data.Renewable {
+		v.do(ctx, http.MethodPost, strings.TrimSuffix(v.Address, "/")+"/v1/auth/token/renew-self", nil)
+	}
+}
+
+func (v *VaultSource) mount() string {
+	if v.Mount == "" {
+		return "secret"
+	}
+	return strings.Trim(v.Mount, "/")
+}
+
+func (v *VaultSource) field() string {
+	if v.Field == "" {
+		return "key"
+	}
+	return v.Field
+}
+
+func (v *VaultSource) url(kind, query string) string {
+	return fmt.Sprintf("%s/v1/%s/%s/%s%s", strings.TrimSuffix(v.Address, "/"), v.mount(), kind, strings.Trim(v.Path, "/"), query)
+}
+
+func (v *VaultSource) do(ctx context.Context, method, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid vault response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+// Package export writes the objects of a bucket, as they were at one
+// moment, to a tar archive with a signed manifest of their checksums and
+// metadata, for handing over to third parties such as in legal discovery.
+package export
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/attestation"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+)
+
+// Export states
+const (
+	StatusRunning   = "running" // Or interrupted, and resumable
+	StatusCompleted = "completed"
+)
+
+// Files of an export's directory
+const (
+	ArchiveFile   = "archive.tar"
+	ManifestFile  = "manifest.json"
+	SignatureFile = "manifest.json.sig"
+	stateFile     = "state.json"
+)
+
+const (
+	// checkpointInterval is how many objects are archived between saves of
+	// an export's progress; a resumed export redoes at most this many
+	checkpointInterval = 100
+	// copyChunkSize is how much object data is read, and paced, at a time
+	copyChunkSize = 1024 * 1024
+)
+
+var (
+	// ErrNotFound is returned for unknown export IDs
+	ErrNotFound = errors.New("export not found")
+	// ErrCompleted is returned when resuming an export that is complete
+	ErrCompleted = errors.New("export already completed")
+	// ErrObjectChanged is returned when an object of a resumed export was
+	// deleted or replaced since it began, so the archive could no longer
+	// show the bucket at one moment
+	ErrObjectChanged = errors.New("object changed since the export began")
+)
+
+// Entry describes one object of an export
+type Entry struct {
+	Key         string             `json:"key"`
+	VersionID   string             `json:"version_id"`
+	Size        int64              `json:"size"`
+	ETag        string             `json:"etag"`
+	ContentType string             `json:"content_type,omitempty"`
+	Metadata    map[string]string  `json:"metadata,omitempty"`
+	ModifiedAt  time.Time          `json:"modified_at"`
+	Checksum    integrity.Checksum `json:"checksum"`         // As stored
+	SHA256      string             `json:"sha256,omitempty"` // Of the data written to the archive
+	Path        string             `json:"path,omitempty"`   // Of the data in the archive
+}
+
+// Archive describes the archive file of an export
+type Archive struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest lists what an export holds. Objects are as they were at
+// SnapshotAt, whatever happened to them while the export ran.
+type Manifest struct {
+	ID          string    `json:"id"`
+	Bucket      string    `json:"bucket"`
+	Prefix      string    `json:"prefix,omitempty"`
+	SnapshotAt  time.Time `json:"snapshot_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Archive     Archive   `json:"archive"`
+	Objects     []Entry   `json:"objects"`
+}
+
+// Signature is the content of an export's signature file, the server's
+// signature of the exact bytes of its manifest file
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"` // Base64
+}
+
+// Export is the progress of an export, saved in its directory as it runs
+// so an interrupted export can resume where it was
+type Export struct {
+	ID          string    `json:"id"`
+	Bucket      string    `json:"bucket"`
+	Prefix      string    `json:"prefix,omitempty"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	Objects     int       `json:"objects"`      // In the manifest, once the snapshot is taken
+	Done        int       `json:"done"`         // Objects written to the archive
+	ArchiveSize int64     `json:"archive_size"` // Bytes of the archive holding them
+
+	manifest Manifest
+}
+
+// state is the saved form of an export, with the manifest being built
+type state struct {
+	*Export
+	Manifest *Manifest `json:"manifest"`
+}
+
+// Exporter writes exports into a directory, one subdirectory each
+type Exporter struct {
+	objects *object.Service
+	signer  *attestation.Signer
+	dir     string
+}
+
+// NewExporter creates an exporter of objects into dir, signing manifests
+// with signer
+func NewExporter(objects *object.Service, signer *attestation.Signer, dir string) *Exporter {
+	return &Exporter{objects: objects, signer: signer, dir: dir}
+}
+
+// Create records a new export of the objects of bucket with the given key
+// prefix. Nothing is written until Run.
+func (e *Exporter) Create(bucket, prefix string) (*Export, error) {
+	exp := &Export{
+		ID:        uuid.New().String(),
+		Bucket:    bucket,
+		Prefix:    prefix,
+		Status:    StatusRunning,
+		CreatedAt: time.Now().UTC(),
+	}
+	exp.manifest = Manifest{ID: exp.ID, Bucket: bucket, Prefix: prefix}
+	if err := os.MkdirAll(filepath.Join(e.dir, exp.ID), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	if err := e.save(exp); err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+// Get loads an export
+func (e *Exporter) Get(id string) (*Export, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(e.dir, id, stateFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export state: %w", err)
+	}
+
+	exp := &Export{}
+	st := state{Export: exp, Manifest: &exp.manifest}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("invalid export state %s: %w", id, err)
+	}
+	return exp, nil
+}
+
+// List returns every export, newest first
+func (e *Exporter) List() ([]*Export, error) {
+	entries, err := os.ReadDir(e.dir)
+	if os.IsNotExist(err) {
+		return []*Export{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exports := []*Export{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		exp, err := e.Get(entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, exp)
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].CreatedAt.After(exports[j].CreatedAt) })
+	return exports, nil
+}
+
+// File returns the path of one of the files of a completed export: the
+// archive, the manifest or its signature
+func (e *Exporter) File(id, name string) (string, error) {
+	if !slices.Contains([]string{ArchiveFile, ManifestFile, SignatureFile}, name) {
+		return "", ErrNotFound
+	}
+	exp, err := e.Get(id)
+	if err != nil {
+		return "", err
+	}
+	if exp.Status != StatusCompleted {
+		return "", fmt.Errorf("%w: export %s is still running", ErrNotFound, id)
+	}
+	return filepath.Join(e.dir, id, name), nil
+}
+
+// Run writes an export's archive, manifest and signature. A new export
+// snapshots the bucket first; an interrupted one continues from its last
+// checkpoint with the versions it listed, failing with ErrObjectChanged
+// if one has gone since. Reads are paced to bytesPerSecond, 0 leaving
+// them unlimited, and progress is called after each object.
+func (e *Exporter) Run(ctx context.Context, exp *Export, bytesPerSecond int64, progress func(*Export)) error {
+	if exp.Status == StatusCompleted {
+		return ErrCompleted
+	}
+
+	// Objects of a snapshot taken by this run are read from it; those of
+	// one taken before a restart by version
+	var sources []*object.Object
+	var snap *object.Snapshot
+	if exp.manifest.SnapshotAt.IsZero() {
+		var err error
+		if snap, err = e.objects.Snapshot(ctx, exp.Bucket); err != nil {
+			return err
+		}
+		defer snap.Release()
+
+		exp.manifest.SnapshotAt = snap.CreatedAt.UTC()
+		exp.manifest.Objects = []Entry{}
+		for _, obj := range snap.Objects {
+			if !strings.HasPrefix(obj.Key, exp.Prefix) {
+				continue
+			}
+			sources = append(sources, obj)
+			exp.manifest.Objects = append(exp.manifest.Objects, Entry{
+				Key:         obj.Key,
+				VersionID:   obj.VersionID,
+				Size:        obj.Size,
+				ETag:        obj.ETag,
+				ContentType: obj.ContentType,
+				Metadata:    obj.Metadata,
+				ModifiedAt:  obj.ModifiedAt.UTC(),
+				Checksum:    obj.Checksum,
+			})
+		}
+		exp.Objects = len(exp.manifest.Objects)
+		exp.Done, exp.ArchiveSize = 0, 0
+		if err := e.save(exp); err != nil {
+			return err
+		}
+	}
+
+	monitoring.Log.Info("Exporting bucket",
+		zap.String("export_id", exp.ID),
+		zap.String("bucket", exp.Bucket),
+		zap.String("prefix", exp.Prefix),
+		zap.Int("objects", exp.Objects),
+		zap.Int("done", exp.Done))
+
+	archivePath := filepath.Join(e.dir, exp.ID, ArchiveFile)
+	f, err := os.OpenFile(archivePath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open export archive: %w", err)
+	}
+	defer f.Close()
+	// Entries written after the last checkpoint are written again
+	if err := f.Truncate(exp.ArchiveSize); err != nil {
+		return err
+	}
+	if _, err := f.Seek(exp.ArchiveSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(f)
+	pace := &throttle{rate: bytesPerSecond, start: time.Now()}
+	for i := exp.Done; i < len(exp.manifest.Objects); i++ {
+		entry := &exp.manifest.Objects[i]
+		var source *object.Object
+		if sources != nil {
+			source = sources[i]
+		}
+		if err := e.archive(ctx, tw, snap, source, exp.Bucket, entry, pace); err != nil {
+			return fmt.Errorf("failed to export %s: %w", entry.Key, err)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		exp.Done = i + 1
+		if exp.ArchiveSize, err = f.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+		if exp.Done%checkpointInterval == 0 {
+			if err := e.checkpoint(f, exp); err != nil {
+				return err
+			}
+		}
+		if progress != nil {
+			progress(exp)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return e.complete(exp, archivePath)
+}
+
+// archive writes one object to the archive, recording the SHA-256 of the
+// data written and its path
+func (e *Exporter) archive(ctx context.Context, tw *tar.Writer, snap *object.Snapshot, source *object.Object, bucket string, entry *Entry, pace *throttle) error {
+	var data io.Reader
+	if source != nil {
+		data = io.NewSectionReader(snapshotObject{snap, source}, 0, source.Size)
+	} else {
+		obj, rc, err := e.objects.GetObject(ctx, bucket, entry.Key, &entry.VersionID)
+		if errors.Is(err, object.ErrObjectNotFound) || errors.Is(err, object.ErrDeleteMarker) {
+			return ErrObjectChanged
+		}
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if obj.ETag != entry.ETag || obj.Size != entry.Size {
+			return ErrObjectChanged
+		}
+		data = rc
+	}
+
+	entry.Path = path.Join("objects", entry.Key)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Path,
+		Size:     entry.Size,
+		Mode:     0o644,
+		ModTime:  entry.ModifiedAt,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	w := io.MultiWriter(tw, h)
+	buf := make([]byte, copyChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := data.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if err := pace.wait(ctx, int64(n)); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// checkpoint saves an export's progress once the archive it refers to is
+// on disk
+func (e *Exporter) checkpoint(f *os.File, exp *Export) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return e.save(exp)
+}
+
+// complete writes the manifest of an export whose archive is written, and
+// its signature
+func (e *Exporter) complete(exp *Export, archivePath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to hash export archive: %w", err)
+	}
+
+	exp.manifest.Archive = Archive{Name: ArchiveFile, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	exp.manifest.CompletedAt = time.Now().UTC()
+	manifest, err := json.MarshalIndent(exp.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	signature, err := json.MarshalIndent(Signature{
+		Algorithm: attestation.Algorithm,
+		KeyID:     e.signer.KeyID(),
+		Signature: e.signer.SignBytes(manifest),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(e.dir, exp.ID)
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), manifest, 0o644); err != nil {
+		return fmt.Errorf("failed to write export manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, SignatureFile), signature, 0o644); err != nil {
+		return fmt.Errorf("failed to write export signature: %w", err)
+	}
+
+	exp.Status = StatusCompleted
+	exp.ArchiveSize = size
+	monitoring.Log.Info("Export completed",
+		zap.String("export_id", exp.ID),
+		zap.String("bucket", exp.Bucket),
+		zap.Int("objects", exp.Objects),
+		zap.Int64("archive_size", size))
+	return e.save(exp)
+}
+
+// save writes an export's state, replacing the previous one only once it
+// is complete on disk
+func (e *Exporter) save(exp *Export) error {
+	data, err := json.Marshal(state{Export: exp, Manifest: &exp.manifest})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(e.dir, exp.ID, stateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save export state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save export state: %w", err)
+	}
+	return nil
+}
+
+// snapshotObject reads an object of a snapshot as an io.ReaderAt
+type snapshotObject struct {
+	snap *object.Snapshot
+	obj  *object.Object
+}
+
+func (s snapshotObject) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.snap.ReadAt(s.obj, p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// throttle paces reads to a byte rate
+type throttle struct {
+	rate  int64 // Bytes per second; 0 is unlimited
+	start time.Time
+	read  int64
+}
+
+// wait accounts for n bytes read and sleeps until the rate allows more
+func (t *throttle) wait(ctx context.Context, n int64) error {
+	if t.rate <= 0 {
+		return nil
+	}
+	t.read += n
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/attestation"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func newTestExporter(t *testing.T) (*Exporter, *object.Service) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.dat")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := storage.NewSimpleEngine(path, 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { engine.Close() })
+
+	signer, err := attestation.GenerateSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := object.NewService(object.NewMemoryRepository(), engine)
+	return NewExporter(objects, signer, t.TempDir()), objects
+}
+
+func putObject(t *testing.T, objects *object.Service, key, data string) {
+	t.Helper()
+	if _, err := objects.PutObject(context.Background(), "cases", key, strings.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExport_Run(t *testing.T) {
+	exporter, objects := newTestExporter(t)
+	ctx := context.Background()
+	putObject(t, objects, "case-17/mail.eml", "From: a\n\nhello")
+	putObject(t, objects, "case-17/notes.txt", "notes")
+	putObject(t, objects, "case-18/other.txt", "not exported")
+
+	exp, err := exporter.Create("cases", "case-17/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Run(ctx, exp, 0, nil); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if exp.Status != StatusCompleted || exp.Objects != 2 || exp.Done != 2 {
+		t.Errorf("export = %+v, want 2 objects completed", exp)
+	}
+
+	// The manifest is signed as written
+	manifestPath, err := exporter.File(exp.ID, ManifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestData, _ := os.ReadFile(manifestPath)
+	sigData, _ := os.ReadFile(filepath.Join(filepath.Dir(manifestPath), SignatureFile))
+	var sig Signature
+	if err := json.Unmarshal(sigData, &sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := attestation.VerifyBytes(exporter.signer.PublicKey(), manifestData, sig.Signature); err != nil {
+		t.Errorf("manifest signature: %v", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	archive, _ := os.ReadFile(filepath.Join(filepath.Dir(manifestPath), ArchiveFile))
+	if sum := sha256.Sum256(archive); manifest.Archive.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Error("manifest does not hold the archive's SHA-256")
+	}
+
+	// Every object of the manifest is in the archive with its checksum
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for _, entry := range manifest.Objects {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("archive ends before %s: %v", entry.Key, err)
+		}
+		data, _ := io.ReadAll(tr)
+		sum := sha256.Sum256(data)
+		if hdr.Name != entry.Path || entry.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("archive entry %s does not match manifest entry %+v", hdr.Name, entry)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("archive holds more than the manifest lists: %v", err)
+	}
+
+	if err := exporter.Run(ctx, exp, 0, nil); !errors.Is(err, ErrCompleted) {
+		t.Errorf("Run() of a completed export error = %v, want ErrCompleted", err)
+	}
+}
+
+func TestExport_Resume(t *testing.T) {
+	exporter, objects := newTestExporter(t)
+	putObject(t, objects, "a.txt", "first")
+	putObject(t, objects, "b.txt", "second")
+
+	exp, err := exporter.Create("cases", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	err = exporter.Run(ctx, exp, 0, func(*Export) { cancel() })
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want the cancellation", err)
+	}
+
+	// Objects written after the snapshot are not part of the export
+	putObject(t, objects, "c.txt", "third")
+
+	exp, err = exporter.Get(exp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Run(context.Background(), exp, 0, nil); err != nil {
+		t.Fatalf("resumed Run() error = %v", err)
+	}
+	if exp.Objects != 2 || exp.Status != StatusCompleted {
+		t.Errorf("resumed export = %+v, want the 2 objects of the snapshot", exp)
+	}
+
+	// A resumed export cannot show the snapshot once an object of it is
+	// replaced
+	exp, _ = exporter.Create("cases", "")
+	ctx, cancel = context.WithCancel(context.Background())
+	exporter.Run(ctx, exp, 0, func(*Export) { cancel() })
+	putObject(t, objects, "b.txt", "replaced")
+
+	exp, _ = exporter.Get(exp.ID)
+	if err := exporter.Run(context.Background(), exp, 0, nil); !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("resumed Run() error = %v, want ErrObjectChanged", err)
+	}
+}
@@ -0,0 +1,610 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// maxDepth bounds how deeply selections may nest, so a query cannot make
+// the server walk unbounded trees
+const maxDepth = 12
+
+// Request is a GraphQL request, as POSTed in JSON
+type Request struct {
+	Query         string         `json:"query"`
+	Variables     map[string]any `json:"variables,omitempty"`
+	OperationName string         `json:"operationName,omitempty"`
+}
+
+// Response is the result of a request. Data is absent when the request
+// could not be executed at all, and null when a non-null root field failed.
+type Response struct {
+	Data   any      `json:"data,omitempty"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// Error is an error of a request, located in the query and, for fields
+// that failed to resolve, in the response
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func requestError(err error) *Response {
+	var gqlErr *Error
+	if !errors.As(err, &gqlErr) {
+		gqlErr = &Error{Message: err.Error()}
+	}
+	return &Response{Errors: []*Error{gqlErr}}
+}
+
+// Execute parses, validates and runs a query. Fields are resolved one at a
+// time, in the order they are selected.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return requestError(err)
+	}
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return requestError(err)
+	}
+	if op.kind != "query" {
+		return requestError(&Error{Message: fmt.Sprintf("Only queries are supported, not %ss.", op.kind), Locations: []Location{op.loc}})
+	}
+
+	v := &validator{schema: s, doc: doc, args: make(map[*field]map[string]any), defined: make(map[string]bool)}
+	v.variables(op, req.Variables)
+	if len(v.errors) == 0 {
+		v.directives(op.directives)
+		v.selections(s.query, op.selections, 1, make(map[string]bool))
+	}
+	if len(v.errors) > 0 {
+		return &Response{Errors: v.errors}
+	}
+
+	e := &executor{ctx: ctx, doc: doc, args: v.args, vars: v.vars}
+	data, st := e.selectionSet(s.query, nil, op.selections, nil)
+	resp := &Response{Data: data, Errors: e.errors}
+	if st != resolved {
+		resp.Data = json.RawMessage("null")
+	}
+	return resp
+}
+
+// operation picks the operation to run: the one named, or the only one
+func (d *document) operation(name string) (*operation, error) {
+	if name == "" {
+		if len(d.operations) > 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations."}
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
+
+// validator checks a query against the schema before it runs, coercing
+// the variables and the arguments of every field on the way
+type validator struct {
+	schema  *Schema
+	doc     *document
+	vars    map[string]any
+	defined map[string]bool
+	args    map[*field]map[string]any
+	errors  []*Error
+}
+
+func (v *validator) errorf(loc Location, format string, args ...any) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}})
+}
+
+// variables checks the values of an operation's variables
+func (v *validator) variables(op *operation, values map[string]any) {
+	v.vars = make(map[string]any)
+	for _, def := range op.variables {
+		if v.defined[def.name] {
+			v.errorf(def.loc, "There can be only one variable named \"$%s\".", def.name)
+			continue
+		}
+		v.defined[def.name] = true
+
+		t, err := v.schema.inputType(def.typ)
+		if err != nil {
+			v.errorf(def.loc, "Variable \"$%s\" %s.", def.name, err)
+			continue
+		}
+		value, given := values[def.name]
+		if !given {
+			if def.hasDefault {
+				value = def.def
+			} else if def.typ.nonNull {
+				v.errorf(def.loc, "Variable \"$%s\" of required type %q was not provided.", def.name, def.typ)
+				continue
+			} else {
+				continue
+			}
+		}
+		// Values are checked against the variable's type here, and coerced
+		// to the type of each argument they are used for
+		if _, err := coerceInput(t, value); err != nil {
+			v.errorf(def.loc, "Variable \"$%s\" got invalid value: %s.", def.name, err)
+			continue
+		}
+		v.vars[def.name] = value
+	}
+}
+
+// inputType resolves a type named in a query to an input type
+func (s *Schema) inputType(ref *typeRef) (Type, error) {
+	var t Type
+	if ref.elem != nil {
+		elem, err := s.inputType(ref.elem)
+		if err != nil {
+			return nil, err
+		}
+		t = &List{Of: elem}
+	} else {
+		named, ok := s.types[ref.name]
+		if !ok {
+			return nil, fmt.Errorf("has unknown type %q", ref.name)
+		}
+		if _, ok := named.(*Scalar); !ok {
+			return nil, fmt.Errorf("cannot be non-input type %q", ref.name)
+		}
+		t = named
+	}
+	if ref.nonNull {
+		t = &NonNull{Of: t}
+	}
+	return t, nil
+}
+
+func (v *validator) selections(t *Object, selections []selection, depth int, fragments map[string]bool) {
+	if depth > maxDepth {
+		v.errorf(selections[0].location(), "Query is nested deeper than %d levels.", maxDepth)
+		return
+	}
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			v.directives(sel.directives)
+			v.field(t, sel, depth, fragments)
+		case *fragmentSpread:
+			v.directives(sel.directives)
+			frag, ok := v.doc.fragments[sel.name]
+			if !ok {
+				v.errorf(sel.loc, "Unknown fragment %q.", sel.name)
+				continue
+			}
+			if fragments[sel.name] {
+				v.errorf(sel.loc, "Cannot spread fragment %q within itself.", sel.name)
+				continue
+			}
+			v.directives(frag.directives)
+			if v.typeCondition(t, frag.typeCondition, sel.loc) {
+				fragments[sel.name] = true
+				v.selections(t, frag.selections, depth, fragments)
+				delete(fragments, sel.name)
+			}
+		case *inlineFragment:
+			v.directives(sel.directives)
+			if sel.typeCondition == "" || v.typeCondition(t, sel.typeCondition, sel.loc) {
+				v.selections(t, sel.selections, depth, fragments)
+			}
+		}
+	}
+}
+
+// typeCondition reports whether a fragment on a type applies to objects
+// of type t. Without interfaces or unions, it must be t itself.
+func (v *validator) typeCondition(t *Object, name string, loc Location) bool {
+	cond, ok := v.schema.types[name]
+	if !ok {
+		v.errorf(loc, "Unknown type %q.", name)
+		return false
+	}
+	if cond != t {
+		v.errorf(loc, "Fragment cannot be spread here as objects of type %q can never be of type %q.", t.Name, name)
+		return false
+	}
+	return true
+}
+
+func (v *validator) field(t *Object, f *field, depth int, fragments map[string]bool) {
+	if f.name == "__typename" {
+		if len(f.args) > 0 || len(f.selections) > 0 {
+			v.errorf(f.loc, "Field \"__typename\" takes no arguments or subfields.")
+		}
+		return
+	}
+	def := t.field(f.name)
+	if def == nil {
+		v.errorf(f.loc, "Cannot query field %q on type %q.", f.name, t.Name)
+		return
+	}
+
+	args := make(map[string]any)
+	for _, arg := range f.args {
+		a := def.arg(arg.name)
+		if a == nil {
+			v.errorf(arg.loc, "Unknown argument %q on field \"%s.%s\".", arg.name, t.Name, f.name)
+			continue
+		}
+		if _, ok := args[arg.name]; ok {
+			v.errorf(arg.loc, "There can be only one argument named %q.", arg.name)
+			continue
+		}
+		value, ok, err := v.substitute(arg.value)
+		if err != nil {
+			v.errorf(arg.loc, "%s", err)
+			continue
+		}
+		if !ok {
+			continue // An unset variable leaves the argument unset
+		}
+		coerced, err := coerceInput(a.Type, value)
+		if err != nil {
+			v.errorf(arg.loc, "Argument %q has invalid value: %s.", arg.name, err)
+			continue
+		}
+		args[arg.name] = coerced
+	}
+	for _, a := range def.Args {
+		if _, ok := args[a.Name]; ok {
+			continue
+		}
+		if a.Default != nil {
+			args[a.Name] = a.Default
+		} else if _, ok := a.Type.(*NonNull); ok {
+			v.errorf(f.loc, "Field %q argument %q of type %q is required, but it was not provided.", f.name, a.Name, a.Type)
+		}
+	}
+	v.args[f] = args
+
+	switch named := namedType(def.Type).(type) {
+	case *Object:
+		if len(f.selections) == 0 {
+			v.errorf(f.loc, "Field %q of type %q must have a selection of subfields.", f.name, def.Type)
+			return
+		}
+		v.selections(named, f.selections, depth+1, fragments)
+	case *Scalar:
+		if len(f.selections) > 0 {
+			v.errorf(f.loc, "Field %q must not have a selection since type %q has no subfields.", f.name, def.Type)
+		}
+	}
+}
+
+// directives checks @skip and @include, the only directives supported
+func (v *validator) directives(directives []*directive) {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			v.errorf(d.loc, "Unknown directive \"@%s\".", d.name)
+			continue
+		}
+		if _, err := directiveCondition(d, v.vars); err != nil {
+			v.errorf(d.loc, "%s", err)
+		}
+	}
+}
+
+// directiveCondition returns the if argument of @skip or @include
+func directiveCondition(d *directive, vars map[string]any) (bool, error) {
+	if len(d.args) != 1 || d.args[0].name != "if" {
+		return false, fmt.Errorf("Directive \"@%s\" takes one argument \"if\".", d.name)
+	}
+	value := d.args[0].value
+	if name, ok := value.(variable); ok {
+		value = vars[string(name)]
+	}
+	cond, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("Directive \"@%s\" argument \"if\" must be a Boolean.", d.name)
+	}
+	return cond, nil
+}
+
+// substitute replaces the variables in a value with their values. A value
+// that is only an unset variable is reported as not ok.
+func (v *validator) substitute(value any) (any, bool, error) {
+	switch value := value.(type) {
+	case variable:
+		if !v.defined[string(value)] {
+			return nil, false, fmt.Errorf("Variable \"$%s\" is not defined.", value)
+		}
+		vv, ok := v.vars[string(value)]
+		return vv, ok, nil
+	case []any:
+		list := make([]any, len(value))
+		for i, item := range value {
+			vv, _, err := v.substitute(item)
+			if err != nil {
+				return nil, false, err
+			}
+			list[i] = vv
+		}
+		return list, true, nil
+	case map[string]any:
+		obj := make(map[string]any, len(value))
+		for name, item := range value {
+			vv, _, err := v.substitute(item)
+			if err != nil {
+				return nil, false, err
+			}
+			obj[name] = vv
+		}
+		return obj, true, nil
+	}
+	return value, true, nil
+}
+
+// coerceInput converts an argument or variable value to the values
+// resolvers receive. Single values are accepted for lists.
+func coerceInput(t Type, value any) (any, error) {
+	switch t := t.(type) {
+	case *NonNull:
+		if value == nil {
+			return nil, fmt.Errorf("expected a non-null %s", t.Of)
+		}
+		return coerceInput(t.Of, value)
+	case *List:
+		if value == nil {
+			return nil, nil
+		}
+		items, ok := value.([]any)
+		if !ok {
+			items = []any{value}
+		}
+		list := make([]any, len(items))
+		for i, item := range items {
+			coerced, err := coerceInput(t.Of, item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	case *Scalar:
+		if value == nil {
+			return nil, nil
+		}
+		return t.Parse(value)
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+func namedType(t Type) Type {
+	for {
+		switch wrapped := t.(type) {
+		case *NonNull:
+			t = wrapped.Of
+		case *List:
+			t = wrapped.Of
+		default:
+			return t
+		}
+	}
+}
+
+// status is how completing a value went. A field that failed is null, and
+// a null in a non-null position makes its parent null in turn.
+type status int
+
+const (
+	resolved  status = iota
+	nulled           // Null because of a reported error
+	propagate        // Null where null is not allowed; the parent is null
+)
+
+type executor struct {
+	ctx    context.Context
+	doc    *document
+	args   map[*field]map[string]any
+	vars   map[string]any
+	errors []*Error
+}
+
+func (e *executor) fail(err error, f *field, path []any) {
+	e.errors = append(e.errors, &Error{
+		Message:   err.Error(),
+		Locations: []Location{f.loc},
+		Path:      append([]any(nil), path...),
+	})
+}
+
+// collectedField is a response key and the fields selected under it
+type collectedField struct {
+	key    string
+	fields []*field
+}
+
+// collect gathers the fields selected on objects of type t, merging the
+// fields selected more than once under the same response key
+func (e *executor) collect(t *Object, selections []selection, visited map[string]bool, collected []collectedField) []collectedField {
+	for _, sel := range selections {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			key := sel.responseKey()
+			merged := false
+			for i := range collected {
+				if collected[i].key == key {
+					collected[i].fields = append(collected[i].fields, sel)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				collected = append(collected, collectedField{key: key, fields: []*field{sel}})
+			}
+		case *fragmentSpread:
+			frag := e.doc.fragments[sel.name]
+			if visited[sel.name] || !e.included(sel.directives) || !e.included(frag.directives) {
+				continue
+			}
+			visited[sel.name] = true
+			collected = e.collect(t, frag.selections, visited, collected)
+		case *inlineFragment:
+			if e.included(sel.directives) {
+				collected = e.collect(t, sel.selections, visited, collected)
+			}
+		}
+	}
+	return collected
+}
+
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		cond, _ := directiveCondition(d, e.vars)
+		if cond == (d.name == "skip") {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *executor) selectionSet(t *Object, source any, selections []selection, path []any) (any, status) {
+	result := &orderedObject{}
+	for _, cf := range e.collect(t, selections, make(map[string]bool), nil) {
+		value, st := e.resolveField(t, source, cf.fields, append(path, cf.key))
+		if st == propagate {
+			return nil, nulled
+		}
+		result.keys = append(result.keys, cf.key)
+		result.values = append(result.values, value)
+	}
+	return result, resolved
+}
+
+func (e *executor) resolveField(t *Object, source any, fields []*field, path []any) (any, status) {
+	f := fields[0]
+	if f.name == "__typename" {
+		return t.Name, resolved
+	}
+	def := t.field(f.name)
+
+	var value any
+	var err error
+	if def.Resolve != nil {
+		value, err = def.Resolve(ResolveParams{Context: e.ctx, Source: source, Args: e.args[f]})
+	} else if m, ok := source.(map[string]any); ok {
+		value = m[def.Name]
+	} else {
+		err = fmt.Errorf("no resolver for field %s.%s", t.Name, def.Name)
+	}
+	if err == nil {
+		err = e.ctx.Err()
+	}
+	if err != nil {
+		e.fail(err, f, path)
+		if _, ok := def.Type.(*NonNull); ok {
+			return nil, propagate
+		}
+		return nil, nulled
+	}
+	return e.complete(def.Type, fields, value, path)
+}
+
+// complete shapes a resolved value to its type, resolving the fields of
+// objects in it
+func (e *executor) complete(t Type, fields []*field, value any, path []any) (any, status) {
+	if nonNull, ok := t.(*NonNull); ok {
+		completed, st := e.complete(nonNull.Of, fields, value, path)
+		if st == resolved && completed == nil {
+			e.fail(fmt.Errorf("cannot return null for non-nullable field %s", fields[0].name), fields[0], path)
+		}
+		if st != resolved || completed == nil {
+			return nil, propagate
+		}
+		return completed, resolved
+	}
+	if isNull(value) {
+		return nil, resolved
+	}
+
+	switch t := t.(type) {
+	case *List:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fail(fmt.Errorf("expected a list for field %s, got %T", fields[0].name, value), fields[0], path)
+			return nil, nulled
+		}
+		list := make([]any, rv.Len())
+		for i := range list {
+			item, st := e.complete(t.Of, fields, rv.Index(i).Interface(), append(path, i))
+			if st == propagate {
+				return nil, nulled
+			}
+			list[i] = item
+		}
+		return list, resolved
+	case *Scalar:
+		serialized, err := t.Serialize(value)
+		if err != nil {
+			e.fail(err, fields[0], path)
+			return nil, nulled
+		}
+		return serialized, resolved
+	case *Object:
+		var selections []selection
+		for _, f := range fields {
+			selections = append(selections, f.selections...)
+		}
+		return e.selectionSet(t, value, selections, path)
+	}
+	return nil, resolved
+}
+
+// isNull reports whether a resolved value is null. Nil slices are empty
+// lists rather than null.
+func isNull(value any) bool {
+	if value == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// orderedObject is an object of the response, keeping its fields in the
+// order they were selected
+type orderedObject struct {
+	keys   []string
+	values []any
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
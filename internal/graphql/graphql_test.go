@@ -0,0 +1,231 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testSchema serves a list of books with their authors
+func testSchema(t *testing.T) *Schema {
+	t.Helper()
+	author := &Object{
+		Name: "Author",
+		Fields: []*Field{
+			{Name: "name", Type: &NonNull{Of: String}},
+		},
+	}
+	book := &Object{
+		Name:        "Book",
+		Description: "A book",
+		Fields: []*Field{
+			{Name: "title", Type: &NonNull{Of: String}},
+			{Name: "pages", Type: Int},
+			{Name: "bytes", Type: Long},
+			{Name: "author", Type: author},
+			{Name: "isbn", Type: &NonNull{Of: String}, Resolve: func(p ResolveParams) (any, error) {
+				if p.Source.(map[string]any)["title"] == "Lost" {
+					return nil, errors.New("isbn unknown")
+				}
+				return "978-0", nil
+			}},
+		},
+	}
+	books := []map[string]any{
+		{"title": "Dune", "pages": 412, "bytes": int64(1 << 40), "author": map[string]any{"name": "Herbert"}},
+		{"title": "Emma", "pages": 474, "author": map[string]any{"name": "Austen"}},
+		{"title": "Lost", "pages": 1},
+	}
+	query := &Object{
+		Name: "Query",
+		Fields: []*Field{
+			{
+				Name: "books",
+				Type: &NonNull{Of: &List{Of: &NonNull{Of: book}}},
+				Args: []*Argument{
+					{Name: "first", Type: Int, Default: 10},
+					{Name: "titles", Type: &List{Of: &NonNull{Of: String}}},
+				},
+				Resolve: func(p ResolveParams) (any, error) {
+					var result []map[string]any
+					for _, b := range books {
+						if titles, ok := p.Args["titles"].([]any); ok {
+							found := false
+							for _, title := range titles {
+								found = found || title == b["title"]
+							}
+							if !found {
+								continue
+							}
+						}
+						result = append(result, b)
+					}
+					return result[:min(len(result), p.Args["first"].(int))], nil
+				},
+			},
+			{
+				Name: "book",
+				Type: book,
+				Args: []*Argument{{Name: "title", Type: &NonNull{Of: String}}},
+				Resolve: func(p ResolveParams) (any, error) {
+					for _, b := range books {
+						if b["title"] == p.Args["title"] {
+							return b, nil
+						}
+					}
+					return nil, nil
+				},
+			},
+		},
+	}
+	schema, err := NewSchema(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func execute(t *testing.T, schema *Schema, query string, variables map[string]any) string {
+	t.Helper()
+	resp := schema.Execute(context.Background(), Request{Query: query, Variables: variables})
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestExecute(t *testing.T) {
+	schema := testSchema(t)
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		want      string
+	}{
+		{
+			name:  "fields in selection order",
+			query: `{ books(first: 2) { title pages author { name } } }`,
+			want:  `{"data":{"books":[{"title":"Dune","pages":412,"author":{"name":"Herbert"}},{"title":"Emma","pages":474,"author":{"name":"Austen"}}]}}`,
+		},
+		{
+			name:  "aliases and __typename",
+			query: `query { first: book(title: "Dune") { __typename t: title size: bytes } none: book(title: "None") { title } }`,
+			want:  `{"data":{"first":{"__typename":"Book","t":"Dune","size":1099511627776},"none":null}}`,
+		},
+		{
+			name:      "variables, defaults and single values for lists",
+			query:     `query Find($titles: [String!], $n: Int = 5) { books(titles: $titles, first: $n) { title } }`,
+			variables: map[string]any{"titles": "Emma"},
+			want:      `{"data":{"books":[{"title":"Emma"}]}}`,
+		},
+		{
+			name: "fragments and directives",
+			query: `query ($full: Boolean!) {
+				books(first: 1) { ...Names ... on Book @include(if: $full) { pages } author @skip(if: true) { name } }
+			}
+			fragment Names on Book { title author { name } }`,
+			variables: map[string]any{"full": true},
+			want:      `{"data":{"books":[{"title":"Dune","author":{"name":"Herbert"},"pages":412}]}}`,
+		},
+		{
+			name:  "null of a failed non-null field reaches the nearest nullable parent",
+			query: `{ lost: book(title: "Lost") { title isbn } dune: book(title: "Dune") { isbn } }`,
+			want:  `{"data":{"lost":null,"dune":{"isbn":"978-0"}},"errors":[{"message":"isbn unknown","locations":[{"line":1,"column":37}],"path":["lost","isbn"]}]}`,
+		},
+		{
+			name:  "a failed item of a non-null list nulls the root",
+			query: `{ books(titles: ["Lost"]) { isbn } }`,
+			want:  `{"data":null,"errors":[{"message":"isbn unknown","locations":[{"line":1,"column":29}],"path":["books",0,"isbn"]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := execute(t, schema, tt.query, tt.variables); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecute_Errors(t *testing.T) {
+	schema := testSchema(t)
+
+	tests := []struct {
+		query     string
+		variables map[string]any
+		want      string
+	}{
+		{`{ books { title `, nil, "Syntax error: unexpected end of document"},
+		{`{ books { name } }`, nil, `Cannot query field "name" on type "Book".`},
+		{`{ books(limit: 1) { title } }`, nil, `Unknown argument "limit" on field "Query.books".`},
+		{`{ book { title } }`, nil, `argument "title" of type "String!" is required`},
+		{`{ books(first: "two") { title } }`, nil, `Argument "first" has invalid value: Int cannot represent "two".`},
+		{`{ books }`, nil, `must have a selection of subfields`},
+		{`{ books { title { x } } }`, nil, `must not have a selection`},
+		{`{ books(first: $n) { title } }`, nil, `Variable "$n" is not defined.`},
+		{`query ($n: Int!) { books(first: $n) { title } }`, nil, `Variable "$n" of required type "Int!" was not provided.`},
+		{`query ($n: Int) { books(first: $n) { title } }`, map[string]any{"n": 1.5}, `Variable "$n" got invalid value`},
+		{`{ books { ...Missing } }`, nil, `Unknown fragment "Missing".`},
+		{`{ books { ...A } } fragment A on Book { ...A }`, nil, `Cannot spread fragment "A" within itself.`},
+		{`{ books { ... on Author { name } } }`, nil, `can never be of type "Author"`},
+		{`mutation { books { title } }`, nil, "Only queries are supported"},
+		{`{ books { title @cached } }`, nil, `Unknown directive "@cached".`},
+		{`{ books { author { name } } } query Other { books { title } }`, nil, "Must provide operation name"},
+	}
+	for _, tt := range tests {
+		resp := schema.Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables})
+		if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, tt.want) {
+			t.Errorf("%s:\n got  %+v\n want one error containing %s", tt.query, resp.Errors, tt.want)
+		}
+	}
+}
+
+func TestExecute_Depth(t *testing.T) {
+	node := &Object{Name: "Node"}
+	node.Fields = []*Field{{Name: "child", Type: node, Resolve: func(ResolveParams) (any, error) { return struct{}{}, nil }}, {Name: "id", Type: ID}}
+	schema, err := NewSchema(&Object{Name: "Query", Fields: []*Field{{Name: "root", Type: node, Resolve: func(ResolveParams) (any, error) { return struct{}{}, nil }}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := "{ root { " + strings.Repeat("child { ", maxDepth) + "id" + strings.Repeat(" }", maxDepth+1) + " }"
+	if got := execute(t, schema, query, nil); !strings.Contains(got, "nested deeper") {
+		t.Errorf("deep query = %s, want it refused", got)
+	}
+}
+
+func TestSchema_SDL(t *testing.T) {
+	sdl := testSchema(t).SDL()
+	for _, want := range []string{
+		"type Query {\n  books(first: Int = 10, titles: [String!]): [Book!]!\n  book(title: String!): Book\n}\n",
+		"\"A book\"\ntype Book {\n",
+		"scalar Long\n",
+		"type Author {\n  name: String!\n}\n",
+	} {
+		if !strings.Contains(sdl, want) {
+			t.Errorf("SDL does not contain %q:\n%s", want, sdl)
+		}
+	}
+	if !strings.HasPrefix(sdl, "type Query") {
+		t.Errorf("SDL does not start with the query type:\n%s", sdl)
+	}
+}
+
+func TestNewSchema_Invalid(t *testing.T) {
+	other := &Object{Name: "Query", Fields: []*Field{{Name: "x", Type: String}}}
+	tests := map[string]*Object{
+		"duplicate type names": {Name: "Query", Fields: []*Field{{Name: "other", Type: other}}},
+		"object argument":      {Name: "Query", Fields: []*Field{{Name: "x", Type: String, Args: []*Argument{{Name: "a", Type: other}}}}},
+		"missing field type":   {Name: "Query", Fields: []*Field{{Name: "x"}}},
+		"no fields":            {Name: "Query"},
+	}
+	for name, query := range tests {
+		if _, err := NewSchema(query); err == nil {
+			t.Errorf("NewSchema() with %s succeeded", name)
+		}
+	}
+}
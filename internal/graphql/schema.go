@@ -0,0 +1,433 @@
+// Package graphql executes GraphQL queries against a schema of Go
+// resolvers. It covers the query language as clients use it — operations,
+// variables, aliases, fragments and the @skip and @include directives —
+// but not mutations, subscriptions or introspection; the schema is
+// published as SDL instead.
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Type is a GraphQL type: a *Scalar, an *Object, or a List or NonNull of
+// another type
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type
+type Scalar struct {
+	Name        string
+	Description string
+	// Serialize converts a resolved value to its JSON representation
+	Serialize func(v any) (any, error)
+	// Parse converts an argument or variable value, as decoded from the
+	// query or from JSON, to the value resolvers receive
+	Parse func(v any) (any, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Object is a type with fields, each selected and resolved separately
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+func (o *Object) String() string { return o.Name }
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// List is a list of another type
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+// NonNull is a type whose values are never null
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// ResolveFunc returns the value of a field of source
+type ResolveFunc func(p ResolveParams) (any, error)
+
+// ResolveParams are passed to field resolvers
+type ResolveParams struct {
+	Context context.Context
+	Source  any            // The value of the object the field belongs to
+	Args    map[string]any // Argument values, with defaults filled in
+}
+
+// Field is a field of an object type. Without Resolve, the field is read
+// from a map[string]any source by name.
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Argument
+	Resolve     ResolveFunc
+}
+
+// Argument is an argument of a field. Argument types are scalars, or lists
+// or non-null forms of them.
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+	Default     any // Used when the argument is not given; nil for none
+}
+
+func (f *Field) arg(name string) *Argument {
+	for _, a := range f.Args {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Schema is the set of types reachable from the query root
+type Schema struct {
+	query   *Object
+	types   map[string]Type
+	ordered []Type
+}
+
+// NewSchema builds a schema from its query root type, checking that the
+// types reachable from it are consistent
+func NewSchema(query *Object) (*Schema, error) {
+	s := &Schema{query: query, types: make(map[string]Type)}
+	for _, scalar := range []*Scalar{String, Int, Float, Boolean, ID} {
+		s.types[scalar.Name] = scalar
+	}
+	if err := s.add(query); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// add registers a type and the types of its fields
+func (s *Schema) add(t Type) error {
+	switch t := t.(type) {
+	case *NonNull:
+		if _, ok := t.Of.(*NonNull); ok {
+			return fmt.Errorf("type %s is non-null twice", t)
+		}
+		return s.add(t.Of)
+	case *List:
+		return s.add(t.Of)
+	case *Scalar:
+		if existing, ok := s.types[t.Name]; ok {
+			if existing != t {
+				return fmt.Errorf("two types are named %s", t.Name)
+			}
+			return nil
+		}
+		s.types[t.Name] = t
+		s.ordered = append(s.ordered, t)
+		return nil
+	case *Object:
+		if existing, ok := s.types[t.Name]; ok {
+			if existing != t {
+				return fmt.Errorf("two types are named %s", t.Name)
+			}
+			return nil
+		}
+		s.types[t.Name] = t
+		s.ordered = append(s.ordered, t)
+		if len(t.Fields) == 0 {
+			return fmt.Errorf("type %s has no fields", t.Name)
+		}
+		seen := make(map[string]bool)
+		for _, f := range t.Fields {
+			if f.Type == nil || seen[f.Name] || strings.HasPrefix(f.Name, "__") {
+				return fmt.Errorf("field %s.%s is invalid or declared twice", t.Name, f.Name)
+			}
+			seen[f.Name] = true
+			for _, a := range f.Args {
+				if !isInputType(a.Type) {
+					return fmt.Errorf("argument %s of %s.%s must be a scalar", a.Name, t.Name, f.Name)
+				}
+				if err := s.add(a.Type); err != nil {
+					return err
+				}
+			}
+			if err := s.add(f.Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("missing type")
+	}
+	return fmt.Errorf("unsupported type %s", t)
+}
+
+func isInputType(t Type) bool {
+	switch t := t.(type) {
+	case *NonNull:
+		return isInputType(t.Of)
+	case *List:
+		return isInputType(t.Of)
+	case *Scalar:
+		return true
+	}
+	return false
+}
+
+// SDL returns the schema in the GraphQL schema definition language
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	types := append([]Type(nil), s.ordered...)
+	// The query root first, then the other types by name
+	sort.SliceStable(types[1:], func(i, j int) bool {
+		return types[i+1].String() < types[j+1].String()
+	})
+	for _, t := range types {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		switch t := t.(type) {
+		case *Scalar:
+			writeDescription(&b, "", t.Description)
+			fmt.Fprintf(&b, "scalar %s\n", t.Name)
+		case *Object:
+			writeDescription(&b, "", t.Description)
+			fmt.Fprintf(&b, "type %s {\n", t.Name)
+			for _, f := range t.Fields {
+				writeDescription(&b, "  ", f.Description)
+				b.WriteString("  " + f.Name)
+				if len(f.Args) > 0 {
+					args := make([]string, len(f.Args))
+					for i, a := range f.Args {
+						args[i] = a.Name + ": " + a.Type.String()
+						if a.Default != nil {
+							args[i] += " = " + formatValue(a.Default)
+						}
+					}
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				b.WriteString(": " + f.Type.String() + "\n")
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		fmt.Fprintf(b, "%s%q\n", indent, description)
+	}
+}
+
+// formatValue writes a default value as a GraphQL literal
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// Built-in scalars
+
+var (
+	String = &Scalar{
+		Name:      "String",
+		Serialize: serializeString,
+		Parse: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent %s", describe(v))
+		},
+	}
+	Int = &Scalar{
+		Name: "Int",
+		Serialize: func(v any) (any, error) {
+			n, err := toInt64(v)
+			if err != nil {
+				return nil, err
+			}
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %d, use a 64-bit scalar", n)
+			}
+			return n, nil
+		},
+		Parse: func(v any) (any, error) {
+			n, err := parseInteger(v)
+			if err != nil {
+				return nil, fmt.Errorf("Int cannot represent %s", describe(v))
+			}
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %d", n)
+			}
+			return int(n), nil
+		},
+	}
+	Float = &Scalar{
+		Name: "Float",
+		Serialize: func(v any) (any, error) {
+			rv := reflect.ValueOf(v)
+			switch {
+			case rv.CanFloat():
+				return rv.Float(), nil
+			case rv.CanInt():
+				return float64(rv.Int()), nil
+			case rv.CanUint():
+				return float64(rv.Uint()), nil
+			}
+			return nil, fmt.Errorf("Float cannot represent %s", describe(v))
+		},
+		Parse: func(v any) (any, error) {
+			switch v := v.(type) {
+			case float64:
+				return v, nil
+			case int64:
+				return float64(v), nil
+			}
+			return nil, fmt.Errorf("Float cannot represent %s", describe(v))
+		},
+	}
+	Boolean = &Scalar{
+		Name: "Boolean",
+		Serialize: func(v any) (any, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %s", describe(v))
+		},
+		Parse: func(v any) (any, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %s", describe(v))
+		},
+	}
+	ID = &Scalar{
+		Name:      "ID",
+		Serialize: serializeString,
+		Parse: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, err := parseInteger(v); err == nil {
+				return fmt.Sprint(n), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %s", describe(v))
+		},
+	}
+)
+
+// Long is a 64-bit integer scalar, for sizes and counters beyond the 32
+// bits of Int
+var Long = &Scalar{
+	Name:        "Long",
+	Description: "A 64-bit signed integer",
+	Serialize: func(v any) (any, error) {
+		return toInt64(v)
+	},
+	Parse: func(v any) (any, error) {
+		n, err := parseInteger(v)
+		if err != nil {
+			return nil, fmt.Errorf("Long cannot represent %s", describe(v))
+		}
+		return n, nil
+	},
+}
+
+// Time is a point in time as an RFC 3339 string
+var Time = &Scalar{
+	Name:        "Time",
+	Description: "A point in time, as an RFC 3339 string",
+	Serialize: func(v any) (any, error) {
+		switch t := v.(type) {
+		case time.Time:
+			return t.UTC().Format(time.RFC3339Nano), nil
+		case *time.Time:
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+		return nil, fmt.Errorf("Time cannot represent %s", describe(v))
+	},
+	Parse: func(v any) (any, error) {
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("Time cannot represent %s", describe(v))
+	},
+}
+
+// serializeString accepts strings, types defined as strings and
+// fmt.Stringers
+func serializeString(v any) (any, error) {
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String(), nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	return nil, fmt.Errorf("String cannot represent %s", describe(v))
+}
+
+func toInt64(v any) (int64, error) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return rv.Int(), nil
+	case rv.CanUint() && rv.Uint() <= math.MaxInt64:
+		return int64(rv.Uint()), nil
+	}
+	return 0, fmt.Errorf("cannot represent %s as an integer", describe(v))
+}
+
+// parseInteger accepts integer literals and integral JSON numbers
+func parseInteger(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v <= math.MaxInt64 {
+			return int64(v), nil
+		}
+	}
+	return 0, fmt.Errorf("not an integer")
+}
+
+func describe(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case enumValue:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
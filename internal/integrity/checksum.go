@@ -2,67 +2,159 @@ package integrity
 
 import (
 	"crypto/md5"
-	"crypto/sha256"
 	"encoding/hex"
 	"hash"
 	"hash/crc32"
 	"io"
+	"strings"
 )
 
 // Checksum holds checksum information
 type Checksum struct {
-	Algorithm string
-	Value     string
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
 }
 
-// Calculator handles checksum calculation
+// castagnoli is the CRC-32C table. The standard library computes it with
+// the SSE4.2 or ARMv8 CRC32 instructions when the CPU has them.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// DefaultAlgorithms are computed when no algorithms are chosen
+var DefaultAlgorithms = []string{AlgorithmSHA256, AlgorithmCRC32C}
+
+// CalculatorOptions selects the checksums a Calculator computes
+type CalculatorOptions struct {
+	// SkipMD5 leaves out MD5, which has no hardware support and costs more
+	// CPU than SHA-256 and CRC32C together. ETags are then derived from the
+	// first algorithm and no longer match the MD5 S3 clients may check them
+	// against.
+	SkipMD5 bool
+	// Algorithms are the registered algorithms to compute besides MD5; the
+	// first is the checksum stored with objects. Empty means
+	// DefaultAlgorithms.
+	Algorithms []string
+}
+
+// Calculator computes the checksums of the data written to it. SHA-256 and
+// CRC32C run on the CPU's SHA and CRC instructions where available (see
+// Hardware).
 type Calculator struct {
-	md5    hash.Hash
-	sha256 hash.Hash
-	crc32  hash.Hash32
+	md5    hash.Hash // nil with SkipMD5
+	hashes []namedHash
 }
 
-// NewCalculator creates a new checksum calculator
+type namedHash struct {
+	algorithm string
+	hash.Hash
+}
+
+// NewCalculator creates a calculator of MD5 and the default algorithms
 func NewCalculator() *Calculator {
-	return &Calculator{
-		md5:    md5.New(),
-		sha256: sha256.New(),
-		crc32:  crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	c, _ := NewCalculatorWithOptions(CalculatorOptions{})
+	return c
+}
+
+// NewCalculatorWithOptions creates a checksum calculator computing the
+// checksums selected by opts
+func NewCalculatorWithOptions(opts CalculatorOptions) (*Calculator, error) {
+	algorithms := opts.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultAlgorithms
+	}
+	if err := ValidateAlgorithms(algorithms); err != nil {
+		return nil, err
+	}
+
+	c := &Calculator{}
+	if !opts.SkipMD5 {
+		c.md5 = md5.New()
 	}
+	for _, algorithm := range algorithms {
+		algorithm = strings.ToUpper(algorithm)
+		if algorithm == AlgorithmMD5 && c.md5 != nil {
+			c.hashes = append(c.hashes, namedHash{algorithm, c.md5})
+			continue
+		}
+		h, _ := NewHash(algorithm)
+		c.hashes = append(c.hashes, namedHash{algorithm, h})
+		if algorithm == AlgorithmMD5 {
+			c.md5 = h
+		}
+	}
+	return c, nil
 }
 
 // Write implements io.Writer to update all hashes
 func (c *Calculator) Write(p []byte) (n int, err error) {
-	n, err = c.md5.Write(p)
-	if err != nil {
-		return n, err
+	if c.md5 != nil {
+		_, _ = c.md5.Write(p)
+	}
+	for _, h := range c.hashes {
+		if h.Hash != c.md5 {
+			_, _ = h.Write(p)
+		}
 	}
-	_, _ = c.sha256.Write(p)
-	_, _ = c.crc32.Write(p)
-	return n, nil
+	return len(p), nil
 }
 
-// Sums returns all calculated checksums
+// Sums returns all calculated checksums in hex, by algorithm
 func (c *Calculator) Sums() map[string]string {
-	return map[string]string{
-		"MD5":    hex.EncodeToString(c.md5.Sum(nil)),
-		"SHA256": hex.EncodeToString(c.sha256.Sum(nil)),
-		"CRC32":  hex.EncodeToString(c.crc32.Sum(nil)),
+	sums := make(map[string]string, len(c.hashes)+1)
+	if c.md5 != nil {
+		sums[AlgorithmMD5] = hex.EncodeToString(c.md5.Sum(nil))
 	}
+	for _, h := range c.hashes {
+		sums[h.algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// Checksum returns the checksum of the first algorithm, the one stored
+// with objects
+func (c *Calculator) Checksum() Checksum {
+	h := c.hashes[0]
+	return Checksum{Algorithm: h.algorithm, Value: hex.EncodeToString(h.Sum(nil))}
+}
+
+// ETag returns the ETag of the data written: its hex MD5 or, with MD5
+// skipped, the first algorithm's digest cut to MD5's 16 bytes
+func (c *Calculator) ETag() string {
+	if c.md5 != nil {
+		return hex.EncodeToString(c.md5.Sum(nil))
+	}
+	return digestETag(c.hashes[0].Sum(nil))
+}
+
+func digestETag(sum []byte) string {
+	return hex.EncodeToString(sum[:min(len(sum), md5.Size)])
 }
 
-// CalculateChecksum calculates checksum for a reader
+// MatchesETag reports whether etag is an ETag a Calculator could have given
+// data, with MD5 or from any registered algorithm
+func MatchesETag(data []byte, etag string) bool {
+	md5Sum := md5.Sum(data)
+	if hex.EncodeToString(md5Sum[:]) == etag {
+		return true
+	}
+	for _, algorithm := range Algorithms() {
+		h, _ := NewHash(algorithm)
+		if algorithm == AlgorithmMD5 || 2*min(h.Size(), md5.Size) != len(etag) {
+			continue
+		}
+		h.Write(data)
+		if digestETag(h.Sum(nil)) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CalculateChecksum calculates the hex checksum of a reader with a
+// registered algorithm
 func CalculateChecksum(r io.Reader, algo string) (string, error) {
-	var h hash.Hash
-	switch algo {
-	case "MD5":
-		h = md5.New()
-	case "SHA256":
-		h = sha256.New()
-	case "CRC32":
-		h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
-	default:
-		return "", io.ErrUnexpectedEOF
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
 	}
 
 	if _, err := io.Copy(h, r); err != nil {
@@ -2,6 +2,9 @@ package integrity
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -13,11 +16,8 @@ func TestNewCalculator(t *testing.T) {
 	if calc.md5 == nil {
 		t.Error("md5 hash is nil")
 	}
-	if calc.sha256 == nil {
-		t.Error("sha256 hash is nil")
-	}
-	if calc.crc32 == nil {
-		t.Error("crc32 hash is nil")
+	if len(calc.hashes) != len(DefaultAlgorithms) {
+		t.Errorf("%d hashes, want the %d default algorithms", len(calc.hashes), len(DefaultAlgorithms))
 	}
 }
 
@@ -49,8 +49,8 @@ func TestCalculator_Sums(t *testing.T) {
 	if _, ok := sums["SHA256"]; !ok {
 		t.Error("SHA256 checksum missing")
 	}
-	if _, ok := sums["CRC32"]; !ok {
-		t.Error("CRC32 checksum missing")
+	if _, ok := sums["CRC32C"]; !ok {
+		t.Error("CRC32C checksum missing")
 	}
 }
 
@@ -108,3 +108,92 @@ func TestCalculateChecksum_Consistency(t *testing.T) {
 		t.Errorf("Checksums not consistent: %s != %s", checksum1, checksum2)
 	}
 }
+
+func TestCalculator_SkipMD5(t *testing.T) {
+	data := []byte("test data")
+
+	full := NewCalculator()
+	full.Write(data)
+	fast, err := NewCalculatorWithOptions(CalculatorOptions{SkipMD5: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast.Write(data)
+
+	sums := fast.Sums()
+	if _, ok := sums["MD5"]; ok {
+		t.Error("MD5 computed although skipped")
+	}
+	if sums["SHA256"] != full.Sums()["SHA256"] || sums["CRC32C"] != full.Sums()["CRC32C"] {
+		t.Errorf("Sums() = %v, want the same SHA256 and CRC32 as %v", sums, full.Sums())
+	}
+
+	if full.ETag() != full.Sums()["MD5"] {
+		t.Errorf("ETag() = %s, want the MD5", full.ETag())
+	}
+	if etag := fast.ETag(); len(etag) != 32 || etag != sums["SHA256"][:32] {
+		t.Errorf("ETag() without MD5 = %s, want the SHA256 prefix", etag)
+	}
+
+	for _, etag := range []string{full.ETag(), fast.ETag()} {
+		if !MatchesETag(data, etag) {
+			t.Errorf("MatchesETag(%s) = false, want true", etag)
+		}
+	}
+	if MatchesETag([]byte("other data"), full.ETag()) {
+		t.Error("MatchesETag() matched other data")
+	}
+}
+
+func TestCalculator_Algorithms(t *testing.T) {
+	tests := []struct {
+		algorithms []string
+		data       string
+		checksum   string // Hex
+	}{
+		{[]string{"SHA256"}, "test data", "916f0027a575074ce72a331777c3478d6513f786a591bd892da1a577bf2335f9"},
+		{[]string{"crc32c"}, "test data", "3379b4ca"},
+		{[]string{"XXHASH64", "SHA256"}, "abc", "44bc2cf5ad770999"},
+		{[]string{"BLAKE3"}, "", "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	}
+	for _, tt := range tests {
+		calc, err := NewCalculatorWithOptions(CalculatorOptions{SkipMD5: true, Algorithms: tt.algorithms})
+		if err != nil {
+			t.Fatalf("%v: NewCalculatorWithOptions() error = %v", tt.algorithms, err)
+		}
+		calc.Write([]byte(tt.data))
+
+		sums := calc.Sums()
+		if len(sums) != len(tt.algorithms) {
+			t.Errorf("%v: Sums() = %v, want only the chosen algorithms", tt.algorithms, sums)
+		}
+		checksum := calc.Checksum()
+		if checksum.Value != tt.checksum || !strings.EqualFold(checksum.Algorithm, tt.algorithms[0]) {
+			t.Errorf("%v: Checksum() = %+v, want %s", tt.algorithms, checksum, tt.checksum)
+		}
+		if !MatchesETag([]byte(tt.data), calc.ETag()) {
+			t.Errorf("%v: MatchesETag(%s) = false", tt.algorithms, calc.ETag())
+		}
+	}
+
+	for _, algorithms := range [][]string{{"SHA3"}, {"SHA256", "sha256"}} {
+		if _, err := NewCalculatorWithOptions(CalculatorOptions{Algorithms: algorithms}); err == nil {
+			t.Errorf("%v: NewCalculatorWithOptions() succeeded, want an error", algorithms)
+		}
+	}
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	RegisterAlgorithm("test-sha1", sha1.New)
+	if !slices.Contains(Algorithms(), "TEST-SHA1") {
+		t.Fatalf("Algorithms() = %v, want TEST-SHA1", Algorithms())
+	}
+	calc, err := NewCalculatorWithOptions(CalculatorOptions{Algorithms: []string{"Test-SHA1"}})
+	if err != nil {
+		t.Fatalf("NewCalculatorWithOptions() error = %v", err)
+	}
+	calc.Write([]byte("test data"))
+	if got := calc.Checksum().Value; got != "f48dd853820860816c75d54d0f584dc863327a7c" {
+		t.Errorf("Checksum() = %s, want the SHA-1", got)
+	}
+}
@@ -0,0 +1,153 @@
+package integrity
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// DefaultChunkSize is the span of data each chunk checksum covers
+const DefaultChunkSize = 4 * 1024 * 1024
+
+// ErrChunkMismatch is returned when stored data no longer matches the
+// checksum of its chunk
+var ErrChunkMismatch = errors.New("chunk checksum mismatch")
+
+// ChunkChecksums are the checksums of consecutive fixed-size chunks of an
+// object, kept alongside the whole-object checksum so corruption can be
+// localized to the chunks holding it. The last chunk may be shorter.
+type ChunkChecksums struct {
+	Algorithm string   `json:"algorithm"`
+	ChunkSize int64    `json:"chunk_size"`
+	Values    []string `json:"values"` // Hex, in chunk order
+}
+
+// ChunkRange is the byte range of one chunk of an object
+type ChunkRange struct {
+	Index  int   `json:"index"`
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+}
+
+// ChunkMismatchError lists the chunks whose data did not match their
+// checksums
+type ChunkMismatchError struct {
+	Chunks []ChunkRange
+}
+
+func (e *ChunkMismatchError) Error() string {
+	ranges := make([]string, len(e.Chunks))
+	for i, c := range e.Chunks {
+		ranges[i] = fmt.Sprintf("%d-%d", c.Start, c.Start+c.Length-1)
+	}
+	return fmt.Sprintf("%s in bytes %s", ErrChunkMismatch, strings.Join(ranges, ", "))
+}
+
+func (e *ChunkMismatchError) Unwrap() error {
+	return ErrChunkMismatch
+}
+
+// Span returns the range of whole chunks holding bytes
+// [start, start+length) of an object of the given size
+func (c *ChunkChecksums) Span(start, length, size int64) (int64, int64) {
+	if length <= 0 {
+		return start, length
+	}
+	first := start / c.ChunkSize * c.ChunkSize
+	end := min((start+length+c.ChunkSize-1)/c.ChunkSize*c.ChunkSize, size)
+	return first, end - first
+}
+
+// Chunk returns the range of chunk i of an object of the given size
+func (c *ChunkChecksums) Chunk(i int, size int64) ChunkRange {
+	start := int64(i) * c.ChunkSize
+	return ChunkRange{Index: i, Start: start, Length: min(c.ChunkSize, size-start)}
+}
+
+// Verify checks data read at start, a chunk boundary, against the
+// checksums of the chunks it covers. It returns a *ChunkMismatchError
+// listing every chunk that does not match.
+func (c *ChunkChecksums) Verify(data []byte, start int64) error {
+	if c.ChunkSize <= 0 || start%c.ChunkSize != 0 {
+		return fmt.Errorf("chunk checks need data from a chunk boundary, got offset %d", start)
+	}
+	h, err := NewHash(c.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	var mismatch ChunkMismatchError
+	index := int(start / c.ChunkSize)
+	for off := int64(0); off < int64(len(data)); off, index = off+c.ChunkSize, index+1 {
+		chunk := data[off:min(off+c.ChunkSize, int64(len(data)))]
+		if index >= len(c.Values) {
+			break
+		}
+		h.Reset()
+		h.Write(chunk)
+		if hex.EncodeToString(h.Sum(nil)) != c.Values[index] {
+			mismatch.Chunks = append(mismatch.Chunks, ChunkRange{Index: index, Start: start + off, Length: int64(len(chunk))})
+		}
+	}
+	if len(mismatch.Chunks) > 0 {
+		return &mismatch
+	}
+	return nil
+}
+
+// ChunkCalculator computes the chunk checksums of the data written to it
+type ChunkCalculator struct {
+	sums   ChunkChecksums
+	hash   hash.Hash
+	filled int64 // Bytes of the current chunk written
+}
+
+// NewChunkCalculator creates a calculator of checksums of a registered
+// algorithm over chunks of size bytes
+func NewChunkCalculator(algorithm string, size int64) (*ChunkCalculator, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", size)
+	}
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkCalculator{
+		sums: ChunkChecksums{Algorithm: strings.ToUpper(algorithm), ChunkSize: size},
+		hash: h,
+	}, nil
+}
+
+// Write implements io.Writer, ending a chunk every chunk size bytes
+func (c *ChunkCalculator) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(int64(len(p)), c.sums.ChunkSize-c.filled)
+		c.hash.Write(p[:take])
+		c.filled += take
+		p = p[take:]
+		if c.filled == c.sums.ChunkSize {
+			c.endChunk()
+		}
+	}
+	return n, nil
+}
+
+func (c *ChunkCalculator) endChunk() {
+	c.sums.Values = append(c.sums.Values, hex.EncodeToString(c.hash.Sum(nil)))
+	c.hash.Reset()
+	c.filled = 0
+}
+
+// Checksums ends the last, partial chunk and returns the checksums of
+// every chunk written
+func (c *ChunkCalculator) Checksums() *ChunkChecksums {
+	if c.filled > 0 {
+		c.endChunk()
+	}
+	sums := c.sums
+	sums.Values = append([]string(nil), c.sums.Values...)
+	return &sums
+}
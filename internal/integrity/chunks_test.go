@@ -0,0 +1,68 @@
+package integrity
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestChunkCalculator(t *testing.T) {
+	data := bytes.Repeat([]byte("chunked"), 1000) // 7000 bytes
+
+	// Writes of any size give the same checksums
+	whole, _ := NewChunkCalculator(AlgorithmCRC32C, 1024)
+	whole.Write(data)
+	pieces, _ := NewChunkCalculator(AlgorithmCRC32C, 1024)
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 333)
+		pieces.Write(rest[:n])
+		rest = rest[n:]
+	}
+	sums := whole.Checksums()
+	if len(sums.Values) != 7 {
+		t.Fatalf("%d chunks, want 7", len(sums.Values))
+	}
+	if got := pieces.Checksums(); !slices.Equal(got.Values, sums.Values) {
+		t.Errorf("checksums of split writes = %v, want %v", got.Values, sums.Values)
+	}
+
+	last, _ := CalculateChecksum(bytes.NewReader(data[6144:]), AlgorithmCRC32C)
+	if sums.Values[6] != last {
+		t.Errorf("last chunk checksum = %s, want %s", sums.Values[6], last)
+	}
+
+	if err := sums.Verify(data[2048:], 2048); err != nil {
+		t.Errorf("Verify() of intact data error = %v", err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[1500] ^= 1
+	corrupt[6500] ^= 1
+	err := sums.Verify(corrupt, 0)
+	var mismatch *ChunkMismatchError
+	if !errors.As(err, &mismatch) || !errors.Is(err, ErrChunkMismatch) {
+		t.Fatalf("Verify() of corrupt data error = %v", err)
+	}
+	want := []ChunkRange{{Index: 1, Start: 1024, Length: 1024}, {Index: 6, Start: 6144, Length: 856}}
+	if len(mismatch.Chunks) != 2 || mismatch.Chunks[0] != want[0] || mismatch.Chunks[1] != want[1] {
+		t.Errorf("corrupt chunks = %+v, want %+v", mismatch.Chunks, want)
+	}
+}
+
+func TestChunkChecksums_Span(t *testing.T) {
+	sums := &ChunkChecksums{ChunkSize: 1024}
+	for _, tt := range []struct {
+		start, length, size int64
+		wantStart, wantLen  int64
+	}{
+		{0, 10, 5000, 0, 1024},
+		{1000, 100, 5000, 0, 2048},
+		{4500, 500, 5000, 4096, 904},
+		{2048, 1024, 5000, 2048, 1024},
+	} {
+		start, length := sums.Span(tt.start, tt.length, tt.size)
+		if start != tt.wantStart || length != tt.wantLen {
+			t.Errorf("Span(%d, %d) = %d, %d, want %d, %d", tt.start, tt.length, start, length, tt.wantStart, tt.wantLen)
+		}
+	}
+}
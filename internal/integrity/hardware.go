@@ -0,0 +1,33 @@
+package integrity
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/cpu"
+)
+
+// Hardware lists the CPU features this machine offers the checksum hashes,
+// for logging at startup. The standard library picks them itself: CRC32C
+// uses SSE4.2 with PCLMULQDQ on amd64 and the CRC32 instructions on arm64;
+// SHA-256 uses SHA-NI, or else AVX2, on amd64 and the SHA2 instructions on
+// arm64. An empty list means the portable Go code is used.
+func Hardware() []string {
+	var features []string
+	add := func(name string, ok bool) {
+		if ok {
+			features = append(features, name)
+		}
+	}
+	switch runtime.GOARCH {
+	case "amd64":
+		add("sse4.2", cpu.X86.HasSSE42)
+		add("pclmulqdq", cpu.X86.HasPCLMULQDQ)
+		add("avx2", cpu.X86.HasAVX2)
+	case "arm64":
+		add("crc32", cpu.ARM64.HasCRC32)
+		add("sha2", cpu.ARM64.HasSHA2)
+		add("pmull", cpu.ARM64.HasPMULL)
+		add("neon", cpu.ARM64.HasASIMD)
+	}
+	return features
+}
@@ -0,0 +1,83 @@
+package integrity
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// Checksum algorithms a Calculator can compute besides the S3 ones
+const (
+	AlgorithmMD5      = "MD5"
+	AlgorithmXXHash64 = "XXHASH64"
+	AlgorithmBLAKE3   = "BLAKE3"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() hash.Hash{
+		AlgorithmMD5:      md5.New,
+		AlgorithmSHA1:     sha1.New,
+		AlgorithmSHA256:   sha256.New,
+		AlgorithmCRC32:    func() hash.Hash { return crc32.NewIEEE() },
+		AlgorithmCRC32C:   func() hash.Hash { return crc32.New(castagnoli) },
+		AlgorithmXXHash64: func() hash.Hash { return xxhash.New() },
+		AlgorithmBLAKE3:   func() hash.Hash { return blake3.New() },
+	}
+)
+
+// RegisterAlgorithm adds a checksum algorithm to the registry, or replaces
+// the one of the same name. Names are upper case.
+func RegisterAlgorithm(name string, newHash func() hash.Hash) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToUpper(name)] = newHash
+}
+
+// NewHash returns a new hash of a registered algorithm, named in any case
+func NewHash(algorithm string) (hash.Hash, error) {
+	registryMu.RLock()
+	newHash, ok := registry[strings.ToUpper(algorithm)]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+	return newHash(), nil
+}
+
+// Algorithms returns the names of the registered algorithms, sorted
+func Algorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateAlgorithms checks a set of algorithms is registered and has no
+// duplicates
+func ValidateAlgorithms(algorithms []string) error {
+	seen := make(map[string]bool)
+	for _, name := range algorithms {
+		if _, err := NewHash(name); err != nil {
+			return err
+		}
+		if seen[strings.ToUpper(name)] {
+			return fmt.Errorf("checksum algorithm %s is listed twice", name)
+		}
+		seen[strings.ToUpper(name)] = true
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package integrity
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+	"strings"
+)
+
+// S3 additional checksum algorithms, as named in x-amz-checksum-* headers
+const (
+	AlgorithmCRC32  = "CRC32"
+	AlgorithmCRC32C = "CRC32C"
+	AlgorithmSHA1   = "SHA1"
+	AlgorithmSHA256 = "SHA256"
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned for unknown checksum algorithms
+	ErrUnsupportedAlgorithm = errors.New("unsupported checksum algorithm")
+	// ErrChecksumMismatch is returned when data does not match its checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// S3Algorithms lists the supported additional checksum algorithms
+var S3Algorithms = []string{AlgorithmCRC32, AlgorithmCRC32C, AlgorithmSHA1, AlgorithmSHA256}
+
+// NewS3Hash returns a hash for an S3 checksum algorithm. Unlike the hex
+// sums of Calculator, S3 checksums are the base64 of the raw digest.
+func NewS3Hash(algorithm string) (hash.Hash, error) {
+	if !slices.Contains(S3Algorithms, strings.ToUpper(algorithm)) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+	return NewHash(algorithm)
+}
+
+// S3Verifier checks streamed data against an expected S3 checksum
+type S3Verifier struct {
+	expected Checksum
+	h        hash.Hash
+}
+
+// NewS3Verifier creates a verifier for a base64 checksum value
+func NewS3Verifier(expected Checksum) (*S3Verifier, error) {
+	h, err := NewS3Hash(expected.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	expected.Algorithm = strings.ToUpper(expected.Algorithm)
+	return &S3Verifier{expected: expected, h: h}, nil
+}
+
+// Write implements io.Writer
+func (v *S3Verifier) Write(p []byte) (int, error) {
+	return v.h.Write(p)
+}
+
+// Sum returns the base64 checksum of the data written so far
+func (v *S3Verifier) Sum() string {
+	return base64.StdEncoding.EncodeToString(v.h.Sum(nil))
+}
+
+// Verify returns ErrChecksumMismatch if the data does not match
+func (v *S3Verifier) Verify() error {
+	if got := v.Sum(); got != v.expected.Value {
+		return fmt.Errorf("%w: %s expected %s, got %s", ErrChecksumMismatch, v.expected.Algorithm, v.expected.Value, got)
+	}
+	return nil
+}
+
+// Checksum returns the verified checksum
+func (v *S3Verifier) Checksum() Checksum {
+	return v.expected
+}
@@ -0,0 +1,145 @@
+// Package inventory writes a listing of the objects of every bucket to an
+// inventory bucket, as S3 inventory reports do, for audits and for tools
+// that would otherwise list the buckets themselves.
+package inventory
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+)
+
+// DateLayout formats the date in the key of an inventory
+const DateLayout = "2006-01-02"
+
+// ErrNoBucket is returned when no inventory bucket is configured
+var ErrNoBucket = errors.New("no inventory bucket configured")
+
+// header is the first row of an inventory
+var header = []string{"key", "version_id", "size", "etag", "last_modified", "content_type", "storage_class"}
+
+// Buckets lists the buckets to take the inventory of
+type Buckets interface {
+	ListBuckets(ctx context.Context, owner string) ([]*bucket.Bucket, error)
+}
+
+// Objects lists the objects of a bucket and stores the inventories
+type Objects interface {
+	WalkObjects(ctx context.Context, bucket, prefix, startAfter string, fn func(*object.Object) error) error
+	PutObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string) (*object.Object, error)
+}
+
+// Summary totals an inventory run
+type Summary struct {
+	Buckets int   `json:"buckets"` // Inventories written
+	Objects int64 `json:"objects"` // Objects listed in them
+	Bytes   int64 `json:"bytes"`   // Size of those objects
+}
+
+// Observer is notified after every object listed
+type Observer func(summary Summary)
+
+// Writer writes the inventories of the buckets
+type Writer struct {
+	buckets Buckets
+	objects Objects
+	bucket  string
+	prefix  string
+	now     func() time.Time
+}
+
+// NewWriter creates a writer storing inventories in bucket under prefix
+func NewWriter(buckets Buckets, objects Objects, bucket, prefix string) *Writer {
+	return &Writer{buckets: buckets, objects: objects, bucket: bucket, prefix: prefix, now: time.Now}
+}
+
+// Key returns the key of the inventory of a bucket taken on date
+func (w *Writer) Key(bucketName string, date time.Time) string {
+	return w.prefix + bucketName + "/" + date.UTC().Format(DateLayout) + ".csv"
+}
+
+// Run writes the inventory of every bucket but the inventory bucket, a CSV
+// with a row per object under Key. Delete markers are not listed. The
+// listing is spooled to a temporary file, so memory use does not grow with
+// the bucket.
+func (w *Writer) Run(ctx context.Context, observer Observer) (Summary, error) {
+	var summary Summary
+	if w.bucket == "" {
+		return summary, ErrNoBucket
+	}
+	buckets, err := w.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return summary, err
+	}
+
+	date := w.now()
+	for _, b := range buckets {
+		if b.Name == w.bucket {
+			continue
+		}
+		if err := w.write(ctx, b.Name, date, &summary, observer); err != nil {
+			return summary, fmt.Errorf("failed to write the inventory of bucket %s: %w", b.Name, err)
+		}
+		summary.Buckets++
+	}
+	return summary, nil
+}
+
+// write lists one bucket and stores its inventory
+func (w *Writer) write(ctx context.Context, bucketName string, date time.Time, summary *Summary, observer Observer) error {
+	f, err := os.CreateTemp("", "comio-inventory-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	out := csv.NewWriter(f)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	err = w.objects.WalkObjects(ctx, bucketName, "", "", func(obj *object.Object) error {
+		if obj.DeleteMarker {
+			return nil
+		}
+		summary.Objects++
+		summary.Bytes += obj.Size
+		if observer != nil {
+			observer(*summary)
+		}
+		return out.Write([]string{
+			obj.Key,
+			obj.VersionID,
+			strconv.FormatInt(obj.Size, 10),
+			obj.ETag,
+			obj.ModifiedAt.UTC().Format(time.RFC3339),
+			obj.ContentType,
+			obj.StorageClass,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = w.objects.PutObject(ctx, w.bucket, w.Key(bucketName, date), f, size, "text/csv")
+	return err
+}
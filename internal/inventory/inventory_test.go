@@ -0,0 +1,94 @@
+package inventory
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+)
+
+type fakeStore struct {
+	objects map[string][]*object.Object
+	written map[string]string
+}
+
+func (f *fakeStore) ListBuckets(ctx context.Context, owner string) ([]*bucket.Bucket, error) {
+	return []*bucket.Bucket{{Name: "inventory"}, {Name: "logs"}, {Name: "media"}}, nil
+}
+
+func (f *fakeStore) WalkObjects(ctx context.Context, bucket, prefix, startAfter string, fn func(*object.Object) error) error {
+	for _, obj := range f.objects[bucket] {
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) PutObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string) (*object.Object, error) {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != size || contentType != "text/csv" {
+		return nil, errors.New("unexpected upload")
+	}
+	f.written[bucket+"/"+key] = string(b)
+	return &object.Object{BucketName: bucket, Key: key, Size: size}, nil
+}
+
+func TestWriter_Run(t *testing.T) {
+	modified := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeStore{
+		objects: map[string][]*object.Object{
+			"inventory": {{Key: "inventory/logs/2026-10-15.csv", Size: 1}},
+			"logs": {
+				{Key: "a.log", VersionID: "v1", Size: 10, ETag: "e1", ModifiedAt: modified, ContentType: "text/plain"},
+				{Key: "b.log", DeleteMarker: true},
+				{Key: "c,d.log", VersionID: "v2", Size: 5, ETag: "e2", ModifiedAt: modified},
+			},
+		},
+		written: map[string]string{},
+	}
+	writer := NewWriter(store, store, "inventory", "inventory/")
+	writer.now = func() time.Time { return time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC) }
+
+	var observed Summary
+	summary, err := writer.Run(context.Background(), func(s Summary) { observed = s })
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if summary != (Summary{Buckets: 2, Objects: 2, Bytes: 15}) || observed.Objects != 2 {
+		t.Errorf("Run() = %+v, observed %+v", summary, observed)
+	}
+	if len(store.written) != 2 {
+		t.Fatalf("written = %v, want the inventories of logs and media only", store.written)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(store.written["inventory/inventory/logs/2026-10-16.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0][0] != "key" {
+		t.Fatalf("rows = %q, want a header and two objects", rows)
+	}
+	if got := strings.Join(rows[1], "|"); got != "a.log|v1|10|e1|2026-10-01T12:00:00Z|text/plain|" {
+		t.Errorf("row = %q", got)
+	}
+	if rows[2][0] != "c,d.log" {
+		t.Errorf("key = %q, want c,d.log", rows[2][0])
+	}
+	if media := store.written["inventory/inventory/media/2026-10-16.csv"]; strings.Count(media, "\n") != 1 {
+		t.Errorf("empty bucket inventory = %q, want the header only", media)
+	}
+
+	if _, err := NewWriter(store, store, "", "").Run(context.Background(), nil); !errors.Is(err, ErrNoBucket) {
+		t.Errorf("Run() without a bucket error = %v, want ErrNoBucket", err)
+	}
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"time"
+)
+
+// State is the lifecycle state of a job
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Finished reports whether a job in this state will not run again
+func (s State) Finished() bool {
+	return s == StateCompleted || s == StateFailed || s == StateCancelled
+}
+
+// Well-known job types
+const (
+	TypePurge        = "purge"
+	TypeDecommission = "decommission"
+	TypeRebalance    = "rebalance"
+	TypeRewrap       = "rewrap"
+	TypeBootstrap    = "bootstrap"
+	TypeExport       = "export"
+	TypeReport       = "report"
+	TypeScrub        = "scrub"
+	TypeCompaction   = "compaction"
+	TypeLifecycle    = "lifecycle"
+	TypeInventory    = "inventory"
+	TypeResync       = "resync"
+)
+
+// Progress describes how far a job has got. Units are job-specific; most
+// jobs count objects in Total/Done and payload bytes in Bytes.
+type Progress struct {
+	Total   int64  `json:"total"`
+	Done    int64  `json:"done"`
+	Bytes   int64  `json:"bytes"`
+	Message string `json:"message,omitempty"`
+}
+
+// Job is the persisted record of a background job
+type Job struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	Key        string            `json:"key,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	State      State             `json:"state"`
+	Progress   Progress          `json:"progress"`
+	Error      string            `json:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+}
+
+// Spec describes a job to submit
+type Spec struct {
+	// Type identifies the kind of work, e.g. TypePurge
+	Type string
+	// Key scopes mutual exclusion: only one unfinished job may exist per
+	// Type and Key (for example one purge per bucket). Empty disables it.
+	Key    string
+	Params map[string]string
+}
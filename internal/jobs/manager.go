@@ -0,0 +1,407 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+var (
+	// ErrNotFound is returned when a job ID is unknown
+	ErrNotFound = errors.New("job not found")
+	// ErrDuplicate is returned when an unfinished job with the same type and key exists
+	ErrDuplicate = errors.New("job already in progress")
+	// ErrQueueFull is returned when the job queue cannot accept more work
+	ErrQueueFull = errors.New("job queue full")
+	// ErrFinished is returned when cancelling a job that already finished
+	ErrFinished = errors.New("job already finished")
+)
+
+// RunFunc performs the work of a job. It must return promptly once ctx is
+// cancelled and should report progress through the handle.
+type RunFunc func(ctx context.Context, h *Handle) error
+
+// Config holds job manager settings
+type Config struct {
+	Workers         int           // Number of jobs run concurrently
+	QueueSize       int           // Maximum number of queued jobs
+	Retention       int           // Finished jobs kept for status queries
+	PersistInterval time.Duration // How often progress of running jobs is saved
+}
+
+// DefaultConfig returns default job manager settings
+func DefaultConfig() Config {
+	return Config{
+		Workers:         2,
+		QueueSize:       100,
+		Retention:       100,
+		PersistInterval: 5 * time.Second,
+	}
+}
+
+// entry is the in-memory state of a job
+type entry struct {
+	job    Job
+	run    RunFunc
+	cancel context.CancelFunc
+	dirty  bool // progress changed since last save
+}
+
+// Manager queues background jobs, runs them on a worker pool and tracks
+// their status. Features such as purge, lifecycle or scrubbing submit work
+// here instead of managing their own goroutines.
+type Manager struct {
+	config   Config
+	store    Store
+	queue    chan string
+	mu       sync.Mutex
+	jobs     map[string]*entry
+	finished []string // IDs in completion order, oldest first
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewManager creates a job manager and loads previously persisted jobs.
+// Jobs that were queued or running when the process stopped are marked
+// failed, since their run functions are not persisted.
+func NewManager(config Config, store Store) (*Manager, error) {
+	defaults := DefaultConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaults.Retention
+	}
+	if config.PersistInterval <= 0 {
+		config.PersistInterval = defaults.PersistInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		config: config,
+		store:  store,
+		queue:  make(chan string, config.QueueSize),
+		jobs:   make(map[string]*entry),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	persisted, err := store.LoadAll()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to load jobs: %w", err)
+	}
+
+	sort.Slice(persisted, func(i, j int) bool {
+		return persisted[i].CreatedAt.Before(persisted[j].CreatedAt)
+	})
+
+	for _, job := range persisted {
+		if !job.State.Finished() {
+			now := time.Now()
+			job.State = StateFailed
+			job.Error = "interrupted by server restart"
+			job.FinishedAt = &now
+			if err := store.Save(job); err != nil {
+				monitoring.Log.Warn("Failed to persist interrupted job",
+					zap.String("job_id", job.ID),
+					zap.Error(err))
+			}
+		}
+		m.jobs[job.ID] = &entry{job: job}
+		m.finished = append(m.finished, job.ID)
+	}
+	m.evictLocked()
+
+	return m, nil
+}
+
+// Start starts the worker pool
+func (m *Manager) Start() {
+	for i := 0; i < m.config.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+
+	m.wg.Add(1)
+	go m.persister()
+
+	monitoring.Log.Info("Job manager started", zap.Int("workers", m.config.Workers))
+}
+
+// Stop cancels running jobs and waits for workers to exit
+func (m *Manager) Stop() {
+	monitoring.Log.Info("Stopping job manager")
+	m.cancel()
+	m.wg.Wait()
+	monitoring.Log.Info("Job manager stopped")
+}
+
+// Submit queues a job for execution
+func (m *Manager) Submit(spec Spec, run RunFunc) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if spec.Key != "" {
+		for _, e := range m.jobs {
+			if e.job.Type == spec.Type && e.job.Key == spec.Key && !e.job.State.Finished() {
+				return Job{}, fmt.Errorf("%s %q: %w (job %s)", spec.Type, spec.Key, ErrDuplicate, e.job.ID)
+			}
+		}
+	}
+
+	job := Job{
+		ID:        uuid.New().String(),
+		Type:      spec.Type,
+		Key:       spec.Key,
+		Params:    spec.Params,
+		State:     StateQueued,
+		CreatedAt: time.Now(),
+	}
+
+	select {
+	case m.queue <- job.ID:
+	default:
+		return Job{}, ErrQueueFull
+	}
+
+	m.jobs[job.ID] = &entry{job: job, run: run}
+	m.saveLocked(m.jobs[job.ID])
+
+	return job, nil
+}
+
+// Get returns a job by ID
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// List returns known jobs, newest first. An empty jobType lists all jobs.
+func (m *Manager) List(jobType string) []Job {
+	m.mu.Lock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, e := range m.jobs {
+		if jobType == "" || e.job.Type == jobType {
+			jobs = append(jobs, e.job)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Cancel cancels a queued or running job. Running jobs stop at their next
+// cancellation check; work already done is not rolled back.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	switch e.job.State {
+	case StateQueued:
+		// The worker skips it when dequeued
+		m.finishLocked(e, StateCancelled, "")
+	case StateRunning:
+		e.cancel()
+	default:
+		return ErrFinished
+	}
+
+	return nil
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			m.runJob(id)
+		}
+	}
+}
+
+func (m *Manager) runJob(id string) {
+	m.mu.Lock()
+	e, ok := m.jobs[id]
+	if !ok || e.job.State != StateQueued {
+		m.mu.Unlock()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+
+	now := time.Now()
+	e.cancel = cancel
+	e.job.State = StateRunning
+	e.job.StartedAt = &now
+	m.saveLocked(e)
+	job := e.job
+	m.mu.Unlock()
+
+	monitoring.Log.Info("Job started",
+		zap.String("job_id", job.ID),
+		zap.String("type", job.Type),
+		zap.String("key", job.Key))
+
+	err := m.safeRun(ctx, e.run, &Handle{manager: m, id: id})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case err == nil:
+		m.finishLocked(e, StateCompleted, "")
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		m.finishLocked(e, StateCancelled, "")
+	default:
+		m.finishLocked(e, StateFailed, err.Error())
+	}
+
+	monitoring.Log.Info("Job finished",
+		zap.String("job_id", e.job.ID),
+		zap.String("type", e.job.Type),
+		zap.String("state", string(e.job.State)),
+		zap.Int64("done", e.job.Progress.Done),
+		zap.Int64("total", e.job.Progress.Total))
+}
+
+// safeRun runs a job function, converting panics into job failures
+func (m *Manager) safeRun(ctx context.Context, run RunFunc, h *Handle) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return run(ctx, h)
+}
+
+// finishLocked records a terminal state. m.mu must be held.
+func (m *Manager) finishLocked(e *entry, state State, errMsg string) {
+	now := time.Now()
+	e.job.State = state
+	e.job.Error = errMsg
+	e.job.FinishedAt = &now
+	e.run = nil
+	m.saveLocked(e)
+
+	m.finished = append(m.finished, e.job.ID)
+	m.evictLocked()
+}
+
+// evictLocked drops the oldest finished jobs beyond the retention limit
+func (m *Manager) evictLocked() {
+	for len(m.finished) > m.config.Retention {
+		id := m.finished[0]
+		m.finished = m.finished[1:]
+		delete(m.jobs, id)
+		if err := m.store.Delete(id); err != nil {
+			monitoring.Log.Warn("Failed to delete expired job", zap.String("job_id", id), zap.Error(err))
+		}
+	}
+}
+
+// saveLocked persists a job. m.mu must be held.
+func (m *Manager) saveLocked(e *entry) {
+	e.dirty = false
+	if err := m.store.Save(e.job); err != nil {
+		monitoring.Log.Warn("Failed to persist job", zap.String("job_id", e.job.ID), zap.Error(err))
+	}
+}
+
+// persister periodically saves progress of running jobs
+func (m *Manager) persister() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.PersistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			for _, e := range m.jobs {
+				if e.dirty {
+					m.saveLocked(e)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// Handle lets a running job report progress
+type Handle struct {
+	manager *Manager
+	id      string
+}
+
+// ID returns the job ID
+func (h *Handle) ID() string {
+	return h.id
+}
+
+// update applies fn to the job's progress
+func (h *Handle) update(fn func(p *Progress)) {
+	h.manager.mu.Lock()
+	defer h.manager.mu.Unlock()
+
+	if e, ok := h.manager.jobs[h.id]; ok {
+		fn(&e.job.Progress)
+		e.dirty = true
+	}
+}
+
+// SetProgress replaces the job's progress
+func (h *Handle) SetProgress(p Progress) {
+	h.update(func(cur *Progress) { *cur = p })
+}
+
+// SetTotal sets the expected amount of work
+func (h *Handle) SetTotal(total int64) {
+	h.update(func(p *Progress) { p.Total = total })
+}
+
+// Add records completed work
+func (h *Handle) Add(done, bytes int64) {
+	h.update(func(p *Progress) {
+		p.Done += done
+		p.Bytes += bytes
+	})
+}
+
+// SetMessage sets a human-readable status message
+func (h *Handle) SetMessage(msg string) {
+	h.update(func(p *Progress) { p.Message = msg })
+}
@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func newTestManager(t *testing.T, store Store) *Manager {
+	m, err := NewManager(Config{Workers: 1, PersistInterval: 10 * time.Millisecond}, store)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	m.Start()
+	t.Cleanup(m.Stop)
+	return m
+}
+
+// waitForState polls until the job reaches a finished state
+func waitForState(t *testing.T, m *Manager, id string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := m.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.State.Finished() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish in time", id)
+	return Job{}
+}
+
+func TestManager_SubmitAndComplete(t *testing.T) {
+	m := newTestManager(t, NewMemoryStore())
+
+	job, err := m.Submit(Spec{Type: "test"}, func(ctx context.Context, h *Handle) error {
+		h.SetTotal(3)
+		h.Add(3, 30)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	done := waitForState(t, m, job.ID)
+	if done.State != StateCompleted {
+		t.Errorf("State = %s, want %s", done.State, StateCompleted)
+	}
+	if done.Progress.Done != 3 || done.Progress.Bytes != 30 {
+		t.Errorf("Progress = %+v, want done=3 bytes=30", done.Progress)
+	}
+	if done.StartedAt == nil || done.FinishedAt == nil {
+		t.Error("StartedAt/FinishedAt not set")
+	}
+}
+
+func TestManager_Failure(t *testing.T) {
+	m := newTestManager(t, NewMemoryStore())
+
+	job, _ := m.Submit(Spec{Type: "test"}, func(ctx context.Context, h *Handle) error {
+		return errors.New("boom")
+	})
+
+	done := waitForState(t, m, job.ID)
+	if done.State != StateFailed || done.Error != "boom" {
+		t.Errorf("job = %+v, want failed with error boom", done)
+	}
+
+	job, _ = m.Submit(Spec{Type: "test"}, func(ctx context.Context, h *Handle) error {
+		panic("bad job")
+	})
+
+	done = waitForState(t, m, job.ID)
+	if done.State != StateFailed {
+		t.Errorf("panicking job State = %s, want %s", done.State, StateFailed)
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	m := newTestManager(t, NewMemoryStore())
+
+	started := make(chan struct{})
+	job, _ := m.Submit(Spec{Type: "test", Key: "bucket"}, func(ctx context.Context, h *Handle) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+
+	// Same type and key is rejected while running
+	if _, err := m.Submit(Spec{Type: "test", Key: "bucket"}, nil); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Submit() duplicate error = %v, want %v", err, ErrDuplicate)
+	}
+
+	// Queued behind the running job on the single worker
+	queued, err := m.Submit(Spec{Type: "test"}, func(ctx context.Context, h *Handle) error {
+		t.Error("cancelled queued job should not run")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := m.Cancel(queued.ID); err != nil {
+		t.Fatalf("Cancel(queued) error = %v", err)
+	}
+
+	if err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+
+	done := waitForState(t, m, job.ID)
+	if done.State != StateCancelled {
+		t.Errorf("State = %s, want %s", done.State, StateCancelled)
+	}
+	if err := m.Cancel(job.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("Cancel() finished job error = %v, want %v", err, ErrFinished)
+	}
+	if err := m.Cancel("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel() missing job error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestManager_Persistence(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	// A job left running by a previous process
+	stale := Job{ID: "stale", Type: "test", State: StateRunning, CreatedAt: time.Now()}
+	if err := store.Save(stale); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	m := newTestManager(t, store)
+
+	job, ok := m.Get("stale")
+	if !ok {
+		t.Fatal("persisted job not loaded")
+	}
+	if job.State != StateFailed {
+		t.Errorf("interrupted job State = %s, want %s", job.State, StateFailed)
+	}
+
+	submitted, _ := m.Submit(Spec{Type: "test"}, func(ctx context.Context, h *Handle) error { return nil })
+	waitForState(t, m, submitted.ID)
+
+	persisted, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll() error = %v", err)
+	}
+	if len(persisted) != 2 {
+		t.Errorf("LoadAll() returned %d jobs, want 2", len(persisted))
+	}
+}
+
+func TestManager_Retention(t *testing.T) {
+	m, err := NewManager(Config{Workers: 1, Retention: 2}, NewMemoryStore())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	m.Start()
+	defer m.Stop()
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		job, _ := m.Submit(Spec{Type: "test"}, func(ctx context.Context, h *Handle) error { return nil })
+		waitForState(t, m, job.ID)
+		ids = append(ids, job.ID)
+	}
+
+	if _, ok := m.Get(ids[0]); ok {
+		t.Error("oldest job should have been evicted")
+	}
+	if len(m.List("test")) != 2 {
+		t.Errorf("List() returned %d jobs, want 2", len(m.List("test")))
+	}
+}
@@ -2,18 +2,57 @@ package lifecycle
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
 )
 
+// Buckets lists the buckets whose rules are evaluated
+type Buckets interface {
+	ListBuckets(ctx context.Context, owner string) ([]*bucket.Bucket, error)
+}
+
+// Objects walks and deletes the objects rules apply to
+type Objects interface {
+	WalkObjects(ctx context.Context, bucket, prefix, startAfter string, fn func(*object.Object) error) error
+	DeleteObject(ctx context.Context, bucket, key string) error
+}
+
+// Summary totals an evaluation pass
+type Summary struct {
+	Buckets      int   `json:"buckets"`       // Buckets with enabled rules
+	Objects      int64 `json:"objects"`       // Objects checked against them
+	Expired      int64 `json:"expired"`       // Objects deleted
+	ExpiredBytes int64 `json:"expired_bytes"` // Their size
+	Failed       int64 `json:"failed"`        // Objects that could not be deleted
+}
+
+// Observer is notified after every object of a pass
+type Observer func(summary Summary)
+
 // Executor handles lifecycle policy execution
 type Executor struct {
+	buckets  Buckets
+	objects  Objects
 	interval time.Duration
+	now      func() time.Time
 }
 
 // NewExecutor creates a new lifecycle executor
-func NewExecutor(interval time.Duration) *Executor {
+func NewExecutor(buckets Buckets, objects Objects, interval time.Duration) *Executor {
 	return &Executor{
+		buckets:  buckets,
+		objects:  objects,
 		interval: interval,
+		now:      time.Now,
 	}
 }
 
@@ -24,7 +63,9 @@ func (e *Executor) Start(ctx context.Context) {
 		for {
 			select {
 			case <-ticker.C:
-				e.run()
+				if _, err := e.Run(ctx, nil); err != nil && ctx.Err() == nil {
+					monitoring.Log.Error("Lifecycle evaluation failed", zap.Error(err))
+				}
 			case <-ctx.Done():
 				ticker.Stop()
 				return
@@ -33,6 +74,116 @@ func (e *Executor) Start(ctx context.Context) {
 	}()
 }
 
-func (e *Executor) run() {
-	// Evaluate rules
+// Run performs a single evaluation pass, for callers that schedule the
+// executor externally instead of using Start. Objects past the expiration
+// of an enabled rule of their bucket are deleted as clients delete them,
+// so versioned buckets keep them behind a delete marker. Objects that
+// cannot be deleted are logged and counted, and the pass goes on.
+func (e *Executor) Run(ctx context.Context, observer Observer) (Summary, error) {
+	var summary Summary
+	buckets, err := e.buckets.ListBuckets(ctx, "")
+	if err != nil {
+		return summary, err
+	}
+
+	now := e.now()
+	for _, b := range buckets {
+		rules := RulesOf(b)
+		if len(rules) == 0 {
+			continue
+		}
+		summary.Buckets++
+		if err := e.evaluate(ctx, b.Name, rules, now, &summary, observer); err != nil {
+			return summary, fmt.Errorf("failed to evaluate the rules of bucket %s: %w", b.Name, err)
+		}
+	}
+
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d of %d expired objects were not deleted", summary.Failed, summary.Expired+summary.Failed)
+	}
+	return summary, nil
+}
+
+// evaluate applies the rules of one bucket, walking only the prefixes
+// they cover
+func (e *Executor) evaluate(ctx context.Context, bucketName string, rules []Rule, now time.Time, summary *Summary, observer Observer) error {
+	for _, prefix := range prefixes(rules) {
+		err := e.objects.WalkObjects(ctx, bucketName, prefix, "", func(obj *object.Object) error {
+			if obj.DeleteMarker {
+				return nil
+			}
+			summary.Objects++
+			if expired(rules, obj, now) {
+				switch err := e.objects.DeleteObject(ctx, bucketName, obj.Key); {
+				case err == nil:
+					summary.Expired++
+					summary.ExpiredBytes += obj.Size
+				case ctx.Err() != nil:
+					return ctx.Err()
+				case errors.Is(err, object.ErrObjectNotFound):
+					// Deleted meanwhile
+				default:
+					summary.Failed++
+					monitoring.Log.Warn("Failed to delete expired object",
+						zap.String("bucket", bucketName),
+						zap.String("key", obj.Key),
+						zap.Error(err))
+				}
+			}
+			if observer != nil {
+				observer(*summary)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RulesOf returns the enabled rules of a bucket that expire objects
+func RulesOf(b *bucket.Bucket) []Rule {
+	var rules []Rule
+	for _, r := range b.Lifecycle {
+		if r.Status != StatusEnabled || r.ExpirationDays <= 0 {
+			continue
+		}
+		rules = append(rules, Rule{
+			ID:         r.ID,
+			Status:     r.Status,
+			Filter:     Filter{Prefix: r.Prefix},
+			Expiration: &Expiration{Days: r.ExpirationDays},
+		})
+	}
+	return rules
+}
+
+// prefixes returns the prefixes to walk for rules, dropping those another
+// one covers
+func prefixes(rules []Rule) []string {
+	var out []string
+	for _, r := range rules {
+		covered := false
+		for _, other := range rules {
+			if other.Filter.Prefix != r.Filter.Prefix && strings.HasPrefix(r.Filter.Prefix, other.Filter.Prefix) {
+				covered = true
+				break
+			}
+		}
+		if !covered && !slices.Contains(out, r.Filter.Prefix) {
+			out = append(out, r.Filter.Prefix)
+		}
+	}
+	return out
+}
+
+// expired reports whether any of rules expires obj at now
+func expired(rules []Rule, obj *object.Object, now time.Time) bool {
+	for _, r := range rules {
+		if r.Expires(obj.Key, obj.ModifiedAt, now) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,83 @@
+package lifecycle
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/bucket"
+	"github.com/danielino/comio/internal/object"
+)
+
+type fakeStore struct {
+	buckets []*bucket.Bucket
+	objects map[string][]*object.Object
+	deleted []string
+}
+
+func (f *fakeStore) ListBuckets(ctx context.Context, owner string) ([]*bucket.Bucket, error) {
+	return f.buckets, nil
+}
+
+func (f *fakeStore) WalkObjects(ctx context.Context, bucket, prefix, startAfter string, fn func(*object.Object) error) error {
+	for _, obj := range f.objects[bucket] {
+		if !strings.HasPrefix(obj.Key, prefix) {
+			continue
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) DeleteObject(ctx context.Context, bucket, key string) error {
+	f.deleted = append(f.deleted, bucket+"/"+key)
+	return nil
+}
+
+func TestExecutor_Run(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	old, recent := now.AddDate(0, 0, -31), now.AddDate(0, 0, -29)
+	store := &fakeStore{
+		buckets: []*bucket.Bucket{
+			{Name: "logs", Lifecycle: []bucket.LifecycleRule{
+				{ID: "expire-app", Status: StatusEnabled, Prefix: "app/", ExpirationDays: 30},
+				{ID: "expire-all", Status: StatusDisabled, ExpirationDays: 1},
+			}},
+			{Name: "photos"},
+		},
+		objects: map[string][]*object.Object{
+			"logs": {
+				{Key: "app/old.log", Size: 10, ModifiedAt: old},
+				{Key: "app/new.log", Size: 10, ModifiedAt: recent},
+				{Key: "app/gone.log", DeleteMarker: true, ModifiedAt: old},
+				{Key: "db/old.log", Size: 10, ModifiedAt: old},
+			},
+			"photos": {{Key: "app/old.jpg", ModifiedAt: old}},
+		},
+	}
+	executor := NewExecutor(store, store, time.Hour)
+	executor.now = func() time.Time { return now }
+
+	var observed Summary
+	summary, err := executor.Run(context.Background(), func(s Summary) { observed = s })
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(store.deleted) != 1 || store.deleted[0] != "logs/app/old.log" {
+		t.Errorf("deleted = %v, want only logs/app/old.log", store.deleted)
+	}
+	want := Summary{Buckets: 1, Objects: 2, Expired: 1, ExpiredBytes: 10}
+	if summary != want || observed != want {
+		t.Errorf("Run() = %+v, observed %+v, want %+v", summary, observed, want)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	rules := []Rule{{Filter: Filter{Prefix: "logs/app/"}}, {Filter: Filter{Prefix: "logs/"}}, {Filter: Filter{Prefix: "tmp/"}}, {Filter: Filter{Prefix: "tmp/"}}}
+	if got := strings.Join(prefixes(rules), ","); got != "logs/,tmp/" {
+		t.Errorf("prefixes() = %q, want logs/,tmp/", got)
+	}
+}
@@ -1,5 +1,16 @@
 package lifecycle
 
+import (
+	"strings"
+	"time"
+)
+
+// Rule statuses
+const (
+	StatusEnabled  = "Enabled"
+	StatusDisabled = "Disabled"
+)
+
 // Rule represents a lifecycle rule
 type Rule struct {
 	ID                 string
@@ -26,3 +37,16 @@ type Expiration struct {
 type NoncurrentVersionExpiration struct {
 	NoncurrentDays int
 }
+
+// Expires reports whether the rule expires an object under key last
+// written at modified, as of now. Disabled rules and rules without an
+// expiration expire nothing.
+func (r Rule) Expires(key string, modified, now time.Time) bool {
+	if r.Status != StatusEnabled || r.Expiration == nil || r.Expiration.Days <= 0 {
+		return false
+	}
+	if !strings.HasPrefix(key, r.Filter.Prefix) {
+		return false
+	}
+	return !now.Before(modified.AddDate(0, 0, r.Expiration.Days))
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package metafile
+
+import "os"
+
+func openFile(name string) (File, error) { return os.Open(name) }
+
+func rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
@@ -0,0 +1,47 @@
+package metafile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// openFile opens a file, or a directory whose Sync does nothing: Windows
+// cannot flush directory handles, and NTFS journals renames and removals
+// itself
+func openFile(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return dirFile{f}, nil
+	}
+	return f, nil
+}
+
+type dirFile struct{ *os.File }
+
+func (dirFile) Sync() error { return nil }
+
+// errSharingViolation is ERROR_SHARING_VIOLATION
+const errSharingViolation = syscall.Errno(32)
+
+// renameAttempts bounds how long rename waits for a reader of the target
+const renameAttempts = 10
+
+// rename replaces newpath like os.Rename. A file open elsewhere, by a
+// concurrent reader or a virus scanner, cannot be replaced on Windows, so
+// the rename is retried briefly while the target is in use.
+func rename(oldpath, newpath string) error {
+	var err error
+	for attempt := 0; attempt < renameAttempts; attempt++ {
+		err = os.Rename(oldpath, newpath)
+		if err == nil || !(errors.Is(err, syscall.ERROR_ACCESS_DENIED) || errors.Is(err, errSharingViolation)) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt+1) * 10 * time.Millisecond)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+// Package metafile encodes the JSON metadata files of the file
+// repositories. Files are written in a canonical form, with sorted keys and
+// two-space indentation, so the same metadata always gives the same bytes.
+// Each file records its schema version; files written by older versions are
+// upgraded when they are read. Each file also carries a CRC-32C of its
+// content, verified on read, so corruption is detected instead of being
+// decoded as valid metadata.
+package metafile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+)
+
+// VersionField is the key holding a file's schema version. Files written
+// before schema versions were recorded have none and are version 1.
+const VersionField = "schema_version"
+
+// ChecksumField is the key holding a file's CRC-32C, in hex. It is computed
+// over the file with the value replaced by zeros.
+const ChecksumField = "file_crc32c"
+
+// ErrUnsupportedVersion is returned for files written by a newer schema
+// version than this build knows
+var ErrUnsupportedVersion = errors.New("unsupported metadata schema version")
+
+// ErrCorrupt is returned for files that fail their checksum or are not
+// valid metadata
+var ErrCorrupt = errors.New("corrupt metadata file")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumPlaceholder is the value the checksum is computed with
+const checksumPlaceholder = `"00000000"`
+
+// checksumLine finds the checksum field. Only top-level fields are
+// indented by exactly two spaces, and strings cannot hold a raw newline,
+// so the match is unique.
+var checksumLine = []byte("\n  \"" + ChecksumField + "\": ")
+
+// Upgrade converts the fields of a file from one schema version to the next
+type Upgrade func(fields map[string]json.RawMessage) error
+
+// Format is a versioned metadata file format. Upgrades[i] converts version
+// i+1 to version i+2, so a format with n upgrades writes version n+1.
+type Format struct {
+	Upgrades []Upgrade
+}
+
+// Version returns the schema version files are written with
+func (f Format) Version() int {
+	return len(f.Upgrades) + 1
+}
+
+// Marshal encodes v, which must encode as a JSON object, as a file of the
+// current schema version
+func (f Format) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("metadata is not a JSON object: %w", err)
+	}
+	fields[VersionField] = json.RawMessage(strconv.Itoa(f.Version()))
+	fields[ChecksumField] = json.RawMessage(checksumPlaceholder)
+
+	// Map keys are encoded in sorted order
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+
+	sum := fmt.Sprintf(`"%08x"`, crc32.Checksum(data, castagnoli))
+	i := bytes.Index(data, checksumLine) + len(checksumLine)
+	copy(data[i:], sum)
+	return data, nil
+}
+
+// verify checks the checksum of a file. Files of versions before the
+// current one may have none.
+func (f Format) verify(data []byte, version int, checksum *string) error {
+	if checksum == nil {
+		if version < f.Version() {
+			return nil
+		}
+		return fmt.Errorf("%w: no checksum", ErrCorrupt)
+	}
+
+	i := bytes.Index(data, checksumLine) + len(checksumLine)
+	if i < len(checksumLine) || len(data) < i+len(checksumPlaceholder) {
+		return fmt.Errorf("%w: checksum not in canonical form", ErrCorrupt)
+	}
+	zeroed := bytes.Clone(data)
+	copy(zeroed[i:], checksumPlaceholder)
+	if sum := fmt.Sprintf("%08x", crc32.Checksum(zeroed, castagnoli)); sum != *checksum {
+		return fmt.Errorf("%w: checksum is %s, want %s", ErrCorrupt, sum, *checksum)
+	}
+	return nil
+}
+
+// Unmarshal decodes a file into v, upgrading it first if it was written
+// with an older schema version. It reports whether the file needs
+// rewriting to be current. Files that cannot be decoded fail with
+// ErrCorrupt.
+func (f Format) Unmarshal(data []byte, v any) (bool, error) {
+	var header struct {
+		Version  *int    `json:"schema_version"`
+		Checksum *string `json:"file_crc32c"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	version := 1
+	if header.Version != nil {
+		version = *header.Version
+	}
+	if version < 1 || version > f.Version() {
+		return false, fmt.Errorf("%w: %d (this build reads up to %d)", ErrUnsupportedVersion, version, f.Version())
+	}
+	if err := f.verify(data, version, header.Checksum); err != nil {
+		return false, err
+	}
+	if version == f.Version() {
+		return false, decode(data, v)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	for ; version < f.Version(); version++ {
+		if err := f.Upgrades[version-1](fields); err != nil {
+			return false, fmt.Errorf("failed to upgrade metadata from schema version %d: %w", version, err)
+		}
+	}
+	delete(fields, VersionField)
+	delete(fields, ChecksumField)
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return false, err
+	}
+	return true, decode(data, v)
+}
+
+// decode unmarshals a verified file, which is corrupt if it does not match
+// the type it holds
+func decode(data []byte, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return nil
+}
+
+// UpgradeResult reports the work done by a batch metadata upgrade
+type UpgradeResult struct {
+	Scanned  int      `json:"scanned"`
+	Upgraded int      `json:"upgraded"`          // Rewritten, or to be rewritten on a dry run
+	Invalid  []string `json:"invalid,omitempty"` // Files that could not be decoded
+}
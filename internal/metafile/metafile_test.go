@@ -0,0 +1,152 @@
+package metafile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+type record struct {
+	Name  string            `json:"name"`
+	Size  int64             `json:"size"`
+	Class string            `json:"class"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+var testFormat = Format{
+	Upgrades: []Upgrade{
+		// Version 2 renamed "length" to "size"
+		func(fields map[string]json.RawMessage) error {
+			if length, ok := fields["length"]; ok {
+				fields["size"] = length
+				delete(fields, "length")
+			}
+			return nil
+		},
+		// Version 3 added the class, "standard" before
+		func(fields map[string]json.RawMessage) error {
+			fields["class"] = json.RawMessage(`"standard"`)
+			return nil
+		},
+	},
+}
+
+func TestFormat_MarshalIsCanonical(t *testing.T) {
+	data, err := testFormat.Marshal(record{Name: "a", Size: 3, Class: "cold", Tags: map[string]string{"z": "1", "a": "2"}})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `{
+  "class": "cold",
+  "file_crc32c": "d80a8c50",
+  "name": "a",
+  "schema_version": 3,
+  "size": 3,
+  "tags": {
+    "a": "2",
+    "z": "1"
+  }
+}
+`
+	if string(data) != want {
+		t.Errorf("Marshal() =\n%s\nwant\n%s", data, want)
+	}
+
+	var got record
+	upgraded, err := testFormat.Unmarshal(data, &got)
+	if err != nil || upgraded {
+		t.Fatalf("Unmarshal() = %v, %v, want a current file", upgraded, err)
+	}
+	again, _ := testFormat.Marshal(got)
+	if string(again) != string(data) {
+		t.Errorf("round trip changed the file:\n%s", again)
+	}
+}
+
+func TestFormat_UnmarshalUpgrades(t *testing.T) {
+	tests := map[string]string{
+		"unversioned": `{"name": "a", "length": 7}`,
+		"version 2":   `{"name": "a", "size": 7, "schema_version": 2}`,
+	}
+	for name, data := range tests {
+		var got record
+		upgraded, err := testFormat.Unmarshal([]byte(data), &got)
+		if err != nil || !upgraded {
+			t.Errorf("%s: Unmarshal() = %v, %v, want an upgraded file", name, upgraded, err)
+			continue
+		}
+		if got.Name != "a" || got.Size != 7 || got.Class != "standard" {
+			t.Errorf("%s: Unmarshal() = %+v", name, got)
+		}
+	}
+}
+
+func TestFormat_UnmarshalRejectsNewerVersions(t *testing.T) {
+	var got record
+	_, err := testFormat.Unmarshal([]byte(`{"name": "a", "schema_version": 4}`), &got)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Unmarshal() error = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestFormat_UnmarshalDetectsCorruption(t *testing.T) {
+	data, err := testFormat.Marshal(record{Name: "photo.jpg", Size: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]byte{
+		"changed value": bytes.Replace(data, []byte("1024"), []byte("1025"), 1),
+		"truncated":     data[:len(data)/2],
+		"empty":         {},
+		"no checksum":   bytes.Replace(data, []byte(ChecksumField), []byte("other_field"), 1),
+	}
+	for name, corrupt := range tests {
+		var got record
+		if _, err := testFormat.Unmarshal(corrupt, &got); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: Unmarshal() error = %v, want ErrCorrupt", name, err)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "objects", "b", "key.meta")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q := NewQuarantine(dir)
+
+	// A file rewritten since it was read is left alone
+	if err := q.Add(path, []byte("older"), ErrCorrupt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("rewritten file was moved: %v", err)
+	}
+
+	if err := q.Add(path, []byte("{"), ErrCorrupt); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still in place: %v", err)
+	}
+	files, err := q.List()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("List() = %+v, %v", files, err)
+	}
+	if want := filepath.Join("objects", "b", "key.meta"); files[0].Path != want || files[0].Size != 1 {
+		t.Errorf("quarantined file = %+v, want path %s", files[0], want)
+	}
+}
@@ -0,0 +1,116 @@
+package metafile
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// QuarantineDir is the directory, under the metadata directory, corrupt
+// files are moved to
+const QuarantineDir = "quarantine"
+
+// Quarantine moves corrupt metadata files out of a metadata directory, so
+// they stop being read but are kept for inspection. A quarantined file keeps
+// its path relative to the metadata directory, with the time it was moved
+// appended.
+type Quarantine struct {
+	metadataDir string
+}
+
+// QuarantinedFile is a file found corrupt
+type QuarantinedFile struct {
+	Path          string    `json:"path"` // Original path, relative to the metadata directory
+	File          string    `json:"file"` // Where the file is now
+	Size          int64     `json:"size"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// NewQuarantine creates the quarantine of a metadata directory
+func NewQuarantine(metadataDir string) *Quarantine {
+	return &Quarantine{metadataDir: metadataDir}
+}
+
+// Add quarantines the file at path, which was found corrupt with the
+// content data. A file that has changed since was rewritten in the meantime
+// and is left in place.
+func (q *Quarantine) Add(path string, data []byte, cause error) error {
+	current, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(current, data) {
+		return nil
+	}
+
+	rel, err := filepath.Rel(q.metadataDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s is not in metadata directory %s", path, q.metadataDir)
+	}
+	now := time.Now()
+	target := filepath.Join(q.metadataDir, QuarantineDir, rel+"."+strconv.FormatInt(now.UnixNano(), 10))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", path, err)
+	}
+
+	monitoring.Log.Error("Quarantined corrupt metadata file",
+		zap.String("path", path),
+		zap.String("quarantined_as", target),
+		zap.Error(cause))
+	return nil
+}
+
+// List returns the quarantined files, oldest first
+func (q *Quarantine) List() ([]QuarantinedFile, error) {
+	root := filepath.Join(q.metadataDir, QuarantineDir)
+	var files []QuarantinedFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(rel)
+		nanos, err := strconv.ParseInt(strings.TrimPrefix(ext, "."), 10, 64)
+		if err != nil {
+			return nil // Not put there by Add
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, QuarantinedFile{
+			Path:          strings.TrimSuffix(rel, ext),
+			File:          path,
+			Size:          info.Size(),
+			QuarantinedAt: time.Unix(0, nanos),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined metadata: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].QuarantinedAt.Before(files[j].QuarantinedAt)
+	})
+	return files, nil
+}
@@ -0,0 +1,193 @@
+package metafile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Durability is how much of a metadata write is synced to disk before the
+// write is reported done
+type Durability int
+
+const (
+	// DurabilityNone only renames the new file into place. A power loss can
+	// lose recent writes, or leave an empty file behind.
+	DurabilityNone Durability = iota
+	// DurabilityFile syncs the file's content before the rename, so a file
+	// is never replaced by a partial one, but the rename itself can still
+	// be lost.
+	DurabilityFile
+	// DurabilityFull also syncs the directory after the rename, so a write
+	// survives a power loss once it is reported done
+	DurabilityFull
+)
+
+// ParseDurability parses the none, file and full durability levels
+func ParseDurability(s string) (Durability, error) {
+	switch s {
+	case "none":
+		return DurabilityNone, nil
+	case "file":
+		return DurabilityFile, nil
+	case "full", "":
+		return DurabilityFull, nil
+	}
+	return 0, fmt.Errorf("unknown durability %q, want none, file or full", s)
+}
+
+// File is a file opened by an FS
+type File interface {
+	io.Writer
+	Name() string
+	Sync() error
+	Close() error
+}
+
+// FS holds the filesystem operations metadata files are committed with.
+// Tests replace it to inject faults.
+type FS interface {
+	CreateTemp(dir, pattern string) (File, error)
+	Open(name string) (File, error)
+	Chmod(name string, mode os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Mkdir(name string, perm os.FileMode) error
+	Stat(name string) (os.FileInfo, error)
+}
+
+// OSFS is the FS of the operating system
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) CreateTemp(dir, pattern string) (File, error) { return os.CreateTemp(dir, pattern) }
+func (osFS) Open(name string) (File, error)               { return openFile(name) }
+func (osFS) Chmod(name string, mode os.FileMode) error    { return os.Chmod(name, mode) }
+func (osFS) Rename(oldpath, newpath string) error         { return rename(oldpath, newpath) }
+func (osFS) Remove(name string) error                     { return os.Remove(name) }
+func (osFS) Mkdir(name string, perm os.FileMode) error    { return os.Mkdir(name, perm) }
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+
+// Writer replaces metadata files atomically: the new content is written to
+// a temporary file next to the target, then renamed over it
+type Writer struct {
+	FS         FS
+	Durability Durability
+}
+
+// NewWriter creates a writer on the operating system's filesystem
+func NewWriter(durability Durability) *Writer {
+	return &Writer{FS: OSFS, Durability: durability}
+}
+
+// WriteTemp writes data to a new temporary file next to path, for Commit to
+// rename into place. Each call gets its own file, so concurrent writes of a
+// path cannot clobber each other; the name starts with ".put-" so listings
+// looking for metadata suffixes skip it.
+func (w *Writer) WriteTemp(path string, data []byte) (string, error) {
+	temp, err := w.FS.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create metadata file: %w", err)
+	}
+	tempPath := temp.Name()
+	_, err = temp.Write(data)
+	if err == nil && w.Durability >= DurabilityFile {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = w.FS.Chmod(tempPath, 0644)
+	}
+	if err != nil {
+		w.FS.Remove(tempPath)
+		return "", fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return tempPath, nil
+}
+
+// Commit atomically replaces path with a file from WriteTemp. With
+// DurabilityFull the directory is synced, so the rename is on disk when
+// Commit returns; if that fails the new file is in place but may not
+// survive a power loss.
+func (w *Writer) Commit(tempPath, path string) error {
+	if err := w.FS.Rename(tempPath, path); err != nil {
+		w.FS.Remove(tempPath)
+		return fmt.Errorf("failed to rename metadata file: %w", err)
+	}
+	if w.Durability < DurabilityFull {
+		return nil
+	}
+	if err := w.SyncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to sync metadata directory: %w", err)
+	}
+	return nil
+}
+
+// WriteFile atomically replaces path with data
+func (w *Writer) WriteFile(path string, data []byte) error {
+	tempPath, err := w.WriteTemp(path, data)
+	if err != nil {
+		return err
+	}
+	return w.Commit(tempPath, path)
+}
+
+// Remove deletes the file at path. With DurabilityFull the directory is
+// synced, so the file does not reappear after a power loss.
+func (w *Writer) Remove(path string) error {
+	if err := w.FS.Remove(path); err != nil {
+		return err
+	}
+	if w.Durability < DurabilityFull {
+		return nil
+	}
+	if err := w.SyncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to sync metadata directory: %w", err)
+	}
+	return nil
+}
+
+// MkdirAll creates dir and any missing parents. With DurabilityFull the
+// parent of each directory created is synced, so files later committed in
+// dir are not lost with a directory entry that never reached the disk.
+func (w *Writer) MkdirAll(dir string) error {
+	if _, err := w.FS.Stat(dir); err == nil {
+		return nil
+	}
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := w.MkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := w.FS.Mkdir(dir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil // Created concurrently
+		}
+		return err
+	}
+	if w.Durability < DurabilityFull {
+		return nil
+	}
+	if err := w.SyncDir(parent); err != nil {
+		return fmt.Errorf("failed to sync metadata directory: %w", err)
+	}
+	return nil
+}
+
+// SyncDir syncs a directory, making the renames and removals in it durable
+func (w *Writer) SyncDir(dir string) error {
+	d, err := w.FS.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package metafile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var errInjected = errors.New("injected fault")
+
+// faultFS records the operations done through it and fails the one named
+// in fail
+type faultFS struct {
+	FS
+	ops  []string
+	fail string
+}
+
+func (f *faultFS) do(op string) error {
+	f.ops = append(f.ops, op)
+	if op == f.fail {
+		return errInjected
+	}
+	return nil
+}
+
+func (f *faultFS) CreateTemp(dir, pattern string) (File, error) {
+	file, err := f.FS.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, kind: "file"}, nil
+}
+
+func (f *faultFS) Open(name string) (File, error) {
+	file, err := f.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: f, kind: "dir"}, nil
+}
+
+func (f *faultFS) Rename(oldpath, newpath string) error {
+	if err := f.do("rename"); err != nil {
+		return err
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+func (f *faultFS) Remove(name string) error {
+	if err := f.do("remove " + filepath.Base(name)); err != nil {
+		return err
+	}
+	return f.FS.Remove(name)
+}
+
+func (f *faultFS) Mkdir(name string, perm os.FileMode) error {
+	if err := f.do("mkdir " + filepath.Base(name)); err != nil {
+		return err
+	}
+	return f.FS.Mkdir(name, perm)
+}
+
+type faultFile struct {
+	File
+	fs   *faultFS
+	kind string
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.fs.do("write"); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.do("sync " + f.kind); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+// userOps drops the removal of temp files from ops
+func userOps(ops []string) string {
+	var kept []string
+	for _, op := range ops {
+		if !strings.HasPrefix(op, "remove .put-") {
+			kept = append(kept, op)
+		}
+	}
+	return strings.Join(kept, ", ")
+}
+
+func TestWriter_Durability(t *testing.T) {
+	tests := []struct {
+		durability Durability
+		want       string
+	}{
+		{DurabilityNone, "write, rename"},
+		{DurabilityFile, "write, sync file, rename"},
+		{DurabilityFull, "write, sync file, rename, sync dir"},
+	}
+	for _, tt := range tests {
+		fs := &faultFS{FS: OSFS}
+		w := &Writer{FS: fs, Durability: tt.durability}
+		path := filepath.Join(t.TempDir(), "a.json")
+		if err := w.WriteFile(path, []byte("{}")); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if got := userOps(fs.ops); got != tt.want {
+			t.Errorf("durability %d: ops = %s, want %s", tt.durability, got, tt.want)
+		}
+	}
+}
+
+func TestWriter_Faults(t *testing.T) {
+	for _, op := range []string{"write", "sync file", "rename", "sync dir"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.json")
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		fs := &faultFS{FS: OSFS, fail: op}
+		w := &Writer{FS: fs, Durability: DurabilityFull}
+		err := w.WriteFile(path, []byte("new"))
+		if !errors.Is(err, errInjected) {
+			t.Errorf("%s fault: WriteFile() error = %v, want the fault", op, err)
+		}
+
+		// Only a failed directory sync comes after the file is replaced
+		want := "old"
+		if op == "sync dir" {
+			want = "new"
+		}
+		if data, _ := os.ReadFile(path); string(data) != want {
+			t.Errorf("%s fault: file = %q, want %q", op, data, want)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("%s fault: %d files left, want no temp file", op, len(entries))
+		}
+	}
+}
+
+func TestWriter_MkdirAllSyncsParents(t *testing.T) {
+	root := t.TempDir()
+	fs := &faultFS{FS: OSFS}
+	w := &Writer{FS: fs, Durability: DurabilityFull}
+	if err := w.MkdirAll(filepath.Join(root, "a", "b")); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if got, want := userOps(fs.ops), "mkdir a, sync dir, mkdir b, sync dir"; got != want {
+		t.Errorf("ops = %s, want %s", got, want)
+	}
+
+	fs.ops = nil
+	if err := w.Remove(filepath.Join(root, "a", "b")); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if got, want := userOps(fs.ops), "remove b, sync dir"; got != want {
+		t.Errorf("ops = %s, want %s", got, want)
+	}
+}
+
+func TestParseDurability(t *testing.T) {
+	for s, want := range map[string]Durability{"none": DurabilityNone, "file": DurabilityFile, "full": DurabilityFull, "": DurabilityFull} {
+		if got, err := ParseDurability(s); err != nil || got != want {
+			t.Errorf("ParseDurability(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseDurability("always"); err == nil {
+		t.Error("ParseDurability(always) succeeded")
+	}
+}
@@ -0,0 +1,106 @@
+package monitoring
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levels holds the log levels in effect: the base level of InitLogger and
+// the overrides of SetModuleLevels
+var levels atomic.Pointer[levelSet]
+
+// minLevel is the lowest level in effect, at which the logger builds
+// entries for moduleCore to filter
+var minLevel = zap.NewAtomicLevel()
+
+type levelSet struct {
+	base    zapcore.Level
+	modules map[string]zapcore.Level
+}
+
+func init() {
+	levels.Store(&levelSet{base: zapcore.InfoLevel})
+}
+
+// SetModuleLevels overrides the log level of modules, the package
+// directories under internal/ such as replication, storage or
+// api/handlers. A module without an override takes the level of its
+// parent directory, and otherwise the level passed to InitLogger.
+func SetModuleLevels(overrides map[string]string) error {
+	modules := make(map[string]zapcore.Level, len(overrides))
+	for module, name := range overrides {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log level %q for %s", name, module)
+		}
+		modules[strings.Trim(strings.ToLower(module), "/")] = level
+	}
+	setLevels(&levelSet{base: levels.Load().base, modules: modules})
+	return nil
+}
+
+func setLevels(set *levelSet) {
+	lowest := set.base
+	for _, level := range set.modules {
+		lowest = min(lowest, level)
+	}
+	levels.Store(set)
+	minLevel.SetLevel(lowest)
+}
+
+// enabled reports whether an entry logged from file at level is written
+func (s *levelSet) enabled(level zapcore.Level, file string) bool {
+	if len(s.modules) == 0 {
+		return level >= s.base
+	}
+	return level >= s.moduleLevel(file)
+}
+
+// moduleLevel returns the level of the module holding a source file
+func (s *levelSet) moduleLevel(file string) zapcore.Level {
+	i := strings.LastIndex(file, "/internal/")
+	if i < 0 {
+		return s.base
+	}
+	for module := path.Dir(file[i+len("/internal/"):]); module != "."; module = path.Dir(module) {
+		if level, ok := s.modules[module]; ok {
+			return level
+		}
+	}
+	return s.base
+}
+
+// moduleCore filters entries by the level of the module logging them.
+// The caller of an entry is only known once the logger has checked it,
+// so entries pass Check at the lowest level in effect and are filtered
+// when written.
+type moduleCore struct {
+	zapcore.Core
+}
+
+func newModuleCore(core zapcore.Core) zapcore.Core {
+	return &moduleCore{Core: core}
+}
+
+func (c *moduleCore) With(fields []zapcore.Field) zapcore.Core {
+	return &moduleCore{Core: c.Core.With(fields)}
+}
+
+func (c *moduleCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *moduleCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if !levels.Load().enabled(ent.Level, ent.Caller.File) {
+		return nil
+	}
+	return c.Core.Write(ent, fields)
+}
@@ -22,7 +22,8 @@ func InitLogger(level, format, output string) error {
 	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
 		zapLevel = zap.InfoLevel
 	}
-	config.Level = zap.NewAtomicLevelAt(zapLevel)
+	setLevels(&levelSet{base: zapLevel, modules: levels.Load().modules})
+	config.Level = minLevel
 
 	// Set output
 	if output == "stdout" {
@@ -33,8 +34,11 @@ func InitLogger(level, format, output string) error {
 		config.OutputPaths = []string{output}
 	}
 
-	// Build logger
-	logger, err := config.Build()
+	// Build logger, redacting credentials at every log site and applying
+	// the levels of modules
+	logger, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return NewRedactingCore(newModuleCore(core))
+	}))
 	if err != nil {
 		return err
 	}
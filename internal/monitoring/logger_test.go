@@ -1,9 +1,13 @@
 package monitoring
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestInitLogger_JSON(t *testing.T) {
@@ -70,3 +74,62 @@ func TestLogger_Usage(t *testing.T) {
 	Log.Info("test message", zap.String("key", "value"))
 	Sync()
 }
+
+func TestSetModuleLevels(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "log")
+	if err := InitLogger("info", "json", output); err != nil {
+		t.Fatal(err)
+	}
+	defer SetModuleLevels(nil)
+
+	// This file is in the monitoring module
+	Log.Debug("debug before")
+	if err := SetModuleLevels(map[string]string{"monitoring": "debug", "storage": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	Log.Debug("debug with override")
+	if err := SetModuleLevels(map[string]string{"Monitoring": "error"}); err != nil {
+		t.Fatal(err)
+	}
+	Log.Info("info below override")
+	Log.Error("error at override")
+	Sync()
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for msg, want := range map[string]bool{
+		"debug before":        false,
+		"debug with override": true,
+		"info below override": false,
+		"error at override":   true,
+	} {
+		if strings.Contains(log, msg) != want {
+			t.Errorf("%q logged = %v, want %v", msg, !want, want)
+		}
+	}
+
+	if err := SetModuleLevels(map[string]string{"replication": "verbose"}); err == nil {
+		t.Error("SetModuleLevels() accepted an invalid level")
+	}
+}
+
+func TestModuleLevel(t *testing.T) {
+	set := &levelSet{
+		base:    zap.InfoLevel,
+		modules: map[string]zapcore.Level{"api": zap.WarnLevel, "api/handlers": zap.DebugLevel},
+	}
+	tests := map[string]zapcore.Level{
+		"/src/comio/internal/api/handlers/object.go":                    zap.DebugLevel,
+		"github.com/danielino/comio/internal/api/middleware/auth.go":    zap.WarnLevel,
+		"github.com/danielino/comio/internal/replication/replicator.go": zap.InfoLevel,
+		"github.com/danielino/comio/cmd/comio/main.go":                  zap.InfoLevel,
+	}
+	for file, want := range tests {
+		if got := set.moduleLevel(file); got != want {
+			t.Errorf("moduleLevel(%s) = %v, want %v", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package monitoring
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// PushFailures counts pushes a sink failed to deliver
+var PushFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "comio_metrics_push_failures_total",
+		Help: "Metrics pushes that failed, by sink",
+	},
+	[]string{"sink"},
+)
+
+func init() {
+	prometheus.MustRegister(PushFailures)
+}
+
+// Label is a name and value distinguishing series of a metric
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is the value of one series at the time of a push
+type Sample struct {
+	Name   string
+	Labels []Label // Sorted by name
+	Value  float64
+	// Cumulative is set for counters and the buckets, sums and counts of
+	// histograms and summaries, whose value only grows until a restart
+	Cumulative bool
+}
+
+// Sink delivers the samples of a push to a monitoring system
+type Sink interface {
+	Push(ctx context.Context, samples []Sample, at time.Time) error
+}
+
+type namedSink struct {
+	name    string
+	sink    Sink
+	failing bool // The last push failed, so the next success is logged
+}
+
+// Pusher periodically gathers the registered metrics and pushes them to
+// sinks, for environments where nothing can scrape the Prometheus
+// endpoint. Sinks are pushed concurrently, so one that is down or slow
+// does not hold back the others.
+type Pusher struct {
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	sinks    []*namedSink
+	now      func() time.Time
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPusher creates a pusher gathering from gatherer every interval
+func NewPusher(gatherer prometheus.Gatherer, interval time.Duration) *Pusher {
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	return &Pusher{gatherer: gatherer, interval: interval, now: time.Now}
+}
+
+// AddSink adds a sink, named in logs and in comio_metrics_push_failures_total.
+// Sinks must be added before Start.
+func (p *Pusher) AddSink(name string, sink Sink) {
+	p.sinks = append(p.sinks, &namedSink{name: name, sink: sink})
+}
+
+// Start starts pushing in the background
+func (p *Pusher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.push(ctx)
+			}
+		}
+	}()
+
+	names := make([]string, len(p.sinks))
+	for i, s := range p.sinks {
+		names[i] = s.name
+	}
+	Log.Info("Metrics push started", zap.Strings("sinks", names), zap.Duration("interval", p.interval))
+}
+
+// Stop stops pushing, after a last push so the counts since the previous
+// one are not lost
+func (p *Pusher) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.push(ctx)
+}
+
+// push gathers the metrics once and pushes them to every sink, each
+// within one interval
+func (p *Pusher) push(ctx context.Context) {
+	families, err := p.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		Log.Warn("Failed to gather metrics to push", zap.Error(err))
+		return
+	}
+	samples := flatten(families)
+	at := p.now()
+
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range p.sinks {
+		wg.Add(1)
+		go func(s *namedSink) {
+			defer wg.Done()
+			if err := s.sink.Push(ctx, samples, at); err != nil {
+				PushFailures.WithLabelValues(s.name).Inc()
+				// Log once per outage rather than on every interval
+				if !s.failing {
+					Log.Warn("Failed to push metrics", zap.String("sink", s.name), zap.Error(err))
+				}
+				s.failing = true
+				return
+			}
+			if s.failing {
+				Log.Info("Metrics push recovered", zap.String("sink", s.name))
+			}
+			s.failing = false
+		}(s)
+	}
+	wg.Wait()
+}
+
+// flatten turns metric families into samples the way the Prometheus text
+// format does: histograms become _bucket, _sum and _count series and
+// summaries quantile, _sum and _count series
+func flatten(families []*dto.MetricFamily) []Sample {
+	var samples []Sample
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			labels := make([]Label, 0, len(m.GetLabel())+1)
+			for _, l := range m.GetLabel() {
+				labels = append(labels, Label{Name: l.GetName(), Value: l.GetValue()})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetCounter().GetValue(), Cumulative: true})
+			case dto.MetricType_GAUGE:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetGauge().GetValue()})
+			case dto.MetricType_UNTYPED:
+				samples = append(samples, Sample{Name: name, Labels: labels, Value: m.GetUntyped().GetValue()})
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				infinite := false
+				for _, b := range h.GetBucket() {
+					infinite = infinite || math.IsInf(b.GetUpperBound(), 1)
+					samples = append(samples, Sample{
+						Name:       name + "_bucket",
+						Labels:     withLabel(labels, "le", formatFloat(b.GetUpperBound())),
+						Value:      float64(b.GetCumulativeCount()),
+						Cumulative: true,
+					})
+				}
+				if !infinite {
+					samples = append(samples, Sample{Name: name + "_bucket", Labels: withLabel(labels, "le", "+Inf"), Value: float64(h.GetSampleCount()), Cumulative: true})
+				}
+				samples = append(samples,
+					Sample{Name: name + "_sum", Labels: labels, Value: h.GetSampleSum(), Cumulative: true},
+					Sample{Name: name + "_count", Labels: labels, Value: float64(h.GetSampleCount()), Cumulative: true})
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					samples = append(samples, Sample{Name: name, Labels: withLabel(labels, "quantile", formatFloat(q.GetQuantile())), Value: q.GetValue()})
+				}
+				samples = append(samples,
+					Sample{Name: name + "_sum", Labels: labels, Value: s.GetSampleSum(), Cumulative: true},
+					Sample{Name: name + "_count", Labels: labels, Value: float64(s.GetSampleCount()), Cumulative: true})
+			}
+		}
+	}
+	return samples
+}
+
+// withLabel returns a copy of sorted labels with one more
+func withLabel(labels []Label, name, value string) []Label {
+	result := make([]Label, 0, len(labels)+1)
+	added := false
+	for _, l := range labels {
+		if !added && name < l.Name {
+			result = append(result, Label{Name: name, Value: value})
+			added = true
+		}
+		result = append(result, l)
+	}
+	if !added {
+		result = append(result, Label{Name: name, Value: value})
+	}
+	return result
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
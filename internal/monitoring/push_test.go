@@ -0,0 +1,377 @@
+package monitoring
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testRegistry(t *testing.T) (*prometheus.Registry, *prometheus.CounterVec) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "h"}, []string{"method", "status"})
+	queue := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_length", Help: "h"})
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "h", Buckets: []float64{0.1, 1}})
+	reg.MustRegister(requests, queue, latency)
+
+	requests.WithLabelValues("GET", "200").Add(5)
+	queue.Set(3)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	return reg, requests
+}
+
+func gather(t *testing.T, reg *prometheus.Registry) []Sample {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flatten(families)
+}
+
+func TestFlatten(t *testing.T) {
+	reg, _ := testRegistry(t)
+	var got []string
+	for _, s := range gather(t, reg) {
+		var labels []string
+		for _, l := range s.Labels {
+			labels = append(labels, l.Name+"="+l.Value)
+		}
+		kind := "gauge"
+		if s.Cumulative {
+			kind = "counter"
+		}
+		got = append(got, s.Name+"{"+strings.Join(labels, ",")+"} "+formatFloat(s.Value)+" "+kind)
+	}
+
+	want := []string{
+		"latency_seconds_bucket{le=0.1} 1 counter",
+		"latency_seconds_bucket{le=1} 2 counter",
+		"latency_seconds_bucket{le=+Inf} 2 counter",
+		"latency_seconds_sum{} 0.55 counter",
+		"latency_seconds_count{} 2 counter",
+		"queue_length{} 3 gauge",
+		"requests_total{method=GET,status=200} 5 counter",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("flatten() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// decodeWriteRequest reads the series of a remote write body, as
+// name{labels} value@timestamp
+func decodeWriteRequest(t *testing.T, body []byte) []string {
+	t.Helper()
+	// Undo the literal-only snappy framing
+	n, size := protowire.ConsumeVarint(body)
+	body = body[size:]
+	var data []byte
+	for len(body) > 0 {
+		if body[0] != 61<<2 {
+			t.Fatalf("unexpected snappy tag %#x", body[0])
+		}
+		length := int(body[1]) | int(body[2])<<8 + 1
+		data = append(data, body[3:3+length]...)
+		body = body[3+length:]
+	}
+	if uint64(len(data)) != n {
+		t.Fatalf("snappy length %d, decoded %d bytes", n, len(data))
+	}
+
+	fields := func(b []byte, each func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal("invalid protobuf tag")
+			}
+			b = b[n:]
+			n = each(num, typ, b)
+			if n < 0 {
+				t.Fatal("invalid protobuf field")
+			}
+			b = b[n:]
+		}
+	}
+	var series []string
+	fields(data, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		var name, value string
+		var labels []string
+		fields(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			if num == 1 {
+				var l [2]string
+				fields(msg, func(num protowire.Number, _ protowire.Type, b []byte) int {
+					s, n := protowire.ConsumeString(b)
+					l[num-1] = s
+					return n
+				})
+				if l[0] == "__name__" {
+					name = l[1]
+				} else {
+					labels = append(labels, l[0]+"="+l[1])
+				}
+			} else {
+				var v float64
+				var ts int64
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						bits, n := protowire.ConsumeFixed64(b)
+						v = math.Float64frombits(bits)
+						return n
+					}
+					x, n := protowire.ConsumeVarint(b)
+					ts = int64(x)
+					return n
+				})
+				value = formatFloat(v) + "@" + time.UnixMilli(ts).UTC().Format("15:04:05")
+			}
+			return n
+		})
+		series = append(series, name+"{"+strings.Join(labels, ",")+"} "+value)
+		return n
+	})
+	return series
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var mu sync.Mutex
+	var received [][]string
+	var statuses []int // Answers to the next requests, then 204
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		if user, pass, _ := r.BasicAuth(); user != "comio" || pass != "secret" {
+			t.Errorf("basic auth = %q, %q", user, pass)
+		}
+		if r.Header.Get("X-Scope-OrgID") != "storage" {
+			t.Errorf("tenant header missing")
+		}
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(statuses) > 0 {
+			status := statuses[0]
+			statuses = statuses[1:]
+			w.WriteHeader(status)
+			return
+		}
+		received = append(received, decodeWriteRequest(t, body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewRemoteWriteSink(RemoteWriteConfig{
+		URL:               server.URL,
+		Username:          "comio",
+		Password:          "secret",
+		Headers:           map[string]string{"X-Scope-OrgID": "storage"},
+		Labels:            map[string]string{"instance": "node-1"},
+		BatchSize:         2,
+		MaxRetries:        1,
+		MaxPendingSamples: 4,
+	})
+	sink.backoff = time.Millisecond
+	samples := []Sample{
+		{Name: "a", Value: 1},
+		{Name: "b", Labels: []Label{{Name: "instance", Value: "node-2"}, {Name: "status", Value: "200"}}, Value: 2, Cumulative: true},
+		{Name: "c", Value: 3},
+	}
+	at := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	reset := func(next ...int) {
+		mu.Lock()
+		defer mu.Unlock()
+		received, statuses = nil, next
+	}
+
+	// A server error is retried; batches hold at most BatchSize series
+	reset(http.StatusServiceUnavailable)
+	if err := sink.Push(ctx, samples, at); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	want := [][]string{
+		{"a{instance=node-1} 1@10:00:00", "b{instance=node-2,status=200} 2@10:00:00"},
+		{"c{instance=node-1} 3@10:00:00"},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("received %v, want %v", received, want)
+	}
+
+	// Samples the endpoint could not take are resent first, and the oldest
+	// are dropped beyond MaxPendingSamples
+	reset(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	if err := sink.Push(ctx, samples, at.Add(time.Minute)); err == nil {
+		t.Fatal("Push() during an outage succeeded")
+	}
+	reset()
+	err := sink.Push(ctx, samples, at.Add(2*time.Minute))
+	if err == nil || !strings.Contains(err.Error(), "dropped 2 samples") {
+		t.Errorf("Push() error = %v, want 2 samples reported dropped", err)
+	}
+	want = [][]string{
+		{"c{instance=node-1} 3@10:01:00", "a{instance=node-1} 1@10:02:00"},
+		{"b{instance=node-2,status=200} 2@10:02:00", "c{instance=node-1} 3@10:02:00"},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("received %v, want %v", received, want)
+	}
+
+	// A refused batch is dropped rather than retried forever
+	reset(http.StatusBadRequest)
+	if err := sink.Push(ctx, samples, at); err == nil {
+		t.Error("Push() refused with 400 succeeded")
+	}
+	if len(received) != 0 {
+		t.Errorf("a refused batch was retried: %v", received)
+	}
+	if err := sink.Push(ctx, nil, at); err != nil || len(received) != 1 || len(received[0]) != 1 {
+		t.Errorf("after a refused batch, Push() = %v sending %v, want the rest", err, received)
+	}
+}
+
+// listenStatsd returns the address of a UDP listener and a function
+// returning the datagrams received so far
+func listenStatsd(t *testing.T) (string, func() []string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn.LocalAddr().String(), func() []string {
+		var packets []string
+		buf := make([]byte, 65536)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return packets
+			}
+			packets = append(packets, string(buf[:n]))
+		}
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	addr, read := listenStatsd(t)
+	reg, requests := testRegistry(t)
+	sink := NewStatsdSink(StatsdConfig{Address: addr, Prefix: "comio", Tags: map[string]string{"env": "prod"}})
+	ctx := context.Background()
+
+	if err := sink.Push(ctx, gather(t, reg), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{strings.Join([]string{
+		"comio.latency_seconds_sum.prod:0.55|c",
+		"comio.latency_seconds_count.prod:2|c",
+		"comio.queue_length.prod:3|g",
+		"comio.requests_total.prod.GET.200:5|c",
+	}, "\n")}
+	if got := read(); !reflect.DeepEqual(got, want) {
+		t.Errorf("first push = %q, want %q", got, want)
+	}
+
+	// Counters are sent as their increase, and left out when unchanged
+	requests.WithLabelValues("GET", "200").Add(2)
+	if err := sink.Push(ctx, gather(t, reg), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"comio.queue_length.prod:3|g\ncomio.requests_total.prod.GET.200:2|c"}
+	if got := read(); !reflect.DeepEqual(got, want) {
+		t.Errorf("second push = %q, want %q", got, want)
+	}
+}
+
+func TestStatsdSink_Datadog(t *testing.T) {
+	addr, read := listenStatsd(t)
+	reg, _ := testRegistry(t)
+	sink := NewStatsdSink(StatsdConfig{Address: addr, Datadog: true, Tags: map[string]string{"env": "prod"}, MaxPacketSize: 75})
+
+	if err := sink.Push(context.Background(), gather(t, reg), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got := read()
+	want := []string{
+		"latency_seconds_sum:0.55|c|#env:prod\nlatency_seconds_count:2|c|#env:prod",
+		"queue_length:3|g|#env:prod",
+		"requests_total:5|c|#env:prod,method:GET,status:200",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("packets = %q, want %q", got, want)
+	}
+}
+
+// recordingSink fails its first pushes and records the rest
+type recordingSink struct {
+	mu       sync.Mutex
+	failures int
+	pushes   int
+}
+
+func (r *recordingSink) Push(ctx context.Context, samples []Sample, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return io.ErrUnexpectedEOF
+	}
+	r.pushes++
+	return nil
+}
+
+func TestPusher(t *testing.T) {
+	InitLogger("error", "json", "stdout")
+	reg, _ := testRegistry(t)
+	failing := &recordingSink{failures: 1 << 30}
+	working := &recordingSink{}
+	pusher := NewPusher(reg, 10*time.Millisecond)
+	pusher.AddSink("failing", failing)
+	pusher.AddSink("working", working)
+
+	before := testCounterValue(t, "failing")
+	pusher.Start()
+	time.Sleep(50 * time.Millisecond)
+	pusher.Stop()
+
+	working.mu.Lock()
+	defer working.mu.Unlock()
+	if working.pushes < 2 {
+		t.Errorf("working sink got %d pushes, want pushes to continue while another sink fails", working.pushes)
+	}
+	if got := testCounterValue(t, "failing") - before; got < 2 {
+		t.Errorf("comio_metrics_push_failures_total{sink=failing} grew by %v, want every failure counted", got)
+	}
+}
+
+func testCounterValue(t *testing.T, sink string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := flatten(families)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Name < samples[j].Name })
+	for _, s := range samples {
+		if s.Name == "comio_metrics_push_failures_total" && s.Labels[0].Value == sink {
+			return s.Value
+		}
+	}
+	return 0
+}
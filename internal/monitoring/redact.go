@@ -0,0 +1,113 @@
+package monitoring
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces sensitive values in log output
+const Redacted = "[REDACTED]"
+
+// sensitiveFields are substrings of field names whose values are never logged
+var sensitiveFields = []string{"authorization", "secret", "password", "token", "signature", "credential"}
+
+// sensitivePatterns match credentials embedded in free text, such as an
+// Authorization header or a presigned URL quoted in an error message
+var sensitivePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:Credential|Signature|X-Amz-Credential|X-Amz-Signature|X-Amz-Security-Token)=)[^&,\s"]+`),
+	regexp.MustCompile(`(?i)(\b(?:Bearer|Basic)\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)(\bAWS\s+[^:\s]+:)[A-Za-z0-9+/=]+`),
+}
+
+// SensitiveField reports whether values of a log field or header are redacted
+func SensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveFields {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactString masks credentials embedded in s
+func RedactString(s string) string {
+	for _, p := range sensitivePatterns {
+		s = p.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return s
+}
+
+// RedactQuery masks the values of sensitive query parameters, such as the
+// signature of a presigned URL
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return RedactString(rawQuery)
+	}
+	for name := range values {
+		if SensitiveField(name) {
+			values[name] = []string{Redacted}
+		}
+	}
+	return values.Encode()
+}
+
+// redactingCore wraps a core so that no log site can leak credentials:
+// sensitive fields are masked by name, and credentials are scrubbed from
+// messages, strings and errors
+type redactingCore struct {
+	zapcore.Core
+}
+
+// NewRedactingCore wraps core with credential redaction
+func NewRedactingCore(core zapcore.Core) zapcore.Core {
+	return &redactingCore{Core: core}
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *redactingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = RedactString(ent.Message)
+	return c.Core.Write(ent, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		redacted[i] = redactField(f)
+	}
+	return redacted
+}
+
+func redactField(f zapcore.Field) zapcore.Field {
+	if SensitiveField(f.Key) {
+		return zapcore.Field{Key: f.Key, Type: zapcore.StringType, String: Redacted}
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = RedactString(f.String)
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok {
+			if msg := err.Error(); RedactString(msg) != msg {
+				return zapcore.Field{Key: f.Key, Type: zapcore.StringType, String: RedactString(msg)}
+			}
+		}
+	}
+	return f
+}
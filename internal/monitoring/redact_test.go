@@ -0,0 +1,64 @@
+package monitoring
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactString(t *testing.T) {
+	tests := []string{
+		"Authorization: AWS4-HMAC-SHA256 Credential=AKID/20250101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abcdef0123",
+		"GET /bucket/key?X-Amz-Credential=AKID%2F20250101&X-Amz-Signature=abcdef0123",
+		"Authorization: Bearer abcdef0123",
+		"Authorization: AWS AKID:abcdef0123",
+	}
+	for _, s := range tests {
+		if got := RedactString(s); strings.Contains(got, "abcdef0123") || strings.Contains(got, "AKID/2025") {
+			t.Errorf("RedactString(%q) = %q", s, got)
+		}
+	}
+	if got := RedactString("uploaded bucket/key"); got != "uploaded bucket/key" {
+		t.Errorf("RedactString changed plain text: %q", got)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	got := RedactQuery("prefix=logs&X-Amz-Signature=abcdef0123&X-Amz-Security-Token=tok")
+	if strings.Contains(got, "abcdef0123") || strings.Contains(got, "tok&") {
+		t.Errorf("RedactQuery = %q", got)
+	}
+	if !strings.Contains(got, "prefix=logs") {
+		t.Errorf("RedactQuery dropped plain parameters: %q", got)
+	}
+}
+
+func TestRedactingCore(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(NewRedactingCore(core)).With(zap.String("remote_token", "abcdef0123"))
+
+	logger.Info("request with Authorization: Bearer abcdef0123",
+		zap.String("secret_access_key", "abcdef0123"),
+		zap.String("header", "Signature=abcdef0123"),
+		zap.Error(errors.New("presign failed: X-Amz-Signature=abcdef0123")),
+		zap.String("bucket", "photos"))
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if strings.Contains(entries[0].Message, "abcdef0123") {
+		t.Errorf("message not redacted: %q", entries[0].Message)
+	}
+	for key, value := range entries[0].ContextMap() {
+		if s, ok := value.(string); ok && strings.Contains(s, "abcdef0123") {
+			t.Errorf("field %s not redacted: %q", key, s)
+		}
+	}
+	if entries[0].ContextMap()["bucket"] != "photos" {
+		t.Errorf("bucket field = %v, want photos", entries[0].ContextMap()["bucket"])
+	}
+}
@@ -0,0 +1,246 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteConfig holds Prometheus remote write settings
+type RemoteWriteConfig struct {
+	URL               string
+	Username          string
+	Password          string
+	Headers           map[string]string // e.g. a tenant header
+	Labels            map[string]string // Added to every series, e.g. instance
+	Timeout           time.Duration     // Per-request timeout
+	BatchSize         int               // Series per request
+	MaxRetries        int               // Retries of a batch the endpoint failed to take
+	MaxPendingSamples int               // Samples kept for resending while the endpoint is down
+}
+
+// DefaultRemoteWriteConfig returns default remote write settings
+func DefaultRemoteWriteConfig() RemoteWriteConfig {
+	return RemoteWriteConfig{
+		Timeout:           10 * time.Second,
+		BatchSize:         500,
+		MaxRetries:        3,
+		MaxPendingSamples: 100000,
+	}
+}
+
+// RemoteWriteSink pushes samples with the Prometheus remote write protocol
+// (version 1.0), accepted by Prometheus, Mimir, Thanos, VictoriaMetrics
+// and most hosted services. Samples the endpoint could not take are kept,
+// up to MaxPendingSamples, and sent ahead of newer ones on the next push,
+// so an outage shorter than the buffer loses no data points.
+type RemoteWriteSink struct {
+	config RemoteWriteConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []timeSeries
+	dropped int // Samples dropped from a full buffer since the last report
+	backoff time.Duration
+}
+
+type timeSeries struct {
+	labels    []Label // Sorted by name, __name__ included
+	value     float64
+	timestamp int64 // Milliseconds since the epoch
+}
+
+// errPermanent marks a batch the endpoint refused, which is not retried
+var errPermanent = errors.New("rejected")
+
+// NewRemoteWriteSink creates a remote write sink
+func NewRemoteWriteSink(config RemoteWriteConfig) *RemoteWriteSink {
+	defaults := DefaultRemoteWriteConfig()
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.MaxPendingSamples <= 0 {
+		config.MaxPendingSamples = defaults.MaxPendingSamples
+	}
+	return &RemoteWriteSink{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		backoff: 500 * time.Millisecond,
+	}
+}
+
+// Push queues the samples and sends everything queued in batches, oldest
+// first, stopping at the first batch that cannot be delivered
+func (r *RemoteWriteSink) Push(ctx context.Context, samples []Sample, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	timestamp := at.UnixMilli()
+	for _, s := range samples {
+		if math.IsNaN(s.Value) {
+			continue
+		}
+		r.pending = append(r.pending, timeSeries{labels: r.labels(s), value: s.Value, timestamp: timestamp})
+	}
+	if over := len(r.pending) - r.config.MaxPendingSamples; over > 0 {
+		r.pending = append([]timeSeries(nil), r.pending[over:]...)
+		r.dropped += over
+	}
+
+	for len(r.pending) > 0 {
+		n := min(len(r.pending), r.config.BatchSize)
+		err := r.send(ctx, encodeWriteRequest(r.pending[:n]))
+		if err != nil && !errors.Is(err, errPermanent) {
+			return err
+		}
+		// Delivered, or refused for good and so not worth keeping
+		r.pending = r.pending[n:]
+		if err != nil {
+			return err
+		}
+	}
+	r.pending = nil
+
+	if r.dropped > 0 {
+		dropped := r.dropped
+		r.dropped = 0
+		return fmt.Errorf("dropped %d samples while the endpoint was down", dropped)
+	}
+	return nil
+}
+
+// labels returns the series labels of a sample: its own, the configured
+// ones where it has none of that name, and __name__
+func (r *RemoteWriteSink) labels(s Sample) []Label {
+	labels := append([]Label{{Name: "__name__", Value: s.Name}}, s.Labels...)
+	for name, value := range r.config.Labels {
+		found := false
+		for _, l := range s.Labels {
+			found = found || l.Name == name
+		}
+		if !found {
+			labels = append(labels, Label{Name: name, Value: value})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+// send posts a batch, retrying server errors and throttling with
+// exponential backoff
+func (r *RemoteWriteSink) send(ctx context.Context, body []byte) error {
+	body = snappyEncode(body)
+	delay := r.backoff
+	var err error
+	for attempt := 0; attempt <= r.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w (last attempt: %v)", ctx.Err(), err)
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		if err = r.post(ctx, body); err == nil || errors.Is(err, errPermanent) {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *RemoteWriteSink) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", r.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "comio")
+	for name, value := range r.config.Headers {
+		req.Header.Set(name, value)
+	}
+	if r.config.Username != "" {
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write returned %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	// As in Prometheus, only server errors and throttling are retried; a
+	// client error would fail again
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errPermanent, err)
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []timeSeries) []byte {
+	var b, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = protowire.AppendTag(msg[:0], 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.Name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = protowire.AppendTag(msg[:0], 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+// snappyEncode frames data as a snappy block, which remote write requires.
+// The block holds the data as literals only, which every snappy decoder
+// reads; batches go out uncompressed, but without a compression
+// dependency.
+func snappyEncode(data []byte) []byte {
+	b := protowire.AppendVarint(make([]byte, 0, len(data)+len(data)/65536*3+16), uint64(len(data)))
+	for len(data) > 0 {
+		n := min(len(data), 65536)
+		// A literal of n bytes: tag 61<<2 followed by n-1 as two bytes
+		b = append(b, 61<<2, byte(n-1), byte((n-1)>>8))
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
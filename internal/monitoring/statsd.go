@@ -0,0 +1,185 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsdConfig holds statsd settings
+type StatsdConfig struct {
+	Address       string            // host:port of the agent, over UDP
+	Prefix        string            // Prepended to every metric name, followed by a dot
+	Datadog       bool              // Send labels as DogStatsD tags instead of in the name
+	Tags          map[string]string // Added to every metric, as tags or in the name
+	MaxPacketSize int               // Bytes per datagram; lines are batched up to it
+}
+
+// StatsdSink pushes samples to a statsd or DogStatsD agent. Counters are
+// sent as the increase since the last push they were delivered in, and
+// everything else as gauges. Histogram buckets are left out: the agent
+// aggregates by itself, so only their _sum and _count are sent.
+//
+// Plain statsd has no labels, so their values are appended to the name,
+// in label name order: comio_requests_total{method="GET",status="200"}
+// becomes comio_requests_total.GET.200.
+type StatsdSink struct {
+	config StatsdConfig
+
+	mu   sync.Mutex
+	conn net.Conn
+	last map[string]float64 // Delivered value of each cumulative series
+}
+
+// NewStatsdSink creates a statsd sink. The agent is not contacted until
+// the first push.
+func NewStatsdSink(config StatsdConfig) *StatsdSink {
+	if config.MaxPacketSize <= 0 {
+		// Fits an Ethernet MTU with IP and UDP headers
+		config.MaxPacketSize = 1432
+	}
+	return &StatsdSink{config: config, last: make(map[string]float64)}
+}
+
+// statsdLine is a line of a datagram with the counter values it delivers
+type statsdLine struct {
+	text  string
+	key   string
+	value float64
+}
+
+// Push sends the samples, batched into datagrams. A datagram that cannot
+// be sent leaves its counters where they were, so their increase is sent
+// with the next push.
+func (s *StatsdSink) Push(ctx context.Context, samples []Sample, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []statsdLine
+	for _, sample := range samples {
+		if strings.HasSuffix(sample.Name, "_bucket") {
+			continue
+		}
+		name, tags := s.format(sample)
+		if !sample.Cumulative {
+			lines = append(lines, statsdLine{text: name + ":" + formatFloat(sample.Value) + "|g" + tags})
+			continue
+		}
+		key := name + tags
+		delta := sample.Value - s.last[key]
+		if delta < 0 {
+			// The counter was reset by a restart
+			delta = sample.Value
+		}
+		if delta == 0 {
+			continue
+		}
+		lines = append(lines, statsdLine{text: name + ":" + formatFloat(delta) + "|c" + tags, key: key, value: sample.Value})
+	}
+
+	if s.conn == nil {
+		conn, err := net.Dial("udp", s.config.Address)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	var packet []byte
+	var delivered []statsdLine
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = s.conn.SetWriteDeadline(deadline)
+		}
+		if _, err := s.conn.Write(packet); err != nil {
+			// Dial again on the next push, the agent may have moved
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("statsd: %w", err)
+		}
+		for _, l := range delivered {
+			if l.key != "" {
+				s.last[l.key] = l.value
+			}
+		}
+		packet, delivered = packet[:0], delivered[:0]
+		return nil
+	}
+	for _, l := range lines {
+		if len(packet) > 0 && len(packet)+1+len(l.text) > s.config.MaxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, l.text...)
+		delivered = append(delivered, l)
+	}
+	return flush()
+}
+
+// format returns the metric name and the tag suffix of a sample's lines
+func (s *StatsdSink) format(sample Sample) (string, string) {
+	name := sample.Name
+	if s.config.Prefix != "" {
+		name = s.config.Prefix + "." + name
+	}
+	name = sanitizeStatsd(name, false)
+
+	labels := append([]Label(nil), sample.Labels...)
+	for tag, value := range s.config.Tags {
+		found := false
+		for _, l := range sample.Labels {
+			found = found || l.Name == tag
+		}
+		if !found {
+			labels = append(labels, Label{Name: tag, Value: value})
+		}
+	}
+	sort.SliceStable(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	if !s.config.Datadog {
+		for _, l := range labels {
+			if l.Value == "" {
+				l.Value = "_"
+			}
+			name += "." + sanitizeStatsd(l.Value, true)
+		}
+		return name, ""
+	}
+	if len(labels) == 0 {
+		return name, ""
+	}
+	tags := make([]string, len(labels))
+	for i, l := range labels {
+		tags[i] = sanitizeStatsd(l.Name, false) + ":" + sanitizeStatsd(l.Value, false)
+	}
+	return name, "|#" + strings.Join(tags, ",")
+}
+
+// sanitizeStatsd replaces the characters delimiting the parts of a line.
+// Dots separate the segments of a plain statsd name, so they are replaced
+// in label values placed there.
+func sanitizeStatsd(s string, segment bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ':', r == '|', r == '@', r == '#', r == ',', r == '\n', r == ' ':
+			return '_'
+		case segment && r == '.':
+			return '_'
+		case !strconv.IsPrint(r):
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,738 @@
+// Package mount exposes a bucket as a filesystem. Keys are paths, with
+// slashes separating directories. Files are read lazily through range
+// requests; files opened for writing are staged in a local temporary file
+// and uploaded when they are closed.
+package mount
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/client"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// rootIno is the inode of the bucket root
+const rootIno = 1
+
+// listPageSize is how many keys are fetched per listing request
+const listPageSize = 1000
+
+// maxKeySuffix sorts after any valid UTF-8 continuation of a key; listing
+// after prefix+maxKeySuffix skips every key under prefix
+const maxKeySuffix = "\U0010FFFF"
+
+// Config configures a mounted bucket
+type Config struct {
+	Client  *client.Client
+	Bucket  string
+	UID     uint32 // Owner reported for every file
+	GID     uint32
+	TempDir string // Where files being written are staged; os.TempDir() when empty
+}
+
+// Attr describes a file or directory
+type Attr struct {
+	Ino   uint64
+	Dir   bool
+	Size  int64
+	Mtime time.Time
+}
+
+// DirEntry is one entry of a directory listing
+type DirEntry struct {
+	Name string
+	Ino  uint64
+	Dir  bool
+}
+
+type node struct {
+	path    string // Key of a file, or key prefix of a directory without the trailing slash
+	dir     bool
+	lookups uint64 // References held by the kernel
+}
+
+// fileHandle is an open file. Handles opened for writing stage the file in
+// tmp and upload it when flushed.
+type fileHandle struct {
+	mu    sync.Mutex
+	path  string
+	size  int64    // Size of the object when opened for reading
+	tmp   *os.File // Local copy of a file opened for writing
+	dirty bool     // tmp has changes not uploaded yet
+}
+
+// FS is a bucket seen as a filesystem. Its methods take and return inode
+// and handle numbers and fail with syscall.Errno values, so they map
+// directly onto a kernel filesystem protocol.
+type FS struct {
+	client  *client.Client
+	bucket  string
+	uid     uint32
+	gid     uint32
+	tempDir string
+	mounted time.Time
+
+	mu      sync.Mutex
+	nodes   map[uint64]*node
+	inodes  map[string]uint64
+	nextIno uint64
+	writers map[string]*fileHandle // Files open for writing, by path
+	files   map[uint64]*fileHandle
+	dirents map[uint64][]DirEntry // Listings of open directories
+	nextFH  uint64
+}
+
+// NewFS creates the filesystem of a bucket
+func NewFS(cfg Config) *FS {
+	return &FS{
+		client:  cfg.Client,
+		bucket:  cfg.Bucket,
+		uid:     cfg.UID,
+		gid:     cfg.GID,
+		tempDir: cfg.TempDir,
+		mounted: time.Now(),
+		nodes:   map[uint64]*node{rootIno: {dir: true, lookups: 1}},
+		inodes:  map[string]uint64{"": rootIno},
+		nextIno: rootIno + 1,
+		writers: make(map[string]*fileHandle),
+		files:   make(map[uint64]*fileHandle),
+		dirents: make(map[uint64][]DirEntry),
+	}
+}
+
+// Lookup resolves name in the directory parent and takes a reference to
+// its inode
+func (fs *FS) Lookup(parent uint64, name string) (Attr, error) {
+	path, err := fs.child(parent, name)
+	if err != nil {
+		return Attr{}, err
+	}
+	attr, err := fs.stat(path)
+	if err != nil {
+		return Attr{}, err
+	}
+	attr.Ino = fs.ref(path, attr.Dir)
+	return attr, nil
+}
+
+// Forget drops n references to an inode
+func (fs *FS) Forget(ino, n uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	nd, ok := fs.nodes[ino]
+	if !ok || ino == rootIno {
+		return
+	}
+	if nd.lookups > n {
+		nd.lookups -= n
+		return
+	}
+	delete(fs.nodes, ino)
+	if fs.inodes[nd.path] == ino {
+		delete(fs.inodes, nd.path)
+	}
+}
+
+// GetAttr returns the attributes of an inode
+func (fs *FS) GetAttr(ino uint64) (Attr, error) {
+	nd, err := fs.node(ino)
+	if err != nil {
+		return Attr{}, err
+	}
+	if nd.dir {
+		return Attr{Ino: ino, Dir: true, Mtime: fs.mounted}, nil
+	}
+	attr, err := fs.stat(nd.path)
+	if err != nil {
+		return Attr{}, err
+	}
+	attr.Ino = ino
+	return attr, nil
+}
+
+// SetSize truncates or extends a file. fh is the handle the change was
+// made through, or 0.
+func (fs *FS) SetSize(ino, fh uint64, size int64) error {
+	nd, err := fs.node(ino)
+	if err != nil {
+		return err
+	}
+	if nd.dir {
+		return syscall.EISDIR
+	}
+
+	fs.mu.Lock()
+	h := fs.files[fh]
+	if h == nil || h.tmp == nil {
+		h = fs.writers[nd.path]
+	}
+	fs.mu.Unlock()
+	if h != nil {
+		return h.truncate(size)
+	}
+
+	// Not open for writing: rewrite the object through a handle of our own
+	trunc := 0
+	if size == 0 {
+		trunc = os.O_TRUNC
+	}
+	fh, err = fs.Open(ino, os.O_RDWR|trunc)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	h = fs.files[fh]
+	fs.mu.Unlock()
+	err = h.truncate(size)
+	if releaseErr := fs.Release(fh); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// Open opens a file and returns its handle
+func (fs *FS) Open(ino uint64, flags int) (uint64, error) {
+	nd, err := fs.node(ino)
+	if err != nil {
+		return 0, err
+	}
+	if nd.dir {
+		return 0, syscall.EISDIR
+	}
+
+	if flags&(os.O_WRONLY|os.O_RDWR) == 0 {
+		attr, err := fs.stat(nd.path)
+		if err != nil {
+			return 0, err
+		}
+		return fs.addHandle(&fileHandle{path: nd.path, size: attr.Size}), nil
+	}
+
+	h, err := fs.newWriter(nd.path, flags&os.O_TRUNC != 0)
+	if err != nil {
+		return 0, err
+	}
+	return fs.addHandle(h), nil
+}
+
+// Create creates an empty file in the directory parent and opens it
+func (fs *FS) Create(parent uint64, name string) (Attr, uint64, error) {
+	path, err := fs.child(parent, name)
+	if err != nil {
+		return Attr{}, 0, err
+	}
+	h, err := fs.newWriter(path, true)
+	if err != nil {
+		return Attr{}, 0, err
+	}
+	fh := fs.addHandle(h)
+	return Attr{Ino: fs.ref(path, false), Mtime: time.Now()}, fh, nil
+}
+
+// Read reads up to size bytes of an open file at offset
+func (fs *FS) Read(fh uint64, offset int64, size int) ([]byte, error) {
+	h, err := fs.handle(fh)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.tmp != nil {
+		buf := make([]byte, size)
+		n, err := h.tmp.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, syscall.EIO
+		}
+		return buf[:n], nil
+	}
+
+	if offset >= h.size {
+		return nil, nil
+	}
+	length := min(int64(size), h.size-offset)
+	data, err := fs.client.GetObjectRange(context.Background(), fs.bucket, h.path, offset, length)
+	if err != nil {
+		return nil, fs.errno("read", h.path, err)
+	}
+	return data, nil
+}
+
+// Write writes data to an open file at offset
+func (fs *FS) Write(fh uint64, offset int64, data []byte) (int, error) {
+	h, err := fs.handle(fh)
+	if err != nil {
+		return 0, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.tmp == nil {
+		return 0, syscall.EBADF
+	}
+	n, err := h.tmp.WriteAt(data, offset)
+	if err != nil {
+		return n, syscall.EIO
+	}
+	h.dirty = true
+	return n, nil
+}
+
+// Flush uploads the changes made through a handle
+func (fs *FS) Flush(fh uint64) error {
+	h, err := fs.handle(fh)
+	if err != nil {
+		return err
+	}
+	return fs.upload(h)
+}
+
+// Release uploads pending changes and closes a handle
+func (fs *FS) Release(fh uint64) error {
+	h, err := fs.handle(fh)
+	if err != nil {
+		return err
+	}
+	err = fs.upload(h)
+
+	fs.mu.Lock()
+	delete(fs.files, fh)
+	if fs.writers[h.path] == h {
+		delete(fs.writers, h.path)
+	}
+	fs.mu.Unlock()
+
+	if h.tmp != nil {
+		h.tmp.Close()
+		os.Remove(h.tmp.Name())
+	}
+	return err
+}
+
+// OpenDir lists a directory and returns a handle to the listing
+func (fs *FS) OpenDir(ino uint64) (uint64, error) {
+	nd, err := fs.node(ino)
+	if err != nil {
+		return 0, err
+	}
+	if !nd.dir {
+		return 0, syscall.ENOTDIR
+	}
+	entries, err := fs.list(ino, nd.path)
+	if err != nil {
+		return 0, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.nextFH++
+	fs.dirents[fs.nextFH] = entries
+	return fs.nextFH, nil
+}
+
+// ReadDir returns the listing of an open directory
+func (fs *FS) ReadDir(fh uint64) ([]DirEntry, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	entries, ok := fs.dirents[fh]
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return entries, nil
+}
+
+// ReleaseDir closes a directory handle
+func (fs *FS) ReleaseDir(fh uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.dirents, fh)
+}
+
+// Mkdir creates a directory. Directories are key prefixes, so the
+// directory is stored as a directory marker, an empty object named after it
+// with a trailing slash, which keeps it while it holds nothing else.
+func (fs *FS) Mkdir(parent uint64, name string) (Attr, error) {
+	path, err := fs.child(parent, name)
+	if err != nil {
+		return Attr{}, err
+	}
+	if _, err := fs.stat(path); err == nil {
+		return Attr{}, syscall.EEXIST
+	} else if err != syscall.ENOENT {
+		return Attr{}, err
+	}
+
+	if _, err := fs.client.PutObject(context.Background(), fs.bucket, path+"/", strings.NewReader(""), 0, nil); err != nil {
+		return Attr{}, fs.errno("mkdir", path, err)
+	}
+	return Attr{Ino: fs.ref(path, true), Dir: true, Mtime: time.Now()}, nil
+}
+
+// Unlink deletes a file
+func (fs *FS) Unlink(parent uint64, name string) error {
+	path, err := fs.child(parent, name)
+	if err != nil {
+		return err
+	}
+	if err := fs.client.DeleteObject(context.Background(), fs.bucket, path); err != nil {
+		return fs.errno("delete", path, err)
+	}
+	return nil
+}
+
+// Rmdir removes an empty directory by deleting its directory marker. An
+// empty directory without a marker does not exist.
+func (fs *FS) Rmdir(parent uint64, name string) error {
+	path, err := fs.child(parent, name)
+	if err != nil {
+		return err
+	}
+	entries, err := fs.list(0, path)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 2 {
+		return syscall.ENOTEMPTY
+	}
+
+	if err := fs.client.DeleteObject(context.Background(), fs.bucket, path+"/"); err != nil {
+		return fs.errno("rmdir", path, err)
+	}
+	return nil
+}
+
+// Rename moves a file by copying it to its new key and deleting the old
+// one. Directories cannot be renamed; EXDEV makes tools such as mv fall
+// back to copying their content.
+func (fs *FS) Rename(parent uint64, name string, newParent uint64, newName string) error {
+	from, err := fs.child(parent, name)
+	if err != nil {
+		return err
+	}
+	to, err := fs.child(newParent, newName)
+	if err != nil {
+		return err
+	}
+	attr, err := fs.stat(from)
+	if err != nil {
+		return err
+	}
+	if attr.Dir {
+		return syscall.EXDEV
+	}
+
+	fs.mu.Lock()
+	w := fs.writers[from]
+	fs.mu.Unlock()
+	if w != nil {
+		if err := fs.upload(w); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := fs.download(from)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return syscall.EIO
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return syscall.EIO
+	}
+	ctx := context.Background()
+	if _, err := fs.client.PutObject(ctx, fs.bucket, to, tmp, size, nil); err != nil {
+		return fs.errno("rename", to, err)
+	}
+	if err := fs.client.DeleteObject(ctx, fs.bucket, from); err != nil {
+		return fs.errno("rename", from, err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if ino, ok := fs.inodes[from]; ok {
+		delete(fs.inodes, from)
+		fs.inodes[to] = ino
+		fs.nodes[ino].path = to
+	}
+	return nil
+}
+
+// child returns the path of name in the directory parent
+func (fs *FS) child(parent uint64, name string) (string, error) {
+	nd, err := fs.node(parent)
+	if err != nil {
+		return "", err
+	}
+	if !nd.dir {
+		return "", syscall.ENOTDIR
+	}
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", syscall.EINVAL
+	}
+	if nd.path == "" {
+		return name, nil
+	}
+	return nd.path + "/" + name, nil
+}
+
+func (fs *FS) node(ino uint64) (*node, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	nd, ok := fs.nodes[ino]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return nd, nil
+}
+
+// ref returns the inode of a path, taking a reference to it
+func (fs *FS) ref(path string, dir bool) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if ino, ok := fs.inodes[path]; ok && fs.nodes[ino].dir == dir {
+		fs.nodes[ino].lookups++
+		return ino
+	}
+	ino := fs.nextIno
+	fs.nextIno++
+	fs.nodes[ino] = &node{path: path, dir: dir, lookups: 1}
+	fs.inodes[path] = ino
+	return ino
+}
+
+func (fs *FS) handle(fh uint64) (*fileHandle, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	h, ok := fs.files[fh]
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return h, nil
+}
+
+func (fs *FS) addHandle(h *fileHandle) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.nextFH++
+	fs.files[fs.nextFH] = h
+	if h.tmp != nil {
+		fs.writers[h.path] = h
+	}
+	return fs.nextFH
+}
+
+// stat returns the attributes of a path: a file being written, an object,
+// or a directory holding objects or a directory marker
+func (fs *FS) stat(path string) (Attr, error) {
+	fs.mu.Lock()
+	w := fs.writers[path]
+	fs.mu.Unlock()
+	if w != nil {
+		return w.attr()
+	}
+
+	ctx := context.Background()
+	info, err := fs.client.HeadObject(ctx, fs.bucket, path)
+	if err == nil {
+		return Attr{Size: info.Size, Mtime: info.LastModified}, nil
+	}
+	if !client.IsCode(err, s3.NoSuchKey) {
+		return Attr{}, fs.errno("stat", path, err)
+	}
+
+	page, err := fs.client.ListObjects(ctx, fs.bucket, client.ListOptions{Prefix: path + "/", MaxKeys: 1})
+	if err != nil {
+		return Attr{}, fs.errno("stat", path, err)
+	}
+	if len(page.Objects) == 0 {
+		return Attr{}, syscall.ENOENT
+	}
+	return Attr{Dir: true, Mtime: fs.mounted}, nil
+}
+
+// list returns the entries of the directory at path, "." and ".." first.
+// Each subdirectory is listed once: the listing skips past its keys. A
+// directory marker lists as its directory, and the directory's own marker
+// is not listed.
+func (fs *FS) list(ino uint64, path string) ([]DirEntry, error) {
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+	children := make(map[string]bool) // Name to whether it is a directory
+
+	opts := client.ListOptions{Prefix: prefix, MaxKeys: listPageSize}
+	for {
+		page, err := fs.client.ListObjects(context.Background(), fs.bucket, opts)
+		if err != nil {
+			return nil, fs.errno("list", path, err)
+		}
+		for _, obj := range page.Objects {
+			name, _, isDir := strings.Cut(strings.TrimPrefix(obj.Key, prefix), "/")
+			if name == "" {
+				continue
+			}
+			children[name] = children[name] || isDir
+		}
+		if !page.IsTruncated || len(page.Objects) == 0 {
+			break
+		}
+		opts.StartAfter = page.NextMarker
+		if name, _, isDir := strings.Cut(strings.TrimPrefix(opts.StartAfter, prefix), "/"); isDir {
+			opts.StartAfter = prefix + name + "/" + maxKeySuffix
+		}
+	}
+
+	fs.mu.Lock()
+	for file := range fs.writers {
+		if name, ok := strings.CutPrefix(file, prefix); ok && !strings.Contains(name, "/") {
+			if _, exists := children[name]; !exists {
+				children[name] = false
+			}
+		}
+	}
+	fs.mu.Unlock()
+
+	entries := []DirEntry{{Name: ".", Ino: ino, Dir: true}, {Name: "..", Ino: rootIno, Dir: true}}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries = append(entries, DirEntry{Name: name, Ino: fs.direntIno(prefix + name), Dir: children[name]})
+	}
+	return entries, nil
+}
+
+// direntIno returns the inode reported for a listed path. Paths the kernel
+// has not looked up get a stable number derived from the path, without
+// taking a reference.
+func (fs *FS) direntIno(path string) uint64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if ino, ok := fs.inodes[path]; ok {
+		return ino
+	}
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return h.Sum64() | 1<<63
+}
+
+// newWriter opens path for writing, starting from the current object
+// unless truncate is set
+func (fs *FS) newWriter(path string, truncate bool) (*fileHandle, error) {
+	if truncate {
+		tmp, err := os.CreateTemp(fs.tempDir, "comio-mount-*")
+		if err != nil {
+			return nil, syscall.EIO
+		}
+		// Uploaded on close even if nothing is written
+		return &fileHandle{path: path, tmp: tmp, dirty: true}, nil
+	}
+
+	tmp, err := fs.download(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileHandle{path: path, tmp: tmp}, nil
+}
+
+// download copies an object to a temporary file
+func (fs *FS) download(path string) (*os.File, error) {
+	body, _, err := fs.client.GetObject(context.Background(), fs.bucket, path, nil)
+	if err != nil {
+		return nil, fs.errno("download", path, err)
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(fs.tempDir, "comio-mount-*")
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fs.errno("download", path, err)
+	}
+	return tmp, nil
+}
+
+// upload writes the staged copy of a file back to its object
+func (fs *FS) upload(h *fileHandle) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tmp == nil || !h.dirty {
+		return nil
+	}
+
+	size, err := h.tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return syscall.EIO
+	}
+	if _, err := h.tmp.Seek(0, io.SeekStart); err != nil {
+		return syscall.EIO
+	}
+	if _, err := fs.client.PutObject(context.Background(), fs.bucket, h.path, h.tmp, size, nil); err != nil {
+		return fs.errno("upload", h.path, err)
+	}
+	h.dirty = false
+	return nil
+}
+
+func (h *fileHandle) attr() (Attr, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info, err := h.tmp.Stat()
+	if err != nil {
+		return Attr{}, syscall.EIO
+	}
+	return Attr{Size: info.Size(), Mtime: info.ModTime()}, nil
+}
+
+func (h *fileHandle) truncate(size int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tmp == nil {
+		return syscall.EBADF
+	}
+	if err := h.tmp.Truncate(size); err != nil {
+		return syscall.EIO
+	}
+	h.dirty = true
+	return nil
+}
+
+// errno maps a client error to the errno returned to the kernel
+func (fs *FS) errno(op, path string, err error) error {
+	switch {
+	case client.IsCode(err, s3.NoSuchKey), client.IsCode(err, s3.NoSuchBucket):
+		return syscall.ENOENT
+	case client.IsCode(err, s3.AccessDenied):
+		return syscall.EACCES
+	case errors.Is(err, context.Canceled):
+		return syscall.EINTR
+	}
+	monitoring.Log.Warn("Mount operation failed",
+		zap.String("op", op),
+		zap.String("bucket", fs.bucket),
+		zap.String("path", path),
+		zap.Error(err))
+	return syscall.EIO
+}
@@ -0,0 +1,372 @@
+package mount
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/client"
+	"github.com/danielino/comio/pkg/s3"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+// objectServer serves one bucket from memory with the object API's
+// listing, range and error formats
+type objectServer struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	ranges  int // Range requests served
+}
+
+func (o *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	_, key, hasKey := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !hasKey {
+		o.list(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		o.objects[key] = data
+		json.NewEncoder(w).Encode(map[string]string{"etag": "etag"})
+	case http.MethodGet, http.MethodHead:
+		data, ok := o.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(s3.ErrorResponse{Code: s3.NoSuchKey, Message: "object not found"})
+			return
+		}
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			o.ranges++
+			end = min(end, len(data)-1)
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		if _, ok := o.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(s3.ErrorResponse{Code: s3.NoSuchKey, Message: "object not found"})
+			return
+		}
+		delete(o.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (o *objectServer) list(w http.ResponseWriter, r *http.Request) {
+	prefix, startAfter := r.URL.Query().Get("prefix"), r.URL.Query().Get("start-after")
+	maxKeys, _ := strconv.Atoi(r.URL.Query().Get("max-keys"))
+
+	var keys []string
+	for key := range o.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type entry struct {
+		Key  string `json:"key"`
+		Size int    `json:"size"`
+	}
+	result := struct {
+		Objects     []entry `json:"objects"`
+		IsTruncated bool    `json:"is_truncated"`
+		NextMarker  string  `json:"next_marker"`
+	}{Objects: []entry{}}
+	if maxKeys > 0 && len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextMarker = keys[maxKeys-1]
+	}
+	for _, key := range keys {
+		result.Objects = append(result.Objects, entry{Key: key, Size: len(o.objects[key])})
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func newTestFS(t *testing.T, objects map[string]string) (*FS, *objectServer) {
+	o := &objectServer{objects: make(map[string][]byte)}
+	for key, data := range objects {
+		o.objects[key] = []byte(data)
+	}
+	srv := httptest.NewServer(o)
+	t.Cleanup(srv.Close)
+
+	c, err := client.New(client.Config{Endpoint: srv.URL, Retry: &client.RetryPolicy{MaxAttempts: 1}})
+	if err != nil {
+		t.Fatalf("client.New failed: %v", err)
+	}
+	return NewFS(Config{Client: c, Bucket: "bucket", TempDir: t.TempDir()}), o
+}
+
+// lookupPath resolves a slash-separated path from the root
+func lookupPath(t *testing.T, fs *FS, path string) (Attr, error) {
+	t.Helper()
+	attr := Attr{Ino: rootIno, Dir: true}
+	for _, name := range strings.Split(path, "/") {
+		var err error
+		if attr, err = fs.Lookup(attr.Ino, name); err != nil {
+			return Attr{}, err
+		}
+	}
+	return attr, nil
+}
+
+func TestFS_LookupAndReadDir(t *testing.T) {
+	fs, _ := newTestFS(t, map[string]string{
+		"readme.txt":        "hello",
+		"photos/cat.jpg":    "meow",
+		"photos/2024/a.jpg": "a",
+		"photos/2024/b.jpg": "b",
+		"photos/dog.jpg":    "woof",
+		"photos/raw/":       "", // Directory marker
+	})
+
+	attr, err := lookupPath(t, fs, "readme.txt")
+	if err != nil || attr.Dir || attr.Size != 5 {
+		t.Errorf("readme.txt = %+v, %v; want a 5-byte file", attr, err)
+	}
+	attr, err = lookupPath(t, fs, "photos/2024")
+	if err != nil || !attr.Dir {
+		t.Errorf("photos/2024 = %+v, %v; want a directory", attr, err)
+	}
+	if _, err := lookupPath(t, fs, "photos/missing"); err != syscall.ENOENT {
+		t.Errorf("missing lookup error = %v, want ENOENT", err)
+	}
+
+	photos, _ := lookupPath(t, fs, "photos")
+	fh, err := fs.OpenDir(photos.Ino)
+	if err != nil {
+		t.Fatalf("OpenDir failed: %v", err)
+	}
+	defer fs.ReleaseDir(fh)
+	entries, _ := fs.ReadDir(fh)
+
+	var got []string
+	for _, e := range entries {
+		if e.Dir {
+			got = append(got, e.Name+"/")
+		} else {
+			got = append(got, e.Name)
+		}
+	}
+	want := "./ ../ 2024/ cat.jpg dog.jpg raw/"
+	if strings.Join(got, " ") != want {
+		t.Errorf("ReadDir = %v, want %s", got, want)
+	}
+}
+
+func TestFS_ReadDirSkipsSubdirectories(t *testing.T) {
+	objects := map[string]string{"z.txt": "z"}
+	for i := range 2500 {
+		objects[fmt.Sprintf("big/%04d", i)] = "x"
+	}
+	fs, _ := newTestFS(t, objects)
+
+	fh, err := fs.OpenDir(rootIno)
+	if err != nil {
+		t.Fatalf("OpenDir failed: %v", err)
+	}
+	entries, _ := fs.ReadDir(fh)
+	if len(entries) != 4 || entries[2].Name != "big" || entries[3].Name != "z.txt" {
+		t.Errorf("ReadDir = %+v, want ., .., big, z.txt", entries)
+	}
+}
+
+func TestFS_ReadUsesRanges(t *testing.T) {
+	fs, o := newTestFS(t, map[string]string{"data": "0123456789"})
+
+	attr, _ := lookupPath(t, fs, "data")
+	fh, err := fs.Open(attr.Ino, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer fs.Release(fh)
+
+	got, err := fs.Read(fh, 3, 4)
+	if err != nil || string(got) != "3456" {
+		t.Errorf("Read(3, 4) = %q, %v; want 3456", got, err)
+	}
+	got, _ = fs.Read(fh, 8, 100)
+	if string(got) != "89" {
+		t.Errorf("Read(8, 100) = %q, want 89", got)
+	}
+	if got, _ := fs.Read(fh, 10, 4); len(got) != 0 {
+		t.Errorf("Read at EOF = %q, want nothing", got)
+	}
+	if o.ranges != 2 {
+		t.Errorf("range requests = %d, want 2", o.ranges)
+	}
+}
+
+func TestFS_WriteUploadsOnRelease(t *testing.T) {
+	fs, o := newTestFS(t, nil)
+
+	attr, fh, err := fs.Create(rootIno, "new.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	fs.Write(fh, 0, []byte("hello "))
+	fs.Write(fh, 6, []byte("world"))
+
+	// Visible with its local size before it is uploaded
+	if got, err := fs.GetAttr(attr.Ino); err != nil || got.Size != 11 {
+		t.Errorf("GetAttr while writing = %+v, %v; want size 11", got, err)
+	}
+	if _, ok := o.objects["new.txt"]; ok {
+		t.Error("object uploaded before close")
+	}
+
+	if err := fs.Release(fh); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if got := string(o.objects["new.txt"]); got != "hello world" {
+		t.Errorf("uploaded %q, want hello world", got)
+	}
+}
+
+func TestFS_ModifyExistingFile(t *testing.T) {
+	fs, o := newTestFS(t, map[string]string{"doc": "hello world"})
+
+	attr, _ := lookupPath(t, fs, "doc")
+	fh, err := fs.Open(attr.Ino, os.O_RDWR)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	fs.Write(fh, 6, []byte("comio"))
+	fs.Release(fh)
+	if got := string(o.objects["doc"]); got != "hello comio" {
+		t.Errorf("object = %q, want hello comio", got)
+	}
+
+	if err := fs.SetSize(attr.Ino, 0, 5); err != nil {
+		t.Fatalf("SetSize failed: %v", err)
+	}
+	if got := string(o.objects["doc"]); got != "hello" {
+		t.Errorf("object after truncate = %q, want hello", got)
+	}
+
+	fh, _ = fs.Open(attr.Ino, os.O_WRONLY|os.O_TRUNC)
+	fs.Release(fh)
+	if got, ok := o.objects["doc"]; !ok || len(got) != 0 {
+		t.Errorf("object after O_TRUNC = %q, want empty", got)
+	}
+}
+
+func TestFS_Directories(t *testing.T) {
+	fs, o := newTestFS(t, map[string]string{"full/file": "x"})
+
+	dir, err := fs.Mkdir(rootIno, "empty")
+	if err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, err := fs.Mkdir(rootIno, "full"); err != syscall.EEXIST {
+		t.Errorf("Mkdir existing error = %v, want EEXIST", err)
+	}
+	if data, ok := o.objects["empty/"]; !ok || len(data) != 0 {
+		t.Fatal("Mkdir did not store the directory marker empty/")
+	}
+
+	// The empty directory outlives the mount
+	other := NewFS(Config{Client: fs.client, Bucket: fs.bucket})
+	if attr, err := other.Lookup(rootIno, "empty"); err != nil || !attr.Dir {
+		t.Errorf("Lookup on a new mount = %+v, %v, want a directory", attr, err)
+	}
+	entries, err := other.list(rootIno, "")
+	if err != nil || len(entries) != 4 || entries[2].Name != "empty" || !entries[2].Dir {
+		t.Errorf("entries on a new mount = %+v", entries)
+	}
+
+	_, fh, err := fs.Create(dir.Ino, "f")
+	if err != nil {
+		t.Fatalf("Create in new directory failed: %v", err)
+	}
+	fs.Release(fh)
+	if _, ok := o.objects["empty/f"]; !ok {
+		t.Error("file in new directory not uploaded as empty/f")
+	}
+
+	if err := fs.Rmdir(rootIno, "full"); err != syscall.ENOTEMPTY {
+		t.Errorf("Rmdir non-empty error = %v, want ENOTEMPTY", err)
+	}
+	if err := fs.Unlink(dir.Ino, "f"); err != nil {
+		t.Fatalf("Unlink failed: %v", err)
+	}
+	if err := fs.Rmdir(rootIno, "empty"); err != nil {
+		t.Errorf("Rmdir failed: %v", err)
+	}
+	if _, ok := o.objects["empty/"]; ok {
+		t.Error("Rmdir left the directory marker")
+	}
+	if _, err := fs.Lookup(rootIno, "empty"); err != syscall.ENOENT {
+		t.Errorf("lookup after Rmdir error = %v, want ENOENT", err)
+	}
+}
+
+func TestFS_Rename(t *testing.T) {
+	fs, o := newTestFS(t, map[string]string{"a/old": "data", "a/sub/x": "x"})
+
+	a, _ := lookupPath(t, fs, "a")
+	file, _ := fs.Lookup(a.Ino, "old")
+	if err := fs.Rename(a.Ino, "old", rootIno, "new"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, ok := o.objects["a/old"]; ok {
+		t.Error("old key still exists")
+	}
+	if got := string(o.objects["new"]); got != "data" {
+		t.Errorf("new key = %q, want data", got)
+	}
+	// The kernel keeps using the renamed inode
+	if attr, err := fs.GetAttr(file.Ino); err != nil || attr.Size != 4 {
+		t.Errorf("GetAttr of renamed inode = %+v, %v", attr, err)
+	}
+
+	if err := fs.Rename(a.Ino, "sub", rootIno, "sub"); err != syscall.EXDEV {
+		t.Errorf("directory Rename error = %v, want EXDEV", err)
+	}
+}
+
+func TestFS_Forget(t *testing.T) {
+	fs, _ := newTestFS(t, map[string]string{"f": "x"})
+
+	first, _ := fs.Lookup(rootIno, "f")
+	second, _ := fs.Lookup(rootIno, "f")
+	if first.Ino != second.Ino {
+		t.Fatalf("lookups returned inodes %d and %d", first.Ino, second.Ino)
+	}
+
+	fs.Forget(first.Ino, 1)
+	if _, err := fs.GetAttr(first.Ino); err != nil {
+		t.Errorf("GetAttr after partial forget = %v", err)
+	}
+	fs.Forget(first.Ino, 1)
+	if _, err := fs.GetAttr(first.Ino); err != syscall.ENOENT {
+		t.Errorf("GetAttr after forget = %v, want ENOENT", err)
+	}
+}
@@ -0,0 +1,685 @@
+//go:build linux
+
+package mount
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// FUSE protocol version spoken by the server. Kernels older than
+// minMinor lack the request layouts decoded below.
+const (
+	fuseMajor = 7
+	fuseMinor = 31
+	minMinor  = 12
+)
+
+// Opcodes of the FUSE kernel protocol, from linux/fuse.h
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opSetattr     = 4
+	opMkdir       = 9
+	opUnlink      = 10
+	opRmdir       = 11
+	opRename      = 12
+	opOpen        = 14
+	opRead        = 15
+	opWrite       = 16
+	opStatfs      = 17
+	opRelease     = 18
+	opFsync       = 20
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opFsyncdir    = 30
+	opAccess      = 34
+	opCreate      = 35
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+	opRename2     = 45
+)
+
+const (
+	initAsyncRead    = 1 << 0
+	initAtomicOTrunc = 1 << 3
+	initBigWrites    = 1 << 5
+
+	setattrSize = 1 << 3
+	setattrFH   = 1 << 6
+
+	inHeaderSize = 40
+	maxWrite     = 128 * 1024
+	// Requests carry at most maxWrite bytes of data after their headers
+	readBufferSize = maxWrite + 4096
+
+	attrValid = time.Second // How long the kernel may cache entries and attributes
+	blockSize = 4096
+)
+
+// The probe file of disablePoll, outside the inode numbers used by FS
+const (
+	pollProbeName = ".comio-poll-probe"
+	pollProbeIno  = 1<<63 - 1
+)
+
+var le = binary.LittleEndian
+
+// Server serves a filesystem to the kernel through a FUSE mount
+type Server struct {
+	fs         *FS
+	dir        string
+	dev        *os.File
+	fusermount bool // Mounted through fusermount, which must also unmount
+	done       chan error
+
+	writeMu sync.Mutex
+}
+
+// Mount mounts fs at dir and serves it until it is unmounted. Root mounts
+// directly; other users go through fusermount. The mount is ready for use
+// when Mount returns.
+func Mount(dir string, fs *FS) (*Server, error) {
+	s := &Server{fs: fs, dir: dir, done: make(chan error, 1)}
+	var err error
+	if os.Geteuid() == 0 {
+		s.dev, err = mountDirect(dir, fs)
+	} else {
+		s.dev, err = mountFusermount(dir)
+		s.fusermount = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to mount %s: %w", dir, err)
+	}
+
+	go func() { s.done <- s.serve() }()
+	if err := s.disablePoll(); err != nil {
+		s.Unmount()
+		return nil, fmt.Errorf("failed to mount %s: %w", dir, err)
+	}
+	return s, nil
+}
+
+func mountDirect(dir string, fs *FS) (*os.File, error) {
+	dev, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions",
+		dev.Fd(), fs.uid, fs.gid)
+	if err := syscall.Mount("comio:"+fs.bucket, dir, "fuse.comio", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return dev, nil
+}
+
+// mountFusermount mounts through the setuid fusermount helper, which
+// passes the opened /dev/fuse back over a socket
+func mountFusermount(dir string) (*os.File, error) {
+	bin, err := exec.LookPath("fusermount3")
+	if err != nil {
+		if bin, err = exec.LookPath("fusermount"); err != nil {
+			return nil, errors.New("fusermount not found; mounting as a regular user needs the FUSE utilities")
+		}
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "fusermount")
+	remote := os.NewFile(uintptr(fds[1]), "fusermount-child")
+	defer local.Close()
+
+	cmd := exec.Command(bin, "-o", "fsname=comio,subtype=comio,default_permissions", "--", dir)
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	remote.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", bin, err)
+	}
+
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(fds[0], buf, oob, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return nil, errors.New("fusermount did not pass a file descriptor")
+	}
+	rights, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(rights) == 0 {
+		return nil, errors.New("fusermount did not pass a file descriptor")
+	}
+	return os.NewFile(uintptr(rights[0]), "/dev/fuse"), nil
+}
+
+// Wait blocks until the filesystem is unmounted
+func (s *Server) Wait() error {
+	return <-s.done
+}
+
+// Unmount detaches the filesystem; Wait returns once the kernel lets go
+func (s *Server) Unmount() error {
+	if s.fusermount {
+		bin, err := exec.LookPath("fusermount3")
+		if err != nil {
+			bin = "fusermount"
+		}
+		return exec.Command(bin, "-u", s.dir).Run()
+	}
+	err := syscall.Unmount(s.dir, 0)
+	if err == syscall.EBUSY {
+		err = syscall.Unmount(s.dir, syscall.MNT_DETACH)
+	}
+	return err
+}
+
+// request is a request read from the kernel
+type request struct {
+	opcode uint32
+	unique uint64
+	node   uint64
+	data   []byte // Body following the header
+}
+
+// serve answers kernel requests until the filesystem is unmounted. Each
+// request is handled in its own goroutine.
+func (s *Server) serve() error {
+	defer s.dev.Close()
+	fd := int(s.dev.Fd())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	initialized := false
+	for {
+		buf := make([]byte, readBufferSize)
+		n, err := syscall.Read(fd, buf)
+		switch err {
+		case nil:
+		case syscall.EINTR, syscall.EAGAIN, syscall.ENOENT:
+			// ENOENT: the request was interrupted before it was read
+			continue
+		case syscall.ENODEV:
+			return nil
+		default:
+			return fmt.Errorf("failed to read FUSE request: %w", err)
+		}
+		if n < inHeaderSize {
+			return fmt.Errorf("short FUSE request of %d bytes", n)
+		}
+
+		req := &request{
+			opcode: le.Uint32(buf[4:]),
+			unique: le.Uint64(buf[8:]),
+			node:   le.Uint64(buf[16:]),
+			data:   buf[inHeaderSize:n],
+		}
+		if !initialized {
+			if req.opcode != opInit {
+				return fmt.Errorf("expected FUSE INIT, got opcode %d", req.opcode)
+			}
+			if err := s.init(req); err != nil {
+				return err
+			}
+			initialized = true
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(req)
+		}()
+	}
+}
+
+// init negotiates the protocol version and limits
+func (s *Server) init(req *request) error {
+	if len(req.data) < 16 {
+		s.reply(req, syscall.EIO)
+		return errors.New("short FUSE INIT request")
+	}
+	major, minor, readahead, flags := le.Uint32(req.data), le.Uint32(req.data[4:]), le.Uint32(req.data[8:]), le.Uint32(req.data[12:])
+	if major != fuseMajor || minor < minMinor {
+		s.reply(req, syscall.EPROTO)
+		return fmt.Errorf("unsupported FUSE protocol %d.%d", major, minor)
+	}
+	minor = min(minor, fuseMinor)
+
+	// fuse_init_out grew to 64 bytes in 7.23; older kernels expect 24
+	size := 64
+	if minor < 23 {
+		size = 24
+	}
+	out := make([]byte, size)
+	le.PutUint32(out[0:], fuseMajor)
+	le.PutUint32(out[4:], minor)
+	le.PutUint32(out[8:], readahead)
+	le.PutUint32(out[12:], flags&(initAsyncRead|initAtomicOTrunc|initBigWrites))
+	le.PutUint16(out[16:], 16) // max_background
+	le.PutUint16(out[18:], 12) // congestion_threshold
+	le.PutUint32(out[20:], maxWrite)
+	if size == 64 {
+		le.PutUint32(out[24:], 1) // time_gran, in nanoseconds
+	}
+	s.reply(req, 0, out)
+	return nil
+}
+
+// disablePoll makes the kernel stop sending POLL requests. The Go
+// runtime adds every opened file to its epoll set without releasing its
+// processor, and on a FUSE file that waits for a POLL reply: a process
+// reading its own mount with a single processor would deadlock. Polling a
+// probe file answered with ENOSYS turns polling off for the connection.
+func (s *Server) disablePoll() error {
+	fd, err := syscall.Open(filepath.Join(s.dir, pollProbeName), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open poll probe: %w", err)
+	}
+	defer syscall.Close(fd)
+	ep, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(ep)
+	// Not syscall.EpollCtl: that raw syscall would keep the processor the
+	// server needs to answer. Errors only mean polling is already off.
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	syscall.Syscall6(syscall.SYS_EPOLL_CTL, uintptr(ep), syscall.EPOLL_CTL_ADD, uintptr(fd), uintptr(unsafe.Pointer(&event)), 0, 0)
+	return nil
+}
+
+// handleProbe answers the requests made by disablePoll
+func (s *Server) handleProbe(req *request) bool {
+	switch {
+	case req.opcode == opLookup && req.node == rootIno && cstring(req.data) == pollProbeName:
+		s.reply(req, 0, s.entryOut(Attr{Ino: pollProbeIno}))
+	case req.node != pollProbeIno:
+		return false
+	case req.opcode == opGetattr:
+		s.replyAttr(req, Attr{Ino: pollProbeIno}, nil)
+	case req.opcode == opOpen:
+		s.reply(req, 0, openOut(0))
+	case req.opcode == opFlush, req.opcode == opRelease:
+		s.reply(req, 0)
+	case req.opcode == opForget:
+	default:
+		s.reply(req, syscall.ENOSYS)
+	}
+	return true
+}
+
+func (s *Server) handle(req *request) {
+	if s.handleProbe(req) {
+		return
+	}
+	fs := s.fs
+	d := req.data
+
+	switch req.opcode {
+	case opLookup:
+		attr, err := fs.Lookup(req.node, cstring(d))
+		s.replyEntry(req, attr, err)
+
+	case opForget:
+		if len(d) >= 8 {
+			fs.Forget(req.node, le.Uint64(d))
+		}
+		// FORGET has no reply
+
+	case opBatchForget:
+		if len(d) < 8 {
+			return
+		}
+		count := int(le.Uint32(d))
+		for i := 0; i < count && 8+16*(i+1) <= len(d); i++ {
+			entry := d[8+16*i:]
+			fs.Forget(le.Uint64(entry), le.Uint64(entry[8:]))
+		}
+
+	case opGetattr:
+		attr, err := fs.GetAttr(req.node)
+		s.replyAttr(req, attr, err)
+
+	case opSetattr:
+		if len(d) < 24 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		valid, fh, size := le.Uint32(d), le.Uint64(d[8:]), le.Uint64(d[16:])
+		if valid&setattrFH == 0 {
+			fh = 0
+		}
+		// Mode, owner and times are not stored and are accepted silently
+		if valid&setattrSize != 0 {
+			if err := fs.SetSize(req.node, fh, int64(size)); err != nil {
+				s.reply(req, errnoOf(err))
+				return
+			}
+		}
+		attr, err := fs.GetAttr(req.node)
+		s.replyAttr(req, attr, err)
+
+	case opOpen:
+		if len(d) < 4 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		fh, err := fs.Open(req.node, int(le.Uint32(d)))
+		s.replyOpen(req, fh, err)
+
+	case opCreate:
+		// fuse_create_in: flags, mode, umask, open_flags
+		if len(d) < 16 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		attr, fh, err := fs.Create(req.node, cstring(d[16:]))
+		if err != nil {
+			s.reply(req, errnoOf(err))
+			return
+		}
+		s.reply(req, 0, s.entryOut(attr), openOut(fh))
+
+	case opRead:
+		if len(d) < 24 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		data, err := fs.Read(le.Uint64(d), int64(le.Uint64(d[8:])), int(le.Uint32(d[16:])))
+		if err != nil {
+			s.reply(req, errnoOf(err))
+			return
+		}
+		s.reply(req, 0, data)
+
+	case opWrite:
+		// fuse_write_in is 40 bytes, followed by the data
+		if len(d) < 40 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		size := int(le.Uint32(d[16:]))
+		if 40+size > len(d) {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		n, err := fs.Write(le.Uint64(d), int64(le.Uint64(d[8:])), d[40:40+size])
+		if err != nil {
+			s.reply(req, errnoOf(err))
+			return
+		}
+		out := make([]byte, 8)
+		le.PutUint32(out, uint32(n))
+		s.reply(req, 0, out)
+
+	case opFlush, opFsync:
+		if len(d) < 8 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		s.reply(req, errnoOf(fs.Flush(le.Uint64(d))))
+
+	case opRelease:
+		if len(d) < 8 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		s.reply(req, errnoOf(fs.Release(le.Uint64(d))))
+
+	case opOpendir:
+		fh, err := fs.OpenDir(req.node)
+		s.replyOpen(req, fh, err)
+
+	case opReaddir:
+		if len(d) < 24 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		entries, err := fs.ReadDir(le.Uint64(d))
+		if err != nil {
+			s.reply(req, errnoOf(err))
+			return
+		}
+		s.reply(req, 0, dirents(entries, le.Uint64(d[8:]), int(le.Uint32(d[16:]))))
+
+	case opReleasedir:
+		if len(d) >= 8 {
+			fs.ReleaseDir(le.Uint64(d))
+		}
+		s.reply(req, 0)
+
+	case opFsyncdir, opAccess:
+		s.reply(req, 0)
+
+	case opMkdir:
+		// fuse_mkdir_in: mode, umask
+		if len(d) < 8 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		attr, err := fs.Mkdir(req.node, cstring(d[8:]))
+		s.replyEntry(req, attr, err)
+
+	case opUnlink:
+		s.reply(req, errnoOf(fs.Unlink(req.node, cstring(d))))
+
+	case opRmdir:
+		s.reply(req, errnoOf(fs.Rmdir(req.node, cstring(d))))
+
+	case opRename, opRename2:
+		// fuse_rename_in is the new directory; fuse_rename2_in adds flags
+		header := 8
+		if req.opcode == opRename2 {
+			header = 16
+			if len(d) < header || le.Uint32(d[8:]) != 0 {
+				s.reply(req, syscall.EINVAL)
+				return
+			}
+		}
+		if len(d) < header {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		names := bytes.SplitN(d[header:], []byte{0}, 3)
+		if len(names) < 2 {
+			s.reply(req, syscall.EINVAL)
+			return
+		}
+		s.reply(req, errnoOf(fs.Rename(req.node, string(names[0]), le.Uint64(d), string(names[1]))))
+
+	case opStatfs:
+		s.reply(req, 0, statfsOut())
+
+	case opInterrupt:
+		// Requests run to completion; INTERRUPT has no reply
+
+	case opDestroy:
+		s.reply(req, 0)
+
+	default:
+		s.reply(req, syscall.ENOSYS)
+	}
+}
+
+// reply sends the answer to a request: an error, or a success with the
+// concatenated payload
+func (s *Server) reply(req *request, errno syscall.Errno, payload ...[]byte) {
+	size := 16
+	for _, p := range payload {
+		size += len(p)
+	}
+	if errno != 0 {
+		size = 16
+	}
+	out := make([]byte, 16, size)
+	le.PutUint32(out[0:], uint32(size))
+	le.PutUint32(out[4:], uint32(-int32(errno)))
+	le.PutUint64(out[8:], req.unique)
+	if errno == 0 {
+		for _, p := range payload {
+			out = append(out, p...)
+		}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	// ENOENT means the request was interrupted and its reply is unwanted
+	syscall.Write(int(s.dev.Fd()), out)
+}
+
+func (s *Server) replyEntry(req *request, attr Attr, err error) {
+	if err != nil {
+		s.reply(req, errnoOf(err))
+		return
+	}
+	s.reply(req, 0, s.entryOut(attr))
+}
+
+func (s *Server) replyAttr(req *request, attr Attr, err error) {
+	if err != nil {
+		s.reply(req, errnoOf(err))
+		return
+	}
+	// fuse_attr_out: attr_valid, attr_valid_nsec, dummy, attr
+	out := make([]byte, 16)
+	le.PutUint64(out, uint64(attrValid/time.Second))
+	s.reply(req, 0, out, s.attr(attr))
+}
+
+func (s *Server) replyOpen(req *request, fh uint64, err error) {
+	if err != nil {
+		s.reply(req, errnoOf(err))
+		return
+	}
+	s.reply(req, 0, openOut(fh))
+}
+
+// entryOut encodes fuse_entry_out: nodeid, generation, entry_valid,
+// attr_valid, their nanoseconds, then the attributes
+func (s *Server) entryOut(attr Attr) []byte {
+	out := make([]byte, 40)
+	le.PutUint64(out[0:], attr.Ino)
+	le.PutUint64(out[16:], uint64(attrValid/time.Second))
+	le.PutUint64(out[24:], uint64(attrValid/time.Second))
+	return append(out, s.attr(attr)...)
+}
+
+// attr encodes fuse_attr
+func (s *Server) attr(attr Attr) []byte {
+	out := make([]byte, 88)
+	mode, nlink := uint32(syscall.S_IFREG|0o644), uint32(1)
+	if attr.Dir {
+		mode, nlink = syscall.S_IFDIR|0o755, 2
+	}
+	mtime := attr.Mtime.Unix()
+	nsec := uint32(attr.Mtime.Nanosecond())
+	if attr.Mtime.IsZero() {
+		mtime, nsec = s.fs.mounted.Unix(), 0
+	}
+
+	le.PutUint64(out[0:], attr.Ino)
+	le.PutUint64(out[8:], uint64(attr.Size))
+	le.PutUint64(out[16:], uint64((attr.Size+511)/512))
+	le.PutUint64(out[24:], uint64(mtime)) // atime
+	le.PutUint64(out[32:], uint64(mtime))
+	le.PutUint64(out[40:], uint64(mtime)) // ctime
+	le.PutUint32(out[48:], nsec)
+	le.PutUint32(out[52:], nsec)
+	le.PutUint32(out[56:], nsec)
+	le.PutUint32(out[60:], mode)
+	le.PutUint32(out[64:], nlink)
+	le.PutUint32(out[68:], s.fs.uid)
+	le.PutUint32(out[72:], s.fs.gid)
+	le.PutUint32(out[80:], blockSize)
+	return out
+}
+
+// openOut encodes fuse_open_out with no open flags, so the kernel may
+// cache file pages
+func openOut(fh uint64) []byte {
+	out := make([]byte, 16)
+	le.PutUint64(out, fh)
+	return out
+}
+
+// dirents encodes the entries of a listing from index offset that fit in
+// size bytes. Each entry's offset is the index of the next one.
+func dirents(entries []DirEntry, offset uint64, size int) []byte {
+	var out []byte
+	for i := offset; i < uint64(len(entries)); i++ {
+		e := entries[i]
+		recLen := (24 + len(e.Name) + 7) &^ 7
+		if len(out)+recLen > size {
+			break
+		}
+		rec := make([]byte, recLen)
+		le.PutUint64(rec[0:], e.Ino)
+		le.PutUint64(rec[8:], i+1)
+		le.PutUint32(rec[16:], uint32(len(e.Name)))
+		typ := uint32(syscall.DT_REG)
+		if e.Dir {
+			typ = syscall.DT_DIR
+		}
+		le.PutUint32(rec[20:], typ)
+		copy(rec[24:], e.Name)
+		out = append(out, rec...)
+	}
+	return out
+}
+
+// statfsOut encodes fuse_kstatfs. Buckets have no fixed capacity, so a
+// large free space is reported.
+func statfsOut() []byte {
+	const blocks = 1 << 40 / blockSize
+	out := make([]byte, 80)
+	le.PutUint64(out[0:], blocks)  // blocks
+	le.PutUint64(out[8:], blocks)  // bfree
+	le.PutUint64(out[16:], blocks) // bavail
+	le.PutUint64(out[24:], 1<<32)  // files
+	le.PutUint64(out[32:], 1<<32)  // ffree
+	le.PutUint32(out[40:], blockSize)
+	le.PutUint32(out[44:], 1024) // namelen
+	le.PutUint32(out[48:], blockSize)
+	return out
+}
+
+// cstring returns the NUL-terminated string at the start of b
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
+
+// errnoOf converts an FS error to an errno
+func errnoOf(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return syscall.EIO
+}
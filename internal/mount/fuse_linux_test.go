@@ -0,0 +1,114 @@
+//go:build linux
+
+package mount
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mountTestFS mounts a test filesystem, skipping where FUSE mounts are
+// not permitted
+func mountTestFS(t *testing.T, objects map[string]string) (string, *objectServer) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("/dev/fuse is not available")
+	}
+
+	fs, o := newTestFS(t, objects)
+	dir := t.TempDir()
+	server, err := Mount(dir, fs)
+	if err != nil {
+		t.Skipf("FUSE mount not permitted: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := server.Unmount(); err != nil {
+			t.Errorf("Unmount failed: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- server.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Wait failed: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("Wait did not return after unmount")
+		}
+	})
+	return dir, o
+}
+
+func TestMount_FileOperations(t *testing.T) {
+	dir, o := mountTestFS(t, map[string]string{
+		"readme.txt":     "hello",
+		"photos/cat.jpg": "meow",
+	})
+
+	data, err := os.ReadFile(filepath.Join(dir, "photos", "cat.jpg"))
+	if err != nil || string(data) != "meow" {
+		t.Errorf("ReadFile = %q, %v; want meow", data, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"photos", "readme.txt"}) || !entries[0].IsDir() {
+		t.Errorf("ReadDir = %v, want [photos/ readme.txt]", names)
+	}
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 40000) // Spans several kernel writes
+	if err := os.MkdirAll(filepath.Join(dir, "new", "deep"), 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new", "deep", "file.bin"), large, 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	o.mu.Lock()
+	uploaded := o.objects["new/deep/file.bin"]
+	o.mu.Unlock()
+	if !bytes.Equal(uploaded, large) {
+		t.Errorf("uploaded %d bytes, want the %d written", len(uploaded), len(large))
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "readme.txt"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile for append failed: %v", err)
+	}
+	f.WriteString(" world")
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	o.mu.Lock()
+	appended := string(o.objects["readme.txt"])
+	o.mu.Unlock()
+	if appended != "hello world" {
+		t.Errorf("after append object = %q, want hello world", appended)
+	}
+
+	if err := os.Rename(filepath.Join(dir, "readme.txt"), filepath.Join(dir, "photos", "readme.txt")); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "photos", "cat.jpg")); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	o.mu.Lock()
+	_, oldExists := o.objects["readme.txt"]
+	_, catExists := o.objects["photos/cat.jpg"]
+	moved := string(o.objects["photos/readme.txt"])
+	o.mu.Unlock()
+	if oldExists || catExists || moved != "hello world" {
+		t.Errorf("after rename and remove: old=%v cat=%v moved=%q", oldExists, catExists, moved)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package mount
+
+import "errors"
+
+// ErrUnsupported is returned by Mount on platforms without FUSE support
+var ErrUnsupported = errors.New("mounting buckets is only supported on Linux")
+
+// Server serves a mounted filesystem
+type Server struct{}
+
+// Mount is not supported on this platform
+func Mount(dir string, fs *FS) (*Server, error) {
+	return nil, ErrUnsupported
+}
+
+// Wait is not supported on this platform
+func (s *Server) Wait() error {
+	return ErrUnsupported
+}
+
+// Unmount is not supported on this platform
+func (s *Server) Unmount() error {
+	return ErrUnsupported
+}
@@ -0,0 +1,68 @@
+package multipart
+
+import (
+	"context"
+	"time"
+)
+
+// CleanupResult reports the work done by ExpireUploads
+type CleanupResult struct {
+	Uploads        int   `json:"uploads"`
+	Parts          int   `json:"parts"`
+	Sessions       int   `json:"sessions"` // Resumable upload sessions
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+// ExpireUploads aborts uploads and resumable sessions that have seen no
+// activity for longer than maxIdle and frees their data. Without it,
+// abandoned uploads would hold engine space forever.
+func (s *Service) ExpireUploads(ctx context.Context, maxIdle time.Duration) (CleanupResult, error) {
+	var result CleanupResult
+	cutoff := time.Now().Add(-maxIdle)
+
+	s.mu.Lock()
+	var expired []*Upload
+	for id, upload := range s.uploads {
+		if upload.UpdatedAt.Before(cutoff) {
+			expired = append(expired, upload)
+			delete(s.uploads, id)
+		}
+	}
+	var expiredSessions []*Session
+	for id, session := range s.sessions {
+		if session.UpdatedAt.Before(cutoff) && !session.writing {
+			expiredSessions = append(expiredSessions, session)
+			delete(s.sessions, id)
+		}
+	}
+	s.mu.Unlock()
+
+	// Sessions are cheap to free, so they are not put back on cancellation
+	for _, session := range expiredSessions {
+		s.freeSession(session)
+		result.Sessions++
+		result.ReclaimedBytes += session.Size
+	}
+
+	for i, upload := range expired {
+		if err := ctx.Err(); err != nil {
+			// Put back what was not cleaned so the next run picks it up
+			s.mu.Lock()
+			for _, u := range expired[i:] {
+				s.uploads[u.UploadID] = u
+			}
+			s.mu.Unlock()
+			return result, err
+		}
+
+		s.forget(ctx, upload.UploadID)
+		for j := range upload.Parts {
+			s.free(&upload.Parts[j])
+		}
+		result.Uploads++
+		result.Parts += len(upload.Parts)
+		result.ReclaimedBytes += upload.Size()
+	}
+
+	return result, nil
+}
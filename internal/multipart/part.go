@@ -1,9 +1,22 @@
 package multipart
 
+import "time"
+
 // Part represents a part of a multipart upload
 type Part struct {
 	PartNumber int    `json:"part_number"`
 	ETag       string `json:"etag"`
 	Size       int64  `json:"size"`
-	Checksum   string `json:"checksum"`
+	Checksum   string `json:"checksum"` // Hex, of the bucket's first checksum algorithm
+	// Additional S3 checksum supplied by the client, base64 encoded
+	ChecksumAlgorithm string    `json:"checksum_algorithm,omitempty"`
+	ChecksumValue     string    `json:"checksum_value,omitempty"`
+	LastModified      time.Time `json:"last_modified"`
+	Offset            int64     `json:"-"` // Location of the part data in the storage engine
+}
+
+// CompletedPart identifies a part in a CompleteMultipartUpload request
+type CompletedPart struct {
+	PartNumber int    `json:"part_number" xml:"PartNumber"`
+	ETag       string `json:"etag" xml:"ETag"`
 }
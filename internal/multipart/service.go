@@ -3,114 +3,713 @@ package multipart
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/bufpool"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+const (
+	// MaxPartNumber is the highest part number S3 accepts, and the default part limit
+	MaxPartNumber = 10000
+	// DefaultMinPartSize is the S3 minimum size of all parts but the last
+	DefaultMinPartSize = 5 * 1024 * 1024
+	// DefaultMaxUploads is the default page size of ListMultipartUploads
+	DefaultMaxUploads = 1000
+	// DefaultMaxParts is the default page size of ListParts
+	DefaultMaxParts = 1000
+
+	// readChunkSize is how much part data is read from the engine at a time
+	readChunkSize = 1024 * 1024
+)
+
+// partBuffers hold the chunks part data is written to the engine in
+var partBuffers = bufpool.New("multipart_part", 4096)
+
+var (
+	// ErrUploadNotFound is returned for unknown or finished upload IDs
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrInvalidPart is returned when a completed part does not match an uploaded one
+	ErrInvalidPart = errors.New("invalid part")
+	// ErrInvalidPartOrder is returned when completed parts are not in ascending order
+	ErrInvalidPartOrder = errors.New("parts must be listed in ascending order")
+	// ErrInvalidPartNumber is returned for part numbers outside 1..MaxParts
+	ErrInvalidPartNumber = errors.New("invalid part number")
+	// ErrEntityTooSmall is returned when a part other than the last is below the minimum size
+	ErrEntityTooSmall = errors.New("part is smaller than the minimum allowed size")
+	// ErrTooManyParts is returned when a completion lists more parts than allowed
+	ErrTooManyParts = errors.New("too many parts")
+	// ErrCopySourceNotFound is returned when the object to copy a part from does not exist
+	ErrCopySourceNotFound = errors.New("copy source not found")
+	// ErrInvalidCopyRange is returned when a part copy range lies outside the source object
+	ErrInvalidCopyRange = errors.New("copy range outside the source object")
 )
 
-// Service handles multipart upload operations
+// Limits are the part constraints enforced on uploads
+type Limits struct {
+	MinPartSize int64 // Minimum size of all parts but the last; 0 disables the check
+	MaxParts    int   // Highest accepted part number
+}
+
+// DefaultLimits returns the S3 part limits
+func DefaultLimits() Limits {
+	return Limits{
+		MinPartSize: DefaultMinPartSize,
+		MaxParts:    MaxPartNumber,
+	}
+}
+
+// ObjectStore stores assembled objects and reads the sources of part copies
+type ObjectStore interface {
+	PutMultipartObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string, parts []object.PartInfo) (*object.Object, error)
+	HeadObject(ctx context.Context, bucket, key string, versionID *string) (*object.Object, error)
+	GetObjectRange(ctx context.Context, bucket, key string, versionID *string, start, length int64) (*object.Object, io.ReadCloser, error)
+}
+
+// UploadStore persists in-progress uploads so they survive a restart.
+// The service keeps every upload in memory and writes changes through.
+type UploadStore interface {
+	Create(ctx context.Context, upload *Upload) error
+	// PutPart records a part, replacing any with the same number, and
+	// moves the upload's UpdatedAt to the part's LastModified
+	PutPart(ctx context.Context, uploadID string, part *Part) error
+	Delete(ctx context.Context, uploadID string) error
+	List(ctx context.Context) ([]*Upload, error)
+}
+
+// CopySource identifies the data of an UploadPartCopy: Length bytes of an
+// existing object from Start. A negative Length copies the whole object.
+type CopySource struct {
+	Bucket    string
+	Key       string
+	VersionID *string // nil for the latest version
+	Start     int64
+	Length    int64
+}
+
+// ListUploadsOptions defines options for listing in-progress uploads
+type ListUploadsOptions struct {
+	Prefix         string
+	KeyMarker      string
+	UploadIDMarker string
+	MaxUploads     int
+}
+
+// ListUploadsResult is a page of in-progress uploads, ordered by key and
+// then initiation time
+type ListUploadsResult struct {
+	Uploads            []*Upload
+	IsTruncated        bool
+	NextKeyMarker      string
+	NextUploadIDMarker string
+}
+
+// ListPartsOptions defines options for listing the parts of an upload
+type ListPartsOptions struct {
+	PartNumberMarker int
+	MaxParts         int
+}
+
+// ListPartsResult is a page of parts, ordered by part number
+type ListPartsResult struct {
+	Upload               *Upload // Upload without its parts
+	Parts                []Part
+	IsTruncated          bool
+	NextPartNumberMarker int
+}
+
+// Service handles multipart upload operations. Part data is written to the
+// storage engine as it arrives; completing an upload streams the parts
+// into a single object and releases their space.
 type Service struct {
-	uploads map[string]*Upload // In-memory for now
+	engine          storage.Engine
+	objects         ObjectStore
+	limits          Limits
+	checksums       integrity.CalculatorOptions
+	bucketChecksums object.ChecksumAlgorithms
+	uploads         map[string]*Upload
+	store           UploadStore // Optional; uploads only live in memory without it
+	// Resumable upload sessions, also in memory
+	sessions map[string]*Session
+	mu       sync.Mutex
 }
 
 // NewService creates a new multipart service
-func NewService() *Service {
+func NewService(engine storage.Engine, objects ObjectStore) *Service {
 	return &Service{
-		uploads: make(map[string]*Upload),
+		engine:   engine,
+		objects:  objects,
+		limits:   DefaultLimits(),
+		uploads:  make(map[string]*Upload),
+		sessions: make(map[string]*Session),
+	}
+}
+
+// SetLimits overrides the S3 part limits, e.g. for internal deployments
+// that upload smaller parts
+func (s *Service) SetLimits(limits Limits) {
+	if limits.MaxParts <= 0 {
+		limits.MaxParts = MaxPartNumber
+	}
+	if limits.MinPartSize < 0 {
+		limits.MinPartSize = 0
+	}
+	s.limits = limits
+}
+
+// SetChecksums selects the checksums computed for new parts
+func (s *Service) SetChecksums(opts integrity.CalculatorOptions) {
+	s.checksums = opts
+}
+
+// SetBucketChecksums lets buckets choose the algorithms computed for their
+// parts in place of those set by SetChecksums
+func (s *Service) SetBucketChecksums(lookup object.ChecksumAlgorithms) {
+	s.bucketChecksums = lookup
+}
+
+// SetStore persists uploads in store and loads those it already holds
+func (s *Service) SetStore(ctx context.Context, store UploadStore) error {
+	uploads, err := store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load multipart uploads: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store = store
+	for _, upload := range uploads {
+		s.uploads[upload.UploadID] = upload
+	}
+	return nil
+}
+
+// forget removes a finished upload from the store. Its parts are about to
+// be freed, so this is not cut short by a client going away.
+func (s *Service) forget(ctx context.Context, uploadID string) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Delete(context.WithoutCancel(ctx), uploadID); err != nil {
+		monitoring.Log.Warn("Failed to delete stored multipart upload",
+			zap.String("upload_id", uploadID),
+			zap.Error(err))
 	}
 }
 
 // InitiateMultipartUpload initiates a new multipart upload
-func (s *Service) InitiateMultipartUpload(ctx context.Context, bucket, key string) (*Upload, error) {
-	uploadID := uuid.New().String()
+func (s *Service) InitiateMultipartUpload(ctx context.Context, bucket, key, contentType string) (*Upload, error) {
+	now := time.Now()
 	upload := &Upload{
-		UploadID:   uploadID,
-		BucketName: bucket,
-		Key:        key,
-		CreatedAt:  time.Now(),
-		Parts:      make([]Part, 0),
+		UploadID:    uuid.New().String(),
+		BucketName:  bucket,
+		Key:         key,
+		ContentType: contentType,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Parts:       make([]Part, 0),
 	}
 
-	s.uploads[uploadID] = upload
-	return upload, nil
+	if s.store != nil {
+		if err := s.store.Create(ctx, upload); err != nil {
+			return nil, fmt.Errorf("failed to store upload: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.uploads[upload.UploadID] = upload
+	s.mu.Unlock()
+
+	return upload.summary(), nil
 }
 
-// UploadPart handles uploading a part
-func (s *Service) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, size int64, etag string) (*Part, error) {
+// getLocked returns the upload for bucket/key/uploadID. s.mu must be held.
+func (s *Service) getLocked(bucket, key, uploadID string) (*Upload, error) {
 	upload, ok := s.uploads[uploadID]
-	if !ok {
-		return nil, errors.New("upload not found")
+	if !ok || upload.BucketName != bucket || upload.Key != key {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// UploadPart stores a part's data, replacing any earlier part with the
+// same number. If checksum is set the data must match it, and the checksum
+// is kept with the part.
+func (s *Service) UploadPart(ctx context.Context, bucket, key, uploadID string, partNumber int, data io.Reader, size int64, checksum *integrity.Checksum) (*Part, error) {
+	if err := s.checkPart(bucket, key, uploadID, partNumber); err != nil {
+		return nil, err
+	}
+
+	part, err := s.writePart(ctx, bucket, data, size, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.addPart(ctx, bucket, key, uploadID, partNumber, part)
+}
+
+// UploadPartCopy stores a byte range of an existing object as a part, so
+// large objects can be re-chunked without the data leaving the server
+func (s *Service) UploadPartCopy(ctx context.Context, bucket, key, uploadID string, partNumber int, src CopySource) (*Part, error) {
+	if err := s.checkPart(bucket, key, uploadID, partNumber); err != nil {
+		return nil, err
+	}
+
+	meta, err := s.objects.HeadObject(ctx, src.Bucket, src.Key, src.VersionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s/%s: %v", ErrCopySourceNotFound, src.Bucket, src.Key, err)
+	}
+
+	length := src.Length
+	if length < 0 {
+		length = meta.Size - src.Start
+	}
+	if src.Start < 0 || length < 0 || src.Start+length > meta.Size {
+		return nil, fmt.Errorf("%w: %d+%d of %d bytes", ErrInvalidCopyRange, src.Start, length, meta.Size)
+	}
+
+	_, data, err := s.objects.GetObjectRange(ctx, src.Bucket, src.Key, src.VersionID, src.Start, length)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read copy source: %w", err)
+	}
+	defer data.Close()
+
+	part, err := s.writePart(ctx, bucket, data, length, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.addPart(ctx, bucket, key, uploadID, partNumber, part)
+}
+
+// checkPart validates a part number and that the upload exists
+func (s *Service) checkPart(bucket, key, uploadID string, partNumber int) error {
+	if partNumber < 1 || partNumber > s.limits.MaxParts {
+		return fmt.Errorf("%w: %d must be between 1 and %d", ErrInvalidPartNumber, partNumber, s.limits.MaxParts)
 	}
 
-	if partNumber < 1 || partNumber > 10000 {
-		return nil, errors.New("invalid part number")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.getLocked(bucket, key, uploadID)
+	return err
+}
+
+// addPart records a written part, replacing any earlier part with the same number
+func (s *Service) addPart(ctx context.Context, bucket, key, uploadID string, partNumber int, part *Part) (*Part, error) {
+	part.PartNumber = partNumber
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The upload may have been completed or aborted meanwhile
+	upload, err := s.getLocked(bucket, key, uploadID)
+	if err != nil {
+		s.free(part)
+		return nil, err
 	}
 
-	part := Part{
-		PartNumber: partNumber,
-		ETag:       etag,
-		Size:       size,
+	if s.store != nil {
+		if err := s.store.PutPart(ctx, uploadID, part); err != nil {
+			s.free(part)
+			return nil, fmt.Errorf("failed to store part: %w", err)
+		}
 	}
 
+	upload.UpdatedAt = part.LastModified
+
 	// Check if part already exists and replace it
 	found := false
 	for i, p := range upload.Parts {
 		if p.PartNumber == partNumber {
-			upload.Parts[i] = part
+			s.free(&upload.Parts[i])
+			upload.Parts[i] = *part
 			found = true
 			break
 		}
 	}
 
 	if !found {
-		upload.Parts = append(upload.Parts, part)
+		upload.Parts = append(upload.Parts, *part)
+		sort.Slice(upload.Parts, func(i, j int) bool {
+			return upload.Parts[i].PartNumber < upload.Parts[j].PartNumber
+		})
 	}
 
-	return &part, nil
+	return part, nil
 }
 
-// ListParts lists parts for an upload
-func (s *Service) ListParts(ctx context.Context, bucket, key, uploadID string) ([]Part, error) {
-	upload, ok := s.uploads[uploadID]
-	if !ok {
-		return nil, errors.New("upload not found")
+// writePart streams part data into newly allocated engine space,
+// verifying it against checksum if set
+func (s *Service) writePart(ctx context.Context, bucket string, data io.Reader, size int64, checksum *integrity.Checksum) (*Part, error) {
+	opts := s.checksums
+	if s.bucketChecksums != nil {
+		if algorithms := s.bucketChecksums(ctx, bucket); len(algorithms) > 0 {
+			opts.Algorithms = algorithms
+		}
+	}
+	calc, err := integrity.NewCalculatorWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	var sink io.Writer = calc
+
+	var verifier *integrity.S3Verifier
+	if checksum != nil {
+		verifier, err = integrity.NewS3Verifier(*checksum)
+		if err != nil {
+			return nil, err
+		}
+		sink = io.MultiWriter(calc, verifier)
+	}
+
+	offset, err := s.engine.Allocate(size)
+	if err != nil {
+		return nil, err
+	}
+
+	tee := io.TeeReader(io.LimitReader(data, size), sink)
+
+	buf := partBuffers.Get()
+	defer partBuffers.Put(buf)
+	written := int64(0)
+	for written < size {
+		n, rErr := tee.Read(buf)
+		if n > 0 {
+			if wErr := s.engine.Write(ctx, offset+written, buf[:n]); wErr != nil {
+				s.engine.Free(offset, size)
+				return nil, wErr
+			}
+			written += int64(n)
+		}
+		if rErr == io.EOF {
+			break
+		}
+		if rErr != nil {
+			s.engine.Free(offset, size)
+			return nil, rErr
+		}
+	}
+
+	if written != size {
+		s.engine.Free(offset, size)
+		return nil, fmt.Errorf("incomplete part: got %d of %d bytes", written, size)
+	}
+	if err := storage.Flush(ctx, s.engine, offset, size); err != nil {
+		s.engine.Free(offset, size)
+		return nil, err
+	}
+
+	part := &Part{
+		Size:         size,
+		LastModified: time.Now(),
+		Offset:       offset,
+	}
+
+	if verifier != nil {
+		if err := verifier.Verify(); err != nil {
+			s.engine.Free(offset, size)
+			return nil, err
+		}
+		part.ChecksumAlgorithm = verifier.Checksum().Algorithm
+		part.ChecksumValue = verifier.Checksum().Value
+	}
+
+	part.ETag = calc.ETag()
+	part.Checksum = calc.Checksum().Value
+	return part, nil
+}
+
+// free releases a part's engine space
+func (s *Service) free(part *Part) {
+	if err := s.engine.Free(part.Offset, part.Size); err != nil {
+		monitoring.Log.Warn("Failed to free multipart part storage",
+			zap.Int("part_number", part.PartNumber),
+			zap.Int64("offset", part.Offset),
+			zap.Int64("size", part.Size),
+			zap.Error(err))
+	}
+}
+
+// StoredExtents calls fn with the location in the storage engine of the
+// parts of every upload in progress, so the engine's allocations can be
+// restored after a restart. Only uploads loaded from a store survive one.
+func (s *Service) StoredExtents(fn func(offset, size int64) error) error {
+	s.mu.Lock()
+	var parts []Part
+	for _, upload := range s.uploads {
+		parts = append(parts, upload.Parts...)
+	}
+	s.mu.Unlock()
+
+	for _, part := range parts {
+		if part.Size == 0 {
+			continue
+		}
+		if err := fn(part.Offset, part.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListParts lists the parts of an upload, a page at a time
+func (s *Service) ListParts(ctx context.Context, bucket, key, uploadID string, opts ListPartsOptions) (*ListPartsResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, err := s.getLocked(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	maxParts := opts.MaxParts
+	if maxParts <= 0 || maxParts > DefaultMaxParts {
+		maxParts = DefaultMaxParts
+	}
+
+	result := &ListPartsResult{
+		Upload: upload.summary(),
+		Parts:  make([]Part, 0),
+	}
+	for _, p := range upload.Parts {
+		if p.PartNumber <= opts.PartNumberMarker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, p)
+	}
+
+	if result.IsTruncated {
+		result.NextPartNumberMarker = result.Parts[len(result.Parts)-1].PartNumber
 	}
 
-	// Sort parts by part number
-	sort.Slice(upload.Parts, func(i, j int) bool {
-		return upload.Parts[i].PartNumber < upload.Parts[j].PartNumber
+	return result, nil
+}
+
+// ListMultipartUploads lists in-progress uploads in a bucket, a page at a time
+func (s *Service) ListMultipartUploads(ctx context.Context, bucket string, opts ListUploadsOptions) (*ListUploadsResult, error) {
+	maxUploads := opts.MaxUploads
+	if maxUploads <= 0 || maxUploads > DefaultMaxUploads {
+		maxUploads = DefaultMaxUploads
+	}
+
+	s.mu.Lock()
+	var uploads []*Upload
+	for _, u := range s.uploads {
+		if u.BucketName == bucket && strings.HasPrefix(u.Key, opts.Prefix) {
+			uploads = append(uploads, u.summary())
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(uploads, func(i, j int) bool {
+		a, b := uploads[i], uploads[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.UploadID < b.UploadID
 	})
 
-	return upload.Parts, nil
+	// Skip everything up to the marker: all uploads for keys before the key
+	// marker, and for the marker key those up to the upload ID marker
+	start := 0
+	for start < len(uploads) && uploads[start].Key < opts.KeyMarker {
+		start++
+	}
+	if opts.KeyMarker != "" {
+		end := start
+		for end < len(uploads) && uploads[end].Key == opts.KeyMarker {
+			end++
+		}
+		if opts.UploadIDMarker == "" {
+			start = end
+		} else {
+			for i := start; i < end; i++ {
+				if uploads[i].UploadID == opts.UploadIDMarker {
+					start = i + 1
+					break
+				}
+			}
+		}
+	}
+	uploads = uploads[start:]
+
+	result := &ListUploadsResult{}
+	if len(uploads) > maxUploads {
+		uploads = uploads[:maxUploads]
+		result.IsTruncated = true
+		last := uploads[len(uploads)-1]
+		result.NextKeyMarker = last.Key
+		result.NextUploadIDMarker = last.UploadID
+	}
+	result.Uploads = uploads
+
+	return result, nil
 }
 
-// CompleteMultipartUpload completes a multipart upload
-func (s *Service) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []Part) error {
-	upload, ok := s.uploads[uploadID]
-	if !ok {
-		return errors.New("upload not found")
+// CompleteMultipartUpload assembles the listed parts, in order, into the
+// final object. Parts that were uploaded but not listed are discarded.
+func (s *Service) CompleteMultipartUpload(ctx context.Context, bucket, key, uploadID string, parts []CompletedPart) (*object.Object, error) {
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: at least one part is required", ErrInvalidPart)
 	}
 
-	// Verify parts
-	if len(parts) != len(upload.Parts) {
-		// This is a simple check, real impl should verify each part ETag/Checksum
+	// Remove the upload first so concurrent part uploads or a second
+	// completion cannot race with assembly
+	s.mu.Lock()
+	upload, err := s.getLocked(bucket, key, uploadID)
+	if err == nil {
+		delete(s.uploads, uploadID)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
 	}
 
-	// Merge parts (logic omitted for now as it requires storage engine interaction)
+	selected, err := selectParts(upload, parts, s.limits)
+	if err != nil {
+		// Leave the upload in place so the client can retry with a valid list
+		s.mu.Lock()
+		s.uploads[uploadID] = upload
+		s.mu.Unlock()
+		return nil, err
+	}
 
-	delete(s.uploads, uploadID)
-	return nil
+	var size int64
+	readers := make([]io.Reader, 0, len(selected))
+	layout := make([]object.PartInfo, 0, len(selected))
+	for _, p := range selected {
+		size += p.Size
+		readers = append(readers, &partReader{ctx: ctx, engine: s.engine, offset: p.Offset, remaining: p.Size})
+		layout = append(layout, object.PartInfo{PartNumber: p.PartNumber, Size: p.Size, ETag: p.ETag})
+	}
+
+	obj, err := s.objects.PutMultipartObject(ctx, bucket, key, io.MultiReader(readers...), size, upload.ContentType, layout)
+	if err != nil {
+		s.mu.Lock()
+		s.uploads[uploadID] = upload
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to assemble object: %w", err)
+	}
+
+	s.forget(ctx, uploadID)
+	for i := range upload.Parts {
+		s.free(&upload.Parts[i])
+	}
+
+	return obj, nil
 }
 
-// AbortMultipartUpload aborts a multipart upload
-func (s *Service) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
-	if _, ok := s.uploads[uploadID]; !ok {
-		return errors.New("upload not found")
+// selectParts matches a completion list against the uploaded parts and
+// enforces the part limits
+func selectParts(upload *Upload, parts []CompletedPart, limits Limits) ([]Part, error) {
+	if len(parts) > limits.MaxParts {
+		return nil, fmt.Errorf("%w: %d listed, at most %d allowed", ErrTooManyParts, len(parts), limits.MaxParts)
+	}
+
+	byNumber := make(map[int]Part, len(upload.Parts))
+	for _, p := range upload.Parts {
+		byNumber[p.PartNumber] = p
+	}
+
+	selected := make([]Part, 0, len(parts))
+	last := 0
+	for _, cp := range parts {
+		if cp.PartNumber <= last {
+			return nil, ErrInvalidPartOrder
+		}
+		last = cp.PartNumber
+
+		p, ok := byNumber[cp.PartNumber]
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d was not uploaded", ErrInvalidPart, cp.PartNumber)
+		}
+		if etag := strings.Trim(cp.ETag, `"`); etag != "" && etag != p.ETag {
+			return nil, fmt.Errorf("%w: part %d ETag mismatch", ErrInvalidPart, cp.PartNumber)
+		}
+		selected = append(selected, p)
+	}
+
+	for _, p := range selected[:len(selected)-1] {
+		if p.Size < limits.MinPartSize {
+			return nil, fmt.Errorf("%w: part %d is %d bytes, minimum is %d", ErrEntityTooSmall, p.PartNumber, p.Size, limits.MinPartSize)
+		}
 	}
 
-	// Cleanup parts (logic omitted)
+	return selected, nil
+}
+
+// AbortMultipartUpload aborts a multipart upload and frees its parts
+func (s *Service) AbortMultipartUpload(ctx context.Context, bucket, key, uploadID string) error {
+	s.mu.Lock()
+	upload, err := s.getLocked(bucket, key, uploadID)
+	if err == nil {
+		delete(s.uploads, uploadID)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
 
-	delete(s.uploads, uploadID)
+	s.forget(ctx, uploadID)
+	for i := range upload.Parts {
+		s.free(&upload.Parts[i])
+	}
 	return nil
 }
+
+// summary returns a copy of the upload without its parts
+func (u *Upload) summary() *Upload {
+	c := *u
+	c.Parts = nil
+	return &c
+}
+
+// partReader streams a part's data from the storage engine in chunks
+type partReader struct {
+	ctx       context.Context
+	engine    storage.Engine
+	offset    int64
+	remaining int64
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	n := int64(len(p))
+	if n > r.remaining {
+		n = r.remaining
+	}
+	if n > readChunkSize {
+		n = readChunkSize
+	}
+
+	data, err := r.engine.Read(r.ctx, r.offset, n)
+	if err != nil {
+		return 0, err
+	}
+
+	copy(p, data)
+	r.offset += n
+	r.remaining -= n
+	return int(n), nil
+}
@@ -0,0 +1,482 @@
+package multipart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+// memEngine is a flat in-memory device with bump allocation
+type memEngine struct {
+	data  []byte
+	freed int64
+}
+
+func (m *memEngine) Open(devicePath string) error { return nil }
+func (m *memEngine) Close() error                 { return nil }
+func (m *memEngine) Sync() error                  { return nil }
+func (m *memEngine) Stats() storage.Stats         { return storage.Stats{} }
+func (m *memEngine) BlockSize() int               { return 4096 }
+
+func (m *memEngine) Allocate(size int64) (int64, error) {
+	offset := int64(len(m.data))
+	m.data = append(m.data, make([]byte, size)...)
+	return offset, nil
+}
+
+func (m *memEngine) Write(ctx context.Context, offset int64, data []byte) error {
+	copy(m.data[offset:], data)
+	return nil
+}
+
+func (m *memEngine) Read(ctx context.Context, offset, size int64) ([]byte, error) {
+	return append([]byte{}, m.data[offset:offset+size]...), nil
+}
+
+func (m *memEngine) Free(offset, size int64) error {
+	m.freed += size
+	return nil
+}
+
+// fakeObjects is an in-memory ObjectStore
+type fakeObjects struct {
+	objects     map[string]string // Key: bucket/key
+	contentType string
+}
+
+func newFakeObjects() *fakeObjects {
+	return &fakeObjects{objects: make(map[string]string)}
+}
+
+func (f *fakeObjects) PutMultipartObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string, parts []object.PartInfo) (*object.Object, error) {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) != size {
+		return nil, fmt.Errorf("size mismatch: got %d, want %d", len(b), size)
+	}
+	f.objects[bucket+"/"+key] = string(b)
+	f.contentType = contentType
+	return &object.Object{BucketName: bucket, Key: key, Size: size, ContentType: contentType, Parts: parts}, nil
+}
+
+func (f *fakeObjects) HeadObject(ctx context.Context, bucket, key string, versionID *string) (*object.Object, error) {
+	data, ok := f.objects[bucket+"/"+key]
+	if !ok {
+		return nil, errors.New("object not found")
+	}
+	return &object.Object{BucketName: bucket, Key: key, Size: int64(len(data))}, nil
+}
+
+func (f *fakeObjects) GetObjectRange(ctx context.Context, bucket, key string, versionID *string, start, length int64) (*object.Object, io.ReadCloser, error) {
+	obj, err := f.HeadObject(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := f.objects[bucket+"/"+key][start : start+length]
+	return obj, io.NopCloser(strings.NewReader(data)), nil
+}
+
+// newTestService returns a service without a minimum part size, so tests
+// can use small parts
+func newTestService(engine *memEngine, objects *fakeObjects) *Service {
+	s := NewService(engine, objects)
+	s.SetLimits(Limits{MinPartSize: 0})
+	return s
+}
+
+func uploadPart(t *testing.T, s *Service, upload *Upload, n int, data string) *Part {
+	t.Helper()
+	part, err := s.UploadPart(context.Background(), upload.BucketName, upload.Key, upload.UploadID, n, strings.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatalf("UploadPart(%d) error = %v", n, err)
+	}
+	return part
+}
+
+func TestService_CompleteMultipartUpload(t *testing.T) {
+	engine := &memEngine{}
+	objects := newFakeObjects()
+	s := newTestService(engine, objects)
+	ctx := context.Background()
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "big.txt", "text/plain")
+	p1 := uploadPart(t, s, upload, 1, "hello ")
+	uploadPart(t, s, upload, 3, "ignored")
+	p2 := uploadPart(t, s, upload, 2, "world")
+
+	_, err := s.CompleteMultipartUpload(ctx, "bucket", "big.txt", upload.UploadID, []CompletedPart{
+		{PartNumber: 1, ETag: `"` + p1.ETag + `"`},
+		{PartNumber: 2, ETag: p2.ETag},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+
+	if got := objects.objects["bucket/big.txt"]; got != "hello world" {
+		t.Errorf("assembled data = %q, want %q", got, "hello world")
+	}
+	if objects.contentType != "text/plain" {
+		t.Errorf("content type = %q, want text/plain", objects.contentType)
+	}
+	if engine.freed != int64(len("hello world")+len("ignored")) {
+		t.Errorf("freed = %d, want all part space released", engine.freed)
+	}
+
+	if _, err := s.ListParts(ctx, "bucket", "big.txt", upload.UploadID, ListPartsOptions{}); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("ListParts() after completion error = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestService_CompleteMultipartUpload_Invalid(t *testing.T) {
+	s := newTestService(&memEngine{}, newFakeObjects())
+	ctx := context.Background()
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "key", "")
+	uploadPart(t, s, upload, 1, "a")
+	uploadPart(t, s, upload, 2, "b")
+
+	tests := []struct {
+		name  string
+		parts []CompletedPart
+		want  error
+	}{
+		{"empty", nil, ErrInvalidPart},
+		{"out of order", []CompletedPart{{PartNumber: 2}, {PartNumber: 1}}, ErrInvalidPartOrder},
+		{"missing part", []CompletedPart{{PartNumber: 1}, {PartNumber: 5}}, ErrInvalidPart},
+		{"etag mismatch", []CompletedPart{{PartNumber: 1, ETag: "bogus"}}, ErrInvalidPart},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CompleteMultipartUpload(ctx, "bucket", "key", upload.UploadID, tt.parts)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// A rejected part list leaves the upload in place
+	if _, err := s.ListParts(ctx, "bucket", "key", upload.UploadID, ListPartsOptions{}); err != nil {
+		t.Errorf("ListParts() error = %v, want upload kept", err)
+	}
+}
+
+func TestService_UploadPart_Replace(t *testing.T) {
+	engine := &memEngine{}
+	s := newTestService(engine, newFakeObjects())
+	ctx := context.Background()
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "key", "")
+	uploadPart(t, s, upload, 1, "first")
+	second := uploadPart(t, s, upload, 1, "second!")
+
+	result, _ := s.ListParts(ctx, "bucket", "key", upload.UploadID, ListPartsOptions{})
+	if len(result.Parts) != 1 || result.Parts[0].ETag != second.ETag {
+		t.Fatalf("parts = %+v, want only the replacement", result.Parts)
+	}
+	if engine.freed != int64(len("first")) {
+		t.Errorf("freed = %d, want the replaced part released", engine.freed)
+	}
+
+	if _, err := s.UploadPart(ctx, "bucket", "key", upload.UploadID, 0, strings.NewReader("x"), 1, nil); !errors.Is(err, ErrInvalidPartNumber) {
+		t.Errorf("UploadPart(0) error = %v, want ErrInvalidPartNumber", err)
+	}
+	if _, err := s.UploadPart(ctx, "bucket", "other", upload.UploadID, 1, strings.NewReader("x"), 1, nil); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("UploadPart() for another key error = %v, want ErrUploadNotFound", err)
+	}
+	if _, err := s.UploadPart(ctx, "bucket", "key", upload.UploadID, 2, strings.NewReader("x"), 5, nil); err == nil {
+		t.Error("UploadPart() with short body should fail")
+	}
+}
+
+func TestService_ListParts_Pagination(t *testing.T) {
+	s := newTestService(&memEngine{}, newFakeObjects())
+	ctx := context.Background()
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "key", "")
+	for n := 1; n <= 5; n++ {
+		uploadPart(t, s, upload, n, "data")
+	}
+
+	var got []int
+	marker := 0
+	for pages := 0; pages < 10; pages++ {
+		result, err := s.ListParts(ctx, "bucket", "key", upload.UploadID, ListPartsOptions{PartNumberMarker: marker, MaxParts: 2})
+		if err != nil {
+			t.Fatalf("ListParts() error = %v", err)
+		}
+		for _, p := range result.Parts {
+			got = append(got, p.PartNumber)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	if fmt.Sprint(got) != "[1 2 3 4 5]" {
+		t.Errorf("paged parts = %v, want [1 2 3 4 5]", got)
+	}
+}
+
+func TestService_ListMultipartUploads_Pagination(t *testing.T) {
+	s := newTestService(&memEngine{}, newFakeObjects())
+	ctx := context.Background()
+
+	var ids []string
+	for _, key := range []string{"b", "a", "b", "c", "logs/x"} {
+		u, _ := s.InitiateMultipartUpload(ctx, "bucket", key, "")
+		ids = append(ids, u.UploadID)
+	}
+	s.InitiateMultipartUpload(ctx, "other", "a", "")
+
+	var keys []string
+	opts := ListUploadsOptions{MaxUploads: 2}
+	for pages := 0; pages < 10; pages++ {
+		result, err := s.ListMultipartUploads(ctx, "bucket", opts)
+		if err != nil {
+			t.Fatalf("ListMultipartUploads() error = %v", err)
+		}
+		for _, u := range result.Uploads {
+			keys = append(keys, u.Key)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		opts.KeyMarker = result.NextKeyMarker
+		opts.UploadIDMarker = result.NextUploadIDMarker
+	}
+
+	if fmt.Sprint(keys) != "[a b b c logs/x]" {
+		t.Errorf("paged keys = %v, want [a b b c logs/x]", keys)
+	}
+
+	result, _ := s.ListMultipartUploads(ctx, "bucket", ListUploadsOptions{Prefix: "logs/"})
+	if len(result.Uploads) != 1 || result.Uploads[0].UploadID != ids[4] {
+		t.Errorf("prefix listing = %+v, want only logs/x", result.Uploads)
+	}
+
+	// A key marker alone skips every upload of that key
+	result, _ = s.ListMultipartUploads(ctx, "bucket", ListUploadsOptions{KeyMarker: "b"})
+	if len(result.Uploads) != 2 || result.Uploads[0].Key != "c" {
+		t.Errorf("key marker listing = %+v, want c and logs/x", result.Uploads)
+	}
+}
+
+func TestService_AbortMultipartUpload(t *testing.T) {
+	engine := &memEngine{}
+	s := newTestService(engine, newFakeObjects())
+	ctx := context.Background()
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "key", "")
+	uploadPart(t, s, upload, 1, "data")
+
+	if err := s.AbortMultipartUpload(ctx, "bucket", "key", upload.UploadID); err != nil {
+		t.Fatalf("AbortMultipartUpload() error = %v", err)
+	}
+	if engine.freed != 4 {
+		t.Errorf("freed = %d, want 4", engine.freed)
+	}
+	if err := s.AbortMultipartUpload(ctx, "bucket", "key", upload.UploadID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("second abort error = %v, want ErrUploadNotFound", err)
+	}
+}
+
+func TestService_UploadPart_Checksum(t *testing.T) {
+	s := newTestService(&memEngine{}, newFakeObjects())
+	ctx := context.Background()
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "key", "")
+
+	// SHA256 of "data", base64 encoded
+	good := &integrity.Checksum{Algorithm: "sha256", Value: "Om6weQ85rIfJTzhWst0sXREOaBFgImGpqSPTuyOtyLc="}
+	part, err := s.UploadPart(ctx, "bucket", "key", upload.UploadID, 1, strings.NewReader("data"), 4, good)
+	if err != nil {
+		t.Fatalf("UploadPart() error = %v", err)
+	}
+	if part.ChecksumAlgorithm != integrity.AlgorithmSHA256 || part.ChecksumValue != good.Value {
+		t.Errorf("part checksum = %s %s, want the supplied SHA256", part.ChecksumAlgorithm, part.ChecksumValue)
+	}
+
+	bad := &integrity.Checksum{Algorithm: "CRC32", Value: "AAAAAA=="}
+	if _, err := s.UploadPart(ctx, "bucket", "key", upload.UploadID, 2, strings.NewReader("data"), 4, bad); !errors.Is(err, integrity.ErrChecksumMismatch) {
+		t.Errorf("UploadPart() with wrong checksum error = %v, want ErrChecksumMismatch", err)
+	}
+
+	unknown := &integrity.Checksum{Algorithm: "MD4", Value: "x"}
+	if _, err := s.UploadPart(ctx, "bucket", "key", upload.UploadID, 2, strings.NewReader("data"), 4, unknown); !errors.Is(err, integrity.ErrUnsupportedAlgorithm) {
+		t.Errorf("UploadPart() with unknown algorithm error = %v, want ErrUnsupportedAlgorithm", err)
+	}
+
+	result, _ := s.ListParts(ctx, "bucket", "key", upload.UploadID, ListPartsOptions{})
+	if len(result.Parts) != 1 {
+		t.Errorf("parts = %d, want rejected parts discarded", len(result.Parts))
+	}
+}
+
+func TestService_UploadPartCopy(t *testing.T) {
+	engine := &memEngine{}
+	objects := newFakeObjects()
+	objects.objects["src/big"] = "0123456789"
+	s := newTestService(engine, objects)
+	ctx := context.Background()
+
+	// Re-chunk src/big into two parts of a new object
+	upload, _ := s.InitiateMultipartUpload(ctx, "dst", "copy", "")
+	if _, err := s.UploadPartCopy(ctx, "dst", "copy", upload.UploadID, 1, CopySource{Bucket: "src", Key: "big", Start: 0, Length: 4}); err != nil {
+		t.Fatalf("UploadPartCopy(1) error = %v", err)
+	}
+	if _, err := s.UploadPartCopy(ctx, "dst", "copy", upload.UploadID, 2, CopySource{Bucket: "src", Key: "big", Start: 4, Length: -1}); err != nil {
+		t.Fatalf("UploadPartCopy(2) error = %v", err)
+	}
+
+	if _, err := s.UploadPartCopy(ctx, "dst", "copy", upload.UploadID, 3, CopySource{Bucket: "src", Key: "big", Start: 8, Length: 5}); !errors.Is(err, ErrInvalidCopyRange) {
+		t.Errorf("out of range copy error = %v, want ErrInvalidCopyRange", err)
+	}
+	if _, err := s.UploadPartCopy(ctx, "dst", "copy", upload.UploadID, 3, CopySource{Bucket: "src", Key: "missing", Length: -1}); !errors.Is(err, ErrCopySourceNotFound) {
+		t.Errorf("missing source copy error = %v, want ErrCopySourceNotFound", err)
+	}
+
+	if _, err := s.CompleteMultipartUpload(ctx, "dst", "copy", upload.UploadID, []CompletedPart{{PartNumber: 1}, {PartNumber: 2}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+	if got := objects.objects["dst/copy"]; got != "0123456789" {
+		t.Errorf("copied object = %q, want 0123456789", got)
+	}
+}
+
+func TestService_CompleteMultipartUpload_Limits(t *testing.T) {
+	s := NewService(&memEngine{}, newFakeObjects())
+	s.SetLimits(Limits{MinPartSize: 4, MaxParts: 3})
+	ctx := context.Background()
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "key", "")
+	uploadPart(t, s, upload, 1, "four")
+	uploadPart(t, s, upload, 2, "sm")
+	uploadPart(t, s, upload, 3, "x")
+
+	if _, err := s.UploadPart(ctx, "bucket", "key", upload.UploadID, 4, strings.NewReader("x"), 1, nil); !errors.Is(err, ErrInvalidPartNumber) {
+		t.Errorf("UploadPart(4) error = %v, want ErrInvalidPartNumber", err)
+	}
+
+	tests := []struct {
+		name  string
+		parts []CompletedPart
+		want  error
+	}{
+		{"small middle part", []CompletedPart{{PartNumber: 1}, {PartNumber: 2}, {PartNumber: 3}}, ErrEntityTooSmall},
+		{"too many parts", []CompletedPart{{PartNumber: 1}, {PartNumber: 2}, {PartNumber: 3}, {PartNumber: 4}}, ErrTooManyParts},
+		{"small last part", []CompletedPart{{PartNumber: 1}, {PartNumber: 3}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CompleteMultipartUpload(ctx, "bucket", "key", upload.UploadID, tt.parts)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_ExpireUploads(t *testing.T) {
+	engine := &memEngine{}
+	s := newTestService(engine, newFakeObjects())
+	ctx := context.Background()
+
+	stale, _ := s.InitiateMultipartUpload(ctx, "bucket", "stale", "")
+	uploadPart(t, s, stale, 1, "abc")
+	uploadPart(t, s, stale, 2, "de")
+	fresh, _ := s.InitiateMultipartUpload(ctx, "bucket", "fresh", "")
+	uploadPart(t, s, fresh, 1, "xyz")
+
+	s.mu.Lock()
+	s.uploads[stale.UploadID].UpdatedAt = time.Now().Add(-2 * time.Hour)
+	s.mu.Unlock()
+
+	result, err := s.ExpireUploads(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("ExpireUploads() error = %v", err)
+	}
+	if result.Uploads != 1 || result.Parts != 2 || result.ReclaimedBytes != 5 {
+		t.Errorf("result = %+v, want 1 upload, 2 parts, 5 bytes", result)
+	}
+	if engine.freed != 5 {
+		t.Errorf("freed = %d, want 5", engine.freed)
+	}
+
+	if _, err := s.ListParts(ctx, "bucket", "stale", stale.UploadID, ListPartsOptions{}); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("stale upload still present: %v", err)
+	}
+	if _, err := s.ListParts(ctx, "bucket", "fresh", fresh.UploadID, ListPartsOptions{}); err != nil {
+		t.Errorf("fresh upload removed: %v", err)
+	}
+}
+
+func TestService_SQLiteStore_SurvivesRestart(t *testing.T) {
+	db, err := database.Open(database.Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatalf("database.Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	engine := &memEngine{}
+	objects := newFakeObjects()
+	s := newTestService(engine, objects)
+	if err := s.SetStore(ctx, NewSQLiteStore(db)); err != nil {
+		t.Fatalf("SetStore() error = %v", err)
+	}
+
+	upload, _ := s.InitiateMultipartUpload(ctx, "bucket", "big.txt", "text/plain")
+	uploadPart(t, s, upload, 1, "hello ")
+	uploadPart(t, s, upload, 2, "replaced")
+	p2 := uploadPart(t, s, upload, 2, "world")
+
+	// A new service over the same engine and database resumes the upload
+	restarted := newTestService(engine, objects)
+	if err := restarted.SetStore(ctx, NewSQLiteStore(db)); err != nil {
+		t.Fatalf("SetStore() after restart error = %v", err)
+	}
+	parts, err := restarted.ListParts(ctx, "bucket", "big.txt", upload.UploadID, ListPartsOptions{})
+	if err != nil {
+		t.Fatalf("ListParts() error = %v", err)
+	}
+	if len(parts.Parts) != 2 || parts.Parts[1].ETag != p2.ETag {
+		t.Fatalf("parts = %+v, want 2 with the replacement last", parts.Parts)
+	}
+
+	if _, err := restarted.CompleteMultipartUpload(ctx, "bucket", "big.txt", upload.UploadID, []CompletedPart{
+		{PartNumber: 1}, {PartNumber: 2},
+	}); err != nil {
+		t.Fatalf("CompleteMultipartUpload() error = %v", err)
+	}
+	if got := objects.objects["bucket/big.txt"]; got != "hello world" {
+		t.Errorf("object = %q, want %q", got, "hello world")
+	}
+
+	// Completed uploads are gone from the store
+	stored, err := NewSQLiteStore(db).List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 0 {
+		t.Errorf("%d uploads still stored after completion", len(stored))
+	}
+}
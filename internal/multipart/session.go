@@ -0,0 +1,245 @@
+package multipart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/danielino/comio/internal/bufpool"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// sessionBuffers hold how much chunk data is written to the engine, and
+// acknowledged, at a time
+var sessionBuffers = bufpool.New("multipart_session", 256*1024)
+
+var (
+	// ErrSessionNotFound is returned for unknown or finished session IDs
+	ErrSessionNotFound = errors.New("resumable upload session not found")
+	// ErrInvalidSessionSize is returned when a session is created without a positive size
+	ErrInvalidSessionSize = errors.New("resumable upload size must be positive")
+	// ErrOffsetMismatch is returned for chunks that do not start where the
+	// data received so far ends
+	ErrOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+	// ErrChunkOutOfRange is returned for chunks extending past the upload size
+	ErrChunkOutOfRange = errors.New("chunk extends past the upload size")
+	// ErrIncompleteChunk is returned when a chunk's body ends early. The
+	// bytes that arrived are kept.
+	ErrIncompleteChunk = errors.New("chunk body ended early")
+	// ErrSessionBusy is returned while another chunk of the session is being written
+	ErrSessionBusy = errors.New("another chunk is being written")
+	// ErrSessionIncomplete is returned when finishing a session still missing data
+	ErrSessionIncomplete = errors.New("resumable upload is incomplete")
+)
+
+// Session is a resumable upload: an object of known size sent as chunks
+// at increasing offsets. Unlike multipart uploads there is no minimum
+// chunk size, and every byte written is kept, so a client on an unstable
+// link asks for the offset after a failure and continues from there.
+type Session struct {
+	SessionID   string    `json:"session_id"`
+	BucketName  string    `json:"bucket_name"`
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"` // Bytes received so far
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"` // Last data received
+
+	dataOffset int64 // Location of the upload's space in the storage engine
+	writing    bool  // A chunk is being written
+}
+
+// CreateSession starts a resumable upload of size bytes, reserving the
+// space for all of it up front
+func (s *Service) CreateSession(ctx context.Context, bucket, key, contentType string, size int64) (*Session, error) {
+	if size <= 0 {
+		return nil, ErrInvalidSessionSize
+	}
+
+	dataOffset, err := s.engine.Allocate(size)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		SessionID:   uuid.New().String(),
+		BucketName:  bucket,
+		Key:         key,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		dataOffset:  dataOffset,
+	}
+
+	s.mu.Lock()
+	s.sessions[session.SessionID] = session
+	s.mu.Unlock()
+
+	c := *session
+	return &c, nil
+}
+
+// getSessionLocked returns the session for bucket/key/sessionID. s.mu must be held.
+func (s *Service) getSessionLocked(bucket, key, sessionID string) (*Session, error) {
+	session, ok := s.sessions[sessionID]
+	if !ok || session.BucketName != bucket || session.Key != key {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// GetSession returns the state of a session, so a client can resume from
+// its offset
+func (s *Service) GetSession(ctx context.Context, bucket, key, sessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.getSessionLocked(bucket, key, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	c := *session
+	return &c, nil
+}
+
+// WriteChunk writes length bytes of data at offset, which must be the
+// session's current offset. The offset advances as data is written, so
+// when the body ends early the bytes received still count and the
+// returned session reports where to resume.
+func (s *Service) WriteChunk(ctx context.Context, bucket, key, sessionID string, offset int64, data io.Reader, length int64) (*Session, error) {
+	s.mu.Lock()
+	session, err := s.getSessionLocked(bucket, key, sessionID)
+	if err == nil {
+		switch {
+		case session.writing:
+			err = ErrSessionBusy
+		case offset != session.Offset:
+			err = fmt.Errorf("%w: got %d, upload is at %d", ErrOffsetMismatch, offset, session.Offset)
+		case length < 0 || offset+length > session.Size:
+			err = fmt.Errorf("%w: %d+%d of %d bytes", ErrChunkOutOfRange, offset, length, session.Size)
+		default:
+			session.writing = true
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		s.mu.Lock()
+		session.writing = false
+		s.mu.Unlock()
+	}()
+
+	buf := sessionBuffers.Get()
+	defer sessionBuffers.Put(buf)
+	src := io.LimitReader(data, length)
+	written := int64(0)
+	for written < length {
+		n, rErr := io.ReadFull(src, buf[:min(int64(len(buf)), length-written)])
+		if n > 0 {
+			at := session.dataOffset + offset + written
+			if wErr := s.engine.Write(ctx, at, buf[:n]); wErr != nil {
+				return s.sessionCopy(session), wErr
+			}
+			// Acknowledged data must be on the device
+			if fErr := storage.Flush(ctx, s.engine, at, int64(n)); fErr != nil {
+				return s.sessionCopy(session), fErr
+			}
+			written += int64(n)
+
+			s.mu.Lock()
+			session.Offset = offset + written
+			session.UpdatedAt = time.Now()
+			s.mu.Unlock()
+		}
+		if rErr == io.EOF || rErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rErr != nil {
+			return s.sessionCopy(session), rErr
+		}
+	}
+
+	if written != length {
+		return s.sessionCopy(session), fmt.Errorf("%w: got %d of %d bytes", ErrIncompleteChunk, written, length)
+	}
+	return s.sessionCopy(session), nil
+}
+
+// sessionCopy returns a copy of a session taken under the lock
+func (s *Service) sessionCopy(session *Session) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *session
+	return &c
+}
+
+// CompleteSession stores the session's data as the object once all of it
+// has been received
+func (s *Service) CompleteSession(ctx context.Context, bucket, key, sessionID string) (*object.Object, error) {
+	s.mu.Lock()
+	session, err := s.getSessionLocked(bucket, key, sessionID)
+	if err == nil {
+		switch {
+		case session.writing:
+			err = ErrSessionBusy
+		case session.Offset != session.Size:
+			err = fmt.Errorf("%w: %d of %d bytes received", ErrSessionIncomplete, session.Offset, session.Size)
+		default:
+			// Removed while the object is stored so no chunk or second
+			// completion can race with it
+			delete(s.sessions, sessionID)
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &partReader{ctx: ctx, engine: s.engine, offset: session.dataOffset, remaining: session.Size}
+	obj, err := s.objects.PutMultipartObject(ctx, bucket, key, data, session.Size, session.ContentType, nil)
+	if err != nil {
+		s.mu.Lock()
+		s.sessions[sessionID] = session
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to store object: %w", err)
+	}
+
+	s.freeSession(session)
+	return obj, nil
+}
+
+// AbortSession discards a session and frees its space
+func (s *Service) AbortSession(ctx context.Context, bucket, key, sessionID string) error {
+	s.mu.Lock()
+	session, err := s.getSessionLocked(bucket, key, sessionID)
+	if err == nil {
+		if session.writing {
+			err = ErrSessionBusy
+		} else {
+			delete(s.sessions, sessionID)
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.freeSession(session)
+	return nil
+}
+
+// freeSession releases a session's engine space
+func (s *Service) freeSession(session *Session) {
+	s.free(&Part{Offset: session.dataOffset, Size: session.Size})
+}
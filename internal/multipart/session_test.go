@@ -0,0 +1,112 @@
+package multipart
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_ResumableSession(t *testing.T) {
+	engine := &memEngine{}
+	objects := newFakeObjects()
+	s := newTestService(engine, objects)
+	ctx := context.Background()
+
+	session, err := s.CreateSession(ctx, "bucket", "video.mp4", "video/mp4", 11)
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	id := session.SessionID
+
+	// Chunks smaller than the multipart minimum are fine
+	if got, err := s.WriteChunk(ctx, "bucket", "video.mp4", id, 0, strings.NewReader("hel"), 3); err != nil || got.Offset != 3 {
+		t.Fatalf("WriteChunk(0) = %+v, %v", got, err)
+	}
+
+	// A chunk not starting at the offset is refused and reports the offset
+	if _, err := s.WriteChunk(ctx, "bucket", "video.mp4", id, 5, strings.NewReader("xx"), 2); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("WriteChunk(wrong offset) error = %v, want ErrOffsetMismatch", err)
+	}
+	if _, err := s.WriteChunk(ctx, "bucket", "video.mp4", id, 3, strings.NewReader("lo world and more"), 17); !errors.Is(err, ErrChunkOutOfRange) {
+		t.Errorf("WriteChunk(past size) error = %v, want ErrChunkOutOfRange", err)
+	}
+
+	// A body cut short keeps what arrived, so the client resumes from there
+	got, err := s.WriteChunk(ctx, "bucket", "video.mp4", id, 3, io.LimitReader(strings.NewReader("lo world"), 4), 8)
+	if !errors.Is(err, ErrIncompleteChunk) || got.Offset != 7 {
+		t.Fatalf("WriteChunk(cut short) = %+v, %v; want offset 7 and ErrIncompleteChunk", got, err)
+	}
+	if got, _ := s.GetSession(ctx, "bucket", "video.mp4", id); got.Offset != 7 || got.Size != 11 {
+		t.Errorf("GetSession() = %+v, want offset 7 of 11", got)
+	}
+
+	if _, err := s.CompleteSession(ctx, "bucket", "video.mp4", id); !errors.Is(err, ErrSessionIncomplete) {
+		t.Errorf("CompleteSession(incomplete) error = %v, want ErrSessionIncomplete", err)
+	}
+
+	if _, err := s.WriteChunk(ctx, "bucket", "video.mp4", id, 7, strings.NewReader("orld"), 4); err != nil {
+		t.Fatalf("WriteChunk(rest) error = %v", err)
+	}
+	obj, err := s.CompleteSession(ctx, "bucket", "video.mp4", id)
+	if err != nil {
+		t.Fatalf("CompleteSession() error = %v", err)
+	}
+	if objects.objects["bucket/video.mp4"] != "hello world" || obj.ContentType != "video/mp4" {
+		t.Errorf("stored %q as %q, want %q", objects.objects["bucket/video.mp4"], obj.ContentType, "hello world")
+	}
+	if engine.freed != 11 {
+		t.Errorf("freed %d bytes, want the session's 11", engine.freed)
+	}
+	if _, err := s.GetSession(ctx, "bucket", "video.mp4", id); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("GetSession(completed) error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestService_SessionErrors(t *testing.T) {
+	engine := &memEngine{}
+	s := newTestService(engine, newFakeObjects())
+	ctx := context.Background()
+
+	if _, err := s.CreateSession(ctx, "bucket", "key", "", 0); !errors.Is(err, ErrInvalidSessionSize) {
+		t.Errorf("CreateSession(0) error = %v, want ErrInvalidSessionSize", err)
+	}
+
+	session, _ := s.CreateSession(ctx, "bucket", "key", "", 4)
+	if _, err := s.GetSession(ctx, "bucket", "other", session.SessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("GetSession(other key) error = %v, want ErrSessionNotFound", err)
+	}
+
+	if err := s.AbortSession(ctx, "bucket", "key", session.SessionID); err != nil {
+		t.Fatalf("AbortSession() error = %v", err)
+	}
+	if engine.freed != 4 {
+		t.Errorf("freed %d bytes after abort, want 4", engine.freed)
+	}
+	if _, err := s.WriteChunk(ctx, "bucket", "key", session.SessionID, 0, strings.NewReader("data"), 4); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("WriteChunk(aborted) error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestService_ExpireSessions(t *testing.T) {
+	engine := &memEngine{}
+	s := newTestService(engine, newFakeObjects())
+	ctx := context.Background()
+
+	idle, _ := s.CreateSession(ctx, "bucket", "idle", "", 100)
+	active, _ := s.CreateSession(ctx, "bucket", "active", "", 10)
+	s.sessions[idle.SessionID].UpdatedAt = time.Now().Add(-2 * time.Hour)
+
+	result, err := s.ExpireUploads(ctx, time.Hour)
+	if err != nil {
+		t.Fatalf("ExpireUploads() error = %v", err)
+	}
+	if result.Sessions != 1 || result.ReclaimedBytes != 100 {
+		t.Errorf("ExpireUploads() = %+v, want the idle session reclaimed", result)
+	}
+	if _, err := s.GetSession(ctx, "bucket", "active", active.SessionID); err != nil {
+		t.Errorf("active session expired: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package multipart
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/danielino/comio/internal/database"
+)
+
+// SQLiteStore implements UploadStore in the metadata database
+type SQLiteStore struct {
+	db *database.DB
+}
+
+// NewSQLiteStore creates a SQLite-based upload store
+func NewSQLiteStore(db *database.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+// Create records a new upload
+func (s *SQLiteStore) Create(ctx context.Context, upload *Upload) error {
+	_, err := s.db.ExecWithRetry(ctx, `
+		INSERT INTO multipart_uploads (upload_id, bucket_name, key, content_type, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, upload.UploadID, upload.BucketName, upload.Key, upload.ContentType, upload.CreatedAt, upload.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create upload: %w", err)
+	}
+	return nil
+}
+
+// PutPart records a part and the upload's last activity in one transaction
+func (s *SQLiteStore) PutPart(ctx context.Context, uploadID string, part *Part) error {
+	return s.db.WithTx(ctx, func(tx *database.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			"UPDATE multipart_uploads SET updated_at = ? WHERE upload_id = ?", part.LastModified, uploadID)
+		if err != nil {
+			return fmt.Errorf("failed to update upload: %w", err)
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		} else if rows == 0 {
+			return ErrUploadNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT OR REPLACE INTO multipart_parts
+				(upload_id, part_number, etag, size, checksum, checksum_algorithm, checksum_value, storage_offset, last_modified)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, uploadID, part.PartNumber, part.ETag, part.Size, part.Checksum,
+			part.ChecksumAlgorithm, part.ChecksumValue, part.Offset, part.LastModified)
+		if err != nil {
+			return fmt.Errorf("failed to store part: %w", err)
+		}
+		return nil
+	})
+}
+
+// Delete removes an upload and, by cascade, its parts
+func (s *SQLiteStore) Delete(ctx context.Context, uploadID string) error {
+	if _, err := s.db.ExecWithRetry(ctx, "DELETE FROM multipart_uploads WHERE upload_id = ?", uploadID); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	return nil
+}
+
+// List returns every stored upload with its parts in part number order
+func (s *SQLiteStore) List(ctx context.Context) ([]*Upload, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT upload_id, bucket_name, key, content_type, created_at, updated_at
+		FROM multipart_uploads
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var uploads []*Upload
+	byID := make(map[string]*Upload)
+	for rows.Next() {
+		upload := &Upload{Parts: make([]Part, 0)}
+		var contentType sql.NullString
+		if err := rows.Scan(&upload.UploadID, &upload.BucketName, &upload.Key, &contentType, &upload.CreatedAt, &upload.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan upload: %w", err)
+		}
+		upload.ContentType = contentType.String
+		uploads = append(uploads, upload)
+		byID[upload.UploadID] = upload
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating uploads: %w", err)
+	}
+	rows.Close()
+
+	parts, err := s.db.QueryContext(ctx, `
+		SELECT upload_id, part_number, etag, size, checksum, checksum_algorithm, checksum_value, storage_offset, last_modified
+		FROM multipart_parts
+		ORDER BY upload_id, part_number
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+	defer parts.Close()
+
+	for parts.Next() {
+		var uploadID string
+		var p Part
+		var checksum, algorithm, value sql.NullString
+		if err := parts.Scan(&uploadID, &p.PartNumber, &p.ETag, &p.Size, &checksum, &algorithm, &value, &p.Offset, &p.LastModified); err != nil {
+			return nil, fmt.Errorf("failed to scan part: %w", err)
+		}
+		p.Checksum, p.ChecksumAlgorithm, p.ChecksumValue = checksum.String, algorithm.String, value.String
+		if upload, ok := byID[uploadID]; ok {
+			upload.Parts = append(upload.Parts, p)
+		}
+	}
+	if err := parts.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating parts: %w", err)
+	}
+
+	return uploads, nil
+}
@@ -6,9 +6,20 @@ import (
 
 // Upload represents a multipart upload
 type Upload struct {
-	UploadID   string    `json:"upload_id"`
-	BucketName string    `json:"bucket_name"`
-	Key        string    `json:"key"`
-	CreatedAt  time.Time `json:"created_at"`
-	Parts      []Part    `json:"parts"`
+	UploadID    string    `json:"upload_id"`
+	BucketName  string    `json:"bucket_name"`
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"` // Last part upload
+	Parts       []Part    `json:"parts"`
+}
+
+// Size returns the total size of the uploaded parts
+func (u *Upload) Size() int64 {
+	var size int64
+	for _, p := range u.Parts {
+		size += p.Size
+	}
+	return size
 }
@@ -0,0 +1,160 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+)
+
+// handleSize is the length of file handles: the export's handle ID
+// followed by the node ID
+const handleSize = 16
+
+// rootNode is the node ID of an export's root directory
+const rootNode = 1
+
+// Export is a bucket snapshot served over NFS
+type Export struct {
+	ID        string    `json:"id"`
+	Bucket    string    `json:"bucket"`
+	Path      string    `json:"path"` // Directory path clients mount
+	CreatedAt time.Time `json:"created_at"`
+	Objects   int       `json:"objects"`
+	Size      int64     `json:"size"`
+
+	handleID uint64
+	snap     *object.Snapshot
+	nodes    []*node // Indexed by node ID; 0 is unused
+}
+
+// node is a file or directory in an export. Directories are implied by
+// the slashes in object keys.
+type node struct {
+	id       uint64
+	parent   uint64
+	name     string
+	obj      *object.Object // nil for directories
+	children []*node        // Sorted by name
+	byName   map[string]*node
+	mtime    time.Time
+}
+
+func (n *node) dir() bool {
+	return n.obj == nil
+}
+
+// newExport builds the directory tree of a snapshot
+func newExport(snap *object.Snapshot, handleID uint64) *Export {
+	e := &Export{
+		ID:        snap.ID,
+		Bucket:    snap.Bucket,
+		Path:      "/" + snap.ID,
+		CreatedAt: snap.CreatedAt,
+		Size:      snap.Size,
+		handleID:  handleID,
+		snap:      snap,
+	}
+	root := e.addNode(rootNode, "", nil)
+	root.mtime = snap.CreatedAt
+
+	for _, obj := range snap.Objects {
+		parts := strings.Split(strings.TrimSuffix(obj.Key, "/"), "/")
+		if slices.ContainsFunc(parts, func(p string) bool { return p == "" || p == "." || p == ".." }) {
+			monitoring.Log.Debug("Skipping object with a key not representable as a path",
+				zap.String("bucket", obj.BucketName),
+				zap.String("key", obj.Key))
+			continue
+		}
+
+		dir := root
+		for _, name := range parts[:len(parts)-1] {
+			dir = e.mkdir(dir, name, obj.ModifiedAt)
+		}
+
+		name := parts[len(parts)-1]
+		if strings.HasSuffix(obj.Key, "/") {
+			// Directory marker
+			e.mkdir(dir, name, obj.ModifiedAt)
+			continue
+		}
+		file := e.addNode(dir.id, name, obj)
+		file.mtime = obj.ModifiedAt
+		e.link(dir, file)
+		e.Objects++
+	}
+
+	for _, n := range e.nodes[1:] {
+		if n.dir() {
+			slices.SortFunc(n.children, func(a, b *node) int { return strings.Compare(a.name, b.name) })
+		}
+	}
+	return e
+}
+
+func (e *Export) addNode(parent uint64, name string, obj *object.Object) *node {
+	if e.nodes == nil {
+		e.nodes = []*node{nil}
+	}
+	n := &node{
+		id:     uint64(len(e.nodes)),
+		parent: parent,
+		name:   name,
+		obj:    obj,
+	}
+	if obj == nil {
+		n.byName = make(map[string]*node)
+	}
+	e.nodes = append(e.nodes, n)
+	return n
+}
+
+func (e *Export) link(dir, n *node) {
+	dir.byName[n.name] = n
+	dir.children = append(dir.children, n)
+	if n.mtime.After(dir.mtime) {
+		dir.mtime = n.mtime
+	}
+}
+
+// mkdir returns the subdirectory name of dir, creating it if needed. When
+// keys "a" and "a/b" both exist, the directory replaces the file.
+func (e *Export) mkdir(dir *node, name string, mtime time.Time) *node {
+	if existing := dir.byName[name]; existing != nil {
+		if !existing.dir() {
+			// Keys are in order, so the file "a" is seen before "a/b"
+			monitoring.Log.Debug("Hiding object shadowed by a directory",
+				zap.String("bucket", existing.obj.BucketName),
+				zap.String("key", existing.obj.Key))
+			existing.obj = nil
+			existing.byName = make(map[string]*node)
+			e.Objects--
+		}
+		return existing
+	}
+	sub := e.addNode(dir.id, name, nil)
+	sub.mtime = mtime
+	e.link(dir, sub)
+	return sub
+}
+
+// node returns the node with the given ID
+func (e *Export) node(id uint64) *node {
+	if id == 0 || id >= uint64(len(e.nodes)) {
+		return nil
+	}
+	return e.nodes[id]
+}
+
+// handle returns the file handle of a node
+func (e *Export) handle(n *node) []byte {
+	fh := make([]byte, handleSize)
+	binary.BigEndian.PutUint64(fh, e.handleID)
+	binary.BigEndian.PutUint64(fh[8:], n.id)
+	return fh
+}
@@ -0,0 +1,138 @@
+package nfs
+
+import (
+	"net"
+	"slices"
+	"strings"
+)
+
+// MOUNT version 3 protocol (RFC 1813 appendix I)
+const (
+	mountProgram = 100005
+	mountVersion = 3
+
+	mountProcNull    = 0
+	mountProcMnt     = 1
+	mountProcDump    = 2
+	mountProcUmnt    = 3
+	mountProcUmntAll = 4
+	mountProcExport  = 5
+
+	mnt3OK       = 0
+	mnt3ErrNoEnt = 2
+
+	maxPathLen = 1024
+	maxNameLen = 255
+)
+
+// mountEntry records a client that mounted an export, as reported by DUMP
+type mountEntry struct {
+	host string
+	path string
+}
+
+func (s *Server) mountProcs() map[uint32]procedure {
+	return map[uint32]procedure{
+		mountProcNull:    s.null,
+		mountProcMnt:     s.mnt,
+		mountProcDump:    s.dump,
+		mountProcUmnt:    s.umnt,
+		mountProcUmntAll: s.umntAll,
+		mountProcExport:  s.exportList,
+	}
+}
+
+func (s *Server) null(c *call, res *encoder) error {
+	return nil
+}
+
+// mnt returns the root handle of the export at a path
+func (s *Server) mnt(c *call, res *encoder) error {
+	path := c.args.string(maxPathLen)
+	if c.args.err != nil {
+		return c.args.err
+	}
+
+	e := s.exportByPath(path)
+	if e == nil {
+		res.uint32(mnt3ErrNoEnt)
+		return nil
+	}
+
+	s.mu.Lock()
+	s.mounts[mountEntry{host: clientHost(c.addr), path: e.Path}] = struct{}{}
+	s.mu.Unlock()
+
+	res.uint32(mnt3OK)
+	res.opaque(e.handle(e.node(rootNode)))
+	res.uint32(1) // Accepted auth flavors
+	res.uint32(authUnix)
+	return nil
+}
+
+// dump lists the clients that mounted exports
+func (s *Server) dump(c *call, res *encoder) error {
+	s.mu.RLock()
+	mounts := make([]mountEntry, 0, len(s.mounts))
+	for m := range s.mounts {
+		mounts = append(mounts, m)
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(mounts, func(a, b mountEntry) int {
+		return strings.Compare(a.host+" "+a.path, b.host+" "+b.path)
+	})
+
+	for _, m := range mounts {
+		res.bool(true)
+		res.string(m.host)
+		res.string(m.path)
+	}
+	res.bool(false)
+	return nil
+}
+
+func (s *Server) umnt(c *call, res *encoder) error {
+	path := c.args.string(maxPathLen)
+	if c.args.err != nil {
+		return c.args.err
+	}
+
+	if e := s.exportByPath(path); e != nil {
+		path = e.Path
+	}
+	s.mu.Lock()
+	delete(s.mounts, mountEntry{host: clientHost(c.addr), path: path})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Server) umntAll(c *call, res *encoder) error {
+	host := clientHost(c.addr)
+
+	s.mu.Lock()
+	for m := range s.mounts {
+		if m.host == host {
+			delete(s.mounts, m)
+		}
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// exportList lists the exports, each open to all clients
+func (s *Server) exportList(c *call, res *encoder) error {
+	for _, e := range s.Exports() {
+		res.bool(true)
+		res.string(e.Path)
+		res.bool(false) // No group restrictions
+	}
+	res.bool(false)
+	return nil
+}
+
+func clientHost(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	return addr.String()
+}
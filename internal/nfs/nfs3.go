@@ -0,0 +1,500 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+)
+
+// NFS version 3 protocol (RFC 1813)
+const (
+	nfsProgram = 100003
+	nfsVersion = 3
+
+	nfsProcNull        = 0
+	nfsProcGetAttr     = 1
+	nfsProcSetAttr     = 2
+	nfsProcLookup      = 3
+	nfsProcAccess      = 4
+	nfsProcReadLink    = 5
+	nfsProcRead        = 6
+	nfsProcWrite       = 7
+	nfsProcCreate      = 8
+	nfsProcMkdir       = 9
+	nfsProcSymlink     = 10
+	nfsProcMknod       = 11
+	nfsProcRemove      = 12
+	nfsProcRmdir       = 13
+	nfsProcRename      = 14
+	nfsProcLink        = 15
+	nfsProcReadDir     = 16
+	nfsProcReadDirPlus = 17
+	nfsProcFsStat      = 18
+	nfsProcFsInfo      = 19
+	nfsProcPathConf    = 20
+	nfsProcCommit      = 21
+
+	nfs3OK           = 0
+	nfs3ErrNoEnt     = 2
+	nfs3ErrIO        = 5
+	nfs3ErrNotDir    = 20
+	nfs3ErrIsDir     = 21
+	nfs3ErrInval     = 22
+	nfs3ErrROFS      = 30
+	nfs3ErrStale     = 70
+	nfs3ErrBadHandle = 10001
+	nfs3ErrBadCookie = 10003
+	nfs3ErrTooSmall  = 10005
+
+	nf3Reg = 1
+	nf3Dir = 2
+
+	access3Read    = 0x01
+	access3Lookup  = 0x02
+	access3Execute = 0x20
+
+	fsf3Homogeneous = 0x08
+
+	maxHandleSize = 64
+	maxRead       = 1 << 20
+	preferredIO   = 1 << 16
+)
+
+// Encoded sizes used to fit directory listings into the client's limits
+const (
+	postOpAttrSize = 4 + 84
+	postOpFhSize   = 4 + 4 + handleSize
+	readDirHeader  = 4 + postOpAttrSize + 8 + 4 + 4 // Status, attributes, verifier, end of list, eof
+)
+
+func (s *Server) nfsProcs() map[uint32]procedure {
+	return map[uint32]procedure{
+		nfsProcNull:        s.null,
+		nfsProcGetAttr:     s.getAttr,
+		nfsProcSetAttr:     readOnly(1),
+		nfsProcLookup:      s.lookup,
+		nfsProcAccess:      s.access,
+		nfsProcReadLink:    s.readLink,
+		nfsProcRead:        s.read,
+		nfsProcWrite:       readOnly(1),
+		nfsProcCreate:      readOnly(1),
+		nfsProcMkdir:       readOnly(1),
+		nfsProcSymlink:     readOnly(1),
+		nfsProcMknod:       readOnly(1),
+		nfsProcRemove:      readOnly(1),
+		nfsProcRmdir:       readOnly(1),
+		nfsProcRename:      readOnly(2),
+		nfsProcLink:        s.link,
+		nfsProcReadDir:     s.readDir,
+		nfsProcReadDirPlus: s.readDirPlus,
+		nfsProcFsStat:      s.fsStat,
+		nfsProcFsInfo:      s.fsInfo,
+		nfsProcPathConf:    s.pathConf,
+		nfsProcCommit:      readOnly(1),
+	}
+}
+
+// readOnly refuses a modifying procedure, whose failure result carries wcc
+// weak cache consistency blocks, all empty
+func readOnly(wcc int) procedure {
+	return func(c *call, res *encoder) error {
+		res.uint32(nfs3ErrROFS)
+		for range wcc {
+			res.bool(false) // No pre-operation attributes
+			res.bool(false) // No post-operation attributes
+		}
+		return nil
+	}
+}
+
+// link refuses LINK, whose failure result has the file's attributes
+// before the directory's wcc data
+func (s *Server) link(c *call, res *encoder) error {
+	res.uint32(nfs3ErrROFS)
+	res.bool(false)
+	res.bool(false)
+	res.bool(false)
+	return nil
+}
+
+// attrs encodes the fattr3 of a node. Files and directories are owned by
+// root and readable by everyone.
+func attrs(e *Export, n *node, res *encoder) {
+	if n.dir() {
+		res.uint32(nf3Dir)
+		res.uint32(0o555)
+		res.uint32(2)
+		res.uint32(0) // uid
+		res.uint32(0) // gid
+		res.uint64(4096)
+		res.uint64(4096)
+	} else {
+		res.uint32(nf3Reg)
+		res.uint32(0o444)
+		res.uint32(1)
+		res.uint32(0)
+		res.uint32(0)
+		res.uint64(uint64(n.obj.Size))
+		res.uint64(uint64(n.obj.Size))
+	}
+	res.uint64(0) // rdev
+	res.uint64(e.handleID)
+	res.uint64(n.id)
+	for range 3 { // atime, mtime, ctime
+		res.uint32(uint32(n.mtime.Unix()))
+		res.uint32(uint32(n.mtime.Nanosecond()))
+	}
+}
+
+// postOpAttr encodes optional attributes, present when n is non-nil
+func postOpAttr(e *Export, n *node, res *encoder) {
+	res.bool(n != nil)
+	if n != nil {
+		attrs(e, n, res)
+	}
+}
+
+// fileHandle decodes a file handle argument and resolves it. On failure
+// the status is encoded with absent attributes and nil is returned.
+func (s *Server) fileHandle(c *call, res *encoder) (*Export, *node) {
+	fh := c.args.opaque(maxHandleSize)
+	if c.args.err != nil {
+		return nil, nil
+	}
+	e, n, status := s.resolve(fh)
+	if status != nfs3OK {
+		res.uint32(status)
+		res.bool(false)
+		return nil, nil
+	}
+	return e, n
+}
+
+func (s *Server) getAttr(c *call, res *encoder) error {
+	fh := c.args.opaque(maxHandleSize)
+	if c.args.err != nil {
+		return c.args.err
+	}
+	e, n, status := s.resolve(fh)
+	res.uint32(status)
+	if status == nfs3OK {
+		attrs(e, n, res)
+	}
+	return nil
+}
+
+func (s *Server) lookup(c *call, res *encoder) error {
+	e, dir := s.fileHandle(c, res)
+	name := c.args.string(maxNameLen)
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	if !dir.dir() {
+		res.uint32(nfs3ErrNotDir)
+		postOpAttr(e, dir, res)
+		return nil
+	}
+
+	var found *node
+	switch name {
+	case ".":
+		found = dir
+	case "..":
+		found = e.node(dir.parent)
+	default:
+		found = dir.byName[name]
+	}
+	if found == nil {
+		res.uint32(nfs3ErrNoEnt)
+		postOpAttr(e, dir, res)
+		return nil
+	}
+
+	res.uint32(nfs3OK)
+	res.opaque(e.handle(found))
+	postOpAttr(e, found, res)
+	postOpAttr(e, dir, res)
+	return nil
+}
+
+func (s *Server) access(c *call, res *encoder) error {
+	e, n := s.fileHandle(c, res)
+	requested := c.args.uint32()
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	granted := uint32(access3Read)
+	if n.dir() {
+		granted |= access3Lookup | access3Execute
+	}
+
+	res.uint32(nfs3OK)
+	postOpAttr(e, n, res)
+	res.uint32(requested & granted)
+	return nil
+}
+
+// readLink fails, as there are no symbolic links
+func (s *Server) readLink(c *call, res *encoder) error {
+	e, n := s.fileHandle(c, res)
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	res.uint32(nfs3ErrInval)
+	postOpAttr(e, n, res)
+	return nil
+}
+
+func (s *Server) read(c *call, res *encoder) error {
+	e, n := s.fileHandle(c, res)
+	offset := c.args.uint64()
+	count := c.args.uint32()
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	if n.dir() {
+		res.uint32(nfs3ErrIsDir)
+		postOpAttr(e, n, res)
+		return nil
+	}
+
+	var data []byte
+	if offset < uint64(n.obj.Size) {
+		data = make([]byte, min(int64(count), maxRead, n.obj.Size-int64(offset)))
+		read, err := e.snap.ReadAt(n.obj, data, int64(offset))
+		if err != nil {
+			if errors.Is(err, object.ErrSnapshotReleased) {
+				res.uint32(nfs3ErrStale)
+				res.bool(false)
+				return nil
+			}
+			monitoring.Log.Error("Failed to read exported object",
+				zap.String("bucket", e.Bucket),
+				zap.String("key", n.obj.Key),
+				zap.Error(err))
+			res.uint32(nfs3ErrIO)
+			postOpAttr(e, n, res)
+			return nil
+		}
+		data = data[:read]
+	}
+
+	res.uint32(nfs3OK)
+	postOpAttr(e, n, res)
+	res.uint32(uint32(len(data)))
+	res.bool(offset+uint64(len(data)) >= uint64(n.obj.Size))
+	res.opaque(data)
+	return nil
+}
+
+// dirEntry is a directory listing entry. Its cookie is its position in the
+// listing, which starts with "." and "..", plus one.
+type dirEntry struct {
+	name   string
+	node   *node
+	cookie uint64
+}
+
+// listDir returns the entries of dir after cookie, or false if the cookie
+// is past the end of the directory
+func listDir(e *Export, dir *node, cookie uint64) ([]dirEntry, bool) {
+	entries := make([]dirEntry, 0, len(dir.children)+2)
+	entries = append(entries,
+		dirEntry{name: ".", node: dir},
+		dirEntry{name: "..", node: e.node(dir.parent)})
+	for _, child := range dir.children {
+		entries = append(entries, dirEntry{name: child.name, node: child})
+	}
+	for i := range entries {
+		entries[i].cookie = uint64(i + 1)
+	}
+
+	if cookie > uint64(len(entries)) {
+		return nil, false
+	}
+	return entries[cookie:], true
+}
+
+// cookieVerifier identifies the listing cookies are positions in. Exports
+// never change, so it only differs between exports.
+func cookieVerifier(e *Export) []byte {
+	return binary.BigEndian.AppendUint64(nil, e.handleID)
+}
+
+func xdrStringSize(s string) int {
+	return 4 + (len(s)+3)&^3
+}
+
+func (s *Server) readDir(c *call, res *encoder) error {
+	e, dir := s.fileHandle(c, res)
+	cookie := c.args.uint64()
+	c.args.fixed(8) // Verifier
+	count := c.args.uint32()
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	return s.encodeDir(e, dir, cookie, int(count), int(count), false, res)
+}
+
+func (s *Server) readDirPlus(c *call, res *encoder) error {
+	e, dir := s.fileHandle(c, res)
+	cookie := c.args.uint64()
+	c.args.fixed(8) // Verifier
+	dirCount := c.args.uint32()
+	maxCount := c.args.uint32()
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	return s.encodeDir(e, dir, cookie, int(dirCount), int(maxCount), true, res)
+}
+
+// encodeDir encodes a READDIR or READDIRPLUS result. dirCount bounds the
+// bytes of names, file IDs and cookies, and maxCount the whole result.
+func (s *Server) encodeDir(e *Export, dir *node, cookie uint64, dirCount, maxCount int, plus bool, res *encoder) error {
+	if !dir.dir() {
+		res.uint32(nfs3ErrNotDir)
+		postOpAttr(e, dir, res)
+		return nil
+	}
+	entries, ok := listDir(e, dir, cookie)
+	if !ok {
+		res.uint32(nfs3ErrBadCookie)
+		postOpAttr(e, dir, res)
+		return nil
+	}
+
+	body := &encoder{}
+	size, names := readDirHeader, 0
+	eof := true
+	for _, entry := range entries {
+		nameSize := 8 + xdrStringSize(entry.name) + 8
+		entrySize := 4 + nameSize
+		if plus {
+			entrySize += postOpAttrSize + postOpFhSize
+		}
+		if size+entrySize > maxCount || names+nameSize > dirCount {
+			eof = false
+			break
+		}
+		size += entrySize
+		names += nameSize
+
+		body.bool(true)
+		body.uint64(entry.node.id)
+		body.string(entry.name)
+		body.uint64(entry.cookie)
+		if plus {
+			postOpAttr(e, entry.node, body)
+			body.bool(true)
+			body.opaque(e.handle(entry.node))
+		}
+	}
+	if !eof && len(body.buf) == 0 {
+		res.uint32(nfs3ErrTooSmall)
+		postOpAttr(e, dir, res)
+		return nil
+	}
+
+	res.uint32(nfs3OK)
+	postOpAttr(e, dir, res)
+	res.fixed(cookieVerifier(e))
+	res.buf = append(res.buf, body.buf...)
+	res.bool(false) // End of entries
+	res.bool(eof)
+	return nil
+}
+
+func (s *Server) fsStat(c *call, res *encoder) error {
+	e, n := s.fileHandle(c, res)
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	res.uint32(nfs3OK)
+	postOpAttr(e, n, res)
+	res.uint64(uint64(e.Size)) // Total bytes
+	res.uint64(0)              // Free bytes
+	res.uint64(0)              // Bytes available to the user
+	res.uint64(uint64(len(e.nodes) - 1))
+	res.uint64(0)
+	res.uint64(0)
+	res.uint32(math.MaxUint32) // Snapshots never change
+	return nil
+}
+
+func (s *Server) fsInfo(c *call, res *encoder) error {
+	e, n := s.fileHandle(c, res)
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	res.uint32(nfs3OK)
+	postOpAttr(e, n, res)
+	res.uint32(maxRead)     // rtmax
+	res.uint32(maxRead)     // rtpref
+	res.uint32(4096)        // rtmult
+	res.uint32(preferredIO) // wtmax
+	res.uint32(preferredIO) // wtpref
+	res.uint32(4096)        // wtmult
+	res.uint32(preferredIO) // dtpref
+	res.uint64(math.MaxInt64)
+	res.uint32(0) // Time delta: 1ns
+	res.uint32(1)
+	res.uint32(fsf3Homogeneous)
+	return nil
+}
+
+func (s *Server) pathConf(c *call, res *encoder) error {
+	e, n := s.fileHandle(c, res)
+	if c.args.err != nil {
+		return c.args.err
+	}
+	if e == nil {
+		return nil
+	}
+
+	res.uint32(nfs3OK)
+	postOpAttr(e, n, res)
+	res.uint32(1)          // linkmax
+	res.uint32(maxNameLen) // name_max
+	res.bool(true)         // no_trunc
+	res.bool(true)         // chown_restricted
+	res.bool(false)        // case_insensitive
+	res.bool(true)         // case_preserving
+	return nil
+}
@@ -0,0 +1,214 @@
+package nfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// ONC RPC (RFC 5531) message constants
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+
+	rejectRPCMismatch = 0
+	rejectAuthError   = 1
+
+	authNone    = 0
+	authUnix    = 1
+	authTooWeak = 5
+
+	maxAuthSize = 400
+)
+
+const (
+	// maxRecordSize bounds incoming RPC records. Calls are small since
+	// writes are refused, so this only guards against garbage.
+	maxRecordSize = 1 << 20
+
+	// maxInflight bounds the calls served concurrently per connection
+	maxInflight = 16
+
+	lastFragment = 1 << 31
+)
+
+// call is a decoded RPC call
+type call struct {
+	xid  uint32
+	prog uint32
+	vers uint32
+	proc uint32
+	args *decoder
+	addr net.Addr
+}
+
+// procedure serves one RPC procedure, encoding its result. It returns
+// errGarbage if the arguments could not be decoded.
+type procedure func(c *call, res *encoder) error
+
+// program is an RPC program served at a single version
+type program struct {
+	vers  uint32
+	procs map[uint32]procedure
+}
+
+// serveConn serves RPC calls from conn until it is closed. Calls are
+// served concurrently and replies may be sent out of order, matched to
+// calls by their transaction ID.
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxInflight)
+	defer wg.Wait()
+
+	for {
+		record, err := readRecord(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				monitoring.Log.Debug("NFS connection closed",
+					zap.String("remote", conn.RemoteAddr().String()),
+					zap.Error(err))
+			}
+			return
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			reply := s.handleRecord(record, conn.RemoteAddr())
+			if reply == nil {
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if err := writeRecord(conn, reply); err != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// readRecord reads one record-marked RPC message (RFC 5531 section 11)
+func readRecord(r io.Reader) ([]byte, error) {
+	var record []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		mark := binary.BigEndian.Uint32(header[:])
+		size := int(mark &^ lastFragment)
+		if len(record)+size > maxRecordSize {
+			return nil, fmt.Errorf("RPC record exceeds %d bytes", maxRecordSize)
+		}
+
+		start := len(record)
+		record = append(record, make([]byte, size)...)
+		if _, err := io.ReadFull(r, record[start:]); err != nil {
+			return nil, err
+		}
+		if mark&lastFragment != 0 {
+			return record, nil
+		}
+	}
+}
+
+// writeRecord writes a message as a single record fragment
+func writeRecord(w io.Writer, msg []byte) error {
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(msg)), uint32(len(msg))|lastFragment)
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// handleRecord serves one RPC message and returns the encoded reply, or nil
+// if no reply is due
+func (s *Server) handleRecord(record []byte, addr net.Addr) []byte {
+	d := &decoder{buf: record}
+	xid := d.uint32()
+	if d.uint32() != msgCall || d.err != nil {
+		return nil
+	}
+
+	res := &encoder{}
+	res.uint32(xid)
+	res.uint32(msgReply)
+
+	if d.uint32() != rpcVersion {
+		res.uint32(replyDenied)
+		res.uint32(rejectRPCMismatch)
+		res.uint32(rpcVersion)
+		res.uint32(rpcVersion)
+		return res.buf
+	}
+
+	c := &call{xid: xid, args: d, addr: addr}
+	c.prog = d.uint32()
+	c.vers = d.uint32()
+	c.proc = d.uint32()
+	credFlavor := d.uint32()
+	d.opaque(maxAuthSize)
+	d.uint32() // Verifier flavor
+	d.opaque(maxAuthSize)
+	if d.err != nil {
+		return nil
+	}
+
+	if credFlavor != authNone && credFlavor != authUnix {
+		res.uint32(replyDenied)
+		res.uint32(rejectAuthError)
+		res.uint32(authTooWeak)
+		return res.buf
+	}
+
+	res.uint32(replyAccepted)
+	res.uint32(authNone) // Verifier
+	res.uint32(0)
+
+	prog, ok := s.programs[c.prog]
+	if !ok {
+		res.uint32(acceptProgUnavail)
+		return res.buf
+	}
+	if c.vers != prog.vers {
+		res.uint32(acceptProgMismatch)
+		res.uint32(prog.vers)
+		res.uint32(prog.vers)
+		return res.buf
+	}
+	proc, ok := prog.procs[c.proc]
+	if !ok {
+		res.uint32(acceptProcUnavail)
+		return res.buf
+	}
+
+	header := len(res.buf)
+	res.uint32(acceptSuccess)
+	if err := proc(c, res); err != nil {
+		res.buf = res.buf[:header]
+		res.uint32(acceptGarbageArgs)
+	}
+	return res.buf
+}
@@ -0,0 +1,270 @@
+// Package nfs serves read-only NFSv3 exports of bucket snapshots, giving
+// tools that need POSIX file access a consistent point-in-time view of a
+// bucket. The NFS and MOUNT programs share a single TCP port and no
+// portmapper is registered, so clients mount with explicit ports:
+//
+//	mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,mountproto=tcp,nolock host:/<export-id> /mnt
+//
+// File locking (NLM) is not supported.
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+)
+
+// ErrExportNotFound is returned for unknown export IDs
+var ErrExportNotFound = errors.New("export not found")
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("nfs: server closed")
+
+// Server exports bucket snapshots over NFSv3
+type Server struct {
+	objects  *object.Service
+	programs map[uint32]program
+
+	mu      sync.RWMutex
+	exports map[uint64]*Export // By handle ID
+	mounts  map[mountEntry]struct{}
+
+	connMu   sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates an NFS server for snapshots of the object service's
+// buckets
+func NewServer(objects *object.Service) *Server {
+	s := &Server{
+		objects: objects,
+		exports: make(map[uint64]*Export),
+		mounts:  make(map[mountEntry]struct{}),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.programs = map[uint32]program{
+		nfsProgram:   {vers: nfsVersion, procs: s.nfsProcs()},
+		mountProgram: {vers: mountVersion, procs: s.mountProcs()},
+	}
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves NFS and MOUNT
+// calls until Close
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close
+func (s *Server) Serve(ln net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listener = ln
+	s.connMu.Unlock()
+
+	monitoring.Log.Info("Starting NFS server", zap.String("addr", ln.Addr().String()))
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			s.connMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.connMu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.connMu.Lock()
+			delete(s.conns, conn)
+			s.connMu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// Close stops the listener, closes client connections and releases the
+// snapshots of all exports
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+	s.wg.Wait()
+
+	s.mu.Lock()
+	exports := s.exports
+	s.exports = make(map[uint64]*Export)
+	s.mu.Unlock()
+	for _, e := range exports {
+		e.snap.Release()
+	}
+	return err
+}
+
+// Export snapshots a bucket and exports the snapshot
+func (s *Server) Export(ctx context.Context, bucket string) (*Export, error) {
+	snap, err := s.objects.Snapshot(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Handle IDs are random so handles from before a restart are stale
+	// rather than pointing into another export
+	var handleID uint64
+	for handleID == 0 || s.exports[handleID] != nil {
+		var b [8]byte
+		rand.Read(b[:])
+		handleID = binary.BigEndian.Uint64(b[:])
+	}
+
+	e := newExport(snap, handleID)
+	s.exports[handleID] = e
+
+	monitoring.Log.Info("Exported bucket snapshot over NFS",
+		zap.String("bucket", bucket),
+		zap.String("export", e.ID),
+		zap.Int("objects", e.Objects))
+	return e, nil
+}
+
+// Exports returns the current exports, oldest first
+func (s *Server) Exports() []*Export {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	exports := make([]*Export, 0, len(s.exports))
+	for _, e := range s.exports {
+		exports = append(exports, e)
+	}
+	slices.SortFunc(exports, func(a, b *Export) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return exports
+}
+
+// GetExport returns an export by ID
+func (s *Server) GetExport(id string) (*Export, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.exports {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, ErrExportNotFound
+}
+
+// Unexport removes an export and releases its snapshot. Clients still
+// holding its file handles get stale handle errors.
+func (s *Server) Unexport(id string) error {
+	s.mu.Lock()
+	var found *Export
+	for handleID, e := range s.exports {
+		if e.ID == id {
+			found = e
+			delete(s.exports, handleID)
+			break
+		}
+	}
+	for m := range s.mounts {
+		if found != nil && m.path == found.Path {
+			delete(s.mounts, m)
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		return ErrExportNotFound
+	}
+	found.snap.Release()
+
+	monitoring.Log.Info("Removed NFS export",
+		zap.String("bucket", found.Bucket),
+		zap.String("export", found.ID))
+	return nil
+}
+
+// exportByPath returns the export mounted at path: either an export's
+// path or "/<bucket>" for the bucket's most recent export
+func (s *Server) exportByPath(path string) *Export {
+	path = "/" + strings.Trim(path, "/")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *Export
+	for _, e := range s.exports {
+		if e.Path == path {
+			return e
+		}
+		if "/"+e.Bucket == path && (latest == nil || e.CreatedAt.After(latest.CreatedAt)) {
+			latest = e
+		}
+	}
+	return latest
+}
+
+// resolve returns the export and node a file handle refers to
+func (s *Server) resolve(fh []byte) (*Export, *node, uint32) {
+	if len(fh) != handleSize {
+		return nil, nil, nfs3ErrBadHandle
+	}
+
+	s.mu.RLock()
+	e := s.exports[binary.BigEndian.Uint64(fh)]
+	s.mu.RUnlock()
+	if e == nil {
+		return nil, nil, nfs3ErrStale
+	}
+
+	n := e.node(binary.BigEndian.Uint64(fh[8:]))
+	if n == nil {
+		return nil, nil, nfs3ErrStale
+	}
+	return e, n, nfs3OK
+}
@@ -0,0 +1,380 @@
+package nfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func newTestService(t *testing.T, objects map[string]string) *object.Service {
+	f, err := os.CreateTemp(t.TempDir(), "nfs_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+
+	engine, err := storage.NewSimpleEngine(f.Name(), 16*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	t.Cleanup(func() { engine.Close() })
+
+	service := object.NewService(object.NewMemoryRepository(), engine)
+	for key, data := range objects {
+		putObject(t, service, key, data)
+	}
+	return service
+}
+
+func putObject(t *testing.T, service *object.Service, key, data string) {
+	t.Helper()
+	if _, err := service.PutObject(context.Background(), "bucket", key, bytes.NewReader([]byte(data)), int64(len(data)), "text/plain"); err != nil {
+		t.Fatalf("PutObject(%s) failed: %v", key, err)
+	}
+}
+
+// startServer serves s on a loopback port and returns a connected client
+func startServer(t *testing.T, s *Server) *rpcClient {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rpcClient{t: t, conn: conn}
+}
+
+// rpcClient issues ONC RPC calls with AUTH_UNIX credentials
+type rpcClient struct {
+	t    *testing.T
+	conn net.Conn
+	xid  uint32
+}
+
+// call returns the reply's accept status and result
+func (c *rpcClient) call(prog, vers, proc uint32, args func(*encoder)) (uint32, *decoder) {
+	c.t.Helper()
+	c.xid++
+
+	msg := &encoder{}
+	msg.uint32(c.xid)
+	msg.uint32(msgCall)
+	msg.uint32(rpcVersion)
+	msg.uint32(prog)
+	msg.uint32(vers)
+	msg.uint32(proc)
+
+	cred := &encoder{}
+	cred.uint32(0)        // Stamp
+	cred.string("client") // Machine name
+	cred.uint32(1000)     // uid
+	cred.uint32(1000)     // gid
+	cred.uint32(0)        // Supplementary gids
+	msg.uint32(authUnix)
+	msg.opaque(cred.buf)
+	msg.uint32(authNone)
+	msg.opaque(nil)
+	if args != nil {
+		args(msg)
+	}
+
+	if err := writeRecord(c.conn, msg.buf); err != nil {
+		c.t.Fatalf("write call: %v", err)
+	}
+	record, err := readRecord(c.conn)
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+
+	d := &decoder{buf: record}
+	if xid := d.uint32(); xid != c.xid {
+		c.t.Fatalf("reply xid = %d, want %d", xid, c.xid)
+	}
+	if d.uint32() != msgReply || d.uint32() != replyAccepted {
+		c.t.Fatalf("call was not accepted")
+	}
+	d.uint32() // Verifier
+	d.opaque(maxAuthSize)
+	return d.uint32(), d
+}
+
+// nfs calls an NFS procedure and returns its status and the rest of the
+// result
+func (c *rpcClient) nfs(proc uint32, args func(*encoder)) (uint32, *decoder) {
+	c.t.Helper()
+	accept, d := c.call(nfsProgram, nfsVersion, proc, args)
+	if accept != acceptSuccess {
+		c.t.Fatalf("NFS procedure %d accept status = %d", proc, accept)
+	}
+	return d.uint32(), d
+}
+
+func (c *rpcClient) mount(path string) []byte {
+	c.t.Helper()
+	accept, d := c.call(mountProgram, mountVersion, mountProcMnt, func(e *encoder) { e.string(path) })
+	if accept != acceptSuccess {
+		c.t.Fatalf("MNT accept status = %d", accept)
+	}
+	if status := d.uint32(); status != mnt3OK {
+		c.t.Fatalf("MNT %s status = %d", path, status)
+	}
+	return d.opaque(maxHandleSize)
+}
+
+func (c *rpcClient) lookup(dir []byte, name string) []byte {
+	c.t.Helper()
+	status, d := c.nfs(nfsProcLookup, func(e *encoder) {
+		e.opaque(dir)
+		e.string(name)
+	})
+	if status != nfs3OK {
+		c.t.Fatalf("LOOKUP %s status = %d", name, status)
+	}
+	return d.opaque(maxHandleSize)
+}
+
+func (c *rpcClient) read(fh []byte, offset uint64, count uint32) (string, bool) {
+	c.t.Helper()
+	status, d := c.nfs(nfsProcRead, func(e *encoder) {
+		e.opaque(fh)
+		e.uint64(offset)
+		e.uint32(count)
+	})
+	if status != nfs3OK {
+		c.t.Fatalf("READ status = %d", status)
+	}
+	skipPostOpAttr(d)
+	d.uint32() // Count
+	eof := d.uint32() == 1
+	return string(d.opaque(maxRead)), eof
+}
+
+func skipPostOpAttr(d *decoder) {
+	if d.uint32() == 1 {
+		d.fixed(84)
+	}
+}
+
+// readDirPlus lists a directory in pages of at most maxCount bytes
+func (c *rpcClient) readDirPlus(dir []byte, maxCount uint32) []string {
+	c.t.Helper()
+	var names []string
+	var cookie uint64
+	for page := 0; ; page++ {
+		if page > 100 {
+			c.t.Fatal("READDIRPLUS never reached eof")
+		}
+		status, d := c.nfs(nfsProcReadDirPlus, func(e *encoder) {
+			e.opaque(dir)
+			e.uint64(cookie)
+			e.fixed(make([]byte, 8))
+			e.uint32(maxCount)
+			e.uint32(maxCount)
+		})
+		if status != nfs3OK {
+			c.t.Fatalf("READDIRPLUS status = %d", status)
+		}
+		skipPostOpAttr(d)
+		d.fixed(8) // Verifier
+		for d.uint32() == 1 {
+			d.uint64() // File ID
+			names = append(names, d.string(maxNameLen))
+			cookie = d.uint64()
+			skipPostOpAttr(d)
+			if d.uint32() == 1 {
+				d.opaque(maxHandleSize)
+			}
+		}
+		if d.err != nil {
+			c.t.Fatalf("malformed READDIRPLUS result: %v", d.err)
+		}
+		if d.uint32() == 1 {
+			return names
+		}
+	}
+}
+
+func TestServer_MountAndRead(t *testing.T) {
+	service := newTestService(t, map[string]string{
+		"readme.txt":     "hello",
+		"data/train.csv": "a,b\n1,2\n",
+		"data/test.csv":  "a,b\n3,4\n",
+		"data/raw/":      "", // Directory marker
+	})
+	s := NewServer(service)
+	client := startServer(t, s)
+
+	export, err := s.Export(context.Background(), "bucket")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if export.Objects != 3 {
+		t.Errorf("export has %d objects, want 3", export.Objects)
+	}
+
+	root := client.mount(export.Path)
+	if names := client.readDirPlus(root, 4096); !slices.Equal(names, []string{".", "..", "data", "readme.txt"}) {
+		t.Errorf("root listing = %v", names)
+	}
+
+	data := client.lookup(root, "data")
+	if names := client.readDirPlus(data, 4096); !slices.Equal(names, []string{".", "..", "raw", "test.csv", "train.csv"}) {
+		t.Errorf("data listing = %v", names)
+	}
+
+	train := client.lookup(data, "train.csv")
+	if content, eof := client.read(train, 0, 4096); content != "a,b\n1,2\n" || !eof {
+		t.Errorf("READ = %q eof=%v", content, eof)
+	}
+	if content, eof := client.read(train, 4, 2); content != "1," || eof {
+		t.Errorf("partial READ = %q eof=%v", content, eof)
+	}
+
+	// Changes after the snapshot are not visible through the export
+	putObject(t, service, "data/train.csv", "changed")
+	if err := service.DeleteObject(context.Background(), "bucket", "readme.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if content, _ := client.read(train, 0, 4096); content != "a,b\n1,2\n" {
+		t.Errorf("READ after overwrite = %q, want the snapshot's content", content)
+	}
+	if content, _ := client.read(client.lookup(root, "readme.txt"), 0, 4096); content != "hello" {
+		t.Errorf("READ after delete = %q, want the snapshot's content", content)
+	}
+
+	status, _ := client.nfs(nfsProcLookup, func(e *encoder) {
+		e.opaque(root)
+		e.string("missing")
+	})
+	if status != nfs3ErrNoEnt {
+		t.Errorf("LOOKUP missing status = %d, want NOENT", status)
+	}
+
+	status, d := client.nfs(nfsProcGetAttr, func(e *encoder) { e.opaque(train) })
+	if status != nfs3OK {
+		t.Fatalf("GETATTR status = %d", status)
+	}
+	if typ := d.uint32(); typ != nf3Reg {
+		t.Errorf("GETATTR type = %d, want regular file", typ)
+	}
+	d.fixed(16) // Mode, nlink, uid, gid
+	if size := d.uint64(); size != 8 {
+		t.Errorf("GETATTR size = %d, want 8", size)
+	}
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	s := NewServer(newTestService(t, map[string]string{"file": "data"}))
+	client := startServer(t, s)
+	export, err := s.Export(context.Background(), "bucket")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	root := client.mount("/bucket") // The bucket's latest export
+
+	for _, proc := range []uint32{nfsProcSetAttr, nfsProcWrite, nfsProcCreate, nfsProcMkdir, nfsProcRemove, nfsProcRename, nfsProcLink, nfsProcCommit} {
+		status, _ := client.nfs(proc, func(e *encoder) { e.opaque(root) })
+		if status != nfs3ErrROFS {
+			t.Errorf("procedure %d status = %d, want ROFS", proc, status)
+		}
+	}
+
+	status, d := client.nfs(nfsProcAccess, func(e *encoder) {
+		e.opaque(root)
+		e.uint32(0x3f)
+	})
+	skipPostOpAttr(d)
+	if granted := d.uint32(); status != nfs3OK || granted != access3Read|access3Lookup|access3Execute {
+		t.Errorf("ACCESS = %d granted %#x, want read, lookup and execute", status, granted)
+	}
+
+	if err := s.Unexport(export.ID); err != nil {
+		t.Fatalf("Unexport failed: %v", err)
+	}
+	if status, _ := client.nfs(nfsProcGetAttr, func(e *encoder) { e.opaque(root) }); status != nfs3ErrStale {
+		t.Errorf("GETATTR after Unexport status = %d, want STALE", status)
+	}
+	if err := s.Unexport(export.ID); err != ErrExportNotFound {
+		t.Errorf("second Unexport error = %v, want ErrExportNotFound", err)
+	}
+
+	_, d = client.call(mountProgram, mountVersion, mountProcMnt, func(e *encoder) { e.string(export.Path) })
+	if status := d.uint32(); status != mnt3ErrNoEnt {
+		t.Errorf("MNT of removed export status = %d, want NOENT", status)
+	}
+}
+
+func TestServer_ReadDirPaging(t *testing.T) {
+	objects := make(map[string]string)
+	var want []string
+	for i := range 50 {
+		key := fmt.Sprintf("file-%03d", i)
+		objects[key] = key
+		want = append(want, key)
+	}
+	s := NewServer(newTestService(t, objects))
+	client := startServer(t, s)
+	export, err := s.Export(context.Background(), "bucket")
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	root := client.mount(export.Path)
+	names := client.readDirPlus(root, 1024)
+	if !slices.Equal(names, append([]string{".", ".."}, want...)) {
+		t.Errorf("paged listing = %v", names)
+	}
+
+	status, _ := client.nfs(nfsProcReadDirPlus, func(e *encoder) {
+		e.opaque(root)
+		e.uint64(0)
+		e.fixed(make([]byte, 8))
+		e.uint32(16)
+		e.uint32(16)
+	})
+	if status != nfs3ErrTooSmall {
+		t.Errorf("READDIRPLUS with a tiny buffer status = %d, want TOOSMALL", status)
+	}
+}
+
+func TestServer_RPCErrors(t *testing.T) {
+	client := startServer(t, NewServer(newTestService(t, nil)))
+
+	if accept, _ := client.call(100021, 4, 0, nil); accept != acceptProgUnavail {
+		t.Errorf("unknown program accept status = %d, want PROG_UNAVAIL", accept)
+	}
+	accept, d := client.call(nfsProgram, 4, 0, nil)
+	if low, high := d.uint32(), d.uint32(); accept != acceptProgMismatch || low != 3 || high != 3 {
+		t.Errorf("NFSv4 call = %d (%d-%d), want PROG_MISMATCH 3-3", accept, low, high)
+	}
+	if accept, _ := client.call(nfsProgram, nfsVersion, 99, nil); accept != acceptProcUnavail {
+		t.Errorf("unknown procedure accept status = %d, want PROC_UNAVAIL", accept)
+	}
+	if accept, _ := client.call(nfsProgram, nfsVersion, nfsProcGetAttr, nil); accept != acceptGarbageArgs {
+		t.Errorf("GETATTR without arguments accept status = %d, want GARBAGE_ARGS", accept)
+	}
+	if accept, _ := client.call(mountProgram, mountVersion, mountProcNull, nil); accept != acceptSuccess {
+		t.Errorf("MOUNT NULL accept status = %d", accept)
+	}
+}
@@ -0,0 +1,103 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errGarbage is returned when call arguments cannot be decoded
+var errGarbage = errors.New("malformed XDR arguments")
+
+// decoder reads XDR (RFC 4506) values. The first error is sticky, so a
+// sequence of reads only needs checking once at the end.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint32() uint32 {
+	if d.err != nil || len(d.buf) < 4 {
+		d.err = errGarbage
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if d.err != nil || len(d.buf) < 8 {
+		d.err = errGarbage
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.buf)
+	d.buf = d.buf[8:]
+	return v
+}
+
+// fixed reads n bytes of fixed-length opaque data
+func (d *decoder) fixed(n int) []byte {
+	padded := (n + 3) &^ 3
+	if d.err != nil || len(d.buf) < padded {
+		d.err = errGarbage
+		return nil
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[padded:]
+	return v
+}
+
+// opaque reads variable-length opaque data of at most limit bytes
+func (d *decoder) opaque(limit int) []byte {
+	n := d.uint32()
+	if d.err == nil && n > uint32(limit) {
+		d.err = errGarbage
+	}
+	if d.err != nil {
+		return nil
+	}
+	return d.fixed(int(n))
+}
+
+func (d *decoder) string(limit int) string {
+	return string(d.opaque(limit))
+}
+
+// encoder appends XDR values to a buffer
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint32(1)
+	} else {
+		e.uint32(0)
+	}
+}
+
+// fixed appends fixed-length opaque data
+func (e *encoder) fixed(v []byte) {
+	e.buf = append(e.buf, v...)
+	if pad := len(v) % 4; pad != 0 {
+		e.buf = append(e.buf, make([]byte, 4-pad)...)
+	}
+}
+
+// opaque appends variable-length opaque data
+func (e *encoder) opaque(v []byte) {
+	e.uint32(uint32(len(v)))
+	e.fixed(v)
+}
+
+func (e *encoder) string(v string) {
+	e.opaque([]byte(v))
+}
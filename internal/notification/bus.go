@@ -0,0 +1,130 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// Handler processes an event. Handlers run on the bus workers, never on
+// the request path, and should respect ctx cancellation.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus delivers object events to subscribed handlers asynchronously.
+// Events are dropped (and logged) when the queue is full so a slow
+// subscriber cannot stall writes.
+type Bus struct {
+	queue    chan Event
+	workers  int
+	mu       sync.RWMutex
+	handlers []subscription
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+type subscription struct {
+	name    string
+	handler Handler
+}
+
+// NewBus creates an event bus with the given queue size and worker count
+func NewBus(queueSize, workers int) *Bus {
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	if workers <= 0 {
+		workers = 2
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Bus{
+		queue:   make(chan Event, queueSize),
+		workers: workers,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Subscribe registers a handler for every published event
+func (b *Bus) Subscribe(name string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, subscription{name: name, handler: handler})
+}
+
+// Publish queues an event for delivery
+func (b *Bus) Publish(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	select {
+	case b.queue <- event:
+	default:
+		monitoring.Log.Warn("Notification queue full, dropping event",
+			zap.String("type", string(event.Type)),
+			zap.String("bucket", event.Bucket),
+			zap.String("key", event.Key))
+	}
+}
+
+// Start starts the delivery workers
+func (b *Bus) Start() {
+	for i := 0; i < b.workers; i++ {
+		b.wg.Add(1)
+		go b.worker()
+	}
+}
+
+// Stop stops the workers. Events still queued are dropped.
+func (b *Bus) Stop() {
+	b.cancel()
+	b.wg.Wait()
+}
+
+func (b *Bus) worker() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case event := <-b.queue:
+			b.dispatch(event)
+		}
+	}
+}
+
+// dispatch delivers an event to every handler, isolating failures
+func (b *Bus) dispatch(event Event) {
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, sub := range handlers {
+		if err := b.safeCall(sub.handler, event); err != nil {
+			monitoring.Log.Warn("Notification handler failed",
+				zap.String("handler", sub.name),
+				zap.String("type", string(event.Type)),
+				zap.String("bucket", event.Bucket),
+				zap.String("key", event.Key),
+				zap.Error(err))
+		}
+	}
+}
+
+// safeCall runs a handler, converting panics into errors
+func (b *Bus) safeCall(handler Handler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return handler(b.ctx, event)
+}
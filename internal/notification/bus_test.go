@@ -0,0 +1,56 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func TestBus_Delivery(t *testing.T) {
+	bus := NewBus(10, 1)
+
+	received := make(chan Event, 10)
+	bus.Subscribe("failing", func(ctx context.Context, event Event) error {
+		return errors.New("boom")
+	})
+	bus.Subscribe("panicking", func(ctx context.Context, event Event) error {
+		panic("boom")
+	})
+	bus.Subscribe("recorder", func(ctx context.Context, event Event) error {
+		received <- event
+		return nil
+	})
+
+	bus.Start()
+	defer bus.Stop()
+
+	bus.Publish(Event{Type: EventObjectCreated, Bucket: "b", Key: "k"})
+
+	select {
+	case event := <-received:
+		if event.Key != "k" || event.Timestamp.IsZero() {
+			t.Errorf("event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered past failing handlers")
+	}
+}
+
+func TestBus_DropsWhenFull(t *testing.T) {
+	bus := NewBus(1, 1)
+
+	// Not started: the queue fills and further events are dropped
+	bus.Publish(Event{Key: "a"})
+	bus.Publish(Event{Key: "b"})
+
+	if len(bus.queue) != 1 {
+		t.Errorf("queue length = %d, want 1", len(bus.queue))
+	}
+}
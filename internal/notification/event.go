@@ -0,0 +1,23 @@
+package notification
+
+import "time"
+
+// EventType identifies what happened to an object
+type EventType string
+
+const (
+	EventObjectCreated EventType = "object_created"
+	EventObjectRemoved EventType = "object_removed"
+)
+
+// Event describes a change to an object
+type Event struct {
+	Type        EventType `json:"type"`
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	VersionID   string    `json:"version_id,omitempty"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	ETag        string    `json:"etag,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
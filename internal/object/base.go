@@ -0,0 +1,221 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// baseBlockSize is the size of the blocks a version written on a base is
+// compared with the base in. Blocks matching the base are shared with it.
+const baseBlockSize = 64 << 10
+
+// baseEditLimit is how many changed bytes of a version written on a base
+// are held in memory while it is compared with the base. Versions
+// changing more are stored in full.
+const baseEditLimit = 16 << 20
+
+// ownRun marks the runs of a version being written on a base that hold
+// its changed bytes, until those are stored
+const ownRun = -1
+
+// PutObjectOnBase stores a new version of an object that shares the data
+// it has in common with baseVersion, an earlier version of the same key.
+// The data is compared with the base block by block: blocks unchanged at
+// the same position point at the base's extents instead of being stored
+// again, and those extents are counted so neither version frees what the
+// other still holds. Rewriting a few records of a large fixed-size file,
+// or appending to it, then takes space for the changed blocks only.
+//
+// Sharing is skipped, and the version stored in full as by
+// PutObjectWithMetadata, for encrypted objects, each encrypted with its
+// own data key, when the base is neither the latest version nor already
+// shared, and when more than baseEditLimit bytes changed.
+func (s *Service) PutObjectOnBase(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string, metadata map[string]string, baseVersion string) (*Object, error) {
+	base, err := s.holdBase(ctx, bucket, key, baseVersion, size)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.putObject(ctx, bucket, key, data, size, contentType, metadata, nil, base, "")
+	if base == nil {
+		return obj, err
+	}
+
+	// Only the extents the new version points at stay held; freeing the
+	// others releases them, or frees them if the base went meanwhile
+	var kept []allocation
+	if err == nil {
+		kept = obj.allocations()
+	}
+	for _, a := range base.allocations() {
+		if slices.Contains(kept, a) {
+			continue
+		}
+		if err := s.free(a.offset, a.size); err != nil {
+			monitoring.Log.Warn("Failed to free storage of base version",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.String("version_id", base.VersionID),
+				zap.Error(err))
+		}
+	}
+	return obj, err
+}
+
+// holdBase reads the base version of a put and takes references to its
+// extents, flagging it shared so they are counted again after a restart.
+// It returns nil, holding nothing, when the put cannot share them.
+func (s *Service) holdBase(ctx context.Context, bucket, key, versionID string, size int64) (*Object, error) {
+	// As for bucket clones, extents freed between reading the base and
+	// taking references to them must not be shared
+	capture := s.pins.beginCapture()
+	base, err := s.getObject(ctx, bucket, key, &versionID)
+	shareable := err == nil && s.keys == nil && base.Encryption == nil && base.Size > 0 && size > 0
+	if shareable {
+		s.refs.retain(base)
+	}
+	freed := s.pins.endCapture(capture)
+	if err != nil || !shareable {
+		return nil, err
+	}
+	if base.freedIn(freed) {
+		s.refs.drop(base)
+		return nil, nil
+	}
+	if base.Shared {
+		return base, nil
+	}
+
+	updated := *base
+	updated.Shared = true
+	err = s.repo.UpdateMetadata(ctx, &updated, base.VersionID)
+	if err == nil {
+		return &updated, nil
+	}
+	if freeErr := s.freeObject(base); freeErr != nil {
+		return nil, freeErr
+	}
+	if errors.Is(err, ErrObjectChanged) || errors.Is(err, ErrObjectNotFound) {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("failed to share %s/%s: %w", bucket, key, err)
+}
+
+// writeOnBase stores the data of obj, a version written on base, comparing
+// it with the base block by block. Unchanged blocks point at the base's
+// data; changed ones are held in memory and then stored together in an
+// extent of obj's own, which is returned. Once more than baseEditLimit
+// bytes changed, obj is stored in full instead.
+func (s *Service) writeOnBase(ctx context.Context, obj *Object, data io.Reader, base *Object) (allocation, bool, error) {
+	var runs []Extent
+	var changed []byte
+	block := make([]byte, baseBlockSize)
+	for pos := int64(0); pos < obj.Size; {
+		n, err := io.ReadFull(data, block[:min(baseBlockSize, obj.Size-pos)])
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return allocation{}, false, fmt.Errorf("object data is shorter than its declared size of %d bytes", obj.Size)
+		}
+		if err != nil {
+			return allocation{}, false, err
+		}
+		length := int64(n)
+
+		if pos+length <= base.Size {
+			stored, err := s.readStored(ctx, base, pos, length)
+			if err != nil {
+				return allocation{}, false, err
+			}
+			if bytes.Equal(stored, block[:n]) {
+				runs = appendRuns(runs, base.extents(pos, length)...)
+				pos += length
+				continue
+			}
+		}
+
+		if len(changed)+n > baseEditLimit {
+			// Store what was compared so far, then the rest, in full
+			read := &runReader{ctx: ctx, svc: s, runs: runs, changed: changed}
+			return s.writeExtent(ctx, io.MultiReader(read, bytes.NewReader(block[:n]), data), obj.Size, nil)
+		}
+		runs = appendRuns(runs, Extent{Offset: int64(len(changed)), Size: length, Alloc: ownRun})
+		changed = append(changed, block[:n]...)
+		pos += length
+	}
+	if n, _ := io.ReadFull(data, block[:1]); n > 0 {
+		return allocation{}, false, fmt.Errorf("object data exceeds its declared size of %d bytes", obj.Size)
+	}
+
+	var own allocation
+	var sealed bool
+	if len(changed) > 0 {
+		var err error
+		if own, sealed, err = s.writeExtent(ctx, bytes.NewReader(changed), int64(len(changed)), nil); err != nil {
+			return allocation{}, false, err
+		}
+	}
+	for i := range runs {
+		if runs[i].Alloc == ownRun {
+			runs[i].Offset += own.offset
+			runs[i].Alloc, runs[i].AllocSize = own.offset, own.size
+		}
+	}
+	obj.Extents = runs
+	obj.Shared = true
+	return own, sealed, nil
+}
+
+// appendRuns appends runs to a list, merging those continuing the last
+func appendRuns(runs []Extent, more ...Extent) []Extent {
+	for _, run := range more {
+		if n := len(runs); n > 0 && runs[n-1].Alloc == run.Alloc && runs[n-1].Offset+runs[n-1].Size == run.Offset {
+			runs[n-1].Size += run.Size
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// runReader reads the runs of a version being written on a base in turn,
+// those of its own from the changed bytes held in memory
+type runReader struct {
+	ctx     context.Context
+	svc     *Service
+	runs    []Extent
+	changed []byte
+	buf     []byte
+}
+
+func (r *runReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.runs) == 0 {
+			return 0, io.EOF
+		}
+		run := r.runs[0]
+		n := min(run.Size, baseBlockSize)
+		if run.Alloc == ownRun {
+			r.buf = r.changed[run.Offset : run.Offset+n]
+		} else {
+			data, err := r.svc.engine.Read(r.ctx, run.Offset, n)
+			if err != nil {
+				return 0, err
+			}
+			r.buf = data
+		}
+		if run.Offset, run.Size = run.Offset+n, run.Size-n; run.Size == 0 {
+			r.runs = r.runs[1:]
+		} else {
+			r.runs[0] = run
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,100 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPutObjectOnBase(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	repo := NewMemoryRepository()
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("r"), 3*baseBlockSize)
+	base, err := service.PutObject(ctx, "db", "table.dat", bytes.NewReader(data), int64(len(data)), "application/octet-stream")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Rewrite a record in the middle block and append to the end
+	edited := append(bytes.Clone(data), "tail"...)
+	copy(edited[baseBlockSize+10:], "edit")
+	obj, err := service.PutObjectOnBase(ctx, "db", "table.dat", bytes.NewReader(edited), int64(len(edited)), "application/octet-stream", nil, base.VersionID)
+	if err != nil {
+		t.Fatalf("PutObjectOnBase failed: %v", err)
+	}
+	if len(obj.Extents) != 4 || !obj.Shared {
+		t.Fatalf("extents = %+v, want the first, middle and last blocks and the tail", obj.Extents)
+	}
+	if own := obj.sealedExtent(); own.size != baseBlockSize+4 {
+		t.Errorf("stored %d changed bytes, want %d", own.size, baseBlockSize+4)
+	}
+	if head, _ := repo.Head(ctx, "db", "table.dat", nil); head.Extents == nil {
+		t.Error("the version written on the base was not saved with its extents")
+	}
+
+	_, r, err := service.GetObject(ctx, "db", "table.dat", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if !bytes.Equal(got, edited) {
+		t.Error("GetObject did not return the edited data")
+	}
+	part, err := service.readRange(ctx, obj, baseBlockSize-2, 16)
+	if err != nil || !bytes.Equal(part, edited[baseBlockSize-2:baseBlockSize+14]) {
+		t.Errorf("range across extents = %q, %v", part, err)
+	}
+
+	// After a restart the extents of the base are still held by the new
+	// version, and freed with it
+	restarted := NewService(repo, engine)
+	var marked []int64
+	err = restarted.StoredExtents(ctx, "db", func(offset, size int64) error {
+		marked = append(marked, offset)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StoredExtents failed: %v", err)
+	}
+	if len(marked) != 2 || marked[0] != base.Offset {
+		t.Errorf("StoredExtents marked %v, want the base extent %d and the changed bytes", marked, base.Offset)
+	}
+	if err := restarted.DeleteObject(ctx, "db", "table.dat"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 2 {
+		t.Errorf("freed %v, want both extents of the version", engine.freed)
+	}
+}
+
+func TestPutObjectOnBase_Failed(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	service := NewService(NewMemoryRepository(), engine)
+	ctx := context.Background()
+
+	base, err := service.PutObject(ctx, "db", "table.dat", bytes.NewReader([]byte("original")), 8, "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	if _, err := service.PutObjectOnBase(ctx, "db", "table.dat", bytes.NewReader([]byte("replaced")), 8, "text/plain", nil, "missing"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("unknown base version = %v, want ErrVersionNotFound", err)
+	}
+	if _, err := service.PutObjectOnBase(ctx, "db", "table.dat", bytes.NewReader([]byte("short")), 8, "text/plain", nil, base.VersionID); err == nil {
+		t.Error("data shorter than its declared size was stored")
+	}
+
+	// The failed put released the base, whose delete frees its extent
+	if err := service.DeleteObject(ctx, "db", "table.dat"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 1 || engine.freed[0] != base.Offset {
+		t.Errorf("freed %v, want the base extent %d", engine.freed, base.Offset)
+	}
+}
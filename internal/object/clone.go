@@ -0,0 +1,170 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// cloneAttempts is how many times the latest version of a key is re-read
+// when it is written while being cloned
+const cloneAttempts = 3
+
+// CloneResult counts what a bucket clone copied
+type CloneResult struct {
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"` // Shared with the source, not copied
+}
+
+// CloneBucket copies the latest version of every object of bucket into
+// target without copying their data: the copies point at the same
+// extents, which are counted so deleting an object of either bucket
+// frees nothing the other still holds. Writes never change stored data,
+// so an overwrite in one bucket leaves the other's object as it was.
+//
+// Each object is cloned as it was when reached, with its version ID and
+// times. The copies are not replicated, recorded or announced.
+func (s *Service) CloneBucket(ctx context.Context, bucket, target string) (CloneResult, error) {
+	var result CloneResult
+
+	// As for snapshots, extents freed between listing an object and
+	// taking a reference to it must not be cloned
+	capture := s.pins.beginCapture()
+	var shared []*Object
+	err := s.WalkObjects(ctx, bucket, "", "", func(obj *Object) error {
+		if obj.DeleteMarker {
+			return nil
+		}
+		src, err := s.share(ctx, obj)
+		if src != nil {
+			shared = append(shared, src)
+		}
+		return err
+	})
+	freed := s.pins.endCapture(capture)
+	if err != nil {
+		for _, obj := range shared {
+			s.refs.drop(obj)
+		}
+		return result, fmt.Errorf("failed to clone bucket %s: %w", bucket, err)
+	}
+
+	for i, src := range shared {
+		if src.freedIn(freed) {
+			s.refs.drop(src)
+			continue
+		}
+		dst := *src
+		dst.BucketName = target
+		dst.ReplicatedAt = nil
+		if err := s.repo.Put(ctx, &dst, nil); err != nil {
+			for _, obj := range shared[i:] {
+				s.refs.drop(obj)
+			}
+			return result, fmt.Errorf("failed to clone %s/%s: %w", bucket, src.Key, err)
+		}
+		result.Objects++
+		result.Bytes += src.Size
+	}
+	return result, nil
+}
+
+// share takes a reference to the extent of obj, or of the latest version
+// of its key should obj have been replaced, and flags the version shared
+// so the reference is counted again after a restart. It returns the
+// version to clone, nil if the key was deleted meanwhile.
+func (s *Service) share(ctx context.Context, obj *Object) (*Object, error) {
+	for attempt := 0; ; attempt++ {
+		// Empty objects have no extent to share
+		if obj.Size == 0 {
+			return obj, nil
+		}
+		s.refs.retain(obj)
+		if obj.Shared {
+			return obj, nil
+		}
+
+		updated := *obj
+		updated.Shared = true
+		err := s.repo.UpdateMetadata(ctx, &updated, obj.VersionID)
+		if err == nil {
+			return &updated, nil
+		}
+		s.refs.drop(obj)
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, nil
+		}
+		if !errors.Is(err, ErrObjectChanged) || attempt == cloneAttempts-1 {
+			return nil, fmt.Errorf("failed to share %s/%s: %w", obj.BucketName, obj.Key, err)
+		}
+
+		if obj, _, err = s.repo.Get(ctx, obj.BucketName, obj.Key, nil); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if obj.DeleteMarker {
+			return nil, nil
+		}
+	}
+}
+
+// refTracker counts the objects holding each extent shared by bucket
+// clones or by versions written on a base, keyed by offset. Extents held
+// by one object are not tracked.
+type refTracker struct {
+	mu   sync.Mutex
+	refs map[int64]int
+}
+
+func newRefTracker() *refTracker {
+	return &refTracker{refs: make(map[int64]int)}
+}
+
+// retain counts another object holding obj's extents
+func (t *refTracker) retain(obj *Object) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, a := range obj.allocations() {
+		if n, ok := t.refs[a.offset]; ok {
+			t.refs[a.offset] = n + 1
+			continue
+		}
+		t.refs[a.offset] = 2
+	}
+}
+
+// drop undoes a retain of obj's extents
+func (t *refTracker) drop(obj *Object) {
+	for _, a := range obj.allocations() {
+		t.release(a.offset)
+	}
+}
+
+// release drops an object's hold on an extent and reports whether other
+// objects still hold it
+func (t *refTracker) release(offset int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.refs[offset]
+	if !ok {
+		return false
+	}
+	if n <= 2 {
+		delete(t.refs, offset)
+		return n == 2
+	}
+	t.refs[offset] = n - 1
+	return true
+}
+
+// restore counts a shared object found while restoring allocations and
+// reports whether it is the first holding its extent
+func (t *refTracker) restore(offset int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refs[offset]++
+	return t.refs[offset] == 1
+}
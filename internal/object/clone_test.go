@@ -0,0 +1,117 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestCloneBucket(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	service := NewService(NewMemoryRepository(), engine)
+	ctx := context.Background()
+
+	for key, data := range map[string]string{"a.txt": "first", "b.txt": "second", "dir/": ""} {
+		if _, err := service.PutObject(ctx, "prod", key, bytes.NewReader([]byte(data)), int64(len(data)), "text/plain"); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	result, err := service.CloneBucket(ctx, "prod", "test")
+	if err != nil {
+		t.Fatalf("CloneBucket failed: %v", err)
+	}
+	if result.Objects != 3 || result.Bytes != 11 {
+		t.Errorf("CloneBucket = %+v, want 3 objects of 11 bytes", result)
+	}
+	src, _ := service.HeadObject(ctx, "prod", "b.txt", nil)
+	dst, _ := service.HeadObject(ctx, "test", "b.txt", nil)
+	if src.Offset != dst.Offset || !src.Shared || !dst.Shared || dst.VersionID != src.VersionID {
+		t.Errorf("clone %+v does not share the data of %+v", dst, src)
+	}
+
+	read := func(bucket, key string) string {
+		t.Helper()
+		_, r, err := service.GetObject(ctx, bucket, key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s/%s) failed: %v", bucket, key, err)
+		}
+		defer r.Close()
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	// Writes to one bucket leave the other as it was
+	if _, err := service.PutObject(ctx, "test", "a.txt", bytes.NewReader([]byte("edited")), 6, "text/plain"); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if got := read("prod", "a.txt"); got != "first" {
+		t.Errorf("source after overwriting the clone = %q, want first", got)
+	}
+	if got := read("test", "a.txt"); got != "edited" {
+		t.Errorf("clone after overwriting it = %q, want edited", got)
+	}
+
+	// The last object holding an extent frees it
+	if err := service.DeleteObject(ctx, "prod", "b.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 0 {
+		t.Errorf("freed %v still held by the clone", engine.freed)
+	}
+	if got := read("test", "b.txt"); got != "second" {
+		t.Errorf("clone after deleting the source = %q, want second", got)
+	}
+	if err := service.DeleteObject(ctx, "test", "b.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 1 || engine.freed[0] != src.Offset {
+		t.Errorf("freed %v, want the shared extent %d", engine.freed, src.Offset)
+	}
+}
+
+func TestCloneBucket_RestoredReferences(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	repo := NewMemoryRepository()
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	obj, err := service.PutObject(ctx, "prod", "data.bin", bytes.NewReader([]byte("payload")), 7, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := service.CloneBucket(ctx, "prod", "test"); err != nil {
+		t.Fatalf("CloneBucket failed: %v", err)
+	}
+
+	// After a restart the references are counted again from the metadata,
+	// and the shared extent restored once
+	restarted := NewService(repo, engine)
+	var marked []int64
+	for _, bucket := range []string{"prod", "test"} {
+		err := restarted.StoredExtents(ctx, bucket, func(offset, size int64) error {
+			marked = append(marked, offset)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("StoredExtents(%s) failed: %v", bucket, err)
+		}
+	}
+	if len(marked) != 1 || marked[0] != obj.Offset {
+		t.Errorf("StoredExtents marked %v, want the shared extent %d once", marked, obj.Offset)
+	}
+
+	if err := restarted.DeleteObject(ctx, "test", "data.bin"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 0 {
+		t.Errorf("freed %v still held by the source", engine.freed)
+	}
+	if err := restarted.DeleteObject(ctx, "prod", "data.bin"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 1 {
+		t.Errorf("freed %v, want the shared extent", engine.freed)
+	}
+}
@@ -0,0 +1,137 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// CompactionSummary totals a compaction run
+type CompactionSummary struct {
+	Objects int   `json:"objects"` // Objects moved out of the slabs
+	Bytes   int64 `json:"bytes"`
+	Skipped int   `json:"skipped"` // Objects sharing their data, or changed meanwhile
+	Failed  int   `json:"failed"`  // Objects that could not be moved
+}
+
+// CompactionObserver is notified after every object moved or skipped
+type CompactionObserver func(summary CompactionSummary)
+
+// Compact moves the objects of the buckets stored in slabs to new extents,
+// so that the slabs empty and take new data again. New data, the moved
+// objects included, is kept out of the slabs while they drain. Objects
+// whose data is shared with clones or other versions stay where they are.
+// Objects that cannot be moved are logged and counted, and compaction goes
+// on; observer, if not nil, is notified as it does.
+func (s *Service) Compact(ctx context.Context, buckets []string, slabs []storage.SlabFragmentation, observer CompactionObserver) (CompactionSummary, error) {
+	var summary CompactionSummary
+	if len(slabs) == 0 {
+		return summary, nil
+	}
+	if drainer, ok := s.engine.(storage.SlabDrainer); ok {
+		offsets := make([]int64, len(slabs))
+		for i, slab := range slabs {
+			offsets[i] = slab.Offset
+		}
+		defer drainer.DrainSlabs(offsets)()
+	}
+
+	for _, bucket := range buckets {
+		err := s.WalkObjects(ctx, bucket, "", "", func(obj *Object) error {
+			if obj.DeleteMarker || obj.Size == 0 || !inSlabs(obj.Offset, slabs) {
+				return nil
+			}
+			switch err := s.moveData(ctx, obj); {
+			case err == nil:
+				summary.Objects++
+				summary.Bytes += obj.Size
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(err, errShared), errors.Is(err, ErrObjectChanged), errors.Is(err, ErrObjectNotFound):
+				summary.Skipped++
+			default:
+				monitoring.Log.Error("Failed to move object data",
+					zap.String("bucket", bucket),
+					zap.String("key", obj.Key),
+					zap.Error(err))
+				summary.Failed++
+			}
+			if observer != nil {
+				observer(summary)
+			}
+			return nil
+		})
+		if err != nil {
+			return summary, fmt.Errorf("failed to compact bucket %s: %w", bucket, err)
+		}
+	}
+
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d objects were not moved", summary.Failed)
+	}
+	return summary, nil
+}
+
+// errShared is returned when moving data other objects point into
+var errShared = errors.New("object data is shared")
+
+// moveData copies the stored bytes of an object to a new extent and points
+// the object at it, then frees the old extent. Data is copied as stored,
+// encrypted or not. It returns ErrObjectChanged if the object was
+// overwritten since obj was read.
+func (s *Service) moveData(ctx context.Context, obj *Object) error {
+	if obj.Shared || obj.Extents != nil {
+		return errShared
+	}
+	data, err := s.readStored(ctx, obj, 0, obj.Size)
+	if err != nil {
+		return err
+	}
+	own, sealed, err := s.writeExtent(ctx, bytes.NewReader(data), obj.Size, nil)
+	if err != nil {
+		return err
+	}
+
+	current, _, err := s.repo.Get(ctx, obj.BucketName, obj.Key, nil)
+	if err == nil && (current.VersionID != obj.VersionID || current.Offset != obj.Offset) {
+		err = ErrObjectChanged
+	}
+	if err == nil && (current.Shared || current.Extents != nil) {
+		err = errShared
+	}
+	if err == nil {
+		// Repositories may hand out shared objects, so update a copy
+		updated := *current
+		updated.Offset = own.offset
+		updated.Sealed = sealed
+		err = s.repo.UpdateMetadata(ctx, &updated, current.VersionID)
+	}
+	if err != nil {
+		s.discard(own)
+		return err
+	}
+
+	if err := s.freeObject(current); err != nil {
+		monitoring.Log.Warn("Failed to free the old extent of a moved object",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.Error(err))
+	}
+	return nil
+}
+
+// inSlabs reports whether offset lies in one of slabs
+func inSlabs(offset int64, slabs []storage.SlabFragmentation) bool {
+	for _, slab := range slabs {
+		if offset >= slab.Offset && offset < slab.Offset+slab.Size {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/danielino/comio/internal/storage"
+)
+
+func TestCompact(t *testing.T) {
+	engine := createTestEngine(t)
+	service := NewService(NewMemoryRepository(), engine)
+	ctx := context.Background()
+
+	data := map[string][]byte{}
+	for _, key := range []string{"a", "b", "c"} {
+		data[key] = bytes.Repeat([]byte(key), 1000)
+		if _, err := service.PutObject(ctx, "bucket", key, bytes.NewReader(data[key]), 1000, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deleting the first objects leaves a hole before the last one
+	for _, key := range []string{"a", "b"} {
+		if err := service.DeleteObject(ctx, "bucket", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reporter := engine.(storage.FragmentationReporter)
+	slabs := reporter.Fragmentation().Compactable(0)
+	if len(slabs) != 1 || slabs[0].Offset != 0 {
+		t.Fatalf("Compactable() = %+v, want the first slab", slabs)
+	}
+
+	var observed CompactionSummary
+	summary, err := service.Compact(ctx, []string{"bucket"}, slabs, func(s CompactionSummary) { observed = s })
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if summary.Objects != 1 || summary.Bytes != 1000 || observed != summary {
+		t.Errorf("Compact() = %+v, observed %+v", summary, observed)
+	}
+
+	// The object moved out of the slab, which is empty again
+	obj, rc, err := service.GetObject(ctx, "bucket", "c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data["c"]) {
+		t.Error("moved object data differs")
+	}
+	if obj.Offset < slabs[0].Size {
+		t.Errorf("object offset = %d, want outside the compacted slab", obj.Offset)
+	}
+	report := reporter.Fragmentation()
+	for _, slab := range report.Slabs {
+		if slab.Offset == 0 && (slab.Objects != 0 || slab.WastedBytes != 0) {
+			t.Errorf("compacted slab = %+v, want it empty", slab)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// putTestObject stores metadata for key in the test bucket
+func putTestObject(ctx context.Context, repo Repository, key string, size int64) error {
+	now := time.Now()
+	return repo.Put(ctx, &Object{
+		Key:        key,
+		BucketName: "iter-bucket",
+		VersionID:  GenerateVersionID(),
+		Size:       size,
+		CreatedAt:  now,
+		ModifiedAt: now,
+	}, nil)
+}
+
+// listKeys returns the keys and common prefixes of a full listing
+func listKeys(ctx context.Context, repo Repository, prefix, delimiter string) ([]string, []string, error) {
+	result, err := repo.List(ctx, "iter-bucket", prefix, ListOptions{Prefix: prefix, Delimiter: delimiter})
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := []string{}
+	for _, obj := range result.Objects {
+		keys = append(keys, obj.Key)
+	}
+	prefixes := append([]string{}, result.CommonPrefixes...)
+	return keys, prefixes, nil
+}
+
+// expectedListing lists a model of the bucket the way every backend must
+func expectedListing(model map[string]bool, prefix, delimiter string) ([]string, []string) {
+	var objects []*Object
+	for key := range model {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, &Object{Key: key})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	objects, prefixes := groupCommonPrefixes(objects, prefix, delimiter)
+
+	keys := []string{}
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	return keys, append([]string{}, prefixes...)
+}
+
+func TestRepository_ListAfterWrite(t *testing.T) {
+	ctx := context.Background()
+
+	// Keys chosen to trip up prefix matching: SQL wildcards, case and
+	// nesting
+	keys := []string{"a", "a%b", "a_b", "aXb", "A/x", "a/x", "a/y/z", "a/y/w", "b/c", "b/c/d"}
+	queries := []struct{ prefix, delimiter string }{
+		{"", ""}, {"", "/"}, {"a", ""}, {"a%", ""}, {"a_", ""}, {"a/", "/"}, {"A", ""}, {"b/c", "/"},
+	}
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			model := make(map[string]bool)
+
+			for i := 0; i < 200; i++ {
+				key := keys[rng.Intn(len(keys))]
+				op := "put"
+				if model[key] && rng.Intn(2) == 0 {
+					op = "delete"
+					if err := repo.Delete(ctx, "iter-bucket", key, nil); err != nil {
+						t.Fatalf("step %d: Delete(%q) error = %v", i, key, err)
+					}
+					delete(model, key)
+				} else {
+					if err := putTestObject(ctx, repo, key, int64(i)); err != nil {
+						t.Fatalf("step %d: Put(%q) error = %v", i, key, err)
+					}
+					model[key] = true
+				}
+
+				// Every listing reflects the write that just returned
+				for _, q := range queries {
+					gotKeys, gotPrefixes, err := listKeys(ctx, repo, q.prefix, q.delimiter)
+					if err != nil {
+						t.Fatalf("step %d: List(%q, %q) error = %v", i, q.prefix, q.delimiter, err)
+					}
+					wantKeys, wantPrefixes := expectedListing(model, q.prefix, q.delimiter)
+					if !reflect.DeepEqual(gotKeys, wantKeys) || !reflect.DeepEqual(gotPrefixes, wantPrefixes) {
+						t.Fatalf("step %d after %s %q: List(%q, %q) = %v %v, want %v %v",
+							i, op, key, q.prefix, q.delimiter, gotKeys, gotPrefixes, wantKeys, wantPrefixes)
+					}
+				}
+			}
+
+			// Deleting a missing key is reported the same way everywhere
+			if err := repo.Delete(ctx, "iter-bucket", "missing", nil); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Delete(missing) error = %v, want ErrObjectNotFound", err)
+			}
+
+			// DeleteAll empties nested keys too
+			count, _, err := repo.DeleteAll(ctx, "iter-bucket")
+			if err != nil {
+				t.Fatalf("DeleteAll() error = %v", err)
+			}
+			if count != len(model) {
+				t.Errorf("DeleteAll() removed %d objects, want %d", count, len(model))
+			}
+			if got, _, _ := listKeys(ctx, repo, "", ""); len(got) != 0 {
+				t.Errorf("List() after DeleteAll = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestRepository_ConcurrentListAfterWrite(t *testing.T) {
+	const (
+		writers = 4
+		rounds  = 25
+	)
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			errs := make(chan error, writers*rounds+16)
+			done := make(chan struct{})
+
+			// Each writer owns its keys, so after its own write returns the
+			// listing must agree with it whatever else is in flight
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < rounds; i++ {
+						key := fmt.Sprintf("w%d/dir%d/obj%d", w, i%3, i)
+						if err := putTestObject(ctx, repo, key, 1); err != nil {
+							errs <- fmt.Errorf("Put(%q): %w", key, err)
+							return
+						}
+						got, _, err := listKeys(ctx, repo, key, "")
+						if err != nil || len(got) != 1 || got[0] != key {
+							errs <- fmt.Errorf("List(%q) after put = %v, %v", key, got, err)
+							return
+						}
+
+						if i%2 == 0 {
+							if err := repo.Delete(ctx, "iter-bucket", key, nil); err != nil {
+								errs <- fmt.Errorf("Delete(%q): %w", key, err)
+								return
+							}
+							got, _, err := listKeys(ctx, repo, key, "")
+							if err != nil || len(got) != 0 {
+								errs <- fmt.Errorf("List(%q) after delete = %v, %v", key, got, err)
+								return
+							}
+						}
+					}
+				}(w)
+			}
+
+			// Every writer also rewrites a shared key
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < rounds; i++ {
+						if err := putTestObject(ctx, repo, "shared", 1); err != nil {
+							errs <- fmt.Errorf("Put(shared): %w", err)
+							return
+						}
+					}
+				}()
+			}
+
+			// Readers list the whole bucket meanwhile: no errors, no
+			// duplicates, always in key order
+			var readers sync.WaitGroup
+			for r := 0; r < 2; r++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						keys, _, err := listKeys(ctx, repo, "", "")
+						if err != nil {
+							errs <- fmt.Errorf("concurrent List(): %w", err)
+							return
+						}
+						for i := 1; i < len(keys); i++ {
+							if keys[i-1] >= keys[i] {
+								errs <- fmt.Errorf("concurrent List() out of order or duplicated: %q, %q", keys[i-1], keys[i])
+								return
+							}
+						}
+					}
+				}()
+			}
+
+			wg.Wait()
+			close(done)
+			readers.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			// Quiesced, the listing holds exactly the surviving keys
+			want := []string{"shared"}
+			for w := 0; w < writers; w++ {
+				for i := 1; i < rounds; i += 2 {
+					want = append(want, fmt.Sprintf("w%d/dir%d/obj%d", w, i%3, i))
+				}
+			}
+			sort.Strings(want)
+			got, _, err := listKeys(ctx, repo, "", "")
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("List() = %v, want %v", got, want)
+			}
+		})
+	}
+}
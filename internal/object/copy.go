@@ -0,0 +1,33 @@
+package object
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCopyToSelf is returned when an object is copied onto its own key
+// without replacing its metadata, which would change nothing
+var ErrCopyToSelf = errors.New("cannot copy an object onto itself without replacing its metadata")
+
+// CopyObject copies a version of an object, the latest when versionID is
+// nil, to dstBucket/dstKey server-side. The data is read from the storage engine and written to an
+// allocation of the copy's own, so either object can later be changed or
+// deleted on its own. The copy keeps the source's content type and
+// metadata, or with replace gets contentType and metadata instead, as with
+// S3's x-amz-metadata-directive.
+func (s *Service) CopyObject(ctx context.Context, bucket, key string, versionID *string, dstBucket, dstKey string, replace bool, contentType string, metadata map[string]string) (*Object, error) {
+	if bucket == dstBucket && key == dstKey && versionID == nil && !replace {
+		return nil, ErrCopyToSelf
+	}
+
+	src, data, err := s.GetObject(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	if !replace {
+		contentType, metadata = src.ContentType, src.Metadata
+	}
+	return s.putObject(ctx, dstBucket, dstKey, data, src.Size, contentType, metadata, nil, nil, "")
+}
@@ -0,0 +1,91 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestCopyObject(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+	ctx := context.Background()
+
+	src, err := service.PutObjectWithMetadata(ctx, "bucket", "src", bytes.NewReader([]byte("payload")), 7, "text/plain",
+		map[string]string{"x-amz-meta-album": "2024"})
+	if err != nil {
+		t.Fatalf("PutObjectWithMetadata failed: %v", err)
+	}
+
+	kept, err := service.CopyObject(ctx, "bucket", "src", nil, "other", "kept", false, "", nil)
+	if err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+	if kept.ContentType != "text/plain" || kept.Metadata["x-amz-meta-album"] != "2024" || kept.ETag != src.ETag {
+		t.Errorf("copy = %+v, want the source's content type, metadata and ETag", kept)
+	}
+	if kept.Offset == src.Offset {
+		t.Error("copy shares the source's extent, want one of its own")
+	}
+
+	replaced, err := service.CopyObject(ctx, "bucket", "src", nil, "bucket", "src", true, "application/json", map[string]string{"x-amz-meta-album": "2025"})
+	if err != nil {
+		t.Fatalf("CopyObject onto itself with REPLACE failed: %v", err)
+	}
+	if replaced.ContentType != "application/json" || replaced.Metadata["x-amz-meta-album"] != "2025" {
+		t.Errorf("replaced = %+v, want the new content type and metadata", replaced)
+	}
+	if _, err := service.CopyObject(ctx, "bucket", "src", nil, "bucket", "src", false, "", nil); !errors.Is(err, ErrCopyToSelf) {
+		t.Errorf("copy onto itself error = %v, want ErrCopyToSelf", err)
+	}
+
+	// The copy outlives its source
+	if err := service.DeleteObject(ctx, "bucket", "src"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	_, r, err := service.GetObject(ctx, "other", "kept", nil)
+	if err != nil {
+		t.Fatalf("GetObject of the copy failed: %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "payload" {
+		t.Errorf("copy data = %q, want %q", data, "payload")
+	}
+	if _, err := service.CopyObject(ctx, "bucket", "src", nil, "other", "gone", false, "", nil); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("copy of a missing object error = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestCopyObject_Version(t *testing.T) {
+	// Only the SQLite repository keeps older versions
+	service := NewService(testRepositories(t)["sqlite"], createTestEngine(t))
+	service.SetVersioning(func(ctx context.Context, bucket string) bool { return true })
+	ctx := context.Background()
+
+	first, err := service.PutObject(ctx, "iter-bucket", "src", bytes.NewReader([]byte("first")), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := service.PutObject(ctx, "iter-bucket", "src", bytes.NewReader([]byte("second")), 6, "text/plain"); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// An older version restores onto its own key
+	if _, err := service.CopyObject(ctx, "iter-bucket", "src", &first.VersionID, "iter-bucket", "src", false, "", nil); err != nil {
+		t.Fatalf("CopyObject of the first version failed: %v", err)
+	}
+	_, r, err := service.GetObject(ctx, "iter-bucket", "src", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "first" {
+		t.Errorf("latest data = %q, want the copied first version", data)
+	}
+
+	missing := "no-such-version"
+	if _, err := service.CopyObject(ctx, "iter-bucket", "src", &missing, "other-bucket", "copy", false, "", nil); err == nil {
+		t.Error("copy of a missing version succeeded")
+	}
+}
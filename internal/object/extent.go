@@ -0,0 +1,138 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/danielino/comio/internal/storage"
+)
+
+// Extent is a run of an object's data in the storage engine. Versions
+// written on a base version are stored as a list of them, pointing into
+// an allocation of their own and into those of the base.
+type Extent struct {
+	Offset    int64 `json:"offset"`
+	Size      int64 `json:"size"`
+	Alloc     int64 `json:"alloc"`      // Offset of the allocation holding the run
+	AllocSize int64 `json:"alloc_size"` // Size of that allocation, its seal record included
+}
+
+// allocation is an extent handed out by the storage engine, of the size it
+// was allocated with
+type allocation struct {
+	offset, size int64
+}
+
+// allocSize returns the size allocated for the data of an object stored
+// in an extent of its own, with room for the seal record of sealed ones
+func (o *Object) allocSize() int64 {
+	if o.Sealed {
+		return o.Size + storage.SealSize
+	}
+	return o.Size
+}
+
+// allocations returns the extents allocated for an object's data: its
+// own, or for versions written on a base each one their runs lie in
+func (o *Object) allocations() []allocation {
+	if o.Extents == nil {
+		if o.Size == 0 {
+			return nil
+		}
+		return []allocation{{o.Offset, o.allocSize()}}
+	}
+	var allocs []allocation
+	for _, e := range o.Extents {
+		if a := (allocation{e.Alloc, e.AllocSize}); !slices.Contains(allocs, a) {
+			allocs = append(allocs, a)
+		}
+	}
+	return allocs
+}
+
+// extents returns the runs holding bytes [start, start+length) of an
+// object, in order
+func (o *Object) extents(start, length int64) []Extent {
+	if o.Extents == nil {
+		return []Extent{{Offset: o.Offset + start, Size: length, Alloc: o.Offset, AllocSize: o.allocSize()}}
+	}
+	var runs []Extent
+	var pos int64
+	for _, e := range o.Extents {
+		end := pos + e.Size
+		if end > start && pos < start+length {
+			from, to := max(start, pos), min(start+length, end)
+			runs = append(runs, Extent{Offset: e.Offset + from - pos, Size: to - from, Alloc: e.Alloc, AllocSize: e.AllocSize})
+		}
+		pos = end
+	}
+	return runs
+}
+
+// sealedExtent returns the extent an object's seal record follows: its
+// data, or for versions written on a base the bytes they changed, which
+// fill their allocation up to the record
+func (o *Object) sealedExtent() allocation {
+	for _, a := range o.allocations() {
+		if a.offset == o.Offset {
+			if o.Sealed {
+				a.size -= storage.SealSize
+			}
+			return a
+		}
+	}
+	return allocation{}
+}
+
+// freedIn reports whether an extent of the object is among those freed
+// during a capture
+func (o *Object) freedIn(freed map[int64]bool) bool {
+	for _, a := range o.allocations() {
+		if freed[a.offset] {
+			return true
+		}
+	}
+	return false
+}
+
+// readStored reads the stored bytes [start, start+length) of an object
+func (s *Service) readStored(ctx context.Context, obj *Object, start, length int64) ([]byte, error) {
+	if obj.Extents == nil {
+		return s.engine.Read(ctx, obj.Offset+start, length)
+	}
+	data := make([]byte, 0, length)
+	for _, run := range obj.extents(start, length) {
+		b, err := s.engine.Read(ctx, run.Offset, run.Size)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, nil
+}
+
+// freeObject frees the extents of an object's data, as free does
+func (s *Service) freeObject(obj *Object) error {
+	var errs []error
+	for _, a := range obj.allocations() {
+		if err := s.free(a.offset, a.size); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pinObject pins the extents of an object's data like a snapshot's
+func (s *Service) pinObject(obj *Object) {
+	for _, a := range obj.allocations() {
+		s.pins.pin(a.offset)
+	}
+}
+
+// unpinObject undoes pinObject
+func (s *Service) unpinObject(obj *Object) {
+	for _, a := range obj.allocations() {
+		s.unpin(a.offset)
+	}
+}
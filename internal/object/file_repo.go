@@ -5,21 +5,48 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 
+	"github.com/danielino/comio/internal/metafile"
 	"github.com/danielino/comio/pkg/pathutil"
 )
 
+// keyLockStripes is the number of locks keys are spread over
+const keyLockStripes = 64
+
+// metaFormat is the format of object metadata files. Version 1 files may
+// predate storage classes; version 3 added the file checksum.
+var metaFormat = metafile.Format{
+	Upgrades: []metafile.Upgrade{
+		func(fields map[string]json.RawMessage) error {
+			if class, ok := fields["storage_class"]; !ok || string(class) == `""` {
+				fields["storage_class"] = json.RawMessage(`"` + StorageClassStandard + `"`)
+			}
+			return nil
+		},
+		func(fields map[string]json.RawMessage) error { return nil },
+	},
+}
+
 // FileRepository implements Repository using filesystem metadata files
 // Like MinIO: no global locks, filesystem handles concurrency
 type FileRepository struct {
 	metadataDir string
 	// No global mutex - each file operation is independent
-	// Filesystem provides atomic operations (rename) and concurrency
+	// Filesystem provides atomic operations (rename) and concurrency.
+	// Replacing a metadata file is guarded by a per-key lock stripe only so
+	// UpdateMetadata's read-compare-rename cannot interleave with a write.
+	keyLocks [keyLockStripes]sync.Mutex
+	// Corrupt metadata files are moved out of the way and reported
+	quarantine *metafile.Quarantine
+	writer     *metafile.Writer
 }
 
 // NewFileRepository creates a new file-based repository
@@ -32,9 +59,30 @@ func NewFileRepository(metadataDir string) (*FileRepository, error) {
 
 	return &FileRepository{
 		metadataDir: metadataDir,
+		quarantine:  metafile.NewQuarantine(metadataDir),
+		writer:      metafile.NewWriter(metafile.DurabilityFull),
 	}, nil
 }
 
+// SetDurability sets how much of each metadata write is synced to disk
+// before it returns. It must be called before the repository is used.
+func (r *FileRepository) SetDurability(durability metafile.Durability) {
+	r.writer.Durability = durability
+}
+
+// decodeMeta decodes the metadata file at path into obj. A corrupt file is
+// quarantined, so the loss is reported once instead of the file being
+// skipped on every read.
+func (r *FileRepository) decodeMeta(path string, metaData []byte, obj *Object) error {
+	_, err := metaFormat.Unmarshal(metaData, obj)
+	if errors.Is(err, metafile.ErrCorrupt) {
+		if qErr := r.quarantine.Add(path, metaData, err); qErr != nil {
+			return fmt.Errorf("%w (%v)", err, qErr)
+		}
+	}
+	return err
+}
+
 // getObjectMetaPath returns the path to an object's metadata file
 func (r *FileRepository) getObjectMetaPath(bucket, key string) string {
 	// Sanitize bucket and key for filesystem
@@ -43,6 +91,20 @@ func (r *FileRepository) getObjectMetaPath(bucket, key string) string {
 	return filepath.Join(r.metadataDir, "objects", safeBucket, safeKey+".meta")
 }
 
+// lockKey locks the stripe of a metadata file and returns its unlock
+func (r *FileRepository) lockKey(metaPath string) func() {
+	mu := &r.keyLocks[keyStripe(metaPath)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// keyStripe returns the lock stripe of a metadata file
+func keyStripe(metaPath string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(metaPath))
+	return h.Sum32() % keyLockStripes
+}
+
 // getBucketDir returns the directory for a bucket's objects
 func (r *FileRepository) getBucketDir(bucket string) string {
 	safeBucket := pathutil.SanitizePath(bucket)
@@ -54,28 +116,30 @@ func (r *FileRepository) Put(ctx context.Context, obj *Object, data io.Reader) e
 	metaPath := r.getObjectMetaPath(obj.BucketName, obj.Key)
 
 	// Create bucket directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+	if err := r.writer.MkdirAll(filepath.Dir(metaPath)); err != nil {
 		return fmt.Errorf("failed to create bucket directory: %w", err)
 	}
 
-	// Marshal object metadata to JSON
-	metaData, err := json.MarshalIndent(obj, "", "  ")
+	tempPath, err := r.writeMetaTemp(metaPath, obj)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+		return err
 	}
 
-	// Write metadata file atomically (write to temp, then rename)
-	tempPath := metaPath + ".tmp"
-	if err := os.WriteFile(tempPath, metaData, 0644); err != nil {
-		return fmt.Errorf("failed to write metadata file: %w", err)
-	}
+	unlock := r.lockKey(metaPath)
+	defer unlock()
+	return r.writer.Commit(tempPath, metaPath)
+}
 
-	if err := os.Rename(tempPath, metaPath); err != nil {
-		os.Remove(tempPath) // Clean up temp file
-		return fmt.Errorf("failed to rename metadata file: %w", err)
+// writeMetaTemp writes obj's metadata to a temp file next to metaPath, for
+// the writer to commit into place. Each write gets its own temp file so
+// concurrent puts of a key cannot clobber each other; the name does not
+// end in .meta so listings skip it.
+func (r *FileRepository) writeMetaTemp(metaPath string, obj *Object) (string, error) {
+	metaData, err := metaFormat.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
 	}
-
-	return nil
+	return r.writer.WriteTemp(metaPath, metaData)
 }
 
 func (r *FileRepository) Get(ctx context.Context, bucket, key string, versionID *string) (*Object, io.ReadCloser, error) {
@@ -85,16 +149,20 @@ func (r *FileRepository) Get(ctx context.Context, bucket, key string, versionID
 	metaData, err := os.ReadFile(metaPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, nil, errors.New("object not found")
+			return nil, nil, ErrObjectNotFound
 		}
 		return nil, nil, fmt.Errorf("failed to read metadata: %w", err)
 	}
 
 	// Unmarshal metadata
 	var obj Object
-	if err := json.Unmarshal(metaData, &obj); err != nil {
+	if err := r.decodeMeta(metaPath, metaData, &obj); err != nil {
 		return nil, nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
 	}
+	// Only the latest version is kept
+	if versionID != nil && *versionID != "" && *versionID != obj.VersionID {
+		return nil, nil, ErrVersionNotFound
+	}
 
 	return &obj, nil, nil
 }
@@ -103,9 +171,22 @@ func (r *FileRepository) Delete(ctx context.Context, bucket, key string, version
 
 	metaPath := r.getObjectMetaPath(bucket, key)
 
-	if err := os.Remove(metaPath); err != nil {
+	unlock := r.lockKey(metaPath)
+	defer unlock()
+
+	// Only the latest version is kept
+	if versionID != nil && *versionID != "" {
+		current, err := r.Head(ctx, bucket, key, nil)
+		if err != nil {
+			return err
+		}
+		if current.VersionID != *versionID {
+			return ErrVersionNotFound
+		}
+	}
+	if err := r.writer.Remove(metaPath); err != nil {
 		if os.IsNotExist(err) {
-			return errors.New("object not found")
+			return ErrObjectNotFound
 		}
 		return fmt.Errorf("failed to delete metadata: %w", err)
 	}
@@ -128,28 +209,31 @@ func (r *FileRepository) List(ctx context.Context, bucket, prefix string, opts L
 
 	// Read all metadata files in the bucket
 	var allObjects []*Object
-	err := filepath.Walk(bucketDir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed by a concurrent delete
+			}
 			return err
 		}
 
-		if info.IsDir() || !strings.HasSuffix(path, ".meta") {
+		if d.IsDir() || !strings.HasSuffix(path, ".meta") {
 			return nil
 		}
 
 		// Read metadata
 		metaData, err := os.ReadFile(path)
 		if err != nil {
-			return nil // Skip files we can't read
+			return nil // Skip files we can't read (e.g. removed concurrently)
 		}
 
 		var obj Object
-		if err := json.Unmarshal(metaData, &obj); err != nil {
-			return nil // Skip invalid metadata
+		if err := r.decodeMeta(path, metaData, &obj); err != nil {
+			return nil // Quarantined, or written by a newer version
 		}
 
-		// Apply prefix filter
-		if prefix != "" && !strings.HasPrefix(obj.Key, prefix) {
+		// Apply prefix filter; deleted keys are not listed
+		if obj.DeleteMarker || (prefix != "" && !strings.HasPrefix(obj.Key, prefix)) {
 			return nil
 		}
 
@@ -196,37 +280,7 @@ func (r *FileRepository) List(ctx context.Context, bucket, prefix string, opts L
 		nextMarker = allObjects[len(allObjects)-1].Key
 	}
 
-	// Handle delimiter (common prefixes)
-	var commonPrefixes []string
-	if opts.Delimiter != "" {
-		prefixMap := make(map[string]bool)
-		var filteredObjects []*Object
-
-		for _, obj := range allObjects {
-			// Get the part after the prefix
-			remainder := obj.Key
-			if prefix != "" {
-				remainder = strings.TrimPrefix(obj.Key, prefix)
-			}
-
-			// Check if there's a delimiter in the remainder
-			idx := strings.Index(remainder, opts.Delimiter)
-			if idx >= 0 {
-				// This is a "directory", add to common prefixes
-				commonPrefix := prefix + remainder[:idx+len(opts.Delimiter)]
-				prefixMap[commonPrefix] = true
-			} else {
-				// This is a direct object
-				filteredObjects = append(filteredObjects, obj)
-			}
-		}
-
-		allObjects = filteredObjects
-		for p := range prefixMap {
-			commonPrefixes = append(commonPrefixes, p)
-		}
-		sort.Strings(commonPrefixes)
-	}
+	allObjects, commonPrefixes := groupCommonPrefixes(allObjects, prefix, opts.Delimiter)
 
 	return &ListResult{
 		Objects:        allObjects,
@@ -244,14 +298,14 @@ func (r *FileRepository) Head(ctx context.Context, bucket, key string, versionID
 	metaData, err := os.ReadFile(metaPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, errors.New("object not found")
+			return nil, ErrObjectNotFound
 		}
 		return nil, fmt.Errorf("failed to read metadata: %w", err)
 	}
 
 	// Unmarshal metadata
 	var obj Object
-	if err := json.Unmarshal(metaData, &obj); err != nil {
+	if err := r.decodeMeta(metaPath, metaData, &obj); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
 	}
 
@@ -280,8 +334,6 @@ func (r *FileRepository) Count(ctx context.Context, bucket string) (int, int64,
 			return nil
 		}
 
-		count++
-
 		// Read metadata to get size
 		metaData, err := os.ReadFile(path)
 		if err != nil {
@@ -289,11 +341,15 @@ func (r *FileRepository) Count(ctx context.Context, bucket string) (int, int64,
 		}
 
 		var obj Object
-		if err := json.Unmarshal(metaData, &obj); err != nil {
-			return nil // Skip invalid metadata
+		if err := r.decodeMeta(path, metaData, &obj); err != nil {
+			return nil // Quarantined, or written by a newer version
 		}
 
-		totalSize += obj.Size
+		// Count what List returns
+		if !obj.DeleteMarker {
+			count++
+			totalSize += obj.Size
+		}
 		return nil
 	})
 
@@ -317,42 +373,288 @@ func (r *FileRepository) DeleteAll(ctx context.Context, bucket string) (int, int
 	var totalSize int64
 	var objects []*Object
 
-	// Read directory entries (faster than Walk)
-	entries, err := os.ReadDir(bucketDir)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read bucket directory: %w", err)
-	}
-
-	// Collect all objects first
-	for _, entry := range entries {
-		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".meta") {
-			continue
+	// Collect all objects first, including those of nested keys
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".meta") {
+			return nil
 		}
-
-		metaPath := filepath.Join(bucketDir, entry.Name())
 
 		// Read metadata to get offset and size
-		metaData, err := os.ReadFile(metaPath)
+		metaData, err := os.ReadFile(path)
 		if err != nil {
-			continue // Skip files we can't read
+			return nil // Skip files we can't read
 		}
 
 		var obj Object
-		if err := json.Unmarshal(metaData, &obj); err != nil {
-			continue // Skip invalid metadata
+		if err := r.decodeMeta(path, metaData, &obj); err != nil {
+			return nil // Quarantined, or written by a newer version
 		}
 
 		objects = append(objects, &obj)
-		totalSize += obj.Size
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read bucket directory: %w", err)
 	}
 
-	// Now delete all metadata files
+	// Now delete all metadata files, syncing each directory once
+	dirs := make(map[string]bool)
 	for _, obj := range objects {
 		metaPath := r.getObjectMetaPath(bucket, obj.Key)
-		if err := os.Remove(metaPath); err == nil {
+		if err := r.writer.FS.Remove(metaPath); err == nil {
 			count++
+			totalSize += obj.Size
+			dirs[filepath.Dir(metaPath)] = true
+		}
+	}
+	if r.writer.Durability == metafile.DurabilityFull {
+		for dir := range dirs {
+			if err := r.writer.SyncDir(dir); err != nil {
+				return count, totalSize, fmt.Errorf("failed to sync metadata directory: %w", err)
+			}
 		}
 	}
 
 	return count, totalSize, nil
 }
+
+func (r *FileRepository) UpdateMetadata(ctx context.Context, obj *Object, versionID string) error {
+	metaPath := r.getObjectMetaPath(obj.BucketName, obj.Key)
+
+	tempPath, err := r.writeMetaTemp(metaPath, obj)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrObjectNotFound // The key's directory is gone
+		}
+		return err
+	}
+
+	unlock := r.lockKey(metaPath)
+	defer unlock()
+
+	current, err := r.Head(ctx, obj.BucketName, obj.Key, nil)
+	if err == nil && current.VersionID != versionID {
+		err = ErrObjectChanged
+	}
+	if err != nil {
+		r.writer.FS.Remove(tempPath)
+		return err
+	}
+	return r.writer.Commit(tempPath, metaPath)
+}
+
+// Move replaces or removes src's file before committing dst's. If dst
+// cannot be committed src is put back; a crash in between loses the
+// object's metadata but never leaves two keys sharing its data.
+func (r *FileRepository) Move(ctx context.Context, src, dst, marker *Object) error {
+	srcPath := r.getObjectMetaPath(src.BucketName, src.Key)
+	dstPath := r.getObjectMetaPath(dst.BucketName, dst.Key)
+
+	if err := r.writer.MkdirAll(filepath.Dir(dstPath)); err != nil {
+		return fmt.Errorf("failed to create bucket directory: %w", err)
+	}
+	dstTemp, err := r.writeMetaTemp(dstPath, dst)
+	if err != nil {
+		return err
+	}
+
+	defer r.lockKeys(srcPath, dstPath)()
+
+	current, err := r.Head(ctx, src.BucketName, src.Key, nil)
+	if err == nil && current.VersionID != src.VersionID {
+		err = ErrObjectChanged
+	}
+	if err == nil {
+		if marker != nil {
+			err = r.commitMeta(srcPath, marker)
+		} else if err = r.writer.Remove(srcPath); os.IsNotExist(err) {
+			err = ErrObjectNotFound
+		}
+	}
+	if err != nil {
+		r.writer.FS.Remove(dstTemp)
+		return err
+	}
+
+	if err := r.writer.Commit(dstTemp, dstPath); err != nil {
+		if restoreErr := r.commitMeta(srcPath, current); restoreErr != nil {
+			return fmt.Errorf("%w (restoring %s/%s: %v)", err, src.BucketName, src.Key, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// commitMeta atomically replaces the metadata file at metaPath with obj
+func (r *FileRepository) commitMeta(metaPath string, obj *Object) error {
+	tempPath, err := r.writeMetaTemp(metaPath, obj)
+	if err != nil {
+		return err
+	}
+	return r.writer.Commit(tempPath, metaPath)
+}
+
+// lockKeys locks the stripes of two metadata files in stripe order, so
+// moves in opposite directions cannot deadlock
+func (r *FileRepository) lockKeys(a, b string) func() {
+	i, j := keyStripe(a), keyStripe(b)
+	if i > j {
+		i, j = j, i
+	}
+	r.keyLocks[i].Lock()
+	if i == j {
+		return r.keyLocks[i].Unlock
+	}
+	r.keyLocks[j].Lock()
+	return func() {
+		r.keyLocks[j].Unlock()
+		r.keyLocks[i].Unlock()
+	}
+}
+
+func (r *FileRepository) Iterate(ctx context.Context, bucket, prefix string, fn IterateFunc) error {
+	bucketDir := r.getBucketDir(bucket)
+
+	if _, err := os.Stat(bucketDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Decode one metadata file at a time; nothing is accumulated
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if d.IsDir() || !strings.HasSuffix(path, ".meta") {
+			return nil
+		}
+
+		metaData, err := os.ReadFile(path)
+		if err != nil {
+			return nil // Skip files we can't read (e.g. removed concurrently)
+		}
+
+		var obj Object
+		if err := r.decodeMeta(path, metaData, &obj); err != nil {
+			return nil // Quarantined, or written by a newer version
+		}
+
+		if !strings.HasPrefix(obj.Key, prefix) {
+			return nil
+		}
+
+		return fn(&obj)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to iterate objects: %w", err)
+	}
+
+	return nil
+}
+
+// Walk reads the bucket's metadata files once, keeping only the keys and
+// paths of those to visit, then reads each again in key order as it is
+// visited. Files removed or deleted since are skipped.
+func (r *FileRepository) Walk(ctx context.Context, bucket, prefix, startAfter string, fn IterateFunc) error {
+	type entry struct{ key, path string }
+	var entries []entry
+	err := r.Iterate(ctx, bucket, prefix, func(obj *Object) error {
+		if !obj.DeleteMarker && obj.Key > startAfter {
+			entries = append(entries, entry{obj.Key, r.getObjectMetaPath(bucket, obj.Key)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		metaData, err := os.ReadFile(e.path)
+		if err != nil {
+			continue
+		}
+		var obj Object
+		if err := r.decodeMeta(e.path, metaData, &obj); err != nil || obj.DeleteMarker {
+			continue
+		}
+		if err := fn(&obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpgradeMetadata rewrites the metadata files written with an older schema
+// version in the current one. With dryRun set, files are only counted.
+func (r *FileRepository) UpgradeMetadata(ctx context.Context, dryRun bool) (metafile.UpgradeResult, error) {
+	var result metafile.UpgradeResult
+	err := filepath.WalkDir(filepath.Join(r.metadataDir, "objects"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".meta") {
+			return nil
+		}
+
+		// Locked so a concurrent write is not overwritten with older data
+		unlock := r.lockKey(path)
+		defer unlock()
+
+		metaData, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to read metadata: %w", err)
+		}
+		result.Scanned++
+
+		var obj Object
+		upgraded, err := metaFormat.Unmarshal(metaData, &obj)
+		if err != nil {
+			result.Invalid = append(result.Invalid, path)
+			if errors.Is(err, metafile.ErrCorrupt) && !dryRun {
+				return r.quarantine.Add(path, metaData, err)
+			}
+			return nil
+		}
+		if !upgraded {
+			return nil
+		}
+		result.Upgraded++
+		if dryRun {
+			return nil
+		}
+
+		tempPath, err := r.writeMetaTemp(path, &obj)
+		if err != nil {
+			return err
+		}
+		return r.writer.Commit(tempPath, path)
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to upgrade object metadata: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,221 @@
+package object
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/danielino/comio/pkg/pathutil"
+)
+
+// DefaultHistoryLimit is the default number of events kept per object
+const DefaultHistoryLimit = 100
+
+// ErrHistoryDisabled is returned when object history is not enabled
+var ErrHistoryDisabled = errors.New("object history is disabled")
+
+// HistoryOp identifies an operation recorded in an object's history
+type HistoryOp string
+
+const (
+	HistoryPut               HistoryOp = "put"
+	HistoryOverwrite         HistoryOp = "overwrite"
+	HistoryDelete            HistoryOp = "delete"
+	HistoryRestore           HistoryOp = "restore"
+	HistoryMove              HistoryOp = "move" // Recorded on the source key
+	HistoryMetadata          HistoryOp = "metadata"
+	HistoryReplicated        HistoryOp = "replicated"
+	HistoryReplicationFailed HistoryOp = "replication_failed"
+)
+
+// HistoryEvent is one entry in an object's operation history
+type HistoryEvent struct {
+	Op        HistoryOp `json:"op"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	VersionID string    `json:"version_id,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	ETag      string    `json:"etag,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Source    string    `json:"source,omitempty"` // Client address
+	Detail    string    `json:"detail,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HistoryStore keeps a bounded, per-object operation history
+type HistoryStore interface {
+	// Append records an event, dropping the oldest events beyond the limit
+	Append(ctx context.Context, event HistoryEvent) error
+	// List returns an object's events, oldest first
+	List(ctx context.Context, bucket, key string) ([]HistoryEvent, error)
+}
+
+type actorKey struct{}
+
+type actor struct {
+	id     string
+	source string
+}
+
+// WithActor returns a context that attributes object operations to the
+// given identity and client address in the object history
+func WithActor(ctx context.Context, id, source string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor{id: id, source: source})
+}
+
+// actorFromContext returns the identity set by WithActor, if any
+func actorFromContext(ctx context.Context) actor {
+	if ctx == nil {
+		return actor{}
+	}
+	a, _ := ctx.Value(actorKey{}).(actor)
+	return a
+}
+
+// appendBounded appends event and trims events to the newest limit entries
+func appendBounded(events []HistoryEvent, event HistoryEvent, limit int) []HistoryEvent {
+	events = append(events, event)
+	if limit > 0 && len(events) > limit {
+		events = append([]HistoryEvent(nil), events[len(events)-limit:]...)
+	}
+	return events
+}
+
+// MemoryHistoryStore implements HistoryStore in memory
+type MemoryHistoryStore struct {
+	limit  int
+	events map[string][]HistoryEvent // Key: bucket/key
+	mu     sync.RWMutex
+}
+
+// NewMemoryHistoryStore creates a memory history store keeping limit events per object
+func NewMemoryHistoryStore(limit int) *MemoryHistoryStore {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	return &MemoryHistoryStore{
+		limit:  limit,
+		events: make(map[string][]HistoryEvent),
+	}
+}
+
+func (s *MemoryHistoryStore) Append(ctx context.Context, event HistoryEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := event.Bucket + "/" + event.Key
+	s.events[key] = appendBounded(s.events[key], event, s.limit)
+	return nil
+}
+
+func (s *MemoryHistoryStore) List(ctx context.Context, bucket, key string) ([]HistoryEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := s.events[bucket+"/"+key]
+	return append([]HistoryEvent{}, events...), nil
+}
+
+// FileHistoryStore implements HistoryStore with one JSON file per object
+// under <metadataDir>/history/<bucket>. History outlives the object itself
+// so deletions remain queryable.
+type FileHistoryStore struct {
+	dir   string
+	limit int
+	mu    sync.Mutex // Serializes read-modify-write of history files
+}
+
+// NewFileHistoryStore creates a file-based history store keeping limit events per object
+func NewFileHistoryStore(metadataDir string, limit int) (*FileHistoryStore, error) {
+	dir := filepath.Join(metadataDir, "history")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	return &FileHistoryStore{
+		dir:   dir,
+		limit: limit,
+	}, nil
+}
+
+// path returns the history file of an object. Sanitizing may map distinct
+// keys onto one file, so events carry their key and are filtered on read.
+func (s *FileHistoryStore) path(bucket, key string) string {
+	return filepath.Join(s.dir, pathutil.SanitizePath(bucket), pathutil.SanitizePath(key)+".json")
+}
+
+func (s *FileHistoryStore) read(path string) ([]HistoryEvent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	var events []HistoryEvent
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal history: %w", err)
+	}
+	return events, nil
+}
+
+func (s *FileHistoryStore) Append(ctx context.Context, event HistoryEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(event.Bucket, event.Key)
+	events, err := s.read(path)
+	if err != nil {
+		return err
+	}
+	events = appendBounded(events, event, s.limit)
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	// Write atomically (write to temp, then rename)
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename history file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileHistoryStore) List(ctx context.Context, bucket, key string) ([]HistoryEvent, error) {
+	s.mu.Lock()
+	events, err := s.read(s.path(bucket, key))
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]HistoryEvent, 0, len(events))
+	for _, event := range events {
+		if event.Bucket == bucket && event.Key == key {
+			matched = append(matched, event)
+		}
+	}
+	return matched, nil
+}
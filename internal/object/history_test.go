@@ -0,0 +1,89 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestFileHistoryStore_Bounded(t *testing.T) {
+	store, err := NewFileHistoryStore(t.TempDir(), 3)
+	if err != nil {
+		t.Fatalf("NewFileHistoryStore() error = %v", err)
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		event := HistoryEvent{Op: HistoryPut, Bucket: "bucket", Key: "dir/key", VersionID: fmt.Sprintf("v%d", i)}
+		if err := store.Append(ctx, event); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// "dir_key" sanitizes to the same file but must not show up
+	if err := store.Append(ctx, HistoryEvent{Op: HistoryPut, Bucket: "bucket", Key: "dir_key"}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	events, err := store.List(ctx, "bucket", "dir/key")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("len(events) = %d, want 2", len(events))
+	}
+	if events[0].VersionID != "v3" || events[1].VersionID != "v4" {
+		t.Errorf("events = %s, %s; want oldest-first v3, v4", events[0].VersionID, events[1].VersionID)
+	}
+
+	events, err = store.List(ctx, "bucket", "missing")
+	if err != nil || len(events) != 0 {
+		t.Errorf("List() missing key = %v, %v; want empty", events, err)
+	}
+}
+
+func TestObjectService_History(t *testing.T) {
+	engine := createTestEngine(t)
+	service := NewService(NewMemoryRepository(), engine)
+	service.SetHistory(NewMemoryHistoryStore(0))
+
+	ctx := WithActor(context.Background(), "AKIDEXAMPLE", "10.0.0.1")
+	content := []byte("history")
+
+	if _, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if _, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if err := service.DeleteObject(ctx, "bucket", "key"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+
+	events, err := service.GetObjectHistory(context.Background(), "bucket", "key")
+	if err != nil {
+		t.Fatalf("GetObjectHistory() error = %v", err)
+	}
+
+	want := []HistoryOp{HistoryPut, HistoryOverwrite, HistoryDelete}
+	if len(events) != len(want) {
+		t.Fatalf("len(events) = %d, want %d", len(events), len(want))
+	}
+	for i, op := range want {
+		if events[i].Op != op {
+			t.Errorf("events[%d].Op = %s, want %s", i, events[i].Op, op)
+		}
+		if events[i].Actor != "AKIDEXAMPLE" || events[i].Source != "10.0.0.1" {
+			t.Errorf("events[%d] actor = %s@%s", i, events[i].Actor, events[i].Source)
+		}
+	}
+}
+
+func TestObjectService_HistoryDisabled(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+
+	if _, err := service.GetObjectHistory(context.Background(), "bucket", "key"); err != ErrHistoryDisabled {
+		t.Errorf("GetObjectHistory() error = %v, want %v", err, ErrHistoryDisabled)
+	}
+}
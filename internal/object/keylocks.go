@@ -0,0 +1,43 @@
+package object
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// writeLockStripes is the number of stripes serializing writes per key
+const writeLockStripes = 64
+
+// writeLocks serializes writes to the same key, for repositories that read
+// a key's latest version around each write to keep a derived record of it
+type writeLocks [writeLockStripes]sync.Mutex
+
+func (l *writeLocks) lock(bucket, key string) func() {
+	mu := &l[writeStripe(bucket, key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockPair locks the stripes of two keys in stripe order, so moves in
+// opposite directions cannot deadlock
+func (l *writeLocks) lockPair(a, b *Object) func() {
+	i, j := writeStripe(a.BucketName, a.Key), writeStripe(b.BucketName, b.Key)
+	if i > j {
+		i, j = j, i
+	}
+	l[i].Lock()
+	if i == j {
+		return l[i].Unlock
+	}
+	l[j].Lock()
+	return func() {
+		l[j].Unlock()
+		l[i].Unlock()
+	}
+}
+
+func writeStripe(bucket, key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(bucket + "/" + key))
+	return h.Sum32() % writeLockStripes
+}
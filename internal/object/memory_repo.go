@@ -2,7 +2,6 @@ package object
 
 import (
 	"context"
-	"errors"
 	"io"
 	"sort"
 	"strings"
@@ -38,7 +37,11 @@ func (r *MemoryRepository) Get(ctx context.Context, bucket, key string, versionI
 	objKey := bucket + "/" + key
 	obj, exists := r.objects[objKey]
 	if !exists {
-		return nil, nil, errors.New("object not found")
+		return nil, nil, ErrObjectNotFound
+	}
+	// Only the latest version is kept
+	if versionID != nil && *versionID != "" && *versionID != obj.VersionID {
+		return nil, nil, ErrVersionNotFound
 	}
 
 	return obj, nil, nil
@@ -49,6 +52,14 @@ func (r *MemoryRepository) Delete(ctx context.Context, bucket, key string, versi
 	defer r.mu.Unlock()
 
 	objKey := bucket + "/" + key
+	obj, exists := r.objects[objKey]
+	if !exists {
+		return ErrObjectNotFound
+	}
+	// Only the latest version is kept
+	if versionID != nil && *versionID != "" && *versionID != obj.VersionID {
+		return ErrVersionNotFound
+	}
 	delete(r.objects, objKey)
 	return nil
 }
@@ -60,12 +71,12 @@ func (r *MemoryRepository) List(ctx context.Context, bucket, prefix string, opts
 	// Collect matching objects
 	var allObjects []*Object
 	for _, obj := range r.objects {
-		if obj.BucketName != bucket {
+		if obj.BucketName != bucket || obj.DeleteMarker {
 			continue
 		}
 
 		// Filter by prefix
-		if opts.Prefix != "" && !strings.HasPrefix(obj.Key, opts.Prefix) {
+		if !strings.HasPrefix(obj.Key, prefix) {
 			continue
 		}
 
@@ -103,10 +114,12 @@ func (r *MemoryRepository) List(ctx context.Context, bucket, prefix string, opts
 		objects = allObjects
 	}
 
+	objects, commonPrefixes := groupCommonPrefixes(objects, prefix, opts.Delimiter)
 	return &ListResult{
-		Objects:     objects,
-		IsTruncated: isTruncated,
-		NextMarker:  nextMarker,
+		Objects:        objects,
+		CommonPrefixes: commonPrefixes,
+		IsTruncated:    isTruncated,
+		NextMarker:     nextMarker,
 	}, nil
 }
 
@@ -117,7 +130,7 @@ func (r *MemoryRepository) Head(ctx context.Context, bucket, key string, version
 	objKey := bucket + "/" + key
 	obj, exists := r.objects[objKey]
 	if !exists {
-		return nil, errors.New("object not found")
+		return nil, ErrObjectNotFound
 	}
 
 	return obj, nil
@@ -131,7 +144,7 @@ func (r *MemoryRepository) Count(ctx context.Context, bucket string) (int, int64
 	var totalSize int64
 
 	for _, obj := range r.objects {
-		if obj.BucketName == bucket {
+		if obj.BucketName == bucket && !obj.DeleteMarker {
 			count++
 			totalSize += obj.Size
 		}
@@ -164,3 +177,89 @@ func (r *MemoryRepository) DeleteAll(ctx context.Context, bucket string) (int, i
 
 	return count, totalSize, nil
 }
+
+func (r *MemoryRepository) UpdateMetadata(ctx context.Context, obj *Object, versionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	objKey := obj.BucketName + "/" + obj.Key
+	current, exists := r.objects[objKey]
+	if !exists {
+		return ErrObjectNotFound
+	}
+	if current.VersionID != versionID {
+		return ErrObjectChanged
+	}
+	r.objects[objKey] = obj
+	return nil
+}
+
+func (r *MemoryRepository) Move(ctx context.Context, src, dst, marker *Object) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	srcKey := src.BucketName + "/" + src.Key
+	current, exists := r.objects[srcKey]
+	if !exists {
+		return ErrObjectNotFound
+	}
+	if current.VersionID != src.VersionID {
+		return ErrObjectChanged
+	}
+	if marker != nil {
+		r.objects[srcKey] = marker
+	} else {
+		delete(r.objects, srcKey)
+	}
+	r.objects[dst.BucketName+"/"+dst.Key] = dst
+	return nil
+}
+
+func (r *MemoryRepository) Walk(ctx context.Context, bucket, prefix, startAfter string, fn IterateFunc) error {
+	// Snapshot matching objects so fn may modify the repository
+	r.mu.RLock()
+	var matched []*Object
+	for _, obj := range r.objects {
+		if obj.BucketName == bucket && !obj.DeleteMarker && strings.HasPrefix(obj.Key, prefix) && obj.Key > startAfter {
+			matched = append(matched, obj)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Key < matched[j].Key
+	})
+	for _, obj := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) Iterate(ctx context.Context, bucket, prefix string, fn IterateFunc) error {
+	// Snapshot matching objects so fn may modify the repository
+	r.mu.RLock()
+	var matched []*Object
+	for _, obj := range r.objects {
+		if obj.BucketName == bucket && strings.HasPrefix(obj.Key, prefix) {
+			matched = append(matched, obj)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, obj := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
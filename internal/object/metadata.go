@@ -0,0 +1,99 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/danielino/comio/internal/notification"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// ErrPreconditionFailed is returned when a conditional request's object
+// does not match the condition
+var ErrPreconditionFailed = errors.New("object does not match the precondition")
+
+// MetadataUpdate changes an object's metadata without rewriting its data
+type MetadataUpdate struct {
+	// IfMatch is the ETag the object must have, or "*" for any
+	IfMatch string
+	// VersionID, if set, is the version the object must still be at. Every
+	// update creates a new version, so passing the version that was read
+	// makes concurrent updates fail rather than overwrite each other.
+	VersionID string
+
+	ContentType string // Replaces the content type when set
+	// Metadata entries are set, or removed when their value is empty;
+	// entries not listed are kept
+	Metadata map[string]string
+}
+
+// UpdateObjectMetadata applies update to the latest version of an object
+// as a compare-and-swap, returning the new version. It returns
+// ErrPreconditionFailed if the object does not match update's conditions,
+// including when it is written between the check and the update.
+func (s *Service) UpdateObjectMetadata(ctx context.Context, bucket, key string, update MetadataUpdate) (*Object, error) {
+	current, err := s.getObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if update.IfMatch != "*" && update.IfMatch != current.ETag {
+		return nil, ErrPreconditionFailed
+	}
+	if update.VersionID != "" && update.VersionID != current.VersionID {
+		return nil, ErrPreconditionFailed
+	}
+
+	// Repositories may hand out shared objects, so update a copy
+	updated := *current
+	updated.VersionID = GenerateVersionID()
+	updated.ModifiedAt = time.Now()
+	if update.ContentType != "" {
+		updated.ContentType = update.ContentType
+	}
+	updated.Metadata = maps.Clone(current.Metadata)
+	for name, value := range update.Metadata {
+		if value == "" {
+			delete(updated.Metadata, name)
+			continue
+		}
+		if updated.Metadata == nil {
+			updated.Metadata = make(map[string]string)
+		}
+		updated.Metadata[name] = value
+	}
+
+	if err := s.repo.UpdateMetadata(ctx, &updated, current.VersionID); err != nil {
+		if errors.Is(err, ErrObjectChanged) {
+			return nil, fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+		}
+		return nil, err
+	}
+
+	s.recordHistory(ctx, HistoryMetadata, &updated, "")
+	s.publish(notification.EventObjectCreated, &updated)
+
+	// Replicas get the object again; the data is unchanged, so unless it
+	// is encrypted it goes as an empty delta against itself
+	if s.replicates(ctx, bucket) {
+		event := replication.Event{
+			Type:      replication.EventPutObject,
+			Bucket:    bucket,
+			Key:       key,
+			Timestamp: updated.ModifiedAt,
+			Metadata: map[string]interface{}{
+				"content_type": updated.ContentType,
+				"size":         updated.Size,
+			},
+			StoragePointer: &replication.StoragePointer{Offset: updated.Offset, Size: updated.Size},
+		}
+		if updated.Encryption == nil {
+			event.Base = &replication.BaseVersion{ETag: updated.ETag, Pointer: *event.StoragePointer}
+		}
+		s.replicator.QueueEvent(event)
+	}
+
+	return &updated, nil
+}
@@ -0,0 +1,128 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestService_UpdateObjectMetadata(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			service := NewService(repo, createTestEngine(t))
+			data := []byte("unchanged data")
+			put, err := service.PutObjectWithMetadata(ctx, "iter-bucket", "doc", bytes.NewReader(data), int64(len(data)), "text/plain",
+				map[string]string{"x-amz-meta-color": "red", "x-amz-meta-owner": "ann"})
+			if err != nil {
+				t.Fatalf("PutObjectWithMetadata() error = %v", err)
+			}
+
+			if _, err := service.UpdateObjectMetadata(ctx, "iter-bucket", "doc", MetadataUpdate{IfMatch: "0123"}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("UpdateObjectMetadata(wrong ETag) error = %v, want ErrPreconditionFailed", err)
+			}
+
+			updated, err := service.UpdateObjectMetadata(ctx, "iter-bucket", "doc", MetadataUpdate{
+				IfMatch:     put.ETag,
+				VersionID:   put.VersionID,
+				ContentType: "text/markdown",
+				Metadata:    map[string]string{"x-amz-meta-color": "blue", "x-amz-meta-owner": ""},
+			})
+			if err != nil {
+				t.Fatalf("UpdateObjectMetadata() error = %v", err)
+			}
+			if updated.VersionID == put.VersionID || updated.ETag != put.ETag {
+				t.Errorf("update = version %s ETag %s, want a new version of ETag %s", updated.VersionID, updated.ETag, put.ETag)
+			}
+
+			// The data is untouched and the metadata merged
+			obj, body, err := service.GetObject(ctx, "iter-bucket", "doc", nil)
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			got, _ := io.ReadAll(body)
+			body.Close()
+			if !bytes.Equal(got, data) {
+				t.Errorf("data = %q, want %q", got, data)
+			}
+			if obj.ContentType != "text/markdown" || obj.VersionID != updated.VersionID {
+				t.Errorf("object = %s at %s, want text/markdown at %s", obj.ContentType, obj.VersionID, updated.VersionID)
+			}
+			if len(obj.Metadata) != 1 || obj.Metadata["x-amz-meta-color"] != "blue" {
+				t.Errorf("Metadata = %v, want only color=blue", obj.Metadata)
+			}
+
+			// The version read before the update is stale now
+			if _, err := service.UpdateObjectMetadata(ctx, "iter-bucket", "doc", MetadataUpdate{IfMatch: "*", VersionID: put.VersionID}); !errors.Is(err, ErrPreconditionFailed) {
+				t.Errorf("UpdateObjectMetadata(stale version) error = %v, want ErrPreconditionFailed", err)
+			}
+			if _, err := service.UpdateObjectMetadata(ctx, "iter-bucket", "missing", MetadataUpdate{IfMatch: "*"}); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("UpdateObjectMetadata(missing) error = %v, want ErrObjectNotFound", err)
+			}
+		})
+	}
+}
+
+func TestRepository_UpdateMetadataCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := putTestObject(ctx, repo, "cas", 10); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			base, err := repo.Head(ctx, "iter-bucket", "cas", nil)
+			if err != nil {
+				t.Fatalf("Head() error = %v", err)
+			}
+
+			// Updates racing from the same version: exactly one wins
+			const racers = 8
+			var wg sync.WaitGroup
+			results := make(chan error, racers)
+			for i := 0; i < racers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					updated := *base
+					updated.VersionID = GenerateVersionID()
+					updated.Metadata = map[string]string{"x-amz-meta-winner": updated.VersionID}
+					results <- repo.UpdateMetadata(ctx, &updated, base.VersionID)
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			won := 0
+			for err := range results {
+				switch {
+				case err == nil:
+					won++
+				case !errors.Is(err, ErrObjectChanged):
+					t.Errorf("UpdateMetadata() error = %v, want ErrObjectChanged for losers", err)
+				}
+			}
+			if won != 1 {
+				t.Errorf("%d updates won, want 1", won)
+			}
+
+			current, err := repo.Head(ctx, "iter-bucket", "cas", nil)
+			if err != nil {
+				t.Fatalf("Head() error = %v", err)
+			}
+			if current.Metadata["x-amz-meta-winner"] != current.VersionID || current.Size != base.Size {
+				t.Errorf("stored %+v, want the winner's metadata on the same data", current)
+			}
+
+			missing := *base
+			missing.Key = "gone"
+			if err := repo.UpdateMetadata(ctx, &missing, base.VersionID); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("UpdateMetadata(missing) error = %v, want ErrObjectNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danielino/comio/internal/notification"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// ErrMoveToSelf is returned when an object is moved onto its own key
+var ErrMoveToSelf = errors.New("cannot move an object onto itself")
+
+// MoveObject moves the latest version of an object to another bucket or
+// key without copying its data: the version is retargeted in one
+// repository operation and gets a new version ID at the destination. The
+// source key is removed, or hidden under a delete marker in buckets that
+// use them. It returns ErrObjectChanged if the source is written while
+// the move is in progress.
+func (s *Service) MoveObject(ctx context.Context, bucket, key, dstBucket, dstKey string) (*Object, error) {
+	if bucket == dstBucket && key == dstKey {
+		return nil, ErrMoveToSelf
+	}
+
+	src, err := s.getObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.Size != 0 && strings.HasSuffix(dstKey, "/") {
+		return nil, fmt.Errorf("%w: %q", ErrDirectoryMarkerData, dstKey)
+	}
+
+	op := HistoryPut
+	if s.history != nil {
+		if _, err := s.getObject(ctx, dstBucket, dstKey, nil); err == nil {
+			op = HistoryOverwrite
+		}
+	}
+
+	// Repositories may hand out shared objects, so move a copy
+	now := time.Now()
+	dst := *src
+	dst.BucketName, dst.Key = dstBucket, dstKey
+	dst.VersionID = GenerateVersionID()
+	dst.CreatedAt, dst.ModifiedAt = now, now
+	dst.Owner = actorFromContext(ctx).id
+	dst.ReplicatedAt = nil
+
+	var marker *Object
+	if s.UsesDeleteMarkers(ctx, bucket) {
+		marker = &Object{
+			Key:          key,
+			BucketName:   bucket,
+			VersionID:    GenerateVersionID(),
+			CreatedAt:    now,
+			ModifiedAt:   now,
+			StorageClass: StorageClassStandard,
+			Owner:        dst.Owner,
+			DeleteMarker: true,
+		}
+	}
+
+	if err := s.repo.Move(ctx, src, &dst, marker); err != nil {
+		return nil, err
+	}
+
+	s.recordHistory(ctx, HistoryMove, src, "to "+dstBucket+"/"+dstKey)
+	s.recordHistory(ctx, op, &dst, "moved from "+bucket+"/"+key)
+	s.publish(notification.EventObjectRemoved, src)
+	s.publish(notification.EventObjectCreated, &dst)
+
+	// Replicas hold the data under the source key, but have no way to
+	// move it, so the destination is sent in full
+	if s.replicates(ctx, dstBucket) {
+		event := replication.Event{
+			Type:      replication.EventPutObject,
+			Bucket:    dstBucket,
+			Key:       dstKey,
+			Timestamp: now,
+			Metadata: map[string]interface{}{
+				"content_type": dst.ContentType,
+				"size":         dst.Size,
+			},
+			StoragePointer: &replication.StoragePointer{Offset: dst.Offset, Size: dst.Size},
+		}
+		if len(dst.Parts) > 1 {
+			event.Manifest = replicationManifest(&dst)
+		}
+		s.replicator.QueueEvent(event)
+	}
+	if s.replicates(ctx, bucket) {
+		event := replication.Event{
+			Type:      replication.EventDeleteObject,
+			Bucket:    bucket,
+			Key:       key,
+			Timestamp: now,
+		}
+		if marker != nil {
+			event.Metadata = map[string]interface{}{
+				replication.MetadataDeleteMarker: marker.VersionID,
+			}
+		}
+		s.replicator.QueueEvent(event)
+	}
+
+	return &dst, nil
+}
@@ -0,0 +1,124 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRepository_Move(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			put := func(bucket, key string) *Object {
+				t.Helper()
+				obj := &Object{BucketName: bucket, Key: key, VersionID: GenerateVersionID(), Size: 10, Offset: 4096, CreatedAt: time.Now(), ModifiedAt: time.Now()}
+				if err := repo.Put(ctx, obj, nil); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+				return obj
+			}
+			moved := func(src *Object, bucket, key string) *Object {
+				dst := *src
+				dst.BucketName, dst.Key, dst.VersionID = bucket, key, GenerateVersionID()
+				dst.CreatedAt, dst.ModifiedAt = time.Now(), time.Now()
+				return &dst
+			}
+
+			src := put("iter-bucket", "staging/a")
+			dst := moved(src, "other-bucket", "prod/a")
+			if err := repo.Move(ctx, src, dst, nil); err != nil {
+				t.Fatalf("Move() error = %v", err)
+			}
+			if _, err := repo.Head(ctx, "iter-bucket", "staging/a", nil); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("source after move: error = %v, want ErrObjectNotFound", err)
+			}
+			got, err := repo.Head(ctx, "other-bucket", "prod/a", nil)
+			if err != nil || got.VersionID != dst.VersionID || got.Offset != src.Offset {
+				t.Fatalf("destination = %+v, %v", got, err)
+			}
+
+			// The source must still be at the version read
+			stale := put("iter-bucket", "staging/b")
+			put("iter-bucket", "staging/b")
+			if err := repo.Move(ctx, stale, moved(stale, "iter-bucket", "prod/b"), nil); !errors.Is(err, ErrObjectChanged) {
+				t.Errorf("Move() of an overwritten object error = %v, want ErrObjectChanged", err)
+			}
+			if _, err := repo.Head(ctx, "iter-bucket", "prod/b", nil); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("failed move wrote the destination: %v", err)
+			}
+			if err := repo.Move(ctx, src, moved(src, "iter-bucket", "x"), nil); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Move() of a missing object error = %v, want ErrObjectNotFound", err)
+			}
+
+			// A marker hides the source instead
+			src = put("iter-bucket", "staging/c")
+			marker := &Object{BucketName: "iter-bucket", Key: "staging/c", VersionID: GenerateVersionID(), CreatedAt: time.Now(), DeleteMarker: true}
+			if err := repo.Move(ctx, src, moved(src, "iter-bucket", "prod/c"), marker); err != nil {
+				t.Fatalf("Move() with marker error = %v", err)
+			}
+			if got, err := repo.Head(ctx, "iter-bucket", "staging/c", nil); err != nil || !got.DeleteMarker {
+				t.Errorf("source after move = %+v, %v; want the delete marker", got, err)
+			}
+		})
+	}
+}
+
+func TestObjectService_MoveObject(t *testing.T) {
+	repo := NewPrefixStatsRepository(NewMemoryRepository(), NewMemoryPrefixStatsStore())
+	service := NewService(repo, createTestEngine(t))
+	service.SetPrefixStats(repo)
+	history := NewMemoryHistoryStore(10)
+	service.SetHistory(history)
+	ctx := context.Background()
+
+	data := []byte("release artifact")
+	src, err := service.PutObject(ctx, "staging", "site/build.tar", bytes.NewReader(data), int64(len(data)), "application/x-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PrefixStats(ctx, "production"); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := service.MoveObject(ctx, "staging", "site/build.tar", "production", "releases/build.tar")
+	if err != nil {
+		t.Fatalf("MoveObject() error = %v", err)
+	}
+	if obj.VersionID == src.VersionID || obj.Offset != src.Offset || obj.ETag != src.ETag {
+		t.Errorf("moved = %+v, want the same data under a new version", obj)
+	}
+
+	_, reader, err := service.GetObject(ctx, "production", "releases/build.tar", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("moved data = %q, want %q", got, data)
+	}
+	if _, err := service.HeadObject(ctx, "staging", "site/build.tar", nil); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("source after move: error = %v, want ErrObjectNotFound", err)
+	}
+
+	stats, _ := service.PrefixStats(ctx, "production")
+	if len(stats) != 1 || stats[0] != (PrefixStat{Prefix: "releases/", Objects: 1, Bytes: int64(len(data))}) {
+		t.Errorf("destination prefix stats = %+v", stats)
+	}
+	events, _ := service.GetObjectHistory(ctx, "staging", "site/build.tar")
+	if last := events[len(events)-1]; last.Op != HistoryMove || last.Detail != "to production/releases/build.tar" {
+		t.Errorf("source history = %+v", last)
+	}
+
+	if _, err := service.MoveObject(ctx, "production", "releases/build.tar", "production", "releases/build.tar"); !errors.Is(err, ErrMoveToSelf) {
+		t.Errorf("MoveObject() onto itself error = %v, want ErrMoveToSelf", err)
+	}
+	if _, err := service.MoveObject(ctx, "production", "releases/build.tar", "production", "releases/"); !errors.Is(err, ErrDirectoryMarkerData) {
+		t.Errorf("MoveObject() onto a directory marker error = %v, want ErrDirectoryMarkerData", err)
+	}
+}
@@ -1,24 +1,51 @@
 package object
 
 import (
+	"strings"
 	"time"
 
+	"github.com/danielino/comio/internal/encryption"
 	"github.com/danielino/comio/internal/integrity"
 )
 
+// StorageClassStandard is the storage class of every stored object
+const StorageClassStandard = "STANDARD"
+
 // Object represents a stored object
 type Object struct {
-	Key          string             `json:"key"`
-	BucketName   string             `json:"bucket_name"`
-	VersionID    string             `json:"version_id"`
-	Size         int64              `json:"size"`
-	ContentType  string             `json:"content_type"`
-	ETag         string             `json:"etag"`
-	Checksum     integrity.Checksum `json:"checksum"`
-	CreatedAt    time.Time          `json:"created_at"`
-	ModifiedAt   time.Time          `json:"modified_at"`
-	Metadata     map[string]string  `json:"metadata"`
-	StorageClass string             `json:"storage_class"`
-	DeleteMarker bool               `json:"delete_marker"`
-	Offset       int64              `json:"offset"` // Internal use
+	Key          string                    `json:"key"`
+	BucketName   string                    `json:"bucket_name"`
+	VersionID    string                    `json:"version_id"`
+	Size         int64                     `json:"size"`
+	ContentType  string                    `json:"content_type"`
+	ETag         string                    `json:"etag"`
+	Checksum     integrity.Checksum        `json:"checksum"`
+	CreatedAt    time.Time                 `json:"created_at"`
+	ModifiedAt   time.Time                 `json:"modified_at"`
+	Metadata     map[string]string         `json:"metadata"`
+	StorageClass string                    `json:"storage_class"`
+	Owner        string                    `json:"owner,omitempty"` // Access key of the uploader
+	DeleteMarker bool                      `json:"delete_marker"`
+	ReplicatedAt *time.Time                `json:"replicated_at,omitempty"` // Set on delete markers once replicated
+	Offset       int64                     `json:"offset"`                  // Internal use
+	Parts        []PartInfo                `json:"parts,omitempty"`         // Set for objects assembled from a multipart upload
+	Encryption   *encryption.Envelope      `json:"encryption,omitempty"`    // Set for objects stored encrypted
+	Chunks       *integrity.ChunkChecksums `json:"chunks,omitempty"`        // Set for objects of at least the chunk threshold
+	Sealed       bool                      `json:"sealed,omitempty"`        // The data is followed by a seal record on the device
+	Shared       bool                      `json:"shared,omitempty"`        // The data may be shared with objects of bucket clones or other versions
+	Extents      []Extent                  `json:"extents,omitempty"`       // Set for versions sharing data with the version they were written on
+}
+
+// IsDirectoryMarker reports whether the object is a directory marker: an
+// empty object whose key ends in "/". Markers let empty directories exist
+// and are left out of prefix statistics.
+func (o *Object) IsDirectoryMarker() bool {
+	return o.Size == 0 && strings.HasSuffix(o.Key, "/")
+}
+
+// PartInfo describes one part of an object assembled from a multipart upload
+type PartInfo struct {
+	PartNumber int    `json:"part_number"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
 }
@@ -0,0 +1,51 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/replication"
+)
+
+var (
+	// ErrPatchBaseMismatch is returned when the current object is not the
+	// version a replication patch was computed against
+	ErrPatchBaseMismatch = errors.New("object does not match patch base")
+	// ErrPatchChecksum is returned when a patched object does not match the
+	// ETag of the source
+	ErrPatchChecksum = errors.New("patched object checksum mismatch")
+)
+
+// ApplyReplicationPatch rebuilds an object from a delta against its current
+// version, as sent by a replication source for mostly unchanged overwrites
+func (s *Service) ApplyReplicationPatch(ctx context.Context, patch *replication.Patch) (*Object, error) {
+	current, _, err := s.repo.Get(ctx, patch.Bucket, patch.Key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPatchBaseMismatch, err)
+	}
+	if current.ETag != patch.BaseETag {
+		return nil, fmt.Errorf("%w: have %s, patch expects %s", ErrPatchBaseMismatch, current.ETag, patch.BaseETag)
+	}
+
+	base, err := s.readRange(ctx, current, 0, current.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch base: %w", err)
+	}
+
+	data, err := replication.ApplyDelta(base, patch.Ops, patch.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	if !integrity.MatchesETag(data, patch.ETag) {
+		sum := md5.Sum(data)
+		return nil, fmt.Errorf("%w: got %s, want %s", ErrPatchChecksum, hex.EncodeToString(sum[:]), patch.ETag)
+	}
+
+	return s.PutObject(ctx, patch.Bucket, patch.Key, bytes.NewReader(data), patch.Size, patch.ContentType)
+}
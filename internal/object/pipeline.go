@@ -0,0 +1,113 @@
+package object
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/integrity"
+)
+
+// readLayer is one transformation between the bytes kept in the storage
+// engine and the bytes of an object, such as decryption. Layers map a
+// range of their output back to the range of their input it is computed
+// from, so range requests are served without reading the whole object
+// through layers that work in blocks or chunks.
+type readLayer interface {
+	// sourceRange returns the input range holding output bytes
+	// [start, start+length). It may be wider than the output range when
+	// the layer can only transform whole blocks.
+	sourceRange(start, length int64) (int64, int64)
+	// transform turns the input read at srcStart into output bytes
+	// [start, start+length)
+	transform(data []byte, srcStart, start, length int64) ([]byte, error)
+}
+
+// readPipeline is the ordered list of layers of an object, starting with
+// the one applied to the stored bytes
+type readPipeline []readLayer
+
+// readAtFunc reads length stored bytes at start of an object
+type readAtFunc func(start, length int64) ([]byte, error)
+
+// read returns object bytes [start, start+length), reading the stored
+// range the layers need and passing it through each layer in turn
+func (p readPipeline) read(readAt readAtFunc, start, length int64) ([]byte, error) {
+	// ranges[i] is the input range of layer i; the last entry is the output
+	ranges := make([][2]int64, len(p)+1)
+	ranges[len(p)] = [2]int64{start, length}
+	for i := len(p) - 1; i >= 0; i-- {
+		ranges[i][0], ranges[i][1] = p[i].sourceRange(ranges[i+1][0], ranges[i+1][1])
+	}
+
+	data, err := readAt(ranges[0][0], ranges[0][1])
+	if err != nil {
+		return nil, err
+	}
+	for i, layer := range p {
+		data, err = layer.transform(data, ranges[i][0], ranges[i+1][0], ranges[i+1][1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// decryptLayer decrypts objects stored encrypted. The keystream can be
+// positioned at any offset, so ranges map onto themselves.
+type decryptLayer struct {
+	keys     *encryption.Keyring
+	envelope *encryption.Envelope
+}
+
+func (l decryptLayer) sourceRange(start, length int64) (int64, int64) {
+	return start, length
+}
+
+func (l decryptLayer) transform(data []byte, srcStart, start, length int64) ([]byte, error) {
+	if err := l.keys.Decrypt(l.envelope, data, srcStart); err != nil {
+		return nil, fmt.Errorf("failed to decrypt object: %w", err)
+	}
+	return data, nil
+}
+
+// verifyLayer checks object bytes against the checksums of their chunks.
+// Ranges widen to whole chunks, which are checked and then cut back to
+// the range.
+type verifyLayer struct {
+	chunks *integrity.ChunkChecksums
+	size   int64
+}
+
+func (l verifyLayer) sourceRange(start, length int64) (int64, int64) {
+	return l.chunks.Span(start, length, l.size)
+}
+
+func (l verifyLayer) transform(data []byte, srcStart, start, length int64) ([]byte, error) {
+	if err := l.chunks.Verify(data, srcStart); err != nil {
+		return nil, err
+	}
+	return data[start-srcStart : start-srcStart+length], nil
+}
+
+// readPipeline returns the layers an object was stored through, in the
+// order they are undone on read, followed by the check of its chunk
+// checksums when reads verify them
+func (s *Service) readPipeline(obj *Object) readPipeline {
+	var p readPipeline
+	if obj.Encryption != nil {
+		p = append(p, decryptLayer{keys: s.keys, envelope: obj.Encryption})
+	}
+	if obj.Chunks != nil && s.chunks.Verify {
+		p = append(p, verifyLayer{chunks: obj.Chunks, size: obj.Size})
+	}
+	return p
+}
+
+// readRange reads object bytes [start, start+length) from the storage
+// engine through the object's read pipeline
+func (s *Service) readRange(ctx context.Context, obj *Object, start, length int64) ([]byte, error) {
+	return s.readPipeline(obj).read(func(start, length int64) ([]byte, error) {
+		return s.readStored(ctx, obj, start, length)
+	}, start, length)
+}
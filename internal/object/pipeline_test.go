@@ -0,0 +1,133 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/danielino/comio/internal/encryption"
+)
+
+// blockLayer stands in for layers working on fixed-size blocks, such as
+// compression: it can only transform whole blocks, each stored with every
+// byte offset by its block number
+type blockLayer struct {
+	size int64
+}
+
+func (l blockLayer) sourceRange(start, length int64) (int64, int64) {
+	first := start / l.size * l.size
+	end := (start + length + l.size - 1) / l.size * l.size
+	return first, end - first
+}
+
+func (l blockLayer) transform(data []byte, srcStart, start, length int64) ([]byte, error) {
+	if srcStart%l.size != 0 {
+		return nil, fmt.Errorf("unaligned block read at %d", srcStart)
+	}
+	out := make([]byte, len(data))
+	for i := range data {
+		out[i] = data[i] - byte((srcStart+int64(i))/l.size)
+	}
+	return out[start-srcStart : start-srcStart+length], nil
+}
+
+// encodeBlocks stores plain through blockLayer
+func encodeBlocks(plain []byte, size int64) []byte {
+	out := make([]byte, len(plain))
+	for i := range plain {
+		out[i] = plain[i] + byte(int64(i)/size)
+	}
+	return out
+}
+
+func TestReadPipeline_Combinations(t *testing.T) {
+	// A whole number of blocks; the layer has no notion of a short last block
+	plain := make([]byte, 320)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := testKeyring(t, map[int][]byte{1: randomKey(t)})
+	dataKey, env, err := keys.NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+	encrypt := func(data []byte) []byte {
+		stream, err := encryption.NewStream(dataKey, env.IV, 0)
+		if err != nil {
+			t.Fatalf("NewStream failed: %v", err)
+		}
+		out := make([]byte, len(data))
+		stream.XORKeyStream(out, data)
+		return out
+	}
+	blocks := blockLayer{size: 16}
+
+	tests := []struct {
+		name     string
+		stored   []byte
+		pipeline readPipeline
+	}{
+		{"plain", plain, nil},
+		{"encrypted", encrypt(plain), readPipeline{decryptLayer{keys: keys, envelope: env}}},
+		{"blocks", encodeBlocks(plain, blocks.size), readPipeline{blocks}},
+		// Written through the block layer, then encrypted
+		{"blocks+encrypted", encrypt(encodeBlocks(plain, blocks.size)),
+			readPipeline{decryptLayer{keys: keys, envelope: env}, blocks}},
+	}
+
+	ranges := [][2]int64{{0, 320}, {0, 1}, {15, 2}, {16, 16}, {17, 100}, {250, 50}, {319, 1}}
+
+	for _, tt := range tests {
+		for _, r := range ranges {
+			t.Run(fmt.Sprintf("%s/%d-%d", tt.name, r[0], r[0]+r[1]-1), func(t *testing.T) {
+				readAt := func(start, length int64) ([]byte, error) {
+					return append([]byte(nil), tt.stored[start:start+length]...), nil
+				}
+				got, err := tt.pipeline.read(readAt, r[0], r[1])
+				if err != nil {
+					t.Fatalf("read() error = %v", err)
+				}
+				if !bytes.Equal(got, plain[r[0]:r[0]+r[1]]) {
+					t.Errorf("read() returned wrong bytes for range %v", r)
+				}
+			})
+		}
+	}
+}
+
+func TestObjectService_EncryptedRange(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+	service.SetEncryption(testKeyring(t, map[int][]byte{1: randomKey(t)}), false)
+	ctx := context.Background()
+
+	plain := make([]byte, 1000)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(plain), int64(len(plain)), ""); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// Ranges not aligned to the AES block size
+	for _, r := range [][2]int64{{0, 1000}, {7, 9}, {16, 33}, {999, 1}} {
+		_, data, err := service.GetObjectRange(ctx, "bucket", "key", nil, r[0], r[1])
+		if err != nil {
+			t.Fatalf("GetObjectRange(%v) error = %v", r, err)
+		}
+		got := new(bytes.Buffer)
+		got.ReadFrom(data)
+		data.Close()
+		if !bytes.Equal(got.Bytes(), plain[r[0]:r[0]+r[1]]) {
+			t.Errorf("GetObjectRange(%v) returned wrong bytes", r)
+		}
+	}
+
+	if _, _, err := service.GetObjectRange(ctx, "bucket", "key", nil, 990, 20); !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("range past the end error = %v, want ErrInvalidRange", err)
+	}
+}
@@ -0,0 +1,436 @@
+package object
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/pkg/pathutil"
+)
+
+// ErrPrefixStatsDisabled is returned when prefix statistics are not enabled
+var ErrPrefixStatsDisabled = errors.New("prefix statistics are disabled")
+
+// PrefixStat is the number and size of the objects under a first-level
+// prefix of a bucket. Only the latest version of each key is counted, and
+// directory markers are not counted at all.
+type PrefixStat struct {
+	Prefix  string `json:"prefix"` // Empty for keys without a "/"
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// StatsPrefix returns the first-level "directory" a key is counted under:
+// the key up to and including its first "/", or "" for top-level keys
+func StatsPrefix(key string) string {
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i+1]
+	}
+	return ""
+}
+
+// PrefixStatsStore keeps the per-prefix totals of tracked buckets
+type PrefixStatsStore interface {
+	// Add applies a change to a prefix of a tracked bucket. Changes to
+	// buckets not tracked yet are ignored.
+	Add(ctx context.Context, bucket, prefix string, objects, bytes int64) error
+	// Get returns a bucket's totals, and false if it is not tracked
+	Get(ctx context.Context, bucket string) ([]PrefixStat, bool, error)
+	// Replace sets a bucket's totals and starts tracking it
+	Replace(ctx context.Context, bucket string, stats []PrefixStat) error
+}
+
+// PrefixStatsRepository maintains per-prefix totals as objects are put and
+// deleted through it. A bucket's totals are computed by listing it the
+// first time they are asked for, then kept up to date incrementally.
+type PrefixStatsRepository struct {
+	Repository
+	store PrefixStatsStore
+	// Writes to a key are serialized so the latest version read before
+	// and after each write belong to that write
+	locks writeLocks
+}
+
+// NewPrefixStatsRepository wraps repo to maintain prefix totals in store
+func NewPrefixStatsRepository(repo Repository, store PrefixStatsStore) *PrefixStatsRepository {
+	return &PrefixStatsRepository{Repository: repo, store: store}
+}
+
+// latest returns what the latest version of a key counts for: nothing
+// if the key does not exist, ends in a delete marker or is a directory
+// marker
+func (r *PrefixStatsRepository) latest(ctx context.Context, bucket, key string) (int64, int64) {
+	obj, err := r.Repository.Head(ctx, bucket, key, nil)
+	if err != nil || obj.DeleteMarker || obj.IsDirectoryMarker() {
+		return 0, 0
+	}
+	return 1, obj.Size
+}
+
+// update records the change of a key's latest version since before.
+// Failures are logged; the totals are then off until recomputed.
+func (r *PrefixStatsRepository) update(ctx context.Context, bucket, key string, objectsBefore, bytesBefore int64) {
+	objects, bytes := r.latest(ctx, bucket, key)
+	if objects == objectsBefore && bytes == bytesBefore {
+		return
+	}
+	if err := r.store.Add(ctx, bucket, StatsPrefix(key), objects-objectsBefore, bytes-bytesBefore); err != nil {
+		monitoring.Log.Warn("Failed to update prefix statistics",
+			zap.String("bucket", bucket),
+			zap.String("key", key),
+			zap.Error(err))
+	}
+}
+
+func (r *PrefixStatsRepository) Put(ctx context.Context, obj *Object, data io.Reader) error {
+	defer r.locks.lock(obj.BucketName, obj.Key)()
+	objects, bytes := r.latest(ctx, obj.BucketName, obj.Key)
+	if err := r.Repository.Put(ctx, obj, data); err != nil {
+		return err
+	}
+	r.update(ctx, obj.BucketName, obj.Key, objects, bytes)
+	return nil
+}
+
+func (r *PrefixStatsRepository) Delete(ctx context.Context, bucket, key string, versionID *string) error {
+	defer r.locks.lock(bucket, key)()
+	objects, bytes := r.latest(ctx, bucket, key)
+	if err := r.Repository.Delete(ctx, bucket, key, versionID); err != nil {
+		return err
+	}
+	r.update(ctx, bucket, key, objects, bytes)
+	return nil
+}
+
+func (r *PrefixStatsRepository) Move(ctx context.Context, src, dst, marker *Object) error {
+	defer r.locks.lockPair(src, dst)()
+	srcObjects, srcBytes := r.latest(ctx, src.BucketName, src.Key)
+	dstObjects, dstBytes := r.latest(ctx, dst.BucketName, dst.Key)
+	if err := r.Repository.Move(ctx, src, dst, marker); err != nil {
+		return err
+	}
+	r.update(ctx, src.BucketName, src.Key, srcObjects, srcBytes)
+	r.update(ctx, dst.BucketName, dst.Key, dstObjects, dstBytes)
+	return nil
+}
+
+func (r *PrefixStatsRepository) DeleteAll(ctx context.Context, bucket string) (int, int64, error) {
+	count, size, err := r.Repository.DeleteAll(ctx, bucket)
+	if err != nil {
+		return count, size, err
+	}
+	if err := r.store.Replace(ctx, bucket, nil); err != nil {
+		monitoring.Log.Warn("Failed to reset prefix statistics",
+			zap.String("bucket", bucket),
+			zap.Error(err))
+	}
+	return count, size, nil
+}
+
+// PrefixStats returns a bucket's totals per first-level prefix, largest
+// first, computing them on first use
+func (r *PrefixStatsRepository) PrefixStats(ctx context.Context, bucket string) ([]PrefixStat, error) {
+	stats, ok, err := r.store.Get(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if stats, err = r.compute(ctx, bucket); err != nil {
+			return nil, err
+		}
+		if err := r.store.Replace(ctx, bucket, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes != stats[j].Bytes {
+			return stats[i].Bytes > stats[j].Bytes
+		}
+		return stats[i].Prefix < stats[j].Prefix
+	})
+	return stats, nil
+}
+
+// compute lists a bucket a page at a time and totals it per prefix
+func (r *PrefixStatsRepository) compute(ctx context.Context, bucket string) ([]PrefixStat, error) {
+	totals := make(map[string]*PrefixStat)
+	startAfter := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := r.Repository.List(ctx, bucket, "", ListOptions{MaxKeys: MaxKeysLimit, StartAfter: startAfter})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Objects {
+			if obj.IsDirectoryMarker() {
+				continue
+			}
+			prefix := StatsPrefix(obj.Key)
+			stat := totals[prefix]
+			if stat == nil {
+				stat = &PrefixStat{Prefix: prefix}
+				totals[prefix] = stat
+			}
+			stat.Objects++
+			stat.Bytes += obj.Size
+		}
+		if !result.IsTruncated || len(result.Objects) == 0 {
+			break
+		}
+		startAfter = result.NextMarker
+	}
+
+	stats := make([]PrefixStat, 0, len(totals))
+	for _, stat := range totals {
+		stats = append(stats, *stat)
+	}
+	return stats, nil
+}
+
+// applyPrefixDelta adds a change to a prefix, dropping emptied prefixes
+func applyPrefixDelta(totals map[string]PrefixStat, prefix string, objects, bytes int64) {
+	stat := totals[prefix]
+	stat.Prefix = prefix
+	stat.Objects += objects
+	stat.Bytes += bytes
+	if stat.Objects <= 0 && stat.Bytes <= 0 {
+		delete(totals, prefix)
+		return
+	}
+	totals[prefix] = stat
+}
+
+// MemoryPrefixStatsStore implements PrefixStatsStore in memory
+type MemoryPrefixStatsStore struct {
+	buckets map[string]map[string]PrefixStat
+	mu      sync.Mutex
+}
+
+// NewMemoryPrefixStatsStore creates a memory prefix statistics store
+func NewMemoryPrefixStatsStore() *MemoryPrefixStatsStore {
+	return &MemoryPrefixStatsStore{buckets: make(map[string]map[string]PrefixStat)}
+}
+
+func (s *MemoryPrefixStatsStore) Add(ctx context.Context, bucket, prefix string, objects, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if totals, ok := s.buckets[bucket]; ok {
+		applyPrefixDelta(totals, prefix, objects, bytes)
+	}
+	return nil
+}
+
+func (s *MemoryPrefixStatsStore) Get(ctx context.Context, bucket string) ([]PrefixStat, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals, ok := s.buckets[bucket]
+	if !ok {
+		return nil, false, nil
+	}
+	stats := make([]PrefixStat, 0, len(totals))
+	for _, stat := range totals {
+		stats = append(stats, stat)
+	}
+	return stats, true, nil
+}
+
+func (s *MemoryPrefixStatsStore) Replace(ctx context.Context, bucket string, stats []PrefixStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[string]PrefixStat, len(stats))
+	for _, stat := range stats {
+		totals[stat.Prefix] = stat
+	}
+	s.buckets[bucket] = totals
+	return nil
+}
+
+// FilePrefixStatsStore implements PrefixStatsStore with one JSON file per
+// tracked bucket under <metadataDir>/prefix-stats, cached in memory
+type FilePrefixStatsStore struct {
+	dir   string
+	cache *MemoryPrefixStatsStore
+	mu    sync.Mutex // Serializes updates with the file writes
+}
+
+// NewFilePrefixStatsStore creates a file-based prefix statistics store
+// and loads the buckets it already tracks
+func NewFilePrefixStatsStore(metadataDir string) (*FilePrefixStatsStore, error) {
+	dir := filepath.Join(metadataDir, "prefix-stats")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create prefix statistics directory: %w", err)
+	}
+
+	s := &FilePrefixStatsStore{dir: dir, cache: NewMemoryPrefixStatsStore()}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prefix statistics directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prefix statistics: %w", err)
+		}
+		var file struct {
+			Bucket   string       `json:"bucket"`
+			Prefixes []PrefixStat `json:"prefixes"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			// Recomputed on the next request
+			monitoring.Log.Warn("Ignoring invalid prefix statistics file",
+				zap.String("file", entry.Name()),
+				zap.Error(err))
+			continue
+		}
+		s.cache.Replace(context.Background(), file.Bucket, file.Prefixes)
+	}
+	return s, nil
+}
+
+// save writes a bucket's cached totals to its file
+func (s *FilePrefixStatsStore) save(ctx context.Context, bucket string) error {
+	stats, _, _ := s.cache.Get(ctx, bucket)
+	data, err := json.Marshal(struct {
+		Bucket   string       `json:"bucket"`
+		Prefixes []PrefixStat `json:"prefixes"`
+	}{bucket, stats})
+	if err != nil {
+		return fmt.Errorf("failed to marshal prefix statistics: %w", err)
+	}
+
+	path := filepath.Join(s.dir, pathutil.SanitizePath(bucket)+".json")
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write prefix statistics: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename prefix statistics: %w", err)
+	}
+	return nil
+}
+
+func (s *FilePrefixStatsStore) Add(ctx context.Context, bucket, prefix string, objects, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok, _ := s.cache.Get(ctx, bucket); !ok {
+		return nil
+	}
+	s.cache.Add(ctx, bucket, prefix, objects, bytes)
+	return s.save(ctx, bucket)
+}
+
+func (s *FilePrefixStatsStore) Get(ctx context.Context, bucket string) ([]PrefixStat, bool, error) {
+	return s.cache.Get(ctx, bucket)
+}
+
+func (s *FilePrefixStatsStore) Replace(ctx context.Context, bucket string, stats []PrefixStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Replace(ctx, bucket, stats)
+	return s.save(ctx, bucket)
+}
+
+// SQLitePrefixStatsStore implements PrefixStatsStore in the metadata
+// database
+type SQLitePrefixStatsStore struct {
+	db *database.DB
+}
+
+// NewSQLitePrefixStatsStore creates a SQLite-based prefix statistics store
+func NewSQLitePrefixStatsStore(db *database.DB) *SQLitePrefixStatsStore {
+	return &SQLitePrefixStatsStore{db: db}
+}
+
+func (s *SQLitePrefixStatsStore) Add(ctx context.Context, bucket, prefix string, objects, bytes int64) error {
+	return s.db.WithTx(ctx, func(tx *database.Tx) error {
+		var tracked bool
+		if err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM prefix_stats_buckets WHERE bucket_name = ?)", bucket).Scan(&tracked); err != nil {
+			return fmt.Errorf("failed to check prefix statistics: %w", err)
+		}
+		if !tracked {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO prefix_stats (bucket_name, prefix, objects, bytes) VALUES (?, ?, ?, ?)
+			ON CONFLICT (bucket_name, prefix) DO UPDATE SET
+				objects = objects + excluded.objects,
+				bytes = bytes + excluded.bytes
+		`, bucket, prefix, objects, bytes); err != nil {
+			return fmt.Errorf("failed to update prefix statistics: %w", err)
+		}
+		_, err := tx.ExecContext(ctx,
+			"DELETE FROM prefix_stats WHERE bucket_name = ? AND prefix = ? AND objects <= 0 AND bytes <= 0", bucket, prefix)
+		return err
+	})
+}
+
+func (s *SQLitePrefixStatsStore) Get(ctx context.Context, bucket string) ([]PrefixStat, bool, error) {
+	var tracked bool
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM prefix_stats_buckets WHERE bucket_name = ?)", bucket).Scan(&tracked); err != nil {
+		return nil, false, fmt.Errorf("failed to check prefix statistics: %w", err)
+	}
+	if !tracked {
+		return nil, false, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT prefix, objects, bytes FROM prefix_stats WHERE bucket_name = ?", bucket)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read prefix statistics: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make([]PrefixStat, 0)
+	for rows.Next() {
+		var stat PrefixStat
+		if err := rows.Scan(&stat.Prefix, &stat.Objects, &stat.Bytes); err != nil {
+			return nil, false, fmt.Errorf("failed to scan prefix statistics: %w", err)
+		}
+		stats = append(stats, stat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating prefix statistics: %w", err)
+	}
+	return stats, true, nil
+}
+
+func (s *SQLitePrefixStatsStore) Replace(ctx context.Context, bucket string, stats []PrefixStat) error {
+	return s.db.WithTx(ctx, func(tx *database.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM prefix_stats WHERE bucket_name = ?", bucket); err != nil {
+			return fmt.Errorf("failed to reset prefix statistics: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO prefix_stats_buckets (bucket_name) VALUES (?)", bucket); err != nil {
+			return fmt.Errorf("failed to track prefix statistics: %w", err)
+		}
+		for _, stat := range stats {
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO prefix_stats (bucket_name, prefix, objects, bytes) VALUES (?, ?, ?, ?)",
+				bucket, stat.Prefix, stat.Objects, stat.Bytes); err != nil {
+				return fmt.Errorf("failed to store prefix statistics: %w", err)
+			}
+		}
+		return nil
+	})
+}
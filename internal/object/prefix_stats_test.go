@@ -0,0 +1,103 @@
+package object
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/database"
+)
+
+func TestStatsPrefix(t *testing.T) {
+	tests := map[string]string{
+		"photos/2024/a.jpg": "photos/",
+		"photos/":           "photos/",
+		"readme.txt":        "",
+		"/leading":          "/",
+	}
+	for key, want := range tests {
+		if got := StatsPrefix(key); got != want {
+			t.Errorf("StatsPrefix(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestPrefixStatsRepository(t *testing.T) {
+	fileStore, err := NewFilePrefixStatsStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Open(database.Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stores := map[string]PrefixStatsStore{
+		"memory": NewMemoryPrefixStatsStore(),
+		"file":   fileStore,
+		"sqlite": NewSQLitePrefixStatsStore(db),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := NewPrefixStatsRepository(NewMemoryRepository(), store)
+			put := func(key string, size int64) {
+				t.Helper()
+				obj := &Object{BucketName: "b", Key: key, Size: size, VersionID: GenerateVersionID(), CreatedAt: time.Now()}
+				if err := repo.Put(ctx, obj, nil); err != nil {
+					t.Fatalf("Put(%s) error = %v", key, err)
+				}
+			}
+
+			// Objects written before the first request are found by listing
+			put("photos/a.jpg", 100)
+			put("readme.txt", 5)
+
+			stats, err := repo.PrefixStats(ctx, "b")
+			if err != nil {
+				t.Fatalf("PrefixStats() error = %v", err)
+			}
+			if len(stats) != 2 || stats[0] != (PrefixStat{Prefix: "photos/", Objects: 1, Bytes: 100}) {
+				t.Fatalf("stats = %+v", stats)
+			}
+
+			// Later writes are applied incrementally
+			put("photos/b.jpg", 50)
+			put("photos/a.jpg", 30) // Overwrite
+			put("docs/x.pdf", 500)
+			put("docs/", 0) // Directory marker, not counted
+			put("empty/", 0)
+			if err := repo.Delete(ctx, "b", "readme.txt", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			stats, err = repo.PrefixStats(ctx, "b")
+			if err != nil {
+				t.Fatalf("PrefixStats() error = %v", err)
+			}
+			want := []PrefixStat{
+				{Prefix: "docs/", Objects: 1, Bytes: 500},
+				{Prefix: "photos/", Objects: 2, Bytes: 80},
+			}
+			if len(stats) != len(want) {
+				t.Fatalf("stats = %+v, want %+v", stats, want)
+			}
+			for i := range want {
+				if stats[i] != want[i] {
+					t.Errorf("stats = %+v, want %+v", stats, want)
+					break
+				}
+			}
+
+			if _, _, err := repo.DeleteAll(ctx, "b"); err != nil {
+				t.Fatal(err)
+			}
+			if stats, _ := repo.PrefixStats(ctx, "b"); len(stats) != 0 {
+				t.Errorf("stats after DeleteAll = %+v, want none", stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package object
+
+import (
+	"sync"
+	"time"
+)
+
+// purgeBatchSize is the number of objects freed per batch during a bucket purge
+const purgeBatchSize = 1000
+
+// PurgeStatus is a point-in-time view of a bucket purge
+type PurgeStatus struct {
+	Bucket     string    `json:"bucket"`
+	Total      int       `json:"total"`
+	Deleted    int       `json:"deleted"`
+	FreedBytes int64     `json:"freed_bytes"`
+	StartedAt  time.Time `json:"started_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// PurgeObserver is notified with the updated status after every purge batch
+type PurgeObserver func(status PurgeStatus)
+
+// PurgeProgress tracks a running bucket purge
+type PurgeProgress struct {
+	mu       sync.Mutex
+	status   PurgeStatus
+	observer PurgeObserver
+}
+
+func (p *PurgeProgress) add(deleted int, freed int64) {
+	p.mu.Lock()
+	p.status.Deleted += deleted
+	p.status.FreedBytes += freed
+	status, observer := p.status, p.observer
+	p.mu.Unlock()
+
+	if observer != nil {
+		observer(status)
+	}
+}
+
+func (p *PurgeProgress) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Error = err.Error()
+}
+
+func (p *PurgeProgress) snapshot() PurgeStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// purgeTracker indexes running purges by bucket
+type purgeTracker struct {
+	mu      sync.RWMutex
+	running map[string]*PurgeProgress
+}
+
+func newPurgeTracker() *purgeTracker {
+	return &purgeTracker{
+		running: make(map[string]*PurgeProgress),
+	}
+}
+
+func (t *purgeTracker) start(bucket string, total int, observer PurgeObserver) *PurgeProgress {
+	p := &PurgeProgress{
+		status: PurgeStatus{
+			Bucket:    bucket,
+			Total:     total,
+			StartedAt: time.Now(),
+		},
+		observer: observer,
+	}
+
+	t.mu.Lock()
+	t.running[bucket] = p
+	t.mu.Unlock()
+
+	return p
+}
+
+func (t *purgeTracker) finish(bucket string) {
+	t.mu.Lock()
+	delete(t.running, bucket)
+	t.mu.Unlock()
+}
+
+func (t *purgeTracker) get(bucket string) (PurgeStatus, bool) {
+	t.mu.RLock()
+	p, ok := t.running[bucket]
+	t.mu.RUnlock()
+
+	if !ok {
+		return PurgeStatus{}, false
+	}
+	return p.snapshot(), true
+}
@@ -3,6 +3,8 @@ package object
 import (
 	"context"
 	"io"
+	"sort"
+	"strings"
 )
 
 const (
@@ -22,13 +24,24 @@ type ListOptions struct {
 
 // ListResult defines the result of listing objects
 type ListResult struct {
-	Objects        []*Object
-	CommonPrefixes []string
-	IsTruncated    bool
-	NextMarker     string
+	Objects        []*Object `json:"objects"`
+	CommonPrefixes []string  `json:"common_prefixes"`
+	IsTruncated    bool      `json:"is_truncated"`
+	NextMarker     string    `json:"next_marker"`
 }
 
-// Repository defines the object persistence interface
+// IterateFunc is called for each object visited by Repository.Iterate.
+// Returning an error stops the iteration and the error is returned to the caller.
+type IterateFunc func(obj *Object) error
+
+// Repository defines the object persistence interface.
+//
+// Every backend gives read-after-write consistency on a node: once Put or
+// Delete returns, a List, Head or Get on the same node reflects it, even
+// while other writes to the bucket are in flight. List filters keys by the
+// prefix argument, compared byte for byte, and returns each key once, in
+// key order. Keys under a common prefix are rolled up into CommonPrefixes
+// when a delimiter is set.
 type Repository interface {
 	Put(ctx context.Context, obj *Object, data io.Reader) error
 	Get(ctx context.Context, bucket, key string, versionID *string) (*Object, io.ReadCloser, error)
@@ -37,4 +50,52 @@ type Repository interface {
 	Head(ctx context.Context, bucket, key string, versionID *string) (*Object, error)
 	Count(ctx context.Context, bucket string) (int, int64, error)
 	DeleteAll(ctx context.Context, bucket string) (int, int64, error)
+	// UpdateMetadata atomically replaces the latest version of obj's key
+	// with obj, which describes the same stored data, provided that version
+	// is still versionID. It returns ErrObjectChanged if the key was
+	// written since, and ErrObjectNotFound if it no longer exists.
+	UpdateMetadata(ctx context.Context, obj *Object, versionID string) error
+	// Move atomically retargets src, provided it is still the latest
+	// version of its key, to dst, which describes the same stored data
+	// under another bucket or key. With a marker, the marker becomes the
+	// latest version of src's key; otherwise the key is removed, as by
+	// Delete without a version. It returns ErrObjectChanged if src's key
+	// was written since, and ErrObjectNotFound if it no longer exists.
+	Move(ctx context.Context, src, dst, marker *Object) error
+	// Iterate streams every stored object in bucket whose key has the given
+	// prefix to fn without materialising the full listing. Visit order is
+	// backend-defined; use List when key order matters.
+	Iterate(ctx context.Context, bucket, prefix string, fn IterateFunc) error
+	// Walk streams to fn what List would return over every page: the latest
+	// version of each key in bucket with the given prefix and sorting after
+	// startAfter, in key order, leaving out deleted keys.
+	Walk(ctx context.Context, bucket, prefix, startAfter string, fn IterateFunc) error
+}
+
+// groupCommonPrefixes rolls up the objects whose key, after prefix,
+// contains delimiter into sorted common prefixes, returning the objects
+// directly under prefix
+func groupCommonPrefixes(objects []*Object, prefix, delimiter string) ([]*Object, []string) {
+	if delimiter == "" {
+		return objects, nil
+	}
+
+	seen := make(map[string]bool)
+	var direct []*Object
+	var prefixes []string
+	for _, obj := range objects {
+		remainder := strings.TrimPrefix(obj.Key, prefix)
+		idx := strings.Index(remainder, delimiter)
+		if idx < 0 {
+			direct = append(direct, obj)
+			continue
+		}
+		p := prefix + remainder[:idx+len(delimiter)]
+		if !seen[p] {
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	return direct, prefixes
 }
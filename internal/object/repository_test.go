@@ -0,0 +1,320 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/metafile"
+)
+
+// testRepositories returns one instance of every Repository backend
+func testRepositories(t *testing.T) map[string]Repository {
+	fileRepo, err := NewFileRepository(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file repository: %v", err)
+	}
+
+	db, err := database.Open(database.Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, name := range []string{"iter-bucket", "other-bucket"} {
+		if _, err := db.Exec("INSERT INTO buckets (name, owner, created_at) VALUES (?, ?, ?)",
+			name, "default", time.Now()); err != nil {
+			t.Fatalf("Failed to create bucket row: %v", err)
+		}
+	}
+
+	return map[string]Repository{
+		"memory": NewMemoryRepository(),
+		"file":   fileRepo,
+		"sqlite": NewSQLiteRepository(db),
+	}
+}
+
+func TestRepository_Iterate(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			keys := []string{"logs/a", "logs/b", "data/c"}
+			for _, key := range keys {
+				obj := &Object{
+					Key:        key,
+					BucketName: "iter-bucket",
+					VersionID:  GenerateVersionID(),
+					Size:       10,
+					CreatedAt:  time.Now(),
+					ModifiedAt: time.Now(),
+				}
+				if err := repo.Put(ctx, obj, nil); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+
+			var visited []string
+			err := repo.Iterate(ctx, "iter-bucket", "logs/", func(obj *Object) error {
+				visited = append(visited, obj.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Iterate() error = %v", err)
+			}
+
+			sort.Strings(visited)
+			if len(visited) != 2 || visited[0] != "logs/a" || visited[1] != "logs/b" {
+				t.Errorf("Iterate() visited %v, want [logs/a logs/b]", visited)
+			}
+
+			// Errors from the callback stop iteration
+			stop := errors.New("stop")
+			calls := 0
+			err = repo.Iterate(ctx, "iter-bucket", "", func(obj *Object) error {
+				calls++
+				return stop
+			})
+			if !errors.Is(err, stop) {
+				t.Errorf("Iterate() error = %v, want %v", err, stop)
+			}
+			if calls != 1 {
+				t.Errorf("Iterate() called fn %d times after error, want 1", calls)
+			}
+
+			// Missing buckets iterate nothing
+			err = repo.Iterate(ctx, "missing-bucket", "", func(obj *Object) error {
+				t.Errorf("unexpected object %s", obj.Key)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Iterate() on missing bucket error = %v", err)
+			}
+		})
+	}
+}
+
+func TestRepository_Walk(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, key := range []string{"logs/c", "logs/a", "data/x", "logs/b", "logs/d"} {
+				obj := &Object{
+					Key:        key,
+					BucketName: "iter-bucket",
+					VersionID:  GenerateVersionID(),
+					Size:       10,
+					CreatedAt:  time.Now(),
+					ModifiedAt: time.Now(),
+				}
+				if err := repo.Put(ctx, obj, nil); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+			// Deleted keys are left out
+			marker := &Object{
+				Key:          "logs/d",
+				BucketName:   "iter-bucket",
+				VersionID:    GenerateVersionID(),
+				DeleteMarker: true,
+				CreatedAt:    time.Now().Add(time.Second),
+				ModifiedAt:   time.Now().Add(time.Second),
+			}
+			if err := repo.Put(ctx, marker, nil); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			var visited []string
+			err := repo.Walk(ctx, "iter-bucket", "logs/", "logs/a", func(obj *Object) error {
+				visited = append(visited, obj.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if strings.Join(visited, " ") != "logs/b logs/c" {
+				t.Errorf("Walk() visited %v, want [logs/b logs/c] in order", visited)
+			}
+
+			stop := errors.New("stop")
+			calls := 0
+			err = repo.Walk(ctx, "iter-bucket", "", "", func(obj *Object) error {
+				calls++
+				return stop
+			})
+			if !errors.Is(err, stop) || calls != 1 {
+				t.Errorf("Walk() = %v after %d calls, want %v after 1", err, calls, stop)
+			}
+		})
+	}
+}
+
+func TestRepository_ListEntryFields(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			obj := &Object{
+				Key:          "owned",
+				BucketName:   "iter-bucket",
+				VersionID:    GenerateVersionID(),
+				Size:         10,
+				Checksum:     integrity.Checksum{Algorithm: "SHA256", Value: "abc"},
+				StorageClass: StorageClassStandard,
+				Owner:        "AKIDOWNER",
+				CreatedAt:    time.Now(),
+				ModifiedAt:   time.Now(),
+			}
+			if err := repo.Put(ctx, obj, nil); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			result, err := repo.List(ctx, "iter-bucket", "", ListOptions{})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(result.Objects) != 1 {
+				t.Fatalf("List() returned %d objects, want 1", len(result.Objects))
+			}
+			got := result.Objects[0]
+			if got.Owner != "AKIDOWNER" {
+				t.Errorf("Owner = %q, want AKIDOWNER", got.Owner)
+			}
+			if got.StorageClass != StorageClassStandard {
+				t.Errorf("StorageClass = %q, want %s", got.StorageClass, StorageClassStandard)
+			}
+			if got.Checksum.Algorithm != "SHA256" {
+				t.Errorf("Checksum.Algorithm = %q, want SHA256", got.Checksum.Algorithm)
+			}
+		})
+	}
+}
+
+func TestRepository_GetVersion(t *testing.T) {
+	ctx := context.Background()
+
+	for name, repo := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			obj := &Object{
+				Key:        "versioned",
+				BucketName: "iter-bucket",
+				VersionID:  GenerateVersionID(),
+				Size:       10,
+				CreatedAt:  time.Now(),
+				ModifiedAt: time.Now(),
+			}
+			if err := repo.Put(ctx, obj, nil); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			got, _, err := repo.Get(ctx, "iter-bucket", "versioned", &obj.VersionID)
+			if err != nil {
+				t.Fatalf("Get(version) error = %v", err)
+			}
+			if got.VersionID != obj.VersionID {
+				t.Errorf("VersionID = %q, want %q", got.VersionID, obj.VersionID)
+			}
+
+			missing := "no-such-version"
+			_, _, err = repo.Get(ctx, "iter-bucket", "versioned", &missing)
+			if !errors.Is(err, ErrVersionNotFound) || !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("Get(missing version) error = %v, want ErrVersionNotFound", err)
+			}
+		})
+	}
+}
+
+func TestFileRepository_UpgradeMetadata(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	current := &Object{BucketName: "b", Key: "current.txt", Size: 1, VersionID: GenerateVersionID(), StorageClass: StorageClassStandard}
+	if err := repo.Put(ctx, current, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Written before schema versions and storage classes
+	legacy := filepath.Join(dir, "objects", "b", "legacy.txt.meta")
+	if err := os.WriteFile(legacy, []byte(`{"key": "legacy.txt", "bucket_name": "b", "size": 5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "objects", "b", "broken.meta"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Old files are upgraded on read
+	obj, err := repo.Head(ctx, "b", "legacy.txt", nil)
+	if err != nil || obj.Size != 5 || obj.StorageClass != StorageClassStandard {
+		t.Fatalf("Head(legacy.txt) = %+v, %v", obj, err)
+	}
+
+	result, err := repo.UpgradeMetadata(ctx, true)
+	if err != nil || result.Scanned != 3 || result.Upgraded != 1 || len(result.Invalid) != 1 {
+		t.Fatalf("dry run = %+v, %v", result, err)
+	}
+	if data, _ := os.ReadFile(legacy); strings.Contains(string(data), "schema_version") {
+		t.Error("dry run rewrote the file")
+	}
+
+	if result, err := repo.UpgradeMetadata(ctx, false); err != nil || result.Upgraded != 1 {
+		t.Fatalf("UpgradeMetadata() = %+v, %v", result, err)
+	}
+	if data, _ := os.ReadFile(legacy); !strings.Contains(string(data), `"schema_version": 3`) {
+		t.Errorf("upgraded file = %s", data)
+	}
+	if result, _ := repo.UpgradeMetadata(ctx, false); result.Upgraded != 0 {
+		t.Errorf("second run upgraded %d files", result.Upgraded)
+	}
+}
+
+func TestFileRepository_QuarantinesCorruptMetadata(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, key := range []string{"good.txt", "bad.txt"} {
+		obj := &Object{BucketName: "b", Key: key, Size: 10, VersionID: GenerateVersionID()}
+		if err := repo.Put(ctx, obj, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Flip the size of one object on disk
+	bad := filepath.Join(dir, "objects", "b", "bad.txt.meta")
+	data, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte(strings.Replace(string(data), `"size": 10`, `"size": 90`, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.Head(ctx, "b", "bad.txt", nil); !errors.Is(err, metafile.ErrCorrupt) {
+		t.Fatalf("Head() error = %v, want ErrCorrupt", err)
+	}
+	if _, err := repo.Head(ctx, "b", "bad.txt", nil); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("Head() after quarantine error = %v, want ErrObjectNotFound", err)
+	}
+	if count, size, err := repo.Count(ctx, "b"); err != nil || count != 1 || size != 10 {
+		t.Errorf("Count() = %d, %d, %v, want the good object only", count, size, err)
+	}
+
+	files, err := metafile.NewQuarantine(dir).List()
+	if err != nil || len(files) != 1 || files[0].Path != filepath.Join("objects", "b", "bad.txt.meta") {
+		t.Errorf("quarantined = %+v, %v", files, err)
+	}
+}
@@ -0,0 +1,82 @@
+package object
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// SetSealVerification makes StoredExtents check the data of sealed
+// objects against the CRC in their seal, not only that the seal is there.
+// That reads all stored data.
+func (s *Service) SetSealVerification(verifyData bool) {
+	s.verifySeals = verifyData
+}
+
+// StoredExtents calls fn with the location in the storage engine of the
+// data of every version stored in a bucket, so the engine's allocations
+// can be restored after a restart. Delete markers and empty objects have
+// no data and are skipped.
+//
+// Versions whose data was sealed but whose seal is missing, or does not
+// match the data, were torn by a crash before their write reached the
+// device. They are discarded and their extents left free for reuse.
+//
+// Extents shared by bucket clones or by versions written on a base are
+// passed once, however many objects hold them, and their objects counted
+// so they are freed by the last.
+func (s *Service) StoredExtents(ctx context.Context, bucket string, fn func(offset, size int64) error) error {
+	var torn []*Object
+	err := s.repo.Iterate(ctx, bucket, "", func(obj *Object) error {
+		if obj.DeleteMarker || obj.Size == 0 {
+			return nil
+		}
+		if obj.Sealed {
+			sealed := obj.sealedExtent()
+			err := storage.CheckSeal(ctx, s.engine, sealed.offset, sealed.size, s.verifySeals)
+			if errors.Is(err, storage.ErrTornWrite) {
+				torn = append(torn, obj)
+				return nil
+			}
+			// An unreadable seal is no proof of a torn write; the data
+			// is kept for reads or the scrubber to judge
+			if err != nil {
+				monitoring.Log.Warn("Failed to check object seal",
+					zap.String("bucket", obj.BucketName),
+					zap.String("key", obj.Key),
+					zap.Error(err))
+			}
+		}
+		for _, a := range obj.allocations() {
+			if obj.Shared && !s.refs.restore(a.offset) {
+				continue
+			}
+			if err := fn(a.offset, a.size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Discarded once the iteration is over, as repositories may not allow
+	// changes during one
+	for _, obj := range torn {
+		monitoring.Log.Error("Discarding object version torn by a crash",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.String("version_id", obj.VersionID),
+			zap.Int64("offset", obj.Offset),
+			zap.Int64("size", obj.Size))
+		if err := s.repo.Delete(ctx, obj.BucketName, obj.Key, &obj.VersionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/danielino/comio/internal/storage"
+)
+
+func TestStoredExtents_DiscardsTornWrites(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "object_seal_*.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Truncate(16 * 1024 * 1024)
+	f.Close()
+	engine, err := storage.NewSimpleEngine(f.Name(), 16*1024*1024, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	engine.SetSealing(true)
+
+	repo := NewMemoryRepository()
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	objects := map[string]*Object{}
+	for _, key := range []string{"complete", "no-seal", "torn-data"} {
+		obj, err := service.PutObject(ctx, "bucket", key, bytes.NewReader(bytes.Repeat([]byte(key), 100)), int64(100*len(key)), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !obj.Sealed {
+			t.Fatalf("%s was not sealed", key)
+		}
+		objects[key] = obj
+	}
+
+	// The crash lost one object's seal and part of another's data
+	noSeal := objects["no-seal"]
+	engine.Write(ctx, noSeal.Offset+noSeal.Size, make([]byte, storage.SealSize))
+	torn := objects["torn-data"]
+	engine.Write(ctx, torn.Offset+torn.Size-10, make([]byte, 10))
+
+	complete := objects["complete"]
+	restore := func() []int64 {
+		var offsets []int64
+		if err := service.StoredExtents(ctx, "bucket", func(offset, size int64) error {
+			offsets = append(offsets, offset)
+			if offset == complete.Offset && size != complete.Size+storage.SealSize {
+				t.Errorf("restored size %d, want the data and its seal, %d", size, complete.Size+storage.SealSize)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return offsets
+	}
+
+	// Without the data check only the missing seal is found
+	if offsets := restore(); len(offsets) != 2 {
+		t.Errorf("restored %d extents, want 2", len(offsets))
+	}
+	if _, err := service.GetObjectMetadata(ctx, "bucket", "no-seal"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("object without its seal was kept: %v", err)
+	}
+
+	service.SetSealVerification(true)
+	if offsets := restore(); len(offsets) != 1 || offsets[0] != objects["complete"].Offset {
+		t.Errorf("restored extents %v, want only the complete object's", offsets)
+	}
+	if _, err := service.GetObjectMetadata(ctx, "bucket", "torn-data"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("object with torn data was kept: %v", err)
+	}
+
+	// Deleting an object frees its seal with its data
+	used := engine.Stats().UsedBytes
+	if err := service.DeleteObject(ctx, "bucket", "complete"); err != nil {
+		t.Fatal(err)
+	}
+	if freed := used - engine.Stats().UsedBytes; freed != complete.Size+storage.SealSize {
+		t.Errorf("deleting freed %d bytes, want %d", freed, complete.Size+storage.SealSize)
+	}
+}
@@ -0,0 +1,86 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/danielino/comio/internal/replication"
+)
+
+// ErrNotReplicated is returned when resyncing a bucket whose objects are
+// not replicated
+var ErrNotReplicated = errors.New("bucket is not replicated")
+
+// ResyncSummary totals a resync
+type ResyncSummary struct {
+	Objects int64 `json:"objects"` // Objects queued for replication
+	Bytes   int64 `json:"bytes"`
+	Deleted int64 `json:"deleted"` // Keys whose latest version is a delete marker
+}
+
+// ResyncObserver is notified after every object queued
+type ResyncObserver func(summary ResyncSummary)
+
+// ResyncBucket queues the latest version of every object of a bucket for
+// replication again, so a replica that lost data, or missed events while
+// the queue overflowed, catches up. Keys whose latest version is a delete
+// marker are deleted on the replica. Rather than overflow the queue in
+// turn, it waits for room as the replicator sends the events.
+func (s *Service) ResyncBucket(ctx context.Context, bucket string, observer ResyncObserver) (ResyncSummary, error) {
+	var summary ResyncSummary
+	if !s.replicates(ctx, bucket) {
+		return summary, ErrNotReplicated
+	}
+
+	err := s.WalkObjects(ctx, bucket, "", "", func(obj *Object) error {
+		if err := s.replicator.QueueEventWait(ctx, resyncEvent(obj)); err != nil {
+			return err
+		}
+		if obj.DeleteMarker {
+			summary.Deleted++
+		} else {
+			summary.Objects++
+			summary.Bytes += obj.Size
+		}
+		if observer != nil {
+			observer(summary)
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, fmt.Errorf("failed to resync bucket %s: %w", bucket, err)
+	}
+	return summary, nil
+}
+
+// resyncEvent returns the event replicating the current state of obj
+func resyncEvent(obj *Object) replication.Event {
+	if obj.DeleteMarker {
+		return replication.Event{
+			Type:      replication.EventDeleteObject,
+			Bucket:    obj.BucketName,
+			Key:       obj.Key,
+			Timestamp: obj.ModifiedAt,
+			Metadata: map[string]interface{}{
+				replication.MetadataDeleteMarker: obj.VersionID,
+			},
+		}
+	}
+
+	event := replication.Event{
+		Type:      replication.EventPutObject,
+		Bucket:    obj.BucketName,
+		Key:       obj.Key,
+		Timestamp: obj.ModifiedAt,
+		Metadata: map[string]interface{}{
+			"content_type": obj.ContentType,
+			"size":         obj.Size,
+		},
+		StoragePointer: &replication.StoragePointer{Offset: obj.Offset, Size: obj.Size},
+	}
+	if len(obj.Parts) > 1 {
+		event.Manifest = replicationManifest(obj)
+	}
+	return event
+}
@@ -0,0 +1,158 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// KeyUsage counts objects by the master key version their data key is
+// wrapped with
+type KeyUsage struct {
+	ActiveVersion int           `json:"active_version"`
+	Versions      map[int]int64 `json:"versions"`    // Encrypted objects per master key version
+	Unencrypted   int64         `json:"unencrypted"` // Objects written before encryption was enabled
+	Stale         int64         `json:"stale"`       // Objects on a version other than the active one
+}
+
+// RewrapStatus is the progress of re-wrapping data keys
+type RewrapStatus struct {
+	Total     int64 `json:"total"`     // Objects on old master key versions when the run started
+	Rewrapped int64 `json:"rewrapped"` // Objects now on the active version
+	Skipped   int64 `json:"skipped"`   // Objects overwritten during the run
+	Failed    int64 `json:"failed"`
+}
+
+// RewrapObserver is notified after every object of a re-wrap run
+type RewrapObserver func(status RewrapStatus)
+
+// KeyUsage walks the buckets and counts their objects per master key
+// version
+func (s *Service) KeyUsage(ctx context.Context, buckets []string) (*KeyUsage, error) {
+	if s.keys == nil {
+		return nil, encryption.ErrNotConfigured
+	}
+
+	usage := &KeyUsage{
+		ActiveVersion: s.keys.ActiveVersion(),
+		Versions:      make(map[int]int64),
+	}
+	for _, bucket := range buckets {
+		err := s.WalkObjects(ctx, bucket, "", "", func(obj *Object) error {
+			if obj.Encryption == nil {
+				usage.Unencrypted++
+				return nil
+			}
+			usage.Versions[obj.Encryption.KeyVersion]++
+			if s.keys.Stale(obj.Encryption) {
+				usage.Stale++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan bucket %s: %w", bucket, err)
+		}
+	}
+	return usage, nil
+}
+
+// RewrapObject re-wraps the data key of an object with the active master
+// key version. Only metadata is rewritten; the stored data is unchanged.
+// It returns ErrObjectChanged if the object was overwritten since obj was
+// read.
+func (s *Service) RewrapObject(ctx context.Context, obj *Object) error {
+	if s.keys == nil {
+		return encryption.ErrNotConfigured
+	}
+	if !s.keys.Stale(obj.Encryption) {
+		return nil
+	}
+
+	env, err := s.keys.Rewrap(obj.Encryption)
+	if err != nil {
+		return err
+	}
+
+	current, _, err := s.repo.Get(ctx, obj.BucketName, obj.Key, nil)
+	if err != nil {
+		return err
+	}
+	if current.VersionID != obj.VersionID || current.Offset != obj.Offset {
+		return ErrObjectChanged
+	}
+
+	// Repositories may hand out shared objects, so update a copy
+	updated := *current
+	updated.Encryption = env
+	return s.repo.Put(ctx, &updated, nil)
+}
+
+// RewrapObjects re-wraps every object in the buckets that is on an old
+// master key version, notifying observer (if non-nil) as it goes. Once it
+// succeeds, old master key versions can be removed from the configuration.
+func (s *Service) RewrapObjects(ctx context.Context, buckets []string, observer RewrapObserver) (RewrapStatus, error) {
+	var status RewrapStatus
+
+	usage, err := s.KeyUsage(ctx, buckets)
+	if err != nil {
+		return status, err
+	}
+	status.Total = usage.Stale
+	if observer != nil {
+		observer(status)
+	}
+
+	for _, bucket := range buckets {
+		err := s.WalkObjects(ctx, bucket, "", "", func(obj *Object) error {
+			if !s.keys.Stale(obj.Encryption) {
+				return nil
+			}
+
+			switch err := s.RewrapObject(ctx, obj); {
+			case err == nil:
+				status.Rewrapped++
+			case errors.Is(err, ErrObjectChanged):
+				// The new version is already on the active key
+				status.Skipped++
+			default:
+				status.Failed++
+				monitoring.Log.Warn("Failed to re-wrap object data key",
+					zap.String("bucket", obj.BucketName),
+					zap.String("key", obj.Key),
+					zap.Int("key_version", obj.Encryption.KeyVersion),
+					zap.Error(err))
+			}
+			if observer != nil {
+				observer(status)
+			}
+			return nil
+		})
+		if err != nil {
+			return status, fmt.Errorf("failed to re-wrap bucket %s: %w", bucket, err)
+		}
+	}
+
+	if status.Failed > 0 {
+		return status, fmt.Errorf("%d of %d objects were not re-wrapped", status.Failed, status.Total)
+	}
+	return status, nil
+}
+
+// rewrapOnAccess re-wraps an object read while on an old master key
+// version. Failures are logged; the next read or re-wrap run retries.
+func (s *Service) rewrapOnAccess(ctx context.Context, obj *Object) {
+	if !s.keys.Stale(obj.Encryption) {
+		return
+	}
+	if err := s.RewrapObject(ctx, obj); err != nil && !errors.Is(err, ErrObjectChanged) {
+		monitoring.Log.Warn("Failed to re-wrap object data key on read",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,160 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/danielino/comio/internal/encryption"
+)
+
+func testKeyring(t *testing.T, keys map[int][]byte) *encryption.Keyring {
+	k, err := encryption.NewKeyring(keys, 0)
+	if err != nil {
+		t.Fatalf("NewKeyring failed: %v", err)
+	}
+	return k
+}
+
+func randomKey(t *testing.T) []byte {
+	key := make([]byte, encryption.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestObjectService_Encryption(t *testing.T) {
+	engine := createTestEngine(t)
+	service := NewService(NewMemoryRepository(), engine)
+	service.SetEncryption(testKeyring(t, map[int][]byte{1: randomKey(t)}), false)
+	ctx := context.Background()
+
+	data := []byte("attack at dawn, bring the rest of the plan")
+	obj, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(data), int64(len(data)), "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if obj.Encryption == nil || obj.Encryption.KeyVersion != 1 {
+		t.Fatalf("Encryption = %+v", obj.Encryption)
+	}
+
+	stored, err := engine.Read(context.Background(), obj.Offset, obj.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(stored, data) {
+		t.Error("data stored in plaintext")
+	}
+
+	_, r, err := service.GetObject(ctx, "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	if !bytes.Equal(got, data) {
+		t.Errorf("GetObject = %q, want %q", got, data)
+	}
+
+	_, r, err = service.GetObjectRange(ctx, "bucket", "key", nil, 7, 4)
+	if err != nil {
+		t.Fatalf("GetObjectRange failed: %v", err)
+	}
+	got, _ = io.ReadAll(r)
+	if string(got) != "at d" {
+		t.Errorf("GetObjectRange = %q, want %q", got, "at d")
+	}
+
+	// Without the keys the data cannot be read
+	plain := NewService(service.repo, engine)
+	if _, _, err := plain.GetObject(ctx, "bucket", "key", nil); err == nil {
+		t.Error("expected error reading encrypted object without keys")
+	}
+}
+
+func TestObjectService_KeyRotation(t *testing.T) {
+	v1, v2 := randomKey(t), randomKey(t)
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	ctx := context.Background()
+
+	service := NewService(repo, engine)
+	service.SetEncryption(testKeyring(t, map[int][]byte{1: v1}), true)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := service.PutObject(ctx, "bucket", key, bytes.NewReader([]byte(key)), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rotate: version 2 becomes active, version 1 is kept for reads
+	rotated := NewService(repo, engine)
+	rotated.SetEncryption(testKeyring(t, map[int][]byte{1: v1, 2: v2}), true)
+	if _, err := rotated.PutObject(ctx, "bucket", "d", bytes.NewReader([]byte("d")), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := rotated.KeyUsage(ctx, []string{"bucket"})
+	if err != nil {
+		t.Fatalf("KeyUsage failed: %v", err)
+	}
+	if usage.Stale != 3 || usage.Versions[1] != 3 || usage.Versions[2] != 1 || usage.ActiveVersion != 2 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	// Reading re-wraps lazily
+	if _, _, err := rotated.GetObject(ctx, "bucket", "a", nil); err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if obj, _ := rotated.GetObjectMetadata(ctx, "bucket", "a"); obj.Encryption.KeyVersion != 2 {
+		t.Errorf("key version after read = %d, want 2", obj.Encryption.KeyVersion)
+	}
+
+	var last RewrapStatus
+	status, err := rotated.RewrapObjects(ctx, []string{"bucket"}, func(s RewrapStatus) { last = s })
+	if err != nil {
+		t.Fatalf("RewrapObjects failed: %v", err)
+	}
+	if status.Total != 2 || status.Rewrapped != 2 || last != status {
+		t.Errorf("status = %+v, last = %+v", status, last)
+	}
+
+	// Version 1 can now be retired
+	retired := NewService(repo, engine)
+	retired.SetEncryption(testKeyring(t, map[int][]byte{2: v2}), false)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_, r, err := retired.GetObject(ctx, "bucket", key, nil)
+		if err != nil {
+			t.Fatalf("GetObject(%s) failed: %v", key, err)
+		}
+		if got, _ := io.ReadAll(r); string(got) != key {
+			t.Errorf("GetObject(%s) = %q", key, got)
+		}
+	}
+	if usage, _ := retired.KeyUsage(ctx, []string{"bucket"}); usage.Stale != 0 {
+		t.Errorf("stale after rewrap = %d", usage.Stale)
+	}
+}
+
+func TestObjectService_RewrapObjectChanged(t *testing.T) {
+	v1, v2 := randomKey(t), randomKey(t)
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	ctx := context.Background()
+
+	service := NewService(repo, engine)
+	service.SetEncryption(testKeyring(t, map[int][]byte{1: v1}), false)
+	old, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader([]byte("old")), 3, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader([]byte("new")), 3, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	service.SetEncryption(testKeyring(t, map[int][]byte{1: v1, 2: v2}), false)
+	if err := service.RewrapObject(ctx, old); err != ErrObjectChanged {
+		t.Errorf("err = %v, want ErrObjectChanged", err)
+	}
+}
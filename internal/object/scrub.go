@@ -0,0 +1,226 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/encryption"
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// ScrubResult is the outcome of checking an object's data against its
+// checksums
+type ScrubResult struct {
+	Bucket    string                 `json:"bucket"`
+	Key       string                 `json:"key"`
+	VersionID string                 `json:"version_id"`
+	Chunks    int                    `json:"chunks"`             // Checked; 1 for objects without chunk checksums
+	Corrupt   []integrity.ChunkRange `json:"corrupt,omitempty"`  // Chunks not matching their checksum
+	Repaired  []integrity.ChunkRange `json:"repaired,omitempty"` // Corrupt chunks rewritten from the replica
+}
+
+// ScrubObject reads an object chunk by chunk and checks each against its
+// checksum. With repair, corrupt chunks are fetched from the replica and
+// rewritten in place, leaving the rest of the object untouched. Objects
+// without chunk checksums are checked, and repaired, as a single chunk
+// against the whole-object checksum.
+func (s *Service) ScrubObject(ctx context.Context, bucket, key string, versionID *string, repair bool) (*ScrubResult, error) {
+	obj, err := s.getObject(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scrub(ctx, obj, repair)
+}
+
+// ScrubSummary totals the scrub of a bucket
+type ScrubSummary struct {
+	Objects  int   `json:"objects"`
+	Bytes    int64 `json:"bytes"`
+	Corrupt  int   `json:"corrupt"`  // Objects with corrupt chunks
+	Repaired int   `json:"repaired"` // Corrupt objects whose every chunk was repaired
+	Failed   int   `json:"failed"`   // Objects that could not be read
+}
+
+// ScrubBucket scrubs every object of a bucket as ScrubObject does. Objects
+// that cannot be read are logged and counted, and the scrub goes on.
+func (s *Service) ScrubBucket(ctx context.Context, bucket string, repair bool) (ScrubSummary, error) {
+	var summary ScrubSummary
+	err := s.repo.Iterate(ctx, bucket, "", func(obj *Object) error {
+		if obj.DeleteMarker {
+			return nil
+		}
+		result, err := s.scrub(ctx, obj, repair)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			monitoring.Log.Error("Failed to scrub object",
+				zap.String("bucket", bucket),
+				zap.String("key", obj.Key),
+				zap.Error(err))
+			summary.Failed++
+			return nil
+		}
+		summary.Objects++
+		summary.Bytes += obj.Size
+		if len(result.Corrupt) > 0 {
+			summary.Corrupt++
+			if len(result.Repaired) == len(result.Corrupt) {
+				summary.Repaired++
+			}
+		}
+		return nil
+	})
+	return summary, err
+}
+
+// scrub checks the data of obj against its checksums
+func (s *Service) scrub(ctx context.Context, obj *Object, repair bool) (*ScrubResult, error) {
+	bucket, key := obj.BucketName, obj.Key
+	result := &ScrubResult{Bucket: bucket, Key: key, VersionID: obj.VersionID}
+	sums := objectChunks(obj)
+	if sums == nil {
+		return result, nil
+	}
+
+	// Chunks are read one at a time through the object's layers, with the
+	// check added when reads do not make it
+	p := s.readPipeline(obj)
+	if obj.Chunks == nil || !s.chunks.Verify {
+		p = append(p, verifyLayer{chunks: sums, size: obj.Size})
+	}
+	readAt := func(start, length int64) ([]byte, error) {
+		return s.readStored(ctx, obj, start, length)
+	}
+	for i := range sums.Values {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		chunk := sums.Chunk(i, obj.Size)
+		result.Chunks++
+		if _, err := p.read(readAt, chunk.Start, chunk.Length); err != nil {
+			if !errors.Is(err, integrity.ErrChunkMismatch) {
+				return nil, fmt.Errorf("failed to read chunk %d: %w", i, err)
+			}
+			result.Corrupt = append(result.Corrupt, chunk)
+		}
+	}
+
+	if len(result.Corrupt) > 0 {
+		monitoring.Log.Error("Object data does not match its checksums",
+			zap.String("bucket", bucket),
+			zap.String("key", key),
+			zap.String("version_id", obj.VersionID),
+			zap.Int("corrupt_chunks", len(result.Corrupt)))
+		if repair {
+			result.Repaired = s.repairChunks(ctx, obj, sums, result.Corrupt)
+		}
+	}
+	return result, nil
+}
+
+// objectChunks returns the chunk checksums of an object, or its
+// whole-object checksum as a single chunk for objects without them. It
+// returns nil for objects with nothing to check.
+func objectChunks(obj *Object) *integrity.ChunkChecksums {
+	if obj.Chunks != nil {
+		return obj.Chunks
+	}
+	if obj.Size == 0 || obj.Checksum.Value == "" {
+		return nil
+	}
+	return &integrity.ChunkChecksums{
+		Algorithm: obj.Checksum.Algorithm,
+		ChunkSize: obj.Size,
+		Values:    []string{obj.Checksum.Value},
+	}
+}
+
+// repairChunks rewrites corrupt chunks of an object with the replica's
+// copy and returns those repaired. Failures are logged; the chunks left
+// corrupt are found again by the next read or scrub.
+func (s *Service) repairChunks(ctx context.Context, obj *Object, sums *integrity.ChunkChecksums, chunks []integrity.ChunkRange) []integrity.ChunkRange {
+	if s.replicator == nil {
+		return nil
+	}
+
+	var repaired []integrity.ChunkRange
+	for _, chunk := range chunks {
+		if err := s.repairChunk(ctx, obj, sums, chunk); err != nil {
+			monitoring.Log.Error("Failed to repair corrupt chunk from replica",
+				zap.String("bucket", obj.BucketName),
+				zap.String("key", obj.Key),
+				zap.Int64("start", chunk.Start),
+				zap.Int64("length", chunk.Length),
+				zap.Error(err))
+			continue
+		}
+		monitoring.Log.Warn("Repaired corrupt chunk from replica",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.Int64("start", chunk.Start),
+			zap.Int64("length", chunk.Length))
+		repaired = append(repaired, chunk)
+	}
+	return repaired
+}
+
+// repairChunk fetches one chunk of an object from the replica, checks it
+// and writes it over the local copy
+func (s *Service) repairChunk(ctx context.Context, obj *Object, sums *integrity.ChunkChecksums, chunk integrity.ChunkRange) error {
+	remote, err := s.replicator.FetchObject(ctx, obj.BucketName, obj.Key, obj.ETag, chunk.Start, chunk.Length)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(remote)
+	remote.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != chunk.Length {
+		return fmt.Errorf("replica sent %d bytes of a %d byte chunk", len(data), chunk.Length)
+	}
+	if err := sums.Verify(data, chunk.Start); err != nil {
+		return fmt.Errorf("replica copy is corrupt too: %w", err)
+	}
+
+	if obj.Encryption != nil {
+		dataKey, err := s.keys.DataKey(obj.Encryption)
+		if err != nil {
+			return err
+		}
+		stream, err := encryption.NewStream(dataKey, obj.Encryption.IV, chunk.Start)
+		if err != nil {
+			return err
+		}
+		stream.XORKeyStream(data, data)
+	}
+
+	// The extent is written only while it still holds this version; once
+	// the object is overwritten or deleted it may belong to another
+	current, _, err := s.repo.Get(ctx, obj.BucketName, obj.Key, &obj.VersionID)
+	if err != nil {
+		return err
+	}
+	if current.Offset != obj.Offset {
+		return ErrObjectChanged
+	}
+	// Runs shared with other versions hold the same bytes in each, so
+	// they are repaired for all of them
+	for _, run := range obj.extents(chunk.Start, chunk.Length) {
+		if err := s.engine.Write(ctx, run.Offset, data[:run.Size]); err != nil {
+			return err
+		}
+		if err := storage.Flush(ctx, s.engine, run.Offset, run.Size); err != nil {
+			return err
+		}
+		data = data[run.Size:]
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/replication"
+)
+
+func TestChunkChecksums_LocalizeCorruption(t *testing.T) {
+	engine := createTestEngine(t)
+	service := NewService(NewMemoryRepository(), engine)
+	service.SetChunkChecksums(ChunkOptions{Threshold: 4096, ChunkSize: 1024, Verify: true})
+	ctx := context.Background()
+
+	data := make([]byte, 10*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	obj, err := service.PutObject(ctx, "bucket", "large", bytes.NewReader(data), int64(len(data)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Chunks == nil || len(obj.Chunks.Values) != 11 {
+		t.Fatalf("Chunks = %+v, want 11 chunk checksums", obj.Chunks)
+	}
+	small, err := service.PutObject(ctx, "bucket", "small", bytes.NewReader(data[:100]), 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Chunks != nil {
+		t.Error("object below the threshold has chunk checksums")
+	}
+
+	// Flip bytes in the third chunk
+	if err := engine.Write(ctx, obj.Offset+3000, []byte{0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, rc, err := service.GetObjectRange(ctx, "bucket", "large", nil, 100, 1000)
+	if err != nil {
+		t.Fatalf("GetObjectRange() of intact chunks error = %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	if !bytes.Equal(got, data[100:1100]) {
+		t.Error("GetObjectRange() returned the wrong bytes")
+	}
+
+	_, _, err = service.GetObjectRange(ctx, "bucket", "large", nil, 2900, 200)
+	var mismatch *integrity.ChunkMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("GetObjectRange() over the corrupt chunk error = %v, want a chunk mismatch", err)
+	}
+	if len(mismatch.Chunks) != 1 || mismatch.Chunks[0].Start != 2048 || mismatch.Chunks[0].Length != 1024 {
+		t.Errorf("corrupt chunks = %+v, want bytes 2048-3071", mismatch.Chunks)
+	}
+
+	result, err := service.ScrubObject(ctx, "bucket", "large", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Chunks != 11 || len(result.Corrupt) != 1 || result.Corrupt[0].Index != 2 {
+		t.Errorf("ScrubObject() = %+v, want chunk 2 of 11 corrupt", result)
+	}
+
+	// Objects without chunk checksums are checked whole
+	if err := engine.Write(ctx, small.Offset, []byte{0xff}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = service.ScrubObject(ctx, "bucket", "small", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Chunks != 1 || len(result.Corrupt) != 1 || result.Corrupt[0].Length != 100 {
+		t.Errorf("ScrubObject() of a small object = %+v, want it corrupt as a whole", result)
+	}
+
+	summary, err := service.ScrubBucket(ctx, "bucket", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Objects != 2 || summary.Corrupt != 2 || summary.Repaired != 0 || summary.Bytes != int64(len(data))+100 {
+		t.Errorf("ScrubBucket() = %+v, want both objects corrupt", summary)
+	}
+}
+
+func TestChunkChecksums_RepairFromReplica(t *testing.T) {
+	engine := createTestEngine(t)
+	service := NewService(NewMemoryRepository(), engine)
+	service.SetChunkChecksums(ChunkOptions{Threshold: 1, ChunkSize: 1024, Verify: true})
+	ctx := context.Background()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 512)
+	obj, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(data), int64(len(data)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var fetched []string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.Header.Get("Range"))
+		mu.Unlock()
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.Header().Set("ETag", `"`+obj.ETag+`"`)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : end+1])
+	}))
+	defer remote.Close()
+	service.SetReplicator(replication.NewReplicator(replication.Config{Enabled: true, RemoteURL: remote.URL}))
+
+	for _, off := range []int64{1500, 5000} {
+		if err := engine.Write(ctx, obj.Offset+off, []byte("XX")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A read repairs the chunk it hits, fetching only that chunk
+	_, rc, err := service.GetObjectRange(ctx, "bucket", "key", nil, 1000, 1000)
+	if err != nil {
+		t.Fatalf("GetObjectRange() error = %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	if !bytes.Equal(got, data[1000:2000]) {
+		t.Error("GetObjectRange() returned the wrong bytes")
+	}
+	if len(fetched) != 1 || fetched[0] != "bytes=1024-2047" {
+		t.Errorf("fetched %q from the replica, want the corrupt chunk only", fetched)
+	}
+
+	// A scrub repairs the rest
+	result, err := service.ScrubObject(ctx, "bucket", "key", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Corrupt) != 1 || len(result.Repaired) != 1 || result.Repaired[0].Start != 4096 {
+		t.Errorf("ScrubObject() = %+v, want chunk 4 repaired", result)
+	}
+	stored, err := engine.Read(ctx, obj.Offset, obj.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Error("stored data differs from the original after repair")
+	}
+}
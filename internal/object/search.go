@@ -0,0 +1,534 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/database"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+var (
+	// ErrSearchDisabled is returned when metadata search is not enabled
+	ErrSearchDisabled = errors.New("metadata search is disabled")
+	// ErrInvalidSearch is returned for queries with nothing to search for
+	ErrInvalidSearch = errors.New("invalid search query")
+)
+
+const (
+	// DefaultSearchKeys is the number of matches a search returns by default
+	DefaultSearchKeys = 100
+	// MaxSearchKeys caps the matches returned by one search request
+	MaxSearchKeys = 1000
+)
+
+// Search fields a query term can be restricted to with "field:term"
+const (
+	SearchFieldKey      = "key"
+	SearchFieldMetadata = "meta"
+)
+
+// SearchDocument is what the search index holds of the latest version of
+// a key: the key and its user metadata as text
+type SearchDocument struct {
+	Key      string
+	Metadata string
+}
+
+// SearchTerm is one term of a query: words that must appear in that order,
+// the last as a word prefix, in a field or in any field when Field is empty
+type SearchTerm struct {
+	Field string
+	Words []string
+}
+
+// SearchMatch names an object matching a query
+type SearchMatch struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// SearchOptions scopes and pages a search
+type SearchOptions struct {
+	Buckets    []string
+	Query      string
+	MaxKeys    int
+	StartAfter SearchMatch // Matches sort by bucket, then key
+}
+
+// SearchResult is a page of the objects matching a query
+type SearchResult struct {
+	Objects     []*Object    `json:"objects"`
+	IsTruncated bool         `json:"is_truncated"`
+	Next        *SearchMatch `json:"next,omitempty"` // StartAfter of the next page
+}
+
+// SearchIndex keeps the search documents of tracked buckets
+type SearchIndex interface {
+	// Index sets the document of a key of a tracked bucket, removing it
+	// when doc is nil. Keys of buckets not tracked yet are ignored.
+	Index(ctx context.Context, bucket, key string, doc *SearchDocument) error
+	// Tracked reports whether a bucket's documents are kept
+	Tracked(ctx context.Context, bucket string) (bool, error)
+	// Replace sets every document of a bucket and starts tracking it
+	Replace(ctx context.Context, bucket string, docs []SearchDocument) error
+	// Search returns up to limit matches of all terms in the buckets, in
+	// bucket and key order after the given match
+	Search(ctx context.Context, buckets []string, terms []SearchTerm, after SearchMatch, limit int) ([]SearchMatch, error)
+}
+
+// searchWords splits text into lowercase words of letters and digits, as
+// the index tokenizes it
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ParseSearchQuery splits a query into terms. Terms are separated by
+// spaces and may be restricted to a field with "key:" or "meta:".
+func ParseSearchQuery(query string) ([]SearchTerm, error) {
+	var terms []SearchTerm
+	for _, field := range strings.Fields(query) {
+		term := SearchTerm{}
+		if name, text, ok := strings.Cut(field, ":"); ok && (name == SearchFieldKey || name == SearchFieldMetadata) {
+			term.Field, field = name, text
+		}
+		if term.Words = searchWords(field); len(term.Words) > 0 {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("%w: no words to search for", ErrInvalidSearch)
+	}
+	return terms, nil
+}
+
+// searchDocument returns the document of an object version, nil for
+// delete markers
+func searchDocument(obj *Object) *SearchDocument {
+	if obj.DeleteMarker {
+		return nil
+	}
+	names := make([]string, 0, len(obj.Metadata))
+	for name := range obj.Metadata {
+		if strings.HasPrefix(name, "x-amz-meta-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var text strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&text, "%s %s\n", strings.TrimPrefix(name, "x-amz-meta-"), obj.Metadata[name])
+	}
+	return &SearchDocument{Key: obj.Key, Metadata: text.String()}
+}
+
+// SearchRepository maintains a search index of keys and user metadata as
+// objects are written through it. A bucket is indexed by listing it the
+// first time it is searched, then kept up to date incrementally.
+type SearchRepository struct {
+	Repository
+	index SearchIndex
+	// Writes to a key are serialized so the version indexed after each
+	// write is the latest
+	locks writeLocks
+}
+
+// NewSearchRepository wraps repo to maintain a search index in index
+func NewSearchRepository(repo Repository, index SearchIndex) *SearchRepository {
+	return &SearchRepository{Repository: repo, index: index}
+}
+
+// reindex indexes the latest version of a key. Failures are logged; the
+// key is then found as before until it is written again.
+func (r *SearchRepository) reindex(ctx context.Context, bucket, key string) {
+	var doc *SearchDocument
+	obj, err := r.Repository.Head(ctx, bucket, key, nil)
+	if err == nil {
+		doc = searchDocument(obj)
+	} else if !errors.Is(err, ErrObjectNotFound) {
+		monitoring.Log.Warn("Failed to read object to index",
+			zap.String("bucket", bucket),
+			zap.String("key", key),
+			zap.Error(err))
+		return
+	}
+	if err := r.index.Index(ctx, bucket, key, doc); err != nil {
+		monitoring.Log.Warn("Failed to update search index",
+			zap.String("bucket", bucket),
+			zap.String("key", key),
+			zap.Error(err))
+	}
+}
+
+func (r *SearchRepository) Put(ctx context.Context, obj *Object, data io.Reader) error {
+	defer r.locks.lock(obj.BucketName, obj.Key)()
+	if err := r.Repository.Put(ctx, obj, data); err != nil {
+		return err
+	}
+	r.reindex(ctx, obj.BucketName, obj.Key)
+	return nil
+}
+
+func (r *SearchRepository) Delete(ctx context.Context, bucket, key string, versionID *string) error {
+	defer r.locks.lock(bucket, key)()
+	if err := r.Repository.Delete(ctx, bucket, key, versionID); err != nil {
+		return err
+	}
+	r.reindex(ctx, bucket, key)
+	return nil
+}
+
+func (r *SearchRepository) UpdateMetadata(ctx context.Context, obj *Object, versionID string) error {
+	defer r.locks.lock(obj.BucketName, obj.Key)()
+	if err := r.Repository.UpdateMetadata(ctx, obj, versionID); err != nil {
+		return err
+	}
+	r.reindex(ctx, obj.BucketName, obj.Key)
+	return nil
+}
+
+func (r *SearchRepository) Move(ctx context.Context, src, dst, marker *Object) error {
+	defer r.locks.lockPair(src, dst)()
+	if err := r.Repository.Move(ctx, src, dst, marker); err != nil {
+		return err
+	}
+	r.reindex(ctx, src.BucketName, src.Key)
+	r.reindex(ctx, dst.BucketName, dst.Key)
+	return nil
+}
+
+func (r *SearchRepository) DeleteAll(ctx context.Context, bucket string) (int, int64, error) {
+	count, size, err := r.Repository.DeleteAll(ctx, bucket)
+	if err != nil {
+		return count, size, err
+	}
+	if err := r.index.Replace(ctx, bucket, nil); err != nil {
+		monitoring.Log.Warn("Failed to reset search index",
+			zap.String("bucket", bucket),
+			zap.Error(err))
+	}
+	return count, size, nil
+}
+
+// Search finds the latest object versions whose key or user metadata
+// match a query, indexing the buckets searched for the first time
+func (r *SearchRepository) Search(ctx context.Context, opts SearchOptions) (*SearchResult, error) {
+	terms, err := ParseSearchQuery(opts.Query)
+	if err != nil {
+		return nil, err
+	}
+	limit := opts.MaxKeys
+	if limit <= 0 {
+		limit = DefaultSearchKeys
+	}
+	limit = min(limit, MaxSearchKeys)
+
+	for _, bucket := range opts.Buckets {
+		tracked, err := r.index.Tracked(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if !tracked {
+			if err := r.build(ctx, bucket); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	matches, err := r.index.Search(ctx, opts.Buckets, terms, opts.StartAfter, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	result := &SearchResult{Objects: make([]*Object, 0, min(len(matches), limit))}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		result.IsTruncated = true
+		result.Next = &matches[limit-1]
+	}
+	for _, m := range matches {
+		obj, err := r.Repository.Head(ctx, m.Bucket, m.Key, nil)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !obj.DeleteMarker {
+			result.Objects = append(result.Objects, obj)
+		}
+	}
+	return result, nil
+}
+
+// build lists a bucket a page at a time and indexes it
+func (r *SearchRepository) build(ctx context.Context, bucket string) error {
+	var docs []SearchDocument
+	startAfter := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result, err := r.Repository.List(ctx, bucket, "", ListOptions{MaxKeys: MaxKeysLimit, StartAfter: startAfter})
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Objects {
+			if doc := searchDocument(obj); doc != nil {
+				docs = append(docs, *doc)
+			}
+		}
+		if !result.IsTruncated || len(result.Objects) == 0 {
+			break
+		}
+		startAfter = result.NextMarker
+	}
+	return r.index.Replace(ctx, bucket, docs)
+}
+
+// MemorySearchIndex implements SearchIndex in memory. Nothing survives a
+// restart; buckets are indexed again when next searched.
+type MemorySearchIndex struct {
+	buckets map[string]map[string]memorySearchDocument
+	mu      sync.RWMutex
+}
+
+// memorySearchDocument holds the words of a document's fields
+type memorySearchDocument struct {
+	key      []string
+	metadata []string
+}
+
+// NewMemorySearchIndex creates a memory search index
+func NewMemorySearchIndex() *MemorySearchIndex {
+	return &MemorySearchIndex{buckets: make(map[string]map[string]memorySearchDocument)}
+}
+
+func newMemorySearchDocument(doc SearchDocument) memorySearchDocument {
+	return memorySearchDocument{key: searchWords(doc.Key), metadata: searchWords(doc.Metadata)}
+}
+
+func (s *MemorySearchIndex) Index(ctx context.Context, bucket, key string, doc *SearchDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	if doc == nil {
+		delete(docs, key)
+		return nil
+	}
+	docs[key] = newMemorySearchDocument(*doc)
+	return nil
+}
+
+func (s *MemorySearchIndex) Tracked(ctx context.Context, bucket string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.buckets[bucket]
+	return ok, nil
+}
+
+func (s *MemorySearchIndex) Replace(ctx context.Context, bucket string, docs []SearchDocument) error {
+	indexed := make(map[string]memorySearchDocument, len(docs))
+	for _, doc := range docs {
+		indexed[doc.Key] = newMemorySearchDocument(doc)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket] = indexed
+	return nil
+}
+
+func (s *MemorySearchIndex) Search(ctx context.Context, buckets []string, terms []SearchTerm, after SearchMatch, limit int) ([]SearchMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matches []SearchMatch
+	for _, bucket := range buckets {
+		if bucket < after.Bucket {
+			continue
+		}
+		for key, doc := range s.buckets[bucket] {
+			if bucket == after.Bucket && key <= after.Key {
+				continue
+			}
+			if doc.matches(terms) {
+				matches = append(matches, SearchMatch{Bucket: bucket, Key: key})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Bucket != matches[j].Bucket {
+			return matches[i].Bucket < matches[j].Bucket
+		}
+		return matches[i].Key < matches[j].Key
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// matches reports whether every term appears in the document
+func (d memorySearchDocument) matches(terms []SearchTerm) bool {
+	for _, term := range terms {
+		found := false
+		if term.Field != SearchFieldMetadata {
+			found = containsWords(d.key, term.Words)
+		}
+		if !found && term.Field != SearchFieldKey {
+			found = containsWords(d.metadata, term.Words)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsWords reports whether words appear in text in order, the last
+// as the prefix of a word
+func containsWords(text, words []string) bool {
+	n := len(words)
+	for i := 0; i+n <= len(text); i++ {
+		match := strings.HasPrefix(text[i+n-1], words[n-1])
+		for j := 0; match && j < n-1; j++ {
+			match = text[i+j] == words[j]
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// SQLiteSearchIndex implements SearchIndex with an FTS5 full-text index in
+// the metadata database
+type SQLiteSearchIndex struct {
+	db *database.DB
+}
+
+// NewSQLiteSearchIndex creates a SQLite-based search index
+func NewSQLiteSearchIndex(db *database.DB) *SQLiteSearchIndex {
+	return &SQLiteSearchIndex{db: db}
+}
+
+func (s *SQLiteSearchIndex) Index(ctx context.Context, bucket, key string, doc *SearchDocument) error {
+	return s.db.WithTx(ctx, func(tx *database.Tx) error {
+		var tracked bool
+		if err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM search_buckets WHERE bucket_name = ?)", bucket).Scan(&tracked); err != nil {
+			return fmt.Errorf("failed to check search index: %w", err)
+		}
+		if !tracked {
+			return nil
+		}
+
+		if doc == nil {
+			_, err := tx.ExecContext(ctx,
+				"DELETE FROM search_documents WHERE bucket_name = ? AND object_key = ?", bucket, key)
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO search_documents (bucket_name, object_key, metadata) VALUES (?, ?, ?)
+			ON CONFLICT (bucket_name, object_key) DO UPDATE SET metadata = excluded.metadata
+		`, bucket, key, doc.Metadata)
+		return err
+	})
+}
+
+func (s *SQLiteSearchIndex) Tracked(ctx context.Context, bucket string) (bool, error) {
+	var tracked bool
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM search_buckets WHERE bucket_name = ?)", bucket).Scan(&tracked); err != nil {
+		return false, fmt.Errorf("failed to check search index: %w", err)
+	}
+	return tracked, nil
+}
+
+func (s *SQLiteSearchIndex) Replace(ctx context.Context, bucket string, docs []SearchDocument) error {
+	return s.db.WithTx(ctx, func(tx *database.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM search_documents WHERE bucket_name = ?", bucket); err != nil {
+			return fmt.Errorf("failed to reset search index: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT OR IGNORE INTO search_buckets (bucket_name) VALUES (?)", bucket); err != nil {
+			return fmt.Errorf("failed to track search index: %w", err)
+		}
+		for _, doc := range docs {
+			if _, err := tx.ExecContext(ctx,
+				"INSERT INTO search_documents (bucket_name, object_key, metadata) VALUES (?, ?, ?)",
+				bucket, doc.Key, doc.Metadata); err != nil {
+				return fmt.Errorf("failed to store search document: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// searchColumns are the index columns of the search fields
+var searchColumns = map[string]string{
+	SearchFieldKey:      "object_key",
+	SearchFieldMetadata: "metadata",
+}
+
+func (s *SQLiteSearchIndex) Search(ctx context.Context, buckets []string, terms []SearchTerm, after SearchMatch, limit int) ([]SearchMatch, error) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+
+	// Words are letters and digits only, so they need no escaping inside
+	// a quoted phrase
+	expr := make([]string, len(terms))
+	for i, term := range terms {
+		phrase := `"` + strings.Join(term.Words, " ") + `" *`
+		if column, ok := searchColumns[term.Field]; ok {
+			phrase = column + " : " + phrase
+		}
+		expr[i] = phrase
+	}
+
+	args := []any{strings.Join(expr, " AND ")}
+	for _, bucket := range buckets {
+		args = append(args, bucket)
+	}
+	args = append(args, after.Bucket, after.Bucket, after.Key, limit)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.bucket_name, d.object_key
+		FROM search_index JOIN search_documents d ON d.id = search_index.rowid
+		WHERE search_index MATCH ?
+			AND d.bucket_name IN (?`+strings.Repeat(", ?", len(buckets)-1)+`)
+			AND (d.bucket_name > ? OR (d.bucket_name = ? AND d.object_key > ?))
+		ORDER BY d.bucket_name, d.object_key
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search index: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []SearchMatch
+	for rows.Next() {
+		var m SearchMatch
+		if err := rows.Scan(&m.Bucket, &m.Key); err != nil {
+			return nil, fmt.Errorf("failed to scan search match: %w", err)
+		}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search matches: %w", err)
+	}
+	return matches, nil
+}
@@ -0,0 +1,150 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/database"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	terms, err := ParseSearchQuery(`key:Reports/2024 meta:"Project X" invoice`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SearchTerm{
+		{Field: SearchFieldKey, Words: []string{"reports", "2024"}},
+		{Field: SearchFieldMetadata, Words: []string{"project"}},
+		{Words: []string{"x"}},
+		{Words: []string{"invoice"}},
+	}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("ParseSearchQuery() = %+v, want %+v", terms, want)
+	}
+
+	if _, err := ParseSearchQuery(" key: -- "); !errors.Is(err, ErrInvalidSearch) {
+		t.Errorf("ParseSearchQuery() of no words error = %v, want ErrInvalidSearch", err)
+	}
+}
+
+func TestSearchRepository(t *testing.T) {
+	db, err := database.Open(database.Config{Path: filepath.Join(t.TempDir(), "comio.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	indexes := map[string]SearchIndex{
+		"memory": NewMemorySearchIndex(),
+		"sqlite": NewSQLiteSearchIndex(db),
+	}
+
+	for name, index := range indexes {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := NewSearchRepository(NewMemoryRepository(), index)
+			put := func(bucket, key string, metadata map[string]string) {
+				t.Helper()
+				obj := &Object{BucketName: bucket, Key: key, Metadata: metadata, VersionID: GenerateVersionID(), CreatedAt: time.Now()}
+				if err := repo.Put(ctx, obj, nil); err != nil {
+					t.Fatalf("Put(%s) error = %v", key, err)
+				}
+			}
+			search := func(query string, buckets ...string) []string {
+				t.Helper()
+				result, err := repo.Search(ctx, SearchOptions{Buckets: buckets, Query: query})
+				if err != nil {
+					t.Fatalf("Search(%q) error = %v", query, err)
+				}
+				keys := []string{}
+				for _, obj := range result.Objects {
+					keys = append(keys, obj.BucketName+"/"+obj.Key)
+				}
+				return keys
+			}
+			assertKeys := func(query string, got, want []string) {
+				t.Helper()
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Search(%q) = %v, want %v", query, got, want)
+				}
+			}
+
+			// Objects written before the first search are found by listing
+			put("docs", "reports/2024/q1.pdf", map[string]string{"x-amz-meta-customer": "Acme Corp", "content-type": "application/pdf"})
+			put("docs", "reports/2024/q2.pdf", map[string]string{"x-amz-meta-customer": "Globex"})
+			assertKeys("acme", search("acme", "docs"), []string{"docs/reports/2024/q1.pdf"})
+			assertKeys("report", search("report", "docs"), []string{"docs/reports/2024/q1.pdf", "docs/reports/2024/q2.pdf"})
+			// System metadata is not indexed
+			assertKeys("application", search("application", "docs"), []string{})
+
+			// Later writes are indexed incrementally
+			put("docs", "invoices/acme.pdf", map[string]string{"x-amz-meta-status": "paid"})
+			put("docs", "reports/2024/q2.pdf", map[string]string{"x-amz-meta-customer": "Acme Corp"})
+			assertKeys("acme", search("acme", "docs"), []string{"docs/invoices/acme.pdf", "docs/reports/2024/q1.pdf", "docs/reports/2024/q2.pdf"})
+			assertKeys("acme corp", search("acme corp", "docs"), []string{"docs/reports/2024/q1.pdf", "docs/reports/2024/q2.pdf"})
+			assertKeys("meta:acme", search("meta:acme", "docs"), []string{"docs/reports/2024/q1.pdf", "docs/reports/2024/q2.pdf"})
+			assertKeys("key:acme", search("key:acme", "docs"), []string{"docs/invoices/acme.pdf"})
+			assertKeys("meta:acme-corp", search("meta:acme-corp", "docs"), []string{"docs/reports/2024/q1.pdf", "docs/reports/2024/q2.pdf"})
+			assertKeys("meta:corp-acme", search("meta:corp-acme", "docs"), []string{})
+
+			if err := repo.Delete(ctx, "docs", "reports/2024/q1.pdf", nil); err != nil {
+				t.Fatal(err)
+			}
+			current, err := repo.Head(ctx, "docs", "invoices/acme.pdf", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			updated := *current
+			updated.Metadata = map[string]string{"x-amz-meta-status": "overdue"}
+			if err := repo.UpdateMetadata(ctx, &updated, current.VersionID); err != nil {
+				t.Fatal(err)
+			}
+			assertKeys("acme", search("acme", "docs"), []string{"docs/invoices/acme.pdf", "docs/reports/2024/q2.pdf"})
+			assertKeys("overdue", search("overdue", "docs"), []string{"docs/invoices/acme.pdf"})
+			assertKeys("paid", search("paid", "docs"), []string{})
+
+			// Searches span the buckets asked for and page in bucket and
+			// key order
+			put("archive", "acme/old.pdf", nil)
+			assertKeys("acme", search("acme", "archive", "docs"), []string{"archive/acme/old.pdf", "docs/invoices/acme.pdf", "docs/reports/2024/q2.pdf"})
+
+			var pages [][]string
+			var after SearchMatch
+			for {
+				result, err := repo.Search(ctx, SearchOptions{Buckets: []string{"archive", "docs"}, Query: "acme", MaxKeys: 2, StartAfter: after})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var page []string
+				for _, obj := range result.Objects {
+					page = append(page, obj.BucketName+"/"+obj.Key)
+				}
+				pages = append(pages, page)
+				if !result.IsTruncated {
+					break
+				}
+				after = *result.Next
+			}
+			want := [][]string{{"archive/acme/old.pdf", "docs/invoices/acme.pdf"}, {"docs/reports/2024/q2.pdf"}}
+			if !reflect.DeepEqual(pages, want) {
+				t.Errorf("pages = %v, want %v", pages, want)
+			}
+
+			if _, _, err := repo.DeleteAll(ctx, "archive"); err != nil {
+				t.Fatal(err)
+			}
+			assertKeys("acme", search("acme", "archive"), []string{})
+		})
+	}
+}
+
+func TestService_SearchObjectsDisabled(t *testing.T) {
+	service := NewService(NewMemoryRepository(), nil)
+	if _, err := service.SearchObjects(context.Background(), SearchOptions{Query: "x"}); err != ErrSearchDisabled {
+		t.Errorf("SearchObjects() error = %v, want %v", err, ErrSearchDisabled)
+	}
+}
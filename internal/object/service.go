@@ -3,26 +3,169 @@ package object
 import (
 	"bytes"
 	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/danielino/comio/internal/bufpool"
+	"github.com/danielino/comio/internal/encryption"
 	"github.com/danielino/comio/internal/integrity"
 	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/notification"
 	"github.com/danielino/comio/internal/replication"
 	"github.com/danielino/comio/internal/storage"
 )
 
+// copyBuffers holds the chunks object data is streamed to the engine in
+var copyBuffers = bufpool.New("object_copy", 4096)
+
+// ErrObjectNotFound is returned for keys with no object
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrVersionNotFound is returned for version IDs a key does not have. It
+// wraps ErrObjectNotFound, so callers not dealing with versions need not
+// tell the two apart.
+var ErrVersionNotFound = fmt.Errorf("%w: no such version", ErrObjectNotFound)
+
+// ErrObjectChanged is returned when an object was overwritten while an
+// operation on the previous version was in progress
+var ErrObjectChanged = errors.New("object changed concurrently")
+
+// ErrDirectoryMarkerData is returned when data is written to a key ending
+// in "/". Such keys are directory markers and are always empty.
+var ErrDirectoryMarkerData = errors.New("directory markers cannot hold data")
+
+// ErrInvalidRange is returned when a range read falls outside the object,
+// which may have been replaced by a shorter version since its size was read
+var ErrInvalidRange = errors.New("range not satisfiable")
+
 // Service handles object operations
 type Service struct {
 	repo       Repository
 	engine     storage.Engine
 	replicator *replication.Replicator
+	purges     *purgeTracker
+	pins       *pinTracker
+	refs       *refTracker
+	history    HistoryStore
+	events     *notification.Bus
+	prefixes   *PrefixStatsRepository
+	search     *SearchRepository
+
+	keys         *encryption.Keyring
+	rewrapOnRead bool
+
+	checksums       integrity.CalculatorOptions
+	bucketChecksums ChecksumAlgorithms
+	chunks          ChunkOptions
+	verifySeals     bool
+
+	versioned  VersioningCheck
+	replicated ReplicationCheck
 }
 
 func (s *Service) SetReplicator(replicator *replication.Replicator) {
 	s.replicator = replicator
+	if replicator != nil {
+		replicator.SetResultHandler(s.recordReplication)
+		replicator.SetStorageReader(func(ptr replication.StoragePointer) ([]byte, error) {
+			return s.engine.Read(context.Background(), ptr.Offset, ptr.Size)
+		})
+		replicator.SetObjectOpener(s.openReplicationSource)
+	}
+}
+
+// ReplicationCheck reports whether the objects of a bucket are replicated
+type ReplicationCheck func(ctx context.Context, bucket string) bool
+
+// SetBucketReplication lets buckets opt out of replication. Without it,
+// every bucket is replicated.
+func (s *Service) SetBucketReplication(check ReplicationCheck) {
+	s.replicated = check
+}
+
+// replicates reports whether changes to bucket are queued for replication
+func (s *Service) replicates(ctx context.Context, bucket string) bool {
+	return s.replicator != nil && (s.replicated == nil || s.replicated(ctx, bucket))
+}
+
+// SetNotifications publishes object events to bus
+func (s *Service) SetNotifications(bus *notification.Bus) {
+	s.events = bus
+}
+
+// SetHistory enables per-object operation history
+func (s *Service) SetHistory(history HistoryStore) {
+	s.history = history
+}
+
+// SetPrefixStats serves per-prefix totals from prefixes, which must wrap
+// the repository the service was created with
+func (s *Service) SetPrefixStats(prefixes *PrefixStatsRepository) {
+	s.prefixes = prefixes
+}
+
+// SetSearch serves metadata search from search, which must wrap the
+// repository the service was created with
+func (s *Service) SetSearch(search *SearchRepository) {
+	s.search = search
+}
+
+// SetEncryption encrypts new objects with data keys wrapped by keys. With
+// rewrapOnRead, objects read while wrapped with an old master key version
+// are re-wrapped with the active one.
+func (s *Service) SetEncryption(keys *encryption.Keyring, rewrapOnRead bool) {
+	s.keys = keys
+	s.rewrapOnRead = rewrapOnRead
+}
+
+// SetChecksums selects the checksums computed for new objects
+func (s *Service) SetChecksums(opts integrity.CalculatorOptions) {
+	s.checksums = opts
+}
+
+// ChunkOptions selects the objects given per-chunk checksums besides the
+// whole-object one
+type ChunkOptions struct {
+	Threshold int64 // Smallest object given chunk checksums; 0 disables them
+	ChunkSize int64 // Span of each checksum; 0 means integrity.DefaultChunkSize
+	Verify    bool  // Check the chunks read on every GET
+}
+
+// SetChunkChecksums checksums objects of at least opts.Threshold bytes
+// chunk by chunk, so corruption found by a read or a scrub is localized to
+// its chunks and only those are repaired from the replica
+func (s *Service) SetChunkChecksums(opts ChunkOptions) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = integrity.DefaultChunkSize
+	}
+	s.chunks = opts
+}
+
+// ChecksumAlgorithms returns the checksum algorithms chosen for a bucket,
+// or none for the server's
+type ChecksumAlgorithms func(ctx context.Context, bucket string) []string
+
+// SetBucketChecksums lets buckets choose the algorithms computed for their
+// objects in place of those set by SetChecksums
+func (s *Service) SetBucketChecksums(lookup ChecksumAlgorithms) {
+	s.bucketChecksums = lookup
+}
+
+// newCalculator returns a calculator of the checksums chosen for bucket
+func (s *Service) newCalculator(ctx context.Context, bucket string) (*integrity.Calculator, error) {
+	opts := s.checksums
+	if s.bucketChecksums != nil {
+		if algorithms := s.bucketChecksums(ctx, bucket); len(algorithms) > 0 {
+			opts.Algorithms = algorithms
+		}
+	}
+	return integrity.NewCalculatorWithOptions(opts)
 }
 
 // NewService creates a new object service
@@ -30,82 +173,125 @@ func NewService(repo Repository, engine storage.Engine) *Service {
 	return &Service{
 		repo:   repo,
 		engine: engine,
+		purges: newPurgeTracker(),
+		pins:   newPinTracker(),
+		refs:   newRefTracker(),
 	}
 }
 
 // PutObject uploads an object
 func (s *Service) PutObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string) (*Object, error) {
+	return s.putObject(ctx, bucket, key, data, size, contentType, nil, nil, nil, "")
+}
+
+// PutObjectWithMetadata stores an object with user metadata and the
+// response headers, like Cache-Control, returned when it is read
+func (s *Service) PutObjectWithMetadata(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string, metadata map[string]string) (*Object, error) {
+	return s.putObject(ctx, bucket, key, data, size, contentType, metadata, nil, nil, "")
+}
+
+// PutMultipartObject stores an object assembled from multipart upload
+// parts, recording the part layout so it can be replicated part by part
+func (s *Service) PutMultipartObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string, parts []PartInfo) (*Object, error) {
+	return s.putObject(ctx, bucket, key, data, size, contentType, nil, parts, nil, "")
+}
+
+// putObject stores an object. base is the version it is written on, whose
+// extents its caller holds, if any. restoredFrom is the version being
+// restored when the data is an older version of the object, for its
+// history.
+func (s *Service) putObject(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string, metadata map[string]string, parts []PartInfo, base *Object, restoredFrom string) (*Object, error) {
+	if size != 0 && strings.HasSuffix(key, "/") {
+		return nil, fmt.Errorf("%w: %q", ErrDirectoryMarkerData, key)
+	}
+
 	// Calculate checksums while streaming?
 	// For now, just pass through
 
 	obj := &Object{
-		Key:         key,
-		BucketName:  bucket,
-		Size:        size,
-		ContentType: contentType,
-		CreatedAt:   time.Now(),
-		ModifiedAt:  time.Now(),
-		VersionID:   GenerateVersionID(), // Always generate version ID for now
+		Key:          key,
+		BucketName:   bucket,
+		Size:         size,
+		ContentType:  contentType,
+		CreatedAt:    time.Now(),
+		ModifiedAt:   time.Now(),
+		VersionID:    GenerateVersionID(), // Always generate version ID for now
+		StorageClass: StorageClassStandard,
+		Owner:        actorFromContext(ctx).id,
+		Metadata:     metadata,
+		Parts:        parts,
 	}
 
 	// In a real impl, we would stream to storage engine here, calculate checksums, then save metadata to repo.
 	// The repo.Put might handle the storage engine interaction or we do it here.
 	// The prompt says "Stream object data to storage engine" in service.go
 
+	// Remember whether this put replaces an existing object, and which
+	// version it replaces so replication can send a delta against it
+	op := HistoryPut
+	var previous *Object
+	if s.history != nil || s.replicates(ctx, bucket) {
+		if prev, err := s.getObject(ctx, bucket, key, nil); err == nil {
+			op = HistoryOverwrite
+			previous = prev
+		}
+	}
+
 	// We need to wrap the reader to calculate checksums
-	calc := integrity.NewCalculator()
-	tee := io.TeeReader(data, calc)
+	calc, err := s.newCalculator(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	var hashes io.Writer = calc
+	var chunks *integrity.ChunkCalculator
+	if s.chunks.Threshold > 0 && size >= s.chunks.Threshold {
+		if chunks, err = integrity.NewChunkCalculator(integrity.AlgorithmCRC32C, s.chunks.ChunkSize); err != nil {
+			return nil, err
+		}
+		hashes = io.MultiWriter(calc, chunks)
+	}
+	tee := io.TeeReader(data, hashes)
 
-	// Allocate storage space
-	offset, err := s.engine.Allocate(size)
+	// Each object is encrypted with its own data key. Checksums and the
+	// ETag are of the plaintext.
+	var stream cipher.Stream
+	if s.keys != nil {
+		dataKey, env, err := s.keys.NewDataKey()
+		if err != nil {
+			return nil, err
+		}
+		if stream, err = encryption.NewStream(dataKey, env.IV, 0); err != nil {
+			return nil, err
+		}
+		obj.Encryption = env
+	}
+
+	// Store the data, in an extent of its own or sharing the base's
+	var own allocation
+	if base != nil {
+		own, obj.Sealed, err = s.writeOnBase(ctx, obj, tee, base)
+	} else {
+		own, obj.Sealed, err = s.writeExtent(ctx, tee, size, stream)
+	}
 	if err != nil {
 		return nil, err
 	}
+	obj.Offset = own.offset
 
-	// Setup cleanup: free allocated space if operation fails
+	// Setup cleanup: free allocated space if saving the metadata fails
 	allocated := true
 	defer func() {
 		if allocated {
-			// Operation failed - free the allocated space
-			if freeErr := s.engine.Free(offset, size); freeErr != nil {
-				// Log error - in production, a background process should handle orphaned blocks
-				monitoring.Log.Error("Failed to free allocated storage space during cleanup",
-					zap.Int64("offset", offset),
-					zap.Int64("size", size),
-					zap.Error(freeErr))
-			}
+			s.discard(own)
 		}
 	}()
 
-	// Stream data from reader to storage in chunks
-	buf := make([]byte, 4096) // 4KB chunks
-	currentOffset := offset
-	totalRead := int64(0)
-
-	for {
-		n, err := tee.Read(buf)
-		if n > 0 {
-			if wErr := s.engine.Write(currentOffset, buf[:n]); wErr != nil {
-				// Write failed - cleanup will happen via defer
-				return nil, wErr
-			}
-			currentOffset += int64(n)
-			totalRead += int64(n)
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			// Read failed - cleanup will happen via defer
-			return nil, err
-		}
-	}
-
 	// Update object metadata with checksums
-	sums := calc.Sums()
-	obj.ETag = sums["MD5"]
-	obj.Checksum = integrity.Checksum{Algorithm: "SHA256", Value: sums["SHA256"]}
-	obj.Offset = offset // Store offset
+	obj.ETag = calc.ETag()
+	obj.Checksum = calc.Checksum()
+	if chunks != nil {
+		obj.Chunks = chunks.Checksums()
+	}
 
 	// Save metadata
 	if err := s.repo.Put(ctx, obj, nil); err != nil {
@@ -116,12 +302,20 @@ func (s *Service) PutObject(ctx context.Context, bucket, key string, data io.Rea
 	// Success! Mark as committed so defer doesn't free the space
 	allocated = false
 
+	detail := ""
+	if restoredFrom != "" {
+		op, detail = HistoryRestore, "from version "+restoredFrom
+	}
+	s.recordHistory(ctx, op, obj, detail)
+	s.publish(notification.EventObjectCreated, obj)
+
 	// Queue replication event
-	if s.replicator != nil {
+	if s.replicates(ctx, bucket) {
 		event := replication.Event{
-			Type:   replication.EventPutObject,
-			Bucket: bucket,
-			Key:    key,
+			Type:      replication.EventPutObject,
+			Bucket:    bucket,
+			Key:       key,
+			Timestamp: obj.ModifiedAt, // Matches the consistency token of the write
 			Metadata: map[string]interface{}{
 				"content_type": contentType,
 				"size":         size,
@@ -132,48 +326,225 @@ func (s *Service) PutObject(ctx context.Context, bucket, key string, data io.Rea
 		// For larger objects, use storage pointer to avoid memory leak
 		if size < 1024 { // 1KB threshold for inline
 			// Small objects: read data and include inline
-			inlineData, err := s.engine.Read(offset, size)
+			inlineData, err := s.readRange(ctx, obj, 0, size)
 			if err == nil {
 				event.Data = inlineData
 			} else {
 				// Fallback to pointer if read fails
 				event.StoragePointer = &replication.StoragePointer{
-					Offset: offset,
+					Offset: obj.Offset,
 					Size:   size,
 				}
 			}
 		} else {
 			// Larger objects: use storage pointer (avoids memory leak)
 			event.StoragePointer = &replication.StoragePointer{
-				Offset: offset,
+				Offset: obj.Offset,
 				Size:   size,
 			}
 		}
 
+		// Multipart objects replicate part by part with resumable transfers.
+		// Deltas are computed on stored bytes, so encrypted objects are
+		// always sent in full, as are versions sharing data with another,
+		// whose bytes are not in one extent.
+		if len(parts) > 1 {
+			event.Manifest = replicationManifest(obj)
+		} else if previous != nil && previous.Encryption == nil && obj.Encryption == nil && previous.Extents == nil && obj.Extents == nil {
+			event.Base = &replication.BaseVersion{
+				ETag:    previous.ETag,
+				Pointer: replication.StoragePointer{Offset: previous.Offset, Size: previous.Size},
+			}
+		}
+
 		s.replicator.QueueEvent(event)
 	}
 
 	return obj, nil
 }
 
+// writeExtent allocates an extent of size bytes and streams data into
+// it, encrypted with stream when set. The data is on the device, followed
+// by its seal, when it returns; on failure the extent is freed again.
+func (s *Service) writeExtent(ctx context.Context, data io.Reader, size int64, stream cipher.Stream) (allocation, bool, error) {
+	// Allocate storage space, with room for the seal record after the data
+	// on engines sealing extents
+	sealing := storage.Sealing(s.engine)
+	allocSize := size
+	if sealing {
+		allocSize += storage.SealSize
+	}
+	offset, err := s.engine.Allocate(allocSize)
+	if err != nil {
+		return allocation{}, false, err
+	}
+	extent := allocation{offset, allocSize}
+
+	// Setup cleanup: free allocated space if operation fails
+	allocated := true
+	defer func() {
+		if allocated {
+			s.discard(extent)
+		}
+	}()
+
+	// Stream data from reader to storage in chunks, summing the stored
+	// bytes for the seal written after them
+	seal := storage.NewSealHash()
+	buf := copyBuffers.Get()
+	defer copyBuffers.Put(buf)
+	currentOffset := offset
+	totalRead := int64(0)
+
+	for {
+		n, err := data.Read(buf)
+		if totalRead+int64(n) > size {
+			// Writing past the allocation would overwrite other objects
+			return allocation{}, false, fmt.Errorf("object data exceeds its declared size of %d bytes", size)
+		}
+		if n > 0 {
+			if stream != nil {
+				stream.XORKeyStream(buf[:n], buf[:n])
+			}
+			seal.Write(buf[:n])
+			if wErr := s.engine.Write(ctx, currentOffset, buf[:n]); wErr != nil {
+				// Write failed or the client went away - cleanup will happen via defer
+				return allocation{}, false, wErr
+			}
+			currentOffset += int64(n)
+			totalRead += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Read failed - cleanup will happen via defer
+			return allocation{}, false, err
+		}
+	}
+
+	// The data must be on the device before metadata points to it
+	if err := storage.Flush(ctx, s.engine, offset, size); err != nil {
+		return allocation{}, false, err
+	}
+	// and the seal after it, so recovery tells a complete write from a torn one
+	if sealing {
+		if _, err := storage.Seal(ctx, s.engine, offset, size, seal.Sum32()); err != nil {
+			return allocation{}, false, err
+		}
+	}
+
+	allocated = false
+	return extent, sealing, nil
+}
+
+// discard frees an extent written for an object whose put failed
+func (s *Service) discard(extent allocation) {
+	if extent.size == 0 {
+		return
+	}
+	if err := s.engine.Free(extent.offset, extent.size); err != nil {
+		// Log error - in production, a background process should handle orphaned blocks
+		monitoring.Log.Error("Failed to free allocated storage space during cleanup",
+			zap.Int64("offset", extent.offset),
+			zap.Int64("size", extent.size),
+			zap.Error(err))
+	}
+}
+
+// replicationManifest describes a multipart object's parts by offset
+func replicationManifest(obj *Object) *replication.Manifest {
+	manifest := &replication.Manifest{
+		ETag:  obj.ETag,
+		Parts: make([]replication.ManifestPart, 0, len(obj.Parts)),
+	}
+
+	var offset int64
+	for _, p := range obj.Parts {
+		manifest.Parts = append(manifest.Parts, replication.ManifestPart{
+			PartNumber: p.PartNumber,
+			Offset:     offset,
+			Size:       p.Size,
+		})
+		offset += p.Size
+	}
+	return manifest
+}
+
 // GetObject retrieves an object
 func (s *Service) GetObject(ctx context.Context, bucket, key string, versionID *string) (*Object, io.ReadCloser, error) {
 	// Get metadata from repo
-	obj, _, err := s.repo.Get(ctx, bucket, key, versionID)
+	obj, err := s.getObject(ctx, bucket, key, versionID)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Read data from engine
-	data, err := s.engine.Read(obj.Offset, obj.Size)
+	data, err := s.readData(ctx, obj, 0, obj.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return obj, data, nil
+}
+
+// GetObjectRange retrieves length bytes of an object starting at start.
+// Only the requested extent is read from the storage engine.
+func (s *Service) GetObjectRange(ctx context.Context, bucket, key string, versionID *string, start, length int64) (*Object, io.ReadCloser, error) {
+	obj, err := s.getObject(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if start < 0 || length < 0 || start+length > obj.Size {
+		return nil, nil, fmt.Errorf("%w: %d+%d out of bounds for object of size %d", ErrInvalidRange, start, length, obj.Size)
+	}
+
+	data, err := s.readData(ctx, obj, start, length)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Convert []byte to ReadCloser
+	return obj, data, nil
+}
+
+// readData reads length bytes at start of an object. When the local
+// device fails, the read is served from the replica if it holds the same
+// version.
+func (s *Service) readData(ctx context.Context, obj *Object, start, length int64) (io.ReadCloser, error) {
 	// In a real impl, we'd want a stream from the engine, not read all into memory.
 	// But Engine.Read returns []byte.
-	return obj, io.NopCloser(bytes.NewReader(data)), nil
+	data, err := s.readRange(ctx, obj, start, length)
+	// Corrupt chunks are repaired from the replica, then read locally again
+	var mismatch *integrity.ChunkMismatchError
+	if errors.As(err, &mismatch) && len(s.repairChunks(ctx, obj, obj.Chunks, mismatch.Chunks)) == len(mismatch.Chunks) {
+		data, err = s.readRange(ctx, obj, start, length)
+	}
+	if err == nil {
+		if obj.Encryption != nil && s.rewrapOnRead {
+			s.rewrapOnAccess(ctx, obj)
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	if s.replicator == nil {
+		return nil, err
+	}
+
+	remote, fetchErr := s.replicator.FetchObject(ctx, obj.BucketName, obj.Key, obj.ETag, start, length)
+	if fetchErr != nil {
+		monitoring.Log.Error("Local read failed and the replica cannot serve the object",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.Error(err),
+			zap.NamedError("replica_error", fetchErr))
+		return nil, err
+	}
+
+	monitoring.Log.Warn("Serving object from replica after local read failure",
+		zap.String("bucket", obj.BucketName),
+		zap.String("key", obj.Key),
+		zap.Error(err))
+	return remote, nil
 }
 
 // ListObjects lists objects in a bucket
@@ -181,36 +552,75 @@ func (s *Service) ListObjects(ctx context.Context, bucket, prefix string, opts L
 	return s.repo.List(ctx, bucket, prefix, opts)
 }
 
-// DeleteAllObjects deletes all objects in a bucket and returns total size freed
+// WalkObjects calls fn for every object in a bucket whose key has the given
+// prefix and sorts after startAfter, in key order. Objects are streamed from
+// a single repository walk rather than fetched a page at a time, so callers
+// hold one object at a time and the bucket is read once. Walking stops early
+// if ctx is cancelled or fn returns an error.
+func (s *Service) WalkObjects(ctx context.Context, bucket, prefix, startAfter string, fn func(*Object) error) error {
+	return s.repo.Walk(ctx, bucket, prefix, startAfter, func(obj *Object) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(obj)
+	})
+}
+
+// DeleteAllObjects deletes all objects in a bucket and returns total size freed.
+// Objects are streamed from the repository and removed in batches of
+// purgeBatchSize, so memory use does not grow with bucket size. Progress can
+// be observed with PurgeProgress while the purge runs.
 func (s *Service) DeleteAllObjects(ctx context.Context, bucket string) (int, int64, error) {
-	// First, list all objects to get their offsets (we need to free storage)
-	var allObjects []*Object
-	startAfter := ""
+	status, err := s.PurgeBucket(ctx, bucket, nil)
+	return status.Deleted, status.FreedBytes, err
+}
 
-	for {
-		result, err := s.repo.List(ctx, bucket, "", ListOptions{
-			MaxKeys:    1000,
-			StartAfter: startAfter,
-		})
-		if err != nil {
-			return 0, 0, err
-		}
+// PurgeBucket implements DeleteAllObjects, notifying observer (if non-nil)
+// after every batch, and returns the final purge status
+func (s *Service) PurgeBucket(ctx context.Context, bucket string, observer PurgeObserver) (PurgeStatus, error) {
+	total, _, err := s.repo.Count(ctx, bucket)
+	if err != nil {
+		return PurgeStatus{Bucket: bucket}, err
+	}
 
-		if len(result.Objects) == 0 {
-			break
+	progress := s.purges.start(bucket, total, observer)
+	defer s.purges.finish(bucket)
+
+	batch := make([]*Object, 0, purgeBatchSize)
+	err = s.repo.Iterate(ctx, bucket, "", func(obj *Object) error {
+		batch = append(batch, obj)
+		if len(batch) < purgeBatchSize {
+			return nil
 		}
+		s.purgeBatch(ctx, bucket, batch, progress)
+		batch = batch[:0]
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		s.purgeBatch(ctx, bucket, batch, progress)
+	}
 
-		allObjects = append(allObjects, result.Objects...)
+	if err != nil {
+		progress.fail(err)
+		return progress.snapshot(), err
+	}
 
-		if !result.IsTruncated {
-			break
-		}
-		startAfter = result.NextMarker
+	// Queue replication event
+	if s.replicates(ctx, bucket) {
+		s.replicator.QueueEvent(replication.Event{
+			Type:   replication.EventPurgeBucket,
+			Bucket: bucket,
+		})
 	}
 
-	// Free storage for all objects
-	for _, obj := range allObjects {
-		if err := s.engine.Free(obj.Offset, obj.Size); err != nil {
+	return progress.snapshot(), nil
+}
+
+// purgeBatch frees the storage extents of a batch of objects and then
+// removes their metadata
+func (s *Service) purgeBatch(ctx context.Context, bucket string, batch []*Object, progress *PurgeProgress) {
+	for _, obj := range batch {
+		if err := s.freeObject(obj); err != nil {
 			// Log error but continue - storage cleanup can be done by background process
 			monitoring.Log.Warn("Failed to free storage for object during bulk delete",
 				zap.String("bucket", bucket),
@@ -221,21 +631,28 @@ func (s *Service) DeleteAllObjects(ctx context.Context, bucket string) (int, int
 		}
 	}
 
-	// Delete all metadata in one shot
-	count, totalSize, err := s.repo.DeleteAll(ctx, bucket)
-	if err != nil {
-		return 0, 0, err
+	deleted := 0
+	var freed int64
+	for _, obj := range batch {
+		versionID := obj.VersionID
+		if err := s.repo.Delete(ctx, bucket, obj.Key, &versionID); err != nil {
+			monitoring.Log.Warn("Failed to delete object metadata during bulk delete",
+				zap.String("bucket", bucket),
+				zap.String("key", obj.Key),
+				zap.Error(err))
+			continue
+		}
+		deleted++
+		freed += obj.Size
+		s.recordHistory(ctx, HistoryDelete, obj, "bucket purge")
 	}
 
-	// Queue replication event
-	if s.replicator != nil {
-		s.replicator.QueueEvent(replication.Event{
-			Type:   replication.EventPurgeBucket,
-			Bucket: bucket,
-		})
-	}
+	progress.add(deleted, freed)
+}
 
-	return count, totalSize, nil
+// PurgeProgress returns the progress of a running bucket purge, if any
+func (s *Service) PurgeProgress(bucket string) (PurgeStatus, bool) {
+	return s.purges.get(bucket)
 }
 
 // CountObjects returns the number of objects and total size in a bucket
@@ -245,14 +662,19 @@ func (s *Service) CountObjects(ctx context.Context, bucket string) (int, int64,
 
 // DeleteObject deletes a single object
 func (s *Service) DeleteObject(ctx context.Context, bucket, key string) error {
+	if s.UsesDeleteMarkers(ctx, bucket) {
+		_, err := s.PutDeleteMarker(ctx, bucket, key)
+		return err
+	}
+
 	// Get object metadata first to find storage location
-	obj, _, err := s.repo.Get(ctx, bucket, key, nil)
+	obj, err := s.getObject(ctx, bucket, key, nil)
 	if err != nil {
 		return err
 	}
 
 	// Free storage space
-	if err := s.engine.Free(obj.Offset, obj.Size); err != nil {
+	if err := s.freeObject(obj); err != nil {
 		// Log error but continue with metadata deletion
 		// Storage cleanup can be done later by background process
 		monitoring.Log.Warn("Failed to free storage for deleted object",
@@ -268,8 +690,11 @@ func (s *Service) DeleteObject(ctx context.Context, bucket, key string) error {
 		return err
 	}
 
+	s.recordHistory(ctx, HistoryDelete, obj, "")
+	s.publish(notification.EventObjectRemoved, obj)
+
 	// Queue replication event
-	if s.replicator != nil {
+	if s.replicates(ctx, bucket) {
 		s.replicator.QueueEvent(replication.Event{
 			Type:   replication.EventDeleteObject,
 			Bucket: bucket,
@@ -280,8 +705,145 @@ func (s *Service) DeleteObject(ctx context.Context, bucket, key string) error {
 	return nil
 }
 
+// EvictObject removes the local copy of an object that was moved to
+// another node. Unlike DeleteObject, the object still exists in the
+// cluster, so nothing is replicated, published or recorded. It returns
+// ErrObjectChanged if the object was overwritten since obj was read.
+func (s *Service) EvictObject(ctx context.Context, obj *Object) error {
+	current, _, err := s.repo.Get(ctx, obj.BucketName, obj.Key, nil)
+	if err != nil {
+		return err
+	}
+	if current.ETag != obj.ETag || current.Offset != obj.Offset {
+		return ErrObjectChanged
+	}
+
+	if err := s.repo.Delete(ctx, obj.BucketName, obj.Key, nil); err != nil {
+		return err
+	}
+	if err := s.freeObject(current); err != nil {
+		monitoring.Log.Warn("Failed to free storage for evicted object",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.Error(err))
+	}
+	return nil
+}
+
 // GetObjectMetadata retrieves only object metadata without data
 func (s *Service) GetObjectMetadata(ctx context.Context, bucket, key string) (*Object, error) {
-	obj, _, err := s.repo.Get(ctx, bucket, key, nil)
-	return obj, err
+	return s.HeadObject(ctx, bucket, key, nil)
+}
+
+// HeadObject returns the metadata of an object version, the latest one
+// when versionID is nil
+func (s *Service) HeadObject(ctx context.Context, bucket, key string, versionID *string) (*Object, error) {
+	return s.getObject(ctx, bucket, key, versionID)
+}
+
+// getObject returns the metadata of an object version, the latest one
+// when versionID is nil. Delete markers have no data, so for them it
+// returns ErrDeleteMarker.
+func (s *Service) getObject(ctx context.Context, bucket, key string, versionID *string) (*Object, error) {
+	obj, _, err := s.repo.Get(ctx, bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if obj.DeleteMarker {
+		return nil, ErrDeleteMarker
+	}
+	return obj, nil
+}
+
+// GetObjectHistory returns the recorded operations on an object, oldest
+// first. History is kept after deletion, so it is available for keys that
+// no longer exist.
+func (s *Service) GetObjectHistory(ctx context.Context, bucket, key string) ([]HistoryEvent, error) {
+	if s.history == nil {
+		return nil, ErrHistoryDisabled
+	}
+	return s.history.List(ctx, bucket, key)
+}
+
+// PrefixStats returns the object count and size of each first-level
+// prefix of a bucket, largest first
+func (s *Service) PrefixStats(ctx context.Context, bucket string) ([]PrefixStat, error) {
+	if s.prefixes == nil {
+		return nil, ErrPrefixStatsDisabled
+	}
+	return s.prefixes.PrefixStats(ctx, bucket)
+}
+
+// SearchObjects finds the objects whose key or user metadata match a
+// query, in bucket and key order
+func (s *Service) SearchObjects(ctx context.Context, opts SearchOptions) (*SearchResult, error) {
+	if s.search == nil {
+		return nil, ErrSearchDisabled
+	}
+	return s.search.Search(ctx, opts)
+}
+
+// recordHistory appends an operation on obj to its history. Failures are
+// logged rather than failing the operation itself.
+func (s *Service) recordHistory(ctx context.Context, op HistoryOp, obj *Object, detail string) {
+	if s.history == nil {
+		return
+	}
+
+	a := actorFromContext(ctx)
+	event := HistoryEvent{
+		Op:        op,
+		Bucket:    obj.BucketName,
+		Key:       obj.Key,
+		VersionID: obj.VersionID,
+		Size:      obj.Size,
+		ETag:      obj.ETag,
+		Actor:     a.id,
+		Source:    a.source,
+		Detail:    detail,
+		Timestamp: time.Now(),
+	}
+
+	if err := s.history.Append(ctx, event); err != nil {
+		monitoring.Log.Warn("Failed to record object history",
+			zap.String("bucket", obj.BucketName),
+			zap.String("key", obj.Key),
+			zap.String("op", string(op)),
+			zap.Error(err))
+	}
+}
+
+// publish emits an object event if notifications are enabled
+func (s *Service) publish(eventType notification.EventType, obj *Object) {
+	if s.events == nil {
+		return
+	}
+	s.events.Publish(notification.Event{
+		Type:        eventType,
+		Bucket:      obj.BucketName,
+		Key:         obj.Key,
+		VersionID:   obj.VersionID,
+		Size:        obj.Size,
+		ContentType: obj.ContentType,
+		ETag:        obj.ETag,
+	})
+}
+
+// recordReplication records the outcome of replicating a single-object event
+func (s *Service) recordReplication(event replication.Event, err error) {
+	if event.Key == "" {
+		return // Bucket-level events have no object history
+	}
+	if err == nil {
+		s.markReplicated(event)
+	}
+
+	op := HistoryReplicated
+	detail := string(event.Type)
+	if err != nil {
+		op = HistoryReplicationFailed
+		detail = string(event.Type) + ": " + err.Error()
+	}
+
+	s.recordHistory(context.Background(), op, &Object{BucketName: event.Bucket, Key: event.Key}, detail)
 }
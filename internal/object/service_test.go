@@ -3,13 +3,27 @@ package object
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/danielino/comio/internal/integrity"
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/replication"
 	"github.com/danielino/comio/internal/storage"
 )
 
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
 func createTestEngine(t *testing.T) storage.Engine {
 	f, err := os.CreateTemp("", "object_test_*.dat")
 	if err != nil {
@@ -56,6 +70,75 @@ func TestObjectService_PutObject(t *testing.T) {
 	}
 }
 
+func TestObjectService_PutObjectSkipMD5(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+	service.SetChecksums(integrity.CalculatorOptions{SkipMD5: true})
+	ctx := context.Background()
+
+	data := []byte("test data")
+	obj, err := service.PutObject(ctx, "b", "k", bytes.NewReader(data), int64(len(data)), "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	sha := sha256.Sum256(data)
+	if want := hex.EncodeToString(sha[:16]); obj.ETag != want {
+		t.Errorf("ETag = %s, want %s", obj.ETag, want)
+	}
+	if obj.Checksum.Value != hex.EncodeToString(sha[:]) {
+		t.Errorf("Checksum = %+v, want the SHA-256", obj.Checksum)
+	}
+}
+
+func TestObjectService_BucketChecksums(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+	service.SetBucketChecksums(func(ctx context.Context, bucket string) []string {
+		if bucket == "fast" {
+			return []string{integrity.AlgorithmCRC32C}
+		}
+		return nil
+	})
+	ctx := context.Background()
+
+	data := []byte("test data")
+	for bucket, want := range map[string]string{"fast": integrity.AlgorithmCRC32C, "default": integrity.AlgorithmSHA256} {
+		obj, err := service.PutObject(ctx, bucket, "k", bytes.NewReader(data), int64(len(data)), "text/plain")
+		if err != nil {
+			t.Fatalf("PutObject(%s) error = %v", bucket, err)
+		}
+		if obj.Checksum.Algorithm != want {
+			t.Errorf("%s: checksum algorithm = %s, want %s", bucket, obj.Checksum.Algorithm, want)
+		}
+		if sum := md5.Sum(data); obj.ETag != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: ETag = %s, want the MD5", bucket, obj.ETag)
+		}
+	}
+}
+
+func TestObjectService_BucketReplication(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+	replicator := replication.NewReplicator(replication.Config{Enabled: true})
+	service.SetReplicator(replicator)
+	service.SetBucketReplication(func(ctx context.Context, bucket string) bool {
+		return bucket != "scratch"
+	})
+	ctx := context.Background()
+
+	data := []byte("test data")
+	for _, bucket := range []string{"scratch", "photos"} {
+		if _, err := service.PutObject(ctx, bucket, "k", bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+			t.Fatalf("PutObject(%s) error = %v", bucket, err)
+		}
+		if err := service.DeleteObject(ctx, bucket, "k"); err != nil {
+			t.Fatalf("DeleteObject(%s) error = %v", bucket, err)
+		}
+	}
+
+	// Only the put and delete in photos are queued
+	if queued := replicator.QueueLength(); queued != 2 {
+		t.Errorf("queued %d events, want 2", queued)
+	}
+}
+
 func TestObjectService_GetObject(t *testing.T) {
 	repo := NewMemoryRepository()
 	engine := createTestEngine(t)
@@ -344,3 +427,321 @@ func TestObjectService_PutLargeObject(t *testing.T) {
 		t.Errorf("Read data length = %d, want %d", len(readData), len(data))
 	}
 }
+
+func TestObjectService_WalkObjects(t *testing.T) {
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	// Span more than one list page
+	total := DefaultMaxKeys + 5
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("key-%05d", i)
+		if _, err := service.PutObject(ctx, "walk-bucket", key, bytes.NewReader([]byte("x")), 1, "text/plain"); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	var keys []string
+	err := service.WalkObjects(ctx, "walk-bucket", "", "", func(obj *Object) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkObjects() error = %v", err)
+	}
+	if len(keys) != total {
+		t.Errorf("WalkObjects() visited %d objects, want %d", len(keys), total)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] >= keys[i] {
+			t.Fatalf("WalkObjects() keys out of order: %s >= %s", keys[i-1], keys[i])
+		}
+	}
+}
+
+func TestObjectService_DeleteAllObjects_Batches(t *testing.T) {
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	total := purgeBatchSize*2 + 7
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("key-%05d", i)
+		if _, err := service.PutObject(ctx, "purge-bucket", key, bytes.NewReader([]byte("xy")), 2, "text/plain"); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	count, freed, err := service.DeleteAllObjects(ctx, "purge-bucket")
+	if err != nil {
+		t.Fatalf("DeleteAllObjects() error = %v", err)
+	}
+	if count != total {
+		t.Errorf("DeleteAllObjects() count = %d, want %d", count, total)
+	}
+	if freed != int64(total*2) {
+		t.Errorf("DeleteAllObjects() freed = %d, want %d", freed, total*2)
+	}
+
+	remaining, _, _ := service.CountObjects(ctx, "purge-bucket")
+	if remaining != 0 {
+		t.Errorf("CountObjects() after purge = %d, want 0", remaining)
+	}
+
+	if _, ok := service.PurgeProgress("purge-bucket"); ok {
+		t.Error("PurgeProgress() reported a running purge after completion")
+	}
+	if used := engine.Stats().UsedBytes; used != 0 {
+		t.Errorf("engine UsedBytes after purge = %d, want 0", used)
+	}
+}
+
+func TestObjectService_PurgeBucket_Observer(t *testing.T) {
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	total := purgeBatchSize + 1
+	for i := 0; i < total; i++ {
+		key := fmt.Sprintf("key-%05d", i)
+		if _, err := service.PutObject(ctx, "observe-bucket", key, bytes.NewReader([]byte("d")), 1, "text/plain"); err != nil {
+			t.Fatalf("Failed to put object: %v", err)
+		}
+	}
+
+	var updates []PurgeStatus
+	status, err := service.PurgeBucket(ctx, "observe-bucket", func(s PurgeStatus) {
+		updates = append(updates, s)
+	})
+	if err != nil {
+		t.Fatalf("PurgeBucket() error = %v", err)
+	}
+
+	// One update per batch
+	if len(updates) != 2 {
+		t.Fatalf("observer called %d times, want 2", len(updates))
+	}
+	if updates[0].Deleted != purgeBatchSize || updates[0].Total != total {
+		t.Errorf("first update = %+v, want %d/%d", updates[0], purgeBatchSize, total)
+	}
+	if status.Deleted != total {
+		t.Errorf("PurgeBucket() deleted = %d, want %d", status.Deleted, total)
+	}
+}
+
+func TestObjectService_PurgeBucket_Cancelled(t *testing.T) {
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	service := NewService(repo, engine)
+
+	if _, err := service.PutObject(context.Background(), "cancel-bucket", "key", bytes.NewReader([]byte("d")), 1, "text/plain"); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := service.PurgeBucket(ctx, "cancel-bucket", nil); err == nil {
+		t.Error("PurgeBucket() with cancelled context should return error")
+	}
+}
+
+// cancelingReader cancels its context once the first chunk was read, like
+// a client disconnecting mid-upload
+type cancelingReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.cancel()
+	return n, err
+}
+
+func TestObjectService_PutObject_ClientGone(t *testing.T) {
+	engine := createTestEngine(t)
+	service := NewService(NewMemoryRepository(), engine)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	data := bytes.Repeat([]byte("x"), 64*1024)
+	body := &cancelingReader{r: bytes.NewReader(data), cancel: cancel}
+
+	if _, err := service.PutObject(ctx, "bucket", "key", body, int64(len(data)), "text/plain"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("PutObject() error = %v, want context.Canceled", err)
+	}
+	if used := engine.Stats().UsedBytes; used != 0 {
+		t.Errorf("UsedBytes = %d after canceled upload, want the allocation released", used)
+	}
+	if _, err := service.HeadObject(context.Background(), "bucket", "key", nil); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("HeadObject() error = %v, want ErrObjectNotFound", err)
+	}
+}
+
+func TestReplicationManifest(t *testing.T) {
+	obj := &Object{
+		ETag: "abc",
+		Parts: []PartInfo{
+			{PartNumber: 1, Size: 10},
+			{PartNumber: 3, Size: 10},
+			{PartNumber: 4, Size: 2},
+		},
+	}
+
+	manifest := replicationManifest(obj)
+	if manifest.ETag != "abc" || len(manifest.Parts) != 3 {
+		t.Fatalf("manifest = %+v, want 3 parts of object abc", manifest)
+	}
+	for i, want := range []int64{0, 10, 20} {
+		if manifest.Parts[i].Offset != want {
+			t.Errorf("part %d offset = %d, want %d", i, manifest.Parts[i].Offset, want)
+		}
+	}
+	if manifest.Parts[1].PartNumber != 3 {
+		t.Errorf("part number = %d, want 3", manifest.Parts[1].PartNumber)
+	}
+}
+
+func TestObjectService_ApplyReplicationPatch(t *testing.T) {
+	service := NewService(NewMemoryRepository(), createTestEngine(t))
+	ctx := context.Background()
+
+	base := bytes.Repeat([]byte("0123456789abcdef"), 512)
+	current, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(base), int64(len(base)), "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	target := append([]byte(nil), base...)
+	copy(target[4000:], "patched")
+	sum := md5.Sum(target)
+	patch := &replication.Patch{
+		Bucket:      "bucket",
+		Key:         "key",
+		BaseETag:    current.ETag,
+		ETag:        hex.EncodeToString(sum[:]),
+		Size:        int64(len(target)),
+		ContentType: "text/plain",
+		Ops:         replication.ComputeDelta(base, target, 1024),
+	}
+
+	obj, err := service.ApplyReplicationPatch(ctx, patch)
+	if err != nil {
+		t.Fatalf("ApplyReplicationPatch() error = %v", err)
+	}
+	if obj.ETag != patch.ETag {
+		t.Errorf("ETag = %s, want %s", obj.ETag, patch.ETag)
+	}
+	_, reader, _ := service.GetObject(ctx, "bucket", "key", nil)
+	got, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(got, target) {
+		t.Error("patched object differs from target")
+	}
+
+	// The replica no longer holds the base version
+	if _, err := service.ApplyReplicationPatch(ctx, patch); !errors.Is(err, ErrPatchBaseMismatch) {
+		t.Errorf("error = %v, want ErrPatchBaseMismatch", err)
+	}
+
+	patch.BaseETag = obj.ETag
+	patch.ETag = "wrong"
+	if _, err := service.ApplyReplicationPatch(ctx, patch); !errors.Is(err, ErrPatchChecksum) {
+		t.Errorf("error = %v, want ErrPatchChecksum", err)
+	}
+}
+
+func TestObjectService_EvictObject(t *testing.T) {
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	moved, err := service.PutObject(ctx, "bucket", "moved", bytes.NewReader([]byte("v1")), 2, "")
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if err := service.EvictObject(ctx, moved); err != nil {
+		t.Fatalf("EvictObject() error = %v", err)
+	}
+	if _, err := service.GetObjectMetadata(ctx, "bucket", "moved"); err == nil {
+		t.Error("evicted object is still stored")
+	}
+
+	// An overwrite after the object was read is not evicted
+	old, _ := service.PutObject(ctx, "bucket", "changed", bytes.NewReader([]byte("v1")), 2, "")
+	service.PutObject(ctx, "bucket", "changed", bytes.NewReader([]byte("v2")), 2, "")
+	if err := service.EvictObject(ctx, old); !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("EvictObject() error = %v, want ErrObjectChanged", err)
+	}
+	if _, err := service.GetObjectMetadata(ctx, "bucket", "changed"); err != nil {
+		t.Errorf("overwritten object was evicted: %v", err)
+	}
+}
+
+// failingEngine simulates a device whose reads fail
+type failingEngine struct {
+	storage.Engine
+}
+
+func (e failingEngine) Read(ctx context.Context, offset, size int64) ([]byte, error) {
+	return nil, errors.New("input/output error")
+}
+
+func TestObjectService_ReadFailsOverToReplica(t *testing.T) {
+	repo := NewMemoryRepository()
+	engine := createTestEngine(t)
+	service := NewService(repo, engine)
+	ctx := context.Background()
+
+	data := []byte("hello replica")
+	obj, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader(data), int64(len(data)), "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	etag := obj.ETag
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+etag+`"`)
+		if r.Header.Get("Range") == "bytes=6-12" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[6:])
+			return
+		}
+		w.Write(data)
+	}))
+	defer remote.Close()
+
+	failing := NewService(repo, failingEngine{engine})
+	failing.SetReplicator(replication.NewReplicator(replication.Config{Enabled: true, RemoteURL: remote.URL}))
+
+	_, rc, err := failing.GetObject(ctx, "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("GetObject() data = %q, want %q", got, data)
+	}
+
+	_, rc, err = failing.GetObjectRange(ctx, "bucket", "key", nil, 6, 7)
+	if err != nil {
+		t.Fatalf("GetObjectRange() error = %v", err)
+	}
+	got, _ = io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "replica" {
+		t.Errorf("GetObjectRange() data = %q, want %q", got, "replica")
+	}
+
+	// A replica holding another version is not used
+	etag = "stale"
+	if _, _, err := failing.GetObject(ctx, "bucket", "key", nil); err == nil {
+		t.Error("GetObject() served a different version from the replica")
+	}
+}
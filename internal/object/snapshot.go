@@ -0,0 +1,263 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// ErrSnapshotReleased is returned when reading from a released snapshot
+var ErrSnapshotReleased = errors.New("snapshot released")
+
+// Snapshot is a read-only point-in-time view of the objects in a bucket.
+// The storage extents of its objects are pinned until Release, so objects
+// deleted or overwritten after the snapshot was taken stay readable through
+// it.
+type Snapshot struct {
+	ID        string
+	Bucket    string
+	CreatedAt time.Time
+	Objects   []*Object // In key order
+	Size      int64     // Total size of Objects
+
+	svc  *Service
+	once sync.Once
+
+	mu       sync.RWMutex
+	released bool
+}
+
+// Snapshot captures the current objects of a bucket. Release must be
+// called once the snapshot is no longer needed, or the storage of objects
+// deleted in the meantime is never reclaimed.
+func (s *Service) Snapshot(ctx context.Context, bucket string) (*Snapshot, error) {
+	snap := &Snapshot{
+		ID:        uuid.New().String(),
+		Bucket:    bucket,
+		CreatedAt: time.Now(),
+		svc:       s,
+	}
+
+	// Extents freed between listing an object and pinning it must not be
+	// read later, so frees are recorded while the walk runs
+	capture := s.pins.beginCapture()
+	err := s.WalkObjects(ctx, bucket, "", "", func(obj *Object) error {
+		if obj.DeleteMarker {
+			return nil
+		}
+		s.pinObject(obj)
+		snap.Objects = append(snap.Objects, obj)
+		return nil
+	})
+	freed := s.pins.endCapture(capture)
+	if err != nil {
+		for _, obj := range snap.Objects {
+			s.unpinObject(obj)
+		}
+		return nil, fmt.Errorf("failed to snapshot bucket %s: %w", bucket, err)
+	}
+
+	kept := snap.Objects[:0]
+	for _, obj := range snap.Objects {
+		if obj.freedIn(freed) {
+			s.unpinObject(obj)
+			continue
+		}
+		kept = append(kept, obj)
+		snap.Size += obj.Size
+	}
+	snap.Objects = kept
+
+	return snap, nil
+}
+
+// ReadAt reads up to len(p) bytes of obj starting at off. obj must be one
+// of the snapshot's objects.
+func (sn *Snapshot) ReadAt(obj *Object, p []byte, off int64) (int, error) {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
+	if sn.released {
+		return 0, ErrSnapshotReleased
+	}
+
+	if off >= obj.Size {
+		return 0, nil
+	}
+	length := min(int64(len(p)), obj.Size-off)
+	data, err := sn.svc.readRange(context.Background(), obj, off, length)
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, data), nil
+}
+
+// Release unpins the snapshot's storage, freeing extents of objects that
+// were deleted since it was taken. Reads started before Release complete
+// first; later reads fail with ErrSnapshotReleased.
+func (sn *Snapshot) Release() {
+	sn.once.Do(func() {
+		sn.mu.Lock()
+		sn.released = true
+		sn.mu.Unlock()
+
+		for _, obj := range sn.Objects {
+			sn.svc.unpinObject(obj)
+		}
+	})
+}
+
+// openReplicationSource opens the current version of an object for the
+// replicator to stream. Its extent is pinned like a snapshot's, so it
+// stays readable until the source is closed even if the object is
+// overwritten or deleted meanwhile.
+func (s *Service) openReplicationSource(ctx context.Context, event replication.Event) (replication.ObjectSource, error) {
+	capture := s.pins.beginCapture()
+	obj, _, err := s.repo.Get(ctx, event.Bucket, event.Key, nil)
+	if err == nil && obj.DeleteMarker {
+		err = ErrObjectNotFound
+	}
+	if err == nil {
+		s.pinObject(obj)
+	}
+	freed := s.pins.endCapture(capture)
+	if err != nil {
+		return nil, err
+	}
+	if obj.freedIn(freed) {
+		s.unpinObject(obj)
+		return nil, fmt.Errorf("object %s/%s was replaced while being opened", event.Bucket, event.Key)
+	}
+	return &replicationSource{svc: s, obj: obj}, nil
+}
+
+// replicationSource reads a pinned object through its read pipeline, so
+// replicas receive plaintext
+type replicationSource struct {
+	svc  *Service
+	obj  *Object
+	once sync.Once
+}
+
+func (r *replicationSource) Size() int64 {
+	return r.obj.Size
+}
+
+func (r *replicationSource) ReadAt(start, length int64) ([]byte, error) {
+	return r.svc.readRange(context.Background(), r.obj, start, length)
+}
+
+func (r *replicationSource) Close() {
+	r.once.Do(func() { r.svc.unpinObject(r.obj) })
+}
+
+// free releases a storage extent, deferring it while a snapshot pins it.
+// Extents shared with bucket clones or other versions are only freed by
+// their last object.
+func (s *Service) free(offset, size int64) error {
+	// Empty objects share offset 0 with no extent behind it, so they must
+	// not touch the pin of a real extent there
+	if size == 0 {
+		return nil
+	}
+	if s.refs.release(offset) {
+		return nil
+	}
+	if s.pins.deferFree(offset, size) {
+		return nil
+	}
+	return s.engine.Free(offset, size)
+}
+
+// unpin drops a snapshot pin, freeing the extent if it was deleted while
+// pinned
+func (s *Service) unpin(offset int64) {
+	size, ok := s.pins.unpin(offset)
+	if !ok {
+		return
+	}
+	if err := s.engine.Free(offset, size); err != nil {
+		monitoring.Log.Warn("Failed to free storage released by snapshot",
+			zap.Int64("offset", offset),
+			zap.Int64("size", size),
+			zap.Error(err))
+	}
+}
+
+// pinTracker counts snapshot pins on storage extents, keyed by offset
+type pinTracker struct {
+	mu       sync.Mutex
+	pins     map[int64]int
+	deferred map[int64]int64         // Offset to size of pinned extents already deleted
+	captures map[*int]map[int64]bool // Extents freed during each running capture
+}
+
+func newPinTracker() *pinTracker {
+	return &pinTracker{
+		pins:     make(map[int64]int),
+		deferred: make(map[int64]int64),
+		captures: make(map[*int]map[int64]bool),
+	}
+}
+
+func (t *pinTracker) pin(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pins[offset]++
+}
+
+// unpin drops a pin and reports whether the extent must now be freed
+func (t *pinTracker) unpin(offset int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pins[offset]--
+	if t.pins[offset] > 0 {
+		return 0, false
+	}
+	delete(t.pins, offset)
+
+	size, ok := t.deferred[offset]
+	delete(t.deferred, offset)
+	return size, ok
+}
+
+// deferFree reports whether the extent is pinned, in which case freeing it
+// is left to the last unpin
+func (t *pinTracker) deferFree(offset, size int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pins[offset] > 0 {
+		t.deferred[offset] = size
+		return true
+	}
+	for _, freed := range t.captures {
+		freed[offset] = true
+	}
+	return false
+}
+
+func (t *pinTracker) beginCapture() *int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := new(int)
+	t.captures[id] = make(map[int64]bool)
+	return id
+}
+
+// endCapture returns the extents freed since beginCapture
+func (t *pinTracker) endCapture(id *int) map[int64]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	freed := t.captures[id]
+	delete(t.captures, id)
+	return freed
+}
@@ -0,0 +1,147 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/danielino/comio/internal/replication"
+	"github.com/danielino/comio/internal/storage"
+)
+
+// freeRecordingEngine records the extents freed through it
+type freeRecordingEngine struct {
+	storage.Engine
+	freed []int64
+}
+
+func (e *freeRecordingEngine) Free(offset, size int64) error {
+	e.freed = append(e.freed, offset)
+	return e.Engine.Free(offset, size)
+}
+
+func TestSnapshot_PointInTime(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	service := NewService(NewMemoryRepository(), engine)
+	ctx := context.Background()
+
+	for key, data := range map[string]string{"a.txt": "first", "b.txt": "second"} {
+		if _, err := service.PutObject(ctx, "bucket", key, bytes.NewReader([]byte(data)), int64(len(data)), "text/plain"); err != nil {
+			t.Fatalf("PutObject(%s) failed: %v", key, err)
+		}
+	}
+
+	snap, err := service.Snapshot(ctx, "bucket")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snap.Objects) != 2 || snap.Objects[0].Key != "a.txt" || snap.Size != 11 {
+		t.Fatalf("snapshot has %d objects of %d bytes, want a.txt and b.txt of 11", len(snap.Objects), snap.Size)
+	}
+	deleted := snap.Objects[0]
+
+	if err := service.DeleteObject(ctx, "bucket", "a.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := service.PutObject(ctx, "bucket", "c.txt", bytes.NewReader([]byte("third")), 5, "text/plain"); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if len(engine.freed) != 0 {
+		t.Errorf("freed %v while the snapshot pins them", engine.freed)
+	}
+
+	buf := make([]byte, 16)
+	n, err := snap.ReadAt(deleted, buf, 1)
+	if err != nil || string(buf[:n]) != "irst" {
+		t.Errorf("ReadAt of deleted object = %q, %v; want irst", buf[:n], err)
+	}
+	if len(snap.Objects) != 2 {
+		t.Errorf("snapshot gained objects: %d", len(snap.Objects))
+	}
+
+	snap.Release()
+	if len(engine.freed) != 1 || engine.freed[0] != deleted.Offset {
+		t.Errorf("after Release freed %v, want the deleted object's extent %d", engine.freed, deleted.Offset)
+	}
+	if _, err := snap.ReadAt(deleted, buf, 0); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("ReadAt after Release error = %v, want ErrSnapshotReleased", err)
+	}
+
+	snap.Release() // Idempotent
+	if len(engine.freed) != 1 {
+		t.Errorf("second Release freed again: %v", engine.freed)
+	}
+}
+
+func TestSnapshot_OverlappingPins(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	service := NewService(NewMemoryRepository(), engine)
+	ctx := context.Background()
+
+	if _, err := service.PutObject(ctx, "bucket", "key", bytes.NewReader([]byte("data")), 4, "text/plain"); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	first, err := service.Snapshot(ctx, "bucket")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	second, err := service.Snapshot(ctx, "bucket")
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	if err := service.DeleteObject(ctx, "bucket", "key"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	first.Release()
+	if len(engine.freed) != 0 {
+		t.Errorf("freed %v while the second snapshot pins it", engine.freed)
+	}
+	second.Release()
+	if len(engine.freed) != 1 {
+		t.Errorf("after releasing both snapshots freed %v, want one extent", engine.freed)
+	}
+}
+
+func TestOpenReplicationSource(t *testing.T) {
+	engine := &freeRecordingEngine{Engine: createTestEngine(t)}
+	service := NewService(NewMemoryRepository(), engine)
+	ctx := context.Background()
+
+	obj, err := service.PutObject(ctx, "bucket", "a.txt", bytes.NewReader([]byte("replicated")), 10, "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	event := replication.Event{Type: replication.EventPutObject, Bucket: "bucket", Key: "a.txt"}
+	src, err := service.openReplicationSource(ctx, event)
+	if err != nil {
+		t.Fatalf("openReplicationSource failed: %v", err)
+	}
+	if src.Size() != 10 {
+		t.Errorf("Size() = %d, want 10", src.Size())
+	}
+
+	// The data stays readable while the replicator streams it
+	if err := service.DeleteObject(ctx, "bucket", "a.txt"); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if len(engine.freed) != 0 {
+		t.Errorf("freed %v while the replicator reads it", engine.freed)
+	}
+	data, err := src.ReadAt(2, 6)
+	if err != nil || string(data) != "plicat" {
+		t.Errorf("ReadAt() = %q, %v; want plicat", data, err)
+	}
+
+	src.Close()
+	src.Close()
+	if len(engine.freed) != 1 || engine.freed[0] != obj.Offset {
+		t.Errorf("after Close freed %v, want the object's extent %d", engine.freed, obj.Offset)
+	}
+
+	if _, err := service.openReplicationSource(ctx, event); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("openReplicationSource of deleted object error = %v, want ErrObjectNotFound", err)
+	}
+}
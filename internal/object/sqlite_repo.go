@@ -6,12 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"strings"
+	"time"
 
 	"github.com/danielino/comio/internal/database"
 	"github.com/danielino/comio/internal/integrity"
 )
 
+// keyPrefixClause matches keys starting with a prefix, given its length in
+// bytes and the prefix itself. LIKE would treat % and _ in the prefix as
+// wildcards and ignore ASCII case.
+const keyPrefixClause = "substr(CAST(key AS BLOB), 1, ?) = CAST(? AS BLOB)"
+
 // SQLiteRepository implements Repository using SQLite
 type SQLiteRepository struct {
 	db *database.DB
@@ -29,7 +34,18 @@ func (r *SQLiteRepository) Put(ctx context.Context, obj *Object, data io.Reader)
 	// For SQLite repository, we only store metadata
 	// The actual data is stored in the storage engine
 	// data parameter is ignored - it's for compatibility with the interface
+	err := r.db.WithTx(ctx, func(tx *database.Tx) error {
+		return putObjectTx(ctx, tx, obj)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
 
+	return nil
+}
+
+// putObjectTx inserts a version of an object in tx
+func putObjectTx(ctx context.Context, tx *database.Tx, obj *Object) error {
 	// Serialize user metadata to JSON (if any)
 	var metadataJSON []byte
 	if obj.Metadata != nil {
@@ -40,15 +56,60 @@ func (r *SQLiteRepository) Put(ctx context.Context, obj *Object, data io.Reader)
 		}
 	}
 
+	var encryptionJSON []byte
+	if obj.Encryption != nil {
+		var err error
+		encryptionJSON, err = json.Marshal(obj.Encryption)
+		if err != nil {
+			return fmt.Errorf("failed to marshal encryption: %w", err)
+		}
+	}
+
+	var chunksJSON []byte
+	if obj.Chunks != nil {
+		var err error
+		chunksJSON, err = json.Marshal(obj.Chunks)
+		if err != nil {
+			return fmt.Errorf("failed to marshal chunk checksums: %w", err)
+		}
+	}
+
+	var extentsJSON []byte
+	if obj.Extents != nil {
+		var err error
+		extentsJSON, err = json.Marshal(obj.Extents)
+		if err != nil {
+			return fmt.Errorf("failed to marshal extents: %w", err)
+		}
+	}
+
 	query := `
 		INSERT OR REPLACE INTO objects (
 			bucket_name, key, version_id, size, content_type, etag,
 			checksum_algorithm, checksum_value, storage_offset,
-			created_at, modified_at, metadata
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			created_at, modified_at, metadata, encryption, chunk_checksums, sealed, shared, extents, owner, storage_class,
+			delete_marker, replicated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := r.db.ExecWithRetry(ctx, query,
+	// The latest version is the one created last. A version put in the
+	// same instant as the current one would tie with it, so it is moved
+	// just after it; reading the current version and inserting are one
+	// transaction so no other put slips in between. Versions created
+	// earlier, such as replicated ones arriving out of order, keep their
+	// time.
+	var latest sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		"SELECT created_at FROM objects WHERE bucket_name = ? AND key = ? AND version_id != ? ORDER BY created_at DESC LIMIT 1",
+		obj.BucketName, obj.Key, obj.VersionID).Scan(&latest)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if latest.Valid && obj.CreatedAt.Equal(latest.Time) {
+		obj.CreatedAt = latest.Time.Add(time.Microsecond)
+	}
+
+	_, err = tx.ExecContext(ctx, query,
 		obj.BucketName,
 		obj.Key,
 		obj.VersionID,
@@ -61,13 +122,17 @@ func (r *SQLiteRepository) Put(ctx context.Context, obj *Object, data io.Reader)
 		obj.CreatedAt,
 		obj.ModifiedAt,
 		metadataJSON,
+		encryptionJSON,
+		chunksJSON,
+		obj.Sealed,
+		obj.Shared,
+		extentsJSON,
+		obj.Owner,
+		obj.StorageClass,
+		obj.DeleteMarker,
+		obj.ReplicatedAt,
 	)
-
-	if err != nil {
-		return fmt.Errorf("failed to put object: %w", err)
-	}
-
-	return nil
+	return err
 }
 
 // Get retrieves an object metadata (returns nil for data - data is in storage engine)
@@ -75,7 +140,8 @@ func (r *SQLiteRepository) Get(ctx context.Context, bucket, key string, versionI
 	query := `
 		SELECT bucket_name, key, version_id, size, content_type, etag,
 		       checksum_algorithm, checksum_value, storage_offset,
-		       created_at, modified_at, metadata
+		       created_at, modified_at, metadata, encryption, chunk_checksums, sealed, shared, extents, owner, storage_class,
+		       delete_marker, replicated_at
 		FROM objects
 		WHERE bucket_name = ? AND key = ?
 	`
@@ -92,8 +158,9 @@ func (r *SQLiteRepository) Get(ctx context.Context, bucket, key string, versionI
 	}
 
 	obj := &Object{}
-	var metadataJSON []byte
+	var metadataJSON, encryptionJSON, chunksJSON, extentsJSON []byte
 	var checksumAlg, checksumVal sql.NullString
+	var replicatedAt sql.NullTime
 
 	err := r.db.QueryRowContext(ctx, query, args...).Scan(
 		&obj.BucketName,
@@ -108,10 +175,22 @@ func (r *SQLiteRepository) Get(ctx context.Context, bucket, key string, versionI
 		&obj.CreatedAt,
 		&obj.ModifiedAt,
 		&metadataJSON,
+		&encryptionJSON,
+		&chunksJSON,
+		&obj.Sealed,
+		&obj.Shared,
+		&extentsJSON,
+		&obj.Owner,
+		&obj.StorageClass,
+		&obj.DeleteMarker,
+		&replicatedAt,
 	)
 
 	if err == sql.ErrNoRows {
-		return nil, nil, fmt.Errorf("object not found")
+		if versionID != nil && *versionID != "" {
+			return nil, nil, ErrVersionNotFound
+		}
+		return nil, nil, ErrObjectNotFound
 	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get object: %w", err)
@@ -124,6 +203,9 @@ func (r *SQLiteRepository) Get(ctx context.Context, bucket, key string, versionI
 			Value:     checksumVal.String,
 		}
 	}
+	if replicatedAt.Valid {
+		obj.ReplicatedAt = &replicatedAt.Time
+	}
 
 	// Deserialize metadata into object
 	if len(metadataJSON) > 0 {
@@ -131,6 +213,15 @@ func (r *SQLiteRepository) Get(ctx context.Context, bucket, key string, versionI
 			return nil, nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
 		}
 	}
+	if err := unmarshalEncryption(obj, encryptionJSON); err != nil {
+		return nil, nil, err
+	}
+	if err := unmarshalChunks(obj, chunksJSON); err != nil {
+		return nil, nil, err
+	}
+	if err := unmarshalExtents(obj, extentsJSON); err != nil {
+		return nil, nil, err
+	}
 
 	// Return nil for data - the actual object data is in the storage engine
 	// The service layer will fetch it using obj.Offset and obj.Size
@@ -139,11 +230,65 @@ func (r *SQLiteRepository) Get(ctx context.Context, bucket, key string, versionI
 
 // List lists objects in a bucket with pagination
 func (r *SQLiteRepository) List(ctx context.Context, bucket, prefix string, opts ListOptions) (*ListResult, error) {
+	query, args := latestQuery(bucket, prefix, opts.StartAfter)
+
+	// Limit
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	if maxKeys > MaxKeysLimit {
+		maxKeys = MaxKeysLimit
+	}
+
+	// Fetch one extra to determine if truncated
+	query += " LIMIT ?"
+	args = append(args, maxKeys+1)
+
+	var objects []*Object
+	err := r.scanLatest(ctx, query, args, func(obj *Object) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if truncated
+	isTruncated := len(objects) > maxKeys
+	if isTruncated {
+		objects = objects[:maxKeys]
+	}
+
+	result := &ListResult{
+		Objects:     objects,
+		IsTruncated: isTruncated,
+	}
+
+	if isTruncated && len(objects) > 0 {
+		result.NextMarker = objects[len(objects)-1].Key
+	}
+
+	// Handle common prefixes for delimiter
+	result.Objects, result.CommonPrefixes = groupCommonPrefixes(objects, prefix, opts.Delimiter)
+
+	return result, nil
+}
+
+// Walk streams the latest version of each key from a single query
+func (r *SQLiteRepository) Walk(ctx context.Context, bucket, prefix, startAfter string, fn IterateFunc) error {
+	query, args := latestQuery(bucket, prefix, startAfter)
+	return r.scanLatest(ctx, query, args, fn)
+}
+
+// latestQuery selects the latest version of each key of a bucket with
+// prefix and after startAfter, in key order, leaving out deleted keys
+func latestQuery(bucket, prefix, startAfter string) (string, []interface{}) {
 	// Base query - get latest version of each object
 	query := `
 		SELECT o1.bucket_name, o1.key, o1.version_id, o1.size, o1.content_type,
 		       o1.etag, o1.checksum_algorithm, o1.checksum_value, o1.storage_offset,
-		       o1.created_at, o1.modified_at
+		       o1.created_at, o1.modified_at, o1.encryption, o1.chunk_checksums, o1.sealed, o1.shared, o1.extents, o1.owner, o1.storage_class
 		FROM objects o1
 		INNER JOIN (
 			SELECT bucket_name, key, MAX(created_at) as max_created
@@ -155,8 +300,8 @@ func (r *SQLiteRepository) List(ctx context.Context, bucket, prefix string, opts
 
 	// Add prefix filter
 	if prefix != "" {
-		query += " AND key LIKE ?"
-		args = append(args, prefix+"%")
+		query += " AND " + keyPrefixClause
+		args = append(args, len(prefix), prefix)
 	}
 
 	query += `
@@ -164,38 +309,32 @@ func (r *SQLiteRepository) List(ctx context.Context, bucket, prefix string, opts
 		) o2 ON o1.bucket_name = o2.bucket_name
 		   AND o1.key = o2.key
 		   AND o1.created_at = o2.max_created
+		   AND o1.delete_marker = FALSE
 	`
 
 	// Add pagination
-	if opts.StartAfter != "" {
+	if startAfter != "" {
 		query += " AND o1.key > ?"
-		args = append(args, opts.StartAfter)
+		args = append(args, startAfter)
 	}
 
-	query += " ORDER BY o1.key"
-
-	// Limit
-	maxKeys := opts.MaxKeys
-	if maxKeys <= 0 {
-		maxKeys = DefaultMaxKeys
-	}
-	if maxKeys > MaxKeysLimit {
-		maxKeys = MaxKeysLimit
-	}
-
-	// Fetch one extra to determine if truncated
-	query += " LIMIT ?"
-	args = append(args, maxKeys+1)
+	query += " ORDER BY o1.key, o1.version_id DESC"
+	return query, args
+}
 
+// scanLatest runs a latestQuery and passes each key's object to fn as
+// its row is read
+func (r *SQLiteRepository) scanLatest(ctx context.Context, query string, args []interface{}, fn IterateFunc) error {
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
+		return fmt.Errorf("failed to list objects: %w", err)
 	}
 	defer rows.Close()
 
-	var objects []*Object
+	var last string
 	for rows.Next() {
 		obj := &Object{}
+		var encryptionJSON, chunksJSON, extentsJSON []byte
 		var checksumAlg, checksumVal sql.NullString
 
 		err := rows.Scan(
@@ -210,9 +349,25 @@ func (r *SQLiteRepository) List(ctx context.Context, bucket, prefix string, opts
 			&obj.Offset,
 			&obj.CreatedAt,
 			&obj.ModifiedAt,
+			&encryptionJSON,
+			&chunksJSON,
+			&obj.Sealed,
+			&obj.Shared,
+			&extentsJSON,
+			&obj.Owner,
+			&obj.StorageClass,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan object: %w", err)
+			return fmt.Errorf("failed to scan object: %w", err)
+		}
+		if err := unmarshalEncryption(obj, encryptionJSON); err != nil {
+			return err
+		}
+		if err := unmarshalChunks(obj, chunksJSON); err != nil {
+			return err
+		}
+		if err := unmarshalExtents(obj, extentsJSON); err != nil {
+			return err
 		}
 
 		// Set checksum if present
@@ -223,58 +378,21 @@ func (r *SQLiteRepository) List(ctx context.Context, bucket, prefix string, opts
 			}
 		}
 
-		objects = append(objects, obj)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating objects: %w", err)
-	}
-
-	// Check if truncated
-	isTruncated := len(objects) > maxKeys
-	if isTruncated {
-		objects = objects[:maxKeys]
-	}
-
-	result := &ListResult{
-		Objects:     objects,
-		IsTruncated: isTruncated,
-	}
-
-	if isTruncated && len(objects) > 0 {
-		result.NextMarker = objects[len(objects)-1].Key
-	}
-
-	// Handle common prefixes for delimiter
-	if opts.Delimiter != "" {
-		result.CommonPrefixes = r.extractCommonPrefixes(objects, prefix, opts.Delimiter)
-	}
-
-	return result, nil
-}
-
-// extractCommonPrefixes extracts common prefixes based on delimiter
-func (r *SQLiteRepository) extractCommonPrefixes(objects []*Object, prefix, delimiter string) []string {
-	prefixMap := make(map[string]bool)
-
-	for _, obj := range objects {
-		key := obj.Key
-		if prefix != "" {
-			key = strings.TrimPrefix(key, prefix)
+		// Versions written in the same instant tie on created_at; the key
+		// is listed once
+		if last != "" && obj.Key == last {
+			continue
 		}
-
-		if idx := strings.Index(key, delimiter); idx >= 0 {
-			commonPrefix := prefix + key[:idx+len(delimiter)]
-			prefixMap[commonPrefix] = true
+		last = obj.Key
+		if err := fn(obj); err != nil {
+			return err
 		}
 	}
 
-	var prefixes []string
-	for p := range prefixMap {
-		prefixes = append(prefixes, p)
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating objects: %w", err)
 	}
-
-	return prefixes
+	return nil
 }
 
 // Delete deletes an object
@@ -298,30 +416,126 @@ func (r *SQLiteRepository) Delete(ctx context.Context, bucket, key string, versi
 	}
 
 	if rows == 0 {
-		return fmt.Errorf("object not found")
+		return ErrObjectNotFound
 	}
 
 	return nil
 }
 
+// UpdateMetadata rewrites the row of the latest version in a single
+// statement, which matches nothing if that is no longer versionID
+func (r *SQLiteRepository) UpdateMetadata(ctx context.Context, obj *Object, versionID string) error {
+	var metadataJSON []byte
+	if obj.Metadata != nil {
+		var err error
+		metadataJSON, err = json.Marshal(obj.Metadata)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+	}
+
+	query := `
+		UPDATE objects
+		SET version_id = ?, content_type = ?, metadata = ?, modified_at = ?, replicated_at = ?, shared = ?
+		WHERE bucket_name = ? AND key = ? AND version_id = ?
+		  AND NOT EXISTS (
+			SELECT 1 FROM objects newer
+			WHERE newer.bucket_name = objects.bucket_name
+			  AND newer.key = objects.key
+			  AND newer.created_at > objects.created_at
+		  )
+	`
+	// The update and telling why it matched nothing are one transaction
+	return r.db.WithTx(ctx, func(tx *database.Tx) error {
+		result, err := tx.ExecContext(ctx, query,
+			obj.VersionID, obj.ContentType, metadataJSON, obj.ModifiedAt, obj.ReplicatedAt, obj.Shared,
+			obj.BucketName, obj.Key, versionID)
+		if err != nil {
+			return fmt.Errorf("failed to update object metadata: %w", err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rows > 0 {
+			return nil
+		}
+
+		// Nothing matched: tell a missing key from a newer version
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM objects WHERE bucket_name = ? AND key = ?)",
+			obj.BucketName, obj.Key).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+		if !exists {
+			return ErrObjectNotFound
+		}
+		return ErrObjectChanged
+	})
+}
+
+// Move removes the version from src's key and inserts dst in one
+// transaction. Without a marker the older versions of src's key go too.
+func (r *SQLiteRepository) Move(ctx context.Context, src, dst, marker *Object) error {
+	return r.db.WithTx(ctx, func(tx *database.Tx) error {
+		var latest string
+		err := tx.QueryRowContext(ctx,
+			"SELECT version_id FROM objects WHERE bucket_name = ? AND key = ? ORDER BY created_at DESC LIMIT 1",
+			src.BucketName, src.Key).Scan(&latest)
+		if err == sql.ErrNoRows {
+			return ErrObjectNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+		if latest != src.VersionID {
+			return ErrObjectChanged
+		}
+
+		query, args := "DELETE FROM objects WHERE bucket_name = ? AND key = ?", []interface{}{src.BucketName, src.Key}
+		if marker != nil {
+			query, args = query+" AND version_id = ?", append(args, src.VersionID)
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to delete object: %w", err)
+		}
+
+		if err := putObjectTx(ctx, tx, dst); err != nil {
+			return fmt.Errorf("failed to put object: %w", err)
+		}
+		if marker != nil {
+			if err := putObjectTx(ctx, tx, marker); err != nil {
+				return fmt.Errorf("failed to put delete marker: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
 // DeleteAll deletes all objects in a bucket
 func (r *SQLiteRepository) DeleteAll(ctx context.Context, bucket string) (int, int64, error) {
-	// First get count and total size
+	// Count and delete in one transaction, so objects put in between are
+	// neither left behind uncounted nor counted twice. Every version's
+	// space is freed, but a key with several versions is one object.
 	var count int
 	var totalSize int64
 
-	err := r.db.QueryRowContext(ctx,
-		"SELECT COUNT(*), COALESCE(SUM(size), 0) FROM objects WHERE bucket_name = ?",
-		bucket).Scan(&count, &totalSize)
-
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to count objects: %w", err)
-	}
+	err := r.db.WithTx(ctx, func(tx *database.Tx) error {
+		if err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(DISTINCT key), COALESCE(SUM(size), 0) FROM objects WHERE bucket_name = ?",
+			bucket).Scan(&count, &totalSize); err != nil {
+			return fmt.Errorf("failed to count objects: %w", err)
+		}
 
-	// Delete all objects
-	_, err = r.db.ExecWithRetry(ctx, "DELETE FROM objects WHERE bucket_name = ?", bucket)
+		if _, err := tx.ExecContext(ctx, "DELETE FROM objects WHERE bucket_name = ?", bucket); err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to delete objects: %w", err)
+		return 0, 0, err
 	}
 
 	return count, totalSize, nil
@@ -332,9 +546,20 @@ func (r *SQLiteRepository) Count(ctx context.Context, bucket string) (int, int64
 	var count int
 	var totalSize int64
 
-	err := r.db.QueryRowContext(ctx,
-		"SELECT COUNT(*), COALESCE(SUM(size), 0) FROM objects WHERE bucket_name = ?",
-		bucket).Scan(&count, &totalSize)
+	// Counts the keys List returns, those whose latest version is not a
+	// delete marker; the size covers every version stored
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT o1.key) FROM objects o1
+			 WHERE o1.bucket_name = ? AND o1.delete_marker = FALSE
+			   AND NOT EXISTS (
+				SELECT 1 FROM objects newer
+				WHERE newer.bucket_name = o1.bucket_name
+				  AND newer.key = o1.key
+				  AND newer.created_at > o1.created_at
+			   )),
+			(SELECT COALESCE(SUM(size), 0) FROM objects WHERE bucket_name = ?)
+	`, bucket, bucket).Scan(&count, &totalSize)
 
 	if err != nil {
 		return 0, 0, fmt.Errorf("failed to count objects: %w", err)
@@ -350,3 +575,121 @@ func (r *SQLiteRepository) Head(ctx context.Context, bucket, key string, version
 	obj, _, err := r.Get(ctx, bucket, key, versionID)
 	return obj, err
 }
+
+// Iterate streams every stored object row (all versions) in a bucket
+func (r *SQLiteRepository) Iterate(ctx context.Context, bucket, prefix string, fn IterateFunc) error {
+	query := `
+		SELECT bucket_name, key, version_id, size, content_type, etag,
+		       checksum_algorithm, checksum_value, storage_offset,
+		       created_at, modified_at, encryption, chunk_checksums, sealed, shared, extents, owner, storage_class,
+		       delete_marker, replicated_at
+		FROM objects
+		WHERE bucket_name = ?
+	`
+	args := []interface{}{bucket}
+
+	if prefix != "" {
+		query += " AND " + keyPrefixClause
+		args = append(args, len(prefix), prefix)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to iterate objects: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		obj := &Object{}
+		var encryptionJSON, chunksJSON, extentsJSON []byte
+		var checksumAlg, checksumVal sql.NullString
+		var replicatedAt sql.NullTime
+
+		if err := rows.Scan(
+			&obj.BucketName,
+			&obj.Key,
+			&obj.VersionID,
+			&obj.Size,
+			&obj.ContentType,
+			&obj.ETag,
+			&checksumAlg,
+			&checksumVal,
+			&obj.Offset,
+			&obj.CreatedAt,
+			&obj.ModifiedAt,
+			&encryptionJSON,
+			&chunksJSON,
+			&obj.Sealed,
+			&obj.Shared,
+			&extentsJSON,
+			&obj.Owner,
+			&obj.StorageClass,
+			&obj.DeleteMarker,
+			&replicatedAt,
+		); err != nil {
+			return fmt.Errorf("failed to scan object: %w", err)
+		}
+		if err := unmarshalEncryption(obj, encryptionJSON); err != nil {
+			return err
+		}
+		if err := unmarshalChunks(obj, chunksJSON); err != nil {
+			return err
+		}
+		if err := unmarshalExtents(obj, extentsJSON); err != nil {
+			return err
+		}
+
+		if checksumAlg.Valid && checksumVal.Valid {
+			obj.Checksum = integrity.Checksum{
+				Algorithm: checksumAlg.String,
+				Value:     checksumVal.String,
+			}
+		}
+		if replicatedAt.Valid {
+			obj.ReplicatedAt = &replicatedAt.Time
+		}
+
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating objects: %w", err)
+	}
+
+	return nil
+}
+
+// unmarshalEncryption sets the encryption envelope of obj from its column
+func unmarshalEncryption(obj *Object, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &obj.Encryption); err != nil {
+		return fmt.Errorf("failed to unmarshal encryption: %w", err)
+	}
+	return nil
+}
+
+// unmarshalChunks sets the chunk checksums of obj from their column
+func unmarshalChunks(obj *Object, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &obj.Chunks); err != nil {
+		return fmt.Errorf("failed to unmarshal chunk checksums: %w", err)
+	}
+	return nil
+}
+
+// unmarshalExtents sets the runs of data of obj from their column
+func unmarshalExtents(obj *Object, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &obj.Extents); err != nil {
+		return fmt.Errorf("failed to unmarshal extents: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/notification"
+	"github.com/danielino/comio/internal/replication"
+)
+
+// ErrDeleteMarker is returned when the version read is a delete marker.
+// It wraps ErrObjectNotFound, as the object is deleted.
+var ErrDeleteMarker = fmt.Errorf("%w: delete marker", ErrObjectNotFound)
+
+// VersioningCheck reports whether versioning is enabled on a bucket
+type VersioningCheck func(ctx context.Context, bucket string) bool
+
+// SetVersioning tells the service which buckets have versioning enabled.
+// In those, deletes write delete markers while replication is enabled.
+func (s *Service) SetVersioning(check VersioningCheck) {
+	s.versioned = check
+}
+
+// UsesDeleteMarkers reports whether deletes in bucket write delete markers
+// rather than removing objects. Replicas are sent the marker, so a delete
+// and a concurrent write to the same key resolve the same way everywhere,
+// and versions stay restorable until CollectTombstones removes them.
+func (s *Service) UsesDeleteMarkers(ctx context.Context, bucket string) bool {
+	return s.versioned != nil && s.replicates(ctx, bucket) && s.replicator.Enabled() && s.versioned(ctx, bucket)
+}
+
+// PutDeleteMarker deletes an object by making a delete marker its latest
+// version, returning the marker. The data of the versions it hides is kept
+// by repositories storing versions, and freed at once by the others.
+func (s *Service) PutDeleteMarker(ctx context.Context, bucket, key string) (*Object, error) {
+	current, err := s.getObject(ctx, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	marker := &Object{
+		Key:          key,
+		BucketName:   bucket,
+		VersionID:    GenerateVersionID(),
+		CreatedAt:    now,
+		ModifiedAt:   now,
+		StorageClass: StorageClassStandard,
+		Owner:        actorFromContext(ctx).id,
+		DeleteMarker: true,
+	}
+	if err := s.repo.Put(ctx, marker, nil); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := s.repo.Get(ctx, bucket, key, &current.VersionID); errors.Is(err, ErrVersionNotFound) {
+		if err := s.freeObject(current); err != nil {
+			monitoring.Log.Warn("Failed to free storage for deleted object",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.Error(err))
+		}
+	}
+
+	s.recordHistory(ctx, HistoryDelete, marker, "delete marker")
+	s.publish(notification.EventObjectRemoved, current)
+
+	if s.replicates(ctx, bucket) {
+		s.replicator.QueueEvent(replication.Event{
+			Type:      replication.EventDeleteObject,
+			Bucket:    bucket,
+			Key:       key,
+			Timestamp: now,
+			Metadata: map[string]interface{}{
+				replication.MetadataDeleteMarker: marker.VersionID,
+			},
+		})
+	}
+
+	return marker, nil
+}
+
+// markReplicated records that a delete marker reached the replication
+// target, which makes it collectable. Markers no longer latest are left
+// alone; the object was written again.
+func (s *Service) markReplicated(event replication.Event) {
+	versionID, ok := event.Metadata[replication.MetadataDeleteMarker].(string)
+	if event.Type != replication.EventDeleteObject || !ok {
+		return
+	}
+
+	ctx := context.Background()
+	marker, _, err := s.repo.Get(ctx, event.Bucket, event.Key, nil)
+	if err != nil || !marker.DeleteMarker || marker.VersionID != versionID {
+		return
+	}
+
+	updated := *marker
+	now := time.Now()
+	updated.ReplicatedAt = &now
+	if err := s.repo.UpdateMetadata(ctx, &updated, versionID); err != nil && !errors.Is(err, ErrObjectChanged) {
+		monitoring.Log.Warn("Failed to mark delete marker replicated",
+			zap.String("bucket", event.Bucket),
+			zap.String("key", event.Key),
+			zap.Error(err))
+	}
+}
+
+// TombstoneResult summarises a CollectTombstones run
+type TombstoneResult struct {
+	Markers        int   `json:"markers"`         // Delete markers removed
+	Versions       int   `json:"versions"`        // Versions under them removed
+	ReclaimedBytes int64 `json:"reclaimed_bytes"` // Space freed
+	Pending        int   `json:"pending"`         // Old enough markers awaiting replication
+}
+
+// CollectTombstones permanently removes deleted objects of bucket: keys
+// whose latest version is a delete marker older than maxAge lose the
+// marker and every version under it. While replication is enabled only
+// markers the replication target acknowledged are collected, so a replica
+// never misses a delete.
+func (s *Service) CollectTombstones(ctx context.Context, bucket string, maxAge time.Duration) (TombstoneResult, error) {
+	var result TombstoneResult
+
+	deleted := make(map[string]bool)
+	err := s.repo.Iterate(ctx, bucket, "", func(obj *Object) error {
+		if obj.DeleteMarker {
+			deleted[obj.Key] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to scan bucket %s: %w", bucket, err)
+	}
+
+	keys := make([]string, 0, len(deleted))
+	for key := range deleted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cutoff := time.Now().Add(-maxAge)
+	awaitReplication := s.replicates(ctx, bucket) && s.replicator.Enabled()
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		latest, _, err := s.repo.Get(ctx, bucket, key, nil)
+		if err != nil || !latest.DeleteMarker || latest.ModifiedAt.After(cutoff) {
+			continue
+		}
+		if awaitReplication && latest.ReplicatedAt == nil {
+			result.Pending++
+			continue
+		}
+
+		if err := s.collectKey(ctx, bucket, key, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// collectKey removes every version of a deleted key, data first so a
+// failure leaves the key deleted
+func (s *Service) collectKey(ctx context.Context, bucket, key string, result *TombstoneResult) error {
+	var versions, markers []*Object
+	err := s.repo.Iterate(ctx, bucket, key, func(obj *Object) error {
+		switch {
+		case obj.Key != key:
+		case obj.DeleteMarker:
+			markers = append(markers, obj)
+		default:
+			versions = append(versions, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list versions of %s/%s: %w", bucket, key, err)
+	}
+
+	// Versions written after the scan are not collected: they are
+	// deleted by version ID
+	for _, v := range versions {
+		if err := s.repo.Delete(ctx, bucket, key, &v.VersionID); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return err
+		}
+		if err := s.freeObject(v); err != nil {
+			monitoring.Log.Warn("Failed to free storage for collected version",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.String("version_id", v.VersionID),
+				zap.Error(err))
+		}
+		result.Versions++
+		result.ReclaimedBytes += v.Size
+	}
+	for _, m := range markers {
+		if err := s.repo.Delete(ctx, bucket, key, &m.VersionID); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return err
+		}
+		result.Markers++
+	}
+	return nil
+}
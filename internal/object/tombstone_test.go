@@ -0,0 +1,136 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/replication"
+)
+
+// newMarkerService returns a service writing delete markers in every
+// bucket, replicating to remoteURL
+func newMarkerService(t *testing.T, remoteURL string) (*Service, *replication.Replicator) {
+	t.Helper()
+
+	// Only the SQLite repository keeps the versions under a marker
+	service := NewService(testRepositories(t)["sqlite"], createTestEngine(t))
+	service.SetVersioning(func(ctx context.Context, bucket string) bool { return true })
+
+	config := replication.DefaultConfig()
+	config.Enabled = true
+	config.RemoteURL = remoteURL
+	config.LocalURL = remoteURL
+	config.RetryAttempts = 0
+	config.BatchInterval = 10 * time.Millisecond
+	config.DeltaEnabled = false
+	replicator := replication.NewReplicator(config)
+	service.SetReplicator(replicator)
+	return service, replicator
+}
+
+func TestService_DeleteMarkers(t *testing.T) {
+	ctx := context.Background()
+
+	var markerDeletes atomic.Int32
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && r.Header.Get(replication.HeaderDeleteMarker) == "true" {
+			markerDeletes.Add(1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer remote.Close()
+
+	service, replicator := newMarkerService(t, remote.URL)
+	if err := replicator.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer replicator.Stop()
+
+	put, err := service.PutObject(ctx, "iter-bucket", "gone", strings.NewReader("hello"), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if err := service.DeleteObject(ctx, "iter-bucket", "gone"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+
+	if _, _, err := service.GetObject(ctx, "iter-bucket", "gone", nil); !errors.Is(err, ErrDeleteMarker) || !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetObject() error = %v, want ErrDeleteMarker", err)
+	}
+	if _, err := service.HeadObject(ctx, "iter-bucket", "gone", &put.VersionID); err != nil {
+		t.Errorf("HeadObject(hidden version) error = %v", err)
+	}
+	if listed, err := service.ListObjects(ctx, "iter-bucket", "", ListOptions{}); err != nil || len(listed.Objects) != 0 {
+		t.Errorf("ListObjects() = %+v, %v; want no objects", listed, err)
+	}
+
+	// Too young to collect
+	if result, err := service.CollectTombstones(ctx, "iter-bucket", time.Hour); err != nil || result.Markers != 0 {
+		t.Errorf("CollectTombstones(1h) = %+v, %v; want nothing collected", result, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		marker, _, err := service.repo.Get(ctx, "iter-bucket", "gone", nil)
+		if err == nil && marker.ReplicatedAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delete marker not marked replicated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if markerDeletes.Load() != 1 {
+		t.Errorf("remote received %d delete marker requests, want 1", markerDeletes.Load())
+	}
+
+	result, err := service.CollectTombstones(ctx, "iter-bucket", 0)
+	if err != nil {
+		t.Fatalf("CollectTombstones() error = %v", err)
+	}
+	if result.Markers != 1 || result.Versions != 1 || result.ReclaimedBytes != 5 || result.Pending != 0 {
+		t.Errorf("CollectTombstones() = %+v, want 1 marker and 1 version of 5 bytes", result)
+	}
+	if _, err := service.HeadObject(ctx, "iter-bucket", "gone", &put.VersionID); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("HeadObject(collected version) error = %v, want ErrVersionNotFound", err)
+	}
+
+	// The key can be written again
+	if _, err := service.PutObject(ctx, "iter-bucket", "gone", strings.NewReader("back"), 4, "text/plain"); err != nil {
+		t.Fatalf("PutObject(again) error = %v", err)
+	}
+	if _, err := service.HeadObject(ctx, "iter-bucket", "gone", nil); err != nil {
+		t.Errorf("HeadObject(again) error = %v", err)
+	}
+}
+
+func TestService_CollectTombstonesAwaitsReplication(t *testing.T) {
+	ctx := context.Background()
+
+	// The replicator is not started, so the marker is never replicated
+	service, _ := newMarkerService(t, "http://127.0.0.1:0")
+
+	if _, err := service.PutObject(ctx, "iter-bucket", "pending", strings.NewReader("data"), 4, "text/plain"); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if _, err := service.PutDeleteMarker(ctx, "iter-bucket", "pending"); err != nil {
+		t.Fatalf("PutDeleteMarker() error = %v", err)
+	}
+	if _, err := service.PutDeleteMarker(ctx, "iter-bucket", "pending"); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("PutDeleteMarker(deleted) error = %v, want ErrObjectNotFound", err)
+	}
+
+	result, err := service.CollectTombstones(ctx, "iter-bucket", 0)
+	if err != nil {
+		t.Fatalf("CollectTombstones() error = %v", err)
+	}
+	if result.Markers != 0 || result.Pending != 1 {
+		t.Errorf("CollectTombstones() = %+v, want 1 pending marker", result)
+	}
+}
@@ -1,6 +1,8 @@
 package object
 
 import (
+	"context"
+
 	"github.com/google/uuid"
 )
 
@@ -8,3 +10,17 @@ import (
 func GenerateVersionID() string {
 	return uuid.New().String()
 }
+
+// RestoreVersion makes an older version of an object current by copying
+// it as a new version; the version history is not rewritten. It returns
+// ErrVersionNotFound if the key has no such version, which is the case
+// for every version but the latest on repositories keeping only that.
+func (s *Service) RestoreVersion(ctx context.Context, bucket, key, versionID string) (*Object, error) {
+	version, data, err := s.GetObject(ctx, bucket, key, &versionID)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	return s.putObject(ctx, bucket, key, data, version.Size, version.ContentType, version.Metadata, version.Parts, nil, versionID)
+}
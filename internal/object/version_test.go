@@ -0,0 +1,64 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestService_RestoreVersion(t *testing.T) {
+	ctx := context.Background()
+
+	// Only the SQLite repository keeps older versions
+	repo := testRepositories(t)["sqlite"]
+	service := NewService(repo, createTestEngine(t))
+	service.SetHistory(NewMemoryHistoryStore(0))
+
+	v1, err := service.PutObjectWithMetadata(ctx, "iter-bucket", "report", strings.NewReader("first draft"), 11, "text/plain",
+		map[string]string{"x-amz-meta-status": "draft"})
+	if err != nil {
+		t.Fatalf("PutObject(v1) error = %v", err)
+	}
+	if _, err := service.PutObject(ctx, "iter-bucket", "report", strings.NewReader("final"), 5, "text/markdown"); err != nil {
+		t.Fatalf("PutObject(v2) error = %v", err)
+	}
+
+	restored, err := service.RestoreVersion(ctx, "iter-bucket", "report", v1.VersionID)
+	if err != nil {
+		t.Fatalf("RestoreVersion() error = %v", err)
+	}
+	if restored.VersionID == v1.VersionID || restored.ETag != v1.ETag {
+		t.Errorf("restored version %s ETag %s, want a new version with ETag %s", restored.VersionID, restored.ETag, v1.ETag)
+	}
+
+	obj, data, err := service.GetObject(ctx, "iter-bucket", "report", nil)
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	got, _ := io.ReadAll(data)
+	data.Close()
+	if !bytes.Equal(got, []byte("first draft")) || obj.ContentType != "text/plain" || obj.Metadata["x-amz-meta-status"] != "draft" {
+		t.Errorf("current object = %q %s %v, want the first version", got, obj.ContentType, obj.Metadata)
+	}
+
+	// The older version is still there
+	if _, err := service.HeadObject(ctx, "iter-bucket", "report", &v1.VersionID); err != nil {
+		t.Errorf("HeadObject(v1) error = %v", err)
+	}
+
+	events, err := service.GetObjectHistory(ctx, "iter-bucket", "report")
+	if err != nil {
+		t.Fatalf("GetObjectHistory() error = %v", err)
+	}
+	last := events[len(events)-1]
+	if last.Op != HistoryRestore || last.VersionID != restored.VersionID || last.Detail != "from version "+v1.VersionID {
+		t.Errorf("last history event = %+v, want the restore of %s", last, v1.VersionID)
+	}
+
+	if _, err := service.RestoreVersion(ctx, "iter-bucket", "report", "no-such-version"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("RestoreVersion(missing) error = %v, want ErrVersionNotFound", err)
+	}
+}
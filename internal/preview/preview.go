@@ -0,0 +1,119 @@
+// Package preview generates derived objects, such as thumbnails or
+// extracted text, after objects are written
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/notification"
+	"github.com/danielino/comio/internal/object"
+)
+
+// DefaultPrefix is the key prefix derived objects are written under
+const DefaultPrefix = ".previews/"
+
+// maxSourceSize bounds the objects processors are run on
+const maxSourceSize = 64 * 1024 * 1024
+
+// Processor derives a new object from a source object
+type Processor interface {
+	// Name identifies the processor; it is part of derived object keys
+	Name() string
+	// Match reports whether the processor handles the content type
+	Match(contentType string) bool
+	// Process reads the source and returns the derived data and its content type
+	Process(ctx context.Context, src io.Reader) (data []byte, contentType string, err error)
+}
+
+// Hook runs registered processors on newly created objects. Derived
+// objects are stored in the same bucket at <prefix><processor>/<key>, and
+// objects under the prefix are never processed themselves.
+type Hook struct {
+	service    *object.Service
+	prefix     string
+	processors []Processor
+}
+
+// NewHook creates a preview hook writing derived objects under prefix
+func NewHook(service *object.Service, prefix string, processors ...Processor) *Hook {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &Hook{
+		service:    service,
+		prefix:     prefix,
+		processors: processors,
+	}
+}
+
+// Register subscribes the hook to object events on bus
+func (h *Hook) Register(bus *notification.Bus) {
+	bus.Subscribe("preview", h.Handle)
+}
+
+// DerivedKey returns the key a processor's output for key is stored under
+func (h *Hook) DerivedKey(processor Processor, key string) string {
+	return h.prefix + processor.Name() + "/" + key
+}
+
+// Handle processes one object event
+func (h *Hook) Handle(ctx context.Context, event notification.Event) error {
+	if event.Type != notification.EventObjectCreated || strings.HasPrefix(event.Key, h.prefix) {
+		return nil
+	}
+	if event.Size > maxSourceSize {
+		return nil
+	}
+
+	for _, p := range h.processors {
+		if !p.Match(event.ContentType) {
+			continue
+		}
+		if err := h.run(ctx, p, event); err != nil {
+			monitoring.Log.Warn("Preview processor failed",
+				zap.String("processor", p.Name()),
+				zap.String("bucket", event.Bucket),
+				zap.String("key", event.Key),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+func (h *Hook) run(ctx context.Context, p Processor, event notification.Event) error {
+	versionID := event.VersionID
+	var version *string
+	if versionID != "" {
+		version = &versionID
+	}
+
+	_, src, err := h.service.GetObject(ctx, event.Bucket, event.Key, version)
+	if err != nil {
+		return fmt.Errorf("failed to read source object: %w", err)
+	}
+	defer src.Close()
+
+	data, contentType, err := p.Process(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	key := h.DerivedKey(p, event.Key)
+	if _, err := h.service.PutObject(ctx, event.Bucket, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return fmt.Errorf("failed to write derived object: %w", err)
+	}
+
+	monitoring.Log.Debug("Preview generated",
+		zap.String("processor", p.Name()),
+		zap.String("bucket", event.Bucket),
+		zap.String("key", key))
+	return nil
+}
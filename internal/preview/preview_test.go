@@ -0,0 +1,151 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/danielino/comio/internal/monitoring"
+	"github.com/danielino/comio/internal/notification"
+	"github.com/danielino/comio/internal/object"
+	"github.com/danielino/comio/internal/storage"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func newTestService(t *testing.T) *object.Service {
+	f, err := os.CreateTemp(t.TempDir(), "preview_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f.Close()
+
+	engine, err := storage.NewSimpleEngine(f.Name(), 64*1024*1024, 4096)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	t.Cleanup(func() { engine.Close() })
+
+	return object.NewService(object.NewMemoryRepository(), engine)
+}
+
+func put(t *testing.T, service *object.Service, key, contentType string, data []byte) *object.Object {
+	obj, err := service.PutObject(context.Background(), "bucket", key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	return obj
+}
+
+func created(obj *object.Object) notification.Event {
+	return notification.Event{
+		Type:        notification.EventObjectCreated,
+		Bucket:      obj.BucketName,
+		Key:         obj.Key,
+		Size:        obj.Size,
+		ContentType: obj.ContentType,
+	}
+}
+
+func read(t *testing.T, service *object.Service, key string) (*object.Object, []byte) {
+	obj, body, err := service.GetObject(context.Background(), "bucket", key, nil)
+	if err != nil {
+		t.Fatalf("GetObject(%s) error = %v", key, err)
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	return obj, data
+}
+
+func TestHook_Thumbnail(t *testing.T) {
+	service := newTestService(t)
+	hook := NewHook(service, "", NewThumbnailer(64))
+
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := put(t, service, "photo.png", "image/png", buf.Bytes())
+	if err := hook.Handle(context.Background(), created(obj)); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	thumbObj, data := read(t, service, ".previews/thumbnail/photo.png")
+	if thumbObj.ContentType != "image/jpeg" {
+		t.Errorf("ContentType = %s, want image/jpeg", thumbObj.ContentType)
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("thumbnail size = %dx%d, want 64x32", b.Dx(), b.Dy())
+	}
+}
+
+func TestHook_SkipsDerivedAndUnmatched(t *testing.T) {
+	service := newTestService(t)
+	hook := NewHook(service, "", NewThumbnailer(0), NewTextExtractor(0))
+
+	for _, key := range []string{".previews/text/page.html", "notes.bin"} {
+		contentType := "text/html"
+		if key == "notes.bin" {
+			contentType = "application/octet-stream"
+		}
+		obj := put(t, service, key, contentType, []byte("<p>hi</p>"))
+		if err := hook.Handle(context.Background(), created(obj)); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+	}
+
+	result, err := service.ListObjects(context.Background(), "bucket", "", object.ListOptions{MaxKeys: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Objects) != 2 {
+		t.Errorf("objects = %d, want 2 (no derived objects)", len(result.Objects))
+	}
+}
+
+func TestTextExtractor(t *testing.T) {
+	html := `<html><head><style>p { color: red; }</style><script>alert("x")</script></head>
+<body><h1>Title</h1>
+<p>Fish &amp; chips</p></body></html>`
+
+	e := NewTextExtractor(0)
+	if !e.Match("text/html; charset=utf-8") || e.Match("text/plain") {
+		t.Error("unexpected Match result")
+	}
+
+	data, contentType, err := e.Process(context.Background(), strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("contentType = %s", contentType)
+	}
+
+	text := string(data)
+	if text != "Title\nFish & chips" {
+		t.Errorf("text = %q", text)
+	}
+}
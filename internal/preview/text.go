@@ -0,0 +1,122 @@
+package preview
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultTextLimit is the default number of bytes of extracted text kept
+const DefaultTextLimit = 64 * 1024
+
+// TextExtractor produces a plain-text rendition of text documents, with
+// markup stripped from HTML and XML, truncated to a size limit
+type TextExtractor struct {
+	limit int
+}
+
+// NewTextExtractor creates a text extractor keeping at most limit bytes
+func NewTextExtractor(limit int) *TextExtractor {
+	if limit <= 0 {
+		limit = DefaultTextLimit
+	}
+	return &TextExtractor{limit: limit}
+}
+
+func (e *TextExtractor) Name() string {
+	return "text"
+}
+
+func (e *TextExtractor) Match(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = strings.TrimSpace(ct[:i])
+	}
+	return ct == "text/html" || ct == "application/xhtml+xml" || ct == "application/xml" || ct == "text/xml"
+}
+
+func (e *TextExtractor) Process(ctx context.Context, src io.Reader) ([]byte, string, error) {
+	// Markup inflates text, so read a bounded multiple of the limit
+	raw, err := io.ReadAll(io.LimitReader(src, int64(e.limit)*4))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read document: %w", err)
+	}
+
+	text := collapseSpace(stripMarkup(string(raw)))
+	if len(text) > e.limit {
+		text = text[:e.limit]
+		// Do not cut a multi-byte character in half
+		for len(text) > 0 && !utf8.ValidString(text) {
+			text = text[:len(text)-1]
+		}
+	}
+
+	return []byte(text), "text/plain; charset=utf-8", nil
+}
+
+// stripMarkup removes tags, and the contents of script and style elements
+func stripMarkup(s string) string {
+	var b strings.Builder
+	lower := strings.ToLower(s)
+
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		// Skip script and style bodies entirely
+		skipped := false
+		for _, tag := range []string{"script", "style"} {
+			if strings.HasPrefix(lower[i+1:], tag) {
+				end := strings.Index(lower[i:], "</"+tag)
+				if end < 0 {
+					return b.String()
+				}
+				i += end + len(tag) + 2
+				skipped = true
+				break
+			}
+		}
+
+		end := strings.IndexByte(s[i:], '>')
+		if end < 0 {
+			break
+		}
+		i += end + 1
+		if !skipped {
+			b.WriteByte(' ')
+		}
+	}
+
+	return decodeEntities(b.String())
+}
+
+var entityReplacer = strings.NewReplacer(
+	"&amp;", "&",
+	"&lt;", "<",
+	"&gt;", ">",
+	"&quot;", `"`,
+	"&#39;", "'",
+	"&nbsp;", " ",
+)
+
+func decodeEntities(s string) string {
+	return entityReplacer.Replace(s)
+}
+
+// collapseSpace joins whitespace runs into single spaces, keeping line
+// breaks between paragraphs
+func collapseSpace(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,107 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strings"
+
+	// Register decoders for the supported source formats
+	_ "image/gif"
+	_ "image/png"
+)
+
+// DefaultThumbnailSize is the default bounding box of generated thumbnails
+const DefaultThumbnailSize = 256
+
+// Thumbnailer scales images down to fit a square bounding box and encodes
+// the result as JPEG
+type Thumbnailer struct {
+	size int
+}
+
+// NewThumbnailer creates a thumbnailer with the given bounding box size
+func NewThumbnailer(size int) *Thumbnailer {
+	if size <= 0 {
+		size = DefaultThumbnailSize
+	}
+	return &Thumbnailer{size: size}
+}
+
+func (t *Thumbnailer) Name() string {
+	return "thumbnail"
+}
+
+func (t *Thumbnailer) Match(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+func (t *Thumbnailer) Process(ctx context.Context, src io.Reader) ([]byte, string, error) {
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	thumb := scale(img, t.size)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
+
+// scale resizes img to fit within a size x size box, preserving the aspect
+// ratio, by averaging the source pixels covered by each destination pixel.
+// Images already within the box are returned unchanged.
+func scale(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	dw, dh := size, size
+	if w > h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0 := b.Min.Y + y*h/dh
+		sy1 := max(sy0+1, b.Min.Y+(y+1)*h/dh)
+		for x := 0; x < dw; x++ {
+			sx0 := b.Min.X + x*w/dw
+			sx1 := max(sx0+1, b.Min.X+(x+1)*w/dw)
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					bl += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+
+	return dst
+}
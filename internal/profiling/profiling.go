@@ -0,0 +1,32 @@
+// Package profiling serves the Go runtime profiles of net/http/pprof for
+// performance investigation on live nodes
+package profiling
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Path is the URL prefix, relative to the group given to Register, the
+// profiles are served under
+const Path = "/debug/pprof"
+
+// Register serves the pprof index and profiles under Path on router, behind
+// HTTP basic auth with the admin credentials and any further handlers given
+func Register(router gin.IRouter, accessKey, secretKey string, handlers ...gin.HandlerFunc) {
+	group := router.Group(Path, handlers...)
+	group.Use(gin.BasicAuthForRealm(gin.Accounts{accessKey: secretKey}, "ComIO Profiling"))
+
+	// pprof.Index only resolves profile names under /debug/pprof/, so
+	// every profile is routed explicitly
+	group.GET("/", gin.WrapF(pprof.Index))
+	group.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	group.GET("/profile", gin.WrapF(pprof.Profile))
+	group.GET("/symbol", gin.WrapF(pprof.Symbol))
+	group.POST("/symbol", gin.WrapF(pprof.Symbol))
+	group.GET("/trace", gin.WrapF(pprof.Trace))
+	group.GET("/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+}
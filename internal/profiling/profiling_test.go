@@ -0,0 +1,66 @@
+package profiling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupProfiling() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// The profiles must coexist with legacy admin routes taking a bucket
+	admin := router.Group("/admin")
+	admin.GET("/:bucket/replication", func(c *gin.Context) { c.String(http.StatusOK, "replication") })
+
+	Register(admin, "admin", "secret")
+	return router
+}
+
+func TestProfiling_RequiresCredentials(t *testing.T) {
+	router := setupProfiling()
+
+	for _, password := range []string{"", "wrong"} {
+		req := httptest.NewRequest("GET", "/admin/debug/pprof/heap", nil)
+		if password != "" {
+			req.SetBasicAuth("admin", password)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status with password %q = %d, want %d", password, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestProfiling_ServesProfiles(t *testing.T) {
+	router := setupProfiling()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/admin/debug/pprof/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine") {
+		t.Errorf("index = %d, want the list of profiles", w.Code)
+	}
+	if w := get("/admin/debug/pprof/goroutine?debug=1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("goroutine profile = %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/admin/debug/pprof/cmdline"); w.Code != http.StatusOK {
+		t.Errorf("cmdline = %d", w.Code)
+	}
+	if w := get("/admin/debug/pprof/nosuchprofile"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown profile = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := get("/admin/bucket/replication"); w.Body.String() != "replication" {
+		t.Errorf("bucket route = %q, want it still routed", w.Body.String())
+	}
+}
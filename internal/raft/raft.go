@@ -0,0 +1,457 @@
+// Package raft replicates a state machine across nodes with the Raft
+// consensus algorithm. Elections, log replication and snapshots are left
+// to hashicorp/raft; this package runs its RPCs over the HTTP server the
+// nodes already share, keeps the log in BoltDB and forwards proposals from
+// followers to the leader.
+package raft
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	hraft "github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+var (
+	// ErrNoLeader is returned when a proposal is made while no leader is known
+	ErrNoLeader = errors.New("no raft leader")
+	// ErrStopped is returned by operations on a stopped node
+	ErrStopped = errors.New("raft node stopped")
+)
+
+// State is the role of a node
+type State string
+
+const (
+	Follower  State = "follower"
+	Candidate State = "candidate"
+	Leader    State = "leader"
+)
+
+// proposeTimeout bounds how long a proposal waits to be enqueued on the
+// leader when the caller's context has no deadline
+const proposeTimeout = 30 * time.Second
+
+// FSM is the state machine replicated by the log. Apply must be
+// deterministic: every node applies the same commands in the same order.
+type FSM interface {
+	Apply(command []byte) error
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// Config configures a node
+type Config struct {
+	ID    string
+	Peers map[string]string // Node ID -> address, including this node
+	Dir   string            // Where the log, state and snapshots are kept
+
+	ElectionTimeout   time.Duration // Time without a leader before an election
+	SnapshotThreshold uint64        // Applied entries between snapshots
+}
+
+// DefaultConfig returns the default timings
+func DefaultConfig() Config {
+	return Config{
+		ElectionTimeout:   time.Second,
+		SnapshotThreshold: 1024,
+	}
+}
+
+// Status describes a node for monitoring
+type Status struct {
+	ID            string            `json:"id"`
+	State         State             `json:"state"`
+	Term          uint64            `json:"term"`
+	Leader        string            `json:"leader"`
+	CommitIndex   uint64            `json:"commit_index"`
+	AppliedIndex  uint64            `json:"applied_index"`
+	SnapshotIndex uint64            `json:"snapshot_index"`
+	LastIndex     uint64            `json:"last_index"`
+	Peers         map[string]string `json:"peers"`
+}
+
+// Node is a member of a raft group
+type Node struct {
+	cfg       Config
+	fsm       *fsmAdapter
+	transport *transport
+	store     *raftboltdb.BoltStore
+	snapshots hraft.SnapshotStore
+	config    *hraft.Config
+
+	mu      sync.Mutex
+	raft    *hraft.Raft // Set by Start
+	stopped bool
+}
+
+// NewNode creates a node, opening its persisted state. A node without any
+// state bootstraps the group from its peer list; as every node has the
+// same list, they all bootstrap the same configuration.
+func NewNode(cfg Config, fsm FSM, transport *HTTPTransport) (*Node, error) {
+	defaults := DefaultConfig()
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = defaults.ElectionTimeout
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = defaults.SnapshotThreshold
+	}
+	if _, ok := cfg.Peers[cfg.ID]; !ok {
+		return nil, fmt.Errorf("node %q is not in its own peer list", cfg.ID)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create raft directory: %w", err)
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  hclog.Warn,
+		Output: logWriter{},
+	})
+	config := hraft.DefaultConfig()
+	config.LocalID = hraft.ServerID(cfg.ID)
+	config.Logger = logger
+	// Leaders heartbeat at a tenth of the heartbeat timeout
+	config.HeartbeatTimeout = cfg.ElectionTimeout
+	config.ElectionTimeout = cfg.ElectionTimeout
+	config.LeaderLeaseTimeout = cfg.ElectionTimeout / 2
+	config.SnapshotThreshold = cfg.SnapshotThreshold
+	// Followers further behind than a snapshot's worth of entries catch
+	// up from the snapshot
+	config.TrailingLogs = cfg.SnapshotThreshold
+	if err := hraft.ValidateConfig(config); err != nil {
+		return nil, err
+	}
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raft log: %w", err)
+	}
+	snapshots, err := hraft.NewFileSnapshotStoreWithLogger(cfg.Dir, 2, logger)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to open raft snapshots: %w", err)
+	}
+
+	n := &Node{
+		cfg:       cfg,
+		fsm:       newFSMAdapter(fsm),
+		transport: newTransport(transport, cfg.Peers[cfg.ID]),
+		store:     store,
+		snapshots: snapshots,
+		config:    config,
+	}
+
+	existing, err := hraft.HasExistingState(store, store, snapshots)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to read raft state: %w", err)
+	}
+	if !existing {
+		var servers []hraft.Server
+		for id, address := range cfg.Peers {
+			servers = append(servers, hraft.Server{ID: hraft.ServerID(id), Address: hraft.ServerAddress(address)})
+		}
+		if err := hraft.BootstrapCluster(config, store, store, snapshots, n.transport, hraft.Configuration{Servers: servers}); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to bootstrap raft group: %w", err)
+		}
+	}
+
+	return n, nil
+}
+
+// Start runs the node until Stop
+func (n *Node) Start() error {
+	r, err := hraft.NewRaft(n.config, n.fsm, n.store, n.store, n.snapshots, n.transport)
+	if err != nil {
+		return fmt.Errorf("failed to start raft node: %w", err)
+	}
+	n.mu.Lock()
+	n.raft = r
+	n.mu.Unlock()
+
+	monitoring.Log.Info("Raft node started",
+		zap.String("id", n.cfg.ID),
+		zap.Int("peers", len(n.cfg.Peers)),
+		zap.Uint64("last_index", r.LastIndex()))
+	return nil
+}
+
+// Stop stops the node
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.stopped = true
+	r := n.raft
+	n.mu.Unlock()
+
+	if r != nil {
+		if err := r.Shutdown().Error(); err != nil {
+			monitoring.Log.Warn("Failed to stop raft node", zap.Error(err))
+		}
+	}
+	n.transport.Close()
+	n.store.Close()
+}
+
+// running returns the raft instance, or nil before Start and after Stop
+func (n *Node) running() *hraft.Raft {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		return nil
+	}
+	return n.raft
+}
+
+// ID returns the node ID
+func (n *Node) ID() string {
+	return n.cfg.ID
+}
+
+// IsLeader reports whether the node is the leader
+func (n *Node) IsLeader() bool {
+	r := n.running()
+	return r != nil && r.State() == hraft.Leader
+}
+
+// Leader returns the ID and address of the known leader
+func (n *Node) Leader() (id, address string) {
+	r := n.running()
+	if r == nil {
+		return "", ""
+	}
+	leaderAddress, leaderID := r.LeaderWithID()
+	return string(leaderID), string(leaderAddress)
+}
+
+// Status returns the node's view of the group
+func (n *Node) Status() Status {
+	status := Status{ID: n.cfg.ID, State: Follower, Peers: n.cfg.Peers}
+	r := n.running()
+	if r == nil {
+		return status
+	}
+
+	stats := r.Stats()
+	parse := func(key string) uint64 {
+		v, _ := strconv.ParseUint(stats[key], 10, 64)
+		return v
+	}
+	switch r.State() {
+	case hraft.Leader:
+		status.State = Leader
+	case hraft.Candidate:
+		status.State = Candidate
+	}
+	status.Term = parse("term")
+	status.Leader, _ = n.Leader()
+	status.CommitIndex = r.CommitIndex()
+	status.AppliedIndex = n.fsm.appliedIndex()
+	status.SnapshotIndex = parse("last_snapshot_index")
+	status.LastIndex = r.LastIndex()
+	return status
+}
+
+// Propose replicates a command and returns once it is applied on this
+// node. Followers forward the command to the leader. The error of the
+// state machine applying the command is returned as is.
+func (n *Node) Propose(ctx context.Context, command []byte) error {
+	if command == nil {
+		command = []byte{}
+	}
+
+	index, err := n.propose(ctx, command)
+	if err != nil {
+		return err
+	}
+	return n.fsm.waitApplied(ctx, index)
+}
+
+// propose applies a command on the leader, or forwards it, and returns its
+// index once committed and applied on the leader
+func (n *Node) propose(ctx context.Context, command []byte) (uint64, error) {
+	r := n.running()
+	if r == nil {
+		return 0, ErrStopped
+	}
+
+	if r.State() != hraft.Leader {
+		address, _ := r.LeaderWithID()
+		if address == "" {
+			return 0, ErrNoLeader
+		}
+		resp, err := n.transport.http.Forward(ctx, string(address), &ForwardRequest{Command: command})
+		if err != nil {
+			return 0, fmt.Errorf("failed to forward to leader: %w", err)
+		}
+		if resp.Error != "" {
+			return resp.Index, errors.New(resp.Error)
+		}
+		return resp.Index, nil
+	}
+
+	timeout := proposeTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return 0, ctx.Err()
+		}
+	}
+	future := r.Apply(command, timeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, hraft.ErrRaftShutdown) {
+			return 0, ErrStopped
+		}
+		return 0, err
+	}
+	if err, ok := future.Response().(error); ok && err != nil {
+		return future.Index(), err
+	}
+	return future.Index(), nil
+}
+
+// handleForward proposes a command forwarded by a follower
+func (n *Node) handleForward(ctx context.Context, req *ForwardRequest) *ForwardResponse {
+	// Never forward again, so stale leader views cannot bounce a command
+	if !n.IsLeader() {
+		return &ForwardResponse{Error: ErrNoLeader.Error()}
+	}
+	index, err := n.propose(ctx, req.Command)
+	if err != nil {
+		return &ForwardResponse{Index: index, Error: err.Error()}
+	}
+	return &ForwardResponse{Index: index}
+}
+
+// fsmAdapter runs an FSM under hashicorp/raft and tracks the index of the
+// last entry it applied, which snapshots carry along, so proposals can
+// wait for their entry on followers
+type fsmAdapter struct {
+	fsm FSM
+
+	mu      sync.Mutex
+	applied uint64
+	changed chan struct{} // Closed and replaced after every apply
+}
+
+func newFSMAdapter(fsm FSM) *fsmAdapter {
+	return &fsmAdapter{fsm: fsm, changed: make(chan struct{})}
+}
+
+// Apply implements hraft.FSM, returning the error of the FSM
+func (a *fsmAdapter) Apply(log *hraft.Log) interface{} {
+	var err error
+	if log.Type == hraft.LogCommand {
+		err = a.fsm.Apply(log.Data)
+	}
+	a.setApplied(log.Index)
+	return err
+}
+
+// Snapshot implements hraft.FSM. Raft does not apply entries while it
+// runs, so the state and index match.
+func (a *fsmAdapter) Snapshot() (hraft.FSMSnapshot, error) {
+	data, err := a.fsm.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{index: a.appliedIndex(), data: data}, nil
+}
+
+// Restore implements hraft.FSM
+func (a *fsmAdapter) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var index uint64
+	if err := binary.Read(rc, binary.BigEndian, &index); err != nil {
+		return fmt.Errorf("failed to read snapshot index: %w", err)
+	}
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if err := a.fsm.Restore(data); err != nil {
+		return err
+	}
+	a.setApplied(index)
+	return nil
+}
+
+func (a *fsmAdapter) setApplied(index uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.applied = index
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *fsmAdapter) appliedIndex() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.applied
+}
+
+// waitApplied blocks until the entry at index is applied locally
+func (a *fsmAdapter) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		a.mu.Lock()
+		if a.applied >= index {
+			a.mu.Unlock()
+			return nil
+		}
+		ch := a.changed
+		a.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fsmSnapshot is the state of an FSM at an index, persisted as the index
+// followed by the FSM's data
+type fsmSnapshot struct {
+	index uint64
+	data  []byte
+}
+
+func (s *fsmSnapshot) Persist(sink hraft.SnapshotSink) error {
+	err := binary.Write(sink, binary.BigEndian, s.index)
+	if err == nil {
+		_, err = sink.Write(s.data)
+	}
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
+
+// logWriter sends the log lines of hashicorp/raft to the monitoring log
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	monitoring.Log.Warn(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,302 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+// listFSM records applied commands in order
+type listFSM struct {
+	mu    sync.Mutex
+	items []string
+}
+
+func (f *listFSM) Apply(command []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if strings.HasPrefix(string(command), "bad") {
+		return errors.New("rejected")
+	}
+	f.items = append(f.items, string(command))
+	return nil
+}
+
+func (f *listFSM) Snapshot() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.Marshal(f.items)
+}
+
+func (f *listFSM) Restore(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = nil
+	return json.Unmarshal(data, &f.items)
+}
+
+func (f *listFSM) get() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.items...)
+}
+
+// testCluster runs nodes over the HTTP transport. Down nodes are cut off
+// in both directions.
+type testCluster struct {
+	t     *testing.T
+	nodes map[string]*Node
+	fsms  map[string]*listFSM
+	dirs  map[string]string
+	peers map[string]string
+
+	mu       sync.Mutex
+	handlers map[string]http.Handler
+	down     map[string]bool
+	ids      map[string]string // Host -> node ID
+}
+
+func newTestCluster(t *testing.T, size int, snapshotThreshold uint64) *testCluster {
+	c := &testCluster{
+		t:        t,
+		nodes:    make(map[string]*Node),
+		fsms:     make(map[string]*listFSM),
+		dirs:     make(map[string]string),
+		peers:    make(map[string]string),
+		handlers: make(map[string]http.Handler),
+		down:     make(map[string]bool),
+		ids:      make(map[string]string),
+	}
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprintf("n%d", i)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.mu.Lock()
+			h := c.handlers[id]
+			c.mu.Unlock()
+			h.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		c.peers[id] = server.URL
+		c.ids[strings.TrimPrefix(server.URL, "http://")] = id
+		c.dirs[id] = t.TempDir()
+	}
+	for id := range c.peers {
+		c.start(id, snapshotThreshold)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+func (c *testCluster) start(id string, snapshotThreshold uint64) {
+	fsm := &listFSM{}
+	transport := NewHTTPTransport("secret")
+	// Requests of or to a down node fail as they would on a partition
+	cut := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if c.isDown(id) || c.isDown(c.ids[r.URL.Host]) {
+			return nil, errors.New("unreachable")
+		}
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	transport.client.Transport = cut
+	transport.stream.Transport = cut
+
+	n, err := NewNode(Config{
+		ID:                id,
+		Peers:             c.peers,
+		Dir:               c.dirs[id],
+		ElectionTimeout:   150 * time.Millisecond,
+		SnapshotThreshold: snapshotThreshold,
+	}, fsm, transport)
+	if err != nil {
+		c.t.Fatalf("NewNode(%s) error = %v", id, err)
+	}
+	c.mu.Lock()
+	c.handlers[id] = n.Handler("secret")
+	c.mu.Unlock()
+	c.nodes[id] = n
+	c.fsms[id] = fsm
+	if err := n.Start(); err != nil {
+		c.t.Fatalf("Start(%s) error = %v", id, err)
+	}
+}
+
+// setDown isolates a node in both directions
+func (c *testCluster) setDown(id string, down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down[id] = down
+}
+
+func (c *testCluster) isDown(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.down[id]
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// snapshot takes a snapshot of a node now, rather than when raft next
+// checks the threshold
+func snapshot(t *testing.T, n *Node) {
+	t.Helper()
+	if err := n.running().Snapshot().Error(); err != nil {
+		t.Fatalf("Snapshot() on %s error = %v", n.ID(), err)
+	}
+}
+
+// leader waits for a single leader among the reachable nodes
+func (c *testCluster) leader() *Node {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*Node
+		for id, n := range c.nodes {
+			if !c.isDown(id) && n.IsLeader() {
+				leaders = append(leaders, n)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("no leader elected")
+	return nil
+}
+
+// waitItems waits until the node's FSM holds want
+func (c *testCluster) waitItems(id string, want []string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if fmt.Sprint(c.fsms[id].get()) == fmt.Sprint(want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatalf("node %s has %v, want %v", id, c.fsms[id].get(), want)
+}
+
+func propose(t *testing.T, n *Node, command string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Propose(ctx, []byte(command)); err != nil {
+		t.Fatalf("Propose(%q) on %s error = %v", command, n.ID(), err)
+	}
+}
+
+func TestRaft_ReplicatesAndForwards(t *testing.T) {
+	c := newTestCluster(t, 3, 1000)
+	leader := c.leader()
+
+	propose(t, leader, "a")
+	for id, n := range c.nodes {
+		if n != leader {
+			// A follower forwards, and returns once it applied the entry
+			propose(t, n, "b")
+			if items := c.fsms[id].get(); len(items) != 2 {
+				t.Errorf("follower %s has %v right after its proposal", id, items)
+			}
+			break
+		}
+	}
+
+	for id := range c.nodes {
+		c.waitItems(id, []string{"a", "b"})
+	}
+
+	// FSM errors reach the proposer but the entry stays committed
+	if err := leader.Propose(context.Background(), []byte("bad")); err == nil {
+		t.Error("Propose() of a rejected command should fail")
+	}
+}
+
+func TestRaft_LeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3, 1000)
+	old := c.leader()
+	propose(t, old, "before")
+
+	c.setDown(old.ID(), true)
+	leader := c.leader()
+	if leader == old {
+		t.Fatal("isolated leader still leads")
+	}
+	propose(t, leader, "after")
+
+	// The old leader rejoins and catches up as a follower
+	c.setDown(old.ID(), false)
+	c.waitItems(old.ID(), []string{"before", "after"})
+	if old.IsLeader() && old.Status().Term < leader.Status().Term {
+		t.Error("stale leader did not step down")
+	}
+}
+
+func TestRaft_SnapshotCatchUp(t *testing.T) {
+	c := newTestCluster(t, 3, 5)
+	leader := c.leader()
+
+	var lagging string
+	for id := range c.nodes {
+		if id != leader.ID() {
+			lagging = id
+			break
+		}
+	}
+	c.setDown(lagging, true)
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		item := fmt.Sprintf("item-%d", i)
+		propose(t, leader, item)
+		want = append(want, item)
+	}
+	snapshot(t, leader)
+
+	// The log the lagging node needs was compacted: it gets the snapshot
+	c.setDown(lagging, false)
+	c.waitItems(lagging, want)
+	if c.nodes[lagging].Status().SnapshotIndex == 0 {
+		t.Error("lagging node caught up without installing a snapshot")
+	}
+}
+
+func TestRaft_Restart(t *testing.T) {
+	c := newTestCluster(t, 1, 3)
+	leader := c.leader()
+	var want []string
+	for i := 0; i < 5; i++ {
+		item := fmt.Sprintf("item-%d", i)
+		propose(t, leader, item)
+		want = append(want, item)
+		if i == 2 {
+			snapshot(t, leader)
+		}
+	}
+	leader.Stop()
+
+	// State comes back from the snapshot plus the log after it
+	c.start(leader.ID(), 3)
+	c.waitItems(leader.ID(), want)
+	propose(t, c.leader(), "more")
+	c.waitItems(leader.ID(), append(want, "more"))
+}
@@ -0,0 +1,300 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// TokenHeader carries the shared secret authenticating raft RPCs
+const TokenHeader = "X-Comio-Raft-Token"
+
+// snapshotHeader carries the InstallSnapshot request, as its body is the
+// snapshot itself
+const snapshotHeader = "X-Comio-Raft-Snapshot"
+
+// ForwardRequest carries a follower's proposal to the leader
+type ForwardRequest struct {
+	Command []byte `json:"command"`
+}
+
+// ForwardResponse answers a ForwardRequest with the index the command was
+// applied at on the leader
+type ForwardResponse struct {
+	Index uint64 `json:"index"`
+	Error string `json:"error,omitempty"`
+}
+
+// HTTPTransport sends RPCs as JSON to the Handler of other nodes, at
+// addresses like "http://node-2:8080"
+type HTTPTransport struct {
+	client *http.Client
+	stream *http.Client // For snapshots, which take as long as they take
+	token  string
+}
+
+// NewHTTPTransport creates a transport authenticating with token
+func NewHTTPTransport(token string) *HTTPTransport {
+	return &HTTPTransport{
+		client: &http.Client{Timeout: 30 * time.Second},
+		stream: &http.Client{},
+		token:  token,
+	}
+}
+
+// post sends an RPC and decodes its reply into resp
+func (t *HTTPTransport) post(ctx context.Context, client *http.Client, url string, body io.Reader, header http.Header, resp interface{}) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+	httpReq.Header.Set(TokenHeader, t.token)
+
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(httpResp.Body)
+		return fmt.Errorf("raft peer returned %d: %s", httpResp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// call sends a JSON RPC
+func (t *HTTPTransport) call(ctx context.Context, url string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	return t.post(ctx, t.client, url, bytes.NewReader(body), header, resp)
+}
+
+// Forward sends a proposal to the leader at address
+func (t *HTTPTransport) Forward(ctx context.Context, address string, req *ForwardRequest) (*ForwardResponse, error) {
+	var resp ForwardResponse
+	return &resp, t.call(ctx, address+"/raft/forward", req, &resp)
+}
+
+// transport is the hashicorp/raft transport of a node: RPCs go out
+// through an HTTPTransport and come in through the node's Handler
+type transport struct {
+	http    *HTTPTransport
+	address string
+
+	consumeCh  chan hraft.RPC
+	shutdownCh chan struct{}
+	closeOnce  sync.Once
+
+	heartbeatMu sync.Mutex
+	heartbeatFn func(hraft.RPC)
+}
+
+func newTransport(http *HTTPTransport, address string) *transport {
+	return &transport{
+		http:       http,
+		address:    address,
+		consumeCh:  make(chan hraft.RPC),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// Consumer implements hraft.Transport
+func (t *transport) Consumer() <-chan hraft.RPC {
+	return t.consumeCh
+}
+
+// LocalAddr implements hraft.Transport
+func (t *transport) LocalAddr() hraft.ServerAddress {
+	return hraft.ServerAddress(t.address)
+}
+
+// AppendEntriesPipeline implements hraft.Transport; raft falls back to
+// single AppendEntries calls
+func (t *transport) AppendEntriesPipeline(id hraft.ServerID, target hraft.ServerAddress) (hraft.AppendPipeline, error) {
+	return nil, hraft.ErrPipelineReplicationNotSupported
+}
+
+// AppendEntries implements hraft.Transport
+func (t *transport) AppendEntries(id hraft.ServerID, target hraft.ServerAddress, args *hraft.AppendEntriesRequest, resp *hraft.AppendEntriesResponse) error {
+	return t.http.call(context.Background(), string(target)+"/raft/append", args, resp)
+}
+
+// RequestVote implements hraft.Transport
+func (t *transport) RequestVote(id hraft.ServerID, target hraft.ServerAddress, args *hraft.RequestVoteRequest, resp *hraft.RequestVoteResponse) error {
+	return t.http.call(context.Background(), string(target)+"/raft/vote", args, resp)
+}
+
+// TimeoutNow implements hraft.Transport
+func (t *transport) TimeoutNow(id hraft.ServerID, target hraft.ServerAddress, args *hraft.TimeoutNowRequest, resp *hraft.TimeoutNowResponse) error {
+	return t.http.call(context.Background(), string(target)+"/raft/timeout", args, resp)
+}
+
+// InstallSnapshot implements hraft.Transport, streaming the snapshot as
+// the request body
+func (t *transport) InstallSnapshot(id hraft.ServerID, target hraft.ServerAddress, args *hraft.InstallSnapshotRequest, resp *hraft.InstallSnapshotResponse, data io.Reader) error {
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	header := http.Header{
+		"Content-Type": {"application/octet-stream"},
+		snapshotHeader: {base64.StdEncoding.EncodeToString(encoded)},
+	}
+	return t.http.post(context.Background(), t.http.stream, string(target)+"/raft/snapshot", data, header, resp)
+}
+
+// EncodePeer implements hraft.Transport
+func (t *transport) EncodePeer(id hraft.ServerID, address hraft.ServerAddress) []byte {
+	return []byte(address)
+}
+
+// DecodePeer implements hraft.Transport
+func (t *transport) DecodePeer(buf []byte) hraft.ServerAddress {
+	return hraft.ServerAddress(buf)
+}
+
+// SetHeartbeatHandler implements hraft.Transport
+func (t *transport) SetHeartbeatHandler(fn func(hraft.RPC)) {
+	t.heartbeatMu.Lock()
+	defer t.heartbeatMu.Unlock()
+	t.heartbeatFn = fn
+}
+
+// Close implements hraft.WithClose
+func (t *transport) Close() error {
+	t.closeOnce.Do(func() { close(t.shutdownCh) })
+	return nil
+}
+
+// dispatch hands an incoming RPC to raft and waits for its response.
+// Heartbeats skip the queue, so they are not held up behind disk writes.
+func (t *transport) dispatch(ctx context.Context, command interface{}, reader io.Reader, heartbeat bool) (interface{}, error) {
+	respCh := make(chan hraft.RPCResponse, 1)
+	rpc := hraft.RPC{Command: command, Reader: reader, RespChan: respCh}
+
+	t.heartbeatMu.Lock()
+	fn := t.heartbeatFn
+	t.heartbeatMu.Unlock()
+	if heartbeat && fn != nil {
+		fn(rpc)
+	} else {
+		select {
+		case t.consumeCh <- rpc:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.shutdownCh:
+			return nil, hraft.ErrTransportShutdown
+		}
+	}
+
+	select {
+	case resp := <-respCh:
+		return resp.Response, resp.Error
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.shutdownCh:
+		return nil, hraft.ErrTransportShutdown
+	}
+}
+
+// isHeartbeat reports whether an AppendEntries request only asserts
+// leadership, as hashicorp/raft's own transport decides it
+func isHeartbeat(req *hraft.AppendEntriesRequest) bool {
+	leader := req.RPCHeader.Addr
+	if len(leader) == 0 {
+		leader = req.Leader
+	}
+	return req.Term != 0 && leader != nil && req.PrevLogEntry == 0 && req.PrevLogTerm == 0 &&
+		len(req.Entries) == 0 && req.LeaderCommitIndex == 0
+}
+
+// Handler serves the RPCs of HTTPTransport for this node under /raft/
+func (n *Node) Handler(token string) http.Handler {
+	t := n.transport
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /raft/append", rpc(token, func(r *http.Request, req *hraft.AppendEntriesRequest) (interface{}, error) {
+		return t.dispatch(r.Context(), req, nil, isHeartbeat(req))
+	}))
+	mux.HandleFunc("POST /raft/vote", rpc(token, func(r *http.Request, req *hraft.RequestVoteRequest) (interface{}, error) {
+		return t.dispatch(r.Context(), req, nil, false)
+	}))
+	mux.HandleFunc("POST /raft/timeout", rpc(token, func(r *http.Request, req *hraft.TimeoutNowRequest) (interface{}, error) {
+		return t.dispatch(r.Context(), req, nil, false)
+	}))
+	mux.HandleFunc("POST /raft/forward", rpc(token, func(r *http.Request, req *ForwardRequest) (interface{}, error) {
+		return n.handleForward(r.Context(), req), nil
+	}))
+	mux.HandleFunc("POST /raft/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(r, token) {
+			http.Error(w, "invalid raft token", http.StatusUnauthorized)
+			return
+		}
+		var req hraft.InstallSnapshotRequest
+		encoded, err := base64.StdEncoding.DecodeString(r.Header.Get(snapshotHeader))
+		if err == nil {
+			err = json.Unmarshal(encoded, &req)
+		}
+		if err != nil {
+			http.Error(w, "invalid snapshot request", http.StatusBadRequest)
+			return
+		}
+		resp, err := t.dispatch(r.Context(), &req, io.LimitReader(r.Body, req.Size), false)
+		reply(w, resp, err)
+	})
+	return mux
+}
+
+// validToken reports whether a request carries the shared token
+func validToken(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(TokenHeader)), []byte(token)) == 1
+}
+
+// rpc decodes a JSON request, checks the shared token and encodes the reply
+func rpc[T any](token string, handle func(r *http.Request, req *T) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validToken(r, token) {
+			http.Error(w, "invalid raft token", http.StatusUnauthorized)
+			return
+		}
+
+		var req T
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := handle(r, &req)
+		reply(w, resp, err)
+	}
+}
+
+// reply encodes the response to an RPC, or its error
+func reply(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, hraft.ErrTransportShutdown) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,179 @@
+// Package recovery tracks the work a node does after a restart before it
+// can serve data, so clients and load balancers can tell a recovering node
+// from a hung one.
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+// Phases of startup recovery
+const (
+	PhaseMultipartUploads = "multipart_uploads" // Re-registering the space of in-progress uploads
+	PhaseAllocations      = "allocations"       // Rebuilding the allocator from object metadata
+)
+
+// PhaseStatus is the progress of one recovery phase
+type PhaseStatus struct {
+	Name       string     `json:"name"`
+	Done       int64      `json:"done"`
+	Total      int64      `json:"total"` // 0 while unknown
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// finished reports whether the phase has ended
+func (p *PhaseStatus) finished() bool {
+	return p.FinishedAt != nil
+}
+
+// fraction returns how much of the phase is done, 0-1
+func (p *PhaseStatus) fraction() float64 {
+	switch {
+	case p.finished():
+		return 1
+	case p.Total <= 0:
+		return 0
+	default:
+		// Totals are estimates, so a phase is not done until it ends
+		return min(float64(p.Done)/float64(p.Total), 0.99)
+	}
+}
+
+// Status is the progress of startup recovery
+type Status struct {
+	Recovering bool          `json:"recovering"`
+	Percent    float64       `json:"percent"`
+	Phase      string        `json:"phase,omitempty"` // Running phase
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Error      string        `json:"error,omitempty"` // Set if a phase failed, leaving recovery stuck
+	Phases     []PhaseStatus `json:"phases"`
+}
+
+// Tracker records the progress of startup recovery through a fixed list
+// of phases, run in order
+type Tracker struct {
+	mu      sync.Mutex
+	status  Status
+	current int
+}
+
+// NewTracker starts tracking a recovery made of phases
+func NewTracker(phases ...string) *Tracker {
+	t := &Tracker{
+		status: Status{
+			Recovering: true,
+			StartedAt:  time.Now(),
+			Phases:     make([]PhaseStatus, len(phases)),
+		},
+		current: -1,
+	}
+	for i, name := range phases {
+		t.status.Phases[i].Name = name
+	}
+	return t
+}
+
+// Begin starts the named phase, which is expected to take total steps,
+// 0 if unknown
+func (t *Tracker) Begin(name string, total int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.status.Phases {
+		if t.status.Phases[i].Name == name {
+			now := time.Now()
+			t.current = i
+			t.status.Phases[i].StartedAt = &now
+			t.status.Phases[i].Total = total
+			return
+		}
+	}
+}
+
+// Advance records n more steps of the running phase
+func (t *Tracker) Advance(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current >= 0 {
+		p := &t.status.Phases[t.current]
+		p.Done += n
+		p.Total = max(p.Total, p.Done)
+	}
+}
+
+// End ends the running phase, with the error it failed with if any
+func (t *Tracker) End(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current < 0 {
+		return
+	}
+	now := time.Now()
+	p := &t.status.Phases[t.current]
+	p.FinishedAt = &now
+	if err != nil {
+		p.Error = err.Error()
+		t.status.Error = p.Name + ": " + p.Error
+	}
+	t.current = -1
+}
+
+// Finish marks recovery complete. Phases that did not run count as done.
+func (t *Tracker) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.status.Recovering = false
+	t.status.FinishedAt = &now
+	t.current = -1
+}
+
+// Recovering reports whether recovery is still running
+func (t *Tracker) Recovering() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status.Recovering
+}
+
+// Status returns the progress of recovery
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := t.status
+	status.Phases = append([]PhaseStatus(nil), t.status.Phases...)
+	if !status.Recovering {
+		status.Percent = 100
+		return status
+	}
+
+	var done float64
+	for i := range status.Phases {
+		done += status.Phases[i].fraction()
+	}
+	if len(status.Phases) > 0 {
+		status.Percent = done * 100 / float64(len(status.Phases))
+	}
+	if t.current >= 0 {
+		status.Phase = status.Phases[t.current].Name
+	}
+	return status
+}
+
+// RetryAfter estimates how long until recovery completes from its progress
+// so far, within [minimum, maximum]
+func (s Status) RetryAfter(minimum, maximum time.Duration) time.Duration {
+	if s.Percent <= 0 {
+		return maximum
+	}
+	elapsed := time.Since(s.StartedAt)
+	remaining := time.Duration(float64(elapsed) * (100 - s.Percent) / s.Percent)
+	return min(max(remaining, minimum), maximum)
+}
@@ -0,0 +1,63 @@
+package recovery
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTracker_Progress(t *testing.T) {
+	tracker := NewTracker("first", "second")
+	if status := tracker.Status(); !status.Recovering || status.Percent != 0 || len(status.Phases) != 2 {
+		t.Fatalf("initial status = %+v", status)
+	}
+
+	tracker.Begin("first", 0)
+	tracker.Advance(5)
+	tracker.End(nil)
+
+	tracker.Begin("second", 10)
+	tracker.Advance(5)
+	status := tracker.Status()
+	if status.Phase != "second" || status.Percent != 75 {
+		t.Errorf("status = %+v, want second phase half done", status)
+	}
+
+	// Totals are estimates: more steps than expected never reach 100%
+	tracker.Advance(10)
+	if status := tracker.Status(); status.Percent >= 100 || status.Phases[1].Total != 15 {
+		t.Errorf("status = %+v", status)
+	}
+
+	tracker.End(nil)
+	tracker.Finish()
+	status = tracker.Status()
+	if status.Recovering || status.Percent != 100 || status.FinishedAt == nil || status.Phase != "" {
+		t.Errorf("final status = %+v", status)
+	}
+}
+
+func TestTracker_Error(t *testing.T) {
+	tracker := NewTracker("scan")
+	tracker.Begin("scan", 4)
+	tracker.End(errors.New("disk on fire"))
+
+	status := tracker.Status()
+	if !tracker.Recovering() || status.Error != "scan: disk on fire" || status.Phases[0].Error != "disk on fire" {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestStatus_RetryAfter(t *testing.T) {
+	status := Status{StartedAt: time.Now().Add(-10 * time.Second), Percent: 50}
+	if got := status.RetryAfter(time.Second, time.Minute); got < 9*time.Second || got > 11*time.Second {
+		t.Errorf("RetryAfter() = %v, want about 10s", got)
+	}
+	if got := (Status{StartedAt: time.Now()}).RetryAfter(time.Second, time.Minute); got != time.Minute {
+		t.Errorf("RetryAfter() without progress = %v, want the maximum", got)
+	}
+	status.Percent = 99.99
+	if got := status.RetryAfter(time.Second, time.Minute); got != time.Second {
+		t.Errorf("RetryAfter() near the end = %v, want the minimum", got)
+	}
+}
@@ -0,0 +1,362 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/metafile"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// DefaultBootstrapPageSize is how many objects a manifest page lists
+const DefaultBootstrapPageSize = 100
+
+// ErrSnapshotNotFound is returned when the source no longer holds a
+// bootstrap snapshot, because it expired or the source restarted
+var ErrSnapshotNotFound = errors.New("bootstrap snapshot not found")
+
+// BootstrapSnapshot is a point-in-time view of a source's buckets, held on
+// the source until released so a new replica can copy it
+type BootstrapSnapshot struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Buckets   []BootstrapBucket `json:"buckets"`
+}
+
+// BootstrapBucket is a bucket of a bootstrap snapshot
+type BootstrapBucket struct {
+	Name     string          `json:"name"`
+	Objects  int             `json:"objects"`
+	Size     int64           `json:"size"`
+	Settings json.RawMessage `json:"settings"` // The bucket as the source stores it
+}
+
+// ManifestEntry describes an object of a bootstrap snapshot
+type ManifestEntry struct {
+	Key         string            `json:"key"`
+	Size        int64             `json:"size"`
+	ETag        string            `json:"etag"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	ModifiedAt  time.Time         `json:"modified_at"`
+}
+
+// ManifestPage is a page of the objects of a bucket in a bootstrap
+// snapshot, in key order
+type ManifestPage struct {
+	Objects   []ManifestEntry `json:"objects"`
+	NextAfter string          `json:"next_after,omitempty"` // Empty on the last page
+}
+
+// BootstrapTarget stores what a bootstrap copies on the new replica
+type BootstrapTarget interface {
+	// ApplyBucket creates a bucket, or updates its settings, to match the
+	// source's
+	ApplyBucket(ctx context.Context, bucket BootstrapBucket) error
+	// ObjectETag returns the ETag of the replica's copy of an object, if any
+	ObjectETag(ctx context.Context, bucket, key string) (string, bool)
+	// PutObject stores an object read from the source
+	PutObject(ctx context.Context, bucket string, entry ManifestEntry, data io.Reader) error
+}
+
+// BootstrapCheckpoint records how far a bootstrap got, so an interrupted
+// one resumes instead of copying everything again
+type BootstrapCheckpoint struct {
+	SourceURL  string    `json:"source_url"`
+	SnapshotID string    `json:"snapshot_id"`
+	Done       []string  `json:"done,omitempty"`   // Buckets fully copied
+	Bucket     string    `json:"bucket,omitempty"` // Bucket being copied
+	After      string    `json:"after,omitempty"`  // Last key of Bucket handled
+	Objects    int64     `json:"objects"`          // Objects copied
+	Bytes      int64     `json:"bytes"`
+	Skipped    int64     `json:"skipped"` // Objects already current on the replica
+	Completed  bool      `json:"completed"`
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// BootstrapConfig holds the settings of a bootstrap
+type BootstrapConfig struct {
+	SourceURL      string
+	Token          string // Bearer token for the source
+	PageSize       int
+	CheckpointPath string
+}
+
+// Bootstrapper fills a new replica with a snapshot of its source before it
+// relies on incremental replication. Replication from the source should be
+// running already: writes made after the snapshot reach the replica as
+// events, and objects those events touched are not overwritten with the
+// older snapshot copy.
+type Bootstrapper struct {
+	config  BootstrapConfig
+	client  *http.Client
+	target  BootstrapTarget
+	replica *ReplicaState
+	writer  *metafile.Writer
+}
+
+// NewBootstrapper creates a bootstrapper copying from config.SourceURL
+func NewBootstrapper(config BootstrapConfig, target BootstrapTarget) *Bootstrapper {
+	if config.PageSize <= 0 {
+		config.PageSize = DefaultBootstrapPageSize
+	}
+	return &Bootstrapper{
+		config: config,
+		client: &http.Client{Timeout: 5 * time.Minute},
+		target: target,
+		writer: metafile.NewWriter(metafile.DurabilityFull),
+	}
+}
+
+// SetReplicaState skips objects the replica applied a replicated write or
+// delete of since the snapshot was taken
+func (b *Bootstrapper) SetReplicaState(state *ReplicaState) {
+	b.replica = state
+}
+
+// LoadBootstrapCheckpoint reads the checkpoint at path, nil if there is none
+func LoadBootstrapCheckpoint(path string) (*BootstrapCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap checkpoint: %w", err)
+	}
+	var cp BootstrapCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("invalid bootstrap checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// Run copies the source's buckets and objects, resuming from the
+// checkpoint of an interrupted run from the same source. progress, if set,
+// is called whenever the checkpoint is saved.
+func (b *Bootstrapper) Run(ctx context.Context, progress func(BootstrapCheckpoint)) error {
+	cp, err := LoadBootstrapCheckpoint(b.config.CheckpointPath)
+	if err != nil {
+		return err
+	}
+	if cp == nil || cp.SourceURL != b.config.SourceURL || cp.Completed {
+		cp = &BootstrapCheckpoint{SourceURL: b.config.SourceURL, StartedAt: time.Now()}
+	}
+	save := func() error {
+		cp.UpdatedAt = time.Now()
+		data, err := json.MarshalIndent(cp, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := b.writer.MkdirAll(filepath.Dir(b.config.CheckpointPath)); err != nil {
+			return fmt.Errorf("failed to create bootstrap checkpoint directory: %w", err)
+		}
+		if err := b.writer.WriteFile(b.config.CheckpointPath, data); err != nil {
+			return fmt.Errorf("failed to save bootstrap checkpoint: %w", err)
+		}
+		if progress != nil {
+			progress(*cp)
+		}
+		return nil
+	}
+
+	// Continue with the snapshot of the interrupted run while the source
+	// still holds it. Otherwise a new one is taken: what changed in the
+	// buckets already copied since reached the replica as events.
+	var snap *BootstrapSnapshot
+	if cp.SnapshotID != "" {
+		snap, err = b.getSnapshot(ctx, cp.SnapshotID)
+		if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+			return err
+		}
+	}
+	if snap == nil {
+		if snap, err = b.createSnapshot(ctx); err != nil {
+			return err
+		}
+		cp.SnapshotID = snap.ID
+	}
+	if err := save(); err != nil {
+		return err
+	}
+
+	monitoring.Log.Info("Bootstrapping replica",
+		zap.String("source", b.config.SourceURL),
+		zap.String("snapshot", snap.ID),
+		zap.Int("buckets", len(snap.Buckets)),
+		zap.Strings("done", cp.Done))
+
+	done := make(map[string]bool, len(cp.Done))
+	for _, name := range cp.Done {
+		done[name] = true
+	}
+	buckets := append([]BootstrapBucket(nil), snap.Buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+
+	for _, bucket := range buckets {
+		if done[bucket.Name] {
+			continue
+		}
+		if err := b.target.ApplyBucket(ctx, bucket); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", bucket.Name, err)
+		}
+
+		after := ""
+		if cp.Bucket == bucket.Name {
+			after = cp.After
+		}
+		cp.Bucket, cp.After = bucket.Name, after
+
+		for {
+			page, err := b.listObjects(ctx, snap.ID, bucket.Name, after)
+			if err != nil {
+				return err
+			}
+			for _, entry := range page.Objects {
+				copied, err := b.copyObject(ctx, snap, bucket.Name, entry)
+				if err != nil {
+					return fmt.Errorf("failed to copy %s/%s: %w", bucket.Name, entry.Key, err)
+				}
+				if copied {
+					cp.Objects++
+					cp.Bytes += entry.Size
+				} else {
+					cp.Skipped++
+				}
+				cp.After = entry.Key
+			}
+			if err := save(); err != nil {
+				return err
+			}
+			if page.NextAfter == "" {
+				break
+			}
+			after = page.NextAfter
+		}
+
+		cp.Done = append(cp.Done, bucket.Name)
+		cp.Bucket, cp.After = "", ""
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	cp.Completed = true
+	if err := save(); err != nil {
+		return err
+	}
+	if err := b.releaseSnapshot(ctx, snap.ID); err != nil {
+		monitoring.Log.Warn("Failed to release bootstrap snapshot on the source",
+			zap.String("snapshot", snap.ID),
+			zap.Error(err))
+	}
+
+	monitoring.Log.Info("Replica bootstrapped",
+		zap.Int64("objects", cp.Objects),
+		zap.Int64("bytes", cp.Bytes),
+		zap.Int64("skipped", cp.Skipped))
+	return nil
+}
+
+// copyObject copies an object unless the replica already holds it or a
+// newer write of it, and reports whether it was copied
+func (b *Bootstrapper) copyObject(ctx context.Context, snap *BootstrapSnapshot, bucket string, entry ManifestEntry) (bool, error) {
+	if b.replica != nil && b.replica.AppliedSince(bucket, entry.Key, snap.CreatedAt) {
+		return false, nil
+	}
+	if etag, ok := b.target.ObjectETag(ctx, bucket, entry.Key); ok && etag == entry.ETag {
+		return false, nil
+	}
+
+	resp, err := b.do(ctx, "GET", fmt.Sprintf("/snapshots/%s/buckets/%s/data/%s", snap.ID, bucket, (&url.URL{Path: entry.Key}).EscapedPath()), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if err := b.target.PutObject(ctx, bucket, entry, resp.Body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bootstrapper) createSnapshot(ctx context.Context) (*BootstrapSnapshot, error) {
+	var snap BootstrapSnapshot
+	if err := b.getJSON(ctx, "POST", "/snapshots", &snap); err != nil {
+		return nil, fmt.Errorf("failed to create bootstrap snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+func (b *Bootstrapper) getSnapshot(ctx context.Context, id string) (*BootstrapSnapshot, error) {
+	var snap BootstrapSnapshot
+	if err := b.getJSON(ctx, "GET", "/snapshots/"+id, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func (b *Bootstrapper) listObjects(ctx context.Context, id, bucket, after string) (*ManifestPage, error) {
+	query := url.Values{"limit": {fmt.Sprint(b.config.PageSize)}}
+	if after != "" {
+		query.Set("after", after)
+	}
+	var page ManifestPage
+	if err := b.getJSON(ctx, "GET", fmt.Sprintf("/snapshots/%s/buckets/%s/objects?%s", id, bucket, query.Encode()), &page); err != nil {
+		return nil, fmt.Errorf("failed to list objects of %s: %w", bucket, err)
+	}
+	return &page, nil
+}
+
+func (b *Bootstrapper) releaseSnapshot(ctx context.Context, id string) error {
+	resp, err := b.do(ctx, "DELETE", "/snapshots/"+id, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *Bootstrapper) getJSON(ctx context.Context, method, path string, v any) error {
+	resp, err := b.do(ctx, method, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do sends a request to the source's replication admin API, failing on
+// any status but success
+func (b *Bootstrapper) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.config.SourceURL+"/admin/v1/replication"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if b.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.config.Token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s %s", ErrSnapshotNotFound, method, path)
+		}
+		return nil, fmt.Errorf("source returned %d for %s %s", resp.StatusCode, method, path)
+	}
+	return resp, nil
+}
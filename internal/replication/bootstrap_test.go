@@ -0,0 +1,225 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource serves one bootstrap snapshot of fixed buckets
+type fakeSource struct {
+	mu        sync.Mutex
+	objects   map[string]map[string]string // bucket -> key -> data
+	snapshots map[string]BootstrapSnapshot
+	created   int
+	released  []string
+}
+
+func (s *fakeSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/admin/v1/replication/snapshots"), "/", 6)
+	switch {
+	case r.Method == "POST" && len(parts) == 1:
+		s.created++
+		snap := BootstrapSnapshot{ID: fmt.Sprintf("snap-%d", s.created), CreatedAt: time.Now()}
+		for name, objects := range s.objects {
+			snap.Buckets = append(snap.Buckets, BootstrapBucket{Name: name, Objects: len(objects), Settings: json.RawMessage(`{}`)})
+		}
+		s.snapshots[snap.ID] = snap
+		json.NewEncoder(w).Encode(snap)
+		return
+	case len(parts) < 2:
+		http.NotFound(w, r)
+		return
+	}
+
+	snap, ok := s.snapshots[parts[1]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case r.Method == "GET" && len(parts) == 2:
+		json.NewEncoder(w).Encode(snap)
+	case r.Method == "DELETE" && len(parts) == 2:
+		delete(s.snapshots, snap.ID)
+		s.released = append(s.released, snap.ID)
+	case len(parts) == 5 && parts[4] == "objects":
+		objects := s.objects[parts[3]]
+		keys := make([]string, 0, len(objects))
+		for key := range objects {
+			if key > r.URL.Query().Get("after") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var page ManifestPage
+		limit := 2
+		if len(keys) > limit {
+			keys = keys[:limit]
+			page.NextAfter = keys[limit-1]
+		}
+		for _, key := range keys {
+			page.Objects = append(page.Objects, ManifestEntry{Key: key, Size: int64(len(objects[key])), ETag: objects[key]})
+		}
+		json.NewEncoder(w).Encode(page)
+	case len(parts) == 6 && parts[4] == "data":
+		io.WriteString(w, s.objects[parts[3]][parts[5]])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeTarget stores objects in memory, failing puts once fail reaches zero
+type fakeTarget struct {
+	buckets map[string]bool
+	objects map[string]string
+	fail    int
+}
+
+func (t *fakeTarget) ApplyBucket(ctx context.Context, bucket BootstrapBucket) error {
+	t.buckets[bucket.Name] = true
+	return nil
+}
+
+func (t *fakeTarget) ObjectETag(ctx context.Context, bucket, key string) (string, bool) {
+	data, ok := t.objects[bucket+"/"+key]
+	return data, ok
+}
+
+func (t *fakeTarget) PutObject(ctx context.Context, bucket string, entry ManifestEntry, data io.Reader) error {
+	if t.fail--; t.fail == 0 {
+		return errors.New("disk full")
+	}
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	t.objects[bucket+"/"+entry.Key] = string(b)
+	return nil
+}
+
+func TestBootstrapper_Resume(t *testing.T) {
+	source := &fakeSource{
+		objects: map[string]map[string]string{
+			"a": {"1": "one", "2": "two", "3": "three"},
+			"b": {"x": "ex", "y": "why"},
+		},
+		snapshots: make(map[string]BootstrapSnapshot),
+	}
+	server := httptest.NewServer(source)
+	defer server.Close()
+
+	checkpoint := filepath.Join(t.TempDir(), "bootstrap.json")
+	target := &fakeTarget{buckets: map[string]bool{}, objects: map[string]string{}, fail: 5}
+	config := BootstrapConfig{SourceURL: server.URL, CheckpointPath: checkpoint}
+
+	// The fifth put fails while bucket b is copied
+	if err := NewBootstrapper(config, target).Run(context.Background(), nil); err == nil {
+		t.Fatal("Run() should fail when the target does")
+	}
+	cp, err := LoadBootstrapCheckpoint(checkpoint)
+	if err != nil || cp == nil {
+		t.Fatalf("LoadBootstrapCheckpoint() = %v, %v", cp, err)
+	}
+	if cp.Completed || len(cp.Done) != 1 || cp.Done[0] != "a" || cp.Objects != 3 {
+		t.Fatalf("checkpoint = %+v, want bucket a done", cp)
+	}
+
+	// Resuming continues with the same snapshot, copying only what is left
+	var progress []BootstrapCheckpoint
+	if err := NewBootstrapper(config, target).Run(context.Background(), func(cp BootstrapCheckpoint) {
+		progress = append(progress, cp)
+	}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if source.created != 1 {
+		t.Errorf("snapshots created = %d, want 1", source.created)
+	}
+	if len(source.released) != 1 || len(source.snapshots) != 0 {
+		t.Errorf("snapshot not released: %v", source.released)
+	}
+	if len(target.objects) != 5 || target.objects["b/y"] != "why" || !target.buckets["b"] {
+		t.Errorf("target = %v", target.objects)
+	}
+	last := progress[len(progress)-1]
+	// b/x was stored before the failure, and is current on the replica
+	if !last.Completed || last.Objects != 4 || last.Skipped != 1 {
+		t.Errorf("last progress = %+v, want 4 objects copied and 1 skipped", last)
+	}
+
+	// A completed bootstrap starts over, skipping objects already current
+	if err := NewBootstrapper(config, target).Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if cp, _ := LoadBootstrapCheckpoint(checkpoint); cp.Objects != 0 || cp.Skipped != 5 {
+		t.Errorf("checkpoint = %+v, want all skipped", cp)
+	}
+}
+
+func TestBootstrapper_SkipsReplicatedWrites(t *testing.T) {
+	source := &fakeSource{
+		objects:   map[string]map[string]string{"a": {"old": "snapshot", "new": "snapshot"}},
+		snapshots: make(map[string]BootstrapSnapshot),
+	}
+	server := httptest.NewServer(source)
+	defer server.Close()
+
+	target := &fakeTarget{buckets: map[string]bool{}, objects: map[string]string{"a/new": "replicated"}}
+	state := NewReplicaState()
+	bootstrapper := NewBootstrapper(BootstrapConfig{
+		SourceURL:      server.URL,
+		CheckpointPath: filepath.Join(t.TempDir(), "bootstrap.json"),
+	}, target)
+	bootstrapper.SetReplicaState(state)
+
+	// A write replicated after the snapshot was taken is newer than it
+	state.Record("a", "new", time.Now().Add(time.Hour))
+	state.Record("a", "old", time.Now().Add(-time.Hour))
+
+	if err := bootstrapper.Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if target.objects["a/new"] != "replicated" {
+		t.Errorf("replicated write overwritten with %q", target.objects["a/new"])
+	}
+	if target.objects["a/old"] != "snapshot" {
+		t.Errorf("a/old = %q, want copied", target.objects["a/old"])
+	}
+}
+
+func TestBootstrapper_SnapshotExpired(t *testing.T) {
+	source := &fakeSource{
+		objects:   map[string]map[string]string{"a": {"1": "one"}},
+		snapshots: make(map[string]BootstrapSnapshot),
+	}
+	server := httptest.NewServer(source)
+	defer server.Close()
+
+	checkpoint := filepath.Join(t.TempDir(), "bootstrap.json")
+	data, _ := json.Marshal(BootstrapCheckpoint{SourceURL: server.URL, SnapshotID: "gone"})
+	if err := os.WriteFile(checkpoint, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	target := &fakeTarget{buckets: map[string]bool{}, objects: map[string]string{}}
+	if err := NewBootstrapper(BootstrapConfig{SourceURL: server.URL, CheckpointPath: checkpoint}, target).Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if source.created != 1 || target.objects["a/1"] != "one" {
+		t.Errorf("created = %d, objects = %v; want a new snapshot copied", source.created, target.objects)
+	}
+}
@@ -7,13 +7,35 @@ type Config struct {
 	Mode          Mode          `yaml:"mode"` // async, sync
 	RemoteURL     string        `yaml:"remote_url"`
 	RemoteToken   string        `yaml:"remote_token"`
-	LocalURL      string        `yaml:"local_url"` // Local server URL for fetching objects
+	NodeID        string        `yaml:"node_id"`     // Identifies this node to the remote in signed requests
+	SigningKey    string        `yaml:"signing_key"` // Key requests to the remote are signed with; the remote holds it under node_id
+	LocalURL      string        `yaml:"local_url"`   // Local server URL for fetching objects
 	BatchSize     int           `yaml:"batch_size"`
 	BatchInterval time.Duration `yaml:"batch_interval"`
 	RetryAttempts int           `yaml:"retry_attempts"`
 	RetryDelay    time.Duration `yaml:"retry_delay"`
+
+	// ShutdownTimeout bounds how long Stop waits for queued events to be
+	// sent before dropping the rest
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Delta replication sends overwrites as patches against the replaced
+	// version to remotes supporting /admin/replication/patch
+	DeltaEnabled   bool  `yaml:"delta_enabled"`
+	DeltaBlockSize int   `yaml:"delta_block_size"`
+	DeltaMaxSize   int64 `yaml:"delta_max_size"`
+
+	// Objects streamed from local storage are read PrefetchChunkSize bytes
+	// at a time, up to PrefetchDepth chunks ahead of the upload. At most
+	// MaxLargeTransfers objects larger than a chunk are streamed at once.
+	PrefetchChunkSize int64 `yaml:"prefetch_chunk_size"`
+	PrefetchDepth     int   `yaml:"prefetch_depth"`
+	MaxLargeTransfers int   `yaml:"max_large_transfers"`
 }
 
+// DefaultShutdownTimeout is how long Stop drains the queue by default
+const DefaultShutdownTimeout = 30 * time.Second
+
 type Mode string
 
 const (
@@ -30,5 +52,15 @@ func DefaultConfig() Config {
 		BatchInterval: 1 * time.Second,
 		RetryAttempts: 3,
 		RetryDelay:    5 * time.Second,
+
+		ShutdownTimeout: DefaultShutdownTimeout,
+
+		DeltaEnabled:   true,
+		DeltaBlockSize: DefaultDeltaBlockSize,
+		DeltaMaxSize:   DefaultDeltaMaxSize,
+
+		PrefetchChunkSize: DefaultPrefetchChunkSize,
+		PrefetchDepth:     DefaultPrefetchDepth,
+		MaxLargeTransfers: DefaultMaxLargeTransfers,
 	}
 }
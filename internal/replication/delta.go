@@ -0,0 +1,292 @@
+package replication
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/danielino/comio/internal/integrity"
+)
+
+const (
+	// DefaultDeltaBlockSize is the block size of delta signatures
+	DefaultDeltaBlockSize = 4096
+	// DefaultDeltaMaxSize bounds the objects that are delta encoded, since
+	// both versions are held in memory
+	DefaultDeltaMaxSize = 64 * 1024 * 1024
+
+	// maxDeltaRatio is the share of literal bytes above which a delta is
+	// not worth sending over a full copy
+	maxDeltaRatio = 0.5
+)
+
+// ErrInvalidDelta is returned when delta ops do not fit their base
+var ErrInvalidDelta = errors.New("invalid delta")
+
+// BaseVersion points to the version an overwrite replaced, which replicas
+// likely still hold and deltas can be computed against
+type BaseVersion struct {
+	ETag    string         `json:"etag"`
+	Pointer StoragePointer `json:"pointer"`
+}
+
+// DeltaOp is one instruction for rebuilding an object from its base: copy
+// Length bytes at Offset of the base, or insert Data
+type DeltaOp struct {
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+// Patch is the body of /admin/replication/patch: an overwrite expressed as
+// a delta against the version the replica is expected to hold
+type Patch struct {
+	Bucket      string    `json:"bucket"`
+	Key         string    `json:"key"`
+	BaseETag    string    `json:"base_etag"`
+	ETag        string    `json:"etag"` // MD5 of the rebuilt object
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	Ops         []DeltaOp `json:"ops"`
+}
+
+// LiteralBytes returns how many bytes the patch carries inline
+func (p *Patch) LiteralBytes() int64 {
+	var n int64
+	for _, op := range p.Ops {
+		n += int64(len(op.Data))
+	}
+	return n
+}
+
+// rollingChecksum is the rsync weak checksum over a sliding window
+type rollingChecksum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRollingChecksum(block []byte) rollingChecksum {
+	var r rollingChecksum
+	r.n = uint32(len(block))
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += (r.n - uint32(i)) * uint32(c)
+	}
+	return r
+}
+
+// roll slides the window one byte, dropping out and adding in
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r rollingChecksum) sum() uint32 {
+	return (r.b&0xffff)<<16 | r.a&0xffff
+}
+
+func strongSum(block []byte) [md5.Size]byte {
+	return md5.Sum(block)
+}
+
+// ComputeDelta encodes target as copies of blocks of base plus literal
+// data, using a rolling hash so matches are found at any offset
+func ComputeDelta(base, target []byte, blockSize int) []DeltaOp {
+	if blockSize <= 0 {
+		blockSize = DefaultDeltaBlockSize
+	}
+
+	// Signatures of the full base blocks, by weak checksum
+	blocks := make(map[uint32][]int64)
+	for off := 0; off+blockSize <= len(base); off += blockSize {
+		weak := newRollingChecksum(base[off : off+blockSize]).sum()
+		blocks[weak] = append(blocks[weak], int64(off))
+	}
+
+	var ops []DeltaOp
+	literalStart := 0
+
+	emitCopy := func(offset, length int64) {
+		if n := len(ops); n > 0 && ops[n-1].Data == nil && ops[n-1].Offset+ops[n-1].Length == offset {
+			ops[n-1].Length += length
+			return
+		}
+		ops = append(ops, DeltaOp{Offset: offset, Length: length})
+	}
+	emitLiteral := func(data []byte) {
+		if len(data) > 0 {
+			ops = append(ops, DeltaOp{Data: append([]byte(nil), data...)})
+		}
+	}
+
+	if len(blocks) == 0 || len(target) < blockSize {
+		emitLiteral(target)
+		return ops
+	}
+
+	pos := 0
+	rc := newRollingChecksum(target[:blockSize])
+	for pos+blockSize <= len(target) {
+		if match, ok := findBlock(blocks, rc.sum(), base, target[pos:pos+blockSize]); ok {
+			emitLiteral(target[literalStart:pos])
+			emitCopy(match, int64(blockSize))
+			pos += blockSize
+			literalStart = pos
+			if pos+blockSize <= len(target) {
+				rc = newRollingChecksum(target[pos : pos+blockSize])
+			}
+			continue
+		}
+
+		if pos+blockSize < len(target) {
+			rc.roll(target[pos], target[pos+blockSize])
+		}
+		pos++
+	}
+
+	emitLiteral(target[literalStart:])
+	return ops
+}
+
+// findBlock returns the base offset of a block matching window
+func findBlock(blocks map[uint32][]int64, weak uint32, base, window []byte) (int64, bool) {
+	candidates, ok := blocks[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := strongSum(window)
+	for _, off := range candidates {
+		if strongSum(base[off:off+int64(len(window))]) == strong {
+			return off, true
+		}
+	}
+	return 0, false
+}
+
+// ApplyDelta rebuilds an object from its base and delta ops
+func ApplyDelta(base []byte, ops []DeltaOp, size int64) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for _, op := range ops {
+		if op.Data != nil {
+			out = append(out, op.Data...)
+			continue
+		}
+		if op.Offset < 0 || op.Length < 0 || op.Offset+op.Length > int64(len(base)) {
+			return nil, fmt.Errorf("%w: copy %d+%d outside base of %d bytes", ErrInvalidDelta, op.Offset, op.Length, len(base))
+		}
+		out = append(out, base[op.Offset:op.Offset+op.Length]...)
+	}
+
+	if int64(len(out)) != size {
+		return nil, fmt.Errorf("%w: rebuilt %d bytes, want %d", ErrInvalidDelta, len(out), size)
+	}
+	return out, nil
+}
+
+// md5Hex returns the hex MD5 of data, matching object ETags
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// StorageReader reads object data from the local storage engine
+type StorageReader func(ptr StoragePointer) ([]byte, error)
+
+// errNoDelta means the event should be replicated with a full copy
+var errNoDelta = errors.New("delta replication not applicable")
+
+// SetStorageReader lets the replicator read the previous and new version of
+// overwritten objects to compute deltas. It must be called before Start.
+func (r *Replicator) SetStorageReader(reader StorageReader) {
+	r.readStorage = reader
+}
+
+// replicateDelta sends an overwrite as a patch against the replaced
+// version. It returns errNoDelta when a full copy should be sent instead.
+func (r *Replicator) replicateDelta(event Event) error {
+	if !r.config.DeltaEnabled || event.Base == nil || r.readStorage == nil || r.patchUnsupported.Load() {
+		return errNoDelta
+	}
+
+	maxSize := r.config.DeltaMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultDeltaMaxSize
+	}
+	if event.Base.Pointer.Size > maxSize || (event.StoragePointer != nil && event.StoragePointer.Size > maxSize) {
+		return errNoDelta
+	}
+
+	target := event.Data
+	if target == nil {
+		if event.StoragePointer == nil {
+			return errNoDelta
+		}
+		var err error
+		if target, err = r.readStorage(*event.StoragePointer); err != nil {
+			return errNoDelta
+		}
+	}
+
+	base, err := r.readStorage(event.Base.Pointer)
+	if err != nil || !integrity.MatchesETag(base, event.Base.ETag) {
+		// The replaced version is no longer intact in local storage
+		return errNoDelta
+	}
+
+	patch := &Patch{
+		Bucket:   event.Bucket,
+		Key:      event.Key,
+		BaseETag: event.Base.ETag,
+		ETag:     md5Hex(target),
+		Size:     int64(len(target)),
+		Ops:      ComputeDelta(base, target, r.config.DeltaBlockSize),
+	}
+	if contentType, ok := event.Metadata["content_type"].(string); ok {
+		patch.ContentType = contentType
+	}
+	if float64(patch.LiteralBytes()) > maxDeltaRatio*float64(patch.Size) {
+		return errNoDelta
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	req, err := r.remoteRequest("POST", r.config.RemoteURL+"/admin/replication/patch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	stampReplication(req, event)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		r.mu.Lock()
+		r.stats.DeltaBytesSaved += patch.Size - int64(len(body))
+		r.mu.Unlock()
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// The remote predates patches; stop trying
+		r.patchUnsupported.Store(true)
+		return errNoDelta
+	case http.StatusConflict, http.StatusUnprocessableEntity:
+		// The replica does not hold the base version, or could not rebuild
+		// the object from it
+		return errNoDelta
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("remote returned %d applying patch: %s", resp.StatusCode, string(bodyBytes))
+	}
+}
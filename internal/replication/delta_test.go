@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func randomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestComputeDelta_RoundTrip(t *testing.T) {
+	base := randomData(64*1024, 1)
+
+	// Insert bytes in the middle so the tail shifts off block boundaries,
+	// and overwrite a range near the start
+	target := append([]byte(nil), base[:30000]...)
+	target = append(target, []byte("inserted bytes")...)
+	target = append(target, base[30000:]...)
+	copy(target[1000:], "changed")
+
+	ops := ComputeDelta(base, target, 1024)
+	patch := &Patch{Ops: ops, Size: int64(len(target))}
+	if literal := patch.LiteralBytes(); literal > 3*1024 {
+		t.Errorf("LiteralBytes() = %d, want only the changed blocks", literal)
+	}
+
+	rebuilt, err := ApplyDelta(base, ops, int64(len(target)))
+	if err != nil {
+		t.Fatalf("ApplyDelta() error = %v", err)
+	}
+	if !bytes.Equal(rebuilt, target) {
+		t.Error("rebuilt object differs from target")
+	}
+}
+
+func TestComputeDelta_NoCommonBlocks(t *testing.T) {
+	target := randomData(8*1024, 2)
+	ops := ComputeDelta(randomData(8*1024, 3), target, 1024)
+	if len(ops) != 1 || !bytes.Equal(ops[0].Data, target) {
+		t.Errorf("ComputeDelta() = %d ops, want the whole target as one literal", len(ops))
+	}
+
+	ops = ComputeDelta(nil, []byte("short"), 1024)
+	if rebuilt, err := ApplyDelta(nil, ops, 5); err != nil || string(rebuilt) != "short" {
+		t.Errorf("ApplyDelta() = %q, %v", rebuilt, err)
+	}
+}
+
+func TestApplyDelta_Invalid(t *testing.T) {
+	base := []byte("0123456789")
+	for _, tt := range []struct {
+		name string
+		ops  []DeltaOp
+		size int64
+	}{
+		{"copy past end", []DeltaOp{{Offset: 5, Length: 10}}, 10},
+		{"negative offset", []DeltaOp{{Offset: -1, Length: 2}}, 2},
+		{"wrong size", []DeltaOp{{Offset: 0, Length: 4}}, 5},
+	} {
+		if _, err := ApplyDelta(base, tt.ops, tt.size); !errors.Is(err, ErrInvalidDelta) {
+			t.Errorf("%s: error = %v, want ErrInvalidDelta", tt.name, err)
+		}
+	}
+}
+
+func deltaEvent(base, target []byte) (Event, StorageReader) {
+	storage := map[int64][]byte{0: base, 1: target}
+	reader := func(ptr StoragePointer) ([]byte, error) {
+		return storage[ptr.Offset], nil
+	}
+	return Event{
+		Type:           EventPutObject,
+		Bucket:         "test",
+		Key:            "obj",
+		StoragePointer: &StoragePointer{Offset: 1, Size: int64(len(target))},
+		Base:           &BaseVersion{ETag: md5Hex(base), Pointer: StoragePointer{Offset: 0, Size: int64(len(base))}},
+	}, reader
+}
+
+func TestReplicator_ReplicateDelta(t *testing.T) {
+	base := randomData(32*1024, 4)
+	target := append([]byte(nil), base...)
+	copy(target[5000:], "patched")
+
+	var received Patch
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/replication/patch" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.RemoteURL = server.URL
+	r := NewReplicator(config)
+	event, reader := deltaEvent(base, target)
+	r.SetStorageReader(reader)
+
+	if err := r.replicateDelta(event); err != nil {
+		t.Fatalf("replicateDelta() error = %v", err)
+	}
+	if received.BaseETag != md5Hex(base) || received.ETag != md5Hex(target) {
+		t.Errorf("patch etags = %s -> %s", received.BaseETag, received.ETag)
+	}
+	rebuilt, err := ApplyDelta(base, received.Ops, received.Size)
+	if err != nil || !bytes.Equal(rebuilt, target) {
+		t.Errorf("patch does not rebuild the target: %v", err)
+	}
+	if r.GetStats().DeltaBytesSaved <= 0 {
+		t.Errorf("DeltaBytesSaved = %d, want > 0", r.GetStats().DeltaBytesSaved)
+	}
+}
+
+func TestReplicator_ReplicateDelta_Fallback(t *testing.T) {
+	base := randomData(32*1024, 5)
+	target := append([]byte(nil), base...)
+	copy(target[100:], "patched")
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.RemoteURL = server.URL
+	r := NewReplicator(config)
+	event, reader := deltaEvent(base, target)
+	r.SetStorageReader(reader)
+
+	// A remote without the patch endpoint gets full copies from then on
+	if err := r.replicateDelta(event); !errors.Is(err, errNoDelta) {
+		t.Fatalf("replicateDelta() error = %v, want errNoDelta", err)
+	}
+	if err := r.replicateDelta(event); !errors.Is(err, errNoDelta) {
+		t.Fatalf("replicateDelta() error = %v, want errNoDelta", err)
+	}
+	if calls != 1 {
+		t.Errorf("remote called %d times, want 1", calls)
+	}
+
+	// Mostly rewritten objects are not worth a delta
+	r = NewReplicator(config)
+	event, reader = deltaEvent(base, randomData(32*1024, 6))
+	r.SetStorageReader(reader)
+	if err := r.replicateDelta(event); !errors.Is(err, errNoDelta) {
+		t.Errorf("replicateDelta() error = %v, want errNoDelta", err)
+	}
+
+	// The base changed in local storage since the event was queued
+	event, reader = deltaEvent(base, target)
+	event.Base.ETag = "stale"
+	r.SetStorageReader(reader)
+	if err := r.replicateDelta(event); !errors.Is(err, errNoDelta) {
+		t.Errorf("replicateDelta() error = %v, want errNoDelta", err)
+	}
+	if calls != 1 {
+		t.Errorf("remote called %d times, want 1", calls)
+	}
+}
@@ -17,6 +17,20 @@ type StoragePointer struct {
 	Size   int64 `json:"size"`
 }
 
+// Manifest describes an object assembled from multipart upload parts, so
+// it can be replicated part by part with ranged reads of the source
+type Manifest struct {
+	ETag  string         `json:"etag"` // Source object ETag; guards against the object changing mid-transfer
+	Parts []ManifestPart `json:"parts"`
+}
+
+// ManifestPart is one part of a Manifest, at Offset within the object
+type ManifestPart struct {
+	PartNumber int   `json:"part_number"`
+	Offset     int64 `json:"offset"`
+	Size       int64 `json:"size"`
+}
+
 type Event struct {
 	ID             string                 `json:"id"`
 	Type           EventType              `json:"type"`
@@ -27,4 +41,8 @@ type Event struct {
 	Data           []byte                 `json:"data,omitempty"`            // For small objects (<1MB) - inline data
 	DataURL        string                 `json:"data_url,omitempty"`        // For large objects - external URL
 	StoragePointer *StoragePointer        `json:"storage_pointer,omitempty"` // For objects in local storage - avoids memory copy
+	Manifest       *Manifest              `json:"manifest,omitempty"`        // For multipart objects - replicated part by part
+	Base           *BaseVersion           `json:"base,omitempty"`            // For overwrites - the replaced version, for deltas
+
+	barrier *barrier // For bucket-level events - holds the other workers until sent
 }
@@ -0,0 +1,61 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrReplicaMismatch is returned when the remote does not hold the
+// requested version of an object
+var ErrReplicaMismatch = errors.New("remote holds a different version of the object")
+
+// FetchObject reads length bytes at start of an object from the remote,
+// for reads the local device cannot serve. The remote copy must have the
+// given ETag, so a lagging replica never serves an older version.
+func (r *Replicator) FetchObject(ctx context.Context, bucket, key, etag string, start, length int64) (io.ReadCloser, error) {
+	if !r.config.Enabled || r.config.RemoteURL == "" {
+		return nil, errors.New("replication is disabled")
+	}
+
+	req, err := r.remoteRequest("GET", fmt.Sprintf("%s/%s/%s", r.config.RemoteURL, bucket, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("remote returned %d", resp.StatusCode)
+	}
+	if got := strings.Trim(resp.Header.Get("ETag"), `"`); got != etag {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s, want %s", ErrReplicaMismatch, got, etag)
+	}
+
+	// A remote ignoring the range sends the whole object
+	if resp.StatusCode == http.StatusOK && start > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return &limitedBody{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+}
+
+// limitedBody bounds a response body while closing the underlying one
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,295 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+var (
+	// errSourceChanged is returned when the source object was replaced
+	// while its manifest was being replicated
+	errSourceChanged = errors.New("source object changed")
+	// errUploadLost is returned when the remote no longer knows the upload,
+	// e.g. after it expired there
+	errUploadLost = errors.New("remote upload no longer exists")
+)
+
+// transfer is the resumable state of a manifest replication: the remote
+// upload and the parts it already holds. A retry continues from here
+// instead of restarting from zero.
+type transfer struct {
+	UploadID string
+	Done     map[int]string // Part number -> remote ETag
+}
+
+// transfers tracks in-progress manifest replications by bucket/key/etag
+type transfers struct {
+	mu    sync.Mutex
+	state map[string]*transfer
+}
+
+func newTransfers() *transfers {
+	return &transfers{state: make(map[string]*transfer)}
+}
+
+func transferKey(event Event) string {
+	return event.Bucket + "/" + event.Key + "/" + event.Manifest.ETag
+}
+
+func (t *transfers) get(key string) *transfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state[key]
+}
+
+func (t *transfers) put(key string, tr *transfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state[key] = tr
+}
+
+// partETag returns the remote ETag of an already transferred part
+func (t *transfers) partETag(key string, partNumber int) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr, ok := t.state[key]; ok {
+		etag, done := tr.Done[partNumber]
+		return etag, done
+	}
+	return "", false
+}
+
+func (t *transfers) markDone(key string, partNumber int, etag string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr, ok := t.state[key]; ok {
+		tr.Done[partNumber] = etag
+	}
+}
+
+func (t *transfers) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.state, key)
+}
+
+// pending returns the number of manifest replications in progress
+func (t *transfers) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.state)
+}
+
+// remoteRequest builds an authenticated request to the remote
+func (r *Replicator) remoteRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.RemoteToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.RemoteToken)
+	}
+	return req, nil
+}
+
+// replicateManifest replicates a multipart object as a remote multipart
+// upload, fetching each part with a ranged read of the local object
+func (r *Replicator) replicateManifest(event Event) error {
+	objectURL := fmt.Sprintf("%s/%s/%s", r.config.RemoteURL, event.Bucket, event.Key)
+	key := transferKey(event)
+
+	tr := r.transfers.get(key)
+	if tr == nil {
+		uploadID, err := r.initiateRemoteUpload(objectURL, event)
+		if err != nil {
+			return err
+		}
+		tr = &transfer{UploadID: uploadID, Done: make(map[int]string)}
+		r.transfers.put(key, tr)
+	}
+
+	for _, part := range event.Manifest.Parts {
+		if _, ok := r.transfers.partETag(key, part.PartNumber); ok {
+			continue
+		}
+
+		etag, err := r.copyPart(objectURL, tr.UploadID, event, part)
+		if errors.Is(err, errSourceChanged) {
+			// A newer put replicates the new object; drop this stale one
+			monitoring.Log.Warn("Source object changed during replication, skipping stale event",
+				zap.String("event_id", event.ID),
+				zap.String("bucket", event.Bucket),
+				zap.String("key", event.Key))
+			r.abortRemoteUpload(objectURL, tr.UploadID)
+			r.transfers.remove(key)
+			return nil
+		}
+		if err != nil {
+			if errors.Is(err, errUploadLost) {
+				// Start over with a new remote upload on the next attempt
+				r.transfers.remove(key)
+			}
+			return fmt.Errorf("part %d: %w", part.PartNumber, err)
+		}
+		r.transfers.markDone(key, part.PartNumber, etag)
+	}
+
+	if err := r.completeRemoteUpload(objectURL, key, tr.UploadID, event); err != nil {
+		if errors.Is(err, errUploadLost) {
+			r.transfers.remove(key)
+		}
+		return err
+	}
+
+	r.transfers.remove(key)
+	return nil
+}
+
+func (r *Replicator) initiateRemoteUpload(objectURL string, event Event) (string, error) {
+	req, err := r.remoteRequest("POST", objectURL+"?uploads", nil)
+	if err != nil {
+		return "", err
+	}
+	if contentType, ok := event.Metadata["content_type"].(string); ok {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("remote returned %d initiating upload: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var result struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid initiate response from remote: %v", err)
+	}
+	return result.UploadID, nil
+}
+
+// copyPart streams one part from the local object to the remote upload
+// and returns the remote part ETag
+func (r *Replicator) copyPart(objectURL, uploadID string, event Event, part ManifestPart) (string, error) {
+	localURL := r.config.LocalURL
+	if localURL == "" {
+		localURL = "http://localhost:8080" // fallback
+	}
+
+	fetch, err := http.NewRequestWithContext(r.ctx, "GET", fmt.Sprintf("%s/%s/%s", localURL, event.Bucket, event.Key), nil)
+	if err != nil {
+		return "", err
+	}
+	fetch.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.Offset, part.Offset+part.Size-1))
+	// If the object was replaced, the full new object comes back instead of 206
+	fetch.Header.Set("If-Range", `"`+event.Manifest.ETag+`"`)
+
+	src, err := r.client.Do(fetch)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch part from local storage: %w", err)
+	}
+	defer src.Body.Close()
+
+	switch src.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK, http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable:
+		return "", errSourceChanged
+	default:
+		bodyBytes, _ := io.ReadAll(src.Body)
+		return "", fmt.Errorf("local storage returned %d: %s", src.StatusCode, string(bodyBytes))
+	}
+
+	url := fmt.Sprintf("%s?partNumber=%d&uploadId=%s", objectURL, part.PartNumber, uploadID)
+	req, err := r.remoteRequest("PUT", url, src.Body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = part.Size
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errUploadLost
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("remote returned %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func (r *Replicator) completeRemoteUpload(objectURL, key, uploadID string, event Event) error {
+	type completedPart struct {
+		PartNumber int    `json:"part_number"`
+		ETag       string `json:"etag"`
+	}
+	parts := make([]completedPart, 0, len(event.Manifest.Parts))
+	for _, p := range event.Manifest.Parts {
+		etag, _ := r.transfers.partETag(key, p.PartNumber)
+		parts = append(parts, completedPart{PartNumber: p.PartNumber, ETag: etag})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"parts": parts})
+	if err != nil {
+		return err
+	}
+
+	req, err := r.remoteRequest("POST", objectURL+"?uploadId="+uploadID, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	stampReplication(req, event)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errUploadLost
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("remote returned %d completing upload: %s", resp.StatusCode, string(bodyBytes))
+	}
+	return nil
+}
+
+// abortRemoteUpload releases a remote upload; failures are only logged
+// since the remote's cleanup janitor expires it eventually
+func (r *Replicator) abortRemoteUpload(objectURL, uploadID string) {
+	req, err := r.remoteRequest("DELETE", objectURL+"?uploadId="+uploadID, nil)
+	if err != nil {
+		return
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		monitoring.Log.Warn("Failed to abort remote multipart upload",
+			zap.String("upload_id", uploadID),
+			zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+}
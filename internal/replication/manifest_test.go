@@ -0,0 +1,152 @@
+package replication
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMultipartRemote records the multipart calls of a remote comio
+type fakeMultipartRemote struct {
+	mu         sync.Mutex
+	initiated  int
+	parts      map[string]string // Part number -> data
+	failPart   string            // Part number failing once with 500
+	completed  string
+	contentLen []int64
+}
+
+func (f *fakeMultipartRemote) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	switch {
+	case r.Method == "POST" && q.Has("uploads"):
+		f.initiated++
+		fmt.Fprintf(w, `{"upload_id":"up-%d"}`, f.initiated)
+	case r.Method == "PUT" && q.Has("partNumber"):
+		n := q.Get("partNumber")
+		if n == f.failPart {
+			f.failPart = ""
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.parts[n] = string(data)
+		f.contentLen = append(f.contentLen, r.ContentLength)
+		w.Header().Set("ETag", `"etag-`+n+`"`)
+	case r.Method == "POST" && q.Has("uploadId"):
+		var req struct {
+			Parts []struct {
+				PartNumber int    `json:"part_number"`
+				ETag       string `json:"etag"`
+			} `json:"parts"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var sb strings.Builder
+		for _, p := range req.Parts {
+			if p.ETag != fmt.Sprintf(`"etag-%d"`, p.PartNumber) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sb.WriteString(f.parts[fmt.Sprint(p.PartNumber)])
+		}
+		f.completed = sb.String()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// rangeSource serves ranged reads of one object guarded by If-Range
+func rangeSource(data, etag string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-Range") != `"`+etag+`"` {
+			w.Write([]byte(data))
+			return
+		}
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(data[start : end+1]))
+	}))
+}
+
+func manifestEvent(etag string) Event {
+	return Event{
+		ID:     "1",
+		Type:   EventPutObject,
+		Bucket: "test",
+		Key:    "big",
+		Manifest: &Manifest{
+			ETag: etag,
+			Parts: []ManifestPart{
+				{PartNumber: 1, Offset: 0, Size: 5},
+				{PartNumber: 2, Offset: 5, Size: 5},
+				{PartNumber: 3, Offset: 10, Size: 3},
+			},
+		},
+	}
+}
+
+func TestReplicator_ReplicateManifest_Resume(t *testing.T) {
+	local := rangeSource("hello, world!", "abc")
+	defer local.Close()
+	remote := &fakeMultipartRemote{parts: make(map[string]string), failPart: "2"}
+	server := httptest.NewServer(remote)
+	defer server.Close()
+
+	r := NewReplicator(Config{Enabled: true, RemoteURL: server.URL, LocalURL: local.URL})
+	event := manifestEvent("abc")
+
+	// Part 2 fails: the transfer is kept for the retry
+	if err := r.replicateManifest(event); err == nil {
+		t.Fatal("first attempt should fail")
+	}
+	if r.PendingTransfers() != 1 {
+		t.Errorf("PendingTransfers() = %d, want 1", r.PendingTransfers())
+	}
+
+	if err := r.replicateManifest(event); err != nil {
+		t.Fatalf("retry error = %v", err)
+	}
+
+	if remote.initiated != 1 {
+		t.Errorf("initiated %d uploads, want the retry to reuse the first", remote.initiated)
+	}
+	if len(remote.contentLen) != 3 {
+		t.Errorf("uploaded %d parts, want 3 (part 1 not resent)", len(remote.contentLen))
+	}
+	if remote.completed != "hello, world!" {
+		t.Errorf("completed object = %q, want %q", remote.completed, "hello, world!")
+	}
+	if r.PendingTransfers() != 0 {
+		t.Errorf("PendingTransfers() = %d, want 0", r.PendingTransfers())
+	}
+}
+
+func TestReplicator_ReplicateManifest_SourceChanged(t *testing.T) {
+	local := rangeSource("replaced data", "new")
+	defer local.Close()
+	remote := &fakeMultipartRemote{parts: make(map[string]string)}
+	server := httptest.NewServer(remote)
+	defer server.Close()
+
+	r := NewReplicator(Config{Enabled: true, RemoteURL: server.URL, LocalURL: local.URL})
+
+	// The stale event is dropped rather than retried forever
+	if err := r.replicateManifest(manifestEvent("old")); err != nil {
+		t.Fatalf("replicateManifest() error = %v", err)
+	}
+	if remote.completed != "" {
+		t.Errorf("stale object was completed on the remote: %q", remote.completed)
+	}
+	if r.PendingTransfers() != 0 {
+		t.Errorf("PendingTransfers() = %d, want 0", r.PendingTransfers())
+	}
+}
@@ -0,0 +1,65 @@
+package replication
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	eventsQueuedDesc = prometheus.NewDesc("comio_replication_events_queued_total",
+		"Events accepted into the replication queue", []string{"target"}, nil)
+	eventsReplicatedDesc = prometheus.NewDesc("comio_replication_events_replicated_total",
+		"Events replicated to the target", []string{"target"}, nil)
+	eventsFailedDesc = prometheus.NewDesc("comio_replication_events_failed_total",
+		"Events dropped or failed after all retries", []string{"target"}, nil)
+	queueLengthDesc = prometheus.NewDesc("comio_replication_queue_length",
+		"Events waiting to be replicated", []string{"target"}, nil)
+	lagDesc = prometheus.NewDesc("comio_replication_lag_seconds",
+		"Delay between the write and the replication of the last replicated event", []string{"target"}, nil)
+	deltaBytesSavedDesc = prometheus.NewDesc("comio_replication_delta_bytes_saved_total",
+		"Bytes not sent thanks to delta replication", []string{"target"}, nil)
+	breakerStateDesc = prometheus.NewDesc("comio_replication_circuit_breaker_state",
+		"Circuit breaker state of the target, 1 for the current state", []string{"target", "state"}, nil)
+)
+
+// Collector exports the stats of a replicator to Prometheus
+type Collector struct {
+	replicator *Replicator
+}
+
+// NewCollector creates a collector for replicator's stats
+func NewCollector(replicator *Replicator) *Collector {
+	return &Collector{replicator: replicator}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventsQueuedDesc
+	ch <- eventsReplicatedDesc
+	ch <- eventsFailedDesc
+	ch <- queueLengthDesc
+	ch <- lagDesc
+	ch <- deltaBytesSavedDesc
+	ch <- breakerStateDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	target := c.replicator.Target()
+	stats := c.replicator.GetStats()
+
+	ch <- prometheus.MustNewConstMetric(eventsQueuedDesc, prometheus.CounterValue, float64(stats.EventsQueued), target)
+	ch <- prometheus.MustNewConstMetric(eventsReplicatedDesc, prometheus.CounterValue, float64(stats.EventsReplicated), target)
+	ch <- prometheus.MustNewConstMetric(eventsFailedDesc, prometheus.CounterValue, float64(stats.EventsFailed), target)
+	ch <- prometheus.MustNewConstMetric(queueLengthDesc, prometheus.GaugeValue, float64(c.replicator.QueueLength()), target)
+	ch <- prometheus.MustNewConstMetric(lagDesc, prometheus.GaugeValue, stats.Lag.Seconds(), target)
+	ch <- prometheus.MustNewConstMetric(deltaBytesSavedDesc, prometheus.CounterValue, float64(stats.DeltaBytesSaved), target)
+
+	current := c.replicator.GetCircuitBreakerStats().State
+	for _, state := range []CircuitState{StateClosed, StateOpen, StateHalfOpen} {
+		value := 0.0
+		if state == current {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, value, target, string(state))
+	}
+}
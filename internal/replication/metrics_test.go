@@ -0,0 +1,50 @@
+package replication
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	replicator := NewReplicator(Config{Enabled: true, RemoteURL: "https://site-b.example.com"})
+	replicator.QueueEvent(Event{Type: EventDeleteObject, Bucket: "test", Key: "file1"})
+	replicator.stats.EventsReplicated = 3
+	replicator.stats.EventsFailed = 1
+	replicator.stats.Lag = 1500 * time.Millisecond
+
+	expected := `
+# HELP comio_replication_circuit_breaker_state Circuit breaker state of the target, 1 for the current state
+# TYPE comio_replication_circuit_breaker_state gauge
+comio_replication_circuit_breaker_state{state="closed",target="https://site-b.example.com"} 1
+comio_replication_circuit_breaker_state{state="half_open",target="https://site-b.example.com"} 0
+comio_replication_circuit_breaker_state{state="open",target="https://site-b.example.com"} 0
+# HELP comio_replication_events_failed_total Events dropped or failed after all retries
+# TYPE comio_replication_events_failed_total counter
+comio_replication_events_failed_total{target="https://site-b.example.com"} 1
+# HELP comio_replication_events_queued_total Events accepted into the replication queue
+# TYPE comio_replication_events_queued_total counter
+comio_replication_events_queued_total{target="https://site-b.example.com"} 1
+# HELP comio_replication_events_replicated_total Events replicated to the target
+# TYPE comio_replication_events_replicated_total counter
+comio_replication_events_replicated_total{target="https://site-b.example.com"} 3
+# HELP comio_replication_lag_seconds Delay between the write and the replication of the last replicated event
+# TYPE comio_replication_lag_seconds gauge
+comio_replication_lag_seconds{target="https://site-b.example.com"} 1.5
+# HELP comio_replication_queue_length Events waiting to be replicated
+# TYPE comio_replication_queue_length gauge
+comio_replication_queue_length{target="https://site-b.example.com"} 1
+`
+	if err := testutil.CollectAndCompare(NewCollector(replicator), strings.NewReader(expected),
+		"comio_replication_circuit_breaker_state",
+		"comio_replication_events_failed_total",
+		"comio_replication_events_queued_total",
+		"comio_replication_events_replicated_total",
+		"comio_replication_lag_seconds",
+		"comio_replication_queue_length",
+	); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,164 @@
+package replication
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultPrefetchChunkSize is the size of the reads of objects streamed
+	// from local storage
+	DefaultPrefetchChunkSize = 4 * 1024 * 1024
+	// DefaultPrefetchDepth is how many chunks are read ahead of the upload
+	DefaultPrefetchDepth = 2
+	// DefaultMaxLargeTransfers bounds the objects larger than a chunk
+	// streamed at once
+	DefaultMaxLargeTransfers = 2
+)
+
+// ObjectSource is the local data of an object being replicated. It stays
+// readable until Close, even if the object is deleted meanwhile.
+type ObjectSource interface {
+	Size() int64
+	// ReadAt returns length bytes of the object at start
+	ReadAt(start, length int64) ([]byte, error)
+	Close()
+}
+
+// ObjectOpener opens the local data of the object a put event replicates
+type ObjectOpener func(ctx context.Context, event Event) (ObjectSource, error)
+
+// SetObjectOpener lets the replicator stream objects from local storage
+// rather than fetch them from the local HTTP API. It must be called
+// before Start.
+func (r *Replicator) SetObjectOpener(open ObjectOpener) {
+	r.openObject = open
+}
+
+// acquireTransfer waits for a large transfer slot when size spans more
+// than one chunk, so replication does not flood the device with reads
+// competing with client traffic. It returns the release of the slot.
+func (r *Replicator) acquireTransfer(size int64) (func(), error) {
+	if size <= r.chunkSize() {
+		return func() {}, nil
+	}
+	select {
+	case r.largeTransfers <- struct{}{}:
+		return func() { <-r.largeTransfers }, nil
+	case <-r.ctx.Done():
+		return nil, r.ctx.Err()
+	}
+}
+
+func (r *Replicator) chunkSize() int64 {
+	if r.config.PrefetchChunkSize > 0 {
+		return r.config.PrefetchChunkSize
+	}
+	return DefaultPrefetchChunkSize
+}
+
+// openStream returns the body of a put replicated from local storage and
+// its length
+func (r *Replicator) openStream(event Event) (io.ReadCloser, int64, error) {
+	release, err := r.acquireTransfer(event.StoragePointer.Size)
+	if err != nil {
+		return nil, 0, err
+	}
+	src, err := r.openObject(r.ctx, event)
+	if err != nil {
+		release()
+		return nil, 0, err
+	}
+
+	depth := r.config.PrefetchDepth
+	if depth <= 0 {
+		depth = DefaultPrefetchDepth
+	}
+	body := newPrefetchReader(src, r.chunkSize(), depth)
+	body.release = release
+	return body, src.Size(), nil
+}
+
+// prefetchReader streams an object chunk by chunk, reading up to depth
+// chunks ahead of the consumer so device reads overlap the upload instead
+// of the whole object being read into memory first
+type prefetchReader struct {
+	src     ObjectSource
+	chunks  chan prefetchChunk
+	cur     []byte
+	err     error
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	release func()
+}
+
+type prefetchChunk struct {
+	data []byte
+	err  error
+}
+
+func newPrefetchReader(src ObjectSource, chunkSize int64, depth int) *prefetchReader {
+	p := &prefetchReader{
+		src:    src,
+		chunks: make(chan prefetchChunk, depth),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.prefetch(chunkSize)
+	return p
+}
+
+func (p *prefetchReader) prefetch(chunkSize int64) {
+	defer close(p.done)
+	defer close(p.chunks)
+
+	size := p.src.Size()
+	for start := int64(0); start < size; start += chunkSize {
+		data, err := p.src.ReadAt(start, min(chunkSize, size-start))
+		select {
+		case p.chunks <- prefetchChunk{data: data, err: err}:
+		case <-p.stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read implements io.Reader
+func (p *prefetchReader) Read(b []byte) (int, error) {
+	for len(p.cur) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		chunk, ok := <-p.chunks
+		switch {
+		case !ok:
+			p.err = io.EOF
+		case chunk.err != nil:
+			p.err = chunk.err
+		default:
+			p.cur = chunk.data
+		}
+	}
+	n := copy(b, p.cur)
+	p.cur = p.cur[n:]
+	return n, nil
+}
+
+// Close stops prefetching and releases the source and transfer slot. It
+// may be called more than once.
+func (p *prefetchReader) Close() error {
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.src.Close()
+		if p.release != nil {
+			p.release()
+		}
+	})
+	return nil
+}
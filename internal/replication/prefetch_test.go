@@ -0,0 +1,172 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memorySource is an ObjectSource over a byte slice
+type memorySource struct {
+	data   []byte
+	reads  int32
+	closed int32
+	err    error
+}
+
+func (m *memorySource) Size() int64 { return int64(len(m.data)) }
+
+func (m *memorySource) ReadAt(start, length int64) ([]byte, error) {
+	atomic.AddInt32(&m.reads, 1)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.data[start : start+length], nil
+}
+
+func (m *memorySource) Close() { atomic.AddInt32(&m.closed, 1) }
+
+func TestPrefetchReader(t *testing.T) {
+	src := &memorySource{data: []byte("0123456789")}
+	p := newPrefetchReader(src, 3, 2)
+
+	got, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, src.data) {
+		t.Errorf("read %q, want %q", got, src.data)
+	}
+	if reads := atomic.LoadInt32(&src.reads); reads != 4 {
+		t.Errorf("source reads = %d, want 4", reads)
+	}
+
+	p.Close()
+	p.Close()
+	if closed := atomic.LoadInt32(&src.closed); closed != 1 {
+		t.Errorf("source closed %d times, want 1", closed)
+	}
+}
+
+func TestPrefetchReader_Error(t *testing.T) {
+	readErr := errors.New("device error")
+	src := &memorySource{data: make([]byte, 10), err: readErr}
+	p := newPrefetchReader(src, 3, 2)
+	defer p.Close()
+
+	if _, err := io.ReadAll(p); !errors.Is(err, readErr) {
+		t.Errorf("ReadAll() error = %v, want %v", err, readErr)
+	}
+}
+
+func TestPrefetchReader_CloseEarly(t *testing.T) {
+	src := &memorySource{data: make([]byte, 1000)}
+	p := newPrefetchReader(src, 1, 2)
+
+	buf := make([]byte, 1)
+	if _, err := p.Read(buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	p.Close()
+
+	// Prefetching stops at most depth chunks ahead of the reader
+	if reads := atomic.LoadInt32(&src.reads); reads > 4 {
+		t.Errorf("source reads = %d after close, want at most 4", reads)
+	}
+	if closed := atomic.LoadInt32(&src.closed); closed != 1 {
+		t.Errorf("source closed %d times, want 1", closed)
+	}
+}
+
+func TestReplicator_StreamsFromObjectOpener(t *testing.T) {
+	data := bytes.Repeat([]byte("comio"), 1000)
+	var received atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(data)) {
+			t.Errorf("ContentLength = %d, want %d", r.ContentLength, len(data))
+		}
+		received.Store(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	replicator := NewReplicator(Config{
+		Enabled:           true,
+		Mode:              ModeAsync,
+		RemoteURL:         server.URL,
+		BatchSize:         10,
+		BatchInterval:     50 * time.Millisecond,
+		RetryAttempts:     1,
+		RetryDelay:        10 * time.Millisecond,
+		PrefetchChunkSize: 512,
+	})
+	src := &memorySource{data: data}
+	replicator.SetObjectOpener(func(ctx context.Context, event Event) (ObjectSource, error) {
+		return src, nil
+	})
+	replicator.Start()
+	defer replicator.Stop()
+
+	replicator.QueueEvent(Event{
+		Type:           EventPutObject,
+		Bucket:         "test",
+		Key:            "large",
+		StoragePointer: &StoragePointer{Offset: 4096, Size: int64(len(data))},
+	})
+	time.Sleep(300 * time.Millisecond)
+
+	if body, _ := received.Load().([]byte); !bytes.Equal(body, data) {
+		t.Errorf("received %d bytes, want the %d bytes of the object", len(body), len(data))
+	}
+	if closed := atomic.LoadInt32(&src.closed); closed != 1 {
+		t.Errorf("source closed %d times, want 1", closed)
+	}
+	if held := len(replicator.largeTransfers); held != 0 {
+		t.Errorf("%d large transfer slots held after replication, want 0", held)
+	}
+}
+
+func TestReplicator_LimitsLargeTransfers(t *testing.T) {
+	replicator := NewReplicator(Config{PrefetchChunkSize: 100, MaxLargeTransfers: 1})
+
+	release, err := replicator.acquireTransfer(1000)
+	if err != nil {
+		t.Fatalf("acquireTransfer() error = %v", err)
+	}
+
+	// Objects within a chunk are not limited
+	small, err := replicator.acquireTransfer(100)
+	if err != nil {
+		t.Fatalf("acquireTransfer(small) error = %v", err)
+	}
+	small()
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := replicator.acquireTransfer(1000)
+		if err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second large transfer started while the first was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second large transfer did not start after the first finished")
+	}
+}
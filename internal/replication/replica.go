@@ -0,0 +1,125 @@
+package replication
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers exchanged between primaries, replicas and clients
+const (
+	// HeaderReplicationTimestamp carries the primary write time of a
+	// replicated operation
+	HeaderReplicationTimestamp = "X-Comio-Replication-Timestamp"
+	// HeaderReplicaLag reports on replica reads how far behind the primary
+	// the replica was at its last applied write, in seconds
+	HeaderReplicaLag = "X-Comio-Replica-Lag"
+	// HeaderConsistencyToken is returned by writes on the primary and can
+	// be sent on replica reads to require at least that write be visible
+	HeaderConsistencyToken = "X-Comio-Consistency-Token"
+	// HeaderServedBy tells whether a replica read was proxied to the primary
+	HeaderServedBy = "X-Comio-Served-By"
+	// HeaderDeleteMarker asks a replica to apply a delete as a delete
+	// marker rather than removing the object
+	HeaderDeleteMarker = "X-Comio-Delete-Marker"
+)
+
+// MetadataDeleteMarker is the event metadata key carrying the version ID
+// of the delete marker a delete event propagates
+const MetadataDeleteMarker = "delete_marker_version"
+
+// maxTrackedKeys bounds the per-key write times a replica remembers.
+// Reads with a token for a forgotten key are proxied to the primary.
+const maxTrackedKeys = 100000
+
+// ConsistencyToken encodes a primary write time as a consistency token
+func ConsistencyToken(written time.Time) string {
+	return strconv.FormatInt(written.UnixNano(), 10)
+}
+
+// ParseConsistencyToken decodes a token from ConsistencyToken, or the
+// value of HeaderReplicationTimestamp
+func ParseConsistencyToken(token string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(token, 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, fmt.Errorf("invalid consistency token %q", token)
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// ReplicaState tracks what an async read replica has applied from its
+// primary, to report lag and honour read-your-writes tokens
+type ReplicaState struct {
+	mu          sync.RWMutex
+	applied     map[string]time.Time // bucket/key -> newest applied primary write
+	lag         time.Duration
+	lastApplied time.Time
+}
+
+// NewReplicaState creates an empty replica state
+func NewReplicaState() *ReplicaState {
+	return &ReplicaState{applied: make(map[string]time.Time)}
+}
+
+// Record notes that a write made on the primary at written was applied
+func (s *ReplicaState) Record(bucket, key string, written time.Time) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.applied) >= maxTrackedKeys {
+		s.applied = make(map[string]time.Time)
+	}
+	id := bucket + "/" + key
+	if written.After(s.applied[id]) {
+		s.applied[id] = written
+	}
+
+	s.lag = now.Sub(written)
+	if s.lag < 0 {
+		s.lag = 0 // Clock skew between sites
+	}
+	s.lastApplied = now
+}
+
+// AppliedSince reports whether a write or delete of bucket/key made on the
+// primary at or after t was applied
+func (s *ReplicaState) AppliedSince(bucket, key string, t time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	applied, ok := s.applied[bucket+"/"+key]
+	return ok && !applied.Before(t)
+}
+
+// Lag returns the replication delay of the last applied write. ok is false
+// until the replica has applied anything.
+func (s *ReplicaState) Lag() (lag time.Duration, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lag, !s.lastApplied.IsZero()
+}
+
+// CaughtUp reports whether the write a consistency token was issued for
+// is visible on this replica for bucket/key
+func (s *ReplicaState) CaughtUp(bucket, key, token string) bool {
+	written, err := ParseConsistencyToken(token)
+	if err != nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	applied, ok := s.applied[bucket+"/"+key]
+	return ok && !applied.Before(written)
+}
+
+// stampReplication marks req as applying event, so a read replica can track
+// its lag behind the primary
+func stampReplication(req *http.Request, event Event) {
+	if !event.Timestamp.IsZero() {
+		req.Header.Set(HeaderReplicationTimestamp, ConsistencyToken(event.Timestamp))
+	}
+}
@@ -0,0 +1,56 @@
+package replication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsistencyToken(t *testing.T) {
+	written := time.Now()
+	parsed, err := ParseConsistencyToken(ConsistencyToken(written))
+	if err != nil || !parsed.Equal(time.Unix(0, written.UnixNano())) {
+		t.Errorf("ParseConsistencyToken() = %v, %v, want %v", parsed, err, written)
+	}
+
+	for _, token := range []string{"", "abc", "-5", "0"} {
+		if _, err := ParseConsistencyToken(token); err == nil {
+			t.Errorf("ParseConsistencyToken(%q) should fail", token)
+		}
+	}
+}
+
+func TestReplicaState(t *testing.T) {
+	state := NewReplicaState()
+	if _, ok := state.Lag(); ok {
+		t.Error("Lag() should be unknown before any write is applied")
+	}
+
+	first := time.Now().Add(-2 * time.Second)
+	second := first.Add(time.Second)
+
+	// Nothing applied yet: any token is unmet
+	if state.CaughtUp("b", "k", ConsistencyToken(first)) {
+		t.Error("CaughtUp() = true for an unknown key")
+	}
+
+	state.Record("b", "k", first)
+	if lag, ok := state.Lag(); !ok || lag < 2*time.Second {
+		t.Errorf("Lag() = %v, %v, want at least 2s", lag, ok)
+	}
+	if !state.CaughtUp("b", "k", ConsistencyToken(first)) {
+		t.Error("CaughtUp() = false for the applied write")
+	}
+	if state.CaughtUp("b", "k", ConsistencyToken(second)) {
+		t.Error("CaughtUp() = true for a newer write")
+	}
+	if state.CaughtUp("b", "k", "garbage") {
+		t.Error("CaughtUp() = true for an invalid token")
+	}
+
+	// Out of order delivery never moves a key backwards
+	state.Record("b", "k", second)
+	state.Record("b", "k", first)
+	if !state.CaughtUp("b", "k", ConsistencyToken(second)) {
+		t.Error("CaughtUp() = false after an older write was applied late")
+	}
+}
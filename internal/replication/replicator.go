@@ -3,10 +3,13 @@ package replication
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -17,25 +20,47 @@ import (
 const (
 	// Small objects (<1MB) are replicated inline
 	InlineDataThreshold = 1024 * 1024
+
+	numWorkers = 5
+	queueSize  = 10000 // Events buffered across all workers
 )
 
 type Replicator struct {
 	config         Config
 	client         *http.Client
-	queue          chan Event
+	queues         []chan Event // One per worker, partitioned by key
 	wg             sync.WaitGroup
+	intake         sync.RWMutex // Held for writing to close the queues
+	stopped        bool
+	stopOnce       sync.Once
 	ctx            context.Context
 	cancel         context.CancelFunc
 	mu             sync.RWMutex
 	stats          Stats
 	circuitBreaker *CircuitBreaker
+	onResult       ResultHandler
+	transfers      *transfers
+
+	// Streaming of large objects from local storage
+	openObject     ObjectOpener
+	largeTransfers chan struct{}
+
+	// Delta replication of overwrites
+	readStorage      StorageReader
+	patchUnsupported atomic.Bool
 }
 
+// ResultHandler is notified of the outcome of every replicated event.
+// err is nil when the event reached the remote.
+type ResultHandler func(event Event, err error)
+
 type Stats struct {
 	EventsQueued     int64
 	EventsReplicated int64
 	EventsFailed     int64
 	LastReplication  time.Time
+	Lag              time.Duration // Delay between the write and the replication of the last replicated event
+	DeltaBytesSaved  int64         // Bytes not sent thanks to delta replication
 }
 
 func NewReplicator(config Config) *Replicator {
@@ -45,18 +70,51 @@ func NewReplicator(config Config) *Replicator {
 	cbConfig := DefaultCircuitBreakerConfig()
 	circuitBreaker := NewCircuitBreaker(cbConfig)
 
+	maxLarge := config.MaxLargeTransfers
+	if maxLarge <= 0 {
+		maxLarge = DefaultMaxLargeTransfers
+	}
+
+	queues := make([]chan Event, numWorkers)
+	for i := range queues {
+		queues[i] = make(chan Event, queueSize/numWorkers)
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	if config.SigningKey != "" {
+		client.Transport = newSigningTransport(NewSigner(config.NodeID, []byte(config.SigningKey)), config.RemoteURL)
+	}
+
 	return &Replicator{
-		config: config,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		queue:          make(chan Event, 10000), // Buffer 10k events
+		config:         config,
+		client:         client,
+		queues:         queues,
 		ctx:            ctx,
 		cancel:         cancel,
 		circuitBreaker: circuitBreaker,
+		transfers:      newTransfers(),
+		largeTransfers: make(chan struct{}, maxLarge),
 	}
 }
 
+// SetResultHandler registers a handler for replication outcomes.
+// It must be called before Start.
+func (r *Replicator) SetResultHandler(handler ResultHandler) {
+	r.onResult = handler
+}
+
+// Target returns the URL of the remote events are replicated to
+func (r *Replicator) Target() string {
+	return r.config.RemoteURL
+}
+
+// Enabled reports whether events are replicated
+func (r *Replicator) Enabled() bool {
+	return r.config.Enabled
+}
+
 func (r *Replicator) Start() error {
 	if !r.config.Enabled {
 		monitoring.Log.Info("Replication disabled")
@@ -68,8 +126,7 @@ func (r *Replicator) Start() error {
 		zap.String("mode", string(r.config.Mode)))
 
 	// Start worker goroutines
-	numWorkers := 5
-	for i := 0; i < numWorkers; i++ {
+	for i := range r.queues {
 		r.wg.Add(1)
 		go r.worker(i)
 	}
@@ -77,12 +134,43 @@ func (r *Replicator) Start() error {
 	return nil
 }
 
+// Stop stops accepting events, then waits for the workers to send those
+// already queued. Events still queued after ShutdownTimeout are dropped and
+// counted as failed. Stop may be called more than once.
 func (r *Replicator) Stop() {
-	monitoring.Log.Info("Stopping replicator")
-	r.cancel()
-	close(r.queue)
-	r.wg.Wait()
-	monitoring.Log.Info("Replicator stopped")
+	r.stopOnce.Do(func() {
+		monitoring.Log.Info("Stopping replicator", zap.Int("queued", r.QueueLength()))
+
+		// Close intake first so QueueEvent never sends on a closed queue
+		r.intake.Lock()
+		r.stopped = true
+		for _, queue := range r.queues {
+			close(queue)
+		}
+		r.intake.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			r.wg.Wait()
+			close(done)
+		}()
+
+		timeout := r.config.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = DefaultShutdownTimeout
+		}
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			monitoring.Log.Warn("Replication queue not drained in time, dropping remaining events",
+				zap.Duration("timeout", timeout),
+				zap.Int("queued", r.QueueLength()))
+			r.cancel()
+			<-done
+		}
+		r.cancel()
+		monitoring.Log.Info("Replicator stopped")
+	})
 }
 
 func (r *Replicator) QueueEvent(event Event) {
@@ -90,27 +178,174 @@ func (r *Replicator) QueueEvent(event Event) {
 		return
 	}
 
-	// Generate ID if not set
+	event = prepareEvent(event)
+	switch err := r.offer(event); {
+	case errors.Is(err, errStopped):
+		monitoring.Log.Warn("Replicator stopped, dropping event",
+			zap.String("event_id", event.ID))
+	case err != nil:
+		monitoring.Log.Warn("Replication queue full, dropping event",
+			zap.String("event_id", event.ID))
+		r.mu.Lock()
+		r.stats.EventsFailed++
+		r.mu.Unlock()
+	}
+}
+
+// QueueEventWait queues an event as QueueEvent does, but waits for room in
+// the queue instead of dropping the event, for callers queueing many
+// events at once. It returns ctx's error if ctx is done first.
+func (r *Replicator) QueueEventWait(ctx context.Context, event Event) error {
+	if !r.config.Enabled {
+		return nil
+	}
+
+	event = prepareEvent(event)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		err := r.offer(event)
+		if !errors.Is(err, errQueueFull) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// prepareEvent fills in the ID and timestamp of an event if not set
+func prepareEvent(event Event) Event {
 	if event.ID == "" {
 		event.ID = fmt.Sprintf("%d-%s-%s", time.Now().UnixNano(), event.Bucket, event.Key)
 	}
-
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
+	return event
+}
+
+var (
+	errStopped   = errors.New("replicator stopped")
+	errQueueFull = errors.New("replication queue full")
+)
+
+// offer queues an event without waiting. Events offered after Stop are
+// counted as failed; those finding the queue full are left to the caller.
+func (r *Replicator) offer(event Event) error {
+	if event.Key == "" {
+		return r.offerBarrier(event)
+	}
+
+	r.intake.RLock()
+	defer r.intake.RUnlock()
+	if r.stopped {
+		r.mu.Lock()
+		r.stats.EventsFailed++
+		r.mu.Unlock()
+		return errStopped
+	}
 
 	select {
-	case r.queue <- event:
+	case r.queues[r.partition(event)] <- event:
 		r.mu.Lock()
 		r.stats.EventsQueued++
 		r.mu.Unlock()
+		return nil
 	default:
-		monitoring.Log.Warn("Replication queue full, dropping event",
-			zap.String("event_id", event.ID))
+		return errQueueFull
+	}
+}
+
+// offerBarrier queues a bucket-level event on every worker, so that it is
+// sent once every event queued before it has been, and before any event
+// queued after it. Otherwise a purge could reach the remote after PUTs
+// queued after it on other workers, and delete them there. The intake is
+// held for writing so that barriers reach all the queues in the same order.
+func (r *Replicator) offerBarrier(event Event) error {
+	r.intake.Lock()
+	defer r.intake.Unlock()
+	if r.stopped {
 		r.mu.Lock()
 		r.stats.EventsFailed++
 		r.mu.Unlock()
+		return errStopped
+	}
+	for _, queue := range r.queues {
+		if len(queue) == cap(queue) {
+			return errQueueFull
+		}
+	}
+
+	event.barrier = newBarrier(len(r.queues), r.partition(event))
+	for _, queue := range r.queues {
+		// Nothing else queues while the intake is held, so there is room
+		queue <- event
+	}
+	r.mu.Lock()
+	r.stats.EventsQueued++
+	r.mu.Unlock()
+	return nil
+}
+
+// barrier holds the workers at a bucket-level event until its owner has
+// sent it
+type barrier struct {
+	owner     int           // Worker sending the event
+	remaining atomic.Int32  // Workers yet to reach the barrier
+	arrived   chan struct{} // Closed when all the workers reached it
+	sent      chan struct{} // Closed when the owner sent the event
+}
+
+func newBarrier(workers, owner int) *barrier {
+	b := &barrier{
+		owner:   owner,
+		arrived: make(chan struct{}),
+		sent:    make(chan struct{}),
 	}
+	b.remaining.Store(int32(workers))
+	return b
+}
+
+// pass blocks worker id at the barrier of event. The owner sends the event
+// once every worker arrived, the others wait for it to be sent. It returns
+// false if the replicator was cancelled meanwhile.
+func (r *Replicator) pass(id int, event Event) bool {
+	b := event.barrier
+	if b.remaining.Add(-1) == 0 {
+		close(b.arrived)
+	}
+	if id != b.owner {
+		select {
+		case <-b.sent:
+			return true
+		case <-r.ctx.Done():
+			return false
+		}
+	}
+
+	select {
+	case <-b.arrived:
+	case <-r.ctx.Done():
+		return false
+	}
+	r.sendBatch([]Event{event})
+	close(b.sent)
+	return true
+}
+
+// partition picks the worker of an event. Events of the same key always go
+// to the same worker, which sends them in order, so a PUT followed by a
+// DELETE cannot reach the remote reversed. Bucket-level events are queued
+// on every worker, see offerBarrier, and sent by the worker of their bucket.
+func (r *Replicator) partition(event Event) int {
+	h := fnv.New32a()
+	h.Write([]byte(event.Bucket))
+	h.Write([]byte{0})
+	h.Write([]byte(event.Key))
+	return int(h.Sum32() % uint32(len(r.queues)))
 }
 
 func (r *Replicator) worker(id int) {
@@ -125,16 +360,29 @@ func (r *Replicator) worker(id int) {
 	for {
 		select {
 		case <-r.ctx.Done():
-			// Flush remaining events
-			if len(batch) > 0 {
-				r.sendBatch(batch)
-			}
+			// Shutdown timed out, so what is left is dropped
+			r.drop(len(batch) + r.drainQueue(id))
 			return
 
-		case event, ok := <-r.queue:
+		case event, ok := <-r.queues[id]:
 			if !ok {
+				// Intake is closed and the queue drained
+				r.sendBatch(batch)
 				return
 			}
+			if event.barrier != nil {
+				// What was queued before the barrier is sent first
+				r.sendBatch(batch)
+				batch = batch[:0]
+				if !r.pass(id, event) {
+					r.drop(r.drainQueue(id))
+					if id == event.barrier.owner {
+						r.drop(1)
+					}
+					return
+				}
+				continue
+			}
 			batch = append(batch, event)
 
 			if len(batch) >= r.config.BatchSize {
@@ -156,8 +404,16 @@ func (r *Replicator) sendBatch(events []Event) {
 		return
 	}
 
-	for _, event := range events {
-		if err := r.sendEvent(event); err != nil {
+	for i, event := range events {
+		if r.ctx.Err() != nil {
+			r.drop(len(events) - i)
+			return
+		}
+		err := r.sendEvent(event)
+		if r.onResult != nil {
+			r.onResult(event, err)
+		}
+		if err != nil {
 			monitoring.Log.Error("Failed to replicate event",
 				zap.String("event_id", event.ID),
 				zap.Error(err))
@@ -168,11 +424,34 @@ func (r *Replicator) sendBatch(events []Event) {
 			r.mu.Lock()
 			r.stats.EventsReplicated++
 			r.stats.LastReplication = time.Now()
+			r.stats.Lag = r.stats.LastReplication.Sub(event.Timestamp)
 			r.mu.Unlock()
 		}
 	}
 }
 
+// drainQueue empties the closed queue of worker id on shutdown and returns
+// the number of events it held. Barriers count once, for their owner.
+func (r *Replicator) drainQueue(id int) int {
+	n := 0
+	for event := range r.queues[id] {
+		if event.barrier == nil || event.barrier.owner == id {
+			n++
+		}
+	}
+	return n
+}
+
+// drop counts n events abandoned on shutdown as failed
+func (r *Replicator) drop(n int) {
+	if n == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.stats.EventsFailed += int64(n)
+	r.mu.Unlock()
+}
+
 func (r *Replicator) sendEvent(event Event) error {
 	// Use circuit breaker to protect against cascading failures
 	return r.circuitBreaker.Call(func() error {
@@ -198,12 +477,20 @@ func (r *Replicator) sendEventWithRetry(event Event) error {
 				zap.Int("attempt", attempt),
 				zap.Duration("backoff", delay))
 
-			time.Sleep(delay)
+			select {
+			case <-time.After(delay):
+			case <-r.ctx.Done():
+				return r.ctx.Err()
+			}
 		}
 
 		switch event.Type {
 		case EventPutObject:
-			err = r.replicatePutObject(event)
+			if event.Manifest != nil && len(event.Manifest.Parts) > 1 {
+				err = r.replicateManifest(event)
+			} else if err = r.replicateDelta(event); errors.Is(err, errNoDelta) {
+				err = r.replicatePutObject(event)
+			}
 		case EventDeleteObject:
 			err = r.replicateDeleteObject(event)
 		case EventPurgeBucket:
@@ -224,9 +511,18 @@ func (r *Replicator) replicatePutObject(event Event) error {
 	url := fmt.Sprintf("%s/%s/%s", r.config.RemoteURL, event.Bucket, event.Key)
 
 	var body io.Reader
+	contentLength := int64(-1)
 	if len(event.Data) > 0 {
 		// Inline data (for small objects)
 		body = bytes.NewReader(event.Data)
+	} else if event.StoragePointer != nil && r.openObject != nil {
+		// Stream from local storage, reading ahead of the upload
+		stream, size, err := r.openStream(event)
+		if err != nil {
+			return fmt.Errorf("failed to open object data in local storage: %w", err)
+		}
+		defer stream.Close()
+		body, contentLength = stream, size
 	} else if event.StoragePointer != nil {
 		// Storage pointer: fetch from local storage via API
 		// This avoids holding large object data in memory
@@ -263,6 +559,9 @@ func (r *Replicator) replicatePutObject(event Event) error {
 	if err != nil {
 		return err
 	}
+	if contentLength >= 0 {
+		req.ContentLength = contentLength
+	}
 
 	if r.config.RemoteToken != "" {
 		req.Header.Set("Authorization", "Bearer "+r.config.RemoteToken)
@@ -271,6 +570,7 @@ func (r *Replicator) replicatePutObject(event Event) error {
 	if contentType, ok := event.Metadata["content_type"].(string); ok {
 		req.Header.Set("Content-Type", contentType)
 	}
+	stampReplication(req, event)
 
 	resp, err := r.client.Do(req)
 	if err != nil {
@@ -297,6 +597,10 @@ func (r *Replicator) replicateDeleteObject(event Event) error {
 	if r.config.RemoteToken != "" {
 		req.Header.Set("Authorization", "Bearer "+r.config.RemoteToken)
 	}
+	stampReplication(req, event)
+	if _, ok := event.Metadata[MetadataDeleteMarker]; ok {
+		req.Header.Set(HeaderDeleteMarker, "true")
+	}
 
 	resp, err := r.client.Do(req)
 	if err != nil {
@@ -313,7 +617,8 @@ func (r *Replicator) replicateDeleteObject(event Event) error {
 }
 
 func (r *Replicator) replicatePurgeBucket(event Event) error {
-	url := fmt.Sprintf("%s/admin/%s/objects", r.config.RemoteURL, event.Bucket)
+	// confirm=true performs the purge; the remote runs it as a background job
+	url := fmt.Sprintf("%s/admin/%s/objects?confirm=true", r.config.RemoteURL, event.Bucket)
 
 	req, err := http.NewRequestWithContext(r.ctx, "DELETE", url, nil)
 	if err != nil {
@@ -330,7 +635,7 @@ func (r *Replicator) replicatePurgeBucket(event Event) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("remote returned %d: %s", resp.StatusCode, string(bodyBytes))
 	}
@@ -338,6 +643,21 @@ func (r *Replicator) replicatePurgeBucket(event Event) error {
 	return nil
 }
 
+// PendingTransfers returns the number of multipart objects whose
+// replication is partially done and will resume on retry
+func (r *Replicator) PendingTransfers() int {
+	return r.transfers.pending()
+}
+
+// QueueLength returns the number of events waiting to be replicated
+func (r *Replicator) QueueLength() int {
+	n := 0
+	for _, queue := range r.queues {
+		n += len(queue)
+	}
+	return n
+}
+
 func (r *Replicator) GetStats() Stats {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
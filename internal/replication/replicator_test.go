@@ -1,8 +1,10 @@
 package replication
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -168,6 +170,175 @@ func TestReplicator_DisabledNoOp(t *testing.T) {
 	replicator.Stop()
 }
 
+func TestReplicator_OrderedPerKey(t *testing.T) {
+	var mu sync.Mutex
+	ops := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			// Slow PUTs would let a DELETE of the same key overtake them
+			// if both could be sent by different workers
+			time.Sleep(5 * time.Millisecond)
+		}
+		mu.Lock()
+		ops[r.URL.Path] = append(ops[r.URL.Path], r.Method)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	replicator := NewReplicator(Config{
+		Enabled:       true,
+		RemoteURL:     server.URL,
+		BatchSize:     1,
+		BatchInterval: 10 * time.Millisecond,
+	})
+	replicator.Start()
+	defer replicator.Stop()
+
+	keys := 20
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("file%d", i)
+		replicator.QueueEvent(Event{Type: EventPutObject, Bucket: "test", Key: key, Data: []byte("data")})
+		replicator.QueueEvent(Event{Type: EventDeleteObject, Bucket: "test", Key: key})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for replicator.GetStats().EventsReplicated < int64(2*keys) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < keys; i++ {
+		path := fmt.Sprintf("/test/file%d", i)
+		if got := ops[path]; len(got) != 2 || got[0] != "PUT" || got[1] != "DELETE" {
+			t.Errorf("%s received %v, want [PUT DELETE]", path, got)
+		}
+	}
+}
+
+func TestReplicator_Partition(t *testing.T) {
+	replicator := NewReplicator(Config{})
+
+	event := Event{Type: EventPutObject, Bucket: "test", Key: "file1"}
+	want := replicator.partition(event)
+	event.Type = EventDeleteObject
+	if got := replicator.partition(event); got != want {
+		t.Errorf("DELETE partition = %d, PUT partition = %d; want the same", got, want)
+	}
+
+	used := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		used[replicator.partition(Event{Bucket: "test", Key: fmt.Sprintf("file%d", i)})] = true
+	}
+	if len(used) != numWorkers {
+		t.Errorf("100 keys spread over %d workers, want %d", len(used), numWorkers)
+	}
+}
+
+func TestReplicator_StopDrainsQueue(t *testing.T) {
+	received := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	replicator := NewReplicator(Config{
+		Enabled:       true,
+		RemoteURL:     server.URL,
+		BatchSize:     100,
+		BatchInterval: time.Minute, // Nothing is sent before Stop
+	})
+	replicator.Start()
+
+	for i := 0; i < 50; i++ {
+		replicator.QueueEvent(Event{Type: EventPutObject, Bucket: "test", Key: fmt.Sprintf("file%d", i), Data: []byte("data")})
+	}
+	replicator.Stop()
+	replicator.Stop() // Idempotent
+
+	if got := atomic.LoadInt32(&received); got != 50 {
+		t.Errorf("remote received %d events, want the 50 queued before Stop", got)
+	}
+
+	// Events queued after Stop are dropped
+	replicator.QueueEvent(Event{Type: EventPutObject, Bucket: "test", Key: "late", Data: []byte("data")})
+	if stats := replicator.GetStats(); stats.EventsReplicated != 50 || stats.EventsFailed != 1 {
+		t.Errorf("stats = %+v, want 50 replicated and 1 failed", stats)
+	}
+}
+
+func TestReplicator_StopTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	replicator := NewReplicator(Config{
+		Enabled:         true,
+		RemoteURL:       server.URL,
+		BatchSize:       1,
+		BatchInterval:   10 * time.Millisecond,
+		ShutdownTimeout: 50 * time.Millisecond,
+	})
+	replicator.Start()
+
+	for i := 0; i < 10; i++ {
+		replicator.QueueEvent(Event{Type: EventPutObject, Bucket: "test", Key: "file", Data: []byte("data")})
+	}
+
+	start := time.Now()
+	replicator.Stop()
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Stop took %v, want it bounded by the shutdown timeout", elapsed)
+	}
+	if stats := replicator.GetStats(); stats.EventsReplicated+stats.EventsFailed != 10 {
+		t.Errorf("stats = %+v, want all 10 events replicated or dropped", stats)
+	}
+}
+
+func TestReplicator_StopWhileQueueing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	replicator := NewReplicator(Config{
+		Enabled:       true,
+		RemoteURL:     server.URL,
+		BatchSize:     10,
+		BatchInterval: 10 * time.Millisecond,
+	})
+	replicator.Start()
+
+	const producers, events = 8, 200
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				replicator.QueueEvent(Event{Type: EventDeleteObject, Bucket: "test", Key: fmt.Sprintf("p%d-%d", p, i)})
+			}
+		}(p)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	replicator.Stop()
+	wg.Wait()
+
+	// Every event was either sent or counted as dropped
+	stats := replicator.GetStats()
+	if total := stats.EventsReplicated + stats.EventsFailed; total != producers*events {
+		t.Errorf("replicated %d + failed %d = %d, want %d", stats.EventsReplicated, stats.EventsFailed, total, producers*events)
+	}
+}
+
 func TestReplicator_PurgeBucket(t *testing.T) {
 	received := int32(0)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -205,6 +376,61 @@ func TestReplicator_PurgeBucket(t *testing.T) {
 	}
 }
 
+func TestReplicator_PurgeThenPut(t *testing.T) {
+	var mu sync.Mutex
+	remote := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.Path == "/admin/test/objects" {
+			// A slow purge would delete the PUTs queued after it if they
+			// could be sent by other workers meanwhile
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			clear(remote)
+			mu.Unlock()
+		} else if r.Method == "PUT" {
+			mu.Lock()
+			remote[r.URL.Path] = true
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	replicator := NewReplicator(Config{
+		Enabled:       true,
+		RemoteURL:     server.URL,
+		BatchSize:     1,
+		BatchInterval: 10 * time.Millisecond,
+	})
+	replicator.Start()
+	defer replicator.Stop()
+
+	keys := 20
+	for i := 0; i < keys; i++ {
+		replicator.QueueEvent(Event{Type: EventPutObject, Bucket: "test", Key: fmt.Sprintf("old%d", i), Data: []byte("data")})
+	}
+	replicator.QueueEvent(Event{Type: EventPurgeBucket, Bucket: "test"})
+	for i := 0; i < keys; i++ {
+		replicator.QueueEvent(Event{Type: EventPutObject, Bucket: "test", Key: fmt.Sprintf("new%d", i), Data: []byte("data")})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for replicator.GetStats().EventsReplicated < int64(2*keys+1) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < keys; i++ {
+		if path := fmt.Sprintf("/test/old%d", i); remote[path] {
+			t.Errorf("%s survived the purge", path)
+		}
+		if path := fmt.Sprintf("/test/new%d", i); !remote[path] {
+			t.Errorf("%s put after the purge is missing on the remote", path)
+		}
+	}
+}
+
 func TestReplicator_LargeObjectWithURL(t *testing.T) {
 	received := int32(0)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,378 @@
+package replication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of requests signed by a replication source. The signature covers
+// the method, path, query and, as SigV4 does, a sorted list of headers:
+// every header describing the object or the replication, node, date,
+// nonce and body digest included, named by HeaderSignedHeaders.
+const (
+	HeaderNodeID        = "X-Comio-Node"
+	HeaderDate          = "X-Comio-Date"
+	HeaderNonce         = "X-Comio-Nonce"
+	HeaderContentSHA256 = "X-Comio-Content-Sha256"
+	HeaderContentLength = "X-Comio-Content-Length"
+	HeaderSignedHeaders = "X-Comio-Signed-Headers"
+	HeaderSignature     = "X-Comio-Signature"
+	// Trailer of streamed bodies signing their digest
+	HeaderPayloadSignature = "X-Comio-Payload-Signature"
+)
+
+// StreamingPayload stands in for the digest of bodies streamed without
+// being read first, such as objects copied from local storage. They are
+// sent chunked, their digest signed in the HeaderPayloadSignature trailer
+// and their length, when known, in HeaderContentLength.
+const StreamingPayload = "STREAMING-SIGNED-TRAILER"
+
+// MaxClockSkew is how far the date of a signed request may be from the
+// receiver's clock. Nonces are remembered for twice as long, so a request
+// cannot be replayed while its date is accepted.
+const MaxClockSkew = 5 * time.Minute
+
+var (
+	// ErrUnknownNode is returned for requests signed by a node without a key
+	ErrUnknownNode = errors.New("unknown replication node")
+	// ErrInvalidSignature is returned for requests whose signature, date or
+	// body does not match
+	ErrInvalidSignature = errors.New("invalid replication signature")
+	// ErrReplayedRequest is returned for a signed request seen before
+	ErrReplayedRequest = errors.New("replayed replication request")
+)
+
+// Signer signs requests to replication peers with the key of this node
+type Signer struct {
+	nodeID string
+	key    []byte
+	now    func() time.Time
+}
+
+// NewSigner creates a signer for node nodeID holding key
+func NewSigner(nodeID string, key []byte) *Signer {
+	return &Signer{nodeID: nodeID, key: key, now: time.Now}
+}
+
+// Sign signs req. Bodies that can be read again through GetBody, as those
+// of bytes readers are, are signed by digest; other bodies are streamed as
+// StreamingPayload, their digest signed in a trailer once read.
+func (s *Signer) Sign(req *http.Request) error {
+	payload, err := payloadHash(req)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	req.Header.Set(HeaderNodeID, s.nodeID)
+	req.Header.Set(HeaderDate, s.now().UTC().Format(time.RFC3339))
+	req.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+	req.Header.Set(HeaderContentSHA256, payload)
+	if payload == StreamingPayload && req.ContentLength > 0 {
+		req.Header.Set(HeaderContentLength, strconv.FormatInt(req.ContentLength, 10))
+	}
+	req.Header.Set(HeaderSignedHeaders, strings.Join(signedHeaders(req.Header), ";"))
+	seed := signature(s.key, req)
+	req.Header.Set(HeaderSignature, seed)
+
+	if payload == StreamingPayload {
+		// Trailers are only sent with chunked bodies
+		req.ContentLength = -1
+		req.Trailer = http.Header{HeaderPayloadSignature: nil}
+		req.Body = &trailerSigner{ReadCloser: req.Body, hash: sha256.New(), key: s.key, seed: seed, trailer: req.Trailer}
+	}
+	return nil
+}
+
+// signedHeader reports whether a header is covered by the signature: the
+// headers object writes are described with, and those replication sets
+func signedHeader(name string) bool {
+	switch name = strings.ToLower(name); name {
+	case "content-type", "content-encoding", "content-disposition", "content-language", "content-md5", "cache-control", "expires":
+		return true
+	case strings.ToLower(HeaderSignature), strings.ToLower(HeaderSignedHeaders):
+		return false
+	}
+	return strings.HasPrefix(name, "x-amz-") || strings.HasPrefix(name, "x-comio-")
+}
+
+// signedHeaders returns the lower-cased names of the headers of h covered
+// by the signature, sorted
+func signedHeaders(h http.Header) []string {
+	var names []string
+	for name := range h {
+		if signedHeader(name) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// payloadHash returns the hex SHA-256 of a request's body
+func payloadHash(req *http.Request) (string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(sha256.New().Sum(nil)), nil
+	}
+	if req.GetBody == nil {
+		return StreamingPayload, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", fmt.Errorf("failed to hash request body: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// signature computes the signature of a request from its method, path,
+// query and the headers HeaderSignedHeaders names, each on a line of its
+// own with its values joined by commas
+func signature(key []byte, req *http.Request) string {
+	lines := []string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery}
+	signed := req.Header.Get(HeaderSignedHeaders)
+	for _, name := range strings.Split(signed, ";") {
+		var values []string
+		for _, v := range req.Header.Values(name) {
+			values = append(values, strings.TrimSpace(v))
+		}
+		lines = append(lines, name+":"+strings.Join(values, ","))
+	}
+	lines = append(lines, signed)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// payloadSignature signs the digest of a streamed body, chained to the
+// signature of its request
+func payloadSignature(key []byte, seed string, digest []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(seed + "\n" + hex.EncodeToString(digest)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks the signatures of requests from replication peers,
+// each signing with a key of its own, and refuses replays
+type Verifier struct {
+	keys map[string][]byte
+	now  func() time.Time
+
+	// Nonces of accepted requests, in two generations each spanning the
+	// window dates are accepted in. A nonce is remembered for at least
+	// that long and dropped with its generation.
+	mu       sync.Mutex
+	seen     map[string]struct{}
+	previous map[string]struct{}
+	rotated  time.Time // When seen was started
+}
+
+// NewVerifier creates a verifier accepting requests signed by the nodes
+// of keys, keyed by node ID
+func NewVerifier(keys map[string][]byte) *Verifier {
+	return &Verifier{keys: keys, now: time.Now, seen: make(map[string]struct{})}
+}
+
+// Verify checks the signature of req and returns the node that signed it.
+// Requests carrying a header the signature should cover but does not are
+// refused. The body digest, or the trailer signing it for streamed bodies,
+// is checked as the body is read: reading a body that does not match fails
+// with ErrInvalidSignature at its end, so handlers storing it fail rather
+// than keep altered data. Streamed bodies get back the content length
+// their source signed.
+func (v *Verifier) Verify(req *http.Request) (string, error) {
+	nodeID := req.Header.Get(HeaderNodeID)
+	key, ok := v.keys[nodeID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownNode, nodeID)
+	}
+	signed := make(map[string]bool)
+	for _, name := range strings.Split(req.Header.Get(HeaderSignedHeaders), ";") {
+		signed[name] = true
+	}
+	for _, name := range signedHeaders(req.Header) {
+		if !signed[name] {
+			return "", fmt.Errorf("%w: header %s is not signed", ErrInvalidSignature, name)
+		}
+	}
+	seed := signature(key, req)
+	if !hmac.Equal([]byte(req.Header.Get(HeaderSignature)), []byte(seed)) {
+		return "", ErrInvalidSignature
+	}
+
+	date, err := time.Parse(time.RFC3339, req.Header.Get(HeaderDate))
+	now := v.now()
+	if err != nil || date.Before(now.Add(-MaxClockSkew)) || date.After(now.Add(MaxClockSkew)) {
+		return "", fmt.Errorf("%w: date %q is not within %s of now", ErrInvalidSignature, req.Header.Get(HeaderDate), MaxClockSkew)
+	}
+	if err := v.remember(nodeID+"/"+req.Header.Get(HeaderNonce), now); err != nil {
+		return "", err
+	}
+
+	if req.Body == nil {
+		return nodeID, nil
+	}
+	switch payload := req.Header.Get(HeaderContentSHA256); payload {
+	case StreamingPayload:
+		reader := &trailerReader{ReadCloser: req.Body, hash: sha256.New(), key: key, seed: seed, req: req, length: -1}
+		if length := req.Header.Get(HeaderContentLength); length != "" {
+			n, err := strconv.ParseInt(length, 10, 64)
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("%w: invalid content length", ErrInvalidSignature)
+			}
+			req.ContentLength, reader.length = n, n
+		}
+		req.Body = reader
+	default:
+		want, err := hex.DecodeString(payload)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid body digest", ErrInvalidSignature)
+		}
+		req.Body = &digestReader{ReadCloser: req.Body, hash: sha256.New(), want: want}
+	}
+	return nodeID, nil
+}
+
+// remember records a nonce, failing if it was seen within the window its
+// requests are accepted in. Older nonces are forgotten a generation at a
+// time rather than one by one.
+func (v *Verifier) remember(nonce string, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	const window = 2 * MaxClockSkew
+	switch age := now.Sub(v.rotated); {
+	case age >= 2*window:
+		v.previous, v.seen, v.rotated = nil, make(map[string]struct{}), now
+	case age >= window:
+		v.previous, v.seen, v.rotated = v.seen, make(map[string]struct{}), now
+	}
+
+	if _, ok := v.seen[nonce]; ok {
+		return ErrReplayedRequest
+	}
+	if _, ok := v.previous[nonce]; ok {
+		return ErrReplayedRequest
+	}
+	v.seen[nonce] = struct{}{}
+	return nil
+}
+
+// digestReader fails at the end of a body whose SHA-256 is not want
+type digestReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(r.hash.Sum(nil), r.want) {
+		return n, fmt.Errorf("%w: body does not match its digest", ErrInvalidSignature)
+	}
+	return n, err
+}
+
+// trailerSigner hashes a streamed body as it is sent and, at its end, signs
+// the digest in the trailer of its request
+type trailerSigner struct {
+	io.ReadCloser
+	hash    hash.Hash
+	key     []byte
+	seed    string
+	trailer http.Header
+}
+
+func (r *trailerSigner) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		// The transport reads the trailer once the body returns EOF
+		r.trailer.Set(HeaderPayloadSignature, payloadSignature(r.key, r.seed, r.hash.Sum(nil)))
+	}
+	return n, err
+}
+
+// trailerReader fails at the end of a streamed body whose trailer does not
+// sign its digest, or whose length is not the signed one. The trailer of
+// a received request is read with the end of its body.
+type trailerReader struct {
+	io.ReadCloser
+	hash   hash.Hash
+	key    []byte
+	seed   string
+	req    *http.Request
+	length int64 // Signed length, -1 if unknown
+	read   int64
+}
+
+func (r *trailerReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	r.read += int64(n)
+	if r.length >= 0 && r.read > r.length {
+		return n, fmt.Errorf("%w: body is longer than its signed length", ErrInvalidSignature)
+	}
+	if err == io.EOF {
+		if r.length >= 0 && r.read != r.length {
+			return n, fmt.Errorf("%w: body is shorter than its signed length", ErrInvalidSignature)
+		}
+		want := payloadSignature(r.key, r.seed, r.hash.Sum(nil))
+		if !hmac.Equal([]byte(r.req.Trailer.Get(HeaderPayloadSignature)), []byte(want)) {
+			return n, fmt.Errorf("%w: body does not match its trailer", ErrInvalidSignature)
+		}
+	}
+	return n, err
+}
+
+// signingTransport signs the requests a replicator sends to its remote.
+// Requests to other hosts, such as reads of local storage, are sent as
+// they are.
+type signingTransport struct {
+	base   http.RoundTripper
+	signer *Signer
+	remote string // Host of the remote
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.remote {
+		return t.base.RoundTrip(req)
+	}
+	// Round trippers must not change the request they are given
+	signed := req.Clone(req.Context())
+	if err := t.signer.Sign(signed); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(signed)
+}
+
+// newSigningTransport returns a transport signing requests to the host of
+// remoteURL. Requests to a remote URL that does not parse fail anyway.
+func newSigningTransport(signer *Signer, remoteURL string) http.RoundTripper {
+	var remote string
+	if u, err := url.Parse(remoteURL); err == nil {
+		remote = u.Host
+	}
+	return &signingTransport{base: http.DefaultTransport, signer: signer, remote: remote}
+}
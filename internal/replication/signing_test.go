@@ -0,0 +1,209 @@
+package replication
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigning(t *testing.T) {
+	signer := NewSigner("site-a", []byte("secret"))
+	verifier := NewVerifier(map[string][]byte{"site-a": []byte("secret")})
+
+	signed := func(method, target string, body []byte) *http.Request {
+		t.Helper()
+		req, _ := http.NewRequest(method, target, bytes.NewReader(body))
+		if err := signer.Sign(req); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		// As received: the body is read from the connection
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return req
+	}
+
+	req := signed("PUT", "http://site-b/photos/a%20b.jpg?versionId=1", []byte("data"))
+	if node, err := verifier.Verify(req); err != nil || node != "site-a" {
+		t.Fatalf("Verify() = %q, %v", node, err)
+	}
+	if data, err := io.ReadAll(req.Body); err != nil || string(data) != "data" {
+		t.Errorf("body = %q, %v", data, err)
+	}
+	if _, err := verifier.Verify(req); !errors.Is(err, ErrReplayedRequest) {
+		t.Errorf("replayed request error = %v, want ErrReplayedRequest", err)
+	}
+
+	// Anything covered by the signature that changes breaks it
+	req = signed("DELETE", "http://site-b/photos/a.jpg", nil)
+	req.URL.Path = "/photos/b.jpg"
+	if _, err := verifier.Verify(req); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("altered path error = %v, want ErrInvalidSignature", err)
+	}
+
+	// So does any header replication describes objects with, whether
+	// altered or added
+	req = signed("PUT", "http://site-b/photos/a.jpg", []byte("data"))
+	req.Header.Set("Content-Type", "text/html")
+	if _, err := verifier.Verify(req); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("altered Content-Type error = %v, want ErrInvalidSignature", err)
+	}
+	req = signed("PUT", "http://site-b/photos/a.jpg", nil)
+	req.Header.Set("X-Amz-Copy-Source", "/secrets/key")
+	if _, err := verifier.Verify(req); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("added x-amz-copy-source error = %v, want ErrInvalidSignature", err)
+	}
+	req = signed("DELETE", "http://site-b/photos/a.jpg", nil)
+	req.Header.Set(HeaderDeleteMarker, "true")
+	if _, err := verifier.Verify(req); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("added delete marker header error = %v, want ErrInvalidSignature", err)
+	}
+
+	req = signed("PUT", "http://site-b/photos/a.jpg", []byte("data"))
+	req.Body = io.NopCloser(bytes.NewReader([]byte("evil")))
+	if _, err := verifier.Verify(req); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if _, err := io.ReadAll(req.Body); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("altered body read error = %v, want ErrInvalidSignature", err)
+	}
+
+	signer.now = func() time.Time { return time.Now().Add(-2 * MaxClockSkew) }
+	if _, err := verifier.Verify(signed("DELETE", "http://site-b/photos/a.jpg", nil)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("stale request error = %v, want ErrInvalidSignature", err)
+	}
+
+	other := NewSigner("site-c", []byte("secret"))
+	req, _ = http.NewRequest("DELETE", "http://site-b/photos/a.jpg", nil)
+	other.Sign(req)
+	if _, err := verifier.Verify(req); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("unknown node error = %v, want ErrUnknownNode", err)
+	}
+}
+
+func TestSigning_StreamedBody(t *testing.T) {
+	signer := NewSigner("site-a", []byte("secret"))
+	verifier := NewVerifier(map[string][]byte{"site-a": []byte("secret")})
+
+	// Sent as the transport sends it: read to its end, then the trailer
+	streamed := func(body string) (*http.Request, string) {
+		t.Helper()
+		req, _ := http.NewRequest("PUT", "http://site-b/photos/a.jpg", io.NopCloser(strings.NewReader(body)))
+		req.ContentLength = int64(len(body))
+		if err := signer.Sign(req); err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if req.Header.Get(HeaderContentSHA256) != StreamingPayload || req.ContentLength != -1 {
+			t.Fatalf("streamed body signed as %q with length %d", req.Header.Get(HeaderContentSHA256), req.ContentLength)
+		}
+		if _, err := io.ReadAll(req.Body); err != nil {
+			t.Fatalf("reading the signed body: %v", err)
+		}
+		return req, req.Trailer.Get(HeaderPayloadSignature)
+	}
+	received := func(req *http.Request, body, trailer string) *http.Request {
+		req.Body = io.NopCloser(strings.NewReader(body))
+		req.Trailer = http.Header{HeaderPayloadSignature: {trailer}}
+		return req
+	}
+
+	req, trailer := streamed("data")
+	req = received(req, "data", trailer)
+	if _, err := verifier.Verify(req); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if req.ContentLength != 4 {
+		t.Errorf("ContentLength = %d, want the signed 4", req.ContentLength)
+	}
+	if data, err := io.ReadAll(req.Body); err != nil || string(data) != "data" {
+		t.Errorf("body = %q, %v", data, err)
+	}
+
+	req, trailer = streamed("data")
+	req = received(req, "evil", trailer)
+	if _, err := verifier.Verify(req); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if _, err := io.ReadAll(req.Body); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("swapped body read error = %v, want ErrInvalidSignature", err)
+	}
+
+	req, _ = streamed("data")
+	req = received(req, "data", "")
+	if _, err := verifier.Verify(req); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if _, err := io.ReadAll(req.Body); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("missing trailer read error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestReplicator_SignsRequests(t *testing.T) {
+	verifier := NewVerifier(map[string][]byte{"site-a": []byte("secret")})
+	var verifyErr error
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, verifyErr = verifier.Verify(r)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer remote.Close()
+
+	config := DefaultConfig()
+	config.RemoteURL = remote.URL
+	config.NodeID = "site-a"
+	config.SigningKey = "secret"
+	replicator := NewReplicator(config)
+
+	if err := replicator.replicateDeleteObject(Event{Bucket: "photos", Key: "a.jpg"}); err != nil {
+		t.Fatalf("replicateDeleteObject() error = %v", err)
+	}
+	if verifyErr != nil {
+		t.Errorf("remote could not verify the request: %v", verifyErr)
+	}
+
+	// Streamed bodies reach the remote chunked, signed by their trailer
+	var body []byte
+	var length int64
+	remote.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, verifyErr = verifier.Verify(r); verifyErr == nil {
+			length = r.ContentLength
+			body, verifyErr = io.ReadAll(r.Body)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	req, _ := http.NewRequest("PUT", remote.URL+"/photos/a.jpg", io.NopCloser(strings.NewReader("data")))
+	req.ContentLength = 4
+	resp, err := replicator.client.Do(req)
+	if err != nil {
+		t.Fatalf("streamed PUT error = %v", err)
+	}
+	resp.Body.Close()
+	if verifyErr != nil || string(body) != "data" || length != 4 {
+		t.Errorf("remote received %q of length %d, %v", body, length, verifyErr)
+	}
+}
+
+func TestVerifier_ForgetsNonces(t *testing.T) {
+	verifier := NewVerifier(nil)
+	start := time.Now()
+
+	if err := verifier.remember("n1", start); err != nil {
+		t.Fatalf("remember() error = %v", err)
+	}
+	// A generation later the nonce is still known, as its date may be
+	if err := verifier.remember("n2", start.Add(2*MaxClockSkew)); err != nil {
+		t.Fatalf("remember() error = %v", err)
+	}
+	if err := verifier.remember("n1", start.Add(3*MaxClockSkew)); !errors.Is(err, ErrReplayedRequest) {
+		t.Errorf("nonce of the previous generation error = %v, want ErrReplayedRequest", err)
+	}
+	// Two generations later it is forgotten, its date refused anyway
+	if err := verifier.remember("n1", start.Add(4*MaxClockSkew)); err != nil {
+		t.Errorf("nonce of an expired generation error = %v, want nil", err)
+	}
+	if err := verifier.remember("n2", start.Add(4*MaxClockSkew)); !errors.Is(err, ErrReplayedRequest) {
+		t.Errorf("nonce of the previous generation error = %v, want ErrReplayedRequest", err)
+	}
+}
@@ -0,0 +1,309 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+// Well-known scheduled task names. Tasks run as jobs of the same type.
+const (
+	TaskScrub            = jobs.TypeScrub
+	TaskInventory        = jobs.TypeInventory
+	TaskLifecycle        = jobs.TypeLifecycle
+	TaskCompaction       = jobs.TypeCompaction
+	TaskMetadataBackup   = "metadata_backup"
+	TaskMultipartCleanup = "multipart_cleanup"
+	TaskTombstoneGC      = "tombstone_gc"
+	TaskBillingReport    = "billing_report"
+)
+
+// Run outcomes recorded in ScheduleStatus.LastResult
+const (
+	ResultSubmitted = "submitted"
+	ResultSkipped   = "skipped" // previous run still in progress
+	ResultError     = "error"
+)
+
+// ErrUnknownTask is returned when a schedule references an unregistered task
+var ErrUnknownTask = errors.New("unknown task")
+
+// ErrScheduleNotFound is returned when running a schedule that is not configured
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Definition describes one configured schedule
+type Definition struct {
+	Name string
+	Task string
+	Cron string
+}
+
+// ScheduleStatus is the externally visible state of a schedule
+type ScheduleStatus struct {
+	Name         string     `json:"name"`
+	Task         string     `json:"task"`
+	Cron         string     `json:"cron"`
+	NextRun      time.Time  `json:"next_run"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastResult   string     `json:"last_result,omitempty"`
+	LastJobID    string     `json:"last_job_id,omitempty"`
+	LastJobState jobs.State `json:"last_job_state,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+type schedule struct {
+	def     Definition
+	cron    *Cron
+	next    time.Time
+	lastRun *time.Time
+	result  string
+	jobID   string
+	errMsg  string
+}
+
+// Scheduler submits registered tasks to the job manager on cron schedules.
+// Each schedule runs as a job keyed by the schedule name, so a run is
+// skipped while the previous one is still queued or running.
+type Scheduler struct {
+	jobs      *jobs.Manager
+	tasks     map[string]jobs.RunFunc
+	schedules []*schedule
+	mu        sync.Mutex
+	now       func() time.Time
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New creates a scheduler that runs tasks through the given job manager
+func New(manager *jobs.Manager) *Scheduler {
+	return &Scheduler{
+		jobs:  manager,
+		tasks: make(map[string]jobs.RunFunc),
+		now:   time.Now,
+	}
+}
+
+// RegisterTask makes a task available to schedules
+func (s *Scheduler) RegisterTask(name string, run jobs.RunFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[name] = run
+}
+
+// Add validates and adds a schedule
+func (s *Scheduler) Add(def Definition) error {
+	cron, err := ParseCron(def.Cron)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tasks[def.Task]; !ok {
+		return fmt.Errorf("schedule %q: %w %q", def.Name, ErrUnknownTask, def.Task)
+	}
+	for _, existing := range s.schedules {
+		if existing.def.Name == def.Name {
+			return fmt.Errorf("schedule %q already defined", def.Name)
+		}
+	}
+
+	s.schedules = append(s.schedules, &schedule{
+		def:  def,
+		cron: cron,
+		next: cron.Next(s.now()),
+	})
+
+	return nil
+}
+
+// Start starts the scheduling loop
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go s.loop(ctx)
+
+	monitoring.Log.Info("Scheduler started", zap.Int("schedules", len(s.schedules)))
+}
+
+// Stop stops the scheduling loop. Jobs already submitted keep running
+// under the job manager.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	monitoring.Log.Info("Scheduler stopped")
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		wait := s.untilNext()
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.runDue()
+		}
+	}
+}
+
+// untilNext returns how long to sleep before the earliest schedule is due
+func (s *Scheduler) untilNext() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-check at least once a minute so clock changes are picked up
+	wait := time.Minute
+	now := s.now()
+	for _, sc := range s.schedules {
+		if sc.next.IsZero() {
+			continue
+		}
+		if d := sc.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// runDue submits every schedule whose activation time has passed
+func (s *Scheduler) runDue() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for _, sc := range s.schedules {
+		if sc.next.IsZero() || now.Before(sc.next) {
+			continue
+		}
+		s.fireLocked(sc, now)
+		sc.next = sc.cron.Next(now)
+	}
+}
+
+// RunNow triggers a schedule immediately, outside its cron timing
+func (s *Scheduler) RunNow(name string) (ScheduleStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sc := range s.schedules {
+		if sc.def.Name == name {
+			s.fireLocked(sc, s.now())
+			return s.statusLocked(sc), nil
+		}
+	}
+	return ScheduleStatus{}, fmt.Errorf("%w: %q", ErrScheduleNotFound, name)
+}
+
+// RunTask runs a registered task now as a job of its own, outside any
+// schedule. Only one such run of a task is queued or running at a time.
+func (s *Scheduler) RunTask(task string) (jobs.Job, error) {
+	s.mu.Lock()
+	run, ok := s.tasks[task]
+	s.mu.Unlock()
+	if !ok {
+		return jobs.Job{}, fmt.Errorf("%w %q", ErrUnknownTask, task)
+	}
+
+	return s.jobs.Submit(jobs.Spec{
+		Type: task,
+		Key:  "task:" + task,
+	}, run)
+}
+
+// fireLocked submits one run of a schedule. s.mu must be held.
+func (s *Scheduler) fireLocked(sc *schedule, now time.Time) {
+	ranAt := now
+	sc.lastRun = &ranAt
+	sc.errMsg = ""
+
+	job, err := s.jobs.Submit(jobs.Spec{
+		Type:   sc.def.Task,
+		Key:    "schedule:" + sc.def.Name,
+		Params: map[string]string{"schedule": sc.def.Name},
+	}, s.tasks[sc.def.Task])
+
+	switch {
+	case err == nil:
+		sc.result = ResultSubmitted
+		sc.jobID = job.ID
+		monitoring.Log.Info("Scheduled task submitted",
+			zap.String("schedule", sc.def.Name),
+			zap.String("task", sc.def.Task),
+			zap.String("job_id", job.ID))
+	case errors.Is(err, jobs.ErrDuplicate):
+		sc.result = ResultSkipped
+		monitoring.Log.Warn("Skipping scheduled task, previous run still in progress",
+			zap.String("schedule", sc.def.Name),
+			zap.String("task", sc.def.Task))
+	default:
+		sc.result = ResultError
+		sc.errMsg = err.Error()
+		monitoring.Log.Error("Failed to submit scheduled task",
+			zap.String("schedule", sc.def.Name),
+			zap.String("task", sc.def.Task),
+			zap.Error(err))
+	}
+}
+
+// Status returns the state of every schedule, ordered by name
+func (s *Scheduler) Status() []ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]ScheduleStatus, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		statuses = append(statuses, s.statusLocked(sc))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// statusLocked builds a schedule's status. s.mu must be held.
+func (s *Scheduler) statusLocked(sc *schedule) ScheduleStatus {
+	status := ScheduleStatus{
+		Name:       sc.def.Name,
+		Task:       sc.def.Task,
+		Cron:       sc.def.Cron,
+		NextRun:    sc.next,
+		LastRun:    sc.lastRun,
+		LastResult: sc.result,
+		LastJobID:  sc.jobID,
+		LastError:  sc.errMsg,
+	}
+
+	if sc.jobID != "" {
+		if job, ok := s.jobs.Get(sc.jobID); ok {
+			status.LastJobState = job.State
+			if job.Error != "" {
+				status.LastError = job.Error
+			}
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/internal/jobs"
+	"github.com/danielino/comio/internal/monitoring"
+)
+
+func init() {
+	monitoring.InitLogger("error", "json", "stdout")
+}
+
+func newTestScheduler(t *testing.T) *Scheduler {
+	manager, err := jobs.NewManager(jobs.Config{Workers: 1}, jobs.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	manager.Start()
+	t.Cleanup(manager.Stop)
+	return New(manager)
+}
+
+func TestScheduler_AddValidation(t *testing.T) {
+	s := newTestScheduler(t)
+	s.RegisterTask("noop", func(ctx context.Context, h *jobs.Handle) error { return nil })
+
+	if err := s.Add(Definition{Name: "a", Task: "noop", Cron: "@daily"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.Add(Definition{Name: "a", Task: "noop", Cron: "@daily"}); err == nil {
+		t.Error("Add() duplicate name should fail")
+	}
+	if err := s.Add(Definition{Name: "b", Task: "missing", Cron: "@daily"}); !errors.Is(err, ErrUnknownTask) {
+		t.Errorf("Add() unknown task error = %v, want %v", err, ErrUnknownTask)
+	}
+	if err := s.Add(Definition{Name: "c", Task: "noop", Cron: "bad"}); err == nil {
+		t.Error("Add() invalid cron should fail")
+	}
+}
+
+func TestScheduler_OverlapPrevention(t *testing.T) {
+	s := newTestScheduler(t)
+
+	release := make(chan struct{})
+	s.RegisterTask("slow", func(ctx context.Context, h *jobs.Handle) error {
+		<-release
+		return nil
+	})
+	if err := s.Add(Definition{Name: "slow-task", Task: "slow", Cron: "* * * * *"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	first, err := s.RunNow("slow-task")
+	if err != nil {
+		t.Fatalf("RunNow() error = %v", err)
+	}
+	if first.LastResult != ResultSubmitted {
+		t.Fatalf("first run result = %s, want %s", first.LastResult, ResultSubmitted)
+	}
+
+	second, _ := s.RunNow("slow-task")
+	if second.LastResult != ResultSkipped {
+		t.Errorf("overlapping run result = %s, want %s", second.LastResult, ResultSkipped)
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status := s.Status()[0]
+		if status.LastJobState == jobs.StateCompleted {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("scheduled job did not complete")
+}
+
+func TestScheduler_RunDue(t *testing.T) {
+	s := newTestScheduler(t)
+
+	now := time.Date(2024, time.January, 1, 12, 0, 30, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	ran := make(chan struct{}, 1)
+	s.RegisterTask("tick", func(ctx context.Context, h *jobs.Handle) error {
+		ran <- struct{}{}
+		return nil
+	})
+	if err := s.Add(Definition{Name: "tick", Task: "tick", Cron: "* * * * *"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// Not yet due
+	s.runDue()
+	if s.Status()[0].LastRun != nil {
+		t.Fatal("schedule ran before it was due")
+	}
+
+	now = now.Add(time.Minute)
+	s.runDue()
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("due schedule did not run")
+	}
+
+	status := s.Status()[0]
+	want := time.Date(2024, time.January, 1, 12, 2, 0, 0, time.UTC)
+	if !status.NextRun.Equal(want) {
+		t.Errorf("NextRun = %v, want %v", status.NextRun, want)
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrInsufficientStorage is returned when allocating above the critical
+// watermark
+var ErrInsufficientStorage = errors.New("insufficient storage: usage is above the critical watermark")
+
+// CapacityLevel is the band of space usage relative to the watermarks
+type CapacityLevel string
+
+const (
+	CapacityOK       CapacityLevel = "ok"
+	CapacityHigh     CapacityLevel = "high"     // Above the high watermark: warn
+	CapacityCritical CapacityLevel = "critical" // Above the critical watermark: refuse new data
+)
+
+// Watermarks are the usage percentages at which the engine warns and at
+// which it stops accepting new data, so that deletes, which free space,
+// still succeed on a nearly full device. 0 disables a mark.
+type Watermarks struct {
+	HighPercent     float64
+	CriticalPercent float64
+}
+
+// CapacityStatus is the usage of an engine against its watermarks
+type CapacityStatus struct {
+	Level           CapacityLevel `json:"level"`
+	UsedPercent     float64       `json:"used_percent"`
+	HighPercent     float64       `json:"high_percent,omitempty"`
+	CriticalPercent float64       `json:"critical_percent,omitempty"`
+}
+
+// CapacityReporter is implemented by engines that enforce watermarks
+type CapacityReporter interface {
+	Capacity() CapacityStatus
+}
+
+// CapacityChangeFunc is called when usage moves to another level
+type CapacityChangeFunc func(prev CapacityLevel, status CapacityStatus)
+
+// UsedPercent returns the share of the device holding live data, 0-100
+func UsedPercent(stats Stats) float64 {
+	if stats.TotalBytes <= 0 {
+		return 0
+	}
+	return float64(stats.UsedBytes) * 100 / float64(stats.TotalBytes)
+}
+
+// Available returns how many bytes can still be allocated before usage
+// reaches the critical watermark, or the device is full without one
+func (w Watermarks) Available(stats Stats) int64 {
+	if w.CriticalPercent <= 0 {
+		return stats.FreeBytes
+	}
+	limit := int64(float64(stats.TotalBytes) * w.CriticalPercent / 100)
+	return max(min(limit-stats.UsedBytes, stats.FreeBytes), 0)
+}
+
+// Status returns the usage of stats against the watermarks
+func (w Watermarks) Status(stats Stats) CapacityStatus {
+	status := CapacityStatus{
+		Level:           CapacityOK,
+		UsedPercent:     UsedPercent(stats),
+		HighPercent:     w.HighPercent,
+		CriticalPercent: w.CriticalPercent,
+	}
+	switch {
+	case w.CriticalPercent > 0 && status.UsedPercent >= w.CriticalPercent:
+		status.Level = CapacityCritical
+	case w.HighPercent > 0 && status.UsedPercent >= w.HighPercent:
+		status.Level = CapacityHigh
+	}
+	return status
+}
+
+// capacityTracker remembers the last level seen so changes are reported
+// once rather than on every allocation
+type capacityTracker struct {
+	mu         sync.Mutex
+	watermarks Watermarks
+	level      CapacityLevel
+	onChange   CapacityChangeFunc
+}
+
+func newCapacityTracker() *capacityTracker {
+	return &capacityTracker{level: CapacityOK}
+}
+
+func (t *capacityTracker) set(w Watermarks, onChange CapacityChangeFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watermarks = w
+	t.onChange = onChange
+}
+
+// update evaluates stats and reports a change of level
+func (t *capacityTracker) update(stats Stats) CapacityStatus {
+	t.mu.Lock()
+	status := t.watermarks.Status(stats)
+	prev := t.level
+	t.level = status.Level
+	onChange := t.onChange
+	t.mu.Unlock()
+
+	if prev != status.Level && onChange != nil {
+		onChange(prev, status)
+	}
+	return status
+}
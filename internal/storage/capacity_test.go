@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSimpleEngine_Watermarks(t *testing.T) {
+	f, err := os.CreateTemp("", "engine_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	// Ten 1MB slabs
+	engine, err := NewSimpleEngine(f.Name(), 10*1024*1024, 1024*1024)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	var levels []CapacityLevel
+	engine.SetWatermarks(Watermarks{HighPercent: 50, CriticalPercent: 80}, func(prev CapacityLevel, status CapacityStatus) {
+		levels = append(levels, status.Level)
+	})
+
+	var offsets []int64
+	for i := 0; i < 8; i++ {
+		offset, err := engine.Allocate(1024 * 1024)
+		if err != nil {
+			t.Fatalf("Allocate() #%d error = %v", i, err)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	// 80% used: new data is refused
+	if _, err := engine.Allocate(1024 * 1024); !errors.Is(err, ErrInsufficientStorage) {
+		t.Fatalf("Allocate() above critical error = %v, want ErrInsufficientStorage", err)
+	}
+	if got := engine.Capacity().Level; got != CapacityCritical {
+		t.Errorf("Capacity().Level = %s, want critical", got)
+	}
+
+	// Deletes still work and bring usage back down
+	if err := engine.Free(offsets[0], 1024*1024); err != nil {
+		t.Fatalf("Free() error = %v", err)
+	}
+	if got := engine.Capacity().Level; got != CapacityHigh {
+		t.Errorf("Capacity().Level after delete = %s, want high", got)
+	}
+
+	want := []CapacityLevel{CapacityHigh, CapacityCritical, CapacityHigh}
+	if len(levels) != len(want) {
+		t.Fatalf("level changes = %v, want %v", levels, want)
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("level changes = %v, want %v", levels, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Coalescing configures the buffering of small writes. Writes landing in
+// the same slab within the window are flushed together, each run of
+// adjacent ones in a single device write.
+type Coalescing struct {
+	Window   time.Duration // Longest a write stays buffered
+	MaxBytes int64         // Buffered bytes of a slab that flush it at once
+	MaxWrite int           // Writes larger than this go straight to the device
+}
+
+// WriteFlusher is implemented by engines that buffer writes. Data written
+// to a range is only known to be on the device once Flush returns, with
+// the error writing it failed with if any.
+type WriteFlusher interface {
+	Flush(ctx context.Context, offset, size int64) error
+}
+
+// Flush waits until the data written to a range is on the device, on
+// engines that buffer writes
+func Flush(ctx context.Context, engine Engine, offset, size int64) error {
+	if f, ok := engine.(WriteFlusher); ok {
+		return f.Flush(ctx, offset, size)
+	}
+	return nil
+}
+
+// coalescer buffers small writes per slab
+type coalescer struct {
+	cfg      Coalescing
+	slabSize int64
+	write    func(ctx context.Context, offset int64, data []byte) error
+
+	mu      sync.Mutex
+	pending map[int64]*slabBuffer // By slab index
+	failed  []failedWrite         // Until reported by Flush
+	// Flushes run one at a time, so a buffer is on the device before the
+	// next one of its slab is written over it
+	flushMu sync.Mutex
+
+	buffered     atomic.Int64 // Writes buffered
+	deviceWrites atomic.Int64 // Device writes flushing them
+}
+
+// slabBuffer holds the writes to one slab waiting to be flushed
+type slabBuffer struct {
+	slab     int64
+	segments []segment // In arrival order, later ones win where they overlap
+	bytes    int64
+	timer    *time.Timer
+	flushed  chan struct{}
+	err      error // Set before flushed is closed
+}
+
+// maxFailedWrites bounds the failed writes kept for Flush to report, in
+// case their writers never ask
+const maxFailedWrites = 1024
+
+// failedWrite is a buffered write the device failed to take
+type failedWrite struct {
+	offset, end int64
+	err         error
+}
+
+type segment struct {
+	offset int64
+	data   []byte
+}
+
+func (s segment) end() int64 {
+	return s.offset + int64(len(s.data))
+}
+
+func newCoalescer(cfg Coalescing, slabSize int64, write func(context.Context, int64, []byte) error) *coalescer {
+	return &coalescer{
+		cfg:      cfg,
+		slabSize: slabSize,
+		write:    write,
+		pending:  make(map[int64]*slabBuffer),
+	}
+}
+
+// add buffers a copy of data, returning false for writes that are too
+// large or span slabs and must go to the device
+func (c *coalescer) add(offset int64, data []byte) bool {
+	size := int64(len(data))
+	if size == 0 || len(data) > c.cfg.MaxWrite {
+		return false
+	}
+	slab := offset / c.slabSize
+	if (offset+size-1)/c.slabSize != slab {
+		return false
+	}
+
+	c.mu.Lock()
+	b, ok := c.pending[slab]
+	if !ok {
+		b = &slabBuffer{slab: slab, flushed: make(chan struct{})}
+		b.timer = time.AfterFunc(c.cfg.Window, func() { c.flush(b) })
+		c.pending[slab] = b
+	}
+	b.segments = append(b.segments, segment{offset: offset, data: append([]byte(nil), data...)})
+	b.bytes += size
+	full := b.bytes >= c.cfg.MaxBytes
+	c.mu.Unlock()
+
+	c.buffered.Add(1)
+	if full {
+		c.flush(b)
+	}
+	return true
+}
+
+// flush writes a buffer to the device, unless it has been already
+func (c *coalescer) flush(b *slabBuffer) {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	if c.pending[b.slab] != b {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, b.slab)
+	b.timer.Stop()
+	c.mu.Unlock()
+
+	merged, runOf := runs(b.segments)
+	for r, run := range merged {
+		c.deviceWrites.Add(1)
+		err := c.write(context.Background(), run.offset, run.data)
+		if err == nil {
+			continue
+		}
+		if b.err == nil {
+			b.err = err
+		}
+		// Writers whose data was in the buffer find out in Flush, even
+		// after it is gone
+		c.mu.Lock()
+		for i, s := range b.segments {
+			if runOf[i] == r {
+				c.failed = append(c.failed, failedWrite{offset: s.offset, end: s.end(), err: err})
+			}
+		}
+		if len(c.failed) > maxFailedWrites {
+			c.failed = c.failed[len(c.failed)-maxFailedWrites:]
+		}
+		c.mu.Unlock()
+	}
+	close(b.flushed)
+}
+
+// overlapping returns the buffers holding writes to the range
+func (c *coalescer) overlapping(offset, size int64) []*slabBuffer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var found []*slabBuffer
+	end := offset + size
+	for slab := offset / c.slabSize; slab <= (end-1)/c.slabSize; slab++ {
+		b, ok := c.pending[slab]
+		if !ok {
+			continue
+		}
+		for _, s := range b.segments {
+			if s.offset < end && offset < s.end() {
+				found = append(found, b)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// flushRange flushes the buffers holding writes to the range at once, so
+// reads see them and direct writes are not overwritten by them. Errors go
+// to the writers waiting for their data in Flush.
+func (c *coalescer) flushRange(offset, size int64) {
+	for _, b := range c.overlapping(offset, size) {
+		c.flush(b)
+	}
+}
+
+// wait waits for the buffers holding writes to the range to be flushed in
+// their turn, and returns the error writing any of them failed with
+func (c *coalescer) wait(ctx context.Context, offset, size int64) error {
+	for _, b := range c.overlapping(offset, size) {
+		select {
+		case <-b.flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	end := offset + size
+	kept := c.failed[:0]
+	for _, f := range c.failed {
+		if f.offset < end && offset < f.end {
+			if err == nil {
+				err = f.err
+			}
+			continue
+		}
+		kept = append(kept, f)
+	}
+	c.failed = kept
+	return err
+}
+
+// flushAll flushes every buffer
+func (c *coalescer) flushAll() error {
+	c.mu.Lock()
+	buffers := make([]*slabBuffer, 0, len(c.pending))
+	for _, b := range c.pending {
+		buffers = append(buffers, b)
+	}
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, b := range buffers {
+		c.flush(b)
+		if b.err != nil && firstErr == nil {
+			firstErr = b.err
+		}
+	}
+	return firstErr
+}
+
+// runs merges segments into runs of contiguous data, one per device
+// write, and returns the run each segment went into. Where segments
+// overlap, the later one's data is kept.
+func runs(segments []segment) ([]segment, []int) {
+	order := make([]int, len(segments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return segments[order[i]].offset < segments[order[j]].offset
+	})
+
+	// Find the extent of each run, and the run each segment falls in
+	var merged []segment
+	runOf := make([]int, len(segments))
+	var start, end int64
+	for n, i := range order {
+		s := segments[i]
+		if n == 0 || s.offset > end {
+			if n > 0 {
+				merged = append(merged, segment{offset: start, data: make([]byte, end-start)})
+			}
+			start, end = s.offset, s.end()
+		} else {
+			end = max(end, s.end())
+		}
+		runOf[i] = len(merged)
+	}
+	if len(segments) > 0 {
+		merged = append(merged, segment{offset: start, data: make([]byte, end-start)})
+	}
+
+	for i, s := range segments {
+		run := merged[runOf[i]]
+		copy(run.data[s.offset-run.offset:], s.data)
+	}
+	return merged, runOf
+}
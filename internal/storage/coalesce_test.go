@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openCoalescingEngine opens an engine on a temporary 16MB device, with
+// 1MB slabs and writes up to 64KB buffered
+func openCoalescingEngine(t *testing.T, window time.Duration) *SimpleEngine {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "comio-coalesce-*.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Truncate(16 * 1024 * 1024)
+	f.Close()
+
+	engine, err := NewSimpleEngine(f.Name(), 16*1024*1024, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { engine.Close() })
+	engine.SetWriteCoalescing(Coalescing{Window: window, MaxBytes: 256 * 1024, MaxWrite: 64 * 1024})
+	return engine
+}
+
+func TestWriteCoalescing_AdjacentWrites(t *testing.T) {
+	engine := openCoalescingEngine(t, time.Hour)
+	ctx := context.Background()
+
+	// Small objects written side by side in a slab, chunk by chunk as the
+	// object service streams them
+	var want []byte
+	for i := range 16 {
+		data := bytes.Repeat([]byte{byte(i + 1)}, 4096)
+		if err := engine.Write(ctx, int64(i)*4096, data); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, data...)
+	}
+	if got := engine.coalescer.deviceWrites.Load(); got != 0 {
+		t.Errorf("%d device writes before the flush, want 0", got)
+	}
+
+	// Reads see buffered data
+	got, err := engine.Read(ctx, 0, int64(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("read does not return the buffered writes")
+	}
+	if n := engine.coalescer.deviceWrites.Load(); n != 1 {
+		t.Errorf("%d device writes for 16 adjacent writes, want 1", n)
+	}
+}
+
+func TestWriteCoalescing_Window(t *testing.T) {
+	engine := openCoalescingEngine(t, 5*time.Millisecond)
+	ctx := context.Background()
+
+	// Writers to one slab, each waiting for its data until the window ends
+	for i := range 8 {
+		if err := engine.Write(ctx, int64(i)*8192, bytes.Repeat([]byte{byte(i + 1)}, 8192)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := engine.Flush(ctx, int64(i)*8192, 8192); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := engine.coalescer.deviceWrites.Load(); n != 1 {
+		t.Errorf("%d device writes, want 1", n)
+	}
+	if len(engine.coalescer.pending) != 0 {
+		t.Errorf("%d buffers pending after every writer flushed", len(engine.coalescer.pending))
+	}
+}
+
+func TestWriteCoalescing_Bypass(t *testing.T) {
+	engine := openCoalescingEngine(t, time.Hour)
+	ctx := context.Background()
+
+	if err := engine.Write(ctx, 0, bytes.Repeat([]byte{1}, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	// Large writes go to the device, after buffered writes they overlap
+	large := bytes.Repeat([]byte{2}, 128*1024)
+	if err := engine.Write(ctx, 2048, large); err != nil {
+		t.Fatal(err)
+	}
+	// as do writes spanning slabs
+	if err := engine.Write(ctx, 1024*1024-10, bytes.Repeat([]byte{3}, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if n := engine.coalescer.buffered.Load(); n != 1 {
+		t.Errorf("%d writes buffered, want 1", n)
+	}
+
+	got, err := engine.Read(ctx, 0, 2048+int64(len(large)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(bytes.Repeat([]byte{1}, 2048), large...)
+	if !bytes.Equal(got, want) {
+		t.Error("buffered write landed over the later direct write")
+	}
+}
+
+func TestWriteCoalescing_FlushError(t *testing.T) {
+	engine := openCoalescingEngine(t, time.Hour)
+	ctx := context.Background()
+
+	failure := errors.New("device gone")
+	write := engine.coalescer.write
+	engine.coalescer.write = func(ctx context.Context, offset int64, data []byte) error {
+		if offset < 8192 {
+			return failure
+		}
+		return write(ctx, offset, data)
+	}
+
+	engine.Write(ctx, 0, make([]byte, 4096))
+	engine.Write(ctx, 4096, make([]byte, 4096))
+	engine.Write(ctx, 16384, make([]byte, 4096))
+	if err := engine.Sync(); !errors.Is(err, failure) {
+		t.Errorf("Sync() error = %v, want the flush error", err)
+	}
+
+	// Writers learn of the failure after the buffer is gone, once each
+	if err := engine.Flush(ctx, 4096, 4096); !errors.Is(err, failure) {
+		t.Errorf("Flush() of a failed write error = %v, want the flush error", err)
+	}
+	if err := engine.Flush(ctx, 4096, 4096); err != nil {
+		t.Errorf("second Flush() error = %v, want the failure reported once", err)
+	}
+	if err := engine.Flush(ctx, 16384, 4096); err != nil {
+		t.Errorf("Flush() of a written range error = %v", err)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	merged, runOf := runs([]segment{
+		{offset: 10, data: []byte("bbbb")},
+		{offset: 0, data: []byte("aaaaaaaaaa")},
+		{offset: 12, data: []byte("cc")}, // Overwrites part of the first
+		{offset: 20, data: []byte("dd")},
+	})
+	if len(merged) != 2 {
+		t.Fatalf("runs() = %d runs, want 2", len(merged))
+	}
+	if merged[0].offset != 0 || string(merged[0].data) != "aaaaaaaaaabbcc" {
+		t.Errorf("first run = %d %q", merged[0].offset, merged[0].data)
+	}
+	if merged[1].offset != 20 || string(merged[1].data) != "dd" {
+		t.Errorf("second run = %d %q", merged[1].offset, merged[1].data)
+	}
+	if runOf[0] != 0 || runOf[3] != 1 {
+		t.Errorf("runOf = %v", runOf)
+	}
+}
@@ -0,0 +1,5 @@
+//go:build !windows
+
+package storage
+
+func checkDevicePath(path string) error { return nil }
@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkDevicePath rejects raw volumes and disks (\\.\PhysicalDrive0,
+// \\.\D:), which Windows only reads and writes in whole sectors and whose
+// size cannot be found by seeking. Storage on Windows is a file.
+func checkDevicePath(path string) error {
+	if strings.HasPrefix(path, `\\.\`) || strings.HasPrefix(path, `//./`) {
+		return fmt.Errorf("raw device %s is not supported on Windows, use a file", path)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDevice_RejectsRawWindowsDevice(t *testing.T) {
+	err := NewDevice(`\\.\PhysicalDrive0`, 4096).Open()
+	if err == nil || !strings.Contains(err.Error(), "not supported on Windows") {
+		t.Errorf("Open() error = %v, want raw devices refused", err)
+	}
+}
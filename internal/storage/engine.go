@@ -1,11 +1,15 @@
 package storage
 
-// Engine defines the storage engine interface
+import "context"
+
+// Engine defines the storage engine interface. Reads and writes stop with
+// the context's error once it is canceled, so I/O for a client that went
+// away does not run to completion.
 type Engine interface {
 	Open(devicePath string) error
 	Close() error
-	Read(offset, size int64) ([]byte, error)
-	Write(offset int64, data []byte) error
+	Read(ctx context.Context, offset, size int64) ([]byte, error)
+	Write(ctx context.Context, offset int64, data []byte) error
 	Allocate(size int64) (offset int64, err error)
 	Free(offset, size int64) error
 	Sync() error
@@ -0,0 +1,155 @@
+package storage
+
+import "sort"
+
+// FragmentationLevel is the band of fragmentation relative to the
+// thresholds
+type FragmentationLevel string
+
+const (
+	FragmentationOK       FragmentationLevel = "ok"
+	FragmentationWarning  FragmentationLevel = "warning"
+	FragmentationCritical FragmentationLevel = "critical"
+)
+
+// FragmentationThresholds are the shares of allocated space compaction
+// would give back at which fragmentation warrants a warning and a
+// critical alert. 0 disables a threshold.
+type FragmentationThresholds struct {
+	WarningPercent  float64
+	CriticalPercent float64
+}
+
+// Level returns the band a fragmentation percentage falls in
+func (t FragmentationThresholds) Level(percent float64) FragmentationLevel {
+	switch {
+	case t.CriticalPercent > 0 && percent >= t.CriticalPercent:
+		return FragmentationCritical
+	case t.WarningPercent > 0 && percent >= t.WarningPercent:
+		return FragmentationWarning
+	default:
+		return FragmentationOK
+	}
+}
+
+// SlabFragmentation is the layout of one slab. Wasted bytes are holes left
+// by freed objects that new data cannot be packed into; free bytes are the
+// tail of a slab still open to small objects.
+type SlabFragmentation struct {
+	Offset        int64   `json:"offset"`
+	Size          int64   `json:"size"`
+	Objects       int     `json:"objects"`
+	UsedBytes     int64   `json:"used_bytes"`
+	WastedBytes   int64   `json:"wasted_bytes"`
+	FreeBytes     int64   `json:"free_bytes"`
+	Fragmentation float64 `json:"fragmentation_percent"` // Wasted share of the slab, 0-100
+}
+
+// FragmentationReport summarises how much allocated space no longer holds
+// live data, and how much of it compacting the slabs would give back
+type FragmentationReport struct {
+	SlabSize         int64 `json:"slab_size"`
+	AllocatedBytes   int64 `json:"allocated_bytes"` // Space taken by slabs, which is never returned to the device
+	UsedBytes        int64 `json:"used_bytes"`
+	WastedBytes      int64 `json:"wasted_bytes"`
+	ReclaimableBytes int64 `json:"reclaimable_bytes"` // Allocated space compaction would free
+	// Reclaimable share of the allocated space, 0-100
+	Fragmentation float64             `json:"fragmentation_percent"`
+	Slabs         []SlabFragmentation `json:"slabs"`
+}
+
+// MostFragmented returns the n slabs wasting the most space, all of them
+// if n is zero or negative
+func (r FragmentationReport) MostFragmented(n int) []SlabFragmentation {
+	slabs := append([]SlabFragmentation(nil), r.Slabs...)
+	sort.SliceStable(slabs, func(i, j int) bool {
+		return slabs[i].WastedBytes > slabs[j].WastedBytes
+	})
+	if n > 0 && len(slabs) > n {
+		slabs = slabs[:n]
+	}
+	return slabs
+}
+
+// Compactable returns the slab-sized slabs wasting at least minPercent of
+// their space, most wasteful first. Moving their objects out empties them,
+// and an empty slab takes small objects again from its start. Slabs of
+// larger objects are left alone: their waste is only reclaimed once the
+// object is deleted.
+func (r FragmentationReport) Compactable(minPercent float64) []SlabFragmentation {
+	var slabs []SlabFragmentation
+	for _, s := range r.MostFragmented(0) {
+		if s.Size == r.SlabSize && s.WastedBytes > 0 && s.Fragmentation >= minPercent {
+			slabs = append(slabs, s)
+		}
+	}
+	return slabs
+}
+
+// FragmentationReporter is implemented by engines that can report the
+// fragmentation of their slabs
+type FragmentationReporter interface {
+	Fragmentation() FragmentationReport
+}
+
+// SlabDrainer is implemented by engines that can keep new data out of the
+// slabs compaction is emptying, until release is called
+type SlabDrainer interface {
+	DrainSlabs(offsets []int64) (release func())
+}
+
+// Fragmentation reports the layout of every slab, in offset order.
+// Compaction is estimated to pack the live data of slab-sized slabs into
+// as few slabs as it fills, and to trim multi-slab allocations down to the
+// slabs their object needs.
+func (a *SlabAllocator) Fragmentation() FragmentationReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	report := FragmentationReport{
+		SlabSize:       a.slabSize,
+		AllocatedBytes: a.nextOffset,
+		UsedBytes:      a.usedBytes,
+		Slabs:          make([]SlabFragmentation, 0, len(a.slabs)),
+	}
+
+	var packed, needed int64
+	for _, slab := range a.slabs {
+		s := SlabFragmentation{
+			Offset:    slab.offset,
+			Size:      slab.size,
+			Objects:   len(slab.fragments),
+			UsedBytes: slab.used,
+		}
+		if slab.size == a.slabSize {
+			// Small objects are appended, so only the space past the
+			// last one is still usable
+			s.WastedBytes = slab.end - slab.used
+			packed += slab.used
+		} else {
+			s.WastedBytes = slab.size - slab.used
+			needed += roundUp(slab.used, a.slabSize)
+		}
+		s.FreeBytes = slab.size - slab.used - s.WastedBytes
+		if slab.size > 0 {
+			s.Fragmentation = float64(s.WastedBytes) * 100 / float64(slab.size)
+		}
+		report.WastedBytes += s.WastedBytes
+		report.Slabs = append(report.Slabs, s)
+	}
+	sort.Slice(report.Slabs, func(i, j int) bool {
+		return report.Slabs[i].Offset < report.Slabs[j].Offset
+	})
+
+	needed += roundUp(packed, a.slabSize)
+	report.ReclaimableBytes = max(report.AllocatedBytes-needed, 0)
+	if report.AllocatedBytes > 0 {
+		report.Fragmentation = float64(report.ReclaimableBytes) * 100 / float64(report.AllocatedBytes)
+	}
+	return report
+}
+
+// roundUp rounds n up to a multiple of size
+func roundUp(n, size int64) int64 {
+	return (n + size - 1) / size * size
+}
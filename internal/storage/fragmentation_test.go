@@ -0,0 +1,72 @@
+package storage
+
+import "testing"
+
+func TestSlabAllocator_Fragmentation(t *testing.T) {
+	a := NewSlabAllocator(1000, 100)
+
+	// Slab 0 holds three small objects, slab 100 a fourth, and slabs
+	// 200-399 a large object
+	small := make([]int64, 4)
+	for i, size := range []int64{40, 30, 30, 50} {
+		offset, err := a.Allocate(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		small[i] = offset
+	}
+	large, err := a.Allocate(150)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := a.Fragmentation()
+	if report.AllocatedBytes != 400 || report.WastedBytes != 50 || report.ReclaimableBytes != 0 {
+		t.Errorf("report = %+v, want only the large object's padding wasted", report)
+	}
+
+	// Freeing objects leaves holes compaction would close
+	a.Free(small[0], 40)
+	a.Free(small[1], 30)
+	a.Free(large, 150)
+
+	report = a.Fragmentation()
+	if len(report.Slabs) != 3 {
+		t.Fatalf("slabs = %+v", report.Slabs)
+	}
+	first := report.Slabs[0]
+	if first.Offset != 0 || first.Objects != 1 || first.UsedBytes != 30 || first.WastedBytes != 70 || first.FreeBytes != 0 {
+		t.Errorf("slab 0 = %+v", first)
+	}
+	if first.Fragmentation != 70 {
+		t.Errorf("slab 0 fragmentation = %.1f, want 70", first.Fragmentation)
+	}
+	if s := report.Slabs[1]; s.WastedBytes != 0 || s.FreeBytes != 50 {
+		t.Errorf("slab 100 = %+v", s)
+	}
+	if s := report.Slabs[2]; s.Offset != 200 || s.WastedBytes != 200 {
+		t.Errorf("slab 200 = %+v", s)
+	}
+
+	// The 80 live bytes fit in one slab, giving back three of four
+	if report.UsedBytes != 80 || report.WastedBytes != 270 || report.ReclaimableBytes != 300 || report.Fragmentation != 75 {
+		t.Errorf("report = %+v", report)
+	}
+	if top := report.MostFragmented(1); len(top) != 1 || top[0].Offset != 200 {
+		t.Errorf("MostFragmented(1) = %+v", top)
+	}
+	// Only the small objects' slab is worth compacting
+	if slabs := report.Compactable(50); len(slabs) != 1 || slabs[0].Offset != 0 {
+		t.Errorf("Compactable(50) = %+v, want slab 0", slabs)
+	}
+	if slabs := report.Compactable(80); len(slabs) != 0 {
+		t.Errorf("Compactable(80) = %+v, want none", slabs)
+	}
+
+	thresholds := FragmentationThresholds{WarningPercent: 30, CriticalPercent: 80}
+	for percent, want := range map[float64]FragmentationLevel{0: FragmentationOK, 30: FragmentationWarning, 75: FragmentationWarning, 80: FragmentationCritical} {
+		if got := thresholds.Level(percent); got != want {
+			t.Errorf("Level(%.0f) = %s, want %s", percent, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultErrorThreshold is the number of consecutive I/O errors after which
+// a device is considered failed
+const DefaultErrorThreshold = 3
+
+// ErrDeviceUnhealthy is returned when allocating on a failed device
+var ErrDeviceUnhealthy = errors.New("storage device is unhealthy")
+
+// DeviceHealth is the I/O error record of a device
+type DeviceHealth struct {
+	Path              string     `json:"path"`
+	Healthy           bool       `json:"healthy"`
+	ConsecutiveErrors int        `json:"consecutive_errors"`
+	ReadErrors        int64      `json:"read_errors"`
+	WriteErrors       int64      `json:"write_errors"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time `json:"last_error_at,omitempty"`
+	UnhealthySince    *time.Time `json:"unhealthy_since,omitempty"`
+}
+
+// HealthReporter is implemented by engines that track the health of their
+// devices
+type HealthReporter interface {
+	DeviceHealth() []DeviceHealth
+}
+
+// healthTracker marks a device unhealthy after persistent I/O errors.
+// A device that failed stays unhealthy until the process restarts, so an
+// intermittently failing disk does not flap back into service.
+type healthTracker struct {
+	mu        sync.Mutex
+	threshold int
+	status    DeviceHealth
+}
+
+func newHealthTracker(path string) *healthTracker {
+	return &healthTracker{
+		threshold: DefaultErrorThreshold,
+		status:    DeviceHealth{Path: path, Healthy: true},
+	}
+}
+
+// record accounts for the outcome of a read or write
+func (t *healthTracker) record(write bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err == nil {
+		t.status.ConsecutiveErrors = 0
+		return
+	}
+
+	now := time.Now()
+	if write {
+		t.status.WriteErrors++
+	} else {
+		t.status.ReadErrors++
+	}
+	t.status.ConsecutiveErrors++
+	t.status.LastError = err.Error()
+	t.status.LastErrorAt = &now
+	if t.status.Healthy && t.status.ConsecutiveErrors >= t.threshold {
+		t.status.Healthy = false
+		t.status.UnhealthySince = &now
+	}
+}
+
+func (t *healthTracker) healthy() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status.Healthy
+}
+
+func (t *healthTracker) setThreshold(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n > 0 {
+		t.threshold = n
+	}
+}
+
+func (t *healthTracker) snapshot() DeviceHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
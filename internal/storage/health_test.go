@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestHealthTracker_ConsecutiveErrors(t *testing.T) {
+	tracker := newHealthTracker("/dev/test")
+	ioErr := errors.New("input/output error")
+
+	tracker.record(false, ioErr)
+	tracker.record(true, ioErr)
+	tracker.record(false, nil) // A success in between resets the streak
+	tracker.record(false, ioErr)
+	tracker.record(false, ioErr)
+	if !tracker.healthy() {
+		t.Fatal("device marked unhealthy before the threshold")
+	}
+
+	tracker.record(false, ioErr)
+	status := tracker.snapshot()
+	if status.Healthy || status.UnhealthySince == nil {
+		t.Errorf("status = %+v, want unhealthy", status)
+	}
+	if status.ReadErrors != 4 || status.WriteErrors != 1 {
+		t.Errorf("errors = %d read, %d write, want 4 and 1", status.ReadErrors, status.WriteErrors)
+	}
+
+	// Failed devices stay failed
+	tracker.record(false, nil)
+	if tracker.healthy() {
+		t.Error("device recovered after a single success")
+	}
+}
+
+func TestSimpleEngine_StopsAllocatingOnFailedDevice(t *testing.T) {
+	f, err := os.CreateTemp("", "engine_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	engine, err := NewSimpleEngine(f.Name(), 64*1024*1024, 4*1024*1024)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+	engine.SetErrorThreshold(2)
+
+	offset, err := engine.Allocate(1024)
+	if err != nil {
+		t.Fatalf("Allocate() error = %v", err)
+	}
+	if err := engine.Write(context.Background(), offset, make([]byte, 1024)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// The device disappears underneath the engine
+	engine.device.file.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := engine.Read(context.Background(), offset, 1024); err == nil {
+			t.Fatal("Read() on a closed device succeeded")
+		}
+	}
+
+	health := engine.DeviceHealth()
+	if len(health) != 1 || health[0].Healthy || health[0].Path != f.Name() {
+		t.Errorf("DeviceHealth() = %+v, want %s unhealthy", health, f.Name())
+	}
+	if _, err := engine.Allocate(1024); !errors.Is(err, ErrDeviceUnhealthy) {
+		t.Errorf("Allocate() error = %v, want ErrDeviceUnhealthy", err)
+	}
+}
@@ -0,0 +1,36 @@
+package storage
+
+import "errors"
+
+// ErrRestoring is returned by Allocate while the allocations made before a
+// restart are being restored, when new space could overlap existing data
+var ErrRestoring = errors.New("storage allocations are being restored after a restart")
+
+// Restorer is implemented by engines whose allocations live in memory and
+// must be restored from the metadata after a restart. Allocate fails with
+// ErrRestoring between BeginRestore and EndRestore.
+type Restorer interface {
+	BeginRestore()
+	MarkAllocated(offset, size int64) error
+	EndRestore()
+}
+
+// BeginRestore refuses allocations until EndRestore
+func (e *SimpleEngine) BeginRestore() {
+	e.restoring.Store(true)
+}
+
+// MarkAllocated registers an extent allocated before the restart, of the
+// size it was allocated with
+func (e *SimpleEngine) MarkAllocated(offset, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	return e.allocator.MarkAllocated(offset, size)
+}
+
+// EndRestore allows allocations again
+func (e *SimpleEngine) EndRestore() {
+	e.capacity.update(e.allocator.Stats())
+	e.restoring.Store(false)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// ioScheduler shares the device between concurrent streams by locking the
+// byte ranges they touch. Streams take turns of one chunk each in arrival
+// order, queueing again behind whoever is waiting after every chunk, so a
+// multi-GB transfer is interleaved with the requests arriving during it
+// rather than making them wait for all of it.
+//
+// Turns on disjoint ranges run at the same time, as do reads of the same
+// range; a write excludes every other turn overlapping it. A turn waits
+// only for the running and earlier queued turns it overlaps, so streams
+// touching other ranges pass it.
+type ioScheduler struct {
+	mu      sync.Mutex
+	running []*ioTurn
+	queue   []*ioTurn
+}
+
+// ioTurn is a lock on the range [offset, end) of the device
+type ioTurn struct {
+	offset  int64
+	end     int64
+	write   bool
+	granted chan struct{}
+}
+
+// wholeDevice is the size of a turn covering the whole device
+const wholeDevice = math.MaxInt64
+
+// conflicts reports whether two turns may not run at the same time
+func (t *ioTurn) conflicts(other *ioTurn) bool {
+	return (t.write || other.write) && t.offset < other.end && other.offset < t.end
+}
+
+// acquire waits for a turn on size bytes at offset, exclusive if write is
+// set, and returns the context's error if it is canceled first. Each turn
+// must be released.
+func (s *ioScheduler) acquire(ctx context.Context, offset, size int64, write bool) (*ioTurn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	turn := &ioTurn{offset: offset, end: offset + size, write: write}
+	if size > math.MaxInt64-offset {
+		turn.end = math.MaxInt64
+	}
+
+	s.mu.Lock()
+	if !s.blocked(turn, len(s.queue)) {
+		s.running = append(s.running, turn)
+		s.mu.Unlock()
+		return turn, nil
+	}
+	turn.granted = make(chan struct{})
+	s.queue = append(s.queue, turn)
+	s.mu.Unlock()
+
+	select {
+	case <-turn.granted:
+		return turn, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-turn.granted:
+		// Granted as the context ended; hand it on
+		s.end(turn)
+	default:
+		s.queue = removeTurn(s.queue, turn)
+		// Turns queued behind one that gave up may now run
+		s.dispatch()
+	}
+	return nil, ctx.Err()
+}
+
+// release ends a turn and starts the queued turns it held up
+func (s *ioScheduler) release(turn *ioTurn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.end(turn)
+}
+
+func (s *ioScheduler) end(turn *ioTurn) {
+	s.running = removeTurn(s.running, turn)
+	s.dispatch()
+}
+
+// blocked reports whether turn conflicts with a running turn or with one
+// of the first queued turns, which go first
+func (s *ioScheduler) blocked(turn *ioTurn, queued int) bool {
+	for _, t := range s.running {
+		if turn.conflicts(t) {
+			return true
+		}
+	}
+	for _, t := range s.queue[:queued] {
+		if turn.conflicts(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch starts the queued turns no running or earlier queued turn
+// holds up
+func (s *ioScheduler) dispatch() {
+	for i := 0; i < len(s.queue); {
+		turn := s.queue[i]
+		if s.blocked(turn, i) {
+			i++
+			continue
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.running = append(s.running, turn)
+		close(turn.granted)
+	}
+}
+
+// removeTurn removes turn from turns
+func removeTurn(turns []*ioTurn, turn *ioTurn) []*ioTurn {
+	for i, t := range turns {
+		if t == turn {
+			return append(turns[:i], turns[i+1:]...)
+		}
+	}
+	return turns
+}
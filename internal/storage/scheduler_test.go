@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued waits until n turns are waiting on s
+func queued(t *testing.T, s *ioScheduler, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; {
+		s.mu.Lock()
+		l := len(s.queue)
+		s.mu.Unlock()
+		if l == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d turns queued, want %d", l, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIOScheduler_RoundRobin(t *testing.T) {
+	var s ioScheduler
+	ctx := context.Background()
+
+	// A large write holds a turn when a small read of its range arrives
+	turn, err := s.acquire(ctx, 0, 100, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan string, 2)
+	go func() {
+		turn, _ := s.acquire(ctx, 50, 100, false)
+		order <- "small read"
+		s.release(turn)
+	}()
+	queued(t, &s, 1)
+
+	// The write's next chunk goes behind the read
+	s.release(turn)
+	turn, _ = s.acquire(ctx, 100, 100, true)
+	order <- "next chunk"
+	s.release(turn)
+
+	if first := <-order; first != "small read" {
+		t.Errorf("first after the chunk = %q, want the small read", first)
+	}
+}
+
+func TestIOScheduler_Ranges(t *testing.T) {
+	var s ioScheduler
+	ctx := context.Background()
+
+	// Writes to disjoint ranges and reads of the same range run together
+	held := []*ioTurn{}
+	for _, r := range []struct {
+		offset, size int64
+		write        bool
+	}{{0, 100, true}, {100, 100, true}, {200, 100, false}, {250, 100, false}} {
+		turn, err := s.acquire(ctx, r.offset, r.size, r.write)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, turn)
+	}
+	queued(t, &s, 0)
+
+	// A write overlapping a read waits for it, and a read arriving after
+	// that write and overlapping it waits for the write
+	wrote := make(chan struct{})
+	go func() {
+		turn, _ := s.acquire(ctx, 290, 20, true)
+		close(wrote)
+		s.release(turn)
+	}()
+	queued(t, &s, 1)
+	read := make(chan struct{})
+	go func() {
+		turn, _ := s.acquire(ctx, 300, 10, false)
+		close(read)
+		s.release(turn)
+	}()
+	queued(t, &s, 2)
+
+	// Turns clear of both pass them
+	turn, err := s.acquire(ctx, 400, 100, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.release(turn)
+
+	for _, turn := range held[:3] {
+		s.release(turn)
+	}
+	select {
+	case <-wrote:
+		t.Fatal("write ran while a read of its range was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	s.release(held[3])
+	<-wrote
+	<-read
+}
+
+func TestIOScheduler_Cancel(t *testing.T) {
+	var s ioScheduler
+	held, _ := s.acquire(context.Background(), 0, 100, false)
+
+	// A write giving up does not hold back the reads queued behind it
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, 0, wholeDevice, true)
+		failed <- err
+	}()
+	queued(t, &s, 1)
+	read := make(chan struct{})
+	go func() {
+		turn, _ := s.acquire(context.Background(), 500, 100, false)
+		close(read)
+		s.release(turn)
+	}()
+	queued(t, &s, 2)
+
+	cancel()
+	if err := <-failed; !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire() error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatal("read queued behind a canceled write never ran")
+	}
+	s.release(held)
+
+	if _, err := s.acquire(ctx, 0, 1, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire() with a canceled context error = %v, want context.Canceled", err)
+	}
+}
+
+// TestIOScheduler_Exclusion has readers and writers copy to and from one
+// buffer under turns on overlapping and disjoint ranges. Run with -race,
+// any two turns touching the same bytes at once are reported.
+func TestIOScheduler_Exclusion(t *testing.T) {
+	var s ioScheduler
+	buf := make([]byte, 4096)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for g := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(g)))
+			for range 100 {
+				offset := rng.Int63n(int64(len(buf)) - 256)
+				size := 1 + rng.Int63n(256)
+				write := rng.Intn(2) == 0
+
+				turn, err := s.acquire(ctx, offset, size, write)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				region := buf[offset : offset+size]
+				if write {
+					for i := range region {
+						region[i] = byte(g)
+					}
+					// Hold the turn long enough for others to try the range
+					time.Sleep(10 * time.Microsecond)
+					// No other writer may have touched the range meanwhile
+					if !bytes.Equal(region, bytes.Repeat([]byte{byte(g)}, int(size))) {
+						t.Errorf("range [%d, %d) changed under a write", offset, offset+size)
+					}
+				} else {
+					snapshot := append([]byte(nil), region...)
+					time.Sleep(10 * time.Microsecond)
+					if !bytes.Equal(region, snapshot) {
+						t.Errorf("range [%d, %d) changed under a read", offset, offset+size)
+					}
+				}
+				s.release(turn)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(s.running) != 0 || len(s.queue) != 0 {
+		t.Errorf("%d turns running and %d queued after every turn was released", len(s.running), len(s.queue))
+	}
+}
+
+func TestSimpleEngine_ConcurrentRanges(t *testing.T) {
+	f, err := os.CreateTemp("", "comio-ranges-*.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Truncate(16 * 1024 * 1024)
+	f.Close()
+
+	engine, err := NewSimpleEngine(f.Name(), 16*1024*1024, 4*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each writer fills its own range, overlapping its neighbours' by half,
+	// so every byte ends up written by one of the two writers covering it
+	const size = 64 * 1024
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte(w + 1)}, size)
+			for range 20 {
+				if err := engine.Write(ctx, int64(w)*size/2, data); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := engine.Read(ctx, int64(w)*size/2, size); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := engine.Read(ctx, 0, 9*size/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for half := range 9 {
+		chunk := data[half*size/2 : (half+1)*size/2]
+		// Halves are covered by writers half-1 and half, and written whole
+		var want []byte
+		for _, w := range []int{half - 1, half} {
+			if w >= 0 && w < 8 && chunk[0] == byte(w+1) {
+				want = bytes.Repeat([]byte{byte(w + 1)}, size/2)
+			}
+		}
+		if !bytes.Equal(chunk, want) {
+			t.Errorf("half %d is torn or written by the wrong writer", half)
+		}
+	}
+}
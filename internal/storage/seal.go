@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// SealSize is the size of the seal record ending a sealed extent
+const SealSize = 32
+
+// sealMagic starts every seal record
+var sealMagic = [4]byte{'C', 'S', 'L', '1'}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrTornWrite is returned for sealed extents whose seal record is missing
+// or does not match their data: the write was cut short by a crash
+var ErrTornWrite = errors.New("torn write")
+
+// Sealer is implemented by engines that end extents with a seal record
+// holding their length and the CRC-32C of their data. The record is
+// written once the data is, so after a crash an extent without one, or
+// whose data does not match it, is known to be incomplete whatever the
+// metadata says.
+type Sealer interface {
+	// Sealing reports whether extents are sealed, and so need SealSize
+	// bytes allocated after their data for the record
+	Sealing() bool
+	// Seal writes the seal record of the size bytes at offset, whose
+	// CRC-32C is sum. It reports false, writing nothing, when sealing is
+	// off.
+	Seal(ctx context.Context, offset, size int64, sum uint32) (bool, error)
+	// CheckSeal returns ErrTornWrite unless the extent's seal record is on
+	// the device and, with verifyData, its data matches the CRC in it
+	CheckSeal(ctx context.Context, offset, size int64, verifyData bool) error
+}
+
+// NewSealHash returns the CRC-32C hash seal records hold of the data they
+// seal
+func NewSealHash() hash.Hash32 {
+	return crc32.New(castagnoli)
+}
+
+// Sealing reports whether an engine seals extents
+func Sealing(engine Engine) bool {
+	s, ok := engine.(Sealer)
+	return ok && s.Sealing()
+}
+
+// Seal seals an extent on engines that seal them, reporting whether it did
+func Seal(ctx context.Context, engine Engine, offset, size int64, sum uint32) (bool, error) {
+	if s, ok := engine.(Sealer); ok {
+		return s.Seal(ctx, offset, size, sum)
+	}
+	return false, nil
+}
+
+// CheckSeal checks the seal of an extent on engines that seal them
+func CheckSeal(ctx context.Context, engine Engine, offset, size int64, verifyData bool) error {
+	if s, ok := engine.(Sealer); ok {
+		return s.CheckSeal(ctx, offset, size, verifyData)
+	}
+	return nil
+}
+
+// sealRecord is the content of a seal record: magic, data length, data
+// CRC-32C, reserved bytes and the CRC-32C of what precedes it
+type sealRecord struct {
+	length int64
+	sum    uint32
+}
+
+func (r sealRecord) encode() []byte {
+	buf := make([]byte, SealSize)
+	copy(buf, sealMagic[:])
+	binary.LittleEndian.PutUint64(buf[4:], uint64(r.length))
+	binary.LittleEndian.PutUint32(buf[12:], r.sum)
+	binary.LittleEndian.PutUint32(buf[SealSize-4:], crc32.Checksum(buf[:SealSize-4], castagnoli))
+	return buf
+}
+
+// decodeSeal parses a seal record, reporting false for bytes that are not
+// a whole one
+func decodeSeal(buf []byte) (sealRecord, bool) {
+	if len(buf) != SealSize || [4]byte(buf[:4]) != sealMagic {
+		return sealRecord{}, false
+	}
+	if crc32.Checksum(buf[:SealSize-4], castagnoli) != binary.LittleEndian.Uint32(buf[SealSize-4:]) {
+		return sealRecord{}, false
+	}
+	return sealRecord{
+		length: int64(binary.LittleEndian.Uint64(buf[4:])),
+		sum:    binary.LittleEndian.Uint32(buf[12:]),
+	}, true
+}
+
+// SetSealing lets Seal write seal records, in the SealSize bytes writers
+// allocate after their data when Sealing reports true. Set before the
+// engine is in use.
+func (e *SimpleEngine) SetSealing(enabled bool) {
+	e.sealing = enabled
+}
+
+// Sealing implements Sealer
+func (e *SimpleEngine) Sealing() bool {
+	return e.sealing
+}
+
+// Seal implements Sealer
+func (e *SimpleEngine) Seal(ctx context.Context, offset, size int64, sum uint32) (bool, error) {
+	if !e.sealing || size == 0 {
+		return false, nil
+	}
+	if err := e.Write(ctx, offset+size, sealRecord{length: size, sum: sum}.encode()); err != nil {
+		return false, err
+	}
+	return true, e.Flush(ctx, offset+size, SealSize)
+}
+
+// CheckSeal implements Sealer
+func (e *SimpleEngine) CheckSeal(ctx context.Context, offset, size int64, verifyData bool) error {
+	record, ok, err := e.readSeal(ctx, offset, size)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: no seal after extent %d+%d", ErrTornWrite, offset, size)
+	}
+	if !verifyData {
+		return nil
+	}
+
+	h := NewSealHash()
+	for done := int64(0); done < size; {
+		n := min(size-done, ioChunkSize)
+		data, err := e.Read(ctx, offset+done, n)
+		if err != nil {
+			return err
+		}
+		h.Write(data)
+		done += n
+	}
+	if h.Sum32() != record.sum {
+		return fmt.Errorf("%w: data of extent %d+%d does not match its seal", ErrTornWrite, offset, size)
+	}
+	return nil
+}
+
+// readSeal reads the seal record after an extent, reporting false unless
+// there is one for an extent of its size
+func (e *SimpleEngine) readSeal(ctx context.Context, offset, size int64) (sealRecord, bool, error) {
+	buf, err := e.Read(ctx, offset+size, SealSize)
+	if err != nil {
+		return sealRecord{}, false, err
+	}
+	record, ok := decodeSeal(buf)
+	return record, ok && record.length == size, nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+// openSealingEngine opens an engine on the 16MB device at path, with 1MB
+// slabs and sealing on
+func openSealingEngine(t *testing.T, path string) *SimpleEngine {
+	t.Helper()
+	engine, err := NewSimpleEngine(path, 16*1024*1024, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { engine.Close() })
+	engine.SetSealing(true)
+	return engine
+}
+
+func sealingDevice(t *testing.T) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "comio-seal-*.data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Truncate(16 * 1024 * 1024)
+	f.Close()
+	return f.Name()
+}
+
+// writeSealed stores data in a new extent, with room for its seal, and
+// seals it
+func writeSealed(t *testing.T, engine *SimpleEngine, data []byte) int64 {
+	t.Helper()
+	ctx := context.Background()
+	offset, err := engine.Allocate(int64(len(data)) + SealSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Write(ctx, offset, data); err != nil {
+		t.Fatal(err)
+	}
+	h := NewSealHash()
+	h.Write(data)
+	if sealed, err := engine.Seal(ctx, offset, int64(len(data)), h.Sum32()); err != nil || !sealed {
+		t.Fatalf("Seal() = %v, %v", sealed, err)
+	}
+	return offset
+}
+
+func TestSeal_TornWrites(t *testing.T) {
+	engine := openSealingEngine(t, sealingDevice(t))
+	ctx := context.Background()
+
+	first := writeSealed(t, engine, bytes.Repeat([]byte{1}, 1000))
+	second := writeSealed(t, engine, bytes.Repeat([]byte{2}, 1000))
+	// Extents leave room for their seal
+	if second != first+1000+SealSize {
+		t.Errorf("second extent at %d, want %d", second, first+1000+SealSize)
+	}
+	if err := engine.CheckSeal(ctx, first, 1000, true); err != nil {
+		t.Errorf("CheckSeal() of a complete write error = %v", err)
+	}
+
+	// Data that did not reach the device is caught by the data check only
+	engine.Write(ctx, first+500, []byte{0})
+	if err := engine.CheckSeal(ctx, first, 1000, false); err != nil {
+		t.Errorf("CheckSeal() without the data check error = %v", err)
+	}
+	if err := engine.CheckSeal(ctx, first, 1000, true); !errors.Is(err, ErrTornWrite) {
+		t.Errorf("CheckSeal() of torn data error = %v, want ErrTornWrite", err)
+	}
+
+	// A seal that did not reach the device, a damaged one, or one of
+	// another length is no seal
+	third, _ := engine.Allocate(1000)
+	engine.Write(ctx, third, bytes.Repeat([]byte{3}, 1000))
+	if err := engine.CheckSeal(ctx, third, 1000, false); !errors.Is(err, ErrTornWrite) {
+		t.Errorf("CheckSeal() of an unsealed extent error = %v, want ErrTornWrite", err)
+	}
+	engine.Write(ctx, second+1000+4, []byte{0xff})
+	if err := engine.CheckSeal(ctx, second, 1000, false); !errors.Is(err, ErrTornWrite) {
+		t.Errorf("CheckSeal() with a damaged seal error = %v, want ErrTornWrite", err)
+	}
+	if err := engine.CheckSeal(ctx, first, 999, false); !errors.Is(err, ErrTornWrite) {
+		t.Errorf("CheckSeal() of another length error = %v, want ErrTornWrite", err)
+	}
+}
+
+func TestSeal_Restore(t *testing.T) {
+	path := sealingDevice(t)
+	engine := openSealingEngine(t, path)
+	ctx := context.Background()
+
+	sealed := writeSealed(t, engine, bytes.Repeat([]byte{1}, 1000))
+	unsealed, _ := engine.Allocate(1000)
+	engine.Write(ctx, unsealed, bytes.Repeat([]byte{2}, 1000))
+	engine.Sync()
+
+	// After a restart sealed extents are restored with their seal, so new
+	// extents do not land on it
+	restarted := openSealingEngine(t, path)
+	restarted.BeginRestore()
+	if err := restarted.MarkAllocated(sealed, 1000+SealSize); err != nil {
+		t.Fatal(err)
+	}
+	restarted.EndRestore()
+	offset, err := restarted.Allocate(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < sealed+1000+SealSize {
+		t.Errorf("Allocate() = %d, over the seal ending at %d", offset, sealed+1000+SealSize)
+	}
+
+	// Extents free with the size they were allocated with, seal included
+	if err := restarted.Free(sealed, 1000); err == nil {
+		t.Error("Free() of a sealed extent without its seal succeeded")
+	}
+	if err := restarted.Free(sealed, 1000+SealSize); err != nil {
+		t.Errorf("Free() of a sealed extent error = %v", err)
+	}
+}
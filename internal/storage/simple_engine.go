@@ -1,12 +1,17 @@
 package storage
 
 import (
-	"sync"
+	"context"
+	"sync/atomic"
 )
 
 const (
 	// DefaultBlockSize is the default block size for storage allocation (4MB for performance)
 	DefaultBlockSize = 4 * 1024 * 1024 // 4MB
+
+	// ioChunkSize bounds the device I/O done between cancellation checks,
+	// and in one turn of the I/O scheduler
+	ioChunkSize = 1024 * 1024 // 1MB
 )
 
 // SimpleEngine implements Engine using slab allocation
@@ -15,7 +20,12 @@ type SimpleEngine struct {
 	allocator *SlabAllocator
 	blockMgr  *BlockManager
 	slabSize  int64
-	mu        sync.RWMutex // Protects concurrent access to device operations
+	health    *healthTracker
+	capacity  *capacityTracker
+	restoring atomic.Bool // Allocations are refused while set, see Restorer
+	io        ioScheduler // Locks the ranges device operations touch
+	coalescer *coalescer  // Buffers small writes, nil unless enabled
+	sealing   bool        // Extents are allocated with room for a seal record
 }
 
 // NewSimpleEngine creates a new simple engine with slab allocation
@@ -29,48 +39,178 @@ func NewSimpleEngine(devicePath string, size int64, slabSize int) (*SimpleEngine
 		allocator: allocator,
 		blockMgr:  blockMgr,
 		slabSize:  int64(slabSize),
+		health:    newHealthTracker(devicePath),
+		capacity:  newCapacityTracker(),
 	}, nil
 }
 
 func (e *SimpleEngine) Open(devicePath string) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	turn, _ := e.io.acquire(context.Background(), 0, wholeDevice, true)
+	defer e.io.release(turn)
 	return e.device.Open()
 }
 
 func (e *SimpleEngine) Close() error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	return e.device.Close()
+	var flushErr error
+	if e.coalescer != nil {
+		flushErr = e.coalescer.flushAll()
+	}
+	turn, _ := e.io.acquire(context.Background(), 0, wholeDevice, true)
+	defer e.io.release(turn)
+	if err := e.device.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// SetWriteCoalescing buffers writes of up to cfg.MaxWrite bytes, flushing
+// those to a slab together. A zero window or size turns buffering off.
+// Set before the engine is in use.
+func (e *SimpleEngine) SetWriteCoalescing(cfg Coalescing) {
+	if cfg.Window <= 0 || cfg.MaxBytes <= 0 || cfg.MaxWrite <= 0 {
+		e.coalescer = nil
+		return
+	}
+	e.coalescer = newCoalescer(cfg, e.slabSize, e.writeDevice)
 }
 
-func (e *SimpleEngine) Read(offset, size int64) ([]byte, error) {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return e.device.Read(offset, size)
+// Flush implements WriteFlusher
+func (e *SimpleEngine) Flush(ctx context.Context, offset, size int64) error {
+	if e.coalescer == nil || size == 0 {
+		return nil
+	}
+	return e.coalescer.wait(ctx, offset, size)
 }
 
-func (e *SimpleEngine) Write(offset int64, data []byte) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	return e.device.Write(offset, data)
+// Read reads size bytes at offset. Large reads take the device a chunk at
+// a time, interleaved with other streams.
+func (e *SimpleEngine) Read(ctx context.Context, offset, size int64) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+	// Buffered writes to the range are read back from the device
+	if e.coalescer != nil {
+		e.coalescer.flushRange(offset, size)
+	}
+
+	if size <= ioChunkSize {
+		turn, err := e.io.acquire(ctx, offset, size, false)
+		if err != nil {
+			return nil, err
+		}
+		defer e.io.release(turn)
+		data, err := e.device.Read(offset, size)
+		e.health.record(false, err)
+		return data, err
+	}
+
+	data := make([]byte, 0, size)
+	for done := int64(0); done < size; {
+		// Cancellation is not a device error and is not recorded
+		n := min(size-done, ioChunkSize)
+		turn, err := e.io.acquire(ctx, offset+done, n, false)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := e.device.Read(offset+done, n)
+		e.io.release(turn)
+		e.health.record(false, err)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		done += n
+	}
+	return data, nil
+}
+
+// Write writes data at offset a chunk at a time, interleaved with other
+// streams. Reads of the range may see part of the data until it returns,
+// which callers avoid by writing to extents no object points to yet. With
+// write coalescing small writes are buffered; see Flush.
+func (e *SimpleEngine) Write(ctx context.Context, offset int64, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.coalescer != nil {
+		if e.coalescer.add(offset, data) {
+			return nil
+		}
+		// Buffered writes to the range must not land over this one
+		e.coalescer.flushRange(offset, int64(len(data)))
+	}
+	return e.writeDevice(ctx, offset, data)
+}
+
+// writeDevice writes data to the device a chunk at a time
+func (e *SimpleEngine) writeDevice(ctx context.Context, offset int64, data []byte) error {
+	for done := 0; done < len(data); {
+		n := min(len(data)-done, ioChunkSize)
+		turn, err := e.io.acquire(ctx, offset+int64(done), int64(n), true)
+		if err != nil {
+			return err
+		}
+		err = e.device.Write(offset+int64(done), data[done:done+n])
+		e.io.release(turn)
+		e.health.record(true, err)
+		if err != nil {
+			return err
+		}
+		done += n
+	}
+	return nil
 }
 
 func (e *SimpleEngine) Allocate(size int64) (int64, error) {
+	// Empty objects and directory markers need no extent
+	if size == 0 {
+		return 0, nil
+	}
+	// Space handed out before the restart is not known yet
+	if e.restoring.Load() {
+		return 0, ErrRestoring
+	}
+	// New data goes nowhere once the device has failed
+	if !e.health.healthy() {
+		return 0, ErrDeviceUnhealthy
+	}
+	// Stop short of a full device, where the allocator would wedge
+	if e.capacity.update(e.allocator.Stats()).Level == CapacityCritical {
+		return 0, ErrInsufficientStorage
+	}
 	// SlabAllocator has its own internal mutex for thread safety.
 	// Allocation is independent of device I/O operations, so no engine lock needed.
-	return e.allocator.Allocate(size)
+	offset, err := e.allocator.Allocate(size)
+	if err == nil {
+		e.capacity.update(e.allocator.Stats())
+	}
+	return offset, err
 }
 
 func (e *SimpleEngine) Free(offset, size int64) error {
+	if size == 0 {
+		return nil
+	}
 	// SlabAllocator has its own internal mutex for thread safety.
 	// Freeing is independent of device I/O operations, so no engine lock needed.
-	return e.allocator.Free(offset, size)
+	if err := e.allocator.Free(offset, size); err != nil {
+		return err
+	}
+	e.capacity.update(e.allocator.Stats())
+	return nil
 }
 
 func (e *SimpleEngine) Sync() error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	if e.coalescer != nil {
+		if err := e.coalescer.flushAll(); err != nil {
+			return err
+		}
+	}
+	turn, _ := e.io.acquire(context.Background(), 0, wholeDevice, true)
+	defer e.io.release(turn)
 	return e.device.Sync()
 }
 
@@ -82,3 +222,38 @@ func (e *SimpleEngine) Stats() Stats {
 func (e *SimpleEngine) BlockSize() int {
 	return int(e.slabSize)
 }
+
+// SetWatermarks sets the usage at which the engine warns and at which it
+// refuses allocations. onChange, which may be nil, is called when usage
+// crosses a watermark in either direction.
+func (e *SimpleEngine) SetWatermarks(w Watermarks, onChange CapacityChangeFunc) {
+	e.capacity.set(w, onChange)
+	e.capacity.update(e.allocator.Stats())
+}
+
+// Capacity returns the usage against the watermarks
+func (e *SimpleEngine) Capacity() CapacityStatus {
+	return e.capacity.update(e.allocator.Stats())
+}
+
+// Fragmentation reports the space freed objects leave in the slabs
+func (e *SimpleEngine) Fragmentation() FragmentationReport {
+	return e.allocator.Fragmentation()
+}
+
+// DrainSlabs implements SlabDrainer
+func (e *SimpleEngine) DrainSlabs(offsets []int64) func() {
+	e.allocator.Drain(offsets)
+	return func() { e.allocator.Undrain(offsets) }
+}
+
+// SetErrorThreshold sets how many consecutive I/O errors mark the device
+// unhealthy
+func (e *SimpleEngine) SetErrorThreshold(n int) {
+	e.health.setThreshold(n)
+}
+
+// DeviceHealth implements HealthReporter
+func (e *SimpleEngine) DeviceHealth() []DeviceHealth {
+	return []DeviceHealth{e.health.snapshot()}
+}
@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
 	"testing"
 )
@@ -44,6 +47,39 @@ func TestSimpleEngine_AllocateFree(t *testing.T) {
 	}
 }
 
+func TestSimpleEngine_EmptyExtent(t *testing.T) {
+	f, err := os.CreateTemp("", "engine_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	engine, err := NewSimpleEngine(f.Name(), 64*1024*1024, 4*1024*1024)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+
+	before := engine.Stats()
+	offset, err := engine.Allocate(0)
+	if err != nil || offset != 0 {
+		t.Fatalf("Allocate(0) = %d, %v, want 0, nil", offset, err)
+	}
+	if after := engine.Stats(); after.UsedBytes != before.UsedBytes {
+		t.Errorf("Allocate(0) used %d bytes", after.UsedBytes-before.UsedBytes)
+	}
+	if data, err := engine.Read(context.Background(), offset, 0); err != nil || len(data) != 0 {
+		t.Errorf("Read of 0 bytes = %q, %v", data, err)
+	}
+	if err := engine.Free(offset, 0); err != nil {
+		t.Errorf("Free of 0 bytes error = %v", err)
+	}
+}
+
 func TestSimpleEngine_ReadWrite(t *testing.T) {
 	f, err := os.CreateTemp("", "engine_test_*.dat")
 	if err != nil {
@@ -71,12 +107,12 @@ func TestSimpleEngine_ReadWrite(t *testing.T) {
 
 	// Write
 	data := []byte("test data")
-	if err := engine.Write(offset, data); err != nil {
+	if err := engine.Write(context.Background(), offset, data); err != nil {
 		t.Errorf("Write() error = %v", err)
 	}
 
 	// Read
-	read, err := engine.Read(offset, int64(len(data)))
+	read, err := engine.Read(context.Background(), offset, int64(len(data)))
 	if err != nil {
 		t.Errorf("Read() error = %v", err)
 	}
@@ -160,7 +196,7 @@ func TestSimpleEngine_Sync(t *testing.T) {
 	}
 
 	data := []byte("test data")
-	if err := engine.Write(offset, data); err != nil {
+	if err := engine.Write(context.Background(), offset, data); err != nil {
 		t.Fatalf("Failed to write: %v", err)
 	}
 
@@ -169,3 +205,52 @@ func TestSimpleEngine_Sync(t *testing.T) {
 		t.Errorf("Sync() error = %v", err)
 	}
 }
+
+func TestSimpleEngine_CanceledContext(t *testing.T) {
+	f, err := os.CreateTemp("", "engine_test_*.dat")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	engine, err := NewSimpleEngine(f.Name(), 16*1024*1024, 4*1024*1024)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+	if err := engine.Open(f.Name()); err != nil {
+		t.Fatalf("Failed to open engine: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := engine.Write(ctx, 0, make([]byte, 3*ioChunkSize)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Write() error = %v, want context.Canceled", err)
+	}
+	if _, err := engine.Read(ctx, 0, 3*ioChunkSize); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read() error = %v, want context.Canceled", err)
+	}
+
+	// Canceled I/O does not count against the device
+	if status := engine.DeviceHealth()[0]; status.ReadErrors != 0 || status.WriteErrors != 0 {
+		t.Errorf("device errors = %d read, %d write, want none", status.ReadErrors, status.WriteErrors)
+	}
+
+	// Reads spanning several chunks return the data in order
+	data := make([]byte, 2*ioChunkSize+10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := engine.Write(context.Background(), 0, data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	read, err := engine.Read(context.Background(), 0, int64(len(data)))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Error("chunked read does not match the written data")
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -13,6 +14,7 @@ type SlabAllocator struct {
 	slabs      map[int64]*Slab // Key: slab offset
 	usedBytes  int64
 	nextOffset int64
+	draining   map[int64]int // Slabs being emptied by compaction, which take no new data
 	mu         sync.Mutex
 }
 
@@ -21,6 +23,7 @@ type Slab struct {
 	offset    int64
 	size      int64
 	used      int64
+	end       int64 // Past the last fragment, relative to offset; small objects are appended there
 	fragments []Fragment
 }
 
@@ -37,6 +40,7 @@ func NewSlabAllocator(totalSize int64, slabSize int64) *SlabAllocator {
 		totalSize:  totalSize,
 		slabs:      make(map[int64]*Slab),
 		nextOffset: 0,
+		draining:   make(map[int64]int),
 	}
 }
 
@@ -65,6 +69,7 @@ func (a *SlabAllocator) Allocate(size int64) (int64, error) {
 			offset:    offset,
 			size:      totalSize,
 			used:      size,
+			end:       size,
 			fragments: []Fragment{{offset: offset, size: size}},
 		}
 		a.nextOffset += totalSize
@@ -82,14 +87,15 @@ func (a *SlabAllocator) Allocate(size int64) (int64, error) {
 	for _, off := range slabOffsets {
 		slab := a.slabs[off]
 		// Only pack into slabs that were created for small objects (size == slabSize)
-		if slab.size == a.slabSize && slab.used+size <= slab.size {
+		if slab.size == a.slabSize && slab.end+size <= slab.size && a.draining[off] == 0 {
 			// Found space in existing slab
-			fragmentOffset := slab.offset + slab.used
+			fragmentOffset := slab.offset + slab.end
 			slab.fragments = append(slab.fragments, Fragment{
 				offset: fragmentOffset,
 				size:   size,
 			})
 			slab.used += size
+			slab.end += size
 			a.usedBytes += size
 			return fragmentOffset, nil
 		}
@@ -105,6 +111,7 @@ func (a *SlabAllocator) Allocate(size int64) (int64, error) {
 		offset:    offset,
 		size:      a.slabSize,
 		used:      size,
+		end:       size,
 		fragments: []Fragment{{offset: offset, size: size}},
 	}
 	a.slabs[offset] = slab
@@ -137,6 +144,7 @@ func (a *SlabAllocator) Free(offset, size int64) error {
 		if frag.offset == offset && frag.size == size {
 			targetSlab.fragments = append(targetSlab.fragments[:i], targetSlab.fragments[i+1:]...)
 			targetSlab.used -= size
+			targetSlab.end = fragmentsEnd(targetSlab)
 			a.usedBytes -= size
 
 			// Keep empty slabs so they can be reused for small objects
@@ -149,6 +157,109 @@ func (a *SlabAllocator) Free(offset, size int64) error {
 	return errors.New("fragment not found")
 }
 
+// Drain stops packing new data into the slabs at offsets, so that moving
+// their objects elsewhere empties them. Drains of a slab nest; Undrain ends
+// one.
+func (a *SlabAllocator) Drain(offsets []int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, off := range offsets {
+		a.draining[off]++
+	}
+}
+
+// Undrain ends a Drain of the slabs at offsets
+func (a *SlabAllocator) Undrain(offsets []int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, off := range offsets {
+		if a.draining[off] <= 1 {
+			delete(a.draining, off)
+		} else {
+			a.draining[off]--
+		}
+	}
+}
+
+// fragmentsEnd returns the end of the last fragment of a slab, relative
+// to its offset. Holes before it are only reused once the slab empties.
+func fragmentsEnd(slab *Slab) int64 {
+	var end int64
+	for _, f := range slab.fragments {
+		end = max(end, f.offset+f.size-slab.offset)
+	}
+	return end
+}
+
+// slabAt returns the slab holding offset, if any
+func (a *SlabAllocator) slabAt(offset int64) *Slab {
+	if slab, ok := a.slabs[offset]; ok {
+		return slab
+	}
+	for _, slab := range a.slabs {
+		if offset >= slab.offset && offset < slab.offset+slab.size {
+			return slab
+		}
+	}
+	return nil
+}
+
+// MarkAllocated registers space allocated before a restart, so it is not
+// handed out again. Objects smaller than a slab are placed in the slab
+// holding offset, larger ones get the dedicated slabs Allocate would have
+// given them.
+func (a *SlabAllocator) MarkAllocated(offset, size int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if size <= 0 || offset < 0 || offset+size > a.totalSize {
+		return fmt.Errorf("invalid extent %d+%d", offset, size)
+	}
+
+	if size >= a.slabSize {
+		if offset%a.slabSize != 0 {
+			return fmt.Errorf("extent %d+%d is not slab aligned", offset, size)
+		}
+		totalSize := roundUp(size, a.slabSize)
+		for off := offset; off < offset+totalSize; off += a.slabSize {
+			if a.slabAt(off) != nil {
+				return fmt.Errorf("extent %d+%d overlaps allocated space", offset, size)
+			}
+		}
+		a.slabs[offset] = &Slab{
+			offset:    offset,
+			size:      totalSize,
+			used:      size,
+			end:       size,
+			fragments: []Fragment{{offset: offset, size: size}},
+		}
+		a.nextOffset = max(a.nextOffset, offset+totalSize)
+		a.usedBytes += size
+		return nil
+	}
+
+	slabOffset := offset - offset%a.slabSize
+	slab := a.slabAt(slabOffset)
+	if slab == nil {
+		slab = &Slab{offset: slabOffset, size: a.slabSize}
+		a.slabs[slabOffset] = slab
+	}
+	if slab.size != a.slabSize || offset+size > slab.offset+slab.size {
+		return fmt.Errorf("extent %d+%d overlaps allocated space", offset, size)
+	}
+	for _, f := range slab.fragments {
+		if offset < f.offset+f.size && f.offset < offset+size {
+			return fmt.Errorf("extent %d+%d overlaps allocated space", offset, size)
+		}
+	}
+	slab.fragments = append(slab.fragments, Fragment{offset: offset, size: size})
+	slab.used += size
+	slab.end = max(slab.end, offset+size-slab.offset)
+	a.nextOffset = max(a.nextOffset, slabOffset+a.slabSize)
+	a.usedBytes += size
+	return nil
+}
+
 // Stats returns allocation statistics
 func (a *SlabAllocator) Stats() Stats {
 	a.mu.Lock()
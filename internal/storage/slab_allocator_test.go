@@ -239,3 +239,79 @@ func TestSlabAllocator_EdgeCases(t *testing.T) {
 		t.Error("Allocate(-1) expected error, got nil")
 	}
 }
+
+func TestSlabAllocator_AllocateAfterFree(t *testing.T) {
+	a := NewSlabAllocator(1000, 100)
+
+	first, _ := a.Allocate(30)
+	second, _ := a.Allocate(30)
+	if err := a.Free(first, 30); err != nil {
+		t.Fatal(err)
+	}
+
+	// New data goes after the last object, not over it
+	third, err := a.Allocate(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third < second+30 && second < third+30 {
+		t.Errorf("Allocate() = %d overlaps the object at %d", third, second)
+	}
+}
+
+func TestSlabAllocator_Drain(t *testing.T) {
+	a := NewSlabAllocator(1000, 100)
+
+	first, _ := a.Allocate(30)
+	a.Drain([]int64{0})
+
+	// A drained slab takes no new data, however much room it has left
+	second, err := a.Allocate(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second < 100 {
+		t.Errorf("Allocate() = %d, want outside the drained slab", second)
+	}
+	if err := a.Free(first, 30); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once undrained, the emptied slab is filled from its start
+	a.Undrain([]int64{0})
+	if third, err := a.Allocate(80); err != nil || third != 0 {
+		t.Errorf("Allocate(80) = %d, %v; want the emptied slab at 0", third, err)
+	}
+}
+
+func TestSlabAllocator_MarkAllocated(t *testing.T) {
+	a := NewSlabAllocator(1000, 100)
+
+	// Extents found in the metadata after a restart
+	for _, e := range [][2]int64{{0, 30}, {60, 20}, {200, 150}, {400, 10}} {
+		if err := a.MarkAllocated(e[0], e[1]); err != nil {
+			t.Fatalf("MarkAllocated(%d, %d) error = %v", e[0], e[1], err)
+		}
+	}
+	if stats := a.Stats(); stats.UsedBytes != 210 || stats.FreeBytes != 500 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	for _, e := range [][2]int64{{10, 10}, {250, 10}, {300, 100}, {990, 20}, {50, 0}} {
+		if err := a.MarkAllocated(e[0], e[1]); err == nil {
+			t.Errorf("MarkAllocated(%d, %d) should fail", e[0], e[1])
+		}
+	}
+
+	// Small objects are appended after the restored ones
+	offset, err := a.Allocate(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 80 && offset != 410 {
+		t.Errorf("Allocate() = %d, want after a restored object", offset)
+	}
+	if offset, err := a.Allocate(100); err != nil || offset != 500 {
+		t.Errorf("Allocate(100) = %d, %v; want a new slab at 500", offset, err)
+	}
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStatsRetention is how long stats samples are kept by default
+const DefaultStatsRetention = 30 * 24 * time.Hour
+
+// statsDayLayout names the file holding one UTC day of samples
+const statsDayLayout = "2006-01-02"
+
+// StatsSample is a point-in-time record of the engine's space usage
+type StatsSample struct {
+	Timestamp  time.Time `json:"timestamp"`
+	TotalBytes int64     `json:"total_bytes"`
+	UsedBytes  int64     `json:"used_bytes"`
+	FreeBytes  int64     `json:"free_bytes"`
+	// Fragmentation is the share of allocated space not holding live
+	// data, 0-1. Freed space behind the slab allocator's high-water mark
+	// cannot be reused until compaction.
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// NewStatsSample records stats as taken at the given time
+func NewStatsSample(stats Stats, at time.Time) StatsSample {
+	sample := StatsSample{
+		Timestamp:  at.UTC(),
+		TotalBytes: stats.TotalBytes,
+		UsedBytes:  stats.UsedBytes,
+		FreeBytes:  stats.FreeBytes,
+	}
+	if allocated := stats.TotalBytes - stats.FreeBytes; allocated > 0 && allocated > stats.UsedBytes {
+		sample.Fragmentation = float64(allocated-stats.UsedBytes) / float64(allocated)
+	}
+	return sample
+}
+
+// StatsHistory persists stats samples as one JSON line per sample, in one
+// file per UTC day under <metadataDir>/stats. Days older than the
+// retention are removed as new samples are appended.
+type StatsHistory struct {
+	dir       string
+	retention time.Duration
+	mu        sync.Mutex // Serializes appends and pruning
+}
+
+// NewStatsHistory creates a stats history keeping samples for retention
+func NewStatsHistory(metadataDir string, retention time.Duration) (*StatsHistory, error) {
+	dir := filepath.Join(metadataDir, "stats")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create stats directory: %w", err)
+	}
+
+	if retention <= 0 {
+		retention = DefaultStatsRetention
+	}
+
+	return &StatsHistory{
+		dir:       dir,
+		retention: retention,
+	}, nil
+}
+
+// Retention returns how long samples are kept
+func (h *StatsHistory) Retention() time.Duration {
+	return h.retention
+}
+
+func (h *StatsHistory) path(day time.Time) string {
+	return filepath.Join(h.dir, day.UTC().Format(statsDayLayout)+".jsonl")
+}
+
+// Append records a sample and removes days past the retention
+func (h *StatsHistory) Append(sample StatsSample) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stats sample: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	f, err := os.OpenFile(h.path(sample.Timestamp), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open stats file: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write stats sample: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close stats file: %w", err)
+	}
+
+	return h.prune(sample.Timestamp.Add(-h.retention))
+}
+
+// prune removes the files of days entirely before cutoff
+func (h *StatsHistory) prune(cutoff time.Time) error {
+	days, err := h.days()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if !day.AddDate(0, 0, 1).Before(cutoff) {
+			break
+		}
+		if err := os.Remove(h.path(day)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stats file: %w", err)
+		}
+	}
+	return nil
+}
+
+// days returns the days that have a samples file, oldest first
+func (h *StatsHistory) days() ([]time.Time, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats directory: %w", err)
+	}
+
+	var days []time.Time
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		day, err := time.Parse(statsDayLayout, name)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// Since returns the samples taken at or after since, oldest first.
+// Lines that cannot be parsed, such as one torn by a crash, are skipped.
+func (h *StatsHistory) Since(since time.Time) ([]StatsSample, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	days, err := h.days()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []StatsSample{}
+	for _, day := range days {
+		if day.AddDate(0, 0, 1).Before(since) {
+			continue
+		}
+		data, err := os.ReadFile(h.path(day))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read stats file: %w", err)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var sample StatsSample
+			if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+				continue
+			}
+			if !sample.Timestamp.Before(since) {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples, nil
+}
+
+// StatsRecorder periodically appends the engine's stats to a StatsHistory
+type StatsRecorder struct {
+	engine   Engine
+	history  *StatsHistory
+	interval time.Duration
+	now      func() time.Time
+	onError  func(error)
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewStatsRecorder creates a recorder sampling engine every interval
+func NewStatsRecorder(engine Engine, history *StatsHistory, interval time.Duration) *StatsRecorder {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &StatsRecorder{
+		engine:   engine,
+		history:  history,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// OnError sets a callback for samples that could not be recorded
+func (r *StatsRecorder) OnError(fn func(error)) {
+	r.onError = fn
+}
+
+// Record appends one sample of the current stats
+func (r *StatsRecorder) Record() error {
+	return r.history.Append(NewStatsSample(r.engine.Stats(), r.now()))
+}
+
+// Start records a sample now and then every interval
+func (r *StatsRecorder) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := r.Record(); err != nil && r.onError != nil {
+				r.onError(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops recording
+func (r *StatsRecorder) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewStatsSample_Fragmentation(t *testing.T) {
+	// 600 bytes allocated below the high-water mark, 450 of them live
+	sample := NewStatsSample(Stats{TotalBytes: 1000, UsedBytes: 450, FreeBytes: 400}, time.Now())
+	if sample.Fragmentation != 0.25 {
+		t.Errorf("Fragmentation = %v, want 0.25", sample.Fragmentation)
+	}
+
+	sample = NewStatsSample(Stats{TotalBytes: 1000, UsedBytes: 0, FreeBytes: 1000}, time.Now())
+	if sample.Fragmentation != 0 {
+		t.Errorf("Fragmentation of empty device = %v, want 0", sample.Fragmentation)
+	}
+}
+
+func TestStatsHistory_SinceAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	history, err := NewStatsHistory(dir, 48*time.Hour)
+	if err != nil {
+		t.Fatalf("NewStatsHistory() error = %v", err)
+	}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for day := 0; day < 5; day++ {
+		at := start.AddDate(0, 0, day)
+		if err := history.Append(NewStatsSample(Stats{TotalBytes: 100, UsedBytes: int64(day)}, at)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// Days entirely before the retention are gone
+	if _, err := os.Stat(filepath.Join(dir, "stats", "2026-03-02.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expired day still present: %v", err)
+	}
+
+	samples, err := history.Since(start.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatalf("Since() error = %v", err)
+	}
+	if len(samples) != 2 || samples[0].UsedBytes != 3 || samples[1].UsedBytes != 4 {
+		t.Errorf("samples = %+v, want used 3 and 4", samples)
+	}
+
+	// A torn trailing line is skipped
+	f, err := os.OpenFile(filepath.Join(dir, "stats", "2026-03-05.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"timestamp":"2026-03-0`)
+	f.Close()
+
+	samples, err = history.Since(start.AddDate(0, 0, 4))
+	if err != nil {
+		t.Fatalf("Since() error = %v", err)
+	}
+	if len(samples) != 1 {
+		t.Errorf("len(samples) = %d, want 1", len(samples))
+	}
+}
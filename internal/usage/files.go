@@ -0,0 +1,123 @@
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// dayLayout names the file holding one UTC day of lines
+const dayLayout = "2006-01-02"
+
+// dayFiles is a directory of JSON lines, one file per UTC day
+type dayFiles struct {
+	dir string
+}
+
+func newDayFiles(dir string) (dayFiles, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return dayFiles{}, fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	return dayFiles{dir: dir}, nil
+}
+
+func (f dayFiles) path(day time.Time) string {
+	return filepath.Join(f.dir, day.UTC().Format(dayLayout)+".jsonl")
+}
+
+// append writes values as lines of the file of day
+func (f dayFiles) append(day time.Time, values ...any) error {
+	var buf bytes.Buffer
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to marshal usage record: %w", err)
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	file, err := os.OpenFile(f.path(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage file: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write usage records: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close usage file: %w", err)
+	}
+	return nil
+}
+
+// prune removes the files of days entirely before cutoff
+func (f dayFiles) prune(cutoff time.Time) error {
+	days, err := f.days()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if !day.AddDate(0, 0, 1).Before(cutoff) {
+			break
+		}
+		if err := os.Remove(f.path(day)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove usage file: %w", err)
+		}
+	}
+	return nil
+}
+
+// days returns the days that have a file, oldest first
+func (f dayFiles) days() ([]time.Time, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage directory: %w", err)
+	}
+
+	var days []time.Time
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		day, err := time.Parse(dayLayout, name)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// read passes the lines of the days overlapping [from, to) to fn, oldest
+// first
+func (f dayFiles) read(from, to time.Time, fn func(line []byte)) error {
+	days, err := f.days()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		if day.AddDate(0, 0, 1).Before(from) || !day.Before(to) {
+			continue
+		}
+		data, err := os.ReadFile(f.path(day))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read usage file: %w", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fn(scanner.Bytes())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// StorageSample is what a bucket held in one hour
+type StorageSample struct {
+	Hour    time.Time `json:"hour"`
+	Bucket  string    `json:"bucket"`
+	Owner   string    `json:"owner,omitempty"`
+	Objects int64     `json:"objects"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// StorageSource returns what each bucket holds now. Hour is set by the
+// tracker.
+type StorageSource func(ctx context.Context) ([]StorageSample, error)
+
+// SetStorageSource sets where the tracker samples storage from. It must be
+// called before Start.
+func (t *Tracker) SetStorageSource(source StorageSource) {
+	t.source = source
+}
+
+// SampleStorage records what each bucket holds, unless it was already
+// recorded in the current hour
+func (t *Tracker) SampleStorage(ctx context.Context) error {
+	if t.source == nil {
+		return nil
+	}
+	hour := t.now().UTC().Truncate(time.Hour)
+	t.mu.Lock()
+	done := t.sampled.Equal(hour)
+	t.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	samples, err := t.source(ctx)
+	if err != nil {
+		return err
+	}
+	lines := make([]any, len(samples))
+	for i := range samples {
+		samples[i].Hour = hour
+		lines[i] = samples[i]
+	}
+
+	t.fileMu.Lock()
+	defer t.fileMu.Unlock()
+	if len(lines) > 0 {
+		if err := t.storage.append(hour, lines...); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	t.sampled = hour
+	t.mu.Unlock()
+	return t.storage.prune(t.now().Add(-t.retention))
+}
+
+// QueryStorage returns the storage samples of the hours starting in
+// [from, to), one per bucket and hour, ordered by hour and bucket. An hour
+// sampled twice, such as across a restart, keeps its last sample.
+func (t *Tracker) QueryStorage(from, to time.Time) ([]StorageSample, error) {
+	type sampleKey struct {
+		hour   time.Time
+		bucket string
+	}
+	latest := make(map[sampleKey]StorageSample)
+
+	t.fileMu.Lock()
+	err := t.storage.read(from, to, func(line []byte) {
+		var s StorageSample
+		if err := json.Unmarshal(line, &s); err != nil {
+			return
+		}
+		if s.Hour.Before(from) || !s.Hour.Before(to) {
+			return
+		}
+		s.Hour = s.Hour.UTC()
+		latest[sampleKey{hour: s.Hour, bucket: s.Bucket}] = s
+	})
+	t.fileMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]StorageSample, 0, len(latest))
+	for _, s := range latest {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		return a.Bucket < b.Bucket
+	})
+	return samples, nil
+}
@@ -0,0 +1,286 @@
+// Package usage counts the requests and traffic of each access key, per
+// bucket and hour, and samples what each bucket stores, so load and cost
+// can be attributed to the teams holding the keys.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRetention is how long hourly counters are kept by default
+const DefaultRetention = 90 * 24 * time.Hour
+
+// Counters are the requests and traffic of an access key
+type Counters struct {
+	Requests     int64 `json:"requests"`
+	ClientErrors int64 `json:"client_errors"` // Requests answered with 4xx
+	ServerErrors int64 `json:"server_errors"` // Requests answered with 5xx
+	BytesIn      int64 `json:"bytes_in"`      // Request bodies read
+	BytesOut     int64 `json:"bytes_out"`     // Response bodies written
+}
+
+// Add adds other to c
+func (c *Counters) Add(other Counters) {
+	c.Requests += other.Requests
+	c.ClientErrors += other.ClientErrors
+	c.ServerErrors += other.ServerErrors
+	c.BytesIn += other.BytesIn
+	c.BytesOut += other.BytesOut
+}
+
+// ErrorRate is the share of requests answered with an error, 0-1
+func (c Counters) ErrorRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.ClientErrors+c.ServerErrors) / float64(c.Requests)
+}
+
+// Record holds the counters of an access key for one bucket in one hour
+type Record struct {
+	Hour      time.Time `json:"hour"`
+	AccessKey string    `json:"access_key"`
+	Bucket    string    `json:"bucket,omitempty"` // Empty for requests outside buckets, such as the admin API
+	Counters
+}
+
+type recordKey struct {
+	hour      time.Time
+	accessKey string
+	bucket    string
+}
+
+// Tracker counts requests in memory and periodically appends the counts
+// to one JSON line per access key, bucket and hour, in one file per UTC
+// day under <metadataDir>/usage. An hour may be spread over several
+// lines, which reads add up; a crash loses at most one flush interval.
+//
+// With a storage source, the tracker also samples what each bucket holds
+// once an hour, under <metadataDir>/usage/storage, for billing storage
+// by the hour.
+type Tracker struct {
+	counters  dayFiles
+	storage   dayFiles
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+	onError   func(error)
+	source    StorageSource
+
+	mu      sync.Mutex
+	pending map[recordKey]*Counters
+	sampled time.Time // Hour of the last storage sample
+
+	fileMu sync.Mutex // Serializes appends, pruning and reads of the files
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewTracker creates a tracker flushing its counts every interval and
+// keeping them for retention
+func NewTracker(metadataDir string, retention, interval time.Duration) (*Tracker, error) {
+	counters, err := newDayFiles(filepath.Join(metadataDir, "usage"))
+	if err != nil {
+		return nil, err
+	}
+	storage, err := newDayFiles(filepath.Join(metadataDir, "usage", "storage"))
+	if err != nil {
+		return nil, err
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &Tracker{
+		counters:  counters,
+		storage:   storage,
+		retention: retention,
+		interval:  interval,
+		now:       time.Now,
+		pending:   make(map[recordKey]*Counters),
+	}, nil
+}
+
+// Retention returns how long counters are kept
+func (t *Tracker) Retention() time.Duration {
+	return t.retention
+}
+
+// OnError sets a callback for counts that could not be flushed
+func (t *Tracker) OnError(fn func(error)) {
+	t.onError = fn
+}
+
+// Record counts a request of accessKey to bucket
+func (t *Tracker) Record(accessKey, bucket string, c Counters) {
+	key := recordKey{hour: t.now().UTC().Truncate(time.Hour), accessKey: accessKey, bucket: bucket}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if counters, ok := t.pending[key]; ok {
+		counters.Add(c)
+		return
+	}
+	t.pending[key] = &c
+}
+
+// Flush appends the counts recorded since the last flush and removes days
+// past the retention. Counts that cannot be written are kept for the
+// next flush.
+func (t *Tracker) Flush() error {
+	t.fileMu.Lock()
+	defer t.fileMu.Unlock()
+
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[recordKey]*Counters)
+	t.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	records := make([]Record, 0, len(pending))
+	for key, counters := range pending {
+		records = append(records, Record{Hour: key.hour, AccessKey: key.accessKey, Bucket: key.bucket, Counters: *counters})
+	}
+	sortRecords(records)
+
+	// Records are sorted by hour, so each day's are written together
+	for start := 0; start < len(records); {
+		day := records[start].Hour.Truncate(24 * time.Hour)
+		end := start
+		var lines []any
+		for ; end < len(records) && records[end].Hour.Truncate(24*time.Hour).Equal(day); end++ {
+			lines = append(lines, records[end])
+		}
+		if err := t.counters.append(day, lines...); err != nil {
+			// Put back what was not written
+			t.mu.Lock()
+			for _, r := range records[start:] {
+				key := recordKey{hour: r.Hour, accessKey: r.AccessKey, bucket: r.Bucket}
+				if counters, ok := t.pending[key]; ok {
+					counters.Add(r.Counters)
+				} else {
+					c := r.Counters
+					t.pending[key] = &c
+				}
+			}
+			t.mu.Unlock()
+			return err
+		}
+		start = end
+	}
+	return t.counters.prune(t.now().Add(-t.retention))
+}
+
+// Query returns the hourly counters of the hours starting in [from, to),
+// flushed or not, one record per access key, bucket and hour, ordered by
+// hour, access key and bucket. Lines that cannot be parsed, such as one
+// torn by a crash, are skipped.
+func (t *Tracker) Query(from, to time.Time) ([]Record, error) {
+	totals := make(map[recordKey]*Counters)
+	add := func(r Record) {
+		if r.Hour.Before(from) || !r.Hour.Before(to) {
+			return
+		}
+		key := recordKey{hour: r.Hour.UTC(), accessKey: r.AccessKey, bucket: r.Bucket}
+		if counters, ok := totals[key]; ok {
+			counters.Add(r.Counters)
+			return
+		}
+		c := r.Counters
+		totals[key] = &c
+	}
+
+	t.fileMu.Lock()
+	err := t.counters.read(from, to, func(line []byte) {
+		var r Record
+		if err := json.Unmarshal(line, &r); err == nil {
+			add(r)
+		}
+	})
+	if err != nil {
+		t.fileMu.Unlock()
+		return nil, err
+	}
+	// Counts not flushed yet are read under the file lock too, so a flush
+	// cannot move them to the files in between
+	t.mu.Lock()
+	for key, counters := range t.pending {
+		add(Record{Hour: key.hour, AccessKey: key.accessKey, Bucket: key.bucket, Counters: *counters})
+	}
+	t.mu.Unlock()
+	t.fileMu.Unlock()
+
+	records := make([]Record, 0, len(totals))
+	for key, counters := range totals {
+		records = append(records, Record{Hour: key.hour, AccessKey: key.accessKey, Bucket: key.bucket, Counters: *counters})
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		if a.AccessKey != b.AccessKey {
+			return a.AccessKey < b.AccessKey
+		}
+		return a.Bucket < b.Bucket
+	})
+}
+
+// Start flushes the counts every interval, and samples storage once an
+// hour when a storage source is set
+func (t *Tracker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		t.sample(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := t.Flush(); err != nil && t.onError != nil {
+					t.onError(err)
+				}
+				t.sample(ctx)
+			}
+		}
+	}()
+}
+
+func (t *Tracker) sample(ctx context.Context) {
+	if err := t.SampleStorage(ctx); err != nil && ctx.Err() == nil && t.onError != nil {
+		t.onError(err)
+	}
+}
+
+// Stop stops flushing, after a last flush of the counts recorded since
+// the previous one
+func (t *Tracker) Stop() error {
+	if t.cancel != nil {
+		t.cancel()
+		t.wg.Wait()
+	}
+	return t.Flush()
+}
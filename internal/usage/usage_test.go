@@ -0,0 +1,149 @@
+package usage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracker_FlushAndQuery(t *testing.T) {
+	dir := t.TempDir()
+	tracker, err := NewTracker(dir, 48*time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Record("team-a", "logs", Counters{Requests: 1, BytesIn: 100})
+	tracker.Record("team-a", "logs", Counters{Requests: 1, ClientErrors: 1})
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	// The same hour again after a flush, and a new hour left unflushed
+	tracker.Record("team-a", "logs", Counters{Requests: 2, BytesOut: 50})
+	tracker.Record("team-b", "", Counters{Requests: 1, ServerErrors: 1})
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	now = now.Add(time.Hour)
+	tracker.Record("team-a", "logs", Counters{Requests: 1})
+
+	records, err := tracker.Query(now.Add(-24*time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	want := []Record{
+		{Hour: hour, AccessKey: "team-a", Bucket: "logs", Counters: Counters{Requests: 4, ClientErrors: 1, BytesIn: 100, BytesOut: 50}},
+		{Hour: hour, AccessKey: "team-b", Counters: Counters{Requests: 1, ServerErrors: 1}},
+		{Hour: hour.Add(time.Hour), AccessKey: "team-a", Bucket: "logs", Counters: Counters{Requests: 1}},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Query() = %+v, want %+v", records, want)
+	}
+	for i := range want {
+		if !records[i].Hour.Equal(want[i].Hour) || records[i].AccessKey != want[i].AccessKey || records[i].Bucket != want[i].Bucket || records[i].Counters != want[i].Counters {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+	if rate := records[0].ErrorRate(); rate != 0.25 {
+		t.Errorf("ErrorRate() = %v, want 0.25", rate)
+	}
+
+	// Hours outside the range are left out
+	records, err = tracker.Query(hour.Add(time.Hour), hour.Add(2*time.Hour))
+	if err != nil || len(records) != 1 {
+		t.Errorf("Query() of the second hour = %+v, %v", records, err)
+	}
+
+	// Stop writes what was not flushed, and a torn line is skipped
+	if err := tracker.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "usage", "2026-03-01.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"hour":"2026-03-0`)
+	f.Close()
+	reopened, err := NewTracker(dir, 48*time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = reopened.Query(hour, hour.Add(2*time.Hour))
+	if err != nil || len(records) != 3 {
+		t.Errorf("Query() after a restart = %+v, %v, want 3 records", records, err)
+	}
+}
+
+func TestTracker_Retention(t *testing.T) {
+	dir := t.TempDir()
+	tracker, err := NewTracker(dir, 48*time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for day := 0; day < 5; day++ {
+		now := start.AddDate(0, 0, day)
+		tracker.now = func() time.Time { return now }
+		tracker.Record("team-a", "logs", Counters{Requests: 1})
+		if err := tracker.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "usage", "2026-03-02.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("expired day still present: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "usage", "2026-03-03.jsonl")); err != nil {
+		t.Errorf("day within the retention removed: %v", err)
+	}
+}
+
+func TestTracker_SampleStorage(t *testing.T) {
+	tracker, err := NewTracker(t.TempDir(), 48*time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("NewTracker() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	size := int64(100)
+	calls := 0
+	tracker.SetStorageSource(func(ctx context.Context) ([]StorageSample, error) {
+		calls++
+		return []StorageSample{{Bucket: "logs", Owner: "team-a", Objects: 1, Bytes: size}}, nil
+	})
+
+	ctx := context.Background()
+	if err := tracker.SampleStorage(ctx); err != nil {
+		t.Fatalf("SampleStorage() error = %v", err)
+	}
+	// Sampled once an hour
+	now = now.Add(30 * time.Minute)
+	tracker.SampleStorage(ctx)
+	if calls != 1 {
+		t.Errorf("source called %d times in an hour, want 1", calls)
+	}
+	now = now.Add(time.Hour)
+	size = 300
+	tracker.SampleStorage(ctx)
+
+	samples, err := tracker.QueryStorage(now.Add(-24*time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("QueryStorage() error = %v", err)
+	}
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	if len(samples) != 2 || !samples[0].Hour.Equal(hour) || samples[0].Bytes != 100 ||
+		!samples[1].Hour.Equal(hour.Add(time.Hour)) || samples[1].Bytes != 300 || samples[1].Owner != "team-a" {
+		t.Errorf("QueryStorage() = %+v", samples)
+	}
+
+	// Samples do not show up as counters
+	records, err := tracker.Query(hour, hour.Add(2*time.Hour))
+	if err != nil || len(records) != 0 {
+		t.Errorf("Query() = %+v, %v, want no records", records, err)
+	}
+}
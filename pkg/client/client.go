@@ -0,0 +1,424 @@
+// Package client is a Go client for the comio object API
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// userMetadataPrefix marks headers carrying user metadata
+const userMetadataPrefix = "x-amz-meta-"
+
+// Config configures a Client
+type Config struct {
+	Endpoint   string // Base URL of the server, e.g. http://localhost:8080
+	AccessKey  string // Requests are unsigned when empty
+	SecretKey  string
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+
+	// Objects larger than DownloadPartSize are downloaded as range
+	// requests of that size, DownloadConcurrency at a time. A concurrency
+	// of 1 downloads every object with a single request.
+	DownloadPartSize    int64 // Defaults to 8 MiB
+	DownloadConcurrency int   // Defaults to 4
+
+	// Retry configures retries of transient failures; nil uses
+	// DefaultRetryPolicy
+	Retry *RetryPolicy
+
+	// Encryption enables client-side encryption of uploaded objects
+	Encryption *EncryptionConfig
+}
+
+// Client talks to a comio server
+type Client struct {
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	http      *http.Client
+	keys      *masterKeys
+
+	partSize    int64
+	concurrency int
+	retry       RetryPolicy
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+	VersionID    string
+	Metadata     map[string]string // User metadata, without the x-amz-meta- prefix
+}
+
+// GetOptions are optional settings of a download
+type GetOptions struct {
+	// Progress is called as the payload is read with the bytes received so
+	// far and the stored size of the object
+	Progress func(transferred, total int64)
+}
+
+// PutOptions are optional settings of an upload
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string // User metadata, without the x-amz-meta- prefix
+}
+
+// New creates a client
+func New(cfg Config) (*Client, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+
+	c := &Client{
+		endpoint:    endpoint,
+		accessKey:   cfg.AccessKey,
+		secretKey:   cfg.SecretKey,
+		http:        cfg.HTTPClient,
+		partSize:    cfg.DownloadPartSize,
+		concurrency: cfg.DownloadConcurrency,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	if c.partSize <= 0 {
+		c.partSize = DefaultDownloadPartSize
+	}
+	if c.concurrency <= 0 {
+		c.concurrency = DefaultDownloadConcurrency
+	}
+	c.retry = DefaultRetryPolicy
+	if cfg.Retry != nil {
+		c.retry = *cfg.Retry
+	}
+	if c.retry.MaxAttempts < 1 {
+		c.retry.MaxAttempts = 1
+	}
+	if cfg.Encryption != nil {
+		if c.keys, err = newMasterKeys(cfg.Encryption); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// PutObject uploads size bytes read from body. With encryption enabled the
+// payload is encrypted before it leaves the client. Failed uploads are
+// retried only when body is an io.Seeker, such as a file.
+func (c *Client) PutObject(ctx context.Context, bucket, key string, body io.Reader, size int64, opts *PutOptions) (*ObjectInfo, error) {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	if size < 0 {
+		return nil, errors.New("object size must be known")
+	}
+
+	plainSize := size
+	header := make(http.Header)
+	for name, value := range opts.Metadata {
+		header.Set(userMetadataPrefix+name, value)
+	}
+	if opts.ContentType != "" {
+		header.Set("Content-Type", opts.ContentType)
+	}
+
+	// payload returns the body to send; it is called again from the start
+	// of the source when a seekable upload is retried
+	src := body
+	payload := func() (io.Reader, error) { return src, nil }
+	if c.keys != nil {
+		env, err := c.keys.newEnvelope()
+		if err != nil {
+			return nil, err
+		}
+		payload = func() (io.Reader, error) { return env.encryptReader(src) }
+		env.setHeaders(header, size)
+		size = encryptedSize(size)
+	}
+
+	body, err := payload()
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPut, bucket, key, body)
+	if err != nil {
+		return nil, err
+	}
+	if seeker, ok := src.(io.Seeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, err
+				}
+				body, err := payload()
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(body), nil
+			}
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = size
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stored struct {
+		ETag      string `json:"etag"`
+		VersionID string `json:"version_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &ObjectInfo{
+		Key:         key,
+		Size:        plainSize,
+		ETag:        stored.ETag,
+		ContentType: opts.ContentType,
+		VersionID:   stored.VersionID,
+		Metadata:    opts.Metadata,
+	}, nil
+}
+
+// GetObject downloads an object. The caller must close the returned
+// reader. Objects larger than the download part size are fetched as
+// parallel range requests and reassembled in order. The payload is checked
+// against the checksum the server returns, and client-side encrypted
+// objects are decrypted as they are read.
+func (c *Client) GetObject(ctx context.Context, bucket, key string, opts *GetOptions) (io.ReadCloser, *ObjectInfo, error) {
+	if opts == nil {
+		opts = &GetOptions{}
+	}
+
+	if c.concurrency > 1 {
+		info, header, err := c.head(ctx, bucket, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if info.Size > c.partSize {
+			body := c.parallelGet(ctx, bucket, key, info)
+			return c.openPayload(newVerifyReader(body, header, info.Size, opts.Progress), info, header)
+		}
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := objectInfo(key, resp)
+	return c.openPayload(newVerifyReader(resp.Body, resp.Header, info.Size, opts.Progress), info, resp.Header)
+}
+
+// openPayload returns the plaintext reader of a downloaded payload,
+// decrypting client-side encrypted objects
+func (c *Client) openPayload(body io.ReadCloser, info *ObjectInfo, header http.Header) (io.ReadCloser, *ObjectInfo, error) {
+	env, err := parseEnvelope(header)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	if env == nil {
+		return body, info, nil
+	}
+
+	if c.keys == nil {
+		body.Close()
+		return nil, nil, ErrNoDecryptionKey
+	}
+	plain, err := c.keys.decryptReader(env, body)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	info.Size = env.size
+	removeEnvelopeMetadata(info.Metadata)
+	return plain, info, nil
+}
+
+// HeadObject returns the metadata of an object
+func (c *Client) HeadObject(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	info, header, err := c.head(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	env, err := parseEnvelope(header)
+	if err != nil {
+		return nil, err
+	}
+	if env != nil {
+		info.Size = env.size
+		removeEnvelopeMetadata(info.Metadata)
+	}
+	return info, nil
+}
+
+// head returns the stored size and metadata of an object, and the
+// response headers
+func (c *Client) head(ctx context.Context, bucket, key string) (*ObjectInfo, http.Header, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, bucket, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(key, resp), resp.Header, nil
+}
+
+// DeleteObject deletes an object
+func (c *Client) DeleteObject(ctx context.Context, bucket, key string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// newRequest builds a request for an object
+func (c *Client) newRequest(ctx context.Context, method, bucket, key string, body io.Reader) (*http.Request, error) {
+	u := *c.endpoint
+	u.Path = c.endpoint.Path + "/" + bucket + "/" + key
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// do signs and sends a request, turning error responses into
+// *s3.ErrorResponse. Transient failures are retried per the retry policy.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		c.sign(req)
+		resp, err := c.http.Do(req)
+
+		retry := false
+		if err != nil {
+			retry = retryableNetworkError(err)
+		} else if resp.StatusCode < 300 {
+			return resp, nil
+		} else {
+			e := responseError(req, resp)
+			resp.Body.Close()
+			retry = retryableStatus(resp.StatusCode, e)
+			err = e
+		}
+
+		if !retry || attempt >= c.retry.MaxAttempts || !rewind(req) {
+			return nil, err
+		}
+		if err := sleep(req.Context(), c.retry.delay(attempt, retryAfter(resp))); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sign authenticates a request the way the server's HMAC authenticator
+// expects: the payload hash header signed with the secret key
+func (c *Client) sign(req *http.Request) {
+	if c.accessKey == "" {
+		return
+	}
+	const payload = "UNSIGNED-PAYLOAD"
+	mac := hmac.New(sha256.New, []byte(c.secretKey))
+	mac.Write([]byte(payload))
+
+	date := time.Now().UTC().Format("20060102")
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s/us-east-1/s3/aws4_request, SignedHeaders=x-amz-content-sha256, Signature=%s",
+		c.accessKey, date, hex.EncodeToString(mac.Sum(nil))))
+}
+
+// responseError decodes the error document of a failed response. HEAD
+// responses have no body, so their code is derived from the status.
+func responseError(req *http.Request, resp *http.Response) *s3.ErrorResponse {
+	e := &s3.ErrorResponse{
+		Resource:  req.URL.Path,
+		RequestID: resp.Header.Get("x-amz-request-id"),
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(body, e); err == nil && e.Code != "" {
+		return e
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		e.Code = s3.NoSuchKey
+	case http.StatusForbidden, http.StatusUnauthorized:
+		e.Code = s3.AccessDenied
+	case http.StatusServiceUnavailable:
+		e.Code = s3.ServiceUnavailable
+	default:
+		e.Code = s3.InternalError
+	}
+	e.Message = resp.Status
+	if len(body) > 0 {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// objectInfo reads object metadata from response headers
+func objectInfo(key string, resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), `"`),
+		ContentType: resp.Header.Get("Content-Type"),
+		VersionID:   resp.Header.Get("x-amz-version-id"),
+	}
+	if n, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = n
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = t
+	}
+	for name, values := range resp.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, userMetadataPrefix) && len(values) > 0 {
+			if info.Metadata == nil {
+				info.Metadata = make(map[string]string)
+			}
+			info.Metadata[strings.TrimPrefix(name, userMetadataPrefix)] = values[0]
+		}
+	}
+	return info
+}
+
+// IsCode reports whether err is an error response with the given code
+func IsCode(err error, code s3.ErrorCode) bool {
+	var e *s3.ErrorResponse
+	return errors.As(err, &e) && e.Code == code
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// fakeServer stores objects and their x-amz-meta-* headers in memory, as
+// the object API does
+type fakeServer struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	metadata map[string]http.Header
+	requests []*http.Request
+	onRange  func(path string, data []byte) []byte // Called with the lock held before a range is served
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *httptest.Server) {
+	f := &fakeServer{objects: make(map[string][]byte), metadata: make(map[string]http.Header)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(data)) {
+			http.Error(w, "length mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = data
+		meta := make(http.Header)
+		for name, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), userMetadataPrefix) {
+				meta[name] = values
+			}
+		}
+		f.metadata[r.URL.Path] = meta
+		json.NewEncoder(w).Encode(map[string]string{"etag": "etag", "version_id": "v1"})
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("x-amz-request-id", "REQ1")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				json.NewEncoder(w).Encode(s3.ErrorResponse{Code: s3.NoSuchKey, Message: "object not found"})
+			}
+			return
+		}
+		for name, values := range f.metadata[r.URL.Path] {
+			w.Header()[name] = values
+		}
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+			if f.onRange != nil {
+				data = f.onRange(r.URL.Path, data)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[start : end+1])
+			return
+		}
+
+		sha := sha256.Sum256(data)
+		w.Header().Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sha[:]))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestClient_PutGetObject(t *testing.T) {
+	_, srv := newFakeServer(t)
+	c, err := New(Config{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	data := []byte("hello comio")
+	if _, err := c.PutObject(ctx, "bucket", "key", bytes.NewReader(data), int64(len(data)),
+		&PutOptions{Metadata: map[string]string{"color": "blue"}}); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	body, info, err := c.GetObject(ctx, "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("GetObject returned %q, want %q", got, data)
+	}
+	if info.Metadata["color"] != "blue" {
+		t.Errorf("Metadata = %v, want color=blue", info.Metadata)
+	}
+}
+
+func TestClient_ErrorCodes(t *testing.T) {
+	_, srv := newFakeServer(t)
+	c, _ := New(Config{Endpoint: srv.URL})
+	ctx := context.Background()
+
+	_, _, err := c.GetObject(ctx, "bucket", "missing", nil)
+	if !IsCode(err, s3.NoSuchKey) {
+		t.Errorf("GetObject error = %v, want NoSuchKey", err)
+	}
+	var e *s3.ErrorResponse
+	if !errors.As(err, &e) || e.RequestID != "REQ1" {
+		t.Errorf("error request ID = %v, want REQ1", e)
+	}
+
+	// HEAD has no body; the code comes from the status
+	if _, err := c.HeadObject(ctx, "bucket", "missing"); !IsCode(err, s3.NoSuchKey) {
+		t.Errorf("HeadObject error = %v, want NoSuchKey", err)
+	}
+}
+
+func TestClient_SignsRequests(t *testing.T) {
+	f, srv := newFakeServer(t)
+	c, _ := New(Config{Endpoint: srv.URL, AccessKey: "AKID", SecretKey: "secret"})
+
+	c.DeleteObject(context.Background(), "bucket", "key")
+
+	auth := f.requests[0].Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "Signature=") {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if got := f.requests[0].Header.Get("X-Amz-Content-Sha256"); got != "UNSIGNED-PAYLOAD" {
+		t.Errorf("X-Amz-Content-Sha256 = %q", got)
+	}
+}
+
+func TestNew_InvalidEndpoint(t *testing.T) {
+	if _, err := New(Config{Endpoint: "localhost:8080"}); err == nil {
+		t.Error("New accepted an endpoint without scheme")
+	}
+}
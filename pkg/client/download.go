@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultDownloadPartSize is the size of the range requests of a
+	// parallel download
+	DefaultDownloadPartSize = 8 * 1024 * 1024
+	// DefaultDownloadConcurrency is how many range requests of a download
+	// are in flight at once
+	DefaultDownloadConcurrency = 4
+)
+
+var (
+	// ErrChecksumMismatch is returned at the end of a download whose
+	// payload does not match the checksum sent by the server
+	ErrChecksumMismatch = errors.New("downloaded object does not match its checksum")
+	// ErrObjectChanged is returned when an object is overwritten while it
+	// is downloaded in parts
+	ErrObjectChanged = errors.New("object changed during download")
+	// ErrEncryptedRange is returned by GetObjectRange for client-side
+	// encrypted objects, which can only be read in full
+	ErrEncryptedRange = errors.New("client-side encrypted objects cannot be read by range")
+)
+
+// part is the result of one range request
+type part struct {
+	data []byte
+	err  error
+}
+
+// parallelReader reassembles the parts of a download in order. Parts are
+// fetched ahead of the reader, at most concurrency at a time, so memory
+// use is bounded by concurrency times the part size.
+type parallelReader struct {
+	cancel context.CancelFunc
+	parts  []chan part
+	slots  chan struct{}
+	next   int
+	cur    []byte
+	err    error
+}
+
+// parallelGet downloads the stored payload of an object as range requests
+func (c *Client) parallelGet(ctx context.Context, bucket, key string, info *ObjectInfo) io.ReadCloser {
+	size, etag := info.Size, info.ETag
+	ctx, cancel := context.WithCancel(ctx)
+	count := int((size + c.partSize - 1) / c.partSize)
+	r := &parallelReader{
+		cancel: cancel,
+		parts:  make([]chan part, count),
+		slots:  make(chan struct{}, c.concurrency),
+	}
+	for i := range r.parts {
+		r.parts[i] = make(chan part, 1)
+	}
+
+	go func() {
+		for i := range r.parts {
+			// A slot is freed when the reader consumes a part
+			select {
+			case r.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			start := int64(i) * c.partSize
+			end := min(start+c.partSize, size) - 1
+			go func(i int) {
+				data, err := c.getRange(ctx, bucket, key, etag, start, end)
+				r.parts[i] <- part{data: data, err: err}
+			}(i)
+		}
+	}()
+	return r
+}
+
+// getRange fetches bytes [start, end] of an object, failing if the object
+// is no longer the version with etag
+func (c *Client) getRange(ctx context.Context, bucket, key, etag string, start, end int64) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("range request returned status %d", resp.StatusCode)
+	}
+	if got := strings.Trim(resp.Header.Get("ETag"), `"`); got != etag {
+		return nil, fmt.Errorf("%w: ETag %s, started with %s", ErrObjectChanged, got, etag)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to read bytes %d-%d: %w", start, end, err)
+	}
+	return data, nil
+}
+
+// GetObjectRange returns up to length bytes of an object starting at
+// offset; fewer bytes are returned when the object ends first
+func (c *Client) GetObjectRange(ctx context.Context, bucket, key string, offset, length int64) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get(metaAlgorithm) != "" {
+		return nil, ErrEncryptedRange
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server sent the whole object
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return nil, nil
+		}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, length))
+}
+
+func (r *parallelReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.next == len(r.parts) {
+			return 0, io.EOF
+		}
+		result := <-r.parts[r.next]
+		if result.err != nil {
+			r.err = result.err
+			r.cancel()
+			return 0, r.err
+		}
+		r.cur = result.data
+		r.next++
+		<-r.slots
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+func (r *parallelReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// verifyReader reports progress and checks the payload against the
+// x-amz-checksum-sha256 header once it has been read in full
+type verifyReader struct {
+	r        io.ReadCloser
+	h        hash.Hash
+	expected string
+	progress func(transferred, total int64)
+	read     int64
+	total    int64
+}
+
+func newVerifyReader(r io.ReadCloser, header http.Header, total int64, progress func(int64, int64)) io.ReadCloser {
+	v := &verifyReader{
+		r:        r,
+		expected: header.Get("x-amz-checksum-sha256"),
+		progress: progress,
+		total:    total,
+	}
+	if v.expected != "" {
+		v.h = sha256.New()
+	}
+	return v
+}
+
+func (v *verifyReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if n > 0 {
+		if v.h != nil {
+			v.h.Write(p[:n])
+		}
+		v.read += int64(n)
+		if v.progress != nil {
+			v.progress(v.read, v.total)
+		}
+	}
+	if err == io.EOF && v.h != nil {
+		if got := base64.StdEncoding.EncodeToString(v.h.Sum(nil)); got != v.expected {
+			return n, fmt.Errorf("%w: sha256 %s, expected %s", ErrChecksumMismatch, got, v.expected)
+		}
+	}
+	return n, err
+}
+
+func (v *verifyReader) Close() error {
+	return v.r.Close()
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func putRandom(t *testing.T, c *Client, size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	if _, err := c.PutObject(context.Background(), "bucket", "key", bytes.NewReader(data), int64(size), nil); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	return data
+}
+
+func countRanges(f *fakeServer) int {
+	n := 0
+	for _, r := range f.requests {
+		if r.Header.Get("Range") != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestClient_ParallelDownload(t *testing.T) {
+	f, srv := newFakeServer(t)
+	c, _ := New(Config{Endpoint: srv.URL, DownloadPartSize: 1000, DownloadConcurrency: 3})
+	data := putRandom(t, c, 10*1000+3)
+
+	var last, total int64
+	body, info, err := c.GetObject(context.Background(), "bucket", "key", &GetOptions{
+		Progress: func(transferred, size int64) {
+			if transferred < last {
+				t.Errorf("progress went back from %d to %d", last, transferred)
+			}
+			last, total = transferred, size
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading download: %v", err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Error("parts were not reassembled in order")
+	}
+	if info.Size != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", info.Size, len(data))
+	}
+	if n := countRanges(f); n != 11 {
+		t.Errorf("made %d range requests, want 11", n)
+	}
+	if last != int64(len(data)) || total != int64(len(data)) {
+		t.Errorf("final progress = %d/%d, want %d/%d", last, total, len(data), len(data))
+	}
+}
+
+func TestClient_SmallDownloadSingleRequest(t *testing.T) {
+	f, srv := newFakeServer(t)
+	c, _ := New(Config{Endpoint: srv.URL, DownloadPartSize: 1000})
+	data := putRandom(t, c, 1000)
+
+	body, _, err := c.GetObject(context.Background(), "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if !bytes.Equal(got, data) {
+		t.Error("download does not match")
+	}
+	if n := countRanges(f); n != 0 {
+		t.Errorf("made %d range requests, want 0", n)
+	}
+}
+
+func TestClient_ParallelDownloadChecksum(t *testing.T) {
+	f, srv := newFakeServer(t)
+	c, _ := New(Config{Endpoint: srv.URL, DownloadPartSize: 1000})
+	putRandom(t, c, 5000)
+
+	// Corrupt the bytes served for one range
+	f.onRange = func(path string, data []byte) []byte {
+		bad := append([]byte(nil), data...)
+		bad[2500] ^= 1
+		return bad
+	}
+
+	body, _, err := c.GetObject(context.Background(), "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("error = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestClient_ParallelDownloadObjectChanged(t *testing.T) {
+	f, srv := newFakeServer(t)
+	c, _ := New(Config{Endpoint: srv.URL, DownloadPartSize: 1000, DownloadConcurrency: 1})
+	putRandom(t, c, 5000)
+
+	// Concurrency 1 still downloads large objects in one request
+	body, _, err := c.GetObject(context.Background(), "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	io.ReadAll(body)
+	body.Close()
+	if n := countRanges(f); n != 0 {
+		t.Errorf("concurrency 1 made %d range requests", n)
+	}
+
+	c, _ = New(Config{Endpoint: srv.URL, DownloadPartSize: 1000, DownloadConcurrency: 2})
+	// Overwrite the object once the download has started
+	f.onRange = func(path string, data []byte) []byte {
+		replaced := make([]byte, len(data))
+		rand.Read(replaced)
+		f.objects[path] = replaced
+		f.onRange = nil
+		return data
+	}
+	body, _, err = c.GetObject(context.Background(), "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); !errors.Is(err, ErrObjectChanged) {
+		t.Errorf("error = %v, want ErrObjectChanged", err)
+	}
+}
+
+func TestClient_ParallelDownloadEncrypted(t *testing.T) {
+	_, srv := newFakeServer(t)
+	c, _ := New(Config{
+		Endpoint:         srv.URL,
+		DownloadPartSize: 10000,
+		Encryption:       &EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": testKey(t)}},
+	})
+	data := putRandom(t, c, 3*chunkSize+5)
+
+	body, info, err := c.GetObject(context.Background(), "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("reading download: %v", err)
+	}
+	if !bytes.Equal(got, data) || info.Size != int64(len(data)) {
+		t.Error("decrypted parallel download does not match")
+	}
+}
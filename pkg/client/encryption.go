@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Metadata of client-side encrypted objects. The server stores it like any
+// other user metadata and never sees the master keys.
+const (
+	metaAlgorithm = userMetadataPrefix + "comio-cse"        // Payload format
+	metaKeyID     = userMetadataPrefix + "comio-cse-key-id" // Master key wrapping the data key
+	metaKey       = userMetadataPrefix + "comio-cse-key"    // Wrapped data key, base64
+	metaNonce     = userMetadataPrefix + "comio-cse-nonce"  // Base nonce of the payload chunks, base64
+	metaSize      = userMetadataPrefix + "comio-cse-size"   // Plaintext size
+)
+
+// cseAlgorithm names the payload format: AES-256-GCM over 64 KiB chunks
+const cseAlgorithm = "AES256-GCM-64K"
+
+const (
+	chunkSize = 64 * 1024
+	tagSize   = 16
+	nonceSize = 12
+	keySize   = 32
+)
+
+// wrapAAD binds wrapped data keys to their use
+var wrapAAD = []byte("comio-cse-key")
+
+var (
+	// ErrNoDecryptionKey is returned when reading a client-side encrypted
+	// object without its master key
+	ErrNoDecryptionKey = errors.New("no master key to decrypt object")
+	// ErrDecryption is returned for encrypted payloads that fail
+	// authentication, because they were modified, truncated or reordered
+	ErrDecryption = errors.New("client-side decryption failed")
+)
+
+// EncryptionConfig holds the master keys of client-side encryption. Each
+// object is encrypted with its own data key; only the data key wrapped
+// with a master key is stored, in the object's metadata.
+type EncryptionConfig struct {
+	KeyID string            // Master key wrapping the data keys of new uploads
+	Keys  map[string][]byte // 32-byte AES-256 master keys by ID; keep old IDs to read existing objects
+}
+
+type masterKeys struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+func newMasterKeys(cfg *EncryptionConfig) (*masterKeys, error) {
+	if _, ok := cfg.Keys[cfg.KeyID]; !ok {
+		return nil, fmt.Errorf("encryption key %q is not configured", cfg.KeyID)
+	}
+	m := &masterKeys{active: cfg.KeyID, aeads: make(map[string]cipher.AEAD, len(cfg.Keys))}
+	for id, key := range cfg.Keys {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes, got %d", id, keySize, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		m.aeads[id] = aead
+	}
+	return m, nil
+}
+
+// envelope holds what is needed to decrypt one object
+type envelope struct {
+	keyID   string
+	wrapped []byte
+	nonce   []byte
+	dataKey []byte
+	size    int64 // Plaintext size
+}
+
+// newEnvelope generates a data key and wraps it with the active master key
+func (m *masterKeys) newEnvelope() (*envelope, error) {
+	env := &envelope{
+		keyID:   m.active,
+		dataKey: make([]byte, keySize),
+		nonce:   make([]byte, nonceSize),
+	}
+	if _, err := rand.Read(env.dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(env.nonce); err != nil {
+		return nil, err
+	}
+
+	aead := m.aeads[m.active]
+	wrapNonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(wrapNonce); err != nil {
+		return nil, err
+	}
+	env.wrapped = aead.Seal(wrapNonce, wrapNonce, env.dataKey, wrapAAD)
+	return env, nil
+}
+
+// unwrap recovers the data key of an envelope
+func (m *masterKeys) unwrap(env *envelope) error {
+	aead, ok := m.aeads[env.keyID]
+	if !ok {
+		return fmt.Errorf("%w: key %q is not configured", ErrNoDecryptionKey, env.keyID)
+	}
+	if len(env.wrapped) < aead.NonceSize() {
+		return fmt.Errorf("%w: malformed wrapped key", ErrDecryption)
+	}
+	nonce, sealed := env.wrapped[:aead.NonceSize()], env.wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, wrapAAD)
+	if err != nil {
+		return fmt.Errorf("%w: cannot unwrap data key with key %q", ErrDecryption, env.keyID)
+	}
+	env.dataKey = dataKey
+	return nil
+}
+
+// setHeaders records the envelope in the metadata of an upload
+func (env *envelope) setHeaders(header http.Header, size int64) {
+	header.Set(metaAlgorithm, cseAlgorithm)
+	header.Set(metaKeyID, env.keyID)
+	header.Set(metaKey, base64.StdEncoding.EncodeToString(env.wrapped))
+	header.Set(metaNonce, base64.StdEncoding.EncodeToString(env.nonce))
+	header.Set(metaSize, strconv.FormatInt(size, 10))
+}
+
+// parseEnvelope reads the envelope of an object from response headers. It
+// returns nil for objects not encrypted by the client.
+func parseEnvelope(header http.Header) (*envelope, error) {
+	algorithm := header.Get(metaAlgorithm)
+	if algorithm == "" {
+		return nil, nil
+	}
+	if algorithm != cseAlgorithm {
+		return nil, fmt.Errorf("unsupported client-side encryption %q", algorithm)
+	}
+
+	env := &envelope{keyID: header.Get(metaKeyID)}
+	var err error
+	if env.wrapped, err = base64.StdEncoding.DecodeString(header.Get(metaKey)); err != nil {
+		return nil, fmt.Errorf("%w: malformed wrapped key", ErrDecryption)
+	}
+	if env.nonce, err = base64.StdEncoding.DecodeString(header.Get(metaNonce)); err != nil || len(env.nonce) != nonceSize {
+		return nil, fmt.Errorf("%w: malformed nonce", ErrDecryption)
+	}
+	if env.size, err = strconv.ParseInt(header.Get(metaSize), 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: malformed size", ErrDecryption)
+	}
+	return env, nil
+}
+
+// removeEnvelopeMetadata hides the envelope from the user metadata returned
+// to callers
+func removeEnvelopeMetadata(metadata map[string]string) {
+	for name := range metadata {
+		if full := userMetadataPrefix + name; full == metaAlgorithm || strings.HasPrefix(full, metaAlgorithm+"-") {
+			delete(metadata, name)
+		}
+	}
+}
+
+// encryptedSize returns the stored size of a payload of size bytes: every
+// chunk, and at least one, carries an authentication tag
+func encryptedSize(size int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*tagSize
+}
+
+// encryptReader returns a reader of the encrypted payload of src
+func (env *envelope) encryptReader(src io.Reader) (io.Reader, error) {
+	aead, err := newGCM(env.dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReader{
+		aead:  aead,
+		nonce: env.nonce,
+		src:   bufio.NewReaderSize(src, chunkSize),
+		in:    make([]byte, chunkSize),
+		seal:  true,
+	}, nil
+}
+
+// decryptReader returns a reader of the plaintext of an encrypted payload
+func (m *masterKeys) decryptReader(env *envelope, src io.ReadCloser) (io.ReadCloser, error) {
+	if err := m.unwrap(env); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(env.dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkReadCloser{
+		chunkReader: chunkReader{
+			aead:      aead,
+			nonce:     env.nonce,
+			src:       bufio.NewReaderSize(src, chunkSize+tagSize),
+			in:        make([]byte, chunkSize+tagSize),
+			remaining: env.size,
+		},
+		closer: src,
+	}, nil
+}
+
+// chunkReader seals or opens a payload a chunk at a time. The chunk index
+// is mixed into each nonce and the last chunk is flagged in its additional
+// data, so reordered, dropped or appended chunks fail authentication.
+type chunkReader struct {
+	aead      cipher.AEAD
+	nonce     []byte
+	src       *bufio.Reader
+	in        []byte
+	out       []byte
+	index     uint64
+	done      bool
+	seal      bool
+	remaining int64 // Plaintext bytes still expected when opening
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// next transforms the next chunk of the source
+func (r *chunkReader) next() error {
+	n, err := io.ReadFull(r.src, r.in)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	final := n < len(r.in)
+	if !final {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			final = true
+		}
+	}
+
+	nonce := make([]byte, nonceSize)
+	copy(nonce, r.nonce)
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ r.index
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	aad := []byte{0}
+	if final {
+		aad[0] = 1
+	}
+	r.index++
+	r.done = final
+
+	if r.seal {
+		r.out = r.aead.Seal(r.out[:0], nonce, r.in[:n], aad)
+		return nil
+	}
+
+	out, err := r.aead.Open(r.out[:0], nonce, r.in[:n], aad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrDecryption, r.index-1)
+	}
+	r.remaining -= int64(len(out))
+	if final && r.remaining != 0 {
+		return fmt.Errorf("%w: size does not match metadata", ErrDecryption)
+	}
+	r.out = out
+	return nil
+}
+
+type chunkReadCloser struct {
+	chunkReader
+	closer io.Closer
+}
+
+func (r *chunkReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestClient_Encryption(t *testing.T) {
+	f, srv := newFakeServer(t)
+	c, err := New(Config{
+		Endpoint:   srv.URL,
+		Encryption: &EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": testKey(t)}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// Empty, partial, exact and multiple chunks
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		info, err := c.PutObject(ctx, "bucket", "key", bytes.NewReader(data), int64(size),
+			&PutOptions{Metadata: map[string]string{"color": "blue"}})
+		if err != nil {
+			t.Fatalf("PutObject(%d) failed: %v", size, err)
+		}
+		if info.Size != int64(size) {
+			t.Errorf("PutObject(%d) Size = %d", size, info.Size)
+		}
+
+		stored := f.objects["/bucket/key"]
+		if int64(len(stored)) != encryptedSize(int64(size)) {
+			t.Errorf("stored %d bytes, want %d", len(stored), encryptedSize(int64(size)))
+		}
+		// Short plaintexts turn up in random ciphertext by chance
+		if size >= 16 && bytes.Contains(stored, data) {
+			t.Errorf("server holds the plaintext of a %d byte object", size)
+		}
+
+		body, info, err := c.GetObject(ctx, "bucket", "key", nil)
+		if err != nil {
+			t.Fatalf("GetObject(%d) failed: %v", size, err)
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatalf("reading %d byte object: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("decrypted %d byte object does not match", size)
+		}
+		if info.Size != int64(size) {
+			t.Errorf("GetObject(%d) Size = %d", size, info.Size)
+		}
+		if len(info.Metadata) != 1 || info.Metadata["color"] != "blue" {
+			t.Errorf("Metadata = %v, want only color=blue", info.Metadata)
+		}
+	}
+}
+
+func TestClient_EncryptionTampering(t *testing.T) {
+	f, srv := newFakeServer(t)
+	cfg := &EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": testKey(t)}}
+	c, _ := New(Config{Endpoint: srv.URL, Encryption: cfg})
+	ctx := context.Background()
+
+	data := make([]byte, 2*chunkSize+10)
+	rand.Read(data)
+	put := func() {
+		if _, err := c.PutObject(ctx, "bucket", "key", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+			t.Fatalf("PutObject failed: %v", err)
+		}
+	}
+	read := func() error {
+		body, _, err := c.GetObject(ctx, "bucket", "key", nil)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.ReadAll(body)
+		return err
+	}
+
+	tamper := map[string]func(stored []byte) []byte{
+		"flipped byte": func(s []byte) []byte { s[100] ^= 1; return s },
+		"truncated at chunk boundary": func(s []byte) []byte {
+			return s[:2*(chunkSize+tagSize)]
+		},
+		"reordered chunks": func(s []byte) []byte {
+			n := chunkSize + tagSize
+			out := append([]byte(nil), s[n:2*n]...)
+			out = append(out, s[:n]...)
+			return append(out, s[2*n:]...)
+		},
+		"appended data": func(s []byte) []byte { return append(s, s[:chunkSize+tagSize]...) },
+	}
+	for name, fn := range tamper {
+		put()
+		f.objects["/bucket/key"] = fn(f.objects["/bucket/key"])
+		if err := read(); !errors.Is(err, ErrDecryption) {
+			t.Errorf("%s: error = %v, want ErrDecryption", name, err)
+		}
+	}
+}
+
+func TestClient_EncryptionKeys(t *testing.T) {
+	_, srv := newFakeServer(t)
+	k1, k2 := testKey(t), testKey(t)
+	ctx := context.Background()
+
+	old, _ := New(Config{Endpoint: srv.URL, Encryption: &EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": k1}}})
+	old.PutObject(ctx, "bucket", "key", strings.NewReader("secret"), 6, nil)
+
+	// Objects wrapped with a retired key stay readable while it is configured
+	rotated, _ := New(Config{Endpoint: srv.URL, Encryption: &EncryptionConfig{KeyID: "k2", Keys: map[string][]byte{"k1": k1, "k2": k2}}})
+	body, _, err := rotated.GetObject(ctx, "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject with rotated keys failed: %v", err)
+	}
+	got, _ := io.ReadAll(body)
+	body.Close()
+	if string(got) != "secret" {
+		t.Errorf("GetObject = %q, want secret", got)
+	}
+
+	without, _ := New(Config{Endpoint: srv.URL, Encryption: &EncryptionConfig{KeyID: "k2", Keys: map[string][]byte{"k2": k2}}})
+	if _, _, err := without.GetObject(ctx, "bucket", "key", nil); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("GetObject without the key error = %v, want ErrNoDecryptionKey", err)
+	}
+	plain, _ := New(Config{Endpoint: srv.URL})
+	if _, _, err := plain.GetObject(ctx, "bucket", "key", nil); !errors.Is(err, ErrNoDecryptionKey) {
+		t.Errorf("GetObject without encryption error = %v, want ErrNoDecryptionKey", err)
+	}
+
+	if _, err := New(Config{Endpoint: srv.URL, Encryption: &EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": k1[:16]}}}); err == nil {
+		t.Error("New accepted a 16 byte master key")
+	}
+}
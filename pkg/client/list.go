@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListOptions select the objects returned by ListObjects
+type ListOptions struct {
+	Prefix     string
+	Delimiter  string
+	StartAfter string // Only keys sorting after StartAfter are returned
+	MaxKeys    int    // Server default when zero
+}
+
+// ListResult is one page of a listing
+type ListResult struct {
+	Objects        []ObjectInfo
+	CommonPrefixes []string
+	IsTruncated    bool
+	NextMarker     string // StartAfter of the next page
+}
+
+// listedObject is an object as encoded in a listing
+type listedObject struct {
+	Key         string            `json:"key"`
+	VersionID   string            `json:"version_id"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"content_type"`
+	ETag        string            `json:"etag"`
+	ModifiedAt  time.Time         `json:"modified_at"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// ListObjects returns one page of the objects of a bucket, in key order
+func (c *Client) ListObjects(ctx context.Context, bucket string, opts ListOptions) (*ListResult, error) {
+	u := *c.endpoint
+	u.Path = c.endpoint.Path + "/" + bucket
+	query := url.Values{}
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.Delimiter != "" {
+		query.Set("delimiter", opts.Delimiter)
+	}
+	if opts.StartAfter != "" {
+		query.Set("start-after", opts.StartAfter)
+	}
+	if opts.MaxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(opts.MaxKeys))
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var page struct {
+		Objects        []listedObject `json:"objects"`
+		CommonPrefixes []string       `json:"common_prefixes"`
+		IsTruncated    bool           `json:"is_truncated"`
+		NextMarker     string         `json:"next_marker"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to decode listing: %w", err)
+	}
+
+	result := &ListResult{
+		Objects:        make([]ObjectInfo, 0, len(page.Objects)),
+		CommonPrefixes: page.CommonPrefixes,
+		IsTruncated:    page.IsTruncated,
+		NextMarker:     page.NextMarker,
+	}
+	for _, o := range page.Objects {
+		info := ObjectInfo{
+			Key:          o.Key,
+			Size:         o.Size,
+			ETag:         o.ETag,
+			ContentType:  o.ContentType,
+			LastModified: o.ModifiedAt,
+			VersionID:    o.VersionID,
+		}
+		for name, value := range o.Metadata {
+			if strings.HasPrefix(name, userMetadataPrefix) {
+				if info.Metadata == nil {
+					info.Metadata = make(map[string]string)
+				}
+				info.Metadata[strings.TrimPrefix(name, userMetadataPrefix)] = value
+			}
+		}
+		// Client-side encrypted objects are listed with their plaintext size
+		if size, err := strconv.ParseInt(info.Metadata[strings.TrimPrefix(metaSize, userMetadataPrefix)], 10, 64); err == nil {
+			info.Size = size
+			removeEnvelopeMetadata(info.Metadata)
+		}
+		result.Objects = append(result.Objects, info)
+	}
+	return result, nil
+}
@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// DefaultChunkSize is the size of the chunks of a resumable upload
+const DefaultChunkSize = 8 * 1024 * 1024
+
+// ErrResumableEncrypted is returned for resumable uploads by clients with
+// client-side encryption, which needs the whole payload in one stream
+var ErrResumableEncrypted = errors.New("resumable uploads do not support client-side encryption")
+
+// ResumableOptions are optional settings of a resumable upload
+type ResumableOptions struct {
+	ContentType string
+	ChunkSize   int64 // Defaults to DefaultChunkSize
+
+	// SessionID continues an earlier upload of the same data, for example
+	// after the client was restarted
+	SessionID string
+	// OnSession is called with the session ID once it is known, so it can
+	// be saved to resume the upload later
+	OnSession func(sessionID string)
+	// Progress is called after each chunk with the bytes the server holds
+	Progress func(transferred, total int64)
+}
+
+// session is the server's view of a resumable upload
+type session struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+// ResumableUpload uploads size bytes of body in chunks over the
+// resumable upload protocol, for unstable links. When a chunk fails the
+// client asks the server how much it received and continues from there;
+// it gives up after the retry policy's attempts fail without progress.
+// Unlike multipart uploads, chunks may be of any size.
+func (c *Client) ResumableUpload(ctx context.Context, bucket, key string, body io.ReaderAt, size int64, opts *ResumableOptions) (*ObjectInfo, error) {
+	if opts == nil {
+		opts = &ResumableOptions{}
+	}
+	if c.keys != nil {
+		return nil, ErrResumableEncrypted
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	id := opts.SessionID
+	var offset int64
+	if id == "" {
+		created, err := c.createSession(ctx, bucket, key, size, opts.ContentType)
+		if err != nil {
+			return nil, err
+		}
+		id = created.SessionID
+	} else {
+		var err error
+		if offset, err = c.sessionOffset(ctx, bucket, key, id); err != nil {
+			return nil, err
+		}
+	}
+	if opts.OnSession != nil {
+		opts.OnSession(id)
+	}
+
+	failures := 0
+	for offset < size {
+		length := min(chunkSize, size-offset)
+		next, err := c.uploadChunk(ctx, bucket, key, id, offset, io.NewSectionReader(body, offset, length), length)
+		if err == nil {
+			offset, failures = next, 0
+			if opts.Progress != nil {
+				opts.Progress(offset, size)
+			}
+			continue
+		}
+
+		if !resumable(err) {
+			return nil, err
+		}
+		if failures++; failures >= c.retry.MaxAttempts {
+			return nil, fmt.Errorf("resumable upload %s stopped at %d of %d bytes: %w", id, offset, size, err)
+		}
+		if err := sleep(ctx, c.retry.delay(failures, 0)); err != nil {
+			return nil, err
+		}
+		// Some of the chunk may have arrived; continue where the server is
+		if offset, err = c.sessionOffset(ctx, bucket, key, id); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := c.sessionRequest(ctx, http.MethodPost, bucket, key, "session="+url.QueryEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stored struct {
+		ETag      string `json:"etag"`
+		VersionID string `json:"version_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &ObjectInfo{
+		Key:         key,
+		Size:        size,
+		ETag:        stored.ETag,
+		ContentType: opts.ContentType,
+		VersionID:   stored.VersionID,
+	}, nil
+}
+
+// AbortResumableUpload discards an unfinished resumable upload
+func (c *Client) AbortResumableUpload(ctx context.Context, bucket, key, sessionID string) error {
+	req, err := c.sessionRequest(ctx, http.MethodDelete, bucket, key, "session="+url.QueryEscape(sessionID), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// createSession starts a resumable upload
+func (c *Client) createSession(ctx context.Context, bucket, key string, size int64, contentType string) (*session, error) {
+	req, err := c.sessionRequest(ctx, http.MethodPost, bucket, key, "resumable", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var s session
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &s, nil
+}
+
+// sessionOffset returns how many bytes of a session the server holds
+func (c *Client) sessionOffset(ctx context.Context, bucket, key, id string) (int64, error) {
+	req, err := c.sessionRequest(ctx, http.MethodHead, bucket, key, "session="+url.QueryEscape(id), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return uploadOffset(resp)
+}
+
+// uploadChunk sends a chunk and returns the server's new offset
+func (c *Client) uploadChunk(ctx context.Context, bucket, key, id string, offset int64, data io.Reader, length int64) (int64, error) {
+	req, err := c.sessionRequest(ctx, http.MethodPatch, bucket, key, "session="+url.QueryEscape(id), data)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = length
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return uploadOffset(resp)
+}
+
+// resumable reports whether a failed chunk is worth resuming: the
+// connection failed, the server holds a different offset, the body was
+// cut short, or the previous chunk is still being written
+func resumable(err error) bool {
+	var e *s3.ErrorResponse
+	if !errors.As(err, &e) {
+		return retryableNetworkError(err)
+	}
+	switch e.Code {
+	case s3.OffsetMismatch, s3.IncompleteBody, s3.OperationAborted, s3.ServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// sessionRequest builds a request for an object with a query string
+func (c *Client) sessionRequest(ctx context.Context, method, bucket, key, query string, body io.Reader) (*http.Request, error) {
+	req, err := c.newRequest(ctx, method, bucket, key, body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = query
+	return req, nil
+}
+
+// uploadOffset reads the Upload-Offset header of a session response
+func uploadOffset(resp *http.Response) (int64, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("response has no valid Upload-Offset: %w", err)
+	}
+	return offset, nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+// RetryPolicy controls how requests failing with transient errors are
+// retried. Delays grow exponentially from BaseDelay up to MaxDelay, with
+// full jitter so clients failing together do not retry together.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts including the first; 1 disables retries
+	BaseDelay   time.Duration // Upper bound of the first delay
+	MaxDelay    time.Duration // Upper bound of any delay, including a server's Retry-After
+}
+
+// DefaultRetryPolicy is used by clients configured without a policy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// busyMarkers identify internal errors caused by a locked metadata
+// database, which clear up on their own
+var busyMarkers = []string{"database is locked", "SQLITE_BUSY"}
+
+// retryableStatus reports whether a failed response is worth retrying
+func retryableStatus(status int, e *s3.ErrorResponse) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusInternalServerError:
+		for _, marker := range busyMarkers {
+			if strings.Contains(e.Message, marker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryableNetworkError reports whether a transport error is transient:
+// connections reset or closed by the server, and timeouts
+func retryableNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// delay returns how long to wait before retry number attempt, at least
+// the server's Retry-After
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	ceiling := p.BaseDelay << (attempt - 1)
+	if ceiling > p.MaxDelay || ceiling <= 0 {
+		ceiling = p.MaxDelay
+	}
+	d := time.Duration(0)
+	if ceiling > 0 {
+		d = rand.N(ceiling)
+	}
+	if retryAfter > d {
+		d = min(retryAfter, p.MaxDelay)
+	}
+	return d
+}
+
+// retryAfter parses a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// rewind prepares a request to be sent again. Requests whose body cannot
+// be read again are not retried.
+func rewind(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielino/comio/pkg/s3"
+)
+
+var fastRetry = &RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// flakyServer fails the first len(failures) requests with the given
+// responses, then serves from a fakeServer
+type flakyServer struct {
+	mu       sync.Mutex
+	failures []func(w http.ResponseWriter)
+	attempts int
+	next     http.Handler
+}
+
+func newFlakyServer(t *testing.T, failures ...func(w http.ResponseWriter)) (*flakyServer, *httptest.Server) {
+	f, _ := newFakeServer(t)
+	s := &flakyServer{failures: failures, next: f}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.attempts++
+	var fail func(w http.ResponseWriter)
+	if len(s.failures) > 0 {
+		fail, s.failures = s.failures[0], s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if fail != nil {
+		io.Copy(io.Discard, r.Body)
+		fail(w)
+		return
+	}
+	s.next.ServeHTTP(w, r)
+}
+
+func status(code int, errCode s3.ErrorCode, message string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(s3.ErrorResponse{Code: errCode, Message: message})
+	}
+}
+
+// reset closes the connection without a response
+func reset(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func TestClient_RetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure func(w http.ResponseWriter)
+	}{
+		{"unavailable", status(http.StatusServiceUnavailable, s3.ServiceUnavailable, "try again")},
+		{"throttled", status(http.StatusTooManyRequests, "SlowDown", "slow down")},
+		{"database busy", status(http.StatusInternalServerError, s3.InternalError, "failed to put object: database is locked (5) (SQLITE_BUSY)")},
+		{"connection reset", reset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := newFlakyServer(t, tt.failure, tt.failure)
+			c, _ := New(Config{Endpoint: srv.URL, Retry: fastRetry})
+
+			data := []byte("retried upload")
+			if _, err := c.PutObject(context.Background(), "bucket", "key", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+				t.Fatalf("PutObject failed: %v", err)
+			}
+			if s.attempts != 3 {
+				t.Errorf("attempts = %d, want 3", s.attempts)
+			}
+
+			body, _, err := c.GetObject(context.Background(), "bucket", "key", nil)
+			if err != nil {
+				t.Fatalf("GetObject failed: %v", err)
+			}
+			got, _ := io.ReadAll(body)
+			body.Close()
+			if !bytes.Equal(got, data) {
+				t.Errorf("GetObject returned %q, want %q", got, data)
+			}
+		})
+	}
+}
+
+func TestClient_DoesNotRetryPermanentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure func(w http.ResponseWriter)
+	}{
+		{"not found", status(http.StatusNotFound, s3.NoSuchKey, "object not found")},
+		{"forbidden", status(http.StatusForbidden, s3.AccessDenied, "access denied")},
+		{"internal error", status(http.StatusInternalServerError, s3.InternalError, "disk full")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := newFlakyServer(t, tt.failure)
+			c, _ := New(Config{Endpoint: srv.URL, Retry: fastRetry})
+
+			if err := c.DeleteObject(context.Background(), "bucket", "key"); err == nil {
+				t.Fatal("DeleteObject succeeded, want error")
+			}
+			if s.attempts != 1 {
+				t.Errorf("attempts = %d, want 1", s.attempts)
+			}
+		})
+	}
+}
+
+func TestClient_RetryAttemptsExhausted(t *testing.T) {
+	unavailable := status(http.StatusServiceUnavailable, s3.ServiceUnavailable, "try again")
+	s, srv := newFlakyServer(t, unavailable, unavailable, unavailable, unavailable, unavailable)
+	c, _ := New(Config{Endpoint: srv.URL, Retry: fastRetry})
+
+	err := c.DeleteObject(context.Background(), "bucket", "key")
+	if !IsCode(err, s3.ServiceUnavailable) {
+		t.Errorf("DeleteObject error = %v, want ServiceUnavailable", err)
+	}
+	if s.attempts != fastRetry.MaxAttempts {
+		t.Errorf("attempts = %d, want %d", s.attempts, fastRetry.MaxAttempts)
+	}
+}
+
+func TestClient_RetryDisabled(t *testing.T) {
+	s, srv := newFlakyServer(t, status(http.StatusServiceUnavailable, s3.ServiceUnavailable, "try again"))
+	c, _ := New(Config{Endpoint: srv.URL, Retry: &RetryPolicy{MaxAttempts: 1}})
+
+	if err := c.DeleteObject(context.Background(), "bucket", "key"); err == nil {
+		t.Fatal("DeleteObject succeeded, want error")
+	}
+	if s.attempts != 1 {
+		t.Errorf("attempts = %d, want 1", s.attempts)
+	}
+}
+
+func TestClient_RetriesEncryptedUpload(t *testing.T) {
+	s, srv := newFlakyServer(t, reset)
+	c, _ := New(Config{
+		Endpoint:   srv.URL,
+		Retry:      fastRetry,
+		Encryption: &EncryptionConfig{KeyID: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{7}, keySize)}},
+	})
+
+	data := bytes.Repeat([]byte("secret "), 20000)
+	if _, err := c.PutObject(context.Background(), "bucket", "key", bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if s.attempts != 2 {
+		t.Errorf("attempts = %d, want 2", s.attempts)
+	}
+
+	body, _, err := c.GetObject(context.Background(), "bucket", "key", nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("GetObject returned %d bytes, err %v; want the uploaded %d bytes", len(got), err, len(data))
+	}
+}
+
+func TestClient_DoesNotRetryUnseekableUpload(t *testing.T) {
+	s, srv := newFlakyServer(t, status(http.StatusServiceUnavailable, s3.ServiceUnavailable, "try again"))
+	c, _ := New(Config{Endpoint: srv.URL, Retry: fastRetry})
+
+	data := "streamed"
+	if _, err := c.PutObject(context.Background(), "bucket", "key", io.MultiReader(strings.NewReader(data)), int64(len(data)), nil); err == nil {
+		t.Fatal("PutObject succeeded, want error")
+	}
+	if s.attempts != 1 {
+		t.Errorf("attempts = %d, want 1", s.attempts)
+	}
+}
+
+func TestClient_RetryStopsOnCancel(t *testing.T) {
+	_, srv := newFlakyServer(t, status(http.StatusServiceUnavailable, s3.ServiceUnavailable, "try again"))
+	c, _ := New(Config{Endpoint: srv.URL, Retry: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.DeleteObject(ctx, "bucket", "key"); err != context.DeadlineExceeded {
+		t.Errorf("DeleteObject error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for attempt := 1; attempt <= 8; attempt++ {
+		if d := p.delay(attempt, 0); d < 0 || d > p.MaxDelay {
+			t.Errorf("delay(%d) = %v, want within [0, %v]", attempt, d, p.MaxDelay)
+		}
+	}
+	if d := p.delay(1, 30*time.Millisecond); d < 30*time.Millisecond {
+		t.Errorf("delay with Retry-After = %v, want at least 30ms", d)
+	}
+	if d := p.delay(1, time.Minute); d != p.MaxDelay {
+		t.Errorf("delay with long Retry-After = %v, want %v", d, p.MaxDelay)
+	}
+}
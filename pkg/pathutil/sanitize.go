@@ -11,6 +11,8 @@ import (
 // 1. Replacing path separators (/ and \) with underscores
 // 2. Replacing parent directory references (..) with underscores
 // 3. Cleaning the path to remove redundant separators
+//
+// On Windows the result is also escaped with WindowsName.
 func SanitizePath(s string) string {
 	// Replace various forms of path traversal
 	s = strings.ReplaceAll(s, "/", "_")
@@ -25,5 +27,5 @@ func SanitizePath(s string) string {
 		s = "_" + s
 	}
 	
-	return s
+	return platformName(s)
 }
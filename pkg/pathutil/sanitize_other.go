@@ -0,0 +1,9 @@
+//go:build !windows
+
+package pathutil
+
+// platformName leaves names alone where any name without a separator is
+// a valid file name
+func platformName(s string) string {
+	return s
+}
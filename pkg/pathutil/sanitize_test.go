@@ -1,6 +1,9 @@
 package pathutil
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestSanitizePath(t *testing.T) {
 	tests := []struct {
@@ -49,3 +52,37 @@ func TestSanitizePath(t *testing.T) {
 		})
 	}
 }
+
+func TestWindowsName(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":    "photo.jpg",
+		"Photo.JPG":    "^photo.^j^p^g",
+		"a^b":          "a^^b",
+		"q?:*.txt":     "q%3F%3A%2A.txt",
+		"100%":         "100%25",
+		"tab\there":    "tab%09here",
+		"trailing.":    "trailing%2E",
+		"trailing ":    "trailing%20",
+		"con":          "%63on",
+		"lpt1.txt":     "%6Cpt1.txt",
+		"console":      "console",
+		"Émile":        "^émile",
+		"日本語.txt":      "日本語.txt",
+		"<report>.pdf": "%3Creport%3E.pdf",
+	}
+	for in, want := range tests {
+		if got := WindowsName(in); got != want {
+			t.Errorf("WindowsName(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	// Names differing only in case, or in their escapes, stay distinct
+	seen := make(map[string]string)
+	for _, name := range []string{"a", "A", "^a", "^A", "%41", "%", "%25", "CON", "con", "%63on"} {
+		folded := strings.ToLower(WindowsName(name))
+		if other, ok := seen[folded]; ok {
+			t.Errorf("%q and %q both escape to %q", name, other, folded)
+		}
+		seen[folded] = name
+	}
+}
@@ -0,0 +1,6 @@
+package pathutil
+
+// platformName makes a sanitized name safe on NTFS
+func platformName(s string) string {
+	return WindowsName(s)
+}
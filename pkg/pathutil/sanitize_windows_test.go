@@ -0,0 +1,24 @@
+package pathutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizePath_WindowsFiles(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"Photo.jpg", "photo.jpg", "PHOTO.JPG", "con", "lpt1.txt", "a?b", "trailing.", "trailing ", "x:y"}
+	for i, name := range names {
+		path := filepath.Join(dir, SanitizePath(name))
+		if err := os.WriteFile(path, []byte{byte(i)}, 0644); err != nil {
+			t.Fatalf("WriteFile(%q) error = %v", name, err)
+		}
+	}
+	for i, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, SanitizePath(name)))
+		if err != nil || len(data) != 1 || data[0] != byte(i) {
+			t.Errorf("ReadFile(%q) = %v, %v; want its own file", name, data, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package pathutil
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// windowsReserved are the device names Windows refuses as file names, with
+// or without an extension
+var windowsReserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// WindowsName escapes a file name so it is valid on Windows and does not
+// collide with another escaped name when compared case-insensitively, as
+// NTFS does:
+//
+//   - Upper case letters become "^" and the lower case letter, and "^"
+//     becomes "^^", so "Photo" and "photo" stay distinct
+//   - The characters Windows forbids (<>:"|?* and control characters),
+//     "%" itself, and a trailing dot or space become %XX
+//   - Reserved device names such as CON or lpt1.txt get their first
+//     letter escaped as %XX
+//
+// Distinct names always give distinct results.
+func WindowsName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '^':
+			b.WriteString("^^")
+		case unicode.IsUpper(r):
+			b.WriteByte('^')
+			b.WriteRune(unicode.ToLower(r))
+		case r == '%' || r < 0x20 || strings.ContainsRune(`<>:"|?*`, r):
+			fmt.Fprintf(&b, "%%%02X", r)
+		case (r == '.' || r == ' ') && i == len(s)-1:
+			fmt.Fprintf(&b, "%%%02X", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name := b.String()
+
+	stem, _, _ := strings.Cut(name, ".")
+	if windowsReserved[stem] {
+		name = fmt.Sprintf("%%%02X", name[0]) + name[1:]
+	}
+	return name
+}
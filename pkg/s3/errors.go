@@ -1,31 +1,76 @@
 package s3
 
+import "encoding/xml"
+
 // ErrorCode represents an S3 error code
 type ErrorCode string
 
 const (
-	AccessDenied        ErrorCode = "AccessDenied"
-	BucketAlreadyExists ErrorCode = "BucketAlreadyExists"
-	BucketNotEmpty      ErrorCode = "BucketNotEmpty"
-	EntityTooLarge      ErrorCode = "EntityTooLarge"
-	EntityTooSmall      ErrorCode = "EntityTooSmall"
-	InternalError       ErrorCode = "InternalError"
-	InvalidArgument     ErrorCode = "InvalidArgument"
-	InvalidBucketName   ErrorCode = "InvalidBucketName"
-	InvalidPart         ErrorCode = "InvalidPart"
-	InvalidPartOrder    ErrorCode = "InvalidPartOrder"
-	InvalidRange        ErrorCode = "InvalidRange"
-	NoSuchBucket        ErrorCode = "NoSuchBucket"
-	NoSuchKey           ErrorCode = "NoSuchKey"
-	NoSuchUpload        ErrorCode = "NoSuchUpload"
-	NoSuchVersion       ErrorCode = "NoSuchVersion"
-	PreconditionFailed  ErrorCode = "PreconditionFailed"
+	AccessDenied          ErrorCode = "AccessDenied"
+	BadDigest             ErrorCode = "BadDigest"
+	BucketAlreadyExists   ErrorCode = "BucketAlreadyExists"
+	BucketNotEmpty        ErrorCode = "BucketNotEmpty"
+	EntityTooLarge        ErrorCode = "EntityTooLarge"
+	EntityTooSmall        ErrorCode = "EntityTooSmall"
+	IncompleteBody        ErrorCode = "IncompleteBody"
+	InternalError         ErrorCode = "InternalError"
+	InvalidArgument       ErrorCode = "InvalidArgument"
+	InvalidBucketName     ErrorCode = "InvalidBucketName"
+	InvalidBucketState    ErrorCode = "InvalidBucketState"
+	InvalidPart           ErrorCode = "InvalidPart"
+	InvalidPolicyDocument ErrorCode = "InvalidPolicyDocument"
+	InvalidPartOrder      ErrorCode = "InvalidPartOrder"
+	InvalidRange          ErrorCode = "InvalidRange"
+	InvalidRequest        ErrorCode = "InvalidRequest"
+	KeyTooLong            ErrorCode = "KeyTooLongError"
+	MalformedXML          ErrorCode = "MalformedXML"
+	MethodNotAllowed      ErrorCode = "MethodNotAllowed"
+	MissingContentLength  ErrorCode = "MissingContentLength"
+	NoSuchBucket          ErrorCode = "NoSuchBucket"
+	NoSuchKey             ErrorCode = "NoSuchKey"
+	NoSuchUpload          ErrorCode = "NoSuchUpload"
+	NoSuchVersion         ErrorCode = "NoSuchVersion"
+	NotImplemented        ErrorCode = "NotImplemented"
+	OperationAborted      ErrorCode = "OperationAborted"
+	PreconditionFailed    ErrorCode = "PreconditionFailed"
+	QuotaExceeded         ErrorCode = "QuotaExceeded"
+	RequestHeaderTooLarge ErrorCode = "RequestHeaderSectionTooLarge"
+	ServiceUnavailable    ErrorCode = "ServiceUnavailable"
+	SignatureDoesNotMatch ErrorCode = "SignatureDoesNotMatch"
+	SlowDown              ErrorCode = "SlowDown"
+)
+
+// Error codes of the admin and extension API, which has no S3 equivalent
+const (
+	InsufficientStorage  ErrorCode = "InsufficientStorage"
+	NoSuchExport         ErrorCode = "NoSuchExport"
+	NoSuchJob            ErrorCode = "NoSuchJob"
+	NoSuchNode           ErrorCode = "NoSuchNode"
+	NoSuchReservation    ErrorCode = "NoSuchReservation"
+	NoSuchSchedule       ErrorCode = "NoSuchSchedule"
+	NoSuchServiceAccount ErrorCode = "NoSuchServiceAccount"
+	NoSuchSession        ErrorCode = "NoSuchSession"
+	NoSuchSnapshot       ErrorCode = "NoSuchSnapshot"
+	NoSuchTask           ErrorCode = "NoSuchTask"
+	OffsetMismatch       ErrorCode = "OffsetMismatch"
+	JobAlreadyRunning    ErrorCode = "JobAlreadyRunning"
+	JobAlreadyFinished   ErrorCode = "JobAlreadyFinished"
+	NotConfigured        ErrorCode = "NotConfigured"
+	ReadOnly             ErrorCode = "ReadOnly"
+	WrongNode            ErrorCode = "WrongNode"
 )
 
-// ErrorResponse represents an S3 error response
+// ErrorResponse represents an S3 error response. The same document is
+// returned as JSON to clients not asking for XML.
 type ErrorResponse struct {
-	Code      ErrorCode `xml:"Code"`
-	Message   string    `xml:"Message"`
-	Resource  string    `xml:"Resource"`
-	RequestID string    `xml:"RequestId"`
+	XMLName   xml.Name  `json:"-" xml:"Error"`
+	Code      ErrorCode `json:"code" xml:"Code"`
+	Message   string    `json:"message" xml:"Message"`
+	Resource  string    `json:"resource,omitempty" xml:"Resource"`
+	RequestID string    `json:"requestId,omitempty" xml:"RequestId"`
+}
+
+// Error returns the error message prefixed with its code
+func (e *ErrorResponse) Error() string {
+	return string(e.Code) + ": " + e.Message
 }
@@ -219,7 +219,7 @@ func uploadFile(key string, size int) TestResult {
 }
 
 func showMetrics() {
-	resp, err := http.Get(baseURL + "/admin/metrics")
+	resp, err := http.Get(baseURL + "/admin/v1/metrics")
 	if err != nil {
 		fmt.Printf("Error fetching metrics: %v\n", err)
 		return